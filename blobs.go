@@ -29,6 +29,10 @@ var (
 	// ErrBlobInvalidLength returned when the blob has an expected length on
 	// commit, meaning mismatched with the descriptor or an invalid value.
 	ErrBlobInvalidLength = errors.New("blob invalid length")
+
+	// ErrBlobRedirectUnavailable returned when a blob must be served through
+	// a redirect but the storage does not provide one.
+	ErrBlobRedirectUnavailable = errors.New("blob redirect unavailable")
 )
 
 // ErrBlobInvalidDigest returned when digest check fails.
@@ -42,6 +46,17 @@ func (err ErrBlobInvalidDigest) Error() string {
 		err.Digest, err.Reason)
 }
 
+// ErrBlobDecompressedSizeExceeded returned when a compressed blob
+// decompresses to more than the allowed size.
+type ErrBlobDecompressedSizeExceeded struct {
+	Size  int64
+	Limit int64
+}
+
+func (err ErrBlobDecompressedSizeExceeded) Error() string {
+	return fmt.Sprintf("blob of %d bytes decompresses to more than %d bytes", err.Size, err.Limit)
+}
+
 // ErrBlobMounted returned when a blob is mounted from another repository
 // instead of initiating an upload session.
 type ErrBlobMounted struct {
@@ -208,6 +223,16 @@ type BlobWriter interface {
 	Cancel(ctx context.Context) error
 }
 
+// BlobWriteAbandoner is implemented by the blob writers which can be marked
+// as abandoned by their client, such as after the client disconnected in the
+// middle of an upload.
+type BlobWriteAbandoner interface {
+	// Abandon marks the blob write as abandoned, so that its data may be
+	// reclaimed sooner than that of blob writes in progress. Resuming the
+	// blob write clears the mark.
+	Abandon(ctx context.Context) error
+}
+
 // BlobService combines the operations to access, read and write blobs. This
 // can be used to describe remote blob services.
 type BlobService interface {
@@ -11,10 +11,16 @@ import (
 	_ "github.com/distribution/distribution/v3/registry/storage/driver/azure"
 	_ "github.com/distribution/distribution/v3/registry/storage/driver/filesystem"
 	_ "github.com/distribution/distribution/v3/registry/storage/driver/gcs"
+	_ "github.com/distribution/distribution/v3/registry/storage/driver/hdfs"
 	_ "github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
 	_ "github.com/distribution/distribution/v3/registry/storage/driver/middleware/cloudfront"
+	_ "github.com/distribution/distribution/v3/registry/storage/driver/middleware/georedirect"
+	_ "github.com/distribution/distribution/v3/registry/storage/driver/middleware/metrics"
+	_ "github.com/distribution/distribution/v3/registry/storage/driver/middleware/mirror"
 	_ "github.com/distribution/distribution/v3/registry/storage/driver/middleware/redirect"
 	_ "github.com/distribution/distribution/v3/registry/storage/driver/middleware/rewrite"
+	_ "github.com/distribution/distribution/v3/registry/storage/driver/oci-objectstorage"
+	_ "github.com/distribution/distribution/v3/registry/storage/driver/postgres"
 	_ "github.com/distribution/distribution/v3/registry/storage/driver/s3-aws"
 )
 
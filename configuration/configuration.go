@@ -78,12 +78,404 @@ type Configuration struct {
 
 	// Policy configures registry policy options.
 	Policy Policy `yaml:"policy,omitempty"`
+
+	// PullStats configures the counting of manifest and blob downloads,
+	// served per repository by the "/v2/<name>/_stats" endpoint.
+	PullStats PullStats `yaml:"pullstats,omitempty"`
+
+	// Archive configures the archival of the manifests pushed to a
+	// secondary storage backend.
+	Archive Archive `yaml:"archive,omitempty"`
+
+	// Replication configures the replication of the manifests pushed, with
+	// their blobs, to downstream registries.
+	Replication Replication `yaml:"replication,omitempty"`
+
+	// AutoIndex configures the assembly of image indexes from tags pushed
+	// for each platform.
+	AutoIndex AutoIndex `yaml:"autoindex,omitempty"`
+
+	// Changes configures the log of the changes made to repositories and
+	// tags, served by the "/v2/_changes" endpoint.
+	Changes Changes `yaml:"changes,omitempty"`
+
+	// Compatibility configures behaviors easing the migration of clients
+	// from other registries.
+	Compatibility Compatibility `yaml:"compatibility,omitempty"`
+}
+
+// Compatibility configures behaviors easing the migration of clients from
+// other registries.
+type Compatibility struct {
+	// NamespaceRewrites map the repository names requested by clients to
+	// the names the repositories are stored under.
+	NamespaceRewrites []NamespaceRewrite `yaml:"namespacerewrites,omitempty"`
+}
+
+// NamespaceRewrite maps the repositories under a name prefix to another
+// prefix. A prefix matches the repository of the same name and the
+// repositories nested under it, so that "oldteam" matches "oldteam" and
+// "oldteam/app" but not "oldteamapp".
+type NamespaceRewrite struct {
+	// From is the prefix of the names requested by clients.
+	From string `yaml:"from,omitempty"`
+
+	// To replaces From in the names of the repositories accessed.
+	To string `yaml:"to,omitempty"`
+
+	// Writes rewrites pushes and deletes too. Otherwise, they are denied
+	// under From, so that no repository shadowed by the rewrite is
+	// created.
+	Writes bool `yaml:"writes,omitempty"`
+}
+
+// Validate checks the namespace rewrites are unambiguous: no name may be
+// matched by several rules, and no rewritten name may be matched by a rule
+// again.
+func (c Compatibility) Validate() error {
+	for i, rule := range c.NamespaceRewrites {
+		for _, prefix := range []string{rule.From, rule.To} {
+			if prefix == "" || strings.HasPrefix(prefix, "/") || strings.HasSuffix(prefix, "/") {
+				return fmt.Errorf("compatibility.namespacerewrites: rule %d: invalid prefix %q", i, prefix)
+			}
+		}
+		for j, other := range c.NamespaceRewrites {
+			if j != i && namespacesOverlap(rule.From, other.From) {
+				return fmt.Errorf("compatibility.namespacerewrites: rules %d and %d overlap: %q and %q", i, j, rule.From, other.From)
+			}
+			if namespacesOverlap(rule.To, other.From) {
+				return fmt.Errorf("compatibility.namespacerewrites: rule %d rewrites to %q, which rule %d rewrites again from %q", i, rule.To, j, other.From)
+			}
+		}
+	}
+	return nil
+}
+
+// namespacesOverlap reports whether a repository name may be under both
+// prefixes a and b.
+func namespacesOverlap(a, b string) bool {
+	return a == b || strings.HasPrefix(a, b+"/") || strings.HasPrefix(b, a+"/")
+}
+
+// Changes configures the change feed, an append-only log of the repositories
+// created and deleted, the tags updated and deleted and the manifests
+// deleted, numbered by increasing sequence numbers. The log is kept in redis
+// when it is configured, and otherwise by the storage driver, in which case
+// a single registry may write to the storage backend.
+type Changes struct {
+	// Enabled turns on the logging of changes and the change feed endpoint.
+	Enabled bool `yaml:"enabled,omitempty"`
+
+	// MaxChanges is the number of most recent changes retained. Defaults to
+	// 10000.
+	MaxChanges int `yaml:"maxchanges,omitempty"`
+
+	// MaxAge is the age after which changes are compacted away. Changes are
+	// retained regardless of their age if not set.
+	MaxAge time.Duration `yaml:"maxage,omitempty"`
+}
+
+// AutoIndex configures the automatic assembly of an image index tag from
+// per-platform tags. Whenever a tag matching a rule is pushed, the index tag
+// of its group is created or updated to reference the manifests of every
+// tag of the group, with the platform read from their image configuration.
+type AutoIndex struct {
+	// Rules are the repositories and tags indexes are assembled for. Only
+	// the first rule matching a pushed tag applies.
+	Rules []AutoIndexRule `yaml:"rules,omitempty"`
+}
+
+// AutoIndexRule groups per-platform tags into an index tag.
+type AutoIndexRule struct {
+	// Repositories are patterns of the repositories the rule applies to,
+	// in the syntax of path.Match.
+	Repositories []string `yaml:"repositories,omitempty"`
+
+	// Tags is the template of the per-platform tags, in which "{group}"
+	// stands for the group of the tag and "{platform}" for its platform,
+	// such as "{group}-{platform}". "{platform}" is required.
+	Tags string `yaml:"tags,omitempty"`
+
+	// Index is the template of the index tag of a group, in which
+	// "{group}" stands for the group. Defaults to "{group}".
+	Index string `yaml:"index,omitempty"`
+}
+
+// PullStats configures the counting of the pulls of each repository, by tag
+// or digest and by day. Pulls are counted in redis when it is configured,
+// and otherwise in memory, periodically flushed to the storage driver.
+// Counting is best-effort and never delays or fails pulls.
+type PullStats struct {
+	// Enabled turns on the counting of pulls.
+	Enabled bool `yaml:"enabled,omitempty"`
+
+	// FlushInterval is the interval at which pulls counted in memory are
+	// flushed to the storage driver, when redis is not configured.
+	// Defaults to one minute.
+	FlushInterval time.Duration `yaml:"flushinterval,omitempty"`
+
+	// QueueSize is the number of pulls queued before being counted. Pulls
+	// are dropped while the queue is full. Defaults to 1024.
+	QueueSize int `yaml:"queuesize,omitempty"`
+
+	// MaxAge is the age after which the counts of a day are dropped.
+	// Defaults to 90 days.
+	MaxAge time.Duration `yaml:"maxage,omitempty"`
+}
+
+// Archive configures the archival of every manifest pushed, with a record
+// of the push, to a secondary storage backend, where it is retained
+// independently of the deletions and garbage collection of the registry.
+// Archival is asynchronous and never delays or fails pushes.
+type Archive struct {
+	// Storage configures the archive storage driver, as the storage
+	// section does. Archival is enabled when a driver is configured.
+	Storage Storage `yaml:"storage,omitempty"`
+
+	// Path is the template of the directory of an archived manifest, in
+	// which "{repository}", "{algorithm}" and "{hex}" stand for the
+	// repository name and the algorithm and hex of the digest of the
+	// manifest. Defaults to "/{repository}/{algorithm}/{hex}".
+	Path string `yaml:"path,omitempty"`
+
+	// QueueSize is the number of pushes queued before being archived.
+	// Pushes are dropped while the queue is full. Defaults to 1024.
+	QueueSize int `yaml:"queuesize,omitempty"`
+
+	// MaxRetries is the number of times a failed archive write is retried,
+	// with an exponential backoff, before the push is dropped. Defaults
+	// to 5.
+	MaxRetries int `yaml:"maxretries,omitempty"`
+}
+
+// Replication configures the replication of every manifest pushed, with the
+// manifests and blobs it references, to downstream registries, so that they
+// hold it before it is pulled from them. Replication is asynchronous and
+// never delays or fails pushes.
+type Replication struct {
+	// Targets are the downstream registries the manifests pushed are
+	// replicated to. Replication is enabled when a target is configured.
+	Targets []ReplicationTarget `yaml:"targets,omitempty"`
+
+	// Workers is the number of replications run concurrently. Defaults
+	// to 4.
+	Workers int `yaml:"workers,omitempty"`
+
+	// QueueSize is the number of replications queued before being run.
+	// Pushes are not replicated while the queue is full. Defaults to 1024.
+	QueueSize int `yaml:"queuesize,omitempty"`
+
+	// MaxRetries is the number of times a failed replication is retried,
+	// with an exponential backoff, before it is dropped. Defaults to 5.
+	MaxRetries int `yaml:"maxretries,omitempty"`
+}
+
+// ReplicationTarget is a downstream registry manifests are replicated to.
+type ReplicationTarget struct {
+	// Name identifies the target in logs and metrics.
+	Name string `yaml:"name"`
+
+	// URL is the base URL of the downstream registry.
+	URL string `yaml:"url"`
+
+	// Username is the user the registry authenticates as to the
+	// downstream registry, which must be allowed to push to the
+	// repositories replicated.
+	Username string `yaml:"username,omitempty"`
+
+	// Password is the password of the user.
+	Password string `yaml:"password,omitempty"`
 }
 
 // Policy defines configuration options for managing registry policies.
 type Policy struct {
 	// Repository configures policies for repositories
 	Repository Repository `yaml:"repository,omitempty"`
+
+	// Mount configures which repositories blobs may be mounted from.
+	Mount MountPolicy `yaml:"mount,omitempty"`
+
+	// PreverifiedDigest configures which clients may skip the verification
+	// of blob digests on upload.
+	PreverifiedDigest PreverifiedDigestPolicy `yaml:"preverifieddigest,omitempty"`
+
+	// Content configures the verification of uploaded content against the
+	// content already stored for the same digest.
+	Content ContentPolicy `yaml:"content,omitempty"`
+
+	// ManifestPuts limits the rate at which manifests are pushed to each
+	// repository.
+	ManifestPuts ManifestPutPolicy `yaml:"manifestputs,omitempty"`
+
+	// Pulls limits the rate at which each client pulls manifests.
+	Pulls PullPolicy `yaml:"pulls,omitempty"`
+
+	// Referrers limits the rate at which the referrers of the manifests of
+	// each repository are queried.
+	Referrers ReferrersPolicy `yaml:"referrers,omitempty"`
+
+	// Quarantine blocks the pulls of the manifests flagged by referrers,
+	// such as the findings pushed by a vulnerability scanner.
+	Quarantine QuarantinePolicy `yaml:"quarantine,omitempty"`
+}
+
+// ManifestPutPolicy limits the rate of manifest pushes per repository, to
+// protect the tag store from clients pushing in a tight loop. Pushes
+// exceeding the rate are rejected with 429 Too Many Requests.
+//
+// Each repository has its own token bucket, further split by authenticated
+// user so that a runaway client does not exhaust the rate of others.
+// Anonymous pushes share the bucket of the repository.
+type ManifestPutPolicy struct {
+	// Rules lists the rates of the repositories they match. The first rule
+	// matching a repository applies, repositories matching no rule are not
+	// limited.
+	Rules []ManifestPutRule `yaml:"rules,omitempty"`
+}
+
+// ManifestPutRule limits the rate of manifest pushes to a set of
+// repositories.
+type ManifestPutRule struct {
+	// Repositories lists patterns of the repository names the rule applies
+	// to, in the syntax of path.Match.
+	Repositories []string `yaml:"repositories,omitempty"`
+
+	// Rate is the sustained number of manifest pushes allowed per second.
+	Rate float64 `yaml:"rate,omitempty"`
+
+	// Burst is the number of manifest pushes allowed in quick succession.
+	// Defaults to the rate rounded up, and at least 1.
+	Burst int `yaml:"burst,omitempty"`
+}
+
+// ReferrersPolicy limits the rate of the queries of the referrers API per
+// repository, to protect the referrers index from scanners polling it.
+// Queries exceeding the rate are rejected with 429 Too Many Requests.
+//
+// As for manifest pushes, each repository has its own token bucket, further
+// split by authenticated user, and anonymous queries share the bucket of the
+// repository.
+type ReferrersPolicy struct {
+	// Rules lists the rates of the repositories they match. The first rule
+	// matching a repository applies, repositories matching no rule are not
+	// limited.
+	Rules []ReferrersRule `yaml:"rules,omitempty"`
+}
+
+// ReferrersRule limits the rate of the referrers queries of a set of
+// repositories.
+type ReferrersRule struct {
+	// Repositories lists patterns of the repository names the rule applies
+	// to, in the syntax of path.Match.
+	Repositories []string `yaml:"repositories,omitempty"`
+
+	// Rate is the sustained number of referrers queries allowed per second.
+	Rate float64 `yaml:"rate,omitempty"`
+
+	// Burst is the number of referrers queries allowed in quick succession.
+	// Defaults to the rate rounded up, and at least 1.
+	Burst int `yaml:"burst,omitempty"`
+}
+
+// PullPolicy limits the rate of manifest pulls per client. Authenticated
+// clients are identified by user name, anonymous ones by IP address. Pulls
+// exceeding the rate are rejected with 429 Too Many Requests.
+//
+// The rate of a client may depend on its tier, read from a claim of the
+// token it authenticated with, so that paying customers pull at a higher
+// rate than free ones.
+type PullPolicy struct {
+	// Default is the rate of the clients without tier, including anonymous
+	// clients, and of the clients of a tier not listed in Tiers.
+	Default PullRate `yaml:"default,omitempty"`
+
+	// Claim names the claim of the tokens of clients holding their tier.
+	Claim string `yaml:"claim,omitempty"`
+
+	// Tiers maps the values of the claim to the rates of the clients of
+	// each tier.
+	Tiers map[string]PullRate `yaml:"tiers,omitempty"`
+}
+
+// PullRate is a rate of manifest pulls.
+type PullRate struct {
+	// Rate is the sustained number of manifest pulls allowed per second.
+	Rate float64 `yaml:"rate,omitempty"`
+
+	// Burst is the number of manifest pulls allowed in quick succession.
+	// Defaults to the rate rounded up, and at least 1.
+	Burst int `yaml:"burst,omitempty"`
+}
+
+// QuarantinePolicy quarantines the manifests referenced as their subject by
+// a manifest of a flagging artifact type, so that an external scanner can
+// gate deployments by pushing such a referrer.
+type QuarantinePolicy struct {
+	// Rules lists the flagging artifact types of the repositories they
+	// match. The first rule matching a repository applies, repositories
+	// matching no rule are not quarantined.
+	Rules []QuarantineRule `yaml:"rules,omitempty"`
+}
+
+// QuarantineRule quarantines the flagged manifests of a set of
+// repositories.
+type QuarantineRule struct {
+	// Repositories lists patterns of the repository names the rule applies
+	// to, in the syntax of path.Match.
+	Repositories []string `yaml:"repositories,omitempty"`
+
+	// ArtifactTypes lists the artifact types of the referrers flagging
+	// their subject.
+	ArtifactTypes []string `yaml:"artifacttypes,omitempty"`
+
+	// Action is what happens to the pulls of flagged manifests: "deny"
+	// rejects them with DENIED, "warn" serves them with a Warning header.
+	// Defaults to "deny".
+	Action string `yaml:"action,omitempty"`
+}
+
+// MountPolicy restricts the source repositories of cross-repository blob
+// mounts, based on the authenticated identity of the client. When enabled, a
+// mount is only permitted if one of the rules applying to the client allows
+// its source repository.
+type MountPolicy struct {
+	// Enabled turns on enforcement of the mount policy.
+	Enabled bool `yaml:"enabled,omitempty"`
+
+	// Rules lists the source repositories permitted for mounts.
+	Rules []MountRule `yaml:"rules,omitempty"`
+}
+
+// MountRule permits a set of users to mount blobs from a set of repositories.
+type MountRule struct {
+	// Users lists the names of the authenticated users the rule applies to.
+	// If empty, the rule applies to every client, including anonymous ones.
+	Users []string `yaml:"users,omitempty"`
+
+	// From lists patterns of the repositories the users may mount from, in
+	// the syntax of path.Match. The placeholder "{user}" is replaced with
+	// the name of the authenticated user.
+	From []string `yaml:"from,omitempty"`
+}
+
+// PreverifiedDigestPolicy lists the clients trusted to compute the digests of
+// the blobs they push. When completing an upload with the
+// Docker-Content-Digest-Preverified header set to "true", such clients skip
+// the hashing of the content by the registry, which instead links it right
+// away and verifies it in the background, removing the blobs not matching
+// their digest.
+type PreverifiedDigestPolicy struct {
+	// Users lists the names of the trusted authenticated users.
+	Users []string `yaml:"users,omitempty"`
+}
+
+// ContentPolicy guards the immutability of the content stored for a digest,
+// across every repository.
+type ContentPolicy struct {
+	// VerifyExisting compares the content of uploads completed with the
+	// digest of an existing blob to the stored content, logging a critical
+	// error if it differs. The stored content is never overwritten.
+	VerifyExisting bool `yaml:"verifyexisting,omitempty"`
 }
 
 // Repository defines configuration options related to repository policies in the registry.
@@ -101,6 +493,11 @@ type Catalog struct {
 	// to the catalog endpoint will return at most MaxEntries entries.
 	// An empty or a negative value will set a default of 1000 maximum entries by default.
 	MaxEntries int `yaml:"maxentries,omitempty"`
+
+	// ArtifactTypes maintains the index of the artifact types of the
+	// manifests of each repository, reported by the catalog listings
+	// requested with detail=true.
+	ArtifactTypes bool `yaml:"artifacttypes,omitempty"`
 }
 
 // Log represents the configuration for logging within the application.
@@ -157,6 +554,12 @@ type HTTP struct {
 	// Location headers
 	RelativeURLs bool `yaml:"relativeurls,omitempty"`
 
+	// TrustedProxies lists the networks, in CIDR notation, of the proxies
+	// whose Forwarded and X-Forwarded-* headers are honored when building
+	// URLs. The headers of other peers are ignored. If empty, the headers of
+	// every peer are honored.
+	TrustedProxies []string `yaml:"trustedproxies,omitempty"`
+
 	// Amount of time to wait for connection to drain before shutting down when registry
 	// receives a stop signal
 	DrainTimeout time.Duration `yaml:"draintimeout,omitempty"`
@@ -184,6 +587,171 @@ type HTTP struct {
 
 	// H2C configures support for HTTP/2 without requiring TLS (HTTP/2 Cleartext).
 	H2C H2C `yaml:"h2c,omitempty"`
+
+	// HTTP3 configures support for HTTP/3 over QUIC.
+	HTTP3 HTTP3 `yaml:"http3,omitempty"`
+
+	// Concurrency limits the number of blob transfers served at once.
+	Concurrency Concurrency `yaml:"concurrency,omitempty"`
+
+	// TimeBudget bounds the time the registry spends serving a request.
+	TimeBudget TimeBudget `yaml:"timebudget,omitempty"`
+
+	// ServerTiming reports the time spent in the phases of a request
+	// through the Server-Timing response header.
+	ServerTiming ServerTiming `yaml:"servertiming,omitempty"`
+
+	// StorageErrors configures how failures of the storage driver are
+	// reported to clients and logged.
+	StorageErrors StorageErrors `yaml:"storageerrors,omitempty"`
+
+	// Uploads configures how blob uploads are received.
+	Uploads Uploads `yaml:"uploads,omitempty"`
+
+	// ListCompression configures the compression of the catalog and tags
+	// list responses.
+	ListCompression ListCompression `yaml:"listcompression,omitempty"`
+
+	// Capabilities configures the document describing the optional
+	// endpoints and the limits of the registry.
+	Capabilities Capabilities `yaml:"capabilities,omitempty"`
+}
+
+// Concurrency configures limits on the number of blob uploads and downloads
+// the registry serves simultaneously. Requests exceeding a limit are
+// rejected with 429 Too Many Requests. A zero value disables a limit.
+type Concurrency struct {
+	// Uploads is the maximum number of blob upload requests served at
+	// once across the registry.
+	Uploads int `yaml:"uploads,omitempty"`
+
+	// Downloads is the maximum number of blob download streams served at
+	// once across the registry.
+	Downloads int `yaml:"downloads,omitempty"`
+
+	// PerClient is the maximum number of blob uploads, and separately of
+	// blob downloads, served at once for a single client. Clients are
+	// identified by their authenticated user name, or by their remote IP
+	// address for anonymous requests.
+	PerClient int `yaml:"perclient,omitempty"`
+
+	// QueueSize is the number of requests which may wait for a slot once a
+	// limit has been reached. Requests beyond it are rejected immediately.
+	QueueSize int `yaml:"queuesize,omitempty"`
+
+	// QueueTimeout is how long a queued request waits for a slot before it
+	// is rejected.
+	QueueTimeout time.Duration `yaml:"queuetimeout,omitempty"`
+
+	// RetryAfter is the delay advertised to rejected clients through the
+	// Retry-After header. Defaults to 1 second.
+	RetryAfter time.Duration `yaml:"retryafter,omitempty"`
+}
+
+// TimeBudget configures the maximum time the registry spends serving a
+// request. Requests which exceed their budget before a response has been
+// written are cancelled and fail with 503 Service Unavailable. A zero value
+// disables a budget.
+type TimeBudget struct {
+	// Default is the budget of requests other than blob transfers.
+	Default time.Duration `yaml:"default,omitempty"`
+
+	// Transfers is the budget of blob downloads and blob upload chunks,
+	// whose duration grows with the size of the content. When zero, blob
+	// transfers are exempt from the budget.
+	Transfers time.Duration `yaml:"transfers,omitempty"`
+
+	// RetryAfter is the delay advertised to clients through the
+	// Retry-After header once a budget is exceeded. Defaults to 1 second.
+	RetryAfter time.Duration `yaml:"retryafter,omitempty"`
+}
+
+// ServerTiming configures the Server-Timing response header, which breaks
+// down the time spent serving a request into authorization, repository
+// resolution, storage driver calls and the handler. Timings may reveal
+// details of the registry internals, so the header is disabled by default.
+type ServerTiming struct {
+	// Enabled adds the header to responses.
+	Enabled bool `yaml:"enabled,omitempty"`
+
+	// RequestHeader, if set, restricts the header to responses to requests
+	// carrying a non-empty request header of that name.
+	RequestHeader string `yaml:"requestheader,omitempty"`
+}
+
+// ListCompression configures the gzip compression of the JSON responses of
+// the "/v2/_catalog" and "/v2/<name>/tags/list" endpoints, for the clients
+// accepting it. Blobs and manifests are never compressed.
+type ListCompression struct {
+	// Enabled compresses the responses larger than Threshold.
+	Enabled bool `yaml:"enabled,omitempty"`
+
+	// Threshold is the size, in bytes, above which responses are
+	// compressed. Defaults to 1024.
+	Threshold int `yaml:"threshold,omitempty"`
+}
+
+// Capabilities configures the capabilities document, describing the version,
+// the optional endpoints, the limits and the digest algorithms of the
+// registry. The document is always served at "/v2/_capabilities".
+type Capabilities struct {
+	// Base also serves the document as the body of "/v2/", instead of an
+	// empty JSON object.
+	Base bool `yaml:"base,omitempty"`
+}
+
+// StorageErrors configures the handling of the errors of the storage driver,
+// which are classified as not found, throttled, permission denied, transient
+// or fatal. Requests failing because the storage backend throttles the
+// registry are answered with 503 Service Unavailable.
+type StorageErrors struct {
+	// Audit logs every storage driver error causing a request to fail, with
+	// the action of the driver and the class of the error.
+	Audit bool `yaml:"audit,omitempty"`
+
+	// RetryAfter is the delay advertised to clients through the
+	// Retry-After header when the storage backend throttles the registry.
+	// Defaults to 1 second.
+	RetryAfter time.Duration `yaml:"retryafter,omitempty"`
+}
+
+// Uploads configures how the registry receives blob uploads.
+type Uploads struct {
+	// MinChunkSize is the minimum size in bytes of the chunks of a chunked
+	// upload, except the final one. A smaller chunk ends the upload: the
+	// chunks following it are rejected with 416 Requested Range Not
+	// Satisfiable. A zero value accepts chunks of any size.
+	MinChunkSize int64 `yaml:"minchunksize,omitempty"`
+
+	// MaxChecksumChunkSize is the size in bytes of the largest chunk which
+	// may carry a Content-MD5 or Content-Digest checksum. Such chunks are
+	// verified as they are appended to the upload, which a mismatch
+	// cancels, and larger ones are rejected. Defaults to 64 MiB.
+	MaxChecksumChunkSize int64 `yaml:"maxchecksumchunksize,omitempty"`
+
+	// MaxTotalConcurrent is the maximum number of upload sessions in
+	// progress across the registry, from the request starting an upload to
+	// the request completing or cancelling it, or until the upload is
+	// purged. Uploads started beyond it are rejected with 429 Too Many
+	// Requests. A zero value disables the limit.
+	MaxTotalConcurrent int `yaml:"maxtotalconcurrent,omitempty"`
+
+	// WebSocket configures the upload of blobs over WebSocket connections.
+	WebSocket WebSocketUploads `yaml:"websocket,omitempty"`
+}
+
+// WebSocketUploads configures the upload of blobs over WebSocket
+// connections, served by the "/v2/<name>/blobs/uploads/_websocket" endpoint
+// for browser clients unable to send chunked uploads.
+type WebSocketUploads struct {
+	// Enabled serves the endpoint.
+	Enabled bool `yaml:"enabled,omitempty"`
+
+	// AllowedOrigins are the origins of the web pages allowed to upload,
+	// such as "https://build.example.com". When empty, only connections
+	// without an Origin header or from the origin of the registry itself
+	// are accepted.
+	AllowedOrigins []string `yaml:"allowedorigins,omitempty"`
 }
 
 // Debug defines the configuration options for the registry's debug interface.
@@ -207,6 +775,31 @@ type Prometheus struct {
 	// Path specifies the URL path where the Prometheus metrics are exposed.
 	// The default is "/metrics", but it can be customized here.
 	Path string `yaml:"path,omitempty"`
+
+	// TransferSpeed configures the size classes of the blob transfer speed
+	// histograms.
+	TransferSpeed TransferSpeed `yaml:"transferspeed,omitempty"`
+
+	// Exemplars attaches the trace ID of the requests as exemplars to the
+	// request and storage latency histograms, and serves the metrics in the
+	// OpenMetrics format to the scrapers accepting it. It has no effect
+	// unless tracing is enabled.
+	Exemplars bool `yaml:"exemplars,omitempty"`
+}
+
+// TransferSpeed configures the size classes of the blob transfer speed
+// histograms. Sizes are in bytes, and a zero size leaves the default.
+type TransferSpeed struct {
+	// MinSize is the size under which transfers are not observed, so that
+	// the speed of the many transfers of small blobs, which is dominated by
+	// the latency of the requests, does not skew the histograms.
+	MinSize int64 `yaml:"minsize,omitempty"`
+
+	// MediumSize is the size from which transfers are classed as medium.
+	MediumSize int64 `yaml:"mediumsize,omitempty"`
+
+	// LargeSize is the size from which transfers are classed as large.
+	LargeSize int64 `yaml:"largesize,omitempty"`
 }
 
 // HTTP2 configures options.
@@ -223,6 +816,17 @@ type H2C struct {
 	Enabled bool `yaml:"enabled,omitempty"`
 }
 
+// HTTP3 configures support for HTTP/3 over QUIC.
+type HTTP3 struct {
+	// Enabled serves HTTP/3 alongside the TLS listener, and advertises it
+	// to clients through the Alt-Svc header. Requires TLS to be configured.
+	Enabled bool `yaml:"enabled,omitempty"`
+
+	// Addr specifies the UDP address the HTTP/3 server listens on. It
+	// defaults to the address of the http section.
+	Addr string `yaml:"addr,omitempty"`
+}
+
 // TLS defines the configuration options for enabling and configuring TLS (Transport Layer Security)
 // for secure communication between the registry and clients. It allows the registry to listen for
 // HTTPS connections with a specified certificate, key, and optional client authentication settings.
@@ -273,6 +877,46 @@ type LetsEncrypt struct {
 	// DirectoryURL points to the CA directory endpoint.
 	// If empty, LetsEncrypt is used.
 	DirectoryURL string `yaml:"directoryurl,omitempty"`
+
+	// Challenge is the type of the challenges proving the control of the
+	// hosts: "tls-alpn-01" (the default), "http-01" or "dns-01".
+	Challenge string `yaml:"challenge,omitempty"`
+
+	// HTTPAddr is the address listened on to solve http-01 challenges.
+	// Defaults to ":80".
+	HTTPAddr string `yaml:"httpaddr,omitempty"`
+
+	// DNS configures the provider publishing the records of dns-01
+	// challenges.
+	DNS LetsEncryptDNS `yaml:"dns,omitempty"`
+
+	// Cache is where certificates and keys are kept: "file" (the default)
+	// keeps them in CacheFile, "redis" in the redis instance of the
+	// registry, shared by its replicas.
+	Cache string `yaml:"cache,omitempty"`
+
+	// RenewBefore is the time before its expiry at which the certificate
+	// is renewed. Defaults to 30 days.
+	RenewBefore time.Duration `yaml:"renewbefore,omitempty"`
+}
+
+// Enabled reports whether certificates are obtained through Let's Encrypt.
+func (l LetsEncrypt) Enabled() bool {
+	return l.CacheFile != "" || l.Cache == "redis"
+}
+
+// LetsEncryptDNS configures the provider publishing the records of dns-01
+// challenges.
+type LetsEncryptDNS struct {
+	// Provider is the name of the provider, such as "exec".
+	Provider string `yaml:"provider,omitempty"`
+
+	// Parameters configure the provider.
+	Parameters Parameters `yaml:"parameters,omitempty"`
+
+	// PropagationDelay is the time waited for the records to propagate
+	// before they are validated.
+	PropagationDelay time.Duration `yaml:"propagationdelay,omitempty"`
 }
 
 // Tags provides configuration options for the "/v2/<name>/tags/list" endpoint.
@@ -281,6 +925,52 @@ type Tags struct {
 	// Requesting n tags to the tags endpoint will return at most MaxTags tags.
 	// Default to 1000 tags if not set.
 	MaxTags int `yaml:"maxtags,omitempty"`
+
+	// DefaultTag configures the non-standard "/v2/<name>/manifests/"
+	// compatibility endpoint, which serves the manifest of a repository's
+	// default tag when a client omits the reference.
+	DefaultTag DefaultTag `yaml:"defaulttag,omitempty"`
+
+	// DigestPinning reports the digest a tag points to when its manifest is
+	// fetched, and lets clients detect whether it changed since.
+	DigestPinning DigestPinning `yaml:"digestpinning,omitempty"`
+
+	// RepositoryHint tells clients whether the repository exists when a
+	// manifest probed with HEAD is unknown.
+	RepositoryHint RepositoryHint `yaml:"repositoryhint,omitempty"`
+}
+
+// RepositoryHint configures the header hinting whether the repository exists
+// on HEAD requests for unknown manifests.
+type RepositoryHint struct {
+	// Enabled sets the Docker-Repository-Exists response header to "true"
+	// or "false" on HEAD requests for unknown manifests.
+	Enabled bool `yaml:"enabled,omitempty"`
+}
+
+// DigestPinning configures the headers exposing the digest a tag points to on
+// manifest requests by tag.
+type DigestPinning struct {
+	// Enabled sets the Docker-Tag-Digest response header, and answers
+	// requests whose Docker-Tag-If-None-Match header lists the current
+	// digest of the tag with 304 Not Modified.
+	Enabled bool `yaml:"enabled,omitempty"`
+}
+
+// DefaultTag configures the default tag resolved by the manifest
+// compatibility endpoint. The endpoint is not part of the distribution
+// specification and is only served when explicitly enabled.
+type DefaultTag struct {
+	// Enabled registers the compatibility endpoint.
+	Enabled bool `yaml:"enabled,omitempty"`
+
+	// Tag is the default tag used for repositories without an override.
+	// Defaults to "latest" if not set.
+	Tag string `yaml:"tag,omitempty"`
+
+	// Repositories maps repository names to the default tag that should be
+	// served for them, overriding Tag.
+	Repositories map[string]string `yaml:"repositories,omitempty"`
 }
 
 // LogHook is composed of hook Level and Type.
@@ -395,6 +1085,24 @@ type Health struct {
 	// StorageDriver configures a health check on the configured storage
 	// driver
 	StorageDriver StorageDriver `yaml:"storagedriver,omitempty"`
+
+	// Components configures health checks on the background components of
+	// the registry
+	Components HealthComponents `yaml:"components,omitempty"`
+}
+
+// HealthComponents configures health checks reporting the progress of the
+// background components of the registry: the proxy scheduler
+// ("proxyscheduler"), the queues of the notification endpoints
+// ("notifications"), the upload purger ("uploadpurger") and the link
+// reconciler ("reconciler").
+type HealthComponents struct {
+	// Enabled turns on the health checks of the components
+	Enabled bool `yaml:"enabled,omitempty"`
+
+	// Thresholds are the durations without progress after which each
+	// component is unhealthy, overriding the defaults
+	Thresholds map[string]time.Duration `yaml:"thresholds,omitempty"`
 }
 
 // StorageDriver configures health checks specific to the storage driver.
@@ -500,6 +1208,12 @@ func (storage Storage) Type() string {
 			// allow configuration of redirect
 		case "tag":
 			// allow configuration of tag
+		case "mediatypes":
+			// allow configuration of mediatypes
+		case "routes":
+			// allow configuration of routes
+		case "maxrepositories":
+			// allow configuration of maxrepositories
 		default:
 			storageType = append(storageType, k)
 		}
@@ -556,6 +1270,12 @@ func (storage *Storage) UnmarshalYAML(unmarshal func(any) error) error {
 					// allow configuration of redirect
 				case "tag":
 					// allow configuration of tag
+				case "mediatypes":
+					// allow configuration of mediatypes
+				case "routes":
+					// allow configuration of routes
+				case "maxrepositories":
+					// allow configuration of maxrepositories
 				default:
 					types = append(types, k)
 				}
@@ -590,10 +1310,24 @@ func (storage Storage) MarshalYAML() (any, error) {
 // Auth defines the configuration for registry authorization.
 type Auth map[string]Parameters
 
+const (
+	// authObfuscateNotFound is the key of the Auth map holding the
+	// obfuscatenotfound option, as an "enabled" parameter.
+	authObfuscateNotFound = "obfuscatenotfound"
+
+	// authDeleteAsPush is the key of the Auth map holding the deleteaspush
+	// option, as an "enabled" parameter.
+	authDeleteAsPush = "deleteaspush"
+)
+
 // Type returns the auth type, such as htpasswd or token
 func (auth Auth) Type() string {
 	// Return only key in this map
 	for k := range auth {
+		if k == authObfuscateNotFound || k == authDeleteAsPush {
+			// allow configuration of obfuscatenotfound and deleteaspush
+			continue
+		}
 		return k
 	}
 	return ""
@@ -604,6 +1338,21 @@ func (auth Auth) Parameters() Parameters {
 	return auth[auth.Type()]
 }
 
+// ObfuscateNotFound reports whether the registry must hide which
+// repositories exist from the clients it refuses.
+func (auth Auth) ObfuscateNotFound() bool {
+	enabled, _ := auth[authObfuscateNotFound]["enabled"].(bool)
+	return enabled
+}
+
+// DeleteAsPush reports whether deletions must be authorized with the push
+// action rather than the delete action, for the token services unable to
+// issue the delete action.
+func (auth Auth) DeleteAsPush() bool {
+	enabled, _ := auth[authDeleteAsPush]["enabled"].(bool)
+	return enabled
+}
+
 // setParameter changes the parameter at the provided key to the new value
 func (auth Auth) setParameter(key string, value any) {
 	auth[auth.Type()][key] = value
@@ -612,12 +1361,16 @@ func (auth Auth) setParameter(key string, value any) {
 // UnmarshalYAML implements the yaml.Unmarshaler interface
 // Unmarshals a single item map into a Storage or a string into a Storage type with no parameters
 func (auth *Auth) UnmarshalYAML(unmarshal func(any) error) error {
-	var m map[string]Parameters
+	var m struct {
+		ObfuscateNotFound bool                  `yaml:"obfuscatenotfound"`
+		DeleteAsPush      bool                  `yaml:"deleteaspush"`
+		Types             map[string]Parameters `yaml:",inline"`
+	}
 	err := unmarshal(&m)
 	if err == nil {
-		if len(m) > 1 {
-			types := make([]string, 0, len(m))
-			for k := range m {
+		if len(m.Types) > 1 {
+			types := make([]string, 0, len(m.Types))
+			for k := range m.Types {
 				types = append(types, k)
 			}
 
@@ -626,7 +1379,19 @@ func (auth *Auth) UnmarshalYAML(unmarshal func(any) error) error {
 			return fmt.Errorf("must provide exactly one type. Provided: %v", types)
 
 		}
-		*auth = m
+		*auth = m.Types
+		if m.ObfuscateNotFound {
+			if *auth == nil {
+				*auth = Auth{}
+			}
+			(*auth)[authObfuscateNotFound] = Parameters{"enabled": true}
+		}
+		if m.DeleteAsPush {
+			if *auth == nil {
+				*auth = Auth{}
+			}
+			(*auth)[authDeleteAsPush] = Parameters{"enabled": true}
+		}
 		return nil
 	}
 
@@ -642,10 +1407,24 @@ func (auth *Auth) UnmarshalYAML(unmarshal func(any) error) error {
 
 // MarshalYAML implements the yaml.Marshaler interface
 func (auth Auth) MarshalYAML() (any, error) {
-	if auth.Parameters() == nil {
-		return auth.Type(), nil
+	if !auth.ObfuscateNotFound() && !auth.DeleteAsPush() {
+		if auth.Parameters() == nil {
+			return auth.Type(), nil
+		}
+		return map[string]Parameters(auth), nil
+	}
+
+	m := map[string]any{}
+	if auth.ObfuscateNotFound() {
+		m[authObfuscateNotFound] = true
+	}
+	if auth.DeleteAsPush() {
+		m[authDeleteAsPush] = true
+	}
+	if authType := auth.Type(); authType != "" {
+		m[authType] = auth.Parameters()
 	}
-	return map[string]Parameters(auth), nil
+	return m, nil
 }
 
 // Notifications configures multiple http endpoints.
@@ -656,6 +1435,32 @@ type Notifications struct {
 	// respond to webhook notifications. In the future, we may allow other
 	// kinds of endpoints, such as external queues.
 	Endpoints []Endpoint `yaml:"endpoints,omitempty"`
+	// Subscriptions configures the webhook subscriptions of repositories,
+	// managed through the API rather than the configuration.
+	Subscriptions Subscriptions `yaml:"subscriptions,omitempty"`
+	// EnforceTLS rejects at startup, if "strict", or warns about, if "warn",
+	// the endpoints whose url is not https. TLS is not enforced by default.
+	EnforceTLS string `yaml:"enforcetls,omitempty"`
+}
+
+// Subscriptions configures the webhook subscriptions managed through the
+// _webhooks route of repositories. Subscriptions are kept in the storage
+// backend, and receive the events of their repository and of the
+// repositories under it.
+type Subscriptions struct {
+	// Enabled serves the _webhooks route and delivers events to the
+	// subscriptions.
+	Enabled bool `yaml:"enabled,omitempty"`
+
+	// MaxPerRepository bounds the number of subscriptions of a repository,
+	// defaulting to 10.
+	MaxPerRepository int `yaml:"maxperrepository,omitempty"`
+
+	// Timeout, Threshold and Backoff configure the delivery of events to
+	// each subscription, as for endpoints.
+	Timeout   time.Duration `yaml:"timeout,omitempty"`
+	Threshold int           `yaml:"threshold,omitempty"`
+	Backoff   time.Duration `yaml:"backoff,omitempty"`
 }
 
 // Endpoint describes the configuration of an http webhook notification
@@ -663,13 +1468,43 @@ type Notifications struct {
 type Endpoint struct {
 	Name              string        `yaml:"name"`              // identifies the endpoint in the registry instance.
 	Disabled          bool          `yaml:"disabled"`          // disables the endpoint
-	URL               string        `yaml:"url"`               // post url for the endpoint.
-	Headers           http.Header   `yaml:"headers"`           // static headers that should be added to all requests
+	URL               string        `yaml:"url"`               // post url for the endpoint, may hold placeholders resolved per event.
+	Headers           http.Header   `yaml:"headers"`           // headers that should be added to all requests, may hold placeholders
 	Timeout           time.Duration `yaml:"timeout"`           // HTTP timeout
 	Threshold         int           `yaml:"threshold"`         // circuit breaker threshold before backing off on failure
 	Backoff           time.Duration `yaml:"backoff"`           // backoff duration
 	IgnoredMediaTypes []string      `yaml:"ignoredmediatypes"` // target media types to ignore
 	Ignore            Ignore        `yaml:"ignore"`            // ignore event types
+	Signing           Signing       `yaml:"signing,omitempty"` // sign requests with a shared secret
+	TLS               EndpointTLS   `yaml:"tls,omitempty"`     // tls settings of the client
+}
+
+// EndpointTLS configures the TLS client of a notification endpoint.
+type EndpointTLS struct {
+	// Certificate and Key are the paths to the client certificate and its
+	// private key, presented to the endpoint for mutual TLS.
+	Certificate string `yaml:"certificate,omitempty"`
+	Key         string `yaml:"key,omitempty"`
+
+	// RootCAs are the paths to the PEM encoded CA certificates the
+	// certificate of the endpoint is verified against, in place of the
+	// system roots, pinning the CAs of the endpoint.
+	RootCAs []string `yaml:"rootcas,omitempty"`
+}
+
+// Signing configures the signing of notification requests with an HMAC of
+// their body, so that endpoints can verify they were sent by the registry.
+type Signing struct {
+	// Secret is the key of the HMAC. Requests are only signed if it is set.
+	Secret string `yaml:"secret,omitempty"`
+
+	// Header is the name of the header holding the signature, defaulting to
+	// X-Registry-Signature.
+	Header string `yaml:"header,omitempty"`
+
+	// Algorithm is the hash function of the HMAC: sha256, the default,
+	// sha384 or sha512.
+	Algorithm string `yaml:"algorithm,omitempty"`
 }
 
 // Events configures notification events.
@@ -718,6 +1553,18 @@ type Proxy struct {
 	// that cache writes don't hang indefinitely if the storage backend is slow.
 	// If not set, defaults to 5 minutes.
 	CacheWriteTimeout *time.Duration `yaml:"cachewritetimeout,omitempty"`
+
+	// FetchRetries is the number of times an interrupted fetch of a blob
+	// from the remote registry is resumed from the offset reached, with an
+	// exponential backoff. If not set, defaults to 3. Set to zero to fail
+	// the fetch on the first interruption.
+	FetchRetries *int `yaml:"fetchretries,omitempty"`
+
+	// Revalidate enables the revalidation of the tags pulled against the
+	// remote registry with conditional requests carrying the digest of
+	// the manifest cached, so that the manifest is only downloaded again
+	// if the tag moved.
+	Revalidate bool `yaml:"revalidate,omitempty"`
 }
 
 // ExecConfig defines the configuration for executing a command as a credential helper.
@@ -748,6 +1595,80 @@ type Validation struct {
 
 	// Manifests configures manifest validation.
 	Manifests ValidationManifests `yaml:"manifests,omitempty"`
+
+	// Blobs configures blob validation.
+	Blobs ValidationBlobs `yaml:"blobs,omitempty"`
+
+	// Tags configures tag validation.
+	Tags ValidationTags `yaml:"tags,omitempty"`
+}
+
+// ValidationTags configures validation rules for the tags of repositories.
+type ValidationTags struct {
+	// Protect lists the rules protecting tags against deletion.
+	Protect []TagProtectionRule `yaml:"protect,omitempty"`
+
+	// Limit caps the number of tags of each repository.
+	Limit TagLimit `yaml:"limit,omitempty"`
+}
+
+// TagLimit caps the number of tags of each repository, enforced when a
+// manifest is pushed by a new tag.
+type TagLimit struct {
+	// Max is the maximum number of tags of a repository. Zero disables the
+	// limit.
+	Max int `yaml:"max,omitempty"`
+
+	// Action is the action taken by the creation of a new tag in a
+	// repository holding Max tags: "reject", the default, rejects it, and
+	// "evict" deletes the least recently updated tags to make room for the
+	// new one.
+	// Tags protected by the Protect rules are never evicted.
+	Action string `yaml:"action,omitempty"`
+}
+
+// TagProtectionRule protects the tags matching a regular expression in a set
+// of repositories against deletion. A deletion of such tags is rejected if
+// fewer than Min of them would remain, and always if Min is zero. Clients
+// granted the force-delete action may override the rule.
+type TagProtectionRule struct {
+	// Name names the rule in the errors of the deletions it rejects.
+	// Defaults to the position of the rule.
+	Name string `yaml:"name,omitempty"`
+
+	// Repositories lists patterns of the repository names the rule applies
+	// to, in the syntax of path.Match.
+	Repositories []string `yaml:"repositories,omitempty"`
+
+	// Tags is a regular expression (https://godoc.org/regexp/syntax) the
+	// whole protected tags match.
+	Tags string `yaml:"tags,omitempty"`
+
+	// Min is the minimum number of tags matching the rule remaining after
+	// a deletion. The matching tags may not be deleted at all if zero.
+	Min int `yaml:"min,omitempty"`
+}
+
+// ValidationBlobs configures validation rules for blobs uploaded to the registry.
+type ValidationBlobs struct {
+	// Decompression bounds the decompressed size of uploaded blobs.
+	Decompression ValidationDecompression `yaml:"decompression,omitempty"`
+}
+
+// ValidationDecompression configures the rejection of gzip and zstd
+// compressed blobs decompressing to an excessive size, such as
+// decompression bombs. Every uploaded blob is decompressed on commit,
+// which costs CPU, so it is disabled by default.
+type ValidationDecompression struct {
+	// Enabled enables the validation.
+	Enabled bool `yaml:"enabled,omitempty"`
+
+	// MaxRatio is the maximum ratio of the decompressed size of a blob to
+	// its compressed size.
+	MaxRatio int64 `yaml:"maxratio,omitempty"`
+
+	// MaxSize is the maximum decompressed size of a blob, in bytes.
+	MaxSize int64 `yaml:"maxsize,omitempty"`
 }
 
 // ValidationManifests configures validation rules for manifests pushed to the registry.
@@ -757,6 +1678,125 @@ type ValidationManifests struct {
 
 	// ImageIndexes configures validation of image indexes
 	Indexes ValidationIndexes `yaml:"indexes,omitempty"`
+
+	// ImageConfig configures validation of the config media types of image
+	// manifests.
+	ImageConfig ValidationImageConfig `yaml:"imageconfig,omitempty"`
+
+	// LayerMediaTypes configures the correction of the layer media types of
+	// image manifests.
+	LayerMediaTypes ValidationLayerMediaTypes `yaml:"layermediatypes,omitempty"`
+
+	// BlobRetry configures the retries of the checks that the blobs
+	// referenced by image manifests exist.
+	BlobRetry ValidationBlobRetry `yaml:"blobretry,omitempty"`
+
+	// Created configures the rejection of image manifests whose config was
+	// created too long ago.
+	Created ValidationCreated `yaml:"created,omitempty"`
+
+	// Canonical configures the check that pushed manifests are in canonical
+	// form.
+	Canonical ValidationCanonical `yaml:"canonical,omitempty"`
+
+	// UniqueLayers configures the rejection of image manifests listing a
+	// layer more than once.
+	UniqueLayers ValidationUniqueLayers `yaml:"uniquelayers,omitempty"`
+
+	// LayerSize configures the rejection of image manifests listing a layer
+	// too large.
+	LayerSize ValidationLayerSize `yaml:"layersize,omitempty"`
+}
+
+// ValidationLayerSize rejects image manifests listing a layer larger than a
+// maximum size, as stated by the layer descriptors of the manifests. It is
+// disabled by default.
+type ValidationLayerSize struct {
+	// MaxSize is the maximum size of a layer, in bytes. Zero disables the
+	// validation.
+	MaxSize int64 `yaml:"maxsize,omitempty"`
+}
+
+// ValidationUniqueLayers rejects image manifests listing the same layer
+// digest more than once. The empty layers, which some tools legitimately
+// repeat, are always allowed to repeat. It is disabled by default.
+type ValidationUniqueLayers struct {
+	// Enabled enables the validation.
+	Enabled bool `yaml:"enabled,omitempty"`
+
+	// AllowRepeated lists the digests of further layers allowed to be
+	// listed more than once.
+	AllowRepeated []string `yaml:"allowrepeated,omitempty"`
+}
+
+// ValidationCanonical checks that the bytes of pushed manifests are their
+// canonical JSON form, with sorted keys and no insignificant whitespace.
+// Non-canonical manifests are logged, or rejected, but never rewritten, as
+// that would change their digest. As most clients serialize manifests their
+// own way, it is disabled by default.
+type ValidationCanonical struct {
+	// Enabled enables the check.
+	Enabled bool `yaml:"enabled,omitempty"`
+
+	// Reject rejects the non-canonical manifests, which are only logged
+	// otherwise.
+	Reject bool `yaml:"reject,omitempty"`
+}
+
+// ValidationCreated rejects image manifests whose config was created longer
+// ago than MaxAge, as given by its created field, to keep images built on
+// outdated bases out of the registry. It is disabled by default.
+type ValidationCreated struct {
+	// MaxAge is the longest time since the creation of the config of a
+	// pushed image. The validation is disabled if zero.
+	MaxAge time.Duration `yaml:"maxage,omitempty"`
+
+	// Strict rejects the images whose config has no creation time, which
+	// are accepted otherwise.
+	Strict bool `yaml:"strict,omitempty"`
+}
+
+// ValidationBlobRetry retries the checks that the blobs referenced by a
+// pushed image manifest exist, for storage on which blobs uploaded in
+// parallel with the manifest become visible late. As it delays the rejection
+// of manifests referencing missing blobs, it is disabled by default.
+type ValidationBlobRetry struct {
+	// MaxWait bounds the time spent retrying the checks of a manifest.
+	// Retries are disabled if zero.
+	MaxWait time.Duration `yaml:"maxwait,omitempty"`
+
+	// Backoff is the delay before the first retry, doubled before each
+	// following one. Defaults to 100ms.
+	Backoff time.Duration `yaml:"backoff,omitempty"`
+}
+
+// ValidationLayerMediaTypes sets the media type of the layers image manifests
+// pushed by tag reference with a missing or ambiguous media type, from the
+// content of the layers. As it changes the content of the manifests, and so
+// their digest, it is disabled by default.
+type ValidationLayerMediaTypes struct {
+	// Correct enables the correction.
+	Correct bool `yaml:"correct,omitempty"`
+
+	// Repositories restricts the correction to these repositories, and the
+	// repositories under them. The correction applies to all repositories
+	// if empty.
+	Repositories []string `yaml:"repositories,omitempty"`
+}
+
+// ValidationImageConfig restricts the config media types of pushed image
+// manifests to those of container images, well-known artifacts, and any
+// media type explicitly allowed.
+type ValidationImageConfig struct {
+	// Enabled enables the validation.
+	Enabled bool `yaml:"enabled,omitempty"`
+
+	// DisableArtifacts rejects the config media types of well-known
+	// artifacts, such as helm charts, unless explicitly allowed.
+	DisableArtifacts bool `yaml:"disableartifacts,omitempty"`
+
+	// Allow lists additional config media types to accept.
+	Allow []string `yaml:"allow,omitempty"`
 }
 
 // URLs defines validation rules for URLs found in the manifests pushed to the registry.
@@ -777,6 +1817,15 @@ type ValidationIndexes struct {
 
 	// PlatformList filters the set of platforms to validate for image existence.
 	PlatformList []Platform `yaml:"platformlist,omitempty"`
+
+	// RequireWindowsOSVersion rejects image indexes referencing images for
+	// the windows OS without specifying their os.version.
+	RequireWindowsOSVersion bool `yaml:"requirewindowsosversion,omitempty"`
+
+	// VerifyChildren rejects image indexes whose descriptors of the child
+	// manifests already in the repository do not match their size and media
+	// type.
+	VerifyChildren bool `yaml:"verifychildren,omitempty"`
 }
 
 // Platforms configures the validation applies to the platform images included in an image index
@@ -848,6 +1897,9 @@ func Parse(rd io.Reader) (*Configuration, error) {
 					if v0_1.Storage.Type() == "" {
 						return nil, errors.New("no storage configuration provided")
 					}
+					if err := v0_1.Compatibility.Validate(); err != nil {
+						return nil, err
+					}
 					return (*Configuration)(v0_1), nil
 				}
 				return nil, fmt.Errorf("expected *v0_1Configuration, received %#v", c)
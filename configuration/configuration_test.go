@@ -387,6 +387,82 @@ func (suite *ConfigSuite) TestParseInvalidVersion() {
 	suite.Require().Error(err)
 }
 
+// TestParseAuthObfuscateNotFound validates that the obfuscatenotfound option
+// can be set alongside the auth type, and survives a round trip.
+func (suite *ConfigSuite) TestParseAuthObfuscateNotFound() {
+	configYaml := "version: 0.1\nstorage: inmemory\nauth:\n  obfuscatenotfound: true\n  silly:\n    realm: silly\n"
+	config, err := Parse(bytes.NewReader([]byte(configYaml)))
+	suite.Require().NoError(err)
+	suite.Require().Equal("silly", config.Auth.Type())
+	suite.Require().Equal(Parameters{"realm": "silly"}, config.Auth.Parameters())
+	suite.Require().True(config.Auth.ObfuscateNotFound())
+
+	configBytes, err := yaml.Marshal(config)
+	suite.Require().NoError(err)
+	config, err = Parse(bytes.NewReader(configBytes))
+	suite.Require().NoError(err)
+	suite.Require().Equal("silly", config.Auth.Type())
+	suite.Require().True(config.Auth.ObfuscateNotFound())
+
+	config, err = Parse(bytes.NewReader([]byte(configYamlV0_1)))
+	suite.Require().NoError(err)
+	suite.Require().False(config.Auth.ObfuscateNotFound())
+}
+
+// TestParseAuthDeleteAsPush validates that the deleteaspush option can be
+// set alongside the auth type and obfuscatenotfound, and survives a round
+// trip.
+func (suite *ConfigSuite) TestParseAuthDeleteAsPush() {
+	configYaml := "version: 0.1\nstorage: inmemory\nauth:\n  deleteaspush: true\n  token:\n    realm: token\n"
+	config, err := Parse(bytes.NewReader([]byte(configYaml)))
+	suite.Require().NoError(err)
+	suite.Require().Equal("token", config.Auth.Type())
+	suite.Require().True(config.Auth.DeleteAsPush())
+	suite.Require().False(config.Auth.ObfuscateNotFound())
+
+	config.Auth[authObfuscateNotFound] = Parameters{"enabled": true}
+	configBytes, err := yaml.Marshal(config)
+	suite.Require().NoError(err)
+	config, err = Parse(bytes.NewReader(configBytes))
+	suite.Require().NoError(err)
+	suite.Require().Equal("token", config.Auth.Type())
+	suite.Require().Equal(Parameters{"realm": "token"}, config.Auth.Parameters())
+	suite.Require().True(config.Auth.DeleteAsPush())
+	suite.Require().True(config.Auth.ObfuscateNotFound())
+
+	config, err = Parse(bytes.NewReader([]byte(configYamlV0_1)))
+	suite.Require().NoError(err)
+	suite.Require().False(config.Auth.DeleteAsPush())
+}
+
+// TestParseNamespaceRewrites validates that ambiguous namespace rewrites are
+// rejected.
+func (suite *ConfigSuite) TestParseNamespaceRewrites() {
+	parse := func(rules string) error {
+		configYaml := "version: 0.1\nstorage: inmemory\ncompatibility:\n  namespacerewrites:\n" + rules
+		_, err := Parse(bytes.NewReader([]byte(configYaml)))
+		return err
+	}
+
+	suite.Require().NoError(parse("  - {from: oldteam, to: platform/oldteam}\n  - {from: legacy/app, to: platform/app, writes: true}\n"))
+	suite.Require().NoError(parse("  - {from: oldteam, to: platform/oldteam}\n  - {from: oldteamapp, to: platform/oldteamapp}\n"))
+
+	for _, rules := range []string{
+		// invalid prefixes
+		"  - {from: oldteam}\n",
+		"  - {from: oldteam/, to: platform}\n",
+		// overlapping rules
+		"  - {from: oldteam, to: platform/a}\n  - {from: oldteam, to: platform/b}\n",
+		"  - {from: oldteam, to: platform/a}\n  - {from: oldteam/app, to: platform/b}\n",
+		// loops
+		"  - {from: oldteam, to: oldteam/app}\n",
+		"  - {from: a, to: b}\n  - {from: b, to: a}\n",
+		"  - {from: a, to: b}\n  - {from: b/c, to: d}\n",
+	} {
+		suite.Require().Error(parse(rules), rules)
+	}
+}
+
 // TestParseExtraneousVars validates that environment variables referring to
 // nonexistent variables don't cause side effects.
 func (suite *ConfigSuite) TestParseExtraneousVars() {
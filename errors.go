@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/opencontainers/go-digest"
 )
@@ -107,6 +108,84 @@ func (err ErrManifestBlobUnknown) Error() string {
 	return fmt.Sprintf("unknown blob %v on manifest", err.Digest)
 }
 
+// ErrManifestPlatformInvalid is returned when the platform of a manifest
+// referenced by an image index is invalid.
+type ErrManifestPlatformInvalid struct {
+	Digest digest.Digest
+	Reason string
+}
+
+func (err ErrManifestPlatformInvalid) Error() string {
+	return fmt.Sprintf("invalid platform for manifest %v: %s", err.Digest, err.Reason)
+}
+
+// ErrManifestChildDescriptorInvalid is returned when the descriptor of a
+// manifest referenced by an image index does not match the stored manifest.
+type ErrManifestChildDescriptorInvalid struct {
+	Digest digest.Digest
+	Reason string
+}
+
+func (err ErrManifestChildDescriptorInvalid) Error() string {
+	return fmt.Sprintf("invalid descriptor for manifest %v: %s", err.Digest, err.Reason)
+}
+
+// ErrManifestConfigMediaTypeInvalid is returned when the config of an image
+// manifest has a media type the registry does not accept.
+type ErrManifestConfigMediaTypeInvalid struct {
+	MediaType string
+}
+
+func (err ErrManifestConfigMediaTypeInvalid) Error() string {
+	return fmt.Sprintf("config media type %q not allowed", err.MediaType)
+}
+
+// ErrManifestConfigCreatedInvalid is returned when the config of an image
+// manifest was created longer ago than the registry accepts, or has no
+// creation time while the registry requires one.
+type ErrManifestConfigCreatedInvalid struct {
+	// Created is the creation time of the config, nil if it has none.
+	Created *time.Time
+	MaxAge  time.Duration
+}
+
+func (err ErrManifestConfigCreatedInvalid) Error() string {
+	if err.Created == nil {
+		return "config has no created timestamp"
+	}
+	return fmt.Sprintf("config created at %s, more than %s ago", err.Created.UTC().Format(time.RFC3339), err.MaxAge)
+}
+
+// ErrManifestNotCanonical is returned when the bytes of a manifest differ
+// from its canonical serialization.
+type ErrManifestNotCanonical struct{}
+
+func (ErrManifestNotCanonical) Error() string {
+	return "manifest is not in canonical form"
+}
+
+// ErrManifestLayerDuplicate is returned when an image manifest lists the
+// same layer more than once.
+type ErrManifestLayerDuplicate struct {
+	Digest digest.Digest
+}
+
+func (err ErrManifestLayerDuplicate) Error() string {
+	return fmt.Sprintf("layer %s listed more than once", err.Digest)
+}
+
+// ErrManifestLayerTooLarge is returned when an image manifest lists a layer
+// larger than the registry accepts.
+type ErrManifestLayerTooLarge struct {
+	Digest  digest.Digest
+	Size    int64
+	MaxSize int64
+}
+
+func (err ErrManifestLayerTooLarge) Error() string {
+	return fmt.Sprintf("layer %s of %d bytes exceeds the maximum layer size of %d bytes", err.Digest, err.Size, err.MaxSize)
+}
+
 // ErrManifestNameInvalid should be used to denote an invalid manifest
 // name. Reason may set, indicating the cause of invalidity.
 type ErrManifestNameInvalid struct {
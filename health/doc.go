@@ -7,6 +7,11 @@
 // with errors, the JSON reply will include all the failed checks, and the
 // response will be have an HTTP 503 status.
 //
+// "/debug/health/ready" is the same endpoint, which also fails once the
+// service drains through Drain, while "/debug/health/live" always returns an
+// HTTP 200 status, so that orchestrators can tell a service to take out of
+// rotation from one to restart.
+//
 // A Check can either be run synchronously, or asynchronously. We recommend
 // that most checks are registered as an asynchronous check, so a call to the
 // "/debug/health" endpoint always returns immediately. This pattern is
@@ -7,24 +7,32 @@ import (
 	"fmt"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/distribution/distribution/v3/internal/dcontext"
 	"github.com/distribution/distribution/v3/registry/api/errcode"
 )
 
-// Registers global /debug/health api endpoint, creates default registry
+// Registers global /debug/health api endpoints, creates default registry
 func init() {
 	DefaultRegistry = NewRegistry()
 	http.HandleFunc("/debug/health", StatusHandler)
+	http.HandleFunc("/debug/health/ready", StatusHandler)
+	http.HandleFunc("/debug/health/live", LiveHandler)
 }
 
+// drainingCheck is the name under which the readiness status reports a
+// draining registry.
+const drainingCheck = "draining"
+
 // A Registry is a collection of checks. Most applications will use the global
 // registry defined in DefaultRegistry. However, unit tests may need to create
 // separate registries to isolate themselves from other tests.
 type Registry struct {
 	mu               sync.RWMutex
 	registeredChecks map[string]Checker
+	draining         atomic.Bool
 }
 
 // NewRegistry creates a new registry. This isn't necessary for normal use of
@@ -141,6 +149,76 @@ func NewThresholdStatusUpdater(t int) Updater {
 	return NewStatusUpdater()
 }
 
+// Component is the health check of a long-running background component,
+// such as a scheduler or a queue consumer. The component reports its
+// progress through Alive, and is unhealthy once it has not done so for
+// longer than its threshold, unless it reported waiting for work through
+// Idle. A nil Component discards the reports, so that components can report
+// their progress whether or not it is checked.
+type Component struct {
+	threshold time.Duration
+
+	mu   sync.Mutex
+	last time.Time
+	idle bool
+}
+
+// ComponentFunc returns the check of the named component, unhealthy after
+// threshold without progress, or nil if the component is not checked.
+type ComponentFunc func(name string, threshold time.Duration) *Component
+
+// NewComponent returns the check of a component which must report progress
+// at least every threshold.
+func NewComponent(threshold time.Duration) *Component {
+	return &Component{threshold: threshold, last: time.Now()}
+}
+
+// Alive records the progress of the component, such as the start or the
+// successful completion of an iteration of its work.
+func (c *Component) Alive() {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.last = time.Now()
+	c.idle = false
+}
+
+// Idle records that the component is waiting for work, and thus healthy
+// until it reports progress again.
+func (c *Component) Idle() {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.idle = true
+}
+
+// Check implements the Checker interface, failing if the component has not
+// reported progress for longer than its threshold.
+func (c *Component) Check(context.Context) error {
+	if c == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.idle {
+		return nil
+	}
+	if since := time.Since(c.last); since > c.threshold {
+		return fmt.Errorf("no progress since %s (%s ago, threshold %s)", c.last.UTC().Format(time.RFC3339), since.Truncate(time.Second), c.threshold)
+	}
+	return nil
+}
+
 type pollingTerminatedErr struct{ Err error }
 
 func (e pollingTerminatedErr) Error() string {
@@ -187,6 +265,27 @@ func CheckStatus(ctx context.Context) map[string]string {
 	return DefaultRegistry.CheckStatus(ctx)
 }
 
+// ReadyStatus returns a map with all the current health check errors, along
+// with a "draining" entry once the registry drains.
+func (registry *Registry) ReadyStatus(ctx context.Context) map[string]string {
+	statusKeys := registry.CheckStatus(ctx)
+	if registry.draining.Load() {
+		statusKeys[drainingCheck] = "the service is shutting down"
+	}
+	return statusKeys
+}
+
+// Drain marks the service as draining, such as during a graceful shutdown,
+// so that it is no longer ready while it remains live.
+func (registry *Registry) Drain() {
+	registry.draining.Store(true)
+}
+
+// Drain marks the service of the default registry as draining.
+func Drain() {
+	DefaultRegistry.Drain()
+}
+
 // Register associates the checker with the provided name.
 func (registry *Registry) Register(name string, check Checker) {
 	if registry == nil {
@@ -207,6 +306,22 @@ func Register(name string, check Checker) {
 	DefaultRegistry.Register(name, check)
 }
 
+// Unregister removes the checker associated with the provided name, if any.
+func (registry *Registry) Unregister(name string) {
+	if registry == nil {
+		registry = DefaultRegistry
+	}
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	delete(registry.registeredChecks, name)
+}
+
+// Unregister removes the checker associated with the provided name from the
+// default registry, if any.
+func Unregister(name string) {
+	DefaultRegistry.Unregister(name)
+}
+
 // RegisterFunc allows the convenience of registering a checker directly from
 // an arbitrary func(context.Context) error.
 func (registry *Registry) RegisterFunc(name string, check CheckFunc) {
@@ -220,11 +335,17 @@ func RegisterFunc(name string, check CheckFunc) {
 }
 
 // StatusHandler returns a JSON blob with all the currently registered Health Checks
-// and their corresponding status.
+// and their corresponding status, and whether the service is draining. It
+// serves both /debug/health and /debug/health/ready.
 // Returns 503 if any Error status exists, 200 otherwise
 func StatusHandler(w http.ResponseWriter, r *http.Request) {
+	DefaultRegistry.StatusHandler(w, r)
+}
+
+// StatusHandler is the readiness handler of the registry.
+func (registry *Registry) StatusHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
-		checks := CheckStatus(r.Context())
+		checks := registry.ReadyStatus(r.Context())
 		status := http.StatusOK
 
 		// If there is an error, return 503
@@ -238,6 +359,17 @@ func StatusHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// LiveHandler reports that the process is alive, regardless of the health
+// checks and of the draining state, so that failing dependencies take the
+// service out of rotation without restarting it.
+func LiveHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		statusResponse(w, r, http.StatusOK, map[string]string{})
+	} else {
+		http.NotFound(w, r)
+	}
+}
+
 // Handler returns a handler that will return 503 response code if the health
 // checks have failed. If everything is okay with the health checks, the
 // handler will pass through to the provided handler. Use this handler to
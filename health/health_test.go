@@ -191,3 +191,93 @@ func TestPoll(t *testing.T) {
 		})
 	}
 }
+
+// TestComponent ensures that the health endpoint reports a component down
+// once it stops reporting progress for longer than its threshold, and up
+// again once it is unregistered.
+func TestComponent(t *testing.T) {
+	// clear out existing checks.
+	DefaultRegistry = NewRegistry()
+
+	checkStatus := func(message string, expected int) {
+		t.Helper()
+
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "https://fakeurl.com/debug/health", nil)
+		StatusHandler(recorder, req)
+		if recorder.Code != expected {
+			t.Fatalf("unexpected response code %s: %d != %d", message, recorder.Code, expected)
+		}
+	}
+
+	const threshold = 50 * time.Millisecond
+	component := NewComponent(threshold)
+	Register("component", component)
+
+	// a component making progress is up
+	for range 3 {
+		component.Alive()
+		checkStatus("while making progress", http.StatusOK)
+		time.Sleep(threshold / 2)
+	}
+
+	// a wedged component goes down after the threshold
+	component.Alive()
+	time.Sleep(2 * threshold)
+	checkStatus("once wedged", http.StatusServiceUnavailable)
+
+	// an idle component is up regardless of its last progress
+	component.Idle()
+	checkStatus("while idle", http.StatusOK)
+	component.Alive()
+	time.Sleep(2 * threshold)
+	checkStatus("once wedged after idling", http.StatusServiceUnavailable)
+
+	Unregister("component")
+	checkStatus("once unregistered", http.StatusOK)
+
+	// a nil component is always up
+	var nilComponent *Component
+	nilComponent.Alive()
+	if err := nilComponent.Check(context.Background()); err != nil {
+		t.Errorf("nil component check = %v; want nil", err)
+	}
+}
+
+// TestReadinessAndLiveness ensures that the readiness endpoint fails while a
+// check fails or the registry drains, and that the liveness endpoint does not.
+func TestReadinessAndLiveness(t *testing.T) {
+	registry := NewRegistry()
+	storage := NewStatusUpdater()
+	registry.Register("storagedriver_inmemory", storage)
+
+	status := func(t *testing.T, handler http.HandlerFunc) int {
+		t.Helper()
+		recorder := httptest.NewRecorder()
+		handler(recorder, httptest.NewRequest(http.MethodGet, "https://fakeurl.com/debug/health", nil))
+		return recorder.Code
+	}
+	check := func(t *testing.T, ready int) {
+		t.Helper()
+		if code := status(t, registry.StatusHandler); code != ready {
+			t.Errorf("readiness: got %d, want %d", code, ready)
+		}
+		if code := status(t, LiveHandler); code != http.StatusOK {
+			t.Errorf("liveness: got %d, want %d", code, http.StatusOK)
+		}
+	}
+
+	check(t, http.StatusOK)
+
+	storage.Update(errors.New("storage unreachable"))
+	check(t, http.StatusServiceUnavailable)
+
+	storage.Update(nil)
+	check(t, http.StatusOK)
+
+	registry.Drain()
+	check(t, http.StatusServiceUnavailable)
+	if _, ok := registry.ReadyStatus(context.Background())["draining"]; !ok {
+		t.Error("expected the readiness status to report draining")
+	}
+}
@@ -340,6 +340,53 @@ func (t *tags) Get(ctx context.Context, tag string) (v1.Descriptor, error) {
 	}
 }
 
+// Revalidate checks whether tag still references the manifest of digest dgst
+// with a HEAD request conditional on the Etag of that manifest, so that the
+// registry answers 304 Not Modified if the tag did not move. If the tag moved,
+// the descriptor of the manifest it now references is returned with modified
+// set. Registries answering neither 304 nor a digest fall back to Get.
+func (t *tags) Revalidate(ctx context.Context, tag string, dgst digest.Digest) (_ v1.Descriptor, modified bool, _ error) {
+	ref, err := reference.WithTag(t.name, tag)
+	if err != nil {
+		return v1.Descriptor{}, false, err
+	}
+	u, err := t.ub.BuildManifestURL(ref)
+	if err != nil {
+		return v1.Descriptor{}, false, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, u, nil)
+	if err != nil {
+		return v1.Descriptor{}, false, err
+	}
+	for _, mediaType := range distribution.ManifestMediaTypes() {
+		req.Header.Add("Accept", mediaType)
+	}
+	req.Header.Set("If-None-Match", fmt.Sprintf(`"%s"`, dgst))
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return v1.Descriptor{}, false, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified:
+		return v1.Descriptor{}, false, nil
+	case resp.StatusCode >= 200 && resp.StatusCode < 300 && len(resp.Header.Get("Docker-Content-Digest")) > 0:
+		desc, err := descriptorFromResponse(resp)
+		if err != nil {
+			return v1.Descriptor{}, false, err
+		}
+		return desc, desc.Digest != dgst, nil
+	}
+
+	desc, err := t.Get(ctx, tag)
+	if err != nil {
+		return v1.Descriptor{}, false, err
+	}
+	return desc, desc.Digest != dgst, nil
+}
+
 func (t *tags) Lookup(ctx context.Context, digest v1.Descriptor) ([]string, error) {
 	panic("not implemented")
 }
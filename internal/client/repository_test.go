@@ -1169,6 +1169,72 @@ func TestManifestFetchWithEtag(t *testing.T) {
 	}
 }
 
+func TestTagRevalidate(t *testing.T) {
+	repo, _ := reference.WithName("test.example.com/repo/revalidate")
+	_, d1, _ := newRandomOCIManifest(t, 6)
+	_, d2, p2 := newRandomOCIManifest(t, 6)
+	var m testutil.RequestResponseMap
+	// the tag is unchanged while the manifest of d1 is cached, then moves
+	// to the manifest of d2
+	m = append(m, testutil.RequestResponseMapping{
+		Request: testutil.Request{
+			Method: http.MethodHead,
+			Route:  "/v2/" + repo.Name() + "/manifests/latest",
+			Headers: http.Header(map[string][]string{
+				"If-None-Match": {fmt.Sprintf(`"%s"`, d1)},
+			}),
+		},
+		Response: testutil.Response{
+			StatusCode: http.StatusNotModified,
+		},
+	}, testutil.RequestResponseMapping{
+		Request: testutil.Request{
+			Method: http.MethodHead,
+			Route:  "/v2/" + repo.Name() + "/manifests/latest",
+			Headers: http.Header(map[string][]string{
+				"If-None-Match": {fmt.Sprintf(`"%s"`, d1)},
+			}),
+		},
+		Response: testutil.Response{
+			StatusCode: http.StatusOK,
+			Headers: http.Header(map[string][]string{
+				"Content-Length":        {fmt.Sprint(len(p2))},
+				"Content-Type":          {v1.MediaTypeImageManifest},
+				"Docker-Content-Digest": {d2.String()},
+			}),
+		},
+	})
+
+	e, c := testServer(m)
+	defer c()
+
+	ctx := dcontext.Background()
+	r, err := NewRepository(repo, e, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tags := r.Tags(ctx).(*tags)
+
+	_, modified, err := tags.Revalidate(ctx, "latest", d1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if modified {
+		t.Fatal("expected the unchanged tag not to be modified")
+	}
+
+	desc, modified, err := tags.Revalidate(ctx, "latest", d1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !modified {
+		t.Fatal("expected the moved tag to be modified")
+	}
+	if desc.Digest != d2 || desc.Size != int64(len(p2)) {
+		t.Fatalf("unexpected descriptor of the moved tag: %v", desc)
+	}
+}
+
 func TestManifestFetchWithAccept(t *testing.T) {
 	ctx := dcontext.Background()
 	repo, _ := reference.WithName("test.example.com/repo")
@@ -230,6 +230,11 @@ func (irw *instrumentedResponseWriter) Flush() {
 	}
 }
 
+// Unwrap returns the underlying response writer, for http.ResponseController.
+func (irw *instrumentedResponseWriter) Unwrap() http.ResponseWriter {
+	return irw.ResponseWriter
+}
+
 func (irw *instrumentedResponseWriter) Value(key any) any {
 	if keyStr, ok := key.(string); ok {
 		switch keyStr {
@@ -0,0 +1,94 @@
+// Package servertiming collects the time spent in the phases of a request,
+// to report it to the client through the Server-Timing response header.
+package servertiming
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HeaderName is the name of the response header reporting the timings.
+const HeaderName = "Server-Timing"
+
+type timingsKey struct{}
+
+// Timings accumulates the time spent in named phases of a request. Phases
+// may be entered more than once, such as storage driver calls, in which
+// case their durations add up. A nil Timings discards every duration, so
+// that code can record timings whether or not they are collected.
+type Timings struct {
+	mu      sync.Mutex
+	metrics []*metric
+}
+
+type metric struct {
+	name     string
+	duration time.Duration
+	count    int
+}
+
+// WithTimings returns a context collecting the timings of a request.
+func WithTimings(ctx context.Context) (context.Context, *Timings) {
+	t := &Timings{}
+	return context.WithValue(ctx, timingsKey{}, t), t
+}
+
+// FromContext returns the timings collected for ctx, or nil if timings are
+// not collected.
+func FromContext(ctx context.Context) *Timings {
+	t, _ := ctx.Value(timingsKey{}).(*Timings)
+	return t
+}
+
+// Since adds the time elapsed since start to the phase name of the timings
+// collected for ctx, if any.
+func Since(ctx context.Context, name string, start time.Time) {
+	if t := FromContext(ctx); t != nil {
+		t.Add(name, time.Since(start))
+	}
+}
+
+// Add adds d to the phase name.
+func (t *Timings) Add(name string, d time.Duration) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, m := range t.metrics {
+		if m.name == name {
+			m.duration += d
+			m.count++
+			return
+		}
+	}
+	t.metrics = append(t.metrics, &metric{name: name, duration: d, count: 1})
+}
+
+// String formats the timings as the value of a Server-Timing header, in the
+// order the phases were first entered. Durations are in milliseconds, and
+// phases entered more than once are described with their number of entries.
+func (t *Timings) String() string {
+	if t == nil {
+		return ""
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	entries := make([]string, 0, len(t.metrics))
+	for _, m := range t.metrics {
+		entry := m.name + ";dur=" + strconv.FormatFloat(float64(m.duration)/float64(time.Millisecond), 'f', 3, 64)
+		if m.count > 1 {
+			entry += fmt.Sprintf(";desc=\"%d calls\"", m.count)
+		}
+		entries = append(entries, entry)
+	}
+	return strings.Join(entries, ", ")
+}
@@ -0,0 +1,39 @@
+package servertiming
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTimings(t *testing.T) {
+	ctx, timings := WithTimings(context.Background())
+	if FromContext(ctx) != timings {
+		t.Fatal("expected the timings of the context")
+	}
+
+	timings.Add("auth", 1500*time.Microsecond)
+	timings.Add("storage", time.Millisecond)
+	timings.Add("storage", 2*time.Millisecond)
+	timings.Add("total", 10*time.Millisecond)
+
+	expected := `auth;dur=1.500, storage;dur=3.000;desc="2 calls", total;dur=10.000`
+	if got := timings.String(); got != expected {
+		t.Fatalf("expected %q, got %q", expected, got)
+	}
+}
+
+func TestTimingsDisabled(t *testing.T) {
+	ctx := context.Background()
+	if FromContext(ctx) != nil {
+		t.Fatal("expected no timings")
+	}
+
+	// recording without timings is a no-op
+	Since(ctx, "storage", time.Now())
+	var timings *Timings
+	timings.Add("storage", time.Second)
+	if got := timings.String(); got != "" {
+		t.Fatalf("expected no header, got %q", got)
+	}
+}
@@ -55,6 +55,10 @@ type Manifest struct {
 	// MediaType is the media type of this schema.
 	MediaType string `json:"mediaType,omitempty"`
 
+	// ArtifactType is the type of an artifact when the manifest is used for
+	// an artifact.
+	ArtifactType string `json:"artifactType,omitempty"`
+
 	// Config references the image configuration as a blob.
 	Config v1.Descriptor `json:"config"`
 
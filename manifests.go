@@ -49,6 +49,14 @@ type ManifestEnumerator interface {
 	Enumerate(ctx context.Context, ingester func(digest.Digest) error) error
 }
 
+// ManifestDescriber is implemented by manifest services which can describe
+// a manifest, possibly without reading its payload.
+type ManifestDescriber interface {
+	// Describe returns the descriptor of the manifest dgst, with the media
+	// type of its payload.
+	Describe(ctx context.Context, dgst digest.Digest) (v1.Descriptor, error)
+}
+
 // Describable is an interface for descriptors.
 //
 // Implementations of Describable are generally objects which can be
@@ -0,0 +1,73 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/distribution/distribution/v3/tracing"
+	"github.com/docker/go-metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// TraceIDLabel is the label of the exemplars holding the ID of the trace of
+// the observation.
+const TraceIDLabel = "trace_id"
+
+// exemplars records whether the latency histograms are observed with
+// exemplars.
+var exemplars atomic.Bool
+
+// EnableExemplars attaches the trace ID of the current span as an exemplar to
+// the observations of the latency histograms, and serves the metrics in the
+// OpenMetrics format to the scrapers accepting it.
+func EnableExemplars() {
+	exemplars.Store(true)
+}
+
+// Exemplar returns the exemplar labels of an observation made in ctx: the
+// trace ID of the sampled span of ctx, or nil if there is none or the
+// exemplars are disabled.
+func Exemplar(ctx context.Context) prometheus.Labels {
+	if !exemplars.Load() {
+		return nil
+	}
+	traceID, ok := tracing.TraceID(ctx)
+	if !ok {
+		return nil
+	}
+	return prometheus.Labels{TraceIDLabel: traceID}
+}
+
+// ObserveDuration observes the number of seconds elapsed since start with
+// observer, along with the exemplar of ctx if any.
+func ObserveDuration(ctx context.Context, observer prometheus.Observer, start time.Time) {
+	elapsed := time.Since(start).Seconds()
+	if labels := Exemplar(ctx); labels != nil {
+		if eo, ok := observer.(prometheus.ExemplarObserver); ok {
+			eo.ObserveWithExemplar(elapsed, labels)
+			return
+		}
+	}
+	observer.Observe(elapsed)
+}
+
+// Handler returns the handler serving the metrics, in the OpenMetrics format
+// with their exemplars to the scrapers accepting it if the exemplars are
+// enabled, as the other formats do not carry them.
+func Handler() http.Handler {
+	defaultHandler := metrics.Handler()
+	openMetricsHandler := promhttp.InstrumentMetricHandler(
+		prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{EnableOpenMetrics: true}),
+	)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if exemplars.Load() {
+			openMetricsHandler.ServeHTTP(w, r)
+			return
+		}
+		defaultHandler.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,122 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const traceID = "0102030405060708090a0b0c0d0e0f10"
+
+// enableExemplars enables the exemplars for the duration of the test.
+func enableExemplars(t *testing.T) {
+	t.Helper()
+	EnableExemplars()
+	t.Cleanup(func() { exemplars.Store(false) })
+}
+
+// withSpan returns ctx with a remote span of the trace traceID, sampled or
+// not.
+func withSpan(t *testing.T, ctx context.Context, sampled bool) context.Context {
+	t.Helper()
+	tid, err := trace.TraceIDFromHex(traceID)
+	require.NoError(t, err)
+	sid, err := trace.SpanIDFromHex("0102030405060708")
+	require.NoError(t, err)
+	var flags trace.TraceFlags
+	if sampled {
+		flags = trace.FlagsSampled
+	}
+	return trace.ContextWithSpanContext(ctx, trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    tid,
+		SpanID:     sid,
+		TraceFlags: flags,
+		Remote:     true,
+	}))
+}
+
+func TestExemplar(t *testing.T) {
+	ctx := context.Background()
+
+	require.Nil(t, Exemplar(withSpan(t, ctx, true)), "exemplars are disabled by default")
+
+	enableExemplars(t)
+	require.Nil(t, Exemplar(ctx))
+	require.Nil(t, Exemplar(withSpan(t, ctx, false)))
+	require.Equal(t, prometheus.Labels{TraceIDLabel: traceID}, Exemplar(withSpan(t, ctx, true)))
+}
+
+func TestObserveDuration(t *testing.T) {
+	enableExemplars(t)
+
+	histogram := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "test_seconds", Help: "test"})
+	registry := prometheus.NewRegistry()
+	require.NoError(t, registry.Register(histogram))
+
+	ObserveDuration(context.Background(), histogram, time.Now())
+	ObserveDuration(withSpan(t, context.Background(), true), histogram, time.Now())
+
+	families, err := registry.Gather()
+	require.NoError(t, err)
+	require.Len(t, families, 1)
+	h := families[0].GetMetric()[0].GetHistogram()
+	require.EqualValues(t, 2, h.GetSampleCount())
+
+	var exemplarLabels []map[string]string
+	for _, bucket := range h.GetBucket() {
+		if exemplar := bucket.GetExemplar(); exemplar != nil {
+			labels := make(map[string]string)
+			for _, label := range exemplar.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			exemplarLabels = append(exemplarLabels, labels)
+		}
+	}
+	require.Equal(t, []map[string]string{{TraceIDLabel: traceID}}, exemplarLabels)
+}
+
+func TestHandler(t *testing.T) {
+	histogram := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "registry_test_exemplars_seconds", Help: "test"})
+	require.NoError(t, prometheus.Register(histogram))
+	t.Cleanup(func() { prometheus.Unregister(histogram) })
+
+	server := httptest.NewServer(Handler())
+	t.Cleanup(server.Close)
+
+	scrape := func(t *testing.T) (string, string) {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+		require.NoError(t, err)
+		req.Header.Set("Accept", "application/openmetrics-text; version=1.0.0")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.Header.Get("Content-Type"), string(body)
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		ObserveDuration(withSpan(t, context.Background(), true), histogram, time.Now())
+		contentType, body := scrape(t)
+		require.True(t, strings.HasPrefix(contentType, "text/plain"), contentType)
+		require.Contains(t, body, "registry_test_exemplars_seconds_count 1")
+		require.NotContains(t, body, TraceIDLabel)
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		enableExemplars(t)
+		ObserveDuration(withSpan(t, context.Background(), true), histogram, time.Now())
+		contentType, body := scrape(t)
+		require.True(t, strings.HasPrefix(contentType, "application/openmetrics-text"), contentType)
+		require.Contains(t, body, `# {trace_id="`+traceID+`"}`)
+	})
+}
@@ -16,4 +16,31 @@ var (
 
 	// ProxyNamespace is the prometheus namespace of proxy related metrics
 	ProxyNamespace = metrics.NewNamespace(NamespacePrefix, "proxy", nil)
+
+	// HTTPNamespace is the prometheus namespace of http request handling related metrics
+	HTTPNamespace = metrics.NewNamespace(NamespacePrefix, "http", nil)
+
+	// TransferNamespace is the prometheus namespace of blob transfer related metrics
+	TransferNamespace = metrics.NewNamespace(NamespacePrefix, "transfer", nil)
+
+	// StorageMiddlewareNamespace is the prometheus namespace of the metrics
+	// of the storage metrics middleware
+	StorageMiddlewareNamespace = metrics.NewNamespace(NamespacePrefix, "storage_middleware", nil)
+
+	// ArchiveNamespace is the prometheus namespace of manifest archival related metrics
+	ArchiveNamespace = metrics.NewNamespace(NamespacePrefix, "archive", nil)
+
+	// ReplicationNamespace is the prometheus namespace of manifest replication related metrics
+	ReplicationNamespace = metrics.NewNamespace(NamespacePrefix, "replication", nil)
+
+	// ClientNamespace is the prometheus namespace of the metrics of client
+	// behavior, such as aborted requests
+	ClientNamespace = metrics.NewNamespace(NamespacePrefix, "client", nil)
+
+	// CloudFrontNamespace is the prometheus namespace of the metrics of the
+	// CloudFront storage middleware
+	CloudFrontNamespace = metrics.NewNamespace(NamespacePrefix, "cloudfront", nil)
+
+	// TLSNamespace is the prometheus namespace of TLS certificate provisioning related metrics
+	TLSNamespace = metrics.NewNamespace(NamespacePrefix, "tls", nil)
 )
@@ -22,7 +22,10 @@ type bridge struct {
 	sink              events.Sink
 }
 
-var _ Listener = &bridge{}
+var (
+	_ Listener               = &bridge{}
+	_ BlobQuarantineListener = &bridge{}
+)
 
 // URLBuilder defines a subset of url builder to be used by the event listener.
 type URLBuilder interface {
@@ -112,6 +115,16 @@ func (b *bridge) BlobDeleted(repo reference.Named, dgst digest.Digest) error {
 	return b.createBlobDeleteEventAndWrite(EventActionDelete, repo, dgst)
 }
 
+func (b *bridge) BlobQuarantined(repo reference.Named, desc v1.Descriptor) error {
+	// the blob is gone, so the event carries no url
+	event := b.createEvent(EventActionQuarantine)
+	event.Target.Descriptor = desc
+	event.Target.Length = desc.Size
+	event.Target.Repository = repo.Name()
+
+	return b.sink.Write(*event)
+}
+
 func (b *bridge) TagDeleted(repo reference.Named, tag string) error {
 	event := b.createEvent(EventActionDelete)
 	event.Target.Repository = repo.Name()
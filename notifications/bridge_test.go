@@ -114,6 +114,25 @@ func TestEventBridgeManifestDeleted(t *testing.T) {
 	}
 }
 
+func TestEventBridgeBlobQuarantined(t *testing.T) {
+	desc := v1.Descriptor{Digest: dgst, Size: 1234, MediaType: "application/octet-stream"}
+	l := createTestEnv(t, testSinkFn(func(event events.Event) error {
+		checkDeleted(t, EventActionQuarantine, event)
+		if event.(Event).Action != EventActionQuarantine {
+			t.Fatalf("unexpected event action: %q != %q", event.(Event).Action, EventActionQuarantine)
+		}
+		if event.(Event).Target.Digest != dgst || event.(Event).Target.Length != desc.Size {
+			t.Fatalf("unexpected event target: %#v", event.(Event).Target)
+		}
+		return nil
+	}))
+
+	repoRef, _ := reference.WithName(repo)
+	if err := l.(BlobQuarantineListener).BlobQuarantined(repoRef, desc); err != nil {
+		t.Fatalf("unexpected error notifying blob quarantine: %v", err)
+	}
+}
+
 func TestEventBridgeTagDeleted(t *testing.T) {
 	l := createTestEnv(t, testSinkFn(func(event events.Event) error {
 		checkDeleted(t, EventActionDelete, event)
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/health"
 	events "github.com/docker/go-events"
 )
 
@@ -19,6 +20,11 @@ type EndpointConfig struct {
 	IgnoredMediaTypes []string
 	Transport         *http.Transport `json:"-"`
 	Ignore            configuration.Ignore
+	Signing           configuration.Signing
+
+	// Health, if set, reports the progress of the delivery of the queued
+	// events.
+	Health *health.Component `json:"-"`
 }
 
 // defaults set any zero-valued fields to a reasonable default.
@@ -53,8 +59,15 @@ type Endpoint struct {
 	metrics *safeMetrics
 }
 
-// NewEndpoint returns a running endpoint, ready to receive events.
-func NewEndpoint(name, url string, config EndpointConfig) *Endpoint {
+// NewEndpoint returns a running endpoint, ready to receive events. It
+// returns an error if the signing configuration is invalid, rather than
+// sending the events unsigned.
+func NewEndpoint(name, url string, config EndpointConfig) (*Endpoint, error) {
+	signer, err := newSigner(config.Signing)
+	if err != nil {
+		return nil, err
+	}
+
 	var endpoint Endpoint
 	endpoint.name = name
 	endpoint.url = url
@@ -63,16 +76,18 @@ func NewEndpoint(name, url string, config EndpointConfig) *Endpoint {
 	endpoint.metrics = newSafeMetrics(name)
 
 	// Configures the inmemory queue, retry, http pipeline.
-	endpoint.Sink = newHTTPSink(
+	sink := newHTTPSink(
 		endpoint.url, endpoint.Timeout, endpoint.Headers,
 		endpoint.Transport, endpoint.metrics.httpStatusListener())
+	sink.signer = signer
+	endpoint.Sink = sink
 	endpoint.Sink = events.NewRetryingSink(endpoint.Sink, events.NewBreaker(endpoint.Threshold, endpoint.Backoff))
-	endpoint.Sink = newEventQueue(endpoint.Sink, endpoint.metrics.eventQueueListener())
+	endpoint.Sink = newEventQueue(endpoint.Sink, endpoint.metrics.eventQueueListener(), newHealthListener(config.Health))
 	mediaTypes := append(config.Ignore.MediaTypes, config.IgnoredMediaTypes...)
 	endpoint.Sink = newIgnoredSink(endpoint.Sink, mediaTypes, config.Ignore.Actions)
 
 	register(&endpoint)
-	return &endpoint
+	return &endpoint, nil
 }
 
 // Name returns the name of the endpoint, generally used for debugging.
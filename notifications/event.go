@@ -14,6 +14,10 @@ const (
 	EventActionPush   = "push"
 	EventActionMount  = "mount"
 	EventActionDelete = "delete"
+
+	// EventActionQuarantine is sent when a blob pushed with a pre-verified
+	// digest is found not to match it, and has been removed.
+	EventActionQuarantine = "quarantine"
 )
 
 const (
@@ -127,6 +131,10 @@ type RequestRecord struct {
 
 	// UserAgent contains the user agent header of the request.
 	UserAgent string `json:"useragent"`
+
+	// Repository is the repository name requested, when it was rewritten
+	// to the name of the target repository by a namespace rewrite.
+	Repository string `json:"repository,omitempty"`
 }
 
 // SourceRecord identifies the registry node that generated the event. Put
@@ -15,7 +15,11 @@ import (
 // very lightweight in that it only makes an attempt at an http request.
 // Reliability should be provided by the caller.
 type httpSink struct {
-	url string
+	url    string
+	signer *signer
+	// templates is nil unless the url or headers hold placeholders resolved
+	// per event.
+	templates *endpointTemplates
 
 	mu        sync.Mutex
 	closed    bool
@@ -32,8 +36,10 @@ func newHTTPSink(u string, timeout time.Duration, headers http.Header, transport
 	if transport == nil {
 		transport = http.DefaultTransport.(*http.Transport)
 	}
+	templates, headers := newEndpointTemplates(u, headers)
 	return &httpSink{
 		url:       u,
+		templates: templates,
 		listeners: listeners,
 		client: &http.Client{
 			Transport: &headerRoundTripper{
@@ -80,8 +86,22 @@ func (hs *httpSink) Write(event events.Event) error {
 		return fmt.Errorf("%v: error marshaling event envelope: %v", hs, err)
 	}
 
-	body := bytes.NewReader(p)
-	resp, err := hs.client.Post(hs.url, EventsMediaType, body)
+	// each request carries a single event, so that the placeholders of the
+	// url and headers are resolved per event
+	req, err := http.NewRequest(http.MethodPost, hs.templates.resolveURL(event, hs.url), bytes.NewReader(p))
+	if err != nil {
+		for _, listener := range hs.listeners {
+			listener.err(err, event)
+		}
+		return fmt.Errorf("%v: error creating request: %v", hs, err)
+	}
+	req.Header.Set("Content-Type", EventsMediaType)
+	hs.templates.setHeaders(req.Header, event)
+	if hs.signer != nil {
+		hs.signer.signRequest(req, p)
+	}
+
+	resp, err := hs.client.Do(req)
 	if err != nil {
 		for _, listener := range hs.listeners {
 			listener.err(err, event)
@@ -28,6 +28,13 @@ type BlobListener interface {
 	BlobDeleted(repo reference.Named, desc digest.Digest) error
 }
 
+// BlobQuarantineListener is implemented by the listeners which respond to the
+// quarantine of blobs found not to match their pre-verified digest. It is
+// optional, so that existing BlobListener implementations are unaffected.
+type BlobQuarantineListener interface {
+	BlobQuarantined(repo reference.Named, desc v1.Descriptor) error
+}
+
 // RepoListener provides repository methods that respond to repository lifecycle
 type RepoListener interface {
 	TagDeleted(repo reference.Named, tag string) error
@@ -119,6 +126,12 @@ func (msl *manifestServiceListener) Put(ctx context.Context, sm distribution.Man
 	dgst, err := msl.ManifestService.Put(ctx, sm, options...)
 
 	if err == nil {
+		// the manifest may be stored with corrections, under another digest
+		if _, payload, perr := sm.Payload(); perr == nil && digest.FromBytes(payload) != dgst {
+			if stored, gerr := msl.ManifestService.Get(ctx, dgst); gerr == nil {
+				sm = stored
+			}
+		}
 		if err := msl.parent.listener.ManifestPushed(msl.parent.Repository.Named(), sm, options...); err != nil {
 			dcontext.GetLogger(ctx).Errorf("error dispatching manifest push to listener: %v", err)
 		}
@@ -127,6 +140,17 @@ func (msl *manifestServiceListener) Put(ctx context.Context, sm distribution.Man
 	return dgst, err
 }
 
+// Describe describes the manifest dgst, if supported by the manifest service.
+// Manifests described are not notified as pulled, as their payload may not
+// have been read.
+func (msl *manifestServiceListener) Describe(ctx context.Context, dgst digest.Digest) (v1.Descriptor, error) {
+	describer, ok := msl.ManifestService.(distribution.ManifestDescriber)
+	if !ok {
+		return v1.Descriptor{}, distribution.ErrUnsupported
+	}
+	return describer.Describe(ctx, dgst)
+}
+
 type blobServiceListener struct {
 	distribution.BlobStore
 	parent *repositoryListener
@@ -243,6 +267,15 @@ func (bwl *blobWriterListener) Commit(ctx context.Context, desc v1.Descriptor) (
 	return committed, err
 }
 
+// Abandon marks the underlying blob writer as abandoned, if it supports it.
+func (bwl *blobWriterListener) Abandon(ctx context.Context) error {
+	abandoner, ok := bwl.BlobWriter.(distribution.BlobWriteAbandoner)
+	if !ok {
+		return distribution.ErrUnsupported
+	}
+	return abandoner.Abandon(ctx)
+}
+
 type tagServiceListener struct {
 	distribution.TagService
 	parent *repositoryListener
@@ -255,6 +288,42 @@ func (rl *repositoryListener) Tags(ctx context.Context) distribution.TagService
 	}
 }
 
+func (tagSL *tagServiceListener) ListFiltered(ctx context.Context, opts distribution.TagListOptions, limit int, last string) ([]distribution.TagInfo, error) {
+	lister, ok := tagSL.TagService.(distribution.TagFilterLister)
+	if !ok {
+		return nil, distribution.ErrUnsupported
+	}
+	return lister.ListFiltered(ctx, opts, limit, last)
+}
+
+// TagBatch applies the tag operations and, once they are all applied, emits
+// a manifest push event per tag.
+func (tagSL *tagServiceListener) TagBatch(ctx context.Context, ops []distribution.TagOperation) error {
+	batcher, ok := tagSL.TagService.(distribution.TagBatcher)
+	if !ok {
+		return distribution.ErrUnsupported
+	}
+	if err := batcher.TagBatch(ctx, ops); err != nil {
+		return err
+	}
+
+	manifests, err := tagSL.parent.Repository.Manifests(ctx)
+	if err != nil {
+		dcontext.GetLogger(ctx).Errorf("error dispatching tag batch to listener: %v", err)
+		return nil
+	}
+	for _, op := range ops {
+		sm, err := manifests.Get(ctx, op.Desc.Digest)
+		if err == nil {
+			err = tagSL.parent.listener.ManifestPushed(tagSL.parent.Repository.Named(), sm, distribution.WithTag(op.Tag))
+		}
+		if err != nil {
+			dcontext.GetLogger(ctx).Errorf("error dispatching manifest push of tag %s to listener: %v", op.Tag, err)
+		}
+	}
+	return nil
+}
+
 func (tagSL *tagServiceListener) Untag(ctx context.Context, tag string) error {
 	if err := tagSL.TagService.Untag(ctx, tag); err != nil {
 		return err
@@ -0,0 +1,81 @@
+package notifications
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net/http"
+
+	"github.com/distribution/distribution/v3/configuration"
+)
+
+// DefaultSignatureHeader is the header holding the signature of notification
+// requests, unless configured otherwise.
+const DefaultSignatureHeader = "X-Registry-Signature"
+
+// signatureAlgorithms maps the supported algorithms to their hash function.
+var signatureAlgorithms = map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"sha384": sha512.New384,
+	"sha512": sha512.New,
+}
+
+// signer signs the body of notification requests with an HMAC. The signature
+// is set as "<algorithm>=<hex encoded hmac>".
+type signer struct {
+	header    string
+	algorithm string
+	hash      func() hash.Hash
+	secret    []byte
+}
+
+// ValidateSigning returns an error if the signing configuration of an
+// endpoint is invalid.
+func ValidateSigning(config configuration.Signing) error {
+	_, err := newSigner(config)
+	return err
+}
+
+// newSigner returns the signer configured by config, or nil if requests are
+// not to be signed.
+func newSigner(config configuration.Signing) (*signer, error) {
+	if config.Secret == "" {
+		return nil, nil
+	}
+
+	algorithm := config.Algorithm
+	if algorithm == "" {
+		algorithm = "sha256"
+	}
+	h, ok := signatureAlgorithms[algorithm]
+	if !ok {
+		return nil, fmt.Errorf("unsupported signature algorithm %q", config.Algorithm)
+	}
+
+	header := config.Header
+	if header == "" {
+		header = DefaultSignatureHeader
+	}
+
+	return &signer{
+		header:    header,
+		algorithm: algorithm,
+		hash:      h,
+		secret:    []byte(config.Secret),
+	}, nil
+}
+
+// sign returns the signature of body.
+func (s *signer) sign(body []byte) string {
+	mac := hmac.New(s.hash, s.secret)
+	mac.Write(body)
+	return s.algorithm + "=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// signRequest sets the signature of body on req.
+func (s *signer) signRequest(req *http.Request, body []byte) {
+	req.Header.Set(s.header, s.sign(body))
+}
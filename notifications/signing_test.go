@@ -0,0 +1,73 @@
+package notifications
+
+import (
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/distribution/distribution/v3/configuration"
+)
+
+func TestSigner(t *testing.T) {
+	body := []byte("The quick brown fox jumps over the lazy dog")
+
+	s, err := newSigner(configuration.Signing{Secret: "key"})
+	if err != nil {
+		t.Fatalf("unexpected error creating signer: %v", err)
+	}
+	if s.header != DefaultSignatureHeader {
+		t.Fatalf("unexpected signature header: %q", s.header)
+	}
+	expected := "sha256=f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8"
+	if signature := s.sign(body); signature != expected {
+		t.Fatalf("unexpected signature: %q != %q", signature, expected)
+	}
+
+	if s, err := newSigner(configuration.Signing{}); err != nil || s != nil {
+		t.Fatalf("expected no signer without a secret, got %v, %v", s, err)
+	}
+	if err := ValidateSigning(configuration.Signing{Secret: "key", Algorithm: "md5"}); err == nil {
+		t.Fatal("expected unsupported algorithm to be rejected")
+	}
+}
+
+func TestNewEndpointInvalidSigning(t *testing.T) {
+	if _, err := NewEndpoint("invalid", "https://example.com/events", EndpointConfig{Signing: configuration.Signing{Secret: "key", Algorithm: "md5"}}); err == nil {
+		t.Fatal("expected an endpoint with an unsupported signature algorithm to be rejected")
+	}
+}
+
+func TestHTTPSinkSignature(t *testing.T) {
+	const secret = "s3cr3t"
+
+	signatures := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("error reading request body: %v", err)
+		}
+
+		mac := hmac.New(sha512.New, []byte(secret))
+		mac.Write(body)
+		expected := "sha512=" + hex.EncodeToString(mac.Sum(nil))
+		if signature := r.Header.Get("X-Hub-Signature"); signature != expected {
+			t.Errorf("unexpected signature: %q != %q", signature, expected)
+		}
+		signatures <- r.Header.Get("X-Hub-Signature")
+	}))
+	defer server.Close()
+
+	sink := newHTTPSink(server.URL, 0, nil, nil)
+	sink.signer, _ = newSigner(configuration.Signing{Secret: secret, Header: "X-Hub-Signature", Algorithm: "sha512"})
+	if err := sink.Write(createTestEvent("push", "library/test", "application/json")); err != nil {
+		t.Fatalf("unexpected error writing event: %v", err)
+	}
+	if signature := <-signatures; signature == "" {
+		t.Fatal("expected request to be signed")
+	}
+}
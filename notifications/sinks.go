@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"sync"
 
+	"github.com/distribution/distribution/v3/health"
 	events "github.com/docker/go-events"
 	"github.com/sirupsen/logrus"
 )
@@ -119,6 +120,44 @@ func (eq *eventQueue) next() events.Event {
 	return block
 }
 
+// healthListener reports the progress of an event queue to a health
+// component. The queue is idle while empty, and otherwise must deliver an
+// event at least every threshold of the component, whether or not the
+// delivery succeeds.
+type healthListener struct {
+	component *health.Component
+
+	mu      sync.Mutex
+	pending int
+}
+
+func newHealthListener(component *health.Component) *healthListener {
+	component.Idle()
+	return &healthListener{component: component}
+}
+
+func (hl *healthListener) ingress(events.Event) {
+	hl.mu.Lock()
+	defer hl.mu.Unlock()
+
+	if hl.pending == 0 {
+		hl.component.Alive()
+	}
+	hl.pending++
+}
+
+func (hl *healthListener) egress(events.Event) {
+	hl.mu.Lock()
+	defer hl.mu.Unlock()
+
+	hl.pending--
+	if hl.pending == 0 {
+		hl.component.Idle()
+	} else {
+		hl.component.Alive()
+	}
+}
+
 // ignoredSink discards events with ignored target media types and actions.
 // passes the rest along.
 type ignoredSink struct {
@@ -1,11 +1,13 @@
 package notifications
 
 import (
+	"context"
 	"reflect"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/distribution/distribution/v3/health"
 	events "github.com/docker/go-events"
 
 	"github.com/sirupsen/logrus"
@@ -63,6 +65,50 @@ func TestEventQueue(t *testing.T) {
 	}
 }
 
+// blockingSink blocks writes until unblocked.
+type blockingSink struct {
+	testSink
+	unblock chan struct{}
+}
+
+func (bs *blockingSink) Write(event events.Event) error {
+	<-bs.unblock
+	return bs.testSink.Write(event)
+}
+
+func TestEventQueueHealth(t *testing.T) {
+	const threshold = 50 * time.Millisecond
+	component := health.NewComponent(threshold)
+	sink := &blockingSink{unblock: make(chan struct{})}
+	eq := newEventQueue(sink, newHealthListener(component))
+
+	// an empty queue is healthy regardless of the threshold
+	time.Sleep(2 * threshold)
+	if err := component.Check(context.Background()); err != nil {
+		t.Fatalf("idle queue is unhealthy: %v", err)
+	}
+
+	// a queue wedged on a write is unhealthy after the threshold
+	if err := eq.Write(createTestEvent("push", "library/test", "blob")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(threshold / 2)
+	if err := component.Check(context.Background()); err != nil {
+		t.Fatalf("queue is unhealthy before the threshold: %v", err)
+	}
+	time.Sleep(threshold)
+	if err := component.Check(context.Background()); err == nil {
+		t.Fatal("wedged queue is healthy")
+	}
+
+	// and healthy again once the write goes through
+	close(sink.unblock)
+	checkClose(t, eq)
+	if err := component.Check(context.Background()); err != nil {
+		t.Fatalf("drained queue is unhealthy: %v", err)
+	}
+}
+
 func TestIgnoredSink(t *testing.T) {
 	blob := createTestEvent("push", "library/test", "blob")
 	manifest := createTestEvent("pull", "library/test", "manifest")
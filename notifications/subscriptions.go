@@ -0,0 +1,282 @@
+package notifications
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/distribution/distribution/v3/configuration"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	events "github.com/docker/go-events"
+	"github.com/sirupsen/logrus"
+)
+
+// subscriptionsRoot is the directory subscriptions are stored in, relative
+// to the root of the storage driver.
+const subscriptionsRoot = "/docker/registry/v2/subscriptions"
+
+// Subscription is a webhook subscription of a repository, managed through
+// the API. It receives the events of the repository and of the repositories
+// under it.
+type Subscription struct {
+	// Name identifies the subscription among those of the repository.
+	Name string `json:"name"`
+	// URL is the url events are posted to. It may hold the placeholders of
+	// endpoint urls.
+	URL string `json:"url"`
+	// Secret signs the requests with an HMAC of their body, set in the
+	// X-Registry-Signature header, if not empty.
+	Secret string `json:"secret,omitempty"`
+	// Actions and MediaTypes restrict the events delivered to those of the
+	// listed actions and target media types. Empty lists deliver any.
+	Actions    []string `json:"actions,omitempty"`
+	MediaTypes []string `json:"mediatypes,omitempty"`
+}
+
+// Validate returns an error if the subscription is invalid.
+func (s Subscription) Validate() error {
+	if s.Name == "" {
+		return errors.New("subscription name is required")
+	}
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		return fmt.Errorf("subscription %s: invalid url: %v", s.Name, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("subscription %s: url must be an absolute http or https url", s.Name)
+	}
+	if err := ValidateTemplates(s.URL, nil); err != nil {
+		return fmt.Errorf("subscription %s: %v", s.Name, err)
+	}
+	return nil
+}
+
+// matches returns true if event is delivered to the subscription.
+func (s Subscription) matches(event Event) bool {
+	if len(s.Actions) > 0 && !slices.Contains(s.Actions, event.Action) {
+		return false
+	}
+	if len(s.MediaTypes) > 0 && !slices.Contains(s.MediaTypes, event.Target.MediaType) {
+		return false
+	}
+	return true
+}
+
+// SubscriptionStore keeps the subscriptions of repositories.
+type SubscriptionStore interface {
+	// Subscriptions returns the subscriptions of the repository name.
+	Subscriptions(ctx context.Context, name string) ([]Subscription, error)
+
+	// SetSubscriptions replaces the subscriptions of the repository name,
+	// removing them if subscriptions is empty.
+	SetSubscriptions(ctx context.Context, name string, subscriptions []Subscription) error
+}
+
+// driverSubscriptionStore keeps the subscriptions of a repository in a file
+// of the storage driver, so that they are shared by the registries using
+// the same storage backend.
+type driverSubscriptionStore struct {
+	driver storagedriver.StorageDriver
+}
+
+// NewDriverSubscriptionStore returns a store keeping subscriptions with
+// driver.
+func NewDriverSubscriptionStore(driver storagedriver.StorageDriver) SubscriptionStore {
+	return &driverSubscriptionStore{driver: driver}
+}
+
+func (s *driverSubscriptionStore) Subscriptions(ctx context.Context, name string) ([]Subscription, error) {
+	content, err := s.driver.GetContent(ctx, subscriptionsPath(name))
+	if err != nil {
+		if errors.As(err, &storagedriver.PathNotFoundError{}) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var subscriptions []Subscription
+	if err := json.Unmarshal(content, &subscriptions); err != nil {
+		return nil, fmt.Errorf("invalid subscriptions of %s: %v", name, err)
+	}
+	return subscriptions, nil
+}
+
+func (s *driverSubscriptionStore) SetSubscriptions(ctx context.Context, name string, subscriptions []Subscription) error {
+	if len(subscriptions) == 0 {
+		err := s.driver.Delete(ctx, subscriptionsPath(name))
+		if errors.As(err, &storagedriver.PathNotFoundError{}) {
+			return nil
+		}
+		return err
+	}
+	content, err := json.Marshal(subscriptions)
+	if err != nil {
+		return err
+	}
+	return s.driver.PutContent(ctx, subscriptionsPath(name), content)
+}
+
+// subscriptionsPath returns the path of the subscriptions of the repository
+// name. Path components of repository names cannot start with an
+// underscore, so the file cannot clash with the subscriptions of another
+// repository.
+func subscriptionsPath(name string) string {
+	return path.Join(subscriptionsRoot, name, "_subscriptions.json")
+}
+
+// subscriptionSink delivers events to the subscriptions of their repository
+// and of the repositories above it. Each subscription is delivered to by its
+// own queue, retrying as endpoints do, so that a failing subscription does
+// not hold back the others.
+type subscriptionSink struct {
+	store  SubscriptionStore
+	config EndpointConfig
+
+	mu     sync.Mutex
+	closed bool
+	// endpoints are the delivery pipelines of the subscriptions, by
+	// repository and subscription name.
+	endpoints map[string]map[string]*subscriptionEndpoint
+}
+
+// subscriptionEndpoint is the delivery pipeline of a subscription.
+type subscriptionEndpoint struct {
+	subscription Subscription
+	events.Sink
+	retrying *events.RetryingSink
+}
+
+// Close stops retrying the delivery of the queued events, which are dropped,
+// and closes the pipeline.
+func (e *subscriptionEndpoint) Close() error {
+	if err := e.retrying.Close(); err != nil {
+		return err
+	}
+	return e.Sink.Close()
+}
+
+// NewSubscriptionSink returns a sink delivering events to the subscriptions
+// kept in store, as configured by config. The subscriptions are looked up
+// as events are delivered, so that changes are picked up without restarting
+// the registry.
+func NewSubscriptionSink(store SubscriptionStore, config EndpointConfig) events.Sink {
+	config.defaults()
+	return newEventQueue(&subscriptionSink{
+		store:     store,
+		config:    config,
+		endpoints: make(map[string]map[string]*subscriptionEndpoint),
+	})
+}
+
+// Write queues event for the subscriptions it matches.
+func (ss *subscriptionSink) Write(event events.Event) error {
+	ev, ok := event.(Event)
+	if !ok || ev.Target.Repository == "" {
+		return nil
+	}
+
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if ss.closed {
+		return ErrSinkClosed
+	}
+
+	for _, name := range repositoryAncestors(ev.Target.Repository) {
+		subscriptions, err := ss.store.Subscriptions(context.Background(), name)
+		if err != nil {
+			logrus.Errorf("subscriptions: error looking up the subscriptions of %s: %v", name, err)
+			continue
+		}
+		for _, endpoint := range ss.refresh(name, subscriptions) {
+			if endpoint.subscription.matches(ev) {
+				if err := endpoint.Write(ev); err != nil {
+					logrus.Errorf("subscriptions: error queuing event for %s of %s: %v", endpoint.subscription.Name, name, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// refresh replaces the endpoints of the subscriptions of name which were
+// removed or changed, and returns the endpoints of subscriptions.
+func (ss *subscriptionSink) refresh(name string, subscriptions []Subscription) []*subscriptionEndpoint {
+	current := ss.endpoints[name]
+	next := make(map[string]*subscriptionEndpoint, len(subscriptions))
+	endpoints := make([]*subscriptionEndpoint, 0, len(subscriptions))
+	for _, subscription := range subscriptions {
+		endpoint, ok := current[subscription.Name]
+		if !ok || !equalSubscriptions(endpoint.subscription, subscription) {
+			endpoint = ss.newEndpoint(subscription)
+		}
+		next[subscription.Name] = endpoint
+		endpoints = append(endpoints, endpoint)
+	}
+	for subscriptionName, endpoint := range current {
+		if next[subscriptionName] != endpoint {
+			// closing waits for the request in flight, if any
+			go endpoint.Close()
+		}
+	}
+
+	if len(next) == 0 {
+		delete(ss.endpoints, name)
+	} else {
+		ss.endpoints[name] = next
+	}
+	return endpoints
+}
+
+// newEndpoint returns the delivery pipeline of subscription.
+func (ss *subscriptionSink) newEndpoint(subscription Subscription) *subscriptionEndpoint {
+	sink := newHTTPSink(subscription.URL, ss.config.Timeout, ss.config.Headers, ss.config.Transport)
+	// the algorithm and header are the defaults, which cannot fail
+	sink.signer, _ = newSigner(configuration.Signing{Secret: subscription.Secret})
+	retrying := events.NewRetryingSink(sink, events.NewBreaker(ss.config.Threshold, ss.config.Backoff))
+	return &subscriptionEndpoint{
+		subscription: subscription,
+		Sink:         newEventQueue(retrying),
+		retrying:     retrying,
+	}
+}
+
+// Close closes the delivery pipelines of the subscriptions, dropping the
+// events they have not delivered yet.
+func (ss *subscriptionSink) Close() error {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if ss.closed {
+		return nil
+	}
+	ss.closed = true
+
+	var errs []error
+	for _, endpoints := range ss.endpoints {
+		for _, endpoint := range endpoints {
+			if err := endpoint.Close(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func equalSubscriptions(a, b Subscription) bool {
+	return a.URL == b.URL && a.Secret == b.Secret && slices.Equal(a.Actions, b.Actions) && slices.Equal(a.MediaTypes, b.MediaTypes)
+}
+
+// repositoryAncestors returns name and the names above it, from the
+// longest.
+func repositoryAncestors(name string) []string {
+	names := []string{name}
+	for i := strings.LastIndexByte(name, '/'); i > 0; i = strings.LastIndexByte(name, '/') {
+		name = name[:i]
+		names = append(names, name)
+	}
+	return names
+}
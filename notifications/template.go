@@ -0,0 +1,154 @@
+package notifications
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	events "github.com/docker/go-events"
+)
+
+// templateFields maps the placeholders of endpoint templates to the field of
+// the event they are resolved to.
+var templateFields = map[string]func(event Event) string{
+	"repository": func(event Event) string { return event.Target.Repository },
+	"action":     func(event Event) string { return event.Action },
+	"digest":     func(event Event) string { return event.Target.Digest.String() },
+	"tag":        func(event Event) string { return event.Target.Tag },
+}
+
+// template is a string with placeholders, such as {repository}, resolved to
+// the fields of each event. Fields missing from an event resolve to the empty
+// string.
+type template struct {
+	// literals are the parts of the string around the placeholders, one more
+	// than the fields.
+	literals []string
+	fields   []func(event Event) string
+}
+
+// ValidateTemplates returns an error if the url or the headers of an endpoint
+// hold invalid placeholders.
+func ValidateTemplates(u string, headers http.Header) error {
+	if _, err := parseTemplate(u); err != nil {
+		return fmt.Errorf("url: %v", err)
+	}
+	for name, values := range headers {
+		for _, value := range values {
+			if _, err := parseTemplate(value); err != nil {
+				return fmt.Errorf("header %s: %v", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// parseTemplate parses the placeholders of s.
+func parseTemplate(s string) (*template, error) {
+	t := &template{}
+	rest := s
+	for {
+		start := strings.IndexByte(rest, '{')
+		if start < 0 {
+			t.literals = append(t.literals, rest)
+			return t, nil
+		}
+		end := strings.IndexByte(rest[start:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated placeholder in %q", s)
+		}
+		name := rest[start+1 : start+end]
+		field, ok := templateFields[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown placeholder {%s} in %q", name, s)
+		}
+		t.literals = append(t.literals, rest[:start])
+		t.fields = append(t.fields, field)
+		rest = rest[start+end+1:]
+	}
+}
+
+// static reports whether the template holds no placeholders.
+func (t *template) static() bool {
+	return len(t.fields) == 0
+}
+
+// resolve resolves the placeholders of the template to the fields of event,
+// escaped with escape.
+func (t *template) resolve(event events.Event, escape func(string) string) string {
+	e, _ := event.(Event)
+	var b strings.Builder
+	for i, field := range t.fields {
+		b.WriteString(t.literals[i])
+		b.WriteString(escape(field(e)))
+	}
+	b.WriteString(t.literals[len(t.literals)-1])
+	return b.String()
+}
+
+// endpointTemplates are the templates of the url and of the headers of an
+// endpoint holding placeholders.
+type endpointTemplates struct {
+	url     *template
+	headers map[string][]*template
+}
+
+// newEndpointTemplates returns the templates of u and headers, or nil if they
+// hold no placeholders, and the headers holding none. Invalid templates,
+// rejected by ValidateTemplates, are used as is.
+func newEndpointTemplates(u string, headers http.Header) (*endpointTemplates, http.Header) {
+	templates := &endpointTemplates{headers: make(map[string][]*template)}
+	templated := false
+	if t, err := parseTemplate(u); err == nil && !t.static() {
+		templates.url = t
+		templated = true
+	}
+
+	static := make(http.Header, len(headers))
+	for name, values := range headers {
+		var parsed []*template
+		dynamic := false
+		for _, value := range values {
+			t, err := parseTemplate(value)
+			if err != nil {
+				t = &template{literals: []string{value}}
+			}
+			parsed = append(parsed, t)
+			dynamic = dynamic || !t.static()
+		}
+		if !dynamic {
+			static[name] = values
+			continue
+		}
+		// a header is templated as a whole, so that the order of its values
+		// is kept
+		templates.headers[name] = parsed
+		templated = true
+	}
+	if !templated {
+		return nil, headers
+	}
+	return templates, static
+}
+
+// resolveURL returns the url of the endpoint for event, with the fields
+// escaped, or fallback if the url holds no placeholders.
+func (et *endpointTemplates) resolveURL(event events.Event, fallback string) string {
+	if et == nil || et.url == nil {
+		return fallback
+	}
+	return et.url.resolve(event, url.PathEscape)
+}
+
+// setHeaders sets the templated headers of the endpoint for event.
+func (et *endpointTemplates) setHeaders(header http.Header, event events.Event) {
+	if et == nil {
+		return
+	}
+	for name, templates := range et.headers {
+		for _, t := range templates {
+			header.Add(name, t.resolve(event, func(s string) string { return s }))
+		}
+	}
+}
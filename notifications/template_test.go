@@ -0,0 +1,79 @@
+package notifications
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+)
+
+func TestHTTPSinkTemplates(t *testing.T) {
+	type request struct {
+		path    string
+		headers http.Header
+	}
+	requests := make(chan request, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+		requests <- request{path: r.URL.EscapedPath(), headers: r.Header}
+	}))
+	defer server.Close()
+
+	sink := newHTTPSink(server.URL+"/hooks/{repository}/{action}", 0, http.Header{
+		"X-Event":  []string{"{action}:{tag}"},
+		"X-Static": []string{"static"},
+	}, nil)
+
+	push := createTestEvent(EventActionPush, "library/test", "application/json")
+	push.Target.Tag = "latest"
+	deletion := createTestEvent(EventActionDelete, "library/test", "application/json")
+	deletion.Target.Digest = digest.FromString("manifest")
+
+	for _, tc := range []struct {
+		event Event
+		path  string
+		value string
+	}{
+		{event: push, path: "/hooks/library%2Ftest/push", value: "push:latest"},
+		// missing fields resolve to the empty string
+		{event: deletion, path: "/hooks/library%2Ftest/delete", value: "delete:"},
+	} {
+		if err := sink.Write(tc.event); err != nil {
+			t.Fatalf("unexpected error writing event: %v", err)
+		}
+		r := <-requests
+		if r.path != tc.path {
+			t.Errorf("unexpected path: %q != %q", r.path, tc.path)
+		}
+		if value := r.headers.Values("X-Event"); len(value) != 1 || value[0] != tc.value {
+			t.Errorf("unexpected templated header: %q != %q", value, tc.value)
+		}
+		if value := r.headers.Values("X-Static"); len(value) != 1 || value[0] != "static" {
+			t.Errorf("unexpected static header: %q", value)
+		}
+	}
+}
+
+func TestValidateTemplates(t *testing.T) {
+	for _, tc := range []struct {
+		url     string
+		headers http.Header
+		valid   bool
+	}{
+		{url: "https://example.com/hooks", valid: true},
+		{url: "https://example.com/hooks/{repository}/{action}/{digest}/{tag}", valid: true},
+		{url: "https://example.com/hooks", headers: http.Header{"X-Repository": []string{"{repository}"}}, valid: true},
+		{url: "https://example.com/hooks/{name}"},
+		{url: "https://example.com/hooks/{repository"},
+		{url: "https://example.com/hooks", headers: http.Header{"X-Repository": []string{"{repo}"}}},
+	} {
+		err := ValidateTemplates(tc.url, tc.headers)
+		if tc.valid && err != nil {
+			t.Errorf("%s %v: unexpected error: %v", tc.url, tc.headers, err)
+		}
+		if !tc.valid && err == nil {
+			t.Errorf("%s %v: expected an error", tc.url, tc.headers)
+		}
+	}
+}
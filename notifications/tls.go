@@ -0,0 +1,75 @@
+package notifications
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/distribution/distribution/v3/configuration"
+)
+
+const (
+	// TLSEnforcementWarn warns about the endpoints not using TLS.
+	TLSEnforcementWarn = "warn"
+
+	// TLSEnforcementStrict rejects the endpoints not using TLS.
+	TLSEnforcementStrict = "strict"
+)
+
+// ValidateTLSEnforcement returns an error if enforce is not a TLS
+// enforcement mode.
+func ValidateTLSEnforcement(enforce string) error {
+	switch enforce {
+	case "", TLSEnforcementWarn, TLSEnforcementStrict:
+		return nil
+	}
+	return fmt.Errorf("unsupported tls enforcement %q, must be %q or %q", enforce, TLSEnforcementWarn, TLSEnforcementStrict)
+}
+
+// UsesTLS reports whether the url of an endpoint is https. Urls are checked
+// before their placeholders are resolved, so a url whose scheme is a
+// placeholder is not deemed https.
+func UsesTLS(url string) bool {
+	return strings.HasPrefix(strings.ToLower(url), "https://")
+}
+
+// NewTLSTransport returns the transport of an endpoint configured by config,
+// or nil for the default transport if config is empty.
+func NewTLSTransport(config configuration.EndpointTLS) (*http.Transport, error) {
+	if config.Certificate == "" && config.Key == "" && len(config.RootCAs) == 0 {
+		return nil, nil
+	}
+
+	tlsConf := &tls.Config{MinVersion: tls.VersionTLS12}
+	if config.Certificate != "" || config.Key != "" {
+		if config.Certificate == "" || config.Key == "" {
+			return nil, errors.New("both tls certificate and key must be set for mutual TLS")
+		}
+		cert, err := tls.LoadX509KeyPair(config.Certificate, config.Key)
+		if err != nil {
+			return nil, err
+		}
+		tlsConf.Certificates = []tls.Certificate{cert}
+	}
+	if len(config.RootCAs) != 0 {
+		pool := x509.NewCertPool()
+		for _, ca := range config.RootCAs {
+			caPem, err := os.ReadFile(ca)
+			if err != nil {
+				return nil, err
+			}
+			if ok := pool.AppendCertsFromPEM(caPem); !ok {
+				return nil, fmt.Errorf("could not add CA %s to pool", ca)
+			}
+		}
+		tlsConf.RootCAs = pool
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConf
+	return transport, nil
+}
@@ -0,0 +1,161 @@
+package notifications
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+)
+
+// writeClientCertificate writes a self-signed client certificate and its key
+// to dir, and returns their paths along with the certificate.
+func writeClientCertificate(t *testing.T, dir string) (string, string, *x509.Certificate) {
+	t.Helper()
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "registry"},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, priv.Public(), priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPath := filepath.Join(dir, "client.crt")
+	keyPath := filepath.Join(dir, "client.key")
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certPath, keyPath, cert
+}
+
+func TestTLSEnforcement(t *testing.T) {
+	for _, enforce := range []string{"", TLSEnforcementWarn, TLSEnforcementStrict} {
+		if err := ValidateTLSEnforcement(enforce); err != nil {
+			t.Fatalf("unexpected error validating %q: %v", enforce, err)
+		}
+	}
+	if err := ValidateTLSEnforcement("always"); err == nil {
+		t.Fatal("expected unsupported tls enforcement to be rejected")
+	}
+
+	for url, expected := range map[string]bool{
+		"https://example.com/events":     true,
+		"HTTPS://example.com/events":     true,
+		"http://example.com/events":      false,
+		"{{.Scheme}}://example.com/hook": false,
+	} {
+		if UsesTLS(url) != expected {
+			t.Fatalf("unexpected UsesTLS(%q), expected %v", url, expected)
+		}
+	}
+}
+
+func TestNewTLSTransport(t *testing.T) {
+	if transport, err := NewTLSTransport(configuration.EndpointTLS{}); err != nil || transport != nil {
+		t.Fatalf("expected the default transport without tls configuration, got %v, %v", transport, err)
+	}
+
+	dir := t.TempDir()
+	certPath, keyPath, _ := writeClientCertificate(t, dir)
+	for _, config := range []configuration.EndpointTLS{
+		{Certificate: certPath},
+		{Key: keyPath},
+		{Certificate: certPath, Key: filepath.Join(dir, "missing.key")},
+		{RootCAs: []string{filepath.Join(dir, "missing.crt")}},
+		{RootCAs: []string{keyPath}},
+	} {
+		if _, err := NewTLSTransport(config); err == nil {
+			t.Fatalf("expected error for tls configuration %+v", config)
+		}
+	}
+}
+
+// TestHTTPSinkMutualTLS validates that events are delivered to an endpoint
+// requiring client certificates and served with a certificate of a pinned CA.
+func TestHTTPSinkMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath, clientCert := writeClientCertificate(t, dir)
+
+	delivered := make(chan string, 1)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		delivered <- r.TLS.PeerCertificates[0].Subject.CommonName
+	}))
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	server.StartTLS()
+	defer server.Close()
+
+	serverCAPath := filepath.Join(dir, "server.crt")
+	serverCA := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(serverCAPath, serverCA, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		config configuration.EndpointTLS
+		err    string
+	}{
+		{name: "unpinned", config: configuration.EndpointTLS{Certificate: certPath, Key: keyPath}, err: "certificate"},
+		{name: "no client certificate", config: configuration.EndpointTLS{RootCAs: []string{serverCAPath}}, err: "certificate"},
+		{name: "mutual", config: configuration.EndpointTLS{Certificate: certPath, Key: keyPath, RootCAs: []string{serverCAPath}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			transport, err := NewTLSTransport(tc.config)
+			if err != nil {
+				t.Fatalf("unexpected error creating transport: %v", err)
+			}
+			sink := newHTTPSink(server.URL, 5*time.Second, nil, transport)
+			defer sink.Close()
+
+			err = sink.Write(createTestEvent("push", "library/test", "application/json"))
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("expected %q error, got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error writing event: %v", err)
+			}
+			if subject := <-delivered; subject != "registry" {
+				t.Fatalf("unexpected client certificate subject %q", subject)
+			}
+		})
+	}
+}
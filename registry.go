@@ -4,6 +4,8 @@ import (
 	"context"
 
 	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
 // Scope defines the set of items that match a namespace.
@@ -59,6 +61,11 @@ type RepositoryRemover interface {
 	Remove(ctx context.Context, name reference.Named) error
 }
 
+// RepositoryChecker checks whether a given repository exists
+type RepositoryChecker interface {
+	RepositoryExists(ctx context.Context, name reference.Named) (bool, error)
+}
+
 // ManifestServiceOption is a function argument for Manifest Service methods
 type ManifestServiceOption interface {
 	Apply(ManifestService) error
@@ -113,6 +120,36 @@ type Repository interface {
 	Tags(ctx context.Context) TagService
 }
 
+// ArtifactTypeLister provides a method to list the artifact types of the
+// manifests pushed to a repository.
+type ArtifactTypeLister interface {
+	// ArtifactTypes returns the artifact types of the image manifests
+	// pushed to the repository, in lexical order. The artifact type of a
+	// manifest is its artifactType field if set, else its config media type.
+	// ErrUnsupported is returned if the artifact types are not recorded.
+	ArtifactTypes(ctx context.Context) ([]string, error)
+}
+
+// ReferrerChecker provides a method to check the referrers of the manifests
+// of a repository, the manifests referencing them as their subject.
+type ReferrerChecker interface {
+	// HasReferrer reports whether a manifest of the repository, of one of
+	// artifactTypes, references the manifest subject as its subject. The
+	// artifact type of a referrer is its artifactType field if set, else
+	// its config media type.
+	HasReferrer(ctx context.Context, subject digest.Digest, artifactTypes ...string) (bool, error)
+}
+
+// ReferrerLister provides a method to list the referrers of the manifests of
+// a repository.
+type ReferrerLister interface {
+	// Referrers returns the descriptors of the manifests of the repository
+	// referencing the manifest subject as their subject, of artifactType if
+	// not empty, ordered by digest. The descriptors carry the artifact type
+	// and the annotations of the referrers.
+	Referrers(ctx context.Context, subject digest.Digest, artifactType string) ([]v1.Descriptor, error)
+}
+
 // TODO(stevvooe): Must add close methods to all these. May want to change the
 // way instances are created to better reflect internal dependency
 // relationships.
@@ -73,6 +73,16 @@ var (
 		service too many times`,
 		HTTPStatusCode: http.StatusTooManyRequests,
 	})
+
+	// ErrorCodeInsufficientStorage is returned if the registry storage is
+	// running out of space to store the data sent by the client.
+	ErrorCodeInsufficientStorage = register("errcode", ErrorDescriptor{
+		Value:   "INSUFFICIENTSTORAGE",
+		Message: "insufficient storage",
+		Description: `Returned when the registry does not have enough free
+		storage space to accept the data sent by the client`,
+		HTTPStatusCode: http.StatusInsufficientStorage,
+	})
 )
 
 const errGroup = "registry.api.v2"
@@ -224,6 +234,117 @@ var (
 		the maximum allowed.`,
 		HTTPStatusCode: http.StatusBadRequest,
 	})
+
+	// ErrorCodeTagFilterInvalid is returned when a tag listing filter
+	// parameter is malformed.
+	ErrorCodeTagFilterInvalid = register(errGroup, ErrorDescriptor{
+		Value:   "TAG_FILTER_INVALID",
+		Message: "invalid tag filter",
+		Description: `Returned when the "modified_before" or
+		"modified_after" parameter of a tag listing is not an RFC 3339
+		timestamp, or the "detail" parameter is not a boolean.`,
+		HTTPStatusCode: http.StatusBadRequest,
+	})
+
+	// ErrorCodeCatalogDetailInvalid is returned when the detail parameter of
+	// a catalog listing is malformed.
+	ErrorCodeCatalogDetailInvalid = register(errGroup, ErrorDescriptor{
+		Value:   "CATALOG_DETAIL_INVALID",
+		Message: "invalid catalog detail",
+		Description: `Returned when the "detail" parameter of a catalog
+		listing is not a boolean.`,
+		HTTPStatusCode: http.StatusBadRequest,
+	})
+
+	// ErrorCodeTagOperationsInvalid is returned when the body of a tag
+	// operations request is malformed.
+	ErrorCodeTagOperationsInvalid = register(errGroup, ErrorDescriptor{
+		Value:   "TAG_OPERATIONS_INVALID",
+		Message: "invalid tag operations",
+		Description: `Returned when the body of a tag operations request
+		is not a list of tag and digest pairs, is empty or too long, or
+		updates a tag more than once.`,
+		HTTPStatusCode: http.StatusBadRequest,
+	})
+
+	// ErrorCodeRetagInvalid is returned when the body of a retag request is
+	// malformed.
+	ErrorCodeRetagInvalid = register(errGroup, ErrorDescriptor{
+		Value:   "RETAG_INVALID",
+		Message: "invalid retag operations",
+		Description: `Returned when the body of a retag request is not a
+		list of operations copying a manifest to a tag, is empty or too
+		long, or updates a tag more than once.`,
+		HTTPStatusCode: http.StatusBadRequest,
+	})
+
+	// ErrorCodeSubscriptionsInvalid is returned when the webhook
+	// subscriptions of a repository are malformed.
+	ErrorCodeSubscriptionsInvalid = register(errGroup, ErrorDescriptor{
+		Value:   "SUBSCRIPTIONS_INVALID",
+		Message: "invalid webhook subscriptions",
+		Description: `Returned when the webhook subscriptions of a
+		repository are not a list of subscriptions with a unique name and
+		an http or https url, or are more than the registry allows.`,
+		HTTPStatusCode: http.StatusBadRequest,
+	})
+
+	// ErrorCodeBlobRedirectUnavailable is returned when a blob may only be
+	// served through a redirect to the storage, which is not available.
+	ErrorCodeBlobRedirectUnavailable = register(errGroup, ErrorDescriptor{
+		Value:   "BLOB_REDIRECT_UNAVAILABLE",
+		Message: "blob redirect unavailable",
+		Description: `Returned when the registry only serves blobs through
+		redirects to the storage, and the storage does not provide a
+		redirect for the blob.`,
+		HTTPStatusCode: http.StatusNotImplemented,
+	})
+
+	// ErrorCodeChangesSequenceInvalid is returned when the sequence number a
+	// change feed is requested since is malformed.
+	ErrorCodeChangesSequenceInvalid = register(errGroup, ErrorDescriptor{
+		Value:   "CHANGES_SEQUENCE_INVALID",
+		Message: "invalid change sequence number",
+		Description: `Returned when the "since" parameter of a change feed
+		request is not a non-negative integer.`,
+		HTTPStatusCode: http.StatusBadRequest,
+	})
+
+	// ErrorCodeChangesCompacted is returned when the changes following the
+	// sequence number a change feed is requested since are no longer
+	// retained.
+	ErrorCodeChangesCompacted = register(errGroup, ErrorDescriptor{
+		Value:   "CHANGES_COMPACTED",
+		Message: "changes compacted",
+		Description: `Returned when changes following the "since" parameter
+		of a change feed request were compacted away. The client must
+		resynchronize from the full catalog, then follow the change feed
+		from the latest sequence number.`,
+		HTTPStatusCode: http.StatusGone,
+	})
+
+	// ErrorCodeTagProtected is returned when deleting a tag or manifest
+	// would break a tag protection rule.
+	ErrorCodeTagProtected = register(errGroup, ErrorDescriptor{
+		Value:   "TAG_PROTECTED",
+		Message: "tag protected",
+		Description: `Returned when a tag or manifest deletion would delete
+		a protected tag, or leave fewer tags matching a protection rule than
+		the rule requires. The detail names the rule.`,
+		HTTPStatusCode: http.StatusConflict,
+	})
+
+	// ErrorCodeManifestNotAcceptable is returned when a manifest exists, but
+	// in none of the media types the client accepts.
+	ErrorCodeManifestNotAcceptable = register(errGroup, ErrorDescriptor{
+		Value:   "MANIFEST_NOT_ACCEPTABLE",
+		Message: "manifest not acceptable",
+		Description: `Returned when the manifest, identified by name and
+		reference, is not available in any of the media types listed by the
+		Accept headers of the request. The detail lists the available media
+		types.`,
+		HTTPStatusCode: http.StatusNotAcceptable,
+	})
 )
 
 var (
@@ -122,6 +122,44 @@ var (
 		},
 	}
 
+	tagFilterParameters = []ParameterDescriptor{
+		{
+			Name:        "prefix",
+			Type:        "string",
+			Description: "Only return tags starting with prefix.",
+			Format:      "<prefix>",
+			Required:    false,
+		},
+		{
+			Name:        "filter",
+			Type:        "string",
+			Description: "Only return tags matching the glob pattern, where '*' matches any sequence of characters and '?' any single character.",
+			Format:      "<glob>",
+			Required:    false,
+		},
+		{
+			Name:        "modified_before",
+			Type:        "string",
+			Description: "Only return tags last modified before the given time.",
+			Format:      "<RFC 3339 timestamp>",
+			Required:    false,
+		},
+		{
+			Name:        "modified_after",
+			Type:        "string",
+			Description: "Only return tags last modified after the given time.",
+			Format:      "<RFC 3339 timestamp>",
+			Required:    false,
+		},
+		{
+			Name:        "detail",
+			Type:        "boolean",
+			Description: "Return the digest and modification time of each tag.",
+			Format:      "<boolean>",
+			Required:    false,
+		},
+	}
+
 	unauthorizedResponseDescriptor = ResponseDescriptor{
 		Name:        "Authentication Required",
 		StatusCode:  http.StatusUnauthorized,
@@ -157,6 +195,32 @@ var (
 		},
 	}
 
+	invalidTagFilterResponseDescriptor = ResponseDescriptor{
+		Name:        "Invalid tag filter",
+		Description: "A received filter parameter was invalid in some way, as described by the error code. The client should resolve the issue and retry the request.",
+		StatusCode:  http.StatusBadRequest,
+		Body: BodyDescriptor{
+			ContentType: "application/json",
+			Format:      errorsBody,
+		},
+		ErrorCodes: []errcode.ErrorCode{
+			errcode.ErrorCodeTagFilterInvalid,
+		},
+	}
+
+	invalidCatalogDetailResponseDescriptor = ResponseDescriptor{
+		Name:        "Invalid catalog detail",
+		Description: "The received detail parameter was not a boolean. The client should resolve the issue and retry the request.",
+		StatusCode:  http.StatusBadRequest,
+		Body: BodyDescriptor{
+			ContentType: "application/json",
+			Format:      errorsBody,
+		},
+		ErrorCodes: []errcode.ErrorCode{
+			errcode.ErrorCodeCatalogDetailInvalid,
+		},
+	}
+
 	repositoryNotFoundResponseDescriptor = ResponseDescriptor{
 		Name:        "No Such Repository Error",
 		StatusCode:  http.StatusNotFound,
@@ -518,6 +582,102 @@ var routeDescriptors = []RouteDescriptor{
 							tooManyRequestsDescriptor,
 						},
 					},
+					{
+						Name:            "Tags Filtered",
+						Description:     "Return the tags of the specified repository matching a prefix, a glob pattern or a modification time range, optionally with their digest and modification time. Filtered listings may be paginated, the `Link` header then preserves the filter parameters.",
+						PathParameters:  []ParameterDescriptor{nameParameterDescriptor},
+						QueryParameters: append(append([]ParameterDescriptor{}, paginationParameters...), tagFilterParameters...),
+						Successes: []ResponseDescriptor{
+							{
+								StatusCode:  http.StatusOK,
+								Description: "A list of the matching tags for the named repository. When `detail` is set, each tag is described by an object instead of its name.",
+								Headers: []ParameterDescriptor{
+									{
+										Name:        "Content-Length",
+										Type:        "integer",
+										Description: "Length of the JSON response body.",
+										Format:      "<length>",
+									},
+									linkHeader,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format: `{
+    "name": <name>,
+    "tags": [
+        {
+            "name": <tag>,
+            "digest": <digest>,
+            "modified": <RFC 3339 timestamp>
+        },
+        ...
+    ]
+}`,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							invalidPaginationResponseDescriptor,
+							invalidTagFilterResponseDescriptor,
+							unauthorizedResponseDescriptor,
+							repositoryNotFoundResponseDescriptor,
+							deniedResponseDescriptor,
+							tooManyRequestsDescriptor,
+						},
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:        RouteNameManifestDefault,
+		Path:        "/v2/{name:" + reference.NameRegexp.String() + "}/manifests/",
+		Entity:      "Default Manifest",
+		Description: "Non-standard compatibility route which retrieves the manifest of a repository's configured default tag. The route is only served when explicitly enabled in the registry configuration.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      http.MethodGet,
+				Description: "Fetch the manifest referenced by the default tag of the repository identified by `name`. A `HEAD` request can also be issued to this endpoint to obtain resource information without receiving all data.",
+				Requests: []RequestDescriptor{
+					{
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						PathParameters: []ParameterDescriptor{
+							nameParameterDescriptor,
+						},
+						Successes: []ResponseDescriptor{
+							{
+								Description: "The manifest referenced by the default tag of `name`.",
+								StatusCode:  http.StatusOK,
+								Headers: []ParameterDescriptor{
+									digestHeader,
+								},
+								Body: BodyDescriptor{
+									ContentType: "<media type of manifest>",
+									Format:      manifestBody,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								Description: "The default tag does not exist in the repository or the route is not enabled.",
+								StatusCode:  http.StatusNotFound,
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeManifestUnknown,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							unauthorizedResponseDescriptor,
+							repositoryNotFoundResponseDescriptor,
+							deniedResponseDescriptor,
+							tooManyRequestsDescriptor,
+						},
+					},
 				},
 			},
 		},
@@ -567,6 +727,17 @@ var routeDescriptors = []RouteDescriptor{
 									Format:      errorsBody,
 								},
 							},
+							{
+								Description: "The manifest is not available in any of the media types accepted by the `Accept` headers of the request, weighted by their `q` values. The error detail lists the available media types.",
+								StatusCode:  http.StatusNotAcceptable,
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeManifestNotAcceptable,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
 							unauthorizedResponseDescriptor,
 							repositoryNotFoundResponseDescriptor,
 							deniedResponseDescriptor,
@@ -677,6 +848,14 @@ var routeDescriptors = []RouteDescriptor{
 							nameParameterDescriptor,
 							referenceParameterDescriptor,
 						},
+						QueryParameters: []ParameterDescriptor{
+							{
+								Name:        "force",
+								Type:        "query",
+								Format:      "<boolean>",
+								Description: "Set to `true` to override the tag protection rules of the registry, which requires the `force-delete` action on the repository.",
+							},
+						},
 						Successes: []ResponseDescriptor{
 							{
 								StatusCode: http.StatusAccepted,
@@ -713,6 +892,18 @@ var routeDescriptors = []RouteDescriptor{
 									Format:      errorsBody,
 								},
 							},
+							{
+								Name:        "Protected Tag",
+								Description: "The delete would delete a protected tag, or leave fewer tags matching a tag protection rule than the rule requires. The detail names the rule.",
+								StatusCode:  http.StatusConflict,
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeTagProtected,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
 							{
 								Name:        "Not allowed",
 								Description: "Manifest or tag delete is not allowed because the registry is configured as a pull-through cache or `delete` has been disabled.",
@@ -805,6 +996,17 @@ var routeDescriptors = []RouteDescriptor{
 									errcode.ErrorCodeBlobUnknown,
 								},
 							},
+							{
+								Description: "The registry only serves blobs through redirects to the storage, which provided no redirect for the blob.",
+								StatusCode:  http.StatusNotImplemented,
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeBlobRedirectUnavailable,
+								},
+							},
 							unauthorizedResponseDescriptor,
 							repositoryNotFoundResponseDescriptor,
 							deniedResponseDescriptor,
@@ -1155,6 +1357,64 @@ var routeDescriptors = []RouteDescriptor{
 		},
 	},
 
+	{
+		Name:        RouteNameBlobUploadWS,
+		Path:        "/v2/{name:" + reference.NameRegexp.String() + "}/blobs/uploads/_websocket",
+		Entity:      "Blob Upload WebSocket",
+		Description: "Non-standard route which receives a blob upload over a WebSocket connection, for browser clients unable to send chunked uploads. The route is only served when WebSocket uploads are enabled in the registry configuration, and requires the push action on the repository.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      http.MethodGet,
+				Description: "Open a WebSocket connection starting an upload, or resuming the upload identified by the `uuid` parameter. Once connected, the registry sends the status of the upload as a text message, `{\"uuid\": <uuid>, \"offset\": <offset>}`. The client then sends the content of the blob as binary messages, and completes the upload with a text message, `{\"digest\": <digest>}`, answered with `{\"digest\": <digest>, \"location\": <blob url>}`. Failures are answered with `{\"errors\": [...]}` before the connection is closed. An upload whose connection is lost is kept, and resumed by connecting again with its `uuid`.",
+				Requests: []RequestDescriptor{
+					{
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						PathParameters: []ParameterDescriptor{
+							nameParameterDescriptor,
+						},
+						QueryParameters: []ParameterDescriptor{
+							{
+								Name:        "uuid",
+								Type:        "query",
+								Format:      "<uuid>",
+								Description: "The upload to resume. If absent, an upload is started.",
+							},
+							{
+								Name:        "offset",
+								Type:        "query",
+								Format:      "<offset>",
+								Description: "The offset the client resumes the upload from, which must match the size of the content received so far.",
+							},
+						},
+						Successes: []ResponseDescriptor{
+							{
+								Description: "The connection was upgraded to a WebSocket.",
+								StatusCode:  http.StatusSwitchingProtocols,
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								Description: "WebSocket uploads are not enabled.",
+								StatusCode:  http.StatusNotFound,
+							},
+							{
+								Description: "The origin of the request is not allowed.",
+								StatusCode:  http.StatusForbidden,
+							},
+							unauthorizedResponseDescriptor,
+							repositoryNotFoundResponseDescriptor,
+							deniedResponseDescriptor,
+							tooManyRequestsDescriptor,
+						},
+					},
+				},
+			},
+		},
+	},
+
 	{
 		Name:        RouteNameBlobUploadChunk,
 		Path:        "/v2/{name:" + reference.NameRegexp.String() + "}/blobs/uploads/{uuid:[a-zA-Z0-9-_.=]+}",
@@ -1321,6 +1581,18 @@ var routeDescriptors = []RouteDescriptor{
 								Format:      "<length of chunk>",
 								Description: "Length of the chunk being uploaded, corresponding the length of the request body.",
 							},
+							{
+								Name:        "Content-MD5",
+								Type:        "header",
+								Format:      "<base64 md5 digest>",
+								Description: "Optional MD5 digest of the chunk. The chunk is only appended if it matches.",
+							},
+							{
+								Name:        "Content-Digest",
+								Type:        "header",
+								Format:      "sha-256=:<base64 digest>:",
+								Description: "Optional `sha-256` or `sha-512` digests of the chunk, as defined by RFC 9530. The chunk is only appended if it matches. Digests of other algorithms are ignored.",
+							},
 						},
 						Body: BodyDescriptor{
 							ContentType: "application/octet-stream",
@@ -1374,6 +1646,19 @@ var routeDescriptors = []RouteDescriptor{
 									Format:      errorsBody,
 								},
 							},
+							{
+								Name:        "Chunk Checksum Mismatch",
+								Description: "The chunk does not match its `Content-MD5` or `Content-Digest` checksum, which is malformed, or the chunk is too large to be verified. The chunk was not appended, and the current progress is available in the range header of a mismatch, so that the client can send the chunk again.",
+								StatusCode:  http.StatusBadRequest,
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeDigestInvalid,
+									errcode.ErrorCodeSizeInvalid,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
 							{
 								Description: "The `Content-Range` specification cannot be accepted, either because it does not overlap with the current progress or it is invalid.",
 								StatusCode:  http.StatusRequestedRangeNotSatisfiable,
@@ -1605,6 +1890,733 @@ var routeDescriptors = []RouteDescriptor{
 							invalidPaginationResponseDescriptor,
 						},
 					},
+					{
+						Name:        "Catalog Fetch Detailed",
+						Description: "Return the repositories with the artifact types of their manifests, as recorded when the manifests were pushed. The registry must be configured to record them. The request may be paginated, the `Link` header then preserves the `detail` parameter.",
+						QueryParameters: append(append([]ParameterDescriptor{}, paginationParameters...), ParameterDescriptor{
+							Name:        "detail",
+							Type:        "boolean",
+							Description: "Return the artifact types of each repository.",
+							Format:      "<boolean>",
+							Required:    true,
+						}),
+						Successes: []ResponseDescriptor{
+							{
+								StatusCode:  http.StatusOK,
+								Description: "Each repository is described by an object holding its name and the artifact types of its manifests, in lexical order.",
+								Headers: []ParameterDescriptor{
+									{
+										Name:        "Content-Length",
+										Type:        "integer",
+										Description: "Length of the JSON response body.",
+										Format:      "<length>",
+									},
+									linkHeader,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format: `{
+    "repositories": [
+        {
+            "name": <name>,
+            "artifactTypes": [
+                <media type>,
+                ...
+            ]
+        },
+        ...
+    ]
+}`,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							invalidPaginationResponseDescriptor,
+							invalidCatalogDetailResponseDescriptor,
+							{
+								Name:        "Not allowed",
+								Description: "The registry is not configured to record the artifact types of the manifests.",
+								StatusCode:  http.StatusMethodNotAllowed,
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeUnsupported,
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:        RouteNameAuth,
+		Path:        "/v2/_auth",
+		Entity:      "Auth",
+		Description: "Describe the authentication challenge issued by the registry, allowing clients to discover the token realm without making a failing request first. The route does not require authentication and is only served when authentication is configured.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      http.MethodGet,
+				Description: "Retrieve the authentication challenge issued to unauthenticated requests.",
+				Requests: []RequestDescriptor{
+					{
+						Headers: []ParameterDescriptor{
+							hostHeader,
+						},
+						Successes: []ResponseDescriptor{
+							{
+								Description: "The challenge matching the `WWW-Authenticate` header of unauthorized responses. The scopes are only described for bearer challenges.",
+								StatusCode:  http.StatusOK,
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format: `{
+	"type": "bearer",
+	"realm": "<url>",
+	"service": "<service>",
+	"scopes": {
+		"grammar": "<resourcetype>:<resourcename>:<action>[,<action>...]",
+		"resourceTypes": ["repository", "registry"],
+		"actions": ["pull", "push", "delete", "*"]
+	}
+}`,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								Description: "Authentication is not configured.",
+								StatusCode:  http.StatusNotFound,
+							},
+						},
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:        RouteNameCapabilities,
+		Path:        "/v2/_capabilities",
+		Entity:      "Capabilities",
+		Description: "Non-standard route which describes the version of the registry, the endpoints it serves, its limits and the digest algorithms it supports, so that clients do not need to probe optional endpoints. The route requires the same authentication as the base route. The document is also served by the base route when enabled in the registry configuration.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      http.MethodGet,
+				Description: "Retrieve the capabilities document.",
+				Requests: []RequestDescriptor{
+					{
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						Successes: []ResponseDescriptor{
+							{
+								Description: "The capabilities of the registry. The endpoints served are listed by route name, with their path template. A zero limit is not enforced.",
+								StatusCode:  http.StatusOK,
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format: `{
+	"version": "<version>",
+	"endpoints": {
+		"<route name>": "<path template>",
+		...
+	},
+	"limits": {
+		"maxManifestSize": <bytes>,
+		"minChunkSize": <bytes>
+	},
+	"digestAlgorithms": ["sha256", ...]
+}`,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							unauthorizedResponseDescriptor,
+							tooManyRequestsDescriptor,
+						},
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:        RouteNameReferrers,
+		Path:        "/v2/{name:" + reference.NameRegexp.String() + "}/referrers/{digest:" + digest.DigestRegexp.String() + "}",
+		Entity:      "Referrers",
+		Description: "Retrieve the manifests of a repository referring to a subject manifest through their `subject` field. The queries of a repository may be rate limited by the registry configuration.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      http.MethodGet,
+				Description: "List the manifests of the repository identified by `name` whose subject is the manifest identified by `digest`, as an image index. The subject does not need to exist in the repository.",
+				Requests: []RequestDescriptor{
+					{
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						PathParameters: []ParameterDescriptor{
+							nameParameterDescriptor,
+							digestPathParameter,
+						},
+						QueryParameters: []ParameterDescriptor{
+							{
+								Name:        "artifactType",
+								Type:        "string",
+								Format:      "<artifact type>",
+								Required:    false,
+								Description: "Only list the referrers of the given artifact type.",
+							},
+						},
+						Successes: []ResponseDescriptor{
+							{
+								Description: "The referrers of the subject, sorted by digest. The list is empty when the subject has no referrers.",
+								StatusCode:  http.StatusOK,
+								Headers: []ParameterDescriptor{
+									{
+										Name:        "OCI-Filters-Applied",
+										Type:        "string",
+										Description: "Set to `artifactType` when the referrers were filtered by artifact type.",
+										Format:      "artifactType",
+									},
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/vnd.oci.image.index.v1+json",
+									Format: `{
+    "schemaVersion": 2,
+    "mediaType": "application/vnd.oci.image.index.v1+json",
+    "manifests": [
+        {
+            "mediaType": <media type>,
+            "digest": <digest>,
+            "size": <size>,
+            "artifactType": <artifact type>,
+            "annotations": {
+                <key>: <value>,
+                ...
+            }
+        },
+        ...
+    ]
+}`,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								Name:        "Invalid Digest",
+								Description: "The digest of the subject is invalid.",
+								StatusCode:  http.StatusBadRequest,
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeDigestInvalid,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							unauthorizedResponseDescriptor,
+							repositoryNotFoundResponseDescriptor,
+							deniedResponseDescriptor,
+							{
+								Name:        "Too Many Requests",
+								Description: "The rate of the referrers queries of the repository was exceeded. The client should retry after the delay given by the `Retry-After` header.",
+								StatusCode:  http.StatusTooManyRequests,
+								Headers: []ParameterDescriptor{
+									{
+										Name:        "Retry-After",
+										Type:        "integer",
+										Description: "The number of seconds to wait before retrying.",
+										Format:      "<seconds>",
+									},
+								},
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeTooManyRequests,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:        RouteNameStats,
+		Path:        "/v2/{name:" + reference.NameRegexp.String() + "}/_stats",
+		Entity:      "Stats",
+		Description: "Non-standard route which retrieves the pulls counted for a repository. The route is only served when pull stats are enabled in the registry configuration.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      http.MethodGet,
+				Description: "Retrieve the number of pulls of the manifests and blobs of the repository identified by `name`, in total and per UTC day. Manifests are counted by the tag or digest they were requested by, blobs by digest. Counting is best-effort: pulls may be dropped when the registry is overloaded or the stats backend is unavailable.",
+				Requests: []RequestDescriptor{
+					{
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						PathParameters: []ParameterDescriptor{
+							nameParameterDescriptor,
+						},
+						Successes: []ResponseDescriptor{
+							{
+								Description: "The pulls counted for the repository.",
+								StatusCode:  http.StatusOK,
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format: `{
+    "name": <name>,
+    "total": <count>,
+    "daily": {
+        <YYYY-MM-DD>: <count>,
+        ...
+    },
+    "manifests": {
+        <tag or digest>: {
+            "total": <count>,
+            "daily": {
+                <YYYY-MM-DD>: <count>,
+                ...
+            }
+        },
+        ...
+    },
+    "blobs": {
+        <digest>: {
+            "total": <count>,
+            "daily": {
+                <YYYY-MM-DD>: <count>,
+                ...
+            }
+        },
+        ...
+    }
+}`,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								Description: "Pull stats are not enabled.",
+								StatusCode:  http.StatusNotFound,
+							},
+							unauthorizedResponseDescriptor,
+							repositoryNotFoundResponseDescriptor,
+							deniedResponseDescriptor,
+							tooManyRequestsDescriptor,
+						},
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:        RouteNameTagOperations,
+		Path:        "/v2/{name:" + reference.NameRegexp.String() + "}/_tags",
+		Entity:      "Tag Operations",
+		Description: "Non-standard route which updates several tags of a repository at once.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      http.MethodPost,
+				Description: "Point each tag of the request at the manifest identified by its digest, all or nothing: if any tag cannot be updated, the tags already updated are restored. Operations on the same repository are serialized, so that observers never see the tags disagree. A manifest push event is emitted per tag once all the tags are updated.",
+				Requests: []RequestDescriptor{
+					{
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						PathParameters: []ParameterDescriptor{
+							nameParameterDescriptor,
+						},
+						Body: BodyDescriptor{
+							ContentType: "application/json",
+							Format: `[
+    {
+        "tag": <tag>,
+        "digest": <digest>
+    },
+    ...
+]`,
+						},
+						Successes: []ResponseDescriptor{
+							{
+								Description: "All the tags were updated.",
+								StatusCode:  http.StatusNoContent,
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								Name:        "Invalid Operations",
+								Description: "The body is not a valid list of operations, or a tag or digest is invalid. No tag was updated.",
+								StatusCode:  http.StatusBadRequest,
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeTagOperationsInvalid,
+									errcode.ErrorCodeTagInvalid,
+									errcode.ErrorCodeDigestInvalid,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							{
+								Name:        "Unknown Manifest",
+								Description: "A digest does not identify a manifest of the repository. No tag was updated.",
+								StatusCode:  http.StatusNotFound,
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeManifestUnknown,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							{
+								Name:        "Not allowed",
+								Description: "Tag operations are not supported by the registry, for example when it is configured as a pull-through cache.",
+								StatusCode:  http.StatusMethodNotAllowed,
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeUnsupported,
+								},
+							},
+							unauthorizedResponseDescriptor,
+							repositoryNotFoundResponseDescriptor,
+							deniedResponseDescriptor,
+							tooManyRequestsDescriptor,
+						},
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:        RouteNameChanges,
+		Path:        "/v2/_changes",
+		Entity:      "Changes",
+		Description: "Non-standard route which retrieves the changes made to the repositories and tags of the registry since a checkpoint, so that mirrors of the catalog do not need to list every repository again. The route is only served when the change feed is enabled in the registry configuration, and requires the same access as the catalog.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      http.MethodGet,
+				Description: "Retrieve the changes following the sequence number `since`, in order, and the sequence number of the latest change. Changes are numbered consecutively, and record repositories created and deleted, tags updated and deleted and manifests deleted. Clients keep the sequence number of the last change received, or the latest sequence number once no change is returned, as their checkpoint.",
+				Requests: []RequestDescriptor{
+					{
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						QueryParameters: []ParameterDescriptor{
+							{
+								Name:        "since",
+								Type:        "integer",
+								Description: "Return the changes following this sequence number. If not present, changes are returned from the oldest retained.",
+								Format:      "<integer>",
+							},
+							{
+								Name:        "n",
+								Type:        "integer",
+								Description: "Limit the number of changes in the response. If not present, 100 changes will be returned.",
+								Format:      "<integer>",
+							},
+						},
+						Successes: []ResponseDescriptor{
+							{
+								Description: "The changes following `since`.",
+								StatusCode:  http.StatusOK,
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format: `{
+    "changes": [
+        {
+            "sequence": <sequence>,
+            "action": "repository_created" | "repository_deleted" | "tag_updated" | "tag_deleted" | "manifest_deleted",
+            "repository": <name>,
+            "tag": <tag>,
+            "digest": <digest>,
+            "timestamp": <timestamp>
+        },
+        ...
+    ],
+    "latest": <sequence>
+}`,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								Name:        "Invalid Sequence Number",
+								Description: "The `since` parameter is not a non-negative integer.",
+								StatusCode:  http.StatusBadRequest,
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeChangesSequenceInvalid,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							invalidPaginationResponseDescriptor,
+							{
+								Name:        "Changes Compacted",
+								Description: "Changes following `since` are no longer retained. The client must resynchronize from the full catalog, then follow the changes from the latest sequence number.",
+								StatusCode:  http.StatusGone,
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeChangesCompacted,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							{
+								Description: "The change feed is not enabled.",
+								StatusCode:  http.StatusNotFound,
+							},
+							unauthorizedResponseDescriptor,
+							deniedResponseDescriptor,
+							tooManyRequestsDescriptor,
+						},
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:        RouteNameWebhooks,
+		Path:        "/v2/{name:" + reference.NameRegexp.String() + "}/_webhooks",
+		Entity:      "Webhooks",
+		Description: "Non-standard route which manages the webhook subscriptions of a repository. A subscription receives the notification events of the repository and of the repositories under it, such as `team-a/app` for the subscriptions of `team-a`, with the same retries as the endpoints of the registry configuration. The route is only served when subscriptions are enabled in the registry configuration, and requires all the actions (`*`) on the repository.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      http.MethodGet,
+				Description: "Retrieve the webhook subscriptions of the repository identified by `name`. Secrets are not returned.",
+				Requests: []RequestDescriptor{
+					{
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						PathParameters: []ParameterDescriptor{
+							nameParameterDescriptor,
+						},
+						Successes: []ResponseDescriptor{
+							{
+								Description: "The subscriptions of the repository.",
+								StatusCode:  http.StatusOK,
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format: `{
+    "subscriptions": [
+        {
+            "name": <name>,
+            "url": <url>,
+            "secret": <secret>,
+            "actions": [<action>, ...],
+            "mediatypes": [<media type>, ...]
+        },
+        ...
+    ]
+}`,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								Description: "Subscriptions are not enabled.",
+								StatusCode:  http.StatusNotFound,
+							},
+							unauthorizedResponseDescriptor,
+							deniedResponseDescriptor,
+							tooManyRequestsDescriptor,
+						},
+					},
+				},
+			},
+			{
+				Method:      http.MethodPut,
+				Description: "Replace the webhook subscriptions of the repository identified by `name`. Requests to a subscription are signed with an HMAC-SHA256 of their body, keyed by its `secret`, in the `X-Registry-Signature` header. The `actions` and `mediatypes` of a subscription, if not empty, restrict the events delivered to those of the listed actions and target media types.",
+				Requests: []RequestDescriptor{
+					{
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						PathParameters: []ParameterDescriptor{
+							nameParameterDescriptor,
+						},
+						Body: BodyDescriptor{
+							ContentType: "application/json",
+							Format: `{
+    "subscriptions": [
+        {
+            "name": <name>,
+            "url": <url>,
+            "secret": <secret>,
+            "actions": [<action>, ...],
+            "mediatypes": [<media type>, ...]
+        },
+        ...
+    ]
+}`,
+						},
+						Successes: []ResponseDescriptor{
+							{
+								Description: "The subscriptions were replaced.",
+								StatusCode:  http.StatusNoContent,
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								Name:        "Invalid Subscriptions",
+								Description: "The body is not a valid list of subscriptions, or holds more subscriptions than the registry allows per repository.",
+								StatusCode:  http.StatusBadRequest,
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeSubscriptionsInvalid,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							{
+								Description: "Subscriptions are not enabled.",
+								StatusCode:  http.StatusNotFound,
+							},
+							unauthorizedResponseDescriptor,
+							deniedResponseDescriptor,
+							tooManyRequestsDescriptor,
+						},
+					},
+				},
+			},
+			{
+				Method:      http.MethodDelete,
+				Description: "Remove the webhook subscriptions of the repository identified by `name`.",
+				Requests: []RequestDescriptor{
+					{
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						PathParameters: []ParameterDescriptor{
+							nameParameterDescriptor,
+						},
+						Successes: []ResponseDescriptor{
+							{
+								Description: "The subscriptions were removed.",
+								StatusCode:  http.StatusNoContent,
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								Description: "Subscriptions are not enabled.",
+								StatusCode:  http.StatusNotFound,
+							},
+							unauthorizedResponseDescriptor,
+							deniedResponseDescriptor,
+							tooManyRequestsDescriptor,
+						},
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:        RouteNameRetag,
+		Path:        "/v2/_admin/retag",
+		Entity:      "Retag",
+		Description: "Non-standard administrative route which tags manifests of repositories in other repositories, without transferring their content, to migrate repositories to new names. It requires pull access to the source repositories and push access to the destination repositories.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      http.MethodPost,
+				Description: "Point each destination tag of the request at the manifest identified by the source tag or digest of its source repository. The blobs and child manifests of the manifest are linked into the destination repository rather than copied, except when the repositories are stored on different storage backends. All the sources are resolved, and their blobs checked to exist, before any destination is updated. Operations are then applied in order and emit the push events of their destination, a failing operation leaving the preceding ones applied.",
+				Requests: []RequestDescriptor{
+					{
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						Body: BodyDescriptor{
+							ContentType: "application/json",
+							Format: `[
+    {
+        "sourceRepository": <name>,
+        "sourceReference": <tag> | <digest>,
+        "destRepository": <name>,
+        "destTag": <tag>
+    },
+    ...
+]`,
+						},
+						Successes: []ResponseDescriptor{
+							{
+								Description: "All the destination tags were updated. The response lists the digest each destination tag points at.",
+								StatusCode:  http.StatusOK,
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format: `[
+    {
+        "destRepository": <name>,
+        "destTag": <tag>,
+        "digest": <digest>
+    },
+    ...
+]`,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								Name:        "Invalid Operations",
+								Description: "The body is not a valid list of operations, or a repository name, tag or digest is invalid. No tag was updated.",
+								StatusCode:  http.StatusBadRequest,
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeRetagInvalid,
+									errcode.ErrorCodeNameInvalid,
+									errcode.ErrorCodeTagInvalid,
+									errcode.ErrorCodeDigestInvalid,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							{
+								Name:        "Incomplete Source",
+								Description: "A blob referenced by a source manifest is missing from its repository. No tag was updated.",
+								StatusCode:  http.StatusBadRequest,
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeManifestBlobUnknown,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							{
+								Name:        "Unknown Source",
+								Description: "A source tag or digest does not identify a manifest of its repository. No tag was updated.",
+								StatusCode:  http.StatusNotFound,
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeManifestUnknown,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							{
+								Name:        "Not allowed",
+								Description: "Retagging is not supported by the registry, for example when it is configured as a pull-through cache.",
+								StatusCode:  http.StatusMethodNotAllowed,
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeUnsupported,
+								},
+							},
+							unauthorizedResponseDescriptor,
+							deniedResponseDescriptor,
+							tooManyRequestsDescriptor,
+						},
+					},
 				},
 			},
 		},
@@ -11,11 +11,21 @@ import (
 const (
 	RouteNameBase            = "base"
 	RouteNameManifest        = "manifest"
+	RouteNameManifestDefault = "manifest-default"
 	RouteNameTags            = "tags"
 	RouteNameBlob            = "blob"
 	RouteNameBlobUpload      = "blob-upload"
 	RouteNameBlobUploadChunk = "blob-upload-chunk"
+	RouteNameBlobUploadWS    = "blob-upload-websocket"
 	RouteNameCatalog         = "catalog"
+	RouteNameAuth            = "auth"
+	RouteNameCapabilities    = "capabilities"
+	RouteNameStats           = "stats"
+	RouteNameTagOperations   = "tag-operations"
+	RouteNameChanges         = "changes"
+	RouteNameWebhooks        = "webhooks"
+	RouteNameRetag           = "retag"
+	RouteNameReferrers       = "referrers"
 )
 
 var (
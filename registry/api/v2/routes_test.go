@@ -59,6 +59,57 @@ func TestRouter(t *testing.T) {
 				"reference": "sha256:abcdef01234567890",
 			},
 		},
+		{
+			RouteName:  RouteNameManifestDefault,
+			RequestURI: "/v2/foo/bar/manifests/",
+			Vars: map[string]string{
+				"name": "foo/bar",
+			},
+		},
+		{
+			RouteName:  RouteNameChanges,
+			RequestURI: "/v2/_changes",
+			Vars:       map[string]string{},
+		},
+		{
+			RouteName:  RouteNameCapabilities,
+			RequestURI: "/v2/_capabilities",
+			Vars:       map[string]string{},
+		},
+		{
+			RouteName:  RouteNameRetag,
+			RequestURI: "/v2/_admin/retag",
+			Vars:       map[string]string{},
+		},
+		{
+			RouteName:  RouteNameReferrers,
+			RequestURI: "/v2/foo/bar/referrers/sha256:abcdef0919234",
+			Vars: map[string]string{
+				"name":   "foo/bar",
+				"digest": "sha256:abcdef0919234",
+			},
+		},
+		{
+			RouteName:  RouteNameStats,
+			RequestURI: "/v2/foo/bar/_stats",
+			Vars: map[string]string{
+				"name": "foo/bar",
+			},
+		},
+		{
+			RouteName:  RouteNameTagOperations,
+			RequestURI: "/v2/foo/bar/_tags",
+			Vars: map[string]string{
+				"name": "foo/bar",
+			},
+		},
+		{
+			RouteName:  RouteNameWebhooks,
+			RequestURI: "/v2/foo/bar/_webhooks",
+			Vars: map[string]string{
+				"name": "foo/bar",
+			},
+		},
 		{
 			RouteName:  RouteNameTags,
 			RequestURI: "/v2/foo/bar/tags/list",
@@ -102,6 +153,13 @@ func TestRouter(t *testing.T) {
 				"name": "foo/bar",
 			},
 		},
+		{
+			RouteName:  RouteNameBlobUploadWS,
+			RequestURI: "/v2/foo/bar/blobs/uploads/_websocket",
+			Vars: map[string]string{
+				"name": "foo/bar",
+			},
+		},
 		{
 			RouteName:  RouteNameBlobUploadChunk,
 			RequestURI: "/v2/foo/bar/blobs/uploads/uuid",
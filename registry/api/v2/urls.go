@@ -2,8 +2,10 @@ package v2
 
 import (
 	"fmt"
+	"net"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 
 	"github.com/distribution/reference"
@@ -45,8 +47,31 @@ func NewURLBuilderFromString(root string, relative bool) (*URLBuilder, error) {
 }
 
 // NewURLBuilderFromRequest uses information from an *http.Request to
-// construct the root url.
+// construct the root url, honoring the headers set by forwarding proxies.
+//
+// The scheme and host of the root url are taken, in order of precedence,
+// from:
+//
+//  1. the first element of the "Forwarded" header (RFC 7239), if present and
+//     valid, ignoring every X-Forwarded-* header;
+//  2. the "X-Forwarded-Proto" and "X-Forwarded-Host" headers, the latter
+//     taking the port from "X-Forwarded-Port" if it has none;
+//  3. the request itself.
+//
+// Forwarded headers may be set by any client, so this must only be used for
+// requests received from a trusted proxy. See NewURLBuilderFromDirectRequest.
 func NewURLBuilderFromRequest(r *http.Request, relative bool) *URLBuilder {
+	return newURLBuilderFromRequest(r, relative, true)
+}
+
+// NewURLBuilderFromDirectRequest works identically to NewURLBuilderFromRequest
+// except it ignores forwarded headers, for requests which were not received
+// from a trusted proxy.
+func NewURLBuilderFromDirectRequest(r *http.Request, relative bool) *URLBuilder {
+	return newURLBuilderFromRequest(r, relative, false)
+}
+
+func newURLBuilderFromRequest(r *http.Request, relative bool, forwarded bool) *URLBuilder {
 	var (
 		scheme = "http"
 		host   = r.Host
@@ -58,31 +83,8 @@ func NewURLBuilderFromRequest(r *http.Request, relative bool) *URLBuilder {
 		scheme = r.URL.Scheme
 	}
 
-	// Handle forwarded headers
-	// Prefer "Forwarded" header as defined by rfc7239 if given
-	// see https://tools.ietf.org/html/rfc7239
-	if forwarded := r.Header.Get("Forwarded"); len(forwarded) > 0 {
-		forwardedHeader, _, err := parseForwardedHeader(forwarded)
-		if err == nil {
-			if fproto := forwardedHeader["proto"]; len(fproto) > 0 {
-				scheme = fproto
-			}
-			if fhost := forwardedHeader["host"]; len(fhost) > 0 {
-				host = fhost
-			}
-		}
-	} else {
-		if forwardedProto := r.Header.Get("X-Forwarded-Proto"); len(forwardedProto) > 0 {
-			scheme = forwardedProto
-		}
-		if forwardedHost := r.Header.Get("X-Forwarded-Host"); len(forwardedHost) > 0 {
-			// According to the Apache mod_proxy docs, X-Forwarded-Host can be a
-			// comma-separated list of hosts, to which each proxy appends the
-			// requested host. We want to grab the first from this comma-separated
-			// list.
-			host, _, _ = strings.Cut(forwardedHost, ",")
-			host = strings.TrimSpace(host)
-		}
+	if forwarded {
+		scheme, host = forwardedRoot(r, scheme, host)
 	}
 
 	basePath := routeDescriptorsMap[RouteNameBase].Path
@@ -103,6 +105,52 @@ func NewURLBuilderFromRequest(r *http.Request, relative bool) *URLBuilder {
 	return NewURLBuilder(u, relative)
 }
 
+// forwardedRoot returns the scheme and host of the root url according to the
+// forwarded headers of r, defaulting to scheme and host.
+func forwardedRoot(r *http.Request, scheme, host string) (string, string) {
+	// Prefer "Forwarded" header as defined by rfc7239 if given
+	// see https://tools.ietf.org/html/rfc7239
+	if forwarded := r.Header.Get("Forwarded"); len(forwarded) > 0 {
+		forwardedHeader, _, err := parseForwardedHeader(forwarded)
+		if err == nil {
+			if fproto := forwardedHeader["proto"]; len(fproto) > 0 {
+				scheme = fproto
+			}
+			if fhost := forwardedHeader["host"]; len(fhost) > 0 {
+				host = fhost
+			}
+		}
+		return scheme, host
+	}
+
+	if forwardedProto := r.Header.Get("X-Forwarded-Proto"); len(forwardedProto) > 0 {
+		scheme = forwardedProto
+	}
+	if forwardedHost := r.Header.Get("X-Forwarded-Host"); len(forwardedHost) > 0 {
+		// According to the Apache mod_proxy docs, X-Forwarded-Host can be a
+		// comma-separated list of hosts, to which each proxy appends the
+		// requested host. We want to grab the first from this comma-separated
+		// list.
+		host, _, _ = strings.Cut(forwardedHost, ",")
+		host = strings.TrimSpace(host)
+
+		if _, _, err := net.SplitHostPort(host); err != nil {
+			// The port of the first proxy applies to the first host.
+			port, _, _ := strings.Cut(r.Header.Get("X-Forwarded-Port"), ",")
+			port = strings.TrimSpace(port)
+			if n, err := strconv.ParseUint(port, 10, 16); err == nil && n > 0 && !isDefaultPort(scheme, port) {
+				host = net.JoinHostPort(strings.Trim(host, "[]"), port)
+			}
+		}
+	}
+	return scheme, host
+}
+
+// isDefaultPort reports whether port is the default port of scheme.
+func isDefaultPort(scheme, port string) bool {
+	return (scheme == "http" && port == "80") || (scheme == "https" && port == "443")
+}
+
 // BuildBaseURL constructs a base url for the API, typically just "/v2/".
 func (ub *URLBuilder) BuildBaseURL() (string, error) {
 	route := ub.cloneRoute(RouteNameBase)
@@ -127,6 +175,45 @@ func (ub *URLBuilder) BuildCatalogURL(values ...url.Values) (string, error) {
 	return appendValuesURL(catalogURL, values...).String(), nil
 }
 
+// BuildChangesURL constructs a url to retrieve the changes made to the
+// repositories and tags of the registry.
+func (ub *URLBuilder) BuildChangesURL(values ...url.Values) (string, error) {
+	route := ub.cloneRoute(RouteNameChanges)
+
+	changesURL, err := route.URL()
+	if err != nil {
+		return "", err
+	}
+
+	return appendValuesURL(changesURL, values...).String(), nil
+}
+
+// BuildAuthURL constructs a url to describe the authentication challenge of
+// the registry.
+func (ub *URLBuilder) BuildAuthURL() (string, error) {
+	route := ub.cloneRoute(RouteNameAuth)
+
+	authURL, err := route.URL()
+	if err != nil {
+		return "", err
+	}
+
+	return authURL.String(), nil
+}
+
+// BuildCapabilitiesURL constructs a url to describe the capabilities of the
+// registry.
+func (ub *URLBuilder) BuildCapabilitiesURL() (string, error) {
+	route := ub.cloneRoute(RouteNameCapabilities)
+
+	capabilitiesURL, err := route.URL()
+	if err != nil {
+		return "", err
+	}
+
+	return capabilitiesURL.String(), nil
+}
+
 // BuildTagsURL constructs a url to list the tags in the named repository.
 func (ub *URLBuilder) BuildTagsURL(name reference.Named, values ...url.Values) (string, error) {
 	route := ub.cloneRoute(RouteNameTags)
@@ -139,6 +226,58 @@ func (ub *URLBuilder) BuildTagsURL(name reference.Named, values ...url.Values) (
 	return appendValuesURL(tagsURL, values...).String(), nil
 }
 
+// BuildStatsURL constructs a url to retrieve the pull stats of the named
+// repository.
+func (ub *URLBuilder) BuildStatsURL(name reference.Named) (string, error) {
+	route := ub.cloneRoute(RouteNameStats)
+
+	statsURL, err := route.URL("name", name.Name())
+	if err != nil {
+		return "", err
+	}
+
+	return statsURL.String(), nil
+}
+
+// BuildTagOperationsURL constructs a url to update several tags of the named
+// repository at once.
+func (ub *URLBuilder) BuildTagOperationsURL(name reference.Named) (string, error) {
+	route := ub.cloneRoute(RouteNameTagOperations)
+
+	tagOperationsURL, err := route.URL("name", name.Name())
+	if err != nil {
+		return "", err
+	}
+
+	return tagOperationsURL.String(), nil
+}
+
+// BuildRetagURL constructs a url to copy manifests to tags of other
+// repositories.
+func (ub *URLBuilder) BuildRetagURL() (string, error) {
+	route := ub.cloneRoute(RouteNameRetag)
+
+	retagURL, err := route.URL()
+	if err != nil {
+		return "", err
+	}
+
+	return retagURL.String(), nil
+}
+
+// BuildWebhooksURL constructs a url to manage the webhook subscriptions of
+// the named repository.
+func (ub *URLBuilder) BuildWebhooksURL(name reference.Named) (string, error) {
+	route := ub.cloneRoute(RouteNameWebhooks)
+
+	webhooksURL, err := route.URL("name", name.Name())
+	if err != nil {
+		return "", err
+	}
+
+	return webhooksURL.String(), nil
+}
+
 // BuildManifestURL constructs a url for the manifest identified by name and
 // reference. The argument reference may be either a tag or digest.
 func (ub *URLBuilder) BuildManifestURL(ref reference.Named) (string, error) {
@@ -174,6 +313,19 @@ func (ub *URLBuilder) BuildBlobURL(ref reference.Canonical) (string, error) {
 	return layerURL.String(), nil
 }
 
+// BuildReferrersURL constructs a url to list the referrers of the manifest
+// identified by ref, including any url values.
+func (ub *URLBuilder) BuildReferrersURL(ref reference.Canonical, values ...url.Values) (string, error) {
+	route := ub.cloneRoute(RouteNameReferrers)
+
+	referrersURL, err := route.URL("name", ref.Name(), "digest", ref.Digest().String())
+	if err != nil {
+		return "", err
+	}
+
+	return appendValuesURL(referrersURL, values...).String(), nil
+}
+
 // BuildBlobUploadURL constructs a url to begin a blob upload in the
 // repository identified by name.
 func (ub *URLBuilder) BuildBlobUploadURL(name reference.Named, values ...url.Values) (string, error) {
@@ -202,6 +354,20 @@ func (ub *URLBuilder) BuildBlobUploadChunkURL(name reference.Named, uuid string,
 	return appendValuesURL(uploadURL, values...).String(), nil
 }
 
+// BuildBlobUploadWebSocketURL constructs a url to upload a blob over a
+// WebSocket connection in the repository identified by name, including any
+// url values.
+func (ub *URLBuilder) BuildBlobUploadWebSocketURL(name reference.Named, values ...url.Values) (string, error) {
+	route := ub.cloneRoute(RouteNameBlobUploadWS)
+
+	uploadURL, err := route.URL("name", name.Name())
+	if err != nil {
+		return "", err
+	}
+
+	return appendValuesURL(uploadURL, values...).String(), nil
+}
+
 // cloneRoute returns a clone of the named route from the router. Routes
 // must be cloned to avoid modifying them during url generation.
 func (ub *URLBuilder) cloneRoute(name string) clonedRoute {
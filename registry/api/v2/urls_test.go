@@ -80,6 +80,15 @@ func makeURLBuilderTestCases(urlBuilder *URLBuilder) []urlBuilderTestCase {
 				return urlBuilder.BuildBlobURL(ref)
 			},
 		},
+		{
+			description:  "build referrers url",
+			expectedPath: "/v2/foo/bar/referrers/sha256:3b3692957d439ac1928219a83fac91e7bf96c153725526874673ae1f2023f8d5?artifactType=application%2Fvnd.example.finding",
+			expectedErr:  nil,
+			build: func() (string, error) {
+				ref, _ := reference.WithDigest(fooBarRef, "sha256:3b3692957d439ac1928219a83fac91e7bf96c153725526874673ae1f2023f8d5")
+				return urlBuilder.BuildReferrersURL(ref, url.Values{"artifactType": []string{"application/vnd.example.finding"}})
+			},
+		},
 		{
 			description:  "build blob upload url",
 			expectedPath: "/v2/foo/bar/blobs/uploads/",
@@ -118,6 +127,17 @@ func makeURLBuilderTestCases(urlBuilder *URLBuilder) []urlBuilderTestCase {
 				})
 			},
 		},
+		{
+			description:  "build blob upload websocket url",
+			expectedPath: "/v2/foo/bar/blobs/uploads/_websocket?offset=10000&uuid=uuid-part",
+			expectedErr:  nil,
+			build: func() (string, error) {
+				return urlBuilder.BuildBlobUploadWebSocketURL(fooBarRef, url.Values{
+					"uuid":   []string{"uuid-part"},
+					"offset": []string{"10000"},
+				})
+			},
+		},
 	}
 }
 
@@ -314,6 +334,15 @@ func TestBuilderFromRequest(t *testing.T) {
 			}},
 			base: "http://first.example.com:5000",
 		},
+		{
+			name: "forwarded port applied to forwarded host without port",
+			request: &http.Request{URL: u, Host: u.Host, Header: http.Header{
+				"X-Forwarded-Proto": []string{"https"},
+				"X-Forwarded-Host":  []string{"first.example.com"},
+				"X-Forwarded-Port":  []string{"5443, 5000"},
+			}},
+			base: "https://first.example.com:5443",
+		},
 		{
 			name: "malformed forwarded port",
 			request: &http.Request{URL: u, Host: u.Host, Header: http.Header{
@@ -450,6 +479,27 @@ func TestBuilderFromRequest(t *testing.T) {
 	doTest(false)
 }
 
+func TestBuilderFromDirectRequest(t *testing.T) {
+	u, err := url.Parse("http://example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, header := range []http.Header{
+		{"X-Forwarded-Proto": []string{"https"}, "X-Forwarded-Host": []string{"first.example.com"}, "X-Forwarded-Port": []string{"5443"}},
+		{"Forwarded": []string{`host=second.example.com; proto=https`}},
+	} {
+		builder := NewURLBuilderFromDirectRequest(&http.Request{URL: u, Host: u.Host, Header: header}, false)
+		baseURL, err := builder.BuildBaseURL()
+		if err != nil {
+			t.Fatalf("unexpected error building base url: %v", err)
+		}
+		if baseURL != "http://example.com/v2/" {
+			t.Errorf("forwarded headers %v not ignored: %q", header, baseURL)
+		}
+	}
+}
+
 func TestBuilderFromRequestWithPrefix(t *testing.T) {
 	u, err := url.Parse("http://example.com/prefix/v2/")
 	if err != nil {
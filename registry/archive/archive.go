@@ -0,0 +1,256 @@
+// Package archive keeps a copy of every manifest pushed, with a record of
+// the push, in a secondary storage backend, independently of the deletions
+// and garbage collection of the registry.
+//
+// Archival is asynchronous: pushes are queued by an Archiver, which never
+// blocks the caller, and written to the archive driver in the background,
+// retrying failed writes a bounded number of times.
+package archive
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	prometheus "github.com/distribution/distribution/v3/metrics"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/docker/go-metrics"
+	"github.com/opencontainers/go-digest"
+)
+
+const (
+	// DefaultPath is the default template of the directory of an archived
+	// manifest.
+	DefaultPath = "/{repository}/{algorithm}/{hex}"
+
+	// DefaultQueueSize is the default number of pushes queued by an
+	// Archiver before they are dropped.
+	DefaultQueueSize = 1024
+
+	// DefaultMaxRetries is the default number of times a failed archive
+	// write is retried.
+	DefaultMaxRetries = 5
+
+	// defaultRetryInterval is the delay before the first retry of a failed
+	// archive write, doubled on each further retry up to maxRetryInterval.
+	defaultRetryInterval = time.Second
+
+	maxRetryInterval = time.Minute
+
+	// writeTimeout bounds the time an Archiver waits for a write.
+	writeTimeout = 30 * time.Second
+
+	// timestampFormat formats the names of the push records.
+	timestampFormat = "20060102T150405.000000000Z"
+)
+
+var (
+	// lagTimer measures the time between the push of a manifest and its
+	// archival.
+	lagTimer = prometheus.ArchiveNamespace.NewTimer("lag", "The time between the push of a manifest and its archival")
+	// failuresCounter counts the failed archive writes, by whether they are
+	// retried or the push is dropped.
+	failuresCounter = prometheus.ArchiveNamespace.NewLabeledCounter("failures", "The number of failed archive writes", "outcome")
+	// pendingGauge measures the number of pushes queued.
+	pendingGauge = prometheus.ArchiveNamespace.NewGauge("pending", "The number of pushes waiting to be archived", metrics.Total)
+)
+
+func init() {
+	metrics.Register(prometheus.ArchiveNamespace)
+}
+
+// Record describes a push of a manifest. It is stored next to the archived
+// manifest, one per push.
+type Record struct {
+	Repository string        `json:"repository"`
+	Digest     digest.Digest `json:"digest"`
+	MediaType  string        `json:"mediaType"`
+	Tags       []string      `json:"tags,omitempty"`
+	// Actor is the name of the user who pushed the manifest, if
+	// authenticated.
+	Actor     string    `json:"actor,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+type push struct {
+	record  Record
+	payload []byte
+}
+
+// Archiver writes the manifests pushed to an archive driver, in the
+// background.
+type Archiver struct {
+	driver        storagedriver.StorageDriver
+	path          string
+	maxRetries    int
+	retryInterval time.Duration
+
+	// mu guards the queue of pushes against sends once closed.
+	mu     sync.RWMutex
+	pushes chan push
+	closed bool
+	stop   chan struct{}
+	done   chan struct{}
+
+	dropped atomic.Int64
+}
+
+// NewArchiver returns an Archiver writing to driver under the directories
+// given by the template path, or DefaultPath if empty, in which
+// "{repository}", "{algorithm}" and "{hex}" stand for the repository name
+// and the algorithm and hex of the digest of the manifest. It queues up to
+// queueSize pushes, or DefaultQueueSize if not positive, and retries failed
+// writes up to maxRetries times, or DefaultMaxRetries if not positive. The
+// Archiver runs until Close is called.
+func NewArchiver(ctx context.Context, driver storagedriver.StorageDriver, path string, queueSize, maxRetries int) (*Archiver, error) {
+	if path == "" {
+		path = DefaultPath
+	}
+	if !strings.HasPrefix(path, "/") {
+		return nil, errors.New("archive path must be absolute")
+	}
+	if !strings.Contains(path, "{hex}") {
+		return nil, errors.New("archive path must contain {hex}")
+	}
+	if queueSize <= 0 {
+		queueSize = DefaultQueueSize
+	}
+	if maxRetries <= 0 {
+		maxRetries = DefaultMaxRetries
+	}
+	a := &Archiver{
+		driver:        driver,
+		path:          path,
+		maxRetries:    maxRetries,
+		retryInterval: defaultRetryInterval,
+		pushes:        make(chan push, queueSize),
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+	go a.run(context.WithoutCancel(ctx))
+	return a, nil
+}
+
+// Archive queues the archival of the manifest payload described by record.
+// It never blocks: the push is dropped if the queue is full.
+func (a *Archiver) Archive(record Record, payload []byte) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.closed {
+		a.drop()
+		return
+	}
+	select {
+	case a.pushes <- push{record: record, payload: payload}:
+		pendingGauge.Inc(1)
+	default:
+		a.drop()
+	}
+}
+
+// Dropped returns the number of pushes not archived, because the queue was
+// full or the archive driver kept failing.
+func (a *Archiver) Dropped() int64 {
+	return a.dropped.Load()
+}
+
+// Close stops the Archiver once the queued pushes are archived. Failed
+// writes are not retried once closed, and pushes archived after Close are
+// dropped.
+func (a *Archiver) Close() {
+	a.mu.Lock()
+	if !a.closed {
+		a.closed = true
+		close(a.stop)
+		close(a.pushes)
+	}
+	a.mu.Unlock()
+	<-a.done
+}
+
+// Dir returns the directory of the archived manifest dgst of repository.
+func (a *Archiver) Dir(repository string, dgst digest.Digest) string {
+	return path.Clean(strings.NewReplacer(
+		"{repository}", repository,
+		"{algorithm}", dgst.Algorithm().String(),
+		"{hex}", dgst.Encoded(),
+	).Replace(a.path))
+}
+
+func (a *Archiver) drop() {
+	a.dropped.Add(1)
+	failuresCounter.WithValues("dropped").Inc(1)
+}
+
+func (a *Archiver) run(ctx context.Context) {
+	defer close(a.done)
+
+	for p := range a.pushes {
+		pendingGauge.Dec(1)
+		a.archive(ctx, p)
+	}
+}
+
+// archive writes p, retrying with an exponential backoff.
+func (a *Archiver) archive(ctx context.Context, p push) {
+	log := dcontext.GetLoggerWithFields(ctx, map[any]any{
+		"repository": p.record.Repository,
+		"digest":     p.record.Digest,
+	})
+
+	delay := a.retryInterval
+	for attempt := 0; ; attempt++ {
+		err := a.write(ctx, p)
+		if err == nil {
+			lagTimer.UpdateSince(p.record.Timestamp)
+			return
+		}
+		if attempt >= a.maxRetries {
+			log.Errorf("dropping archive of manifest after %d attempts: %v", attempt+1, err)
+			a.drop()
+			return
+		}
+		failuresCounter.WithValues("retried").Inc(1)
+		log.Warnf("archiving manifest failed, retrying in %s: %v", delay, err)
+
+		select {
+		case <-time.After(delay):
+		case <-a.stop:
+			log.Errorf("dropping archive of manifest on shutdown: %v", err)
+			a.drop()
+			return
+		}
+		delay = min(2*delay, maxRetryInterval)
+	}
+}
+
+// write stores the payload of p, unless already archived by a previous
+// push, and the record of p.
+func (a *Archiver) write(ctx context.Context, p push) error {
+	ctx, cancel := context.WithTimeout(ctx, writeTimeout)
+	defer cancel()
+
+	dir := a.Dir(p.record.Repository, p.record.Digest)
+	dataPath := path.Join(dir, "data")
+	if _, err := a.driver.Stat(ctx, dataPath); err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+			return err
+		}
+		if err := a.driver.PutContent(ctx, dataPath, p.payload); err != nil {
+			return err
+		}
+	}
+
+	record, err := json.Marshal(p.record)
+	if err != nil {
+		return err
+	}
+	name := p.record.Timestamp.UTC().Format(timestampFormat) + ".json"
+	return a.driver.PutContent(ctx, path.Join(dir, "pushes", name), record)
+}
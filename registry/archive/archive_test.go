@@ -0,0 +1,167 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"path"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/opencontainers/go-digest"
+)
+
+// failingDriver fails the writes of its driver until failures is
+// decremented to zero, or always if negative.
+type failingDriver struct {
+	storagedriver.StorageDriver
+	failures atomic.Int64
+}
+
+func (d *failingDriver) PutContent(ctx context.Context, path string, content []byte) error {
+	if n := d.failures.Load(); n < 0 || n > 0 && d.failures.Add(-1) >= 0 {
+		return errors.New("archive unavailable")
+	}
+	return d.StorageDriver.PutContent(ctx, path, content)
+}
+
+func testRecord(payload []byte, timestamp time.Time) Record {
+	return Record{
+		Repository: "foo/bar",
+		Digest:     digest.FromBytes(payload),
+		MediaType:  "application/vnd.oci.image.manifest.v1+json",
+		Tags:       []string{"latest"},
+		Actor:      "alice",
+		Timestamp:  timestamp,
+	}
+}
+
+// checkArchived checks that the payload and the records of its pushes are
+// archived in driver.
+func checkArchived(t *testing.T, a *Archiver, driver storagedriver.StorageDriver, payload []byte, records ...Record) {
+	t.Helper()
+	ctx := context.Background()
+
+	dir := a.Dir(records[0].Repository, records[0].Digest)
+	archived, err := driver.GetContent(ctx, path.Join(dir, "data"))
+	if err != nil {
+		t.Fatalf("reading archived payload: %v", err)
+	}
+	if !bytes.Equal(archived, payload) {
+		t.Fatalf("unexpected archived payload %q, expected %q", archived, payload)
+	}
+
+	names, err := driver.List(ctx, path.Join(dir, "pushes"))
+	if err != nil {
+		t.Fatalf("listing archived pushes: %v", err)
+	}
+	if len(names) != len(records) {
+		t.Fatalf("unexpected archived pushes %v, expected %d", names, len(records))
+	}
+	for _, expected := range records {
+		content, err := driver.GetContent(ctx, path.Join(dir, "pushes", expected.Timestamp.Format(timestampFormat)+".json"))
+		if err != nil {
+			t.Fatalf("reading archived push: %v", err)
+		}
+		var record Record
+		if err := json.Unmarshal(content, &record); err != nil {
+			t.Fatalf("decoding archived push: %v", err)
+		}
+		if !reflect.DeepEqual(record, expected) {
+			t.Fatalf("unexpected archived push %+v, expected %+v", record, expected)
+		}
+	}
+}
+
+func TestArchive(t *testing.T) {
+	driver := inmemory.New()
+	a, err := NewArchiver(context.Background(), driver, "/archive/{algorithm}/{hex}/{repository}", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload := []byte(`{"schemaVersion":2}`)
+	now := time.Now().UTC()
+	first := testRecord(payload, now)
+	second := testRecord(payload, now.Add(time.Second))
+	second.Tags = []string{"stable"}
+	second.Actor = ""
+	a.Archive(first, payload)
+	a.Archive(second, payload)
+	a.Close()
+
+	if dir := a.Dir(first.Repository, first.Digest); dir != "/archive/sha256/"+first.Digest.Encoded()+"/foo/bar" {
+		t.Fatalf("unexpected archive directory %q", dir)
+	}
+	checkArchived(t, a, driver, payload, first, second)
+
+	a.Archive(testRecord(payload, now), payload)
+	if a.Dropped() != 1 {
+		t.Fatalf("expected the push archived once closed to be dropped, got %d dropped", a.Dropped())
+	}
+}
+
+func TestArchiveRetries(t *testing.T) {
+	driver := &failingDriver{StorageDriver: inmemory.New()}
+	driver.failures.Store(2)
+	a, err := NewArchiver(context.Background(), driver, "", 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.retryInterval = time.Millisecond
+
+	payload := []byte(`{"schemaVersion":2}`)
+	record := testRecord(payload, time.Now().UTC())
+	a.Archive(record, payload)
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if _, err := driver.Stat(context.Background(), path.Join(a.Dir(record.Repository, record.Digest), "pushes")); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the push to be archived once the driver recovers")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	a.Close()
+
+	checkArchived(t, a, driver, payload, record)
+	if a.Dropped() != 0 {
+		t.Fatalf("unexpected dropped pushes: %d", a.Dropped())
+	}
+}
+
+func TestArchiveDriverErrors(t *testing.T) {
+	driver := &failingDriver{StorageDriver: inmemory.New()}
+	driver.failures.Store(-1)
+	a, err := NewArchiver(context.Background(), driver, "", 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.retryInterval = time.Millisecond
+
+	// archiving never blocks, however many pushes fail
+	payload := []byte(`{"schemaVersion":2}`)
+	for range 100 {
+		a.Archive(testRecord(payload, time.Now().UTC()), payload)
+	}
+	a.Close()
+
+	if a.Dropped() != 100 {
+		t.Fatalf("expected all pushes to be dropped, got %d dropped", a.Dropped())
+	}
+}
+
+func TestInvalidPath(t *testing.T) {
+	for _, path := range []string{"{repository}/{hex}", "/{repository}"} {
+		if _, err := NewArchiver(context.Background(), inmemory.New(), path, 0, 0); err == nil {
+			t.Fatalf("expected path %q to be invalid", path)
+		}
+	}
+}
@@ -32,9 +32,12 @@
 package auth
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
+	"sort"
+	"sync"
 )
 
 var (
@@ -43,6 +46,12 @@ var (
 
 	// ErrAuthenticationFailure returned when authentication fails.
 	ErrAuthenticationFailure = errors.New("authentication failure")
+
+	// ErrAccessDenied is wrapped by the challenges refusing valid
+	// credentials an access their authority decided to deny, as opposed to
+	// credentials missing, invalid or not covering the access yet, which the
+	// client may still obtain by authenticating again.
+	ErrAccessDenied = errors.New("access denied")
 )
 
 // InitFunc is the type of an AccessController factory function and is used
@@ -68,17 +77,52 @@ type Resource struct {
 	Name  string
 }
 
+// TagParameter is the access parameter naming the tag of a manifest pulled
+// by tag.
+const TagParameter = "tag"
+
 // Access describes a specific action that is
 // requested or allowed for a given resource.
 type Access struct {
 	Resource
 	Action string
+
+	// Parameters narrow down the action, such as the tag of a manifest
+	// pulled by tag, encoded by EncodeParameters so that Access stays
+	// comparable. Access to the action without parameters covers the
+	// action with any parameters, so access controllers may ignore them.
+	Parameters string
+}
+
+// EncodeParameters encodes parameters as the Parameters of an Access, in the
+// form ":key=value" with parameters sorted by key. It is empty if there are
+// no parameters.
+func EncodeParameters(parameters map[string]string) string {
+	keys := make([]string, 0, len(parameters))
+	for key := range parameters {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var encoded string
+	for _, key := range keys {
+		encoded += ":" + key + "=" + parameters[key]
+	}
+	return encoded
+}
+
+// ParameterizedAction returns the action followed by its parameters, in the
+// form "action:key=value". It is the action alone if the access has no
+// parameters.
+func (a Access) ParameterizedAction() string {
+	return a.Action + a.Parameters
 }
 
 // Grant describes the permitted level of access for an authorized request.
 type Grant struct {
-	User      UserInfo   // The authenticated user for the request.
-	Resources []Resource // The list of resources which have been authorized for the request.
+	User      UserInfo       // The authenticated user for the request.
+	Resources []Resource     // The list of resources which have been authorized for the request.
+	Claims    map[string]any // The claims of the credential of the request, if any, such as the claims of a token.
 }
 
 // Challenge is a special error type which is used for HTTP 401 Unauthorized
@@ -135,3 +179,49 @@ func GetAccessController(name string, options map[string]any) (AccessController,
 
 	return nil, fmt.Errorf("no access controller registered with name: %s", name)
 }
+
+// RequestCache holds what access controllers computed to check the access of
+// a request, such as the claims of verified credentials, for the other access
+// checks of the request.
+type RequestCache struct {
+	mu     sync.Mutex
+	values map[any]any
+}
+
+type requestCacheKey struct{}
+
+// WithRequestCache returns a context carrying a new RequestCache, to be
+// used for the access checks of a single request.
+func WithRequestCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, requestCacheKey{}, &RequestCache{})
+}
+
+// GetRequestCache returns the RequestCache of ctx, or nil if it has none.
+func GetRequestCache(ctx context.Context) *RequestCache {
+	cache, _ := ctx.Value(requestCacheKey{}).(*RequestCache)
+	return cache
+}
+
+// Load returns the value cached for key. A nil cache holds no value.
+func (c *RequestCache) Load(key any) (any, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	value, ok := c.values[key]
+	return value, ok
+}
+
+// Store caches value for key. Storing in a nil cache does nothing.
+func (c *RequestCache) Store(key, value any) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.values == nil {
+		c.values = make(map[any]any)
+	}
+	c.values[key] = value
+}
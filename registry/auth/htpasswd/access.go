@@ -9,6 +9,7 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
@@ -23,6 +24,10 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// errDeleteNotAllowed is returned when a user requests the delete action,
+// which is only granted when the allowdelete option is set.
+var errDeleteNotAllowed = errors.New("delete not allowed")
+
 func init() {
 	if err := auth.Register("htpasswd", auth.InitFunc(newAccessController)); err != nil {
 		logrus.Errorf("failed to register htpasswd auth: %v", err)
@@ -36,6 +41,9 @@ type accessController struct {
 	mu       sync.Mutex
 	htpasswd *htpasswd
 
+	// allowDelete grants the delete action to the authenticated users.
+	allowDelete bool
+
 	// overrideDummyHash allows overriding the dummy-hash for testing.
 	overrideDummyHash []byte
 }
@@ -56,13 +64,21 @@ func newAccessController(options map[string]any) (auth.AccessController, error)
 	if err := createHtpasswdFile(path); err != nil {
 		return nil, err
 	}
+	allowDelete := false
+	if v, present := options["allowdelete"]; present {
+		b, ok := v.(bool)
+		if !ok {
+			return nil, fmt.Errorf(`"allowdelete" must be a boolean for htpasswd access controller`)
+		}
+		allowDelete = b
+	}
 	var dummyHash []byte
 	if hash, ok := options["overrideDummyHash"]; ok {
 		// override dummy hash for testing
 		dummyHash = hash.([]byte)
 	}
 
-	return &accessController{realm: realm.(string), path: path, overrideDummyHash: dummyHash}, nil
+	return &accessController{realm: realm.(string), path: path, allowDelete: allowDelete, overrideDummyHash: dummyHash}, nil
 }
 
 func (ac *accessController) Authorized(req *http.Request, accessRecords ...auth.Access) (*auth.Grant, error) {
@@ -109,6 +125,17 @@ func (ac *accessController) Authorized(req *http.Request, accessRecords ...auth.
 		}
 	}
 
+	if !ac.allowDelete {
+		for _, access := range accessRecords {
+			if access.Action == "delete" {
+				return nil, &challenge{
+					realm: ac.realm,
+					err:   errDeleteNotAllowed,
+				}
+			}
+		}
+	}
+
 	return &auth.Grant{User: auth.UserInfo{Name: username}}, nil
 }
 
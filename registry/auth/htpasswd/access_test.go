@@ -135,6 +135,53 @@ func TestBasicAccessController(t *testing.T) {
 	}
 }
 
+func TestDeleteAccess(t *testing.T) {
+	tempFile := filepath.Join(t.TempDir(), "htpasswd")
+	// frodo:baggins
+	err := os.WriteFile(tempFile, []byte("frodo:$2y$05$926C3y10Quzn/LnqQH86VOEVh/18T6RnLaS.khre96jLNL/7e.K5W"), 0600)
+	if err != nil {
+		t.Fatal("could not write temporary htpasswd file")
+	}
+
+	resource := auth.Resource{Type: "repository", Name: "foo/bar"}
+	for _, tc := range []struct {
+		options map[string]any
+		action  string
+		allowed bool
+	}{
+		{action: "push", allowed: true},
+		{action: "delete", allowed: false},
+		{options: map[string]any{"allowdelete": false}, action: "delete", allowed: false},
+		{options: map[string]any{"allowdelete": true}, action: "delete", allowed: true},
+	} {
+		options := map[string]any{"realm": "The-Shire", "path": tempFile}
+		for k, v := range tc.options {
+			options[k] = v
+		}
+		accessCtrl, err := newAccessController(options)
+		if err != nil {
+			t.Fatalf("error creating access controller: %v", err)
+		}
+
+		req := httptest.NewRequest(http.MethodDelete, "/v2/foo/bar/manifests/latest", nil)
+		req.SetBasicAuth("frodo", "baggins")
+		grant, err := accessCtrl.Authorized(req, auth.Access{Resource: resource, Action: tc.action})
+		if tc.allowed {
+			if err != nil || grant == nil {
+				t.Fatalf("%v: expected %s to be allowed, got %v", tc.options, tc.action, err)
+			}
+			continue
+		}
+		if _, ok := err.(auth.Challenge); !ok {
+			t.Fatalf("%v: expected a challenge refusing %s, got %v", tc.options, tc.action, err)
+		}
+	}
+
+	if _, err := newAccessController(map[string]any{"realm": "The-Shire", "path": tempFile, "allowdelete": "yes"}); err == nil {
+		t.Fatal("expected a non-boolean allowdelete to be refused")
+	}
+}
+
 func TestCreateHtpasswdFile(t *testing.T) {
 	tempFile := filepath.Join(t.TempDir(), "htpasswd")
 	if err := os.WriteFile(tempFile, []byte{}, 0600); err != nil {
@@ -11,6 +11,7 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"sort"
 	"strings"
 
 	"github.com/distribution/distribution/v3/registry/auth"
@@ -47,16 +48,25 @@ func newAccessSet(accessItems ...auth.Access) accessSet {
 		}
 
 		set.add(access.Action)
+		if len(access.Parameters) > 0 {
+			set.add(access.ParameterizedAction())
+		}
 	}
 
 	return accessSet
 }
 
 // contains returns whether or not the given access is in this accessSet.
+// An access with parameters is contained if either its action or its
+// parameterized action is, so that tokens granting the action keep granting
+// it whatever its parameters.
 func (s accessSet) contains(access auth.Access) bool {
 	actionSet, ok := s[access.Resource]
 	if ok {
-		return actionSet.contains(access.Action)
+		if actionSet.contains(access.Action) {
+			return true
+		}
+		return len(access.Parameters) > 0 && actionSet.contains(access.ParameterizedAction())
 	}
 
 	return false
@@ -65,12 +75,42 @@ func (s accessSet) contains(access auth.Access) bool {
 // scopeParam returns a collection of scopes which can
 // be used for a WWW-Authenticate challenge parameter.
 // See https://tools.ietf.org/html/rfc6750#section-3
+//
+// Parameterized actions, such as "pull:tag=latest", are requested in scopes
+// of their own, so that token servers unaware of parameters still grant the
+// plain actions. Every resource of the set is requested, ordered by type and
+// name, so that a request needing access to several repositories, such as a
+// cross-repository mount, is satisfied with a single token.
 func (s accessSet) scopeParam() string {
+	resources := make([]auth.Resource, 0, len(s))
+	for resource := range s {
+		resources = append(resources, resource)
+	}
+	sort.Slice(resources, func(i, j int) bool {
+		if resources[i].Type != resources[j].Type {
+			return resources[i].Type < resources[j].Type
+		}
+		return resources[i].Name < resources[j].Name
+	})
+
 	scopes := make([]string, 0, len(s))
+	for _, resource := range resources {
+		actionSet := s[resource]
+		var actions, parameterized []string
+		for _, action := range actionSet.keys() {
+			if strings.Contains(action, ":") {
+				parameterized = append(parameterized, action)
+			} else {
+				actions = append(actions, action)
+			}
+		}
+		sort.Strings(actions)
+		sort.Strings(parameterized)
 
-	for resource, actionSet := range s {
-		actions := strings.Join(actionSet.keys(), ",")
-		scopes = append(scopes, fmt.Sprintf("%s:%s:%s", resource.Type, resource.Name, actions))
+		scopes = append(scopes, fmt.Sprintf("%s:%s:%s", resource.Type, resource.Name, strings.Join(actions, ",")))
+		for _, action := range parameterized {
+			scopes = append(scopes, fmt.Sprintf("%s:%s:%s", resource.Type, resource.Name, action))
+		}
 	}
 
 	return strings.Join(scopes, " ")
@@ -90,6 +130,11 @@ type authChallenge struct {
 	autoRedirectPath string
 	service          string
 	accessSet        accessSet
+	// basicExchange is set if basic credentials are exchanged for tokens.
+	basicExchange bool
+	// denied is set if the token was denied every action on one of the
+	// resources requested.
+	denied bool
 }
 
 var _ auth.Challenge = authChallenge{}
@@ -99,6 +144,15 @@ func (ac authChallenge) Error() string {
 	return ac.err.Error()
 }
 
+// Unwrap returns the error of the challenge, along with auth.ErrAccessDenied
+// if the token server denied the access requested.
+func (ac authChallenge) Unwrap() []error {
+	if ac.denied {
+		return []error{ac.err, auth.ErrAccessDenied}
+	}
+	return []error{ac.err}
+}
+
 // Status returns the HTTP Response Status Code for this authChallenge.
 func (ac authChallenge) Status() int {
 	return http.StatusUnauthorized
@@ -145,9 +199,14 @@ func (ac authChallenge) challengeParams(r *http.Request) string {
 	return str
 }
 
-// SetHeaders sets the WWW-Authenticate value for the response.
+// SetHeaders sets the WWW-Authenticate value for the response, and a basic
+// challenge for the clients unable to request tokens if basic credentials are
+// exchanged for tokens.
 func (ac authChallenge) SetHeaders(r *http.Request, w http.ResponseWriter) {
 	w.Header().Add("WWW-Authenticate", ac.challengeParams(r))
+	if ac.basicExchange {
+		w.Header().Add("WWW-Authenticate", fmt.Sprintf("Basic realm=%q", ac.service))
+	}
 }
 
 // accessController implements the auth.AccessController interface.
@@ -160,6 +219,10 @@ type accessController struct {
 	rootCerts         *x509.CertPool
 	trustedKeys       map[string]crypto.PublicKey
 	signingAlgorithms []jose.SignatureAlgorithm
+	// cache is nil unless the claims of verified tokens are cached.
+	cache *claimsCache
+	// exchange is nil unless basic credentials are exchanged for tokens.
+	exchange *basicExchange
 }
 
 const (
@@ -177,6 +240,8 @@ type tokenAccessOptions struct {
 	rootCertBundle    string
 	jwks              string
 	signingAlgorithms []string
+	cache             *cacheOptions
+	basicExchange     *exchangeOptions
 }
 
 // checkOptions gathers the necessary options
@@ -244,12 +309,25 @@ func checkOptions(options map[string]any) (tokenAccessOptions, error) {
 		}
 	}
 
+	cache, err := parseCacheOptions(options["cache"])
+	if err != nil {
+		return tokenAccessOptions{}, err
+	}
+	opts.cache = cache
+
+	basicExchange, err := parseExchangeOptions(options["basicexchange"])
+	if err != nil {
+		return tokenAccessOptions{}, err
+	}
+	opts.basicExchange = basicExchange
+
 	return opts, nil
 }
 
 var (
-	rootCertFetcher func(string) ([]*x509.Certificate, error) = getRootCerts
-	jwkFetcher      func(string) (*jose.JSONWebKeySet, error) = getJwks
+	rootCertFetcher func(string) ([]*x509.Certificate, error)      = getRootCerts
+	jwkFetcher      func(string) (*jose.JSONWebKeySet, error)      = getJwks
+	tokenVerifier   func(*Token, VerifyOptions) (*ClaimSet, error) = (*Token).Verify
 )
 
 func getRootCerts(path string) ([]*x509.Certificate, error) {
@@ -373,6 +451,20 @@ func newAccessController(options map[string]any) (auth.AccessController, error)
 		signAlgos = defaultSigningAlgorithms
 	}
 
+	var cache *claimsCache
+	if config.cache != nil {
+		if cache, err = newClaimsCache(*config.cache); err != nil {
+			return nil, err
+		}
+	}
+
+	var exchange *basicExchange
+	if config.basicExchange != nil {
+		if exchange, err = newBasicExchange(*config.basicExchange); err != nil {
+			return nil, err
+		}
+	}
+
 	return &accessController{
 		realm:             config.realm,
 		autoRedirect:      config.autoRedirect,
@@ -382,6 +474,8 @@ func newAccessController(options map[string]any) (auth.AccessController, error)
 		rootCerts:         rootPool,
 		trustedKeys:       trustedKeys,
 		signingAlgorithms: signAlgos,
+		cache:             cache,
+		exchange:          exchange,
 	}, nil
 }
 
@@ -394,28 +488,27 @@ func (ac *accessController) Authorized(req *http.Request, accessItems ...auth.Ac
 		autoRedirectPath: ac.autoRedirectPath,
 		service:          ac.service,
 		accessSet:        newAccessSet(accessItems...),
+		basicExchange:    ac.exchange != nil,
 	}
 
 	prefix, rawToken, ok := strings.Cut(req.Header.Get("Authorization"), " ")
-	if !ok || rawToken == "" || !strings.EqualFold(prefix, "bearer") {
+	switch {
+	case ok && rawToken != "" && strings.EqualFold(prefix, "bearer"):
+	case ok && rawToken != "" && strings.EqualFold(prefix, "basic") && ac.exchange != nil:
+		// The credentials are only forwarded to the configured token server,
+		// never to a realm derived from the headers of the request.
+		var err error
+		rawToken, err = ac.exchange.token(req, ac.realm, ac.service, challenge.accessSet.scopeParam())
+		if err != nil {
+			challenge.err = ErrTokenExchange
+			return nil, challenge
+		}
+	default:
 		challenge.err = ErrTokenRequired
 		return nil, challenge
 	}
 
-	token, err := NewToken(rawToken, ac.signingAlgorithms)
-	if err != nil {
-		challenge.err = err
-		return nil, challenge
-	}
-
-	verifyOpts := VerifyOptions{
-		TrustedIssuers:    []string{ac.issuer},
-		AcceptedAudiences: []string{ac.service},
-		Roots:             ac.rootCerts,
-		TrustedKeys:       ac.trustedKeys,
-	}
-
-	claims, err := token.Verify(verifyOpts)
+	claims, err := ac.verify(req, rawToken)
 	if err != nil {
 		challenge.err = err
 		return nil, challenge
@@ -425,6 +518,11 @@ func (ac *accessController) Authorized(req *http.Request, accessItems ...auth.Ac
 	for _, access := range accessItems {
 		if !accessSet.contains(access) {
 			challenge.err = ErrInsufficientScope
+			// the token server lists the resources it was asked for, even
+			// without granting any action on them
+			if actions, ok := accessSet[access.Resource]; ok && len(actions.stringSet) == 0 {
+				challenge.denied = true
+			}
 			return nil, challenge
 		}
 	}
@@ -432,5 +530,51 @@ func (ac *accessController) Authorized(req *http.Request, accessItems ...auth.Ac
 	return &auth.Grant{
 		User:      auth.UserInfo{Name: claims.Subject},
 		Resources: claims.resources(),
+		Claims:    claims.Raw,
 	}, nil
 }
+
+// requestClaimsKey is the key of the claims of the tokens verified for a
+// request in its auth.RequestCache.
+type requestClaimsKey struct {
+	rawToken string
+}
+
+// verify returns the claims of the verified token rawToken. The claims are
+// looked up in the cache of the request first, then in the cache of the
+// verified tokens, if enabled.
+func (ac *accessController) verify(req *http.Request, rawToken string) (*ClaimSet, error) {
+	requestCache := auth.GetRequestCache(req.Context())
+	if claims, ok := requestCache.Load(requestClaimsKey{rawToken}); ok {
+		return claims.(*ClaimSet), nil
+	}
+	if ac.cache != nil {
+		if claims, ok := ac.cache.get(rawToken); ok {
+			requestCache.Store(requestClaimsKey{rawToken}, claims)
+			return claims, nil
+		}
+	}
+
+	token, err := NewToken(rawToken, ac.signingAlgorithms)
+	if err != nil {
+		return nil, err
+	}
+
+	verifyOpts := VerifyOptions{
+		TrustedIssuers:    []string{ac.issuer},
+		AcceptedAudiences: []string{ac.service},
+		Roots:             ac.rootCerts,
+		TrustedKeys:       ac.trustedKeys,
+	}
+
+	claims, err := tokenVerifier(token, verifyOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	requestCache.Store(requestClaimsKey{rawToken}, claims)
+	if ac.cache != nil {
+		ac.cache.add(rawToken, claims)
+	}
+	return claims, nil
+}
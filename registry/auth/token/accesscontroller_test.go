@@ -2,6 +2,7 @@ package token
 
 import (
 	"testing"
+	"time"
 
 	"crypto/rand"
 	"crypto/rsa"
@@ -177,3 +178,50 @@ func TestJWKSIncludedInTrustedKeys(t *testing.T) {
 		t.Fatalf("Unexpected number of trusted keys, expected 1 got: %d", got)
 	}
 }
+
+func TestCheckCacheOptions(t *testing.T) {
+	options := map[string]any{
+		"realm":   "https://auth.example.com/token/",
+		"issuer":  "test-issuer.example.com",
+		"service": "test-service.example.com",
+	}
+
+	ta, err := checkOptions(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ta.cache != nil {
+		t.Fatal("cache should be disabled by default")
+	}
+
+	options["cache"] = map[any]any{"size": 10, "maxttl": "30s"}
+	ta, err = checkOptions(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ta.cache == nil || ta.cache.size != 10 || ta.cache.maxTTL != 30*time.Second {
+		t.Fatalf("unexpected cache options: %+v", ta.cache)
+	}
+
+	options["cache"] = map[string]any{}
+	ta, err = checkOptions(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ta.cache == nil || ta.cache.size != defaultCacheSize || ta.cache.maxTTL != defaultCacheMaxTTL {
+		t.Fatalf("unexpected default cache options: %+v", ta.cache)
+	}
+
+	for _, cache := range []any{
+		true,
+		map[string]any{"size": 0},
+		map[string]any{"size": "10"},
+		map[string]any{"maxttl": "forever"},
+		map[string]any{"maxttl": "-1m"},
+	} {
+		options["cache"] = cache
+		if _, err := checkOptions(options); err == nil {
+			t.Errorf("expected an error with cache options %v", cache)
+		}
+	}
+}
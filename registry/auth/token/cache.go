@@ -0,0 +1,131 @@
+package token
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/golang-lru/v2/simplelru"
+)
+
+const (
+	defaultCacheSize   = 1024
+	defaultCacheMaxTTL = time.Minute
+)
+
+// cacheOptions configures the cache of the claims of verified tokens.
+type cacheOptions struct {
+	size   int
+	maxTTL time.Duration
+}
+
+// parseCacheOptions parses the cache option, a map of the size and maxttl
+// options. The cache is disabled if the option is not set.
+func parseCacheOptions(option any) (*cacheOptions, error) {
+	var options map[string]any
+	switch v := option.(type) {
+	case nil:
+		return nil, nil
+	case map[string]any:
+		options = v
+	case map[any]any:
+		options = make(map[string]any, len(v))
+		for key, value := range v {
+			options[fmt.Sprint(key)] = value
+		}
+	default:
+		return nil, errors.New("token auth requires a valid option map: cache")
+	}
+
+	opts := &cacheOptions{size: defaultCacheSize, maxTTL: defaultCacheMaxTTL}
+	switch v := options["size"].(type) {
+	case nil:
+	case int:
+		opts.size = v
+	default:
+		return nil, errors.New("token auth requires a valid option int: cache.size")
+	}
+	if opts.size <= 0 {
+		return nil, fmt.Errorf("token auth requires a positive cache.size: %d", opts.size)
+	}
+	switch v := options["maxttl"].(type) {
+	case nil:
+	case time.Duration:
+		opts.maxTTL = v
+	case string:
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("token auth requires a valid option duration: cache.maxttl: %v", err)
+		}
+		opts.maxTTL = d
+	default:
+		return nil, errors.New("token auth requires a valid option duration: cache.maxttl")
+	}
+	if opts.maxTTL <= 0 {
+		return nil, fmt.Errorf("token auth requires a positive cache.maxttl: %v", opts.maxTTL)
+	}
+	return opts, nil
+}
+
+// cachedClaims are the claims of a verified token, valid until expires.
+type cachedClaims struct {
+	claims  *ClaimSet
+	expires time.Time
+}
+
+// claimsCache caches the claims of verified tokens by the hash of the whole
+// token, so that a token only matches itself. Claims are cached until the
+// token expires, and for at most maxTTL so that tokens revoked by the token
+// server stop being honored after maxTTL.
+type claimsCache struct {
+	maxTTL time.Duration
+	now    func() time.Time
+
+	mu  sync.Mutex
+	lru *simplelru.LRU[[sha256.Size]byte, cachedClaims]
+}
+
+func newClaimsCache(opts cacheOptions) (*claimsCache, error) {
+	lru, err := simplelru.NewLRU[[sha256.Size]byte, cachedClaims](opts.size, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &claimsCache{maxTTL: opts.maxTTL, now: time.Now, lru: lru}, nil
+}
+
+// get returns the claims cached for rawToken, unless they expired.
+func (c *claimsCache) get(rawToken string) (*ClaimSet, bool) {
+	key := sha256.Sum256([]byte(rawToken))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cached, ok := c.lru.Get(key)
+	if !ok {
+		return nil, false
+	}
+	if !c.now().Before(cached.expires) {
+		c.lru.Remove(key)
+		return nil, false
+	}
+	return cached.claims, true
+}
+
+// add caches the claims of the verified token rawToken.
+func (c *claimsCache) add(rawToken string, claims *ClaimSet) {
+	now := c.now()
+	expires := now.Add(c.maxTTL)
+	if exp := time.Unix(claims.Expiration, 0).Add(Leeway); exp.Before(expires) {
+		expires = exp
+	}
+	if !now.Before(expires) {
+		return
+	}
+
+	key := sha256.Sum256([]byte(rawToken))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lru.Add(key, cachedClaims{claims: claims, expires: expires})
+}
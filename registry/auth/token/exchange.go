@@ -0,0 +1,230 @@
+package token
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/hashicorp/golang-lru/v2/simplelru"
+)
+
+const (
+	defaultExchangeTimeout = 10 * time.Second
+	// defaultExchangedTokenTTL is the lifetime of the tokens issued without
+	// expires_in, as assumed by the token specification.
+	defaultExchangedTokenTTL = 60 * time.Second
+)
+
+// ErrTokenExchange is returned when the basic credentials of a request
+// could not be exchanged for a token.
+var ErrTokenExchange = errors.New("basic credentials could not be exchanged for a token")
+
+// exchangeOptions configures the exchange of basic credentials for tokens.
+type exchangeOptions struct {
+	// url is the token server endpoint, the realm if empty.
+	url     string
+	timeout time.Duration
+	cache   cacheOptions
+}
+
+// parseExchangeOptions parses the basicexchange option, a map of the url,
+// timeout and cache options. Basic credentials are not exchanged if the
+// option is not set.
+func parseExchangeOptions(option any) (*exchangeOptions, error) {
+	var options map[string]any
+	switch v := option.(type) {
+	case nil:
+		return nil, nil
+	case map[string]any:
+		options = v
+	case map[any]any:
+		options = make(map[string]any, len(v))
+		for key, value := range v {
+			options[fmt.Sprint(key)] = value
+		}
+	default:
+		return nil, errors.New("token auth requires a valid option map: basicexchange")
+	}
+
+	opts := &exchangeOptions{
+		timeout: defaultExchangeTimeout,
+		cache:   cacheOptions{size: defaultCacheSize, maxTTL: defaultCacheMaxTTL},
+	}
+	switch v := options["url"].(type) {
+	case nil:
+	case string:
+		if _, err := url.Parse(v); err != nil {
+			return nil, fmt.Errorf("token auth requires a valid option url: basicexchange.url: %v", err)
+		}
+		opts.url = v
+	default:
+		return nil, errors.New("token auth requires a valid option string: basicexchange.url")
+	}
+	switch v := options["timeout"].(type) {
+	case nil:
+	case time.Duration:
+		opts.timeout = v
+	case string:
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("token auth requires a valid option duration: basicexchange.timeout: %v", err)
+		}
+		opts.timeout = d
+	default:
+		return nil, errors.New("token auth requires a valid option duration: basicexchange.timeout")
+	}
+	if opts.timeout <= 0 {
+		return nil, fmt.Errorf("token auth requires a positive basicexchange.timeout: %v", opts.timeout)
+	}
+	cache, err := parseCacheOptions(options["cache"])
+	if err != nil {
+		return nil, fmt.Errorf("basicexchange: %v", err)
+	}
+	if cache != nil {
+		opts.cache = *cache
+	}
+	return opts, nil
+}
+
+// cachedToken is a token obtained for basic credentials, valid until
+// expires.
+type cachedToken struct {
+	rawToken string
+	expires  time.Time
+}
+
+// basicExchange exchanges the basic credentials of requests for tokens of the
+// token server, for the clients only able to authenticate with basic
+// credentials. Tokens are cached by the hash of the credentials and of the
+// scope they were obtained for, until they expire, and for at most maxTTL.
+type basicExchange struct {
+	url    string
+	client *http.Client
+	maxTTL time.Duration
+	now    func() time.Time
+
+	mu  sync.Mutex
+	lru *simplelru.LRU[[sha256.Size]byte, cachedToken]
+}
+
+func newBasicExchange(opts exchangeOptions) (*basicExchange, error) {
+	lru, err := simplelru.NewLRU[[sha256.Size]byte, cachedToken](opts.cache.size, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &basicExchange{
+		url:    opts.url,
+		client: &http.Client{Timeout: opts.timeout},
+		maxTTL: opts.cache.maxTTL,
+		now:    time.Now,
+		lru:    lru,
+	}, nil
+}
+
+// tokenResponse is the response of the token server.
+type tokenResponse struct {
+	Token       string `json:"token"`
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// token returns a token of the token server at realm for the service and
+// scope, obtained with the basic credentials of req.
+func (e *basicExchange) token(req *http.Request, realm, service, scope string) (string, error) {
+	authorization := req.Header.Get("Authorization")
+	// the scopes of an access set, and their actions, are listed in no
+	// particular order
+	scopes := strings.Fields(scope)
+	slices.Sort(scopes)
+	key := sha256.Sum256([]byte(authorization + "\n" + service + "\n" + strings.Join(normalizeScopes(scopes), " ")))
+
+	e.mu.Lock()
+	cached, ok := e.lru.Get(key)
+	if ok && !e.now().Before(cached.expires) {
+		e.lru.Remove(key)
+		ok = false
+	}
+	e.mu.Unlock()
+	if ok {
+		return cached.rawToken, nil
+	}
+
+	endpoint := e.url
+	if endpoint == "" {
+		endpoint = realm
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	query := u.Query()
+	query.Set("service", service)
+	for _, s := range scopes {
+		query.Add("scope", s)
+	}
+	u.RawQuery = query.Encode()
+
+	tokenReq, err := http.NewRequestWithContext(req.Context(), http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	tokenReq.Header.Set("Authorization", authorization)
+	resp, err := e.client.Do(tokenReq)
+	if err != nil {
+		dcontext.GetLogger(req.Context()).Warnf("token exchange failed: %v", err)
+		return "", ErrTokenExchange
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		dcontext.GetLogger(req.Context()).Infof("token exchange rejected by the token server: %s", resp.Status)
+		return "", ErrTokenExchange
+	}
+	var tr tokenResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&tr); err != nil {
+		dcontext.GetLogger(req.Context()).Warnf("invalid token exchange response: %v", err)
+		return "", ErrTokenExchange
+	}
+	rawToken := tr.Token
+	if rawToken == "" {
+		rawToken = tr.AccessToken
+	}
+	if rawToken == "" {
+		dcontext.GetLogger(req.Context()).Warn("invalid token exchange response: no token")
+		return "", ErrTokenExchange
+	}
+
+	ttl := defaultExchangedTokenTTL
+	if tr.ExpiresIn > 0 {
+		ttl = time.Duration(tr.ExpiresIn) * time.Second
+	}
+	e.mu.Lock()
+	e.lru.Add(key, cachedToken{rawToken: rawToken, expires: e.now().Add(min(ttl, e.maxTTL))})
+	e.mu.Unlock()
+	return rawToken, nil
+}
+
+// normalizeScopes returns the sorted scopes with their actions sorted.
+func normalizeScopes(scopes []string) []string {
+	normalized := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		i := strings.LastIndex(scope, ":")
+		if i < 0 {
+			normalized = append(normalized, scope)
+			continue
+		}
+		actions := strings.Split(scope[i+1:], ",")
+		slices.Sort(actions)
+		normalized = append(normalized, scope[:i+1]+strings.Join(actions, ","))
+	}
+	slices.Sort(normalized)
+	return normalized
+}
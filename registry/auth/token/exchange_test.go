@@ -0,0 +1,251 @@
+package token
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/registry/auth"
+)
+
+func TestBasicExchange(t *testing.T) {
+	ac, jwk := newCachingAccessController(t, nil)
+
+	// the token server grants the requested scopes to alice, for tokens
+	// expiring in expiresIn seconds
+	var requests int
+	expiresIn := 300
+	failing := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if failing {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if user, password, ok := r.BasicAuth(); !ok || user != "alice" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if service := r.URL.Query().Get("service"); service != ac.service {
+			t.Errorf("unexpected service %q", service)
+		}
+		var actions []*ResourceActions
+		for _, scope := range r.URL.Query()["scope"] {
+			parts := strings.Split(scope, ":")
+			actions = append(actions, &ResourceActions{Type: parts[0], Name: parts[1], Actions: strings.Split(parts[2], ",")})
+		}
+		token, err := makeTestToken(jwk, ac.issuer, ac.service, actions, time.Now(), time.Now().Add(5*time.Minute))
+		if err != nil {
+			t.Error(err)
+		}
+		json.NewEncoder(w).Encode(map[string]any{"token": token.Raw, "expires_in": expiresIn})
+	}))
+	defer srv.Close()
+
+	exchange, err := newBasicExchange(exchangeOptions{
+		url:     srv.URL,
+		timeout: time.Second,
+		cache:   cacheOptions{size: 10, maxTTL: time.Minute},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	exchange.now = func() time.Time { return now }
+	ac.exchange = exchange
+
+	pull := auth.Access{Resource: auth.Resource{Type: "repository", Name: "foo/bar"}, Action: "pull"}
+	push := pull
+	push.Action = "push"
+	authorize := func(user, password string, access ...auth.Access) (*auth.Grant, error) {
+		req := httptest.NewRequest(http.MethodGet, "http://example.com/v2/foo/bar/blobs/sha256:abc", nil)
+		req.SetBasicAuth(user, password)
+		return ac.Authorized(req, access...)
+	}
+
+	grant, err := authorize("alice", "secret", pull, push)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if grant.User.Name != "foo" {
+		t.Fatalf("unexpected user %q", grant.User.Name)
+	}
+	if requests != 1 {
+		t.Fatalf("unexpected number of token requests: %d", requests)
+	}
+
+	// tokens are cached per credentials and scope, for at most the maximum
+	// time to live
+	for range 3 {
+		if _, err := authorize("alice", "secret", push, pull); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if requests != 1 {
+		t.Fatalf("unexpected number of token requests: %d", requests)
+	}
+	if _, err := authorize("alice", "secret", pull); err != nil {
+		t.Fatal(err)
+	}
+	if requests != 2 {
+		t.Fatalf("unexpected number of token requests: %d", requests)
+	}
+	now = now.Add(time.Minute)
+	if _, err := authorize("alice", "secret", pull); err != nil {
+		t.Fatal(err)
+	}
+	if requests != 3 {
+		t.Fatalf("unexpected number of token requests: %d", requests)
+	}
+
+	// and for at most the lifetime of the token
+	expiresIn = 10
+	if _, err := authorize("alice", "secret", push); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(10 * time.Second)
+	if _, err := authorize("alice", "secret", push); err != nil {
+		t.Fatal(err)
+	}
+	if requests != 5 {
+		t.Fatalf("unexpected number of token requests: %d", requests)
+	}
+
+	// rejected credentials and failures of the token server are challenged
+	for _, tc := range []struct {
+		name     string
+		password string
+		failing  bool
+	}{
+		{name: "bad credentials", password: "wrong"},
+		{name: "server failure", password: "secret", failing: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			failing = tc.failing
+			defer func() { failing = false }()
+			_, err := authorize("alice", tc.password, auth.Access{Resource: pull.Resource, Action: "delete"})
+			var challenge *authChallenge
+			if !errors.As(err, &challenge) || challenge.err != ErrTokenExchange {
+				t.Fatalf("expected a token exchange challenge, got %v", err)
+			}
+			w := httptest.NewRecorder()
+			challenge.SetHeaders(httptest.NewRequest(http.MethodGet, "/v2/", nil), w)
+			if challenge.Status() != http.StatusUnauthorized {
+				t.Fatalf("unexpected status %d", challenge.Status())
+			}
+			headers := w.Header().Values("WWW-Authenticate")
+			if len(headers) != 2 || !strings.HasPrefix(headers[0], "Bearer ") || headers[1] != `Basic realm="test-service.example.com"` {
+				t.Fatalf("unexpected challenges %q", headers)
+			}
+		})
+	}
+
+	// the scopes granted by the token server are checked
+	exchange.lru.Purge()
+	expiresIn = 300
+	srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, err := makeTestToken(jwk, ac.issuer, ac.service,
+			[]*ResourceActions{{Type: pull.Type, Name: pull.Name, Actions: []string{pull.Action}}},
+			time.Now(), time.Now().Add(5*time.Minute))
+		if err != nil {
+			t.Error(err)
+		}
+		json.NewEncoder(w).Encode(map[string]any{"access_token": token.Raw})
+	})
+	if _, err := authorize("alice", "secret", pull); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := authorize("alice", "secret", push); err == nil || err.Error() != ErrInsufficientScope.Error() {
+		t.Fatalf("expected insufficient scope error, got %v", err)
+	}
+}
+
+func TestParseExchangeOptions(t *testing.T) {
+	for _, tc := range []struct {
+		option   any
+		expected *exchangeOptions
+		err      bool
+	}{
+		{option: nil},
+		{
+			option:   map[string]any{},
+			expected: &exchangeOptions{timeout: defaultExchangeTimeout, cache: cacheOptions{size: defaultCacheSize, maxTTL: defaultCacheMaxTTL}},
+		},
+		{
+			option: map[any]any{"url": "https://auth.example.com/token", "timeout": "2s", "cache": map[string]any{"size": 10, "maxttl": "30s"}},
+			expected: &exchangeOptions{
+				url:     "https://auth.example.com/token",
+				timeout: 2 * time.Second,
+				cache:   cacheOptions{size: 10, maxTTL: 30 * time.Second},
+			},
+		},
+		{option: "yes", err: true},
+		{option: map[string]any{"url": 1}, err: true},
+		{option: map[string]any{"timeout": "soon"}, err: true},
+		{option: map[string]any{"timeout": "-1s"}, err: true},
+		{option: map[string]any{"cache": map[string]any{"size": -1}}, err: true},
+	} {
+		opts, err := parseExchangeOptions(tc.option)
+		if tc.err {
+			if err == nil {
+				t.Errorf("%v: expected an error", tc.option)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %v", tc.option, err)
+			continue
+		}
+		if (opts == nil) != (tc.expected == nil) || opts != nil && *opts != *tc.expected {
+			t.Errorf("%v: unexpected options %+v", tc.option, opts)
+		}
+	}
+}
+
+// TestBasicExchangeAutoRedirect checks that basic credentials are only
+// exchanged with the configured realm, not with the realm derived from the
+// headers of the request when autoredirect is enabled.
+func TestBasicExchangeAutoRedirect(t *testing.T) {
+	ac, jwk := newCachingAccessController(t, nil)
+
+	var forwarded int
+	attacker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded++
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer attacker.Close()
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, err := makeTestToken(jwk, ac.issuer, ac.service,
+			[]*ResourceActions{{Type: "repository", Name: "foo/bar", Actions: []string{"pull"}}},
+			time.Now(), time.Now().Add(5*time.Minute))
+		if err != nil {
+			t.Error(err)
+		}
+		json.NewEncoder(w).Encode(map[string]any{"token": token.Raw})
+	}))
+	defer tokenServer.Close()
+
+	exchange, err := newBasicExchange(exchangeOptions{timeout: time.Second, cache: cacheOptions{size: 10, maxTTL: time.Minute}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ac.exchange = exchange
+	ac.realm = tokenServer.URL
+	ac.autoRedirect = true
+	ac.autoRedirectPath = "/auth/token"
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/v2/foo/bar/manifests/latest", nil)
+	req.Host = strings.TrimPrefix(attacker.URL, "http://")
+	req.Header.Set("X-Forwarded-Proto", "http")
+	req.SetBasicAuth("alice", "secret")
+	if _, err := ac.Authorized(req, auth.Access{Resource: auth.Resource{Type: "repository", Name: "foo/bar"}, Action: "pull"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if forwarded != 0 {
+		t.Fatalf("credentials forwarded %d times to the host of the request", forwarded)
+	}
+}
@@ -82,6 +82,10 @@ type ClaimSet struct {
 
 	// Private claims
 	Access []*ResourceActions `json:"access"`
+
+	// Raw holds every claim of the token, including the claims not
+	// described above, as decoded from JSON.
+	Raw map[string]any `json:"-"`
 }
 
 // Token is a JSON Web Token.
@@ -126,7 +130,7 @@ func (t *Token) Verify(verifyOpts VerifyOptions) (*ClaimSet, error) {
 	// NOTE(milosgajdos): Claims both verifies the signature
 	// and returns the claims within the payload
 	var claims ClaimSet
-	err = t.JWT.Claims(signingKey, &claims)
+	err = t.JWT.Claims(signingKey, &claims, &claims.Raw)
 	if err != nil {
 		return nil, err
 	}
@@ -14,7 +14,9 @@ import (
 	"math/big"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -570,6 +572,9 @@ func TestAccessController(t *testing.T) {
 	if grant.User.Name != "foo" {
 		t.Fatalf("expected user name %q, got %q", "foo", grant.User.Name)
 	}
+	if sub := grant.Claims["sub"]; sub != "foo" {
+		t.Fatalf("expected the claims of the token in the grant, got sub %v", sub)
+	}
 
 	// 5. Supply a token with full admin rights, which is represented as "*".
 	token, err = makeTestToken(
@@ -700,3 +705,335 @@ func TestVerifyJWKWithTrustedKey(t *testing.T) {
 		t.Errorf("Expected 'untrusted JWK with no certificate chain' error, got: %v", err)
 	}
 }
+
+func TestAccessControllerTagParameter(t *testing.T) {
+	rootKeys, err := makeRootKeys(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rootCertBundleFilename, err := writeTempRootCerts(rootKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(rootCertBundleFilename)
+
+	realm := "https://auth.example.com/token/"
+	issuer := "test-issuer.example.com"
+	service := "test-service.example.com"
+
+	accessController, err := newAccessController(map[string]any{
+		"realm":          realm,
+		"issuer":         issuer,
+		"service":        service,
+		"rootcertbundle": rootCertBundleFilename,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jwk, err := makeSigningKeyWithChain(rootKeys[0], 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resource := auth.Resource{Type: "repository", Name: "foo/bar"}
+	tagAccess := func(tag string) auth.Access {
+		return auth.Access{
+			Resource:   resource,
+			Action:     "pull",
+			Parameters: auth.EncodeParameters(map[string]string{auth.TagParameter: tag}),
+		}
+	}
+	digestAccess := auth.Access{Resource: resource, Action: "pull"}
+
+	// the challenge requests both the plain and the parameterized scope
+	req, err := http.NewRequest(http.MethodGet, "http://example.com/v2/foo/bar/manifests/prod-1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = accessController.Authorized(req, tagAccess("prod-1"))
+	challenge, ok := err.(auth.Challenge)
+	if !ok {
+		t.Fatal("accessController did not return a challenge")
+	}
+	resp := httptest.NewRecorder()
+	challenge.SetHeaders(req, resp)
+	if expected := `scope="repository:foo/bar:pull repository:foo/bar:pull:tag=prod-1"`; !strings.Contains(resp.Header().Get("WWW-Authenticate"), expected) {
+		t.Fatalf("expected challenge to contain %s, got %q", expected, resp.Header().Get("WWW-Authenticate"))
+	}
+
+	for _, tc := range []struct {
+		name     string
+		actions  []string
+		access   auth.Access
+		expected bool
+	}{
+		{
+			name:     "plain scope grants tagged pulls",
+			actions:  []string{"pull"},
+			access:   tagAccess("prod-1"),
+			expected: true,
+		},
+		{
+			name:     "plain scope grants digest pulls",
+			actions:  []string{"pull"},
+			access:   digestAccess,
+			expected: true,
+		},
+		{
+			name:     "tag scope grants pulls of the tag",
+			actions:  []string{"pull:tag=prod-1"},
+			access:   tagAccess("prod-1"),
+			expected: true,
+		},
+		{
+			name:    "tag scope denies pulls of other tags",
+			actions: []string{"pull:tag=prod-1"},
+			access:  tagAccess("dev-1"),
+		},
+		{
+			name:    "tag scope denies digest pulls",
+			actions: []string{"pull:tag=prod-1"},
+			access:  digestAccess,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			token, err := makeTestToken(
+				jwk, issuer, service,
+				[]*ResourceActions{{
+					Type:    resource.Type,
+					Name:    resource.Name,
+					Actions: tc.actions,
+				}},
+				time.Now(), time.Now().Add(5*time.Minute),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token.Raw))
+
+			_, err = accessController.Authorized(req, tc.access)
+			if tc.expected && err != nil {
+				t.Fatalf("accessController returned unexpected error: %s", err)
+			}
+			if !tc.expected && (err == nil || err.Error() != ErrInsufficientScope.Error()) {
+				t.Fatalf("expected insufficient scope error, got %v", err)
+			}
+		})
+	}
+}
+
+// newCachingAccessController returns an access controller caching verified
+// tokens, and a key signing tokens it trusts.
+func newCachingAccessController(tb testing.TB, cache map[string]any) (*accessController, *jose.JSONWebKey) {
+	tb.Helper()
+
+	rootKeys, err := makeRootKeys(1)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	rootCertBundleFilename, err := writeTempRootCerts(rootKeys)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { os.Remove(rootCertBundleFilename) })
+
+	options := map[string]any{
+		"realm":          "https://auth.example.com/token/",
+		"issuer":         "test-issuer.example.com",
+		"service":        "test-service.example.com",
+		"rootcertbundle": rootCertBundleFilename,
+	}
+	if cache != nil {
+		options["cache"] = cache
+	}
+	ac, err := newAccessController(options)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	jwk, err := makeSigningKeyWithChain(rootKeys[0], 1)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return ac.(*accessController), jwk
+}
+
+// countVerifications counts the tokens verified until the test ends.
+func countVerifications(tb testing.TB) *int {
+	var count int
+	old := tokenVerifier
+	tokenVerifier = func(token *Token, opts VerifyOptions) (*ClaimSet, error) {
+		count++
+		return old(token, opts)
+	}
+	tb.Cleanup(func() { tokenVerifier = old })
+	return &count
+}
+
+func TestAccessControllerCache(t *testing.T) {
+	verifications := countVerifications(t)
+	ac, jwk := newCachingAccessController(t, map[string]any{"size": 2})
+
+	pull := auth.Access{Resource: auth.Resource{Type: "repository", Name: "foo/bar"}, Action: "pull"}
+	newToken := func(access ...auth.Access) string {
+		var actions []*ResourceActions
+		for _, a := range access {
+			actions = append(actions, &ResourceActions{Type: a.Type, Name: a.Name, Actions: []string{a.Action}})
+		}
+		token, err := makeTestToken(jwk, ac.issuer, ac.service, actions, time.Now(), time.Now().Add(5*time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token.Raw
+	}
+	authorize := func(rawToken string, access auth.Access) error {
+		req := httptest.NewRequest(http.MethodGet, "http://example.com/v2/foo/bar/blobs/sha256:abc", nil)
+		req.Header.Set("Authorization", "Bearer "+rawToken)
+		_, err := ac.Authorized(req, access)
+		return err
+	}
+
+	token := newToken(pull)
+	for i := 0; i < 10; i++ {
+		if err := authorize(token, pull); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if *verifications != 1 {
+		t.Fatalf("unexpected number of verifications: %d", *verifications)
+	}
+
+	// scopes are checked on cached claims
+	push := pull
+	push.Action = "push"
+	if err := authorize(token, push); err == nil || err.Error() != ErrInsufficientScope.Error() {
+		t.Fatalf("expected insufficient scope error, got %v", err)
+	}
+
+	// tampered tokens sharing the prefix of the cached token never hit
+	signature := token[strings.LastIndex(token, ".")+1:]
+	flipped := "A"
+	if signature[0] == 'A' {
+		flipped = "B"
+	}
+	for _, tampered := range []string{
+		token[:len(token)-len(signature)] + flipped + signature[1:],
+		token[:len(token)-1],
+		token + "A",
+	} {
+		if err := authorize(tampered, pull); err == nil {
+			t.Fatalf("tampered token %q authorized", tampered)
+		}
+	}
+	if *verifications == 1 {
+		t.Fatal("tampered tokens were not verified")
+	}
+
+	// the least recently used tokens are evicted
+	*verifications = 0
+	authorize(newToken(pull), pull)
+	authorize(newToken(pull), pull)
+	if err := authorize(token, pull); err != nil {
+		t.Fatal(err)
+	}
+	if *verifications != 3 {
+		t.Fatalf("unexpected number of verifications: %d", *verifications)
+	}
+
+	// the claims verified for a request are reused by its other checks
+	uncached, jwk := newCachingAccessController(t, nil)
+	rawToken, err := makeTestToken(jwk, uncached.issuer, uncached.service,
+		[]*ResourceActions{{Type: pull.Type, Name: pull.Name, Actions: []string{pull.Action}}},
+		time.Now(), time.Now().Add(5*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	*verifications = 0
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/v2/foo/bar/blobs/sha256:abc", nil)
+	req.Header.Set("Authorization", "Bearer "+rawToken.Raw)
+	req = req.WithContext(auth.WithRequestCache(req.Context()))
+	for i := 0; i < 3; i++ {
+		if _, err := uncached.Authorized(req, pull); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := uncached.Authorized(req.WithContext(auth.WithRequestCache(req.Context())), pull); err != nil {
+		t.Fatal(err)
+	}
+	if *verifications != 2 {
+		t.Fatalf("unexpected number of verifications: %d", *verifications)
+	}
+}
+
+func TestClaimsCacheExpiry(t *testing.T) {
+	cache, err := newClaimsCache(cacheOptions{size: 10, maxTTL: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+
+	// tokens expiring after the maximum ttl are cached for the maximum ttl
+	cache.add("long", &ClaimSet{Expiration: now.Add(time.Hour).Unix()})
+	// tokens expiring before are cached until they expire, with leeway
+	cache.add("short", &ClaimSet{Expiration: now.Add(-30 * time.Second).Unix()})
+	// expired tokens are not cached
+	cache.add("expired", &ClaimSet{Expiration: now.Add(-2 * Leeway).Unix()})
+
+	for _, tc := range []struct {
+		after  time.Duration
+		cached map[string]bool
+	}{
+		{after: 0, cached: map[string]bool{"long": true, "short": true, "expired": false}},
+		{after: 30 * time.Second, cached: map[string]bool{"long": true, "short": false}},
+		{after: time.Minute, cached: map[string]bool{"long": false}},
+	} {
+		now = now.Add(tc.after)
+		for rawToken, expected := range tc.cached {
+			if _, ok := cache.get(rawToken); ok != expected {
+				t.Errorf("after %v, unexpected cached state of %s: %t", tc.after, rawToken, ok)
+			}
+		}
+	}
+	if cache.lru.Len() != 0 {
+		t.Fatalf("expired claims not evicted: %d", cache.lru.Len())
+	}
+}
+
+// BenchmarkAuthorizedPull authorizes the requests of a pull of 100 layers
+// with the same token.
+func BenchmarkAuthorizedPull(b *testing.B) {
+	for _, bm := range []struct {
+		name  string
+		cache map[string]any
+	}{
+		{name: "uncached"},
+		{name: "cached", cache: map[string]any{}},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			verifications := countVerifications(b)
+			ac, jwk := newCachingAccessController(b, bm.cache)
+			pull := auth.Access{Resource: auth.Resource{Type: "repository", Name: "foo/bar"}, Action: "pull"}
+			token, err := makeTestToken(jwk, ac.issuer, ac.service,
+				[]*ResourceActions{{Type: pull.Type, Name: pull.Name, Actions: []string{pull.Action}}},
+				time.Now(), time.Now().Add(time.Hour))
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				for layer := 0; layer < 100; layer++ {
+					req := httptest.NewRequest(http.MethodGet, "http://example.com/v2/foo/bar/blobs/sha256:abc", nil)
+					req.Header.Set("Authorization", "Bearer "+token.Raw)
+					if _, err := ac.Authorized(req, pull); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+			b.ReportMetric(float64(*verifications)/float64(b.N), "verifications/op")
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package autotls
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// accountKeyName is the name of the cached ACME account key, shared with
+// autocert.
+const accountKeyName = "acme_account+key"
+
+// ACMEIssuer obtains certificates from an ACME certificate authority.
+type ACMEIssuer struct {
+	// DirectoryURL is the directory of the certificate authority. Defaults
+	// to the directory of Let's Encrypt.
+	DirectoryURL string
+
+	// Email is the contact of the account, which the certificate authority
+	// notifies of problems with its certificates.
+	Email string
+
+	// Cache keeps the account key.
+	Cache autocert.Cache
+
+	mu     sync.Mutex
+	client *acme.Client
+}
+
+// Issue obtains a certificate through an order of the certificate
+// authority, accepting its terms of service.
+func (i *ACMEIssuer) Issue(ctx context.Context, key crypto.Signer, hosts []string, challengeType string, solver Solver) ([][]byte, error) {
+	client, err := i.acmeClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	order, err := client.AuthorizeOrder(ctx, acme.DomainIDs(hosts...))
+	if err != nil {
+		return nil, err
+	}
+	for _, url := range order.AuthzURLs {
+		if err := i.authorize(ctx, client, url, challengeType, solver); err != nil {
+			return nil, err
+		}
+	}
+	order, err = client.WaitOrder(ctx, order.URI)
+	if err != nil {
+		return nil, err
+	}
+
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: hosts[0]},
+		DNSNames: hosts,
+	}, key)
+	if err != nil {
+		return nil, err
+	}
+	chain, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	return chain, err
+}
+
+// authorize proves the control of the host of the authorization at url, if
+// not already proven.
+func (i *ACMEIssuer) authorize(ctx context.Context, client *acme.Client, url, challengeType string, solver Solver) error {
+	authz, err := client.GetAuthorization(ctx, url)
+	if err != nil {
+		return err
+	}
+	if authz.Status == acme.StatusValid {
+		return nil
+	}
+
+	var chal *acme.Challenge
+	for _, c := range authz.Challenges {
+		if c.Type == challengeType {
+			chal = c
+			break
+		}
+	}
+	if chal == nil {
+		return fmt.Errorf("no %s challenge offered for %s", challengeType, authz.Identifier.Value)
+	}
+
+	challenge := Challenge{
+		Type:  challengeType,
+		Host:  strings.TrimPrefix(authz.Identifier.Value, "*."),
+		Token: chal.Token,
+	}
+	switch challengeType {
+	case ChallengeHTTP01:
+		challenge.Response, err = client.HTTP01ChallengeResponse(chal.Token)
+	case ChallengeDNS01:
+		challenge.Response, err = client.DNS01ChallengeRecord(chal.Token)
+	case ChallengeTLSALPN01:
+		cert, certErr := client.TLSALPN01ChallengeCert(chal.Token, challenge.Host)
+		challenge.Certificate, err = &cert, certErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := solver.Present(ctx, challenge); err != nil {
+		return fmt.Errorf("presenting the %s challenge of %s: %w", challengeType, challenge.Host, err)
+	}
+	defer solver.CleanUp(context.WithoutCancel(ctx), challenge)
+
+	if _, err := client.Accept(ctx, chal); err != nil {
+		return err
+	}
+	_, err = client.WaitAuthorization(ctx, authz.URI)
+	return err
+}
+
+// acmeClient returns a client of the certificate authority, registering its
+// account on first use.
+func (i *ACMEIssuer) acmeClient(ctx context.Context) (*acme.Client, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.client != nil {
+		return i.client, nil
+	}
+
+	key, err := i.accountKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	client := &acme.Client{Key: key, DirectoryURL: i.DirectoryURL}
+	if client.DirectoryURL == "" {
+		client.DirectoryURL = autocert.DefaultACMEDirectory
+	}
+	account := &acme.Account{}
+	if i.Email != "" {
+		account.Contact = []string{"mailto:" + i.Email}
+	}
+	if _, err := client.Register(ctx, account, autocert.AcceptTOS); err != nil && !errors.Is(err, acme.ErrAccountAlreadyExists) {
+		return nil, err
+	}
+	i.client = client
+	return client, nil
+}
+
+// accountKey returns the cached account key, generating it if missing.
+func (i *ACMEIssuer) accountKey(ctx context.Context) (crypto.Signer, error) {
+	data, err := i.Cache.Get(ctx, accountKeyName)
+	if err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, errors.New("malformed acme account key")
+		}
+		return parsePrivateKey(block)
+	}
+	if !errors.Is(err, autocert.ErrCacheMiss) {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	keyBytes, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	data = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes})
+	if err := i.Cache.Put(ctx, accountKeyName, data); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
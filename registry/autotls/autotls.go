@@ -0,0 +1,500 @@
+// Package autotls obtains the TLS certificate of the registry from an ACME
+// certificate authority, such as Let's Encrypt, and renews it before it
+// expires.
+//
+// A Manager proves the control of the hosts of the certificate by solving
+// tls-alpn-01 challenges on the TLS listener of the registry, http-01
+// challenges on a plain HTTP listener, or dns-01 challenges through a
+// DNSProvider. The certificate and the ACME account key are kept in an
+// autocert.Cache, which registries may share to reuse the certificate
+// obtained by any of them.
+package autotls
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	prometheus "github.com/distribution/distribution/v3/metrics"
+	"github.com/docker/go-metrics"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// The challenge types a Manager solves.
+const (
+	ChallengeTLSALPN01 = "tls-alpn-01"
+	ChallengeHTTP01    = "http-01"
+	ChallengeDNS01     = "dns-01"
+)
+
+const (
+	// DefaultRenewBefore is the default time before its expiry at which the
+	// certificate is renewed.
+	DefaultRenewBefore = 30 * 24 * time.Hour
+
+	// minRetryDelay and maxRetryDelay bound the delay before retrying to
+	// obtain a certificate after a failure.
+	minRetryDelay = time.Minute
+	maxRetryDelay = time.Hour
+
+	// httpChallengePath is the path prefix of http-01 challenges.
+	httpChallengePath = "/.well-known/acme-challenge/"
+)
+
+var (
+	// renewals counts the attempts to obtain or renew the certificate
+	renewals = prometheus.TLSNamespace.NewLabeledCounter("certificate_renewals", "The number of attempts to obtain or renew the certificate", "result")
+	// expiry is the expiry time of the active certificate
+	expiry = prometheus.TLSNamespace.NewGauge("certificate_expiry", "The expiry time of the active certificate, as a unix timestamp", metrics.Seconds)
+)
+
+func init() {
+	metrics.Register(prometheus.TLSNamespace)
+}
+
+// Challenge is a challenge proving the control of a host to the certificate
+// authority.
+type Challenge struct {
+	// Type is the type of the challenge, such as ChallengeHTTP01.
+	Type string
+
+	// Host is the host whose control is proven.
+	Host string
+
+	// Token identifies http-01 challenges.
+	Token string
+
+	// Response is the response served to http-01 challenges, or the value of
+	// the TXT record of dns-01 challenges.
+	Response string
+
+	// Certificate is the certificate served to tls-alpn-01 challenges.
+	Certificate *tls.Certificate
+}
+
+// Solver makes challenges available to the certificate authority.
+type Solver interface {
+	// Present makes the challenge available.
+	Present(ctx context.Context, challenge Challenge) error
+
+	// CleanUp removes the challenge once it was validated or failed.
+	CleanUp(ctx context.Context, challenge Challenge) error
+}
+
+// Issuer obtains certificates from a certificate authority.
+type Issuer interface {
+	// Issue obtains a certificate for the public key of key valid for
+	// hosts, proving their control with solver through challenges of type
+	// challengeType. It returns the DER encoded certificate chain, leaf
+	// first.
+	Issue(ctx context.Context, key crypto.Signer, hosts []string, challengeType string, solver Solver) ([][]byte, error)
+}
+
+// Options configure a Manager.
+type Options struct {
+	// Hosts are the hosts the certificate is valid for. Wildcard hosts
+	// require dns-01 challenges.
+	Hosts []string
+
+	// Cache keeps the certificate.
+	Cache autocert.Cache
+
+	// Issuer obtains the certificate.
+	Issuer Issuer
+
+	// Challenge is the type of the challenges solved. Defaults to
+	// ChallengeTLSALPN01.
+	Challenge string
+
+	// DNSProvider publishes the records of dns-01 challenges.
+	DNSProvider DNSProvider
+
+	// DNSPropagationDelay is the time waited for the records of dns-01
+	// challenges to propagate before they are validated.
+	DNSPropagationDelay time.Duration
+
+	// RenewBefore is the time before its expiry at which the certificate is
+	// renewed. Defaults to DefaultRenewBefore.
+	RenewBefore time.Duration
+}
+
+// Manager obtains a certificate valid for a set of hosts and renews it
+// before it expires.
+type Manager struct {
+	hosts       []string
+	cacheKey    string
+	cache       autocert.Cache
+	issuer      Issuer
+	challenge   string
+	solver      Solver
+	renewBefore time.Duration
+	now         func() time.Time
+
+	// http and alpn serve the http-01 and tls-alpn-01 challenges, if
+	// solved.
+	http *httpSolver
+	alpn *alpnSolver
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+}
+
+// NewManager returns a Manager configured by opts.
+func NewManager(opts Options) (*Manager, error) {
+	if len(opts.Hosts) == 0 {
+		return nil, errors.New("autotls: no hosts")
+	}
+	if opts.Cache == nil || opts.Issuer == nil {
+		return nil, errors.New("autotls: a cache and an issuer are required")
+	}
+	if opts.RenewBefore < 0 {
+		return nil, errors.New("autotls: the renewal window must not be negative")
+	}
+
+	hosts := slices.Clone(opts.Hosts)
+	sort.Strings(hosts)
+	m := &Manager{
+		hosts:       hosts,
+		cacheKey:    strings.Join(hosts, "+"),
+		cache:       opts.Cache,
+		issuer:      opts.Issuer,
+		challenge:   opts.Challenge,
+		renewBefore: opts.RenewBefore,
+		now:         time.Now,
+	}
+	if m.challenge == "" {
+		m.challenge = ChallengeTLSALPN01
+	}
+	if m.renewBefore == 0 {
+		m.renewBefore = DefaultRenewBefore
+	}
+
+	switch m.challenge {
+	case ChallengeTLSALPN01:
+		m.alpn = &alpnSolver{certs: make(map[string]*tls.Certificate)}
+		m.solver = m.alpn
+	case ChallengeHTTP01:
+		m.http = &httpSolver{responses: make(map[string]string)}
+		m.solver = m.http
+	case ChallengeDNS01:
+		if opts.DNSProvider == nil {
+			return nil, errors.New("autotls: dns-01 challenges require a dns provider")
+		}
+		m.solver = &dnsSolver{provider: opts.DNSProvider, propagationDelay: opts.DNSPropagationDelay}
+	default:
+		return nil, fmt.Errorf("autotls: unsupported challenge type %q", m.challenge)
+	}
+	if m.challenge != ChallengeDNS01 {
+		for _, host := range hosts {
+			if strings.HasPrefix(host, "*.") {
+				return nil, fmt.Errorf("autotls: wildcard host %q requires dns-01 challenges", host)
+			}
+		}
+	}
+	return m, nil
+}
+
+// Run obtains the certificate, and renews it when due, until ctx is done.
+// Failures are logged and retried with an increasing delay.
+func (m *Manager) Run(ctx context.Context) {
+	retryDelay := minRetryDelay
+	for {
+		wait, err := m.renew(ctx)
+		if err != nil {
+			dcontext.GetLogger(ctx).Errorf("error obtaining the tls certificate of %s, retrying in %v: %v", strings.Join(m.hosts, ", "), retryDelay, err)
+			wait = retryDelay
+			retryDelay = min(2*retryDelay, maxRetryDelay)
+		} else {
+			retryDelay = minRetryDelay
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// renew activates the cached certificate, after obtaining a new one if it
+// is missing or due for renewal, and returns the time until it is due.
+// Registries sharing the cache reuse the certificate renewed by any of them.
+func (m *Manager) renew(ctx context.Context) (time.Duration, error) {
+	cert, err := m.load(ctx)
+	if err != nil {
+		dcontext.GetLogger(ctx).Warnf("ignoring the cached tls certificate of %s: %v", strings.Join(m.hosts, ", "), err)
+	}
+	if cert == nil || !m.now().Before(m.renewAt(cert)) {
+		dcontext.GetLogger(ctx).Infof("obtaining a tls certificate for %s with %s challenges", strings.Join(m.hosts, ", "), m.challenge)
+		cert, err = m.issue(ctx)
+		if err != nil {
+			renewals.WithValues("failure").Inc()
+			return 0, err
+		}
+		renewals.WithValues("success").Inc()
+	}
+
+	m.mu.Lock()
+	changed := m.cert == nil || !bytes.Equal(m.cert.Certificate[0], cert.Certificate[0])
+	m.cert = cert
+	m.mu.Unlock()
+	if changed {
+		expiry.Set(float64(cert.Leaf.NotAfter.Unix()))
+		dcontext.GetLogger(ctx).Infof("serving the tls certificate of %s expiring at %s", strings.Join(m.hosts, ", "), cert.Leaf.NotAfter.Format(time.RFC3339))
+	}
+	return m.renewAt(cert).Sub(m.now()), nil
+}
+
+// renewAt returns the time at which cert is due for renewal.
+func (m *Manager) renewAt(cert *tls.Certificate) time.Time {
+	return cert.Leaf.NotAfter.Add(-m.renewBefore)
+}
+
+// load returns the cached certificate, or nil if it is not cached or does
+// not cover the hosts.
+func (m *Manager) load(ctx context.Context) (*tls.Certificate, error) {
+	data, err := m.cache.Get(ctx, m.cacheKey)
+	if err != nil {
+		if errors.Is(err, autocert.ErrCacheMiss) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	keyBlock, rest := pem.Decode(data)
+	if keyBlock == nil {
+		return nil, errors.New("no private key")
+	}
+	key, err := parsePrivateKey(keyBlock)
+	if err != nil {
+		return nil, err
+	}
+	var chain [][]byte
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		chain = append(chain, block.Bytes)
+	}
+	cert, err := m.certificate(key, chain)
+	if err != nil {
+		return nil, err
+	}
+	for _, host := range m.hosts {
+		if !slices.Contains(cert.Leaf.DNSNames, host) {
+			return nil, nil
+		}
+	}
+	return cert, nil
+}
+
+// issue obtains a certificate and caches it.
+func (m *Manager) issue(ctx context.Context) (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	chain, err := m.issuer.Issue(ctx, key, m.hosts, m.challenge, m.solver)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := m.certificate(key, chain)
+	if err != nil {
+		return nil, err
+	}
+
+	var data bytes.Buffer
+	keyBytes, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := pem.Encode(&data, &pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes}); err != nil {
+		return nil, err
+	}
+	for _, der := range chain {
+		if err := pem.Encode(&data, &pem.Block{Type: "CERTIFICATE", Bytes: der}); err != nil {
+			return nil, err
+		}
+	}
+	if err := m.cache.Put(ctx, m.cacheKey, data.Bytes()); err != nil {
+		// the certificate is still served, and obtained again on restart
+		dcontext.GetLogger(ctx).Errorf("error caching the tls certificate of %s: %v", strings.Join(m.hosts, ", "), err)
+	}
+	return cert, nil
+}
+
+// certificate assembles a certificate of key and chain, checking it is
+// currently valid.
+func (m *Manager) certificate(key crypto.Signer, chain [][]byte) (*tls.Certificate, error) {
+	if len(chain) == 0 {
+		return nil, errors.New("no certificate")
+	}
+	leaf, err := x509.ParseCertificate(chain[0])
+	if err != nil {
+		return nil, err
+	}
+	now := m.now()
+	if now.Before(leaf.NotBefore) || now.After(leaf.NotAfter) {
+		return nil, fmt.Errorf("certificate valid from %s to %s", leaf.NotBefore, leaf.NotAfter)
+	}
+	if !publicKeysEqual(leaf.PublicKey, key.Public()) {
+		return nil, errors.New("certificate does not match its private key")
+	}
+	return &tls.Certificate{Certificate: chain, PrivateKey: key, Leaf: leaf}, nil
+}
+
+// GetCertificate returns the certificate obtained, or the certificate of a
+// tls-alpn-01 challenge. It is meant for tls.Config.GetCertificate.
+func (m *Manager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if m.alpn != nil && slices.Contains(hello.SupportedProtos, acme.ALPNProto) {
+		if cert := m.alpn.certificate(hello.ServerName); cert != nil {
+			return cert, nil
+		}
+		return nil, fmt.Errorf("autotls: no tls-alpn-01 challenge for %q", hello.ServerName)
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.cert == nil {
+		return nil, errors.New("autotls: no certificate obtained yet")
+	}
+	return m.cert, nil
+}
+
+// NextProtos returns the protocols the TLS listener must negotiate for the
+// challenges to be solved.
+func (m *Manager) NextProtos() []string {
+	if m.alpn != nil {
+		return []string{acme.ALPNProto}
+	}
+	return nil
+}
+
+// HTTPHandler serves http-01 challenges, passing other requests to fallback,
+// or redirecting them to HTTPS if fallback is nil.
+func (m *Manager) HTTPHandler(fallback http.Handler) http.Handler {
+	if fallback == nil {
+		fallback = http.HandlerFunc(redirectHTTPS)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.URL.Path, httpChallengePath)
+		if !ok {
+			fallback.ServeHTTP(w, r)
+			return
+		}
+		response, ok := "", false
+		if m.http != nil {
+			response, ok = m.http.response(token)
+		}
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte(response))
+	})
+}
+
+func redirectHTTPS(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "use HTTPS", http.StatusBadRequest)
+		return
+	}
+	http.Redirect(w, r, "https://"+r.Host+r.URL.RequestURI(), http.StatusFound)
+}
+
+func parsePrivateKey(block *pem.Block) (crypto.Signer, error) {
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("unsupported private key")
+	}
+	return signer, nil
+}
+
+func publicKeysEqual(a, b crypto.PublicKey) bool {
+	key, ok := a.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && key.Equal(b)
+}
+
+// alpnSolver serves the certificates of tls-alpn-01 challenges.
+type alpnSolver struct {
+	mu    sync.RWMutex
+	certs map[string]*tls.Certificate
+}
+
+func (s *alpnSolver) Present(ctx context.Context, challenge Challenge) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.certs[challenge.Host] = challenge.Certificate
+	return nil
+}
+
+func (s *alpnSolver) CleanUp(ctx context.Context, challenge Challenge) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.certs, challenge.Host)
+	return nil
+}
+
+func (s *alpnSolver) certificate(host string) *tls.Certificate {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.certs[host]
+}
+
+// httpSolver serves the responses of http-01 challenges.
+type httpSolver struct {
+	mu        sync.RWMutex
+	responses map[string]string
+}
+
+func (s *httpSolver) Present(ctx context.Context, challenge Challenge) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.responses[challenge.Token] = challenge.Response
+	return nil
+}
+
+func (s *httpSolver) CleanUp(ctx context.Context, challenge Challenge) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.responses, challenge.Token)
+	return nil
+}
+
+func (s *httpSolver) response(token string) (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	response, ok := s.responses[token]
+	return response, ok
+}
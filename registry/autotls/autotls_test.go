@@ -0,0 +1,333 @@
+package autotls
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// fakeIssuer issues self-signed certificates after checking the challenges
+// are solved, as a certificate authority would.
+type fakeIssuer struct {
+	t        *testing.T
+	manager  *Manager
+	validity time.Duration
+	issued   int
+	err      error
+}
+
+func (i *fakeIssuer) Issue(ctx context.Context, key crypto.Signer, hosts []string, challengeType string, solver Solver) ([][]byte, error) {
+	if i.err != nil {
+		return nil, i.err
+	}
+	for _, host := range hosts {
+		challenge := Challenge{Type: challengeType, Host: strings.TrimPrefix(host, "*."), Token: "token-" + host, Response: "response-" + host}
+		if challengeType == ChallengeTLSALPN01 {
+			challenge.Certificate = selfSigned(i.t, []string{host}, time.Hour)
+		}
+		if err := solver.Present(ctx, challenge); err != nil {
+			return nil, err
+		}
+		i.validate(challenge)
+		if err := solver.CleanUp(ctx, challenge); err != nil {
+			return nil, err
+		}
+	}
+	i.issued++
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(int64(i.issued)),
+		Subject:      pkix.Name{CommonName: hosts[0]},
+		DNSNames:     hosts,
+		NotBefore:    i.manager.now().Add(-time.Minute),
+		NotAfter:     i.manager.now().Add(i.validity),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+	return [][]byte{der}, nil
+}
+
+// validate checks the manager serves the challenge.
+func (i *fakeIssuer) validate(challenge Challenge) {
+	i.t.Helper()
+
+	switch challenge.Type {
+	case ChallengeHTTP01:
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "http://"+challenge.Host+httpChallengePath+challenge.Token, nil)
+		i.manager.HTTPHandler(nil).ServeHTTP(w, r)
+		if w.Code != http.StatusOK || w.Body.String() != challenge.Response {
+			i.t.Errorf("unexpected http-01 response: %d %q", w.Code, w.Body.String())
+		}
+	case ChallengeTLSALPN01:
+		cert, err := i.manager.GetCertificate(&tls.ClientHelloInfo{ServerName: challenge.Host, SupportedProtos: []string{acme.ALPNProto}})
+		if err != nil || cert != challenge.Certificate {
+			i.t.Errorf("unexpected tls-alpn-01 certificate: %v", err)
+		}
+	}
+}
+
+func selfSigned(t *testing.T, hosts []string, validity time.Duration) *tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		DNSNames:     hosts,
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(validity),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// fakeDNSProvider records the published records.
+type fakeDNSProvider struct {
+	records map[string]string
+	present []string
+}
+
+func (p *fakeDNSProvider) Present(ctx context.Context, fqdn, value string) error {
+	p.records[fqdn] = value
+	p.present = append(p.present, fqdn)
+	return nil
+}
+
+func (p *fakeDNSProvider) CleanUp(ctx context.Context, fqdn, value string) error {
+	if p.records[fqdn] != value {
+		return errors.New("cleaning up an unknown record")
+	}
+	delete(p.records, fqdn)
+	return nil
+}
+
+func newTestManager(t *testing.T, opts Options) (*Manager, *fakeIssuer) {
+	t.Helper()
+
+	issuer := &fakeIssuer{t: t, validity: 90 * 24 * time.Hour}
+	if opts.Cache == nil {
+		opts.Cache = autocert.DirCache(t.TempDir())
+	}
+	opts.Issuer = issuer
+	m, err := NewManager(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer.manager = m
+	return m, issuer
+}
+
+func TestManagerChallenges(t *testing.T) {
+	ctx := context.Background()
+	hosts := []string{"registry.example.com", "mirror.example.com"}
+
+	for _, challenge := range []string{ChallengeTLSALPN01, ChallengeHTTP01} {
+		t.Run(challenge, func(t *testing.T) {
+			m, issuer := newTestManager(t, Options{Hosts: hosts, Challenge: challenge})
+			if _, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: hosts[0]}); err == nil {
+				t.Fatal("certificate served before being obtained")
+			}
+			if _, err := m.renew(ctx); err != nil {
+				t.Fatal(err)
+			}
+			if issuer.issued != 1 {
+				t.Fatalf("unexpected number of certificates issued: %d", issuer.issued)
+			}
+			cert, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: hosts[0], SupportedProtos: []string{"h2"}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, host := range hosts {
+				if err := cert.Leaf.VerifyHostname(host); err != nil {
+					t.Error(err)
+				}
+			}
+		})
+	}
+
+	t.Run(ChallengeDNS01, func(t *testing.T) {
+		provider := &fakeDNSProvider{records: make(map[string]string)}
+		m, _ := newTestManager(t, Options{Hosts: []string{"*.example.com"}, Challenge: ChallengeDNS01, DNSProvider: provider})
+		if _, err := m.renew(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if len(provider.present) != 1 || provider.present[0] != "_acme-challenge.example.com." {
+			t.Fatalf("unexpected records published: %v", provider.present)
+		}
+		if len(provider.records) != 0 {
+			t.Fatalf("records not cleaned up: %v", provider.records)
+		}
+	})
+
+	// http-01 challenges redirect other requests to https
+	m, _ := newTestManager(t, Options{Hosts: hosts, Challenge: ChallengeHTTP01})
+	w := httptest.NewRecorder()
+	m.HTTPHandler(nil).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://registry.example.com/v2/", nil))
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "https://registry.example.com/v2/" {
+		t.Fatalf("unexpected response: %d %v", w.Code, w.Header())
+	}
+}
+
+func TestManagerRenewal(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Second)
+	cache := autocert.DirCache(t.TempDir())
+	opts := Options{Hosts: []string{"registry.example.com"}, Cache: cache, RenewBefore: 24 * time.Hour}
+
+	m, issuer := newTestManager(t, opts)
+	m.now = func() time.Time { return now }
+	issuer.validity = 10 * 24 * time.Hour
+	wait, err := m.renew(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wait != 9*24*time.Hour {
+		t.Fatalf("unexpected time until renewal: %v", wait)
+	}
+
+	// a manager sharing the cache reuses the certificate until it is due
+	other, otherIssuer := newTestManager(t, opts)
+	other.now = m.now
+	if _, err := other.renew(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if otherIssuer.issued != 0 {
+		t.Fatal("cached certificate not reused")
+	}
+
+	now = now.Add(9*24*time.Hour + time.Minute)
+	otherIssuer.err = errors.New("unavailable")
+	if _, err := other.renew(ctx); err == nil {
+		t.Fatal("expected a renewal failure")
+	}
+	before, _ := m.GetCertificate(&tls.ClientHelloInfo{})
+	if _, err := m.renew(ctx); err != nil {
+		t.Fatal(err)
+	}
+	after, _ := m.GetCertificate(&tls.ClientHelloInfo{})
+	if issuer.issued != 2 || before == after {
+		t.Fatal("certificate not renewed")
+	}
+
+	// the certificate of other hosts is not reused
+	opts.Hosts = append(opts.Hosts, "mirror.example.com")
+	m, issuer = newTestManager(t, opts)
+	if _, err := m.renew(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if issuer.issued != 1 {
+		t.Fatal("certificate for other hosts reused")
+	}
+}
+
+func TestNewManager(t *testing.T) {
+	for _, opts := range []Options{
+		{},
+		{Hosts: []string{"registry.example.com"}, Challenge: "tls-sni-01"},
+		{Hosts: []string{"registry.example.com"}, Challenge: ChallengeDNS01},
+		{Hosts: []string{"*.example.com"}, Challenge: ChallengeHTTP01},
+		{Hosts: []string{"registry.example.com"}, RenewBefore: -time.Hour},
+	} {
+		opts.Cache = autocert.DirCache(t.TempDir())
+		opts.Issuer = &fakeIssuer{}
+		if _, err := NewManager(opts); err == nil {
+			t.Errorf("expected an error creating a manager with %+v", opts)
+		}
+	}
+}
+
+func TestRedisCache(t *testing.T) {
+	ctx := context.Background()
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer client.Close()
+
+	cache := NewRedisCache(client)
+	if _, err := cache.Get(ctx, "registry.example.com"); !errors.Is(err, autocert.ErrCacheMiss) {
+		t.Fatalf("expected a cache miss, got %v", err)
+	}
+	if err := cache.Put(ctx, "registry.example.com", []byte("certificate")); err != nil {
+		t.Fatal(err)
+	}
+	data, err := cache.Get(ctx, "registry.example.com")
+	if err != nil || string(data) != "certificate" {
+		t.Fatalf("unexpected cached data: %q, %v", data, err)
+	}
+	if err := cache.Delete(ctx, "registry.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.Get(ctx, "registry.example.com"); !errors.Is(err, autocert.ErrCacheMiss) {
+		t.Fatalf("expected a cache miss, got %v", err)
+	}
+}
+
+func TestExecDNSProvider(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	script := filepath.Join(dir, "dns.sh")
+	out := filepath.Join(dir, "out")
+	err := os.WriteFile(script, []byte("#!/bin/sh\necho \"$@\" >> "+out+"\n"), 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewDNSProvider("exec", nil); err == nil {
+		t.Fatal("expected an error creating an exec provider without command")
+	}
+	if _, err := NewDNSProvider("unknown", nil); err == nil {
+		t.Fatal("expected an error creating an unknown provider")
+	}
+	provider, err := NewDNSProvider("exec", map[string]any{"command": script, "args": []any{"--zone", "example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := provider.Present(ctx, "_acme-challenge.example.com.", "value"); err != nil {
+		t.Fatal(err)
+	}
+	if err := provider.CleanUp(ctx, "_acme-challenge.example.com.", "value"); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	p, _ := io.ReadAll(f)
+	expected := "--zone example.com present _acme-challenge.example.com. value\n--zone example.com cleanup _acme-challenge.example.com. value\n"
+	if string(p) != expected {
+		t.Fatalf("unexpected commands run: %q", p)
+	}
+}
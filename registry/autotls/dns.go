@@ -0,0 +1,118 @@
+package autotls
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// DNSProvider publishes the TXT records of dns-01 challenges.
+type DNSProvider interface {
+	// Present publishes the TXT record fqdn with value.
+	Present(ctx context.Context, fqdn, value string) error
+
+	// CleanUp removes the TXT record fqdn with value.
+	CleanUp(ctx context.Context, fqdn, value string) error
+}
+
+// DNSProviderFactory creates a DNSProvider from its parameters.
+type DNSProviderFactory func(parameters map[string]any) (DNSProvider, error)
+
+var dnsProviders = map[string]DNSProviderFactory{
+	"exec": newExecProvider,
+}
+
+// RegisterDNSProvider makes a DNSProvider available by name. It panics if
+// factory is nil or a provider of the same name is already registered.
+func RegisterDNSProvider(name string, factory DNSProviderFactory) {
+	if factory == nil {
+		panic("autotls: nil dns provider factory")
+	}
+	if _, registered := dnsProviders[name]; registered {
+		panic(fmt.Sprintf("autotls: dns provider %q registered twice", name))
+	}
+	dnsProviders[name] = factory
+}
+
+// NewDNSProvider creates the DNSProvider registered by name.
+func NewDNSProvider(name string, parameters map[string]any) (DNSProvider, error) {
+	factory, ok := dnsProviders[name]
+	if !ok {
+		return nil, fmt.Errorf("autotls: unknown dns provider %q", name)
+	}
+	return factory(parameters)
+}
+
+// dnsSolver solves dns-01 challenges with a DNSProvider.
+type dnsSolver struct {
+	provider         DNSProvider
+	propagationDelay time.Duration
+}
+
+func (s *dnsSolver) Present(ctx context.Context, challenge Challenge) error {
+	if err := s.provider.Present(ctx, dnsChallengeRecord(challenge.Host), challenge.Response); err != nil {
+		return err
+	}
+	if s.propagationDelay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(s.propagationDelay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *dnsSolver) CleanUp(ctx context.Context, challenge Challenge) error {
+	return s.provider.CleanUp(ctx, dnsChallengeRecord(challenge.Host), challenge.Response)
+}
+
+// dnsChallengeRecord returns the fully qualified name of the TXT record of
+// the dns-01 challenge of host.
+func dnsChallengeRecord(host string) string {
+	return "_acme-challenge." + strings.TrimPrefix(host, "*.") + "."
+}
+
+// execProvider publishes records by running a command, passed the action
+// ("present" or "cleanup"), the fully qualified name of the record and its
+// value as arguments.
+type execProvider struct {
+	command string
+	args    []string
+}
+
+func newExecProvider(parameters map[string]any) (DNSProvider, error) {
+	command, _ := parameters["command"].(string)
+	if command == "" {
+		return nil, fmt.Errorf("autotls: the exec dns provider requires a command")
+	}
+	p := &execProvider{command: command}
+	if args, ok := parameters["args"].([]any); ok {
+		for _, arg := range args {
+			p.args = append(p.args, fmt.Sprint(arg))
+		}
+	}
+	return p, nil
+}
+
+func (p *execProvider) Present(ctx context.Context, fqdn, value string) error {
+	return p.run(ctx, "present", fqdn, value)
+}
+
+func (p *execProvider) CleanUp(ctx context.Context, fqdn, value string) error {
+	return p.run(ctx, "cleanup", fqdn, value)
+}
+
+func (p *execProvider) run(ctx context.Context, action, fqdn, value string) error {
+	args := append(append([]string{}, p.args...), action, fqdn, value)
+	out, err := exec.CommandContext(ctx, p.command, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %s: %w: %s", p.command, action, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
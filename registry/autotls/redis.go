@@ -0,0 +1,39 @@
+package autotls
+
+import (
+	"context"
+	"errors"
+
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// redisCachePrefix prefixes the keys of the cached certificates.
+const redisCachePrefix = "tls::acme::"
+
+// redisCache keeps certificates and account keys in redis.
+type redisCache struct {
+	client redis.UniversalClient
+}
+
+// NewRedisCache returns an autocert.Cache keeping certificates in redis, so
+// that registries using the same redis instance share them.
+func NewRedisCache(client redis.UniversalClient) autocert.Cache {
+	return &redisCache{client: client}
+}
+
+func (c *redisCache) Get(ctx context.Context, name string) ([]byte, error) {
+	data, err := c.client.Get(ctx, redisCachePrefix+name).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, autocert.ErrCacheMiss
+	}
+	return data, err
+}
+
+func (c *redisCache) Put(ctx context.Context, name string, data []byte) error {
+	return c.client.Set(ctx, redisCachePrefix+name, data, 0).Err()
+}
+
+func (c *redisCache) Delete(ctx context.Context, name string) error {
+	return c.client.Del(ctx, redisCachePrefix+name).Err()
+}
@@ -0,0 +1,113 @@
+// Package changefeed keeps an append-only log of the changes made to the
+// repositories and tags of the registry, numbered by monotonically
+// increasing sequence numbers, so that clients mirroring the catalog can
+// fetch the changes made since a checkpoint instead of listing every
+// repository again.
+//
+// The oldest changes are compacted away according to a Retention. Clients
+// asking for changes following a compacted sequence number get ErrCompacted
+// and must resynchronize from the full catalog.
+package changefeed
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+)
+
+// Action is the kind of a change.
+type Action string
+
+const (
+	// ActionRepositoryCreated records the first manifest pushed to a
+	// repository.
+	ActionRepositoryCreated Action = "repository_created"
+
+	// ActionRepositoryDeleted records the deletion of a repository.
+	ActionRepositoryDeleted Action = "repository_deleted"
+
+	// ActionTagUpdated records a tag created or moved to a digest.
+	ActionTagUpdated Action = "tag_updated"
+
+	// ActionTagDeleted records the deletion of a tag.
+	ActionTagDeleted Action = "tag_deleted"
+
+	// ActionManifestDeleted records the deletion of a manifest by digest.
+	ActionManifestDeleted Action = "manifest_deleted"
+)
+
+// DefaultMaxChanges is the default number of changes retained.
+const DefaultMaxChanges = 10000
+
+// ErrCompacted is returned when changes following the requested sequence
+// number were compacted away.
+var ErrCompacted = errors.New("changefeed: changes compacted")
+
+// Change is an entry of the log.
+type Change struct {
+	// Sequence numbers the change. The sequence numbers of consecutive
+	// changes are consecutive, starting from 1.
+	Sequence   uint64        `json:"sequence"`
+	Action     Action        `json:"action"`
+	Repository string        `json:"repository"`
+	Tag        string        `json:"tag,omitempty"`
+	Digest     digest.Digest `json:"digest,omitempty"`
+	Timestamp  time.Time     `json:"timestamp"`
+}
+
+// Retention bounds the changes kept in the log. Changes beyond either bound
+// are compacted away.
+type Retention struct {
+	// MaxChanges is the number of most recent changes retained. Defaults
+	// to DefaultMaxChanges if not positive.
+	MaxChanges int
+
+	// MaxAge is the age after which changes are compacted away. Changes
+	// are retained regardless of their age if not positive.
+	MaxAge time.Duration
+}
+
+// compactedUpTo returns the highest sequence number to compact away once the
+// change numbered latest is appended, given the sequence numbers and
+// timestamps of the oldest retained changes, in order.
+func (r Retention) compactedUpTo(latest uint64, oldest []Change, now time.Time) uint64 {
+	maxChanges := r.MaxChanges
+	if maxChanges <= 0 {
+		maxChanges = DefaultMaxChanges
+	}
+	var upTo uint64
+	if latest > uint64(maxChanges) {
+		upTo = latest - uint64(maxChanges)
+	}
+	if r.MaxAge > 0 {
+		cutoff := now.Add(-r.MaxAge)
+		for _, change := range oldest {
+			if !change.Timestamp.Before(cutoff) {
+				break
+			}
+			upTo = max(upTo, change.Sequence)
+		}
+	}
+	return upTo
+}
+
+// Store persists the log.
+type Store interface {
+	// Append adds changes to the log, in order, numbering them after the
+	// latest change, and compacts the changes falling out of retention.
+	Append(ctx context.Context, changes ...Change) error
+
+	// AddRepository records repository as existing, and returns whether it
+	// was not recorded yet.
+	AddRepository(ctx context.Context, repository string) (bool, error)
+
+	// RemoveRepository records repository as deleted.
+	RemoveRepository(ctx context.Context, repository string) error
+
+	// Since returns up to n changes following the sequence number since,
+	// in order, and the sequence number of the latest change. It returns
+	// ErrCompacted if a change following since was compacted away.
+	Since(ctx context.Context, since uint64, n int) ([]Change, uint64, error)
+}
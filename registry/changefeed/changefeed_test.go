@@ -0,0 +1,172 @@
+package changefeed
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/redis/go-redis/v9"
+)
+
+// appendTags appends n tag updates, numbered from first.
+func appendTags(t *testing.T, store Store, first, n int, timestamp time.Time) {
+	t.Helper()
+
+	for i := first; i < first+n; i++ {
+		change := Change{Action: ActionTagUpdated, Repository: "foo/bar", Tag: fmt.Sprint(i), Timestamp: timestamp}
+		if err := store.Append(context.Background(), change); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// checkSince checks the changes following since are the tag updates
+// numbered by their sequence number, from first to last.
+func checkSince(t *testing.T, store Store, since uint64, n int, first, last, latest uint64) {
+	t.Helper()
+
+	changes, l, err := store.Since(context.Background(), since, n)
+	if err != nil {
+		t.Fatalf("reading changes since %d: %v", since, err)
+	}
+	if l != latest {
+		t.Errorf("unexpected latest sequence: %d != %d", l, latest)
+	}
+	if uint64(len(changes)) != last-first+1 {
+		t.Fatalf("unexpected changes since %d: %+v", since, changes)
+	}
+	for i, change := range changes {
+		sequence := first + uint64(i)
+		if change.Sequence != sequence || change.Tag != fmt.Sprint(sequence) || change.Repository != "foo/bar" || change.Action != ActionTagUpdated {
+			t.Errorf("unexpected change %d: %+v", sequence, change)
+		}
+	}
+}
+
+func testStore(t *testing.T, newStore func(Retention) Store) {
+	ctx := context.Background()
+
+	t.Run("order", func(t *testing.T) {
+		store := newStore(Retention{})
+		changes, latest, err := store.Since(ctx, 0, 10)
+		if err != nil || len(changes) != 0 || latest != 0 {
+			t.Fatalf("unexpected changes of an empty log: %+v, %d, %v", changes, latest, err)
+		}
+
+		appendTags(t, store, 1, 5, time.Now())
+		checkSince(t, store, 0, 10, 1, 5, 5)
+		checkSince(t, store, 0, 2, 1, 2, 5)
+		checkSince(t, store, 3, 10, 4, 5, 5)
+		checkSince(t, store, 5, 10, 6, 5, 5)
+		checkSince(t, store, 0, 0, 1, 0, 5)
+
+		// changes appended together are numbered consecutively
+		err = store.Append(ctx,
+			Change{Action: ActionTagUpdated, Repository: "foo/bar", Tag: "6"},
+			Change{Action: ActionTagUpdated, Repository: "foo/bar", Tag: "7"},
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkSince(t, store, 4, 10, 5, 7, 7)
+	})
+
+	t.Run("max changes", func(t *testing.T) {
+		store := newStore(Retention{MaxChanges: 3})
+		appendTags(t, store, 1, 5, time.Now())
+		checkSince(t, store, 2, 10, 3, 5, 5)
+		if _, _, err := store.Since(ctx, 1, 10); !errors.Is(err, ErrCompacted) {
+			t.Fatalf("expected compacted changes, got %v", err)
+		}
+	})
+
+	t.Run("max age", func(t *testing.T) {
+		store := newStore(Retention{MaxAge: time.Hour})
+		appendTags(t, store, 1, 2, time.Now().Add(-2*time.Hour))
+		appendTags(t, store, 3, 2, time.Now())
+		checkSince(t, store, 2, 10, 3, 4, 4)
+		if _, _, err := store.Since(ctx, 0, 10); !errors.Is(err, ErrCompacted) {
+			t.Fatalf("expected compacted changes, got %v", err)
+		}
+	})
+
+	t.Run("repositories", func(t *testing.T) {
+		store := newStore(Retention{})
+		for _, expected := range []bool{true, false} {
+			added, err := store.AddRepository(ctx, "foo/bar")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if added != expected {
+				t.Fatalf("unexpected addition of the repository: %v", added)
+			}
+		}
+		if err := store.RemoveRepository(ctx, "foo/bar"); err != nil {
+			t.Fatal(err)
+		}
+		if added, err := store.AddRepository(ctx, "foo/bar"); err != nil || !added {
+			t.Fatalf("removed repository not added again: %v, %v", added, err)
+		}
+	})
+}
+
+func TestDriverStore(t *testing.T) {
+	testStore(t, func(retention Retention) Store {
+		store, err := NewDriverStore(context.Background(), inmemory.New(), retention)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return store
+	})
+}
+
+func TestDriverStoreReload(t *testing.T) {
+	ctx := context.Background()
+	driver := inmemory.New()
+
+	store, err := NewDriverStore(ctx, driver, Retention{MaxChanges: 1500})
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendTags(t, store, 1, 2500, time.Now())
+	if _, err := store.AddRepository(ctx, "foo/bar"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the first segment only held compacted changes
+	if _, err := driver.Stat(ctx, segmentPath(1)); err == nil {
+		t.Fatal("compacted segment not deleted")
+	}
+
+	store, err = NewDriverStore(ctx, driver, Retention{MaxChanges: 1500})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSince(t, store, 1000, 2000, 1001, 2500, 2500)
+	if _, _, err := store.Since(ctx, 999, 10); !errors.Is(err, ErrCompacted) {
+		t.Fatalf("expected compacted changes, got %v", err)
+	}
+	if added, err := store.AddRepository(ctx, "foo/bar"); err != nil || added {
+		t.Fatalf("stored repository added again: %v, %v", added, err)
+	}
+
+	appendTags(t, store, 2501, 1, time.Now())
+	checkSince(t, store, 2499, 10, 2500, 2501, 2501)
+}
+
+func TestRedisStore(t *testing.T) {
+	testStore(t, func(retention Retention) Store {
+		server, err := miniredis.Run()
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(server.Close)
+		client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+		t.Cleanup(func() { client.Close() })
+		return NewRedisStore(client, retention)
+	})
+}
@@ -0,0 +1,253 @@
+package changefeed
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+)
+
+const (
+	// storageRoot is the directory the log is stored in, relative to the
+	// root of the storage driver.
+	storageRoot = "/docker/registry/v2/changes"
+
+	// segmentSize is the number of changes stored per file, so that appends
+	// rewrite a bounded amount of the log.
+	segmentSize = 1000
+)
+
+// driverState is the stored state of the log besides its changes.
+type driverState struct {
+	Compacted    uint64   `json:"compacted"`
+	Repositories []string `json:"repositories"`
+}
+
+// DriverStore keeps the log in memory and writes it through to the storage
+// driver, in segments of consecutive changes.
+//
+// The log is read from the storage driver only when the store is created,
+// so a single registry may write to it; use redis for registries sharing a
+// storage backend.
+type DriverStore struct {
+	driver    storagedriver.StorageDriver
+	retention Retention
+
+	mu           sync.Mutex
+	changes      []Change
+	latest       uint64
+	compacted    uint64
+	repositories map[string]struct{}
+}
+
+// NewDriverStore returns a store keeping the log in driver, reading the log
+// stored by a previous instance.
+func NewDriverStore(ctx context.Context, driver storagedriver.StorageDriver, retention Retention) (*DriverStore, error) {
+	s := &DriverStore{
+		driver:       driver,
+		retention:    retention,
+		repositories: make(map[string]struct{}),
+	}
+	if err := s.load(ctx); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// load reads the stored log.
+func (s *DriverStore) load(ctx context.Context) error {
+	content, err := s.driver.GetContent(ctx, path.Join(storageRoot, "state.json"))
+	switch {
+	case errors.As(err, &storagedriver.PathNotFoundError{}):
+	case err != nil:
+		return err
+	default:
+		var state driverState
+		if err := json.Unmarshal(content, &state); err != nil {
+			return err
+		}
+		s.compacted = state.Compacted
+		for _, repository := range state.Repositories {
+			s.repositories[repository] = struct{}{}
+		}
+	}
+	s.latest = s.compacted
+
+	segments, err := s.driver.List(ctx, path.Join(storageRoot, "log"))
+	if err != nil {
+		if errors.As(err, &storagedriver.PathNotFoundError{}) {
+			return nil
+		}
+		return err
+	}
+	sort.Strings(segments)
+	for _, segment := range segments {
+		content, err := s.driver.GetContent(ctx, segment)
+		if err != nil {
+			return err
+		}
+		var changes []Change
+		if err := json.Unmarshal(content, &changes); err != nil {
+			return fmt.Errorf("changefeed: reading %s: %w", segment, err)
+		}
+		for _, change := range changes {
+			if change.Sequence > s.compacted {
+				s.changes = append(s.changes, change)
+				s.latest = max(s.latest, change.Sequence)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *DriverStore) Append(ctx context.Context, changes ...Change) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	appended := make([]Change, 0, len(changes))
+	for i, change := range changes {
+		change.Sequence = s.latest + uint64(i) + 1
+		appended = append(appended, change)
+	}
+	latest := appended[len(appended)-1].Sequence
+	for segment := segmentOf(s.latest + 1); segment <= segmentOf(latest); segment += segmentSize {
+		if err := s.writeSegment(ctx, segment, appended); err != nil {
+			return err
+		}
+	}
+	s.changes = append(s.changes, appended...)
+	s.latest = latest
+
+	upTo := s.retention.compactedUpTo(latest, s.changes, time.Now())
+	if upTo <= s.compacted {
+		return nil
+	}
+	return s.compact(ctx, upTo)
+}
+
+// compact removes the changes up to the sequence number upTo, and the
+// segments holding only such changes.
+func (s *DriverStore) compact(ctx context.Context, upTo uint64) error {
+	previous := s.compacted
+	s.compacted = upTo
+	if err := s.writeState(ctx); err != nil {
+		s.compacted = previous
+		return err
+	}
+	s.changes = slices.Delete(s.changes, 0, s.index(upTo+1))
+
+	// segments fully compacted end before the segment of the first change
+	// retained
+	for segment := segmentOf(previous + 1); segment < segmentOf(upTo+1); segment += segmentSize {
+		if err := s.driver.Delete(ctx, segmentPath(segment)); err != nil && !errors.As(err, &storagedriver.PathNotFoundError{}) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *DriverStore) AddRepository(ctx context.Context, repository string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.repositories[repository]; ok {
+		return false, nil
+	}
+	s.repositories[repository] = struct{}{}
+	if err := s.writeState(ctx); err != nil {
+		delete(s.repositories, repository)
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *DriverStore) RemoveRepository(ctx context.Context, repository string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.repositories[repository]; !ok {
+		return nil
+	}
+	delete(s.repositories, repository)
+	if err := s.writeState(ctx); err != nil {
+		s.repositories[repository] = struct{}{}
+		return err
+	}
+	return nil
+}
+
+func (s *DriverStore) Since(ctx context.Context, since uint64, n int) ([]Change, uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if since < s.compacted {
+		return nil, 0, ErrCompacted
+	}
+	changes := s.changes[s.index(since+1):]
+	if len(changes) > n {
+		changes = changes[:n]
+	}
+	return slices.Clone(changes), s.latest, nil
+}
+
+// writeSegment writes the changes of segment, including the appended
+// changes.
+func (s *DriverStore) writeSegment(ctx context.Context, segment uint64, appended []Change) error {
+	var stored []Change
+	for _, change := range slices.Concat(s.changes[s.index(segment):], appended) {
+		if segmentOf(change.Sequence) == segment {
+			stored = append(stored, change)
+		}
+	}
+	content, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+	return s.driver.PutContent(ctx, segmentPath(segment), content)
+}
+
+// index returns the index of the first retained change numbered sequence or
+// later.
+func (s *DriverStore) index(sequence uint64) int {
+	i, _ := slices.BinarySearchFunc(s.changes, sequence, func(change Change, sequence uint64) int {
+		return cmp.Compare(change.Sequence, sequence)
+	})
+	return i
+}
+
+// writeState writes the compaction and repositories of the log.
+func (s *DriverStore) writeState(ctx context.Context) error {
+	state := driverState{Compacted: s.compacted, Repositories: make([]string, 0, len(s.repositories))}
+	for repository := range s.repositories {
+		state.Repositories = append(state.Repositories, repository)
+	}
+	sort.Strings(state.Repositories)
+	content, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return s.driver.PutContent(ctx, path.Join(storageRoot, "state.json"), content)
+}
+
+// segmentOf returns the first sequence number of the segment of the change
+// numbered sequence.
+func segmentOf(sequence uint64) uint64 {
+	return (sequence-1)/segmentSize*segmentSize + 1
+}
+
+// segmentPath returns the path of the segment starting at sequence number
+// first, padded so that segments list in order.
+func segmentPath(first uint64) string {
+	return path.Join(storageRoot, "log", fmt.Sprintf("%020d.json", first))
+}
@@ -0,0 +1,182 @@
+package changefeed
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// The keys of the log share a hash tag, so that the scripts updating them
+// run on a single node of a redis cluster.
+const (
+	sequenceKey     = "{changes}::sequence"
+	logKey          = "{changes}::log"
+	compactedKey    = "{changes}::compacted"
+	repositoriesKey = "{changes}::repositories"
+)
+
+// ageScanSize bounds the number of the oldest changes read to compact the
+// changes older than the maximum age after each append.
+const ageScanSize = 100
+
+// appendScript numbers the changes of ARGV after the latest one and adds
+// them to the log, whose members are prefixed with their sequence number so
+// that they are unique. It returns the latest sequence number.
+var appendScript = redis.NewScript(`
+local sequence = 0
+for i, change in ipairs(ARGV) do
+	sequence = redis.call("INCR", KEYS[1])
+	redis.call("ZADD", KEYS[2], sequence, sequence .. "|" .. change)
+end
+return sequence
+`)
+
+// compactScript removes the changes up to the sequence number ARGV[1] from
+// the log and records it as compacted, unless later changes already are.
+var compactScript = redis.NewScript(`
+local upTo = tonumber(ARGV[1])
+if upTo > tonumber(redis.call("GET", KEYS[2]) or "0") then
+	redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", upTo)
+	redis.call("SET", KEYS[2], upTo)
+end
+return 0
+`)
+
+// redisStore keeps the log in a sorted set scored by sequence number.
+type redisStore struct {
+	client    redis.UniversalClient
+	retention Retention
+}
+
+// NewRedisStore returns a Store keeping the log in redis, shared by every
+// registry using the same redis instance.
+func NewRedisStore(client redis.UniversalClient, retention Retention) Store {
+	return &redisStore{client: client, retention: retention}
+}
+
+func (s *redisStore) Append(ctx context.Context, changes ...Change) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	args := make([]any, 0, len(changes))
+	for _, change := range changes {
+		change.Sequence = 0
+		p, err := json.Marshal(change)
+		if err != nil {
+			return err
+		}
+		args = append(args, string(p))
+	}
+	latest, err := appendScript.Run(ctx, s.client, []string{sequenceKey, logKey}, args...).Uint64()
+	if err != nil {
+		return err
+	}
+
+	var oldest []Change
+	if s.retention.MaxAge > 0 {
+		members, err := s.client.ZRange(ctx, logKey, 0, ageScanSize-1).Result()
+		if err != nil {
+			return err
+		}
+		oldest, err = parseMembers(members)
+		if err != nil {
+			return err
+		}
+	}
+	upTo := s.retention.compactedUpTo(latest, oldest, time.Now())
+	if upTo == 0 {
+		return nil
+	}
+	return compactScript.Run(ctx, s.client, []string{logKey, compactedKey}, upTo).Err()
+}
+
+func (s *redisStore) AddRepository(ctx context.Context, repository string) (bool, error) {
+	added, err := s.client.SAdd(ctx, repositoriesKey, repository).Result()
+	return added > 0, err
+}
+
+func (s *redisStore) RemoveRepository(ctx context.Context, repository string) error {
+	return s.client.SRem(ctx, repositoriesKey, repository).Err()
+}
+
+func (s *redisStore) Since(ctx context.Context, since uint64, n int) ([]Change, uint64, error) {
+	var (
+		compacted *redis.StringCmd
+		members   *redis.StringSliceCmd
+		latest    *redis.StringCmd
+	)
+	// read the log, its compaction and its latest sequence number at once
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		compacted = pipe.Get(ctx, compactedKey)
+		rangeBy := &redis.ZRangeBy{
+			Min:   "(" + strconv.FormatUint(since, 10),
+			Max:   "+inf",
+			Count: int64(n),
+		}
+		if n <= 0 {
+			// a zero count does not limit the range, make it empty instead
+			rangeBy.Max = strconv.FormatUint(since, 10)
+		}
+		members = pipe.ZRangeByScore(ctx, logKey, rangeBy)
+		latest = pipe.Get(ctx, sequenceKey)
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, 0, err
+	}
+
+	compactedUpTo, err := uint64Value(compacted)
+	if err != nil {
+		return nil, 0, err
+	}
+	if since < compactedUpTo {
+		return nil, 0, ErrCompacted
+	}
+	latestSequence, err := uint64Value(latest)
+	if err != nil {
+		return nil, 0, err
+	}
+	changes, err := parseMembers(members.Val())
+	if err != nil {
+		return nil, 0, err
+	}
+	return changes, latestSequence, nil
+}
+
+// uint64Value returns the number held by the key read by cmd, or zero if the
+// key does not exist.
+func uint64Value(cmd *redis.StringCmd) (uint64, error) {
+	v, err := cmd.Uint64()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	return v, err
+}
+
+// parseMembers decodes members of the log, prefixed with their sequence
+// number.
+func parseMembers(members []string) ([]Change, error) {
+	changes := make([]Change, 0, len(members))
+	for _, member := range members {
+		sequence, p, ok := strings.Cut(member, "|")
+		if !ok {
+			return nil, errors.New("changefeed: malformed change")
+		}
+		var change Change
+		if err := json.Unmarshal([]byte(p), &change); err != nil {
+			return nil, err
+		}
+		var err error
+		change.Sequence, err = strconv.ParseUint(sequence, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"mime"
+	"strconv"
+	"strings"
+)
+
+// mediaRange is a media range of an Accept header, with the quality value
+// the client weighted it with.
+type mediaRange struct {
+	typ, subtype string
+	q            float64
+}
+
+// acceptHeader holds the media ranges of the Accept headers of a request.
+type acceptHeader []mediaRange
+
+// parseAccept parses the values of the Accept headers of a request, following
+// RFC 9110. Malformed media ranges, and those with a malformed quality value,
+// are ignored.
+func parseAccept(values []string) acceptHeader {
+	var accept acceptHeader
+	for _, value := range values {
+		// each header value is a comma separated list of media ranges
+		for s := range strings.SplitSeq(value, ",") {
+			if strings.TrimSpace(s) == "" {
+				continue
+			}
+			mediaType, params, err := mime.ParseMediaType(s)
+			if err != nil {
+				continue
+			}
+			typ, subtype, ok := strings.Cut(mediaType, "/")
+			if !ok || typ == "*" && subtype != "*" {
+				continue
+			}
+
+			q := 1.0
+			if v, ok := params["q"]; ok {
+				q, err = strconv.ParseFloat(v, 64)
+				if err != nil || q < 0 || q > 1 {
+					continue
+				}
+			}
+			accept = append(accept, mediaRange{typ: typ, subtype: subtype, q: q})
+		}
+	}
+	return accept
+}
+
+// quality returns the quality value the client weighted mediaType with: that
+// of the most specific media range matching it, or zero, meaning not
+// acceptable, if none does.
+func (accept acceptHeader) quality(mediaType string) float64 {
+	typ, subtype, _ := strings.Cut(mediaType, "/")
+
+	q, specificity := 0.0, -1
+	for _, r := range accept {
+		var s int
+		switch {
+		case r.typ == typ && r.subtype == subtype:
+			s = 2
+		case r.typ == typ && r.subtype == "*":
+			s = 1
+		case r.typ == "*":
+			s = 0
+		default:
+			continue
+		}
+		if s > specificity {
+			q, specificity = r.q, s
+		}
+	}
+	return q
+}
+
+// choose returns the media type among available the client prefers, ties
+// going to the earliest, or false if the client accepts none of them.
+func (accept acceptHeader) choose(available []string) (string, bool) {
+	var (
+		chosen string
+		best   float64
+	)
+	for _, mediaType := range available {
+		if q := accept.quality(mediaType); q > best {
+			chosen, best = mediaType, q
+		}
+	}
+	return chosen, best > 0
+}
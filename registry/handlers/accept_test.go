@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+
+	"github.com/distribution/distribution/v3/manifest/schema2"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/distribution/reference"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestAcceptQuality(t *testing.T) {
+	for _, tc := range []struct {
+		accept    []string
+		mediaType string
+		expected  float64
+	}{
+		{accept: nil, mediaType: v1.MediaTypeImageManifest, expected: 0},
+		{accept: []string{v1.MediaTypeImageManifest}, mediaType: v1.MediaTypeImageManifest, expected: 1},
+		{accept: []string{v1.MediaTypeImageManifest + ";q=0.5"}, mediaType: v1.MediaTypeImageManifest, expected: 0.5},
+		{accept: []string{v1.MediaTypeImageIndex}, mediaType: v1.MediaTypeImageManifest, expected: 0},
+		// values are comma separated lists, possibly repeated
+		{accept: []string{" text/plain , " + v1.MediaTypeImageIndex + " ; q=0.3 ", schema2.MediaTypeManifest}, mediaType: v1.MediaTypeImageIndex, expected: 0.3},
+		// wildcards
+		{accept: []string{"*/*"}, mediaType: v1.MediaTypeImageManifest, expected: 1},
+		{accept: []string{"application/*;q=0.2"}, mediaType: v1.MediaTypeImageManifest, expected: 0.2},
+		{accept: []string{"text/*"}, mediaType: v1.MediaTypeImageManifest, expected: 0},
+		// the most specific media range applies
+		{accept: []string{"*/*;q=0.1, application/*;q=0.4, " + v1.MediaTypeImageManifest + ";q=0.7"}, mediaType: v1.MediaTypeImageManifest, expected: 0.7},
+		{accept: []string{"*/*;q=0.1, application/*;q=0.4"}, mediaType: v1.MediaTypeImageManifest, expected: 0.4},
+		{accept: []string{"*/*, " + v1.MediaTypeImageManifest + ";q=0"}, mediaType: v1.MediaTypeImageManifest, expected: 0},
+		// malformed media ranges are ignored
+		{accept: []string{"application, " + v1.MediaTypeImageManifest + ";q=0.6"}, mediaType: v1.MediaTypeImageManifest, expected: 0.6},
+		{accept: []string{"*/json, ;;, " + v1.MediaTypeImageManifest + ";q=0.6"}, mediaType: v1.MediaTypeImageManifest, expected: 0.6},
+		{accept: []string{v1.MediaTypeImageManifest + ";q=high"}, mediaType: v1.MediaTypeImageManifest, expected: 0},
+		{accept: []string{v1.MediaTypeImageManifest + ";q=2, */*;q=0.1"}, mediaType: v1.MediaTypeImageManifest, expected: 0.1},
+	} {
+		if q := parseAccept(tc.accept).quality(tc.mediaType); q != tc.expected {
+			t.Errorf("unexpected quality of %s for %q: %v != %v", tc.mediaType, tc.accept, q, tc.expected)
+		}
+	}
+}
+
+func TestAcceptChoose(t *testing.T) {
+	available := []string{v1.MediaTypeImageIndex, schema2.MediaTypeManifest}
+	for _, tc := range []struct {
+		accept   string
+		expected string
+		ok       bool
+	}{
+		{accept: v1.MediaTypeImageIndex + ";q=0.9, " + schema2.MediaTypeManifest, expected: schema2.MediaTypeManifest, ok: true},
+		{accept: v1.MediaTypeImageIndex + ", " + schema2.MediaTypeManifest + ";q=0.9", expected: v1.MediaTypeImageIndex, ok: true},
+		// ties go to the earliest available type
+		{accept: schema2.MediaTypeManifest + ", " + v1.MediaTypeImageIndex, expected: v1.MediaTypeImageIndex, ok: true},
+		{accept: "*/*", expected: v1.MediaTypeImageIndex, ok: true},
+		{accept: "*/*, " + v1.MediaTypeImageIndex + ";q=0", expected: schema2.MediaTypeManifest, ok: true},
+		{accept: v1.MediaTypeImageManifest, ok: false},
+	} {
+		chosen, ok := parseAccept([]string{tc.accept}).choose(available)
+		if ok != tc.ok || chosen != tc.expected {
+			t.Errorf("unexpected choice for %q: %q %v != %q %v", tc.accept, chosen, ok, tc.expected, tc.ok)
+		}
+	}
+}
+
+func TestManifestNotAcceptable(t *testing.T) {
+	env := newTestEnv(t, false)
+	defer env.Shutdown()
+
+	name, _ := reference.WithName("foo/accept")
+	pushPlatformImage(t, env, name, "latest", "amd64")
+	tagRef, _ := reference.WithTag(name, "latest")
+	manifestURL, err := env.builder.BuildManifestURL(tagRef)
+	checkErr(t, err, "building manifest url")
+
+	get := func(accept string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, manifestURL, nil)
+		checkErr(t, err, "building request")
+		req.Header.Set("Accept", accept)
+		resp, err := http.DefaultClient.Do(req)
+		checkErr(t, err, "fetching manifest")
+		return resp
+	}
+
+	for _, accept := range []string{"*/*", "application/*;q=0.1", "text/plain, " + v1.MediaTypeImageManifest + ";q=0.2"} {
+		resp := get(accept)
+		checkResponse(t, "fetching manifest with Accept "+accept, resp, http.StatusOK)
+		if ct := resp.Header.Get("Content-Type"); ct != v1.MediaTypeImageManifest {
+			t.Errorf("unexpected content type with Accept %s: %s", accept, ct)
+		}
+		resp.Body.Close()
+	}
+
+	for _, accept := range []string{schema2.MediaTypeManifest, "*/*, " + v1.MediaTypeImageManifest + ";q=0"} {
+		resp := get(accept)
+		checkResponse(t, "fetching manifest with Accept "+accept, resp, http.StatusNotAcceptable)
+		errs, body, _ := checkBodyHasErrorCodes(t, "fetching manifest with Accept "+accept, resp, errcode.ErrorCodeManifestNotAcceptable)
+		resp.Body.Close()
+		if len(errs) != 1 || !bytes.Contains(body, []byte(`{"available":["`+v1.MediaTypeImageManifest+`"]}`)) {
+			t.Errorf("unexpected body with Accept %s: %s", accept, body)
+		}
+	}
+}
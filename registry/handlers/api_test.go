@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
 	"errors"
@@ -19,10 +20,12 @@ import (
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/distribution/distribution/v3"
 	"github.com/distribution/distribution/v3/configuration"
 	"github.com/distribution/distribution/v3/manifest/manifestlist"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
 	"github.com/distribution/distribution/v3/manifest/schema2"
 	"github.com/distribution/distribution/v3/registry/api/errcode"
 	v2 "github.com/distribution/distribution/v3/registry/api/v2"
@@ -669,6 +672,182 @@ func TestTagsAPIMaxTagsClamp(t *testing.T) {
 	}
 }
 
+// TestTagsAPIFiltered tests the filter parameters of the
+// /v2/<name>/tags/list endpoint.
+func TestTagsAPIFiltered(t *testing.T) {
+	env := newTestEnv(t, false)
+	defer env.Shutdown()
+
+	imageName, err := reference.WithName("test")
+	if err != nil {
+		t.Fatalf("unable to parse reference: %v", err)
+	}
+
+	digests := make(map[string]digest.Digest)
+	for _, tag := range []string{"pr-1", "pr-2", "release-1"} {
+		digests[tag] = createRepository(env, t, imageName.Name(), tag)
+	}
+	time.Sleep(10 * time.Millisecond)
+	boundary := time.Now().UTC().Truncate(time.Second).Add(time.Second)
+	time.Sleep(time.Until(boundary) + 10*time.Millisecond)
+	digests["pr-3"] = createRepository(env, t, imageName.Name(), "pr-3")
+
+	getTags := func(t *testing.T, params url.Values) *http.Response {
+		tagsURL, err := env.builder.BuildTagsURL(imageName, params)
+		if err != nil {
+			t.Fatalf("unexpected error building tags URL: %v", err)
+		}
+		resp, err := http.Get(tagsURL)
+		if err != nil {
+			t.Fatalf("unexpected error issuing request: %v", err)
+		}
+		return resp
+	}
+
+	tt := []struct {
+		name               string
+		queryParams        url.Values
+		expectedBody       tagsAPIResponse
+		expectedLinkHeader string
+	}{
+		{
+			name:         "prefix",
+			queryParams:  url.Values{"prefix": []string{"pr-"}},
+			expectedBody: tagsAPIResponse{Name: imageName.Name(), Tags: []string{"pr-1", "pr-2", "pr-3"}},
+		},
+		{
+			name:         "prefix without matches",
+			queryParams:  url.Values{"prefix": []string{"nightly-"}},
+			expectedBody: tagsAPIResponse{Name: imageName.Name(), Tags: []string{}},
+		},
+		{
+			name:         "modified before",
+			queryParams:  url.Values{"modified_before": []string{boundary.Format(time.RFC3339)}},
+			expectedBody: tagsAPIResponse{Name: imageName.Name(), Tags: []string{"pr-1", "pr-2", "release-1"}},
+		},
+		{
+			name: "prefix and modified before",
+			queryParams: url.Values{
+				"prefix":          []string{"pr-"},
+				"modified_before": []string{boundary.Format(time.RFC3339)},
+			},
+			expectedBody: tagsAPIResponse{Name: imageName.Name(), Tags: []string{"pr-1", "pr-2"}},
+		},
+		{
+			name: "prefix and modified after",
+			queryParams: url.Values{
+				"prefix":         []string{"pr-"},
+				"modified_after": []string{boundary.Format(time.RFC3339)},
+			},
+			expectedBody: tagsAPIResponse{Name: imageName.Name(), Tags: []string{"pr-3"}},
+		},
+		{
+			name: "paginated prefix",
+			queryParams: url.Values{
+				"prefix": []string{"pr-"},
+				"n":      []string{"1"},
+				"last":   []string{"pr-1"},
+			},
+			expectedBody:       tagsAPIResponse{Name: imageName.Name(), Tags: []string{"pr-2"}},
+			expectedLinkHeader: `</v2/test/tags/list?last=pr-2&n=1&prefix=pr->; rel="next"`,
+		},
+		{
+			name:         "filter",
+			queryParams:  url.Values{"filter": []string{"*-1"}},
+			expectedBody: tagsAPIResponse{Name: imageName.Name(), Tags: []string{"pr-1", "release-1"}},
+		},
+		{
+			name:         "filter without matches",
+			queryParams:  url.Values{"filter": []string{"nightly-*"}},
+			expectedBody: tagsAPIResponse{Name: imageName.Name(), Tags: []string{}},
+		},
+		{
+			name: "filter and modified after",
+			queryParams: url.Values{
+				"filter":         []string{"pr-?"},
+				"modified_after": []string{boundary.Format(time.RFC3339)},
+			},
+			expectedBody: tagsAPIResponse{Name: imageName.Name(), Tags: []string{"pr-3"}},
+		},
+		{
+			name: "paginated filter",
+			queryParams: url.Values{
+				"filter": []string{"*-1"},
+				"n":      []string{"1"},
+			},
+			expectedBody:       tagsAPIResponse{Name: imageName.Name(), Tags: []string{"pr-1"}},
+			expectedLinkHeader: `</v2/test/tags/list?filter=%2A-1&last=pr-1&n=1>; rel="next"`,
+		},
+		{
+			name: "paginated filter next page",
+			queryParams: url.Values{
+				"filter": []string{"*-1"},
+				"n":      []string{"1"},
+				"last":   []string{"pr-1"},
+			},
+			expectedBody:       tagsAPIResponse{Name: imageName.Name(), Tags: []string{"release-1"}},
+			expectedLinkHeader: `</v2/test/tags/list?filter=%2A-1&last=release-1&n=1>; rel="next"`,
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			resp := getTags(t, test.queryParams)
+			defer resp.Body.Close()
+			checkResponse(t, test.name, resp, http.StatusOK)
+
+			var body tagsAPIResponse
+			if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+				t.Fatalf("unexpected error decoding response body: %v", err)
+			}
+			if !reflect.DeepEqual(body, test.expectedBody) {
+				t.Fatalf("expected response body to be:\n%+v\ngot:\n%+v", test.expectedBody, body)
+			}
+			if resp.Header.Get("Link") != test.expectedLinkHeader {
+				t.Fatalf("expected response Link header to be %q, got %q", test.expectedLinkHeader, resp.Header.Get("Link"))
+			}
+		})
+	}
+
+	t.Run("detail", func(t *testing.T) {
+		resp := getTags(t, url.Values{
+			"prefix":          []string{"pr-"},
+			"modified_before": []string{boundary.Format(time.RFC3339)},
+			"detail":          []string{"true"},
+		})
+		defer resp.Body.Close()
+		checkResponse(t, "listing tag details", resp, http.StatusOK)
+
+		var body tagsDetailAPIResponse
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatalf("unexpected error decoding response body: %v", err)
+		}
+		if body.Name != imageName.Name() || len(body.Tags) != 2 {
+			t.Fatalf("unexpected response body: %+v", body)
+		}
+		for i, tag := range []string{"pr-1", "pr-2"} {
+			detail := body.Tags[i]
+			if detail.Name != tag || detail.Digest != digests[tag] {
+				t.Fatalf("unexpected details for tag %s: %+v", tag, detail)
+			}
+			if detail.Modified.IsZero() || !detail.Modified.Before(boundary) {
+				t.Fatalf("unexpected modification time for tag %s: %v", tag, detail.Modified)
+			}
+		}
+	})
+
+	for _, params := range []url.Values{
+		{"modified_before": []string{"yesterday"}},
+		{"modified_after": []string{"2024-01-01"}},
+		{"detail": []string{"maybe"}},
+	} {
+		resp := getTags(t, params)
+		checkResponse(t, "listing tags with an invalid filter", resp, http.StatusBadRequest)
+		checkBodyHasErrorCodes(t, "listing tags with an invalid filter", resp, errcode.ErrorCodeTagFilterInvalid)
+		resp.Body.Close()
+	}
+}
+
 func checkLink(t *testing.T, urlStr string, numEntries int, last string) url.Values {
 	re := regexp.MustCompile("<(/v2/_catalog.*)>; rel=\"next\"")
 	matches := re.FindStringSubmatch(urlStr)
@@ -1405,6 +1584,78 @@ func TestManifestAPI_DeleteTag(t *testing.T) {
 	checkResponse(t, msg, resp, http.StatusOK)
 }
 
+// TestManifestAPI_DeletePruneEmpty validates that a repository is no longer
+// listed by the catalog once its last tag and manifest are deleted, when
+// pruning empty repositories is enabled.
+func TestManifestAPI_DeletePruneEmpty(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory":    configuration.Parameters{},
+			"delete":      configuration.Parameters{"enabled": true, "pruneempty": true},
+			"maintenance": configuration.Parameters{"uploadpurging": map[any]any{"enabled": false}},
+		},
+		Catalog: configuration.Catalog{MaxEntries: 100},
+	}
+	config.HTTP.Headers = headerConfig
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	catalog := func() []string {
+		t.Helper()
+		catalogURL, err := env.builder.BuildCatalogURL()
+		checkErr(t, err, "building catalog url")
+		resp, err := http.Get(catalogURL)
+		checkErr(t, err, "fetching catalog")
+		defer resp.Body.Close()
+		checkResponse(t, "fetching catalog", resp, http.StatusOK)
+		var ctlg struct {
+			Repositories []string `json:"repositories"`
+		}
+		checkErr(t, json.NewDecoder(resp.Body).Decode(&ctlg), "decoding catalog")
+		return ctlg.Repositories
+	}
+	deleteManifest := func(ref reference.Named) {
+		t.Helper()
+		u, err := env.builder.BuildManifestURL(ref)
+		checkErr(t, err, "building manifest url")
+		resp, err := httpDelete(u)
+		checkErr(t, err, "deleting manifest")
+		resp.Body.Close()
+		checkResponse(t, "deleting "+ref.String(), resp, http.StatusAccepted)
+	}
+
+	imageName, _ := reference.WithName("foo/bar")
+	latest := createRepository(env, t, imageName.Name(), "latest")
+	stable := createRepository(env, t, imageName.Name(), "stable")
+	createRepository(env, t, "foo/baz", "latest")
+
+	// the untagged manifest keeps the repository
+	stableRef, _ := reference.WithTag(imageName, "stable")
+	deleteManifest(stableRef)
+	if repositories := catalog(); !reflect.DeepEqual(repositories, []string{"foo/bar", "foo/baz"}) {
+		t.Fatalf("unexpected catalog after deleting a tag: %v", repositories)
+	}
+	stableDigestRef, _ := reference.WithDigest(imageName, stable)
+	deleteManifest(stableDigestRef)
+	if repositories := catalog(); !reflect.DeepEqual(repositories, []string{"foo/bar", "foo/baz"}) {
+		t.Fatalf("unexpected catalog after deleting an untagged manifest: %v", repositories)
+	}
+
+	// deleting the last manifest deletes its last tag, emptying the
+	// repository
+	latestRef, _ := reference.WithDigest(imageName, latest)
+	deleteManifest(latestRef)
+	if repositories := catalog(); !reflect.DeepEqual(repositories, []string{"foo/baz"}) {
+		t.Fatalf("unexpected catalog after emptying the repository: %v", repositories)
+	}
+
+	// the repository can be pushed to again
+	createRepository(env, t, imageName.Name(), "latest")
+	if repositories := catalog(); !reflect.DeepEqual(repositories, []string{"foo/bar", "foo/baz"}) {
+		t.Fatalf("unexpected catalog after pushing again: %v", repositories)
+	}
+}
+
 func TestManifestAPI_DeleteTag_Unknown(t *testing.T) {
 	env := newTestEnv(t, true)
 	defer env.Shutdown()
@@ -2070,8 +2321,8 @@ func testManifestAPIManifestList(t *testing.T, env *testEnv, args manifestArgs)
 		t.Fatalf("Error constructing request: %s", err)
 	}
 	// multiple headers in mixed list format to ensure we parse correctly server-side
-	req.Header.Set("Accept", fmt.Sprintf(` %s ; q=0.8 `, manifestlist.MediaTypeManifestList))
-	req.Header.Add("Accept", schema2.MediaTypeManifest)
+	req.Header.Set("Accept", fmt.Sprintf(` %s ; q=0.8 `, schema2.MediaTypeManifest))
+	req.Header.Add("Accept", manifestlist.MediaTypeManifestList)
 	resp, err = http.DefaultClient.Do(req)
 	if err != nil {
 		t.Fatalf("unexpected error fetching manifest list: %v", err)
@@ -2560,6 +2811,8 @@ func finishUpload(t *testing.T, ub *v2.URLBuilder, name reference.Named, uploadU
 type chunkOptions struct {
 	// Content-Range header to set when pushing chunks
 	contentRange string
+	// headers are set when pushing chunks
+	headers http.Header
 }
 
 func doPushChunk(t *testing.T, uploadURLBase string, body io.Reader, options chunkOptions) (*http.Response, error) {
@@ -2582,6 +2835,9 @@ func doPushChunk(t *testing.T, uploadURLBase string, body io.Reader, options chu
 	if options.contentRange != "" {
 		req.Header.Set("Content-Range", options.contentRange)
 	}
+	for name, values := range options.headers {
+		req.Header[name] = values
+	}
 
 	resp, err := http.DefaultClient.Do(req)
 
@@ -2958,3 +3214,707 @@ func TestProxyManifestGetByTag(t *testing.T) {
 		"Docker-Content-Digest": []string{newDigest.String()},
 	})
 }
+
+func TestManifestDefaultTag(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory":    configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[any]any{"enabled": false}},
+		},
+		Catalog: configuration.Catalog{MaxEntries: 5},
+		Tags: configuration.Tags{
+			MaxTags: 1000,
+			DefaultTag: configuration.DefaultTag{
+				Enabled:      true,
+				Repositories: map[string]string{"foo/bar": "stable"},
+			},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	stableDigest := createRepository(env, t, "foo/bar", "stable")
+	latestDigest := createRepository(env, t, "foo/baz", "latest")
+
+	for _, tc := range []struct {
+		name     string
+		expected digest.Digest
+	}{
+		{name: "foo/bar", expected: stableDigest},
+		{name: "foo/baz", expected: latestDigest},
+	} {
+		for _, method := range []string{http.MethodGet, http.MethodHead} {
+			req, err := http.NewRequest(method, env.server.URL+"/v2/"+tc.name+"/manifests/", nil)
+			checkErr(t, err, "building default tag request")
+			resp, err := http.DefaultClient.Do(req)
+			checkErr(t, err, "fetching default tag manifest")
+			checkResponse(t, "fetching default tag manifest", resp, http.StatusOK)
+			checkHeaders(t, resp, http.Header{
+				"Docker-Content-Digest": []string{tc.expected.String()},
+			})
+			resp.Body.Close()
+		}
+	}
+
+	// repositories without the default tag report an unknown manifest
+	createRepository(env, t, "foo/qux", "v1")
+	resp, err := http.Get(env.server.URL + "/v2/foo/qux/manifests/")
+	checkErr(t, err, "fetching missing default tag manifest")
+	defer resp.Body.Close()
+	checkResponse(t, "fetching missing default tag manifest", resp, http.StatusNotFound)
+	checkBodyHasErrorCodes(t, "fetching missing default tag manifest", resp, errcode.ErrorCodeManifestUnknown)
+
+	// the endpoint is read only
+	req, err := http.NewRequest(http.MethodDelete, env.server.URL+"/v2/foo/bar/manifests/", nil)
+	checkErr(t, err, "building default tag delete request")
+	resp, err = http.DefaultClient.Do(req)
+	checkErr(t, err, "deleting default tag manifest")
+	defer resp.Body.Close()
+	checkResponse(t, "deleting default tag manifest", resp, http.StatusMethodNotAllowed)
+}
+
+func TestManifestDefaultTagDisabled(t *testing.T) {
+	env := newTestEnv(t, false)
+	defer env.Shutdown()
+
+	dgst := createRepository(env, t, "foo/bar", "latest")
+
+	resp, err := http.Get(env.server.URL + "/v2/foo/bar/manifests/")
+	checkErr(t, err, "fetching default tag manifest")
+	defer resp.Body.Close()
+	checkResponse(t, "fetching default tag manifest", resp, http.StatusNotFound)
+
+	// explicit references are unaffected
+	imageName, _ := reference.WithName("foo/bar")
+	tagRef, _ := reference.WithTag(imageName, "latest")
+	manifestURL, err := env.builder.BuildManifestURL(tagRef)
+	checkErr(t, err, "building manifest url")
+	resp, err = http.Get(manifestURL)
+	checkErr(t, err, "fetching manifest by tag")
+	defer resp.Body.Close()
+	checkResponse(t, "fetching manifest by tag", resp, http.StatusOK)
+	checkHeaders(t, resp, http.Header{
+		"Docker-Content-Digest": []string{dgst.String()},
+	})
+}
+
+func TestManifestTagDigestPinning(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory":    configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[any]any{"enabled": false}},
+		},
+		Tags: configuration.Tags{
+			DigestPinning: configuration.DigestPinning{Enabled: true},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/bar")
+	tagRef, _ := reference.WithTag(imageName, "latest")
+	manifestURL, err := env.builder.BuildManifestURL(tagRef)
+	checkErr(t, err, "building manifest url")
+
+	getPinned := func(last digest.Digest) *http.Response {
+		req, err := http.NewRequest(http.MethodGet, manifestURL, nil)
+		checkErr(t, err, "building manifest request")
+		req.Header.Set("Docker-Tag-If-None-Match", `"`+last.String()+`"`)
+		resp, err := http.DefaultClient.Do(req)
+		checkErr(t, err, "fetching manifest")
+		return resp
+	}
+
+	// unchanged tag
+	pinned := createRepository(env, t, imageName.Name(), "latest")
+	resp := getPinned(pinned)
+	defer resp.Body.Close()
+	checkResponse(t, "fetching unchanged tag", resp, http.StatusNotModified)
+	checkHeaders(t, resp, http.Header{
+		"Docker-Tag-Digest": []string{pinned.String()},
+	})
+
+	// tag moved to another manifest
+	current := createRepository(env, t, imageName.Name(), "latest")
+	if current == pinned {
+		t.Fatal("expected the tag to point to a new manifest")
+	}
+	resp = getPinned(pinned)
+	defer resp.Body.Close()
+	checkResponse(t, "fetching changed tag", resp, http.StatusOK)
+	checkHeaders(t, resp, http.Header{
+		"Docker-Tag-Digest":     []string{current.String()},
+		"Docker-Content-Digest": []string{current.String()},
+	})
+	p, err := io.ReadAll(resp.Body)
+	checkErr(t, err, "reading manifest")
+	if dgst := digest.FromBytes(p); dgst != current {
+		t.Fatalf("unexpected manifest served: %s != %s", dgst, current)
+	}
+
+	// references by digest are unaffected
+	digestRef, _ := reference.WithDigest(imageName, pinned)
+	digestURL, err := env.builder.BuildManifestURL(digestRef)
+	checkErr(t, err, "building manifest url")
+	req, err := http.NewRequest(http.MethodGet, digestURL, nil)
+	checkErr(t, err, "building manifest request")
+	req.Header.Set("Docker-Tag-If-None-Match", pinned.String())
+	resp, err = http.DefaultClient.Do(req)
+	checkErr(t, err, "fetching manifest by digest")
+	defer resp.Body.Close()
+	checkResponse(t, "fetching manifest by digest", resp, http.StatusOK)
+	if v := resp.Header.Get("Docker-Tag-Digest"); v != "" {
+		t.Fatalf("unexpected Docker-Tag-Digest header for digest reference: %q", v)
+	}
+}
+
+func TestManifestRepositoryHint(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory":    configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[any]any{"enabled": false}},
+		},
+		Tags: configuration.Tags{
+			RepositoryHint: configuration.RepositoryHint{Enabled: true},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	existing, _ := reference.WithName("foo/bar")
+	missing, _ := reference.WithName("foo/baz")
+	createRepository(env, t, existing.Name(), "latest")
+
+	probe := func(method string, ref reference.Named) *http.Response {
+		t.Helper()
+		u, err := env.builder.BuildManifestURL(ref)
+		checkErr(t, err, "building manifest url")
+		req, err := http.NewRequest(method, u, nil)
+		checkErr(t, err, "building manifest request")
+		resp, err := http.DefaultClient.Do(req)
+		checkErr(t, err, "probing manifest")
+		return resp
+	}
+
+	unknownDigest := digest.FromString("unknown")
+	existingTag, _ := reference.WithTag(existing, "latest")
+	missingTag, _ := reference.WithTag(existing, "missing")
+	missingDigest, _ := reference.WithDigest(existing, unknownDigest)
+	missingRepoTag, _ := reference.WithTag(missing, "latest")
+	missingRepoDigest, _ := reference.WithDigest(missing, unknownDigest)
+	for _, tc := range []struct {
+		name   string
+		ref    reference.Named
+		exists string
+	}{
+		{"missing tag", missingTag, "true"},
+		{"missing digest", missingDigest, "true"},
+		{"missing repository", missingRepoTag, "false"},
+		{"missing repository by digest", missingRepoDigest, "false"},
+	} {
+		resp := probe(http.MethodHead, tc.ref)
+		resp.Body.Close()
+		checkResponse(t, "probing "+tc.name, resp, http.StatusNotFound)
+		checkHeaders(t, resp, http.Header{
+			"Docker-Repository-Exists": []string{tc.exists},
+		})
+
+		// the error body of GET requests is unchanged and carries no hint
+		resp = probe(http.MethodGet, tc.ref)
+		checkBodyHasErrorCodes(t, "fetching "+tc.name, resp, errcode.ErrorCodeManifestUnknown)
+		resp.Body.Close()
+		if v := resp.Header.Get("Docker-Repository-Exists"); v != "" {
+			t.Fatalf("unexpected hint fetching %s: %q", tc.name, v)
+		}
+	}
+
+	// found manifests carry no hint
+	resp := probe(http.MethodHead, existingTag)
+	resp.Body.Close()
+	checkResponse(t, "probing existing manifest", resp, http.StatusOK)
+	if v := resp.Header.Get("Docker-Repository-Exists"); v != "" {
+		t.Fatalf("unexpected hint probing an existing manifest: %q", v)
+	}
+
+	// the hint is opt-in
+	env = newTestEnv(t, false)
+	defer env.Shutdown()
+	resp = probe(http.MethodHead, missingRepoTag)
+	resp.Body.Close()
+	checkResponse(t, "probing without hint", resp, http.StatusNotFound)
+	if v := resp.Header.Get("Docker-Repository-Exists"); v != "" {
+		t.Fatalf("unexpected hint when disabled: %q", v)
+	}
+}
+
+func TestManifestListWindowsOSVersion(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory":    configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[any]any{"enabled": false}},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	config.Validation.Manifests.Indexes.RequireWindowsOSVersion = true
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/windows")
+	dgst := createRepository(env, t, imageName.Name(), "image")
+
+	tagRef, _ := reference.WithTag(imageName, "latest")
+	manifestURL, err := env.builder.BuildManifestURL(tagRef)
+	checkErr(t, err, "building manifest url")
+
+	manifestList := &manifestlist.ManifestList{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: manifestlist.MediaTypeManifestList,
+		Manifests: []manifestlist.ManifestDescriptor{
+			{
+				Descriptor: v1.Descriptor{
+					Digest:    dgst,
+					MediaType: schema2.MediaTypeManifest,
+				},
+				Platform: manifestlist.PlatformSpec{
+					Architecture: "amd64",
+					OS:           "windows",
+				},
+			},
+		},
+	}
+
+	resp := putManifest(t, "putting windows manifest list without os.version", manifestURL, manifestlist.MediaTypeManifestList, manifestList)
+	defer resp.Body.Close()
+	checkResponse(t, "putting windows manifest list without os.version", resp, http.StatusBadRequest)
+	checkBodyHasErrorCodes(t, "putting windows manifest list without os.version", resp, errcode.ErrorCodeManifestInvalid)
+
+	manifestList.Manifests[0].Platform.OSVersion = "10.0.20348.2340"
+	resp = putManifest(t, "putting windows manifest list with os.version", manifestURL, manifestlist.MediaTypeManifestList, manifestList)
+	defer resp.Body.Close()
+	checkResponse(t, "putting windows manifest list with os.version", resp, http.StatusCreated)
+}
+
+func TestManifestListVerifyChildren(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory":    configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[any]any{"enabled": false}},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	config.Validation.Manifests.Indexes.Platforms = "none"
+	config.Validation.Manifests.Indexes.VerifyChildren = true
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/children")
+	dgst := createRepository(env, t, imageName.Name(), "image")
+
+	digestRef, _ := reference.WithDigest(imageName, dgst)
+	childURL, err := env.builder.BuildManifestURL(digestRef)
+	checkErr(t, err, "building manifest url")
+	req, err := http.NewRequest(http.MethodHead, childURL, nil)
+	checkErr(t, err, "building head request")
+	req.Header.Set("Accept", schema2.MediaTypeManifest)
+	resp, err := http.DefaultClient.Do(req)
+	checkErr(t, err, "heading child manifest")
+	resp.Body.Close()
+	checkResponse(t, "heading child manifest", resp, http.StatusOK)
+
+	tagRef, _ := reference.WithTag(imageName, "latest")
+	manifestURL, err := env.builder.BuildManifestURL(tagRef)
+	checkErr(t, err, "building manifest url")
+
+	child := v1.Descriptor{
+		Digest:    dgst,
+		Size:      resp.ContentLength,
+		MediaType: resp.Header.Get("Content-Type"),
+		Platform:  &v1.Platform{Architecture: "amd64", OS: "linux"},
+	}
+	// never pushed, so skipped
+	missing := v1.Descriptor{
+		Digest:    digest.FromString("missing"),
+		Size:      1,
+		MediaType: v1.MediaTypeImageManifest,
+		Platform:  &v1.Platform{Architecture: "arm64", OS: "linux"},
+	}
+	index := func(children ...v1.Descriptor) *ocischema.DeserializedImageIndex {
+		t.Helper()
+		index, err := ocischema.FromDescriptors(children, nil)
+		checkErr(t, err, "building image index")
+		return index
+	}
+
+	for _, tc := range []struct {
+		name   string
+		modify func(descriptor *v1.Descriptor)
+		status int
+	}{
+		{name: "size mismatch", modify: func(descriptor *v1.Descriptor) { descriptor.Size++ }, status: http.StatusBadRequest},
+		{name: "media type mismatch", modify: func(descriptor *v1.Descriptor) { descriptor.MediaType = v1.MediaTypeImageManifest }, status: http.StatusBadRequest},
+		{name: "matching", modify: func(*v1.Descriptor) {}, status: http.StatusCreated},
+	} {
+		descriptor := child
+		tc.modify(&descriptor)
+		resp := putManifest(t, "putting image index with "+tc.name, manifestURL, v1.MediaTypeImageIndex, index(descriptor, missing))
+		defer resp.Body.Close()
+		checkResponse(t, "putting image index with "+tc.name, resp, tc.status)
+		if tc.status == http.StatusBadRequest {
+			checkBodyHasErrorCodes(t, "putting image index with "+tc.name, resp, errcode.ErrorCodeManifestInvalid)
+		}
+	}
+}
+
+func TestBlobMediaTypes(t *testing.T) {
+	const configMediaType = "application/vnd.example.config.v1+json"
+
+	for _, enabled := range []bool{false, true} {
+		t.Run(fmt.Sprintf("enabled=%t", enabled), func(t *testing.T) {
+			config := configuration.Configuration{
+				Storage: configuration.Storage{
+					"inmemory":    configuration.Parameters{},
+					"maintenance": configuration.Parameters{"uploadpurging": map[any]any{"enabled": false}},
+					"cache":       configuration.Parameters{"blobdescriptor": "inmemory"},
+				},
+			}
+			if enabled {
+				config.Storage["mediatypes"] = configuration.Parameters{"enabled": true}
+			}
+			config.HTTP.Headers = headerConfig
+			env := newTestEnvWithConfig(t, &config)
+			defer env.Shutdown()
+
+			imageName, _ := reference.WithName("foo/artifact")
+			push := func(content []byte) v1.Descriptor {
+				t.Helper()
+				dgst := digest.FromBytes(content)
+				uploadURLBase, _ := startPushLayer(t, env, imageName)
+				pushLayer(t, env.builder, imageName, dgst, uploadURLBase, bytes.NewReader(content))
+				return v1.Descriptor{Digest: dgst, Size: int64(len(content))}
+			}
+
+			configDesc := push([]byte(`{"name":"artifact"}`))
+			configDesc.MediaType = configMediaType
+			layerDesc := push([]byte("layer contents"))
+			layerDesc.MediaType = v1.MediaTypeImageLayerGzip
+			// never referenced by a manifest
+			orphanDesc := push([]byte("orphan contents"))
+
+			tagRef, _ := reference.WithTag(imageName, "latest")
+			manifestURL, err := env.builder.BuildManifestURL(tagRef)
+			checkErr(t, err, "building manifest url")
+			resp := putManifest(t, "putting manifest", manifestURL, v1.MediaTypeImageManifest, &ocischema.Manifest{
+				Versioned: specs.Versioned{SchemaVersion: 2},
+				MediaType: v1.MediaTypeImageManifest,
+				Config:    configDesc,
+				Layers:    []v1.Descriptor{layerDesc},
+			})
+			defer resp.Body.Close()
+			checkResponse(t, "putting manifest", resp, http.StatusCreated)
+
+			for _, tc := range []struct {
+				desc              v1.Descriptor
+				expectedMediaType string
+			}{
+				{desc: configDesc, expectedMediaType: configMediaType},
+				{desc: layerDesc, expectedMediaType: v1.MediaTypeImageLayerGzip},
+				{desc: orphanDesc, expectedMediaType: "application/octet-stream"},
+			} {
+				if !enabled {
+					tc.expectedMediaType = "application/octet-stream"
+				}
+
+				ref, _ := reference.WithDigest(imageName, tc.desc.Digest)
+				blobURL, err := env.builder.BuildBlobURL(ref)
+				checkErr(t, err, "building blob url")
+				resp, err := http.Get(blobURL)
+				checkErr(t, err, "fetching blob")
+				defer resp.Body.Close()
+				checkResponse(t, "fetching blob", resp, http.StatusOK)
+				checkHeaders(t, resp, http.Header{
+					"Content-Type":          []string{tc.expectedMediaType},
+					"Content-Length":        []string{fmt.Sprint(tc.desc.Size)},
+					"Docker-Content-Digest": []string{tc.desc.Digest.String()},
+				})
+			}
+		})
+	}
+}
+
+func TestBlobUploadDecompressionLimits(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory":    configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[any]any{"enabled": false}},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	config.Validation.Blobs.Decompression = configuration.ValidationDecompression{Enabled: true, MaxRatio: 100}
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/bomb")
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(make([]byte, 4<<20)); err != nil {
+		t.Fatalf("unexpected error compressing layer: %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("unexpected error compressing layer: %v", err)
+	}
+	bomb := buf.Bytes()
+	dgst := digest.FromBytes(bomb)
+
+	uploadURLBase, _ := startPushLayer(t, env, imageName)
+	resp, err := doPushLayer(t, env.builder, imageName, dgst, uploadURLBase, bytes.NewReader(bomb))
+	if err != nil {
+		t.Fatalf("unexpected error pushing layer: %v", err)
+	}
+	defer resp.Body.Close()
+	checkResponse(t, "pushing decompression bomb", resp, http.StatusNotFound)
+	checkBodyHasErrorCodes(t, "pushing decompression bomb", resp, errcode.ErrorCodeBlobUploadInvalid)
+
+	ref, _ := reference.WithDigest(imageName, dgst)
+	layerURL, err := env.builder.BuildBlobURL(ref)
+	if err != nil {
+		t.Fatalf("unexpected error building layer url: %v", err)
+	}
+	resp, err = http.Head(layerURL)
+	if err != nil {
+		t.Fatalf("unexpected error checking layer: %v", err)
+	}
+	resp.Body.Close()
+	checkResponse(t, "checking rejected layer", resp, http.StatusNotFound)
+}
+
+func TestManifestConfigMediaTypes(t *testing.T) {
+	const (
+		helmConfigMediaType    = "application/vnd.cncf.helm.config.v1+json"
+		exampleConfigMediaType = "application/vnd.example.config.v1+json"
+	)
+
+	for _, tc := range []struct {
+		name        string
+		imageConfig configuration.ValidationImageConfig
+		accepted    map[string]bool
+	}{
+		{
+			name:     "disabled",
+			accepted: map[string]bool{helmConfigMediaType: true, exampleConfigMediaType: true},
+		},
+		{
+			name:        "enabled",
+			imageConfig: configuration.ValidationImageConfig{Enabled: true},
+			accepted:    map[string]bool{helmConfigMediaType: true},
+		},
+		{
+			name:        "artifacts disabled",
+			imageConfig: configuration.ValidationImageConfig{Enabled: true, DisableArtifacts: true},
+			accepted:    map[string]bool{},
+		},
+		{
+			name: "allowed",
+			imageConfig: configuration.ValidationImageConfig{
+				Enabled:          true,
+				DisableArtifacts: true,
+				Allow:            []string{exampleConfigMediaType},
+			},
+			accepted: map[string]bool{exampleConfigMediaType: true},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			config := configuration.Configuration{
+				Storage: configuration.Storage{
+					"inmemory":    configuration.Parameters{},
+					"maintenance": configuration.Parameters{"uploadpurging": map[any]any{"enabled": false}},
+				},
+			}
+			config.Validation.Manifests.ImageConfig = tc.imageConfig
+			config.HTTP.Headers = headerConfig
+			env := newTestEnvWithConfig(t, &config)
+			defer env.Shutdown()
+
+			// images are always accepted
+			createRepository(env, t, "foo/image", "latest")
+
+			imageName, _ := reference.WithName("foo/chart")
+			for _, mediaType := range []string{helmConfigMediaType, exampleConfigMediaType} {
+				content := []byte(`{"name":"chart","version":"1.0.0"}`)
+				dgst := digest.FromBytes(content)
+				uploadURLBase, _ := startPushLayer(t, env, imageName)
+				pushLayer(t, env.builder, imageName, dgst, uploadURLBase, bytes.NewReader(content))
+
+				tagRef, _ := reference.WithTag(imageName, "1.0.0")
+				manifestURL, err := env.builder.BuildManifestURL(tagRef)
+				checkErr(t, err, "building manifest url")
+				resp := putManifest(t, "putting manifest", manifestURL, v1.MediaTypeImageManifest, &ocischema.Manifest{
+					Versioned: specs.Versioned{SchemaVersion: 2},
+					MediaType: v1.MediaTypeImageManifest,
+					Config:    v1.Descriptor{MediaType: mediaType, Digest: dgst, Size: int64(len(content))},
+					Layers:    []v1.Descriptor{},
+				})
+				defer resp.Body.Close()
+				if tc.accepted[mediaType] {
+					checkResponse(t, "putting manifest with config "+mediaType, resp, http.StatusCreated)
+				} else {
+					checkResponse(t, "putting manifest with config "+mediaType, resp, http.StatusBadRequest)
+					checkBodyHasErrorCodes(t, "putting manifest with config "+mediaType, resp, errcode.ErrorCodeManifestInvalid)
+				}
+			}
+		})
+	}
+}
+
+func TestCatalogAPIDetail(t *testing.T) {
+	const helmConfigMediaType = "application/vnd.cncf.helm.config.v1+json"
+
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory":    configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[any]any{"enabled": false}},
+		},
+	}
+	config.Catalog.MaxEntries = 100
+	config.Catalog.ArtifactTypes = true
+	config.Validation.Manifests.ImageConfig.Enabled = true
+	config.HTTP.Headers = headerConfig
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	createRepository(env, t, "foo/image", "latest")
+
+	imageName, _ := reference.WithName("foo/chart")
+	push := func(content []byte) v1.Descriptor {
+		t.Helper()
+		dgst := digest.FromBytes(content)
+		uploadURLBase, _ := startPushLayer(t, env, imageName)
+		pushLayer(t, env.builder, imageName, dgst, uploadURLBase, bytes.NewReader(content))
+		return v1.Descriptor{Digest: dgst, Size: int64(len(content))}
+	}
+	configDesc := push([]byte(`{"name":"chart","version":"1.0.0"}`))
+	configDesc.MediaType = helmConfigMediaType
+	emptyDesc := push([]byte("{}"))
+	emptyDesc.MediaType = v1.MediaTypeEmptyJSON
+	for tag, manifest := range map[string]*ocischema.Manifest{
+		"1.0.0": {
+			Versioned: specs.Versioned{SchemaVersion: 2},
+			MediaType: v1.MediaTypeImageManifest,
+			Config:    configDesc,
+			Layers:    []v1.Descriptor{},
+		},
+		"sbom": {
+			Versioned:    specs.Versioned{SchemaVersion: 2},
+			MediaType:    v1.MediaTypeImageManifest,
+			ArtifactType: "application/spdx+json",
+			Config:       emptyDesc,
+			Layers:       []v1.Descriptor{},
+		},
+	} {
+		tagRef, _ := reference.WithTag(imageName, tag)
+		manifestURL, err := env.builder.BuildManifestURL(tagRef)
+		checkErr(t, err, "building manifest url")
+		resp := putManifest(t, "putting manifest", manifestURL, v1.MediaTypeImageManifest, manifest)
+		resp.Body.Close()
+		checkResponse(t, "putting manifest", resp, http.StatusCreated)
+	}
+
+	getCatalog := func(t *testing.T, params url.Values) *http.Response {
+		catalogURL, err := env.builder.BuildCatalogURL(params)
+		checkErr(t, err, "building catalog url")
+		resp, err := http.Get(catalogURL)
+		checkErr(t, err, "fetching catalog")
+		return resp
+	}
+
+	resp := getCatalog(t, url.Values{"detail": []string{"true"}})
+	defer resp.Body.Close()
+	checkResponse(t, "fetching catalog detail", resp, http.StatusOK)
+	var body catalogDetailAPIResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("unexpected error decoding catalog: %v", err)
+	}
+	expected := catalogDetailAPIResponse{Repositories: []repositoryDetailJSON{
+		{Name: "foo/chart", ArtifactTypes: []string{helmConfigMediaType, "application/spdx+json"}},
+		{Name: "foo/image", ArtifactTypes: []string{schema2.MediaTypeImageConfig}},
+	}}
+	slices.Sort(expected.Repositories[0].ArtifactTypes)
+	if !reflect.DeepEqual(body, expected) {
+		t.Fatalf("expected catalog detail to be:\n%+v\ngot:\n%+v", expected, body)
+	}
+
+	resp = getCatalog(t, url.Values{"detail": []string{"true"}, "n": []string{"1"}})
+	defer resp.Body.Close()
+	checkResponse(t, "fetching paginated catalog detail", resp, http.StatusOK)
+	if link, expected := resp.Header.Get("Link"), `</v2/_catalog?detail=true&last=foo%2Fchart&n=1>; rel="next"`; link != expected {
+		t.Fatalf("expected Link header %q, got %q", expected, link)
+	}
+
+	resp = getCatalog(t, url.Values{"detail": []string{"maybe"}})
+	defer resp.Body.Close()
+	checkResponse(t, "fetching catalog with invalid detail", resp, http.StatusBadRequest)
+	checkBodyHasErrorCodes(t, "fetching catalog with invalid detail", resp, errcode.ErrorCodeCatalogDetailInvalid)
+}
+
+// TestCatalogAPIDetailDeletedManifests validates that the artifact types of
+// the deleted manifests are no longer reported, and that the artifact types
+// are only reported when enabled.
+func TestCatalogAPIDetailDeletedManifests(t *testing.T) {
+	getDetail := func(t *testing.T, env *testEnv) *http.Response {
+		t.Helper()
+		catalogURL, err := env.builder.BuildCatalogURL(url.Values{"detail": []string{"true"}})
+		checkErr(t, err, "building catalog url")
+		resp, err := http.Get(catalogURL)
+		checkErr(t, err, "fetching catalog")
+		return resp
+	}
+
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory":    configuration.Parameters{},
+			"delete":      configuration.Parameters{"enabled": true},
+			"maintenance": configuration.Parameters{"uploadpurging": map[any]any{"enabled": false}},
+		},
+	}
+	config.Catalog.MaxEntries = 100
+	config.HTTP.Headers = headerConfig
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	createRepository(env, t, "foo/image", "latest")
+	resp := getDetail(t, env)
+	checkResponse(t, "fetching catalog detail", resp, http.StatusMethodNotAllowed)
+	checkBodyHasErrorCodes(t, "fetching catalog detail", resp, errcode.ErrorCodeUnsupported)
+	resp.Body.Close()
+
+	config.Catalog.ArtifactTypes = true
+	env = newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	dgst := createRepository(env, t, "foo/image", "latest")
+	imageName, _ := reference.WithName("foo/image")
+	ref, _ := reference.WithDigest(imageName, dgst)
+	manifestURL, err := env.builder.BuildManifestURL(ref)
+	checkErr(t, err, "building manifest url")
+	resp, err = httpDelete(manifestURL)
+	checkErr(t, err, "deleting manifest")
+	resp.Body.Close()
+	checkResponse(t, "deleting manifest", resp, http.StatusAccepted)
+
+	resp = getDetail(t, env)
+	defer resp.Body.Close()
+	checkResponse(t, "fetching catalog detail", resp, http.StatusOK)
+	var body catalogDetailAPIResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("unexpected error decoding catalog: %v", err)
+	}
+	expected := catalogDetailAPIResponse{Repositories: []repositoryDetailJSON{
+		{Name: "foo/image", ArtifactTypes: []string{}},
+	}}
+	if !reflect.DeepEqual(body, expected) {
+		t.Fatalf("expected catalog detail to be:\n%+v\ngot:\n%+v", expected, body)
+	}
+}
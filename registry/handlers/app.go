@@ -5,16 +5,19 @@ import (
 	"crypto/rand"
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
 	"expvar"
 	"fmt"
 	"math"
 	"math/big"
 	"net"
 	"net/http"
+	"net/netip"
 	"net/url"
 	"os"
 	"regexp"
 	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -24,25 +27,34 @@ import (
 	"github.com/distribution/distribution/v3/health"
 	"github.com/distribution/distribution/v3/health/checks"
 	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/internal/servertiming"
 	prometheus "github.com/distribution/distribution/v3/metrics"
 	"github.com/distribution/distribution/v3/notifications"
 	"github.com/distribution/distribution/v3/registry/api/errcode"
 	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	"github.com/distribution/distribution/v3/registry/archive"
 	"github.com/distribution/distribution/v3/registry/auth"
+	"github.com/distribution/distribution/v3/registry/changefeed"
 	registrymiddleware "github.com/distribution/distribution/v3/registry/middleware/registry"
 	repositorymiddleware "github.com/distribution/distribution/v3/registry/middleware/repository"
 	"github.com/distribution/distribution/v3/registry/proxy"
+	"github.com/distribution/distribution/v3/registry/pullstats"
+	"github.com/distribution/distribution/v3/registry/replication"
 	"github.com/distribution/distribution/v3/registry/storage"
 	memorycache "github.com/distribution/distribution/v3/registry/storage/cache/memory"
 	rediscache "github.com/distribution/distribution/v3/registry/storage/cache/redis"
 	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
 	"github.com/distribution/distribution/v3/registry/storage/driver/factory"
 	storagemiddleware "github.com/distribution/distribution/v3/registry/storage/driver/middleware"
+	"github.com/distribution/distribution/v3/registry/storage/driver/routing"
 	"github.com/distribution/distribution/v3/version"
 	"github.com/distribution/reference"
 	events "github.com/docker/go-events"
 	"github.com/docker/go-metrics"
 	"github.com/gorilla/mux"
+	"github.com/opencontainers/go-digest"
+	promclient "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/redis/go-redis/extra/redisotel/v9"
 	"github.com/redis/go-redis/v9"
 	"github.com/sirupsen/logrus"
@@ -55,6 +67,13 @@ const randomSecretSize = 32
 // defaultCheckInterval is the default time in between health checks
 const defaultCheckInterval = 10 * time.Second
 
+// defaultTagName is the tag served by the default tag compatibility endpoint
+// when none is configured.
+const defaultTagName = "latest"
+
+// anchoredTagRegexp matches a complete tag.
+var anchoredTagRegexp = regexp.MustCompile(`^` + reference.TagRegexp.String() + `$`)
+
 // App is a global registry application object. Shared resources can be placed
 // on this object that will be accessible from all requests. Any writable
 // fields should be protected.
@@ -73,6 +92,11 @@ type App struct {
 	// the configuration. Only the Scheme and Host fields are used.
 	httpHost url.URL
 
+	// trustedProxies lists the networks of the proxies whose forwarded
+	// headers are honored when building urls. If empty, every peer is
+	// trusted.
+	trustedProxies []netip.Prefix
+
 	// events contains notification related configuration.
 	events struct {
 		sink   events.Sink
@@ -89,6 +113,114 @@ type App struct {
 
 	// deleteEnabled is true if the registry is configured to enable deletions.
 	deleteEnabled bool
+
+	// describeManifests is true if manifest HEAD requests are served from the
+	// descriptors of the manifests, cached if configured, rather than from
+	// their payload.
+	describeManifests bool
+
+	// uploadLimiter and downloadLimiter bound the number of concurrent blob
+	// transfers. They are nil when no limit is configured.
+	uploadLimiter   *transferLimiter
+	downloadLimiter *transferLimiter
+
+	// uploadSessions tracks the upload sessions in progress, optionally
+	// bounding their number.
+	uploadSessions *uploadSessions
+
+	// webSocketOrigins are the origins allowed to upload blobs over
+	// WebSocket connections, besides that of the registry.
+	webSocketOrigins []string
+
+	// timeBudget bounds the time spent serving a request. It is nil when no
+	// budget is configured.
+	timeBudget *timeBudget
+
+	// serverTiming reports request timings to clients. It is nil when the
+	// Server-Timing header is disabled.
+	serverTiming *serverTiming
+
+	// listCompression compresses the catalog and tags list responses. It
+	// is nil when they are not compressed.
+	listCompression *listCompression
+
+	// storageErrors maps the errors of the storage driver to responses.
+	storageErrors *storageErrors
+
+	// mountPolicy restricts the source repositories of cross-repository
+	// blob mounts. It is nil when every mount is allowed.
+	mountPolicy *mountPolicy
+
+	// namespaceRewrites maps the repository names requested to the names
+	// repositories are stored under. It is nil when no name is rewritten.
+	namespaceRewrites *namespaceRewriter
+
+	// manifestPutLimiter limits the rate of manifest pushes per repository.
+	// It is nil when no rate is limited.
+	manifestPutLimiter *repositoryLimiter
+
+	// referrersLimiter limits the rate of referrers queries per repository.
+	// It is nil when no rate is limited.
+	referrersLimiter *repositoryLimiter
+
+	// tagProtection protects tags against deletion.
+	tagProtection []tagProtectionRule
+
+	// tagLimit caps the number of tags of each repository. It is nil when the
+	// tags are unlimited.
+	tagLimit *tagLimit
+
+	// pullLimiter limits the rate of manifest pulls per client and tier. It
+	// is nil when no rate is limited.
+	pullLimiter *pullLimiter
+
+	// quarantine blocks the pulls of the manifests flagged by referrers. It
+	// is nil when no manifest is quarantined.
+	quarantine *quarantine
+
+	// repositoryLimit caps the number of repositories. It is nil when the
+	// repositories are unlimited.
+	repositoryLimit *repositoryLimit
+
+	// repositoryLocks serializes the tag operations on a repository, across
+	// the registries sharing redis if configured.
+	repositoryLocks repositoryLocker
+
+	// autoIndexer assembles index tags from per-platform tags. It is nil
+	// when no index is assembled.
+	autoIndexer *autoIndexer
+
+	// archiver archives the manifests pushed. It is nil when archival is
+	// not configured.
+	archiver *archive.Archiver
+
+	// replicator replicates the manifests pushed to downstream
+	// registries. It is nil when replication is not configured.
+	replicator *replication.Replicator
+
+	// pullStats counts the pulls of manifests and blobs. It is nil when
+	// pull stats are disabled.
+	pullStats *pullstats.Recorder
+
+	// flushPullStats flushes the pull counters kept in memory. It is nil
+	// unless pulls are counted in memory.
+	flushPullStats func(context.Context) error
+
+	// changes logs the changes of repositories and tags served by the change
+	// feed. It is nil when the change feed is disabled.
+	changes changefeed.Store
+
+	// subscriptions keeps the webhook subscriptions of repositories, up to
+	// maxSubscriptions each. It is nil when subscriptions are disabled.
+	subscriptions    notifications.SubscriptionStore
+	maxSubscriptions int
+
+	// components are the health checks of the background components, by
+	// name. It is empty unless component checks are enabled.
+	components map[string]*health.Component
+
+	// healthRegistry is the registry the health checks were registered in.
+	healthRegistry *health.Registry
 }
 
 // NewApp takes a configuration and returns a configured app, ready to serve
@@ -104,14 +236,30 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 
 	// Register the handler dispatchers.
 	app.register(v2.RouteNameBase, func(ctx *Context, r *http.Request) http.Handler {
+		if config.HTTP.Capabilities.Base {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				serveCapabilities(ctx, w)
+			})
+		}
 		return http.HandlerFunc(apiBase)
 	})
+	app.register(v2.RouteNameCapabilities, capabilitiesDispatcher)
 	app.register(v2.RouteNameManifest, manifestDispatcher)
 	app.register(v2.RouteNameCatalog, catalogDispatcher)
 	app.register(v2.RouteNameTags, tagsDispatcher)
 	app.register(v2.RouteNameBlob, blobDispatcher)
 	app.register(v2.RouteNameBlobUpload, blobUploadDispatcher)
 	app.register(v2.RouteNameBlobUploadChunk, blobUploadDispatcher)
+	app.register(v2.RouteNameTagOperations, tagOperationsDispatcher)
+	app.register(v2.RouteNameRetag, retagDispatcher)
+	app.register(v2.RouteNameReferrers, referrersDispatcher)
+
+	// The default tag endpoint is a non-standard compatibility route, only
+	// serve it when explicitly requested.
+	if config.Tags.DefaultTag.Enabled {
+		app.configureDefaultTag(config)
+		app.register(v2.RouteNameManifestDefault, manifestDefaultTagDispatcher)
+	}
 
 	// override the storage driver's UA string for registry outbound HTTP requests
 	storageParams := config.Storage.Parameters()
@@ -130,8 +278,19 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 		// a health check.
 		panic(err)
 	}
+	var repositoryRouter storagedriver.RepositoryRouter
+	if routes, ok := config.Storage["routes"]; ok {
+		router, err := routing.FromParameters(app, app.driver, routes)
+		if err != nil {
+			panic(fmt.Sprintf("invalid storage.routes configuration: %v", err))
+		}
+		app.driver = router
+		repositoryRouter = router
+		dcontext.GetLogger(app).Info("storage routing enabled")
+	}
 
 	purgeConfig := uploadPurgeDefaultConfig()
+	var reconcileConfig, indexBackfillConfig map[any]any
 	if mc, ok := config.Storage["maintenance"]; ok {
 		if v, ok := mc["uploadpurging"]; ok {
 			purgeConfig, ok = v.(map[any]any)
@@ -139,6 +298,18 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 				panic("uploadpurging config key must contain additional keys")
 			}
 		}
+		if v, ok := mc["reconcile"]; ok {
+			reconcileConfig, ok = v.(map[any]any)
+			if !ok {
+				panic("reconcile config key must contain additional keys")
+			}
+		}
+		if v, ok := mc["indexbackfill"]; ok {
+			indexBackfillConfig, ok = v.(map[any]any)
+			if !ok {
+				panic("indexbackfill config key must contain additional keys")
+			}
+		}
 		if v, ok := mc["readonly"]; ok {
 			readOnly, ok := v.(map[any]any)
 			if !ok {
@@ -153,7 +324,11 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 		}
 	}
 
-	startUploadPurger(app, app.driver, dcontext.GetLogger(app), purgeConfig)
+	// purged uploads free their upload session slots
+	app.configureUploads(config)
+	startUploadPurger(app, app.driver, dcontext.GetLogger(app), purgeConfig, app.newComponent, app.uploadSessions.reap)
+	startReconciler(app, app.driver, dcontext.GetLogger(app), reconcileConfig, app.newComponent)
+	startIndexBackfill(app, app.driver, dcontext.GetLogger(app), indexBackfillConfig)
 
 	app.driver, err = applyStorageMiddleware(app, app.driver, config.Middleware["storage"])
 	if err != nil {
@@ -167,7 +342,24 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 	}
 	app.configureEvents(config)
 	app.configureRedis(config)
+	app.repositoryLocks = newRepositoryLocker(app.redis)
 	app.configureLogHook(config)
+	app.configureConcurrency(config)
+	app.configureTimeBudget(config)
+	app.configureServerTiming(config)
+	app.configureListCompression(config)
+	app.configureStorageErrors(config)
+	app.configureMountPolicy(config)
+	app.configureNamespaceRewrites(config)
+	app.configureManifestPutLimiter(config)
+	app.configureReferrersLimiter(config)
+	app.configurePullLimiter(config)
+	app.configureQuarantine(config)
+	app.configureAutoIndex(config)
+	app.configureTrustedProxies(config)
+	app.configurePullStats(config)
+	app.configureArchive(config)
+	app.configureChanges(config)
 
 	options := registrymiddleware.GetRegistryOptions()
 
@@ -183,6 +375,24 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 		options = append(options, storage.DisableDigestResumption)
 	}
 
+	if config.Catalog.ArtifactTypes {
+		options = append(options, storage.EnableArtifactTypes)
+	}
+
+	if repositoryRouter != nil {
+		// the storage middlewares hide the router from the registry
+		options = append(options, storage.RouteRepositories(repositoryRouter))
+	}
+
+	if users := config.Policy.PreverifiedDigest.Users; len(users) > 0 {
+		options = append(options, storage.OnPreverifiedDigestMismatch(app.preverifiedDigestMismatch))
+		dcontext.GetLogger(app).Infof("pre-verified digests trusted for %d users", len(users))
+	}
+
+	if config.Policy.Content.VerifyExisting {
+		options = append(options, storage.VerifyExistingContent(app.existingContentMismatch))
+	}
+
 	// configure deletion
 	if d, ok := config.Storage["delete"]; ok {
 		e, ok := d["enabled"]
@@ -192,6 +402,41 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 				options = append(options, storage.EnableDelete)
 			}
 		}
+
+		switch untagged := d["untagged"].(type) {
+		case nil:
+		case bool:
+			if untagged {
+				if !app.deleteEnabled {
+					panic("storage.delete.untagged requires storage.delete.enabled")
+				}
+				dcontext.GetLogger(app).Infof("manifests untagged by a tag update are deleted")
+				options = append(options, storage.EnableDeleteUntagged)
+			}
+		default:
+			panic(fmt.Sprintf("invalid type for storage.delete.untagged: %#v", untagged))
+		}
+
+		switch pruneEmpty := d["pruneempty"].(type) {
+		case nil:
+		case bool:
+			if pruneEmpty {
+				if !app.deleteEnabled {
+					panic("storage.delete.pruneempty requires storage.delete.enabled")
+				}
+				dcontext.GetLogger(app).Infof("repositories emptied by a deletion are pruned")
+				options = append(options, storage.EnablePruneEmpty)
+			}
+		default:
+			panic(fmt.Sprintf("invalid type for storage.delete.pruneempty: %#v", pruneEmpty))
+		}
+	}
+
+	// configure blob media types
+	if mt, ok := config.Storage["mediatypes"]; ok {
+		if enabled, ok := mt["enabled"].(bool); ok && enabled {
+			options = append(options, storage.EnableBlobMediaTypes)
+		}
 	}
 
 	// configure tag lookup concurrency limit
@@ -210,19 +455,47 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 	}
 
 	// configure redirects
-	var redirectDisabled bool
+	var redirectDisabled, redirectRequired bool
 	if redirectConfig, ok := config.Storage["redirect"]; ok {
 		v := redirectConfig["disable"]
 		switch v := v.(type) {
+		case nil:
 		case bool:
 			redirectDisabled = v
 		default:
 			panic(fmt.Sprintf("invalid type for redirect config: %#v", redirectConfig))
 		}
+
+		switch v := redirectConfig["required"].(type) {
+		case nil:
+		case bool:
+			redirectRequired = v
+		default:
+			panic(fmt.Sprintf("invalid type for redirect required config: %#v", v))
+		}
+
+		switch v := redirectConfig["cachecontrol"].(type) {
+		case nil:
+		case string:
+			if v == "" {
+				panic("storage.redirect.cachecontrol must not be empty")
+			}
+			options = append(options, storage.RedirectCacheControl(v))
+		default:
+			panic(fmt.Sprintf("invalid type for redirect cachecontrol config: %#v", v))
+		}
 	}
-	if redirectDisabled {
+	switch {
+	case redirectRequired && redirectDisabled:
+		panic("storage.redirect.required cannot be combined with storage.redirect.disable")
+	case redirectRequired && app.isCache:
+		panic("storage.redirect.required is not supported by a pull through cache, which serves the blobs it fetches")
+	case redirectRequired:
+		dcontext.GetLogger(app).Infof("backend redirection required, blobs are not served by the registry")
+		options = append(options, storage.RequireRedirect)
+	case redirectDisabled:
 		dcontext.GetLogger(app).Infof("backend redirection disabled")
-	} else {
+	default:
 		options = append(options, storage.EnableRedirect)
 	}
 
@@ -276,6 +549,102 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 		default:
 			options = append(options, storage.EnableValidateImageIndexImagesExist)
 		}
+
+		if config.Validation.Manifests.Indexes.RequireWindowsOSVersion {
+			options = append(options, storage.EnableValidateImageIndexWindowsOSVersion)
+		}
+
+		if config.Validation.Manifests.Indexes.VerifyChildren {
+			options = append(options, storage.EnableValidateImageIndexChildDescriptors)
+		}
+
+		if imageConfig := config.Validation.Manifests.ImageConfig; imageConfig.Enabled {
+			mediaTypes := slices.Clone(storage.ImageConfigMediaTypes)
+			if !imageConfig.DisableArtifacts {
+				mediaTypes = append(mediaTypes, storage.ArtifactConfigMediaTypes...)
+			}
+			mediaTypes = append(mediaTypes, imageConfig.Allow...)
+			options = append(options, storage.ValidateConfigMediaTypes(mediaTypes))
+		}
+
+		if layerMediaTypes := config.Validation.Manifests.LayerMediaTypes; layerMediaTypes.Correct {
+			for _, name := range layerMediaTypes.Repositories {
+				if _, err := reference.WithName(name); err != nil {
+					panic(fmt.Sprintf("validation.manifests.layermediatypes: invalid repository %q: %v", name, err))
+				}
+			}
+			options = append(options, storage.CorrectLayerMediaTypes(layerMediaTypes.Repositories))
+		}
+
+		if blobRetry := config.Validation.Manifests.BlobRetry; blobRetry.MaxWait != 0 {
+			backoff := blobRetry.Backoff
+			if backoff == 0 {
+				backoff = 100 * time.Millisecond
+			}
+			if blobRetry.MaxWait < 0 || backoff < 0 {
+				panic("validation.manifests.blobretry: maxwait and backoff must not be negative")
+			}
+			options = append(options, storage.RetryBlobExistenceChecks(backoff, blobRetry.MaxWait))
+		}
+
+		if created := config.Validation.Manifests.Created; created.MaxAge != 0 {
+			if created.MaxAge < 0 {
+				panic("validation.manifests.created: maxage must not be negative")
+			}
+			options = append(options, storage.ValidateConfigCreated(created.MaxAge, created.Strict))
+		}
+
+		if canonical := config.Validation.Manifests.Canonical; canonical.Enabled {
+			options = append(options, storage.ValidateCanonicalManifests(canonical.Reject))
+		}
+
+		rules, err := newTagProtectionRules(config.Validation.Tags)
+		if err != nil {
+			panic(fmt.Sprintf("invalid validation.tags configuration: %v", err))
+		}
+		app.tagProtection = rules
+
+		limit, err := newTagLimit(config.Validation.Tags.Limit, app.deleteEnabled)
+		if err != nil {
+			panic(fmt.Sprintf("invalid validation.tags.limit configuration: %v", err))
+		}
+		app.tagLimit = limit
+		if limit != nil {
+			dcontext.GetLogger(app).Infof("tags limited to %d per repository", limit.max)
+		}
+
+		if uniqueLayers := config.Validation.Manifests.UniqueLayers; uniqueLayers.Enabled {
+			repeatable := slices.Clone(storage.EmptyLayerDigests)
+			for _, s := range uniqueLayers.AllowRepeated {
+				dgst, err := digest.Parse(s)
+				if err != nil {
+					panic(fmt.Sprintf("validation.manifests.uniquelayers: invalid digest %q: %v", s, err))
+				}
+				repeatable = append(repeatable, dgst)
+			}
+			options = append(options, storage.ValidateUniqueLayers(repeatable))
+		}
+
+		if layerSize := config.Validation.Manifests.LayerSize; layerSize.MaxSize != 0 {
+			if layerSize.MaxSize < 0 {
+				panic("validation.manifests.layersize: maxsize must not be negative")
+			}
+			options = append(options, storage.ValidateLayerSize(layerSize.MaxSize))
+		}
+
+		if decompression := config.Validation.Blobs.Decompression; decompression.Enabled {
+			if decompression.MaxRatio < 0 || decompression.MaxSize < 0 {
+				panic("validation.blobs.decompression: maxratio and maxsize must not be negative")
+			}
+			if decompression.MaxRatio == 0 && decompression.MaxSize == 0 {
+				panic("validation.blobs.decompression: maxratio or maxsize is required")
+			}
+			options = append(options, storage.ValidateBlobDecompression(decompression.MaxRatio, decompression.MaxSize))
+		}
+	}
+
+	if transferSpeed := config.HTTP.Debug.Prometheus.TransferSpeed; transferSpeed != (configuration.TransferSpeed{}) {
+		options = append(options, storage.TransferSpeedSizeClasses(transferSpeed.MinSize, transferSpeed.MediumSize, transferSpeed.LargeSize))
 	}
 
 	// configure storage caches
@@ -286,6 +655,30 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 			v = cc["layerinfo"]
 		}
 
+		if prefetch, ok := cc["prefetch"]; ok {
+			enabled, ok := prefetch.(bool)
+			if !ok {
+				panic("prefetch config key must have a boolean value")
+			}
+			if enabled {
+				options = append(options, storage.PrefetchBlobDescriptors)
+			}
+		}
+
+		if manifestDescriptors, ok := cc["manifestdescriptors"]; ok {
+			enabled, ok := manifestDescriptors.(bool)
+			if !ok {
+				panic("manifestdescriptors config key must have a boolean value")
+			}
+			if enabled {
+				if v == nil || v == "" {
+					dcontext.GetLogger(app).Warnf("manifestdescriptors requires a blobdescriptor cache, manifest descriptors not cached")
+				}
+				options = append(options, storage.CacheManifestDescriptors)
+				app.describeManifests = true
+			}
+		}
+
 		switch v {
 		case "redis":
 			if app.redis == nil {
@@ -348,14 +741,21 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 		}
 		app.accessController = accessController
 		dcontext.GetLogger(app).Debugf("configured %q access controller", authType)
+
+		// The challenge is only described when there is one to describe,
+		// the route is left unhandled otherwise.
+		app.router.GetRoute(v2.RouteNameAuth).Handler(app.authDiscoveryHandler())
 	}
 
 	// configure as a pull through cache
 	if config.Proxy.RemoteURL != "" {
-		app.registry, err = proxy.NewRegistryPullThroughCache(ctx, app.registry, app.driver, config.Proxy)
+		app.registry, err = proxy.NewRegistryPullThroughCache(ctx, app.registry, app.driver, app.redis, config.Proxy)
 		if err != nil {
 			panic(err.Error())
 		}
+		if r, ok := app.registry.(proxy.HealthReporter); ok {
+			r.ReportHealth(app.newComponent)
+		}
 		app.isCache = true
 		dcontext.GetLogger(app).Info("Registry configured as a proxy cache to ", config.Proxy.RemoteURL)
 	}
@@ -364,6 +764,8 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 	if !ok {
 		dcontext.GetLogger(app).Warnf("Registry does not implement RepositoryRemover. Will not be able to delete repos and tags")
 	}
+	app.configureRepositoryLimit(config)
+	app.configureReplication(config)
 
 	return app
 }
@@ -449,16 +851,62 @@ func (app *App) RegisterHealthChecks(healthRegistries ...*health.Registry) {
 		healthRegistry.Register(tcpChecker.Addr, updater)
 		go health.Poll(app, updater, checker, interval)
 	}
+
+	for name, component := range app.components {
+		dcontext.GetLogger(app).Infof("configuring component health check name=%s", name)
+		healthRegistry.Register(name, component)
+	}
+	app.healthRegistry = healthRegistry
+}
+
+// newComponent returns the health check of the named background component,
+// unhealthy after threshold without progress unless configured otherwise,
+// or nil if component checks are disabled. The threshold of a component
+// named "<kind>_<name>" is configured by its kind.
+func (app *App) newComponent(name string, threshold time.Duration) *health.Component {
+	config := app.Config.Health.Components
+	if !config.Enabled {
+		return nil
+	}
+
+	kind, _, _ := strings.Cut(name, "_")
+	if t, ok := config.Thresholds[kind]; ok && t > 0 {
+		threshold = t
+	}
+
+	if app.components == nil {
+		app.components = make(map[string]*health.Component)
+	}
+	component := health.NewComponent(threshold)
+	app.components[name] = component
+	return component
 }
 
 // Shutdown close the underlying registry
 func (app *App) Shutdown() error {
+	if app.healthRegistry != nil {
+		for name := range app.components {
+			app.healthRegistry.Unregister(name)
+		}
+	}
+	if err := app.closePullStats(app); err != nil {
+		dcontext.GetLogger(app).Errorf("error flushing pull stats: %v", err)
+	}
+	app.closeArchive()
+	app.closeReplication()
+	app.repositoryLimit.close()
 	if r, ok := app.registry.(proxy.Closer); ok {
 		return r.Close()
 	}
 	return nil
 }
 
+// Redis returns the redis client of the registry, or nil if redis is not
+// configured.
+func (app *App) Redis() redis.UniversalClient {
+	return app.redis
+}
+
 // register a handler with the application, by route name. The handler will be
 // passed through the application filters and context will be constructed at
 // request time.
@@ -467,10 +915,7 @@ func (app *App) register(routeName string, dispatch dispatchFunc) {
 
 	// Chain the handler with prometheus instrumented handler
 	if app.Config.HTTP.Debug.Prometheus.Enabled {
-		namespace := metrics.NewNamespace(prometheus.NamespacePrefix, "http", nil)
-		httpMetrics := namespace.NewDefaultHttpMetrics(strings.ReplaceAll(routeName, "-", "_"))
-		metrics.Register(namespace)
-		handler = metrics.InstrumentHandler(httpMetrics, handler)
+		handler = instrumentHandler(strings.ReplaceAll(routeName, "-", "_"), handler)
 	}
 
 	// TODO(stevvooe): This odd dispatcher/route registration is by-product of
@@ -482,6 +927,35 @@ func (app *App) register(routeName string, dispatch dispatchFunc) {
 	app.router.GetRoute(routeName).Handler(handler)
 }
 
+var (
+	// httpDurationBuckets are the buckets of the request durations, as of
+	// the default http metrics of go-metrics.
+	httpDurationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 25, 60}
+
+	// httpSizeBuckets are the buckets of the request and response sizes, as
+	// of the default http metrics of go-metrics.
+	httpSizeBuckets = promclient.ExponentialBuckets(1024, 2, 22)
+)
+
+// instrumentHandler instruments handler with the default http metrics of the
+// handler named handlerName. The request durations are instrumented apart
+// from the other metrics, so that they are observed with the trace of the
+// request as exemplar if enabled.
+func instrumentHandler(handlerName string, handler http.Handler) http.Handler {
+	namespace := metrics.NewNamespace(prometheus.NamespacePrefix, "http", nil)
+	inFlight := namespace.NewInFlightGaugeMetric(handlerName)
+	duration := namespace.NewRequestDurationMetric(handlerName, httpDurationBuckets)
+	total := namespace.NewRequestTotalMetric(handlerName)
+	requestSize := namespace.NewRequestSizeMetric(handlerName, httpSizeBuckets)
+	responseSize := namespace.NewResponseSizeMetric(handlerName, httpSizeBuckets)
+	metrics.Register(namespace)
+
+	handler = metrics.InstrumentHandler([]*metrics.HTTPMetric{inFlight}, handler)
+	handler = promhttp.InstrumentHandlerDuration(duration.Collector.(promclient.ObserverVec), handler,
+		promhttp.WithExemplarFromContext(prometheus.Exemplar))
+	return metrics.InstrumentHandler([]*metrics.HTTPMetric{total, requestSize, responseSize}, handler)
+}
+
 // configureEvents prepares the event sink for action.
 func (app *App) configureEvents(configuration *configuration.Configuration) {
 	// Configure all of the endpoint sinks.
@@ -491,23 +965,51 @@ func (app *App) configureEvents(configuration *configuration.Configuration) {
 	// should have at the time the iteration starts
 	// nolint:prealloc
 	var sinks []events.Sink
+	if err := notifications.ValidateTLSEnforcement(configuration.Notifications.EnforceTLS); err != nil {
+		panic(fmt.Sprintf("invalid notifications configuration: %v", err))
+	}
 	for _, endpoint := range configuration.Notifications.Endpoints {
 		if endpoint.Disabled {
 			dcontext.GetLogger(app).Infof("endpoint %s disabled, skipping", endpoint.Name)
 			continue
 		}
 
+		if err := notifications.ValidateTemplates(endpoint.URL, endpoint.Headers); err != nil {
+			panic(fmt.Sprintf("invalid template for endpoint %s: %v", endpoint.Name, err))
+		}
+		if !notifications.UsesTLS(endpoint.URL) {
+			switch configuration.Notifications.EnforceTLS {
+			case notifications.TLSEnforcementStrict:
+				panic(fmt.Sprintf("endpoint %s: url %s does not use TLS", endpoint.Name, endpoint.URL))
+			case notifications.TLSEnforcementWarn:
+				dcontext.GetLogger(app).Warnf("endpoint %s: url %s does not use TLS, events are sent in the clear", endpoint.Name, endpoint.URL)
+			}
+		}
+		transport, err := notifications.NewTLSTransport(endpoint.TLS)
+		if err != nil {
+			panic(fmt.Sprintf("invalid tls configuration for endpoint %s: %v", endpoint.Name, err))
+		}
+
 		dcontext.GetLogger(app).Infof("configuring endpoint %v (%v), timeout=%s, headers=%v", endpoint.Name, endpoint.URL, endpoint.Timeout, endpoint.Headers)
-		endpoint := notifications.NewEndpoint(endpoint.Name, endpoint.URL, notifications.EndpointConfig{
+		sink, err := notifications.NewEndpoint(endpoint.Name, endpoint.URL, notifications.EndpointConfig{
 			Timeout:           endpoint.Timeout,
 			Threshold:         endpoint.Threshold,
 			Backoff:           endpoint.Backoff,
 			Headers:           endpoint.Headers,
 			IgnoredMediaTypes: endpoint.IgnoredMediaTypes,
 			Ignore:            endpoint.Ignore,
+			Signing:           endpoint.Signing,
+			Transport:         transport,
+			Health:            app.newComponent("notifications_"+endpoint.Name, 5*time.Minute),
 		})
+		if err != nil {
+			panic(fmt.Sprintf("invalid signing configuration for endpoint %s: %v", endpoint.Name, err))
+		}
 
-		sinks = append(sinks, endpoint)
+		sinks = append(sinks, sink)
+	}
+	if configuration.Notifications.Subscriptions.Enabled {
+		sinks = append(sinks, app.configureSubscriptions(configuration))
 	}
 
 	// NOTE(stevvooe): Moving to a new queuing implementation is as easy as
@@ -669,6 +1171,201 @@ func (app *App) configureLogHook(configuration *configuration.Configuration) {
 	}
 }
 
+// configureConcurrency prepares the blob transfer concurrency limiters.
+func (app *App) configureConcurrency(configuration *configuration.Configuration) {
+	cfg := configuration.HTTP.Concurrency
+	if cfg.Uploads < 0 || cfg.Downloads < 0 || cfg.PerClient < 0 || cfg.QueueSize < 0 {
+		panic("http.concurrency limits must be non-negative integer values")
+	}
+	app.uploadLimiter = newTransferLimiter(transferUpload, cfg.Uploads, cfg)
+	app.downloadLimiter = newTransferLimiter(transferDownload, cfg.Downloads, cfg)
+	if app.uploadLimiter != nil || app.downloadLimiter != nil {
+		dcontext.GetLogger(app).Infof("blob transfer concurrency limited: uploads=%d, downloads=%d, perclient=%d", cfg.Uploads, cfg.Downloads, cfg.PerClient)
+	}
+}
+
+// configureServerTiming prepares the Server-Timing response header.
+func (app *App) configureServerTiming(configuration *configuration.Configuration) {
+	app.serverTiming = newServerTiming(configuration.HTTP.ServerTiming)
+	if app.serverTiming != nil {
+		dcontext.GetLogger(app).Infof("Server-Timing response header enabled: requestheader=%q", configuration.HTTP.ServerTiming.RequestHeader)
+	}
+}
+
+// configureUploads validates the configuration of blob uploads.
+func (app *App) configureUploads(configuration *configuration.Configuration) {
+	cfg := configuration.HTTP.Uploads
+	if cfg.MinChunkSize < 0 {
+		panic("http.uploads.minchunksize must be a non-negative number of bytes")
+	}
+	if cfg.MaxChecksumChunkSize < 0 {
+		panic("http.uploads.maxchecksumchunksize must be a non-negative number of bytes")
+	}
+	if cfg.MaxTotalConcurrent < 0 {
+		panic("http.uploads.maxtotalconcurrent must be a non-negative integer value")
+	}
+	if cfg.MinChunkSize > 0 {
+		dcontext.GetLogger(app).Infof("blob upload chunks smaller than %d bytes rejected", cfg.MinChunkSize)
+	}
+	app.uploadSessions = newUploadSessions(cfg.MaxTotalConcurrent)
+	if cfg.MaxTotalConcurrent > 0 {
+		dcontext.GetLogger(app).Infof("upload sessions in progress limited to %d", cfg.MaxTotalConcurrent)
+	}
+	app.configureWebSocketUploads(cfg.WebSocket)
+}
+
+// configureTimeBudget prepares the request time budget.
+func (app *App) configureTimeBudget(configuration *configuration.Configuration) {
+	cfg := configuration.HTTP.TimeBudget
+	if cfg.Default < 0 || cfg.Transfers < 0 || cfg.RetryAfter < 0 {
+		panic("http.timebudget durations must be non-negative")
+	}
+	app.timeBudget = newTimeBudget(cfg)
+	if app.timeBudget != nil {
+		dcontext.GetLogger(app).Infof("request time budget enabled: default=%v, transfers=%v", cfg.Default, cfg.Transfers)
+	}
+}
+
+// configureStorageErrors prepares the handling of storage driver errors.
+func (app *App) configureStorageErrors(configuration *configuration.Configuration) {
+	cfg := configuration.HTTP.StorageErrors
+	if cfg.RetryAfter < 0 {
+		panic("http.storageerrors.retryafter must be non-negative")
+	}
+	app.storageErrors = newStorageErrors(cfg)
+	if cfg.Audit {
+		dcontext.GetLogger(app).Infof("storage error audit enabled")
+	}
+}
+
+// configureMountPolicy prepares the cross-repository mount policy.
+func (app *App) configureMountPolicy(configuration *configuration.Configuration) {
+	policy, err := newMountPolicy(configuration.Policy.Mount)
+	if err != nil {
+		panic(fmt.Sprintf("invalid policy.mount configuration: %v", err))
+	}
+	app.mountPolicy = policy
+	if policy != nil {
+		dcontext.GetLogger(app).Infof("cross-repository mount policy enabled with %d rules", len(policy.rules))
+	}
+}
+
+// configureNamespaceRewrites prepares the rewrites of repository names.
+func (app *App) configureNamespaceRewrites(configuration *configuration.Configuration) {
+	rewriter, err := newNamespaceRewriter(configuration.Compatibility)
+	if err != nil {
+		panic(fmt.Sprintf("invalid compatibility.namespacerewrites configuration: %v", err))
+	}
+	app.namespaceRewrites = rewriter
+	if rewriter != nil {
+		dcontext.GetLogger(app).Infof("repository namespace rewrites enabled with %d rules", len(rewriter.rules))
+	}
+}
+
+// configureManifestPutLimiter prepares the rate limits of manifest pushes.
+func (app *App) configureManifestPutLimiter(configuration *configuration.Configuration) {
+	limiter, err := newManifestPutLimiter(configuration.Policy.ManifestPuts)
+	if err != nil {
+		panic(fmt.Sprintf("invalid policy.manifestputs configuration: %v", err))
+	}
+	app.manifestPutLimiter = limiter
+	if limiter != nil {
+		dcontext.GetLogger(app).Infof("manifest push rate limits enabled with %d rules", len(limiter.rules))
+	}
+}
+
+// configureReferrersLimiter prepares the rate limits of referrers queries.
+func (app *App) configureReferrersLimiter(configuration *configuration.Configuration) {
+	limiter, err := newReferrersLimiter(configuration.Policy.Referrers)
+	if err != nil {
+		panic(fmt.Sprintf("invalid policy.referrers configuration: %v", err))
+	}
+	app.referrersLimiter = limiter
+	if limiter != nil {
+		dcontext.GetLogger(app).Infof("referrers query rate limits enabled with %d rules", len(limiter.rules))
+	}
+}
+
+// configurePullLimiter prepares the rate limits of manifest pulls.
+func (app *App) configurePullLimiter(configuration *configuration.Configuration) {
+	limiter, err := newPullLimiter(configuration.Policy.Pulls)
+	if err != nil {
+		panic(fmt.Sprintf("invalid policy.pulls configuration: %v", err))
+	}
+	app.pullLimiter = limiter
+	if limiter != nil {
+		dcontext.GetLogger(app).Infof("manifest pull rate limits enabled with %d tiers", len(limiter.tiers))
+	}
+}
+
+// configureQuarantine prepares the quarantine of flagged manifests.
+func (app *App) configureQuarantine(configuration *configuration.Configuration) {
+	quarantine, err := newQuarantine(configuration.Policy.Quarantine)
+	if err != nil {
+		panic(fmt.Sprintf("invalid policy.quarantine configuration: %v", err))
+	}
+	app.quarantine = quarantine
+	if quarantine != nil {
+		dcontext.GetLogger(app).Infof("manifest quarantine enabled with %d rules", len(quarantine.rules))
+	}
+}
+
+// configureRepositoryLimit prepares the cap on the number of repositories.
+func (app *App) configureRepositoryLimit(configuration *configuration.Configuration) {
+	limit, err := newRepositoryLimit(configuration.Storage["maxrepositories"], app.registry)
+	if err != nil {
+		panic(fmt.Sprintf("invalid storage.maxrepositories configuration: %v", err))
+	}
+	app.repositoryLimit = limit
+	if limit != nil {
+		limit.start(app)
+		dcontext.GetLogger(app).Infof("repositories limited to %d", limit.max)
+	}
+}
+
+// configureAutoIndex prepares the assembly of index tags.
+func (app *App) configureAutoIndex(configuration *configuration.Configuration) {
+	indexer, err := newAutoIndexer(configuration.AutoIndex)
+	if err != nil {
+		panic(fmt.Sprintf("invalid autoindex configuration: %v", err))
+	}
+	app.autoIndexer = indexer
+	if indexer != nil {
+		dcontext.GetLogger(app).Infof("index tag assembly enabled with %d rules", len(indexer.rules))
+	}
+}
+
+// configureDefaultTag validates the tags configured for the default tag
+// compatibility endpoint.
+func (app *App) configureDefaultTag(configuration *configuration.Configuration) {
+	cfg := configuration.Tags.DefaultTag
+	if cfg.Tag != "" && !anchoredTagRegexp.MatchString(cfg.Tag) {
+		panic(fmt.Sprintf("tags.defaulttag.tag: invalid tag %q", cfg.Tag))
+	}
+	for name, tag := range cfg.Repositories {
+		if _, err := reference.WithName(name); err != nil {
+			panic(fmt.Sprintf("tags.defaulttag.repositories: invalid repository name %q: %v", name, err))
+		}
+		if !anchoredTagRegexp.MatchString(tag) {
+			panic(fmt.Sprintf("tags.defaulttag.repositories: invalid tag %q for repository %q", tag, name))
+		}
+	}
+	dcontext.GetLogger(app).Infof("default tag compatibility endpoint enabled")
+}
+
+// defaultTag returns the tag served by the default tag compatibility
+// endpoint for the named repository.
+func (app *App) defaultTag(name string) string {
+	cfg := app.Config.Tags.DefaultTag
+	if tag, ok := cfg.Repositories[name]; ok {
+		return tag
+	}
+	if cfg.Tag != "" {
+		return cfg.Tag
+	}
+	return defaultTagName
+}
+
 // configureSecret creates a random secret if a secret wasn't included in the
 // configuration.
 func (app *App) configureSecret(configuration *configuration.Configuration) {
@@ -708,6 +1405,9 @@ type dispatchFunc func(ctx *Context, r *http.Request) http.Handler
 // handler, using the dispatch factory function.
 func (app *App) dispatcher(dispatch dispatchFunc) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorder := &abortRecorder{ResponseWriter: w}
+		w, r = app.serverTiming.apply(recorder, r)
+
 		for headerName, headerValues := range app.Config.HTTP.Headers {
 			for _, value := range headerValues {
 				w.Header().Add(headerName, value)
@@ -715,12 +1415,28 @@ func (app *App) dispatcher(dispatch dispatchFunc) http.Handler {
 		}
 
 		context := app.context(w, r)
+		cancel := app.timeBudget.apply(context, r)
+		defer cancel()
 
 		defer func() {
+			switch {
+			case clientAborted(context, w, r, recorder):
+				// Requests aborted by their client are not failures of the
+				// registry, and nobody is left to receive their errors.
+			case app.timeBudget.exceeded(context, w):
+				// Requests which ran out of time budget report it instead of
+				// whatever error the cancelled storage operation returned.
+			default:
+				app.storageErrors.apply(context, w)
+			}
+
 			// Automated error response handling here. Handlers may return their
 			// own errors if they need different behavior (such as range errors
 			// for layer upload).
 			if context.Errors.Len() > 0 {
+				if app.Config.Auth.ObfuscateNotFound() {
+					context.Errors = obfuscateNotFound(context.Errors)
+				}
 				_ = errcode.ServeJSON(w, context.Errors)
 				app.logError(context, context.Errors)
 			} else if status, ok := context.Value("http.response.status").(int); ok && status >= 200 && status <= 399 {
@@ -728,10 +1444,12 @@ func (app *App) dispatcher(dispatch dispatchFunc) http.Handler {
 			}
 		}()
 
+		authStart := time.Now()
 		if err := app.authorized(w, r, context); err != nil {
 			dcontext.GetLogger(context).Warnf("error authorizing context: %v", err)
 			return
 		}
+		servertiming.Since(context, "auth", authStart)
 
 		// Add username to request logging
 		context.Context = dcontext.WithLogger(context.Context, dcontext.GetLogger(context.Context, userNameKey))
@@ -752,6 +1470,24 @@ func (app *App) dispatcher(dispatch dispatchFunc) http.Handler {
 				}
 				return
 			}
+			if target, writes, ok := app.namespaceRewrites.rewrite(nameRef.Name()); ok {
+				if isWrite(accessMethod(r)) && !writes {
+					dcontext.GetLogger(context).Warnf("denying write to repository %s, rewritten to %s for reads only", nameRef.Name(), target)
+					context.Errors = append(context.Errors, errcode.ErrorCodeDenied.WithDetail(fmt.Sprintf("repository %s is read-only, push to %s instead", nameRef.Name(), target)))
+					return
+				}
+				targetRef, err := reference.WithName(target)
+				if err != nil {
+					context.Errors = append(context.Errors, errcode.ErrorCodeNameInvalid.WithDetail(err))
+					return
+				}
+				dcontext.GetLogger(context).Debugf("rewriting repository %s to %s", nameRef.Name(), target)
+				w.Header().Set(requestedRepositoryHeader, nameRef.Name())
+				context.requestedName = nameRef
+				context.Context = dcontext.WithValues(context.Context, map[string]any{"vars.name": target})
+				nameRef = targetRef
+			}
+			repositoryStart := time.Now()
 			repository, err := app.registry.Repository(context, nameRef)
 			if err != nil {
 				dcontext.GetLogger(context).Errorf("error resolving repository: %v", err)
@@ -764,6 +1500,9 @@ func (app *App) dispatcher(dispatch dispatchFunc) http.Handler {
 				case errcode.Error:
 					context.Errors = append(context.Errors, err)
 				}
+				if app.Config.Auth.ObfuscateNotFound() {
+					context.Errors = obfuscateNotFound(context.Errors)
+				}
 
 				if err := errcode.ServeJSON(w, context.Errors); err != nil {
 					dcontext.GetLogger(context).Errorf("error serving error json: %v (from %v)", err, context.Errors)
@@ -776,6 +1515,7 @@ func (app *App) dispatcher(dispatch dispatchFunc) http.Handler {
 				repository,
 				context.App.repoRemover,
 				app.eventBridge(context, r))
+			context.Repository = app.lockTags(app.limitTags(context.Repository))
 
 			context.Repository, err = applyRepoMiddleware(app, context.Repository, app.Config.Middleware["repository"])
 			if err != nil {
@@ -787,8 +1527,10 @@ func (app *App) dispatcher(dispatch dispatchFunc) http.Handler {
 				}
 				return
 			}
+			servertiming.Since(context, "repository", repositoryStart)
 		}
 
+		startHandler(w)
 		dispatch(context, r).ServeHTTP(w, r)
 	})
 }
@@ -836,6 +1578,7 @@ func (app *App) logError(ctx context.Context, errors errcode.Errors) {
 func (app *App) context(w http.ResponseWriter, r *http.Request) *Context {
 	ctx := r.Context()
 	ctx = dcontext.WithVars(ctx, r)
+	ctx = auth.WithRequestCache(ctx)
 	ctx = dcontext.WithLogger(ctx, dcontext.GetLogger(ctx,
 		"vars.name",
 		"vars.reference",
@@ -852,8 +1595,10 @@ func (app *App) context(w http.ResponseWriter, r *http.Request) *Context {
 		// X-Forwarded-Proto and X-Forwarded-Host headers, and the
 		// hostname in the request.
 		context.urlBuilder = v2.NewURLBuilder(&app.httpHost, false)
-	} else {
+	} else if app.trustsForwardedHeaders(r) {
 		context.urlBuilder = v2.NewURLBuilderFromRequest(r, app.Config.HTTP.RelativeURLs)
+	} else {
+		context.urlBuilder = v2.NewURLBuilderFromDirectRequest(r, app.Config.HTTP.RelativeURLs)
 	}
 
 	return context
@@ -872,13 +1617,42 @@ func (app *App) authorized(w http.ResponseWriter, r *http.Request, context *Cont
 
 	var accessRecords []auth.Access
 
-	if repo != "" {
-		accessRecords = appendAccessRecords(accessRecords, r.Method, repo)
+	if repo != "" && isWebhooksRoute(r) {
+		// the subscriptions of a repository receive the events of the
+		// repositories under it, so managing them requires all the actions
+		accessRecords = append(accessRecords, auth.Access{
+			Resource: auth.Resource{Type: "repository", Name: repo},
+			Action:   "*",
+		})
+	} else if repo != "" {
+		accessRecords = appendAccessRecords(accessRecords, accessMethod(r), repo)
+		accessRecords = appendForceDeleteAccessRecord(accessRecords, r, repo)
+		// rewritten names are served from the repository they are rewritten
+		// to, so the request requires access to that repository as well.
+		if target, _, ok := app.namespaceRewrites.rewrite(repo); ok {
+			accessRecords = appendAccessRecords(accessRecords, accessMethod(r), target)
+			accessRecords = appendForceDeleteAccessRecord(accessRecords, r, target)
+		}
+		if app.Config.Auth.DeleteAsPush() {
+			setDeleteAsPush(accessRecords)
+		}
+		setTagParameter(accessRecords, r, getReference(context))
 		if fromRepo := r.FormValue("from"); fromRepo != "" {
 			// mounting a blob from one repository to another requires pull (GET)
 			// access to the source repository.
 			accessRecords = appendAccessRecords(accessRecords, http.MethodGet, fromRepo)
+			if target, _, ok := app.namespaceRewrites.rewrite(fromRepo); ok {
+				accessRecords = appendAccessRecords(accessRecords, http.MethodGet, target)
+			}
 		}
+	} else if isRetagRoute(r) {
+		// the repositories are named in the body of the request
+		ops, err := peekRetagOperations(w, r)
+		if err != nil {
+			context.Errors = append(context.Errors, err)
+			return err
+		}
+		accessRecords = retagAccessRecords(ops)
 	} else {
 		// Only allow the name not to be set on the base route.
 		if app.nameRequired(r) {
@@ -900,6 +1674,13 @@ func (app *App) authorized(w http.ResponseWriter, r *http.Request, context *Cont
 	if err != nil {
 		switch err := err.(type) {
 		case auth.Challenge:
+			if repo != "" && app.Config.Auth.ObfuscateNotFound() {
+				if err := serveObfuscatedRefusal(w, r, err); err != nil {
+					dcontext.GetLogger(context).Errorf("error serving error json: %v (from %v)", err, context.Errors)
+				}
+				break
+			}
+
 			// Add the appropriate WWW-Auth header
 			err.SetHeaders(r, w)
 
@@ -923,6 +1704,7 @@ func (app *App) authorized(w http.ResponseWriter, r *http.Request, context *Cont
 
 	ctx := withUser(context.Context, grant.User)
 	ctx = withResources(ctx, grant.Resources)
+	ctx = withClaims(ctx, grant.Claims)
 
 	dcontext.GetLogger(ctx, userNameKey).Info("authorized request")
 	// TODO(stevvooe): This pattern needs to be cleaned up a bit. One context
@@ -939,8 +1721,15 @@ func (app *App) eventBridge(ctx *Context, r *http.Request) notifications.Listene
 		Name: getUserName(ctx, r),
 	}
 	request := notifications.NewRequestRecord(dcontext.GetRequestID(ctx), r)
+	if ctx.requestedName != nil {
+		request.Repository = ctx.requestedName.Name()
+	}
 
-	return notifications.NewBridge(ctx.urlBuilder, app.events.source, actor, request, app.events.sink, app.Config.Notifications.EventConfig.IncludeReferences)
+	bridge := notifications.NewBridge(ctx.urlBuilder, app.events.source, actor, request, app.events.sink, app.Config.Notifications.EventConfig.IncludeReferences)
+	if app.changes == nil {
+		return bridge
+	}
+	return &changeListener{Listener: bridge, ctx: context.WithoutCancel(ctx), store: app.changes}
 }
 
 // nameRequired returns true if the route requires a name.
@@ -950,7 +1739,7 @@ func (app *App) nameRequired(r *http.Request) bool {
 		return true
 	}
 	routeName := route.GetName()
-	return routeName != v2.RouteNameBase && routeName != v2.RouteNameCatalog
+	return routeName != v2.RouteNameBase && routeName != v2.RouteNameCapabilities && routeName != v2.RouteNameCatalog && routeName != v2.RouteNameChanges && routeName != v2.RouteNameRetag
 }
 
 // apiBase implements a simple yes-man for doing overall checks against the
@@ -998,12 +1787,51 @@ func appendAccessRecords(records []auth.Access, method string, repo string) []au
 	return records
 }
 
+// setDeleteAsPush replaces the delete action of records with the push
+// action, for the token services unable to issue the delete action.
+func setDeleteAsPush(records []auth.Access) {
+	for i := range records {
+		if records[i].Action == "delete" {
+			records[i].Action = "push"
+		}
+	}
+}
+
+// setTagParameter names the tag of a manifest pulled by tag in the pull
+// access records, so that access controllers may authorize tags differently.
+// Manifests pulled by digest carry no tag.
+func setTagParameter(records []auth.Access, r *http.Request, ref string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return
+	}
+	if route := mux.CurrentRoute(r); route == nil || route.GetName() != v2.RouteNameManifest {
+		return
+	}
+	if _, err := digest.Parse(ref); ref == "" || err == nil {
+		return
+	}
+
+	for i := range records {
+		if records[i].Action == "pull" {
+			records[i].Parameters = auth.EncodeParameters(map[string]string{auth.TagParameter: ref})
+		}
+	}
+}
+
+// isWebhooksRoute returns true if r manages the webhook subscriptions of a
+// repository.
+func isWebhooksRoute(r *http.Request) bool {
+	route := mux.CurrentRoute(r)
+	return route != nil && route.GetName() == v2.RouteNameWebhooks
+}
+
 // Add the access record for the catalog if it's our current route
 func appendCatalogAccessRecord(accessRecords []auth.Access, r *http.Request) []auth.Access {
 	route := mux.CurrentRoute(r)
 	routeName := route.GetName()
 
-	if routeName == v2.RouteNameCatalog {
+	// the change feed discloses the repositories of the catalog
+	if routeName == v2.RouteNameCatalog || routeName == v2.RouteNameChanges {
 		resource := auth.Resource{
 			Type: "registry",
 			Name: "catalog",
@@ -1063,16 +1891,23 @@ func uploadPurgeDefaultConfig() map[any]any {
 	config["age"] = "168h"
 	config["interval"] = "24h"
 	config["dryrun"] = false
+	config["abandonedage"] = defaultAbandonedUploadAge
 	return config
 }
 
+// defaultAbandonedUploadAge is the grace period after which the uploads
+// abandoned by their client are purged, unless configured otherwise.
+const defaultAbandonedUploadAge = "1h"
+
 func badPurgeUploadConfig(reason string) {
 	panic(fmt.Sprintf("Unable to parse upload purge configuration: %s", reason))
 }
 
 // startUploadPurger schedules a goroutine which will periodically
-// check upload directories for old files and delete them
-func startUploadPurger(ctx context.Context, storageDriver storagedriver.StorageDriver, log dcontext.Logger, config map[any]any) {
+// check upload directories for old or abandoned files and delete them.
+// purged is called with the start time before which uploads were deleted,
+// and the upload directories deleted.
+func startUploadPurger(ctx context.Context, storageDriver storagedriver.StorageDriver, log dcontext.Logger, config map[any]any, newComponent health.ComponentFunc, purged func(olderThan time.Time, deleted []string)) {
 	if config["enabled"] == false {
 		return
 	}
@@ -1109,6 +1944,24 @@ func startUploadPurger(ctx context.Context, storageDriver storagedriver.StorageD
 		badPurgeUploadConfig("interval missing")
 	}
 
+	// uploads abandoned by their client are purged after a shorter grace
+	// period, unless it is zero
+	abandonedAge, ok := config["abandonedage"]
+	if !ok {
+		abandonedAge = defaultAbandonedUploadAge
+	}
+	abandonedAgeStr, ok := abandonedAge.(string)
+	if !ok {
+		badPurgeUploadConfig("abandonedage is not a string")
+	}
+	abandonedAgeDuration, err := time.ParseDuration(abandonedAgeStr)
+	if err != nil {
+		badPurgeUploadConfig(fmt.Sprintf("Cannot parse abandonedage: %s", err.Error()))
+	}
+	if abandonedAgeDuration < 0 {
+		badPurgeUploadConfig("abandonedage must be non-negative")
+	}
+
 	var dryRunBool bool
 	dryRun, ok := config["dryrun"]
 	if ok {
@@ -1120,6 +1973,9 @@ func startUploadPurger(ctx context.Context, storageDriver storagedriver.StorageD
 		badPurgeUploadConfig("dryrun missing")
 	}
 
+	// the first purge is delayed by up to an hour
+	component := newComponent("uploadpurger", 2*intervalDuration+time.Hour)
+
 	go func() {
 		randInt, err := rand.Int(rand.Reader, new(big.Int).SetInt64(math.MaxInt64))
 		if err != nil {
@@ -1132,9 +1988,135 @@ func startUploadPurger(ctx context.Context, storageDriver storagedriver.StorageD
 		time.Sleep(jitter)
 
 		for {
-			storage.PurgeUploads(ctx, storageDriver, time.Now().Add(-purgeAgeDuration), !dryRunBool)
+			now := time.Now()
+			olderThan := now.Add(-purgeAgeDuration)
+			var abandonedBefore time.Time
+			if abandonedAgeDuration > 0 {
+				abandonedBefore = now.Add(-abandonedAgeDuration)
+			}
+			deleted, _ := storage.PurgeAbandonedUploads(ctx, storageDriver, olderThan, abandonedBefore, !dryRunBool)
+			if !dryRunBool {
+				purged(olderThan, deleted)
+			}
+			component.Alive()
 			log.Infof("Starting upload purge in %s", intervalDuration)
 			time.Sleep(intervalDuration)
 		}
 	}()
 }
+
+func badReconcileConfig(reason string) {
+	panic(fmt.Sprintf("Unable to parse reconcile configuration: %s", reason))
+}
+
+// startReconciler schedules a goroutine which will periodically check the
+// links of a bounded number of repositories, reporting or removing the
+// dangling ones. Runs are postponed while a garbage collection is in
+// progress. It does nothing unless enabled in config.
+func startReconciler(ctx context.Context, storageDriver storagedriver.StorageDriver, log dcontext.Logger, config map[any]any, newComponent health.ComponentFunc) {
+	enabled, ok := config["enabled"].(bool)
+	if _, set := config["enabled"]; set && !ok {
+		badReconcileConfig("cannot parse enabled")
+	}
+	if !enabled {
+		return
+	}
+
+	intervalDuration := time.Hour
+	if interval, ok := config["interval"]; ok {
+		intervalStr, ok := interval.(string)
+		if !ok {
+			badReconcileConfig("interval is not a string")
+		}
+		var err error
+		intervalDuration, err = time.ParseDuration(intervalStr)
+		if err != nil {
+			badReconcileConfig(fmt.Sprintf("Cannot parse interval: %s", err.Error()))
+		}
+		if intervalDuration <= 0 {
+			badReconcileConfig("interval must be positive")
+		}
+	}
+
+	opts := storage.ReconcileOpts{Repositories: 100}
+	if mode, ok := config["mode"]; ok {
+		switch mode {
+		case "report":
+		case "repair":
+			opts.Repair = true
+		default:
+			badReconcileConfig(fmt.Sprintf("unknown mode %v, must be report or repair", mode))
+		}
+	}
+	if repositories, ok := config["repositories"]; ok {
+		n, ok := repositories.(int)
+		if !ok || n < 1 {
+			badReconcileConfig("repositories must be a positive integer")
+		}
+		opts.Repositories = n
+	}
+
+	component := newComponent("reconciler", 2*intervalDuration)
+
+	go func() {
+		for {
+			time.Sleep(intervalDuration)
+
+			result, err := storage.Reconcile(ctx, storageDriver, opts)
+			switch {
+			case errors.Is(err, storage.ErrGarbageCollectionRunning):
+				// resumed by the next run
+				log.Infof("Reconcile postponed: %v", err)
+				component.Alive()
+			case err != nil:
+				log.Errorf("Reconcile failed: %v", err)
+			default:
+				component.Alive()
+			}
+			log.Infof("Reconcile checked %d repositories, found %d dangling links, completed=%t, repair=%t",
+				len(result.Repositories), len(result.Issues), result.Completed, opts.Repair)
+		}
+	}()
+}
+
+func badIndexBackfillConfig(reason string) {
+	panic(fmt.Sprintf("Unable to parse indexbackfill configuration: %s", reason))
+}
+
+// startIndexBackfill schedules a goroutine which will record the manifests
+// pushed before the referrers index and the index of the manifests of
+// indexes were maintained in both, once. It does nothing unless enabled in
+// config.
+func startIndexBackfill(ctx context.Context, storageDriver storagedriver.StorageDriver, log dcontext.Logger, config map[any]any) {
+	enabled, ok := config["enabled"].(bool)
+	if _, set := config["enabled"]; set && !ok {
+		badIndexBackfillConfig("cannot parse enabled")
+	}
+	if !enabled {
+		return
+	}
+
+	var opts storage.BackfillIndexesOpts
+	if r, ok := config["rate"]; ok {
+		switch r := r.(type) {
+		case int:
+			opts.Rate = float64(r)
+		case float64:
+			opts.Rate = r
+		default:
+			badIndexBackfillConfig("rate is not a number")
+		}
+		if opts.Rate < 0 {
+			badIndexBackfillConfig("rate must not be negative")
+		}
+	}
+
+	go func() {
+		result, err := storage.BackfillIndexes(ctx, storageDriver, opts)
+		if err != nil {
+			log.Errorf("Index backfill failed, will resume on restart: %v", err)
+		}
+		log.Infof("Index backfill indexed %d repositories, %d already indexed, %d manifests read",
+			result.Repositories, result.Skipped, result.Manifests)
+	}()
+}
@@ -6,6 +6,7 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/distribution/distribution/v3/configuration"
@@ -17,6 +18,7 @@ import (
 	"github.com/distribution/distribution/v3/registry/storage"
 	memorycache "github.com/distribution/distribution/v3/registry/storage/cache/memory"
 	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/gorilla/mux"
 )
 
 // TestAppDispatcher builds an application with a test dispatcher and ensures
@@ -39,7 +41,9 @@ func TestAppDispatcher(t *testing.T) {
 	}
 	server := httptest.NewServer(app)
 	defer server.Close()
-	router := v2.Router()
+	// build urls with a router of our own, as pinning the host of the routes
+	// of the shared router would leak into the other tests
+	router := v2.RouterWithPrefix("")
 
 	serverURL, err := url.Parse(server.URL)
 	if err != nil {
@@ -211,6 +215,83 @@ func TestNewApp(t *testing.T) {
 	}
 }
 
+// TestNotificationsEnforceTLS validates that endpoints not using TLS are
+// rejected at startup in strict mode, and only warned about otherwise.
+func TestNotificationsEnforceTLS(t *testing.T) {
+	for _, tc := range []struct {
+		enforce string
+		url     string
+		reject  bool
+	}{
+		{enforce: "", url: "http://example.com/events"},
+		{enforce: "warn", url: "http://example.com/events"},
+		{enforce: "strict", url: "https://example.com/events"},
+		{enforce: "strict", url: "http://example.com/events", reject: true},
+		{enforce: "always", url: "https://example.com/events", reject: true},
+	} {
+		config := configuration.Configuration{
+			Storage: configuration.Storage{
+				"inmemory": nil,
+				"maintenance": configuration.Parameters{"uploadpurging": map[any]any{
+					"enabled": false,
+				}},
+			},
+			Notifications: configuration.Notifications{
+				EnforceTLS: tc.enforce,
+				Endpoints:  []configuration.Endpoint{{Name: "test", URL: tc.url}},
+			},
+		}
+
+		rejected := func() (rejected bool) {
+			defer func() {
+				rejected = recover() != nil
+			}()
+			NewApp(dcontext.Background(), &config)
+			return false
+		}()
+		if rejected != tc.reject {
+			t.Fatalf("enforcetls %q, url %s: expected rejection %v, got %v", tc.enforce, tc.url, tc.reject, rejected)
+		}
+	}
+}
+
+// TestNotificationsSigningValidated validates that endpoints with an invalid
+// signing configuration are rejected at startup rather than left unsigned.
+func TestNotificationsSigningValidated(t *testing.T) {
+	for _, tc := range []struct {
+		signing configuration.Signing
+		reject  bool
+	}{
+		{signing: configuration.Signing{}},
+		{signing: configuration.Signing{Secret: "s3cr3t"}},
+		{signing: configuration.Signing{Secret: "s3cr3t", Algorithm: "sha512"}},
+		{signing: configuration.Signing{Secret: "s3cr3t", Algorithm: "md5"}, reject: true},
+	} {
+		config := configuration.Configuration{
+			Storage: configuration.Storage{
+				"inmemory": nil,
+				"maintenance": configuration.Parameters{"uploadpurging": map[any]any{
+					"enabled": false,
+				}},
+			},
+			Notifications: configuration.Notifications{
+				Endpoints: []configuration.Endpoint{{Name: "test", URL: "https://example.com/events", Signing: tc.signing}},
+			},
+		}
+
+		rejected := func() (rejected bool) {
+			defer func() {
+				rejected = recover() != nil
+			}()
+			NewApp(dcontext.Background(), &config)
+			return false
+		}()
+		if rejected != tc.reject {
+			t.Fatalf("signing algorithm %q: expected rejection %v, got %v", tc.signing.Algorithm, tc.reject, rejected)
+		}
+	}
+}
+
 // Test the access record accumulator
 func TestAppendAccessRecords(t *testing.T) {
 	repo := "testRepo"
@@ -275,3 +356,48 @@ func TestAppendAccessRecords(t *testing.T) {
 		t.Fatal("Actual access record differs from expected")
 	}
 }
+
+// Test that pulls of manifests by tag name the tag in their access records
+func TestSetTagParameter(t *testing.T) {
+	const repo = "foo/bar"
+	dgst := "sha256:" + strings.Repeat("a", 64)
+
+	for _, tc := range []struct {
+		method   string
+		path     string
+		expected string
+	}{
+		{method: http.MethodGet, path: "/v2/foo/bar/manifests/prod-1", expected: ":tag=prod-1"},
+		{method: http.MethodHead, path: "/v2/foo/bar/manifests/prod-1", expected: ":tag=prod-1"},
+		{method: http.MethodGet, path: "/v2/foo/bar/manifests/" + dgst},
+		{method: http.MethodPut, path: "/v2/foo/bar/manifests/prod-1"},
+		{method: http.MethodGet, path: "/v2/foo/bar/blobs/" + dgst},
+		{method: http.MethodGet, path: "/v2/foo/bar/tags/list"},
+	} {
+		var records []auth.Access
+		router := v2.RouterWithPrefix("")
+		router.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			t.Fatalf("%s %s: no route matched", tc.method, tc.path)
+		})
+		for _, name := range []string{v2.RouteNameManifest, v2.RouteNameBlob, v2.RouteNameTags} {
+			router.GetRoute(name).Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				records = appendAccessRecords(nil, r.Method, repo)
+				setTagParameter(records, r, mux.Vars(r)["reference"])
+			}))
+		}
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(tc.method, tc.path, nil))
+		if len(records) == 0 {
+			t.Fatalf("%s %s: no access records", tc.method, tc.path)
+		}
+
+		for _, record := range records {
+			var expected string
+			if record.Action == "pull" {
+				expected = tc.expected
+			}
+			if record.Parameters != expected {
+				t.Fatalf("%s %s: expected %s parameters %q, got %q", tc.method, tc.path, record.Action, expected, record.Parameters)
+			}
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"expvar"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/registry/archive"
+	"github.com/distribution/distribution/v3/registry/storage/driver/factory"
+	"github.com/opencontainers/go-digest"
+)
+
+// configureArchive starts archiving the manifests pushed to the configured
+// archive storage driver.
+func (app *App) configureArchive(configuration *configuration.Configuration) {
+	config := configuration.Archive
+	driverName := config.Storage.Type()
+	if driverName == "" {
+		return
+	}
+	if config.QueueSize < 0 || config.MaxRetries < 0 {
+		panic("archive: queuesize and maxretries must not be negative")
+	}
+
+	driver, err := factory.Create(app, driverName, config.Storage.Parameters())
+	if err != nil {
+		panic(fmt.Sprintf("archive: unable to configure storage driver %q: %v", driverName, err))
+	}
+	archiver, err := archive.NewArchiver(app, driver, config.Path, config.QueueSize, config.MaxRetries)
+	if err != nil {
+		panic(fmt.Sprintf("archive: %v", err))
+	}
+	app.archiver = archiver
+	dcontext.GetLogger(app).Infof("archiving pushed manifests to storage driver %q", driverName)
+
+	registry := expvar.Get("registry")
+	if registry == nil {
+		registry = expvar.NewMap("registry")
+	}
+	registry.(*expvar.Map).Set("archive", expvar.Func(func() any {
+		return map[string]any{
+			"Dropped": archiver.Dropped(),
+		}
+	}))
+}
+
+// archiveManifest queues the archival of the manifest payload dgst pushed to
+// the repository of the request r, if archival is enabled.
+func (ctx *Context) archiveManifest(r *http.Request, dgst digest.Digest, mediaType string, payload []byte, tags []string) {
+	if ctx.App.archiver == nil || ctx.Repository == nil {
+		return
+	}
+	ctx.App.archiver.Archive(archive.Record{
+		Repository: ctx.Repository.Named().Name(),
+		Digest:     dgst,
+		MediaType:  mediaType,
+		Tags:       tags,
+		Actor:      getUserName(ctx, r),
+		Timestamp:  time.Now().UTC(),
+	}, payload)
+}
+
+// closeArchive archives the queued pushes.
+func (app *App) closeArchive() {
+	if app.archiver != nil {
+		app.archiver.Close()
+	}
+}
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path"
+	"testing"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/registry/archive"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/opencontainers/go-digest"
+)
+
+// unavailableDriver fails all writes.
+type unavailableDriver struct {
+	storagedriver.StorageDriver
+}
+
+func (unavailableDriver) PutContent(context.Context, string, []byte) error {
+	return errors.New("archive unavailable")
+}
+
+// newArchiveTestEnv returns a test environment archiving manifests to
+// driver.
+func newArchiveTestEnv(t *testing.T, driver storagedriver.StorageDriver) *testEnv {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory":    configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[any]any{"enabled": false}},
+		},
+		Archive: configuration.Archive{
+			Storage: configuration.Storage{"inmemory": configuration.Parameters{}},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	env := newTestEnvWithConfig(t, &config)
+
+	// archive to driver, to inspect or fail the archive writes
+	env.app.archiver.Close()
+	archiver, err := archive.NewArchiver(env.ctx, driver, "", 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.app.archiver = archiver
+	return env
+}
+
+func TestArchiveManifests(t *testing.T) {
+	driver := inmemory.New()
+	env := newArchiveTestEnv(t, driver)
+	defer env.Shutdown()
+
+	dgst := createRepository(env, t, "foo/bar", "latest")
+	env.app.archiver.Close()
+
+	dir := env.app.archiver.Dir("foo/bar", dgst)
+	payload, err := driver.GetContent(env.ctx, path.Join(dir, "data"))
+	checkErr(t, err, "reading archived manifest")
+	if digest.FromBytes(payload) != dgst {
+		t.Fatalf("archived manifest does not match the manifest pushed %s", dgst)
+	}
+
+	names, err := driver.List(env.ctx, path.Join(dir, "pushes"))
+	checkErr(t, err, "listing archived pushes")
+	if len(names) != 1 {
+		t.Fatalf("expected one archived push, got %v", names)
+	}
+	content, err := driver.GetContent(env.ctx, names[0])
+	checkErr(t, err, "reading archived push")
+	var record archive.Record
+	checkErr(t, json.Unmarshal(content, &record), "decoding archived push")
+	if record.Repository != "foo/bar" || record.Digest != dgst || len(record.Tags) != 1 || record.Tags[0] != "latest" || record.MediaType == "" || record.Timestamp.IsZero() {
+		t.Fatalf("unexpected archived push %+v", record)
+	}
+}
+
+func TestArchiveDriverErrors(t *testing.T) {
+	env := newArchiveTestEnv(t, unavailableDriver{inmemory.New()})
+	defer env.Shutdown()
+
+	// pushes succeed while the manifests cannot be archived
+	createRepository(env, t, "foo/bar", "latest")
+	createRepository(env, t, "foo/bar", "stable")
+	env.app.archiver.Close()
+
+	if env.app.archiver.Dropped() != 2 {
+		t.Fatalf("expected the pushes to be dropped, got %d dropped", env.app.archiver.Dropped())
+	}
+}
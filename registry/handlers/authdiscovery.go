@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/distribution/distribution/v3/internal/client/auth/challenge"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/distribution/distribution/v3/registry/auth"
+	"github.com/gorilla/handlers"
+)
+
+// authDiscovery describes the challenge issued to unauthenticated clients,
+// so that they can obtain credentials without a failing request first.
+type authDiscovery struct {
+	// Type is the auth scheme of the challenge, such as bearer or basic.
+	Type string `json:"type"`
+
+	// Realm is the realm of the challenge. For bearer challenges, it is
+	// the URL of the token server.
+	Realm string `json:"realm,omitempty"`
+
+	// Service is the service name to request tokens for.
+	Service string `json:"service,omitempty"`
+
+	// Parameters holds any other parameter of the challenge.
+	Parameters map[string]string `json:"parameters,omitempty"`
+
+	// Scopes describes the scopes tokens may be requested for, for bearer
+	// challenges.
+	Scopes *authDiscoveryScopes `json:"scopes,omitempty"`
+}
+
+// authDiscoveryScopes describes the grammar of the scopes requested from a
+// token server.
+type authDiscoveryScopes struct {
+	Grammar       string   `json:"grammar"`
+	ResourceTypes []string `json:"resourceTypes"`
+	Actions       []string `json:"actions"`
+}
+
+var bearerScopes = &authDiscoveryScopes{
+	Grammar:       "<resourcetype>:<resourcename>:<action>[,<action>...]",
+	ResourceTypes: []string{"repository", "registry"},
+	Actions:       []string{"pull", "push", "delete", "*"},
+}
+
+// headerRecorder is a response writer only recording headers.
+type headerRecorder http.Header
+
+func (h headerRecorder) Header() http.Header       { return http.Header(h) }
+func (headerRecorder) Write(p []byte) (int, error) { return len(p), nil }
+func (headerRecorder) WriteHeader(int)             {}
+
+// authDiscoveryHandler serves the description of the challenge issued by the
+// access controller, which is asked to authorize an anonymous copy of the
+// request so that the document always matches the WWW-Authenticate header
+// of its 401 responses.
+func (app *App) authDiscoveryHandler() http.Handler {
+	return handlers.MethodHandler{
+		http.MethodGet: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for headerName, headerValues := range app.Config.HTTP.Headers {
+				for _, value := range headerValues {
+					w.Header().Add(headerName, value)
+				}
+			}
+
+			anonymous := r.Clone(r.Context())
+			anonymous.Header.Del("Authorization")
+
+			_, err := app.accessController.Authorized(anonymous)
+			ch, ok := err.(auth.Challenge)
+			if !ok {
+				if err != nil {
+					dcontext.GetLogger(r.Context()).Errorf("error describing authorization challenge: %v", err)
+					_ = errcode.ServeJSON(w, errcode.ErrorCodeUnknown)
+					return
+				}
+				// anonymous clients are not challenged
+				http.NotFound(w, r)
+				return
+			}
+
+			header := headerRecorder{}
+			ch.SetHeaders(anonymous, header)
+			challenges := challenge.ResponseChallenges(&http.Response{
+				StatusCode: http.StatusUnauthorized,
+				Header:     http.Header(header),
+			})
+			if len(challenges) == 0 {
+				http.NotFound(w, r)
+				return
+			}
+
+			doc := authDiscovery{
+				Type:       challenges[0].Scheme,
+				Realm:      challenges[0].Parameters["realm"],
+				Service:    challenges[0].Parameters["service"],
+				Parameters: make(map[string]string),
+			}
+			for k, v := range challenges[0].Parameters {
+				if k != "realm" && k != "service" {
+					doc.Parameters[k] = v
+				}
+			}
+			if strings.EqualFold(doc.Type, "bearer") {
+				doc.Scopes = bearerScopes
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			if err := json.NewEncoder(w).Encode(doc); err != nil {
+				dcontext.GetLogger(r.Context()).Errorf("error encoding authorization challenge: %v", err)
+			}
+		}),
+	}
+}
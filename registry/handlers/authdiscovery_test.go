@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/internal/client/auth/challenge"
+	_ "github.com/distribution/distribution/v3/registry/auth/htpasswd"
+	_ "github.com/distribution/distribution/v3/registry/auth/silly"
+	_ "github.com/distribution/distribution/v3/registry/auth/token"
+	"github.com/go-jose/go-jose/v4"
+)
+
+func TestAuthDiscovery(t *testing.T) {
+	dir := t.TempDir()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks, err := json.Marshal(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Key: &key.PublicKey, KeyID: "test", Algorithm: string(jose.ES256)}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwksPath := filepath.Join(dir, "jwks.json")
+	if err := os.WriteFile(jwksPath, jwks, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		auth configuration.Auth
+	}{
+		{name: "none"},
+		{
+			name: "htpasswd",
+			auth: configuration.Auth{"htpasswd": {"realm": "basic-realm", "path": filepath.Join(dir, "htpasswd")}},
+		},
+		{
+			name: "silly",
+			auth: configuration.Auth{"silly": {"realm": "https://auth.example.com/token", "service": "silly-service"}},
+		},
+		{
+			name: "token",
+			auth: configuration.Auth{"token": {
+				"realm":   "https://auth.example.com/token",
+				"issuer":  "test-issuer",
+				"service": "token-service",
+				"jwks":    jwksPath,
+			}},
+		},
+		{
+			name: "token with autoredirect",
+			auth: configuration.Auth{"token": {
+				"realm":        "https://auth.example.com/token",
+				"issuer":       "test-issuer",
+				"service":      "token-service",
+				"jwks":         jwksPath,
+				"autoredirect": true,
+			}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			config := configuration.Configuration{
+				Storage: configuration.Storage{
+					"inmemory":    configuration.Parameters{},
+					"maintenance": configuration.Parameters{"uploadpurging": map[any]any{"enabled": false}},
+				},
+				Auth: tc.auth,
+			}
+			config.HTTP.Headers = headerConfig
+			env := newTestEnvWithConfig(t, &config)
+			defer env.Shutdown()
+
+			authURL, err := env.builder.BuildAuthURL()
+			checkErr(t, err, "building auth url")
+			resp, err := http.Get(authURL)
+			checkErr(t, err, "fetching auth challenge")
+			defer resp.Body.Close()
+
+			if tc.auth == nil {
+				checkResponse(t, "fetching auth challenge without auth", resp, http.StatusNotFound)
+				return
+			}
+			checkResponse(t, "fetching auth challenge", resp, http.StatusOK)
+			var doc authDiscovery
+			if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+				t.Fatalf("unexpected error decoding auth challenge: %v", err)
+			}
+
+			baseURL, err := env.builder.BuildBaseURL()
+			checkErr(t, err, "building base url")
+			resp, err = http.Get(baseURL)
+			checkErr(t, err, "fetching base url")
+			resp.Body.Close()
+			checkResponse(t, "fetching base url anonymously", resp, http.StatusUnauthorized)
+			challenges := challenge.ResponseChallenges(resp)
+			if len(challenges) != 1 {
+				t.Fatalf("expected a single challenge, got %v", challenges)
+			}
+
+			params := map[string]string{"realm": doc.Realm}
+			if doc.Service != "" {
+				params["service"] = doc.Service
+			}
+			for k, v := range doc.Parameters {
+				params[k] = v
+			}
+			if doc.Type != challenges[0].Scheme || !reflect.DeepEqual(params, challenges[0].Parameters) {
+				t.Fatalf("auth challenge %+v does not match WWW-Authenticate header %+v", doc, challenges[0])
+			}
+			if (doc.Scopes != nil) != (doc.Type == "bearer") {
+				t.Fatalf("expected scopes to be described for bearer challenges only, got %+v", doc.Scopes)
+			}
+		})
+	}
+}
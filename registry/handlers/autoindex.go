@@ -0,0 +1,327 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/manifest/manifestlist"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/manifest/schema2"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Placeholders of the auto index tag templates.
+const (
+	autoIndexGroup    = "{group}"
+	autoIndexPlatform = "{platform}"
+)
+
+// autoIndexer assembles image index tags from the per-platform tags pushed
+// to a repository.
+type autoIndexer struct {
+	rules []autoIndexRule
+
+	// mu serializes assemblies, so that an assembly sees the tags pushed
+	// before any assembly which completes after it.
+	mu sync.Mutex
+}
+
+type autoIndexRule struct {
+	repositories []string
+	// tags matches the per-platform tags, capturing their group.
+	tags  *regexp.Regexp
+	index string
+}
+
+// newAutoIndexer validates config and returns the indexer it describes, or
+// nil if no rule is configured.
+func newAutoIndexer(config configuration.AutoIndex) (*autoIndexer, error) {
+	if len(config.Rules) == 0 {
+		return nil, nil
+	}
+
+	a := &autoIndexer{}
+	for i, rule := range config.Rules {
+		if len(rule.Repositories) == 0 {
+			return nil, fmt.Errorf("rule %d does not match any repository", i)
+		}
+		for _, pattern := range rule.Repositories {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid rule %d pattern %q: %w", i, pattern, err)
+			}
+		}
+
+		tags, err := compileTagTemplate(rule.Tags)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rule %d tags template: %w", i, err)
+		}
+
+		index := rule.Index
+		if index == "" {
+			index = autoIndexGroup
+		}
+		grouped := strings.Contains(rule.Tags, autoIndexGroup)
+		if strings.Contains(index, autoIndexGroup) && !grouped {
+			return nil, fmt.Errorf("rule %d index template refers to %s, which the tags template lacks", i, autoIndexGroup)
+		}
+		if !grouped && tags.MatchString(index) {
+			return nil, fmt.Errorf("rule %d index tag %q matches its own tags template", i, index)
+		}
+
+		a.rules = append(a.rules, autoIndexRule{
+			repositories: rule.Repositories,
+			tags:         tags,
+			index:        index,
+		})
+	}
+	return a, nil
+}
+
+// compileTagTemplate returns a regular expression matching the tags
+// described by template, capturing their group.
+func compileTagTemplate(template string) (*regexp.Regexp, error) {
+	if strings.Count(template, autoIndexPlatform) != 1 {
+		return nil, fmt.Errorf("%q must contain %s exactly once", template, autoIndexPlatform)
+	}
+	if strings.Count(template, autoIndexGroup) > 1 {
+		return nil, fmt.Errorf("%q contains %s more than once", template, autoIndexGroup)
+	}
+
+	expr := regexp.QuoteMeta(template)
+	expr = strings.Replace(expr, regexp.QuoteMeta(autoIndexGroup), `(?P<group>[\w][\w.-]*)`, 1)
+	expr = strings.Replace(expr, regexp.QuoteMeta(autoIndexPlatform), `[\w]+`, 1)
+	return regexp.Compile("^" + expr + "$")
+}
+
+// group returns the group of tag, and whether tag matches the rule.
+func (rule *autoIndexRule) group(tag string) (string, bool) {
+	match := rule.tags.FindStringSubmatch(tag)
+	if match == nil {
+		return "", false
+	}
+	if i := rule.tags.SubexpIndex("group"); i >= 0 {
+		return match[i], true
+	}
+	return "", true
+}
+
+// match returns the rule applying to tag in repository, and the group of
+// the tag.
+func (a *autoIndexer) match(repository, tag string) (*autoIndexRule, string, bool) {
+	for i := range a.rules {
+		rule := &a.rules[i]
+		for _, pattern := range rule.repositories {
+			if ok, _ := path.Match(pattern, repository); !ok {
+				continue
+			}
+			if group, ok := rule.group(tag); ok {
+				return rule, group, true
+			}
+		}
+	}
+	return nil, "", false
+}
+
+// update assembles the index tag of the group of tag, if tag is a
+// per-platform tag. Failures are logged: the push of tag has succeeded
+// regardless. A nil indexer does nothing.
+func (a *autoIndexer) update(ctx *Context, tag string) {
+	if a == nil {
+		return
+	}
+
+	rule, group, ok := a.match(ctx.Repository.Named().Name(), tag)
+	if !ok {
+		return
+	}
+
+	index := strings.ReplaceAll(rule.index, autoIndexGroup, group)
+	if index == tag {
+		// the index tag itself was pushed
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if err := rule.assemble(ctx, index, group); err != nil {
+		dcontext.GetLogger(ctx).Errorf("failed to assemble index tag %q from tag %q: %v", index, tag, err)
+	}
+}
+
+// assemble points the index tag to an image index referencing the
+// manifests of the tags of group. It leaves the index tag untouched if it
+// already references them.
+func (rule *autoIndexRule) assemble(ctx *Context, index, group string) error {
+	if !reference.TagRegexp.MatchString(index) {
+		return fmt.Errorf("invalid index tag %q", index)
+	}
+
+	tags := ctx.Repository.Tags(ctx)
+	all, err := tags.All(ctx)
+	if err != nil {
+		return err
+	}
+
+	var members []string
+	for _, tag := range all {
+		if g, ok := rule.group(tag); ok && g == group && tag != index {
+			members = append(members, tag)
+		}
+	}
+	sort.Strings(members)
+
+	manifests, err := ctx.Repository.Manifests(ctx)
+	if err != nil {
+		return err
+	}
+
+	var (
+		descriptors []v1.Descriptor
+		platforms   = make(map[string]string)
+		oci         = true
+	)
+	for _, tag := range members {
+		desc, isOCI, err := platformDescriptor(ctx, tags, manifests, tag)
+		if err != nil {
+			var tagUnknown distribution.ErrTagUnknown
+			if errors.As(err, &tagUnknown) {
+				continue
+			}
+			return fmt.Errorf("tag %q: %w", tag, err)
+		}
+		if desc == nil {
+			dcontext.GetLogger(ctx).Warnf("not including tag %q in index tag %q: not a single platform image", tag, index)
+			continue
+		}
+
+		platform := desc.Platform.OS + "/" + desc.Platform.Architecture
+		if desc.Platform.Variant != "" {
+			platform += "/" + desc.Platform.Variant
+		}
+		if other, ok := platforms[platform]; ok {
+			dcontext.GetLogger(ctx).Warnf("not including tag %q in index tag %q: tag %q already provides platform %s", tag, index, other, platform)
+			continue
+		}
+		platforms[platform] = tag
+
+		descriptors = append(descriptors, *desc)
+		oci = oci && isOCI
+	}
+	if len(descriptors) == 0 {
+		return nil
+	}
+
+	var manifest distribution.Manifest
+	if oci {
+		manifest, err = ocischema.FromDescriptors(descriptors, nil)
+	} else {
+		listDescriptors := make([]manifestlist.ManifestDescriptor, 0, len(descriptors))
+		for _, desc := range descriptors {
+			listDescriptors = append(listDescriptors, manifestlist.ManifestDescriptor{
+				Descriptor: v1.Descriptor{
+					MediaType: desc.MediaType,
+					Digest:    desc.Digest,
+					Size:      desc.Size,
+				},
+				Platform: manifestlist.PlatformSpec{
+					Architecture: desc.Platform.Architecture,
+					OS:           desc.Platform.OS,
+					OSVersion:    desc.Platform.OSVersion,
+					OSFeatures:   desc.Platform.OSFeatures,
+					Variant:      desc.Platform.Variant,
+				},
+			})
+		}
+		manifest, err = manifestlist.FromDescriptors(listDescriptors)
+	}
+	if err != nil {
+		return err
+	}
+
+	mediaType, payload, err := manifest.Payload()
+	if err != nil {
+		return err
+	}
+	dgst := digest.FromBytes(payload)
+
+	if current, err := tags.Get(ctx, index); err == nil && current.Digest == dgst {
+		return nil
+	}
+
+	if _, err := manifests.Put(ctx, manifest, distribution.WithTag(index)); err != nil {
+		return err
+	}
+	if err := tags.Tag(ctx, index, v1.Descriptor{MediaType: mediaType, Digest: dgst, Size: int64(len(payload))}); err != nil {
+		return err
+	}
+
+	dcontext.GetLogger(ctx).Infof("assembled index tag %q from tags %v", index, members)
+	return nil
+}
+
+// platformDescriptor returns the descriptor of the manifest tagged tag, with
+// the platform read from its image configuration, and whether it is an OCI
+// manifest. The descriptor is nil if tag is not a single platform image.
+func platformDescriptor(ctx *Context, tags distribution.TagService, manifests distribution.ManifestService, tag string) (*v1.Descriptor, bool, error) {
+	tagged, err := tags.Get(ctx, tag)
+	if err != nil {
+		return nil, false, err
+	}
+	manifest, err := manifests.Get(ctx, tagged.Digest)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var (
+		config v1.Descriptor
+		isOCI  bool
+	)
+	switch m := manifest.(type) {
+	case *schema2.DeserializedManifest:
+		config = m.Config
+	case *ocischema.DeserializedManifest:
+		config = m.Config
+		isOCI = true
+	default:
+		return nil, false, nil
+	}
+
+	payload, err := ctx.Repository.Blobs(ctx).Get(ctx, config.Digest)
+	if err != nil {
+		return nil, false, err
+	}
+	var image v1.Image
+	if err := json.Unmarshal(payload, &image); err != nil || image.OS == "" || image.Architecture == "" {
+		// not an image configuration, such as an artifact
+		return nil, false, nil
+	}
+
+	mediaType, manifestPayload, err := manifest.Payload()
+	if err != nil {
+		return nil, false, err
+	}
+	return &v1.Descriptor{
+		MediaType: mediaType,
+		Digest:    tagged.Digest,
+		Size:      int64(len(manifestPayload)),
+		Platform: &v1.Platform{
+			Architecture: image.Architecture,
+			OS:           image.OS,
+			OSVersion:    image.OSVersion,
+			OSFeatures:   image.OSFeatures,
+			Variant:      image.Variant,
+		},
+	}, isOCI, nil
+}
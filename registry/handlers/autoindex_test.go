@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/testutil"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestNewAutoIndexerInvalid(t *testing.T) {
+	for _, rule := range []configuration.AutoIndexRule{
+		{Tags: "{group}-{platform}"},
+		{Repositories: []string{"["}, Tags: "{group}-{platform}"},
+		{Repositories: []string{"*"}, Tags: "{group}"},
+		{Repositories: []string{"*"}, Tags: "{platform}-{platform}"},
+		{Repositories: []string{"*"}, Tags: "{group}-{group}-{platform}"},
+		{Repositories: []string{"*"}, Tags: "latest-{platform}", Index: "{group}"},
+		{Repositories: []string{"*"}, Tags: "{platform}", Index: "amd64"},
+	} {
+		if _, err := newAutoIndexer(configuration.AutoIndex{Rules: []configuration.AutoIndexRule{rule}}); err == nil {
+			t.Errorf("expected rule %+v to be invalid", rule)
+		}
+	}
+}
+
+// pushPlatformImage pushes an OCI image of architecture arch as tag.
+func pushPlatformImage(t *testing.T, env *testEnv, name reference.Named, tag, arch string) digest.Digest {
+	t.Helper()
+
+	config, err := json.Marshal(v1.Image{Platform: v1.Platform{OS: "linux", Architecture: arch}})
+	checkErr(t, err, "marshaling image config")
+	configDigest := digest.FromBytes(config)
+	uploadURLBase, _ := startPushLayer(t, env, name)
+	pushLayer(t, env.builder, name, configDigest, uploadURLBase, bytes.NewReader(config))
+
+	layer, layerDigest, err := testutil.CreateRandomTarFile()
+	checkErr(t, err, "creating random layer")
+	uploadURLBase, _ = startPushLayer(t, env, name)
+	pushLayer(t, env.builder, name, layerDigest, uploadURLBase, layer)
+
+	manifest := &ocischema.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: v1.MediaTypeImageManifest,
+		Config:    v1.Descriptor{MediaType: v1.MediaTypeImageConfig, Digest: configDigest, Size: int64(len(config))},
+		Layers:    []v1.Descriptor{{MediaType: v1.MediaTypeImageLayerGzip, Digest: layerDigest}},
+	}
+	tagRef, _ := reference.WithTag(name, tag)
+	manifestURL, err := env.builder.BuildManifestURL(tagRef)
+	checkErr(t, err, "building manifest url")
+	resp := putManifest(t, "putting manifest", manifestURL, v1.MediaTypeImageManifest, manifest)
+	defer resp.Body.Close()
+	checkResponse(t, "putting manifest", resp, http.StatusCreated)
+
+	dgst, err := digest.Parse(resp.Header.Get("Docker-Content-Digest"))
+	checkErr(t, err, "parsing manifest digest")
+	return dgst
+}
+
+// getIndex returns the index tagged tag, and its digest.
+func getIndex(t *testing.T, env *testEnv, name reference.Named, tag string) (v1.Index, digest.Digest) {
+	t.Helper()
+
+	tagRef, _ := reference.WithTag(name, tag)
+	manifestURL, err := env.builder.BuildManifestURL(tagRef)
+	checkErr(t, err, "building manifest url")
+	req, err := http.NewRequest(http.MethodGet, manifestURL, nil)
+	checkErr(t, err, "building request")
+	req.Header.Set("Accept", v1.MediaTypeImageIndex)
+	resp, err := http.DefaultClient.Do(req)
+	checkErr(t, err, "fetching index")
+	defer resp.Body.Close()
+	checkResponse(t, "fetching index", resp, http.StatusOK)
+
+	var index v1.Index
+	checkErr(t, json.NewDecoder(resp.Body).Decode(&index), "decoding index")
+	if index.MediaType != v1.MediaTypeImageIndex {
+		t.Fatalf("unexpected media type %q", index.MediaType)
+	}
+	return index, digest.Digest(resp.Header.Get("Docker-Content-Digest"))
+}
+
+func TestAutoIndex(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory":    configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[any]any{"enabled": false}},
+		},
+		AutoIndex: configuration.AutoIndex{
+			Rules: []configuration.AutoIndexRule{
+				{Repositories: []string{"ci/*"}, Tags: "{group}-{platform}"},
+			},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	name, _ := reference.WithName("ci/app")
+	amd64 := pushPlatformImage(t, env, name, "v1-amd64", "amd64")
+	index, _ := getIndex(t, env, name, "v1")
+	if len(index.Manifests) != 1 || index.Manifests[0].Digest != amd64 {
+		t.Fatalf("unexpected index manifests after first push: %+v", index.Manifests)
+	}
+
+	arm64 := pushPlatformImage(t, env, name, "v1-arm64", "arm64")
+	index, indexDigest := getIndex(t, env, name, "v1")
+	if len(index.Manifests) != 2 {
+		t.Fatalf("unexpected index manifests after second push: %+v", index.Manifests)
+	}
+	for i, expected := range []struct {
+		digest digest.Digest
+		arch   string
+	}{
+		{amd64, "amd64"},
+		{arm64, "arm64"},
+	} {
+		desc := index.Manifests[i]
+		if desc.Digest != expected.digest || desc.Platform == nil || desc.Platform.OS != "linux" || desc.Platform.Architecture != expected.arch {
+			t.Errorf("unexpected index manifest %d: %+v", i, desc)
+		}
+	}
+
+	// pushing a tag of the group again leaves the index untouched
+	tagRef, _ := reference.WithTag(name, "v1-amd64")
+	manifestURL, err := env.builder.BuildManifestURL(tagRef)
+	checkErr(t, err, "building manifest url")
+	req, err := http.NewRequest(http.MethodGet, manifestURL, nil)
+	checkErr(t, err, "building request")
+	req.Header.Set("Accept", v1.MediaTypeImageManifest)
+	resp, err := http.DefaultClient.Do(req)
+	checkErr(t, err, "fetching manifest")
+	var manifest ocischema.Manifest
+	checkErr(t, json.NewDecoder(resp.Body).Decode(&manifest), "decoding manifest")
+	resp.Body.Close()
+	resp = putManifest(t, "putting manifest again", manifestURL, v1.MediaTypeImageManifest, &manifest)
+	resp.Body.Close()
+	checkResponse(t, "putting manifest again", resp, http.StatusCreated)
+	if _, dgst := getIndex(t, env, name, "v1"); dgst != indexDigest {
+		t.Fatalf("index digest changed from %s to %s", indexDigest, dgst)
+	}
+
+	// tags of other repositories are not grouped
+	other, _ := reference.WithName("other/app")
+	pushPlatformImage(t, env, other, "v1-amd64", "amd64")
+	tagRef, _ = reference.WithTag(other, "v1")
+	manifestURL, err = env.builder.BuildManifestURL(tagRef)
+	checkErr(t, err, "building manifest url")
+	resp, err = http.Get(manifestURL)
+	checkErr(t, err, "fetching index")
+	resp.Body.Close()
+	checkResponse(t, "fetching index", resp, http.StatusNotFound)
+}
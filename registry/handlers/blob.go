@@ -1,11 +1,13 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/distribution/distribution/v3"
 	"github.com/distribution/distribution/v3/internal/dcontext"
 	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/distribution/distribution/v3/registry/pullstats"
 	"github.com/gorilla/handlers"
 	"github.com/opencontainers/go-digest"
 )
@@ -32,7 +34,7 @@ func blobDispatcher(ctx *Context, r *http.Request) http.Handler {
 	}
 
 	mhandler := handlers.MethodHandler{
-		http.MethodGet:  http.HandlerFunc(blobHandler.GetBlob),
+		http.MethodGet:  ctx.downloadLimiter.limit(ctx, http.HandlerFunc(blobHandler.GetBlob)),
 		http.MethodHead: http.HandlerFunc(blobHandler.GetBlob),
 	}
 
@@ -66,10 +68,19 @@ func (bh *blobHandler) GetBlob(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := blobs.ServeBlob(bh, w, r, desc.Digest); err != nil {
+		if errors.Is(err, distribution.ErrBlobRedirectUnavailable) {
+			dcontext.GetLogger(bh).Errorf("blob not served: %v", err)
+			bh.Errors = append(bh.Errors, errcode.ErrorCodeBlobRedirectUnavailable.WithDetail(err.Error()))
+			return
+		}
 		dcontext.GetLogger(bh).Debugf("unexpected error getting blob HTTP handler: %v", err)
 		bh.Errors = append(bh.Errors, toErrcodeErrors(err)...)
 		return
 	}
+
+	if r.Method == http.MethodGet {
+		bh.recordPull(pullstats.KindBlob, desc.Digest.String())
+	}
 }
 
 // DeleteBlob deletes a layer blob
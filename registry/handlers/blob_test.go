@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/distribution/distribution/v3/testutil"
+	"github.com/distribution/reference"
+)
+
+func TestGetBlobRedirectRequired(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory":    configuration.Parameters{},
+			"redirect":    configuration.Parameters{"required": true},
+			"maintenance": configuration.Parameters{"uploadpurging": map[any]any{"enabled": false}},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	name, _ := reference.WithName("foo/redirected")
+	layer, dgst, err := testutil.CreateRandomTarFile()
+	checkErr(t, err, "creating random layer")
+	content, err := io.ReadAll(layer)
+	checkErr(t, err, "reading random layer")
+	uploadURLBase, _ := startPushLayer(t, env, name)
+	pushLayer(t, env.builder, name, dgst, uploadURLBase, bytes.NewReader(content))
+
+	ref, _ := reference.WithDigest(name, dgst)
+	blobURL, err := env.builder.BuildBlobURL(ref)
+	checkErr(t, err, "building blob url")
+
+	// the inmemory driver cannot redirect, and the registry does not fall
+	// back to serving the content
+	resp, err := http.Get(blobURL)
+	checkErr(t, err, "fetching blob")
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	checkErr(t, err, "reading response")
+	if resp.StatusCode != http.StatusNotImplemented {
+		t.Fatalf("unexpected status fetching blob: %d", resp.StatusCode)
+	}
+	if bytes.Contains(body, content) {
+		t.Fatal("blob content served")
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	checkBodyHasErrorCodes(t, "fetching blob", resp, errcode.ErrorCodeBlobRedirectUnavailable)
+
+	resp, err = http.Head(blobURL)
+	checkErr(t, err, "checking blob")
+	resp.Body.Close()
+	checkResponse(t, "checking blob", resp, http.StatusOK)
+}
@@ -1,7 +1,10 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -10,12 +13,17 @@ import (
 	"github.com/distribution/distribution/v3/internal/dcontext"
 	"github.com/distribution/distribution/v3/registry/api/errcode"
 	"github.com/distribution/distribution/v3/registry/storage"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
 	"github.com/distribution/reference"
 	"github.com/gorilla/handlers"
 	"github.com/opencontainers/go-digest"
 	v1 "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
+// ociChunkMinLengthHeader advertises the minimum size of the chunks of an
+// upload to clients.
+const ociChunkMinLengthHeader = "OCI-Chunk-Min-Length"
+
 // blobUploadDispatcher constructs and returns the blob upload handler for the
 // given request context.
 func blobUploadDispatcher(ctx *Context, r *http.Request) http.Handler {
@@ -31,8 +39,8 @@ func blobUploadDispatcher(ctx *Context, r *http.Request) http.Handler {
 
 	if !ctx.readOnly {
 		handler[http.MethodPost] = http.HandlerFunc(buh.StartBlobUpload)
-		handler[http.MethodPatch] = http.HandlerFunc(buh.PatchBlobData)
-		handler[http.MethodPut] = http.HandlerFunc(buh.PutBlobUploadComplete)
+		handler[http.MethodPatch] = ctx.uploadLimiter.limit(ctx, http.HandlerFunc(buh.PatchBlobData))
+		handler[http.MethodPut] = ctx.uploadLimiter.limit(ctx, http.HandlerFunc(buh.PutBlobUploadComplete))
 		handler[http.MethodDelete] = http.HandlerFunc(buh.CancelBlobUpload)
 	}
 
@@ -40,6 +48,9 @@ func blobUploadDispatcher(ctx *Context, r *http.Request) http.Handler {
 		if r.Method == http.MethodGet || r.Method == http.MethodHead {
 			return handler
 		}
+		if ctx.App.preverifiedDigest(ctx, r) {
+			ctx.App.markPreverifiedDigest(ctx, r)
+		}
 		if h := buh.ResumeBlobUpload(ctx, r); h != nil {
 			return h
 		}
@@ -71,12 +82,27 @@ func (buh *blobUploadHandler) StartBlobUpload(w http.ResponseWriter, r *http.Req
 	mountDigest := r.FormValue("mount")
 
 	if mountDigest != "" && fromRepo != "" {
+		if user := dcontext.GetStringValue(buh, userNameKey); !buh.App.mountPolicy.allowed(user, fromRepo) {
+			dcontext.GetLogger(buh).Warnf("denying mount from repository %q for user %q", fromRepo, user)
+			buh.Errors = append(buh.Errors, errcode.ErrorCodeDenied.WithDetail(fmt.Sprintf("mounting from repository %s is not permitted", fromRepo)))
+			return
+		}
+
+		// the source is read, so its name is rewritten as for pulls
+		if target, _, ok := buh.namespaceRewrites.rewrite(fromRepo); ok {
+			fromRepo = target
+		}
 		opt, err := buh.createBlobMountOption(fromRepo, mountDigest)
 		if opt != nil && err == nil {
 			options = append(options, opt)
 		}
 	}
 
+	if err := buh.App.repositoryLimit.check(buh, buh.Repository.Named()); err != nil {
+		buh.Errors = append(buh.Errors, err)
+		return
+	}
+
 	blobs := buh.Repository.Blobs(buh)
 	upload, err := blobs.Create(buh, options...)
 	if err != nil {
@@ -86,6 +112,10 @@ func (buh *blobUploadHandler) StartBlobUpload(w http.ResponseWriter, r *http.Req
 			}
 		} else if err == distribution.ErrUnsupported {
 			buh.Errors = append(buh.Errors, errcode.ErrorCodeUnsupported)
+		} else if errors.As(err, new(storagedriver.InsufficientStorageError)) {
+			buh.Errors = append(buh.Errors, errcode.ErrorCodeInsufficientStorage.WithDetail(err.Error()))
+		} else if errors.As(err, new(distribution.ErrRepositoryNameInvalid)) {
+			buh.Errors = append(buh.Errors, errcode.ErrorCodeNameInvalid.WithDetail(err.Error()))
 		} else {
 			buh.Errors = append(buh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
 		}
@@ -94,6 +124,16 @@ func (buh *blobUploadHandler) StartBlobUpload(w http.ResponseWriter, r *http.Req
 
 	buh.Upload = upload
 
+	if !buh.uploadSessions.add(upload.ID()) {
+		dcontext.GetLogger(buh).Warn("rejecting blob upload: upload session limit reached")
+		if err := upload.Cancel(buh); err != nil {
+			dcontext.GetLogger(buh).Errorf("error canceling upload after reaching the session limit: %v", err)
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(int(defaultTransferRetryAfter.Seconds())))
+		buh.Errors = append(buh.Errors, errcode.ErrorCodeTooManyRequests.WithDetail("upload session limit reached"))
+		return
+	}
+
 	if err := buh.blobUploadResponse(w, r); err != nil {
 		buh.Errors = append(buh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
 		return
@@ -166,10 +206,35 @@ func (buh *blobUploadHandler) PatchBlobData(w http.ResponseWriter, r *http.Reque
 		}
 	}
 
-	if err := copyFullPayload(buh, w, r, buh.Upload, -1, "blob PATCH"); err != nil {
-		buh.Errors = append(buh.Errors, errcode.ErrorCodeUnknown.WithDetail(err.Error()))
+	// a chunk smaller than the minimum is the last one of the upload, so no
+	// chunk may follow it
+	minSize := buh.Config.HTTP.Uploads.MinChunkSize
+	if minSize > 0 && buh.State.Final {
+		w.Header().Set(ociChunkMinLengthHeader, strconv.FormatInt(minSize, 10))
+		buh.Errors = append(buh.Errors, errcode.ErrorCodeRangeInvalid.WithDetail(fmt.Sprintf("no chunk may follow a chunk smaller than the minimum of %d bytes", minSize)))
+		return
+	}
+	offset := buh.Upload.Size()
+
+	checksums, err := parseChunkChecksums(r.Header)
+	if err != nil {
+		buh.Errors = append(buh.Errors, errcode.ErrorCodeDigestInvalid.WithDetail(err.Error()))
+		return
+	}
+	if len(checksums) > 0 {
+		// chunks carrying checksums are verified as they are appended, and
+		// a corrupted chunk cancels the upload
+		if !buh.patchVerifiedChunk(w, r, checksums) {
+			return
+		}
+	} else if err := copyFullPayload(buh, w, r, buh.Upload, -1, "blob PATCH"); err != nil {
+		buh.abandonIfDisconnected(err)
+		buh.Errors = append(buh.Errors, uploadWriteError(err))
 		return
 	}
+	if minSize > 0 && buh.Upload.Size()-offset < minSize {
+		buh.State.Final = true
+	}
 
 	if err := buh.blobUploadResponse(w, r); err != nil {
 		buh.Errors = append(buh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
@@ -179,6 +244,51 @@ func (buh *blobUploadHandler) PatchBlobData(w http.ResponseWriter, r *http.Reque
 	w.WriteHeader(http.StatusAccepted)
 }
 
+// patchVerifiedChunk appends the chunk of the request to the upload,
+// verifying it against checksums as it is streamed. The upload cannot be
+// truncated back, so it is canceled if the chunk does not match or is too
+// large. It reports whether the chunk was appended.
+func (buh *blobUploadHandler) patchVerifiedChunk(w http.ResponseWriter, r *http.Request, checksums []chunkChecksum) bool {
+	maxSize := buh.Config.HTTP.Uploads.MaxChecksumChunkSize
+	if maxSize == 0 {
+		maxSize = defaultMaxChecksumChunkSize
+	}
+	tooLarge := errcode.ErrorCodeSizeInvalid.WithDetail(fmt.Sprintf("chunks carrying checksums must not exceed %d bytes", maxSize))
+	if r.ContentLength > maxSize {
+		buh.Errors = append(buh.Errors, tooLarge)
+		return false
+	}
+
+	verifier := newChunkVerifier(checksums)
+	if err := copyFullPayload(buh, w, r, io.MultiWriter(buh.Upload, verifier), maxSize, "blob PATCH"); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			buh.cancelCorrupted()
+			buh.Errors = append(buh.Errors, tooLarge)
+		} else {
+			buh.abandonIfDisconnected(err)
+			buh.Errors = append(buh.Errors, uploadWriteError(err))
+		}
+		return false
+	}
+
+	if err := verifier.verify(); err != nil {
+		buh.cancelCorrupted()
+		buh.Errors = append(buh.Errors, errcode.ErrorCodeDigestInvalid.WithDetail(err.Error()+", the upload is canceled"))
+		return false
+	}
+	return true
+}
+
+// cancelCorrupted cancels the upload after a chunk failed its checks while
+// being appended.
+func (buh *blobUploadHandler) cancelCorrupted() {
+	buh.uploadSessions.release(buh.UUID)
+	if err := buh.Upload.Cancel(buh); err != nil {
+		dcontext.GetLogger(buh).Errorf("error canceling upload after a corrupted chunk: %v", err)
+	}
+}
+
 // PutBlobUploadComplete takes the final request of a blob upload. The
 // request may include all the blob data or no blob data. Any data
 // provided is received and verified. If successful, the blob is linked
@@ -186,6 +296,7 @@ func (buh *blobUploadHandler) PatchBlobData(w http.ResponseWriter, r *http.Reque
 // url of the blob.
 func (buh *blobUploadHandler) PutBlobUploadComplete(w http.ResponseWriter, r *http.Request) {
 	if buh.Upload == nil {
+		buh.uploadSessions.release(buh.UUID)
 		buh.Errors = append(buh.Errors, errcode.ErrorCodeBlobUploadUnknown)
 		return
 	}
@@ -207,10 +318,13 @@ func (buh *blobUploadHandler) PutBlobUploadComplete(w http.ResponseWriter, r *ht
 	}
 
 	if err := copyFullPayload(buh, w, r, buh.Upload, -1, "blob PUT"); err != nil {
-		buh.Errors = append(buh.Errors, errcode.ErrorCodeUnknown.WithDetail(err.Error()))
+		buh.abandonIfDisconnected(err)
+		buh.Errors = append(buh.Errors, uploadWriteError(err))
 		return
 	}
 
+	// the upload is either committed or canceled below
+	defer buh.uploadSessions.release(buh.UUID)
 	desc, err := buh.Upload.Commit(buh, v1.Descriptor{
 		Digest: dgst,
 
@@ -222,6 +336,8 @@ func (buh *blobUploadHandler) PutBlobUploadComplete(w http.ResponseWriter, r *ht
 		switch err := err.(type) {
 		case distribution.ErrBlobInvalidDigest:
 			buh.Errors = append(buh.Errors, errcode.ErrorCodeDigestInvalid.WithDetail(err))
+		case distribution.ErrBlobDecompressedSizeExceeded:
+			buh.Errors = append(buh.Errors, errcode.ErrorCodeBlobUploadInvalid.WithDetail(err))
 		case errcode.Error:
 			buh.Errors = append(buh.Errors, err)
 		default:
@@ -256,10 +372,12 @@ func (buh *blobUploadHandler) PutBlobUploadComplete(w http.ResponseWriter, r *ht
 // CancelBlobUpload cancels an in-progress upload of a blob.
 func (buh *blobUploadHandler) CancelBlobUpload(w http.ResponseWriter, r *http.Request) {
 	if buh.Upload == nil {
+		buh.uploadSessions.release(buh.UUID)
 		buh.Errors = append(buh.Errors, errcode.ErrorCodeBlobUploadUnknown)
 		return
 	}
 	defer buh.Upload.Close()
+	defer buh.uploadSessions.release(buh.UUID)
 
 	w.Header().Set("Docker-Upload-UUID", buh.UUID)
 	if err := buh.Upload.Cancel(buh); err != nil {
@@ -270,6 +388,38 @@ func (buh *blobUploadHandler) CancelBlobUpload(w http.ResponseWriter, r *http.Re
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// abandonIfDisconnected marks the upload as abandoned if writing the request
+// payload to it failed because the client disconnected, so that its data is
+// purged sooner than that of uploads in progress. Resuming the upload clears
+// the mark.
+func (buh *blobUploadHandler) abandonIfDisconnected(err error) {
+	if !errors.Is(err, errClientDisconnected) {
+		return
+	}
+	abandonUpload(buh, buh.Upload)
+}
+
+// abandonUpload marks upload, whose client is gone, as abandoned.
+func abandonUpload(ctx context.Context, upload distribution.BlobWriter) {
+	abandoner, ok := upload.(distribution.BlobWriteAbandoner)
+	if !ok {
+		return
+	}
+	// the request context is canceled once the client is gone
+	if err := abandoner.Abandon(context.WithoutCancel(ctx)); err != nil && err != distribution.ErrUnsupported {
+		dcontext.GetLogger(ctx).Errorf("error marking upload as abandoned: %v", err)
+	}
+}
+
+// uploadWriteError returns the error reported to the client when writing the
+// request payload to the upload fails.
+func uploadWriteError(err error) errcode.Error {
+	if errors.As(err, new(storagedriver.InsufficientStorageError)) {
+		return errcode.ErrorCodeInsufficientStorage.WithDetail(err.Error())
+	}
+	return errcode.ErrorCodeUnknown.WithDetail(err.Error())
+}
+
 func (buh *blobUploadHandler) ResumeBlobUpload(ctx *Context, r *http.Request) http.Handler {
 	state, err := hmacKey(ctx.Config.HTTP.Secret).unpackUploadState(r.FormValue("_state"))
 	if err != nil {
@@ -312,6 +462,8 @@ func (buh *blobUploadHandler) ResumeBlobUpload(ctx *Context, r *http.Request) ht
 
 	if size := upload.Size(); size != buh.State.Offset {
 		dcontext.GetLogger(ctx).Errorf("upload resumed at wrong offset: %d != %d", size, buh.State.Offset)
+		// the returned handler is not wrapped to close the upload
+		upload.Close()
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			buh.Errors = append(buh.Errors, errcode.ErrorCodeRangeInvalid.WithDetail(err))
 		})
@@ -337,7 +489,7 @@ func (buh *blobUploadHandler) blobUploadResponse(w http.ResponseWriter, r *http.
 	}
 
 	uploadURL, err := buh.urlBuilder.BuildBlobUploadChunkURL(
-		buh.Repository.Named(), buh.Upload.ID(),
+		buh.clientName(), buh.Upload.ID(),
 		url.Values{
 			"_state": []string{token},
 		})
@@ -356,6 +508,9 @@ func (buh *blobUploadHandler) blobUploadResponse(w http.ResponseWriter, r *http.
 
 	w.Header().Set("Content-Length", "0")
 	w.Header().Set("Range", fmt.Sprintf("0-%d", endRange))
+	if minSize := buh.Config.HTTP.Uploads.MinChunkSize; minSize > 0 {
+		w.Header().Set(ociChunkMinLengthHeader, strconv.FormatInt(minSize, 10))
+	}
 
 	return nil
 }
@@ -386,7 +541,7 @@ func (buh *blobUploadHandler) createBlobMountOption(fromRepo, mountDigest string
 // created blob. A 201 Created is written as well as the canonical URL and
 // blob digest.
 func (buh *blobUploadHandler) writeBlobCreatedHeaders(w http.ResponseWriter, desc v1.Descriptor) error {
-	ref, err := reference.WithDigest(buh.Repository.Named(), desc.Digest)
+	ref, err := reference.WithDigest(buh.clientName(), desc.Digest)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,328 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/distribution/distribution/v3/registry/storage"
+	_ "github.com/distribution/distribution/v3/registry/storage/driver/filesystem"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestBlobUploadInsufficientStorage(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"filesystem": configuration.Parameters{
+				"rootdirectory": t.TempDir(),
+				// no filesystem has that much free space
+				"minfreespace": "4000000TB",
+			},
+			"maintenance": configuration.Parameters{"uploadpurging": map[any]any{"enabled": false}},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	name, _ := reference.WithName("foo/bar")
+	uploadURL, err := env.builder.BuildBlobUploadURL(name)
+	checkErr(t, err, "building upload url")
+	resp, err := http.Post(uploadURL, "", nil)
+	checkErr(t, err, "starting upload")
+	defer resp.Body.Close()
+
+	checkResponse(t, "starting upload", resp, http.StatusInsufficientStorage)
+	checkBodyHasErrorCodes(t, "starting upload", resp, errcode.ErrorCodeInsufficientStorage)
+}
+
+func TestBlobUploadMinChunkSize(t *testing.T) {
+	const minChunkSize = 1024
+
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory":    configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[any]any{"enabled": false}},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	config.HTTP.Uploads.MinChunkSize = minChunkSize
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	name, _ := reference.WithName("foo/chunks")
+	uploadURL, err := env.builder.BuildBlobUploadURL(name)
+	checkErr(t, err, "building upload url")
+	resp, err := http.Post(uploadURL, "", nil)
+	checkErr(t, err, "starting upload")
+	resp.Body.Close()
+	checkResponse(t, "starting upload", resp, http.StatusAccepted)
+
+	// clients learn the minimum from the response starting the upload
+	advertised, err := strconv.Atoi(resp.Header.Get("OCI-Chunk-Min-Length"))
+	if err != nil || advertised != minChunkSize {
+		t.Fatalf("unexpected minimum chunk size advertised: %q", resp.Header.Get("OCI-Chunk-Min-Length"))
+	}
+	location := resp.Header.Get("Location")
+
+	blob := make([]byte, 2*minChunkSize+100)
+	_, err = rand.Read(blob)
+	checkErr(t, err, "generating blob")
+	dgst := digest.FromBytes(blob)
+
+	// clients push chunks of the advertised size, then a small final chunk
+	offset := 0
+	for ; len(blob)-offset >= advertised; offset += advertised {
+		resp, err = doPushChunk(t, location, bytes.NewReader(blob[offset:offset+advertised]), chunkOptions{})
+		checkErr(t, err, "pushing chunk")
+		resp.Body.Close()
+		checkResponse(t, "pushing chunk", resp, http.StatusAccepted)
+		location = resp.Header.Get("Location")
+	}
+	resp, err = doPushChunk(t, location, bytes.NewReader(blob[offset:]), chunkOptions{})
+	checkErr(t, err, "pushing final chunk")
+	resp.Body.Close()
+	checkResponse(t, "pushing final chunk", resp, http.StatusAccepted)
+	location = resp.Header.Get("Location")
+
+	// the small chunk ends the upload
+	resp, err = doPushChunk(t, location, bytes.NewReader(blob[:100]), chunkOptions{})
+	checkErr(t, err, "pushing chunk after the final chunk")
+	checkBodyHasErrorCodes(t, "pushing chunk after the final chunk", resp, errcode.ErrorCodeRangeInvalid)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+		t.Fatalf("unexpected status pushing chunk after the final chunk: %d", resp.StatusCode)
+	}
+	if resp.Header.Get("OCI-Chunk-Min-Length") != strconv.Itoa(minChunkSize) {
+		t.Fatalf("unexpected minimum chunk size advised: %q", resp.Header.Get("OCI-Chunk-Min-Length"))
+	}
+
+	// the rejected chunk left the upload untouched
+	finishUpload(t, env.builder, name, location, dgst)
+	checkBlob(t, env, name, dgst, blob)
+
+	// blobs smaller than the minimum are pushed in a single small chunk
+	small := blob[:100]
+	smallDigest := digest.FromBytes(small)
+	location, _ = startPushLayer(t, env, name)
+	resp, err = doPushChunk(t, location, bytes.NewReader(small), chunkOptions{})
+	checkErr(t, err, "pushing only chunk")
+	resp.Body.Close()
+	checkResponse(t, "pushing only chunk", resp, http.StatusAccepted)
+	finishUpload(t, env.builder, name, resp.Header.Get("Location"), smallDigest)
+	checkBlob(t, env, name, smallDigest, small)
+}
+
+// checkBlob checks that the blob dgst of repository name holds content.
+func checkBlob(t *testing.T, env *testEnv, name reference.Named, dgst digest.Digest, content []byte) {
+	t.Helper()
+	ref, _ := reference.WithDigest(name, dgst)
+	blobURL, err := env.builder.BuildBlobURL(ref)
+	checkErr(t, err, "building blob url")
+	resp, err := http.Get(blobURL)
+	checkErr(t, err, "fetching blob")
+	defer resp.Body.Close()
+	checkResponse(t, "fetching blob", resp, http.StatusOK)
+	body, err := io.ReadAll(resp.Body)
+	checkErr(t, err, "reading blob")
+	if !bytes.Equal(body, content) {
+		t.Fatal("unexpected blob content")
+	}
+}
+
+func TestBlobUploadChunkChecksums(t *testing.T) {
+	const maxChunkSize = 1024
+
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory":    configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[any]any{"enabled": false}},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	config.HTTP.Uploads.MaxChecksumChunkSize = maxChunkSize
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	name, _ := reference.WithName("foo/checksums")
+	location, _ := startPushLayer(t, env, name)
+
+	blob := make([]byte, 2*maxChunkSize)
+	_, err := rand.Read(blob)
+	checkErr(t, err, "generating blob")
+	dgst := digest.FromBytes(blob)
+	first, second := blob[:maxChunkSize], blob[maxChunkSize:]
+
+	md5Sum := md5.Sum(first)
+	resp, err := doPushChunk(t, location, bytes.NewReader(first), chunkOptions{headers: http.Header{
+		"Content-Md5": []string{base64.StdEncoding.EncodeToString(md5Sum[:])},
+	}})
+	checkErr(t, err, "pushing chunk")
+	resp.Body.Close()
+	checkResponse(t, "pushing chunk", resp, http.StatusAccepted)
+	location = resp.Header.Get("Location")
+
+	sha256Sum := sha256.Sum256(second)
+	corrupted := bytes.Clone(second)
+	corrupted[0] ^= 0xff
+	for _, tc := range []struct {
+		name   string
+		body   []byte
+		header string
+		code   errcode.ErrorCode
+	}{
+		{"malformed checksum", second, "sha-256=" + base64.StdEncoding.EncodeToString(sha256Sum[:]), errcode.ErrorCodeDigestInvalid},
+		{"chunk too large", blob, "sha-256=:" + base64.StdEncoding.EncodeToString(sha256Sum[:]) + ":", errcode.ErrorCodeSizeInvalid},
+	} {
+		resp, err := doPushChunk(t, location, bytes.NewReader(tc.body), chunkOptions{headers: http.Header{
+			"Content-Digest": []string{tc.header},
+		}})
+		checkErr(t, err, "pushing "+tc.name)
+		checkBodyHasErrorCodes(t, "pushing "+tc.name, resp, tc.code)
+		resp.Body.Close()
+	}
+
+	// the rejected chunks left the upload at the end of the first chunk
+	resp, err = http.Get(location)
+	checkErr(t, err, "fetching upload status")
+	resp.Body.Close()
+	checkResponse(t, "fetching upload status", resp, http.StatusNoContent)
+	if expected := "0-" + strconv.Itoa(maxChunkSize-1); resp.Header.Get("Range") != expected {
+		t.Fatalf("unexpected upload range: %q != %q", resp.Header.Get("Range"), expected)
+	}
+
+	// a corrupted chunk is streamed to the upload, which is canceled
+	resp, err = doPushChunk(t, location, bytes.NewReader(corrupted), chunkOptions{headers: http.Header{
+		"Content-Digest": []string{"sha-256=:" + base64.StdEncoding.EncodeToString(sha256Sum[:]) + ":"},
+	}})
+	checkErr(t, err, "pushing corrupted chunk")
+	checkBodyHasErrorCodes(t, "pushing corrupted chunk", resp, errcode.ErrorCodeDigestInvalid)
+	resp.Body.Close()
+	resp, err = http.Get(location)
+	checkErr(t, err, "fetching upload status")
+	checkBodyHasErrorCodes(t, "fetching the status of the canceled upload", resp, errcode.ErrorCodeBlobUploadUnknown)
+	resp.Body.Close()
+
+	// digests of unknown algorithms are ignored
+	location, _ = startPushLayer(t, env, name)
+	for _, chunk := range [][]byte{first, second} {
+		sum := sha256.Sum256(chunk)
+		resp, err = doPushChunk(t, location, bytes.NewReader(chunk), chunkOptions{headers: http.Header{
+			"Content-Digest": []string{"unknown=:AAAA:, sha-256=:" + base64.StdEncoding.EncodeToString(sum[:]) + ":"},
+		}})
+		checkErr(t, err, "pushing chunk")
+		resp.Body.Close()
+		checkResponse(t, "pushing chunk", resp, http.StatusAccepted)
+		location = resp.Header.Get("Location")
+	}
+
+	resp, err = doPushLayer(t, env.builder, name, dgst, location, bytes.NewReader(nil))
+	checkErr(t, err, "completing upload")
+	resp.Body.Close()
+	checkResponse(t, "completing upload", resp, http.StatusCreated)
+}
+
+// disconnectDuringPatch sends part of a chunk to the upload at location, then
+// drops the connection.
+func disconnectDuringPatch(t *testing.T, location string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	body, bodyWriter := io.Pipe()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPatch, location, body)
+	checkErr(t, err, "building patch request")
+	req.ContentLength = 1024
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	go func() {
+		_, _ = bodyWriter.Write(make([]byte, 16))
+	}()
+	// the transport waits for the body to end after the request is canceled
+	time.AfterFunc(200*time.Millisecond, func() {
+		cancel()
+		bodyWriter.CloseWithError(context.Canceled)
+	})
+	if resp, err := http.DefaultClient.Do(req); err == nil {
+		resp.Body.Close()
+		t.Fatal("expected the request to be interrupted")
+	}
+}
+
+func TestBlobUploadAbandoned(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory":    configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[any]any{"enabled": false}},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+	ctx := context.Background()
+
+	name, _ := reference.WithName("foo/bar")
+	location, uuid := startPushLayer(t, env, name)
+	uploadDir := path.Join("/docker/registry/v2/repositories", name.Name(), "_uploads", uuid)
+	marker := path.Join(uploadDir, "abandonedat")
+
+	abandon := func() {
+		disconnectDuringPatch(t, location)
+		// the marker is written once the server noticed the disconnect
+		for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+			if _, err := env.app.driver.Stat(ctx, marker); err == nil {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("expected the upload to be marked as abandoned")
+			}
+		}
+	}
+	purge := func(abandonedBefore time.Time) []string {
+		deleted, errs := storage.PurgeAbandonedUploads(ctx, env.app.driver, time.Now().Add(-time.Hour), abandonedBefore, true)
+		if len(errs) != 0 {
+			t.Fatalf("unexpected errors purging uploads: %v", errs)
+		}
+		return deleted
+	}
+
+	// abandoned uploads are kept during the grace period
+	abandon()
+	if deleted := purge(time.Now().Add(-time.Hour)); len(deleted) != 0 {
+		t.Fatalf("upload purged before the grace period: %v", deleted)
+	}
+
+	// resuming the upload clears the marker
+	resp, err := http.Get(location)
+	checkErr(t, err, "getting upload status")
+	resp.Body.Close()
+	checkResponse(t, "getting upload status", resp, http.StatusNoContent)
+	location = resp.Header.Get("Location")
+	if _, err := env.app.driver.Stat(ctx, marker); err == nil {
+		t.Fatal("expected the resumed upload to no longer be marked as abandoned")
+	}
+	if deleted := purge(time.Now().Add(time.Minute)); len(deleted) != 0 {
+		t.Fatalf("resumed upload purged: %v", deleted)
+	}
+
+	// abandoned uploads are purged after the grace period
+	abandon()
+	if deleted := purge(time.Now().Add(time.Minute)); len(deleted) != 1 || deleted[0] != uploadDir {
+		t.Fatalf("expected the abandoned upload to be purged: %v", deleted)
+	}
+	resp, err = http.Get(location)
+	checkErr(t, err, "getting upload status")
+	resp.Body.Close()
+	checkResponse(t, "getting purged upload status", resp, http.StatusNotFound)
+}
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	"github.com/distribution/distribution/v3/version"
+	"github.com/gorilla/handlers"
+	"github.com/opencontainers/go-digest"
+)
+
+// capabilitiesDigestAlgorithms are the digest algorithms listed by the
+// capabilities document, if available.
+var capabilitiesDigestAlgorithms = []digest.Algorithm{digest.SHA256, digest.SHA384, digest.SHA512}
+
+// capabilities is the document served by the capabilities endpoint, and by
+// the base endpoint if enabled.
+type capabilities struct {
+	Version          string             `json:"version"`
+	Endpoints        map[string]string  `json:"endpoints"`
+	Limits           capabilitiesLimits `json:"limits"`
+	DigestAlgorithms []digest.Algorithm `json:"digestAlgorithms"`
+}
+
+// capabilitiesLimits are the limits of the registry, zero when not enforced.
+type capabilitiesLimits struct {
+	MaxManifestSize int64 `json:"maxManifestSize"`
+	MinChunkSize    int64 `json:"minChunkSize"`
+}
+
+// capabilities describes the registry. The endpoints are the routes served,
+// so that the document follows the configuration.
+func (app *App) capabilities() capabilities {
+	doc := capabilities{
+		Version:   version.Version(),
+		Endpoints: make(map[string]string),
+		Limits: capabilitiesLimits{
+			MaxManifestSize: maxManifestBodySize,
+			MinChunkSize:    app.Config.HTTP.Uploads.MinChunkSize,
+		},
+	}
+
+	for _, descriptor := range v2.APIDescriptor.RouteDescriptors {
+		route := app.router.GetRoute(descriptor.Name)
+		if route == nil || route.GetHandler() == nil {
+			continue
+		}
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			path = descriptor.Path
+		}
+		doc.Endpoints[descriptor.Name] = path
+	}
+
+	for _, algorithm := range capabilitiesDigestAlgorithms {
+		if algorithm.Available() {
+			doc.DigestAlgorithms = append(doc.DigestAlgorithms, algorithm)
+		}
+	}
+	return doc
+}
+
+// capabilitiesDispatcher serves the capabilities document.
+func capabilitiesDispatcher(ctx *Context, r *http.Request) http.Handler {
+	return handlers.MethodHandler{
+		http.MethodGet: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			serveCapabilities(ctx, w)
+		}),
+	}
+}
+
+// serveCapabilities writes the capabilities document of the registry of ctx.
+func serveCapabilities(ctx *Context, w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(ctx.App.capabilities()); err != nil {
+		dcontext.GetLogger(ctx).Errorf("error encoding capabilities: %v", err)
+	}
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/distribution/distribution/v3/configuration"
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	"github.com/distribution/distribution/v3/version"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestCapabilities(t *testing.T) {
+	getCapabilities := func(t *testing.T, u string) capabilities {
+		t.Helper()
+		resp, err := http.Get(u)
+		checkErr(t, err, "fetching capabilities")
+		defer resp.Body.Close()
+		checkResponse(t, "fetching capabilities", resp, http.StatusOK)
+		var doc capabilities
+		checkErr(t, json.NewDecoder(resp.Body).Decode(&doc), "decoding capabilities")
+		return doc
+	}
+
+	for _, tc := range []struct {
+		name         string
+		configure    func(config *configuration.Configuration)
+		served       []string
+		notServed    []string
+		minChunkSize int64
+	}{
+		{
+			name:      "defaults",
+			configure: func(config *configuration.Configuration) {},
+			served:    []string{v2.RouteNameBase, v2.RouteNameCapabilities, v2.RouteNameCatalog, v2.RouteNameManifest, v2.RouteNameBlobUpload},
+			notServed: []string{v2.RouteNameStats, v2.RouteNameChanges, v2.RouteNameBlobUploadWS, v2.RouteNameManifestDefault},
+		},
+		{
+			name: "optional endpoints",
+			configure: func(config *configuration.Configuration) {
+				config.HTTP.Capabilities.Base = true
+				config.HTTP.Uploads.MinChunkSize = 1 << 20
+				config.PullStats.Enabled = true
+				config.Changes.Enabled = true
+				config.Tags.DefaultTag.Enabled = true
+			},
+			served:       []string{v2.RouteNameBase, v2.RouteNameStats, v2.RouteNameChanges, v2.RouteNameManifestDefault},
+			notServed:    []string{v2.RouteNameBlobUploadWS},
+			minChunkSize: 1 << 20,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			config := configuration.Configuration{
+				Storage: configuration.Storage{
+					"inmemory":    configuration.Parameters{},
+					"maintenance": configuration.Parameters{"uploadpurging": map[any]any{"enabled": false}},
+				},
+			}
+			config.HTTP.Headers = headerConfig
+			tc.configure(&config)
+			env := newTestEnvWithConfig(t, &config)
+			defer env.Shutdown()
+
+			capabilitiesURL, err := env.builder.BuildCapabilitiesURL()
+			checkErr(t, err, "building capabilities url")
+			doc := getCapabilities(t, capabilitiesURL)
+
+			if doc.Version != version.Version() {
+				t.Errorf("unexpected version %q", doc.Version)
+			}
+			for _, name := range tc.served {
+				if _, ok := doc.Endpoints[name]; !ok {
+					t.Errorf("expected endpoint %s to be listed, got %v", name, doc.Endpoints)
+				}
+			}
+			for _, name := range tc.notServed {
+				if _, ok := doc.Endpoints[name]; ok {
+					t.Errorf("unexpected endpoint %s listed", name)
+				}
+			}
+			if doc.Endpoints[v2.RouteNameCapabilities] != "/v2/_capabilities" {
+				t.Errorf("unexpected capabilities path %q", doc.Endpoints[v2.RouteNameCapabilities])
+			}
+			if doc.Limits.MaxManifestSize != maxManifestBodySize || doc.Limits.MinChunkSize != tc.minChunkSize {
+				t.Errorf("unexpected limits %+v", doc.Limits)
+			}
+			if !slices.Contains(doc.DigestAlgorithms, digest.SHA256) {
+				t.Errorf("expected sha256 to be supported, got %v", doc.DigestAlgorithms)
+			}
+
+			// the base endpoint serves the document only if enabled
+			baseURL, err := env.builder.BuildBaseURL()
+			checkErr(t, err, "building base url")
+			if config.HTTP.Capabilities.Base {
+				if base := getCapabilities(t, baseURL); base.Version != doc.Version || len(base.Endpoints) != len(doc.Endpoints) {
+					t.Fatalf("base document %+v does not match the capabilities %+v", base, doc)
+				}
+				return
+			}
+			resp, err := http.Get(baseURL)
+			checkErr(t, err, "fetching base url")
+			defer resp.Body.Close()
+			checkResponse(t, "fetching base url", resp, http.StatusOK)
+			body, err := io.ReadAll(resp.Body)
+			checkErr(t, err, "reading base response")
+			if string(body) != "{}" {
+				t.Fatalf("unexpected base response %q", body)
+			}
+		})
+	}
+}
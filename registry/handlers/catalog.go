@@ -2,14 +2,17 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"strconv"
 
+	"github.com/distribution/distribution/v3"
 	"github.com/distribution/distribution/v3/registry/api/errcode"
 	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/reference"
 	"github.com/gorilla/handlers"
 )
 
@@ -20,9 +23,9 @@ func catalogDispatcher(ctx *Context, r *http.Request) http.Handler {
 		Context: ctx,
 	}
 
-	return handlers.MethodHandler{
+	return ctx.App.listCompression.handler(handlers.MethodHandler{
 		http.MethodGet: http.HandlerFunc(catalogHandler.GetCatalog),
-	}
+	})
 }
 
 type catalogHandler struct {
@@ -33,12 +36,32 @@ type catalogAPIResponse struct {
 	Repositories []string `json:"repositories"`
 }
 
+// catalogDetailAPIResponse is the response of a catalog listing requested
+// with detail=true.
+type catalogDetailAPIResponse struct {
+	Repositories []repositoryDetailJSON `json:"repositories"`
+}
+
+type repositoryDetailJSON struct {
+	Name          string   `json:"name"`
+	ArtifactTypes []string `json:"artifactTypes"`
+}
+
 func (ch *catalogHandler) GetCatalog(w http.ResponseWriter, r *http.Request) {
 	moreEntries := true
 
 	q := r.URL.Query()
 	lastEntry := q.Get("last")
 
+	detail := false
+	if v := q.Get("detail"); v != "" {
+		var err error
+		if detail, err = strconv.ParseBool(v); err != nil {
+			ch.Errors = append(ch.Errors, errcode.ErrorCodeCatalogDetailInvalid.WithDetail(map[string]string{"detail": v}))
+			return
+		}
+	}
+
 	entries := defaultReturnedEntries
 	maximumConfiguredEntries := ch.App.Config.Catalog.MaxEntries
 
@@ -84,12 +107,24 @@ func (ch *catalogHandler) GetCatalog(w http.ResponseWriter, r *http.Request) {
 		filled = returnedRepositories
 	}
 
+	var resp any = catalogAPIResponse{
+		Repositories: repos[0:filled],
+	}
+	if detail {
+		details, err := ch.repositoryDetails(repos[0:filled])
+		if err != nil {
+			ch.Errors = append(ch.Errors, err)
+			return
+		}
+		resp = catalogDetailAPIResponse{Repositories: details}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 
 	// Add a link header if there are more entries to retrieve
 	if moreEntries {
 		lastEntry = repos[filled-1]
-		urlStr, err := createLinkEntry(r.URL.String(), entries, lastEntry)
+		urlStr, err := createLinkEntry(r.URL.String(), entries, lastEntry, "detail")
 		if err != nil {
 			ch.Errors = append(ch.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
 			return
@@ -98,17 +133,46 @@ func (ch *catalogHandler) GetCatalog(w http.ResponseWriter, r *http.Request) {
 	}
 
 	enc := json.NewEncoder(w)
-	if err := enc.Encode(catalogAPIResponse{
-		Repositories: repos[0:filled],
-	}); err != nil {
+	if err := enc.Encode(resp); err != nil {
 		ch.Errors = append(ch.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
 		return
 	}
 }
 
+// repositoryDetails returns the artifact types of the named repositories.
+func (ch *catalogHandler) repositoryDetails(names []string) ([]repositoryDetailJSON, error) {
+	details := make([]repositoryDetailJSON, 0, len(names))
+	for _, name := range names {
+		named, err := reference.WithName(name)
+		if err != nil {
+			return nil, errcode.ErrorCodeUnknown.WithDetail(err)
+		}
+		repository, err := ch.App.registry.Repository(ch, named)
+		if err != nil {
+			return nil, errcode.ErrorCodeUnknown.WithDetail(err)
+		}
+		lister, ok := repository.(distribution.ArtifactTypeLister)
+		if !ok {
+			return nil, errcode.ErrorCodeUnsupported.WithDetail("catalog detail is not supported")
+		}
+		artifactTypes, err := lister.ArtifactTypes(ch)
+		if errors.Is(err, distribution.ErrUnsupported) {
+			return nil, errcode.ErrorCodeUnsupported.WithDetail("catalog detail is not enabled")
+		}
+		if err != nil {
+			return nil, errcode.ErrorCodeUnknown.WithDetail(err)
+		}
+		details = append(details, repositoryDetailJSON{
+			Name:          name,
+			ArtifactTypes: append(make([]string, 0, len(artifactTypes)), artifactTypes...),
+		})
+	}
+	return details, nil
+}
+
 // Use the original URL from the request to create a new URL for
-// the link header
-func createLinkEntry(origURL string, maxEntries int, lastEntry string) (string, error) {
+// the link header, keeping the given parameters of the original URL
+func createLinkEntry(origURL string, maxEntries int, lastEntry string, keep ...string) (string, error) {
 	calledURL, err := url.Parse(origURL)
 	if err != nil {
 		return "", err
@@ -117,6 +181,12 @@ func createLinkEntry(origURL string, maxEntries int, lastEntry string) (string,
 	v := url.Values{}
 	v.Add("n", strconv.Itoa(maxEntries))
 	v.Add("last", lastEntry)
+	q := calledURL.Query()
+	for _, key := range keep {
+		if q.Has(key) {
+			v[key] = q[key]
+		}
+	}
 
 	calledURL.RawQuery = v.Encode()
 
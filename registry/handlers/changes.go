@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/notifications"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	"github.com/distribution/distribution/v3/registry/changefeed"
+	"github.com/distribution/reference"
+	"github.com/gorilla/handlers"
+	"github.com/opencontainers/go-digest"
+)
+
+// maxReturnedChanges bounds the number of changes returned by a change feed
+// request.
+const maxReturnedChanges = 1000
+
+// configureChanges starts logging the changes of repositories and tags, in
+// redis if configured and through the storage driver otherwise, and serves
+// the change feed endpoint.
+func (app *App) configureChanges(configuration *configuration.Configuration) {
+	config := configuration.Changes
+	if !config.Enabled {
+		return
+	}
+	if config.MaxChanges < 0 || config.MaxAge < 0 {
+		panic("changes: maxchanges and maxage must not be negative")
+	}
+
+	retention := changefeed.Retention{MaxChanges: config.MaxChanges, MaxAge: config.MaxAge}
+	if app.redis != nil {
+		app.changes = changefeed.NewRedisStore(app.redis, retention)
+		dcontext.GetLogger(app).Info("logging changes in redis")
+	} else {
+		store, err := changefeed.NewDriverStore(app, app.driver, retention)
+		if err != nil {
+			panic("unable to read the change log: " + err.Error())
+		}
+		app.changes = store
+		dcontext.GetLogger(app).Info("logging changes to storage")
+	}
+
+	app.register(v2.RouteNameChanges, changesDispatcher)
+}
+
+// changeListener logs the changes of a repository to the change feed once
+// they are dispatched to the listener it wraps.
+type changeListener struct {
+	notifications.Listener
+	ctx   context.Context
+	store changefeed.Store
+}
+
+func (cl *changeListener) ManifestPushed(repo reference.Named, sm distribution.Manifest, options ...distribution.ManifestServiceOption) error {
+	err := cl.Listener.ManifestPushed(repo, sm, options...)
+
+	var changes []changefeed.Change
+	created, addErr := cl.store.AddRepository(cl.ctx, repo.Name())
+	if created {
+		changes = append(changes, cl.change(changefeed.ActionRepositoryCreated, repo, "", ""))
+	}
+	var payloadErr error
+	for _, option := range options {
+		if opt, ok := option.(distribution.WithTagOption); ok {
+			var payload []byte
+			if _, payload, payloadErr = sm.Payload(); payloadErr == nil {
+				changes = append(changes, cl.change(changefeed.ActionTagUpdated, repo, opt.Tag, digest.FromBytes(payload)))
+			}
+			break
+		}
+	}
+	return errors.Join(err, addErr, payloadErr, cl.store.Append(cl.ctx, changes...))
+}
+
+func (cl *changeListener) ManifestDeleted(repo reference.Named, dgst digest.Digest) error {
+	err := cl.Listener.ManifestDeleted(repo, dgst)
+	return errors.Join(err, cl.store.Append(cl.ctx, cl.change(changefeed.ActionManifestDeleted, repo, "", dgst)))
+}
+
+func (cl *changeListener) TagDeleted(repo reference.Named, tag string) error {
+	err := cl.Listener.TagDeleted(repo, tag)
+	return errors.Join(err, cl.store.Append(cl.ctx, cl.change(changefeed.ActionTagDeleted, repo, tag, "")))
+}
+
+func (cl *changeListener) RepoDeleted(repo reference.Named) error {
+	err := cl.Listener.RepoDeleted(repo)
+	return errors.Join(err,
+		cl.store.RemoveRepository(cl.ctx, repo.Name()),
+		cl.store.Append(cl.ctx, cl.change(changefeed.ActionRepositoryDeleted, repo, "", "")))
+}
+
+func (cl *changeListener) change(action changefeed.Action, repo reference.Named, tag string, dgst digest.Digest) changefeed.Change {
+	return changefeed.Change{
+		Action:     action,
+		Repository: repo.Name(),
+		Tag:        tag,
+		Digest:     dgst,
+		Timestamp:  time.Now().UTC(),
+	}
+}
+
+// changesDispatcher constructs the change feed handler.
+func changesDispatcher(ctx *Context, r *http.Request) http.Handler {
+	changesHandler := &changesHandler{
+		Context: ctx,
+	}
+
+	return handlers.MethodHandler{
+		http.MethodGet: http.HandlerFunc(changesHandler.GetChanges),
+	}
+}
+
+// changesHandler serves the change feed.
+type changesHandler struct {
+	*Context
+}
+
+// changesAPIResponse is the response of the change feed.
+type changesAPIResponse struct {
+	Changes []changefeed.Change `json:"changes"`
+	Latest  uint64              `json:"latest"`
+}
+
+// GetChanges returns the changes following the since parameter.
+func (ch *changesHandler) GetChanges(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	var since uint64
+	if v := q.Get("since"); v != "" {
+		var err error
+		if since, err = strconv.ParseUint(v, 10, 64); err != nil {
+			ch.Errors = append(ch.Errors, errcode.ErrorCodeChangesSequenceInvalid.WithDetail(map[string]string{"since": v}))
+			return
+		}
+	}
+
+	entries := defaultReturnedEntries
+	if n := q.Get("n"); n != "" {
+		parsed, err := strconv.Atoi(n)
+		if err != nil || parsed < 0 || parsed > maxReturnedChanges {
+			ch.Errors = append(ch.Errors, errcode.ErrorCodePaginationNumberInvalid.WithDetail(map[string]string{"n": n}))
+			return
+		}
+		entries = parsed
+	}
+
+	changes, latest, err := ch.changes.Since(ch, since, entries)
+	if err != nil {
+		if errors.Is(err, changefeed.ErrCompacted) {
+			ch.Errors = append(ch.Errors, errcode.ErrorCodeChangesCompacted.WithDetail(map[string]uint64{"since": since}))
+			return
+		}
+		ch.Errors = append(ch.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+
+	if changes == nil {
+		changes = []changefeed.Change{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(changesAPIResponse{Changes: changes, Latest: latest}); err != nil {
+		ch.Errors = append(ch.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+}
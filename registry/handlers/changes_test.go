@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/distribution/distribution/v3/registry/changefeed"
+	"github.com/distribution/reference"
+)
+
+// getChanges fetches the change feed with the query values.
+func getChanges(t *testing.T, env *testEnv, values url.Values) *http.Response {
+	t.Helper()
+
+	u, err := env.builder.BuildChangesURL(values)
+	checkErr(t, err, "building changes url")
+	resp, err := http.Get(u)
+	checkErr(t, err, "fetching changes")
+	return resp
+}
+
+// checkChanges checks the changes following since are the expected ones, in
+// order, and the latest sequence number.
+func checkChanges(t *testing.T, env *testEnv, since string, latest uint64, expected ...changefeed.Change) {
+	t.Helper()
+
+	resp := getChanges(t, env, url.Values{"since": []string{since}})
+	defer resp.Body.Close()
+	checkResponse(t, "fetching changes", resp, http.StatusOK)
+
+	var body changesAPIResponse
+	checkErr(t, json.NewDecoder(resp.Body).Decode(&body), "decoding changes")
+	if body.Latest != latest {
+		t.Errorf("unexpected latest sequence: %d != %d", body.Latest, latest)
+	}
+	if len(body.Changes) != len(expected) {
+		t.Fatalf("unexpected changes since %s: %+v", since, body.Changes)
+	}
+	for i, change := range body.Changes {
+		e := expected[i]
+		if change.Sequence != e.Sequence || change.Action != e.Action || change.Repository != e.Repository || change.Tag != e.Tag || change.Digest != e.Digest || change.Timestamp.IsZero() {
+			t.Errorf("unexpected change: %+v != %+v", change, e)
+		}
+	}
+}
+
+func TestChanges(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory":    configuration.Parameters{},
+			"delete":      configuration.Parameters{"enabled": true},
+			"maintenance": configuration.Parameters{"uploadpurging": map[any]any{"enabled": false}},
+		},
+		Changes: configuration.Changes{Enabled: true, MaxChanges: 5},
+	}
+	config.HTTP.Headers = headerConfig
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	checkChanges(t, env, "0", 0)
+
+	foo, _ := reference.WithName("foo/changes")
+	bar, _ := reference.WithName("bar/changes")
+	fooDigest := pushPlatformImage(t, env, foo, "latest", "amd64")
+	barDigest := pushPlatformImage(t, env, bar, "latest", "amd64")
+
+	tagRef, _ := reference.WithTag(foo, "latest")
+	manifestURL, err := env.builder.BuildManifestURL(tagRef)
+	checkErr(t, err, "building manifest url")
+	resp, err := httpDelete(manifestURL)
+	checkErr(t, err, "deleting tag")
+	resp.Body.Close()
+	checkResponse(t, "deleting tag", resp, http.StatusAccepted)
+
+	checkChanges(t, env, "0", 5,
+		changefeed.Change{Sequence: 1, Action: changefeed.ActionRepositoryCreated, Repository: foo.Name()},
+		changefeed.Change{Sequence: 2, Action: changefeed.ActionTagUpdated, Repository: foo.Name(), Tag: "latest", Digest: fooDigest},
+		changefeed.Change{Sequence: 3, Action: changefeed.ActionRepositoryCreated, Repository: bar.Name()},
+		changefeed.Change{Sequence: 4, Action: changefeed.ActionTagUpdated, Repository: bar.Name(), Tag: "latest", Digest: barDigest},
+		changefeed.Change{Sequence: 5, Action: changefeed.ActionTagDeleted, Repository: foo.Name(), Tag: "latest"},
+	)
+
+	// pushing to an existing repository does not create it again
+	fooDigest = pushPlatformImage(t, env, foo, "stable", "arm64")
+
+	digestRef, _ := reference.WithDigest(bar, barDigest)
+	manifestURL, err = env.builder.BuildManifestURL(digestRef)
+	checkErr(t, err, "building manifest url")
+	resp, err = httpDelete(manifestURL)
+	checkErr(t, err, "deleting manifest")
+	resp.Body.Close()
+	checkResponse(t, "deleting manifest", resp, http.StatusAccepted)
+
+	checkChanges(t, env, "4", 8,
+		changefeed.Change{Sequence: 5, Action: changefeed.ActionTagDeleted, Repository: foo.Name(), Tag: "latest"},
+		changefeed.Change{Sequence: 6, Action: changefeed.ActionTagUpdated, Repository: foo.Name(), Tag: "stable", Digest: fooDigest},
+		changefeed.Change{Sequence: 7, Action: changefeed.ActionManifestDeleted, Repository: bar.Name(), Digest: barDigest},
+		changefeed.Change{Sequence: 8, Action: changefeed.ActionTagDeleted, Repository: bar.Name(), Tag: "latest"},
+	)
+	checkChanges(t, env, "8", 8)
+
+	resp = getChanges(t, env, url.Values{"since": []string{"4"}, "n": []string{"1"}})
+	var body changesAPIResponse
+	checkErr(t, json.NewDecoder(resp.Body).Decode(&body), "decoding changes")
+	resp.Body.Close()
+	if len(body.Changes) != 1 || body.Changes[0].Sequence != 5 || body.Latest != 8 {
+		t.Fatalf("unexpected limited changes: %+v", body)
+	}
+
+	// the first three changes were compacted away
+	resp = getChanges(t, env, url.Values{"since": []string{"2"}})
+	if resp.StatusCode != http.StatusGone {
+		t.Fatalf("unexpected status fetching compacted changes: %d", resp.StatusCode)
+	}
+	checkBodyHasErrorCodes(t, "fetching compacted changes", resp, errcode.ErrorCodeChangesCompacted)
+	resp.Body.Close()
+
+	for _, tc := range []struct {
+		values url.Values
+		code   errcode.ErrorCode
+	}{
+		{url.Values{"since": []string{"-1"}}, errcode.ErrorCodeChangesSequenceInvalid},
+		{url.Values{"since": []string{"latest"}}, errcode.ErrorCodeChangesSequenceInvalid},
+		{url.Values{"n": []string{"-1"}}, errcode.ErrorCodePaginationNumberInvalid},
+		{url.Values{"n": []string{"100000"}}, errcode.ErrorCodePaginationNumberInvalid},
+	} {
+		resp := getChanges(t, env, tc.values)
+		checkBodyHasErrorCodes(t, "fetching invalid changes", resp, tc.code)
+		resp.Body.Close()
+	}
+}
+
+func TestChangesDisabled(t *testing.T) {
+	env := newTestEnv(t, false)
+	defer env.Shutdown()
+
+	resp := getChanges(t, env, nil)
+	defer resp.Body.Close()
+	checkResponse(t, "fetching disabled changes", resp, http.StatusNotFound)
+}
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"net/http"
+	"strings"
+)
+
+// defaultMaxChecksumChunkSize is the default size of the largest chunk
+// carrying checksums.
+const defaultMaxChecksumChunkSize = 64 << 20
+
+const (
+	// contentMD5Header carries the base64 encoded MD5 digest of a chunk, as
+	// defined by RFC 1864.
+	contentMD5Header = "Content-MD5"
+
+	// contentDigestHeader carries digests of a chunk, as defined by RFC
+	// 9530, such as "sha-256=:<base64>:".
+	contentDigestHeader = "Content-Digest"
+)
+
+// contentDigestAlgorithms are the algorithms of the Content-Digest header
+// verified. Digests of other algorithms are ignored.
+var contentDigestAlgorithms = map[string]func() hash.Hash{
+	"sha-256": sha256.New,
+	"sha-512": sha512.New,
+}
+
+// chunkChecksum is a checksum a chunk of an upload must match.
+type chunkChecksum struct {
+	// name describes the checksum in errors.
+	name     string
+	newHash  func() hash.Hash
+	expected []byte
+}
+
+// parseChunkChecksums returns the checksums of the chunk of request headers
+// h, if any.
+func parseChunkChecksums(h http.Header) ([]chunkChecksum, error) {
+	var checksums []chunkChecksum
+	if v := h.Get(contentMD5Header); v != "" {
+		expected, err := base64.StdEncoding.DecodeString(v)
+		if err != nil || len(expected) != md5.Size {
+			return nil, fmt.Errorf("invalid %s header %q", contentMD5Header, v)
+		}
+		checksums = append(checksums, chunkChecksum{name: contentMD5Header, newHash: md5.New, expected: expected})
+	}
+
+	for _, v := range h.Values(contentDigestHeader) {
+		for member := range strings.SplitSeq(v, ",") {
+			algorithm, value, ok := strings.Cut(strings.TrimSpace(member), "=")
+			if !ok || len(value) < 2 || value[0] != ':' || value[len(value)-1] != ':' {
+				return nil, fmt.Errorf("invalid %s header %q", contentDigestHeader, v)
+			}
+			algorithm = strings.ToLower(algorithm)
+			newHash, ok := contentDigestAlgorithms[algorithm]
+			if !ok {
+				continue
+			}
+			expected, err := base64.StdEncoding.DecodeString(value[1 : len(value)-1])
+			if err != nil || len(expected) != newHash().Size() {
+				return nil, fmt.Errorf("invalid %s digest %q", algorithm, value)
+			}
+			checksums = append(checksums, chunkChecksum{name: contentDigestHeader + " " + algorithm, newHash: newHash, expected: expected})
+		}
+	}
+	return checksums, nil
+}
+
+// chunkVerifier hashes a chunk with the algorithms of its checksums as it
+// is written.
+type chunkVerifier struct {
+	checksums []chunkChecksum
+	hashes    []hash.Hash
+}
+
+func newChunkVerifier(checksums []chunkChecksum) *chunkVerifier {
+	v := &chunkVerifier{checksums: checksums}
+	for _, checksum := range checksums {
+		v.hashes = append(v.hashes, checksum.newHash())
+	}
+	return v
+}
+
+func (v *chunkVerifier) Write(p []byte) (int, error) {
+	for _, h := range v.hashes {
+		h.Write(p)
+	}
+	return len(p), nil
+}
+
+// verify checks the chunk written matches every checksum.
+func (v *chunkVerifier) verify() error {
+	for i, checksum := range v.checksums {
+		if !bytes.Equal(v.hashes[i].Sum(nil), checksum.expected) {
+			return fmt.Errorf("chunk does not match its %s checksum", checksum.name)
+		}
+	}
+	return nil
+}
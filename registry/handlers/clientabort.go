@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	prometheus "github.com/distribution/distribution/v3/metrics"
+	"github.com/docker/go-metrics"
+	"github.com/gorilla/mux"
+)
+
+// statusClientClosedRequest is the non-standard "499 Client Closed Request"
+// status, recorded for the requests aborted by their client.
+const statusClientClosedRequest = 499
+
+// clientAborts counts the requests aborted by their client, which are not
+// failures of the registry.
+var clientAborts = prometheus.ClientNamespace.NewLabeledCounter("aborts", "The number of requests aborted by their client", "route")
+
+func init() {
+	metrics.Register(prometheus.ClientNamespace)
+}
+
+// abortRecorder records the first error writing the response, which tells
+// that the client went away while a response was streamed to it.
+type abortRecorder struct {
+	http.ResponseWriter
+
+	mu  sync.Mutex
+	err error
+}
+
+func (w *abortRecorder) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	if err != nil {
+		w.mu.Lock()
+		if w.err == nil {
+			w.err = err
+		}
+		w.mu.Unlock()
+	}
+	return n, err
+}
+
+func (w *abortRecorder) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap returns the underlying response writer, for http.ResponseController.
+func (w *abortRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// writeErr returns the first error writing the response, if any.
+func (w *abortRecorder) writeErr() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+// clientAbortCause returns why the client of the request served by ctx
+// aborted it, or nil if it did not: either the connection failed while the
+// response was written, or the request was canceled, which happens when the
+// client disconnects. Requests running out of time budget are not canceled
+// but exceed their deadline, and are failures of the registry.
+func clientAbortCause(ctx *Context, r *http.Request, w *abortRecorder) error {
+	if err := w.writeErr(); err != nil {
+		return err
+	}
+	if errors.Is(r.Context().Err(), context.Canceled) {
+		return r.Context().Err()
+	}
+	for _, err := range ctx.Errors {
+		if errors.Is(storageErrorOf(err), errClientDisconnected) {
+			return errClientDisconnected
+		}
+	}
+	return nil
+}
+
+// clientAborted reports whether the client of the request served by ctx
+// aborted it. In that case, the abort is logged at info level and counted
+// apart, the errors it caused are dropped, and the status of the response,
+// unless it was already sent, is 499 Client Closed Request rather than a
+// server error.
+func clientAborted(ctx *Context, w http.ResponseWriter, r *http.Request, recorder *abortRecorder) bool {
+	cause := clientAbortCause(ctx, r, recorder)
+	if cause == nil {
+		return false
+	}
+
+	route := "unknown"
+	if current := mux.CurrentRoute(r); current != nil {
+		route = current.GetName()
+	}
+	clientAborts.WithValues(route).Inc(1)
+
+	if status, ok := ctx.Value("http.response.status").(int); !ok || status == 0 {
+		w.WriteHeader(statusClientClosedRequest)
+	}
+	dcontext.GetResponseLogger(ctx).Infof("request aborted by the client: %v", cause)
+	ctx.Errors = nil
+	return true
+}
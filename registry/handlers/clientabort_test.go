@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/base"
+	"github.com/distribution/distribution/v3/registry/storage/driver/factory"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	promclient "github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	hookstest "github.com/sirupsen/logrus/hooks/test"
+)
+
+// abortingDriverFactory implements the factory.StorageDriverFactory
+// interface.
+type abortingDriverFactory struct{}
+
+func (factory *abortingDriverFactory) Create(ctx context.Context, parameters map[string]any) (storagedriver.StorageDriver, error) {
+	return &base.Base{StorageDriver: &abortingDriver{
+		StorageDriver: inmemory.New(),
+		mode:          parameters["mode"].(string),
+	}}, nil
+}
+
+// abortingDriver serves the blobs pulled according to mode: "block" blocks
+// until the request is canceled, "fail" fails, while "partial" and
+// "partialfail" serve the beginning of their content before blocking or
+// failing.
+type abortingDriver struct {
+	storagedriver.StorageDriver
+	mode string
+}
+
+// pulling reports whether path is the content of a blob being pulled.
+func pulling(ctx context.Context, path string) bool {
+	return strings.Contains(path, "/v2/blobs/") && dcontext.GetStringValue(ctx, "http.request.method") == http.MethodGet
+}
+
+func (d *abortingDriver) Stat(ctx context.Context, path string) (storagedriver.FileInfo, error) {
+	if pulling(ctx, path) {
+		switch d.mode {
+		case "block":
+			<-ctx.Done()
+			return nil, ctx.Err()
+		case "fail":
+			return nil, errors.New("backend failure")
+		}
+	}
+	return d.StorageDriver.Stat(ctx, path)
+}
+
+func (d *abortingDriver) Reader(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
+	if !pulling(ctx, path) || (d.mode != "partial" && d.mode != "partialfail") {
+		return d.StorageDriver.Reader(ctx, path, offset)
+	}
+
+	rc, err := d.StorageDriver.Reader(ctx, path, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	// enough content for the response headers to be sent
+	head := make([]byte, 64<<10)
+	if _, err := io.ReadFull(rc, head); err != nil {
+		return nil, err
+	}
+	var tail io.Reader = blockingReader{ctx}
+	if d.mode == "partialfail" {
+		tail = iotest.ErrReader(errors.New("backend failure"))
+	}
+	return io.NopCloser(io.MultiReader(bytes.NewReader(head), tail)), nil
+}
+
+// blockingReader blocks until its context is done.
+type blockingReader struct {
+	ctx context.Context
+}
+
+func (r blockingReader) Read(p []byte) (int, error) {
+	<-r.ctx.Done()
+	return 0, r.ctx.Err()
+}
+
+// clientAbortCount returns the number of requests to route aborted by their
+// client.
+func clientAbortCount(t *testing.T, route string) float64 {
+	t.Helper()
+	families, err := promclient.DefaultGatherer.Gather()
+	checkErr(t, err, "gathering metrics")
+	for _, family := range families {
+		if family.GetName() != "registry_client_aborts_total" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "route" && label.GetValue() == route {
+					return metric.GetCounter().GetValue()
+				}
+			}
+		}
+	}
+	return 0
+}
+
+// waitForLogEntry waits for the entry logged with message.
+func waitForLogEntry(t *testing.T, hook *hookstest.Hook, message string) *logrus.Entry {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		for _, entry := range hook.AllEntries() {
+			if strings.HasPrefix(entry.Message, message) {
+				return entry
+			}
+		}
+	}
+	t.Fatalf("no log entry %q", message)
+	return nil
+}
+
+func TestClientAborts(t *testing.T) {
+	factory.Register("abortingstorage", &abortingDriverFactory{})
+
+	content := make([]byte, 128<<10)
+	if _, err := rand.Read(content); err != nil {
+		t.Fatal(err)
+	}
+	dgst := digest.FromBytes(content)
+	name, _ := reference.WithName("foo/bar")
+
+	for _, tc := range []struct {
+		name    string
+		mode    string
+		aborted bool
+		status  int
+		message string
+		level   logrus.Level
+	}{
+		{
+			name:    "canceled before the response",
+			mode:    "block",
+			aborted: true,
+			status:  statusClientClosedRequest,
+			message: "request aborted by the client",
+			level:   logrus.InfoLevel,
+		},
+		{
+			name:    "canceled during the response",
+			mode:    "partial",
+			aborted: true,
+			status:  http.StatusOK,
+			message: "request aborted by the client",
+			level:   logrus.InfoLevel,
+		},
+		{
+			name:    "backend failure",
+			mode:    "fail",
+			status:  http.StatusInternalServerError,
+			message: "response completed with error",
+			level:   logrus.ErrorLevel,
+		},
+		{
+			name:    "backend failure during the response",
+			mode:    "partialfail",
+			message: "error reading blob",
+			level:   logrus.ErrorLevel,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			config := configuration.Configuration{
+				Storage: configuration.Storage{
+					"abortingstorage": configuration.Parameters{"mode": tc.mode},
+					"maintenance":     configuration.Parameters{"uploadpurging": map[any]any{"enabled": false}},
+				},
+			}
+			config.HTTP.Headers = headerConfig
+			env := newTestEnvWithConfig(t, &config)
+			defer env.Shutdown()
+
+			uploadURLBase, _ := startPushLayer(t, env, name)
+			pushLayer(t, env.builder, name, dgst, uploadURLBase, bytes.NewReader(content))
+			ref, _ := reference.WithDigest(name, dgst)
+			blobURL, err := env.builder.BuildBlobURL(ref)
+			checkErr(t, err, "building blob url")
+
+			hook := hookstest.NewGlobal()
+			defer hook.Reset()
+			aborts := clientAbortCount(t, "blob")
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, blobURL, nil)
+			checkErr(t, err, "building blob request")
+			if tc.aborted {
+				time.AfterFunc(200*time.Millisecond, cancel)
+			}
+			if resp, err := http.DefaultClient.Do(req); err == nil {
+				_, _ = io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+			}
+
+			entry := waitForLogEntry(t, hook, tc.message)
+			if entry.Level != tc.level {
+				t.Fatalf("expected %q to be logged at %v, got %v", tc.message, tc.level, entry.Level)
+			}
+			if status := entry.Data["http.response.status"]; tc.status != 0 && status != tc.status {
+				t.Fatalf("expected status %d to be logged, got %v", tc.status, status)
+			}
+			if aborted := clientAbortCount(t, "blob") - aborts; (aborted == 1) != tc.aborted {
+				t.Fatalf("unexpected number of aborts counted: %v", aborted)
+			}
+			for _, entry := range hook.AllEntries() {
+				if tc.aborted && entry.Level <= logrus.ErrorLevel {
+					t.Fatalf("client abort logged at %v: %s", entry.Level, entry.Message)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"context"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	prometheus "github.com/distribution/distribution/v3/metrics"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/docker/go-metrics"
+)
+
+const (
+	transferUpload   = "upload"
+	transferDownload = "download"
+
+	// defaultTransferRetryAfter is advertised to clients rejected by a
+	// transfer limiter when no retry delay is configured.
+	defaultTransferRetryAfter = time.Second
+)
+
+var (
+	// transfersInFlight is the number of blob transfers currently being served
+	transfersInFlight = prometheus.HTTPNamespace.NewLabeledGauge("blob_transfers_in_flight", "The number of blob transfers currently being served", metrics.Total, "type")
+	// transferRejections is the number of blob transfers rejected by concurrency limits
+	transferRejections = prometheus.HTTPNamespace.NewLabeledCounter("blob_transfer_rejections", "The number of blob transfers rejected by concurrency limits", "type")
+)
+
+func init() {
+	metrics.Register(prometheus.HTTPNamespace)
+}
+
+// transferLimiter bounds the number of blob transfers of a single kind served
+// at once, registry-wide and per client. A client slot is always acquired
+// before a registry-wide slot, so that a single client can never hold more
+// than its share of the registry-wide slots.
+type transferLimiter struct {
+	kind         string
+	global       chan struct{} // nil if there is no registry-wide limit
+	perClient    int
+	queueSize    int
+	queueTimeout time.Duration
+	retryAfter   time.Duration
+
+	mu      sync.Mutex
+	queued  int
+	clients map[string]*clientSlots
+}
+
+// clientSlots tracks the transfer slots of a single client. refs counts the
+// requests holding or waiting on the semaphore so that it can be dropped once
+// the client goes idle.
+type clientSlots struct {
+	sem  chan struct{}
+	refs int
+}
+
+// newTransferLimiter returns a limiter of the given kind, or nil if the
+// configuration does not limit transfers of that kind.
+func newTransferLimiter(kind string, global int, config configuration.Concurrency) *transferLimiter {
+	if global <= 0 && config.PerClient <= 0 {
+		return nil
+	}
+
+	l := &transferLimiter{
+		kind:         kind,
+		perClient:    config.PerClient,
+		queueSize:    config.QueueSize,
+		queueTimeout: config.QueueTimeout,
+		retryAfter:   config.RetryAfter,
+		clients:      make(map[string]*clientSlots),
+	}
+	if global > 0 {
+		l.global = make(chan struct{}, global)
+	}
+	if l.retryAfter <= 0 {
+		l.retryAfter = defaultTransferRetryAfter
+	}
+	return l
+}
+
+// acquire obtains a transfer slot for client, waiting in the queue if one is
+// not immediately available. The returned function releases the slot. If no
+// slot could be obtained, false is returned.
+func (l *transferLimiter) acquire(ctx context.Context, client string) (func(), bool) {
+	var clientSem chan struct{}
+	if l.perClient > 0 {
+		clientSem = l.clientSemaphore(client)
+	}
+
+	var (
+		queued  bool
+		timeout <-chan time.Time
+	)
+	wait := func() bool {
+		if !queued {
+			l.mu.Lock()
+			if l.queued >= l.queueSize || l.queueTimeout <= 0 {
+				l.mu.Unlock()
+				return false
+			}
+			l.queued++
+			l.mu.Unlock()
+			queued = true
+			timeout = time.After(l.queueTimeout)
+		}
+		return true
+	}
+	take := func(sem chan struct{}) bool {
+		if sem == nil {
+			return true
+		}
+		select {
+		case sem <- struct{}{}:
+			return true
+		default:
+		}
+		if !wait() {
+			return false
+		}
+		select {
+		case sem <- struct{}{}:
+			return true
+		case <-timeout:
+		case <-ctx.Done():
+		}
+		return false
+	}
+
+	ok := take(clientSem)
+	if ok && !take(l.global) {
+		if clientSem != nil {
+			<-clientSem
+		}
+		ok = false
+	}
+
+	if queued {
+		l.mu.Lock()
+		l.queued--
+		l.mu.Unlock()
+	}
+
+	if !ok {
+		l.releaseClient(client)
+		transferRejections.WithValues(l.kind).Inc(1)
+		return nil, false
+	}
+
+	transfersInFlight.WithValues(l.kind).Inc(1)
+	return func() {
+		transfersInFlight.WithValues(l.kind).Dec(1)
+		if l.global != nil {
+			<-l.global
+		}
+		if clientSem != nil {
+			<-clientSem
+		}
+		l.releaseClient(client)
+	}, true
+}
+
+// clientSemaphore returns the semaphore for client, creating it if required.
+func (l *transferLimiter) clientSemaphore(client string) chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	slots, ok := l.clients[client]
+	if !ok {
+		slots = &clientSlots{sem: make(chan struct{}, l.perClient)}
+		l.clients[client] = slots
+	}
+	slots.refs++
+	return slots.sem
+}
+
+// releaseClient drops a reference to the semaphore of client.
+func (l *transferLimiter) releaseClient(client string) {
+	if l.perClient <= 0 {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if slots, ok := l.clients[client]; ok {
+		slots.refs--
+		if slots.refs <= 0 {
+			delete(l.clients, client)
+		}
+	}
+}
+
+// limit wraps handler so that it is only served once a transfer slot has
+// been acquired. Requests which cannot acquire a slot are rejected with 429
+// Too Many Requests. A nil limiter returns handler unchanged.
+func (l *transferLimiter) limit(ctx *Context, handler http.Handler) http.Handler {
+	if l == nil {
+		return handler
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client := transferClient(ctx, r)
+		release, ok := l.acquire(ctx, client)
+		if !ok {
+			dcontext.GetLogger(ctx).Warnf("rejecting blob %s for client %q: concurrency limit reached", l.kind, client)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(l.retryAfter.Seconds()))))
+			ctx.Errors = append(ctx.Errors, errcode.ErrorCodeTooManyRequests.WithDetail("blob "+l.kind+" concurrency limit reached"))
+			return
+		}
+		defer release()
+
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// transferClient identifies the client of a request for the purpose of
+// concurrency limits. Authenticated requests are identified by user name,
+// anonymous ones by remote IP address.
+func transferClient(ctx context.Context, r *http.Request) string {
+	if username := getUserName(ctx, r); username != "" {
+		return "user:" + username
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestTransferLimiterGlobal(t *testing.T) {
+	l := newTransferLimiter(transferDownload, 3, configuration.Concurrency{})
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		releases []func()
+		rejected int
+	)
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release, ok := l.acquire(context.Background(), "ip:127.0.0.1")
+			mu.Lock()
+			defer mu.Unlock()
+			if !ok {
+				rejected++
+				return
+			}
+			releases = append(releases, release)
+		}()
+	}
+	wg.Wait()
+
+	if len(releases) != 3 || rejected != 7 {
+		t.Fatalf("expected 3 acquired and 7 rejected transfers, got %d and %d", len(releases), rejected)
+	}
+
+	for _, release := range releases {
+		release()
+	}
+	release, ok := l.acquire(context.Background(), "ip:127.0.0.1")
+	if !ok {
+		t.Fatal("expected transfer slot to be available after release")
+	}
+	release()
+
+	if len(l.clients) != 0 {
+		t.Fatalf("expected no tracked clients, got %d", len(l.clients))
+	}
+}
+
+func TestTransferLimiterPerClientFairness(t *testing.T) {
+	l := newTransferLimiter(transferUpload, 4, configuration.Concurrency{PerClient: 2})
+
+	for i := range 2 {
+		if _, ok := l.acquire(context.Background(), "user:alice"); !ok {
+			t.Fatalf("expected alice to acquire transfer %d", i)
+		}
+	}
+	if _, ok := l.acquire(context.Background(), "user:alice"); ok {
+		t.Fatal("expected alice to be limited to 2 transfers")
+	}
+
+	// alice saturating her share must not starve bob
+	for i := range 2 {
+		if _, ok := l.acquire(context.Background(), "user:bob"); !ok {
+			t.Fatalf("expected bob to acquire transfer %d", i)
+		}
+	}
+	if _, ok := l.acquire(context.Background(), "user:carol"); ok {
+		t.Fatal("expected registry-wide limit to be reached")
+	}
+}
+
+func TestTransferLimiterQueue(t *testing.T) {
+	l := newTransferLimiter(transferDownload, 1, configuration.Concurrency{
+		QueueSize:    1,
+		QueueTimeout: 5 * time.Second,
+	})
+
+	release, ok := l.acquire(context.Background(), "ip:127.0.0.1")
+	if !ok {
+		t.Fatal("expected to acquire transfer")
+	}
+
+	acquired := make(chan bool)
+	go func() {
+		release, ok := l.acquire(context.Background(), "ip:127.0.0.2")
+		if ok {
+			release()
+		}
+		acquired <- ok
+	}()
+
+	// wait for the second request to queue, the queue is then full
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		l.mu.Lock()
+		queued := l.queued
+		l.mu.Unlock()
+		if queued == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for request to queue")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if _, ok := l.acquire(context.Background(), "ip:127.0.0.3"); ok {
+		t.Fatal("expected request to be rejected with a full queue")
+	}
+
+	release()
+	if !<-acquired {
+		t.Fatal("expected queued request to acquire transfer once released")
+	}
+
+	// queued requests give up after the timeout
+	l.queueTimeout = 10 * time.Millisecond
+	release, _ = l.acquire(context.Background(), "ip:127.0.0.1")
+	defer release()
+	if _, ok := l.acquire(context.Background(), "ip:127.0.0.2"); ok {
+		t.Fatal("expected queued request to time out")
+	}
+}
+
+func TestBlobDownloadConcurrencyLimit(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory":    configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[any]any{"enabled": false}},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	config.HTTP.Concurrency = configuration.Concurrency{
+		Downloads:  2,
+		PerClient:  1,
+		RetryAfter: 3 * time.Second,
+	}
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/bar")
+	contents := strings.NewReader("some layer contents")
+	dgst := digest.FromString("some layer contents")
+	uploadURLBase, _ := startPushLayer(t, env, imageName)
+	pushLayer(t, env.builder, imageName, dgst, uploadURLBase, contents)
+
+	ref, _ := reference.WithDigest(imageName, dgst)
+	blobURL, err := env.builder.BuildBlobURL(ref)
+	checkErr(t, err, "building blob url")
+
+	// another anonymous client holds the other registry-wide slot, the test
+	// client still gets its own share
+	release, ok := env.app.downloadLimiter.acquire(context.Background(), "ip:192.0.2.1")
+	if !ok {
+		t.Fatal("expected to acquire download slot")
+	}
+	resp, err := http.Get(blobURL)
+	checkErr(t, err, "fetching blob")
+	resp.Body.Close()
+	checkResponse(t, "fetching blob", resp, http.StatusOK)
+
+	// the test client has exhausted its own share
+	clientRelease, ok := env.app.downloadLimiter.acquire(context.Background(), "ip:127.0.0.1")
+	if !ok {
+		t.Fatal("expected to acquire download slot")
+	}
+	resp, err = http.Get(blobURL)
+	checkErr(t, err, "fetching blob")
+	defer resp.Body.Close()
+	checkResponse(t, "fetching blob over limit", resp, http.StatusTooManyRequests)
+	checkBodyHasErrorCodes(t, "fetching blob over limit", resp, errcode.ErrorCodeTooManyRequests)
+	checkHeaders(t, resp, http.Header{"Retry-After": []string{"3"}})
+
+	// HEAD requests do not stream content and are not limited
+	resp, err = http.Head(blobURL)
+	checkErr(t, err, "checking blob")
+	resp.Body.Close()
+	checkResponse(t, "checking blob", resp, http.StatusOK)
+
+	clientRelease()
+	release()
+	resp, err = http.Get(blobURL)
+	checkErr(t, err, "fetching blob")
+	resp.Body.Close()
+	checkResponse(t, "fetching blob after release", resp, http.StatusOK)
+}
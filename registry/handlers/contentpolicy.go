@@ -0,0 +1,20 @@
+package handlers
+
+import (
+	"context"
+
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/reference"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// existingContentMismatch reports an upload completed with the digest of an
+// existing blob whose stored content differs from the uploaded content,
+// which was discarded.
+func (app *App) existingContentMismatch(ctx context.Context, repo reference.Named, desc v1.Descriptor) {
+	dcontext.GetLoggerWithFields(ctx, map[any]any{
+		"digest":    desc.Digest,
+		"size":      desc.Size,
+		"vars.name": repo.Name(),
+	}).Error("CRITICAL: content uploaded for an existing blob differs from the stored content, which was kept")
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/distribution/distribution/v3/registry/api/errcode"
 	v2 "github.com/distribution/distribution/v3/registry/api/v2"
 	"github.com/distribution/distribution/v3/registry/auth"
+	"github.com/distribution/reference"
 	"github.com/opencontainers/go-digest"
 )
 
@@ -28,6 +29,10 @@ type Context struct {
 	// RepositoryRemover provides method to delete a repository
 	RepositoryRemover distribution.RepositoryRemover
 
+	// requestedName is the repository name requested by the client, when
+	// it was rewritten to the name of Repository. It is nil otherwise.
+	requestedName reference.Named
+
 	// Errors is a collection of errors encountered during the request to be
 	// returned to the client API. If errors are added to the collection, the
 	// handler *must not* start the response via http.ResponseWriter.
@@ -46,6 +51,16 @@ func (ctx *Context) Value(key any) any {
 	return ctx.Context.Value(key)
 }
 
+// clientName returns the name of the repository as requested by the
+// client, which differs from the name of Repository when it was rewritten.
+// URLs returned to the client must use it.
+func (ctx *Context) clientName() reference.Named {
+	if ctx.requestedName != nil {
+		return ctx.requestedName
+	}
+	return ctx.Repository.Named()
+}
+
 func getName(ctx context.Context) (name string) {
 	return dcontext.GetStringValue(ctx, "vars.name")
 }
@@ -160,3 +175,37 @@ func authorizedResources(ctx context.Context) []auth.Resource {
 
 	return nil
 }
+
+// withClaims returns a context with the claims of the credential of the
+// request.
+func withClaims(ctx context.Context, claims map[string]any) context.Context {
+	return claimsContext{
+		Context: ctx,
+		claims:  claims,
+	}
+}
+
+type claimsContext struct {
+	context.Context
+	claims map[string]any
+}
+
+type claimsKey struct{}
+
+func (cc claimsContext) Value(key any) any {
+	if key == (claimsKey{}) {
+		return cc.claims
+	}
+
+	return cc.Context.Value(key)
+}
+
+// authorizedClaims returns the claims of the credential of the request, or
+// nil if the request carries no claims.
+func authorizedClaims(ctx context.Context) map[string]any {
+	if claims, ok := ctx.Value(claimsKey{}).(map[string]any); ok {
+		return claims
+	}
+
+	return nil
+}
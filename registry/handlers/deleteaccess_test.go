@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/registry/auth/token"
+	"github.com/distribution/reference"
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/opencontainers/go-digest"
+)
+
+// newTokenTestEnv returns a test environment with deletes enabled and the
+// token access controller trusting key.
+func newTokenTestEnv(t *testing.T, key *ecdsa.PrivateKey, deleteAsPush bool) *testEnv {
+	config := tokenTestConfig(t, key)
+	if deleteAsPush {
+		config.Auth["deleteaspush"] = configuration.Parameters{"enabled": true}
+	}
+	return newTestEnvWithConfig(t, config)
+}
+
+// tokenTestConfig returns the configuration of a registry authenticating
+// clients with tokens signed by key.
+func tokenTestConfig(t *testing.T, key *ecdsa.PrivateKey) *configuration.Configuration {
+	jwks, err := json.Marshal(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Key: &key.PublicKey, KeyID: "test", Algorithm: string(jose.ES256)}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwksPath := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(jwksPath, jwks, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory":    configuration.Parameters{},
+			"delete":      configuration.Parameters{"enabled": true},
+			"maintenance": configuration.Parameters{"uploadpurging": map[any]any{"enabled": false}},
+		},
+		Auth: configuration.Auth{"token": {
+			"realm":   "https://auth.example.com/token",
+			"issuer":  "test-issuer",
+			"service": "test-service",
+			"jwks":    jwksPath,
+		}},
+	}
+	config.HTTP.Headers = headerConfig
+	return &config
+}
+
+// signToken returns a token signed by key, granting actions on the
+// repository name.
+func signToken(t *testing.T, key *ecdsa.PrivateKey, name string, actions ...string) string {
+	return signAccessToken(t, key, &token.ResourceActions{Type: "repository", Name: name, Actions: actions})
+}
+
+// signAccessToken returns a token signed by key, granting access.
+func signAccessToken(t *testing.T, key *ecdsa.PrivateKey, access ...*token.ResourceActions) string {
+	signer, err := jose.NewSigner(jose.SigningKey{
+		Algorithm: jose.ES256,
+		Key:       jose.JSONWebKey{Key: key, KeyID: "test"},
+	}, (&jose.SignerOptions{}).WithType("JWT"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	signed, err := jwt.Signed(signer).Claims(&token.ClaimSet{
+		Issuer:     "test-issuer",
+		Subject:    "alice",
+		Audience:   []string{"test-service"},
+		Expiration: now.Add(time.Hour).Unix(),
+		NotBefore:  now.Add(-time.Minute).Unix(),
+		IssuedAt:   now.Unix(),
+		Access:     access,
+	}).Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+// TestDeleteAccess validates that deletions require the delete action, or
+// the push action in compatibility mode.
+func TestDeleteAccess(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	name, _ := reference.WithName("foo/bar")
+	manifestRef, _ := reference.WithDigest(name, digest.FromString("manifest"))
+	blobRef, _ := reference.WithDigest(name, digest.FromString("blob"))
+	tagRef, _ := reference.WithTag(name, "latest")
+
+	for _, tc := range []struct {
+		name         string
+		deleteAsPush bool
+		actions      []string
+		allowed      bool
+	}{
+		{name: "strict push", actions: []string{"pull", "push"}},
+		{name: "strict delete", actions: []string{"delete"}, allowed: true},
+		{name: "compatibility push", deleteAsPush: true, actions: []string{"pull", "push"}, allowed: true},
+		{name: "compatibility delete", deleteAsPush: true, actions: []string{"delete"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			env := newTokenTestEnv(t, key, tc.deleteAsPush)
+			defer env.Shutdown()
+			bearer := "Bearer " + signToken(t, key, name.Name(), tc.actions...)
+
+			manifestURL, err := env.builder.BuildManifestURL(manifestRef)
+			checkErr(t, err, "building manifest url")
+			tagURL, err := env.builder.BuildManifestURL(tagRef)
+			checkErr(t, err, "building tag url")
+			blobURL, err := env.builder.BuildBlobURL(blobRef)
+			checkErr(t, err, "building blob url")
+
+			for _, u := range []string{manifestURL, tagURL, blobURL} {
+				req, err := http.NewRequest(http.MethodDelete, u, nil)
+				checkErr(t, err, "building delete request")
+				req.Header.Set("Authorization", bearer)
+				resp, err := http.DefaultClient.Do(req)
+				checkErr(t, err, "deleting")
+				resp.Body.Close()
+
+				// authorized deletions of unknown content are not found
+				expected := http.StatusUnauthorized
+				if tc.allowed {
+					expected = http.StatusNotFound
+				}
+				checkResponse(t, "deleting "+u, resp, expected)
+			}
+		})
+	}
+}
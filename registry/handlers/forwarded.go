@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+)
+
+// configureTrustedProxies parses the networks of the proxies trusted to set
+// forwarded headers.
+func (app *App) configureTrustedProxies(configuration *configuration.Configuration) {
+	for _, proxy := range configuration.HTTP.TrustedProxies {
+		prefix, err := parseTrustedProxy(proxy)
+		if err != nil {
+			panic(fmt.Sprintf("invalid http.trustedproxies entry %q: %v", proxy, err))
+		}
+		app.trustedProxies = append(app.trustedProxies, prefix)
+	}
+	if len(app.trustedProxies) > 0 {
+		dcontext.GetLogger(app).Infof("forwarded headers honored for %d trusted proxy networks", len(app.trustedProxies))
+	}
+}
+
+// parseTrustedProxy parses a CIDR network, or a single IP address.
+func parseTrustedProxy(proxy string) (netip.Prefix, error) {
+	if addr, err := netip.ParseAddr(proxy); err == nil {
+		return netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()), nil
+	}
+	prefix, err := netip.ParsePrefix(proxy)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return prefix.Masked(), nil
+}
+
+// trustsForwardedHeaders reports whether the forwarded headers of r may be
+// used to build urls, that is whether its direct peer is a trusted proxy.
+// Every peer is trusted if no trusted proxies are configured.
+func (app *App) trustsForwardedHeaders(r *http.Request) bool {
+	if len(app.trustedProxies) == 0 {
+		return true
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap().WithZone("")
+	for _, prefix := range app.trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestForwardedHeaders(t *testing.T) {
+	forwarded := []http.Header{
+		{
+			"X-Forwarded-Proto": []string{"https"},
+			"X-Forwarded-Host":  []string{"registry.example.com"},
+			"X-Forwarded-Port":  []string{"8443"},
+		},
+		{"Forwarded": []string{`proto=https;host="registry.example.com:8443"`}},
+	}
+
+	for _, tc := range []struct {
+		name           string
+		trustedProxies []string
+		trusted        bool
+	}{
+		{name: "no trusted proxies", trusted: true},
+		{name: "trusted proxy", trustedProxies: []string{"10.0.0.0/8", "127.0.0.0/8", "::1"}, trusted: true},
+		{name: "untrusted peer", trustedProxies: []string{"10.0.0.0/8"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			config := configuration.Configuration{
+				Storage: configuration.Storage{
+					"inmemory":    configuration.Parameters{},
+					"maintenance": configuration.Parameters{"uploadpurging": map[any]any{"enabled": false}},
+				},
+				Catalog: configuration.Catalog{MaxEntries: 100},
+			}
+			config.HTTP.Headers = headerConfig
+			config.HTTP.TrustedProxies = tc.trustedProxies
+			env := newTestEnvWithConfig(t, &config)
+			defer env.Shutdown()
+
+			base := env.server.URL
+			if tc.trusted {
+				base = "https://registry.example.com:8443"
+			}
+
+			createRepository(env, t, "foo/first", "latest")
+			createRepository(env, t, "foo/second", "latest")
+			name, _ := reference.WithName("foo/bar")
+			content := []byte("forwarded content")
+			dgst := digest.FromBytes(content)
+
+			for _, header := range forwarded {
+				do := func(method, urlStr string, body []byte) *http.Response {
+					t.Helper()
+					req, err := http.NewRequest(method, urlStr, bytes.NewReader(body))
+					checkErr(t, err, "creating request")
+					for k, v := range header {
+						req.Header[k] = v
+					}
+					resp, err := http.DefaultClient.Do(req)
+					checkErr(t, err, "sending request")
+					resp.Body.Close()
+					return resp
+				}
+
+				uploadURL, err := env.builder.BuildBlobUploadURL(name)
+				checkErr(t, err, "building upload url")
+				resp := do(http.MethodPost, uploadURL, nil)
+				checkResponse(t, "starting upload", resp, http.StatusAccepted)
+				location := resp.Header.Get("Location")
+				if !strings.HasPrefix(location, base+"/v2/foo/bar/blobs/uploads/") {
+					t.Fatalf("unexpected upload location for %v: %q", header, location)
+				}
+
+				u, err := url.Parse(location)
+				checkErr(t, err, "parsing upload location")
+				q := u.Query()
+				q.Set("digest", dgst.String())
+				resp = do(http.MethodPut, env.server.URL+u.Path+"?"+q.Encode(), content)
+				checkResponse(t, "completing upload", resp, http.StatusCreated)
+				if location, expected := resp.Header.Get("Location"), base+"/v2/foo/bar/blobs/"+dgst.String(); location != expected {
+					t.Fatalf("unexpected blob location for %v: %q != %q", header, location, expected)
+				}
+
+				catalogURL, err := env.builder.BuildCatalogURL(url.Values{"n": []string{"1"}})
+				checkErr(t, err, "building catalog url")
+				resp = do(http.MethodGet, catalogURL, nil)
+				checkResponse(t, "listing catalog", resp, http.StatusOK)
+				// Links are relative to the url of the request, whatever its host.
+				if link := resp.Header.Get("Link"); !strings.HasPrefix(link, "</v2/_catalog?") {
+					t.Fatalf("unexpected catalog link for %v: %q", header, link)
+				}
+			}
+		})
+	}
+}
@@ -208,3 +208,55 @@ func TestHTTPHealthCheck(t *testing.T) {
 		t.Fatal("expected 0 items in health check results")
 	}
 }
+
+func TestComponentHealthCheck(t *testing.T) {
+	threshold := 100 * time.Millisecond
+
+	config := &configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"maintenance": configuration.Parameters{
+				"uploadpurging": map[any]any{"enabled": false},
+				// the reconciler does not run before the threshold
+				"reconcile": map[any]any{"enabled": true, "interval": "1h"},
+			},
+		},
+		Health: configuration.Health{
+			Components: configuration.HealthComponents{
+				Enabled: true,
+				Thresholds: map[string]time.Duration{
+					"reconciler":    threshold,
+					"notifications": threshold,
+				},
+			},
+		},
+	}
+	config.Notifications.Endpoints = []configuration.Endpoint{
+		{Name: "idle", URL: "http://127.0.0.1:1/events"},
+	}
+
+	ctx := dcontext.Background()
+
+	app := NewApp(ctx, config)
+	healthRegistry := health.NewRegistry()
+	app.RegisterHealthChecks(healthRegistry)
+
+	if status := healthRegistry.CheckStatus(ctx); len(status) != 0 {
+		t.Fatalf("unexpected health check results: %v", status)
+	}
+
+	<-time.After(2 * threshold)
+
+	// the idle notification queue stays healthy
+	status := healthRegistry.CheckStatus(ctx)
+	if len(status) != 1 || !strings.HasPrefix(status["reconciler"], "no progress since") {
+		t.Fatalf("unexpected health check results: %v", status)
+	}
+
+	if err := app.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+	if status := healthRegistry.CheckStatus(ctx); len(status) != 0 {
+		t.Fatalf("unexpected health check results after shutdown: %v", status)
+	}
+}
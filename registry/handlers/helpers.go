@@ -13,6 +13,10 @@ import (
 	"github.com/distribution/distribution/v3/registry/api/errcode"
 )
 
+// errClientDisconnected is returned by copyFullPayload when the client
+// disconnected before sending the whole payload.
+var errClientDisconnected = errors.New("client disconnected")
+
 // closeResources closes all the provided resources after running the target
 // handler.
 func closeResources(handler http.Handler, closers ...io.Closer) http.Handler {
@@ -49,14 +53,14 @@ func copyFullPayload(ctx context.Context, responseWriter http.ResponseWriter, r
 			// Even though the connection has already been closed,
 			// this causes the logger to pick up a 499 error
 			// instead of showing 0 for the HTTP status.
-			responseWriter.WriteHeader(499)
+			responseWriter.WriteHeader(statusClientClosedRequest)
 
 			dcontext.GetLoggerWithFields(ctx, map[any]any{
 				"error":         err,
 				"copied":        copied,
 				"contentLength": r.ContentLength,
-			}, "error", "copied", "contentLength").Error("client disconnected during " + action)
-			return errors.New("client disconnected")
+			}, "error", "copied", "contentLength").Info("client disconnected during " + action)
+			return errClientDisconnected
 		default:
 		}
 	}
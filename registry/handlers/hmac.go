@@ -22,6 +22,10 @@ type blobUploadState struct {
 
 	// StartedAt is the original start time of the upload.
 	StartedAt time.Time
+
+	// Final is set once a chunk smaller than the minimum chunk size was
+	// appended, which must be the last chunk of the upload.
+	Final bool
 }
 
 type hmacKey string
@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/notifications"
+	"github.com/distribution/distribution/v3/testutil"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestCorrectLayerMediaTypes(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		pushed []digest.Digest
+	)
+	sink := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var envelope struct {
+			Events []notifications.Event `json:"events"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&envelope); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		for _, event := range envelope.Events {
+			if event.Action == notifications.EventActionPush && event.Target.Tag == "latest" {
+				pushed = append(pushed, event.Target.Digest)
+			}
+		}
+		mu.Unlock()
+	}))
+	defer sink.Close()
+
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory":    configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[any]any{"enabled": false}},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	config.Validation.Manifests.LayerMediaTypes = configuration.ValidationLayerMediaTypes{
+		Correct:      true,
+		Repositories: []string{"legacy"},
+	}
+	config.Notifications.Endpoints = []configuration.Endpoint{
+		{Name: "sink", URL: sink.URL, Timeout: time.Second, Threshold: 3, Backoff: 100 * time.Millisecond},
+	}
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	name, _ := reference.WithName("legacy/app")
+	imageConfig, err := json.Marshal(v1.Image{Platform: v1.Platform{OS: "linux", Architecture: "amd64"}})
+	checkErr(t, err, "marshaling image config")
+	configDigest := digest.FromBytes(imageConfig)
+	uploadURLBase, _ := startPushLayer(t, env, name)
+	pushLayer(t, env.builder, name, configDigest, uploadURLBase, bytes.NewReader(imageConfig))
+
+	layer, layerDigest, err := testutil.CreateRandomTarFile()
+	checkErr(t, err, "creating random layer")
+	uploadURLBase, _ = startPushLayer(t, env, name)
+	pushLayer(t, env.builder, name, layerDigest, uploadURLBase, layer)
+
+	// the layer is pushed without media type by legacy tooling
+	manifest := &ocischema.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: v1.MediaTypeImageManifest,
+		Config:    v1.Descriptor{MediaType: v1.MediaTypeImageConfig, Digest: configDigest, Size: int64(len(imageConfig))},
+		Layers:    []v1.Descriptor{{Digest: layerDigest}},
+	}
+	body, err := json.MarshalIndent(manifest, "", "   ")
+	checkErr(t, err, "marshaling manifest")
+	tagRef, _ := reference.WithTag(name, "latest")
+	manifestURL, err := env.builder.BuildManifestURL(tagRef)
+	checkErr(t, err, "building manifest url")
+	resp := putManifest(t, "putting manifest", manifestURL, v1.MediaTypeImageManifest, manifest)
+	resp.Body.Close()
+	checkResponse(t, "putting manifest", resp, http.StatusCreated)
+
+	dgst := digest.Digest(resp.Header.Get("Docker-Content-Digest"))
+	if dgst == digest.FromBytes(body) {
+		t.Fatal("manifest stored without correction")
+	}
+	if tagged := tagDigest(t, env, name, "latest"); tagged != dgst {
+		t.Fatalf("unexpected digest tagged: %s != %s", tagged, dgst)
+	}
+
+	digestRef, _ := reference.WithDigest(name, dgst)
+	manifestDigestURL, err := env.builder.BuildManifestURL(digestRef)
+	checkErr(t, err, "building manifest url")
+	req, err := http.NewRequest(http.MethodGet, manifestDigestURL, nil)
+	checkErr(t, err, "building request")
+	req.Header.Set("Accept", v1.MediaTypeImageManifest)
+	resp, err = http.DefaultClient.Do(req)
+	checkErr(t, err, "fetching manifest")
+	defer resp.Body.Close()
+	checkResponse(t, "fetching manifest", resp, http.StatusOK)
+	var stored ocischema.Manifest
+	checkErr(t, json.NewDecoder(resp.Body).Decode(&stored), "decoding manifest")
+	if mediaType := stored.Layers[0].MediaType; mediaType != v1.MediaTypeImageLayer {
+		t.Fatalf("unexpected media type of the stored layer: %q", mediaType)
+	}
+
+	// the push event reports the stored manifest
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		mu.Lock()
+		events := pushed
+		mu.Unlock()
+		if len(events) > 0 {
+			if events[0] != dgst {
+				t.Fatalf("unexpected digest of push event: %s != %s", events[0], dgst)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("no push event for the corrected manifest")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// manifests of other repositories are stored as pushed
+	other, _ := reference.WithName("current/app")
+	uploadURLBase, _ = startPushLayer(t, env, other)
+	pushLayer(t, env.builder, other, configDigest, uploadURLBase, bytes.NewReader(imageConfig))
+	layer, layerDigest, err = testutil.CreateRandomTarFile()
+	checkErr(t, err, "creating random layer")
+	uploadURLBase, _ = startPushLayer(t, env, other)
+	pushLayer(t, env.builder, other, layerDigest, uploadURLBase, layer)
+	manifest.Layers[0].Digest = layerDigest
+	body, err = json.MarshalIndent(manifest, "", "   ")
+	checkErr(t, err, "marshaling manifest")
+	tagRef, _ = reference.WithTag(other, "latest")
+	manifestURL, err = env.builder.BuildManifestURL(tagRef)
+	checkErr(t, err, "building manifest url")
+	resp = putManifest(t, "putting manifest", manifestURL, v1.MediaTypeImageManifest, manifest)
+	resp.Body.Close()
+	checkResponse(t, "putting manifest", resp, http.StatusCreated)
+	if dgst := digest.Digest(resp.Header.Get("Docker-Content-Digest")); dgst != digest.FromBytes(body) {
+		t.Fatalf("manifest of another repository corrected: %s", dgst)
+	}
+}
@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/distribution/distribution/v3/configuration"
+)
+
+// defaultListCompressionThreshold is the size above which list responses
+// are compressed by default.
+const defaultListCompressionThreshold = 1024
+
+// listCompression compresses the catalog and tags list responses for the
+// clients accepting gzip.
+type listCompression struct {
+	threshold int
+}
+
+// newListCompression returns the compression described by config, or nil if
+// it is disabled.
+func newListCompression(config configuration.ListCompression) *listCompression {
+	if !config.Enabled {
+		return nil
+	}
+	threshold := config.Threshold
+	if threshold <= 0 {
+		threshold = defaultListCompressionThreshold
+	}
+	return &listCompression{threshold: threshold}
+}
+
+// configureListCompression prepares the compression of list responses.
+func (app *App) configureListCompression(configuration *configuration.Configuration) {
+	if configuration.HTTP.ListCompression.Threshold < 0 {
+		panic("listcompression: threshold must not be negative")
+	}
+	app.listCompression = newListCompression(configuration.HTTP.ListCompression)
+}
+
+// handler compresses the responses of h. A nil listCompression leaves h
+// unchanged.
+func (c *listCompression) handler(h http.Handler) http.Handler {
+	if c == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(r.Header.Values("Accept-Encoding")) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		gw := &gzipListWriter{ResponseWriter: w, threshold: c.threshold}
+		defer gw.close()
+		h.ServeHTTP(gw, r)
+	})
+}
+
+// acceptsGzip reports whether the Accept-Encoding header values accept the
+// gzip content coding, following RFC 9110: gzip is acceptable if the most
+// specific coding matching it has a non-zero quality value.
+func acceptsGzip(values []string) bool {
+	q, specificity := 0.0, -1
+	for _, value := range values {
+	codings:
+		for coding := range strings.SplitSeq(value, ",") {
+			name, params, _ := strings.Cut(coding, ";")
+			var s int
+			switch strings.ToLower(strings.TrimSpace(name)) {
+			case "gzip", "x-gzip":
+				s = 1
+			case "*":
+				s = 0
+			default:
+				continue
+			}
+
+			codingQ := 1.0
+			for param := range strings.SplitSeq(params, ";") {
+				key, v, ok := strings.Cut(param, "=")
+				if !ok || !strings.EqualFold(strings.TrimSpace(key), "q") {
+					continue
+				}
+				parsed, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+				if err != nil || parsed < 0 || parsed > 1 {
+					// codings with a malformed quality value are ignored
+					continue codings
+				}
+				codingQ = parsed
+			}
+			if s > specificity {
+				q, specificity = codingQ, s
+			}
+		}
+	}
+	return q > 0
+}
+
+// gzipListWriter buffers a response up to threshold bytes, and compresses it
+// once it exceeds the threshold or is flushed, so that large responses can
+// still be streamed. Smaller responses are written uncompressed when the
+// writer is closed.
+type gzipListWriter struct {
+	http.ResponseWriter
+	threshold int
+
+	status int
+	buf    []byte
+	gz     *gzip.Writer
+	// passthrough is set once the response is written uncompressed.
+	passthrough bool
+}
+
+func (w *gzipListWriter) WriteHeader(status int) {
+	if w.gz != nil || w.passthrough {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *gzipListWriter) Write(p []byte) (int, error) {
+	switch {
+	case w.gz != nil:
+		return w.gz.Write(p)
+	case w.passthrough:
+		return w.ResponseWriter.Write(p)
+	}
+	w.buf = append(w.buf, p...)
+	if len(w.buf) > w.threshold {
+		if err := w.compress(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush compresses the response if not yet decided, and sends what was
+// written so far to the client.
+func (w *gzipListWriter) Flush() {
+	if w.gz == nil && !w.passthrough {
+		if err := w.compress(); err != nil {
+			return
+		}
+	}
+	if w.gz != nil {
+		if err := w.gz.Flush(); err != nil {
+			return
+		}
+	}
+	_ = http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *gzipListWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// compress starts the compressed response with the buffered bytes.
+func (w *gzipListWriter) compress() error {
+	header := w.Header()
+	header.Set("Content-Encoding", "gzip")
+	header.Del("Content-Length")
+	w.writeStatus()
+	w.gz = gzip.NewWriter(w.ResponseWriter)
+	buf := w.buf
+	w.buf = nil
+	_, err := w.gz.Write(buf)
+	return err
+}
+
+func (w *gzipListWriter) writeStatus() {
+	if w.status != 0 {
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+}
+
+// close ends the compressed response, or writes the buffered response
+// uncompressed if it did not exceed the threshold. Nothing is written if the
+// handler wrote nothing, so that its errors can still be served.
+func (w *gzipListWriter) close() {
+	if w.gz != nil {
+		_ = w.gz.Close()
+		return
+	}
+	if w.passthrough || w.status == 0 && len(w.buf) == 0 {
+		return
+	}
+	w.passthrough = true
+	w.writeStatus()
+	_, _ = w.ResponseWriter.Write(w.buf)
+}
@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/reference"
+)
+
+func TestAcceptsGzip(t *testing.T) {
+	for _, tc := range []struct {
+		values   []string
+		expected bool
+	}{
+		{values: nil},
+		{values: []string{"identity"}},
+		{values: []string{"gzip"}, expected: true},
+		{values: []string{"br, GZIP;q=0.5"}, expected: true},
+		{values: []string{"deflate", "x-gzip"}, expected: true},
+		{values: []string{"gzip;q=0"}},
+		{values: []string{"*"}, expected: true},
+		{values: []string{"*;q=0"}},
+		{values: []string{"gzip;q=0, *"}},
+		{values: []string{"gzip, *;q=0"}, expected: true},
+		{values: []string{"gzip;q=high"}},
+	} {
+		if got := acceptsGzip(tc.values); got != tc.expected {
+			t.Errorf("acceptsGzip(%q) = %t, expected %t", tc.values, got, tc.expected)
+		}
+	}
+}
+
+func TestGzipListWriterFlush(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	w := &gzipListWriter{ResponseWriter: recorder, threshold: 1024}
+
+	w.Header().Set("Content-Length", "5")
+	if _, err := w.Write([]byte("first")); err != nil {
+		t.Fatalf("unexpected error writing: %v", err)
+	}
+	if recorder.Body.Len() != 0 {
+		t.Fatalf("expected the response below the threshold to be buffered")
+	}
+
+	// flushing sends what was written so far, compressed
+	w.Flush()
+	if !recorder.Flushed {
+		t.Fatalf("expected the response to be flushed")
+	}
+	if recorder.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("expected the flushed response to be compressed")
+	}
+	if recorder.Header().Get("Content-Length") != "" {
+		t.Fatalf("unexpected content length of the compressed response")
+	}
+	flushed := recorder.Body.Len()
+	if flushed == 0 {
+		t.Fatalf("expected the flushed bytes to be written")
+	}
+
+	if _, err := w.Write([]byte(" second")); err != nil {
+		t.Fatalf("unexpected error writing: %v", err)
+	}
+	w.close()
+	if recorder.Body.Len() <= flushed {
+		t.Fatalf("expected the bytes written after the flush to be written")
+	}
+
+	gz, err := gzip.NewReader(recorder.Body)
+	if err != nil {
+		t.Fatalf("unexpected error reading the compressed response: %v", err)
+	}
+	body, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatalf("unexpected error reading the compressed response: %v", err)
+	}
+	if string(body) != "first second" {
+		t.Fatalf("unexpected response: %q", body)
+	}
+}
+
+func TestGzipListWriterNothingWritten(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	w := &gzipListWriter{ResponseWriter: recorder, threshold: 1024}
+	w.close()
+
+	if recorder.Body.Len() != 0 || recorder.Header().Get("Content-Encoding") != "" {
+		t.Fatalf("expected nothing written")
+	}
+}
+
+func TestListCompression(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory":    configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[any]any{"enabled": false}},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	config.HTTP.ListCompression = configuration.ListCompression{Enabled: true, Threshold: 256}
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/compressed")
+	var tags []string
+	for i := range 20 {
+		tag := fmt.Sprintf("release-%02d", i)
+		createRepository(env, t, imageName.Name(), tag)
+		tags = append(tags, tag)
+	}
+	tagsURL, err := env.builder.BuildTagsURL(imageName)
+	if err != nil {
+		t.Fatalf("unexpected error building tags url: %v", err)
+	}
+	limitedURL, err := env.builder.BuildTagsURL(imageName, map[string][]string{"n": {"2"}})
+	if err != nil {
+		t.Fatalf("unexpected error building tags url: %v", err)
+	}
+	unknownName, _ := reference.WithName("foo/unknown")
+	unknownURL, err := env.builder.BuildTagsURL(unknownName)
+	if err != nil {
+		t.Fatalf("unexpected error building tags url: %v", err)
+	}
+
+	// the client must not decompress the responses transparently
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+
+	for _, tc := range []struct {
+		name           string
+		url            string
+		acceptEncoding string
+		status         int
+		compressed     bool
+		tags           []string
+	}{
+		{name: "no accept encoding", url: tagsURL, status: http.StatusOK, tags: tags},
+		{name: "gzip", url: tagsURL, acceptEncoding: "gzip", status: http.StatusOK, compressed: true, tags: tags},
+		{name: "gzip refused", url: tagsURL, acceptEncoding: "gzip;q=0", status: http.StatusOK, tags: tags},
+		{name: "below threshold", url: limitedURL, acceptEncoding: "gzip", status: http.StatusOK, tags: tags[:2]},
+		{name: "error", url: unknownURL, acceptEncoding: "gzip", status: http.StatusNotFound},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, tc.url, nil)
+			if err != nil {
+				t.Fatalf("unexpected error creating request: %v", err)
+			}
+			if tc.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", tc.acceptEncoding)
+			}
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatalf("unexpected error listing tags: %v", err)
+			}
+			defer resp.Body.Close()
+			checkResponse(t, "listing tags", resp, tc.status)
+
+			if resp.Header.Get("Vary") != "Accept-Encoding" {
+				t.Fatalf("unexpected Vary header: %q", resp.Header.Get("Vary"))
+			}
+			body := io.Reader(resp.Body)
+			if tc.compressed {
+				if resp.Header.Get("Content-Encoding") != "gzip" {
+					t.Fatalf("expected the response to be compressed")
+				}
+				gz, err := gzip.NewReader(resp.Body)
+				if err != nil {
+					t.Fatalf("unexpected error reading the compressed response: %v", err)
+				}
+				body = gz
+			} else if resp.Header.Get("Content-Encoding") != "" {
+				t.Fatalf("unexpected content encoding: %q", resp.Header.Get("Content-Encoding"))
+			}
+			if tc.status != http.StatusOK {
+				return
+			}
+
+			var tagsResponse tagsAPIResponse
+			if err := json.NewDecoder(body).Decode(&tagsResponse); err != nil {
+				t.Fatalf("unexpected error decoding the response: %v", err)
+			}
+			if tagsResponse.Name != imageName.Name() {
+				t.Fatalf("unexpected name: %q", tagsResponse.Name)
+			}
+			if fmt.Sprint(tagsResponse.Tags) != fmt.Sprint(tc.tags) {
+				t.Fatalf("unexpected tags: %v, expected %v", tagsResponse.Tags, tc.tags)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/distribution/distribution/v3/configuration"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/factory"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/reference"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// payloadCountingDriverFactory creates the driver of the current test.
+type payloadCountingDriverFactory struct {
+	driver *payloadCountingDriver
+}
+
+func (factory *payloadCountingDriverFactory) Create(ctx context.Context, parameters map[string]any) (storagedriver.StorageDriver, error) {
+	return factory.driver, nil
+}
+
+// payloadCountingDriver counts the reads of blob payloads, which include the
+// payloads of manifests, whether read whole or streamed.
+type payloadCountingDriver struct {
+	storagedriver.StorageDriver
+	reads atomic.Int64
+}
+
+func (d *payloadCountingDriver) GetContent(ctx context.Context, path string) ([]byte, error) {
+	if strings.Contains(path, "/blobs/") {
+		d.reads.Add(1)
+	}
+	return d.StorageDriver.GetContent(ctx, path)
+}
+
+func (d *payloadCountingDriver) Reader(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
+	if strings.Contains(path, "/blobs/") {
+		d.reads.Add(1)
+	}
+	return d.StorageDriver.Reader(ctx, path, offset)
+}
+
+// headManifest returns the response to a HEAD request of the manifest ref
+// accepting OCI manifests.
+func headManifest(t *testing.T, env *testEnv, ref reference.Named) *http.Response {
+	t.Helper()
+
+	manifestURL, err := env.builder.BuildManifestURL(ref)
+	checkErr(t, err, "building manifest url")
+	req, err := http.NewRequest(http.MethodHead, manifestURL, nil)
+	checkErr(t, err, "building manifest request")
+	req.Header.Set("Accept", v1.MediaTypeImageManifest)
+	resp, err := http.DefaultClient.Do(req)
+	checkErr(t, err, "heading manifest")
+	resp.Body.Close()
+	return resp
+}
+
+func TestManifestDescriptorCache(t *testing.T) {
+	countingFactory := &payloadCountingDriverFactory{}
+	factory.Register("payloadcountingstorage", countingFactory)
+	name, _ := reference.WithName("foo/bar")
+
+	for _, enabled := range []bool{false, true} {
+		t.Run(map[bool]string{false: "disabled", true: "enabled"}[enabled], func(t *testing.T) {
+			countingFactory.driver = &payloadCountingDriver{StorageDriver: inmemory.New()}
+			config := configuration.Configuration{
+				Storage: configuration.Storage{
+					"payloadcountingstorage": configuration.Parameters{},
+					"delete":                 configuration.Parameters{"enabled": true},
+					"cache":                  configuration.Parameters{"blobdescriptor": "inmemory", "manifestdescriptors": enabled},
+					"maintenance":            configuration.Parameters{"uploadpurging": map[any]any{"enabled": false}},
+				},
+			}
+			config.HTTP.Headers = headerConfig
+			env := newTestEnvWithConfig(t, &config)
+			defer env.Shutdown()
+
+			dgst := pushPlatformImage(t, env, name, "latest", "amd64")
+			digestRef, _ := reference.WithDigest(name, dgst)
+			tagRef, _ := reference.WithTag(name, "latest")
+
+			manifestURL, err := env.builder.BuildManifestURL(digestRef)
+			checkErr(t, err, "building manifest url")
+			req, err := http.NewRequest(http.MethodGet, manifestURL, nil)
+			checkErr(t, err, "building manifest request")
+			req.Header.Set("Accept", v1.MediaTypeImageManifest)
+			get, err := http.DefaultClient.Do(req)
+			checkErr(t, err, "getting manifest")
+			get.Body.Close()
+			checkResponse(t, "getting manifest", get, http.StatusOK)
+
+			countingFactory.driver.reads.Store(0)
+			for _, ref := range []reference.Named{digestRef, digestRef, tagRef} {
+				resp := headManifest(t, env, ref)
+				checkResponse(t, "heading manifest", resp, http.StatusOK)
+				checkHeaders(t, resp, http.Header{
+					"Content-Type":          []string{get.Header.Get("Content-Type")},
+					"Content-Length":        []string{get.Header.Get("Content-Length")},
+					"Docker-Content-Digest": []string{dgst.String()},
+					"ETag":                  []string{`"` + dgst.String() + `"`},
+				})
+			}
+			if reads := countingFactory.driver.reads.Load(); enabled != (reads == 0) {
+				t.Fatalf("unexpected %d payload reads heading the manifest", reads)
+			}
+
+			// the deletion of the manifest clears its cached descriptor
+			resp := deleteManifest(t, env, digestRef, "")
+			resp.Body.Close()
+			checkResponse(t, "deleting manifest", resp, http.StatusAccepted)
+			resp = headManifest(t, env, digestRef)
+			checkResponse(t, "heading deleted manifest", resp, http.StatusNotFound)
+		})
+	}
+}
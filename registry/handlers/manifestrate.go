@@ -0,0 +1,205 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	prometheus "github.com/distribution/distribution/v3/metrics"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/docker/go-metrics"
+	"golang.org/x/time/rate"
+)
+
+// repositoryLimiterSweepInterval is how often the buckets of idle clients
+// are dropped.
+const repositoryLimiterSweepInterval = time.Minute
+
+// manifestPutRejections is the number of manifest pushes rejected by rate limits
+var manifestPutRejections = prometheus.HTTPNamespace.NewCounter("manifest_put_rejections", "The number of manifest pushes rejected by rate limits")
+
+// referrersRejections is the number of referrers queries rejected by rate
+// limits
+var referrersRejections = prometheus.HTTPNamespace.NewCounter("referrers_rejections", "The number of referrers queries rejected by rate limits")
+
+// repositoryLimiter limits the rate of an operation, such as manifest
+// pushes, with a token bucket per repository and user.
+type repositoryLimiter struct {
+	// operation names the operation limited in logs and errors.
+	operation  string
+	rejections metrics.Counter
+	rules      []repositoryRule
+
+	mu        sync.Mutex
+	buckets   map[repositoryKey]*repositoryBucket
+	lastSweep time.Time
+}
+
+type repositoryRule struct {
+	repositories []string
+	limit        rate.Limit
+	burst        int
+}
+
+// repositoryRate is a rule of the configuration of a repositoryLimiter.
+type repositoryRate struct {
+	repositories []string
+	rate         float64
+	burst        int
+}
+
+// repositoryKey identifies a token bucket. The user is empty for anonymous
+// requests.
+type repositoryKey struct {
+	repository string
+	user       string
+}
+
+type repositoryBucket struct {
+	limiter *rate.Limiter
+	// full is when the bucket is full again, after which it can be
+	// dropped without loss.
+	full time.Time
+}
+
+// newManifestPutLimiter validates config and returns the limiter it
+// describes, or nil if no rate is limited.
+func newManifestPutLimiter(config configuration.ManifestPutPolicy) (*repositoryLimiter, error) {
+	rates := make([]repositoryRate, 0, len(config.Rules))
+	for _, rule := range config.Rules {
+		rates = append(rates, repositoryRate{repositories: rule.Repositories, rate: rule.Rate, burst: rule.Burst})
+	}
+	return newRepositoryLimiter("manifest push", manifestPutRejections, rates)
+}
+
+// newReferrersLimiter validates config and returns the limiter of the
+// referrers queries it describes, or nil if no rate is limited.
+func newReferrersLimiter(config configuration.ReferrersPolicy) (*repositoryLimiter, error) {
+	rates := make([]repositoryRate, 0, len(config.Rules))
+	for _, rule := range config.Rules {
+		rates = append(rates, repositoryRate{repositories: rule.Repositories, rate: rule.Rate, burst: rule.Burst})
+	}
+	return newRepositoryLimiter("referrers query", referrersRejections, rates)
+}
+
+// newRepositoryLimiter validates rates and returns the limiter of operation
+// they describe, or nil if no rate is limited.
+func newRepositoryLimiter(operation string, rejections metrics.Counter, rates []repositoryRate) (*repositoryLimiter, error) {
+	if len(rates) == 0 {
+		return nil, nil
+	}
+
+	l := &repositoryLimiter{
+		operation:  operation,
+		rejections: rejections,
+		buckets:    make(map[repositoryKey]*repositoryBucket),
+	}
+	for i, rule := range rates {
+		if len(rule.repositories) == 0 {
+			return nil, fmt.Errorf("rule %d does not match any repository", i)
+		}
+		for _, pattern := range rule.repositories {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid rule %d pattern %q: %w", i, pattern, err)
+			}
+		}
+		if rule.rate <= 0 || math.IsInf(rule.rate, 0) || math.IsNaN(rule.rate) {
+			return nil, fmt.Errorf("rule %d rate must be positive", i)
+		}
+		if rule.burst < 0 {
+			return nil, fmt.Errorf("rule %d burst must not be negative", i)
+		}
+
+		burst := rule.burst
+		if burst == 0 {
+			burst = max(1, int(math.Ceil(rule.rate)))
+		}
+		l.rules = append(l.rules, repositoryRule{
+			repositories: rule.repositories,
+			limit:        rate.Limit(rule.rate),
+			burst:        burst,
+		})
+	}
+	return l, nil
+}
+
+// rule returns the rule applying to repository, or nil if it is not limited.
+func (l *repositoryLimiter) rule(repository string) *repositoryRule {
+	for i := range l.rules {
+		for _, pattern := range l.rules[i].repositories {
+			if ok, _ := path.Match(pattern, repository); ok {
+				return &l.rules[i]
+			}
+		}
+	}
+	return nil
+}
+
+// reserve takes a token from the bucket of user in repository at now. If
+// the bucket is empty, it returns false and how long to wait for a token.
+func (l *repositoryLimiter) reserve(repository, user string, now time.Time) (time.Duration, bool) {
+	rule := l.rule(repository)
+	if rule == nil {
+		return 0, true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) >= repositoryLimiterSweepInterval {
+		for key, bucket := range l.buckets {
+			if !now.Before(bucket.full) {
+				delete(l.buckets, key)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	key := repositoryKey{repository: repository, user: user}
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &repositoryBucket{limiter: rate.NewLimiter(rule.limit, rule.burst)}
+		l.buckets[key] = bucket
+	}
+
+	reservation := bucket.limiter.ReserveN(now, 1)
+	if delay := reservation.DelayFrom(now); delay > 0 {
+		reservation.CancelAt(now)
+		return delay, false
+	}
+
+	missing := float64(rule.burst) - bucket.limiter.TokensAt(now)
+	bucket.full = now.Add(time.Duration(missing / float64(rule.limit) * float64(time.Second)))
+	return 0, true
+}
+
+// limit wraps handler so that it is only served if the bucket of the
+// repository and user of the request holds a token. Other requests are
+// rejected with 429 Too Many Requests. A nil limiter returns handler
+// unchanged.
+func (l *repositoryLimiter) limit(ctx *Context, handler http.Handler) http.Handler {
+	if l == nil {
+		return handler
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		repository := getName(ctx)
+		user := getUserName(ctx, r)
+		retryAfter, ok := l.reserve(repository, user, time.Now())
+		if !ok {
+			l.rejections.Inc(1)
+			dcontext.GetLogger(ctx).Warnf("rejecting %s of %s for user %q: rate limit reached", l.operation, repository, user)
+			w.Header().Set("Retry-After", strconv.Itoa(max(1, int(math.Ceil(retryAfter.Seconds())))))
+			ctx.Errors = append(ctx.Errors, errcode.ErrorCodeTooManyRequests.WithDetail(l.operation+" rate limit reached"))
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/manifest/schema2"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/distribution/reference"
+)
+
+func TestManifestPutLimiterReserve(t *testing.T) {
+	limiter, err := newManifestPutLimiter(configuration.ManifestPutPolicy{
+		Rules: []configuration.ManifestPutRule{
+			{Repositories: []string{"ci/*"}, Rate: 1, Burst: 2},
+			{Repositories: []string{"*/*"}, Rate: 0.5},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating limiter: %v", err)
+	}
+
+	now := time.Now()
+	for _, tc := range []struct {
+		repository string
+		user       string
+		after      time.Duration
+		allowed    bool
+	}{
+		// the burst of the first matching rule applies
+		{repository: "ci/app", allowed: true},
+		{repository: "ci/app", allowed: true},
+		{repository: "ci/app", allowed: false},
+		// users have their own bucket
+		{repository: "ci/app", user: "alice", allowed: true},
+		{repository: "ci/app", user: "alice", allowed: true},
+		{repository: "ci/app", user: "alice", allowed: false},
+		// repositories have their own bucket
+		{repository: "ci/other", allowed: true},
+		// the bucket refills at the rate of the rule
+		{repository: "ci/app", after: time.Second, allowed: true},
+		{repository: "ci/app", after: time.Second, allowed: false},
+		// the burst defaults to the rate, and at least 1
+		{repository: "library/app", allowed: true},
+		{repository: "library/app", allowed: false},
+		{repository: "library/app", after: 2 * time.Second, allowed: true},
+		// repositories matching no rule are not limited
+		{repository: "app", allowed: true},
+		{repository: "app", allowed: true},
+	} {
+		retryAfter, allowed := limiter.reserve(tc.repository, tc.user, now.Add(tc.after))
+		if allowed != tc.allowed {
+			t.Fatalf("push to %s by %q after %v: expected allowed=%v, got %v", tc.repository, tc.user, tc.after, tc.allowed, allowed)
+		}
+		if !allowed && retryAfter <= 0 {
+			t.Fatalf("push to %s by %q after %v: expected a retry delay, got %v", tc.repository, tc.user, tc.after, retryAfter)
+		}
+	}
+
+	// full buckets are dropped
+	limiter.reserve("ci/app", "", now.Add(time.Hour))
+	if _, ok := limiter.buckets[repositoryKey{repository: "ci/other"}]; ok {
+		t.Fatal("expected idle buckets to be dropped")
+	}
+}
+
+func TestManifestPutLimiterInvalid(t *testing.T) {
+	for _, config := range []configuration.ManifestPutPolicy{
+		{Rules: []configuration.ManifestPutRule{{Rate: 1}}},
+		{Rules: []configuration.ManifestPutRule{{Repositories: []string{"[ci/*"}, Rate: 1}}},
+		{Rules: []configuration.ManifestPutRule{{Repositories: []string{"ci/*"}}}},
+		{Rules: []configuration.ManifestPutRule{{Repositories: []string{"ci/*"}, Rate: 1, Burst: -1}}},
+	} {
+		if _, err := newManifestPutLimiter(config); err == nil {
+			t.Errorf("expected error for manifest put policy %+v", config)
+		}
+	}
+}
+
+func TestManifestPutRateLimit(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory":    configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[any]any{"enabled": false}},
+		},
+	}
+	config.Policy.ManifestPuts.Rules = []configuration.ManifestPutRule{
+		{Repositories: []string{"ci/*"}, Rate: 0.01, Burst: 3},
+	}
+	config.HTTP.Headers = headerConfig
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	name, _ := reference.WithName("ci/app")
+	createRepository(env, t, name.Name(), "latest")
+
+	latestRef, _ := reference.WithTag(name, "latest")
+	manifestURL, err := env.builder.BuildManifestURL(latestRef)
+	checkErr(t, err, "building manifest url")
+	req, err := http.NewRequest(http.MethodGet, manifestURL, nil)
+	checkErr(t, err, "building manifest request")
+	req.Header.Set("Accept", schema2.MediaTypeManifest)
+	resp, err := http.DefaultClient.Do(req)
+	checkErr(t, err, "fetching manifest")
+	var manifest schema2.Manifest
+	err = json.NewDecoder(resp.Body).Decode(&manifest)
+	resp.Body.Close()
+	checkErr(t, err, "decoding manifest")
+
+	// createRepository took the first token of the burst
+	for i := range 3 {
+		tagRef, _ := reference.WithTag(name, "tag"+strconv.Itoa(i))
+		tagURL, err := env.builder.BuildManifestURL(tagRef)
+		checkErr(t, err, "building manifest url")
+		resp := putManifest(t, "pushing manifest", tagURL, schema2.MediaTypeManifest, &manifest)
+		resp.Body.Close()
+
+		if i < 2 {
+			checkResponse(t, "pushing manifest within the limit", resp, http.StatusCreated)
+			continue
+		}
+		checkResponse(t, "pushing manifest over the limit", resp, http.StatusTooManyRequests)
+		if retryAfter, err := strconv.Atoi(resp.Header.Get("Retry-After")); err != nil || retryAfter < 1 {
+			t.Fatalf("expected a Retry-After delay, got %q", resp.Header.Get("Retry-After"))
+		}
+	}
+
+	tagRef, _ := reference.WithTag(name, "rejected")
+	tagURL, err := env.builder.BuildManifestURL(tagRef)
+	checkErr(t, err, "building manifest url")
+	resp = putManifest(t, "pushing manifest", tagURL, schema2.MediaTypeManifest, &manifest)
+	defer resp.Body.Close()
+	checkBodyHasErrorCodes(t, "pushing manifest over the limit", resp, errcode.ErrorCodeTooManyRequests)
+
+	// other repositories are not limited
+	createRepository(env, t, "library/app", "latest")
+	createRepository(env, t, "library/app", "other")
+}
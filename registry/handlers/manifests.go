@@ -2,11 +2,12 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
-	"mime"
 	"net/http"
 	"slices"
+	"strconv"
 	"strings"
 	"sync"
 
@@ -16,6 +17,7 @@ import (
 	"github.com/distribution/distribution/v3/manifest/ocischema"
 	"github.com/distribution/distribution/v3/manifest/schema2"
 	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/distribution/distribution/v3/registry/pullstats"
 	"github.com/distribution/distribution/v3/registry/storage"
 	"github.com/distribution/distribution/v3/registry/storage/driver"
 	"github.com/distribution/reference"
@@ -58,18 +60,34 @@ func manifestDispatcher(ctx *Context, r *http.Request) http.Handler {
 	}
 
 	mhandler := handlers.MethodHandler{
-		http.MethodGet:  http.HandlerFunc(manifestHandler.GetManifest),
+		http.MethodGet:  ctx.pullLimiter.limit(ctx, http.HandlerFunc(manifestHandler.GetManifest)),
 		http.MethodHead: http.HandlerFunc(manifestHandler.GetManifest),
 	}
 
 	if !ctx.readOnly {
-		mhandler[http.MethodPut] = http.HandlerFunc(manifestHandler.PutManifest)
+		mhandler[http.MethodPut] = ctx.manifestPutLimiter.limit(ctx, http.HandlerFunc(manifestHandler.PutManifest))
 		mhandler[http.MethodDelete] = http.HandlerFunc(manifestHandler.DeleteManifest)
 	}
 
 	return mhandler
 }
 
+// manifestDefaultTagDispatcher builds a manifest handler for the default tag
+// compatibility endpoint. The reference is absent from these requests, so the
+// repository's configured default tag is resolved through the tag store in
+// its place. Only read operations are supported.
+func manifestDefaultTagDispatcher(ctx *Context, r *http.Request) http.Handler {
+	manifestHandler := &manifestHandler{
+		Context: ctx,
+		Tag:     ctx.App.defaultTag(getName(ctx)),
+	}
+
+	return handlers.MethodHandler{
+		http.MethodGet:  ctx.pullLimiter.limit(ctx, http.HandlerFunc(manifestHandler.GetManifest)),
+		http.MethodHead: http.HandlerFunc(manifestHandler.GetManifest),
+	}
+}
+
 // manifestHandler handles http operations on image manifests.
 type manifestHandler struct {
 	*Context
@@ -79,6 +97,28 @@ type manifestHandler struct {
 	Digest digest.Digest
 }
 
+// repositoryExistsHeader hints whether the repository of an unknown manifest
+// probed with HEAD exists.
+const repositoryExistsHeader = "Docker-Repository-Exists"
+
+// hintRepositoryExists sets the repositoryExistsHeader on HEAD requests, if
+// enabled, telling a missing repository from a missing manifest.
+func (imh *manifestHandler) hintRepositoryExists(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodHead || !imh.Config.Tags.RepositoryHint.Enabled {
+		return
+	}
+	checker, ok := imh.registry.(distribution.RepositoryChecker)
+	if !ok {
+		return
+	}
+	exists, err := checker.RepositoryExists(imh, imh.Repository.Named())
+	if err != nil {
+		dcontext.GetLogger(imh).Errorf("error checking whether repository %s exists: %v", imh.Repository.Named().Name(), err)
+		return
+	}
+	w.Header().Set(repositoryExistsHeader, strconv.FormatBool(exists))
+}
+
 // GetManifest fetches the image manifest from the storage backend, if it exists.
 func (imh *manifestHandler) GetManifest(w http.ResponseWriter, r *http.Request) {
 	dcontext.GetLogger(imh).Debug("GetImageManifest")
@@ -87,41 +127,15 @@ func (imh *manifestHandler) GetManifest(w http.ResponseWriter, r *http.Request)
 		imh.Errors = append(imh.Errors, err)
 		return
 	}
-	var supports [numStorageTypes]bool
-
-	// this parsing of Accept headers is not quite as full-featured as godoc.org's parser, but we don't care about "q=" values
-	// https://github.com/golang/gddo/blob/e91d4165076d7474d20abda83f92d15c7ebc3e81/httputil/header/header.go#L165-L202
-	for _, acceptHeader := range r.Header["Accept"] {
-		// r.Header[...] is a slice in case the request contains the same header more than once
-		// if the header isn't set, we'll get the zero value, which "range" will handle gracefully
-
-		// we need to split each header value on "," to get the full list of "Accept" values (per RFC 2616)
-		// https://www.w3.org/Protocols/rfc2616/rfc2616-sec14.html#sec14.1
-		for mediaType := range strings.SplitSeq(acceptHeader, ",") {
-			if mediaType, _, err = mime.ParseMediaType(mediaType); err != nil {
-				continue
-			}
-
-			if mediaType == schema2.MediaTypeManifest {
-				supports[manifestSchema2] = true
-			}
-			if mediaType == manifestlist.MediaTypeManifestList {
-				supports[manifestlistSchema] = true
-			}
-			if mediaType == v1.MediaTypeImageManifest {
-				supports[ociSchema] = true
-			}
-			if mediaType == v1.MediaTypeImageIndex {
-				supports[ociImageIndexSchema] = true
-			}
-		}
-	}
+	// the media types of the Accept headers are weighted by their q values
+	accept := parseAccept(r.Header.Values("Accept"))
 
 	if imh.Tag != "" {
 		tags := imh.Repository.Tags(imh)
 		desc, err := tags.Get(imh, imh.Tag)
 		if err != nil {
 			if _, ok := err.(distribution.ErrTagUnknown); ok {
+				imh.hintRepositoryExists(w, r)
 				imh.Errors = append(imh.Errors, errcode.ErrorCodeManifestUnknown.WithDetail(err))
 			} else {
 				imh.Errors = append(imh.Errors, toErrcodeErrors(err)...)
@@ -129,6 +143,18 @@ func (imh *manifestHandler) GetManifest(w http.ResponseWriter, r *http.Request)
 			return
 		}
 		imh.Digest = desc.Digest
+
+		if imh.Config.Tags.DigestPinning.Enabled {
+			w.Header().Set("Docker-Tag-Digest", desc.Digest.String())
+			if tagDigestMatch(r, desc.Digest) {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+	}
+
+	if !imh.checkQuarantine(w) {
+		return
 	}
 
 	if etagMatch(r, imh.Digest.String()) {
@@ -136,6 +162,10 @@ func (imh *manifestHandler) GetManifest(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	if r.Method == http.MethodHead && imh.App.describeManifests && imh.headManifest(w, manifests, accept) {
+		return
+	}
+
 	var options []distribution.ManifestServiceOption
 	if imh.Tag != "" {
 		options = append(options, distribution.WithTag(imh.Tag))
@@ -143,6 +173,7 @@ func (imh *manifestHandler) GetManifest(w http.ResponseWriter, r *http.Request)
 	manifest, err := manifests.Get(imh, imh.Digest, options...)
 	if err != nil {
 		if _, ok := err.(distribution.ErrManifestUnknownRevision); ok {
+			imh.hintRepositoryExists(w, r)
 			imh.Errors = append(imh.Errors, errcode.ErrorCodeManifestUnknown.WithDetail(err))
 		} else {
 			imh.Errors = append(imh.Errors, toErrcodeErrors(err)...)
@@ -163,16 +194,39 @@ func (imh *manifestHandler) GetManifest(w http.ResponseWriter, r *http.Request)
 		}
 	}
 
-	if manifestType == ociSchema && !supports[ociSchema] {
-		imh.Errors = append(imh.Errors, errcode.ErrorCodeManifestUnknown.WithMessage("OCI manifest found, but accept header does not support OCI manifests"))
-		return
-	}
-	if manifestType == ociImageIndexSchema && !supports[ociImageIndexSchema] {
-		imh.Errors = append(imh.Errors, errcode.ErrorCodeManifestUnknown.WithMessage("OCI index found, but accept header does not support OCI indexes"))
-		return
+	var rewrite bool
+	if len(accept) > 0 {
+		// serve the stored manifest, or the image manifest a manifest list
+		// referenced by tag can be rewritten to, whichever the client
+		// prefers
+		stored, _, err := manifest.Payload()
+		if err != nil {
+			imh.Errors = append(imh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+			return
+		}
+		available := []string{stored}
+		if imh.Tag != "" && manifestType == manifestlistSchema {
+			available = append(available, schema2.MediaTypeManifest)
+		}
+		chosen, ok := accept.choose(available)
+		if !ok {
+			imh.Errors = append(imh.Errors, errcode.ErrorCodeManifestNotAcceptable.WithDetail(map[string][]string{"available": available}))
+			return
+		}
+		rewrite = chosen != stored
+	} else {
+		if manifestType == ociSchema {
+			imh.Errors = append(imh.Errors, errcode.ErrorCodeManifestUnknown.WithMessage("OCI manifest found, but accept header does not support OCI manifests"))
+			return
+		}
+		if manifestType == ociImageIndexSchema {
+			imh.Errors = append(imh.Errors, errcode.ErrorCodeManifestUnknown.WithMessage("OCI index found, but accept header does not support OCI indexes"))
+			return
+		}
+		rewrite = imh.Tag != "" && manifestType == manifestlistSchema
 	}
 
-	if imh.Tag != "" && manifestType == manifestlistSchema && !supports[manifestlistSchema] {
+	if rewrite {
 		// Rewrite manifest in schema1 format
 		dcontext.GetLogger(imh).Infof("rewriting manifest list %s in schema1 format to support old client", imh.Digest.String())
 
@@ -201,7 +255,7 @@ func (imh *manifestHandler) GetManifest(w http.ResponseWriter, r *http.Request)
 			return
 		}
 
-		if _, isSchema2 := manifest.(*schema2.DeserializedManifest); isSchema2 && !supports[manifestSchema2] {
+		if _, isSchema2 := manifest.(*schema2.DeserializedManifest); isSchema2 && accept.quality(schema2.MediaTypeManifest) == 0 {
 			imh.Errors = append(imh.Errors, errcode.ErrorCodeManifestInvalid.WithMessage("Schema 2 manifest not supported by client"))
 			return
 		} else {
@@ -224,11 +278,51 @@ func (imh *manifestHandler) GetManifest(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	if imh.Tag != "" {
+		imh.recordPull(pullstats.KindManifest, imh.Tag)
+	} else {
+		imh.recordPull(pullstats.KindManifest, imh.Digest.String())
+	}
+
 	if _, err := w.Write(p); err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 	}
 }
 
+// headManifest serves a HEAD request from the descriptor of the manifest,
+// without reading its payload if cached, and reports whether it did. Requests
+// the manifest would be rewritten or rejected for, depending on its media
+// type and on the Accept headers, are left to GetManifest.
+func (imh *manifestHandler) headManifest(w http.ResponseWriter, manifests distribution.ManifestService, accept acceptHeader) bool {
+	describer, ok := manifests.(distribution.ManifestDescriber)
+	if !ok {
+		return false
+	}
+	desc, err := describer.Describe(imh, imh.Digest)
+	if err != nil {
+		return false
+	}
+
+	if imh.Tag != "" && desc.MediaType == manifestlist.MediaTypeManifestList {
+		// manifest lists referenced by tag may be rewritten
+		return false
+	}
+	if len(accept) > 0 {
+		if _, ok := accept.choose([]string{desc.MediaType}); !ok {
+			return false
+		}
+	} else if desc.MediaType == v1.MediaTypeImageManifest || desc.MediaType == v1.MediaTypeImageIndex {
+		return false
+	}
+
+	w.Header().Set("Content-Type", desc.MediaType)
+	w.Header().Set("Content-Length", fmt.Sprint(desc.Size))
+	w.Header().Set("Docker-Content-Digest", imh.Digest.String())
+	w.Header().Set("Etag", fmt.Sprintf(`"%s"`, imh.Digest))
+	w.WriteHeader(http.StatusOK)
+	return true
+}
+
 func etagMatch(r *http.Request, etag string) bool {
 	for _, headerVal := range r.Header["If-None-Match"] {
 		if headerVal == etag || headerVal == fmt.Sprintf(`"%s"`, etag) { // allow quoted or unquoted
@@ -238,6 +332,43 @@ func etagMatch(r *http.Request, etag string) bool {
 	return false
 }
 
+// tagDigestMatch reports whether the Docker-Tag-If-None-Match headers of r
+// list dgst, the digest the requested tag currently points to. Unlike the
+// Etag, which identifies the manifest served, this detects whether the tag
+// was moved since the client last pulled it.
+func tagDigestMatch(r *http.Request, dgst digest.Digest) bool {
+	for _, headerVal := range r.Header.Values("Docker-Tag-If-None-Match") {
+		for v := range strings.SplitSeq(headerVal, ",") {
+			if strings.Trim(strings.TrimSpace(v), `"`) == dgst.String() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// readManifestPayload reads the manifest payload of r, rejecting payloads
+// larger than maxManifestBodySize. Requests declaring a larger content length
+// are rejected before reading anything, and the buffer is sized after the
+// declared content length, so that large manifests, such as image indexes
+// with many entries, are not copied as the buffer grows.
+func readManifestPayload(ctx context.Context, w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	if r.ContentLength > maxManifestBodySize {
+		return nil, fmt.Errorf("manifest of %d bytes exceeds the maximum size of %d bytes", r.ContentLength, maxManifestBodySize)
+	}
+
+	var buf bytes.Buffer
+	if r.ContentLength > 0 {
+		// reading up to the end of the payload requires bytes.MinRead spare
+		// bytes, without which the buffer would grow once full
+		buf.Grow(int(r.ContentLength) + bytes.MinRead)
+	}
+	if err := copyFullPayload(ctx, w, r, &buf, maxManifestBodySize, "image manifest PUT"); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
 // PutManifest validates and stores a manifest in the registry.
 func (imh *manifestHandler) PutManifest(w http.ResponseWriter, r *http.Request) {
 	dcontext.GetLogger(imh).Debug("PutImageManifest")
@@ -247,15 +378,15 @@ func (imh *manifestHandler) PutManifest(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	var jsonBuf bytes.Buffer
-	if err := copyFullPayload(imh, w, r, &jsonBuf, maxManifestBodySize, "image manifest PUT"); err != nil {
-		// copyFullPayload reports the error if necessary
+	payload, err := readManifestPayload(imh, w, r)
+	if err != nil {
+		// readManifestPayload reports the error if necessary
 		imh.Errors = append(imh.Errors, errcode.ErrorCodeManifestInvalid.WithDetail(err.Error()))
 		return
 	}
 
 	mediaType := r.Header.Get("Content-Type")
-	manifest, desc, err := distribution.UnmarshalManifest(mediaType, jsonBuf.Bytes())
+	manifest, desc, err := distribution.UnmarshalManifest(mediaType, payload)
 	if err != nil {
 		imh.Errors = append(imh.Errors, errcode.ErrorCodeManifestInvalid.WithDetail(err))
 		return
@@ -292,7 +423,14 @@ func (imh *manifestHandler) PutManifest(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	_, err = manifests.Put(imh, manifest, options...)
+	done, err := imh.App.repositoryLimit.reserve(imh, imh.Repository.Named())
+	if err != nil {
+		imh.Errors = append(imh.Errors, err)
+		return
+	}
+
+	dgst, err := manifests.Put(imh, manifest, options...)
+	done(err == nil)
 	if err != nil {
 		// TODO(stevvooe): These error handling switches really need to be
 		// handled by an app global mapper.
@@ -312,6 +450,20 @@ func (imh *manifestHandler) PutManifest(w http.ResponseWriter, r *http.Request)
 					imh.Errors = append(imh.Errors, errcode.ErrorCodeManifestBlobUnknown.WithDetail(verificationError.Digest))
 				case distribution.ErrManifestNameInvalid:
 					imh.Errors = append(imh.Errors, errcode.ErrorCodeNameInvalid.WithDetail(err))
+				case distribution.ErrManifestPlatformInvalid:
+					imh.Errors = append(imh.Errors, errcode.ErrorCodeManifestInvalid.WithDetail(verificationError.Error()))
+				case distribution.ErrManifestChildDescriptorInvalid:
+					imh.Errors = append(imh.Errors, errcode.ErrorCodeManifestInvalid.WithDetail(verificationError.Error()))
+				case distribution.ErrManifestConfigMediaTypeInvalid:
+					imh.Errors = append(imh.Errors, errcode.ErrorCodeManifestInvalid.WithDetail(verificationError.Error()))
+				case distribution.ErrManifestConfigCreatedInvalid:
+					imh.Errors = append(imh.Errors, errcode.ErrorCodeManifestInvalid.WithDetail(verificationError.Error()))
+				case distribution.ErrManifestNotCanonical:
+					imh.Errors = append(imh.Errors, errcode.ErrorCodeManifestInvalid.WithDetail(verificationError.Error()))
+				case distribution.ErrManifestLayerDuplicate:
+					imh.Errors = append(imh.Errors, errcode.ErrorCodeManifestInvalid.WithDetail(verificationError.Error()))
+				case distribution.ErrManifestLayerTooLarge:
+					imh.Errors = append(imh.Errors, errcode.ErrorCodeManifestInvalid.WithDetail(verificationError.Error()))
 				case distribution.ErrManifestUnverified:
 					imh.Errors = append(imh.Errors, errcode.ErrorCodeManifestUnverified)
 				default:
@@ -322,6 +474,8 @@ func (imh *manifestHandler) PutManifest(w http.ResponseWriter, r *http.Request)
 					}
 				}
 			}
+		case distribution.ErrRepositoryNameInvalid:
+			imh.Errors = append(imh.Errors, errcode.ErrorCodeNameInvalid.WithDetail(err.Error()))
 		case errcode.Error:
 			imh.Errors = append(imh.Errors, err)
 		default:
@@ -330,19 +484,52 @@ func (imh *manifestHandler) PutManifest(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	// The manifest may be stored with corrections, under another digest.
+	if dgst != desc.Digest {
+		stored, err := manifests.Get(imh, dgst)
+		if err != nil {
+			imh.Errors = append(imh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+			return
+		}
+		mediaType, payload, err = stored.Payload()
+		if err != nil {
+			imh.Errors = append(imh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+			return
+		}
+		desc = v1.Descriptor{MediaType: mediaType, Digest: dgst, Size: int64(len(payload))}
+		imh.Digest = dgst
+	}
+
 	// Tag this manifest
 	if imh.Tag != "" {
 		tags := imh.Repository.Tags(imh)
 		err = tags.Tag(imh, imh.Tag, desc)
 		if err != nil {
+			var coded errcode.Error
+			if errors.As(err, &coded) {
+				imh.Errors = append(imh.Errors, coded)
+				return
+			}
+			if errors.As(err, new(distribution.ErrRepositoryNameInvalid)) {
+				imh.Errors = append(imh.Errors, errcode.ErrorCodeNameInvalid.WithDetail(err.Error()))
+				return
+			}
 			imh.Errors = append(imh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
 			return
 		}
 
+		imh.App.autoIndexer.update(imh.Context, imh.Tag)
 	}
 
+	var tags []string
+	if imh.Tag != "" {
+		tags = []string{imh.Tag}
+	}
+	imh.archiveManifest(r, imh.Digest, mediaType, payload, tags)
+	imh.replicateManifest(imh.Digest, imh.Tag)
+
 	// Construct a canonical url for the uploaded manifest.
-	ref, err := reference.WithDigest(imh.Repository.Named(), imh.Digest)
+	ref, err := reference.WithDigest(imh.clientName(), imh.Digest)
 	if err != nil {
 		imh.Errors = append(imh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
 		return
@@ -442,6 +629,12 @@ func (imh *manifestHandler) DeleteManifest(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	unlock, ok := imh.protectTags(r)
+	if !ok {
+		return
+	}
+	defer unlock()
+
 	if imh.Tag != "" {
 		dcontext.GetLogger(imh).Debug("DeleteImageTag")
 		tagService := imh.Repository.Tags(imh.Context)
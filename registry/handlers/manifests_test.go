@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+)
+
+// TestReadManifestPayloadAllocations validates that reading the largest
+// manifest payload accepted allocates it about once.
+func TestReadManifestPayloadAllocations(t *testing.T) {
+	payload := bytes.Repeat([]byte{'x'}, maxManifestBodySize)
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	r := httptest.NewRequest(http.MethodPut, "/v2/foo/bar/manifests/latest", bytes.NewReader(payload))
+	read, err := readManifestPayload(context.Background(), httptest.NewRecorder(), r)
+	runtime.ReadMemStats(&after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(read, payload) {
+		t.Fatalf("read %d bytes not matching the payload", len(read))
+	}
+
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > maxManifestBodySize*5/4 {
+		t.Fatalf("expected about %d bytes to be allocated reading the payload, got %d", maxManifestBodySize, allocated)
+	}
+}
+
+// unreadableBody fails the test reading it.
+type unreadableBody struct {
+	t *testing.T
+}
+
+func (b unreadableBody) Read([]byte) (int, error) {
+	b.t.Error("unexpected read of the request body")
+	return 0, io.EOF
+}
+
+func TestReadManifestPayloadTooLarge(t *testing.T) {
+	// declared too large, rejected before reading
+	r := httptest.NewRequest(http.MethodPut, "/v2/foo/bar/manifests/latest", unreadableBody{t: t})
+	r.ContentLength = maxManifestBodySize + 1
+	if _, err := readManifestPayload(context.Background(), httptest.NewRecorder(), r); err == nil {
+		t.Fatal("expected error reading a payload declared too large")
+	}
+
+	// undeclared and too large, rejected while reading
+	r = httptest.NewRequest(http.MethodPut, "/v2/foo/bar/manifests/latest", bytes.NewReader(make([]byte, maxManifestBodySize+1)))
+	r.ContentLength = -1
+	_, err := readManifestPayload(context.Background(), httptest.NewRecorder(), r)
+	var maxBytesErr *http.MaxBytesError
+	if !errors.As(err, &maxBytesErr) {
+		t.Fatalf("expected a max bytes error reading an undeclared payload too large, got %v", err)
+	}
+}
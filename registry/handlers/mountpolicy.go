@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/distribution/distribution/v3/configuration"
+)
+
+// mountUserPlaceholder is replaced in mount rule patterns with the name of
+// the authenticated user.
+const mountUserPlaceholder = "{user}"
+
+// mountPolicy restricts the repositories blobs may be mounted from.
+type mountPolicy struct {
+	rules []configuration.MountRule
+}
+
+// newMountPolicy validates config and returns the mount policy it describes,
+// or nil if no policy is enforced.
+func newMountPolicy(config configuration.MountPolicy) (*mountPolicy, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+
+	for i, rule := range config.Rules {
+		if len(rule.From) == 0 {
+			return nil, fmt.Errorf("mount rule %d does not permit any repository", i)
+		}
+		for _, pattern := range rule.From {
+			if _, err := path.Match(strings.ReplaceAll(pattern, mountUserPlaceholder, "user"), ""); err != nil {
+				return nil, fmt.Errorf("invalid mount rule %d pattern %q: %w", i, pattern, err)
+			}
+		}
+	}
+
+	return &mountPolicy{rules: config.Rules}, nil
+}
+
+// allowed reports whether user may mount blobs from the repository named
+// from. Anonymous clients have an empty user name. A nil policy allows every
+// mount.
+func (p *mountPolicy) allowed(user, from string) bool {
+	if p == nil {
+		return true
+	}
+
+	for _, rule := range p.rules {
+		if len(rule.Users) > 0 && (user == "" || !slices.Contains(rule.Users, user)) {
+			continue
+		}
+		for _, pattern := range rule.From {
+			if strings.Contains(pattern, mountUserPlaceholder) {
+				if user == "" {
+					continue
+				}
+				pattern = strings.ReplaceAll(pattern, mountUserPlaceholder, escapeMatchPattern(user))
+			}
+			if ok, _ := path.Match(pattern, from); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// escapeMatchPattern escapes the characters of s which are special to
+// path.Match, so that user names cannot widen the patterns they are
+// substituted into.
+func escapeMatchPattern(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '\\', '*', '?', '[':
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
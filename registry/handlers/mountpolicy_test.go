@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/internal/client/auth/challenge"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/distribution/distribution/v3/registry/auth/token"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestMountPolicyAllowed(t *testing.T) {
+	policy, err := newMountPolicy(configuration.MountPolicy{
+		Enabled: true,
+		Rules: []configuration.MountRule{
+			{From: []string{"library/*"}},
+			{From: []string{"{user}/*"}},
+			{Users: []string{"ci"}, From: []string{"tenant-a/*", "tenant-b/*"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating mount policy: %v", err)
+	}
+
+	for _, tc := range []struct {
+		user    string
+		from    string
+		allowed bool
+	}{
+		{user: "", from: "library/alpine", allowed: true},
+		{user: "tenant-a", from: "library/alpine", allowed: true},
+		{user: "tenant-a", from: "tenant-a/app", allowed: true},
+		{user: "tenant-a", from: "tenant-a/nested/app", allowed: false},
+		{user: "tenant-a", from: "tenant-b/app", allowed: false},
+		{user: "", from: "tenant-a/app", allowed: false},
+		{user: "*", from: "tenant-a/app", allowed: false},
+		{user: "ci", from: "tenant-a/app", allowed: true},
+		{user: "ci", from: "tenant-b/app", allowed: true},
+		{user: "ci", from: "tenant-c/app", allowed: false},
+	} {
+		if allowed := policy.allowed(tc.user, tc.from); allowed != tc.allowed {
+			t.Errorf("user %q mounting from %q: expected allowed=%v, got %v", tc.user, tc.from, tc.allowed, allowed)
+		}
+	}
+
+	var nilPolicy *mountPolicy
+	if !nilPolicy.allowed("", "tenant-a/app") {
+		t.Error("expected a nil policy to allow every mount")
+	}
+}
+
+func TestMountPolicyInvalid(t *testing.T) {
+	for _, config := range []configuration.MountPolicy{
+		{Enabled: true, Rules: []configuration.MountRule{{Users: []string{"ci"}}}},
+		{Enabled: true, Rules: []configuration.MountRule{{From: []string{"[library/*"}}}},
+	} {
+		if _, err := newMountPolicy(config); err == nil {
+			t.Errorf("expected error for mount policy %+v", config)
+		}
+	}
+}
+
+func TestBlobMountPolicy(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory":    configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[any]any{"enabled": false}},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	config.Policy.Mount = configuration.MountPolicy{
+		Enabled: true,
+		Rules:   []configuration.MountRule{{From: []string{"shared/*"}}},
+	}
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	dgst := digest.FromString("some layer contents")
+	for _, name := range []string{"shared/base", "tenant-b/app"} {
+		imageName, _ := reference.WithName(name)
+		uploadURLBase, _ := startPushLayer(t, env, imageName)
+		pushLayer(t, env.builder, imageName, dgst, uploadURLBase, strings.NewReader("some layer contents"))
+	}
+
+	target, _ := reference.WithName("tenant-a/app")
+	mount := func(from string) *http.Response {
+		mountURL, err := env.builder.BuildBlobUploadURL(target, url.Values{
+			"mount": []string{dgst.String()},
+			"from":  []string{from},
+		})
+		checkErr(t, err, "building mount url")
+		resp, err := http.Post(mountURL, "", nil)
+		checkErr(t, err, "mounting blob")
+		return resp
+	}
+
+	resp := mount("shared/base")
+	defer resp.Body.Close()
+	checkResponse(t, "mounting from allowed repository", resp, http.StatusCreated)
+	checkHeaders(t, resp, http.Header{"Docker-Content-Digest": []string{dgst.String()}})
+
+	resp = mount("tenant-b/app")
+	defer resp.Body.Close()
+	checkResponse(t, "mounting from denied repository", resp, http.StatusForbidden)
+	checkBodyHasErrorCodes(t, "mounting from denied repository", resp, errcode.ErrorCodeDenied)
+}
+
+// TestBlobMountChallenge validates that the challenge of a cross-repository
+// mount requests the scopes of both repositories, so that clients obtain a
+// sufficient token at once.
+func TestBlobMountChallenge(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := newTokenTestEnv(t, key, false)
+	defer env.Shutdown()
+
+	ctx := context.Background()
+	source, _ := reference.WithName("shared/base")
+	repo, err := env.app.registry.Repository(ctx, source)
+	checkErr(t, err, "getting repository")
+	desc, err := repo.Blobs(ctx).Put(ctx, "application/octet-stream", []byte("some layer contents"))
+	checkErr(t, err, "putting blob")
+
+	target, _ := reference.WithName("tenant/app")
+	mountURL, err := env.builder.BuildBlobUploadURL(target, url.Values{
+		"mount": []string{desc.Digest.String()},
+		"from":  []string{source.Name()},
+	})
+	checkErr(t, err, "building mount url")
+	mount := func(bearer string) *http.Response {
+		req, err := http.NewRequest(http.MethodPost, mountURL, nil)
+		checkErr(t, err, "building mount request")
+		if bearer != "" {
+			req.Header.Set("Authorization", "Bearer "+bearer)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		checkErr(t, err, "mounting blob")
+		resp.Body.Close()
+		return resp
+	}
+	expectedScope := "repository:shared/base:pull repository:tenant/app:pull,push"
+
+	for _, tc := range []struct {
+		name   string
+		bearer string
+		err    string
+	}{
+		{name: "anonymous"},
+		{
+			name:   "insufficient token",
+			bearer: signToken(t, key, target.Name(), "pull", "push"),
+			err:    "insufficient_scope",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := mount(tc.bearer)
+			checkResponse(t, "mounting blob", resp, http.StatusUnauthorized)
+			challenges := challenge.ResponseChallenges(resp)
+			if len(challenges) != 1 {
+				t.Fatalf("expected a single challenge, got %v", challenges)
+			}
+			if scope := challenges[0].Parameters["scope"]; scope != expectedScope {
+				t.Fatalf("expected the challenge scope %q, got %q", expectedScope, scope)
+			}
+			if e := challenges[0].Parameters["error"]; e != tc.err {
+				t.Fatalf("expected the challenge error %q, got %q", tc.err, e)
+			}
+		})
+	}
+
+	// a single token granting the scopes of the challenge is sufficient
+	var access []*token.ResourceActions
+	for _, scope := range strings.Fields(expectedScope) {
+		parts := strings.SplitN(scope, ":", 3)
+		access = append(access, &token.ResourceActions{Type: parts[0], Name: parts[1], Actions: strings.Split(parts[2], ",")})
+	}
+	resp := mount(signAccessToken(t, key, access...))
+	checkResponse(t, "mounting blob with the challenged scopes", resp, http.StatusCreated)
+	checkHeaders(t, resp, http.Header{"Docker-Content-Digest": []string{desc.Digest.String()}})
+}
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/reference"
+)
+
+// requestedRepositoryHeader echoes the repository name requested by clients
+// when it was rewritten to another name.
+const requestedRepositoryHeader = "Docker-Requested-Repository"
+
+// namespaceRewriter maps the repository names requested by clients to the
+// names repositories are stored under.
+type namespaceRewriter struct {
+	rules []configuration.NamespaceRewrite
+}
+
+// newNamespaceRewriter validates config and returns the rewriter it
+// describes, or nil if no name is rewritten.
+func newNamespaceRewriter(config configuration.Compatibility) (*namespaceRewriter, error) {
+	if len(config.NamespaceRewrites) == 0 {
+		return nil, nil
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	for i, rule := range config.NamespaceRewrites {
+		for _, prefix := range []string{rule.From, rule.To} {
+			if _, err := reference.WithName(prefix); err != nil {
+				return nil, fmt.Errorf("rule %d: invalid prefix %q: %w", i, prefix, err)
+			}
+		}
+	}
+	return &namespaceRewriter{rules: config.NamespaceRewrites}, nil
+}
+
+// rewrite returns the name name is rewritten to by the rule matching it, if
+// any, and whether the rule rewrites writes. A nil rewriter matches no name.
+func (nr *namespaceRewriter) rewrite(name string) (string, bool, bool) {
+	if nr == nil {
+		return "", false, false
+	}
+	for _, rule := range nr.rules {
+		if name == rule.From {
+			return rule.To, rule.Writes, true
+		}
+		if rest, ok := strings.CutPrefix(name, rule.From+"/"); ok {
+			return rule.To + "/" + rest, rule.Writes, true
+		}
+	}
+	return "", false, false
+}
+
+// isWrite reports whether a request method modifies repositories.
+func isWrite(method string) bool {
+	return method != http.MethodGet && method != http.MethodHead
+}
@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/notifications"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/distribution/distribution/v3/registry/auth"
+	"github.com/distribution/reference"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestNamespaceRewrites(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		events []notifications.Event
+	)
+	sink := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var envelope struct {
+			Events []notifications.Event `json:"events"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&envelope); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		events = append(events, envelope.Events...)
+		mu.Unlock()
+	}))
+	defer sink.Close()
+
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory":    configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[any]any{"enabled": false}},
+		},
+		Compatibility: configuration.Compatibility{
+			NamespaceRewrites: []configuration.NamespaceRewrite{
+				{From: "oldteam", To: "platform/oldteam"},
+				{From: "legacy", To: "platform/legacy", Writes: true},
+			},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	config.Notifications.Endpoints = []configuration.Endpoint{
+		{Name: "sink", URL: sink.URL, Timeout: time.Second, Threshold: 3, Backoff: 100 * time.Millisecond},
+	}
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	// pulls through the old name resolve to the new one
+	oldName, _ := reference.WithName("oldteam/app")
+	newName, _ := reference.WithName("platform/oldteam/app")
+	dgst := pushPlatformImage(t, env, newName, "latest", "amd64")
+	if aliased := tagDigest(t, env, oldName, "latest"); aliased != dgst {
+		t.Fatalf("unexpected digest pulled through the old name: %s != %s", aliased, dgst)
+	}
+	headManifest := func(name reference.Named) *http.Response {
+		t.Helper()
+		tagRef, _ := reference.WithTag(name, "latest")
+		manifestURL, err := env.builder.BuildManifestURL(tagRef)
+		checkErr(t, err, "building manifest url")
+		req, err := http.NewRequest(http.MethodHead, manifestURL, nil)
+		checkErr(t, err, "building request")
+		req.Header.Set("Accept", v1.MediaTypeImageManifest)
+		resp, err := http.DefaultClient.Do(req)
+		checkErr(t, err, "fetching manifest")
+		resp.Body.Close()
+		checkResponse(t, "fetching manifest", resp, http.StatusOK)
+		return resp
+	}
+	resp := headManifest(oldName)
+	checkHeaders(t, resp, http.Header{
+		"Docker-Requested-Repository": []string{oldName.Name()},
+	})
+
+	// names outside the rewritten namespaces are unchanged
+	otherName, _ := reference.WithName("oldteamapp/app")
+	pushPlatformImage(t, env, otherName, "latest", "amd64")
+	resp = headManifest(otherName)
+	if v := resp.Header.Get("Docker-Requested-Repository"); v != "" {
+		t.Fatalf("unexpected requested repository header: %q", v)
+	}
+
+	// pushes through the old name are denied unless enabled
+	uploadURL, err := env.builder.BuildBlobUploadURL(oldName)
+	checkErr(t, err, "building upload url")
+	resp, err = http.Post(uploadURL, "", nil)
+	checkErr(t, err, "starting upload")
+	checkResponse(t, "starting upload through the old name", resp, http.StatusForbidden)
+	checkBodyHasErrorCodes(t, "starting upload through the old name", resp, errcode.ErrorCodeDenied)
+	resp.Body.Close()
+
+	legacyName, _ := reference.WithName("legacy/app")
+	legacyTarget, _ := reference.WithName("platform/legacy/app")
+	dgst = pushPlatformImage(t, env, legacyName, "latest", "arm64")
+	if stored := tagDigest(t, env, legacyTarget, "latest"); stored != dgst {
+		t.Fatalf("unexpected digest pushed through the old name: %s != %s", stored, dgst)
+	}
+
+	// events record both names
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		var found bool
+		mu.Lock()
+		for _, event := range events {
+			if event.Action == notifications.EventActionPush && event.Target.Tag == "latest" && event.Target.Repository == legacyTarget.Name() {
+				if event.Request.Repository != legacyName.Name() {
+					t.Fatalf("unexpected requested repository of push event: %q", event.Request.Repository)
+				}
+				found = true
+			}
+		}
+		mu.Unlock()
+		if found {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("no push event for the rewritten repository")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// TestNamespaceRewritesAuthorized checks that requests through a rewritten
+// name require access to the repository they are rewritten to, so that
+// aliases do not grant access to repositories clients may not reach directly.
+func TestNamespaceRewritesAuthorized(t *testing.T) {
+	if err := auth.Register("rewriteowner", func(options map[string]any) (auth.AccessController, error) {
+		owners, _ := options["owners"].(map[string]string)
+		return ownerAccessController{owners: owners}, nil
+	}); err != nil {
+		t.Fatalf("unexpected error registering access controller: %v", err)
+	}
+
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory":    configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[any]any{"enabled": false}},
+		},
+		Auth: configuration.Auth{
+			"rewriteowner": configuration.Parameters{"owners": map[string]string{"platform/oldteam/app": "alice"}},
+		},
+		Compatibility: configuration.Compatibility{
+			NamespaceRewrites: []configuration.NamespaceRewrite{
+				{From: "oldteam", To: "platform/oldteam"},
+			},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	oldName, _ := reference.WithName("oldteam/app")
+	tagsURL, err := env.builder.BuildTagsURL(oldName)
+	checkErr(t, err, "building tags url")
+	for _, tc := range []struct {
+		user   string
+		status int
+	}{
+		{user: "alice", status: http.StatusNotFound},
+		{user: "bob", status: http.StatusUnauthorized},
+	} {
+		req, err := http.NewRequest(http.MethodGet, tagsURL, nil)
+		checkErr(t, err, "building request")
+		req.SetBasicAuth(tc.user, "password")
+		resp, err := http.DefaultClient.Do(req)
+		checkErr(t, err, "listing tags")
+		checkResponse(t, "listing tags through the old name as "+tc.user, resp, tc.status)
+		resp.Body.Close()
+	}
+}
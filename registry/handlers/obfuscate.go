@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/distribution/distribution/v3/registry/auth"
+)
+
+// notFoundErrorCodes are the codes of the errors answering requests for
+// content missing from a repository, or for a missing repository.
+var notFoundErrorCodes = []errcode.ErrorCode{
+	errcode.ErrorCodeNameUnknown,
+	errcode.ErrorCodeManifestUnknown,
+	errcode.ErrorCodeBlobUnknown,
+}
+
+// errObfuscatedNotFound answers, when auth.obfuscatenotfound is enabled, both
+// the requests for missing content and the requests whose valid credentials
+// were denied the access, so that clients cannot tell the repositories they
+// may not access from the ones which do not exist.
+var errObfuscatedNotFound = errcode.ErrorCodeNameUnknown
+
+// obfuscateNotFound replaces errs with errObfuscatedNotFound if any of them
+// reports missing content.
+func obfuscateNotFound(errs errcode.Errors) errcode.Errors {
+	for _, err := range errs {
+		if coder, ok := err.(errcode.ErrorCoder); ok && slices.Contains(notFoundErrorCodes, coder.ErrorCode()) {
+			return errcode.Errors{errObfuscatedNotFound}
+		}
+	}
+	return errs
+}
+
+// serveObfuscatedRefusal answers a request on a repository refused by the
+// access controller when auth.obfuscatenotfound is enabled. Clients whose
+// valid credentials were denied the access are answered as if the repository
+// did not exist. Other clients, including the ones whose token does not cover
+// the access yet, are still challenged, as their refusal does not depend on
+// the repository. Neither response details the refused access.
+func serveObfuscatedRefusal(w http.ResponseWriter, r *http.Request, challenge auth.Challenge) error {
+	if errors.Is(challenge, auth.ErrAccessDenied) {
+		return errcode.ServeJSON(w, errObfuscatedNotFound)
+	}
+
+	challenge.SetHeaders(r, w)
+	return errcode.ServeJSON(w, errcode.ErrorCodeUnauthorized)
+}
@@ -0,0 +1,239 @@
+package handlers
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/registry/auth"
+	"github.com/distribution/distribution/v3/testutil"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// ownerAccessController authenticates clients as the user named in their
+// basic auth credentials, and grants them the repositories they own as well
+// as the ones nobody owns, like a token server letting users claim any
+// unused repository name would.
+type ownerAccessController struct {
+	owners map[string]string
+}
+
+// ownerChallenge refuses access, to anonymous clients or, if denied, to the
+// users not owning the repository.
+type ownerChallenge struct {
+	denied bool
+}
+
+func (ownerChallenge) Error() string {
+	return "access denied"
+}
+
+func (c ownerChallenge) Unwrap() error {
+	if c.denied {
+		return auth.ErrAccessDenied
+	}
+	return nil
+}
+
+func (ownerChallenge) SetHeaders(r *http.Request, w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Basic realm="test"`)
+}
+
+func (ac ownerAccessController) Authorized(r *http.Request, access ...auth.Access) (*auth.Grant, error) {
+	user, _, ok := r.BasicAuth()
+	if !ok {
+		return nil, ownerChallenge{}
+	}
+	for _, a := range access {
+		if owner, ok := ac.owners[a.Name]; ok && owner != user {
+			return nil, ownerChallenge{denied: true}
+		}
+	}
+	return &auth.Grant{User: auth.UserInfo{Name: user}}, nil
+}
+
+func TestObfuscateNotFound(t *testing.T) {
+	if err := auth.Register("repoowner", func(options map[string]any) (auth.AccessController, error) {
+		owners, _ := options["owners"].(map[string]string)
+		return ownerAccessController{owners: owners}, nil
+	}); err != nil {
+		t.Fatalf("unexpected error registering access controller: %v", err)
+	}
+
+	for _, obfuscate := range []bool{false, true} {
+		t.Run(fmt.Sprintf("obfuscatenotfound=%t", obfuscate), func(t *testing.T) {
+			config := configuration.Configuration{
+				Storage: configuration.Storage{
+					"inmemory":    configuration.Parameters{},
+					"maintenance": configuration.Parameters{"uploadpurging": map[any]any{"enabled": false}},
+				},
+				Auth: configuration.Auth{
+					"repoowner": configuration.Parameters{"owners": map[string]string{"bob/private": "bob"}},
+				},
+			}
+			if obfuscate {
+				config.Auth["obfuscatenotfound"] = configuration.Parameters{"enabled": true}
+			}
+			config.HTTP.Headers = headerConfig
+			env := newTestEnvWithConfig(t, &config)
+			defer env.Shutdown()
+
+			// bob/private exists and is owned by bob, bob/missing does not exist
+			ctx := context.Background()
+			private, _ := reference.WithName("bob/private")
+			missing, _ := reference.WithName("bob/missing")
+			repo, err := env.app.registry.Repository(ctx, private)
+			checkErr(t, err, "getting repository")
+			layers, err := testutil.CreateRandomLayers(1)
+			checkErr(t, err, "creating layers")
+			checkErr(t, testutil.UploadBlobs(repo, layers), "uploading layers")
+			var layer digest.Digest
+			for layer = range layers {
+			}
+			manifest, err := testutil.MakeSchema2Manifest(repo, []digest.Digest{layer})
+			checkErr(t, err, "making manifest")
+			manifests, err := repo.Manifests(ctx)
+			checkErr(t, err, "getting manifest service")
+			manifestDigest, err := manifests.Put(ctx, manifest)
+			checkErr(t, err, "putting manifest")
+			checkErr(t, repo.Tags(ctx).Tag(ctx, "latest", v1.Descriptor{Digest: manifestDigest}), "tagging manifest")
+
+			urls := map[string]func(name reference.Named) string{
+				"manifest": func(name reference.Named) string {
+					ref, _ := reference.WithTag(name, "latest")
+					u, err := env.builder.BuildManifestURL(ref)
+					checkErr(t, err, "building manifest url")
+					return u
+				},
+				"blob": func(name reference.Named) string {
+					ref, _ := reference.WithDigest(name, layer)
+					u, err := env.builder.BuildBlobURL(ref)
+					checkErr(t, err, "building blob url")
+					return u
+				},
+				"tags": func(name reference.Named) string {
+					u, err := env.builder.BuildTagsURL(name)
+					checkErr(t, err, "building tags url")
+					return u
+				},
+			}
+
+			type response struct {
+				status int
+				body   string
+			}
+			get := func(u, user string) response {
+				t.Helper()
+				req, err := http.NewRequest(http.MethodGet, u, nil)
+				checkErr(t, err, "building request")
+				if user != "" {
+					req.SetBasicAuth(user, "password")
+				}
+				resp, err := http.DefaultClient.Do(req)
+				checkErr(t, err, "sending request")
+				defer resp.Body.Close()
+				body, err := io.ReadAll(resp.Body)
+				checkErr(t, err, "reading response")
+				return response{status: resp.StatusCode, body: string(body)}
+			}
+
+			for route, url := range urls {
+				owner := get(url(private), "bob")
+				if owner.status != http.StatusOK {
+					t.Errorf("%s: expected owner to be granted access, got %d: %s", route, owner.status, owner.body)
+				}
+
+				forbidden := get(url(private), "alice")
+				nonexistent := get(url(missing), "alice")
+				anonymousPrivate := get(url(private), "")
+				anonymousMissing := get(url(missing), "")
+
+				if anonymousPrivate.status != http.StatusUnauthorized || anonymousMissing.status != http.StatusUnauthorized {
+					t.Errorf("%s: expected anonymous clients to be challenged, got %d and %d", route, anonymousPrivate.status, anonymousMissing.status)
+				}
+				if nonexistent.status != http.StatusNotFound {
+					t.Errorf("%s: expected nonexistent repository to be answered with %d, got %d", route, http.StatusNotFound, nonexistent.status)
+				}
+
+				if !obfuscate {
+					// the existence of bob/private leaks to alice
+					if forbidden.status != http.StatusUnauthorized {
+						t.Errorf("%s: expected forbidden access to be answered with %d, got %d", route, http.StatusUnauthorized, forbidden.status)
+					}
+					continue
+				}
+
+				if forbidden != nonexistent {
+					t.Errorf("%s: forbidden access answered with %d: %s, nonexistent repository with %d: %s",
+						route, forbidden.status, forbidden.body, nonexistent.status, nonexistent.body)
+				}
+				if anonymousPrivate != anonymousMissing {
+					t.Errorf("%s: anonymous access to an existing repository answered with %d: %s, to a nonexistent one with %d: %s",
+						route, anonymousPrivate.status, anonymousPrivate.body, anonymousMissing.status, anonymousMissing.body)
+				}
+			}
+		})
+	}
+}
+
+// TestObfuscateNotFoundTokenScope checks that token clients whose token does
+// not cover the repository yet are still challenged, while the ones denied
+// access by the token server are answered as if it did not exist.
+func TestObfuscateNotFoundTokenScope(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := tokenTestConfig(t, key)
+	config.Auth["obfuscatenotfound"] = configuration.Parameters{"enabled": true}
+	env := newTestEnvWithConfig(t, config)
+	defer env.Shutdown()
+
+	ctx := context.Background()
+	private, _ := reference.WithName("bob/private")
+	repo, err := env.app.registry.Repository(ctx, private)
+	checkErr(t, err, "getting repository")
+	desc, err := repo.Blobs(ctx).Put(ctx, "application/octet-stream", []byte("private contents"))
+	checkErr(t, err, "putting blob")
+	ref, _ := reference.WithDigest(private, desc.Digest)
+	blobURL, err := env.builder.BuildBlobURL(ref)
+	checkErr(t, err, "building blob url")
+
+	get := func(bearer string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, blobURL, nil)
+		checkErr(t, err, "building request")
+		req.Header.Set("Authorization", "Bearer "+bearer)
+		resp, err := http.DefaultClient.Do(req)
+		checkErr(t, err, "sending request")
+		return resp
+	}
+
+	// a token for another repository is challenged, so that the client asks
+	// the token server for the repository
+	resp := get(signToken(t, key, "alice/other", "pull"))
+	defer resp.Body.Close()
+	checkResponse(t, "fetching blob with a token for another repository", resp, http.StatusUnauthorized)
+	if header := resp.Header.Get("WWW-Authenticate"); !strings.Contains(header, `error="insufficient_scope"`) {
+		t.Fatalf("expected an insufficient_scope challenge, got %q", header)
+	}
+
+	// a token listing the repository without any action was denied it
+	resp = get(signToken(t, key, private.Name()))
+	defer resp.Body.Close()
+	checkResponse(t, "fetching blob with a denied token", resp, http.StatusNotFound)
+	checkBodyHasErrorCodes(t, "fetching blob with a denied token", resp, errObfuscatedNotFound)
+
+	resp = get(signToken(t, key, private.Name(), "pull"))
+	defer resp.Body.Close()
+	checkResponse(t, "fetching blob with a granted token", resp, http.StatusOK)
+}
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/notifications"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/distribution/reference"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// preverifiedDigestHeader is set to "true" by trusted clients completing an
+// upload whose digest they have already computed.
+const preverifiedDigestHeader = "Docker-Content-Digest-Preverified"
+
+// preverifiedDigest reports whether the upload completed by r may skip the
+// hashing of its content: the client must assert it through the header and
+// be authenticated as one of the trusted users. The header is ignored for
+// every other client.
+func (app *App) preverifiedDigest(ctx context.Context, r *http.Request) bool {
+	if r.Method != http.MethodPut || !strings.EqualFold(r.Header.Get(preverifiedDigestHeader), "true") {
+		return false
+	}
+
+	user := dcontext.GetStringValue(ctx, userNameKey)
+	return user != "" && slices.Contains(app.Config.Policy.PreverifiedDigest.Users, user)
+}
+
+// preverifiedBridgeKey holds the event bridge of the request completing a
+// pre-verified upload, used to notify the quarantine of the blob.
+type preverifiedBridgeKey struct{}
+
+// markPreverifiedDigest marks ctx so that the upload it completes skips the
+// hashing of its content.
+func (app *App) markPreverifiedDigest(ctx *Context, r *http.Request) {
+	bridgeCtx := context.WithValue(ctx.Context, preverifiedBridgeKey{}, app.eventBridge(ctx, r))
+	ctx.Context = storage.WithPreverifiedDigest(bridgeCtx)
+}
+
+// preverifiedDigestMismatch reports a pre-verified blob found not to match
+// its digest, once it has been removed. ctx is the context of the request
+// which completed the upload.
+func (app *App) preverifiedDigestMismatch(ctx context.Context, repo reference.Named, desc v1.Descriptor) {
+	dcontext.GetLoggerWithFields(ctx, map[any]any{
+		"digest":    desc.Digest,
+		"size":      desc.Size,
+		"vars.name": repo.Name(),
+	}).Error("CRITICAL: blob pushed with a pre-verified digest did not match it and was removed")
+
+	if bridge, ok := ctx.Value(preverifiedBridgeKey{}).(notifications.BlobQuarantineListener); ok {
+		if err := bridge.BlobQuarantined(repo, desc); err != nil {
+			dcontext.GetLogger(ctx).Errorf("unable to notify blob quarantine: %v", err)
+		}
+	}
+}
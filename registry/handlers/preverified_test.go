@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/notifications"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/distribution/distribution/v3/registry/auth"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// basicUserAccessController grants every request, authenticating clients as
+// the user named in their basic auth credentials.
+type basicUserAccessController struct{}
+
+func (basicUserAccessController) Authorized(r *http.Request, access ...auth.Access) (*auth.Grant, error) {
+	user, _, _ := r.BasicAuth()
+	return &auth.Grant{User: auth.UserInfo{Name: user}}, nil
+}
+
+func TestPreverifiedDigest(t *testing.T) {
+	if err := auth.Register("basicuser", func(map[string]any) (auth.AccessController, error) {
+		return basicUserAccessController{}, nil
+	}); err != nil {
+		t.Fatalf("unexpected error registering access controller: %v", err)
+	}
+
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory":    configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[any]any{"enabled": false}},
+		},
+		Auth: configuration.Auth{"basicuser": configuration.Parameters{}},
+	}
+	config.HTTP.Headers = headerConfig
+	config.Policy.PreverifiedDigest.Users = []string{"builder"}
+
+	quarantined := make(chan struct{}, 1)
+	sink := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var envelope struct {
+			Events []notifications.Event `json:"events"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&envelope); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		for _, event := range envelope.Events {
+			if event.Action == notifications.EventActionQuarantine {
+				select {
+				case quarantined <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}))
+	defer sink.Close()
+	config.Notifications.Endpoints = []configuration.Endpoint{{
+		Name: "sink", URL: sink.URL, Timeout: time.Second, Threshold: 3, Backoff: 100 * time.Millisecond,
+	}}
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/bar")
+	do := func(method, u, user string, body io.Reader, header http.Header) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(method, u, body)
+		checkErr(t, err, "building request")
+		req.SetBasicAuth(user, "password")
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		resp, err := http.DefaultClient.Do(req)
+		checkErr(t, err, "sending request")
+		return resp
+	}
+	push := func(user, contents string, dgst digest.Digest) *http.Response {
+		t.Helper()
+		uploadURL, err := env.builder.BuildBlobUploadURL(imageName)
+		checkErr(t, err, "building upload url")
+		resp := do(http.MethodPost, uploadURL, user, nil, nil)
+		resp.Body.Close()
+		checkResponse(t, "starting upload", resp, http.StatusAccepted)
+
+		u, err := url.Parse(resp.Header.Get("Location"))
+		checkErr(t, err, "parsing upload location")
+		q := u.Query()
+		q.Set("digest", dgst.String())
+		u.RawQuery = q.Encode()
+		return do(http.MethodPut, u.String(), user, strings.NewReader(contents), http.Header{
+			preverifiedDigestHeader: []string{"true"},
+		})
+	}
+	blobURL := func(dgst digest.Digest) string {
+		ref, _ := reference.WithDigest(imageName, dgst)
+		u, err := env.builder.BuildBlobURL(ref)
+		checkErr(t, err, "building blob url")
+		return u
+	}
+
+	// trusted user pushing content matching its digest
+	dgst := digest.FromString("trusted contents")
+	resp := push("builder", "trusted contents", dgst)
+	resp.Body.Close()
+	checkResponse(t, "completing pre-verified upload", resp, http.StatusCreated)
+	checkHeaders(t, resp, http.Header{"Docker-Content-Digest": []string{dgst.String()}})
+
+	// the blob is linked before the upload completes, so that it is served
+	// and may be referenced by manifests right away
+	resp = do(http.MethodGet, blobURL(dgst), "builder", nil, nil)
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	checkErr(t, err, "reading blob")
+	checkResponse(t, "fetching pre-verified blob", resp, http.StatusOK)
+	if string(body) != "trusted contents" {
+		t.Fatalf("unexpected blob contents: %q", body)
+	}
+	imageConfig := []byte("{}")
+	configDigest := digest.FromBytes(imageConfig)
+	uploadURLBase, _ := startPushLayer(t, env, imageName)
+	pushLayer(t, env.builder, imageName, configDigest, uploadURLBase, bytes.NewReader(imageConfig))
+	manifest := &ocischema.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: v1.MediaTypeImageManifest,
+		Config:    v1.Descriptor{MediaType: v1.MediaTypeImageConfig, Digest: configDigest, Size: int64(len(imageConfig))},
+		Layers:    []v1.Descriptor{{MediaType: v1.MediaTypeImageLayer, Digest: dgst, Size: int64(len("trusted contents"))}},
+	}
+	tagRef, _ := reference.WithTag(imageName, "latest")
+	manifestURL, err := env.builder.BuildManifestURL(tagRef)
+	checkErr(t, err, "building manifest url")
+	resp = putManifest(t, "putting manifest referencing the pre-verified blob", manifestURL, v1.MediaTypeImageManifest, manifest)
+	resp.Body.Close()
+	checkResponse(t, "putting manifest referencing the pre-verified blob", resp, http.StatusCreated)
+
+	// untrusted users have the header ignored and their content hashed
+	dgst = digest.FromString("claimed contents")
+	resp = push("someone", "tampered contents", dgst)
+	defer resp.Body.Close()
+	checkResponse(t, "completing untrusted upload", resp, http.StatusBadRequest)
+	checkBodyHasErrorCodes(t, "completing untrusted upload", resp, errcode.ErrorCodeDigestInvalid)
+
+	// trusted user pushing content not matching its digest, which is
+	// accepted then removed in the background
+	resp = push("builder", "tampered contents", dgst)
+	resp.Body.Close()
+	checkResponse(t, "completing mismatched pre-verified upload", resp, http.StatusCreated)
+
+	select {
+	case <-quarantined:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the quarantine of the mismatched blob")
+	}
+	resp = do(http.MethodHead, blobURL(dgst), "builder", nil, nil)
+	resp.Body.Close()
+	checkResponse(t, "fetching mismatched pre-verified blob", resp, http.StatusNotFound)
+}
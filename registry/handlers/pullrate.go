@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	prometheus "github.com/distribution/distribution/v3/metrics"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"golang.org/x/time/rate"
+)
+
+// pullSweepInterval is how often the buckets of idle clients are dropped.
+const pullSweepInterval = time.Minute
+
+// defaultPullTier is the tier label of the clients limited to the default
+// rate.
+const defaultPullTier = "default"
+
+// pullRejections is the number of manifest pulls rejected by rate limits
+var pullRejections = prometheus.HTTPNamespace.NewLabeledCounter("pull_rejections", "The number of manifest pulls rejected by rate limits", "tier")
+
+// pullLimiter limits the rate of manifest pulls with a token bucket per
+// client. The rate of a client depends on its tier, read from a claim of its
+// credential.
+type pullLimiter struct {
+	claim string
+	base  pullRate
+	tiers map[string]pullRate
+
+	mu        sync.Mutex
+	buckets   map[pullKey]*pullBucket
+	lastSweep time.Time
+}
+
+type pullRate struct {
+	limit rate.Limit
+	burst int
+}
+
+// pullKey identifies a token bucket. Clients changing tier get a new
+// bucket.
+type pullKey struct {
+	client string
+	tier   string
+}
+
+type pullBucket struct {
+	limiter *rate.Limiter
+	// full is when the bucket is full again, after which it can be
+	// dropped without loss.
+	full time.Time
+}
+
+// newPullRate validates config and returns the rate it describes.
+func newPullRate(config configuration.PullRate) (pullRate, error) {
+	if config.Rate <= 0 || math.IsInf(config.Rate, 0) || math.IsNaN(config.Rate) {
+		return pullRate{}, errors.New("rate must be positive")
+	}
+	if config.Burst < 0 {
+		return pullRate{}, errors.New("burst must not be negative")
+	}
+
+	burst := config.Burst
+	if burst == 0 {
+		burst = max(1, int(math.Ceil(config.Rate)))
+	}
+	return pullRate{limit: rate.Limit(config.Rate), burst: burst}, nil
+}
+
+// newPullLimiter validates config and returns the limiter it describes, or
+// nil if no rate is limited.
+func newPullLimiter(config configuration.PullPolicy) (*pullLimiter, error) {
+	if config.Default == (configuration.PullRate{}) && len(config.Tiers) == 0 {
+		return nil, nil
+	}
+
+	base, err := newPullRate(config.Default)
+	if err != nil {
+		return nil, fmt.Errorf("default: %w", err)
+	}
+	if len(config.Tiers) > 0 && config.Claim == "" {
+		return nil, errors.New("tiers require a claim")
+	}
+
+	l := &pullLimiter{
+		claim:   config.Claim,
+		base:    base,
+		tiers:   make(map[string]pullRate, len(config.Tiers)),
+		buckets: make(map[pullKey]*pullBucket),
+	}
+	for tier, tierConfig := range config.Tiers {
+		if tier == "" || tier == defaultPullTier {
+			return nil, fmt.Errorf("invalid tier %q", tier)
+		}
+		tierRate, err := newPullRate(tierConfig)
+		if err != nil {
+			return nil, fmt.Errorf("tier %q: %w", tier, err)
+		}
+		l.tiers[tier] = tierRate
+	}
+	return l, nil
+}
+
+// tier returns the tier of a client with claims, and its rate. Clients
+// without a listed tier get the default rate.
+func (l *pullLimiter) tier(claims map[string]any) (string, pullRate) {
+	if tier, ok := claims[l.claim].(string); ok {
+		if tierRate, ok := l.tiers[tier]; ok {
+			return tier, tierRate
+		}
+	}
+	return defaultPullTier, l.base
+}
+
+// reserve takes a token from the bucket of client in tier at now. If the
+// bucket is empty, it returns false and how long to wait for a token.
+func (l *pullLimiter) reserve(client, tier string, tierRate pullRate, now time.Time) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) >= pullSweepInterval {
+		for key, bucket := range l.buckets {
+			if !now.Before(bucket.full) {
+				delete(l.buckets, key)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	key := pullKey{client: client, tier: tier}
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &pullBucket{limiter: rate.NewLimiter(tierRate.limit, tierRate.burst)}
+		l.buckets[key] = bucket
+	}
+
+	reservation := bucket.limiter.ReserveN(now, 1)
+	if delay := reservation.DelayFrom(now); delay > 0 {
+		reservation.CancelAt(now)
+		return delay, false
+	}
+
+	missing := float64(tierRate.burst) - bucket.limiter.TokensAt(now)
+	bucket.full = now.Add(time.Duration(missing / float64(tierRate.limit) * float64(time.Second)))
+	return 0, true
+}
+
+// limit wraps handler so that it is only served if the bucket of the client
+// of the request holds a token. Other requests are rejected with 429 Too
+// Many Requests. A nil limiter returns handler unchanged.
+func (l *pullLimiter) limit(ctx *Context, handler http.Handler) http.Handler {
+	if l == nil {
+		return handler
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client := transferClient(ctx, r)
+		tier, tierRate := l.tier(authorizedClaims(ctx))
+		retryAfter, ok := l.reserve(client, tier, tierRate, time.Now())
+		if !ok {
+			pullRejections.WithValues(tier).Inc(1)
+			dcontext.GetLogger(ctx).Warnf("rejecting manifest pull from %s of tier %s: rate limit reached", client, tier)
+			w.Header().Set("Retry-After", strconv.Itoa(max(1, int(math.Ceil(retryAfter.Seconds())))))
+			ctx.Errors = append(ctx.Errors, errcode.ErrorCodeTooManyRequests.WithDetail("pull rate limit reached"))
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/manifest/schema2"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/distribution/distribution/v3/registry/auth"
+	"github.com/distribution/reference"
+)
+
+// tierClaimsAccessController grants every request, authenticating clients as
+// the user named in their basic auth credentials, with their password as the
+// tier claim.
+type tierClaimsAccessController struct{}
+
+func (tierClaimsAccessController) Authorized(r *http.Request, access ...auth.Access) (*auth.Grant, error) {
+	user, tier, ok := r.BasicAuth()
+	if !ok {
+		return &auth.Grant{}, nil
+	}
+	return &auth.Grant{User: auth.UserInfo{Name: user}, Claims: map[string]any{"tier": tier}}, nil
+}
+
+func TestPullLimiterReserve(t *testing.T) {
+	limiter, err := newPullLimiter(configuration.PullPolicy{
+		Default: configuration.PullRate{Rate: 0.5},
+		Claim:   "tier",
+		Tiers: map[string]configuration.PullRate{
+			"pro": {Rate: 1, Burst: 3},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating limiter: %v", err)
+	}
+
+	now := time.Now()
+	for _, tc := range []struct {
+		client  string
+		claims  map[string]any
+		after   time.Duration
+		allowed bool
+	}{
+		// the burst of the tier applies
+		{client: "user:alice", claims: map[string]any{"tier": "pro"}, allowed: true},
+		{client: "user:alice", claims: map[string]any{"tier": "pro"}, allowed: true},
+		{client: "user:alice", claims: map[string]any{"tier": "pro"}, allowed: true},
+		{client: "user:alice", claims: map[string]any{"tier": "pro"}, allowed: false},
+		// the bucket refills at the rate of the tier
+		{client: "user:alice", claims: map[string]any{"tier": "pro"}, after: time.Second, allowed: true},
+		{client: "user:alice", claims: map[string]any{"tier": "pro"}, after: time.Second, allowed: false},
+		// clients without a listed tier get the default rate, whose burst
+		// defaults to the rate, and at least 1
+		{client: "user:bob", allowed: true},
+		{client: "user:bob", allowed: false},
+		{client: "user:bob", after: 2 * time.Second, allowed: true},
+		{client: "user:carol", claims: map[string]any{"tier": "unknown"}, allowed: true},
+		{client: "user:carol", claims: map[string]any{"tier": "unknown"}, allowed: false},
+		{client: "user:dave", claims: map[string]any{"tier": 1}, allowed: true},
+		{client: "user:dave", claims: map[string]any{"tier": 1}, allowed: false},
+		// clients changing tier get a new bucket
+		{client: "user:bob", claims: map[string]any{"tier": "pro"}, allowed: true},
+		// clients have their own bucket
+		{client: "ip:192.0.2.1", allowed: true},
+	} {
+		tier, tierRate := limiter.tier(tc.claims)
+		retryAfter, allowed := limiter.reserve(tc.client, tier, tierRate, now.Add(tc.after))
+		if allowed != tc.allowed {
+			t.Fatalf("pull by %s with claims %v after %v: expected allowed=%v, got %v", tc.client, tc.claims, tc.after, tc.allowed, allowed)
+		}
+		if !allowed && retryAfter <= 0 {
+			t.Fatalf("pull by %s with claims %v after %v: expected a retry delay, got %v", tc.client, tc.claims, tc.after, retryAfter)
+		}
+	}
+
+	// full buckets are dropped
+	limiter.reserve("user:bob", defaultPullTier, limiter.base, now.Add(time.Hour))
+	if _, ok := limiter.buckets[pullKey{client: "ip:192.0.2.1", tier: defaultPullTier}]; ok {
+		t.Fatal("expected idle buckets to be dropped")
+	}
+}
+
+func TestPullLimiterInvalid(t *testing.T) {
+	if limiter, err := newPullLimiter(configuration.PullPolicy{}); limiter != nil || err != nil {
+		t.Fatalf("expected no limiter without rates, got %v, %v", limiter, err)
+	}
+
+	for _, config := range []configuration.PullPolicy{
+		{Default: configuration.PullRate{Burst: 1}},
+		{Default: configuration.PullRate{Rate: -1}},
+		{Default: configuration.PullRate{Rate: 1, Burst: -1}},
+		{Claim: "tier", Tiers: map[string]configuration.PullRate{"pro": {Rate: 1}}},
+		{Default: configuration.PullRate{Rate: 1}, Tiers: map[string]configuration.PullRate{"pro": {Rate: 1}}},
+		{Default: configuration.PullRate{Rate: 1}, Claim: "tier", Tiers: map[string]configuration.PullRate{"pro": {}}},
+		{Default: configuration.PullRate{Rate: 1}, Claim: "tier", Tiers: map[string]configuration.PullRate{defaultPullTier: {Rate: 1}}},
+	} {
+		if _, err := newPullLimiter(config); err == nil {
+			t.Errorf("expected error for pull policy %+v", config)
+		}
+	}
+}
+
+func TestPullRateLimit(t *testing.T) {
+	if err := auth.Register("tierclaims", func(map[string]any) (auth.AccessController, error) {
+		return tierClaimsAccessController{}, nil
+	}); err != nil {
+		t.Fatalf("unexpected error registering access controller: %v", err)
+	}
+
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory":    configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[any]any{"enabled": false}},
+		},
+		Auth: configuration.Auth{"tierclaims": configuration.Parameters{}},
+	}
+	config.Policy.Pulls = configuration.PullPolicy{
+		Default: configuration.PullRate{Rate: 0.01, Burst: 1},
+		Claim:   "tier",
+		Tiers: map[string]configuration.PullRate{
+			"pro":        {Rate: 0.01, Burst: 3},
+			"enterprise": {Rate: 0.01, Burst: 5},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	name, _ := reference.WithName("foo/bar")
+	createRepository(env, t, name.Name(), "latest")
+	latestRef, _ := reference.WithTag(name, "latest")
+	manifestURL, err := env.builder.BuildManifestURL(latestRef)
+	checkErr(t, err, "building manifest url")
+
+	pull := func(method, user, tier string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(method, manifestURL, nil)
+		checkErr(t, err, "building manifest request")
+		req.Header.Set("Accept", schema2.MediaTypeManifest)
+		if user != "" {
+			req.SetBasicAuth(user, tier)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		checkErr(t, err, "fetching manifest")
+		return resp
+	}
+
+	for _, tc := range []struct {
+		user, tier string
+		allowed    int
+	}{
+		{user: "alice", tier: "free", allowed: 1},
+		{user: "bob", tier: "pro", allowed: 3},
+		{user: "carol", tier: "enterprise", allowed: 5},
+	} {
+		for i := range tc.allowed + 1 {
+			resp := pull(http.MethodGet, tc.user, tc.tier)
+			resp.Body.Close()
+			if i < tc.allowed {
+				checkResponse(t, "pulling manifest of tier "+tc.tier+" within the limit", resp, http.StatusOK)
+				continue
+			}
+			checkResponse(t, "pulling manifest of tier "+tc.tier+" over the limit", resp, http.StatusTooManyRequests)
+			if retryAfter, err := strconv.Atoi(resp.Header.Get("Retry-After")); err != nil || retryAfter < 1 {
+				t.Fatalf("expected a Retry-After delay, got %q", resp.Header.Get("Retry-After"))
+			}
+		}
+	}
+
+	resp := pull(http.MethodGet, "alice", "free")
+	defer resp.Body.Close()
+	checkBodyHasErrorCodes(t, "pulling manifest over the limit", resp, errcode.ErrorCodeTooManyRequests)
+
+	// HEAD requests are not limited
+	resp = pull(http.MethodHead, "alice", "free")
+	resp.Body.Close()
+	checkResponse(t, "probing manifest over the limit", resp, http.StatusOK)
+}
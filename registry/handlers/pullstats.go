@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"net/http"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	"github.com/distribution/distribution/v3/registry/pullstats"
+	"github.com/gorilla/handlers"
+)
+
+// configurePullStats starts counting pulls, in redis if configured and in
+// memory flushed to the storage driver otherwise, and serves the stats
+// endpoint.
+func (app *App) configurePullStats(configuration *configuration.Configuration) {
+	config := configuration.PullStats
+	if !config.Enabled {
+		return
+	}
+	if config.FlushInterval < 0 || config.QueueSize < 0 {
+		panic("pullstats: flushinterval and queuesize must not be negative")
+	}
+
+	var store pullstats.Store
+	if app.redis != nil {
+		store = pullstats.NewRedisStore(app.redis, config.MaxAge)
+		dcontext.GetLogger(app).Info("counting pulls in redis")
+	} else {
+		driverStore := pullstats.NewDriverStore(app, app.driver, config.FlushInterval, config.MaxAge)
+		app.flushPullStats = driverStore.Flush
+		store = driverStore
+		dcontext.GetLogger(app).Info("counting pulls in memory, flushed to storage")
+	}
+	app.pullStats = pullstats.NewRecorder(app, store, config.QueueSize)
+
+	registry := expvar.Get("registry")
+	if registry == nil {
+		registry = expvar.NewMap("registry")
+	}
+	recorder := app.pullStats
+	registry.(*expvar.Map).Set("pullstats", expvar.Func(func() any {
+		return map[string]any{
+			"Dropped": recorder.Dropped(),
+		}
+	}))
+
+	app.register(v2.RouteNameStats, pullStatsDispatcher)
+}
+
+// recordPull counts a pull of reference from the repository of the request,
+// if pull stats are enabled.
+func (ctx *Context) recordPull(kind pullstats.Kind, reference string) {
+	if ctx.pullStats == nil || ctx.Repository == nil {
+		return
+	}
+	ctx.pullStats.Record(ctx.Repository.Named().Name(), kind, reference)
+}
+
+// closePullStats counts the queued pulls and flushes the counters kept in
+// memory.
+func (app *App) closePullStats(ctx context.Context) error {
+	if app.pullStats == nil {
+		return nil
+	}
+	app.pullStats.Close()
+	if app.flushPullStats != nil {
+		return app.flushPullStats(ctx)
+	}
+	return nil
+}
+
+// pullStatsDispatcher constructs the pull stats handler.
+func pullStatsDispatcher(ctx *Context, r *http.Request) http.Handler {
+	pullStatsHandler := &pullStatsHandler{
+		Context: ctx,
+	}
+
+	return handlers.MethodHandler{
+		http.MethodGet: http.HandlerFunc(pullStatsHandler.GetStats),
+	}
+}
+
+// pullStatsHandler serves the pull stats of a repository.
+type pullStatsHandler struct {
+	*Context
+}
+
+// GetStats returns the pulls counted for the repository.
+func (ph *pullStatsHandler) GetStats(w http.ResponseWriter, r *http.Request) {
+	stats, err := ph.pullStats.Stats(ph, ph.Repository.Named().Name())
+	if err != nil {
+		ph.Errors = append(ph.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(stats); err != nil {
+		ph.Errors = append(ph.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+}
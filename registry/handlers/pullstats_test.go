@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/manifest/schema2"
+	"github.com/distribution/distribution/v3/registry/pullstats"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+)
+
+// pullImage fetches the manifest of ref and its config blob, returning the
+// digest of the config.
+func pullImage(t *testing.T, env *testEnv, ref reference.Named) digest.Digest {
+	t.Helper()
+
+	manifestURL, err := env.builder.BuildManifestURL(ref)
+	checkErr(t, err, "building manifest url")
+	req, err := http.NewRequest(http.MethodGet, manifestURL, nil)
+	checkErr(t, err, "building manifest request")
+	req.Header.Set("Accept", schema2.MediaTypeManifest)
+	resp, err := http.DefaultClient.Do(req)
+	checkErr(t, err, "fetching manifest")
+	defer resp.Body.Close()
+	checkResponse(t, "fetching manifest", resp, http.StatusOK)
+
+	var manifest schema2.Manifest
+	if err := json.NewDecoder(resp.Body).Decode(&manifest); err != nil {
+		t.Fatalf("error decoding manifest: %v", err)
+	}
+
+	configRef, _ := reference.WithDigest(ref, manifest.Config.Digest)
+	blobURL, err := env.builder.BuildBlobURL(configRef)
+	checkErr(t, err, "building blob url")
+	resp, err = http.Get(blobURL)
+	checkErr(t, err, "fetching blob")
+	resp.Body.Close()
+	checkResponse(t, "fetching blob", resp, http.StatusOK)
+
+	return manifest.Config.Digest
+}
+
+// waitForStats polls the stats of name until they match the expected totals
+// of manifest and blob pulls.
+func waitForStats(t *testing.T, env *testEnv, name reference.Named, manifests, blobs map[string]int64) *pullstats.RepositoryStats {
+	t.Helper()
+
+	statsURL, err := env.builder.BuildStatsURL(name)
+	checkErr(t, err, "building stats url")
+
+	var stats pullstats.RepositoryStats
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		resp, err := http.Get(statsURL)
+		checkErr(t, err, "fetching stats")
+		checkResponse(t, "fetching stats", resp, http.StatusOK)
+		stats = pullstats.RepositoryStats{}
+		err = json.NewDecoder(resp.Body).Decode(&stats)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("error decoding stats: %v", err)
+		}
+
+		if totals(stats.Manifests, manifests) && totals(stats.Blobs, blobs) {
+			return &stats
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("unexpected stats %+v, expected manifest pulls %v and blob pulls %v", stats, manifests, blobs)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func totals(counts map[string]*pullstats.Counts, expected map[string]int64) bool {
+	if len(counts) != len(expected) {
+		return false
+	}
+	for reference, total := range expected {
+		if c, ok := counts[reference]; !ok || c.Total != total {
+			return false
+		}
+	}
+	return true
+}
+
+func newPullStatsTestEnv(t *testing.T, redisAddr string) *testEnv {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory":    configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[any]any{"enabled": false}},
+		},
+		PullStats: configuration.PullStats{Enabled: true},
+	}
+	config.HTTP.Headers = headerConfig
+	if redisAddr != "" {
+		config.Redis.Options.Addrs = []string{redisAddr}
+	}
+	return newTestEnvWithConfig(t, &config)
+}
+
+func TestPullStats(t *testing.T) {
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	for _, tc := range []struct {
+		name      string
+		redisAddr string
+	}{
+		{name: "storage"},
+		{name: "redis", redisAddr: server.Addr()},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			env := newPullStatsTestEnv(t, tc.redisAddr)
+			defer env.Shutdown()
+
+			name, _ := reference.WithName("foo/" + tc.name)
+			dgst := createRepository(env, t, name.Name(), "latest")
+			tagRef, _ := reference.WithTag(name, "latest")
+			digestRef, _ := reference.WithDigest(name, dgst)
+
+			var config digest.Digest
+			for range 3 {
+				config = pullImage(t, env, tagRef)
+			}
+			pullImage(t, env, digestRef)
+
+			stats := waitForStats(t, env, name, map[string]int64{
+				"latest":      3,
+				dgst.String(): 1,
+			}, map[string]int64{
+				config.String(): 4,
+			})
+			day := time.Now().UTC().Format("2006-01-02")
+			if stats.Name != name.Name() || stats.Total != 8 || stats.Daily[day] != 8 {
+				t.Fatalf("unexpected repository stats: %+v", stats)
+			}
+		})
+	}
+}
+
+func TestPullStatsBackendErrors(t *testing.T) {
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := newPullStatsTestEnv(t, server.Addr())
+	defer env.Shutdown()
+
+	name, _ := reference.WithName("foo/bar")
+	createRepository(env, t, name.Name(), "latest")
+	tagRef, _ := reference.WithTag(name, "latest")
+
+	server.Close()
+
+	// pulls are served while the stats cannot be counted
+	for range 3 {
+		pullImage(t, env, tagRef)
+	}
+
+	deadline := time.Now().Add(30 * time.Second)
+	for env.app.pullStats.Dropped() < 6 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected pulls to be dropped, got %d dropped", env.app.pullStats.Dropped())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	statsURL, err := env.builder.BuildStatsURL(name)
+	checkErr(t, err, "building stats url")
+	resp, err := http.Get(statsURL)
+	checkErr(t, err, "fetching stats")
+	resp.Body.Close()
+	checkResponse(t, "fetching stats of unavailable backend", resp, http.StatusInternalServerError)
+}
+
+func TestPullStatsDisabled(t *testing.T) {
+	env := newTestEnv(t, false)
+	defer env.Shutdown()
+
+	name, _ := reference.WithName("foo/bar")
+	createRepository(env, t, name.Name(), "latest")
+
+	statsURL, err := env.builder.BuildStatsURL(name)
+	checkErr(t, err, "building stats url")
+	resp, err := http.Get(statsURL)
+	checkErr(t, err, "fetching stats")
+	resp.Body.Close()
+	checkResponse(t, "fetching stats when disabled", resp, http.StatusNotFound)
+}
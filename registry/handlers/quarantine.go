@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	prometheus "github.com/distribution/distribution/v3/metrics"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+)
+
+// The actions applied to the pulls of quarantined manifests.
+const (
+	quarantineActionDeny = "deny"
+	quarantineActionWarn = "warn"
+)
+
+// quarantinedPulls counts the pulls of quarantined manifests, by action.
+var quarantinedPulls = prometheus.HTTPNamespace.NewLabeledCounter("quarantined_pulls", "The number of pulls of quarantined manifests", "action")
+
+// quarantine blocks the pulls of the manifests flagged by a referrer of one
+// of the artifact types of the rule matching their repository.
+type quarantine struct {
+	rules []quarantineRule
+}
+
+type quarantineRule struct {
+	repositories  []string
+	artifactTypes []string
+	action        string
+}
+
+// newQuarantine validates config and returns the quarantine it describes,
+// or nil if no manifest is quarantined.
+func newQuarantine(config configuration.QuarantinePolicy) (*quarantine, error) {
+	if len(config.Rules) == 0 {
+		return nil, nil
+	}
+
+	q := &quarantine{}
+	for i, rule := range config.Rules {
+		if len(rule.Repositories) == 0 {
+			return nil, fmt.Errorf("rule %d does not match any repository", i)
+		}
+		for _, pattern := range rule.Repositories {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid rule %d pattern %q: %w", i, pattern, err)
+			}
+		}
+		if len(rule.ArtifactTypes) == 0 {
+			return nil, fmt.Errorf("rule %d has no artifact type", i)
+		}
+
+		action := rule.Action
+		switch action {
+		case "":
+			action = quarantineActionDeny
+		case quarantineActionDeny, quarantineActionWarn:
+		default:
+			return nil, fmt.Errorf("rule %d action must be %q or %q, %q invalid", i, quarantineActionDeny, quarantineActionWarn, action)
+		}
+		q.rules = append(q.rules, quarantineRule{
+			repositories:  rule.Repositories,
+			artifactTypes: rule.ArtifactTypes,
+			action:        action,
+		})
+	}
+	return q, nil
+}
+
+// rule returns the rule applying to repository, or nil if its manifests are
+// not quarantined.
+func (q *quarantine) rule(repository string) *quarantineRule {
+	if q == nil {
+		return nil
+	}
+	for i := range q.rules {
+		for _, pattern := range q.rules[i].repositories {
+			if ok, _ := path.Match(pattern, repository); ok {
+				return &q.rules[i]
+			}
+		}
+	}
+	return nil
+}
+
+// checkQuarantine applies the quarantine to the pull of the manifest
+// imh.Digest. It returns false, with the error recorded, if the pull is
+// denied, and adds a Warning header to the response of a pull served
+// nonetheless.
+func (imh *manifestHandler) checkQuarantine(w http.ResponseWriter) bool {
+	name := imh.Repository.Named().Name()
+	rule := imh.App.quarantine.rule(name)
+	if rule == nil {
+		return true
+	}
+
+	repository, err := imh.App.registry.Repository(imh, imh.Repository.Named())
+	if err != nil {
+		imh.Errors = append(imh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return false
+	}
+	checker, ok := repository.(distribution.ReferrerChecker)
+	if !ok {
+		dcontext.GetLogger(imh).Warnf("quarantine of repository %s is not supported by the storage", name)
+		return true
+	}
+	flagged, err := checker.HasReferrer(imh, imh.Digest, rule.artifactTypes...)
+	if err != nil {
+		// fail closed, not to serve flagged manifests
+		imh.Errors = append(imh.Errors, errcode.ErrorCodeUnknown.WithDetail(fmt.Errorf("checking the quarantine: %w", err)))
+		return false
+	}
+	if !flagged {
+		return true
+	}
+
+	quarantinedPulls.WithValues(rule.action).Inc(1)
+	detail := fmt.Sprintf("manifest %s of repository %s is quarantined", imh.Digest, name)
+	if rule.action == quarantineActionWarn {
+		dcontext.GetLogger(imh).Warnf("serving quarantined manifest %s of repository %s", imh.Digest, name)
+		w.Header().Add("Warning", fmt.Sprintf("299 - %q", detail))
+		return true
+	}
+	dcontext.GetLogger(imh).Infof("denying pull of quarantined manifest %s of repository %s", imh.Digest, name)
+	imh.Errors = append(imh.Errors, errcode.ErrorCodeDenied.WithDetail(detail))
+	return false
+}
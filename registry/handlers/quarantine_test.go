@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const criticalFindingsType = "application/vnd.example.scan.critical+json"
+
+func TestNewQuarantine(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		rules []configuration.QuarantineRule
+		err   bool
+	}{
+		{name: "none"},
+		{name: "default action", rules: []configuration.QuarantineRule{{Repositories: []string{"foo/*"}, ArtifactTypes: []string{criticalFindingsType}}}},
+		{name: "warn", rules: []configuration.QuarantineRule{{Repositories: []string{"foo/*"}, ArtifactTypes: []string{criticalFindingsType}, Action: "warn"}}},
+		{name: "no repository", rules: []configuration.QuarantineRule{{ArtifactTypes: []string{criticalFindingsType}}}, err: true},
+		{name: "invalid pattern", rules: []configuration.QuarantineRule{{Repositories: []string{"foo/["}, ArtifactTypes: []string{criticalFindingsType}}}, err: true},
+		{name: "no artifact type", rules: []configuration.QuarantineRule{{Repositories: []string{"foo/*"}}}, err: true},
+		{name: "invalid action", rules: []configuration.QuarantineRule{{Repositories: []string{"foo/*"}, ArtifactTypes: []string{criticalFindingsType}, Action: "block"}}, err: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			q, err := newQuarantine(configuration.QuarantinePolicy{Rules: tc.rules})
+			if tc.err {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if (q == nil) != (len(tc.rules) == 0) {
+				t.Fatalf("unexpected quarantine: %v", q)
+			}
+		})
+	}
+}
+
+func TestQuarantine(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory":    configuration.Parameters{},
+			"delete":      configuration.Parameters{"enabled": true},
+			"maintenance": configuration.Parameters{"uploadpurging": map[any]any{"enabled": false}},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	config.Policy.Quarantine.Rules = []configuration.QuarantineRule{
+		{Repositories: []string{"audited/*"}, ArtifactTypes: []string{criticalFindingsType}, Action: "warn"},
+		{Repositories: []string{"prod/*"}, ArtifactTypes: []string{"application/vnd.example.other", criticalFindingsType}},
+	}
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	getManifest := func(t *testing.T, name reference.Named, ref string) *http.Response {
+		t.Helper()
+		var named reference.Named
+		if dgst, err := digest.Parse(ref); err == nil {
+			named, _ = reference.WithDigest(name, dgst)
+		} else {
+			named, _ = reference.WithTag(name, ref)
+		}
+		manifestURL, err := env.builder.BuildManifestURL(named)
+		checkErr(t, err, "building manifest url")
+		resp, err := http.Get(manifestURL)
+		checkErr(t, err, "fetching manifest")
+		return resp
+	}
+
+	// flag pushes a referrer of artifactType with dgst as subject, and
+	// returns its digest
+	flag := func(t *testing.T, name reference.Named, dgst digest.Digest, artifactType string) digest.Digest {
+		t.Helper()
+		empty := []byte("{}")
+		uploadURLBase, _ := startPushLayer(t, env, name)
+		pushLayer(t, env.builder, name, digest.FromBytes(empty), uploadURLBase, bytes.NewReader(empty))
+
+		referrer := map[string]any{
+			"schemaVersion": 2,
+			"mediaType":     v1.MediaTypeImageManifest,
+			"artifactType":  artifactType,
+			"config":        v1.Descriptor{MediaType: v1.MediaTypeEmptyJSON, Digest: digest.FromBytes(empty), Size: int64(len(empty))},
+			"layers":        []v1.Descriptor{},
+			"subject":       v1.Descriptor{MediaType: v1.MediaTypeImageManifest, Digest: dgst, Size: 1},
+		}
+		tagRef, _ := reference.WithTag(name, "scan")
+		manifestURL, err := env.builder.BuildManifestURL(tagRef)
+		checkErr(t, err, "building manifest url")
+		resp := putManifest(t, "putting referrer", manifestURL, v1.MediaTypeImageManifest, referrer)
+		defer resp.Body.Close()
+		checkResponse(t, "putting referrer", resp, http.StatusCreated)
+		return digest.Digest(resp.Header.Get("Docker-Content-Digest"))
+	}
+
+	t.Run("deny", func(t *testing.T) {
+		name, _ := reference.WithName("prod/app")
+		dgst := createRepository(env, t, name.Name(), "latest")
+
+		resp := getManifest(t, name, "latest")
+		resp.Body.Close()
+		checkResponse(t, "fetching unflagged manifest", resp, http.StatusOK)
+
+		// referrers of other artifact types do not flag their subject
+		flag(t, name, dgst, "application/spdx+json")
+		resp = getManifest(t, name, "latest")
+		resp.Body.Close()
+		checkResponse(t, "fetching manifest with an sbom", resp, http.StatusOK)
+
+		referrer := flag(t, name, dgst, criticalFindingsType)
+		for _, ref := range []string{"latest", dgst.String()} {
+			resp = getManifest(t, name, ref)
+			checkResponse(t, "fetching flagged manifest", resp, http.StatusForbidden)
+			checkBodyHasErrorCodes(t, "fetching flagged manifest", resp, errcode.ErrorCodeDenied)
+			resp.Body.Close()
+		}
+
+		// deleting the referrer lifts the quarantine
+		ref, _ := reference.WithDigest(name, referrer)
+		manifestURL, err := env.builder.BuildManifestURL(ref)
+		checkErr(t, err, "building manifest url")
+		resp, err = httpDelete(manifestURL)
+		checkErr(t, err, "deleting referrer")
+		resp.Body.Close()
+		checkResponse(t, "deleting referrer", resp, http.StatusAccepted)
+		resp = getManifest(t, name, "latest")
+		resp.Body.Close()
+		checkResponse(t, "fetching manifest unflagged", resp, http.StatusOK)
+	})
+
+	t.Run("warn", func(t *testing.T) {
+		name, _ := reference.WithName("audited/app")
+		dgst := createRepository(env, t, name.Name(), "latest")
+		flag(t, name, dgst, criticalFindingsType)
+
+		resp := getManifest(t, name, "latest")
+		resp.Body.Close()
+		checkResponse(t, "fetching flagged manifest", resp, http.StatusOK)
+		if resp.Header.Get("Warning") == "" {
+			t.Fatal("expected a Warning header")
+		}
+	})
+
+	t.Run("unmatched repository", func(t *testing.T) {
+		name, _ := reference.WithName("dev/app")
+		dgst := createRepository(env, t, name.Name(), "latest")
+		flag(t, name, dgst, criticalFindingsType)
+
+		resp := getManifest(t, name, "latest")
+		resp.Body.Close()
+		checkResponse(t, "fetching flagged manifest", resp, http.StatusOK)
+		if resp.Header.Get("Warning") != "" {
+			t.Fatalf("unexpected Warning header: %q", resp.Header.Get("Warning"))
+		}
+	})
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/gorilla/handlers"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// referrersDispatcher constructs the referrers handler.
+func referrersDispatcher(ctx *Context, r *http.Request) http.Handler {
+	dgst, err := getDigest(ctx)
+	if err != nil {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx.Errors = append(ctx.Errors, errcode.ErrorCodeDigestInvalid.WithDetail(err))
+		})
+	}
+
+	referrersHandler := &referrersHandler{
+		Context: ctx,
+		Subject: dgst,
+	}
+
+	return handlers.MethodHandler{
+		http.MethodGet: ctx.referrersLimiter.limit(ctx, http.HandlerFunc(referrersHandler.GetReferrers)),
+	}
+}
+
+// referrersHandler serves the referrers of a manifest.
+type referrersHandler struct {
+	*Context
+
+	Subject digest.Digest
+}
+
+// GetReferrers returns the referrers of the subject as an image index,
+// filtered by the artifactType query parameter if set.
+func (rh *referrersHandler) GetReferrers(w http.ResponseWriter, r *http.Request) {
+	dcontext.GetLogger(rh).Debug("GetReferrers")
+
+	repository, err := rh.App.registry.Repository(rh, rh.Repository.Named())
+	if err != nil {
+		rh.Errors = append(rh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+	lister, ok := repository.(distribution.ReferrerLister)
+	if !ok {
+		rh.Errors = append(rh.Errors, errcode.ErrorCodeUnsupported.WithDetail("the storage does not index referrers"))
+		return
+	}
+
+	artifactType := r.URL.Query().Get("artifactType")
+	referrers, err := lister.Referrers(rh, rh.Subject, artifactType)
+	if err != nil {
+		rh.Errors = append(rh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+	if referrers == nil {
+		referrers = []v1.Descriptor{}
+	}
+
+	if artifactType != "" {
+		w.Header().Set("OCI-Filters-Applied", "artifactType")
+	}
+	w.Header().Set("Content-Type", v1.MediaTypeImageIndex)
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(v1.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: v1.MediaTypeImageIndex,
+		Manifests: referrers,
+	}); err != nil {
+		rh.Errors = append(rh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+}
@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestReferrersLimiterInvalid(t *testing.T) {
+	for _, config := range []configuration.ReferrersPolicy{
+		{Rules: []configuration.ReferrersRule{{Rate: 1}}},
+		{Rules: []configuration.ReferrersRule{{Repositories: []string{"[ci/*"}, Rate: 1}}},
+		{Rules: []configuration.ReferrersRule{{Repositories: []string{"ci/*"}}}},
+		{Rules: []configuration.ReferrersRule{{Repositories: []string{"ci/*"}, Rate: 1, Burst: -1}}},
+	} {
+		if _, err := newReferrersLimiter(config); err == nil {
+			t.Errorf("expected error for referrers policy %+v", config)
+		}
+	}
+}
+
+func TestReferrers(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory":    configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[any]any{"enabled": false}},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	config.Policy.Referrers.Rules = []configuration.ReferrersRule{
+		{Repositories: []string{"ci/*"}, Rate: 0.01, Burst: 2},
+	}
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	// pushReferrer pushes a referrer of artifactType with dgst as subject,
+	// and returns its digest
+	pushReferrer := func(t *testing.T, name reference.Named, dgst digest.Digest, artifactType string) digest.Digest {
+		t.Helper()
+		empty := []byte("{}")
+		uploadURLBase, _ := startPushLayer(t, env, name)
+		pushLayer(t, env.builder, name, digest.FromBytes(empty), uploadURLBase, bytes.NewReader(empty))
+
+		referrer := map[string]any{
+			"schemaVersion": 2,
+			"mediaType":     v1.MediaTypeImageManifest,
+			"artifactType":  artifactType,
+			"config":        v1.Descriptor{MediaType: v1.MediaTypeEmptyJSON, Digest: digest.FromBytes(empty), Size: int64(len(empty))},
+			"layers":        []v1.Descriptor{},
+			"subject":       v1.Descriptor{MediaType: v1.MediaTypeImageManifest, Digest: dgst, Size: 1},
+			"annotations":   map[string]string{"org.example.scanner": "test"},
+		}
+		tagRef, _ := reference.WithTag(name, "scan")
+		manifestURL, err := env.builder.BuildManifestURL(tagRef)
+		checkErr(t, err, "building manifest url")
+		resp := putManifest(t, "putting referrer", manifestURL, v1.MediaTypeImageManifest, referrer)
+		defer resp.Body.Close()
+		checkResponse(t, "putting referrer", resp, http.StatusCreated)
+		return digest.Digest(resp.Header.Get("Docker-Content-Digest"))
+	}
+
+	getReferrers := func(t *testing.T, name reference.Named, dgst digest.Digest, values ...url.Values) *http.Response {
+		t.Helper()
+		ref, _ := reference.WithDigest(name, dgst)
+		referrersURL, err := env.builder.BuildReferrersURL(ref, values...)
+		checkErr(t, err, "building referrers url")
+		resp, err := http.Get(referrersURL)
+		checkErr(t, err, "fetching referrers")
+		return resp
+	}
+
+	decodeIndex := func(t *testing.T, resp *http.Response) v1.Index {
+		t.Helper()
+		checkResponse(t, "fetching referrers", resp, http.StatusOK)
+		checkHeaders(t, resp, http.Header{"Content-Type": []string{v1.MediaTypeImageIndex}})
+		var index v1.Index
+		checkErr(t, json.NewDecoder(resp.Body).Decode(&index), "decoding referrers")
+		if index.SchemaVersion != 2 || index.MediaType != v1.MediaTypeImageIndex {
+			t.Fatalf("unexpected referrers index: %+v", index)
+		}
+		return index
+	}
+
+	t.Run("list", func(t *testing.T) {
+		name, _ := reference.WithName("library/app")
+		dgst := createRepository(env, t, name.Name(), "latest")
+
+		resp := getReferrers(t, name, dgst)
+		defer resp.Body.Close()
+		if index := decodeIndex(t, resp); index.Manifests == nil || len(index.Manifests) != 0 {
+			t.Fatalf("expected an empty list of referrers, got %v", index.Manifests)
+		}
+
+		sbom := pushReferrer(t, name, dgst, "application/spdx+json")
+		finding := pushReferrer(t, name, dgst, criticalFindingsType)
+
+		resp = getReferrers(t, name, dgst)
+		defer resp.Body.Close()
+		index := decodeIndex(t, resp)
+		if len(index.Manifests) != 2 {
+			t.Fatalf("expected 2 referrers, got %v", index.Manifests)
+		}
+		if resp.Header.Get("OCI-Filters-Applied") != "" {
+			t.Fatalf("unexpected filters applied: %q", resp.Header.Get("OCI-Filters-Applied"))
+		}
+		for _, desc := range index.Manifests {
+			if (desc.Digest != sbom && desc.Digest != finding) || desc.MediaType != v1.MediaTypeImageManifest || desc.Annotations["org.example.scanner"] != "test" {
+				t.Fatalf("unexpected referrer: %+v", desc)
+			}
+		}
+
+		resp = getReferrers(t, name, dgst, url.Values{"artifactType": []string{criticalFindingsType}})
+		defer resp.Body.Close()
+		index = decodeIndex(t, resp)
+		if len(index.Manifests) != 1 || index.Manifests[0].Digest != finding || index.Manifests[0].ArtifactType != criticalFindingsType {
+			t.Fatalf("expected only the finding, got %v", index.Manifests)
+		}
+		checkHeaders(t, resp, http.Header{"OCI-Filters-Applied": []string{"artifactType"}})
+	})
+
+	t.Run("invalid digest", func(t *testing.T) {
+		u, err := env.builder.BuildBaseURL()
+		checkErr(t, err, "building base url")
+		resp, err := http.Get(u + "library/app/referrers/sha256:abc")
+		checkErr(t, err, "fetching referrers")
+		defer resp.Body.Close()
+		checkResponse(t, "fetching referrers of an invalid digest", resp, http.StatusBadRequest)
+		checkBodyHasErrorCodes(t, "fetching referrers of an invalid digest", resp, errcode.ErrorCodeDigestInvalid)
+	})
+
+	t.Run("rate limit", func(t *testing.T) {
+		name, _ := reference.WithName("ci/app")
+		dgst := createRepository(env, t, name.Name(), "latest")
+
+		for range 2 {
+			resp := getReferrers(t, name, dgst)
+			resp.Body.Close()
+			checkResponse(t, "fetching referrers within the limit", resp, http.StatusOK)
+		}
+
+		resp := getReferrers(t, name, dgst)
+		defer resp.Body.Close()
+		checkResponse(t, "fetching referrers over the limit", resp, http.StatusTooManyRequests)
+		if retryAfter, err := strconv.Atoi(resp.Header.Get("Retry-After")); err != nil || retryAfter < 1 {
+			t.Fatalf("expected a Retry-After delay, got %q", resp.Header.Get("Retry-After"))
+		}
+		checkBodyHasErrorCodes(t, "fetching referrers over the limit", resp, errcode.ErrorCodeTooManyRequests)
+
+		// other repositories are not limited
+		other, _ := reference.WithName("library/app")
+		for range 3 {
+			resp := getReferrers(t, other, dgst)
+			resp.Body.Close()
+			checkResponse(t, "fetching referrers of an unlimited repository", resp, http.StatusOK)
+		}
+	})
+}
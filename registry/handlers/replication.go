@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"expvar"
+	"fmt"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/registry/replication"
+	"github.com/opencontainers/go-digest"
+)
+
+// configureReplication starts replicating the manifests pushed to the
+// configured downstream registries.
+func (app *App) configureReplication(configuration *configuration.Configuration) {
+	config := configuration.Replication
+	if len(config.Targets) == 0 {
+		return
+	}
+	if config.Workers < 0 || config.QueueSize < 0 || config.MaxRetries < 0 {
+		panic("replication: workers, queuesize and maxretries must not be negative")
+	}
+
+	targets := make([]replication.Target, 0, len(config.Targets))
+	for _, target := range config.Targets {
+		targets = append(targets, replication.Target{
+			Name:     target.Name,
+			URL:      target.URL,
+			Username: target.Username,
+			Password: target.Password,
+		})
+	}
+	replicator, err := replication.NewReplicator(app, app.registry, targets, config.Workers, config.QueueSize, config.MaxRetries)
+	if err != nil {
+		panic(fmt.Sprintf("replication: %v", err))
+	}
+	app.replicator = replicator
+	for _, target := range targets {
+		dcontext.GetLogger(app).Infof("replicating pushed manifests to %s (%s)", target.Name, target.URL)
+	}
+
+	registry := expvar.Get("registry")
+	if registry == nil {
+		registry = expvar.NewMap("registry")
+	}
+	registry.(*expvar.Map).Set("replication", expvar.Func(func() any {
+		return map[string]any{
+			"Dropped": replicator.Dropped(),
+		}
+	}))
+}
+
+// replicateManifest queues the replication of the manifest dgst pushed to
+// the repository of the request, tagged tag if not empty, if replication is
+// enabled.
+func (ctx *Context) replicateManifest(dgst digest.Digest, tag string) {
+	if ctx.App.replicator == nil || ctx.Repository == nil {
+		return
+	}
+	ctx.App.replicator.Replicate(ctx.Repository.Named().Name(), dgst, tag)
+}
+
+// closeReplication stops replicating.
+func (app *App) closeReplication() {
+	if app.replicator != nil {
+		app.replicator.Close()
+	}
+}
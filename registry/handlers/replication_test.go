@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/reference"
+)
+
+func TestReplication(t *testing.T) {
+	spoke := newTestEnv(t, false)
+	defer spoke.Shutdown()
+
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory":    configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[any]any{"enabled": false}},
+		},
+		Replication: configuration.Replication{
+			Targets: []configuration.ReplicationTarget{{Name: "spoke", URL: spoke.server.URL}},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	hub := newTestEnvWithConfig(t, &config)
+	defer hub.Shutdown()
+
+	name, _ := reference.WithName("foo/bar")
+	dgst := pushPlatformImage(t, hub, name, "latest", "amd64")
+
+	// the image pushed to the hub is replicated to the spoke
+	tagRef, _ := reference.WithTag(name, "latest")
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		resp := headManifest(t, spoke, tagRef)
+		if resp.StatusCode == http.StatusOK {
+			if resp.Header.Get("Docker-Content-Digest") != dgst.String() {
+				t.Fatalf("unexpected manifest replicated: %s", resp.Header.Get("Docker-Content-Digest"))
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the replication of the image")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if dropped := hub.app.replicator.Dropped(); dropped != 0 {
+		t.Fatalf("unexpected %d replications dropped", dropped)
+	}
+}
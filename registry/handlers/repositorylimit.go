@@ -0,0 +1,219 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/reference"
+)
+
+// repositoryCountInterval is how often the repositories are counted, taking
+// the repositories created by other instances and the ones deleted into
+// account.
+const repositoryCountInterval = time.Minute
+
+// repositoryLimit caps the number of repositories, counted as the catalog
+// lists them: the repositories holding manifests. The repositories are counted
+// in the background, so that pushes never wait on a walk of the storage.
+type repositoryLimit struct {
+	max        int
+	checker    distribution.RepositoryChecker
+	enumerator distribution.RepositoryEnumerator
+
+	// counted is closed once the repositories are first counted.
+	counted chan struct{}
+	stop    chan struct{}
+
+	mu sync.Mutex
+	// count is the number of repositories at the last successful count, made
+	// at countedAt, and err the error of the last count, if it failed.
+	count     int
+	countedAt time.Time
+	err       error
+	// pending holds the repositories reserved which the last count may not
+	// have seen, each counted once on top of count.
+	pending map[string]*pendingRepository
+}
+
+// pendingRepository is a repository reserved as created.
+type pendingRepository struct {
+	// reservations is the number of reservations in progress.
+	reservations int
+	// createdAt is when the repository was last created, zero if none of its
+	// reservations completed.
+	createdAt time.Time
+}
+
+// newRepositoryLimit validates the storage.maxrepositories parameters and
+// returns the limit they describe, or nil if the repositories are unlimited.
+func newRepositoryLimit(parameters configuration.Parameters, registry distribution.Namespace) (*repositoryLimit, error) {
+	var limit int
+	switch v := parameters["limit"].(type) {
+	case nil:
+		return nil, nil
+	case int:
+		limit = v
+	default:
+		return nil, fmt.Errorf("limit must be an integer, %#v invalid", v)
+	}
+	if limit < 0 {
+		return nil, fmt.Errorf("limit must be a non-negative integer, %d invalid", limit)
+	}
+	if limit == 0 {
+		return nil, nil
+	}
+
+	checker, ok := registry.(distribution.RepositoryChecker)
+	if !ok {
+		return nil, errors.New("the registry cannot check whether repositories exist")
+	}
+	enumerator, ok := registry.(distribution.RepositoryEnumerator)
+	if !ok {
+		return nil, errors.New("the registry cannot enumerate its repositories")
+	}
+	return &repositoryLimit{
+		max:        limit,
+		checker:    checker,
+		enumerator: enumerator,
+		counted:    make(chan struct{}),
+		stop:       make(chan struct{}),
+		pending:    make(map[string]*pendingRepository),
+	}, nil
+}
+
+// start counts the repositories now and every repositoryCountInterval, until
+// close is called.
+func (rl *repositoryLimit) start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(repositoryCountInterval)
+		defer ticker.Stop()
+		for {
+			rl.refresh(ctx)
+			select {
+			case <-ticker.C:
+			case <-rl.stop:
+				return
+			}
+		}
+	}()
+}
+
+// close stops counting the repositories.
+func (rl *repositoryLimit) close() {
+	if rl != nil {
+		close(rl.stop)
+	}
+}
+
+// refresh counts the repositories, without holding the lock while walking
+// the storage.
+func (rl *repositoryLimit) refresh(ctx context.Context) {
+	startedAt := time.Now()
+	count := 0
+	err := rl.enumerator.Enumerate(ctx, func(string) error {
+		count++
+		return nil
+	})
+	if errors.As(err, new(storagedriver.PathNotFoundError)) {
+		err = nil
+	}
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	select {
+	case <-rl.counted:
+	default:
+		defer close(rl.counted)
+	}
+	if err != nil {
+		// keep the previous count, if any
+		dcontext.GetLogger(ctx).Errorf("error counting the repositories: %v", err)
+		rl.err = err
+		return
+	}
+	rl.count, rl.countedAt, rl.err = count, startedAt, nil
+	// the repositories created before the count started were seen by it,
+	// unless deleted since
+	for name, p := range rl.pending {
+		if p.reservations == 0 && p.createdAt.Before(startedAt) {
+			delete(rl.pending, name)
+		}
+	}
+}
+
+// check returns an error if name is a new repository and the registry holds
+// the maximum number of repositories.
+func (rl *repositoryLimit) check(ctx context.Context, name reference.Named) error {
+	_, err := rl.admit(ctx, name, false)
+	return err
+}
+
+// reserve is as check, but counts name as created if it is a new repository,
+// in the same step. The returned function completes the reservation, if any,
+// telling whether the repository was created: if not, it is uncounted.
+func (rl *repositoryLimit) reserve(ctx context.Context, name reference.Named) (func(created bool), error) {
+	return rl.admit(ctx, name, true)
+}
+
+func (rl *repositoryLimit) admit(ctx context.Context, name reference.Named, reserve bool) (func(created bool), error) {
+	done := func(bool) {}
+	if rl == nil {
+		return done, nil
+	}
+	exists, err := rl.checker.RepositoryExists(ctx, name)
+	if err != nil {
+		return done, errcode.ErrorCodeUnknown.WithDetail(err)
+	}
+	if exists {
+		return done, nil
+	}
+
+	select {
+	case <-rl.counted:
+	case <-ctx.Done():
+		return done, errcode.ErrorCodeUnavailable.WithDetail(fmt.Sprintf("repository %s cannot be created before the repositories are counted", name.Name()))
+	}
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	p, ok := rl.pending[name.Name()]
+	if !ok {
+		if rl.countedAt.IsZero() {
+			return done, errcode.ErrorCodeUnknown.WithDetail(fmt.Errorf("counting the repositories: %w", rl.err))
+		}
+		if rl.count+len(rl.pending) >= rl.max {
+			return done, errcode.ErrorCodeDenied.WithDetail(fmt.Sprintf("repository %s cannot be created, the registry holds the maximum of %d repositories", name.Name(), rl.max))
+		}
+		if !reserve {
+			return done, nil
+		}
+		p = &pendingRepository{}
+		rl.pending[name.Name()] = p
+	} else if !reserve {
+		return done, nil
+	}
+	p.reservations++
+
+	var once sync.Once
+	return func(created bool) {
+		once.Do(func() {
+			rl.mu.Lock()
+			defer rl.mu.Unlock()
+			p.reservations--
+			if created {
+				p.createdAt = time.Now()
+			}
+			if p.reservations == 0 && p.createdAt.IsZero() {
+				delete(rl.pending, name.Name())
+			}
+		})
+	}, nil
+}
@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/reference"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestNewRepositoryLimit(t *testing.T) {
+	registry, err := storage.NewRegistry(t.Context(), inmemory.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name       string
+		parameters configuration.Parameters
+		enabled    bool
+		err        bool
+	}{
+		{name: "unset"},
+		{name: "unlimited", parameters: configuration.Parameters{"limit": 0}},
+		{name: "limited", parameters: configuration.Parameters{"limit": 10}, enabled: true},
+		{name: "negative", parameters: configuration.Parameters{"limit": -1}, err: true},
+		{name: "not an integer", parameters: configuration.Parameters{"limit": "10"}, err: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			limit, err := newRepositoryLimit(tc.parameters, registry)
+			if tc.err {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if (limit != nil) != tc.enabled {
+				t.Fatalf("unexpected limit: %v", limit)
+			}
+		})
+	}
+}
+
+func TestRepositoryLimit(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory":        configuration.Parameters{},
+			"maintenance":     configuration.Parameters{"uploadpurging": map[any]any{"enabled": false}},
+			"maxrepositories": configuration.Parameters{"limit": 2},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	// repositories are created up to the limit
+	createRepository(env, t, "foo/a", "latest")
+	createRepository(env, t, "foo/b", "latest")
+
+	checkDenied := func(t *testing.T, msg string, resp *http.Response) {
+		t.Helper()
+		defer resp.Body.Close()
+		checkResponse(t, msg, resp, http.StatusForbidden)
+		checkBodyHasErrorCodes(t, msg, resp, errcode.ErrorCodeDenied)
+	}
+
+	name, _ := reference.WithName("foo/c")
+	uploadURL, err := env.builder.BuildBlobUploadURL(name)
+	checkErr(t, err, "building upload url")
+	resp, err := http.Post(uploadURL, "", nil)
+	checkErr(t, err, "starting blob upload")
+	checkDenied(t, "starting blob upload beyond the limit", resp)
+
+	tagRef, _ := reference.WithTag(name, "latest")
+	manifestURL, err := env.builder.BuildManifestURL(tagRef)
+	checkErr(t, err, "building manifest url")
+	manifest := map[string]any{
+		"schemaVersion": 2,
+		"mediaType":     v1.MediaTypeImageManifest,
+		"config":        v1.DescriptorEmptyJSON,
+		"layers":        []v1.Descriptor{},
+	}
+	checkDenied(t, "putting manifest beyond the limit", putManifest(t, "putting manifest", manifestURL, v1.MediaTypeImageManifest, manifest))
+
+	ops, err := json.Marshal([]retagOperation{{SourceRepository: "foo/a", SourceReference: "latest", DestRepository: "foo/c", DestTag: "latest"}})
+	checkErr(t, err, "encoding retag operations")
+	checkDenied(t, "retagging beyond the limit", postRetag(t, env, string(ops)))
+
+	// the existing repositories are still pushed to
+	createRepository(env, t, "foo/a", "next")
+}
+
+func TestRepositoryLimitReserve(t *testing.T) {
+	registry, err := storage.NewRegistry(t.Context(), inmemory.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	limit, err := newRepositoryLimit(configuration.Parameters{"limit": 1}, registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	limit.start(t.Context())
+	defer limit.close()
+
+	a, _ := reference.WithName("foo/a")
+	b, _ := reference.WithName("foo/b")
+
+	// concurrent reservations of a new repository count it once
+	doneA, err := limit.reserve(t.Context(), a)
+	if err != nil {
+		t.Fatalf("unexpected error reserving %s: %v", a, err)
+	}
+	doneAgain, err := limit.reserve(t.Context(), a)
+	if err != nil {
+		t.Fatalf("unexpected error reserving %s again: %v", a, err)
+	}
+	if _, err := limit.reserve(t.Context(), b); err == nil {
+		t.Fatalf("expected %s to be denied while %s is reserved", b, a)
+	}
+
+	// the repository stays counted while a reservation is in progress
+	doneA(false)
+	if err := limit.check(t.Context(), b); err == nil {
+		t.Fatalf("expected %s to be denied while %s is still reserved", b, a)
+	}
+	doneAgain(false)
+	if err := limit.check(t.Context(), b); err != nil {
+		t.Fatalf("unexpected error checking %s once %s is released: %v", b, a, err)
+	}
+
+	// a created repository stays counted until the next count sees it
+	doneB, err := limit.reserve(t.Context(), b)
+	if err != nil {
+		t.Fatalf("unexpected error reserving %s: %v", b, err)
+	}
+	doneB(true)
+	if err := limit.check(t.Context(), a); err == nil {
+		t.Fatalf("expected %s to be denied once %s is created", a, b)
+	}
+}
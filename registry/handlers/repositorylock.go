@@ -0,0 +1,249 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// repositoryLockTTL bounds the time a repository stays locked in redis
+	// if the registry holding the lock dies.
+	repositoryLockTTL = time.Minute
+
+	// repositoryLockRetry is the interval between two attempts to take a
+	// lock held by another registry.
+	repositoryLockRetry = 50 * time.Millisecond
+
+	// tagLockTimeout bounds the time a tag update waits for the lock of its
+	// repository.
+	tagLockTimeout = 30 * time.Second
+)
+
+// repositoryLocker serializes the operations of the registries on a
+// repository.
+type repositoryLocker interface {
+	// Lock locks the repository name, waiting for it to be unlocked until
+	// ctx is done. The returned function unlocks it.
+	Lock(ctx context.Context, name string) (func(), error)
+}
+
+// heldRepositoryLockKey marks the contexts of the requests holding the lock
+// of a repository.
+type heldRepositoryLockKey struct {
+	name string
+}
+
+// withRepositoryLock returns a context recording that the lock of the
+// repository name is held, so that lockRepository does not wait for it.
+func withRepositoryLock(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, heldRepositoryLockKey{name: name}, true)
+}
+
+// lockRepository locks the repository name with locker, waiting for it at
+// most timeout, unless ctx holds its lock already.
+func lockRepository(ctx context.Context, locker repositoryLocker, name string, timeout time.Duration) (func(), error) {
+	if held, _ := ctx.Value(heldRepositoryLockKey{name: name}).(bool); held {
+		return func() {}, nil
+	}
+	lockCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return locker.Lock(lockCtx, name)
+}
+
+// lockTags decorates repository so that the tags are created, moved and
+// deleted through it under the lock of the repository, by any request. It
+// must wrap the other decorators of the tags, so that their checks run under
+// the lock too.
+func (app *App) lockTags(repository distribution.Repository) distribution.Repository {
+	return &lockedTagsRepository{Repository: repository, app: app}
+}
+
+type lockedTagsRepository struct {
+	distribution.Repository
+	app *App
+}
+
+func (r *lockedTagsRepository) Tags(ctx context.Context) distribution.TagService {
+	return &lockedTagService{
+		TagService: r.Repository.Tags(ctx),
+		name:       r.Named().Name(),
+		app:        r.app,
+	}
+}
+
+// lockedTagService locks the repository around the tag updates, so that
+// the updates of concurrent requests do not interleave.
+type lockedTagService struct {
+	distribution.TagService
+	name string
+	app  *App
+}
+
+func (ts *lockedTagService) Tag(ctx context.Context, tag string, desc v1.Descriptor) error {
+	ctx, unlock, err := ts.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return ts.TagService.Tag(ctx, tag, desc)
+}
+
+func (ts *lockedTagService) Untag(ctx context.Context, tag string) error {
+	ctx, unlock, err := ts.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return ts.TagService.Untag(ctx, tag)
+}
+
+func (ts *lockedTagService) TagBatch(ctx context.Context, ops []distribution.TagOperation) error {
+	batcher, ok := ts.TagService.(distribution.TagBatcher)
+	if !ok {
+		return distribution.ErrUnsupported
+	}
+
+	ctx, unlock, err := ts.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return batcher.TagBatch(ctx, ops)
+}
+
+func (ts *lockedTagService) ListFiltered(ctx context.Context, opts distribution.TagListOptions, limit int, last string) ([]distribution.TagInfo, error) {
+	lister, ok := ts.TagService.(distribution.TagFilterLister)
+	if !ok {
+		return nil, distribution.ErrUnsupported
+	}
+	return lister.ListFiltered(ctx, opts, limit, last)
+}
+
+// lock locks the repository, unless the request holds its lock already, and
+// returns ctx marked as holding it.
+func (ts *lockedTagService) lock(ctx context.Context) (context.Context, func(), error) {
+	unlock, err := lockRepository(ctx, ts.app.repositoryLocks, ts.name, tagLockTimeout)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			err = fmt.Errorf("timed out waiting for the other tag updates on the repository")
+		}
+		return nil, nil, errcode.ErrorCodeUnavailable.WithDetail(err.Error())
+	}
+	return withRepositoryLock(ctx, ts.name), unlock, nil
+}
+
+// newRepositoryLocker returns a locker shared by the registries through
+// client, or local to this registry if client is nil.
+func newRepositoryLocker(client redis.UniversalClient) repositoryLocker {
+	if client != nil {
+		return &redisRepositoryLocker{client: client}
+	}
+	return &localRepositoryLocker{locks: make(map[string]*repositoryLock)}
+}
+
+// localRepositoryLocker locks repositories within this registry.
+type localRepositoryLocker struct {
+	mu    sync.Mutex
+	locks map[string]*repositoryLock
+}
+
+type repositoryLock struct {
+	// held has an element while the lock is held.
+	held chan struct{}
+	// waiters is the number of holders and waiters of the lock, which is
+	// removed when it drops to zero.
+	waiters int
+}
+
+func (l *localRepositoryLocker) Lock(ctx context.Context, name string) (func(), error) {
+	l.mu.Lock()
+	lock, ok := l.locks[name]
+	if !ok {
+		lock = &repositoryLock{held: make(chan struct{}, 1)}
+		l.locks[name] = lock
+	}
+	lock.waiters++
+	l.mu.Unlock()
+
+	release := func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		lock.waiters--
+		if lock.waiters == 0 {
+			delete(l.locks, name)
+		}
+	}
+
+	select {
+	case lock.held <- struct{}{}:
+	case <-ctx.Done():
+		release()
+		return nil, ctx.Err()
+	}
+	return func() {
+		<-lock.held
+		release()
+	}, nil
+}
+
+// unlockScript deletes a lock only if it is still held by the same holder,
+// so that a lock which expired and was taken by another registry is not
+// released.
+var unlockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// redisRepositoryLocker locks repositories across the registries sharing a
+// redis instance.
+type redisRepositoryLocker struct {
+	client redis.UniversalClient
+}
+
+func (l *redisRepositoryLocker) Lock(ctx context.Context, name string) (func(), error) {
+	var token [16]byte
+	if _, err := rand.Read(token[:]); err != nil {
+		return nil, err
+	}
+	key := "repository::" + name + "::lock"
+	value := hex.EncodeToString(token[:])
+
+	for {
+		err := l.client.SetArgs(ctx, key, value, redis.SetArgs{Mode: "NX", TTL: repositoryLockTTL}).Err()
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, redis.Nil) {
+			return nil, err
+		}
+
+		timer := time.NewTimer(repositoryLockRetry)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+	}
+
+	return func() {
+		// the request context may be done already
+		ctx := context.WithoutCancel(ctx)
+		if err := unlockScript.Run(ctx, l.client, []string{key}, value).Err(); err != nil {
+			dcontext.GetLogger(ctx).Errorf("failed to unlock repository %s: %v", name, err)
+		}
+	}, nil
+}
@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestRepositoryLocker(t *testing.T) {
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer client.Close()
+
+	for _, tc := range []struct {
+		name   string
+		locker repositoryLocker
+	}{
+		{name: "local", locker: newRepositoryLocker(nil)},
+		{name: "redis", locker: newRepositoryLocker(client)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			unlock, err := tc.locker.Lock(ctx, "foo/bar")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// other repositories are not locked
+			unlockOther, err := tc.locker.Lock(ctx, "foo/baz")
+			if err != nil {
+				t.Fatal(err)
+			}
+			unlockOther()
+
+			timeoutCtx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+			defer cancel()
+			if _, err := tc.locker.Lock(timeoutCtx, "foo/bar"); !errors.Is(err, context.DeadlineExceeded) {
+				t.Fatalf("expected the lock to be held, got %v", err)
+			}
+
+			locked := make(chan func())
+			go func() {
+				unlock, err := tc.locker.Lock(ctx, "foo/bar")
+				if err != nil {
+					t.Error(err)
+				}
+				locked <- unlock
+			}()
+			select {
+			case <-locked:
+				t.Fatal("the lock was taken while held")
+			case <-time.After(100 * time.Millisecond):
+			}
+			unlock()
+
+			select {
+			case unlock := <-locked:
+				unlock()
+			case <-time.After(5 * time.Second):
+				t.Fatal("the lock was not taken once released")
+			}
+		})
+	}
+}
+
+// lockCheckingTagService fails the tag updates made without the lock of the
+// repository held.
+type lockCheckingTagService struct {
+	distribution.TagService
+	locker repositoryLocker
+}
+
+func (ts *lockCheckingTagService) checkLocked(ctx context.Context) error {
+	if held, _ := ctx.Value(heldRepositoryLockKey{name: "foo/bar"}).(bool); !held {
+		return errors.New("context not marked as holding the lock")
+	}
+	timeoutCtx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if unlock, err := ts.locker.Lock(timeoutCtx, "foo/bar"); err == nil {
+		unlock()
+		return errors.New("repository not locked")
+	}
+	return nil
+}
+
+func (ts *lockCheckingTagService) Tag(ctx context.Context, tag string, desc v1.Descriptor) error {
+	return ts.checkLocked(ctx)
+}
+
+func (ts *lockCheckingTagService) Untag(ctx context.Context, tag string) error {
+	return ts.checkLocked(ctx)
+}
+
+func (ts *lockCheckingTagService) TagBatch(ctx context.Context, ops []distribution.TagOperation) error {
+	return ts.checkLocked(ctx)
+}
+
+func TestLockedTagService(t *testing.T) {
+	ctx := context.Background()
+	locker := newRepositoryLocker(nil)
+	ts := &lockedTagService{
+		TagService: &lockCheckingTagService{locker: locker},
+		name:       "foo/bar",
+		app:        &App{repositoryLocks: locker},
+	}
+
+	updates := map[string]func(ctx context.Context) error{
+		"tag": func(ctx context.Context) error {
+			return ts.Tag(ctx, "latest", v1.Descriptor{})
+		},
+		"untag": func(ctx context.Context) error {
+			return ts.Untag(ctx, "latest")
+		},
+		"batch": func(ctx context.Context) error {
+			return ts.TagBatch(ctx, []distribution.TagOperation{{Tag: "latest"}})
+		},
+	}
+	for name, update := range updates {
+		t.Run(name, func(t *testing.T) {
+			if err := update(ctx); err != nil {
+				t.Fatal(err)
+			}
+
+			unlock, err := locker.Lock(ctx, "foo/bar")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer unlock()
+
+			// the lock held by another request is waited for
+			timeoutCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+			defer cancel()
+			var coded errcode.Error
+			if err := update(timeoutCtx); !errors.As(err, &coded) || coded.Code != errcode.ErrorCodeUnavailable {
+				t.Fatalf("expected the update to time out waiting for the lock, got %v", err)
+			}
+
+			// the lock held by the request is not
+			if err := update(withRepositoryLock(ctx, "foo/bar")); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
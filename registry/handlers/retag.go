@@ -0,0 +1,420 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/manifest/manifestlist"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/notifications"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	"github.com/distribution/distribution/v3/registry/auth"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/distribution/reference"
+	"github.com/gorilla/handlers"
+	"github.com/gorilla/mux"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	// maxRetagOperations bounds the number of tags updated by a request.
+	maxRetagOperations = 100
+
+	// maxRetagBodySize bounds the size of the body of a request.
+	maxRetagBodySize = 256 * 1024
+)
+
+// retagDispatcher constructs the retag handler.
+func retagDispatcher(ctx *Context, r *http.Request) http.Handler {
+	retagHandler := &retagHandler{
+		Context: ctx,
+	}
+
+	mhandler := handlers.MethodHandler{}
+	if !ctx.readOnly {
+		mhandler[http.MethodPost] = http.HandlerFunc(retagHandler.PostRetag)
+	}
+	return mhandler
+}
+
+// retagHandler tags manifests of repositories in other repositories.
+type retagHandler struct {
+	*Context
+}
+
+// retagOperation is an operation of a retag request, pointing the tag
+// DestTag of DestRepository at the manifest SourceReference, a tag or a
+// digest, of SourceRepository.
+type retagOperation struct {
+	SourceRepository string `json:"sourceRepository"`
+	SourceReference  string `json:"sourceReference"`
+	DestRepository   string `json:"destRepository"`
+	DestTag          string `json:"destTag"`
+}
+
+// retagResult is the outcome of an operation of a retag request.
+type retagResult struct {
+	DestRepository string        `json:"destRepository"`
+	DestTag        string        `json:"destTag"`
+	Digest         digest.Digest `json:"digest"`
+}
+
+// isRetagRoute returns true if r retags manifests.
+func isRetagRoute(r *http.Request) bool {
+	route := mux.CurrentRoute(r)
+	return route != nil && route.GetName() == v2.RouteNameRetag
+}
+
+// peekRetagOperations decodes the operations of the retag request r, leaving
+// its body to be read again by the handler.
+func peekRetagOperations(w http.ResponseWriter, r *http.Request) ([]retagOperation, error) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRetagBodySize))
+	if err != nil {
+		return nil, errcode.ErrorCodeRetagInvalid.WithDetail(err.Error())
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return decodeRetagOperations(bytes.NewReader(body))
+}
+
+// decodeRetagOperations decodes and validates the operations of a retag
+// request.
+func decodeRetagOperations(body io.Reader) ([]retagOperation, error) {
+	var ops []retagOperation
+	decoder := json.NewDecoder(body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&ops); err != nil {
+		return nil, errcode.ErrorCodeRetagInvalid.WithDetail(err.Error())
+	}
+	if _, err := decoder.Token(); !errors.Is(err, io.EOF) {
+		return nil, errcode.ErrorCodeRetagInvalid.WithDetail("unexpected data after the operations")
+	}
+	if len(ops) == 0 || len(ops) > maxRetagOperations {
+		return nil, errcode.ErrorCodeRetagInvalid.WithDetail(fmt.Sprintf("expected between 1 and %d operations", maxRetagOperations))
+	}
+
+	destinations := make(map[string]struct{}, len(ops))
+	for _, op := range ops {
+		for _, name := range []string{op.SourceRepository, op.DestRepository} {
+			if _, err := reference.WithName(name); err != nil {
+				return nil, errcode.ErrorCodeNameInvalid.WithDetail(fmt.Sprintf("invalid repository name %q: %v", name, err))
+			}
+		}
+		if strings.Contains(op.SourceReference, ":") {
+			if _, err := digest.Parse(op.SourceReference); err != nil {
+				return nil, errcode.ErrorCodeDigestInvalid.WithDetail(err)
+			}
+		} else if !anchoredTagRegexp.MatchString(op.SourceReference) {
+			return nil, errcode.ErrorCodeTagInvalid.WithDetail(fmt.Sprintf("invalid tag %q", op.SourceReference))
+		}
+		if !anchoredTagRegexp.MatchString(op.DestTag) {
+			return nil, errcode.ErrorCodeTagInvalid.WithDetail(fmt.Sprintf("invalid tag %q", op.DestTag))
+		}
+
+		destination := op.DestRepository + ":" + op.DestTag
+		if _, ok := destinations[destination]; ok {
+			return nil, errcode.ErrorCodeRetagInvalid.WithDetail(fmt.Sprintf("tag %s is updated more than once", destination))
+		}
+		destinations[destination] = struct{}{}
+	}
+	return ops, nil
+}
+
+// retagAccessRecords returns the access required by ops: pull access to
+// their source repositories and push access to their destination
+// repositories.
+func retagAccessRecords(ops []retagOperation) []auth.Access {
+	var records []auth.Access
+	for _, op := range ops {
+		records = appendAccessRecords(records, http.MethodGet, op.SourceRepository)
+		records = appendAccessRecords(records, http.MethodPost, op.DestRepository)
+	}
+
+	type key struct {
+		auth.Resource
+		action string
+	}
+	unique := records[:0]
+	seen := make(map[key]struct{}, len(records))
+	for _, record := range records {
+		if _, ok := seen[key{record.Resource, record.Action}]; !ok {
+			seen[key{record.Resource, record.Action}] = struct{}{}
+			unique = append(unique, record)
+		}
+	}
+	return unique
+}
+
+// PostRetag points the destination tags of the request at the manifests of
+// their sources, linking the content of the manifests into the destination
+// repositories.
+func (rh *retagHandler) PostRetag(w http.ResponseWriter, r *http.Request) {
+	ops, err := decodeRetagOperations(http.MaxBytesReader(w, r.Body, maxRetagBodySize))
+	if err != nil {
+		rh.Errors = append(rh.Errors, err)
+		return
+	}
+
+	// every source must be complete before any destination is updated
+	sources := make([]distribution.Repository, len(ops))
+	digests := make([]digest.Digest, len(ops))
+	for i, op := range ops {
+		name, _ := reference.WithName(op.SourceRepository)
+		sources[i], err = rh.registry.Repository(rh, name)
+		if err != nil {
+			rh.Errors = append(rh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+			return
+		}
+		digests[i], err = resolveRetagSource(rh, sources[i], op.SourceReference)
+		if err == nil {
+			err = verifyRetagSource(rh, sources[i], digests[i])
+		}
+		if err != nil {
+			rh.Errors = append(rh.Errors, retagError(op, err))
+			return
+		}
+	}
+
+	results := make([]retagResult, 0, len(ops))
+	for i, op := range ops {
+		dest, err := rh.retagDestination(r, op.DestRepository)
+		if err != nil {
+			rh.Errors = append(rh.Errors, retagError(op, err))
+			return
+		}
+		done, err := rh.App.repositoryLimit.reserve(rh, dest.Named())
+		if err != nil {
+			rh.Errors = append(rh.Errors, retagError(op, err))
+			return
+		}
+		desc, err := copyManifest(rh, sources[i], dest, digests[i], distribution.WithTag(op.DestTag))
+		done(err == nil)
+		if err == nil {
+			err = dest.Tags(rh).Tag(rh, op.DestTag, desc)
+		}
+		if err != nil {
+			rh.Errors = append(rh.Errors, retagError(op, err))
+			return
+		}
+		dcontext.GetLogger(rh).Infof("retagged %s:%s as %s:%s", op.SourceRepository, op.SourceReference, op.DestRepository, op.DestTag)
+
+		destCtx := *rh.Context
+		destCtx.Repository = dest
+		rh.App.autoIndexer.update(&destCtx, op.DestTag)
+
+		results = append(results, retagResult{DestRepository: op.DestRepository, DestTag: op.DestTag, Digest: desc.Digest})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(results); err != nil {
+		dcontext.GetLogger(rh).Errorf("error encoding retag results: %v", err)
+	}
+}
+
+// retagDestination returns the repository name, decorated as the
+// repositories of the other requests are, so that its updates emit events.
+func (rh *retagHandler) retagDestination(r *http.Request, name string) (distribution.Repository, error) {
+	named, err := reference.WithName(name)
+	if err != nil {
+		return nil, err
+	}
+	repository, err := rh.registry.Repository(rh, named)
+	if err != nil {
+		return nil, err
+	}
+	repository, _ = notifications.Listen(repository, rh.App.repoRemover, rh.App.eventBridge(rh.Context, r))
+	repository = rh.App.lockTags(rh.App.limitTags(repository))
+	return applyRepoMiddleware(rh.App, repository, rh.App.Config.Middleware["repository"])
+}
+
+// resolveRetagSource returns the digest of the manifest ref, a tag or a
+// digest, of repository.
+func resolveRetagSource(ctx context.Context, repository distribution.Repository, ref string) (digest.Digest, error) {
+	if dgst, err := digest.Parse(ref); err == nil {
+		manifests, err := repository.Manifests(ctx)
+		if err != nil {
+			return "", err
+		}
+		exists, err := manifests.Exists(ctx, dgst)
+		if err != nil {
+			return "", err
+		}
+		if !exists {
+			return "", distribution.ErrManifestUnknownRevision{Name: repository.Named().Name(), Revision: dgst}
+		}
+		return dgst, nil
+	}
+
+	desc, err := repository.Tags(ctx).Get(ctx, ref)
+	if err != nil {
+		return "", err
+	}
+	return desc.Digest, nil
+}
+
+// verifyRetagSource checks that the blobs referenced by the manifest dgst of
+// repository, and by the manifests it indexes, exist in repository.
+func verifyRetagSource(ctx context.Context, repository distribution.Repository, dgst digest.Digest) error {
+	manifests, err := repository.Manifests(ctx)
+	if err != nil {
+		return err
+	}
+	manifest, err := manifests.Get(ctx, dgst)
+	if err != nil {
+		return err
+	}
+
+	if isIndex(manifest) {
+		for _, child := range manifest.References() {
+			// indexes may be accepted without some of their manifests
+			exists, err := manifests.Exists(ctx, child.Digest)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				continue
+			}
+			if err := verifyRetagSource(ctx, repository, child.Digest); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	blobs := repository.Blobs(ctx)
+	for _, ref := range manifest.References() {
+		if _, err := blobs.Stat(ctx, ref.Digest); err != nil {
+			if errors.Is(err, distribution.ErrBlobUnknown) && len(ref.URLs) > 0 {
+				// foreign layers are pulled from their URLs
+				continue
+			}
+			if errors.Is(err, distribution.ErrBlobUnknown) {
+				return distribution.ErrManifestBlobUnknown{Digest: ref.Digest}
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// copyManifest puts the manifest dgst of source in dest, after linking its
+// blobs and the manifests it indexes into dest, and returns its descriptor
+// in dest. Blobs are only copied when dest is stored on another backend.
+func copyManifest(ctx context.Context, source, dest distribution.Repository, dgst digest.Digest, options ...distribution.ManifestServiceOption) (v1.Descriptor, error) {
+	sourceManifests, err := source.Manifests(ctx)
+	if err != nil {
+		return v1.Descriptor{}, err
+	}
+	destManifests, err := dest.Manifests(ctx)
+	if err != nil {
+		return v1.Descriptor{}, err
+	}
+	manifest, err := sourceManifests.Get(ctx, dgst)
+	if err != nil {
+		return v1.Descriptor{}, err
+	}
+
+	if isIndex(manifest) {
+		for _, child := range manifest.References() {
+			exists, err := sourceManifests.Exists(ctx, child.Digest)
+			if err != nil {
+				return v1.Descriptor{}, err
+			}
+			if !exists {
+				continue
+			}
+			if exists, err := destManifests.Exists(ctx, child.Digest); err != nil {
+				return v1.Descriptor{}, err
+			} else if exists {
+				continue
+			}
+			if _, err := copyManifest(ctx, source, dest, child.Digest); err != nil {
+				return v1.Descriptor{}, err
+			}
+		}
+	} else {
+		for _, ref := range manifest.References() {
+			if err := mountBlob(ctx, source, dest, ref); err != nil {
+				return v1.Descriptor{}, err
+			}
+		}
+	}
+
+	put, err := destManifests.Put(ctx, manifest, options...)
+	if err != nil {
+		return v1.Descriptor{}, err
+	}
+	mediaType, payload, err := manifest.Payload()
+	if err != nil {
+		return v1.Descriptor{}, err
+	}
+	return v1.Descriptor{MediaType: mediaType, Digest: put, Size: int64(len(payload))}, nil
+}
+
+// mountBlob links the blob described by desc of source into dest.
+func mountBlob(ctx context.Context, source, dest distribution.Repository, desc v1.Descriptor) error {
+	if _, err := source.Blobs(ctx).Stat(ctx, desc.Digest); errors.Is(err, distribution.ErrBlobUnknown) && len(desc.URLs) > 0 {
+		return nil
+	}
+
+	canonical, err := reference.WithDigest(source.Named(), desc.Digest)
+	if err != nil {
+		return err
+	}
+	upload, err := dest.Blobs(ctx).Create(ctx, storage.WithMountFrom(canonical))
+	if err == nil {
+		// the mount failed, and an upload was started instead
+		_ = upload.Cancel(ctx)
+		return fmt.Errorf("unable to link blob %s from %s", desc.Digest, source.Named().Name())
+	}
+	if _, ok := err.(distribution.ErrBlobMounted); !ok {
+		return err
+	}
+	return nil
+}
+
+// isIndex reports whether manifest indexes other manifests.
+func isIndex(manifest distribution.Manifest) bool {
+	switch manifest.(type) {
+	case *manifestlist.DeserializedManifestList, *ocischema.DeserializedImageIndex:
+		return true
+	}
+	return false
+}
+
+// retagError returns the error of the API reporting that op failed with err.
+func retagError(op retagOperation, err error) error {
+	detail := fmt.Sprintf("retagging %s:%s as %s:%s: %v", op.SourceRepository, op.SourceReference, op.DestRepository, op.DestTag, err)
+
+	var blobUnknown distribution.ErrManifestBlobUnknown
+	var verification distribution.ErrManifestVerification
+	var coded errcode.Error
+	switch {
+	case errors.As(err, &blobUnknown):
+		return errcode.ErrorCodeManifestBlobUnknown.WithDetail(detail)
+	case errors.As(err, new(distribution.ErrTagUnknown)),
+		errors.As(err, new(distribution.ErrManifestUnknownRevision)),
+		errors.Is(err, distribution.ErrBlobUnknown):
+		return errcode.ErrorCodeManifestUnknown.WithDetail(detail)
+	case errors.As(err, &verification):
+		return errcode.ErrorCodeManifestInvalid.WithDetail(detail)
+	case errors.As(err, new(distribution.ErrRepositoryNameInvalid)):
+		return errcode.ErrorCodeNameInvalid.WithDetail(detail)
+	case errors.Is(err, distribution.ErrUnsupported):
+		return errcode.ErrorCodeUnsupported.WithDetail(detail)
+	case errors.Is(err, distribution.ErrAccessDenied):
+		return errcode.ErrorCodeDenied.WithDetail(detail)
+	case errors.As(err, &coded):
+		return coded
+	}
+	return errcode.ErrorCodeUnknown.WithDetail(detail)
+}
@@ -0,0 +1,316 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/notifications"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	_ "github.com/distribution/distribution/v3/registry/storage/driver/middleware/rewrite"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// postRetag posts body to the retag endpoint.
+func postRetag(t *testing.T, env *testEnv, body string) *http.Response {
+	t.Helper()
+
+	u, err := env.builder.BuildRetagURL()
+	checkErr(t, err, "building retag url")
+	resp, err := http.Post(u, "application/json", strings.NewReader(body))
+	checkErr(t, err, "posting retag operations")
+	return resp
+}
+
+// retag posts ops to the retag endpoint and returns the results.
+func retag(t *testing.T, env *testEnv, ops ...retagOperation) []retagResult {
+	t.Helper()
+
+	var body bytes.Buffer
+	checkErr(t, json.NewEncoder(&body).Encode(ops), "encoding retag operations")
+	resp := postRetag(t, env, body.String())
+	defer resp.Body.Close()
+	checkResponse(t, "posting retag operations", resp, http.StatusOK)
+
+	var results []retagResult
+	checkErr(t, json.NewDecoder(resp.Body).Decode(&results), "decoding retag results")
+	return results
+}
+
+// pullImageBlobs pulls the OCI image dgst of name with all its blobs, and
+// returns the digests of the blobs.
+func pullImageBlobs(t *testing.T, env *testEnv, name reference.Named, dgst digest.Digest) []digest.Digest {
+	t.Helper()
+
+	ref, _ := reference.WithDigest(name, dgst)
+	manifestURL, err := env.builder.BuildManifestURL(ref)
+	checkErr(t, err, "building manifest url")
+	req, err := http.NewRequest(http.MethodGet, manifestURL, nil)
+	checkErr(t, err, "building request")
+	req.Header.Set("Accept", v1.MediaTypeImageManifest)
+	resp, err := http.DefaultClient.Do(req)
+	checkErr(t, err, "fetching manifest")
+	defer resp.Body.Close()
+	checkResponse(t, "fetching manifest of "+ref.String(), resp, http.StatusOK)
+
+	var manifest ocischema.Manifest
+	checkErr(t, json.NewDecoder(resp.Body).Decode(&manifest), "decoding manifest")
+	var blobs []digest.Digest
+	for _, desc := range manifest.References() {
+		blobRef, _ := reference.WithDigest(name, desc.Digest)
+		blobURL, err := env.builder.BuildBlobURL(blobRef)
+		checkErr(t, err, "building blob url")
+		resp, err := http.Get(blobURL)
+		checkErr(t, err, "fetching blob")
+		checkResponse(t, "fetching blob "+blobRef.String(), resp, http.StatusOK)
+		content, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		checkErr(t, err, "reading blob")
+		if digest.FromBytes(content) != desc.Digest {
+			t.Fatalf("unexpected content of blob %s", blobRef)
+		}
+		blobs = append(blobs, desc.Digest)
+	}
+	return blobs
+}
+
+func TestRetag(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		pushed = make(map[string]digest.Digest)
+	)
+	sink := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var envelope struct {
+			Events []notifications.Event `json:"events"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&envelope); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		for _, event := range envelope.Events {
+			if event.Action == notifications.EventActionPush && event.Target.Tag != "" {
+				pushed[event.Target.Repository+":"+event.Target.Tag] = event.Target.Digest
+			}
+		}
+		mu.Unlock()
+	}))
+	defer sink.Close()
+
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory":    configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[any]any{"enabled": false}},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	config.Validation.Enabled = true
+	config.Validation.Manifests.Indexes.Platforms = "all"
+	config.Notifications.Endpoints = []configuration.Endpoint{
+		{Name: "sink", URL: sink.URL, Timeout: time.Second, Threshold: 3, Backoff: 100 * time.Millisecond},
+	}
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	source, _ := reference.WithName("old/app")
+	amd64 := pushPlatformImage(t, env, source, "1.0-amd64", "amd64")
+	arm64 := pushPlatformImage(t, env, source, "1.0-arm64", "arm64")
+	index, err := ocischema.FromDescriptors([]v1.Descriptor{
+		{MediaType: v1.MediaTypeImageManifest, Digest: amd64, Platform: &v1.Platform{OS: "linux", Architecture: "amd64"}},
+		{MediaType: v1.MediaTypeImageManifest, Digest: arm64, Platform: &v1.Platform{OS: "linux", Architecture: "arm64"}},
+	}, nil)
+	checkErr(t, err, "building index")
+	index.Versioned = specs.Versioned{SchemaVersion: 2}
+	tagRef, _ := reference.WithTag(source, "1.0")
+	manifestURL, err := env.builder.BuildManifestURL(tagRef)
+	checkErr(t, err, "building manifest url")
+	resp := putManifest(t, "putting index", manifestURL, v1.MediaTypeImageIndex, index)
+	resp.Body.Close()
+	checkResponse(t, "putting index", resp, http.StatusCreated)
+	indexDigest := digest.Digest(resp.Header.Get("Docker-Content-Digest"))
+
+	unknown := digest.FromString("unknown")
+	for _, tc := range []struct {
+		body  string
+		codes []errcode.ErrorCode
+	}{
+		{`{"sourceRepository": "old/app"}`, []errcode.ErrorCode{errcode.ErrorCodeRetagInvalid}},
+		{`[]`, []errcode.ErrorCode{errcode.ErrorCodeRetagInvalid}},
+		{`[{"sourceRepository": "old/app", "sourceReference": "1.0", "destRepository": "new/app", "destTag": "1.0", "force": true}]`, []errcode.ErrorCode{errcode.ErrorCodeRetagInvalid}},
+		{`[{"sourceRepository": "Old/App", "sourceReference": "1.0", "destRepository": "new/app", "destTag": "1.0"}]`, []errcode.ErrorCode{errcode.ErrorCodeNameInvalid}},
+		{`[{"sourceRepository": "old/app", "sourceReference": "-1.0", "destRepository": "new/app", "destTag": "1.0"}]`, []errcode.ErrorCode{errcode.ErrorCodeTagInvalid}},
+		{`[{"sourceRepository": "old/app", "sourceReference": "sha256:invalid", "destRepository": "new/app", "destTag": "1.0"}]`, []errcode.ErrorCode{errcode.ErrorCodeDigestInvalid}},
+		{`[{"sourceRepository": "old/app", "sourceReference": "1.0", "destRepository": "new/app", "destTag": "1.0"}, {"sourceRepository": "old/app", "sourceReference": "1.0-amd64", "destRepository": "new/app", "destTag": "1.0"}]`, []errcode.ErrorCode{errcode.ErrorCodeRetagInvalid}},
+		{`[{"sourceRepository": "old/app", "sourceReference": "1.0", "destRepository": "new/app", "destTag": "1.0"}, {"sourceRepository": "old/app", "sourceReference": "2.0", "destRepository": "new/app", "destTag": "2.0"}]`, []errcode.ErrorCode{errcode.ErrorCodeManifestUnknown}},
+		{`[{"sourceRepository": "old/app", "sourceReference": "` + unknown.String() + `", "destRepository": "new/app", "destTag": "1.0"}]`, []errcode.ErrorCode{errcode.ErrorCodeManifestUnknown}},
+	} {
+		resp := postRetag(t, env, tc.body)
+		checkBodyHasErrorCodes(t, "posting invalid retag operations", resp, tc.codes...)
+		resp.Body.Close()
+	}
+
+	// none of the rejected requests tagged anything
+	dest, _ := reference.WithName("new/app")
+	destTagRef, _ := reference.WithTag(dest, "1.0")
+	destManifestURL, err := env.builder.BuildManifestURL(destTagRef)
+	checkErr(t, err, "building manifest url")
+	resp, err = http.Head(destManifestURL)
+	checkErr(t, err, "fetching manifest")
+	resp.Body.Close()
+	checkResponse(t, "fetching rejected tag", resp, http.StatusNotFound)
+
+	results := retag(t, env,
+		retagOperation{SourceRepository: "old/app", SourceReference: "1.0", DestRepository: "new/app", DestTag: "1.0"},
+		retagOperation{SourceRepository: "old/app", SourceReference: arm64.String(), DestRepository: "new/app", DestTag: "1.0-arm64"},
+		retagOperation{SourceRepository: "old/app", SourceReference: "1.0-amd64", DestRepository: "old/app", DestTag: "stable"},
+	)
+	expected := []retagResult{
+		{DestRepository: "new/app", DestTag: "1.0", Digest: indexDigest},
+		{DestRepository: "new/app", DestTag: "1.0-arm64", Digest: arm64},
+		{DestRepository: "old/app", DestTag: "stable", Digest: amd64},
+	}
+	if fmt.Sprint(results) != fmt.Sprint(expected) {
+		t.Fatalf("unexpected results: %v != %v", results, expected)
+	}
+
+	// the destinations are complete, and the sources untouched
+	if _, dgst := getIndex(t, env, dest, "1.0"); dgst != indexDigest {
+		t.Errorf("unexpected digest of new/app:1.0: %s != %s", dgst, indexDigest)
+	}
+	if dgst := tagDigest(t, env, dest, "1.0-arm64"); dgst != arm64 {
+		t.Errorf("unexpected digest of new/app:1.0-arm64: %s != %s", dgst, arm64)
+	}
+	pullImageBlobs(t, env, dest, amd64)
+	pullImageBlobs(t, env, dest, arm64)
+	if _, dgst := getIndex(t, env, source, "1.0"); dgst != indexDigest {
+		t.Errorf("unexpected digest of old/app:1.0: %s != %s", dgst, indexDigest)
+	}
+	if dgst := tagDigest(t, env, source, "1.0-amd64"); dgst != amd64 {
+		t.Errorf("unexpected digest of old/app:1.0-amd64: %s != %s", dgst, amd64)
+	}
+	pullImageBlobs(t, env, source, amd64)
+
+	// the destinations emit push events
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		mu.Lock()
+		done := pushed["new/app:1.0"] == indexDigest && pushed["new/app:1.0-arm64"] == arm64 && pushed["old/app:stable"] == amd64
+		events := fmt.Sprint(pushed)
+		mu.Unlock()
+		if done {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("missing push events of the retag operations: %s", events)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// TestRetagAcrossBackends validates that retagging to a repository stored on
+// another backend copies the content of the manifest.
+func TestRetagAcrossBackends(t *testing.T) {
+	testRetagAcrossBackends(t, nil)
+}
+
+// TestRetagAcrossBackendsWithStorageMiddleware validates that blobs are
+// copied across backends when storage middlewares wrap the routing driver.
+func TestRetagAcrossBackendsWithStorageMiddleware(t *testing.T) {
+	testRetagAcrossBackends(t, []configuration.Middleware{{Name: "rewrite", Options: configuration.Parameters{}}})
+}
+
+// testRetagAcrossBackends retags an image into a repository stored on
+// another backend, with the storage middlewares middleware, and checks that
+// the backend of the destination holds the data of its blobs.
+func testRetagAcrossBackends(t *testing.T, middleware []configuration.Middleware) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory":    configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[any]any{"enabled": false}},
+			"routes": configuration.Parameters{
+				"backends": map[any]any{
+					"archive": map[any]any{"driver": "inmemory"},
+				},
+				"rules": []any{
+					map[any]any{"repositories": []any{"archive/*"}, "backend": "archive"},
+				},
+			},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	config.Middleware = map[string][]configuration.Middleware{"storage": middleware}
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	source, _ := reference.WithName("team/app")
+	dgst := pushPlatformImage(t, env, source, "1.0", "amd64")
+
+	results := retag(t, env, retagOperation{SourceRepository: "team/app", SourceReference: "1.0", DestRepository: "archive/app", DestTag: "1.0"})
+	if len(results) != 1 || results[0].Digest != dgst {
+		t.Fatalf("unexpected results: %v", results)
+	}
+
+	dest, _ := reference.WithName("archive/app")
+	if d := tagDigest(t, env, dest, "1.0"); d != dgst {
+		t.Fatalf("unexpected digest of archive/app:1.0: %s != %s", d, dgst)
+	}
+	blobs := pullImageBlobs(t, env, dest, dgst)
+	pullImageBlobs(t, env, source, dgst)
+
+	// the archive backend holds the data of the blobs of archive/app
+	for _, dgst := range append(blobs, dgst) {
+		blobPath := path.Join("/docker/registry/v2/blobs", dgst.Algorithm().String(), dgst.Encoded()[:2], dgst.Encoded(), "data")
+		if _, err := env.app.driver.Stat(storagedriver.WithRepository(env.ctx, "archive/app"), blobPath); err != nil {
+			t.Errorf("blob %s not copied to the archive backend: %v", dgst, err)
+		}
+	}
+}
+
+// TestRetagAuthorization validates that retagging requires pull access to the
+// source repositories and push access to the destination repositories.
+func TestRetagAuthorization(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory":    configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[any]any{"enabled": false}},
+		},
+		Auth: configuration.Auth{"silly": {"realm": "https://auth.example.com/token", "service": "silly-service"}},
+	}
+	config.HTTP.Headers = headerConfig
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	body := `[{"sourceRepository": "old/app", "sourceReference": "1.0", "destRepository": "new/app", "destTag": "1.0"}, {"sourceRepository": "old/app", "sourceReference": "2.0", "destRepository": "new/app", "destTag": "2.0"}]`
+	resp := postRetag(t, env, body)
+	resp.Body.Close()
+	checkResponse(t, "posting unauthorized retag operations", resp, http.StatusUnauthorized)
+	expected := `scope="repository:old/app:pull repository:new/app:pull repository:new/app:push"`
+	if challenge := resp.Header.Get("WWW-Authenticate"); !strings.Contains(challenge, expected) {
+		t.Fatalf("unexpected challenge %q, expected %s", challenge, expected)
+	}
+
+	// the operations are still read once authorized
+	u, err := env.builder.BuildRetagURL()
+	checkErr(t, err, "building retag url")
+	req, err := http.NewRequest(http.MethodPost, u, strings.NewReader(body))
+	checkErr(t, err, "building request")
+	req.Header.Set("Authorization", "Bearer token")
+	resp, err = http.DefaultClient.Do(req)
+	checkErr(t, err, "posting retag operations")
+	defer resp.Body.Close()
+	checkBodyHasErrorCodes(t, "posting retag operations of unknown tags", resp, errcode.ErrorCodeManifestUnknown)
+}
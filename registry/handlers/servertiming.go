@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/internal/servertiming"
+)
+
+// serverTiming reports the time spent in the phases of a request through
+// the Server-Timing response header.
+type serverTiming struct {
+	requestHeader string
+}
+
+// newServerTiming returns the reporting described by config, or nil if the
+// header is disabled.
+func newServerTiming(config configuration.ServerTiming) *serverTiming {
+	if !config.Enabled {
+		return nil
+	}
+	return &serverTiming{requestHeader: config.RequestHeader}
+}
+
+// apply starts collecting the timings of r if they are to be reported. The
+// returned response writer adds the header once the handler writes the
+// response status. A nil serverTiming leaves w and r unchanged.
+func (s *serverTiming) apply(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, *http.Request) {
+	if s == nil {
+		return w, r
+	}
+	if s.requestHeader != "" && r.Header.Get(s.requestHeader) == "" {
+		return w, r
+	}
+
+	ctx, timings := servertiming.WithTimings(r.Context())
+	tw := &serverTimingResponseWriter{
+		ResponseWriter: w,
+		timings:        timings,
+		start:          time.Now(),
+	}
+	return tw, r.WithContext(ctx)
+}
+
+// startHandler marks the start of the handler phase of the request served
+// through w.
+func startHandler(w http.ResponseWriter) {
+	if tw, ok := w.(*serverTimingResponseWriter); ok {
+		tw.handlerStart = time.Now()
+	}
+}
+
+// serverTimingResponseWriter adds the Server-Timing header to the response
+// when its status is written. The handler and total phases end there, as
+// the time spent writing the body cannot be reported in the header.
+type serverTimingResponseWriter struct {
+	http.ResponseWriter
+	timings      *servertiming.Timings
+	start        time.Time
+	handlerStart time.Time
+	wroteHeader  bool
+}
+
+func (w *serverTimingResponseWriter) WriteHeader(status int) {
+	if !w.wroteHeader && status >= http.StatusOK {
+		w.wroteHeader = true
+		if !w.handlerStart.IsZero() {
+			w.timings.Add("handler", time.Since(w.handlerStart))
+		}
+		w.timings.Add("total", time.Since(w.start))
+		w.Header().Set(servertiming.HeaderName, w.timings.String())
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *serverTimingResponseWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *serverTimingResponseWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap returns the wrapped response writer, for http.ResponseController.
+func (w *serverTimingResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/reference"
+)
+
+func TestServerTiming(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		config        configuration.ServerTiming
+		requestHeader http.Header
+		expected      bool
+	}{
+		{
+			name: "disabled",
+		},
+		{
+			name:     "enabled",
+			config:   configuration.ServerTiming{Enabled: true},
+			expected: true,
+		},
+		{
+			name:   "missing request header",
+			config: configuration.ServerTiming{Enabled: true, RequestHeader: "X-Debug-Timing"},
+		},
+		{
+			name:          "request header",
+			config:        configuration.ServerTiming{Enabled: true, RequestHeader: "X-Debug-Timing"},
+			requestHeader: http.Header{"X-Debug-Timing": []string{"1"}},
+			expected:      true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			config := configuration.Configuration{
+				Storage: configuration.Storage{
+					"inmemory":    configuration.Parameters{},
+					"maintenance": configuration.Parameters{"uploadpurging": map[any]any{"enabled": false}},
+				},
+			}
+			config.HTTP.Headers = headerConfig
+			config.HTTP.ServerTiming = tc.config
+			env := newTestEnvWithConfig(t, &config)
+			defer env.Shutdown()
+
+			imageName, _ := reference.WithName("foo/timing")
+			createRepository(env, t, imageName.Name(), "latest")
+
+			tagRef, _ := reference.WithTag(imageName, "latest")
+			manifestURL, err := env.builder.BuildManifestURL(tagRef)
+			if err != nil {
+				t.Fatalf("unexpected error building manifest url: %v", err)
+			}
+
+			req, err := http.NewRequest(http.MethodGet, manifestURL, nil)
+			if err != nil {
+				t.Fatalf("unexpected error creating request: %v", err)
+			}
+			for name, values := range tc.requestHeader {
+				req.Header[name] = values
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("unexpected error fetching manifest: %v", err)
+			}
+			defer resp.Body.Close()
+			checkResponse(t, "fetching manifest", resp, http.StatusOK)
+
+			header := resp.Header.Get("Server-Timing")
+			if !tc.expected {
+				if header != "" {
+					t.Fatalf("unexpected Server-Timing header: %q", header)
+				}
+				return
+			}
+
+			var phases []string
+			for metric := range strings.SplitSeq(header, ", ") {
+				name, _, _ := strings.Cut(metric, ";")
+				phases = append(phases, name)
+			}
+			if strings.Join(phases, ",") != "auth,repository,storage,handler,total" {
+				t.Fatalf("unexpected Server-Timing header: %q", header)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+)
+
+// defaultStorageRetryAfter is advertised to clients whose request failed
+// because the storage backend throttled the registry, when no retry delay is
+// configured.
+const defaultStorageRetryAfter = time.Second
+
+// storageErrors maps the errors of the storage driver failing requests to
+// responses: throttling is answered with 503 Service Unavailable, so that
+// clients retry later, and permission errors, which denote a misconfigured
+// registry rather than a client error, are logged apart.
+type storageErrors struct {
+	audit      bool
+	retryAfter time.Duration
+}
+
+// newStorageErrors returns the handling of storage errors described by
+// config.
+func newStorageErrors(config configuration.StorageErrors) *storageErrors {
+	s := &storageErrors{
+		audit:      config.Audit,
+		retryAfter: config.RetryAfter,
+	}
+	if s.retryAfter <= 0 {
+		s.retryAfter = defaultStorageRetryAfter
+	}
+	return s
+}
+
+// apply classifies the storage driver errors of the request served by ctx,
+// replacing those caused by throttling with 503 Service Unavailable errors
+// advertising a retry delay.
+func (s *storageErrors) apply(ctx *Context, w http.ResponseWriter) {
+	if s == nil {
+		return
+	}
+
+	throttled := false
+	for i, e := range ctx.Errors {
+		var driverErr storagedriver.Error
+		if !errors.As(storageErrorOf(e), &driverErr) {
+			continue
+		}
+
+		class := storagedriver.Classify(driverErr)
+		logger := dcontext.GetLoggerWithFields(ctx, map[any]any{
+			"storage.driver": driverErr.DriverName,
+			"storage.action": driverErr.Action,
+			"storage.class":  class,
+		})
+		switch {
+		case class == storagedriver.ErrorClassPermissionDenied:
+			logger.Errorf("storage backend denied access to the registry: %v", driverErr.Detail)
+		case s.audit:
+			logger.Warnf("storage driver error: %v", driverErr.Detail)
+		}
+
+		if class == storagedriver.ErrorClassThrottled {
+			ctx.Errors[i] = errcode.ErrorCodeUnavailable.WithDetail("storage backend is throttling requests")
+			throttled = true
+		}
+	}
+
+	if throttled {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(s.retryAfter.Seconds()))))
+	}
+}
+
+// storageErrorOf returns the error reported by a handler, unwrapping the
+// errcode error carrying it as detail.
+func storageErrorOf(e error) error {
+	var codeErr errcode.Error
+	if errors.As(e, &codeErr) {
+		if detail, ok := codeErr.Detail.(error); ok {
+			return detail
+		}
+		return nil
+	}
+	return e
+}
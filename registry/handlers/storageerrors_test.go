@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/base"
+	"github.com/distribution/distribution/v3/registry/storage/driver/factory"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/reference"
+)
+
+// failingDriverFactory implements the factory.StorageDriverFactory
+// interface.
+type failingDriverFactory struct{}
+
+func (factory *failingDriverFactory) Create(ctx context.Context, parameters map[string]any) (storagedriver.StorageDriver, error) {
+	// the storage drivers report errors through the base driver, which
+	// records the failed action
+	return &base.Base{StorageDriver: &failingDriver{
+		StorageDriver: inmemory.New(),
+		err:           backendError{code: parameters["code"].(string), status: parameters["status"].(int)},
+	}}, nil
+}
+
+// backendError mimics the errors of the SDKs of storage backends.
+type backendError struct {
+	code   string
+	status int
+}
+
+func (e backendError) Error() string   { return e.code }
+func (e backendError) Code() string    { return e.code }
+func (e backendError) StatusCode() int { return e.status }
+
+// failingDriver fails tag lookups with err.
+type failingDriver struct {
+	storagedriver.StorageDriver
+	err error
+}
+
+func (d *failingDriver) GetContent(ctx context.Context, path string) ([]byte, error) {
+	if strings.Contains(path, "/_manifests/tags/") {
+		return nil, d.err
+	}
+	return d.StorageDriver.GetContent(ctx, path)
+}
+
+func TestStorageErrors(t *testing.T) {
+	factory.Register("failingstorage", &failingDriverFactory{})
+
+	imageName, _ := reference.WithName("foo/bar")
+	tagRef, _ := reference.WithTag(imageName, "latest")
+
+	for _, tc := range []struct {
+		name       string
+		code       string
+		status     int
+		expected   int
+		retryAfter string
+	}{
+		{name: "throttled", code: "SlowDown", status: http.StatusServiceUnavailable, expected: http.StatusServiceUnavailable, retryAfter: "2"},
+		{name: "permission denied", code: "AccessDenied", status: http.StatusForbidden, expected: http.StatusInternalServerError},
+		{name: "transient", code: "InternalError", status: http.StatusInternalServerError, expected: http.StatusInternalServerError},
+		{name: "fatal", code: "InvalidArgument", status: http.StatusBadRequest, expected: http.StatusInternalServerError},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			config := configuration.Configuration{
+				Storage: configuration.Storage{
+					"failingstorage": configuration.Parameters{"code": tc.code, "status": tc.status},
+					"maintenance":    configuration.Parameters{"uploadpurging": map[any]any{"enabled": false}},
+				},
+			}
+			config.HTTP.Headers = headerConfig
+			config.HTTP.StorageErrors = configuration.StorageErrors{Audit: true, RetryAfter: 1500 * time.Millisecond}
+			env := newTestEnvWithConfig(t, &config)
+			defer env.Shutdown()
+
+			manifestURL, err := env.builder.BuildManifestURL(tagRef)
+			checkErr(t, err, "building manifest url")
+			resp, err := http.Get(manifestURL)
+			checkErr(t, err, "fetching manifest")
+			defer resp.Body.Close()
+
+			checkResponse(t, "fetching manifest", resp, tc.expected)
+			if tc.expected == http.StatusServiceUnavailable {
+				checkBodyHasErrorCodes(t, "fetching manifest", resp, errcode.ErrorCodeUnavailable)
+			} else {
+				checkBodyHasErrorCodes(t, "fetching manifest", resp, errcode.ErrorCodeUnknown)
+			}
+			if retryAfter := resp.Header.Get("Retry-After"); retryAfter != tc.retryAfter {
+				t.Fatalf("expected Retry-After %q, got %q", tc.retryAfter, retryAfter)
+			}
+		})
+	}
+}
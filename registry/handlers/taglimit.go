@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// The actions taken by the pushes of new tags in repositories holding the
+// maximum number of tags.
+const (
+	tagLimitActionReject = "reject"
+	tagLimitActionEvict  = "evict"
+)
+
+// tagLimit caps the number of tags of each repository.
+type tagLimit struct {
+	max   int
+	evict bool
+}
+
+// newTagLimit validates config and returns the limit it describes, or nil if
+// the tags are unlimited. Evicting tags requires deleteEnabled.
+func newTagLimit(config configuration.TagLimit, deleteEnabled bool) (*tagLimit, error) {
+	if config.Max < 0 {
+		return nil, fmt.Errorf("max must not be negative")
+	}
+	if config.Max == 0 {
+		return nil, nil
+	}
+
+	limit := &tagLimit{max: config.Max}
+	switch config.Action {
+	case "", tagLimitActionReject:
+	case tagLimitActionEvict:
+		if !deleteEnabled {
+			return nil, fmt.Errorf("action %q requires storage.delete.enabled", tagLimitActionEvict)
+		}
+		limit.evict = true
+	default:
+		return nil, fmt.Errorf("action must be %q or %q, %q invalid", tagLimitActionReject, tagLimitActionEvict, config.Action)
+	}
+	return limit, nil
+}
+
+// limitTags decorates repository so that the tags created through it, by
+// any request, fit in the tag limit. It must wrap the event bridge, so that
+// the evictions emit tag deletion events.
+func (app *App) limitTags(repository distribution.Repository) distribution.Repository {
+	if app.tagLimit == nil {
+		return repository
+	}
+	return &tagLimitRepository{Repository: repository, app: app}
+}
+
+type tagLimitRepository struct {
+	distribution.Repository
+	app *App
+}
+
+func (r *tagLimitRepository) Tags(ctx context.Context) distribution.TagService {
+	return &tagLimitTagService{
+		TagService: r.Repository.Tags(ctx),
+		name:       r.Named().Name(),
+		app:        r.app,
+	}
+}
+
+// tagLimitTagService checks the tag limit of a repository before creating
+// tags, evicting the least recently updated tags if configured to. It runs
+// under the lock of the repository taken by lockTags, from the check to the
+// creation, so that concurrent requests do not exceed the limit together.
+type tagLimitTagService struct {
+	distribution.TagService
+	name string
+	app  *App
+}
+
+func (ts *tagLimitTagService) Tag(ctx context.Context, tag string, desc v1.Descriptor) error {
+	if err := ts.makeRoom(ctx, []string{tag}); err != nil {
+		return err
+	}
+	return ts.TagService.Tag(ctx, tag, desc)
+}
+
+func (ts *tagLimitTagService) TagBatch(ctx context.Context, ops []distribution.TagOperation) error {
+	batcher, ok := ts.TagService.(distribution.TagBatcher)
+	if !ok {
+		return distribution.ErrUnsupported
+	}
+
+	tags := make([]string, 0, len(ops))
+	for _, op := range ops {
+		tags = append(tags, op.Tag)
+	}
+	if err := ts.makeRoom(ctx, tags); err != nil {
+		return err
+	}
+	return batcher.TagBatch(ctx, ops)
+}
+
+func (ts *tagLimitTagService) ListFiltered(ctx context.Context, opts distribution.TagListOptions, limit int, last string) ([]distribution.TagInfo, error) {
+	lister, ok := ts.TagService.(distribution.TagFilterLister)
+	if !ok {
+		return nil, distribution.ErrUnsupported
+	}
+	return lister.ListFiltered(ctx, opts, limit, last)
+}
+
+// makeRoom checks that the tags created fit in the tag limit, evicting the
+// least recently updated other tags to make room for them if configured to.
+// Updates of existing tags always fit.
+func (ts *tagLimitTagService) makeRoom(ctx context.Context, tags []string) error {
+	evicted, err := ts.checkTagLimit(ctx, tags)
+	if err != nil {
+		return err
+	}
+	for _, tag := range evicted {
+		if err := ts.TagService.Untag(ctx, tag); err != nil && !errors.As(err, new(distribution.ErrTagUnknown)) {
+			return fmt.Errorf("evicting tag %s: %w", tag, err)
+		}
+		dcontext.GetLogger(ctx).Infof("evicted tag %s of repository %s to make room for tags %v", tag, ts.name, tags)
+	}
+	return nil
+}
+
+// checkTagLimit returns the least recently updated tags to evict to make room
+// for the tags created, or an error if they are rejected.
+func (ts *tagLimitTagService) checkTagLimit(ctx context.Context, tags []string) ([]string, error) {
+	limit := ts.app.tagLimit
+	kept := make(map[string]bool, len(tags))
+	created := 0
+	for _, tag := range tags {
+		if kept[tag] {
+			continue
+		}
+		kept[tag] = true
+		if _, err := ts.TagService.Get(ctx, tag); err == nil {
+			continue
+		} else if !errors.As(err, new(distribution.ErrTagUnknown)) {
+			return nil, errcode.ErrorCodeUnknown.WithDetail(err)
+		}
+		created++
+	}
+	if created == 0 {
+		return nil, nil
+	}
+
+	lister, ok := ts.TagService.(distribution.TagFilterLister)
+	if !ok {
+		return nil, errcode.ErrorCodeUnsupported.WithDetail("tag limits are not supported")
+	}
+	infos, err := lister.ListFiltered(ctx, distribution.TagListOptions{Details: true}, -1, "")
+	if err != nil && err != io.EOF && !errors.As(err, new(distribution.ErrRepositoryUnknown)) {
+		return nil, errcode.ErrorCodeUnknown.WithDetail(err)
+	}
+	excess := len(infos) + created - limit.max
+	if excess <= 0 {
+		return nil, nil
+	}
+	if !limit.evict {
+		return nil, errcode.ErrorCodeDenied.WithDetail(fmt.Sprintf("repository %s holds the maximum of %d tags", ts.name, limit.max))
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		if !infos[i].ModTime.Equal(infos[j].ModTime) {
+			return infos[i].ModTime.Before(infos[j].ModTime)
+		}
+		return infos[i].Name < infos[j].Name
+	})
+	all := make([]string, 0, len(infos))
+	for _, info := range infos {
+		all = append(all, info.Name)
+	}
+	var rules []tagProtectionRule
+	for _, rule := range ts.app.tagProtection {
+		if rule.appliesTo(ts.name) {
+			rules = append(rules, rule)
+		}
+	}
+
+	var evicted []string
+candidates:
+	for _, tag := range all {
+		// the tags updated by the request are not evicted
+		if kept[tag] {
+			continue
+		}
+		candidate := append(evicted[:len(evicted):len(evicted)], tag)
+		for _, rule := range rules {
+			if rule.check(all, candidate) != nil {
+				continue candidates
+			}
+		}
+		evicted = candidate
+		if len(evicted) == excess {
+			return evicted, nil
+		}
+	}
+	return nil, errcode.ErrorCodeDenied.WithDetail(fmt.Sprintf("repository %s holds the maximum of %d tags, and the tags which may be evicted are protected", ts.name, limit.max))
+}
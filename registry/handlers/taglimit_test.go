@@ -0,0 +1,259 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/notifications"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestNewTagLimit(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		config        configuration.TagLimit
+		deleteEnabled bool
+		enabled       bool
+		err           bool
+	}{
+		{name: "unlimited"},
+		{name: "reject", config: configuration.TagLimit{Max: 10}, enabled: true},
+		{name: "explicit reject", config: configuration.TagLimit{Max: 10, Action: "reject"}, enabled: true},
+		{name: "evict", config: configuration.TagLimit{Max: 10, Action: "evict"}, deleteEnabled: true, enabled: true},
+		{name: "evict without delete", config: configuration.TagLimit{Max: 10, Action: "evict"}, err: true},
+		{name: "negative", config: configuration.TagLimit{Max: -1}, err: true},
+		{name: "invalid action", config: configuration.TagLimit{Max: 10, Action: "drop"}, err: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			limit, err := newTagLimit(tc.config, tc.deleteEnabled)
+			if tc.err {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if (limit != nil) != tc.enabled {
+				t.Fatalf("unexpected limit: %v", limit)
+			}
+		})
+	}
+}
+
+// tagLimitTestEnv returns a test environment limiting the tags of each
+// repository to 2 with action, and protecting the tags starting with v, along
+// with a function pushing an image to foo/app by tag.
+func tagLimitTestEnv(t *testing.T, action string, endpoints ...configuration.Endpoint) (*testEnv, func(tag string) *http.Response) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory":    configuration.Parameters{},
+			"delete":      configuration.Parameters{"enabled": true},
+			"maintenance": configuration.Parameters{"uploadpurging": map[any]any{"enabled": false}},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	config.Validation.Tags.Limit = configuration.TagLimit{Max: 2, Action: action}
+	config.Validation.Tags.Protect = []configuration.TagProtectionRule{{Repositories: []string{"foo/*"}, Tags: "v.*"}}
+	config.Notifications.Endpoints = endpoints
+	env := newTestEnvWithConfig(t, &config)
+
+	name, _ := reference.WithName("foo/app")
+	empty := []byte("{}")
+	uploadURLBase, _ := startPushLayer(t, env, name)
+	pushLayer(t, env.builder, name, digest.FromBytes(empty), uploadURLBase, strings.NewReader(string(empty)))
+
+	manifest := map[string]any{
+		"schemaVersion": 2,
+		"mediaType":     v1.MediaTypeImageManifest,
+		"config":        v1.DescriptorEmptyJSON,
+		"layers":        []v1.Descriptor{},
+	}
+	push := func(tag string) *http.Response {
+		tagRef, _ := reference.WithTag(name, tag)
+		manifestURL, err := env.builder.BuildManifestURL(tagRef)
+		checkErr(t, err, "building manifest url")
+		return putManifest(t, "pushing "+tag, manifestURL, v1.MediaTypeImageManifest, manifest)
+	}
+	return env, push
+}
+
+func TestTagLimit(t *testing.T) {
+	pushed := func(t *testing.T, push func(tag string) *http.Response, tag string) {
+		t.Helper()
+		resp := push(tag)
+		resp.Body.Close()
+		checkResponse(t, "pushing "+tag, resp, http.StatusCreated)
+		// tags are evicted by last update
+		time.Sleep(10 * time.Millisecond)
+	}
+	denied := func(t *testing.T, push func(tag string) *http.Response, tag string) {
+		t.Helper()
+		resp := push(tag)
+		defer resp.Body.Close()
+		checkResponse(t, "pushing "+tag, resp, http.StatusForbidden)
+		checkBodyHasErrorCodes(t, "pushing "+tag, resp, errcode.ErrorCodeDenied)
+	}
+	tags := func(t *testing.T, env *testEnv) []string {
+		t.Helper()
+		name, _ := reference.WithName("foo/app")
+		tagsURL, err := env.builder.BuildTagsURL(name)
+		checkErr(t, err, "building tags url")
+		resp, err := http.Get(tagsURL)
+		checkErr(t, err, "listing tags")
+		defer resp.Body.Close()
+		var list tagsAPIResponse
+		checkErr(t, json.NewDecoder(resp.Body).Decode(&list), "decoding tags")
+		slices.Sort(list.Tags)
+		return list.Tags
+	}
+
+	t.Run("reject", func(t *testing.T) {
+		env, push := tagLimitTestEnv(t, "")
+		defer env.Shutdown()
+
+		pushed(t, push, "a")
+		pushed(t, push, "b")
+		denied(t, push, "c")
+		// updates of existing tags fit
+		pushed(t, push, "a")
+		if got := tags(t, env); !slices.Equal(got, []string{"a", "b"}) {
+			t.Fatalf("unexpected tags %v", got)
+		}
+	})
+
+	t.Run("evict", func(t *testing.T) {
+		var (
+			mu      sync.Mutex
+			deleted []string
+		)
+		sink := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var envelope struct {
+				Events []notifications.Event `json:"events"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&envelope); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			mu.Lock()
+			for _, event := range envelope.Events {
+				if event.Action == notifications.EventActionDelete && event.Target.Tag != "" {
+					deleted = append(deleted, event.Target.Tag)
+				}
+			}
+			mu.Unlock()
+		}))
+		defer sink.Close()
+
+		env, push := tagLimitTestEnv(t, "evict", configuration.Endpoint{
+			Name: "sink", URL: sink.URL, Timeout: time.Second, Threshold: 3, Backoff: 100 * time.Millisecond,
+		})
+		defer env.Shutdown()
+
+		// the protected v1 is the oldest tag, a is evicted instead
+		pushed(t, push, "v1")
+		pushed(t, push, "a")
+		pushed(t, push, "b")
+		if got := tags(t, env); !slices.Equal(got, []string{"b", "v1"}) {
+			t.Fatalf("unexpected tags %v", got)
+		}
+
+		// v1 stays protected, b is evicted
+		pushed(t, push, "v2")
+		if got := tags(t, env); !slices.Equal(got, []string{"v1", "v2"}) {
+			t.Fatalf("unexpected tags %v", got)
+		}
+
+		// only protected tags remain
+		denied(t, push, "c")
+
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			mu.Lock()
+			done := slices.Equal(deleted, []string{"a", "b"})
+			events := fmt.Sprint(deleted)
+			mu.Unlock()
+			if done {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("missing delete events of the evicted tags: %s", events)
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	})
+	t.Run("batch", func(t *testing.T) {
+		env, push := tagLimitTestEnv(t, "")
+		defer env.Shutdown()
+		name, _ := reference.WithName("foo/app")
+
+		pushed(t, push, "a")
+		pushed(t, push, "b")
+		dgst := tagDigest(t, env, name, "a")
+
+		resp := postTagOperations(t, env, name, `[{"tag": "a", "digest": "`+dgst.String()+`"}, {"tag": "c", "digest": "`+dgst.String()+`"}]`)
+		checkResponse(t, "posting tag operations", resp, http.StatusForbidden)
+		checkBodyHasErrorCodes(t, "posting tag operations", resp, errcode.ErrorCodeDenied)
+		resp.Body.Close()
+
+		// updates of existing tags fit
+		resp = postTagOperations(t, env, name, `[{"tag": "a", "digest": "`+dgst.String()+`"}, {"tag": "b", "digest": "`+dgst.String()+`"}]`)
+		resp.Body.Close()
+		checkResponse(t, "posting tag operations", resp, http.StatusNoContent)
+		if got := tags(t, env); !slices.Equal(got, []string{"a", "b"}) {
+			t.Fatalf("unexpected tags %v", got)
+		}
+	})
+
+	t.Run("batch evict", func(t *testing.T) {
+		env, push := tagLimitTestEnv(t, "evict")
+		defer env.Shutdown()
+		name, _ := reference.WithName("foo/app")
+
+		pushed(t, push, "a")
+		pushed(t, push, "b")
+		dgst := tagDigest(t, env, name, "a")
+
+		// a is the oldest tag, but it is updated by the batch: b is evicted
+		resp := postTagOperations(t, env, name, `[{"tag": "a", "digest": "`+dgst.String()+`"}, {"tag": "c", "digest": "`+dgst.String()+`"}]`)
+		resp.Body.Close()
+		checkResponse(t, "posting tag operations", resp, http.StatusNoContent)
+		if got := tags(t, env); !slices.Equal(got, []string{"a", "c"}) {
+			t.Fatalf("unexpected tags %v", got)
+		}
+	})
+
+	t.Run("retag", func(t *testing.T) {
+		env, push := tagLimitTestEnv(t, "")
+		defer env.Shutdown()
+
+		pushed(t, push, "a")
+		pushed(t, push, "b")
+
+		resp := postRetag(t, env, `[{"sourceRepository": "foo/app", "sourceReference": "a", "destRepository": "foo/app", "destTag": "c"}]`)
+		checkResponse(t, "retagging", resp, http.StatusForbidden)
+		checkBodyHasErrorCodes(t, "retagging", resp, errcode.ErrorCodeDenied)
+		resp.Body.Close()
+		if got := tags(t, env); !slices.Equal(got, []string{"a", "b"}) {
+			t.Fatalf("unexpected tags %v", got)
+		}
+
+		// the limit applies to each repository
+		results := retag(t, env, retagOperation{SourceRepository: "foo/app", SourceReference: "a", DestRepository: "foo/other", DestTag: "c"})
+		if len(results) != 1 || results[0].DestTag != "c" {
+			t.Fatalf("unexpected results %v", results)
+		}
+	})
+}
@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/gorilla/handlers"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	// maxTagOperations bounds the number of tags updated by a request.
+	maxTagOperations = 100
+
+	// maxTagOperationsBodySize bounds the size of the body of a request.
+	maxTagOperationsBodySize = 64 * 1024
+
+	// tagOperationsLockTimeout bounds the time a request waits for the
+	// operations of other requests on the repository.
+	tagOperationsLockTimeout = 30 * time.Second
+)
+
+// tagOperationsDispatcher constructs the tag operations handler.
+func tagOperationsDispatcher(ctx *Context, r *http.Request) http.Handler {
+	tagOperationsHandler := &tagOperationsHandler{
+		Context: ctx,
+	}
+
+	mhandler := handlers.MethodHandler{}
+	if !ctx.readOnly {
+		mhandler[http.MethodPost] = http.HandlerFunc(tagOperationsHandler.PostTagOperations)
+	}
+	return mhandler
+}
+
+// tagOperationsHandler updates several tags of a repository at once.
+type tagOperationsHandler struct {
+	*Context
+}
+
+// tagOperation is an operation of a tag operations request.
+type tagOperation struct {
+	Tag    string `json:"tag"`
+	Digest string `json:"digest"`
+}
+
+// PostTagOperations points the tags of the request at their manifests, all
+// or nothing.
+func (th *tagOperationsHandler) PostTagOperations(w http.ResponseWriter, r *http.Request) {
+	var requested []tagOperation
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxTagOperationsBodySize))
+	if err := decoder.Decode(&requested); err != nil {
+		th.Errors = append(th.Errors, errcode.ErrorCodeTagOperationsInvalid.WithDetail(err.Error()))
+		return
+	}
+	if _, err := decoder.Token(); !errors.Is(err, io.EOF) {
+		th.Errors = append(th.Errors, errcode.ErrorCodeTagOperationsInvalid.WithDetail("unexpected data after the operations"))
+		return
+	}
+	if len(requested) == 0 || len(requested) > maxTagOperations {
+		th.Errors = append(th.Errors, errcode.ErrorCodeTagOperationsInvalid.WithDetail(fmt.Sprintf("expected between 1 and %d operations", maxTagOperations)))
+		return
+	}
+
+	ops := make([]distribution.TagOperation, 0, len(requested))
+	tags := make(map[string]struct{}, len(requested))
+	for _, op := range requested {
+		if !anchoredTagRegexp.MatchString(op.Tag) {
+			th.Errors = append(th.Errors, errcode.ErrorCodeTagInvalid.WithDetail(fmt.Sprintf("invalid tag %q", op.Tag)))
+			return
+		}
+		if _, ok := tags[op.Tag]; ok {
+			th.Errors = append(th.Errors, errcode.ErrorCodeTagOperationsInvalid.WithDetail(fmt.Sprintf("tag %s is updated more than once", op.Tag)))
+			return
+		}
+		tags[op.Tag] = struct{}{}
+
+		dgst, err := digest.Parse(op.Digest)
+		if err != nil {
+			th.Errors = append(th.Errors, errcode.ErrorCodeDigestInvalid.WithDetail(err))
+			return
+		}
+		ops = append(ops, distribution.TagOperation{Tag: op.Tag, Desc: v1.Descriptor{Digest: dgst}})
+	}
+
+	batcher, ok := th.Repository.Tags(th).(distribution.TagBatcher)
+	if !ok {
+		th.Errors = append(th.Errors, errcode.ErrorCodeUnsupported)
+		return
+	}
+
+	lockCtx, cancel := context.WithTimeout(th, tagOperationsLockTimeout)
+	defer cancel()
+	unlock, err := th.repositoryLocks.Lock(lockCtx, th.Repository.Named().Name())
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			err = fmt.Errorf("timed out waiting for the other tag operations on the repository")
+		}
+		th.Errors = append(th.Errors, errcode.ErrorCodeUnavailable.WithDetail(err.Error()))
+		return
+	}
+	defer unlock()
+	// the tag limit and the automatic indexes run under the lock held
+	th.Context.Context = withRepositoryLock(th.Context.Context, th.Repository.Named().Name())
+
+	// the manifests must exist before any tag is updated
+	manifests, err := th.Repository.Manifests(th)
+	if err != nil {
+		th.Errors = append(th.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+	for _, op := range ops {
+		exists, err := manifests.Exists(th, op.Desc.Digest)
+		if err != nil {
+			th.Errors = append(th.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+			return
+		}
+		if !exists {
+			th.Errors = append(th.Errors, errcode.ErrorCodeManifestUnknown.WithDetail(fmt.Sprintf("unknown manifest %s for tag %s", op.Desc.Digest, op.Tag)))
+			return
+		}
+	}
+
+	var coded errcode.Error
+	if err := batcher.TagBatch(th, ops); err != nil {
+		if errors.As(err, &coded) {
+			th.Errors = append(th.Errors, coded)
+		} else if errors.Is(err, distribution.ErrUnsupported) {
+			th.Errors = append(th.Errors, errcode.ErrorCodeUnsupported)
+		} else if errors.As(err, new(distribution.ErrRepositoryNameInvalid)) {
+			th.Errors = append(th.Errors, errcode.ErrorCodeNameInvalid.WithDetail(err.Error()))
+		} else {
+			th.Errors = append(th.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		}
+		return
+	}
+	dcontext.GetLogger(th).Infof("updated %d tags", len(ops))
+
+	for _, op := range ops {
+		th.App.autoIndexer.update(th.Context, op.Tag)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
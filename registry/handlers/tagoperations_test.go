@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/notifications"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// postTagOperations posts body to the tag operations endpoint of name.
+func postTagOperations(t *testing.T, env *testEnv, name reference.Named, body string) *http.Response {
+	t.Helper()
+
+	u, err := env.builder.BuildTagOperationsURL(name)
+	checkErr(t, err, "building tag operations url")
+	resp, err := http.Post(u, "application/json", strings.NewReader(body))
+	checkErr(t, err, "posting tag operations")
+	return resp
+}
+
+// tagDigest returns the digest of the manifest tagged tag.
+func tagDigest(t *testing.T, env *testEnv, name reference.Named, tag string) digest.Digest {
+	t.Helper()
+
+	tagRef, _ := reference.WithTag(name, tag)
+	manifestURL, err := env.builder.BuildManifestURL(tagRef)
+	checkErr(t, err, "building manifest url")
+	req, err := http.NewRequest(http.MethodHead, manifestURL, nil)
+	checkErr(t, err, "building request")
+	req.Header.Set("Accept", v1.MediaTypeImageManifest)
+	resp, err := http.DefaultClient.Do(req)
+	checkErr(t, err, "fetching manifest")
+	defer resp.Body.Close()
+	checkResponse(t, "fetching manifest", resp, http.StatusOK)
+	return digest.Digest(resp.Header.Get("Docker-Content-Digest"))
+}
+
+func TestTagOperations(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		pushed = make(map[string]digest.Digest)
+	)
+	sink := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var envelope struct {
+			Events []notifications.Event `json:"events"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&envelope); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		for _, event := range envelope.Events {
+			if event.Action == notifications.EventActionPush && event.Target.Tag != "" {
+				pushed[event.Target.Tag] = event.Target.Digest
+			}
+		}
+		mu.Unlock()
+	}))
+	defer sink.Close()
+
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory":    configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[any]any{"enabled": false}},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	config.Notifications.Endpoints = []configuration.Endpoint{
+		{Name: "sink", URL: sink.URL, Timeout: time.Second, Threshold: 3, Backoff: 100 * time.Millisecond},
+	}
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	name, _ := reference.WithName("foo/tagops")
+	amd64 := pushPlatformImage(t, env, name, "build-amd64", "amd64")
+	arm64 := pushPlatformImage(t, env, name, "build-arm64", "arm64")
+
+	for _, tc := range []struct {
+		body  string
+		codes []errcode.ErrorCode
+	}{
+		{`{"tag": "latest"}`, []errcode.ErrorCode{errcode.ErrorCodeTagOperationsInvalid}},
+		{`[]`, []errcode.ErrorCode{errcode.ErrorCodeTagOperationsInvalid}},
+		{`[{"tag": "latest", "digest": "` + amd64.String() + `"}] []`, []errcode.ErrorCode{errcode.ErrorCodeTagOperationsInvalid}},
+		{`[{"tag": "-latest", "digest": "` + amd64.String() + `"}]`, []errcode.ErrorCode{errcode.ErrorCodeTagInvalid}},
+		{`[{"tag": "latest", "digest": "sha256:invalid"}]`, []errcode.ErrorCode{errcode.ErrorCodeDigestInvalid}},
+		{`[{"tag": "latest", "digest": "` + amd64.String() + `"}, {"tag": "latest", "digest": "` + arm64.String() + `"}]`, []errcode.ErrorCode{errcode.ErrorCodeTagOperationsInvalid}},
+		{`[{"tag": "latest", "digest": "` + amd64.String() + `"}, {"tag": "stable", "digest": "` + digest.FromString("unknown").String() + `"}]`, []errcode.ErrorCode{errcode.ErrorCodeManifestUnknown}},
+	} {
+		resp := postTagOperations(t, env, name, tc.body)
+		checkBodyHasErrorCodes(t, "posting invalid tag operations", resp, tc.codes...)
+		resp.Body.Close()
+	}
+
+	// none of the rejected requests tagged anything
+	tagRef, _ := reference.WithTag(name, "latest")
+	manifestURL, err := env.builder.BuildManifestURL(tagRef)
+	checkErr(t, err, "building manifest url")
+	resp, err := http.Head(manifestURL)
+	checkErr(t, err, "fetching manifest")
+	resp.Body.Close()
+	checkResponse(t, "fetching rejected tag", resp, http.StatusNotFound)
+
+	ops := []tagOperation{
+		{Tag: "latest", Digest: arm64.String()},
+		{Tag: "stable", Digest: amd64.String()},
+		{Tag: "build-amd64", Digest: arm64.String()},
+	}
+	var body bytes.Buffer
+	checkErr(t, json.NewEncoder(&body).Encode(ops), "encoding tag operations")
+	resp = postTagOperations(t, env, name, body.String())
+	resp.Body.Close()
+	checkResponse(t, "posting tag operations", resp, http.StatusNoContent)
+
+	for _, op := range ops {
+		if dgst := tagDigest(t, env, name, op.Tag); dgst.String() != op.Digest {
+			t.Errorf("unexpected digest of tag %s: %s != %s", op.Tag, dgst, op.Digest)
+		}
+	}
+
+	// the batch emits a push event per tag
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		mu.Lock()
+		done := pushed["latest"] == arm64 && pushed["stable"] == amd64 && pushed["build-amd64"] == arm64
+		events := fmt.Sprint(pushed)
+		mu.Unlock()
+		if done {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("missing push events of the tag operations: %s", events)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
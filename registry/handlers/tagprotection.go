@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	"github.com/distribution/distribution/v3/registry/auth"
+	"github.com/gorilla/mux"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// tagProtectionLockTimeout bounds the time a deletion of protected tags waits
+// for the other deletions on the repository.
+const tagProtectionLockTimeout = 30 * time.Second
+
+// tagProtectionRule protects the tags matching tags in the repositories
+// matching repositories against deletion.
+type tagProtectionRule struct {
+	name         string
+	repositories []string
+	tags         *regexp.Regexp
+	min          int
+}
+
+// newTagProtectionRules validates config and returns the rules it describes.
+func newTagProtectionRules(config configuration.ValidationTags) ([]tagProtectionRule, error) {
+	var rules []tagProtectionRule
+	for i, rule := range config.Protect {
+		name := rule.Name
+		if name == "" {
+			name = strconv.Itoa(i)
+		}
+		if len(rule.Repositories) == 0 {
+			return nil, fmt.Errorf("rule %s does not match any repository", name)
+		}
+		for _, pattern := range rule.Repositories {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid rule %s pattern %q: %w", name, pattern, err)
+			}
+		}
+		if rule.Tags == "" {
+			return nil, fmt.Errorf("rule %s does not match any tag", name)
+		}
+		tags, err := regexp.Compile("^(?:" + rule.Tags + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid rule %s tags: %w", name, err)
+		}
+		if rule.Min < 0 {
+			return nil, fmt.Errorf("rule %s min must not be negative", name)
+		}
+		rules = append(rules, tagProtectionRule{
+			name:         name,
+			repositories: rule.Repositories,
+			tags:         tags,
+			min:          rule.Min,
+		})
+	}
+	return rules, nil
+}
+
+// appliesTo reports whether the rule protects the tags of repository.
+func (rule tagProtectionRule) appliesTo(repository string) bool {
+	for _, pattern := range rule.repositories {
+		if ok, _ := path.Match(pattern, repository); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// check returns an error if deleting the tags removed from the repository
+// tagged all breaks the rule. The removed tags not in all are ignored.
+func (rule tagProtectionRule) check(all, removed []string) error {
+	matching := make(map[string]struct{})
+	for _, tag := range all {
+		if rule.tags.MatchString(tag) {
+			matching[tag] = struct{}{}
+		}
+	}
+
+	before := len(matching)
+	for _, tag := range removed {
+		if _, ok := matching[tag]; !ok {
+			continue
+		}
+		if rule.min == 0 {
+			return errcode.ErrorCodeTagProtected.WithDetail(fmt.Sprintf("tag %s is protected by rule %s", tag, rule.name))
+		}
+		delete(matching, tag)
+	}
+	if len(matching) < before && len(matching) < rule.min {
+		return errcode.ErrorCodeTagProtected.WithDetail(fmt.Sprintf("rule %s requires %d matching tags, %d would remain", rule.name, rule.min, len(matching)))
+	}
+	return nil
+}
+
+// forcedDelete reports whether r is a manifest or tag deletion overriding the
+// tag protection rules.
+func forcedDelete(r *http.Request) bool {
+	if r.Method != http.MethodDelete {
+		return false
+	}
+	if route := mux.CurrentRoute(r); route == nil || route.GetName() != v2.RouteNameManifest {
+		return false
+	}
+	forced, _ := strconv.ParseBool(r.URL.Query().Get("force"))
+	return forced
+}
+
+// appendForceDeleteAccessRecord requires the force-delete action on repo from
+// the deletions overriding the tag protection rules.
+func appendForceDeleteAccessRecord(records []auth.Access, r *http.Request, repo string) []auth.Access {
+	if !forcedDelete(r) {
+		return records
+	}
+	return append(records, auth.Access{
+		Resource: auth.Resource{Type: "repository", Name: repo},
+		Action:   "force-delete",
+	})
+}
+
+// protectTags checks that the deletion of the manifest or tag of the request
+// does not break a tag protection rule, recording an error otherwise. The
+// repository stays locked until the returned function is called, so that
+// concurrent deletions do not break the rules together. It reports whether
+// the deletion may proceed.
+func (imh *manifestHandler) protectTags(r *http.Request) (func(), bool) {
+	var rules []tagProtectionRule
+	for _, rule := range imh.App.tagProtection {
+		if rule.appliesTo(imh.Repository.Named().Name()) {
+			rules = append(rules, rule)
+		}
+	}
+	if len(rules) == 0 {
+		return func() {}, true
+	}
+	if forcedDelete(r) {
+		dcontext.GetLogger(imh).Infof("deletion overrides the tag protection rules")
+		return func() {}, true
+	}
+
+	name := imh.Repository.Named().Name()
+	unlock, err := lockRepository(imh, imh.repositoryLocks, name, tagProtectionLockTimeout)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			err = fmt.Errorf("timed out waiting for the other deletions on the repository")
+		}
+		imh.Errors = append(imh.Errors, errcode.ErrorCodeUnavailable.WithDetail(err.Error()))
+		return nil, false
+	}
+	// the deletions of the tags run under the lock held
+	imh.Context.Context = withRepositoryLock(imh.Context.Context, name)
+
+	if err := imh.checkTagProtection(rules); err != nil {
+		unlock()
+		imh.Errors = append(imh.Errors, err)
+		return nil, false
+	}
+	return unlock, true
+}
+
+// checkTagProtection returns an error if the deletion of the manifest or tag
+// of the request breaks one of rules.
+func (imh *manifestHandler) checkTagProtection(rules []tagProtectionRule) error {
+	tagService := imh.Repository.Tags(imh)
+	removed := []string{imh.Tag}
+	if imh.Tag == "" {
+		tags, err := tagService.Lookup(imh, v1.Descriptor{Digest: imh.Digest})
+		if err != nil {
+			return errcode.ErrorCodeUnknown.WithDetail(err)
+		}
+		removed = tags
+	}
+	if len(removed) == 0 {
+		return nil
+	}
+
+	all, err := tagService.All(imh)
+	if err != nil {
+		var unknown distribution.ErrRepositoryUnknown
+		if !errors.As(err, &unknown) {
+			return errcode.ErrorCodeUnknown.WithDetail(err)
+		}
+	}
+	for _, rule := range rules {
+		if err := rule.check(all, removed); err != nil {
+			return err
+		}
+	}
+	return nil
+}
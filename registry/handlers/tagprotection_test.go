@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+)
+
+// deleteManifest deletes the manifest or tag ref, with query appended to its
+// url.
+func deleteManifest(t *testing.T, env *testEnv, ref reference.Reference, query string) *http.Response {
+	t.Helper()
+
+	manifestURL, err := env.builder.BuildManifestURL(ref.(reference.Named))
+	checkErr(t, err, "building manifest url")
+	resp, err := httpDelete(manifestURL + query)
+	checkErr(t, err, "deleting manifest")
+	return resp
+}
+
+// checkTagProtected checks that resp rejects a deletion with a detail naming
+// rule.
+func checkTagProtected(t *testing.T, msg string, resp *http.Response, rule string) {
+	t.Helper()
+
+	errs, _, _ := checkBodyHasErrorCodes(t, msg, resp, errcode.ErrorCodeTagProtected)
+	checkResponse(t, msg, resp, http.StatusConflict)
+	if detail, _ := errs[0].(errcode.Error).Detail.(string); !strings.Contains(detail, "rule "+rule) {
+		t.Fatalf("%s: expected the detail to name rule %s, got %q", msg, rule, detail)
+	}
+}
+
+func TestTagProtectionRulesInvalid(t *testing.T) {
+	for _, config := range []configuration.ValidationTags{
+		{Protect: []configuration.TagProtectionRule{{Tags: "latest"}}},
+		{Protect: []configuration.TagProtectionRule{{Repositories: []string{"[ci/*"}, Tags: "latest"}}},
+		{Protect: []configuration.TagProtectionRule{{Repositories: []string{"ci/*"}}}},
+		{Protect: []configuration.TagProtectionRule{{Repositories: []string{"ci/*"}, Tags: "v[0-9"}}},
+		{Protect: []configuration.TagProtectionRule{{Repositories: []string{"ci/*"}, Tags: "latest", Min: -1}}},
+	} {
+		if _, err := newTagProtectionRules(config); err == nil {
+			t.Errorf("expected error for tag protection rules %+v", config)
+		}
+	}
+}
+
+func TestTagProtection(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory":    configuration.Parameters{},
+			"delete":      configuration.Parameters{"enabled": true},
+			"maintenance": configuration.Parameters{"uploadpurging": map[any]any{"enabled": false}},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	config.Validation.Tags.Protect = []configuration.TagProtectionRule{
+		{Name: "releases", Repositories: []string{"foo/*"}, Tags: `v[0-9]+`, Min: 2},
+		{Name: "latest", Repositories: []string{"foo/*"}, Tags: "latest"},
+	}
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	name, _ := reference.WithName("foo/bar")
+	tags := []string{"v1", "v2", "v3", "latest", "dev"}
+	digests := make(map[string]digest.Digest)
+	for _, tag := range tags {
+		digests[tag] = pushPlatformImage(t, env, name, tag, "amd64")
+	}
+	tagRef := func(tag string) reference.Reference {
+		ref, _ := reference.WithTag(name, tag)
+		return ref
+	}
+	digestRef := func(tag string) reference.Reference {
+		ref, _ := reference.WithDigest(name, digests[tag])
+		return ref
+	}
+
+	resp := deleteManifest(t, env, tagRef("latest"), "")
+	checkTagProtected(t, "deleting protected tag", resp, "latest")
+	resp.Body.Close()
+
+	resp = deleteManifest(t, env, digestRef("latest"), "")
+	checkTagProtected(t, "deleting protected manifest", resp, "latest")
+	resp.Body.Close()
+
+	// three releases may go down to two
+	resp = deleteManifest(t, env, tagRef("v1"), "")
+	resp.Body.Close()
+	checkResponse(t, "deleting release above the minimum", resp, http.StatusAccepted)
+
+	// but not below
+	resp = deleteManifest(t, env, tagRef("v2"), "")
+	checkTagProtected(t, "deleting release at the minimum", resp, "releases")
+	resp.Body.Close()
+
+	resp = deleteManifest(t, env, digestRef("v3"), "")
+	checkTagProtected(t, "deleting release manifest at the minimum", resp, "releases")
+	resp.Body.Close()
+
+	// unknown and unprotected tags are not protected
+	resp = deleteManifest(t, env, tagRef("v9"), "")
+	resp.Body.Close()
+	checkResponse(t, "deleting unknown release", resp, http.StatusNotFound)
+
+	resp = deleteManifest(t, env, tagRef("dev"), "")
+	resp.Body.Close()
+	checkResponse(t, "deleting unprotected tag", resp, http.StatusAccepted)
+
+	// nor are the tags of other repositories
+	other, _ := reference.WithName("bar/foo")
+	pushPlatformImage(t, env, other, "latest", "amd64")
+	otherRef, _ := reference.WithTag(other, "latest")
+	resp = deleteManifest(t, env, otherRef, "")
+	resp.Body.Close()
+	checkResponse(t, "deleting tag of unprotected repository", resp, http.StatusAccepted)
+
+	// forced deletions override the rules
+	resp = deleteManifest(t, env, tagRef("v2"), "?force=true")
+	resp.Body.Close()
+	checkResponse(t, "forcing deletion of release", resp, http.StatusAccepted)
+
+	resp = deleteManifest(t, env, digestRef("latest"), "?force=true")
+	resp.Body.Close()
+	checkResponse(t, "forcing deletion of protected manifest", resp, http.StatusAccepted)
+}
+
+// TestTagProtectionForceAccess validates that forced deletions require the
+// force-delete action.
+func TestTagProtectionForceAccess(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	name, _ := reference.WithName("foo/bar")
+	tagRef, _ := reference.WithTag(name, "latest")
+
+	for _, tc := range []struct {
+		name    string
+		query   string
+		actions []string
+		allowed bool
+	}{
+		{name: "delete", actions: []string{"delete"}, allowed: true},
+		{name: "forced delete", query: "?force=true", actions: []string{"delete"}},
+		{name: "forced delete granted", query: "?force=true", actions: []string{"delete", "force-delete"}, allowed: true},
+		{name: "unforced delete", query: "?force=false", actions: []string{"delete"}, allowed: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			env := newTokenTestEnv(t, key, false)
+			defer env.Shutdown()
+
+			manifestURL, err := env.builder.BuildManifestURL(tagRef)
+			checkErr(t, err, "building manifest url")
+			req, err := http.NewRequest(http.MethodDelete, manifestURL+tc.query, nil)
+			checkErr(t, err, "building delete request")
+			req.Header.Set("Authorization", "Bearer "+signToken(t, key, name.Name(), tc.actions...))
+			resp, err := http.DefaultClient.Do(req)
+			checkErr(t, err, "deleting")
+			resp.Body.Close()
+
+			// authorized deletions of unknown tags are not found
+			expected := http.StatusUnauthorized
+			if tc.allowed {
+				expected = http.StatusNotFound
+			}
+			checkResponse(t, "deleting", resp, expected)
+		})
+	}
+}
@@ -2,13 +2,17 @@ package handlers
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"strconv"
+	"time"
 
 	"github.com/distribution/distribution/v3"
 	"github.com/distribution/distribution/v3/registry/api/errcode"
 	"github.com/gorilla/handlers"
+	"github.com/opencontainers/go-digest"
 )
 
 // tagsDispatcher constructs the tags handler api endpoint.
@@ -17,9 +21,9 @@ func tagsDispatcher(ctx *Context, r *http.Request) http.Handler {
 		Context: ctx,
 	}
 
-	return handlers.MethodHandler{
+	return ctx.App.listCompression.handler(handlers.MethodHandler{
 		http.MethodGet: http.HandlerFunc(tagsHandler.GetTags),
-	}
+	})
 }
 
 // tagsHandler handles requests for lists of tags under a repository name.
@@ -32,6 +36,19 @@ type tagsAPIResponse struct {
 	Tags []string `json:"tags"`
 }
 
+// tagsDetailAPIResponse is the response of a tag listing requested with
+// detail=true.
+type tagsDetailAPIResponse struct {
+	Name string          `json:"name"`
+	Tags []tagDetailJSON `json:"tags"`
+}
+
+type tagDetailJSON struct {
+	Name     string        `json:"name"`
+	Digest   digest.Digest `json:"digest"`
+	Modified time.Time     `json:"modified"`
+}
+
 // GetTags returns a json list of tags for a specific image name.
 func (th *tagsHandler) GetTags(w http.ResponseWriter, r *http.Request) {
 	var moreEntries = true
@@ -56,6 +73,16 @@ func (th *tagsHandler) GetTags(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	opts, filtered, err := parseTagListOptions(q)
+	if err != nil {
+		th.Errors = append(th.Errors, errcode.ErrorCodeTagFilterInvalid.WithDetail(err))
+		return
+	}
+	if filtered {
+		th.getFilteredTags(w, r, opts, limit, lastEntry)
+		return
+	}
+
 	filled := make([]string, 0)
 
 	if limit == 0 {
@@ -104,3 +131,117 @@ func (th *tagsHandler) GetTags(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 }
+
+// parseTagListOptions parses the tag listing filter parameters of q. It
+// reports whether any of them was set.
+func parseTagListOptions(q url.Values) (distribution.TagListOptions, bool, error) {
+	var (
+		opts     distribution.TagListOptions
+		filtered bool
+		err      error
+	)
+
+	if q.Has("prefix") {
+		opts.Prefix = q.Get("prefix")
+		filtered = true
+	}
+	if v := q.Get("filter"); v != "" {
+		opts.Glob = v
+		filtered = true
+	}
+	if v := q.Get("modified_before"); v != "" {
+		if opts.ModifiedBefore, err = time.Parse(time.RFC3339, v); err != nil {
+			return opts, false, fmt.Errorf("invalid modified_before: %w", err)
+		}
+		filtered = true
+	}
+	if v := q.Get("modified_after"); v != "" {
+		if opts.ModifiedAfter, err = time.Parse(time.RFC3339, v); err != nil {
+			return opts, false, fmt.Errorf("invalid modified_after: %w", err)
+		}
+		filtered = true
+	}
+	if v := q.Get("detail"); v != "" {
+		if opts.Details, err = strconv.ParseBool(v); err != nil {
+			return opts, false, fmt.Errorf("invalid detail: %w", err)
+		}
+		filtered = true
+	}
+
+	return opts, filtered, nil
+}
+
+// getFilteredTags serves a tag listing restricted by opts.
+func (th *tagsHandler) getFilteredTags(w http.ResponseWriter, r *http.Request, opts distribution.TagListOptions, limit int, lastEntry string) {
+	moreEntries := true
+	filled := make([]distribution.TagInfo, 0)
+
+	if limit == 0 {
+		moreEntries = false
+	} else {
+		lister, ok := th.Repository.Tags(th).(distribution.TagFilterLister)
+		if !ok {
+			th.Errors = append(th.Errors, errcode.ErrorCodeUnsupported.WithDetail("tag filtering is not supported"))
+			return
+		}
+
+		returnedTags, err := lister.ListFiltered(th.Context, opts, limit, lastEntry)
+		if err == distribution.ErrUnsupported {
+			th.Errors = append(th.Errors, errcode.ErrorCodeUnsupported.WithDetail("tag filtering is not supported"))
+			return
+		}
+		if err != nil {
+			if err != io.EOF {
+				switch err := err.(type) {
+				case distribution.ErrRepositoryUnknown:
+					th.Errors = append(th.Errors, errcode.ErrorCodeNameUnknown.WithDetail(map[string]string{"name": th.Repository.Named().Name()}))
+				case errcode.Error:
+					th.Errors = append(th.Errors, err)
+				default:
+					th.Errors = append(th.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+				}
+				return
+			}
+			moreEntries = false
+		}
+		filled = append(filled, returnedTags...)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	// Add a link header if there are more entries to retrieve, keeping the
+	// filter parameters of the request
+	if moreEntries {
+		v := r.URL.Query()
+		v.Set("n", strconv.Itoa(limit))
+		v.Set("last", filled[len(filled)-1].Name)
+		nextURL := *r.URL
+		nextURL.RawQuery = v.Encode()
+		nextURL.Fragment = ""
+		w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"next\"", nextURL.String()))
+	}
+
+	var resp any
+	if opts.Details {
+		tags := make([]tagDetailJSON, 0, len(filled))
+		for _, tag := range filled {
+			tags = append(tags, tagDetailJSON{
+				Name:     tag.Name,
+				Digest:   tag.Digest,
+				Modified: tag.ModTime.UTC(),
+			})
+		}
+		resp = tagsDetailAPIResponse{Name: th.Repository.Named().Name(), Tags: tags}
+	} else {
+		tags := make([]string, 0, len(filled))
+		for _, tag := range filled {
+			tags = append(tags, tag.Name)
+		}
+		resp = tagsAPIResponse{Name: th.Repository.Named().Name(), Tags: tags}
+	}
+
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		th.Errors = append(th.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	"github.com/gorilla/mux"
+)
+
+// defaultBudgetRetryAfter is advertised to clients whose request exceeded
+// its time budget when no retry delay is configured.
+const defaultBudgetRetryAfter = time.Second
+
+// timeBudget bounds the time spent serving a request through a context
+// deadline, so that slow storage operations are cancelled and the client is
+// answered with 503 Service Unavailable rather than held indefinitely.
+type timeBudget struct {
+	standard   time.Duration
+	transfers  time.Duration
+	retryAfter time.Duration
+}
+
+// newTimeBudget returns the time budget described by config, or nil if no
+// budget is configured.
+func newTimeBudget(config configuration.TimeBudget) *timeBudget {
+	if config.Default <= 0 && config.Transfers <= 0 {
+		return nil
+	}
+
+	b := &timeBudget{
+		standard:   config.Default,
+		transfers:  config.Transfers,
+		retryAfter: config.RetryAfter,
+	}
+	if b.retryAfter <= 0 {
+		b.retryAfter = defaultBudgetRetryAfter
+	}
+	return b
+}
+
+// budgetFor returns the budget of r, or zero if r is exempt.
+func (b *timeBudget) budgetFor(r *http.Request) time.Duration {
+	if isBlobTransfer(r) {
+		return b.transfers
+	}
+	return b.standard
+}
+
+// apply sets a deadline on the request context matching the budget of r.
+// The returned function must be called once the request has been served. A
+// nil budget leaves the context unchanged.
+func (b *timeBudget) apply(ctx *Context, r *http.Request) context.CancelFunc {
+	if b == nil {
+		return func() {}
+	}
+
+	budget := b.budgetFor(r)
+	if budget <= 0 {
+		return func() {}
+	}
+
+	var cancel context.CancelFunc
+	ctx.Context, cancel = context.WithTimeout(ctx.Context, budget)
+	return cancel
+}
+
+// exceeded reports whether the request served by ctx ran out of budget before
+// a response was written. In that case, the errors of the request are
+// replaced with a single 503 Service Unavailable error.
+func (b *timeBudget) exceeded(ctx *Context, w http.ResponseWriter) bool {
+	if b == nil || !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return false
+	}
+	if status, ok := ctx.Value("http.response.status").(int); ok && status != 0 {
+		// part of the response has already been sent, it is too late to
+		// report anything else to the client.
+		return false
+	}
+
+	dcontext.GetLogger(ctx).Warnf("request exceeded its time budget: %v", ctx.Errors)
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(b.retryAfter.Seconds()))))
+	ctx.Errors = errcode.Errors{errcode.ErrorCodeUnavailable.WithDetail("request exceeded its time budget")}
+	return true
+}
+
+// isBlobTransfer reports whether r streams blob content, in which case its
+// duration legitimately grows with the size of the blob.
+func isBlobTransfer(r *http.Request) bool {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return false
+	}
+
+	switch route.GetName() {
+	case v2.RouteNameBlob:
+		return r.Method == http.MethodGet
+	case v2.RouteNameBlobUpload:
+		// monolithic uploads carry the blob in the request body
+		return r.Method == http.MethodPost && r.URL.Query().Get("digest") != ""
+	case v2.RouteNameBlobUploadChunk:
+		return r.Method == http.MethodPatch || r.Method == http.MethodPut
+	case v2.RouteNameBlobUploadWS:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/factory"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+)
+
+const slowStorageDelay = 500 * time.Millisecond
+
+// slowDriverFactory implements the factory.StorageDriverFactory interface.
+type slowDriverFactory struct{}
+
+func (factory *slowDriverFactory) Create(ctx context.Context, parameters map[string]any) (storagedriver.StorageDriver, error) {
+	return &slowDriver{StorageDriver: inmemory.New()}, nil
+}
+
+// slowDriver delays tag lookups and blob reads to simulate slow storage.
+type slowDriver struct {
+	storagedriver.StorageDriver
+}
+
+func (d *slowDriver) GetContent(ctx context.Context, path string) ([]byte, error) {
+	if strings.Contains(path, "/_manifests/tags/") {
+		select {
+		case <-time.After(slowStorageDelay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return d.StorageDriver.GetContent(ctx, path)
+}
+
+func (d *slowDriver) Reader(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
+	if strings.Contains(path, "/blobs/") {
+		select {
+		case <-time.After(slowStorageDelay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return d.StorageDriver.Reader(ctx, path, offset)
+}
+
+func TestTimeBudget(t *testing.T) {
+	factory.Register("slowstorage", &slowDriverFactory{})
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"slowstorage": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[any]any{"enabled": false}},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	config.HTTP.TimeBudget = configuration.TimeBudget{
+		Default:    50 * time.Millisecond,
+		RetryAfter: 2 * time.Second,
+	}
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/bar")
+	contents := strings.NewReader("some layer contents")
+	dgst := digest.FromString("some layer contents")
+	uploadURLBase, _ := startPushLayer(t, env, imageName)
+	pushLayer(t, env.builder, imageName, dgst, uploadURLBase, contents)
+
+	// tag lookups exceed the budget and are cancelled
+	tagRef, _ := reference.WithTag(imageName, "latest")
+	manifestURL, err := env.builder.BuildManifestURL(tagRef)
+	checkErr(t, err, "building manifest url")
+
+	start := time.Now()
+	resp, err := http.Get(manifestURL)
+	checkErr(t, err, "fetching manifest")
+	defer resp.Body.Close()
+	if elapsed := time.Since(start); elapsed >= slowStorageDelay {
+		t.Fatalf("expected request to fail before storage completed, took %v", elapsed)
+	}
+	checkResponse(t, "fetching manifest over budget", resp, http.StatusServiceUnavailable)
+	checkBodyHasErrorCodes(t, "fetching manifest over budget", resp, errcode.ErrorCodeUnavailable)
+	checkHeaders(t, resp, http.Header{"Retry-After": []string{"2"}})
+
+	// blob downloads are exempt from the default budget
+	ref, _ := reference.WithDigest(imageName, dgst)
+	blobURL, err := env.builder.BuildBlobURL(ref)
+	checkErr(t, err, "building blob url")
+
+	resp, err = http.Get(blobURL)
+	checkErr(t, err, "fetching blob")
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	checkErr(t, err, "reading blob")
+	checkResponse(t, "fetching blob", resp, http.StatusOK)
+	if string(body) != "some layer contents" {
+		t.Fatalf("unexpected blob contents: %q", body)
+	}
+
+	// unless they have a budget of their own, in which case the transfer is
+	// cut off once the budget is spent
+	config.HTTP.TimeBudget.Transfers = 100 * time.Millisecond
+	env2 := newTestEnvWithConfig(t, &config)
+	defer env2.Shutdown()
+
+	uploadURLBase, _ = startPushLayer(t, env2, imageName)
+	pushLayer(t, env2.builder, imageName, dgst, uploadURLBase, strings.NewReader("some layer contents"))
+	blobURL, err = env2.builder.BuildBlobURL(ref)
+	checkErr(t, err, "building blob url")
+
+	resp, err = http.Get(blobURL)
+	checkErr(t, err, "fetching blob")
+	defer resp.Body.Close()
+	if body, err := io.ReadAll(resp.Body); err == nil {
+		t.Fatalf("expected blob transfer to be cut off, read %q", body)
+	}
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"path"
+	"sync"
+	"time"
+
+	prometheus "github.com/distribution/distribution/v3/metrics"
+	"github.com/docker/go-metrics"
+	promclient "github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// uploadSessionsOpen is the number of upload sessions in progress
+	uploadSessionsOpen = prometheus.HTTPNamespace.NewGauge("upload_sessions_open", "The number of upload sessions in progress", metrics.Total)
+
+	// uploadSessionAge is the age of upload sessions when they end, whether
+	// completed, cancelled or purged. It is added to the namespace before it
+	// is registered.
+	uploadSessionAge = func() promclient.Histogram {
+		h := promclient.NewHistogram(promclient.HistogramOpts{
+			Namespace: prometheus.NamespacePrefix,
+			Subsystem: "http",
+			Name:      "upload_session_age_seconds",
+			Help:      "The age of upload sessions when they are completed, cancelled or purged",
+			// 1s to about 4 days
+			Buckets: promclient.ExponentialBuckets(1, 4, 10),
+		})
+		prometheus.HTTPNamespace.Add(h)
+		return h
+	}()
+)
+
+// uploadSessions tracks the upload sessions in progress across the registry,
+// optionally bounding their number. A session holds its slot from the request
+// starting it to the request completing or cancelling it, or until it is
+// purged.
+//
+// Sessions are tracked in memory: registries sharing a storage backend each
+// limit the sessions they started, and a session completed by another
+// registry holds its slot until it is purged.
+type uploadSessions struct {
+	max int // zero if the number of sessions is not limited
+
+	mu sync.Mutex
+	// sessions holds the start time of the sessions in progress, by upload
+	// id.
+	sessions map[string]time.Time
+}
+
+// newUploadSessions returns a tracker limiting the sessions to max, or not
+// limiting them if max is not positive.
+func newUploadSessions(max int) *uploadSessions {
+	return &uploadSessions{
+		max:      max,
+		sessions: make(map[string]time.Time),
+	}
+}
+
+// add takes a slot for the session id, returning false if none is left. A
+// nil tracker always succeeds.
+func (s *uploadSessions) add(id string) bool {
+	if s == nil {
+		return true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.max > 0 && len(s.sessions) >= s.max {
+		return false
+	}
+	s.sessions[id] = time.Now()
+	uploadSessionsOpen.Inc()
+	return true
+}
+
+// release frees the slot of the session id, if it holds one.
+func (s *uploadSessions) release(id string) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if started, ok := s.sessions[id]; ok {
+		s.end(id, started)
+	}
+}
+
+// reap frees the slots of the sessions started before olderThan, and of the
+// sessions whose upload directories are listed in purged, as their uploads
+// were purged.
+func (s *uploadSessions) reap(olderThan time.Time, purged []string) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, started := range s.sessions {
+		if started.Before(olderThan) {
+			s.end(id, started)
+		}
+	}
+	// the upload directories are named after the upload id
+	for _, dir := range purged {
+		id := path.Base(dir)
+		if started, ok := s.sessions[id]; ok {
+			s.end(id, started)
+		}
+	}
+}
+
+// end drops the session id, recording its age. The caller holds s.mu.
+func (s *uploadSessions) end(id string, started time.Time) {
+	delete(s.sessions, id)
+	uploadSessionsOpen.Dec()
+	uploadSessionAge.Observe(time.Since(started).Seconds())
+}
+
+// open returns the number of sessions in progress.
+func (s *uploadSessions) open() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.sessions)
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestUploadSessionLimit(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory":    configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[any]any{"enabled": false}},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	config.HTTP.Uploads.MaxTotalConcurrent = 2
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	names := make([]reference.Named, 4)
+	for i, name := range []string{"foo/a", "foo/b", "bar/c", "baz/d"} {
+		names[i], _ = reference.WithName(name)
+	}
+	// rejected asserts that no upload session can be started in name.
+	rejected := func(name reference.Named) {
+		t.Helper()
+		u, err := env.builder.BuildBlobUploadURL(name)
+		checkErr(t, err, "building upload url")
+		resp, err := http.Post(u, "", nil)
+		checkErr(t, err, "starting upload")
+		defer resp.Body.Close()
+		checkBodyHasErrorCodes(t, "starting upload beyond the limit", resp, errcode.ErrorCodeTooManyRequests)
+		if resp.Header.Get("Retry-After") == "" {
+			t.Error("missing Retry-After header")
+		}
+	}
+
+	// the sessions of different repositories share the limit
+	locationA, _ := startPushLayer(t, env, names[0])
+	locationB, _ := startPushLayer(t, env, names[1])
+	rejected(names[2])
+
+	// cancelling an upload frees its slot
+	req, err := http.NewRequest(http.MethodDelete, locationA, nil)
+	checkErr(t, err, "building cancel request")
+	resp, err := http.DefaultClient.Do(req)
+	checkErr(t, err, "cancelling upload")
+	resp.Body.Close()
+	checkResponse(t, "cancelling upload", resp, http.StatusNoContent)
+	startPushLayer(t, env, names[2])
+	rejected(names[3])
+
+	// completing an upload frees its slot
+	content := []byte("layer content")
+	pushLayer(t, env.builder, names[1], digest.FromBytes(content), locationB, bytes.NewReader(content))
+	startPushLayer(t, env, names[3])
+	rejected(names[0])
+
+	// purging the uploads frees their slots
+	env.app.uploadSessions.reap(time.Now().Add(time.Second), nil)
+	startPushLayer(t, env, names[0])
+	startPushLayer(t, env, names[1])
+	rejected(names[2])
+}
+
+func TestAbandonedUploadsLeakNoGoroutines(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory":    configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[any]any{"enabled": false}},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	name, _ := reference.WithName("foo/abandoned")
+	u, err := env.builder.BuildBlobUploadURL(name)
+	checkErr(t, err, "building upload url")
+
+	// the requests are served in process to keep connection goroutines out
+	// of the count
+	abandon := func() {
+		rec := httptest.NewRecorder()
+		env.app.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, u, nil))
+		if rec.Code != http.StatusAccepted {
+			t.Fatalf("unexpected status starting upload: %d", rec.Code)
+		}
+		req := httptest.NewRequest(http.MethodPatch, rec.Header().Get("Location"), bytes.NewReader([]byte("partial content")))
+		req.Header.Set("Content-Type", "application/octet-stream")
+		rec = httptest.NewRecorder()
+		env.app.ServeHTTP(rec, req)
+		if rec.Code != http.StatusAccepted {
+			t.Fatalf("unexpected status patching upload: %d", rec.Code)
+		}
+	}
+
+	// warm up lazily started goroutines before counting
+	abandon()
+	before := runtime.NumGoroutine()
+	for range 1000 {
+		abandon()
+	}
+
+	if open := env.app.uploadSessions.open(); open != 1001 {
+		t.Errorf("unexpected number of open upload sessions: %d != 1001", open)
+	}
+	// goroutines ending on their own may still be winding down
+	var after int
+	for range 50 {
+		if after = runtime.NumGoroutine(); after <= before {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("goroutines grew from %d to %d after abandoning 1000 uploads", before, after)
+}
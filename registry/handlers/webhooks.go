@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/notifications"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	events "github.com/docker/go-events"
+	"github.com/gorilla/handlers"
+)
+
+const (
+	// defaultMaxSubscriptions is the default number of webhook subscriptions
+	// of a repository.
+	defaultMaxSubscriptions = 10
+
+	// maxSubscriptionsBodySize bounds the size of the body of a request.
+	maxSubscriptionsBodySize = 64 * 1024
+)
+
+// configureSubscriptions serves the webhook subscriptions of repositories,
+// kept in the storage backend, and returns the sink delivering events to
+// them.
+func (app *App) configureSubscriptions(configuration *configuration.Configuration) events.Sink {
+	config := configuration.Notifications.Subscriptions
+	if config.MaxPerRepository < 0 || config.Timeout < 0 || config.Threshold < 0 || config.Backoff < 0 {
+		panic("subscriptions: maxperrepository, timeout, threshold and backoff must not be negative")
+	}
+	app.maxSubscriptions = config.MaxPerRepository
+	if app.maxSubscriptions == 0 {
+		app.maxSubscriptions = defaultMaxSubscriptions
+	}
+	app.subscriptions = notifications.NewDriverSubscriptionStore(app.driver)
+	dcontext.GetLogger(app).Infof("serving webhook subscriptions, up to %d per repository", app.maxSubscriptions)
+
+	app.register(v2.RouteNameWebhooks, webhooksDispatcher)
+	return notifications.NewSubscriptionSink(app.subscriptions, notifications.EndpointConfig{
+		Timeout:   config.Timeout,
+		Threshold: config.Threshold,
+		Backoff:   config.Backoff,
+	})
+}
+
+// webhooksDispatcher constructs the webhook subscriptions handler.
+func webhooksDispatcher(ctx *Context, r *http.Request) http.Handler {
+	webhooksHandler := &webhooksHandler{
+		Context: ctx,
+	}
+
+	mhandler := handlers.MethodHandler{
+		http.MethodGet: http.HandlerFunc(webhooksHandler.GetWebhooks),
+	}
+	if !ctx.readOnly {
+		mhandler[http.MethodPut] = http.HandlerFunc(webhooksHandler.PutWebhooks)
+		mhandler[http.MethodDelete] = http.HandlerFunc(webhooksHandler.DeleteWebhooks)
+	}
+	return mhandler
+}
+
+// webhooksHandler manages the webhook subscriptions of a repository.
+type webhooksHandler struct {
+	*Context
+}
+
+// webhooksBody is the body of the requests and responses of the handler.
+type webhooksBody struct {
+	Subscriptions []notifications.Subscription `json:"subscriptions"`
+}
+
+// GetWebhooks returns the subscriptions of the repository, without their
+// secrets.
+func (wh *webhooksHandler) GetWebhooks(w http.ResponseWriter, r *http.Request) {
+	subscriptions, err := wh.subscriptions.Subscriptions(wh, wh.Repository.Named().Name())
+	if err != nil {
+		wh.Errors = append(wh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+	for i := range subscriptions {
+		subscriptions[i].Secret = ""
+	}
+	if subscriptions == nil {
+		subscriptions = []notifications.Subscription{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(webhooksBody{Subscriptions: subscriptions}); err != nil {
+		wh.Errors = append(wh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+}
+
+// PutWebhooks replaces the subscriptions of the repository.
+func (wh *webhooksHandler) PutWebhooks(w http.ResponseWriter, r *http.Request) {
+	var body webhooksBody
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxSubscriptionsBodySize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&body); err != nil {
+		wh.Errors = append(wh.Errors, errcode.ErrorCodeSubscriptionsInvalid.WithDetail(err.Error()))
+		return
+	}
+	if _, err := decoder.Token(); !errors.Is(err, io.EOF) {
+		wh.Errors = append(wh.Errors, errcode.ErrorCodeSubscriptionsInvalid.WithDetail("unexpected data after the subscriptions"))
+		return
+	}
+	if len(body.Subscriptions) > wh.maxSubscriptions {
+		wh.Errors = append(wh.Errors, errcode.ErrorCodeSubscriptionsInvalid.WithDetail(fmt.Sprintf("expected at most %d subscriptions", wh.maxSubscriptions)))
+		return
+	}
+	names := make(map[string]struct{}, len(body.Subscriptions))
+	for _, subscription := range body.Subscriptions {
+		if err := subscription.Validate(); err != nil {
+			wh.Errors = append(wh.Errors, errcode.ErrorCodeSubscriptionsInvalid.WithDetail(err.Error()))
+			return
+		}
+		if _, ok := names[subscription.Name]; ok {
+			wh.Errors = append(wh.Errors, errcode.ErrorCodeSubscriptionsInvalid.WithDetail(fmt.Sprintf("subscription %s is defined more than once", subscription.Name)))
+			return
+		}
+		names[subscription.Name] = struct{}{}
+	}
+
+	if err := wh.subscriptions.SetSubscriptions(wh, wh.Repository.Named().Name(), body.Subscriptions); err != nil {
+		wh.Errors = append(wh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+	dcontext.GetLogger(wh).Infof("set %d webhook subscriptions", len(body.Subscriptions))
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeleteWebhooks removes the subscriptions of the repository.
+func (wh *webhooksHandler) DeleteWebhooks(w http.ResponseWriter, r *http.Request) {
+	if err := wh.subscriptions.SetSubscriptions(wh, wh.Repository.Named().Name(), nil); err != nil {
+		wh.Errors = append(wh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+	dcontext.GetLogger(wh).Info("removed webhook subscriptions")
+
+	w.WriteHeader(http.StatusNoContent)
+}
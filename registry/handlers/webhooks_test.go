@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/notifications"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/distribution/reference"
+)
+
+// doWebhooks sends a request with body to the webhooks endpoint of name.
+func doWebhooks(t *testing.T, env *testEnv, method string, name reference.Named, body string) *http.Response {
+	t.Helper()
+
+	u, err := env.builder.BuildWebhooksURL(name)
+	checkErr(t, err, "building webhooks url")
+	req, err := http.NewRequest(method, u, strings.NewReader(body))
+	checkErr(t, err, "building request")
+	resp, err := http.DefaultClient.Do(req)
+	checkErr(t, err, "sending webhooks request")
+	return resp
+}
+
+func TestWebhookSubscriptions(t *testing.T) {
+	const secret = "team-a-secret"
+	type delivery struct {
+		signature string
+		body      []byte
+	}
+	deliveries := make(chan delivery, 100)
+	sink := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		deliveries <- delivery{signature: r.Header.Get(notifications.DefaultSignatureHeader), body: body}
+	}))
+	defer sink.Close()
+
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory":    configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[any]any{"enabled": false}},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	config.Notifications.Subscriptions = configuration.Subscriptions{
+		Enabled:          true,
+		MaxPerRepository: 2,
+		Timeout:          time.Second,
+		Backoff:          100 * time.Millisecond,
+	}
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	team, _ := reference.WithName("team-a")
+	for _, body := range []string{
+		`[]`,
+		`{"subscriptions": [{"url": "` + sink.URL + `"}]}`,
+		`{"subscriptions": [{"name": "ci", "url": "ftp://example.com"}]}`,
+		`{"subscriptions": [{"name": "ci", "url": "` + sink.URL + `"}, {"name": "ci", "url": "` + sink.URL + `"}]}`,
+		`{"subscriptions": [{"name": "a", "url": "` + sink.URL + `"}, {"name": "b", "url": "` + sink.URL + `"}, {"name": "c", "url": "` + sink.URL + `"}]}`,
+	} {
+		resp := doWebhooks(t, env, http.MethodPut, team, body)
+		checkBodyHasErrorCodes(t, "putting invalid subscriptions", resp, errcode.ErrorCodeSubscriptionsInvalid)
+		resp.Body.Close()
+	}
+
+	resp := doWebhooks(t, env, http.MethodPut, team, `{"subscriptions": [{"name": "ci", "url": "`+sink.URL+`", "secret": "`+secret+`", "actions": ["push"]}]}`)
+	resp.Body.Close()
+	checkResponse(t, "putting subscriptions", resp, http.StatusNoContent)
+
+	resp = doWebhooks(t, env, http.MethodGet, team, "")
+	checkResponse(t, "getting subscriptions", resp, http.StatusOK)
+	var listed webhooksBody
+	checkErr(t, json.NewDecoder(resp.Body).Decode(&listed), "decoding subscriptions")
+	resp.Body.Close()
+	if len(listed.Subscriptions) != 1 || listed.Subscriptions[0].Name != "ci" || listed.Subscriptions[0].Secret != "" {
+		t.Fatalf("unexpected subscriptions: %+v", listed.Subscriptions)
+	}
+
+	// the subscriptions of team-a receive the events of its repositories
+	name, _ := reference.WithName("team-a/app")
+	pushPlatformImage(t, env, name, "latest", "amd64")
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	timeout := time.After(10 * time.Second)
+	for pushed := false; !pushed; {
+		select {
+		case d := <-deliveries:
+			mac.Reset()
+			mac.Write(d.body)
+			if expected := "sha256=" + hex.EncodeToString(mac.Sum(nil)); d.signature != expected {
+				t.Fatalf("unexpected signature: %q != %q", d.signature, expected)
+			}
+			var envelope struct {
+				Events []notifications.Event `json:"events"`
+			}
+			checkErr(t, json.Unmarshal(d.body, &envelope), "decoding events")
+			for _, event := range envelope.Events {
+				if event.Action != notifications.EventActionPush {
+					t.Fatalf("unexpected event action: %s", event.Action)
+				}
+				if event.Target.Repository != name.Name() {
+					t.Fatalf("unexpected event repository: %s", event.Target.Repository)
+				}
+				pushed = pushed || event.Target.Tag == "latest"
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for the tag push event")
+		}
+	}
+
+	resp = doWebhooks(t, env, http.MethodDelete, team, "")
+	resp.Body.Close()
+	checkResponse(t, "deleting subscriptions", resp, http.StatusNoContent)
+	resp = doWebhooks(t, env, http.MethodGet, team, "")
+	checkErr(t, json.NewDecoder(resp.Body).Decode(&listed), "decoding subscriptions")
+	resp.Body.Close()
+	if len(listed.Subscriptions) != 0 {
+		t.Fatalf("unexpected subscriptions after deletion: %+v", listed.Subscriptions)
+	}
+}
@@ -0,0 +1,350 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/reference"
+	"github.com/gorilla/handlers"
+	"github.com/gorilla/mux"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"golang.org/x/net/websocket"
+)
+
+// configureWebSocketUploads serves blob uploads over WebSocket connections,
+// if enabled.
+func (app *App) configureWebSocketUploads(config configuration.WebSocketUploads) {
+	if !config.Enabled {
+		return
+	}
+	for _, origin := range config.AllowedOrigins {
+		if u, err := url.Parse(origin); err != nil || u.Scheme == "" || u.Host == "" {
+			panic(fmt.Sprintf("http.uploads.websocket.allowedorigins: invalid origin %q", origin))
+		}
+	}
+	app.webSocketOrigins = config.AllowedOrigins
+	dcontext.GetLogger(app).Infof("serving blob uploads over WebSocket connections, allowed origins: %v", app.webSocketOrigins)
+
+	app.register(v2.RouteNameBlobUploadWS, webSocketUploadDispatcher)
+}
+
+// isWebSocketUploadRoute returns true if r uploads a blob over a WebSocket
+// connection.
+func isWebSocketUploadRoute(r *http.Request) bool {
+	route := mux.CurrentRoute(r)
+	return route != nil && route.GetName() == v2.RouteNameBlobUploadWS
+}
+
+// accessMethod returns the method r is authorized as. Uploads over a
+// WebSocket connection are opened with a GET request, but push content.
+func accessMethod(r *http.Request) string {
+	if isWebSocketUploadRoute(r) {
+		return http.MethodPost
+	}
+	return r.Method
+}
+
+// webSocketUploadDispatcher constructs the handler of blob uploads over
+// WebSocket connections.
+func webSocketUploadDispatcher(ctx *Context, r *http.Request) http.Handler {
+	wsuh := &webSocketUploadHandler{
+		Context: ctx,
+	}
+
+	mhandler := handlers.MethodHandler{}
+	if !ctx.readOnly {
+		mhandler[http.MethodGet] = ctx.uploadLimiter.limit(ctx, http.HandlerFunc(wsuh.ServeUpload))
+	}
+	return mhandler
+}
+
+// webSocketUploadHandler receives a blob upload over a WebSocket connection.
+// Once connected, the registry sends the status of the upload, the client
+// sends the content of the blob as binary messages and completes the upload
+// with a text message carrying its digest. A client which lost its
+// connection resumes the upload by connecting again with its uuid.
+type webSocketUploadHandler struct {
+	*Context
+
+	Upload distribution.BlobWriter
+}
+
+// webSocketUploadStatus is sent to the client once connected.
+type webSocketUploadStatus struct {
+	UUID   string `json:"uuid"`
+	Offset int64  `json:"offset"`
+}
+
+// webSocketUploadCompletion is sent by the client to complete the upload, and
+// echoed back with the location of the blob once committed.
+type webSocketUploadCompletion struct {
+	Digest   digest.Digest `json:"digest"`
+	Location string        `json:"location,omitempty"`
+}
+
+// webSocketMessage is a message received from the client.
+type webSocketMessage struct {
+	payloadType byte
+	data        []byte
+}
+
+// webSocketCodec sends JSON text messages and receives messages of either
+// type.
+var webSocketCodec = websocket.Codec{
+	Marshal: func(v any) ([]byte, byte, error) {
+		data, err := json.Marshal(v)
+		return data, websocket.TextFrame, err
+	},
+	Unmarshal: func(data []byte, payloadType byte, v any) error {
+		msg := v.(*webSocketMessage)
+		msg.payloadType = payloadType
+		msg.data = data
+		return nil
+	},
+}
+
+// ServeUpload starts or resumes the upload, then upgrades the connection to
+// a WebSocket and receives the upload over it. Failures happening before the
+// upgrade are answered as other requests are.
+func (wsuh *webSocketUploadHandler) ServeUpload(w http.ResponseWriter, r *http.Request) {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		w.Header().Set("Upgrade", "websocket")
+		w.WriteHeader(http.StatusUpgradeRequired)
+		return
+	}
+	if origin := r.Header.Get("Origin"); !wsuh.App.webSocketOriginAllowed(origin, r) {
+		dcontext.GetLogger(wsuh).Warnf("denying WebSocket upload from origin %q", origin)
+		wsuh.Errors = append(wsuh.Errors, errcode.ErrorCodeDenied.WithDetail(fmt.Sprintf("origin %s is not allowed", origin)))
+		return
+	}
+
+	if uuid := r.FormValue("uuid"); uuid != "" {
+		if !wsuh.resumeUpload(uuid, r.FormValue("offset")) {
+			return
+		}
+	} else if !wsuh.startUpload(w) {
+		return
+	}
+
+	server := websocket.Server{
+		// the origin was checked above
+		Handshake: func(*websocket.Config, *http.Request) error { return nil },
+		Handler:   wsuh.receive,
+	}
+	server.ServeHTTP(hijacker{w}, r)
+}
+
+// startUpload starts an upload, as the POST request starting a chunked
+// upload does. It returns false, with the errors recorded, if it failed.
+func (wsuh *webSocketUploadHandler) startUpload(w http.ResponseWriter) bool {
+	upload, err := wsuh.Repository.Blobs(wsuh).Create(wsuh)
+	if err != nil {
+		switch {
+		case err == distribution.ErrUnsupported:
+			wsuh.Errors = append(wsuh.Errors, errcode.ErrorCodeUnsupported)
+		case errors.As(err, new(storagedriver.InsufficientStorageError)):
+			wsuh.Errors = append(wsuh.Errors, errcode.ErrorCodeInsufficientStorage.WithDetail(err.Error()))
+		default:
+			wsuh.Errors = append(wsuh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		}
+		return false
+	}
+
+	if !wsuh.uploadSessions.add(upload.ID()) {
+		dcontext.GetLogger(wsuh).Warn("rejecting blob upload: upload session limit reached")
+		if err := upload.Cancel(wsuh); err != nil {
+			dcontext.GetLogger(wsuh).Errorf("error canceling upload after reaching the session limit: %v", err)
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(int(defaultTransferRetryAfter.Seconds())))
+		wsuh.Errors = append(wsuh.Errors, errcode.ErrorCodeTooManyRequests.WithDetail("upload session limit reached"))
+		return false
+	}
+	wsuh.Upload = upload
+	return true
+}
+
+// resumeUpload resumes the upload identified by uuid, checking that the
+// client resumes it from its size if offset is set. It returns false, with
+// the errors recorded, if it failed.
+func (wsuh *webSocketUploadHandler) resumeUpload(uuid, offset string) bool {
+	upload, err := wsuh.Repository.Blobs(wsuh).Resume(wsuh, uuid)
+	if err != nil {
+		if err == distribution.ErrBlobUploadUnknown {
+			wsuh.Errors = append(wsuh.Errors, errcode.ErrorCodeBlobUploadUnknown.WithDetail(err))
+		} else {
+			wsuh.Errors = append(wsuh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		}
+		return false
+	}
+
+	if offset != "" {
+		n, err := strconv.ParseInt(offset, 10, 64)
+		if err != nil || n != upload.Size() {
+			upload.Close()
+			wsuh.Errors = append(wsuh.Errors, errcode.ErrorCodeRangeInvalid.WithDetail(fmt.Sprintf("upload resumed at offset %s, expected %d", offset, upload.Size())))
+			return false
+		}
+	}
+	wsuh.Upload = upload
+	return true
+}
+
+// receive receives the content of the upload over conn until the client
+// completes it. An upload whose connection is lost is kept, marked as
+// abandoned until it is resumed.
+func (wsuh *webSocketUploadHandler) receive(conn *websocket.Conn) {
+	defer conn.Close()
+	// an upload which is not completed is closed once, flushing the content
+	// received so far, so that the client may resume it from its size
+	var completed, lost bool
+	defer func() {
+		if completed {
+			return
+		}
+		if err := wsuh.Upload.Close(); err != nil {
+			dcontext.GetLogger(wsuh).Errorf("error flushing WebSocket upload: %v", err)
+		}
+		if lost {
+			abandonUpload(wsuh, wsuh.Upload)
+		}
+	}()
+
+	if err := webSocketCodec.Send(conn, webSocketUploadStatus{UUID: wsuh.Upload.ID(), Offset: wsuh.Upload.Size()}); err != nil {
+		dcontext.GetLogger(wsuh).Infof("error sending WebSocket upload status: %v", err)
+		return
+	}
+
+	for {
+		var msg webSocketMessage
+		if err := webSocketCodec.Receive(conn, &msg); err != nil {
+			if errors.Is(err, websocket.ErrFrameTooLarge) {
+				wsuh.fail(conn, errcode.ErrorCodeSizeInvalid.WithDetail(fmt.Sprintf("messages must not exceed %d bytes", conn.MaxPayloadBytes)))
+				return
+			}
+			if err != io.EOF {
+				dcontext.GetLogger(wsuh).Infof("WebSocket upload connection lost: %v", err)
+			}
+			lost = true
+			return
+		}
+
+		if msg.payloadType == websocket.BinaryFrame {
+			if _, err := wsuh.Upload.Write(msg.data); err != nil {
+				wsuh.fail(conn, uploadWriteError(err))
+				return
+			}
+			continue
+		}
+
+		var completion webSocketUploadCompletion
+		if err := json.Unmarshal(msg.data, &completion); err != nil {
+			wsuh.fail(conn, errcode.ErrorCodeBlobUploadInvalid.WithDetail(err.Error()))
+			return
+		}
+		if err := completion.Digest.Validate(); err != nil {
+			wsuh.fail(conn, errcode.ErrorCodeDigestInvalid.WithDetail(err.Error()))
+			return
+		}
+		// the upload is either committed or canceled
+		completed = true
+		wsuh.commit(conn, completion.Digest)
+		return
+	}
+}
+
+// commit completes the upload, as the PUT request completing a chunked
+// upload does, and tells the client the location of the blob.
+func (wsuh *webSocketUploadHandler) commit(conn *websocket.Conn, dgst digest.Digest) {
+	// the upload is either committed or canceled below
+	defer wsuh.uploadSessions.release(wsuh.Upload.ID())
+	desc, err := wsuh.Upload.Commit(wsuh, v1.Descriptor{Digest: dgst})
+	if err != nil {
+		var codeErr errcode.Error
+		switch {
+		case errors.As(err, new(distribution.ErrBlobInvalidDigest)):
+			codeErr = errcode.ErrorCodeDigestInvalid.WithDetail(err)
+		case errors.As(err, new(distribution.ErrBlobDecompressedSizeExceeded)):
+			codeErr = errcode.ErrorCodeBlobUploadInvalid.WithDetail(err)
+		case errors.As(err, &codeErr):
+		case err == distribution.ErrAccessDenied:
+			codeErr = errcode.ErrorCodeDenied.WithDetail(nil)
+		case err == distribution.ErrBlobInvalidLength, err == distribution.ErrBlobDigestUnsupported:
+			codeErr = errcode.ErrorCodeBlobUploadInvalid.WithDetail(err)
+		default:
+			dcontext.GetLogger(wsuh).Errorf("unknown error completing upload: %v", err)
+			codeErr = errcode.ErrorCodeUnknown.WithDetail(err)
+		}
+
+		// Clean up the backend blob data if there was an error.
+		if err := wsuh.Upload.Cancel(wsuh); err != nil {
+			dcontext.GetLogger(wsuh).Errorf("error canceling upload after error: %v", err)
+		}
+		wsuh.fail(conn, codeErr)
+		return
+	}
+
+	ref, err := reference.WithDigest(wsuh.clientName(), desc.Digest)
+	if err != nil {
+		wsuh.fail(conn, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+	blobURL, err := wsuh.urlBuilder.BuildBlobURL(ref)
+	if err != nil {
+		wsuh.fail(conn, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+	if err := webSocketCodec.Send(conn, webSocketUploadCompletion{Digest: desc.Digest, Location: blobURL}); err != nil {
+		dcontext.GetLogger(wsuh).Infof("error sending WebSocket upload completion: %v", err)
+		return
+	}
+	dcontext.GetLogger(wsuh).Infof("blob %s uploaded over WebSocket", desc.Digest)
+}
+
+// fail sends err to the client, before the connection is closed.
+func (wsuh *webSocketUploadHandler) fail(conn *websocket.Conn, err errcode.Error) {
+	dcontext.GetLogger(wsuh).Infof("WebSocket upload failed: %v", err)
+	if err := webSocketCodec.Send(conn, errcode.Errors{err}); err != nil {
+		dcontext.GetLogger(wsuh).Infof("error sending WebSocket upload error: %v", err)
+	}
+}
+
+// webSocketOriginAllowed reports whether a WebSocket upload may be opened
+// from origin: the allowed origins and the origin of the registry itself
+// are, as are the clients sending no origin, which are not browsers.
+func (app *App) webSocketOriginAllowed(origin string, r *http.Request) bool {
+	if origin == "" {
+		return true
+	}
+	for _, allowed := range app.webSocketOrigins {
+		if strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// hijacker lets the WebSocket server take over the connection of a response
+// writer wrapped by the registry, through http.ResponseController.
+type hijacker struct {
+	http.ResponseWriter
+}
+
+func (h hijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(h.ResponseWriter).Hijack()
+}
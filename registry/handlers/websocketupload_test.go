@@ -0,0 +1,252 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/factory"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	"golang.org/x/net/websocket"
+)
+
+// dialWebSocketUpload opens a WebSocket upload connection from origin,
+// resuming the upload identified by uuid, if any.
+func dialWebSocketUpload(t *testing.T, env *testEnv, name reference.Named, origin string, values url.Values) (*websocket.Conn, error) {
+	t.Helper()
+	config, err := websocket.NewConfig(strings.Replace(mustWebSocketURL(t, env, name, values), "http://", "ws://", 1), origin)
+	checkErr(t, err, "configuring websocket")
+	return websocket.DialConfig(config)
+}
+
+// receiveWebSocketMessage receives the next JSON text message of conn.
+func receiveWebSocketMessage(t *testing.T, conn *websocket.Conn) map[string]any {
+	t.Helper()
+	var data []byte
+	checkErr(t, websocket.Message.Receive(conn, &data), "receiving websocket message")
+	var msg map[string]any
+	checkErr(t, json.Unmarshal(data, &msg), "decoding websocket message")
+	return msg
+}
+
+func TestWebSocketUpload(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory":    configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[any]any{"enabled": false}},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	config.HTTP.Uploads.WebSocket = configuration.WebSocketUploads{
+		Enabled:        true,
+		AllowedOrigins: []string{"https://build.example.com"},
+	}
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	name, _ := reference.WithName("foo/bar")
+	content := make([]byte, 3<<20)
+	if _, err := rand.Read(content); err != nil {
+		t.Fatal(err)
+	}
+	dgst := digest.FromBytes(content)
+
+	// the first connection sends part of the blob, then is lost
+	conn, err := dialWebSocketUpload(t, env, name, "https://build.example.com", nil)
+	checkErr(t, err, "opening websocket upload")
+	status := receiveWebSocketMessage(t, conn)
+	uuid, _ := status["uuid"].(string)
+	if uuid == "" || status["offset"] != float64(0) {
+		t.Fatalf("unexpected status of the started upload: %v", status)
+	}
+	checkErr(t, websocket.Message.Send(conn, content[:1<<20]), "sending content")
+	checkErr(t, websocket.Message.Send(conn, content[1<<20:2<<20]), "sending content")
+	conn.Close()
+
+	// requests which are not WebSocket handshakes are refused
+	resp, err := http.Get(mustWebSocketURL(t, env, name, url.Values{"uuid": {uuid}}))
+	checkErr(t, err, "resuming without handshake")
+	resp.Body.Close()
+	checkResponse(t, "resuming without handshake", resp, http.StatusUpgradeRequired)
+
+	// resuming from the wrong offset fails
+	resp = webSocketHandshake(t, mustWebSocketURL(t, env, name, url.Values{"uuid": {uuid}, "offset": {"1"}}), env.server.URL)
+	checkResponse(t, "resuming at the wrong offset", resp, http.StatusRequestedRangeNotSatisfiable)
+
+	// the second connection resumes the upload where the first one stopped
+	offset := strconv.Itoa(2 << 20)
+	for i := 0; ; i++ {
+		conn, err = dialWebSocketUpload(t, env, name, env.server.URL, url.Values{"uuid": {uuid}, "offset": {offset}})
+		if err == nil {
+			break
+		}
+		// the first connection may still be flushing its content
+		if i == 50 {
+			t.Fatalf("resuming websocket upload: %v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	status = receiveWebSocketMessage(t, conn)
+	if status["uuid"] != uuid || status["offset"] != float64(2<<20) {
+		t.Fatalf("unexpected status of the resumed upload: %v", status)
+	}
+	checkErr(t, websocket.Message.Send(conn, content[2<<20:]), "sending content")
+	checkErr(t, websocket.Message.Send(conn, `{"digest": "`+dgst.String()+`"}`), "completing upload")
+	completion := receiveWebSocketMessage(t, conn)
+	location, _ := completion["location"].(string)
+	if completion["digest"] != dgst.String() || location == "" {
+		t.Fatalf("unexpected completion of the upload: %v", completion)
+	}
+	conn.Close()
+
+	resp, err = http.Get(location)
+	checkErr(t, err, "fetching uploaded blob")
+	defer resp.Body.Close()
+	checkResponse(t, "fetching uploaded blob", resp, http.StatusOK)
+	body, err := io.ReadAll(resp.Body)
+	checkErr(t, err, "reading uploaded blob")
+	if !bytes.Equal(body, content) {
+		t.Fatal("uploaded blob does not match the content sent")
+	}
+
+	// a wrong digest fails the upload
+	conn, err = dialWebSocketUpload(t, env, name, env.server.URL, nil)
+	checkErr(t, err, "opening websocket upload")
+	receiveWebSocketMessage(t, conn)
+	checkErr(t, websocket.Message.Send(conn, []byte("some content")), "sending content")
+	checkErr(t, websocket.Message.Send(conn, `{"digest": "`+dgst.String()+`"}`), "completing upload")
+	failure := receiveWebSocketMessage(t, conn)
+	if errs, _ := failure["errors"].([]any); len(errs) != 1 || errs[0].(map[string]any)["code"] != "DIGEST_INVALID" {
+		t.Fatalf("unexpected failure of the upload: %v", failure)
+	}
+	conn.Close()
+
+	// other origins are denied
+	resp = webSocketHandshake(t, mustWebSocketURL(t, env, name, nil), "https://evil.example.com")
+	checkResponse(t, "opening websocket upload from a denied origin", resp, http.StatusForbidden)
+}
+
+func TestWebSocketUploadDisabled(t *testing.T) {
+	env := newTestEnv(t, false)
+	defer env.Shutdown()
+
+	name, _ := reference.WithName("foo/bar")
+	resp, err := http.Get(mustWebSocketURL(t, env, name, nil))
+	checkErr(t, err, "opening websocket upload")
+	resp.Body.Close()
+	checkResponse(t, "opening websocket upload", resp, http.StatusNotFound)
+}
+
+// uploadClosingDriverFactory creates the driver of the current test.
+type uploadClosingDriverFactory struct {
+	driver *uploadClosingDriver
+}
+
+func (factory *uploadClosingDriverFactory) Create(ctx context.Context, parameters map[string]any) (storagedriver.StorageDriver, error) {
+	return factory.driver, nil
+}
+
+// uploadClosingDriver counts the closes of the writers of upload data, and
+// the uploads marked as abandoned.
+type uploadClosingDriver struct {
+	storagedriver.StorageDriver
+	closes    atomic.Int64
+	abandoned atomic.Int64
+}
+
+func (d *uploadClosingDriver) Writer(ctx context.Context, path string, append bool) (storagedriver.FileWriter, error) {
+	fw, err := d.StorageDriver.Writer(ctx, path, append)
+	if err != nil || !strings.Contains(path, "/_uploads/") || !strings.HasSuffix(path, "/data") {
+		return fw, err
+	}
+	return &uploadClosingWriter{FileWriter: fw, closes: &d.closes}, nil
+}
+
+func (d *uploadClosingDriver) PutContent(ctx context.Context, path string, content []byte) error {
+	if strings.HasSuffix(path, "/abandonedat") {
+		defer d.abandoned.Add(1)
+	}
+	return d.StorageDriver.PutContent(ctx, path, content)
+}
+
+type uploadClosingWriter struct {
+	storagedriver.FileWriter
+	closes *atomic.Int64
+}
+
+func (w *uploadClosingWriter) Close() error {
+	w.closes.Add(1)
+	return w.FileWriter.Close()
+}
+
+func TestWebSocketUploadClosedOnce(t *testing.T) {
+	closingFactory := &uploadClosingDriverFactory{driver: &uploadClosingDriver{StorageDriver: inmemory.New()}}
+	factory.Register("uploadclosingstorage", closingFactory)
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"uploadclosingstorage": configuration.Parameters{},
+			"maintenance":          configuration.Parameters{"uploadpurging": map[any]any{"enabled": false}},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	config.HTTP.Uploads.WebSocket = configuration.WebSocketUploads{Enabled: true}
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	name, _ := reference.WithName("foo/bar")
+	conn, err := dialWebSocketUpload(t, env, name, env.server.URL, nil)
+	checkErr(t, err, "opening websocket upload")
+	receiveWebSocketMessage(t, conn)
+	checkErr(t, websocket.Message.Send(conn, []byte("some content")), "sending content")
+	conn.Close()
+
+	// the upload lost is marked as abandoned once closed
+	for i := 0; closingFactory.driver.abandoned.Load() == 0; i++ {
+		if i == 50 {
+			t.Fatal("the upload lost was not marked as abandoned")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if closes := closingFactory.driver.closes.Load(); closes != 1 {
+		t.Fatalf("expected the upload lost to be closed once, got %d closes", closes)
+	}
+}
+
+// webSocketHandshake sends the handshake of a WebSocket connection from
+// origin to uploadURL, and returns the response.
+func webSocketHandshake(t *testing.T, uploadURL, origin string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, uploadURL, nil)
+	checkErr(t, err, "building websocket handshake")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	req.Header.Set("Origin", origin)
+	resp, err := http.DefaultClient.Do(req)
+	checkErr(t, err, "sending websocket handshake")
+	resp.Body.Close()
+	return resp
+}
+
+// mustWebSocketURL returns the url of a WebSocket upload.
+func mustWebSocketURL(t *testing.T, env *testEnv, name reference.Named, values url.Values) string {
+	t.Helper()
+	uploadURL, err := env.builder.BuildBlobUploadWebSocketURL(name, values)
+	checkErr(t, err, "building websocket upload url")
+	return uploadURL
+}
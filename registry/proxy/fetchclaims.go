@@ -0,0 +1,127 @@
+package proxy
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	// blobFetchClaimTTL bounds the time a blob fetch stays claimed in redis
+	// if the registry fetching it dies. The claim is refreshed while the
+	// fetch proceeds.
+	blobFetchClaimTTL = 30 * time.Second
+
+	// blobFetchPollInterval is the interval between two checks of a blob
+	// fetch claimed by another registry.
+	blobFetchPollInterval = 100 * time.Millisecond
+)
+
+// refreshClaimScript extends a claim only if it is still held by the same
+// holder.
+var refreshClaimScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// releaseClaimScript deletes a claim only if it is still held by the same
+// holder, so that a claim which expired and was taken over by another
+// registry is not released.
+var releaseClaimScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// blobFetchClaims coordinates the fetches of blobs from the remote registry
+// across the registries sharing a redis instance, so that a blob is fetched
+// by only one of them at a time.
+type blobFetchClaims struct {
+	client redis.UniversalClient
+}
+
+func blobFetchClaimKey(name reference.Named, dgst digest.Digest) string {
+	return "proxy::" + name.Name() + "::blobs::" + dgst.String() + "::fetch"
+}
+
+// claim claims the fetch of the blob dgst of repository name, reporting
+// whether the claim was obtained. The claim is refreshed until the returned
+// function releases it.
+func (c *blobFetchClaims) claim(ctx context.Context, name reference.Named, dgst digest.Digest) (func(), bool, error) {
+	var token [16]byte
+	if _, err := rand.Read(token[:]); err != nil {
+		return nil, false, err
+	}
+	key := blobFetchClaimKey(name, dgst)
+	value := hex.EncodeToString(token[:])
+
+	err := c.client.SetArgs(ctx, key, value, redis.SetArgs{Mode: "NX", TTL: blobFetchClaimTTL}).Err()
+	if errors.Is(err, redis.Nil) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	// the claim outlives the request context only until it is released
+	ctx = context.WithoutCancel(ctx)
+	done := make(chan struct{})
+	refreshed := make(chan struct{})
+	go func() {
+		defer close(refreshed)
+		ticker := time.NewTicker(blobFetchClaimTTL / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				ttl := blobFetchClaimTTL.Milliseconds()
+				if err := refreshClaimScript.Run(ctx, c.client, []string{key}, value, ttl).Err(); err != nil {
+					dcontext.GetLogger(ctx).Warnf("failed to refresh fetch claim of blob %s: %v", dgst, err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-refreshed
+		if err := releaseClaimScript.Run(ctx, c.client, []string{key}, value).Err(); err != nil {
+			dcontext.GetLogger(ctx).Errorf("failed to release fetch claim of blob %s: %v", dgst, err)
+		}
+	}, true, nil
+}
+
+// wait waits until the fetch of the blob dgst of repository name is no
+// longer claimed, because it completed, failed or its claim expired.
+func (c *blobFetchClaims) wait(ctx context.Context, name reference.Named, dgst digest.Digest) error {
+	key := blobFetchClaimKey(name, dgst)
+	for {
+		n, err := c.client.Exists(ctx, key).Result()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return nil
+		}
+
+		timer := time.NewTimer(blobFetchPollInterval)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/distribution/distribution/v3/registry/storage/cache/memory"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/opencontainers/go-digest"
+	"github.com/redis/go-redis/v9"
+)
+
+// slowBlobStore delays opening blobs, so that the fetches of a blob from
+// several clients overlap.
+type slowBlobStore struct {
+	distribution.BlobStore
+	delay time.Duration
+}
+
+func (sbs slowBlobStore) Open(ctx context.Context, dgst digest.Digest) (io.ReadSeekCloser, error) {
+	time.Sleep(sbs.delay)
+	return sbs.BlobStore.Open(ctx, dgst)
+}
+
+// makeReplicas returns count proxy blob stores fetching from the remote
+// store of te, over storage shared between them and coordinated through
+// server, as registries running behind a load balancer.
+func makeReplicas(t *testing.T, te *testEnv, server *miniredis.Miniredis, count int) []*proxyBlobStore {
+	t.Helper()
+
+	driver := inmemory.New()
+	var replicas []*proxyBlobStore
+	for range count {
+		registry, err := storage.NewRegistry(te.ctx, driver, storage.BlobDescriptorCacheProvider(memory.NewInMemoryBlobDescriptorCacheProvider(memory.UnlimitedSize)))
+		if err != nil {
+			t.Fatalf("error creating registry: %v", err)
+		}
+		repo, err := registry.Repository(te.ctx, te.store.repositoryName)
+		if err != nil {
+			t.Fatalf("unexpected error getting repo: %v", err)
+		}
+		client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+		t.Cleanup(func() { client.Close() })
+
+		replicas = append(replicas, &proxyBlobStore{
+			repositoryName:    te.store.repositoryName,
+			remoteStore:       slowBlobStore{BlobStore: te.store.remoteStore.(statsBlobStore), delay: 200 * time.Millisecond},
+			localStore:        repo.Blobs(te.ctx),
+			cacheWriteTimeout: time.Minute,
+			authChallenger:    &mockChallenger{},
+			fetchClaims:       &blobFetchClaims{client: client},
+		})
+	}
+	return replicas
+}
+
+// serveBlob serves dgst from pbs, and returns the content served.
+func serveBlob(t *testing.T, pbs *proxyBlobStore, dgst digest.Digest) []byte {
+	w := httptest.NewRecorder()
+	r, err := http.NewRequest(http.MethodGet, "", nil)
+	if err != nil {
+		t.Error(err)
+		return nil
+	}
+	if err := pbs.ServeBlob(context.Background(), w, r, dgst); err != nil {
+		t.Errorf("unexpected error serving blob: %v", err)
+	}
+	return w.Body.Bytes()
+}
+
+func TestProxyStoreServeClaimed(t *testing.T) {
+	defer func(interval time.Duration) { blobFetchPollInterval = interval }(blobFetchPollInterval)
+	blobFetchPollInterval = 10 * time.Millisecond
+
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	te := makeTestEnv(t, "foo/bar")
+	populate(t, te, 1, 4096, 1)
+	dgst := te.inRemote[0].Digest
+	replicas := makeReplicas(t, te, server, 2)
+
+	var wg sync.WaitGroup
+	for _, replica := range replicas {
+		for range 4 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if served := serveBlob(t, replica, dgst); digest.FromBytes(served) != dgst {
+					t.Errorf("client read %d bytes not matching the blob", len(served))
+				}
+			}()
+		}
+	}
+	wg.Wait()
+
+	if opens := (*te.RemoteStats())["open"]; opens != 1 {
+		t.Fatalf("expected the blob to be fetched once from the remote, got %d fetches", opens)
+	}
+	if server.Exists(blobFetchClaimKey(te.store.repositoryName, dgst)) {
+		t.Fatal("expected the fetch claim to be released")
+	}
+}
+
+func TestProxyStoreServeClaimTakeover(t *testing.T) {
+	defer func(interval time.Duration) { blobFetchPollInterval = interval }(blobFetchPollInterval)
+	blobFetchPollInterval = 10 * time.Millisecond
+
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	te := makeTestEnv(t, "foo/bar")
+	populate(t, te, 1, 4096, 1)
+	dgst := te.inRemote[0].Digest
+	replica := makeReplicas(t, te, server, 1)[0]
+
+	// the fetch is claimed by a registry which died while fetching it
+	key := blobFetchClaimKey(te.store.repositoryName, dgst)
+	if err := server.Set(key, "dead"); err != nil {
+		t.Fatal(err)
+	}
+	server.SetTTL(key, blobFetchClaimTTL)
+
+	served := make(chan []byte)
+	go func() { served <- serveBlob(t, replica, dgst) }()
+
+	select {
+	case <-served:
+		t.Fatal("expected the blob to be served only once the claim expires")
+	case <-time.After(100 * time.Millisecond):
+	}
+	if opens := (*te.RemoteStats())["open"]; opens != 0 {
+		t.Fatalf("expected the claimed blob not to be fetched, got %d fetches", opens)
+	}
+
+	server.FastForward(blobFetchClaimTTL)
+	if content := <-served; digest.FromBytes(content) != dgst {
+		t.Fatalf("client read %d bytes not matching the blob", len(content))
+	}
+	if opens := (*te.RemoteStats())["open"]; opens != 1 {
+		t.Fatalf("expected the blob to be fetched once from the remote, got %d fetches", opens)
+	}
+	if server.Exists(key) {
+		t.Fatal("expected the fetch claim to be released")
+	}
+}
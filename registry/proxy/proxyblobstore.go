@@ -23,12 +23,22 @@ type proxyBlobStore struct {
 	scheduler         *scheduler.TTLExpirationScheduler
 	ttl               *time.Duration
 	cacheWriteTimeout time.Duration
+	fetchRetries      int
 	repositoryName    reference.Named
 	authChallenger    authChallenger
+	// fetchClaims coordinates the fetches of blobs with the other
+	// registries sharing redis, if configured.
+	fetchClaims *blobFetchClaims
+	// remote names the remote registry in errors and metrics.
+	remote string
 }
 
 var _ distribution.BlobStore = &proxyBlobStore{}
 
+// fetchRetryBackoff is the delay before the first attempt to resume an
+// interrupted fetch from the remote store, doubled on every attempt.
+var fetchRetryBackoff = time.Second
+
 // inflight tracks currently downloading blobs
 var inflight = make(map[digest.Digest]struct{})
 
@@ -45,19 +55,27 @@ func setResponseHeaders(h http.Header, length int64, mediaType string, digest di
 func (pbs *proxyBlobStore) copyContent(ctx context.Context, dgst digest.Digest, writer io.Writer, h http.Header) (v1.Descriptor, error) {
 	desc, err := pbs.remoteStore.Stat(ctx, dgst)
 	if err != nil {
-		return v1.Descriptor{}, err
+		return v1.Descriptor{}, upstreamAuthFailure(ctx, pbs.remote, err)
 	}
 
 	setResponseHeaders(h, desc.Size, desc.MediaType, dgst)
 
 	remoteReader, err := pbs.remoteStore.Open(ctx, dgst)
 	if err != nil {
-		return v1.Descriptor{}, err
+		return v1.Descriptor{}, upstreamAuthFailure(ctx, pbs.remote, err)
 	}
 
-	defer remoteReader.Close()
+	rr := &resumingReader{
+		ctx:     ctx,
+		remote:  pbs.remoteStore,
+		dgst:    dgst,
+		size:    desc.Size,
+		retries: pbs.fetchRetries,
+		reader:  remoteReader,
+	}
+	defer rr.Close()
 
-	_, err = io.CopyN(writer, remoteReader, desc.Size)
+	_, err = io.CopyN(writer, rr, desc.Size)
 	if err != nil {
 		return v1.Descriptor{}, err
 	}
@@ -68,6 +86,97 @@ func (pbs *proxyBlobStore) copyContent(ctx context.Context, dgst digest.Digest,
 	return desc, nil
 }
 
+// resumingReader reads a blob from the remote store. When reading fails,
+// the blob is reopened and the fetch resumes from the offset reached, up to
+// retries times. Errors writing the content read are left to the caller,
+// and the digest of the whole content is still verified on commit.
+type resumingReader struct {
+	ctx     context.Context
+	remote  distribution.BlobService
+	dgst    digest.Digest
+	size    int64
+	retries int
+
+	reader   io.ReadSeekCloser
+	offset   int64
+	attempts int
+}
+
+func (rr *resumingReader) Read(p []byte) (int, error) {
+	for {
+		if rr.reader == nil {
+			if err := rr.open(); err != nil {
+				if !rr.retry(err) {
+					return 0, err
+				}
+				continue
+			}
+		}
+
+		n, err := rr.reader.Read(p)
+		rr.offset += int64(n)
+		if err == io.EOF && rr.offset < rr.size {
+			err = io.ErrUnexpectedEOF
+		}
+		if err == nil || err == io.EOF {
+			return n, err
+		}
+
+		rr.reader.Close()
+		rr.reader = nil
+		if !rr.retry(err) {
+			return n, err
+		}
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+// open reopens the blob at the offset reached.
+func (rr *resumingReader) open() error {
+	reader, err := rr.remote.Open(rr.ctx, rr.dgst)
+	if err != nil {
+		return err
+	}
+	if _, err := reader.Seek(rr.offset, io.SeekStart); err != nil {
+		reader.Close()
+		return err
+	}
+	rr.reader = reader
+	return nil
+}
+
+// retry waits before the next attempt to resume the fetch after err. It
+// returns false if the fetch must be abandoned.
+func (rr *resumingReader) retry(err error) bool {
+	if rr.attempts >= rr.retries || rr.ctx.Err() != nil {
+		return false
+	}
+	backoff := fetchRetryBackoff << rr.attempts
+	rr.attempts++
+
+	dcontext.GetLoggerWithFields(rr.ctx, map[any]any{
+		"digest":  rr.dgst,
+		"offset":  rr.offset,
+		"attempt": rr.attempts,
+	}).Warnf("fetching blob from remote failed, resuming in %s: %v", backoff, err)
+
+	select {
+	case <-time.After(backoff):
+		return true
+	case <-rr.ctx.Done():
+		return false
+	}
+}
+
+func (rr *resumingReader) Close() error {
+	if rr.reader == nil {
+		return nil
+	}
+	return rr.reader.Close()
+}
+
 func (pbs *proxyBlobStore) serveLocal(ctx context.Context, w http.ResponseWriter, r *http.Request, dgst digest.Digest) (bool, error) {
 	localDesc, err := pbs.localStore.Stat(ctx, dgst)
 	if err != nil {
@@ -95,6 +204,10 @@ func (pbs *proxyBlobStore) ServeBlob(ctx context.Context, w http.ResponseWriter,
 		return err
 	}
 
+	if pbs.fetchClaims != nil {
+		return pbs.serveClaimed(ctx, w, r, dgst)
+	}
+
 	mu.Lock()
 	_, ok := inflight[dgst]
 	if ok {
@@ -114,6 +227,51 @@ func (pbs *proxyBlobStore) ServeBlob(ctx context.Context, w http.ResponseWriter,
 		mu.Unlock()
 	}()
 
+	return pbs.fetch(ctx, w, dgst)
+}
+
+// serveClaimed serves the blob dgst, fetching it from the remote store only
+// if its fetch is not claimed by another registry, or waiting for the other
+// registry to store it locally otherwise. The fetch is taken over if the
+// other registry fails it or dies.
+func (pbs *proxyBlobStore) serveClaimed(ctx context.Context, w http.ResponseWriter, r *http.Request, dgst digest.Digest) error {
+	for {
+		release, claimed, err := pbs.fetchClaims.claim(ctx, pbs.repositoryName, dgst)
+		if err != nil {
+			dcontext.GetLogger(ctx).Warnf("Error claiming fetch of blob %s, fetching it unclaimed: %v", dgst, err)
+			return pbs.fetch(ctx, w, dgst)
+		}
+		if claimed {
+			err := pbs.fetchClaimed(ctx, w, r, dgst)
+			release()
+			return err
+		}
+
+		if err := pbs.fetchClaims.wait(ctx, pbs.repositoryName, dgst); err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			dcontext.GetLogger(ctx).Warnf("Error waiting for fetch of blob %s, fetching it unclaimed: %v", dgst, err)
+			return pbs.fetch(ctx, w, dgst)
+		}
+		if served, err := pbs.serveLocal(ctx, w, r, dgst); served || err != nil {
+			return err
+		}
+	}
+}
+
+// fetchClaimed serves the blob dgst whose fetch was claimed. The previous
+// claim may have completed since the blob was looked up locally.
+func (pbs *proxyBlobStore) fetchClaimed(ctx context.Context, w http.ResponseWriter, r *http.Request, dgst digest.Digest) error {
+	if served, err := pbs.serveLocal(ctx, w, r, dgst); served || err != nil {
+		return err
+	}
+	return pbs.fetch(ctx, w, dgst)
+}
+
+// fetch serves the blob dgst from the remote store, storing it locally over
+// the same fetching request.
+func (pbs *proxyBlobStore) fetch(ctx context.Context, w http.ResponseWriter, dgst digest.Digest) error {
 	// Create a detached context for the blob writer that won't be canceled
 	// when the HTTP request context is canceled. This allows the cache write
 	// to complete even if the client disconnects.
@@ -181,7 +339,11 @@ func (pbs *proxyBlobStore) Stat(ctx context.Context, dgst digest.Digest) (v1.Des
 		return v1.Descriptor{}, err
 	}
 
-	return pbs.remoteStore.Stat(ctx, dgst)
+	desc, err = pbs.remoteStore.Stat(ctx, dgst)
+	if err != nil {
+		return v1.Descriptor{}, upstreamAuthFailure(ctx, pbs.remote, err)
+	}
+	return desc, nil
 }
 
 func (pbs *proxyBlobStore) Get(ctx context.Context, dgst digest.Digest) ([]byte, error) {
@@ -196,7 +358,7 @@ func (pbs *proxyBlobStore) Get(ctx context.Context, dgst digest.Digest) ([]byte,
 
 	blob, err = pbs.remoteStore.Get(ctx, dgst)
 	if err != nil {
-		return []byte{}, err
+		return []byte{}, upstreamAuthFailure(ctx, pbs.remote, err)
 	}
 
 	_, err = pbs.localStore.Put(ctx, "", blob)
@@ -6,6 +6,7 @@ import (
 	"math/rand"
 	"net/http"
 	"net/http/httptest"
+	"slices"
 	"sync"
 	"testing"
 	"time"
@@ -530,3 +531,114 @@ func testProxyStoreServe(t *testing.T, te *testEnv, numClients int) {
 		t.Fatalf("unexpected remote stats: %#v", remoteStats)
 	}
 }
+
+// flakyBlobStore drops the connection of the first readers it opens once
+// they have read dropAfter bytes.
+type flakyBlobStore struct {
+	distribution.BlobStore
+
+	mu        sync.Mutex
+	drops     int
+	dropAfter int64
+	offsets   []int64
+}
+
+func (fbs *flakyBlobStore) Open(ctx context.Context, dgst digest.Digest) (io.ReadSeekCloser, error) {
+	rsc, err := fbs.BlobStore.Open(ctx, dgst)
+	if err != nil {
+		return nil, err
+	}
+
+	fbs.mu.Lock()
+	defer fbs.mu.Unlock()
+	if fbs.drops == 0 {
+		return &flakyReader{ReadSeekCloser: rsc, store: fbs, remaining: -1}, nil
+	}
+	fbs.drops--
+	return &flakyReader{ReadSeekCloser: rsc, store: fbs, remaining: fbs.dropAfter}, nil
+}
+
+type flakyReader struct {
+	io.ReadSeekCloser
+	store     *flakyBlobStore
+	remaining int64
+}
+
+func (fr *flakyReader) Read(p []byte) (int, error) {
+	if fr.remaining == 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	if fr.remaining > 0 && int64(len(p)) > fr.remaining {
+		p = p[:fr.remaining]
+	}
+	n, err := fr.ReadSeekCloser.Read(p)
+	if fr.remaining > 0 {
+		fr.remaining -= int64(n)
+	}
+	return n, err
+}
+
+func (fr *flakyReader) Seek(offset int64, whence int) (int64, error) {
+	fr.store.mu.Lock()
+	fr.store.offsets = append(fr.store.offsets, offset)
+	fr.store.mu.Unlock()
+	return fr.ReadSeekCloser.Seek(offset, whence)
+}
+
+func TestProxyStoreServeResumesFetch(t *testing.T) {
+	defer func(backoff time.Duration) { fetchRetryBackoff = backoff }(fetchRetryBackoff)
+	fetchRetryBackoff = time.Millisecond
+
+	for _, tc := range []struct {
+		name    string
+		retries int
+		success bool
+	}{
+		{name: "resumed", retries: 3, success: true},
+		{name: "retries exhausted", retries: 1, success: false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			te := makeTestEnv(t, "foo/bar")
+			populate(t, te, 1, 3000, 1)
+			blob := te.inRemote[0]
+
+			remote := &flakyBlobStore{BlobStore: te.store.remoteStore.(statsBlobStore), drops: 2, dropAfter: 1000}
+			te.store.remoteStore = remote
+			te.store.fetchRetries = tc.retries
+			te.store.cacheWriteTimeout = time.Minute
+
+			w := httptest.NewRecorder()
+			r, err := http.NewRequest(http.MethodGet, "", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = te.store.ServeBlob(te.ctx, w, r, blob.Digest)
+			if !tc.success {
+				if err == nil {
+					t.Fatal("expected fetch to fail once retries are exhausted")
+				}
+				if _, err := te.store.localStore.Stat(te.ctx, blob.Digest); err != distribution.ErrBlobUnknown {
+					t.Fatalf("expected blob not to be cached, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error serving blob: %v", err)
+			}
+			if digest.FromBytes(w.Body.Bytes()) != blob.Digest {
+				t.Fatalf("client read %d bytes not matching the blob", w.Body.Len())
+			}
+			if expected := []int64{1000, 2000}; !slices.Equal(remote.offsets, expected) {
+				t.Errorf("expected fetch to resume at offsets %v, got %v", expected, remote.offsets)
+			}
+
+			cached, err := te.store.localStore.Get(te.ctx, blob.Digest)
+			if err != nil {
+				t.Fatalf("expected blob to be cached: %v", err)
+			}
+			if digest.FromBytes(cached) != blob.Digest {
+				t.Fatalf("cached blob does not match its digest")
+			}
+		})
+	}
+}
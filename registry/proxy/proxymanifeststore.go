@@ -20,6 +20,8 @@ type proxyManifestStore struct {
 	scheduler       *scheduler.TTLExpirationScheduler
 	ttl             *time.Duration
 	authChallenger  authChallenger
+	// remote names the remote registry in errors and metrics.
+	remote string
 }
 
 var _ distribution.ManifestService = &proxyManifestStore{}
@@ -35,7 +37,11 @@ func (pms proxyManifestStore) Exists(ctx context.Context, dgst digest.Digest) (b
 	if err := pms.authChallenger.tryEstablishChallenges(ctx); err != nil {
 		return false, err
 	}
-	return pms.remoteManifests.Exists(ctx, dgst)
+	exists, err = pms.remoteManifests.Exists(ctx, dgst)
+	if err != nil {
+		return false, upstreamAuthFailure(ctx, pms.remote, err)
+	}
+	return exists, nil
 }
 
 func (pms proxyManifestStore) Get(ctx context.Context, dgst digest.Digest, options ...distribution.ManifestServiceOption) (distribution.Manifest, error) {
@@ -50,7 +56,7 @@ func (pms proxyManifestStore) Get(ctx context.Context, dgst digest.Digest, optio
 
 		manifest, err = pms.remoteManifests.Get(ctx, dgst, options...)
 		if err != nil {
-			return nil, err
+			return nil, upstreamAuthFailure(ctx, pms.remote, err)
 		}
 		fromRemote = true
 	}
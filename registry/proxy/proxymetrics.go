@@ -19,6 +19,8 @@ var (
 	pulledBytes = prometheus.ProxyNamespace.NewLabeledCounter("pulled_bytes", "The size of total bytes pulled from the upstream", "type")
 	// pushedBytes is the size of total bytes pushed to the client for blob/manifest
 	pushedBytes = prometheus.ProxyNamespace.NewLabeledCounter("pushed_bytes", "The size of total bytes pushed to the client", "type")
+	// upstreamAuthFailures is the number of requests the upstream rejected the credentials of the proxy for
+	upstreamAuthFailures = prometheus.ProxyNamespace.NewLabeledCounter("upstream_auth_failures", "The number of requests the upstream rejected the credentials of the proxy for", "remote")
 )
 
 // Metrics is used to hold metric counters
@@ -34,6 +36,9 @@ type Metrics struct {
 type proxyMetricsCollector struct {
 	blobMetrics     Metrics
 	manifestMetrics Metrics
+	// upstreamAuthFailures counts the rejections of the credentials of the
+	// proxy per remote.
+	upstreamAuthFailures expvar.Map
 }
 
 // proxyMetrics tracks metrics about the proxy cache.  This is
@@ -61,6 +66,8 @@ func init() {
 		return proxyMetrics.manifestMetrics
 	}))
 
+	pm.(*expvar.Map).Set("upstreamauthfailures", &proxyMetrics.upstreamAuthFailures)
+
 	metrics.Register(prometheus.ProxyNamespace)
 	initPrometheusMetrics("blob")
 	initPrometheusMetrics("manifest")
@@ -121,3 +128,11 @@ func (pmc *proxyMetricsCollector) ManifestPush(bytesPushed uint64, isHit bool) {
 		hits.WithValues("manifest").Inc(1)
 	}
 }
+
+// UpstreamAuthFailure tracks the requests remote rejected the credentials of
+// the proxy for
+func (pmc *proxyMetricsCollector) UpstreamAuthFailure(remote string) {
+	pmc.upstreamAuthFailures.Add(remote, 1)
+
+	upstreamAuthFailures.WithValues(remote).Inc(1)
+}
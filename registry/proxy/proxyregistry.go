@@ -13,6 +13,7 @@ import (
 
 	"github.com/distribution/distribution/v3"
 	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/health"
 	"github.com/distribution/distribution/v3/internal/client"
 	"github.com/distribution/distribution/v3/internal/client/auth"
 	"github.com/distribution/distribution/v3/internal/client/auth/challenge"
@@ -21,23 +22,33 @@ import (
 	"github.com/distribution/distribution/v3/registry/proxy/scheduler"
 	"github.com/distribution/distribution/v3/registry/storage"
 	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/redis/go-redis/v9"
 )
 
 var repositoryTTL = 24 * 7 * time.Hour
 
+// defaultFetchRetries is the number of times an interrupted fetch of a blob
+// from the remote registry is resumed by default.
+const defaultFetchRetries = 3
+
 // proxyingRegistry fetches content from a remote registry and caches it locally
 type proxyingRegistry struct {
 	embedded          distribution.Namespace // provides local registry functionality
 	scheduler         *scheduler.TTLExpirationScheduler
 	ttl               *time.Duration
 	cacheWriteTimeout time.Duration
+	fetchRetries      int
+	revalidate        bool
 	remoteURL         url.URL
 	authChallenger    authChallenger
 	basicAuth         auth.CredentialStore
+	fetchClaims       *blobFetchClaims
 }
 
-// NewRegistryPullThroughCache creates a registry acting as a pull through cache
-func NewRegistryPullThroughCache(ctx context.Context, registry distribution.Namespace, driver driver.StorageDriver, config configuration.Proxy) (distribution.Namespace, error) {
+// NewRegistryPullThroughCache creates a registry acting as a pull through cache.
+// If redisClient is not nil, the fetches of blobs from the remote registry are
+// coordinated with the other registries sharing it.
+func NewRegistryPullThroughCache(ctx context.Context, registry distribution.Namespace, driver driver.StorageDriver, redisClient redis.UniversalClient, config configuration.Proxy) (distribution.Namespace, error) {
 	remoteURL, err := url.Parse(config.RemoteURL)
 	if err != nil {
 		return nil, err
@@ -63,6 +74,14 @@ func NewRegistryPullThroughCache(ctx context.Context, registry distribution.Name
 		cacheWriteTimeout = *config.CacheWriteTimeout
 	}
 
+	fetchRetries := defaultFetchRetries
+	if config.FetchRetries != nil {
+		if *config.FetchRetries < 0 {
+			return nil, fmt.Errorf("invalid proxy fetchretries: %d is negative", *config.FetchRetries)
+		}
+		fetchRetries = *config.FetchRetries
+	}
+
 	if ttl != nil {
 		s = scheduler.New(ctx, driver, "/scheduler-state.json")
 		s.OnBlobExpire(func(ref reference.Reference) error {
@@ -135,18 +154,26 @@ func NewRegistryPullThroughCache(ctx context.Context, registry distribution.Name
 		return nil, err
 	}
 
+	var fetchClaims *blobFetchClaims
+	if redisClient != nil {
+		fetchClaims = &blobFetchClaims{client: redisClient}
+	}
+
 	return &proxyingRegistry{
 		embedded:          registry,
 		scheduler:         s,
 		ttl:               ttl,
 		cacheWriteTimeout: cacheWriteTimeout,
+		fetchRetries:      fetchRetries,
+		revalidate:        config.Revalidate,
 		remoteURL:         *remoteURL,
 		authChallenger: &remoteAuthChallenger{
 			remoteURL: *remoteURL,
 			cm:        challenge.NewSimpleManager(),
 			cs:        cs,
 		},
-		basicAuth: b,
+		basicAuth:   b,
+		fetchClaims: fetchClaims,
 	}, nil
 }
 
@@ -197,6 +224,7 @@ func (pr *proxyingRegistry) Repository(ctx context.Context, name reference.Named
 		return nil, err
 	}
 
+	remote := remoteName(pr.remoteURL)
 	return &proxiedRepository{
 		blobStore: &proxyBlobStore{
 			localStore:        localRepo.Blobs(ctx),
@@ -204,8 +232,11 @@ func (pr *proxyingRegistry) Repository(ctx context.Context, name reference.Named
 			scheduler:         pr.scheduler,
 			ttl:               pr.ttl,
 			cacheWriteTimeout: pr.cacheWriteTimeout,
+			fetchRetries:      pr.fetchRetries,
 			repositoryName:    name,
 			authChallenger:    pr.authChallenger,
+			fetchClaims:       pr.fetchClaims,
+			remote:            remote,
 		},
 		manifests: &proxyManifestStore{
 			repositoryName:  name,
@@ -215,12 +246,15 @@ func (pr *proxyingRegistry) Repository(ctx context.Context, name reference.Named
 			scheduler:       pr.scheduler,
 			ttl:             pr.ttl,
 			authChallenger:  pr.authChallenger,
+			remote:          remote,
 		},
 		name: name,
 		tags: &proxyTagService{
 			localTags:      localRepo.Tags(ctx),
 			remoteTags:     remoteRepo.Tags(ctx),
 			authChallenger: pr.authChallenger,
+			revalidate:     pr.revalidate,
+			remote:         remote,
 		},
 	}, nil
 }
@@ -238,6 +272,23 @@ type Closer interface {
 	Close() error
 }
 
+// HealthReporter reports the progress of the background components of a
+// registry.
+type HealthReporter interface {
+	// ReportHealth reports the progress of each background component to
+	// the check returned by newComponent.
+	ReportHealth(newComponent health.ComponentFunc)
+}
+
+// ReportHealth reports the progress of the TTL expiration scheduler, if any,
+// to the proxyscheduler component.
+func (pr *proxyingRegistry) ReportHealth(newComponent health.ComponentFunc) {
+	if pr.scheduler == nil {
+		return
+	}
+	pr.scheduler.ReportHealth(newComponent("proxyscheduler", time.Minute))
+}
+
 func (pr *proxyingRegistry) Close() error {
 	if pr.scheduler == nil {
 		return nil
@@ -5,26 +5,45 @@ import (
 	"io"
 
 	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/opencontainers/go-digest"
 	v1 "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
+// tagRevalidator is implemented by the tag services of remote registries
+// able to check whether a tag still references a manifest with a conditional
+// request.
+type tagRevalidator interface {
+	Revalidate(ctx context.Context, tag string, dgst digest.Digest) (desc v1.Descriptor, modified bool, err error)
+}
+
 // proxyTagService supports local and remote lookup of tags.
 type proxyTagService struct {
 	localTags      distribution.TagService
 	remoteTags     distribution.TagService
 	authChallenger authChallenger
+	// revalidate tells whether the tags cached locally are revalidated
+	// against the remote registry rather than looked up again.
+	revalidate bool
+	// remote names the remote registry in errors and metrics.
+	remote string
 }
 
 var _ distribution.TagService = proxyTagService{}
 
 // Get attempts to get the most recent digest for the tag by checking the remote
 // tag service first and then caching it locally.  If the remote is unavailable
-// the local association is returned
+// the local association is returned. If there is none and the remote rejected
+// the credentials of the proxy, that rejection is returned.
 func (pt proxyTagService) Get(ctx context.Context, tag string) (v1.Descriptor, error) {
-	err := pt.authChallenger.tryEstablishChallenges(ctx)
-	if err == nil {
-		desc, err := pt.remoteTags.Get(ctx, tag)
-		if err == nil {
+	remoteErr := pt.authChallenger.tryEstablishChallenges(ctx)
+	if remoteErr == nil {
+		if desc, ok, err := pt.revalidated(ctx, tag); ok || err != nil {
+			return desc, err
+		}
+		var desc v1.Descriptor
+		desc, remoteErr = pt.remoteTags.Get(ctx, tag)
+		if remoteErr == nil {
 			err := pt.localTags.Tag(ctx, tag, desc)
 			if err != nil {
 				return v1.Descriptor{}, err
@@ -35,11 +54,44 @@ func (pt proxyTagService) Get(ctx context.Context, tag string) (v1.Descriptor, e
 
 	desc, err := pt.localTags.Get(ctx, tag)
 	if err != nil {
+		if isUpstreamAuthFailure(remoteErr) {
+			return v1.Descriptor{}, upstreamAuthFailure(ctx, pt.remote, remoteErr)
+		}
 		return v1.Descriptor{}, err
 	}
 	return desc, nil
 }
 
+// revalidated returns the local association of tag if revalidation is enabled
+// and the remote registry confirms the tag still references the same manifest,
+// so that the cached manifest is served without being downloaded again. A tag
+// the remote registry moved is associated with its new manifest, which is
+// then downloaded by the manifest store. As with lookups, the local
+// association is returned if the remote registry is unavailable.
+func (pt proxyTagService) revalidated(ctx context.Context, tag string) (v1.Descriptor, bool, error) {
+	revalidator, ok := pt.remoteTags.(tagRevalidator)
+	if !pt.revalidate || !ok {
+		return v1.Descriptor{}, false, nil
+	}
+	cached, err := pt.localTags.Get(ctx, tag)
+	if err != nil {
+		return v1.Descriptor{}, false, nil
+	}
+
+	desc, modified, err := revalidator.Revalidate(ctx, tag, cached.Digest)
+	if err != nil {
+		dcontext.GetLogger(ctx).Warnf("failed to revalidate tag %s, serving the cached manifest: %v", tag, err)
+		return cached, true, nil
+	}
+	if !modified {
+		return cached, true, nil
+	}
+	if err := pt.localTags.Tag(ctx, tag, desc); err != nil {
+		return v1.Descriptor{}, false, err
+	}
+	return desc, true, nil
+}
+
 func (pt proxyTagService) Tag(ctx context.Context, tag string, desc v1.Descriptor) error {
 	return distribution.ErrUnsupported
 }
@@ -2,12 +2,14 @@ package proxy
 
 import (
 	"context"
+	"errors"
 	"reflect"
 	"sort"
 	"sync"
 	"testing"
 
 	"github.com/distribution/distribution/v3"
+	"github.com/opencontainers/go-digest"
 	v1 "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
@@ -223,3 +225,95 @@ func TestGet(t *testing.T) {
 		t.Fatalf("Expected 6 auth challenge calls, got %#v", proxyTags.authChallenger)
 	}
 }
+
+// revalidatingTagStore is a remote tag store answering conditional requests.
+type revalidatingTagStore struct {
+	*mockTagStore
+	gets          int
+	revalidations int
+	err           error
+}
+
+func (m *revalidatingTagStore) Get(ctx context.Context, tag string) (v1.Descriptor, error) {
+	m.gets++
+	return m.mockTagStore.Get(ctx, tag)
+}
+
+func (m *revalidatingTagStore) Revalidate(ctx context.Context, tag string, dgst digest.Digest) (v1.Descriptor, bool, error) {
+	m.revalidations++
+	if m.err != nil {
+		return v1.Descriptor{}, false, m.err
+	}
+	desc, err := m.mockTagStore.Get(ctx, tag)
+	if err != nil {
+		return v1.Descriptor{}, false, err
+	}
+	if desc.Digest == dgst {
+		return v1.Descriptor{}, false, nil
+	}
+	return desc, true, nil
+}
+
+func TestGetRevalidate(t *testing.T) {
+	ctx := context.Background()
+	cachedDesc := v1.Descriptor{Digest: digest.FromString("cached"), Size: 42}
+	movedDesc := v1.Descriptor{Digest: digest.FromString("moved"), Size: 43}
+
+	for _, tc := range []struct {
+		name      string
+		remote    v1.Descriptor
+		remoteErr error
+		expected  v1.Descriptor
+	}{
+		{name: "unchanged", remote: cachedDesc, expected: cachedDesc},
+		{name: "changed", remote: movedDesc, expected: movedDesc},
+		{name: "unavailable", remote: movedDesc, remoteErr: errors.New("unavailable"), expected: cachedDesc},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			remoteTags := &revalidatingTagStore{
+				mockTagStore: &mockTagStore{mapping: map[string]v1.Descriptor{"latest": tc.remote}},
+				err:          tc.remoteErr,
+			}
+			proxyTags := &proxyTagService{
+				localTags:      &mockTagStore{mapping: map[string]v1.Descriptor{"latest": cachedDesc}},
+				remoteTags:     remoteTags,
+				authChallenger: &mockChallenger{},
+				revalidate:     true,
+			}
+
+			d, err := proxyTags.Get(ctx, "latest")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(d, tc.expected) {
+				t.Fatalf("expected %v, got %v", tc.expected, d)
+			}
+			if remoteTags.revalidations != 1 || remoteTags.gets != 0 {
+				t.Fatalf("expected the tag to be revalidated only, got %d revalidations and %d lookups", remoteTags.revalidations, remoteTags.gets)
+			}
+			local, err := proxyTags.localTags.Get(ctx, "latest")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(local, tc.expected) {
+				t.Fatalf("expected %v to be cached, got %v", tc.expected, local)
+			}
+		})
+	}
+
+	// tags not cached yet are looked up
+	remoteTags := &revalidatingTagStore{mockTagStore: &mockTagStore{mapping: map[string]v1.Descriptor{"latest": movedDesc}}}
+	proxyTags := &proxyTagService{
+		localTags:      &mockTagStore{mapping: map[string]v1.Descriptor{}},
+		remoteTags:     remoteTags,
+		authChallenger: &mockChallenger{},
+		revalidate:     true,
+	}
+	d, err := proxyTags.Get(ctx, "latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(d, movedDesc) || remoteTags.revalidations != 0 || remoteTags.gets != 1 {
+		t.Fatalf("expected the uncached tag to be looked up, got %v after %d revalidations and %d lookups", d, remoteTags.revalidations, remoteTags.gets)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/distribution/distribution/v3/health"
 	"github.com/distribution/distribution/v3/internal/dcontext"
 	"github.com/distribution/distribution/v3/registry/storage/driver"
 	"github.com/distribution/reference"
@@ -63,6 +64,17 @@ type TTLExpirationScheduler struct {
 	indexDirty bool
 	saveTimer  *time.Ticker
 	doneChan   chan struct{}
+
+	// component reports the progress of the state saving loop.
+	component *health.Component
+}
+
+// ReportHealth reports the progress of the scheduler to component, every
+// time its state is saved or found unchanged.
+func (ttles *TTLExpirationScheduler) ReportHealth(component *health.Component) {
+	ttles.Lock()
+	defer ttles.Unlock()
+	ttles.component = component
 }
 
 // OnBlobExpire is called when a scheduled blob's TTL expires
@@ -137,6 +149,7 @@ func (ttles *TTLExpirationScheduler) Start() error {
 			case <-ttles.saveTimer.C:
 				ttles.Lock()
 				if !ttles.indexDirty {
+					ttles.component.Alive()
 					ttles.Unlock()
 					continue
 				}
@@ -146,6 +159,7 @@ func (ttles *TTLExpirationScheduler) Start() error {
 					dcontext.GetLogger(ttles.ctx).Errorf("Error writing scheduler state: %s", err)
 				} else {
 					ttles.indexDirty = false
+					ttles.component.Alive()
 				}
 				ttles.Unlock()
 
@@ -0,0 +1,53 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+
+	"github.com/distribution/distribution/v3/internal/client"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+)
+
+// remoteName returns the name of the remote registry of remoteURL reported in
+// errors and metrics, without the credentials it may include.
+func remoteName(remoteURL url.URL) string {
+	remoteURL.User = nil
+	return remoteURL.String()
+}
+
+// upstreamAuthFailure returns a DENIED error in place of err if err is the
+// rejection of the credentials of the proxy by the remote registry remote, so
+// that clients can tell it from content missing upstream, and err otherwise.
+func upstreamAuthFailure(ctx context.Context, remote string, err error) error {
+	if !isUpstreamAuthFailure(err) {
+		return err
+	}
+
+	dcontext.GetLogger(ctx).Warnf("upstream %s rejected the configured credentials: %v", remote, err)
+	proxyMetrics.UpstreamAuthFailure(remote)
+	return errcode.ErrorCodeDenied.WithDetail(fmt.Sprintf("the upstream registry %s rejected the configured credentials", remote))
+}
+
+// isUpstreamAuthFailure reports whether err is a remote registry failing to
+// authenticate or authorize the proxy, including when fetching a token.
+func isUpstreamAuthFailure(err error) bool {
+	var errs errcode.Errors
+	if errors.As(err, &errs) {
+		return slices.ContainsFunc(errs, isUpstreamAuthFailure)
+	}
+	var coder errcode.ErrorCoder
+	if errors.As(err, &coder) {
+		code := coder.ErrorCode()
+		return code == errcode.ErrorCodeUnauthorized || code == errcode.ErrorCodeDenied
+	}
+	var unexpected *client.UnexpectedHTTPResponseError
+	if errors.As(err, &unexpected) {
+		return unexpected.StatusCode == http.StatusUnauthorized || unexpected.StatusCode == http.StatusForbidden
+	}
+	return false
+}
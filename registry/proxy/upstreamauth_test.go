@@ -0,0 +1,118 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+)
+
+// newUpstream returns a remote registry answering the requests for content
+// with an error of code.
+func newUpstream(t *testing.T, code errcode.ErrorCode) *httptest.Server {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v2/" {
+			return
+		}
+		if err := errcode.ServeJSON(w, code); err != nil {
+			t.Errorf("error serving upstream error: %v", err)
+		}
+	}))
+	t.Cleanup(upstream.Close)
+	return upstream
+}
+
+func TestUpstreamAuthFailure(t *testing.T) {
+	ctx := context.Background()
+	name, _ := reference.WithName("foo/bar")
+	dgst := digest.FromString("content")
+
+	for _, tc := range []struct {
+		name   string
+		code   errcode.ErrorCode
+		denied bool
+	}{
+		{name: "unauthorized", code: errcode.ErrorCodeUnauthorized, denied: true},
+		{name: "denied", code: errcode.ErrorCodeDenied, denied: true},
+		{name: "not found", code: errcode.ErrorCodeNameUnknown},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			upstream := newUpstream(t, tc.code)
+			local, err := storage.NewRegistry(ctx, inmemory.New())
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ttl time.Duration
+			registry, err := NewRegistryPullThroughCache(ctx, local, inmemory.New(), nil, configuration.Proxy{
+				RemoteURL: upstream.URL,
+				Username:  "user",
+				Password:  "secret",
+				TTL:       &ttl,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			repo, err := registry.Repository(ctx, name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			manifests, err := repo.Manifests(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			checkDenied := func(op string, err error) {
+				t.Helper()
+				var denied errcode.Error
+				isDenied := errors.As(err, &denied) && denied.Code == errcode.ErrorCodeDenied
+				if !tc.denied {
+					if err == nil || isDenied {
+						t.Fatalf("%s: expected an error other than denied, got %v", op, err)
+					}
+					return
+				}
+				if !isDenied {
+					t.Fatalf("%s: expected a denied error, got %v", op, err)
+				}
+				detail, _ := denied.Detail.(string)
+				if !strings.Contains(detail, upstream.URL) || strings.Contains(detail, "secret") {
+					t.Fatalf("%s: expected the detail to name the upstream only, got %q", op, detail)
+				}
+			}
+
+			_, err = manifests.Get(ctx, dgst)
+			checkDenied("getting manifest", err)
+			_, err = repo.Blobs(ctx).Stat(ctx, dgst)
+			checkDenied("statting blob", err)
+			_, err = repo.Tags(ctx).Get(ctx, "latest")
+			checkDenied("getting tag", err)
+
+			if !tc.denied {
+				var unknown distribution.ErrTagUnknown
+				if !errors.As(err, &unknown) {
+					t.Fatalf("expected the tag to be unknown, got %v", err)
+				}
+			}
+
+			var failures int64
+			if v, ok := proxyMetrics.upstreamAuthFailures.Get(upstream.URL).(*expvar.Int); ok {
+				failures = v.Value()
+			}
+			if expected := map[bool]int64{true: 3}[tc.denied]; failures != expected {
+				t.Fatalf("expected %d upstream auth failures, got %d", expected, failures)
+			}
+		})
+	}
+}
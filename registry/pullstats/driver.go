@@ -0,0 +1,348 @@
+package pullstats
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+)
+
+const (
+	// DefaultFlushInterval is the default interval at which counters kept
+	// in memory are flushed to the storage driver.
+	DefaultFlushInterval = time.Minute
+
+	// DefaultMaxAge is the default age after which counters are dropped.
+	DefaultMaxAge = 90 * 24 * time.Hour
+
+	// storageRoot is the directory counters are stored in, relative to the
+	// root of the storage driver.
+	storageRoot = "/docker/registry/v2/pullstats"
+)
+
+// storedCounter is the stored form of a counter, in the file of its day.
+type storedCounter struct {
+	Kind      Kind   `json:"kind"`
+	Reference string `json:"reference"`
+	Count     int64  `json:"count"`
+}
+
+// legacyCounter is the stored form of a counter in the single file of the
+// counters of a repository written by former versions.
+type legacyCounter struct {
+	storedCounter
+	Day string `json:"day"`
+}
+
+// DriverStore keeps counters in memory and periodically adds them to the
+// counters stored by the storage driver, in a file per repository and day,
+// so that flushes only rewrite the counters of the days flushed. The files
+// of the days older than the maximum age are deleted.
+//
+// Flushes read, update and write back the stored counters, so the counts of
+// registries sharing a storage backend may be lost when flushed
+// concurrently; use redis for exact counts.
+type DriverStore struct {
+	driver storagedriver.StorageDriver
+	maxAge time.Duration
+
+	mu      sync.Mutex
+	pending map[Counter]int64
+
+	// flushMu serializes flushes, and reads of the counters with flushes.
+	flushMu sync.Mutex
+}
+
+// NewDriverStore returns a store keeping counters in memory, flushed to
+// driver every interval, or DefaultFlushInterval if not positive, until ctx
+// is done. The counters older than maxAge, or DefaultMaxAge if not positive,
+// are dropped.
+func NewDriverStore(ctx context.Context, driver storagedriver.StorageDriver, interval, maxAge time.Duration) *DriverStore {
+	if interval <= 0 {
+		interval = DefaultFlushInterval
+	}
+	if maxAge <= 0 {
+		maxAge = DefaultMaxAge
+	}
+	s := &DriverStore{
+		driver:  driver,
+		maxAge:  maxAge,
+		pending: make(map[Counter]int64),
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := s.Flush(ctx); err != nil {
+					dcontext.GetLogger(ctx).Warnf("error flushing pull stats: %v", err)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return s
+}
+
+func (s *DriverStore) Increment(ctx context.Context, counts map[Counter]int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for counter, n := range counts {
+		s.pending[counter] += n
+	}
+	return nil
+}
+
+func (s *DriverStore) Counters(ctx context.Context, repository string) (map[Counter]int64, error) {
+	// wait for counters being flushed to be stored
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+
+	counters, err := s.stored(ctx, repository)
+	if err != nil {
+		return nil, err
+	}
+
+	oldest := oldestDay(s.maxAge)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for counter, n := range s.pending {
+		if counter.Repository == repository && counter.Day >= oldest {
+			counters[counter] += n
+		}
+	}
+	return counters, nil
+}
+
+// stored returns the counters of repository stored by the driver, from the
+// day files within the maximum age and the legacy file if not folded yet.
+func (s *DriverStore) stored(ctx context.Context, repository string) (map[Counter]int64, error) {
+	counters, err := s.legacy(ctx, repository)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := s.driver.List(ctx, daysPath(repository))
+	if err != nil && !errors.As(err, &storagedriver.PathNotFoundError{}) {
+		return nil, err
+	}
+	oldest := oldestDay(s.maxAge)
+	for _, file := range files {
+		day, ok := strings.CutSuffix(path.Base(file), ".json")
+		if !ok || day < oldest {
+			continue
+		}
+		stored, err := s.day(ctx, repository, day)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range stored {
+			counters[Counter{Repository: repository, Kind: c.Kind, Reference: c.Reference, Day: day}] += c.Count
+		}
+	}
+	return counters, nil
+}
+
+// day returns the counters of repository stored for day.
+func (s *DriverStore) day(ctx context.Context, repository, day string) ([]storedCounter, error) {
+	content, err := s.driver.GetContent(ctx, dayPath(repository, day))
+	if err != nil {
+		if errors.As(err, &storagedriver.PathNotFoundError{}) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var stored []storedCounter
+	if err := json.Unmarshal(content, &stored); err != nil {
+		return nil, err
+	}
+	return stored, nil
+}
+
+// legacy returns the counters of repository within the maximum age stored
+// in the legacy file.
+func (s *DriverStore) legacy(ctx context.Context, repository string) (map[Counter]int64, error) {
+	counters := make(map[Counter]int64)
+	content, err := s.driver.GetContent(ctx, legacyPath(repository))
+	if err != nil {
+		if errors.As(err, &storagedriver.PathNotFoundError{}) {
+			return counters, nil
+		}
+		return nil, err
+	}
+
+	var stored []legacyCounter
+	if err := json.Unmarshal(content, &stored); err != nil {
+		return nil, err
+	}
+	oldest := oldestDay(s.maxAge)
+	for _, c := range stored {
+		if c.Day >= oldest {
+			counters[Counter{Repository: repository, Kind: c.Kind, Reference: c.Reference, Day: c.Day}] += c.Count
+		}
+	}
+	return counters, nil
+}
+
+// Flush adds the pending counters to the stored ones. The counters of
+// repositories failing to flush are kept pending.
+func (s *DriverStore) Flush(ctx context.Context) error {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+
+	s.mu.Lock()
+	pending := s.pending
+	s.pending = make(map[Counter]int64)
+	s.mu.Unlock()
+
+	byRepository := make(map[string]map[Counter]int64)
+	for counter, n := range pending {
+		counts, ok := byRepository[counter.Repository]
+		if !ok {
+			counts = make(map[Counter]int64)
+			byRepository[counter.Repository] = counts
+		}
+		counts[counter] = n
+	}
+
+	var errs []error
+	for repository, counts := range byRepository {
+		if err := s.flushRepository(ctx, repository, counts); err != nil {
+			errs = append(errs, err)
+			_ = s.Increment(ctx, counts)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *DriverStore) flushRepository(ctx context.Context, repository string, counts map[Counter]int64) error {
+	created := false
+	for day, counts := range countsByDay(counts) {
+		stored, err := s.day(ctx, repository, day)
+		if err != nil {
+			return err
+		}
+		created = created || stored == nil
+		if err := s.putDay(ctx, repository, day, stored, counts); err != nil {
+			return err
+		}
+	}
+
+	// a new day is started once a day per repository: fold the legacy
+	// file and drop the days aged out then
+	if created {
+		if err := s.foldLegacy(ctx, repository); err != nil {
+			return err
+		}
+		return s.ageOut(ctx, repository)
+	}
+	return nil
+}
+
+// putDay writes the counters of repository stored for day, with counts
+// added.
+func (s *DriverStore) putDay(ctx context.Context, repository, day string, stored []storedCounter, counts map[Counter]int64) error {
+	counters := make(map[Counter]int64, len(stored)+len(counts))
+	for _, c := range stored {
+		counters[Counter{Kind: c.Kind, Reference: c.Reference}] += c.Count
+	}
+	for counter, n := range counts {
+		counters[Counter{Kind: counter.Kind, Reference: counter.Reference}] += n
+	}
+
+	stored = make([]storedCounter, 0, len(counters))
+	for counter, n := range counters {
+		stored = append(stored, storedCounter{Kind: counter.Kind, Reference: counter.Reference, Count: n})
+	}
+	content, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+	return s.driver.PutContent(ctx, dayPath(repository, day), content)
+}
+
+// foldLegacy moves the counters of the legacy file of repository to the day
+// files, and deletes it.
+func (s *DriverStore) foldLegacy(ctx context.Context, repository string) error {
+	counters, err := s.legacy(ctx, repository)
+	if err != nil {
+		return err
+	}
+	for day, counts := range countsByDay(counters) {
+		stored, err := s.day(ctx, repository, day)
+		if err != nil {
+			return err
+		}
+		if err := s.putDay(ctx, repository, day, stored, counts); err != nil {
+			return err
+		}
+	}
+	if err := s.driver.Delete(ctx, legacyPath(repository)); err != nil && !errors.As(err, &storagedriver.PathNotFoundError{}) {
+		return err
+	}
+	return nil
+}
+
+// ageOut deletes the day files of repository older than the maximum age.
+func (s *DriverStore) ageOut(ctx context.Context, repository string) error {
+	files, err := s.driver.List(ctx, daysPath(repository))
+	if err != nil {
+		return err
+	}
+	oldest := oldestDay(s.maxAge)
+	for _, file := range files {
+		day, ok := strings.CutSuffix(path.Base(file), ".json")
+		if !ok || day >= oldest {
+			continue
+		}
+		if err := s.driver.Delete(ctx, file); err != nil && !errors.As(err, &storagedriver.PathNotFoundError{}) {
+			return err
+		}
+	}
+	return nil
+}
+
+// countsByDay groups counts by day.
+func countsByDay(counts map[Counter]int64) map[string]map[Counter]int64 {
+	byDay := make(map[string]map[Counter]int64)
+	for counter, n := range counts {
+		if byDay[counter.Day] == nil {
+			byDay[counter.Day] = make(map[Counter]int64)
+		}
+		byDay[counter.Day][counter] = n
+	}
+	return byDay
+}
+
+// oldestDay returns the oldest day within maxAge of now.
+func oldestDay(maxAge time.Duration) string {
+	return time.Now().UTC().Add(-maxAge).Format(dayFormat)
+}
+
+// daysPath returns the directory of the day files of repository. Repository
+// name components never start with an underscore, so the directory never
+// clashes with the counters of another repository.
+func daysPath(repository string) string {
+	return path.Join(storageRoot, repository, "_pulls")
+}
+
+// dayPath returns the path of the counters of repository for day.
+func dayPath(repository, day string) string {
+	return path.Join(daysPath(repository), day+".json")
+}
+
+// legacyPath returns the path of the single file of the counters of
+// repository written by former versions.
+func legacyPath(repository string) string {
+	return path.Join(storageRoot, repository, "_pulls.json")
+}
@@ -0,0 +1,229 @@
+// Package pullstats counts the downloads of manifests and blobs, per
+// repository, reference and day.
+//
+// Counting is best-effort: pulls are recorded by a Recorder, which queues
+// them without ever blocking the caller and drops them when the queue is
+// full or the store fails.
+package pullstats
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/distribution/distribution/v3/internal/dcontext"
+)
+
+// Kind is the kind of content pulled.
+type Kind string
+
+const (
+	// KindManifest counts manifest downloads, by the tag or digest
+	// requested.
+	KindManifest Kind = "manifest"
+
+	// KindBlob counts blob downloads, by digest.
+	KindBlob Kind = "blob"
+)
+
+// dayFormat formats the day buckets of counters.
+const dayFormat = "2006-01-02"
+
+const (
+	// DefaultQueueSize is the default number of pulls queued by a Recorder
+	// before they are dropped.
+	DefaultQueueSize = 1024
+
+	// maxBatch bounds the number of pulls a Recorder writes to its store at
+	// once.
+	maxBatch = 256
+
+	// storeTimeout bounds the time a Recorder waits for its store.
+	storeTimeout = 5 * time.Second
+)
+
+// Counter identifies a counter of pulls.
+type Counter struct {
+	Repository string
+	Kind       Kind
+	// Reference is the tag or digest pulled.
+	Reference string
+	// Day is the UTC day of the pulls, formatted as 2006-01-02.
+	Day string
+}
+
+// Store persists counters of pulls.
+type Store interface {
+	// Increment adds the given counts to the counters.
+	Increment(ctx context.Context, counts map[Counter]int64) error
+
+	// Counters returns the counters of repository.
+	Counters(ctx context.Context, repository string) (map[Counter]int64, error)
+}
+
+// Counts holds the pulls counted for a set of counters.
+type Counts struct {
+	Total int64 `json:"total"`
+	// Daily maps UTC days, formatted as 2006-01-02, to the pulls of the day.
+	Daily map[string]int64 `json:"daily"`
+}
+
+func (c *Counts) add(day string, n int64) {
+	if c.Daily == nil {
+		c.Daily = make(map[string]int64)
+	}
+	c.Total += n
+	c.Daily[day] += n
+}
+
+// RepositoryStats describes the pulls of a repository.
+type RepositoryStats struct {
+	Name string `json:"name"`
+
+	// Counts holds the pulls of manifests and blobs of the repository.
+	Counts
+
+	// Manifests maps the tags and digests manifests were pulled by to
+	// their pulls.
+	Manifests map[string]*Counts `json:"manifests"`
+
+	// Blobs maps the digests of blobs to their pulls.
+	Blobs map[string]*Counts `json:"blobs"`
+}
+
+// NewRepositoryStats aggregates the counters of a repository.
+func NewRepositoryStats(name string, counters map[Counter]int64) *RepositoryStats {
+	stats := &RepositoryStats{
+		Name:      name,
+		Counts:    Counts{Daily: make(map[string]int64)},
+		Manifests: make(map[string]*Counts),
+		Blobs:     make(map[string]*Counts),
+	}
+	for counter, n := range counters {
+		if counter.Repository != name {
+			continue
+		}
+		references := stats.Blobs
+		if counter.Kind == KindManifest {
+			references = stats.Manifests
+		}
+		counts, ok := references[counter.Reference]
+		if !ok {
+			counts = &Counts{}
+			references[counter.Reference] = counts
+		}
+		counts.add(counter.Day, n)
+		stats.add(counter.Day, n)
+	}
+	return stats
+}
+
+// Recorder records pulls in a store, in the background.
+type Recorder struct {
+	store Store
+
+	// mu guards the queue of pulls against sends once closed.
+	mu     sync.RWMutex
+	pulls  chan Counter
+	closed bool
+	done   chan struct{}
+
+	dropped atomic.Int64
+}
+
+// NewRecorder returns a Recorder writing to store, queuing up to queueSize
+// pulls, or DefaultQueueSize if not positive. The Recorder runs until Close
+// is called.
+func NewRecorder(ctx context.Context, store Store, queueSize int) *Recorder {
+	if queueSize <= 0 {
+		queueSize = DefaultQueueSize
+	}
+	r := &Recorder{
+		store: store,
+		pulls: make(chan Counter, queueSize),
+		done:  make(chan struct{}),
+	}
+	go r.run(context.WithoutCancel(ctx))
+	return r
+}
+
+// Record records a pull of reference from repository, at the current time.
+// It never blocks: the pull is dropped if the queue is full.
+func (r *Recorder) Record(repository string, kind Kind, reference string) {
+	counter := Counter{
+		Repository: repository,
+		Kind:       kind,
+		Reference:  reference,
+		Day:        time.Now().UTC().Format(dayFormat),
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.closed {
+		r.dropped.Add(1)
+		return
+	}
+	select {
+	case r.pulls <- counter:
+	default:
+		r.dropped.Add(1)
+	}
+}
+
+// Dropped returns the number of pulls dropped, because the queue was full or
+// the store failed.
+func (r *Recorder) Dropped() int64 {
+	return r.dropped.Load()
+}
+
+// Stats returns the stats of repository.
+func (r *Recorder) Stats(ctx context.Context, repository string) (*RepositoryStats, error) {
+	counters, err := r.store.Counters(ctx, repository)
+	if err != nil {
+		return nil, err
+	}
+	return NewRepositoryStats(repository, counters), nil
+}
+
+// Close stops the Recorder once the queued pulls are written to the store.
+// Pulls recorded after Close are dropped.
+func (r *Recorder) Close() {
+	r.mu.Lock()
+	if !r.closed {
+		r.closed = true
+		close(r.pulls)
+	}
+	r.mu.Unlock()
+	<-r.done
+}
+
+func (r *Recorder) run(ctx context.Context) {
+	defer close(r.done)
+
+	for counter := range r.pulls {
+		counts := map[Counter]int64{counter: 1}
+		n := int64(1)
+	batch:
+		for n < maxBatch {
+			select {
+			case counter, ok := <-r.pulls:
+				if !ok {
+					break batch
+				}
+				counts[counter]++
+				n++
+			default:
+				break batch
+			}
+		}
+
+		storeCtx, cancel := context.WithTimeout(ctx, storeTimeout)
+		err := r.store.Increment(storeCtx, counts)
+		cancel()
+		if err != nil {
+			r.dropped.Add(n)
+			dcontext.GetLogger(ctx).Warnf("dropping %d pull stats samples: %v", n, err)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package pullstats
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/redis/go-redis/v9"
+)
+
+func recordPulls(r *Recorder) {
+	for range 3 {
+		r.Record("foo/bar", KindManifest, "latest")
+	}
+	r.Record("foo/bar", KindManifest, "sha256:aaaa")
+	r.Record("foo/bar", KindBlob, "sha256:bbbb")
+	r.Record("foo/bar", KindBlob, "sha256:bbbb")
+	r.Record("foo/baz", KindManifest, "latest")
+}
+
+func checkStats(t *testing.T, stats *RepositoryStats) {
+	t.Helper()
+
+	day := time.Now().UTC().Format(dayFormat)
+	expected := &RepositoryStats{
+		Name:   "foo/bar",
+		Counts: Counts{Total: 6, Daily: map[string]int64{day: 6}},
+		Manifests: map[string]*Counts{
+			"latest":      {Total: 3, Daily: map[string]int64{day: 3}},
+			"sha256:aaaa": {Total: 1, Daily: map[string]int64{day: 1}},
+		},
+		Blobs: map[string]*Counts{
+			"sha256:bbbb": {Total: 2, Daily: map[string]int64{day: 2}},
+		},
+	}
+	if !reflect.DeepEqual(stats, expected) {
+		t.Fatalf("unexpected stats: %+v != %+v", stats, expected)
+	}
+}
+
+func TestDriverStore(t *testing.T) {
+	ctx := context.Background()
+	driver := inmemory.New()
+
+	store := NewDriverStore(ctx, driver, time.Hour, 0)
+	r := NewRecorder(ctx, store, 0)
+	recordPulls(r)
+	r.Close()
+
+	// pending counters are counted before being flushed
+	stats, err := r.Stats(ctx, "foo/bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkStats(t, stats)
+
+	if err := store.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	stats, err = NewRecorder(ctx, NewDriverStore(ctx, driver, time.Hour, 0), 0).Stats(ctx, "foo/bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkStats(t, stats)
+
+	// flushes add to the stored counters
+	r = NewRecorder(ctx, store, 0)
+	r.Record("foo/bar", KindManifest, "latest")
+	r.Close()
+	if err := store.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	stats, err = r.Stats(ctx, "foo/bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Total != 7 || stats.Manifests["latest"].Total != 4 {
+		t.Fatalf("expected flushed counters to be added to stored counters, got %+v", stats)
+	}
+}
+
+func TestDriverStoreMaxAge(t *testing.T) {
+	ctx := context.Background()
+	driver := inmemory.New()
+	now := time.Now().UTC()
+	today := now.Format(dayFormat)
+	recent := now.AddDate(0, 0, -2).Format(dayFormat)
+	aged := now.AddDate(0, 0, -10).Format(dayFormat)
+
+	// counters stored in a single file by former versions
+	legacy, err := json.Marshal([]legacyCounter{
+		{storedCounter: storedCounter{Kind: KindManifest, Reference: "latest", Count: 2}, Day: recent},
+		{storedCounter: storedCounter{Kind: KindManifest, Reference: "latest", Count: 5}, Day: aged},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := driver.PutContent(ctx, legacyPath("foo/bar"), legacy); err != nil {
+		t.Fatal(err)
+	}
+	aging, err := json.Marshal([]storedCounter{{Kind: KindBlob, Reference: "sha256:bbbb", Count: 3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := driver.PutContent(ctx, dayPath("foo/bar", aged), aging); err != nil {
+		t.Fatal(err)
+	}
+
+	store := NewDriverStore(ctx, driver, time.Hour, 7*24*time.Hour)
+	expected := map[Counter]int64{
+		{Repository: "foo/bar", Kind: KindManifest, Reference: "latest", Day: recent}: 2,
+	}
+	counters, err := store.Counters(ctx, "foo/bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(counters, expected) {
+		t.Fatalf("unexpected counters %v, expected %v", counters, expected)
+	}
+
+	// the first flush of a day folds the legacy file and drops the aged
+	// days, the other flushes only rewrite the file of their day
+	for range 2 {
+		if err := store.Increment(ctx, map[Counter]int64{{Repository: "foo/bar", Kind: KindManifest, Reference: "latest", Day: today}: 1}); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Flush(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected[Counter{Repository: "foo/bar", Kind: KindManifest, Reference: "latest", Day: today}] = 2
+	counters, err = store.Counters(ctx, "foo/bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(counters, expected) {
+		t.Fatalf("unexpected counters %v, expected %v", counters, expected)
+	}
+
+	files, err := driver.List(ctx, daysPath("foo/bar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+	if expected := []string{dayPath("foo/bar", recent), dayPath("foo/bar", today)}; !reflect.DeepEqual(files, expected) {
+		t.Fatalf("unexpected day files %v, expected %v", files, expected)
+	}
+	if _, err := driver.Stat(ctx, legacyPath("foo/bar")); !errors.As(err, &storagedriver.PathNotFoundError{}) {
+		t.Fatalf("expected the legacy file to be deleted, got %v", err)
+	}
+}
+
+func TestRedisStore(t *testing.T) {
+	ctx := context.Background()
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer client.Close()
+
+	r := NewRecorder(ctx, NewRedisStore(client, 0), 0)
+	recordPulls(r)
+	r.Close()
+	if r.Dropped() != 0 {
+		t.Fatalf("unexpected dropped pulls: %d", r.Dropped())
+	}
+
+	// aged counters are dropped
+	aged := time.Now().UTC().AddDate(0, 0, -100).Format(dayFormat)
+	if err := client.HSet(ctx, repositoryKey("foo/bar"), counterField(Counter{Kind: KindBlob, Reference: "sha256:bbbb", Day: aged}), 4).Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := r.Stats(ctx, "foo/bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkStats(t, stats)
+	if n, err := client.HLen(ctx, repositoryKey("foo/bar")).Result(); err != nil || n != 3 {
+		t.Fatalf("expected the aged counter to be deleted, got %d fields: %v", n, err)
+	}
+}
+
+// blockingStore blocks increments until released, then fails them.
+type blockingStore struct {
+	release chan struct{}
+}
+
+func (s *blockingStore) Increment(ctx context.Context, counts map[Counter]int64) error {
+	<-s.release
+	return errors.New("store unavailable")
+}
+
+func (s *blockingStore) Counters(ctx context.Context, repository string) (map[Counter]int64, error) {
+	return nil, errors.New("store unavailable")
+}
+
+func TestRecorderDropsPulls(t *testing.T) {
+	store := &blockingStore{release: make(chan struct{})}
+	r := NewRecorder(context.Background(), store, 2)
+
+	// Record never blocks, even though the store does and the queue fills
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range 10 {
+			r.Record("foo/bar", KindBlob, "sha256:bbbb")
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("recording pulls blocked")
+	}
+	if r.Dropped() < 7 {
+		t.Fatalf("expected pulls beyond the queue to be dropped, got %d dropped", r.Dropped())
+	}
+
+	// failed writes are dropped too
+	close(store.release)
+	r.Close()
+	if r.Dropped() != 10 {
+		t.Fatalf("expected all pulls to be dropped, got %d dropped", r.Dropped())
+	}
+	r.Record("foo/bar", KindBlob, "sha256:bbbb")
+	if r.Dropped() != 11 {
+		t.Fatalf("expected pulls recorded once closed to be dropped, got %d dropped", r.Dropped())
+	}
+}
@@ -0,0 +1,87 @@
+package pullstats
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisStore stores the counters of each repository as the fields of a
+// hash. The counters older than the maximum age are deleted as the counters
+// of their repository are read.
+type redisStore struct {
+	client redis.UniversalClient
+	maxAge time.Duration
+}
+
+// NewRedisStore returns a Store keeping counters in redis, dropping the
+// counters older than maxAge, or DefaultMaxAge if not positive.
+func NewRedisStore(client redis.UniversalClient, maxAge time.Duration) Store {
+	if maxAge <= 0 {
+		maxAge = DefaultMaxAge
+	}
+	return &redisStore{client: client, maxAge: maxAge}
+}
+
+func (s *redisStore) Increment(ctx context.Context, counts map[Counter]int64) error {
+	_, err := s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for counter, n := range counts {
+			pipe.HIncrBy(ctx, repositoryKey(counter.Repository), counterField(counter), n)
+		}
+		return nil
+	})
+	return err
+}
+
+func (s *redisStore) Counters(ctx context.Context, repository string) (map[Counter]int64, error) {
+	fields, err := s.client.HGetAll(ctx, repositoryKey(repository)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	counters := make(map[Counter]int64, len(fields))
+	oldest := oldestDay(s.maxAge)
+	var aged []string
+	for field, value := range fields {
+		counter, ok := parseCounterField(repository, field)
+		if !ok {
+			continue
+		}
+		if counter.Day < oldest {
+			aged = append(aged, field)
+			continue
+		}
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			continue
+		}
+		counters[counter] += n
+	}
+	if len(aged) > 0 {
+		if err := s.client.HDel(ctx, repositoryKey(repository), aged...).Err(); err != nil {
+			return nil, err
+		}
+	}
+	return counters, nil
+}
+
+func repositoryKey(repository string) string {
+	return "repository::" + repository + "::pulls"
+}
+
+// counterField returns the hash field of counter, which tags and digests
+// never contain the "|" separator of.
+func counterField(counter Counter) string {
+	return string(counter.Kind) + "|" + counter.Day + "|" + counter.Reference
+}
+
+func parseCounterField(repository, field string) (Counter, bool) {
+	parts := strings.SplitN(field, "|", 3)
+	if len(parts) != 3 {
+		return Counter{}, false
+	}
+	return Counter{Repository: repository, Kind: Kind(parts[0]), Day: parts[1], Reference: parts[2]}, true
+}
@@ -0,0 +1,260 @@
+package registry
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/distribution/distribution/v3/registry/storage/driver/factory"
+	"github.com/distribution/distribution/v3/registry/storage/driver/routing"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/spf13/cobra"
+)
+
+var dump referenceDump
+
+func init() {
+	RootCmd.AddCommand(DumpReferencesCmd)
+	DumpReferencesCmd.Flags().StringVarP(&dump.format, "format", "f", "text", "output format, text or json")
+	DumpReferencesCmd.Flags().BoolVarP(&dump.sizes, "sizes", "s", false, "list the sizes of the blobs")
+	DumpReferencesCmd.Flags().StringVarP(&dump.output, "output", "o", "", "file to write the list to instead of the standard output")
+	DumpReferencesCmd.Flags().BoolVarP(&dump.resume, "resume", "r", false, "resume the interrupted listing to the output file")
+}
+
+// DumpReferencesCmd is the cobra command that corresponds to the
+// dump-references subcommand
+var DumpReferencesCmd = &cobra.Command{
+	Use:   "dump-references <config>",
+	Short: "`dump-references` lists the blobs referenced by any manifest",
+	Long:  "`dump-references` lists the digests of the manifests of all repositories and of the blobs they reference, once each",
+	Run: func(cmd *cobra.Command, args []string) {
+		config, err := resolveConfiguration(args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
+			// nolint:errcheck
+			cmd.Usage()
+			os.Exit(1)
+		}
+
+		ctx := dcontext.Background()
+		ctx, err = configureLogging(ctx, config)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unable to configure logging with config: %s", err)
+			os.Exit(1)
+		}
+
+		driver, err := factory.Create(ctx, config.Storage.Type(), config.Storage.Parameters())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to construct %s driver: %v", config.Storage.Type(), err)
+			os.Exit(1)
+		}
+		if routes, ok := config.Storage["routes"]; ok {
+			driver, err = routing.FromParameters(ctx, driver, routes)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to construct routing driver: %v", err)
+				os.Exit(1)
+			}
+		}
+
+		registry, err := storage.NewRegistry(ctx, driver)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to construct registry: %v", err)
+			os.Exit(1)
+		}
+
+		if err := dump.run(ctx, registry, os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to dump references: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// referenceDump writes the references of a registry, one per line.
+type referenceDump struct {
+	format string
+	sizes  bool
+	// output is the file written, or the standard output if empty.
+	output string
+	// resume resumes the listing to output from its checkpoint.
+	resume bool
+}
+
+// referenceLine is a line of the json format.
+type referenceLine struct {
+	Digest digest.Digest `json:"digest"`
+	Size   *int64        `json:"size,omitempty"`
+}
+
+// dumpCheckpoint records the progress of a listing to a file, saved once the
+// references of each repository are written. The listing resumes after
+// Repository, from Offset in the file.
+type dumpCheckpoint struct {
+	Repository string `json:"repository"`
+	Offset     int64  `json:"offset"`
+}
+
+func (d referenceDump) checkpointPath() string {
+	return d.output + ".checkpoint"
+}
+
+func (d referenceDump) run(ctx context.Context, registry distribution.Namespace, stdout io.Writer) error {
+	if d.format != "text" && d.format != "json" {
+		return fmt.Errorf("unknown format %q", d.format)
+	}
+	if d.resume && d.output == "" {
+		return errors.New("resuming requires an output file")
+	}
+
+	opts := storage.ReferencesOpts{
+		Seen:  make(map[digest.Digest]struct{}),
+		Sizes: d.sizes,
+	}
+	var (
+		out    io.Writer = stdout
+		file   *os.File
+		offset int64
+	)
+	if d.output != "" {
+		var checkpoint dumpCheckpoint
+		if d.resume {
+			var err error
+			checkpoint, err = d.readCheckpoint()
+			if err != nil {
+				return err
+			}
+		}
+
+		var err error
+		file, err = os.OpenFile(d.output, os.O_RDWR|os.O_CREATE, 0o644)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		// the lines written after the checkpoint are written again
+		if err := file.Truncate(checkpoint.Offset); err != nil {
+			return err
+		}
+		if err := d.readSeen(io.LimitReader(file, checkpoint.Offset), opts.Seen); err != nil {
+			return fmt.Errorf("failed to read %s: %v", d.output, err)
+		}
+		if _, err := file.Seek(checkpoint.Offset, io.SeekStart); err != nil {
+			return err
+		}
+		out, offset, opts.After = file, checkpoint.Offset, checkpoint.Repository
+	}
+
+	w := bufio.NewWriter(out)
+	counter := &countingWriter{w: w}
+	enc := json.NewEncoder(counter)
+	err := storage.ListReferences(ctx, registry, opts, func(desc v1.Descriptor) error {
+		if d.format == "json" {
+			line := referenceLine{Digest: desc.Digest}
+			if d.sizes {
+				line.Size = &desc.Size
+			}
+			return enc.Encode(line)
+		}
+		if d.sizes {
+			_, err := fmt.Fprintf(counter, "%s %d\n", desc.Digest, desc.Size)
+			return err
+		}
+		_, err := fmt.Fprintln(counter, desc.Digest)
+		return err
+	}, func(repoName string) error {
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		if file == nil {
+			return nil
+		}
+		return d.writeCheckpoint(dumpCheckpoint{Repository: repoName, Offset: offset + counter.n})
+	})
+	if err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if file == nil {
+		return nil
+	}
+	if err := os.Remove(d.checkpointPath()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return file.Close()
+}
+
+// readCheckpoint reads the checkpoint of the output file, or returns an empty
+// checkpoint if the listing was never checkpointed.
+func (d referenceDump) readCheckpoint() (dumpCheckpoint, error) {
+	var checkpoint dumpCheckpoint
+	p, err := os.ReadFile(d.checkpointPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return checkpoint, nil
+		}
+		return checkpoint, err
+	}
+	if err := json.Unmarshal(p, &checkpoint); err != nil {
+		return checkpoint, fmt.Errorf("invalid checkpoint %s: %v", d.checkpointPath(), err)
+	}
+	return checkpoint, nil
+}
+
+// writeCheckpoint replaces the checkpoint of the output file.
+func (d referenceDump) writeCheckpoint(checkpoint dumpCheckpoint) error {
+	p, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+	tmp := d.checkpointPath() + ".tmp"
+	if err := os.WriteFile(tmp, p, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, d.checkpointPath())
+}
+
+// readSeen adds the digests listed by r to seen.
+func (d referenceDump) readSeen(r io.Reader, seen map[digest.Digest]struct{}) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		var dgst digest.Digest
+		if d.format == "json" {
+			var line referenceLine
+			if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+				return err
+			}
+			dgst = line.Digest
+		} else {
+			field, _, _ := strings.Cut(scanner.Text(), " ")
+			dgst = digest.Digest(field)
+		}
+		if err := dgst.Validate(); err != nil {
+			return err
+		}
+		seen[dgst] = struct{}{}
+	}
+	return scanner.Err()
+}
+
+// countingWriter counts the bytes written to w.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,157 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// pushReferencesFixture pushes an image to each repository, sharing its
+// config, and returns the lines listing the references of the registry.
+func pushReferencesFixture(t *testing.T, registry distribution.Namespace, repositories ...string) []string {
+	t.Helper()
+	ctx := context.Background()
+	var lines []string
+	listed := make(map[digest.Digest]bool)
+	list := func(dgst digest.Digest, size int64) {
+		if !listed[dgst] {
+			listed[dgst] = true
+			lines = append(lines, fmt.Sprintf("%s %d", dgst, size))
+		}
+	}
+
+	for _, name := range repositories {
+		named, _ := reference.WithName(name)
+		repo, err := registry.Repository(ctx, named)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var blobs []v1.Descriptor
+		for _, content := range []string{"{}", "layer of " + name} {
+			wr, err := repo.Blobs(ctx).Create(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := wr.ReadFrom(strings.NewReader(content)); err != nil {
+				t.Fatal(err)
+			}
+			desc, err := wr.Commit(ctx, v1.Descriptor{Digest: digest.FromString(content)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			blobs = append(blobs, desc)
+		}
+		manifest, err := ocischema.FromStruct(ocischema.Manifest{
+			Versioned: specs.Versioned{SchemaVersion: 2},
+			MediaType: v1.MediaTypeImageManifest,
+			Config:    v1.Descriptor{MediaType: v1.MediaTypeImageConfig, Digest: blobs[0].Digest, Size: blobs[0].Size},
+			Layers:    []v1.Descriptor{{MediaType: v1.MediaTypeImageLayer, Digest: blobs[1].Digest, Size: blobs[1].Size}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ms, err := repo.Manifests(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dgst, err := ms.Put(ctx, manifest, distribution.WithTag("latest"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, payload, _ := manifest.Payload()
+		list(dgst, int64(len(payload)))
+		for _, blob := range blobs {
+			list(blob.Digest, blob.Size)
+		}
+	}
+	return lines
+}
+
+func TestDumpReferences(t *testing.T) {
+	ctx := context.Background()
+	registry, err := storage.NewRegistry(ctx, inmemory.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := pushReferencesFixture(t, registry, "bar", "foo/a", "foo/b")
+
+	for _, tc := range []struct {
+		name string
+		dump referenceDump
+		line func(dgst digest.Digest, size string) string
+	}{
+		{name: "text", dump: referenceDump{format: "text"}, line: func(dgst digest.Digest, size string) string { return dgst.String() }},
+		{name: "text sizes", dump: referenceDump{format: "text", sizes: true}, line: func(dgst digest.Digest, size string) string { return dgst.String() + " " + size }},
+		{name: "json", dump: referenceDump{format: "json"}, line: func(dgst digest.Digest, size string) string { return `{"digest":"` + dgst.String() + `"}` }},
+		{name: "json sizes", dump: referenceDump{format: "json", sizes: true}, line: func(dgst digest.Digest, size string) string {
+			return `{"digest":"` + dgst.String() + `","size":` + size + `}`
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var want []string
+			for _, line := range expected {
+				dgst, size, _ := strings.Cut(line, " ")
+				want = append(want, tc.line(digest.Digest(dgst), size))
+			}
+
+			var buf bytes.Buffer
+			if err := tc.dump.run(ctx, registry, &buf); err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n"); !slices.Equal(got, want) {
+				t.Fatalf("unexpected listing:\n%s\nexpected:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+			}
+
+			// a listing interrupted within the second repository resumes
+			// after the first one, without listing digests twice
+			output := filepath.Join(t.TempDir(), "references")
+			dump := tc.dump
+			dump.output, dump.resume = output, true
+			interrupted := strings.Join(want[:4], "\n") + "\n" + want[4][:10]
+			if err := os.WriteFile(output, []byte(interrupted), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			checkpoint, _ := json.Marshal(dumpCheckpoint{Repository: "bar", Offset: int64(len(strings.Join(want[:3], "\n")) + 1)})
+			if err := os.WriteFile(dump.checkpointPath(), checkpoint, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if err := dump.run(ctx, registry, nil); err != nil {
+				t.Fatal(err)
+			}
+			resumed, err := os.ReadFile(output)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(resumed) != buf.String() {
+				t.Fatalf("unexpected resumed listing:\n%s\nexpected:\n%s", resumed, buf.String())
+			}
+			if _, err := os.Stat(dump.checkpointPath()); !os.IsNotExist(err) {
+				t.Fatalf("expected the checkpoint to be removed, got %v", err)
+			}
+		})
+	}
+
+	for _, dump := range []referenceDump{
+		{format: "yaml"},
+		{format: "text", resume: true},
+	} {
+		if err := dump.run(ctx, registry, &bytes.Buffer{}); err == nil {
+			t.Errorf("expected an error for %+v", dump)
+		}
+	}
+}
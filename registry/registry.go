@@ -6,6 +6,7 @@ import (
 	"crypto/x509"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -14,17 +15,18 @@ import (
 	"time"
 
 	logstash "github.com/bshuster-repo/logrus-logstash-hook"
-	"github.com/docker/go-metrics"
 	gorhandlers "github.com/gorilla/handlers"
+	"github.com/quic-go/quic-go/http3"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
-	"golang.org/x/crypto/acme"
 	"golang.org/x/crypto/acme/autocert"
 
 	"github.com/distribution/distribution/v3/configuration"
 	"github.com/distribution/distribution/v3/health"
 	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/metrics"
+	"github.com/distribution/distribution/v3/registry/autotls"
 	"github.com/distribution/distribution/v3/registry/handlers"
 	"github.com/distribution/distribution/v3/registry/listener"
 	"github.com/distribution/distribution/v3/tracing"
@@ -134,6 +136,7 @@ type Registry struct {
 	config *configuration.Configuration
 	app    *handlers.App
 	server *http.Server
+	http3  *http3.Server
 	quit   chan os.Signal
 }
 
@@ -165,8 +168,21 @@ func NewRegistry(ctx context.Context, config *configuration.Configuration) (*Reg
 	if err != nil {
 		return nil, fmt.Errorf("error during open telemetry initialization: %v", err)
 	}
+	if config.HTTP.Debug.Prometheus.Exemplars {
+		if tracing.Enabled() {
+			metrics.EnableExemplars()
+		} else {
+			dcontext.GetLogger(app).Warn("prometheus exemplars require tracing, exemplars disabled")
+		}
+	}
 	handler = otelHandler(handler)
 
+	var h3 *http3.Server
+	if config.HTTP.HTTP3.Enabled {
+		h3 = &http3.Server{Handler: handler}
+		handler = altSvcHandler(h3, handler)
+	}
+
 	server := &http.Server{
 		Handler:   handler,
 		Protocols: serverProtocols(config),
@@ -176,10 +192,21 @@ func NewRegistry(ctx context.Context, config *configuration.Configuration) (*Reg
 		app:    app,
 		config: config,
 		server: server,
+		http3:  h3,
 		quit:   make(chan os.Signal, 1),
 	}, nil
 }
 
+// altSvcHandler advertises the HTTP/3 server to the clients of the TCP
+// listener through the Alt-Svc header, so that they can upgrade.
+func altSvcHandler(h3 *http3.Server, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the header is only set once the HTTP/3 server listens
+		_ = h3.SetQUICHeaders(w.Header())
+		next.ServeHTTP(w, r)
+	})
+}
+
 // otelHandler returns an http.Handler that wraps the provided `next` handler with OpenTelemetry instrumentation.
 // This instrumentation tracks each HTTP request, creating spans with names derived from the request method and URL path.
 func otelHandler(next http.Handler) http.Handler {
@@ -216,12 +243,45 @@ func getCipherSuiteNames(ids []uint16) []string {
 	return names
 }
 
-// set ACME-server/DirectoryURL, if provided
-func setDirectoryURL(directoryurl string) *acme.Client {
-	if len(directoryurl) > 0 {
-		return &acme.Client{DirectoryURL: directoryurl}
+// newACMEManager returns the manager of the certificate obtained through
+// ACME, as configured by config.
+func newACMEManager(app *handlers.App, config configuration.LetsEncrypt) (*autotls.Manager, error) {
+	var cache autocert.Cache
+	switch config.Cache {
+	case "", "file":
+		if config.CacheFile == "" {
+			return nil, fmt.Errorf("http.tls.letsencrypt.cachefile is required to cache certificates in files")
+		}
+		cache = autocert.DirCache(config.CacheFile)
+	case "redis":
+		if app.Redis() == nil {
+			return nil, fmt.Errorf("redis configuration required to cache certificates in redis")
+		}
+		cache = autotls.NewRedisCache(app.Redis())
+	default:
+		return nil, fmt.Errorf("unknown certificate cache '%s' specified for http.tls.letsencrypt.cache", config.Cache)
 	}
-	return nil
+
+	opts := autotls.Options{
+		Hosts: config.Hosts,
+		Cache: cache,
+		Issuer: &autotls.ACMEIssuer{
+			DirectoryURL: config.DirectoryURL,
+			Email:        config.Email,
+			Cache:        cache,
+		},
+		Challenge:           config.Challenge,
+		DNSPropagationDelay: config.DNS.PropagationDelay,
+		RenewBefore:         config.RenewBefore,
+	}
+	if config.Challenge == autotls.ChallengeDNS01 {
+		provider, err := autotls.NewDNSProvider(config.DNS.Provider, config.DNS.Parameters)
+		if err != nil {
+			return nil, err
+		}
+		opts.DNSProvider = provider
+	}
+	return autotls.NewManager(opts)
 }
 
 // ListenAndServe runs the registry's HTTP server.
@@ -233,7 +293,7 @@ func (registry *Registry) ListenAndServe() error {
 		return err
 	}
 
-	if config.HTTP.TLS.Certificate != "" || config.HTTP.TLS.LetsEncrypt.CacheFile != "" {
+	if config.HTTP.TLS.Certificate != "" || config.HTTP.TLS.LetsEncrypt.Enabled() {
 		if config.HTTP.TLS.MinimumTLS == "" {
 			config.HTTP.TLS.MinimumTLS = defaultTLSVersionStr
 		}
@@ -263,19 +323,35 @@ func (registry *Registry) ListenAndServe() error {
 			CipherSuites: tlsCipherSuites,
 		}
 
-		if config.HTTP.TLS.LetsEncrypt.CacheFile != "" {
+		if config.HTTP.TLS.LetsEncrypt.Enabled() {
 			if config.HTTP.TLS.Certificate != "" {
 				return fmt.Errorf("cannot specify both certificate and Let's Encrypt")
 			}
-			m := &autocert.Manager{
-				HostPolicy: autocert.HostWhitelist(config.HTTP.TLS.LetsEncrypt.Hosts...),
-				Cache:      autocert.DirCache(config.HTTP.TLS.LetsEncrypt.CacheFile),
-				Email:      config.HTTP.TLS.LetsEncrypt.Email,
-				Prompt:     autocert.AcceptTOS,
-				Client:     setDirectoryURL(config.HTTP.TLS.LetsEncrypt.DirectoryURL),
+			m, err := newACMEManager(registry.app, config.HTTP.TLS.LetsEncrypt)
+			if err != nil {
+				return err
 			}
 			tlsConf.GetCertificate = m.GetCertificate
-			tlsConf.NextProtos = append(tlsConf.NextProtos, acme.ALPNProto)
+			tlsConf.NextProtos = append(tlsConf.NextProtos, m.NextProtos()...)
+
+			if config.HTTP.TLS.LetsEncrypt.Challenge == autotls.ChallengeHTTP01 {
+				addr := config.HTTP.TLS.LetsEncrypt.HTTPAddr
+				if addr == "" {
+					addr = ":80"
+				}
+				challengeListener, err := net.Listen("tcp", addr)
+				if err != nil {
+					return err
+				}
+				dcontext.GetLogger(registry.app).Infof("serving http-01 challenges on %v", challengeListener.Addr())
+				go func() {
+					server := &http.Server{Handler: m.HTTPHandler(nil), ReadHeaderTimeout: time.Minute}
+					if err := server.Serve(challengeListener); err != nil {
+						dcontext.GetLogger(registry.app).Errorf("error serving http-01 challenges: %v", err)
+					}
+				}()
+			}
+			go m.Run(registry.app)
 		} else {
 			tlsConf.Certificates = make([]tls.Certificate, 1)
 			tlsConf.Certificates[0], err = tls.LoadX509KeyPair(config.HTTP.TLS.Certificate, config.HTTP.TLS.Key)
@@ -317,9 +393,18 @@ func (registry *Registry) ListenAndServe() error {
 			tlsConf.ClientCAs = pool
 		}
 
+		if registry.http3 != nil {
+			if err := registry.listenHTTP3(tlsConf); err != nil {
+				return err
+			}
+		}
+
 		ln = tls.NewListener(ln, tlsConf)
 		dcontext.GetLogger(registry.app).Infof("listening on %v, tls", ln.Addr())
 	} else {
+		if registry.http3 != nil {
+			return fmt.Errorf("http.http3 requires TLS to be configured")
+		}
 		dcontext.GetLogger(registry.app).Infof("listening on %v", ln.Addr())
 	}
 
@@ -348,9 +433,36 @@ func (registry *Registry) ListenAndServe() error {
 	}
 }
 
+// listenHTTP3 serves HTTP/3 on the UDP address of the http3 configuration,
+// with the TLS configuration of the TCP listener.
+func (registry *Registry) listenHTTP3(tlsConf *tls.Config) error {
+	addr := registry.config.HTTP.HTTP3.Addr
+	if addr == "" {
+		addr = registry.config.HTTP.Addr
+	}
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	registry.http3.TLSConfig = http3.ConfigureTLSConfig(tlsConf)
+	dcontext.GetLogger(registry.app).Infof("listening on %v, http3", conn.LocalAddr())
+	go func() {
+		defer conn.Close()
+		if err := registry.http3.Serve(conn); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			dcontext.GetLogger(registry.app).Errorf("error serving http3: %v", err)
+		}
+	}()
+	return nil
+}
+
 // Shutdown gracefully shuts down the registry's HTTP server and application object.
 func (registry *Registry) Shutdown(ctx context.Context) error {
+	// stop being ready before the connections drain
+	health.Drain()
 	err := registry.server.Shutdown(ctx)
+	if registry.http3 != nil {
+		err = errors.Join(err, registry.http3.Shutdown(ctx))
+	}
 	if appErr := registry.app.Shutdown(); appErr != nil {
 		err = errors.Join(err, appErr)
 	}
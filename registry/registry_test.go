@@ -27,6 +27,7 @@ import (
 	"github.com/distribution/distribution/v3/configuration"
 	"github.com/distribution/distribution/v3/internal/dcontext"
 	_ "github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/quic-go/quic-go/http3"
 	"github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v2"
 )
@@ -434,6 +435,83 @@ func TestRegistryUnsupportedCipherSuite(t *testing.T) {
 	time.Sleep(100 * time.Millisecond)
 }
 
+func TestRegistryHTTP3(t *testing.T) {
+	serverTLS, err := buildRegistryTLSConfig("registry_test_server_http3", "ecdsa", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &configuration.Configuration{}
+	config.HTTP.Addr = "127.0.0.1:5003"
+	config.HTTP.DrainTimeout = 10 * time.Second
+	config.HTTP.TLS.Certificate = serverTLS.certificatePath
+	config.HTTP.TLS.Key = serverTLS.privateKeyPath
+	config.HTTP.HTTP3.Enabled = true
+	config.Storage = map[string]configuration.Parameters{"inmemory": map[string]any{}}
+	registry, err := NewRegistry(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// run registry server
+	errchan := make(chan error, 1)
+	go func() {
+		errchan <- registry.ListenAndServe()
+	}()
+	defer func() {
+		registry.quit <- os.Interrupt
+		if err := <-errchan; err != nil {
+			t.Errorf("unexpected error shutting down: %v", err)
+		}
+	}()
+
+	// Wait for some unknown random time for server to start listening
+	time.Sleep(3 * time.Second)
+
+	clientTLS := &tls.Config{InsecureSkipVerify: true}
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientTLS}}
+	resp, err := client.Get("https://127.0.0.1:5003/v2/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if altSvc := resp.Header.Get("Alt-Svc"); !strings.Contains(altSvc, `h3=":5003"`) {
+		t.Errorf("unexpected Alt-Svc header: %q", altSvc)
+	}
+
+	transport := &http3.Transport{TLSClientConfig: clientTLS}
+	defer transport.Close()
+	client = &http.Client{Transport: transport}
+	resp, err = client.Get("https://127.0.0.1:5003/v2/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.ProtoMajor != 3 {
+		t.Errorf("unexpected protocol: %s", resp.Proto)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("unexpected status: %s", resp.Status)
+	}
+	if body, err := io.ReadAll(resp.Body); err != nil || string(body) != "{}" {
+		t.Error("Body is not {}; ", string(body))
+	}
+}
+
+func TestRegistryHTTP3RequiresTLS(t *testing.T) {
+	config := &configuration.Configuration{}
+	config.HTTP.Addr = "127.0.0.1:5004"
+	config.HTTP.HTTP3.Enabled = true
+	config.Storage = map[string]configuration.Parameters{"inmemory": map[string]any{}}
+	registry, err := NewRegistry(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := registry.ListenAndServe(); err == nil {
+		t.Fatal("expected an error serving http3 without TLS")
+	}
+}
+
 func TestConfigureLogging(t *testing.T) {
 	yamlConfig := `---
 log:
@@ -0,0 +1,501 @@
+// Package replication copies every manifest pushed, with the manifests and
+// blobs it references, to downstream registries, so that they hold it
+// before it is pulled from them.
+//
+// Replication is asynchronous: pushes are queued by a Replicator, which
+// never blocks the caller, and replicated in the background by a pool of
+// workers, retrying failed replications a bounded number of times. Pushes
+// of a reference already queued for a target are replicated once.
+package replication
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/internal/client"
+	"github.com/distribution/distribution/v3/internal/client/auth"
+	"github.com/distribution/distribution/v3/internal/client/auth/challenge"
+	"github.com/distribution/distribution/v3/internal/client/transport"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	prometheus "github.com/distribution/distribution/v3/metrics"
+	"github.com/distribution/reference"
+	"github.com/docker/go-metrics"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	// DefaultWorkers is the default number of replications a Replicator
+	// runs concurrently.
+	DefaultWorkers = 4
+
+	// DefaultQueueSize is the default number of replications queued by a
+	// Replicator before pushes are dropped.
+	DefaultQueueSize = 1024
+
+	// DefaultMaxRetries is the default number of times a failed
+	// replication is retried.
+	DefaultMaxRetries = 5
+
+	// defaultRetryInterval is the delay before the first retry of a failed
+	// replication, doubled on each further retry up to maxRetryInterval.
+	defaultRetryInterval = time.Second
+
+	maxRetryInterval = time.Minute
+)
+
+var (
+	// lagTimer measures the time between the push of a manifest and its
+	// replication.
+	lagTimer = prometheus.ReplicationNamespace.NewLabeledTimer("lag", "The time between the push of a manifest and its replication", "target")
+	// failuresCounter counts the failed replications, by target and by
+	// whether they are retried or dropped.
+	failuresCounter = prometheus.ReplicationNamespace.NewLabeledCounter("failures", "The number of failed replications", "target", "outcome")
+	// deduplicatedCounter counts the pushes replicated along with a push
+	// of the same reference.
+	deduplicatedCounter = prometheus.ReplicationNamespace.NewLabeledCounter("deduplicated", "The number of pushes replicated along with another push", "target")
+	// pendingGauge measures the number of replications queued.
+	pendingGauge = prometheus.ReplicationNamespace.NewGauge("pending", "The number of replications waiting to run", metrics.Total)
+)
+
+func init() {
+	metrics.Register(prometheus.ReplicationNamespace)
+}
+
+// Target is a downstream registry manifests are replicated to.
+type Target struct {
+	// Name identifies the target in logs and metrics.
+	Name string
+	// URL is the base URL of the registry.
+	URL string
+	// Username and Password authenticate the pushes to the registry, if
+	// set.
+	Username string
+	Password string
+}
+
+// target is a Target with the state of its authentication.
+type target struct {
+	Target
+	credentials auth.CredentialStore
+
+	// mu guards the challenges of the registry against concurrent pings.
+	mu         sync.Mutex
+	challenges challenge.Manager
+	pinged     bool
+}
+
+// credentials returns the same username and password to any realm.
+type credentials struct {
+	username string
+	password string
+}
+
+func (c credentials) Basic(*url.URL) (string, string) {
+	return c.username, c.password
+}
+
+func (c credentials) RefreshToken(*url.URL, string) string {
+	return ""
+}
+
+func (c credentials) SetRefreshToken(*url.URL, string, string) {
+}
+
+// job is the replication of a reference of a repository to a target. The
+// reference is a tag, resolved when the job runs, or a digest.
+type job struct {
+	target     *target
+	repository string
+	reference  string
+}
+
+// pendingJob tracks a job from the time it is queued until it has run.
+type pendingJob struct {
+	queued  time.Time
+	running bool
+	// again is set if the reference was pushed again while the job was
+	// running, and the job must run again once done.
+	again time.Time
+}
+
+// Replicator replicates the manifests pushed to downstream registries, in
+// the background.
+type Replicator struct {
+	source        distribution.Namespace
+	targets       []*target
+	maxRetries    int
+	retryInterval time.Duration
+
+	// mu guards the pending jobs and the queue against sends once closed.
+	mu      sync.Mutex
+	pending map[job]*pendingJob
+	jobs    chan job
+	closed  bool
+	cancel  context.CancelFunc
+	workers sync.WaitGroup
+
+	dropped atomic.Int64
+}
+
+// NewReplicator returns a Replicator replicating the manifests of source to
+// targets, with workers replications running concurrently, or
+// DefaultWorkers if not positive. It queues up to queueSize replications,
+// or DefaultQueueSize if not positive, and retries failed replications up
+// to maxRetries times, or DefaultMaxRetries if not positive. The
+// Replicator runs until Close is called.
+func NewReplicator(ctx context.Context, source distribution.Namespace, targets []Target, workers, queueSize, maxRetries int) (*Replicator, error) {
+	if len(targets) == 0 {
+		return nil, errors.New("no replication target")
+	}
+	names := make(map[string]struct{}, len(targets))
+	r := &Replicator{
+		source:        source,
+		maxRetries:    maxRetries,
+		retryInterval: defaultRetryInterval,
+		pending:       make(map[job]*pendingJob),
+	}
+	for _, t := range targets {
+		if t.Name == "" {
+			return nil, errors.New("replication target name must be set")
+		}
+		if _, ok := names[t.Name]; ok {
+			return nil, fmt.Errorf("duplicate replication target %q", t.Name)
+		}
+		names[t.Name] = struct{}{}
+		u, err := url.Parse(t.URL)
+		if err != nil {
+			return nil, fmt.Errorf("replication target %q: %v", t.Name, err)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+			return nil, fmt.Errorf("replication target %q: url must be an absolute http or https url", t.Name)
+		}
+		t.URL = strings.TrimSuffix(t.URL, "/")
+		r.targets = append(r.targets, &target{
+			Target:      t,
+			credentials: credentials{username: t.Username, password: t.Password},
+			challenges:  challenge.NewSimpleManager(),
+		})
+	}
+	if workers <= 0 {
+		workers = DefaultWorkers
+	}
+	if queueSize <= 0 {
+		queueSize = DefaultQueueSize
+	}
+	if r.maxRetries <= 0 {
+		r.maxRetries = DefaultMaxRetries
+	}
+	r.jobs = make(chan job, queueSize)
+
+	ctx, r.cancel = context.WithCancel(context.WithoutCancel(ctx))
+	r.workers.Add(workers)
+	for range workers {
+		go r.run(ctx)
+	}
+	return r, nil
+}
+
+// Replicate queues the replication of the manifest dgst pushed to
+// repository, and tagged tag if not empty, to every target. It never
+// blocks: the replication to a target is dropped if the queue is full.
+// A tag is replicated at the manifest it references when the replication
+// runs, so that a tag pushed repeatedly is replicated once per run.
+func (r *Replicator) Replicate(repository string, dgst digest.Digest, tag string) {
+	ref := dgst.String()
+	if tag != "" {
+		ref = tag
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, t := range r.targets {
+		if r.closed {
+			r.drop(t)
+			continue
+		}
+		j := job{target: t, repository: repository, reference: ref}
+		if p, ok := r.pending[j]; ok {
+			if p.running && p.again.IsZero() {
+				p.again = time.Now()
+			}
+			deduplicatedCounter.WithValues(t.Name).Inc(1)
+			continue
+		}
+		select {
+		case r.jobs <- j:
+			r.pending[j] = &pendingJob{queued: time.Now()}
+			pendingGauge.Inc(1)
+		default:
+			r.drop(t)
+		}
+	}
+}
+
+// Dropped returns the number of replications not run, because the queue was
+// full or the target kept failing.
+func (r *Replicator) Dropped() int64 {
+	return r.dropped.Load()
+}
+
+// Close stops the Replicator. The replications running are canceled and the
+// replications queued are dropped, as are the pushes replicated after
+// Close.
+func (r *Replicator) Close() {
+	r.mu.Lock()
+	if !r.closed {
+		r.closed = true
+		r.cancel()
+		close(r.jobs)
+	}
+	r.mu.Unlock()
+	r.workers.Wait()
+}
+
+func (r *Replicator) drop(t *target) {
+	r.dropped.Add(1)
+	failuresCounter.WithValues(t.Name, "dropped").Inc(1)
+}
+
+func (r *Replicator) run(ctx context.Context) {
+	defer r.workers.Done()
+
+	for j := range r.jobs {
+		pendingGauge.Dec(1)
+
+		r.mu.Lock()
+		p := r.pending[j]
+		p.running = true
+		since := p.queued
+		r.mu.Unlock()
+
+		for {
+			if ctx.Err() != nil {
+				r.drop(j.target)
+			} else if r.replicate(ctx, j) {
+				lagTimer.WithValues(j.target.Name).UpdateSince(since)
+			}
+
+			r.mu.Lock()
+			since = p.again
+			if since.IsZero() || r.closed {
+				delete(r.pending, j)
+				r.mu.Unlock()
+				break
+			}
+			p.again = time.Time{}
+			r.mu.Unlock()
+		}
+	}
+}
+
+// replicate runs j, retrying with an exponential backoff, and returns
+// whether it succeeded.
+func (r *Replicator) replicate(ctx context.Context, j job) bool {
+	log := dcontext.GetLoggerWithFields(ctx, map[any]any{
+		"target":     j.target.Name,
+		"repository": j.repository,
+		"reference":  j.reference,
+	})
+
+	delay := r.retryInterval
+	for attempt := 0; ; attempt++ {
+		err := r.copy(ctx, j)
+		if err == nil {
+			return true
+		}
+		if errors.As(err, &distribution.ErrTagUnknown{}) {
+			log.Debugf("skipping replication of tag deleted since pushed")
+			return false
+		}
+		if attempt >= r.maxRetries {
+			log.Errorf("dropping replication of manifest after %d attempts: %v", attempt+1, err)
+			r.drop(j.target)
+			return false
+		}
+		failuresCounter.WithValues(j.target.Name, "retried").Inc(1)
+		log.Warnf("replicating manifest failed, retrying in %s: %v", delay, err)
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			log.Errorf("dropping replication of manifest on shutdown: %v", err)
+			r.drop(j.target)
+			return false
+		}
+		delay = min(2*delay, maxRetryInterval)
+	}
+}
+
+// copy replicates the manifest referenced by j to its target.
+func (r *Replicator) copy(ctx context.Context, j job) error {
+	name, err := reference.WithName(j.repository)
+	if err != nil {
+		return err
+	}
+	source, err := r.source.Repository(ctx, name)
+	if err != nil {
+		return err
+	}
+	destination, err := j.target.repository(ctx, name)
+	if err != nil {
+		return err
+	}
+	c, err := newCopier(ctx, source, destination)
+	if err != nil {
+		return err
+	}
+
+	dgst, err := digest.Parse(j.reference)
+	if err == nil {
+		return c.copyManifest(ctx, dgst)
+	}
+	desc, err := source.Tags(ctx).Get(ctx, j.reference)
+	if err != nil {
+		return err
+	}
+	return c.copyManifest(ctx, desc.Digest, distribution.WithTag(j.reference))
+}
+
+// repository returns the repository name of the target, authenticated to
+// pull and push.
+func (t *target) repository(ctx context.Context, name reference.Named) (distribution.Repository, error) {
+	if err := t.ping(ctx); err != nil {
+		return nil, err
+	}
+	tr := transport.NewTransport(http.DefaultTransport,
+		auth.NewAuthorizer(t.challenges,
+			auth.NewTokenHandlerWithOptions(auth.TokenHandlerOptions{
+				Transport:   http.DefaultTransport,
+				Credentials: t.credentials,
+				Scopes: []auth.Scope{
+					auth.RepositoryScope{
+						Repository: name.Name(),
+						Actions:    []string{"pull", "push"},
+					},
+				},
+				Logger: dcontext.GetLogger(ctx),
+			}),
+			auth.NewBasicHandler(t.credentials)))
+	return client.NewRepository(name, t.URL, tr)
+}
+
+// ping establishes the authentication challenges of the target, once.
+func (t *target) ping(ctx context.Context) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.pinged {
+		return nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, t.URL+"/v2/", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := t.challenges.AddResponse(resp); err != nil {
+		return err
+	}
+	t.pinged = true
+	return nil
+}
+
+// copier copies manifests and blobs between the repositories of the same
+// name of two registries.
+type copier struct {
+	sourceManifests      distribution.ManifestService
+	sourceBlobs          distribution.BlobStore
+	destinationManifests distribution.ManifestService
+	destinationBlobs     distribution.BlobStore
+}
+
+func newCopier(ctx context.Context, source, destination distribution.Repository) (*copier, error) {
+	sourceManifests, err := source.Manifests(ctx)
+	if err != nil {
+		return nil, err
+	}
+	destinationManifests, err := destination.Manifests(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &copier{
+		sourceManifests:      sourceManifests,
+		sourceBlobs:          source.Blobs(ctx),
+		destinationManifests: destinationManifests,
+		destinationBlobs:     destination.Blobs(ctx),
+	}, nil
+}
+
+// copyManifest copies the manifest dgst, after the manifests and blobs it
+// references, unless the destination already holds it. The manifest is put
+// with options, and put again to tag it if the destination holds it.
+func (c *copier) copyManifest(ctx context.Context, dgst digest.Digest, options ...distribution.ManifestServiceOption) error {
+	exists, err := c.destinationManifests.Exists(ctx, dgst)
+	if err != nil {
+		return err
+	}
+	if exists && len(options) == 0 {
+		return nil
+	}
+
+	manifest, err := c.sourceManifests.Get(ctx, dgst)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		for _, desc := range manifest.References() {
+			if slices.Contains(distribution.ManifestMediaTypes(), desc.MediaType) {
+				err = c.copyManifest(ctx, desc.Digest)
+			} else {
+				err = c.copyBlob(ctx, desc)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	_, err = c.destinationManifests.Put(ctx, manifest, options...)
+	return err
+}
+
+// copyBlob copies the blob desc, unless the destination already holds it.
+// Foreign layers, pulled from their urls, are not copied.
+func (c *copier) copyBlob(ctx context.Context, desc v1.Descriptor) error {
+	if len(desc.URLs) != 0 {
+		return nil
+	}
+	if _, err := c.destinationBlobs.Stat(ctx, desc.Digest); !errors.Is(err, distribution.ErrBlobUnknown) {
+		return err
+	}
+
+	reader, err := c.sourceBlobs.Open(ctx, desc.Digest)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	writer, err := c.destinationBlobs.Create(ctx)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(writer, reader); err != nil {
+		_ = writer.Cancel(ctx)
+		return err
+	}
+	_, err = writer.Commit(ctx, v1.Descriptor{MediaType: desc.MediaType, Digest: desc.Digest, Size: desc.Size})
+	return err
+}
@@ -0,0 +1,357 @@
+package replication
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// mockRegistry is a downstream registry keeping the blobs and manifests
+// pushed in memory. It requires basic authentication if username is set,
+// and fails the requests while failures is positive, or always if
+// negative.
+type mockRegistry struct {
+	username string
+	password string
+
+	// pinged is signaled by the first ping, which waits for release
+	// before responding, if set.
+	pinged  chan struct{}
+	release chan struct{}
+
+	mu           sync.Mutex
+	failures     int
+	blobs        map[digest.Digest][]byte
+	uploads      map[string][]byte
+	manifests    map[string][]byte
+	blobPuts     int
+	manifestPuts map[string]int
+}
+
+func newMockRegistry() *mockRegistry {
+	return &mockRegistry{
+		blobs:        make(map[digest.Digest][]byte),
+		uploads:      make(map[string][]byte),
+		manifests:    make(map[string][]byte),
+		manifestPuts: make(map[string]int),
+	}
+}
+
+func (m *mockRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if m.username != "" {
+		if username, password, ok := r.BasicAuth(); !ok || username != m.username || password != m.password {
+			w.Header().Set("WWW-Authenticate", `Basic realm="mock"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+	}
+	if r.URL.Path == "/v2/" {
+		if m.pinged != nil {
+			close(m.pinged)
+			m.pinged = nil
+			<-m.release
+		}
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.failures != 0 {
+		m.failures--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/v2/")
+	if name, id, ok := strings.Cut(path, "/blobs/uploads/"); ok {
+		switch r.Method {
+		case http.MethodPost:
+			id = strconv.Itoa(len(m.uploads))
+			m.uploads[id] = nil
+		case http.MethodPatch:
+			data, _ := io.ReadAll(r.Body)
+			m.uploads[id] = append(m.uploads[id], data...)
+			w.Header().Set("Range", "0-"+strconv.Itoa(len(m.uploads[id])-1))
+		case http.MethodPut:
+			dgst := digest.Digest(r.URL.Query().Get("digest"))
+			if digest.FromBytes(m.uploads[id]) != dgst {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			m.blobs[dgst] = m.uploads[id]
+			m.blobPuts++
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		w.Header().Set("Location", "/v2/"+name+"/blobs/uploads/"+id)
+		w.Header().Set("Docker-Upload-UUID", id)
+		w.WriteHeader(http.StatusAccepted)
+	} else if _, dgst, ok := strings.Cut(path, "/blobs/"); ok {
+		data, ok := m.blobs[digest.Digest(dgst)]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Header().Set("Docker-Content-Digest", dgst)
+	} else if name, ref, ok := strings.Cut(path, "/manifests/"); ok {
+		switch r.Method {
+		case http.MethodHead:
+			if _, ok := m.manifests[name+":"+ref]; !ok {
+				w.WriteHeader(http.StatusNotFound)
+			}
+		case http.MethodPut:
+			data, _ := io.ReadAll(r.Body)
+			dgst := digest.FromBytes(data)
+			m.manifests[name+":"+dgst.String()] = data
+			m.manifests[name+":"+ref] = data
+			m.manifestPuts[ref]++
+			w.Header().Set("Docker-Content-Digest", dgst.String())
+			w.WriteHeader(http.StatusCreated)
+		}
+	} else {
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// manifest returns the manifest ref of the repository name, if pushed.
+func (m *mockRegistry) manifest(name, ref string) []byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.manifests[name+":"+ref]
+}
+
+// blob returns the blob dgst, if pushed.
+func (m *mockRegistry) blob(dgst digest.Digest) []byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.blobs[dgst]
+}
+
+// pushImage pushes an image of a single layer to the repository name of
+// source, tagged tag, and returns its descriptor.
+func pushImage(t *testing.T, source distribution.Namespace, name, tag, layer string) v1.Descriptor {
+	t.Helper()
+	ctx := context.Background()
+	named, _ := reference.WithName(name)
+	repo, err := source.Repository(ctx, named)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := repo.Blobs(ctx).Put(ctx, v1.MediaTypeImageConfig, []byte("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	layerDesc, err := repo.Blobs(ctx).Put(ctx, v1.MediaTypeImageLayer, []byte(layer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	image, err := ocischema.FromStruct(ocischema.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: v1.MediaTypeImageManifest,
+		Config:    config,
+		Layers:    []v1.Descriptor{layerDesc},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ms, err := repo.Manifests(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dgst, err := ms.Put(ctx, image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, payload, _ := image.Payload()
+	desc := v1.Descriptor{MediaType: v1.MediaTypeImageManifest, Digest: dgst, Size: int64(len(payload))}
+	if err := repo.Tags(ctx).Tag(ctx, tag, desc); err != nil {
+		t.Fatal(err)
+	}
+	return desc
+}
+
+// waitFor waits for condition to hold.
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(10 * time.Second); !condition(); {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for replication")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func newTestReplicator(t *testing.T, targets ...*httptest.Server) (*Replicator, distribution.Namespace) {
+	t.Helper()
+	ctx := context.Background()
+	source, err := storage.NewRegistry(ctx, inmemory.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var replicationTargets []Target
+	for i, target := range targets {
+		replicationTargets = append(replicationTargets, Target{Name: "spoke" + strconv.Itoa(i), URL: target.URL, Username: "hub", Password: "secret"})
+	}
+	r, err := NewReplicator(ctx, source, replicationTargets, 1, 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.retryInterval = time.Millisecond
+	t.Cleanup(r.Close)
+	return r, source
+}
+
+func TestReplicate(t *testing.T) {
+	spokes := []*mockRegistry{newMockRegistry(), newMockRegistry()}
+	var servers []*httptest.Server
+	for _, spoke := range spokes {
+		spoke.username, spoke.password = "hub", "secret"
+		server := httptest.NewServer(spoke)
+		defer server.Close()
+		servers = append(servers, server)
+	}
+	// the first requests of the second spoke fail, and are retried
+	spokes[1].failures = 2
+	r, source := newTestReplicator(t, servers...)
+
+	amd64 := pushImage(t, source, "foo/bar", "latest", "layer")
+	dgst := amd64.Digest
+	r.Replicate("foo/bar", dgst, "latest")
+	for _, spoke := range spokes {
+		waitFor(t, func() bool { return spoke.manifest("foo/bar", "latest") != nil })
+		if manifest := spoke.manifest("foo/bar", dgst.String()); digest.FromBytes(manifest) != dgst {
+			t.Fatalf("unexpected manifest replicated: %s", manifest)
+		}
+		if !bytes.Equal(spoke.blob(digest.FromString("layer")), []byte("layer")) {
+			t.Fatal("expected the layer of the manifest to be replicated")
+		}
+	}
+
+	// an index is replicated after the manifests it references, pushed by
+	// digest
+	arm64 := pushImage(t, source, "foo/bar", "arm64", "arm64 layer")
+	amd64.Platform = &v1.Platform{OS: "linux", Architecture: "amd64"}
+	arm64.Platform = &v1.Platform{OS: "linux", Architecture: "arm64"}
+	index, err := ocischema.FromDescriptors([]v1.Descriptor{amd64, arm64}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	named, _ := reference.WithName("foo/bar")
+	repo, err := source.Repository(context.Background(), named)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ms, err := repo.Manifests(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexDigest, err := ms.Put(context.Background(), index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Replicate("foo/bar", indexDigest, "")
+	for _, spoke := range spokes {
+		waitFor(t, func() bool { return spoke.manifest("foo/bar", indexDigest.String()) != nil })
+		if spoke.manifest("foo/bar", arm64.Digest.String()) == nil {
+			t.Fatal("expected the manifests of the index to be replicated")
+		}
+		if spoke.manifest("foo/bar", "arm64") != nil {
+			t.Fatal("unexpected replication of a tag not pushed")
+		}
+	}
+	if dropped := r.Dropped(); dropped != 0 {
+		t.Fatalf("unexpected %d replications dropped", dropped)
+	}
+}
+
+func TestReplicateDeduplicates(t *testing.T) {
+	spoke := newMockRegistry()
+	spoke.pinged = make(chan struct{})
+	spoke.release = make(chan struct{})
+	server := httptest.NewServer(spoke)
+	defer server.Close()
+	r, source := newTestReplicator(t, server)
+
+	dgst := pushImage(t, source, "foo/bar", "latest", "layer").Digest
+	pinged := spoke.pinged
+	r.Replicate("foo/bar", dgst, "latest")
+	// the pushes made while the tag is replicated are replicated once
+	// more, after it
+	<-pinged
+	for range 3 {
+		r.Replicate("foo/bar", dgst, "latest")
+	}
+	close(spoke.release)
+	waitFor(t, func() bool {
+		spoke.mu.Lock()
+		defer spoke.mu.Unlock()
+		return spoke.manifestPuts["latest"] == 2
+	})
+	waitFor(t, func() bool {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		return len(r.pending) == 0
+	})
+
+	spoke.mu.Lock()
+	defer spoke.mu.Unlock()
+	if puts := spoke.manifestPuts["latest"]; puts != 2 {
+		t.Fatalf("unexpected %d replications of the tag", puts)
+	}
+	if spoke.blobPuts != 2 {
+		t.Fatalf("unexpected %d blobs replicated, expected the config and the layer once", spoke.blobPuts)
+	}
+}
+
+func TestReplicateDropsFailures(t *testing.T) {
+	spoke := newMockRegistry()
+	spoke.failures = -1
+	server := httptest.NewServer(spoke)
+	defer server.Close()
+	r, source := newTestReplicator(t, server)
+
+	dgst := pushImage(t, source, "foo/bar", "latest", "layer").Digest
+	r.Replicate("foo/bar", dgst, "latest")
+	waitFor(t, func() bool { return r.Dropped() == 1 })
+
+	r.Close()
+	r.Replicate("foo/bar", dgst, "latest")
+	if dropped := r.Dropped(); dropped != 2 {
+		t.Fatalf("expected the replication after close to be dropped, got %d dropped", dropped)
+	}
+}
+
+func TestNewReplicator(t *testing.T) {
+	source, err := storage.NewRegistry(context.Background(), inmemory.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, targets := range [][]Target{
+		nil,
+		{{URL: "https://spoke.example.com"}},
+		{{Name: "spoke", URL: "spoke.example.com"}},
+		{{Name: "spoke", URL: "ftp://spoke.example.com"}},
+		{{Name: "spoke", URL: "https://spoke.example.com"}, {Name: "spoke", URL: "https://other.example.com"}},
+	} {
+		if _, err := NewReplicator(context.Background(), source, targets, 0, 0, 0); err == nil {
+			t.Fatalf("expected error for targets %+v", targets)
+		}
+	}
+}
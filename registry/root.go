@@ -1,12 +1,16 @@
 package registry
 
 import (
+	"context"
 	"fmt"
 	"os"
 
 	"github.com/distribution/distribution/v3/internal/dcontext"
 	"github.com/distribution/distribution/v3/registry/storage"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
 	"github.com/distribution/distribution/v3/registry/storage/driver/factory"
+	mirror "github.com/distribution/distribution/v3/registry/storage/driver/middleware/mirror"
+	"github.com/distribution/distribution/v3/registry/storage/driver/routing"
 	"github.com/distribution/distribution/v3/version"
 	"github.com/spf13/cobra"
 )
@@ -16,9 +20,25 @@ var showVersion bool
 func init() {
 	RootCmd.AddCommand(ServeCmd)
 	RootCmd.AddCommand(GCCmd)
+	RootCmd.AddCommand(PackCmd)
+	RootCmd.AddCommand(MirrorCmd)
+	MirrorCmd.AddCommand(BackfillCmd)
+	RootCmd.AddCommand(MigrateCmd)
+	MigrateCmd.AddCommand(MigrateSignaturesCmd)
+	MigrateCmd.AddCommand(MigrateSchema1Cmd)
+	RootCmd.AddCommand(BuildReferrersIndexCmd)
 	GCCmd.Flags().BoolVarP(&dryRun, "dry-run", "d", false, "do everything except remove the blobs")
 	GCCmd.Flags().BoolVarP(&removeUntagged, "delete-untagged", "m", false, "delete manifests that are not currently referenced via tag")
 	GCCmd.Flags().BoolVarP(&quiet, "quiet", "q", false, "silence output")
+	GCCmd.Flags().IntVarP(&gcConcurrency, "concurrency", "c", 1, "number of repositories marked in parallel")
+	GCCmd.Flags().IntVar(&maxMarksInMemory, "max-marks-in-memory", 0, "number of marked digests held in memory before writing them to disk, unlimited if 0")
+	GCCmd.Flags().StringVar(&spillDir, "spill-dir", "", "directory the marked digests are written to, the default directory for temporary files if empty")
+	PackCmd.Flags().BoolVarP(&unpack, "unpack", "u", false, "write the packed blobs back to loose files and remove the pack files")
+	MigrateCmd.PersistentFlags().BoolVarP(&migrateDryRun, "dry-run", "d", false, "report the changes without making them")
+	MigrateCmd.PersistentFlags().IntVarP(&migrateConcurrency, "concurrency", "c", 1, "number of repositories migrated in parallel")
+	MigrateSchema1Cmd.Flags().BoolVar(&purgeOriginals, "purge-originals", false, "remove the converted revisions which are no longer tagged instead of converting")
+	BuildReferrersIndexCmd.Flags().Float64Var(&indexRate, "rate", 0, "maximum number of manifests read per second, unlimited if 0")
+	BackfillCmd.Flags().IntVarP(&backfillConcurrency, "concurrency", "c", 8, "number of objects copied in parallel")
 	RootCmd.Flags().BoolVarP(&showVersion, "version", "v", false, "show the version and exit")
 }
 
@@ -38,9 +58,12 @@ var RootCmd = &cobra.Command{
 }
 
 var (
-	dryRun         bool
-	removeUntagged bool
-	quiet          bool
+	dryRun           bool
+	removeUntagged   bool
+	quiet            bool
+	gcConcurrency    int
+	maxMarksInMemory int
+	spillDir         string
 )
 
 // GCCmd is the cobra command that corresponds to the garbage-collect subcommand
@@ -69,6 +92,14 @@ var GCCmd = &cobra.Command{
 			fmt.Fprintf(os.Stderr, "failed to construct %s driver: %v", config.Storage.Type(), err)
 			os.Exit(1)
 		}
+		if routes, ok := config.Storage["routes"]; ok {
+			// collect the garbage of every backend
+			driver, err = routing.FromParameters(ctx, driver, routes)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to construct routing driver: %v", err)
+				os.Exit(1)
+			}
+		}
 
 		registry, err := storage.NewRegistry(ctx, driver)
 		if err != nil {
@@ -77,9 +108,12 @@ var GCCmd = &cobra.Command{
 		}
 
 		err = storage.MarkAndSweep(ctx, driver, registry, storage.GCOpts{
-			DryRun:         dryRun,
-			RemoveUntagged: removeUntagged,
-			Quiet:          quiet,
+			DryRun:           dryRun,
+			RemoveUntagged:   removeUntagged,
+			Quiet:            quiet,
+			Concurrency:      gcConcurrency,
+			MaxMarksInMemory: maxMarksInMemory,
+			SpillDir:         spillDir,
 		})
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "failed to garbage collect: %v", err)
@@ -87,3 +121,261 @@ var GCCmd = &cobra.Command{
 		}
 	},
 }
+
+var unpack bool
+
+// PackCmd is the cobra command that corresponds to the pack subcommand
+var PackCmd = &cobra.Command{
+	Use:   "pack <config>",
+	Short: "`pack` packs small blobs into pack files",
+	Long:  "`pack` packs the data of small blobs into pack files, for the storage drivers supporting it",
+	Run: func(cmd *cobra.Command, args []string) {
+		config, err := resolveConfiguration(args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
+			// nolint:errcheck
+			cmd.Usage()
+			os.Exit(1)
+		}
+
+		ctx := dcontext.Background()
+		ctx, err = configureLogging(ctx, config)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unable to configure logging with config: %s", err)
+			os.Exit(1)
+		}
+
+		driver, err := factory.Create(ctx, config.Storage.Type(), config.Storage.Parameters())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to construct %s driver: %v", config.Storage.Type(), err)
+			os.Exit(1)
+		}
+
+		if unpack {
+			n, err := storage.UnpackBlobs(ctx, driver)
+			fmt.Printf("%d blobs unpacked\n", n)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to unpack: %v", err)
+				os.Exit(1)
+			}
+			return
+		}
+		n, err := storage.PackBlobs(ctx, driver)
+		fmt.Printf("%d blobs packed\n", n)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to pack: %v", err)
+			os.Exit(1)
+		}
+	},
+}
+
+var backfillConcurrency int
+
+// MirrorCmd is the cobra command that groups the subcommands of the mirror
+// storage middleware
+var MirrorCmd = &cobra.Command{
+	Use:   "mirror",
+	Short: "`mirror` manages the migration of the mirror storage middleware",
+	Long:  "`mirror` manages the migration of the mirror storage middleware",
+	Run: func(cmd *cobra.Command, args []string) {
+		// nolint:errcheck
+		cmd.Usage()
+	},
+}
+
+// BackfillCmd is the cobra command that corresponds to the mirror backfill subcommand
+var BackfillCmd = &cobra.Command{
+	Use:   "backfill <config>",
+	Short: "`backfill` copies objects missing from the storage driver from the secondary driver of the mirror middleware",
+	Long:  "`backfill` copies objects missing from the storage driver from the secondary driver of the mirror middleware",
+	Run: func(cmd *cobra.Command, args []string) {
+		config, err := resolveConfiguration(args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
+			// nolint:errcheck
+			cmd.Usage()
+			os.Exit(1)
+		}
+
+		ctx := dcontext.Background()
+		ctx, err = configureLogging(ctx, config)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unable to configure logging with config: %s", err)
+			os.Exit(1)
+		}
+
+		var options map[string]any
+		for _, mw := range config.Middleware["storage"] {
+			if mw.Name == "mirror" && !mw.Disabled {
+				options = mw.Options
+			}
+		}
+		if options == nil {
+			fmt.Fprintln(os.Stderr, "the mirror storage middleware is not configured")
+			os.Exit(1)
+		}
+
+		driver, err := factory.Create(ctx, config.Storage.Type(), config.Storage.Parameters())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to construct %s driver: %v", config.Storage.Type(), err)
+			os.Exit(1)
+		}
+
+		secondary, err := mirror.NewSecondary(ctx, options)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to construct secondary driver: %v", err)
+			os.Exit(1)
+		}
+
+		result, err := mirror.Backfill(ctx, secondary, driver, backfillConcurrency)
+		fmt.Printf("%d objects copied, %d skipped, %d failed\n", result.Copied, result.Skipped, result.Failed)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to backfill: %v", err)
+			os.Exit(1)
+		}
+		if result.Failed > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+var (
+	migrateDryRun      bool
+	migrateConcurrency int
+	purgeOriginals     bool
+)
+
+// MigrateCmd is the cobra command that groups the subcommands migrating the
+// legacy metadata of the storage
+var MigrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "`migrate` migrates the legacy metadata of the storage",
+	Long:  "`migrate` migrates the legacy metadata of the storage. The registry must not serve writes while migrating.",
+	Run: func(cmd *cobra.Command, args []string) {
+		// nolint:errcheck
+		cmd.Usage()
+	},
+}
+
+// MigrateSignaturesCmd is the cobra command that corresponds to the migrate
+// signatures subcommand
+var MigrateSignaturesCmd = &cobra.Command{
+	Use:   "signatures <config>",
+	Short: "`signatures` removes the legacy signatures of the manifest revisions",
+	Long:  "`signatures` removes the signatures directories of the manifest revisions, which held the signatures of schema1 manifests",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, driver := migrateDriver(cmd, args)
+		result, err := storage.MigrateSignatures(ctx, driver, storage.MigrateOpts{
+			DryRun:      migrateDryRun,
+			Concurrency: migrateConcurrency,
+			Progress:    migrateProgress,
+		})
+		for _, dir := range result.Removed {
+			fmt.Println(dir)
+		}
+		fmt.Printf("%d repositories migrated, %d signatures directories removed\n", result.Repositories, len(result.Removed))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to migrate signatures: %v", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// MigrateSchema1Cmd is the cobra command that corresponds to the migrate
+// schema1 subcommand
+var MigrateSchema1Cmd = &cobra.Command{
+	Use:   "schema1 <config>",
+	Short: "`schema1` converts the schema1 manifests to schema2",
+	Long: "`schema1` converts the schema1 manifests to schema2 and moves their tags to the converted manifests. " +
+		"The manifests which cannot be converted are tagged schema1-review-<digest prefix> for manual review. " +
+		"The original manifests are kept until a run with --purge-originals.",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, driver := migrateDriver(cmd, args)
+		result, err := storage.MigrateSchema1(ctx, driver, storage.MigrateOpts{
+			DryRun:         migrateDryRun,
+			Concurrency:    migrateConcurrency,
+			PurgeOriginals: purgeOriginals,
+			Progress:       migrateProgress,
+		})
+		var converted, review, purged int
+		for _, revision := range result.Revisions {
+			switch {
+			case revision.Purged:
+				purged++
+				fmt.Printf("%s@%s: purged\n", revision.Repository, revision.Digest)
+			case purgeOriginals:
+			case revision.Converted != "":
+				converted++
+				fmt.Printf("%s@%s: converted to %s\n", revision.Repository, revision.Digest, revision.Converted)
+			default:
+				review++
+				fmt.Printf("%s@%s: tagged %s for review: %s\n", revision.Repository, revision.Digest, revision.ReviewTag, revision.Reason)
+			}
+		}
+		if purgeOriginals {
+			fmt.Printf("%d repositories migrated, %d schema1 manifests purged\n", result.Repositories, purged)
+		} else {
+			fmt.Printf("%d repositories migrated, %d schema1 manifests converted, %d to review\n", result.Repositories, converted, review)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to migrate schema1 manifests: %v", err)
+			os.Exit(1)
+		}
+	},
+}
+
+var indexRate float64
+
+// BuildReferrersIndexCmd is the cobra command that corresponds to the
+// build-referrers-index subcommand
+var BuildReferrersIndexCmd = &cobra.Command{
+	Use:   "build-referrers-index <config>",
+	Short: "`build-referrers-index` indexes the referrers and indexes of the manifests pushed before they were indexed",
+	Long: "`build-referrers-index` records the manifests pushed before the registry maintained the referrers index " +
+		"and the index of the manifests of indexes in both indexes. Repositories are marked once indexed, so an interrupted " +
+		"run resumes with the repositories left. It may run while the registry serves pushes, once every instance is upgraded.",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, driver := migrateDriver(cmd, args)
+		result, err := storage.BackfillIndexes(ctx, driver, storage.BackfillIndexesOpts{
+			Rate: indexRate,
+			Progress: func(repository string, done int) {
+				fmt.Fprintf(os.Stderr, "%d repositories indexed, last %s\n", done, repository)
+			},
+		})
+		fmt.Printf("%d repositories indexed, %d already indexed, %d manifests read\n", result.Repositories, result.Skipped, result.Manifests)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to build the referrers index: %v", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// migrateDriver returns the storage driver of the configuration in args for
+// the migrate and build-referrers-index subcommands.
+func migrateDriver(cmd *cobra.Command, args []string) (context.Context, storagedriver.StorageDriver) {
+	config, err := resolveConfiguration(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
+		// nolint:errcheck
+		cmd.Usage()
+		os.Exit(1)
+	}
+
+	ctx := dcontext.Background()
+	ctx, err = configureLogging(ctx, config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to configure logging with config: %s", err)
+		os.Exit(1)
+	}
+
+	driver, err := factory.Create(ctx, config.Storage.Type(), config.Storage.Parameters())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to construct %s driver: %v", config.Storage.Type(), err)
+		os.Exit(1)
+	}
+	return ctx, driver
+}
+
+func migrateProgress(repository string, done int) {
+	fmt.Fprintf(os.Stderr, "%d repositories migrated, last %s\n", done, repository)
+}
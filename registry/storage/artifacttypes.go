@@ -0,0 +1,224 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"path"
+	"slices"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/manifest/schema2"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// ImageConfigMediaTypes are the media types of container image configs.
+var ImageConfigMediaTypes = []string{
+	v1.MediaTypeImageConfig,
+	schema2.MediaTypeImageConfig,
+}
+
+// ArtifactConfigMediaTypes are the config media types of well-known
+// artifacts stored in image manifests.
+var ArtifactConfigMediaTypes = []string{
+	// empty config of artifacts described by their artifactType
+	v1.MediaTypeEmptyJSON,
+	// helm charts
+	"application/vnd.cncf.helm.config.v1+json",
+	// webassembly modules
+	"application/vnd.wasm.config.v0+json",
+	"application/vnd.wasm.config.v1+json",
+	// in-toto attestations
+	"application/vnd.in-toto+json",
+	// sigstore bundles
+	"application/vnd.dev.sigstore.bundle.v0.3+json",
+	"application/vnd.dev.sigstore.bundle+json;version=0.3",
+}
+
+// ValidateConfigMediaTypes is a functional option for NewRegistry. It rejects
+// image manifests whose config media type is not one of mediaTypes.
+func ValidateConfigMediaTypes(mediaTypes []string) RegistryOption {
+	return func(registry *registry) error {
+		registry.configMediaTypes = make(map[string]struct{}, len(mediaTypes))
+		for _, mediaType := range mediaTypes {
+			registry.configMediaTypes[mediaType] = struct{}{}
+		}
+		return nil
+	}
+}
+
+// verifyConfigMediaType returns an error if config media types are validated
+// and mediaType is not one of the allowed ones.
+func verifyConfigMediaType(allowed map[string]struct{}, mediaType string) error {
+	if allowed == nil {
+		return nil
+	}
+	if _, ok := allowed[mediaType]; !ok {
+		return distribution.ErrManifestConfigMediaTypeInvalid{MediaType: mediaType}
+	}
+	return nil
+}
+
+// artifactType returns the artifact type of an image manifest, which is its
+// artifactType field if set, else its config media type.
+func artifactType(manifest distribution.Manifest) string {
+	switch m := manifest.(type) {
+	case *ocischema.DeserializedManifest:
+		if m.ArtifactType != "" {
+			return m.ArtifactType
+		}
+		return m.Config.MediaType
+	case *schema2.DeserializedManifest:
+		return m.Config.MediaType
+	}
+	return ""
+}
+
+// errArtifactTypeFound stops the walk of the manifests of an artifact type
+// once a manifest is found.
+var errArtifactTypeFound = errors.New("artifact type found")
+
+// EnableArtifactTypes is a functional option for NewRegistry. It maintains
+// the index of the artifact types of the manifests of each repository.
+func EnableArtifactTypes(registry *registry) error {
+	registry.artifactTypesEnabled = true
+	return nil
+}
+
+// setArtifactType records manifest dgst in the index of the artifact types of
+// the repository. Failures are logged rather than failing the put, as the
+// index is only used for reporting.
+func (ms *manifestStore) setArtifactType(ctx context.Context, dgst digest.Digest, manifest distribution.Manifest) {
+	mediaType := artifactType(manifest)
+	if mediaType == "" {
+		return
+	}
+
+	name := ms.repository.Named().Name()
+	artifactTypePath, err := pathFor(manifestArtifactTypePathSpec{name: name, artifactType: mediaType})
+	if err != nil {
+		dcontext.GetLogger(ctx).Errorf("error recording artifact type: %v", err)
+		return
+	}
+	linkPath, err := pathFor(manifestArtifactTypeLinkPathSpec{name: name, artifactType: mediaType, revision: dgst})
+	if err != nil {
+		dcontext.GetLogger(ctx).Errorf("error recording artifact type: %v", err)
+		return
+	}
+
+	driver := ms.repository.driver
+	if _, err := driver.Stat(ctx, artifactTypePath); err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+			dcontext.GetLogger(ctx).Errorf("error recording artifact type: %v", err)
+			return
+		}
+		if err := driver.PutContent(ctx, artifactTypePath, []byte(mediaType)); err != nil {
+			dcontext.GetLogger(ctx).Errorf("error recording artifact type: %v", err)
+			return
+		}
+	}
+	if err := driver.PutContent(ctx, linkPath, []byte(dgst)); err != nil {
+		dcontext.GetLogger(ctx).Errorf("error recording artifact type: %v", err)
+	}
+}
+
+// unsetArtifactType removes manifest dgst, of the artifact type mediaType,
+// from the index of the artifact types of the repository. Failures are
+// logged: the listing ignores the manifests which no longer exist.
+func (ms *manifestStore) unsetArtifactType(ctx context.Context, dgst digest.Digest, mediaType string) {
+	linkPath, err := pathFor(manifestArtifactTypeLinkPathSpec{name: ms.repository.Named().Name(), artifactType: mediaType, revision: dgst})
+	if err != nil {
+		dcontext.GetLogger(ctx).Errorf("error removing artifact type: %v", err)
+		return
+	}
+	if err := ms.repository.driver.Delete(ctx, path.Dir(linkPath)); err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+			dcontext.GetLogger(ctx).Errorf("error removing artifact type: %v", err)
+		}
+	}
+}
+
+// ArtifactTypes returns the artifact types of the manifests of the
+// repository. The manifests deleted are removed from the index, and those
+// removed by the garbage collector are ignored. Manifests pushed while the
+// index was not maintained are not reported.
+func (repo *repository) ArtifactTypes(ctx context.Context) ([]string, error) {
+	if !repo.registry.artifactTypesEnabled {
+		return nil, distribution.ErrUnsupported
+	}
+
+	root, err := pathFor(manifestArtifactTypesPathSpec{name: repo.name.Name()})
+	if err != nil {
+		return nil, err
+	}
+	manifests, err := repo.Manifests(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var mediaTypes []string
+	err = repo.driver.Walk(ctx, root, func(fileInfo storagedriver.FileInfo) error {
+		if !fileInfo.IsDir() || path.Base(fileInfo.Path()) != "manifests" {
+			return nil
+		}
+		found, err := repo.hasArtifactTypeManifest(ctx, fileInfo.Path(), manifests)
+		if err != nil {
+			return err
+		}
+		if found {
+			content, err := repo.driver.GetContent(ctx, path.Join(path.Dir(fileInfo.Path()), "mediatype"))
+			if err != nil {
+				return err
+			}
+			mediaTypes = append(mediaTypes, string(content))
+		}
+		return storagedriver.ErrSkipDir
+	})
+	if err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); ok {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	slices.Sort(mediaTypes)
+	return mediaTypes, nil
+}
+
+// hasArtifactTypeManifest reports whether one of the links under root, the
+// manifests of an artifact type, links a manifest of the repository.
+func (repo *repository) hasArtifactTypeManifest(ctx context.Context, root string, manifests distribution.ManifestService) (bool, error) {
+	err := repo.driver.Walk(ctx, root, func(fileInfo storagedriver.FileInfo) error {
+		if fileInfo.IsDir() || path.Base(fileInfo.Path()) != "link" {
+			return nil
+		}
+		content, err := repo.driver.GetContent(ctx, fileInfo.Path())
+		if err != nil {
+			return err
+		}
+		dgst, err := digest.Parse(string(content))
+		if err != nil {
+			return nil
+		}
+		exists, err := manifests.Exists(ctx, dgst)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return errArtifactTypeFound
+		}
+		return nil
+	})
+	switch {
+	case errors.Is(err, errArtifactTypeFound):
+		return true, nil
+	case err == nil:
+		return false, nil
+	case errors.As(err, new(storagedriver.PathNotFoundError)):
+		return false, nil
+	}
+	return false, err
+}
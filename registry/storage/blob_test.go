@@ -4,14 +4,20 @@ import (
 	"bytes"
 	"context"
 	"crypto/sha256"
+	"errors"
 	"fmt"
 	"io"
+	"net/http"
+	"net/http/httptest"
 	"path"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/distribution/distribution/v3"
 	"github.com/distribution/distribution/v3/registry/storage/cache/memory"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
 	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
 	"github.com/distribution/distribution/v3/testutil"
 	"github.com/distribution/reference"
@@ -610,3 +616,308 @@ func addBlob(ctx context.Context, bs distribution.BlobIngester, desc v1.Descript
 
 	return wr.Commit(ctx, desc)
 }
+
+// deleteRecorder records the paths deleted through a storage driver.
+type deleteRecorder struct {
+	storagedriver.StorageDriver
+	deleted []string
+}
+
+func (d *deleteRecorder) Delete(ctx context.Context, path string) error {
+	d.deleted = append(d.deleted, path)
+	return d.StorageDriver.Delete(ctx, path)
+}
+
+// TestAbandonedBlobUpload validates that resuming an upload clears its
+// abandoned mark only if it has one, and that committing it removes the mark.
+func TestAbandonedBlobUpload(t *testing.T) {
+	ctx := context.Background()
+	imageName, _ := reference.WithName("foo/bar")
+	driver := &deleteRecorder{StorageDriver: inmemory.New()}
+	registry, err := NewRegistry(ctx, driver)
+	if err != nil {
+		t.Fatalf("error creating registry: %v", err)
+	}
+	repository, err := registry.Repository(ctx, imageName)
+	if err != nil {
+		t.Fatalf("unexpected error getting repo: %v", err)
+	}
+	bs := repository.Blobs(ctx)
+
+	content := []byte("abandoned")
+	upload, err := bs.Create(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error starting upload: %v", err)
+	}
+	if _, err := upload.Write(content[:4]); err != nil {
+		t.Fatalf("unexpected error writing: %v", err)
+	}
+	if err := upload.Close(); err != nil {
+		t.Fatalf("unexpected error closing upload: %v", err)
+	}
+	abandonedAtPath, err := pathFor(uploadAbandonedAtPathSpec{name: imageName.Name(), id: upload.ID()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// unmarked uploads are resumed without deletions
+	upload, err = bs.Resume(ctx, upload.ID())
+	if err != nil {
+		t.Fatalf("unexpected error resuming upload: %v", err)
+	}
+	if err := upload.Close(); err != nil {
+		t.Fatalf("unexpected error closing upload: %v", err)
+	}
+	if len(driver.deleted) != 0 {
+		t.Fatalf("unexpected deletions resuming an unmarked upload: %v", driver.deleted)
+	}
+
+	if err := upload.(distribution.BlobWriteAbandoner).Abandon(ctx); err != nil {
+		t.Fatalf("unexpected error abandoning upload: %v", err)
+	}
+	upload, err = bs.Resume(ctx, upload.ID())
+	if err != nil {
+		t.Fatalf("unexpected error resuming upload: %v", err)
+	}
+	if _, err := driver.Stat(ctx, abandonedAtPath); !errors.As(err, new(storagedriver.PathNotFoundError)) {
+		t.Fatalf("abandoned mark not cleared on resume: %v", err)
+	}
+
+	// marks set again by a disconnect are removed with the upload
+	if err := upload.(distribution.BlobWriteAbandoner).Abandon(ctx); err != nil {
+		t.Fatalf("unexpected error abandoning upload: %v", err)
+	}
+	if _, err := upload.Write(content[4:]); err != nil {
+		t.Fatalf("unexpected error writing: %v", err)
+	}
+	if _, err := upload.Commit(ctx, v1.Descriptor{Digest: digest.FromBytes(content)}); err != nil {
+		t.Fatalf("unexpected error committing upload: %v", err)
+	}
+	if _, err := driver.Stat(ctx, abandonedAtPath); !errors.As(err, new(storagedriver.PathNotFoundError)) {
+		t.Fatalf("abandoned mark not removed on commit: %v", err)
+	}
+}
+
+// TestPreverifiedBlobUpload covers uploads whose digest is vouched for by the
+// client, which are verified in the background after commit.
+func TestPreverifiedBlobUpload(t *testing.T) {
+	ctx := context.Background()
+	imageName, _ := reference.WithName("foo/bar")
+	driver := inmemory.New()
+
+	mismatched := make(chan v1.Descriptor, 1)
+	registry, err := NewRegistry(ctx, driver,
+		BlobDescriptorCacheProvider(memory.NewInMemoryBlobDescriptorCacheProvider(memory.UnlimitedSize)),
+		OnPreverifiedDigestMismatch(func(ctx context.Context, repo reference.Named, desc v1.Descriptor) {
+			if repo.Name() != imageName.Name() {
+				t.Errorf("unexpected repository for mismatched blob: %s", repo)
+			}
+			mismatched <- desc
+		}))
+	if err != nil {
+		t.Fatalf("error creating registry: %v", err)
+	}
+	repository, err := registry.Repository(ctx, imageName)
+	if err != nil {
+		t.Fatalf("unexpected error getting repo: %v", err)
+	}
+	bs := repository.Blobs(ctx)
+	preverifiedCtx := WithPreverifiedDigest(ctx)
+
+	upload := func(contents []byte, dgst digest.Digest) v1.Descriptor {
+		t.Helper()
+		blobUpload, err := bs.Create(preverifiedCtx)
+		if err != nil {
+			t.Fatalf("unexpected error starting upload: %v", err)
+		}
+		if _, err := blobUpload.Write(contents); err != nil {
+			t.Fatalf("unexpected error writing contents: %v", err)
+		}
+		desc, err := blobUpload.Commit(preverifiedCtx, v1.Descriptor{Digest: dgst})
+		if err != nil {
+			t.Fatalf("unexpected error committing pre-verified upload: %v", err)
+		}
+		if desc.Digest != dgst {
+			t.Fatalf("unexpected digest: %v != %v", desc.Digest, dgst)
+		}
+		return desc
+	}
+
+	exists := func(spec pathSpec) bool {
+		t.Helper()
+		p, err := pathFor(spec)
+		if err != nil {
+			t.Fatalf("unexpected error building path: %v", err)
+		}
+		_, err = driver.Stat(ctx, p)
+		return err == nil
+	}
+
+	// content is linked on commit, so that manifests may reference it right
+	// away, and kept once verified
+	contents := []byte("pre-verified contents")
+	desc := upload(contents, digest.FromBytes(contents))
+	if p, err := bs.Get(ctx, desc.Digest); err != nil || !bytes.Equal(p, contents) {
+		t.Fatalf("unexpected blob contents: %q, %v", p, err)
+	}
+
+	// content not matching its digest is linked on commit too, then removed
+	// from the repository and the blob store once verified
+	dgst := digest.FromString("other contents")
+	upload([]byte("tampered contents"), dgst)
+	select {
+	case desc := <-mismatched:
+		if desc.Digest != dgst {
+			t.Fatalf("unexpected mismatched digest: %v != %v", desc.Digest, dgst)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for background verification")
+	}
+	if _, err := bs.Stat(ctx, dgst); err != distribution.ErrBlobUnknown {
+		t.Fatalf("expected mismatched blob to be unknown, got %v", err)
+	}
+	if exists(blobDataPathSpec{digest: dgst}) {
+		t.Fatal("expected mismatched blob to be deleted from the blob store")
+	}
+	select {
+	case desc := <-mismatched:
+		t.Fatalf("unexpected digest mismatch for %v", desc.Digest)
+	default:
+	}
+	if p, err := bs.Get(ctx, desc.Digest); err != nil || !bytes.Equal(p, contents) {
+		t.Fatalf("unexpected verified blob contents: %q, %v", p, err)
+	}
+
+	// content already in the blob store, as pushed to another repository, is
+	// linked right away and left untouched
+	otherName, _ := reference.WithName("foo/other")
+	other, err := registry.Repository(ctx, otherName)
+	if err != nil {
+		t.Fatalf("unexpected error getting repo: %v", err)
+	}
+	shared := []byte("shared contents")
+	sharedDesc, err := other.Blobs(ctx).Put(ctx, "", shared)
+	if err != nil {
+		t.Fatalf("unexpected error putting blob: %v", err)
+	}
+	if desc := upload([]byte("tampered shared contents"), sharedDesc.Digest); desc.Size != sharedDesc.Size {
+		t.Fatalf("unexpected size of shared blob: %d != %d", desc.Size, sharedDesc.Size)
+	}
+	for _, blobs := range []distribution.BlobStore{bs, other.Blobs(ctx)} {
+		if p, err := blobs.Get(ctx, sharedDesc.Digest); err != nil || !bytes.Equal(p, shared) {
+			t.Fatalf("unexpected shared blob contents: %q, %v", p, err)
+		}
+	}
+}
+
+// redirectingDriver redirects to a fixed storage host.
+type redirectingDriver struct {
+	storagedriver.StorageDriver
+}
+
+func (d redirectingDriver) RedirectURL(r *http.Request, path string) (string, error) {
+	return "https://storage.example.com" + path, nil
+}
+
+// TestServeBlobRequireRedirect checks that blob content is never served by
+// the registry when redirects are required.
+func TestServeBlobRequireRedirect(t *testing.T) {
+	ctx := context.Background()
+	imageName, _ := reference.WithName("foo/bar")
+	driver := inmemory.New()
+
+	registry, err := NewRegistry(ctx, driver, BlobDescriptorCacheProvider(memory.NewInMemoryBlobDescriptorCacheProvider(memory.UnlimitedSize)), RequireRedirect)
+	if err != nil {
+		t.Fatalf("error creating registry: %v", err)
+	}
+	repository, err := registry.Repository(ctx, imageName)
+	if err != nil {
+		t.Fatalf("unexpected error getting repo: %v", err)
+	}
+	content := []byte("blob content which must not be served")
+	desc, err := repository.Blobs(ctx).Put(ctx, "", content)
+	if err != nil {
+		t.Fatalf("unexpected error putting blob: %v", err)
+	}
+
+	// the inmemory driver provides no redirect URL
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/v2/foo/bar/blobs/"+desc.Digest.String(), nil)
+	if err := repository.Blobs(ctx).ServeBlob(ctx, w, r, desc.Digest); !errors.Is(err, distribution.ErrBlobRedirectUnavailable) {
+		t.Fatalf("expected ErrBlobRedirectUnavailable, got %v", err)
+	}
+	if w.Body.Len() != 0 || w.Header().Get("Content-Length") != "" {
+		t.Fatalf("unexpected content served: %v %q", w.Header(), w.Body.Bytes())
+	}
+
+	// HEAD requests carry no content
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodHead, "/v2/foo/bar/blobs/"+desc.Digest.String(), nil)
+	if err := repository.Blobs(ctx).ServeBlob(ctx, w, r, desc.Digest); err != nil {
+		t.Fatalf("unexpected error serving HEAD request: %v", err)
+	}
+	if w.Code != http.StatusOK || w.Body.Len() != 0 {
+		t.Fatalf("unexpected response to HEAD request: %d %q", w.Code, w.Body.Bytes())
+	}
+
+	registry, err = NewRegistry(ctx, redirectingDriver{driver}, BlobDescriptorCacheProvider(memory.NewInMemoryBlobDescriptorCacheProvider(memory.UnlimitedSize)), RequireRedirect)
+	if err != nil {
+		t.Fatalf("error creating registry: %v", err)
+	}
+	repository, err = registry.Repository(ctx, imageName)
+	if err != nil {
+		t.Fatalf("unexpected error getting repo: %v", err)
+	}
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodGet, "/v2/foo/bar/blobs/"+desc.Digest.String(), nil)
+	if err := repository.Blobs(ctx).ServeBlob(ctx, w, r, desc.Digest); err != nil {
+		t.Fatalf("unexpected error serving blob: %v", err)
+	}
+	if w.Code != http.StatusTemporaryRedirect || !strings.HasPrefix(w.Header().Get("Location"), "https://storage.example.com/") {
+		t.Fatalf("unexpected response: %d %v", w.Code, w.Header())
+	}
+	if bytes.Contains(w.Body.Bytes(), content) {
+		t.Fatal("blob content served along with the redirect")
+	}
+}
+
+// TestServeBlobRedirectCacheControl checks that redirects to signed URLs are
+// not cached by default, and carry the configured Cache-Control header.
+func TestServeBlobRedirectCacheControl(t *testing.T) {
+	ctx := context.Background()
+	imageName, _ := reference.WithName("foo/bar")
+	driver := redirectingDriver{inmemory.New()}
+
+	for _, tc := range []struct {
+		options  []RegistryOption
+		expected string
+	}{
+		{options: []RegistryOption{EnableRedirect}, expected: "no-store"},
+		{options: []RegistryOption{EnableRedirect, RedirectCacheControl("private, max-age=60")}, expected: "private, max-age=60"},
+	} {
+		registry, err := NewRegistry(ctx, driver, tc.options...)
+		if err != nil {
+			t.Fatalf("error creating registry: %v", err)
+		}
+		repository, err := registry.Repository(ctx, imageName)
+		if err != nil {
+			t.Fatalf("unexpected error getting repo: %v", err)
+		}
+		desc, err := repository.Blobs(ctx).Put(ctx, "", []byte("redirected blob content"))
+		if err != nil {
+			t.Fatalf("unexpected error putting blob: %v", err)
+		}
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/v2/foo/bar/blobs/"+desc.Digest.String(), nil)
+		if err := repository.Blobs(ctx).ServeBlob(ctx, w, r, desc.Digest); err != nil {
+			t.Fatalf("unexpected error serving blob: %v", err)
+		}
+		if w.Code != http.StatusTemporaryRedirect {
+			t.Fatalf("unexpected response: %d %v", w.Code, w.Header())
+		}
+		if cacheControl := w.Header().Get("Cache-Control"); cacheControl != tc.expected {
+			t.Errorf("unexpected Cache-Control header on redirect: %q != %q", cacheControl, tc.expected)
+		}
+	}
+}
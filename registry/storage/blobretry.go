@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// blobExistenceRetry retries the blob existence checks of manifest
+// verification, for blobs not yet visible on eventually consistent storage.
+type blobExistenceRetry struct {
+	// backoff is the delay before the first retry, doubled before each
+	// following one.
+	backoff time.Duration
+	// maxWait bounds the time spent retrying the checks of a manifest.
+	maxWait time.Duration
+}
+
+// RetryBlobExistenceChecks is a functional option for NewRegistry. When a
+// blob referenced by a pushed image manifest is not found, the check is
+// retried after backoff, doubled before each following retry, for at most
+// maxWait across the blobs of the manifest. This tolerates blobs uploaded
+// in parallel with the manifest becoming visible late on eventually
+// consistent storage, at the cost of delaying the rejection of manifests
+// referencing blobs that are genuinely missing.
+func RetryBlobExistenceChecks(backoff, maxWait time.Duration) RegistryOption {
+	return func(registry *registry) error {
+		if backoff <= 0 || maxWait <= 0 {
+			return errors.New("blob existence check backoff and maximum wait must be positive")
+		}
+		registry.blobExistenceRetry = blobExistenceRetry{backoff: backoff, maxWait: maxWait}
+		return nil
+	}
+}
+
+// statter returns statter, retrying the stats of unknown blobs if enabled.
+// The returned statter must only be used for the verification of a single
+// manifest, the retries of which it bounds.
+func (r blobExistenceRetry) statter(statter distribution.BlobStatter) distribution.BlobStatter {
+	if r.maxWait <= 0 {
+		return statter
+	}
+	return &retryingBlobStatter{BlobStatter: statter, retry: r}
+}
+
+// retryingBlobStatter retries the stats of unknown blobs until the deadline
+// set by the first unknown blob.
+type retryingBlobStatter struct {
+	distribution.BlobStatter
+	retry    blobExistenceRetry
+	deadline time.Time
+}
+
+func (s *retryingBlobStatter) Stat(ctx context.Context, dgst digest.Digest) (v1.Descriptor, error) {
+	desc, err := s.BlobStatter.Stat(ctx, dgst)
+	if err != distribution.ErrBlobUnknown {
+		return desc, err
+	}
+	if s.deadline.IsZero() {
+		s.deadline = time.Now().Add(s.retry.maxWait)
+	}
+
+	backoff := s.retry.backoff
+	for attempt := 1; ; attempt++ {
+		remaining := time.Until(s.deadline)
+		if remaining <= 0 {
+			return desc, err
+		}
+
+		timer := time.NewTimer(min(backoff, remaining))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return desc, err
+		case <-timer.C:
+		}
+		backoff *= 2
+
+		desc, err = s.BlobStatter.Stat(ctx, dgst)
+		if err != distribution.ErrBlobUnknown {
+			if err == nil {
+				dcontext.GetLogger(ctx).Infof("blob %s referenced by manifest found after %d retries", dgst, attempt)
+			}
+			return desc, err
+		}
+	}
+}
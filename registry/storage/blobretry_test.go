@@ -0,0 +1,151 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// delayedDriver hides the files whose path contains hidden until visibleAt,
+// as eventually consistent storage does for recently written files.
+type delayedDriver struct {
+	storagedriver.StorageDriver
+
+	mu        sync.Mutex
+	hidden    string
+	visibleAt time.Time
+}
+
+func (d *delayedDriver) hide(hidden string, visibleAt time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.hidden, d.visibleAt = hidden, visibleAt
+}
+
+func (d *delayedDriver) isHidden(path string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.hidden != "" && strings.Contains(path, d.hidden) && time.Now().Before(d.visibleAt)
+}
+
+func (d *delayedDriver) GetContent(ctx context.Context, path string) ([]byte, error) {
+	if d.isHidden(path) {
+		return nil, storagedriver.PathNotFoundError{Path: path}
+	}
+	return d.StorageDriver.GetContent(ctx, path)
+}
+
+func (d *delayedDriver) Stat(ctx context.Context, path string) (storagedriver.FileInfo, error) {
+	if d.isHidden(path) {
+		return nil, storagedriver.PathNotFoundError{Path: path}
+	}
+	return d.StorageDriver.Stat(ctx, path)
+}
+
+func TestRetryBlobExistenceChecks(t *testing.T) {
+	ctx := context.Background()
+	name, _ := reference.WithName("foo/bar")
+
+	for _, tc := range []struct {
+		name    string
+		options []RegistryOption
+		delay   time.Duration
+		minWait time.Duration
+		valid   bool
+	}{
+		{name: "visible", valid: true},
+		{name: "no retry", delay: 200 * time.Millisecond},
+		{name: "delayed", options: []RegistryOption{RetryBlobExistenceChecks(10*time.Millisecond, 5*time.Second)}, delay: 200 * time.Millisecond, valid: true},
+		{name: "missing", options: []RegistryOption{RetryBlobExistenceChecks(10*time.Millisecond, 300*time.Millisecond)}, delay: time.Hour, minWait: 300 * time.Millisecond},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			driver := &delayedDriver{StorageDriver: inmemory.New()}
+			registry, err := NewRegistry(ctx, driver, tc.options...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			repo, err := registry.Repository(ctx, name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			bs := repo.Blobs(ctx)
+			var blobs []v1.Descriptor
+			for _, content := range [][]byte{[]byte("{}"), []byte("layer")} {
+				desc, err := addBlob(ctx, bs, v1.Descriptor{Digest: digest.FromBytes(content), Size: int64(len(content))}, bytes.NewReader(content))
+				if err != nil {
+					t.Fatal(err)
+				}
+				blobs = append(blobs, desc)
+			}
+			manifest, err := ocischema.FromStruct(ocischema.Manifest{
+				Versioned: specs.Versioned{SchemaVersion: 2},
+				MediaType: v1.MediaTypeImageManifest,
+				Config:    v1.Descriptor{MediaType: v1.MediaTypeImageConfig, Digest: blobs[0].Digest, Size: blobs[0].Size},
+				Layers:    []v1.Descriptor{{MediaType: v1.MediaTypeImageLayer, Digest: blobs[1].Digest, Size: blobs[1].Size}},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// the link of the layer is not yet visible
+			start := time.Now()
+			if tc.delay > 0 {
+				driver.hide("_layers/"+blobs[1].Digest.Algorithm().String()+"/"+blobs[1].Digest.Encoded(), start.Add(tc.delay))
+			}
+			ms, err := repo.Manifests(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = ms.Put(ctx, manifest)
+			elapsed := time.Since(start)
+
+			if tc.valid {
+				if err != nil {
+					t.Fatalf("unexpected error putting manifest: %v", err)
+				}
+				return
+			}
+			var verificationErrs distribution.ErrManifestVerification
+			if !errors.As(err, &verificationErrs) {
+				t.Fatalf("expected a manifest verification error, got %v", err)
+			}
+			found := false
+			for _, err := range verificationErrs {
+				if unknown, ok := err.(distribution.ErrManifestBlobUnknown); ok && unknown.Digest == blobs[1].Digest {
+					found = true
+				}
+			}
+			if !found {
+				t.Fatalf("expected the layer to be reported unknown, got %v", err)
+			}
+			if elapsed < tc.minWait {
+				t.Fatalf("expected the checks to be retried for %v, gave up after %v", tc.minWait, elapsed)
+			}
+			if elapsed > tc.minWait+2*time.Second {
+				t.Fatalf("expected the retries to stop after %v, gave up after %v", tc.minWait, elapsed)
+			}
+		})
+	}
+
+	for _, option := range []RegistryOption{
+		RetryBlobExistenceChecks(0, time.Second),
+		RetryBlobExistenceChecks(time.Second, 0),
+	} {
+		if _, err := NewRegistry(ctx, inmemory.New(), option); err == nil {
+			t.Error("expected an error for an invalid blob existence retry")
+		}
+	}
+}
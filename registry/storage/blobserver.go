@@ -7,13 +7,19 @@ import (
 	"time"
 
 	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/internal/dcontext"
 	"github.com/distribution/distribution/v3/registry/storage/driver"
 	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
 // TODO(stevvooe): This should configurable in the future.
 const blobCacheControlMaxAge = 365 * 24 * time.Hour
 
+// defaultRedirectCacheControl keeps clients and intermediaries from caching
+// redirects, whose signed URLs expire.
+const defaultRedirectCacheControl = "no-store"
+
 // blobServer simply serves blobs from a driver instance using a path function
 // to identify paths and a descriptor service to fill in metadata.
 type blobServer struct {
@@ -21,6 +27,14 @@ type blobServer struct {
 	statter  distribution.BlobStatter
 	pathFn   func(dgst digest.Digest) (string, error)
 	redirect bool // allows disabling RedirectURL redirects
+	// requireRedirect forbids serving the content of blobs directly when the
+	// driver does not provide a redirect URL.
+	requireRedirect bool
+	// redirectCacheControl is the Cache-Control header of the redirects.
+	redirectCacheControl string
+	// transferClasses classes the downloads observed by the transfer speed
+	// metrics.
+	transferClasses transferSizeClasses
 }
 
 func (bs *blobServer) ServeBlob(ctx context.Context, w http.ResponseWriter, r *http.Request, dgst digest.Digest) error {
@@ -28,7 +42,11 @@ func (bs *blobServer) ServeBlob(ctx context.Context, w http.ResponseWriter, r *h
 	if err != nil {
 		return err
 	}
+	return bs.serveBlob(ctx, w, r, desc)
+}
 
+// serveBlob serves the blob desc, found already.
+func (bs *blobServer) serveBlob(ctx context.Context, w http.ResponseWriter, r *http.Request, desc v1.Descriptor) error {
 	path, err := bs.pathFn(desc.Digest)
 	if err != nil {
 		return err
@@ -41,10 +59,16 @@ func (bs *blobServer) ServeBlob(ctx context.Context, w http.ResponseWriter, r *h
 		}
 		if redirectURL != "" {
 			// Redirect to storage URL.
+			w.Header().Set("Cache-Control", bs.redirectCacheControl)
 			http.Redirect(w, r, redirectURL, http.StatusTemporaryRedirect)
 			return nil
 		}
-		// Fallback to serving the content directly.
+		// Fallback to serving the content directly, unless the content
+		// must not transit through the registry. HEAD requests carry no
+		// content.
+		if bs.requireRedirect && r.Method != http.MethodHead {
+			return fmt.Errorf("%w: storage driver %s provided no redirect URL for %s", distribution.ErrBlobRedirectUnavailable, bs.driver.Name(), desc.Digest)
+		}
 	}
 
 	br, err := newFileReader(ctx, bs.driver, path, desc.Size)
@@ -70,6 +94,17 @@ func (bs *blobServer) ServeBlob(ctx context.Context, w http.ResponseWriter, r *h
 		w.Header().Set("Content-Length", fmt.Sprint(desc.Size))
 	}
 
-	http.ServeContent(w, r, desc.Digest.String(), time.Time{}, br)
+	// account the time spent reading from the driver to the backend leg and
+	// the time spent writing to the client to the client leg
+	meter := &transferMeter{driver: bs.driver.Name(), direction: transferDownload, classes: bs.transferClasses}
+	content := &timedReadSeeker{ReadSeeker: br, leg: &meter.backend}
+	http.ServeContent(&timedResponseWriter{ResponseWriter: w, leg: &meter.client}, r, desc.Digest.String(), time.Time{}, content)
+	meter.observe()
+
+	// the response has started, so a failure to read the blob can only cut
+	// it off. Failures caused by the client going away are its own.
+	if content.err != nil && ctx.Err() == nil {
+		dcontext.GetLogger(ctx).Errorf("error reading blob %s from storage: %v", desc.Digest, content.err)
+	}
 	return nil
 }
@@ -19,6 +19,10 @@ import (
 type blobStore struct {
 	driver  driver.StorageDriver
 	statter distribution.BlobStatter
+
+	// transferClasses classes the uploads observed by the transfer speed
+	// metrics.
+	transferClasses transferSizeClasses
 }
 
 var _ distribution.BlobProvider = &blobStore{}
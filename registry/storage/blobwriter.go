@@ -40,9 +40,19 @@ type blobWriter struct {
 
 	resumableDigestEnabled bool
 	committed              bool
+
+	// preverified is set when the client vouches for the digest of the
+	// content, which is then only verified after commit.
+	preverified bool
+
+	// transfer measures the speed of the upload, observed on close.
+	transfer transferMeter
 }
 
-var _ distribution.BlobWriter = &blobWriter{}
+var (
+	_ distribution.BlobWriter         = &blobWriter{}
+	_ distribution.BlobWriteAbandoner = &blobWriter{}
+)
 
 // ID returns the identifier for this upload.
 func (bw *blobWriter) ID() string {
@@ -58,9 +68,11 @@ func (bw *blobWriter) StartedAt() time.Time {
 func (bw *blobWriter) Commit(ctx context.Context, desc v1.Descriptor) (v1.Descriptor, error) {
 	dcontext.GetLogger(ctx).Debug("(*blobWriter).Commit")
 
+	start := time.Now()
 	if err := bw.fileWriter.Commit(ctx); err != nil {
 		return v1.Descriptor{}, err
 	}
+	bw.transfer.backend.add(0, start)
 
 	bw.Close()
 	desc.Size = bw.Size()
@@ -70,6 +82,18 @@ func (bw *blobWriter) Commit(ctx context.Context, desc v1.Descriptor) (v1.Descri
 		return v1.Descriptor{}, err
 	}
 
+	if err := bw.validateDecompressedSize(ctx, canonical); err != nil {
+		return v1.Descriptor{}, err
+	}
+
+	var verify bool
+	if bw.preverified {
+		canonical, verify, err = bw.needsPreverification(ctx, canonical)
+		if err != nil {
+			return v1.Descriptor{}, err
+		}
+	}
+
 	if err := bw.moveBlob(ctx, canonical); err != nil {
 		return v1.Descriptor{}, err
 	}
@@ -88,6 +112,9 @@ func (bw *blobWriter) Commit(ctx context.Context, desc v1.Descriptor) (v1.Descri
 	}
 
 	bw.committed = true
+	if verify {
+		go bw.blobStore.verifyPreverifiedBlob(context.WithoutCancel(ctx), canonical)
+	}
 	return canonical, nil
 }
 
@@ -95,6 +122,7 @@ func (bw *blobWriter) Commit(ctx context.Context, desc v1.Descriptor) (v1.Descri
 // the writer and canceling the operation.
 func (bw *blobWriter) Cancel(ctx context.Context) error {
 	dcontext.GetLogger(ctx).Debug("(*blobWriter).Cancel")
+	bw.transfer.reset()
 	if err := bw.fileWriter.Cancel(ctx); err != nil {
 		return err
 	}
@@ -106,11 +134,30 @@ func (bw *blobWriter) Cancel(ctx context.Context) error {
 	return bw.removeResources(ctx)
 }
 
+// Abandon marks the upload as abandoned by its client, so that it is purged
+// once the grace period of abandoned uploads has passed rather than once it
+// is older than the purge age.
+func (bw *blobWriter) Abandon(ctx context.Context) error {
+	dcontext.GetLogger(ctx).Debug("(*blobWriter).Abandon")
+	abandonedAtPath, err := pathFor(uploadAbandonedAtPathSpec{
+		name: bw.blobStore.repository.Named().Name(),
+		id:   bw.id,
+	})
+	if err != nil {
+		return err
+	}
+	return bw.driver.PutContent(ctx, abandonedAtPath, []byte(time.Now().UTC().Format(time.RFC3339)))
+}
+
 func (bw *blobWriter) Size() int64 {
 	return bw.fileWriter.Size()
 }
 
 func (bw *blobWriter) Write(p []byte) (int, error) {
+	if bw.preverified {
+		return bw.backend().Write(p)
+	}
+
 	// Ensure that the current write offset matches how many bytes have been
 	// written to the digester. If not, we need to update the digest state to
 	// match the current write position.
@@ -118,7 +165,7 @@ func (bw *blobWriter) Write(p []byte) (int, error) {
 		return 0, err
 	}
 
-	_, err := bw.fileWriter.Write(p)
+	_, err := bw.backend().Write(p)
 	if err != nil {
 		return 0, err
 	}
@@ -130,6 +177,10 @@ func (bw *blobWriter) Write(p []byte) (int, error) {
 }
 
 func (bw *blobWriter) ReadFrom(r io.Reader) (n int64, err error) {
+	if bw.preverified {
+		return io.Copy(bw.backend(), bw.client(r))
+	}
+
 	// Ensure that the current write offset matches how many bytes have been
 	// written to the digester. If not, we need to update the digest state to
 	// match the current write position.
@@ -140,7 +191,7 @@ func (bw *blobWriter) ReadFrom(r io.Reader) (n int64, err error) {
 	// Using a TeeReader instead of MultiWriter ensures Copy returns
 	// the amount written to the digester as well as ensuring that we
 	// write to the fileWriter first
-	tee := io.TeeReader(r, bw.fileWriter)
+	tee := io.TeeReader(bw.client(r), bw.backend())
 	nn, err := io.Copy(bw.digester.Hash(), tee)
 	bw.written += nn
 
@@ -151,14 +202,40 @@ func (bw *blobWriter) Close() error {
 	if bw.committed {
 		return errors.New("blobwriter close after commit")
 	}
+	defer bw.transfer.observe()
+
+	// the digester does not follow the content of pre-verified uploads
+	if bw.preverified {
+		return bw.closeFileWriter()
+	}
 
 	if err := bw.storeHashState(bw.blobStore.ctx); err != nil && err != errResumableDigestNotAvailable {
 		return err
 	}
 
+	return bw.closeFileWriter()
+}
+
+// closeFileWriter closes the file writer, accounting the time spent flushing
+// the content to the backend leg of the upload.
+func (bw *blobWriter) closeFileWriter() error {
+	start := time.Now()
+	defer bw.transfer.backend.add(0, start)
 	return bw.fileWriter.Close()
 }
 
+// client accounts the reads of the content from r to the client leg of the
+// upload.
+func (bw *blobWriter) client(r io.Reader) io.Reader {
+	return &timedReader{r: r, leg: &bw.transfer.client}
+}
+
+// backend accounts the writes of the content to the backend leg of the
+// upload.
+func (bw *blobWriter) backend() io.Writer {
+	return &timedWriter{w: bw.fileWriter, leg: &bw.transfer.backend}
+}
+
 // validateBlob checks the data against the digest, returning an error if it
 // does not match. The canonical descriptor is returned.
 func (bw *blobWriter) validateBlob(ctx context.Context, desc v1.Descriptor) (v1.Descriptor, error) {
@@ -210,7 +287,18 @@ func (bw *blobWriter) validateBlob(ctx context.Context, desc v1.Descriptor) (v1.
 	// TODO(stevvooe): This section is very meandering. Need to be broken down
 	// to be a lot more clear.
 
-	if err := bw.resumeDigest(ctx); err == nil {
+	if bw.preverified {
+		// The client vouches for the digest, the content is verified in the
+		// background once committed.
+		if err := desc.Digest.Validate(); err != nil {
+			return v1.Descriptor{}, distribution.ErrBlobInvalidDigest{
+				Digest: desc.Digest,
+				Reason: err,
+			}
+		}
+		canonical = desc.Digest
+		verified = true
+	} else if err := bw.resumeDigest(ctx); err == nil {
 		canonical = bw.digester.Digest()
 
 		if canonical.Algorithm() == desc.Digest.Algorithm() {
@@ -292,6 +380,10 @@ func (bw *blobWriter) validateBlob(ctx context.Context, desc v1.Descriptor) (v1.
 // identified by dgst. The layer should be validated before commencing the
 // move.
 func (bw *blobWriter) moveBlob(ctx context.Context, desc v1.Descriptor) error {
+	// the blob is stored on the backend of the repository if repositories
+	// are stored on different backends
+	ctx = storagedriver.WithRepository(ctx, bw.blobStore.repository.Named().Name())
+
 	blobPath, err := pathFor(blobDataPathSpec{
 		digest: desc.Digest,
 	})
@@ -311,7 +403,8 @@ func (bw *blobWriter) moveBlob(ctx context.Context, desc v1.Descriptor) error {
 		// If the path exists, we can assume that the content has already
 		// been uploaded, since the blob storage is content-addressable.
 		// While it may be corrupted, detection of such corruption belongs
-		// elsewhere.
+		// elsewhere, unless the upload is compared to the existing content.
+		bw.verifyExistingContent(ctx, desc, blobPath)
 		return nil
 	}
 
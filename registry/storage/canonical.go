@@ -0,0 +1,169 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/opencontainers/go-digest"
+)
+
+// canonicalManifests checks that the bytes of pushed manifests are their
+// canonical serialization. The check is disabled unless enabled.
+type canonicalManifests struct {
+	enabled bool
+	// reject rejects the non-canonical manifests, which are only logged
+	// otherwise.
+	reject bool
+}
+
+// ValidateCanonicalManifests is a functional option for NewRegistry. It
+// checks that the bytes of pushed manifests are their canonical JSON form:
+// object keys sorted, without insignificant whitespace. Non-canonical
+// manifests are rejected if reject, and logged otherwise. They are never
+// rewritten, as that would change their digest.
+func ValidateCanonicalManifests(reject bool) RegistryOption {
+	return func(registry *registry) error {
+		registry.canonicalManifests = canonicalManifests{enabled: true, reject: reject}
+		return nil
+	}
+}
+
+// verify returns an error if the manifest is not in canonical form and such
+// manifests are rejected.
+func (c canonicalManifests) verify(ctx context.Context, manifest distribution.Manifest) error {
+	if !c.enabled {
+		return nil
+	}
+
+	_, payload, err := manifest.Payload()
+	if err != nil {
+		return err
+	}
+	canonical, err := canonicalJSON(payload)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(payload, canonical) {
+		return nil
+	}
+
+	dgst := digest.FromBytes(payload)
+	if !c.reject {
+		dcontext.GetLogger(ctx).Warnf("manifest %s is not in canonical form", dgst)
+		return nil
+	}
+	dcontext.GetLogger(ctx).Infof("rejecting manifest %s: not in canonical form", dgst)
+	return distribution.ErrManifestVerification{distribution.ErrManifestNotCanonical{}}
+}
+
+// canonicalJSON returns the canonical form of the JSON document payload: the
+// keys of the objects sorted by their bytes, the strings escaping only the
+// characters JSON requires to, the integers without fraction nor exponent,
+// and no whitespace between the tokens. The fields are kept whether or not
+// the registry knows them. Duplicate keys are reduced to their last value,
+// so a document holding some is not canonical.
+func canonicalJSON(payload []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	var v any
+	if err := decoder.Decode(&v); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := writeCanonicalJSON(&buf, v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeCanonicalJSON writes the canonical form of the decoded JSON value v.
+func writeCanonicalJSON(buf *bytes.Buffer, v any) error {
+	switch v := v.(type) {
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		buf.WriteByte('{')
+		for i, key := range keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writeCanonicalString(buf, key)
+			buf.WriteByte(':')
+			if err := writeCanonicalJSON(buf, v[key]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	case []any:
+		buf.WriteByte('[')
+		for i, element := range v {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeCanonicalJSON(buf, element); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case string:
+		writeCanonicalString(buf, v)
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			buf.WriteString(strconv.FormatInt(n, 10))
+			return nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return err
+		}
+		buf.WriteString(strconv.FormatFloat(f, 'g', -1, 64))
+	case bool:
+		buf.WriteString(strconv.FormatBool(v))
+	case nil:
+		buf.WriteString("null")
+	default:
+		return fmt.Errorf("unexpected JSON value %T", v)
+	}
+	return nil
+}
+
+// writeCanonicalString writes s as a JSON string, escaping the quotation
+// mark, the reverse solidus and the control characters only.
+func writeCanonicalString(buf *bytes.Buffer, s string) {
+	const hex = "0123456789abcdef"
+	buf.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\\':
+			buf.WriteByte('\\')
+			buf.WriteByte(c)
+		case c == '\b':
+			buf.WriteString(`\b`)
+		case c == '\f':
+			buf.WriteString(`\f`)
+		case c == '\n':
+			buf.WriteString(`\n`)
+		case c == '\r':
+			buf.WriteString(`\r`)
+		case c == '\t':
+			buf.WriteString(`\t`)
+		case c < 0x20:
+			buf.WriteString(`\u00`)
+			buf.WriteByte(hex[c>>4])
+			buf.WriteByte(hex[c&0xf])
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	buf.WriteByte('"')
+}
@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestValidateCanonicalManifests(t *testing.T) {
+	ctx := context.Background()
+	name, _ := reference.WithName("foo/bar")
+
+	config := []byte(`{"architecture":"amd64","os":"linux"}`)
+	layer := []byte("layer")
+	m := ocischema.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: v1.MediaTypeImageManifest,
+		Config:    v1.Descriptor{MediaType: v1.MediaTypeImageConfig, Digest: digest.FromBytes(config), Size: int64(len(config))},
+		Layers:    []v1.Descriptor{{MediaType: v1.MediaTypeImageLayer, Digest: digest.FromBytes(layer), Size: int64(len(layer))}},
+	}
+	indented, err := json.MarshalIndent(&m, "", "   ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the keys in the order of the specification
+	compact, err := json.Marshal(&m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the keys sorted without whitespace, which is canonical whatever the
+	// fields
+	descriptor := func(desc v1.Descriptor) string {
+		return fmt.Sprintf(`{"digest":%q,"mediaType":%q,"size":%d}`, desc.Digest, desc.MediaType, desc.Size)
+	}
+	sorted := `{"config":` + descriptor(m.Config) + `,"layers":[` + descriptor(m.Layers[0]) + `],"mediaType":"` + v1.MediaTypeImageManifest + `","schemaVersion":2`
+	canonical := []byte(sorted + `}`)
+	unknown := []byte(sorted + `,"x-build":{"id":"<1>"}}`)
+	duplicate := []byte(sorted + `,"schemaVersion":2}`)
+	escaped := []byte(sorted + `,"x-build":{"id":"\u003c1\u003e"}}`)
+
+	for _, tc := range []struct {
+		name      string
+		payload   []byte
+		reject    bool
+		canonical bool
+	}{
+		{name: "canonical", payload: canonical, reject: true, canonical: true},
+		{name: "unknown field", payload: unknown, reject: true, canonical: true},
+		{name: "indented", payload: indented, reject: true},
+		{name: "specification order", payload: compact, reject: true},
+		{name: "duplicate key", payload: duplicate, reject: true},
+		{name: "escaped", payload: escaped, reject: true},
+		{name: "trailing newline", payload: append(canonical[:len(canonical):len(canonical)], '\n'), reject: true},
+		{name: "indented logged", payload: indented},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			registry, err := NewRegistry(ctx, inmemory.New(), ValidateCanonicalManifests(tc.reject))
+			if err != nil {
+				t.Fatal(err)
+			}
+			repo, err := registry.Repository(ctx, name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			bs := repo.Blobs(ctx)
+			for _, content := range [][]byte{config, layer} {
+				if _, err := addBlob(ctx, bs, v1.Descriptor{Digest: digest.FromBytes(content), Size: int64(len(content))}, bytes.NewReader(content)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			manifest, desc, err := distribution.UnmarshalManifest(v1.MediaTypeImageManifest, tc.payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ms, err := repo.Manifests(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			dgst, err := ms.Put(ctx, manifest)
+			if tc.canonical || !tc.reject {
+				if err != nil {
+					t.Fatalf("unexpected error putting manifest: %v", err)
+				}
+				// the manifest is stored as pushed
+				if dgst != desc.Digest {
+					t.Fatalf("unexpected digest: %s != %s", dgst, desc.Digest)
+				}
+				return
+			}
+			var verificationErrs distribution.ErrManifestVerification
+			if !errors.As(err, &verificationErrs) || len(verificationErrs) != 1 {
+				t.Fatalf("expected a manifest verification error, got %v", err)
+			}
+			if _, ok := verificationErrs[0].(distribution.ErrManifestNotCanonical); !ok {
+				t.Fatalf("expected a non-canonical manifest error, got %v", err)
+			}
+		})
+	}
+}
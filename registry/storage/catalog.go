@@ -91,6 +91,24 @@ func (reg *registry) Remove(ctx context.Context, name reference.Named) error {
 	return reg.driver.Delete(ctx, repoDir)
 }
 
+// RepositoryExists reports whether a repository holds manifests, with a
+// single stat of its manifests directory.
+func (reg *registry) RepositoryExists(ctx context.Context, name reference.Named) (bool, error) {
+	manifestsPath, err := pathFor(manifestsPathSpec{name: name.Name()})
+	if err != nil {
+		return false, err
+	}
+	if _, err := reg.driver.Stat(ctx, manifestsPath); err != nil {
+		switch err.(type) {
+		case driver.PathNotFoundError:
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+	return true, nil
+}
+
 // lessPath returns true if one path a is less than path b.
 //
 // A component-wise comparison is done, rather than the lexical comparison of
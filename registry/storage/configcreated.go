@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/manifest/schema2"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// maxConfigReadSize bounds the bytes of a config read for its creation time.
+const maxConfigReadSize = 4 << 20
+
+// configCreated rejects image manifests whose config was created too long
+// ago. The validation is disabled if maxAge is zero.
+type configCreated struct {
+	maxAge time.Duration
+	// strict rejects the configs without a creation time.
+	strict bool
+}
+
+// ValidateConfigCreated is a functional option for NewRegistry. It rejects
+// image manifests whose config was created more than maxAge ago, as given by
+// its created field. Configs without a creation time are rejected if strict,
+// and accepted otherwise. The configs of artifacts are not checked.
+func ValidateConfigCreated(maxAge time.Duration, strict bool) RegistryOption {
+	return func(registry *registry) error {
+		if maxAge <= 0 {
+			return errors.New("config creation maximum age must be positive")
+		}
+		registry.configCreated = configCreated{maxAge: maxAge, strict: strict}
+		return nil
+	}
+}
+
+// verify returns an error if the config of an image manifest was created
+// too long ago. A missing config is left to the existence checks of the
+// manifest verification.
+func (c configCreated) verify(ctx context.Context, blobs distribution.BlobProvider, config v1.Descriptor) error {
+	if c.maxAge <= 0 {
+		return nil
+	}
+	switch config.MediaType {
+	case v1.MediaTypeImageConfig, schema2.MediaTypeImageConfig:
+	default:
+		return nil
+	}
+
+	rc, err := blobs.Open(ctx, config.Digest)
+	if err != nil {
+		if err == distribution.ErrBlobUnknown {
+			return nil
+		}
+		return err
+	}
+	defer rc.Close()
+
+	var image struct {
+		Created *time.Time `json:"created,omitempty"`
+	}
+	if err := json.NewDecoder(io.LimitReader(rc, maxConfigReadSize)).Decode(&image); err != nil {
+		// configs which cannot be parsed are handled as configs without
+		// a creation time
+		dcontext.GetLogger(ctx).Debugf("error parsing config %s: %v", config.Digest, err)
+		image.Created = nil
+	}
+
+	if image.Created == nil {
+		if c.strict {
+			return distribution.ErrManifestConfigCreatedInvalid{MaxAge: c.maxAge}
+		}
+		return nil
+	}
+	if time.Since(*image.Created) > c.maxAge {
+		return distribution.ErrManifestConfigCreatedInvalid{Created: image.Created, MaxAge: c.maxAge}
+	}
+	return nil
+}
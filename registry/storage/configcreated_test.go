@@ -0,0 +1,52 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestValidateConfigCreated(t *testing.T) {
+	now := time.Now()
+
+	for _, tc := range []struct {
+		name      string
+		config    string
+		mediaType string
+		strict    bool
+		valid     bool
+	}{
+		{name: "fresh", config: `{"created":"` + now.Add(-time.Hour).Format(time.RFC3339) + `"}`, valid: true},
+		{name: "stale", config: `{"created":"` + now.Add(-48*time.Hour).Format(time.RFC3339) + `"}`},
+		{name: "missing lenient", config: `{"architecture":"amd64"}`, valid: true},
+		{name: "missing strict", config: `{"architecture":"amd64"}`, strict: true},
+		{name: "invalid strict", config: `{"created":"yesterday"}`, strict: true},
+		{name: "artifact", config: `{}`, mediaType: v1.MediaTypeEmptyJSON, strict: true, valid: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := putImageManifest(t, ValidateConfigCreated(24*time.Hour, tc.strict), false, tc.mediaType, []byte(tc.config), []byte("layer"))
+			if tc.valid {
+				if err != nil {
+					t.Fatalf("unexpected error putting manifest: %v", err)
+				}
+				return
+			}
+			var verificationErrs distribution.ErrManifestVerification
+			if !errors.As(err, &verificationErrs) || len(verificationErrs) != 1 {
+				t.Fatalf("expected a manifest verification error, got %v", err)
+			}
+			if _, ok := verificationErrs[0].(distribution.ErrManifestConfigCreatedInvalid); !ok {
+				t.Fatalf("expected a config created error, got %v", err)
+			}
+		})
+	}
+
+	if _, err := NewRegistry(context.Background(), inmemory.New(), ValidateConfigCreated(0, false)); err == nil {
+		t.Error("expected an error for an invalid maximum age")
+	}
+}
@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"math"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/klauspost/compress/zstd"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// decompressionLimits bounds the decompressed size of compressed blobs.
+type decompressionLimits struct {
+	// maxRatio is the maximum ratio of the decompressed size to the
+	// compressed size, unbounded if zero.
+	maxRatio int64
+	// maxSize is the maximum decompressed size, unbounded if zero.
+	maxSize int64
+}
+
+func (l decompressionLimits) enabled() bool {
+	return l.maxRatio > 0 || l.maxSize > 0
+}
+
+// limit returns the maximum decompressed size of a blob of size bytes.
+func (l decompressionLimits) limit(size int64) int64 {
+	limit := int64(math.MaxInt64)
+	if l.maxRatio > 0 && size <= math.MaxInt64/l.maxRatio {
+		limit = size * l.maxRatio
+	}
+	if l.maxSize > 0 {
+		limit = min(limit, l.maxSize)
+	}
+	return limit
+}
+
+// ValidateBlobDecompression is a functional option for NewRegistry. It
+// rejects uploaded gzip and zstd compressed blobs decompressing to more than
+// maxRatio times their size, or to more than maxSize bytes. A zero value
+// leaves the corresponding bound unset.
+func ValidateBlobDecompression(maxRatio, maxSize int64) RegistryOption {
+	return func(registry *registry) error {
+		registry.decompressionLimits = decompressionLimits{maxRatio: maxRatio, maxSize: maxSize}
+		return nil
+	}
+}
+
+// validateDecompressedSize decompresses the uploaded content, if compressed
+// with gzip or zstd, returning an error as soon as it exceeds the
+// decompression limits. Content which is not compressed, or fails to
+// decompress, is left to clients to reject.
+func (bw *blobWriter) validateDecompressedSize(ctx context.Context, desc v1.Descriptor) error {
+	limits := bw.blobStore.registry.decompressionLimits
+	if !limits.enabled() || desc.Size == 0 {
+		return nil
+	}
+
+	fr, err := newFileReader(ctx, bw.driver, bw.path, desc.Size)
+	if err != nil {
+		return err
+	}
+	defer fr.Close()
+
+	br := bufio.NewReader(fr)
+	magic, _ := br.Peek(len(zstdMagic))
+
+	var r io.Reader
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil
+		}
+		defer gr.Close()
+		r = gr
+	case bytes.HasPrefix(magic, zstdMagic):
+		zr, err := zstd.NewReader(br, zstd.WithDecoderConcurrency(1), zstd.WithDecoderLowmem(true))
+		if err != nil {
+			return nil
+		}
+		defer zr.Close()
+		r = zr
+	default:
+		return nil
+	}
+
+	limit := limits.limit(desc.Size)
+	n, err := io.Copy(io.Discard, io.LimitReader(r, limit+1))
+	if n > limit {
+		dcontext.GetLoggerWithField(ctx, "digest", desc.Digest).
+			Warnf("rejecting blob of %d bytes decompressing to more than %d bytes", desc.Size, limit)
+		return distribution.ErrBlobDecompressedSizeExceeded{Size: desc.Size, Limit: limit}
+	}
+	if err != nil {
+		dcontext.GetLoggerWithField(ctx, "digest", desc.Digest).
+			Debugf("unable to decompress blob, skipping decompression limits: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/reference"
+	"github.com/klauspost/compress/zstd"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func gzipBytes(t *testing.T, p []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := zw.Write(p); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func zstdBytes(t *testing.T, p []byte) []byte {
+	t.Helper()
+	zw, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedBestCompression))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zw.Close()
+	return zw.EncodeAll(p, nil)
+}
+
+func TestBlobDecompressionLimits(t *testing.T) {
+	ctx := context.Background()
+	imageName, _ := reference.WithName("foo/bar")
+
+	// 16MiB of zeros compress more than a thousandfold
+	bomb := make([]byte, 16<<20)
+	text := bytes.Repeat([]byte("the quick brown fox jumps over the lazy dog\n"), 20)
+
+	for _, tc := range []struct {
+		name     string
+		content  []byte
+		maxRatio int64
+		maxSize  int64
+		rejected bool
+	}{
+		{name: "gzip bomb", content: gzipBytes(t, bomb), maxRatio: 100, rejected: true},
+		{name: "zstd bomb", content: zstdBytes(t, bomb), maxRatio: 100, rejected: true},
+		{name: "gzip bomb within limits", content: gzipBytes(t, bomb), maxRatio: 1 << 20},
+		{name: "gzip over absolute cap", content: gzipBytes(t, text), maxSize: 100, rejected: true},
+		{name: "zstd over absolute cap", content: zstdBytes(t, text), maxSize: 100, rejected: true},
+		{name: "gzip within limits", content: gzipBytes(t, text), maxRatio: 100, maxSize: 1 << 20},
+		{name: "zstd within limits", content: zstdBytes(t, text), maxRatio: 100, maxSize: 1 << 20},
+		{name: "uncompressed", content: bomb, maxRatio: 1, maxSize: 1},
+		{name: "disabled", content: gzipBytes(t, bomb)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			registry, err := NewRegistry(ctx, inmemory.New(), ValidateBlobDecompression(tc.maxRatio, tc.maxSize))
+			if err != nil {
+				t.Fatalf("error creating registry: %v", err)
+			}
+			repository, err := registry.Repository(ctx, imageName)
+			if err != nil {
+				t.Fatalf("unexpected error getting repo: %v", err)
+			}
+			bs := repository.Blobs(ctx)
+
+			dgst := digest.FromBytes(tc.content)
+			desc := v1.Descriptor{Digest: dgst, Size: int64(len(tc.content))}
+			_, err = addBlob(ctx, bs, desc, bytes.NewReader(tc.content))
+			if !tc.rejected {
+				if err != nil {
+					t.Fatalf("unexpected error uploading blob: %v", err)
+				}
+				return
+			}
+
+			var exceeded distribution.ErrBlobDecompressedSizeExceeded
+			if !errors.As(err, &exceeded) {
+				t.Fatalf("expected blob to be rejected, got %v", err)
+			}
+			if _, err := bs.Stat(ctx, dgst); err != distribution.ErrBlobUnknown {
+				t.Fatalf("expected rejected blob to be unknown, got %v", err)
+			}
+		})
+	}
+}
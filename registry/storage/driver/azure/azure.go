@@ -38,6 +38,9 @@ var ErrCorruptedData = errors.New("corrupted data found in the uploaded data")
 const (
 	driverName   = "azure"
 	maxChunkSize = 4 * 1024 * 1024
+
+	// maxBlobNameLength is the maximum length of a blob name.
+	maxBlobNameLength = 1024
 )
 
 type azureDriverFactory struct{}
@@ -103,6 +106,12 @@ func (d *driver) Name() string {
 	return driverName
 }
 
+// DriverLimits returns the blob name length limit, shortened by the root
+// directory.
+func (d *driver) DriverLimits() storagedriver.Limits {
+	return storagedriver.Limits{MaxPathLength: maxBlobNameLength - (len(d.blobName("/a")) - len("/a"))}
+}
+
 // GetContent retrieves the content stored at "path" as a []byte.
 func (d *driver) GetContent(ctx context.Context, path string) ([]byte, error) {
 	// TODO(milosgajdos): should we get a RetryReader here?
@@ -203,7 +212,10 @@ func (d *driver) Reader(ctx context.Context, path string, offset int64) (io.Read
 		return nil, fmt.Errorf("missing ContentLength: %s", path)
 	}
 	size := *props.ContentLength
-	if offset >= size {
+	if offset > size {
+		return nil, storagedriver.InvalidOffsetError{Path: path, Offset: offset}
+	}
+	if offset == size {
 		return io.NopCloser(bytes.NewReader(nil)), nil
 	}
 
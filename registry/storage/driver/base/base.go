@@ -39,28 +39,43 @@ package base
 
 import (
 	"context"
+	"errors"
 	"io"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/internal/servertiming"
 	prometheus "github.com/distribution/distribution/v3/metrics"
 	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
 	"github.com/distribution/distribution/v3/tracing"
 	"github.com/docker/go-metrics"
+	promclient "github.com/prometheus/client_golang/prometheus"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/trace"
 )
 
-// storageAction is the metrics of blob related operations
-var storageAction = prometheus.StorageNamespace.NewLabeledTimer("action", "The number of seconds that the storage action takes", "driver", "action")
+// storageAction is the metrics of blob related operations, observed with
+// their trace as exemplar if enabled
+var storageAction = promclient.NewHistogramVec(promclient.HistogramOpts{
+	Namespace: prometheus.NamespacePrefix,
+	Subsystem: "storage",
+	Name:      "action_seconds",
+	Help:      "The number of seconds that the storage action takes",
+	Buckets:   promclient.DefBuckets,
+}, []string{"driver", "action"})
+
+// storageErrors counts the failed storage actions by class of error
+var storageErrors = prometheus.StorageNamespace.NewLabeledCounter("errors", "The number of failed storage actions", "driver", "action", "class")
 
 // tracer is the OpenTelemetry tracer utilized for tracing operations within
 // this package's code.
 var tracer = otel.Tracer("github.com/distribution/distribution/v3/registry/storage/driver/base")
 
 func init() {
+	prometheus.StorageNamespace.Add(storageAction)
 	metrics.Register(prometheus.StorageNamespace)
 }
 
@@ -70,8 +85,17 @@ type Base struct {
 	storagedriver.StorageDriver
 }
 
-// Format errors received from the storage driver
-func (base *Base) setDriverName(e error) error {
+// Format errors received from the storage driver, counting them by class
+func (base *Base) setDriverName(action string, e error) error {
+	if e == nil {
+		return nil
+	}
+	storageErrors.WithValues(base.Name(), action, string(storagedriver.Classify(e))).Inc(1)
+	return base.formatError(action, e)
+}
+
+// formatError sets the driver name and action of e.
+func (base *Base) formatError(action string, e error) error {
 	switch actual := e.(type) {
 	case nil:
 		return nil
@@ -87,9 +111,13 @@ func (base *Base) setDriverName(e error) error {
 	case storagedriver.InvalidOffsetError:
 		actual.DriverName = base.StorageDriver.Name()
 		return actual
+	case storagedriver.InsufficientStorageError:
+		actual.DriverName = base.StorageDriver.Name()
+		return actual
 	default:
 		return storagedriver.Error{
 			DriverName: base.StorageDriver.Name(),
+			Action:     action,
 			Detail:     e,
 		}
 	}
@@ -114,8 +142,9 @@ func (base *Base) GetContent(ctx context.Context, path string) ([]byte, error) {
 
 	start := time.Now()
 	b, e := base.StorageDriver.GetContent(ctx, path)
-	storageAction.WithValues(base.Name(), "GetContent").UpdateSince(start)
-	return b, base.setDriverName(e)
+	prometheus.ObserveDuration(ctx, storageAction.WithLabelValues(base.Name(), "GetContent"), start)
+	servertiming.Since(ctx, "storage", start)
+	return b, base.setDriverName("GetContent", e)
 }
 
 // PutContent wraps PutContent of underlying storage driver.
@@ -137,8 +166,9 @@ func (base *Base) PutContent(ctx context.Context, path string, content []byte) e
 	}
 
 	start := time.Now()
-	err := base.setDriverName(base.StorageDriver.PutContent(ctx, path, content))
-	storageAction.WithValues(base.Name(), "PutContent").UpdateSince(start)
+	err := base.setDriverName("PutContent", base.StorageDriver.PutContent(ctx, path, content))
+	prometheus.ObserveDuration(ctx, storageAction.WithLabelValues(base.Name(), "PutContent"), start)
+	servertiming.Since(ctx, "storage", start)
 	return err
 }
 
@@ -164,8 +194,10 @@ func (base *Base) Reader(ctx context.Context, path string, offset int64) (io.Rea
 		return nil, storagedriver.InvalidPathError{Path: path, DriverName: base.StorageDriver.Name()}
 	}
 
+	start := time.Now()
 	rc, e := base.StorageDriver.Reader(ctx, path, offset)
-	return rc, base.setDriverName(e)
+	servertiming.Since(ctx, "storage", start)
+	return rc, base.setDriverName("Reader", e)
 }
 
 // Writer wraps Writer of underlying storage driver.
@@ -187,7 +219,7 @@ func (base *Base) Writer(ctx context.Context, path string, append bool) (storage
 	}
 
 	writer, e := base.StorageDriver.Writer(ctx, path, append)
-	return writer, base.setDriverName(e)
+	return writer, base.setDriverName("Writer", e)
 }
 
 // Stat wraps Stat of underlying storage driver.
@@ -209,8 +241,9 @@ func (base *Base) Stat(ctx context.Context, path string) (storagedriver.FileInfo
 
 	start := time.Now()
 	fi, e := base.StorageDriver.Stat(ctx, path)
-	storageAction.WithValues(base.Name(), "Stat").UpdateSince(start)
-	return fi, base.setDriverName(e)
+	prometheus.ObserveDuration(ctx, storageAction.WithLabelValues(base.Name(), "Stat"), start)
+	servertiming.Since(ctx, "storage", start)
+	return fi, base.setDriverName("Stat", e)
 }
 
 // List wraps List of underlying storage driver.
@@ -232,8 +265,45 @@ func (base *Base) List(ctx context.Context, path string) ([]string, error) {
 
 	start := time.Now()
 	str, e := base.StorageDriver.List(ctx, path)
-	storageAction.WithValues(base.Name(), "List").UpdateSince(start)
-	return str, base.setDriverName(e)
+	prometheus.ObserveDuration(ctx, storageAction.WithLabelValues(base.Name(), "List"), start)
+	servertiming.Since(ctx, "storage", start)
+	return str, base.setDriverName("List", e)
+}
+
+// ListPages wraps ListPages of underlying storage driver, falling back to
+// List if it does not list page by page.
+func (base *Base) ListPages(ctx context.Context, path string, f func(paths []string) error) error {
+	attrs := []attribute.KeyValue{
+		attribute.String(tracing.AttributePrefix+"storage.driver.name", base.Name()),
+		attribute.String(tracing.AttributePrefix+"storage.path", path),
+	}
+	ctx, span := tracer.Start(
+		ctx,
+		"ListPages",
+		trace.WithAttributes(attrs...))
+
+	defer span.End()
+
+	if !storagedriver.PathRegexp.MatchString(path) && path != "/" {
+		return storagedriver.InvalidPathError{Path: path, DriverName: base.StorageDriver.Name()}
+	}
+
+	var callback callbackError
+	start := time.Now()
+	e := storagedriver.ListPages(ctx, base.StorageDriver, path, func(paths []string) error {
+		return callback.record(f(paths))
+	})
+	prometheus.ObserveDuration(ctx, storageAction.WithLabelValues(base.Name(), "ListPages"), start)
+	servertiming.Since(ctx, "storage", start)
+	if callback.returned(e) {
+		return base.formatError("ListPages", e)
+	}
+	return base.setDriverName("ListPages", e)
+}
+
+// DriverLimits returns the limits of the underlying storage driver.
+func (base *Base) DriverLimits() storagedriver.Limits {
+	return storagedriver.LimitsOf(base.StorageDriver)
 }
 
 // Move wraps Move of underlying storage driver.
@@ -260,8 +330,9 @@ func (base *Base) Move(ctx context.Context, sourcePath string, destPath string)
 	}
 
 	start := time.Now()
-	err := base.setDriverName(base.StorageDriver.Move(ctx, sourcePath, destPath))
-	storageAction.WithValues(base.Name(), "Move").UpdateSince(start)
+	err := base.setDriverName("Move", base.StorageDriver.Move(ctx, sourcePath, destPath))
+	prometheus.ObserveDuration(ctx, storageAction.WithLabelValues(base.Name(), "Move"), start)
+	servertiming.Since(ctx, "storage", start)
 	return err
 }
 
@@ -283,8 +354,9 @@ func (base *Base) Delete(ctx context.Context, path string) error {
 	}
 
 	start := time.Now()
-	err := base.setDriverName(base.StorageDriver.Delete(ctx, path))
-	storageAction.WithValues(base.Name(), "Delete").UpdateSince(start)
+	err := base.setDriverName("Delete", base.StorageDriver.Delete(ctx, path))
+	prometheus.ObserveDuration(ctx, storageAction.WithLabelValues(base.Name(), "Delete"), start)
+	servertiming.Since(ctx, "storage", start)
 	return err
 }
 
@@ -307,8 +379,9 @@ func (base *Base) RedirectURL(r *http.Request, path string) (string, error) {
 
 	start := time.Now()
 	str, e := base.StorageDriver.RedirectURL(r.WithContext(ctx), path)
-	storageAction.WithValues(base.Name(), "RedirectURL").UpdateSince(start)
-	return str, base.setDriverName(e)
+	prometheus.ObserveDuration(ctx, storageAction.WithLabelValues(base.Name(), "RedirectURL"), start)
+	servertiming.Since(ctx, "storage", start)
+	return str, base.setDriverName("RedirectURL", e)
 }
 
 // Walk wraps Walk of underlying storage driver.
@@ -328,5 +401,39 @@ func (base *Base) Walk(ctx context.Context, path string, f storagedriver.WalkFn,
 		return storagedriver.InvalidPathError{Path: path, DriverName: base.StorageDriver.Name()}
 	}
 
-	return base.setDriverName(base.StorageDriver.Walk(ctx, path, f, options...))
+	var callback callbackError
+	e := base.StorageDriver.Walk(ctx, path, func(fileInfo storagedriver.FileInfo) error {
+		return callback.record(f(fileInfo))
+	}, options...)
+	if callback.returned(e) {
+		return base.formatError("Walk", e)
+	}
+	return base.setDriverName("Walk", e)
+}
+
+// callbackError records the first error returned by the function walking or
+// listing paths, so that it is not counted as a failure of the storage
+// driver.
+type callbackError struct {
+	mu  sync.Mutex
+	err error
+}
+
+func (c *callbackError) record(err error) error {
+	if err == nil || errors.Is(err, storagedriver.ErrSkipDir) {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err == nil {
+		c.err = err
+	}
+	return err
+}
+
+// returned reports whether e is the error returned by the function.
+func (c *callbackError) returned(e error) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return e != nil && c.err != nil && errors.Is(e, c.err)
 }
@@ -97,6 +97,11 @@ func (r *regulator) Name() string {
 	return r.StorageDriver.Name()
 }
 
+// DriverLimits returns the limits of the regulated storage driver.
+func (r *regulator) DriverLimits() storagedriver.Limits {
+	return storagedriver.LimitsOf(r.StorageDriver)
+}
+
 // GetContent retrieves the content stored at "path" as a []byte.
 // This should primarily be used for small objects.
 func (r *regulator) GetContent(ctx context.Context, path string) ([]byte, error) {
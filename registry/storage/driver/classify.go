@@ -0,0 +1,137 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"sync"
+	"syscall"
+)
+
+// ErrorClass categorizes the errors of storage drivers, so that failures can
+// be told apart in metrics and answered appropriately.
+type ErrorClass string
+
+const (
+	// ErrorClassNotFound is the class of the errors reporting a missing
+	// path.
+	ErrorClassNotFound ErrorClass = "not_found"
+
+	// ErrorClassThrottled is the class of the errors reporting the storage
+	// backend rejected a request because of its request rate.
+	ErrorClassThrottled ErrorClass = "throttled"
+
+	// ErrorClassPermissionDenied is the class of the errors reporting the
+	// registry is not allowed to access the storage backend.
+	ErrorClassPermissionDenied ErrorClass = "permission_denied"
+
+	// ErrorClassTransient is the class of the errors likely to succeed if
+	// retried, such as timeouts, dropped connections and server errors.
+	ErrorClassTransient ErrorClass = "transient"
+
+	// ErrorClassFatal is the class of every other error.
+	ErrorClassFatal ErrorClass = "fatal"
+)
+
+// ErrorClasses lists the classes of errors.
+var ErrorClasses = []ErrorClass{
+	ErrorClassNotFound,
+	ErrorClassThrottled,
+	ErrorClassPermissionDenied,
+	ErrorClassTransient,
+	ErrorClassFatal,
+}
+
+// ErrorClassifier returns the class of err, and whether it recognized err.
+type ErrorClassifier func(err error) (ErrorClass, bool)
+
+var classifiers struct {
+	sync.RWMutex
+	all []ErrorClassifier
+}
+
+// RegisterErrorClassifier registers a classifier of the errors of a storage
+// driver, typically those of the SDK of its backend, consulted by Classify
+// before the generic rules.
+func RegisterErrorClassifier(classifier ErrorClassifier) {
+	classifiers.Lock()
+	defer classifiers.Unlock()
+	classifiers.all = append(classifiers.all, classifier)
+}
+
+// Classify returns the class of err, which is ErrorClassFatal unless err, or
+// an error it wraps, is recognized. It returns the empty class for nil.
+func Classify(err error) ErrorClass {
+	if err == nil {
+		return ""
+	}
+
+	classifiers.RLock()
+	all := classifiers.all
+	classifiers.RUnlock()
+	for _, classifier := range all {
+		if class, ok := classifier(err); ok {
+			return class
+		}
+	}
+
+	if errors.As(err, new(PathNotFoundError)) || errors.Is(err, fs.ErrNotExist) {
+		return ErrorClassNotFound
+	}
+	if errors.Is(err, fs.ErrPermission) {
+		return ErrorClassPermissionDenied
+	}
+
+	// the errors of the AWS SDK, and of other SDKs alike, carry a code and
+	// the status of the failed request
+	var coded interface{ Code() string }
+	if errors.As(err, &coded) {
+		switch coded.Code() {
+		case "SlowDown", "Throttling", "ThrottlingException", "RequestLimitExceeded",
+			"TooManyRequests", "TooManyRequestsException", "RequestThrottled":
+			return ErrorClassThrottled
+		case "AccessDenied", "InvalidAccessKeyId", "SignatureDoesNotMatch",
+			"ExpiredToken", "InvalidToken", "AllAccessDisabled":
+			return ErrorClassPermissionDenied
+		case "RequestTimeout", "RequestTimeoutException", "InternalError", "ServiceUnavailable":
+			return ErrorClassTransient
+		}
+	}
+	var status interface{ StatusCode() int }
+	if errors.As(err, &status) {
+		if class, ok := ClassifyStatus(status.StatusCode()); ok {
+			return class
+		}
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) {
+		return ErrorClassTransient
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return ErrorClassTransient
+	}
+
+	return ErrorClassFatal
+}
+
+// ClassifyStatus returns the class of the errors reported by a storage
+// backend with the HTTP status code, and whether the status denotes an error
+// class.
+func ClassifyStatus(code int) (ErrorClass, bool) {
+	switch {
+	case code == http.StatusNotFound:
+		return ErrorClassNotFound, true
+	case code == http.StatusTooManyRequests || code == http.StatusServiceUnavailable:
+		return ErrorClassThrottled, true
+	case code == http.StatusUnauthorized || code == http.StatusForbidden:
+		return ErrorClassPermissionDenied, true
+	case code == http.StatusRequestTimeout || code >= http.StatusInternalServerError:
+		return ErrorClassTransient, true
+	}
+	return "", false
+}
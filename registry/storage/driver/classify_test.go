@@ -0,0 +1,91 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"syscall"
+	"testing"
+)
+
+// codedError mimics the errors of the SDKs carrying an error code and the
+// status of the failed request.
+type codedError struct {
+	code   string
+	status int
+}
+
+func (e codedError) Error() string   { return e.code }
+func (e codedError) Code() string    { return e.code }
+func (e codedError) StatusCode() int { return e.status }
+
+// statusError mimics the errors of the SDKs carrying only the status of the
+// failed request.
+type statusError int
+
+func (e statusError) Error() string   { return http.StatusText(int(e)) }
+func (e statusError) StatusCode() int { return int(e) }
+
+// timeoutError mimics a network timeout.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestClassify(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		err      error
+		expected ErrorClass
+	}{
+		{name: "nil", err: nil, expected: ""},
+		{name: "path not found", err: PathNotFoundError{Path: "/a", DriverName: "test"}, expected: ErrorClassNotFound},
+		{name: "file not found", err: &fs.PathError{Op: "open", Path: "/a", Err: fs.ErrNotExist}, expected: ErrorClassNotFound},
+		{name: "status not found", err: statusError(http.StatusNotFound), expected: ErrorClassNotFound},
+		{name: "slow down", err: codedError{code: "SlowDown", status: http.StatusServiceUnavailable}, expected: ErrorClassThrottled},
+		{name: "too many requests", err: statusError(http.StatusTooManyRequests), expected: ErrorClassThrottled},
+		{name: "status unavailable", err: statusError(http.StatusServiceUnavailable), expected: ErrorClassThrottled},
+		{name: "access denied", err: codedError{code: "AccessDenied", status: http.StatusForbidden}, expected: ErrorClassPermissionDenied},
+		{name: "status forbidden", err: statusError(http.StatusForbidden), expected: ErrorClassPermissionDenied},
+		{name: "file permission", err: &fs.PathError{Op: "open", Path: "/a", Err: fs.ErrPermission}, expected: ErrorClassPermissionDenied},
+		{name: "internal error", err: codedError{code: "InternalError", status: http.StatusInternalServerError}, expected: ErrorClassTransient},
+		{name: "status bad gateway", err: statusError(http.StatusBadGateway), expected: ErrorClassTransient},
+		{name: "deadline exceeded", err: context.DeadlineExceeded, expected: ErrorClassTransient},
+		{name: "unexpected eof", err: io.ErrUnexpectedEOF, expected: ErrorClassTransient},
+		{name: "connection reset", err: fmt.Errorf("read: %w", syscall.ECONNRESET), expected: ErrorClassTransient},
+		{name: "network timeout", err: timeoutError{}, expected: ErrorClassTransient},
+		{name: "unknown code", err: codedError{code: "InvalidArgument", status: http.StatusBadRequest}, expected: ErrorClassFatal},
+		{name: "unknown", err: errors.New("something broke"), expected: ErrorClassFatal},
+		{name: "wrapped", err: Error{DriverName: "test", Action: "Stat", Detail: fmt.Errorf("stat: %w", codedError{code: "SlowDown"})}, expected: ErrorClassThrottled},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if class := Classify(tc.err); class != tc.expected {
+				t.Fatalf("expected %q, got %q", tc.expected, class)
+			}
+		})
+	}
+}
+
+func TestClassifyStatus(t *testing.T) {
+	for status, expected := range map[int]ErrorClass{
+		http.StatusNotFound:            ErrorClassNotFound,
+		http.StatusTooManyRequests:     ErrorClassThrottled,
+		http.StatusServiceUnavailable:  ErrorClassThrottled,
+		http.StatusUnauthorized:        ErrorClassPermissionDenied,
+		http.StatusForbidden:           ErrorClassPermissionDenied,
+		http.StatusRequestTimeout:      ErrorClassTransient,
+		http.StatusInternalServerError: ErrorClassTransient,
+		http.StatusGatewayTimeout:      ErrorClassTransient,
+	} {
+		if class, ok := ClassifyStatus(status); !ok || class != expected {
+			t.Errorf("status %d: expected %q, got %q", status, expected, class)
+		}
+	}
+	if _, ok := ClassifyStatus(http.StatusBadRequest); ok {
+		t.Errorf("status %d: expected no class", http.StatusBadRequest)
+	}
+}
@@ -10,6 +10,8 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"runtime"
+	"slices"
 	"time"
 
 	"github.com/distribution/distribution/v3/internal/uuid"
@@ -27,6 +29,11 @@ const (
 	// parameter. If the driver's parameters are less than this we set
 	// the parameters to minThreads
 	minThreads = uint64(25)
+
+	// maxPathLength and maxNameLength are the PATH_MAX and NAME_MAX limits
+	// of the common filesystems.
+	maxPathLength = 4096
+	maxNameLength = 255
 )
 
 // DriverParameters represents all configuration options available for the
@@ -34,6 +41,11 @@ const (
 type DriverParameters struct {
 	RootDirectory string
 	MaxThreads    uint64
+	// MinFreeSpace is the free space that writes must leave on the
+	// filesystem. Writes are rejected once it is reached.
+	MinFreeSpace FreeSpace
+	// Packing configures the packing of small files.
+	Packing PackingParameters
 }
 
 func init() {
@@ -49,6 +61,9 @@ func (factory *filesystemDriverFactory) Create(ctx context.Context, parameters m
 
 type driver struct {
 	rootDirectory string
+	minFreeSpace  FreeSpace
+	// packs is nil unless packing is enabled or files are packed.
+	packs *packs
 }
 
 type baseEmbed struct {
@@ -59,12 +74,15 @@ type baseEmbed struct {
 // filesystem. All provided paths will be subpaths of the RootDirectory.
 type Driver struct {
 	baseEmbed
+	fs *driver
 }
 
 // FromParameters constructs a new Driver with a given parameters map
 // Optional Parameters:
 // - rootdirectory
 // - maxthreads
+// - minfreespace
+// - packing
 func FromParameters(parameters map[string]any) (*Driver, error) {
 	params, err := fromParametersImpl(parameters)
 	if err != nil || params == nil {
@@ -78,6 +96,8 @@ func fromParametersImpl(parameters map[string]any) (*DriverParameters, error) {
 		err           error
 		maxThreads    = defaultMaxThreads
 		rootDirectory = defaultRootDirectory
+		minFreeSpace  FreeSpace
+		packing       PackingParameters
 	)
 
 	if parameters != nil {
@@ -89,18 +109,37 @@ func fromParametersImpl(parameters map[string]any) (*DriverParameters, error) {
 		if err != nil {
 			return nil, fmt.Errorf("maxthreads config error: %s", err.Error())
 		}
+
+		minFreeSpace, err = parseFreeSpace(parameters["minfreespace"])
+		if err != nil {
+			return nil, fmt.Errorf("minfreespace config error: %s", err.Error())
+		}
+		if !minFreeSpace.IsZero() && !freeSpaceSupported {
+			return nil, fmt.Errorf("minfreespace config error: not supported on %s", runtime.GOOS)
+		}
+
+		packing, err = parsePackingParameters(parameters["packing"])
+		if err != nil {
+			return nil, fmt.Errorf("packing config error: %s", err.Error())
+		}
 	}
 
 	params := &DriverParameters{
 		RootDirectory: rootDirectory,
 		MaxThreads:    maxThreads,
+		MinFreeSpace:  minFreeSpace,
+		Packing:       packing,
 	}
 	return params, nil
 }
 
 // New constructs a new Driver with a given rootDirectory
 func New(params DriverParameters) *Driver {
-	fsDriver := &driver{rootDirectory: params.RootDirectory}
+	fsDriver := &driver{
+		rootDirectory: params.RootDirectory,
+		minFreeSpace:  params.MinFreeSpace,
+		packs:         newPacks(params.RootDirectory, params.Packing),
+	}
 
 	return &Driver{
 		baseEmbed: baseEmbed{
@@ -108,6 +147,7 @@ func New(params DriverParameters) *Driver {
 				StorageDriver: base.NewRegulator(fsDriver, params.MaxThreads),
 			},
 		},
+		fs: fsDriver,
 	}
 }
 
@@ -117,6 +157,15 @@ func (d *driver) Name() string {
 	return driverName
 }
 
+// DriverLimits returns the PATH_MAX and NAME_MAX limits, the former shortened
+// by the root directory.
+func (d *driver) DriverLimits() storagedriver.Limits {
+	return storagedriver.Limits{
+		MaxPathLength:      maxPathLength - (len(d.fullPath("/a")) - len("/a")),
+		MaxComponentLength: maxNameLength,
+	}
+}
+
 // GetContent retrieves the content stored at "path" as a []byte.
 func (d *driver) GetContent(ctx context.Context, path string) ([]byte, error) {
 	rc, err := d.Reader(ctx, path, 0)
@@ -192,12 +241,27 @@ func (d *driver) Reader(ctx context.Context, path string, offset int64) (io.Read
 	file, err := os.OpenFile(d.fullPath(path), os.O_RDONLY, 0o644)
 	if err != nil {
 		if os.IsNotExist(err) {
+			if d.packs != nil {
+				if rc, ok, err := d.packs.reader(path, offset); ok || err != nil {
+					return rc, err
+				}
+			}
 			return nil, storagedriver.PathNotFoundError{Path: path}
 		}
 
 		return nil, err
 	}
 
+	fi, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if offset > fi.Size() {
+		file.Close()
+		return nil, storagedriver.InvalidOffsetError{Path: path, Offset: offset}
+	}
+
 	seekPos, err := file.Seek(offset, io.SeekStart)
 	if err != nil {
 		file.Close()
@@ -210,6 +274,10 @@ func (d *driver) Reader(ctx context.Context, path string, offset int64) (io.Read
 	return file, nil
 }
 
+// Writer returns a FileWriter which will store the content written to it at
+// the location designated by "path". New files are rejected early if the
+// minimum free space is reached, and writes are rejected as soon as it is
+// reached, while appending to a file is allowed so that it can be cancelled.
 func (d *driver) Writer(ctx context.Context, subPath string, append bool) (storagedriver.FileWriter, error) {
 	fullPath := d.fullPath(subPath)
 	parentDir := filepath.Dir(fullPath)
@@ -217,6 +285,12 @@ func (d *driver) Writer(ctx context.Context, subPath string, append bool) (stora
 		return nil, err
 	}
 
+	if !append {
+		if err := d.checkFreeSpace(parentDir, subPath); err != nil {
+			return nil, err
+		}
+	}
+
 	fp, err := os.OpenFile(fullPath, os.O_WRONLY|os.O_CREATE, 0o666)
 	if err != nil {
 		return nil, err
@@ -239,7 +313,17 @@ func (d *driver) Writer(ctx context.Context, subPath string, append bool) (stora
 		offset = n
 	}
 
-	return newFileWriter(fp, offset), nil
+	fw := newFileWriter(fp, offset)
+	if !d.minFreeSpace.IsZero() {
+		fw.checkFreeSpace = func() error {
+			return d.checkFreeSpace(parentDir, subPath)
+		}
+		if append {
+			// check before the first write
+			fw.unchecked = freeSpaceCheckInterval
+		}
+	}
+	return fw, nil
 }
 
 // Stat retrieves the FileInfo for the given path, including the current size
@@ -250,6 +334,11 @@ func (d *driver) Stat(ctx context.Context, subPath string) (storagedriver.FileIn
 	fi, err := os.Stat(fullPath)
 	if err != nil {
 		if os.IsNotExist(err) {
+			if d.packs != nil {
+				if fi, ok, err := d.packs.stat(subPath); ok || err != nil {
+					return fi, err
+				}
+			}
 			return nil, storagedriver.PathNotFoundError{Path: subPath}
 		}
 
@@ -267,9 +356,20 @@ func (d *driver) Stat(ctx context.Context, subPath string) (storagedriver.FileIn
 func (d *driver) List(ctx context.Context, subPath string) ([]string, error) {
 	fullPath := d.fullPath(subPath)
 
+	var packed []string
+	if d.packs != nil {
+		var err error
+		if packed, err = d.packs.children(subPath); err != nil {
+			return nil, err
+		}
+	}
+
 	dir, err := os.Open(fullPath)
 	if err != nil {
 		if os.IsNotExist(err) {
+			if len(packed) > 0 {
+				return packed, nil
+			}
 			return nil, storagedriver.PathNotFoundError{Path: subPath}
 		}
 		return nil, err
@@ -282,10 +382,18 @@ func (d *driver) List(ctx context.Context, subPath string) ([]string, error) {
 		return nil, err
 	}
 
-	keys := make([]string, 0, len(fileNames))
+	keys := make([]string, 0, len(fileNames)+len(packed))
 	for _, fileName := range fileNames {
+		if fileName == packsDirectory && fullPath == filepath.Clean(d.rootDirectory) {
+			continue
+		}
 		keys = append(keys, filepath.Join(subPath, fileName))
 	}
+	for _, key := range packed {
+		if !slices.Contains(keys, key) {
+			keys = append(keys, key)
+		}
+	}
 
 	return keys, nil
 }
@@ -297,6 +405,11 @@ func (d *driver) Move(ctx context.Context, sourcePath string, destPath string) e
 	dest := d.fullPath(destPath)
 
 	if _, err := os.Stat(source); os.IsNotExist(err) {
+		if d.packs != nil {
+			if moved, err := d.packs.move(d.rootDirectory, sourcePath, destPath); moved || err != nil {
+				return err
+			}
+		}
 		return storagedriver.PathNotFoundError{Path: sourcePath}
 	}
 
@@ -304,8 +417,16 @@ func (d *driver) Move(ctx context.Context, sourcePath string, destPath string) e
 		return err
 	}
 
-	err := os.Rename(source, dest)
-	return err
+	if err := os.Rename(source, dest); err != nil {
+		return err
+	}
+	if d.packs != nil {
+		// files packed below a loose directory move with it
+		if _, err := d.packs.move(d.rootDirectory, sourcePath, destPath); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // Delete recursively deletes all objects stored at "path" and its subpaths.
@@ -315,12 +436,25 @@ func (d *driver) Delete(ctx context.Context, subPath string) error {
 	_, err := os.Stat(fullPath)
 	if err != nil && !os.IsNotExist(err) {
 		return err
-	} else if err != nil {
-		return storagedriver.PathNotFoundError{Path: subPath}
 	}
+	loose := err == nil
 
-	err = os.RemoveAll(fullPath)
-	return err
+	if loose {
+		if err := os.RemoveAll(fullPath); err != nil {
+			return err
+		}
+	}
+	if d.packs != nil {
+		if removed, err := d.packs.remove(subPath); err != nil {
+			return err
+		} else if removed {
+			return nil
+		}
+	}
+	if !loose {
+		return storagedriver.PathNotFoundError{Path: subPath}
+	}
+	return nil
 }
 
 // RedirectURL returns a URL which may be used to retrieve the content stored at the given path.
@@ -380,6 +514,11 @@ type fileWriter struct {
 	closed    bool
 	committed bool
 	cancelled bool
+
+	// checkFreeSpace, if set, is called every freeSpaceCheckInterval bytes
+	// written.
+	checkFreeSpace func() error
+	unchecked      int64
 }
 
 func newFileWriter(file *os.File, size int64) *fileWriter {
@@ -398,8 +537,15 @@ func (fw *fileWriter) Write(p []byte) (int, error) {
 	} else if fw.cancelled {
 		return 0, fmt.Errorf("already cancelled")
 	}
+	if fw.checkFreeSpace != nil && fw.unchecked >= freeSpaceCheckInterval {
+		if err := fw.checkFreeSpace(); err != nil {
+			return 0, err
+		}
+		fw.unchecked = 0
+	}
 	n, err := fw.bw.Write(p)
 	fw.size += int64(n)
+	fw.unchecked += int64(n)
 	return n, err
 }
 
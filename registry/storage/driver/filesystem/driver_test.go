@@ -1,8 +1,17 @@
 package filesystem
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
 	"reflect"
+	"sort"
 	"testing"
+	"time"
 
 	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
 	"github.com/distribution/distribution/v3/registry/storage/driver/testsuites"
@@ -80,6 +89,102 @@ func TestFromParametersImpl(t *testing.T) {
 			},
 			pass: true,
 		},
+		{
+			params: map[string]any{
+				"minfreespace": 1024,
+			},
+			expected: DriverParameters{
+				RootDirectory: defaultRootDirectory,
+				MaxThreads:    defaultMaxThreads,
+				MinFreeSpace:  FreeSpace{Bytes: 1024},
+			},
+			pass: true,
+		},
+		{
+			params: map[string]any{
+				"minfreespace": "10GB",
+			},
+			expected: DriverParameters{
+				RootDirectory: defaultRootDirectory,
+				MaxThreads:    defaultMaxThreads,
+				MinFreeSpace:  FreeSpace{Bytes: 10e9},
+			},
+			pass: true,
+		},
+		{
+			params: map[string]any{
+				"minfreespace": "512 MiB",
+			},
+			expected: DriverParameters{
+				RootDirectory: defaultRootDirectory,
+				MaxThreads:    defaultMaxThreads,
+				MinFreeSpace:  FreeSpace{Bytes: 512 << 20},
+			},
+			pass: true,
+		},
+		{
+			params: map[string]any{
+				"minfreespace": "5.5%",
+			},
+			expected: DriverParameters{
+				RootDirectory: defaultRootDirectory,
+				MaxThreads:    defaultMaxThreads,
+				MinFreeSpace:  FreeSpace{Percent: 5.5},
+			},
+			pass: true,
+		},
+		{
+			params: map[string]any{
+				"minfreespace": "100%",
+			},
+			pass: false,
+		},
+		{
+			params: map[string]any{
+				"minfreespace": "10XB",
+			},
+			pass: false,
+		},
+		{
+			params: map[string]any{
+				"minfreespace": -1,
+			},
+			pass: false,
+		},
+		{
+			params: map[string]any{
+				"packing": map[any]any{"enabled": true},
+			},
+			expected: DriverParameters{
+				RootDirectory: defaultRootDirectory,
+				MaxThreads:    defaultMaxThreads,
+				Packing:       PackingParameters{Enabled: true, MaxFileSize: defaultPackMaxFileSize, PackSize: defaultPackSize},
+			},
+			pass: true,
+		},
+		{
+			params: map[string]any{
+				"packing": map[string]any{"enabled": "true", "maxfilesize": "16KiB", "packsize": 1 << 20},
+			},
+			expected: DriverParameters{
+				RootDirectory: defaultRootDirectory,
+				MaxThreads:    defaultMaxThreads,
+				Packing:       PackingParameters{Enabled: true, MaxFileSize: 16 << 10, PackSize: 1 << 20},
+			},
+			pass: true,
+		},
+		{
+			params: map[string]any{
+				"packing": map[string]any{"enabled": true, "maxfilesize": "1MiB", "packsize": "64KiB"},
+			},
+			pass: false,
+		},
+		{
+			params: map[string]any{
+				"packing": true,
+			},
+			pass: false,
+		},
 	}
 
 	for _, item := range tests {
@@ -102,3 +207,202 @@ func TestFromParametersImpl(t *testing.T) {
 		}
 	}
 }
+
+func TestMinFreeSpace(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+
+	unconstrained, err := FromParameters(map[string]any{
+		"rootdirectory": root,
+		"minfreespace":  "1B",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := unconstrained.PutContent(ctx, "/existing", []byte("content")); err != nil {
+		t.Fatalf("unexpected error writing with free space left: %v", err)
+	}
+
+	// no filesystem has that much free space
+	constrained, err := FromParameters(map[string]any{
+		"rootdirectory": root,
+		"minfreespace":  "4000000TB",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkErr := func(err error) {
+		t.Helper()
+		var isErr storagedriver.InsufficientStorageError
+		if !errors.As(err, &isErr) {
+			t.Fatalf("expected an insufficient storage error, got %v", err)
+		}
+		if isErr.DriverName != driverName {
+			t.Errorf("unexpected driver name %q", isErr.DriverName)
+		}
+	}
+
+	checkErr(constrained.PutContent(ctx, "/new", []byte("content")))
+	if _, err := constrained.Stat(ctx, "/new"); !errors.As(err, new(storagedriver.PathNotFoundError)) {
+		t.Errorf("rejected content was written: %v", err)
+	}
+	_, err = constrained.Writer(ctx, "/new", false)
+	checkErr(err)
+
+	// appending to a file is allowed, but not writing to it
+	writer, err := constrained.Writer(ctx, "/existing", true)
+	if err != nil {
+		t.Fatalf("unexpected error opening file for append: %v", err)
+	}
+	_, err = writer.Write([]byte("more content"))
+	checkErr(err)
+	if err := writer.Cancel(ctx); err != nil {
+		t.Fatalf("unexpected error cancelling write: %v", err)
+	}
+}
+
+func TestPacking(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	parameters := map[string]any{
+		"rootdirectory": root,
+		"packing":       map[string]any{"enabled": true, "maxfilesize": "1KiB", "packsize": "4KiB"},
+	}
+	d, err := FromParameters(parameters)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	contents := make(map[string][]byte)
+	var paths []string
+	for i := 0; i < 20; i++ {
+		path := fmt.Sprintf("/blobs/%02d/data", i)
+		contents[path] = bytes.Repeat([]byte{byte(i)}, 300+i)
+		paths = append(paths, path)
+	}
+	contents["/blobs/large/data"] = bytes.Repeat([]byte("large"), 1024)
+	paths = append(paths, "/blobs/large/data", "/blobs/missing/data")
+	modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	for path, content := range contents {
+		if err := d.PutContent(ctx, path, content); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(filepath.Join(root, path), modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	checkContents := func(d storagedriver.StorageDriver) {
+		t.Helper()
+		for path, content := range contents {
+			p, err := d.GetContent(ctx, path)
+			if err != nil || !bytes.Equal(p, content) {
+				t.Fatalf("unexpected content of %s: %v", path, err)
+			}
+			rc, err := d.Reader(ctx, path, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			p, err = io.ReadAll(rc)
+			rc.Close()
+			if err != nil || !bytes.Equal(p, content[10:]) {
+				t.Fatalf("unexpected content of %s from offset: %v", path, err)
+			}
+			fi, err := d.Stat(ctx, path)
+			if err != nil || fi.IsDir() || fi.Size() != int64(len(content)) || !fi.ModTime().Equal(modTime) {
+				t.Fatalf("unexpected info of %s: %+v, %v", path, fi, err)
+			}
+		}
+
+		var walked []string
+		err := d.Walk(ctx, "/", func(fi storagedriver.FileInfo) error {
+			if !fi.IsDir() {
+				walked = append(walked, fi.Path())
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := make([]string, 0, len(contents))
+		for path := range contents {
+			expected = append(expected, path)
+		}
+		sort.Strings(expected)
+		if !reflect.DeepEqual(walked, expected) {
+			t.Fatalf("unexpected files walked: %v", walked)
+		}
+	}
+
+	n, err := d.Pack(ctx, paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 20 {
+		t.Fatalf("unexpected number of files packed: %d", n)
+	}
+	if _, err := os.Stat(filepath.Join(root, "/blobs/00")); !os.IsNotExist(err) {
+		t.Fatalf("loose file left after packing: %v", err)
+	}
+	packs, _ := filepath.Glob(filepath.Join(root, packsDirectory, "*.pack"))
+	if len(packs) < 2 {
+		t.Fatalf("unexpected number of pack files: %d", len(packs))
+	}
+	checkContents(d)
+	if _, err := d.Reader(ctx, "/blobs/00/data", 1000); !errors.As(err, new(storagedriver.InvalidOffsetError)) {
+		t.Fatalf("expected an invalid offset error, got %v", err)
+	}
+	if n, err := d.Pack(ctx, paths); err != nil || n != 0 {
+		t.Fatalf("unexpected repacking: %d, %v", n, err)
+	}
+
+	// another driver, as a registry serving the files, sees the changes
+	other, err := FromParameters(map[string]any{"rootdirectory": root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkContents(other)
+	if _, err := other.Pack(ctx, paths); err == nil {
+		t.Fatal("expected an error packing with packing disabled")
+	}
+	for i := 0; i < 10; i++ {
+		path := fmt.Sprintf("/blobs/%02d", i)
+		if err := other.Delete(ctx, path); err != nil {
+			t.Fatal(err)
+		}
+		delete(contents, path+"/data")
+	}
+	if err := other.Delete(ctx, "/blobs/00"); !errors.As(err, new(storagedriver.PathNotFoundError)) {
+		t.Fatalf("expected a path not found error, got %v", err)
+	}
+	if err := d.Move(ctx, "/blobs/15/data", "/moved/data"); err != nil {
+		t.Fatal(err)
+	}
+	contents["/moved/data"] = contents["/blobs/15/data"]
+	delete(contents, "/blobs/15/data")
+	checkContents(d)
+	checkContents(other)
+
+	reclaimed, err := d.Compact(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reclaimed == 0 {
+		t.Fatal("no space reclaimed by compaction")
+	}
+	checkContents(other)
+
+	n, err = other.Unpack(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 9 {
+		t.Fatalf("unexpected number of files unpacked: %d", n)
+	}
+	if _, err := os.Stat(filepath.Join(root, packsDirectory)); !os.IsNotExist(err) {
+		t.Fatalf("pack files left after unpacking: %v", err)
+	}
+	checkContents(d)
+	checkContents(New(DriverParameters{RootDirectory: root, MaxThreads: minThreads}))
+}
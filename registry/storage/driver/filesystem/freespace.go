@@ -0,0 +1,129 @@
+package filesystem
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+)
+
+// freeSpaceCheckInterval is the number of bytes written by a fileWriter
+// between two checks of the free space.
+const freeSpaceCheckInterval = 64 << 20
+
+// FreeSpace is a minimum amount of free space, either absolute or relative to
+// the size of the filesystem.
+type FreeSpace struct {
+	// Bytes is the minimum number of free bytes.
+	Bytes uint64
+	// Percent is the minimum percentage of free space.
+	Percent float64
+}
+
+// IsZero returns true if no minimum free space is set.
+func (fs FreeSpace) IsZero() bool {
+	return fs.Bytes == 0 && fs.Percent == 0
+}
+
+func (fs FreeSpace) String() string {
+	if fs.Percent != 0 {
+		return strconv.FormatFloat(fs.Percent, 'f', -1, 64) + "%"
+	}
+	return strconv.FormatUint(fs.Bytes, 10) + " bytes"
+}
+
+var sizeUnits = []struct {
+	suffix string
+	bytes  uint64
+}{
+	// longest suffixes first
+	{"KiB", 1 << 10},
+	{"MiB", 1 << 20},
+	{"GiB", 1 << 30},
+	{"TiB", 1 << 40},
+	{"KB", 1e3},
+	{"MB", 1e6},
+	{"GB", 1e9},
+	{"TB", 1e12},
+	{"B", 1},
+}
+
+// parseFreeSpace parses the minfreespace parameter, either a number of bytes,
+// a size with a unit such as "10GB" or "512MiB", or a percentage such as
+// "5%".
+func parseFreeSpace(param any) (FreeSpace, error) {
+	var s string
+	switch v := param.(type) {
+	case nil:
+		return FreeSpace{}, nil
+	case int:
+		if v < 0 {
+			return FreeSpace{}, fmt.Errorf("invalid minimum free space: %d", v)
+		}
+		return FreeSpace{Bytes: uint64(v)}, nil
+	case int64:
+		if v < 0 {
+			return FreeSpace{}, fmt.Errorf("invalid minimum free space: %d", v)
+		}
+		return FreeSpace{Bytes: uint64(v)}, nil
+	case uint64:
+		return FreeSpace{Bytes: v}, nil
+	case string:
+		s = strings.TrimSpace(v)
+	default:
+		return FreeSpace{}, fmt.Errorf("invalid minimum free space type: %T", param)
+	}
+
+	if percent, ok := strings.CutSuffix(s, "%"); ok {
+		p, err := strconv.ParseFloat(strings.TrimSpace(percent), 64)
+		if err != nil || p < 0 || p >= 100 {
+			return FreeSpace{}, fmt.Errorf("invalid minimum free space percentage: %q", s)
+		}
+		return FreeSpace{Percent: p}, nil
+	}
+
+	n, ok := parseSize(s)
+	if !ok {
+		return FreeSpace{}, fmt.Errorf("invalid minimum free space: %q", s)
+	}
+	return FreeSpace{Bytes: n}, nil
+}
+
+// parseSize parses a number of bytes, or a size with a unit such as "10GB" or
+// "512MiB".
+func parseSize(s string) (uint64, bool) {
+	number, multiplier := s, uint64(1)
+	for _, unit := range sizeUnits {
+		if n, ok := strings.CutSuffix(s, unit.suffix); ok {
+			number, multiplier = strings.TrimSpace(n), unit.bytes
+			break
+		}
+	}
+	n, err := strconv.ParseUint(number, 10, 64)
+	if err != nil || n > ^uint64(0)/multiplier {
+		return 0, false
+	}
+	return n * multiplier, true
+}
+
+// checkFreeSpace returns an InsufficientStorageError if writing subPath would
+// leave less than the minimum free space on the filesystem of dir.
+func (d *driver) checkFreeSpace(dir, subPath string) error {
+	if d.minFreeSpace.IsZero() {
+		return nil
+	}
+
+	free, total, err := diskSpace(dir)
+	if err != nil {
+		return fmt.Errorf("failed to check free space: %w", err)
+	}
+	if free >= d.minFreeSpace.Bytes && float64(free) >= d.minFreeSpace.Percent/100*float64(total) {
+		return nil
+	}
+	return storagedriver.InsufficientStorageError{
+		Path:       subPath,
+		DriverName: driverName,
+		Detail:     fmt.Sprintf("%d bytes free, minimum %s", free, d.minFreeSpace),
+	}
+}
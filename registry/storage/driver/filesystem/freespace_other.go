@@ -0,0 +1,14 @@
+//go:build !linux && !darwin && !freebsd && !windows
+
+package filesystem
+
+import (
+	"errors"
+	"runtime"
+)
+
+const freeSpaceSupported = false
+
+func diskSpace(path string) (free, total uint64, err error) {
+	return 0, 0, errors.New("free space check not supported on " + runtime.GOOS)
+}
@@ -0,0 +1,17 @@
+//go:build linux || darwin || freebsd
+
+package filesystem
+
+import "golang.org/x/sys/unix"
+
+const freeSpaceSupported = true
+
+// diskSpace returns the space available to unprivileged users and the total
+// size of the filesystem of path, in bytes.
+func diskSpace(path string) (free, total uint64, err error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return 0, 0, err
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), uint64(st.Blocks) * uint64(st.Bsize), nil
+}
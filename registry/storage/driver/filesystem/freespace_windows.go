@@ -0,0 +1,18 @@
+package filesystem
+
+import "golang.org/x/sys/windows"
+
+const freeSpaceSupported = true
+
+// diskSpace returns the space available to the caller and the total size of
+// the filesystem of path, in bytes.
+func diskSpace(path string) (free, total uint64, err error) {
+	p, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := windows.GetDiskFreeSpaceEx(p, &free, &total, nil); err != nil {
+		return 0, 0, err
+	}
+	return free, total, nil
+}
@@ -0,0 +1,795 @@
+package filesystem
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/distribution/distribution/v3/internal/uuid"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+)
+
+const (
+	// packsDirectory is the directory of the root directory holding the pack
+	// files and their indexes.
+	packsDirectory = "_packs"
+
+	defaultPackMaxFileSize = 64 << 10
+	defaultPackSize        = 64 << 20
+
+	// compactThreshold is the fraction of a pack file which must be taken
+	// by deleted files for Compact to rewrite it.
+	compactThreshold = 0.5
+)
+
+// PackingParameters configures the packing of small files into larger pack
+// files, which spares the inodes and directory entries of the small files.
+type PackingParameters struct {
+	// Enabled allows packing files with Pack. Files already packed are
+	// served whether packing is enabled or not.
+	Enabled bool
+	// MaxFileSize is the size of the largest files packed.
+	MaxFileSize int64
+	// PackSize is the size pack files are filled up to.
+	PackSize int64
+}
+
+// parsePackingParameters parses the packing parameter, a map of the enabled,
+// maxfilesize and packsize options.
+func parsePackingParameters(param any) (PackingParameters, error) {
+	var options map[string]any
+	switch v := param.(type) {
+	case nil:
+		return PackingParameters{}, nil
+	case map[string]any:
+		options = v
+	case map[any]any:
+		options = make(map[string]any, len(v))
+		for key, value := range v {
+			options[fmt.Sprint(key)] = value
+		}
+	default:
+		return PackingParameters{}, fmt.Errorf("invalid packing type: %T", param)
+	}
+
+	params := PackingParameters{
+		MaxFileSize: defaultPackMaxFileSize,
+		PackSize:    defaultPackSize,
+	}
+	switch v := options["enabled"].(type) {
+	case nil:
+	case bool:
+		params.Enabled = v
+	case string:
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return PackingParameters{}, fmt.Errorf("invalid enabled: %q", v)
+		}
+		params.Enabled = enabled
+	default:
+		return PackingParameters{}, fmt.Errorf("invalid enabled type: %T", v)
+	}
+	for name, size := range map[string]*int64{"maxfilesize": &params.MaxFileSize, "packsize": &params.PackSize} {
+		if options[name] == nil {
+			continue
+		}
+		n, ok := parseSize(strings.TrimSpace(fmt.Sprint(options[name])))
+		if !ok || n == 0 || n > 1<<62 {
+			return PackingParameters{}, fmt.Errorf("invalid %s: %v", name, options[name])
+		}
+		*size = int64(n)
+	}
+	if params.MaxFileSize > params.PackSize {
+		return PackingParameters{}, fmt.Errorf("maxfilesize %d is larger than packsize %d", params.MaxFileSize, params.PackSize)
+	}
+	return params, nil
+}
+
+// packEntry locates a packed file in its pack file.
+type packEntry struct {
+	Path    string    `json:"path"`
+	Offset  int64     `json:"offset"`
+	Length  int64     `json:"length"`
+	ModTime time.Time `json:"modtime"`
+
+	// pack is the name of the pack file holding the entry.
+	pack string
+}
+
+// packIndex is the content of the index of a pack file, stored next to it.
+type packIndex struct {
+	Entries []packEntry `json:"entries"`
+}
+
+// packs indexes the files packed in the pack files of a root directory. The
+// index is reloaded when the pack files change, as they are written by the
+// maintenance commands while registries serve them.
+type packs struct {
+	dir    string
+	params PackingParameters
+
+	mu      sync.Mutex
+	modTime time.Time
+	entries map[string]packEntry
+	// paths are the sorted paths of the entries, or nil if they changed
+	// since they were sorted.
+	paths []string
+	// sizes are the sizes of the pack files by name.
+	sizes map[string]int64
+}
+
+// newPacks returns the packs of rootDirectory, or nil if packing is disabled
+// and no file is packed.
+func newPacks(rootDirectory string, params PackingParameters) *packs {
+	dir := filepath.Join(rootDirectory, packsDirectory)
+	if !params.Enabled {
+		if _, err := os.Stat(dir); err != nil {
+			return nil
+		}
+	}
+	return &packs{dir: dir, params: params}
+}
+
+// refreshLocked reloads the indexes if the pack files changed.
+func (p *packs) refreshLocked() error {
+	fi, err := os.Stat(p.dir)
+	if os.IsNotExist(err) {
+		p.modTime, p.entries, p.paths, p.sizes = time.Time{}, nil, nil, nil
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if p.entries != nil && fi.ModTime().Equal(p.modTime) {
+		return nil
+	}
+
+	names, err := filepath.Glob(filepath.Join(p.dir, "*.idx"))
+	if err != nil {
+		return err
+	}
+	entries := make(map[string]packEntry)
+	sizes := make(map[string]int64)
+	for _, name := range names {
+		pack := strings.TrimSuffix(filepath.Base(name), ".idx")
+		index, size, err := p.readIndex(pack)
+		if os.IsNotExist(err) {
+			// removed since listed
+			continue
+		} else if err != nil {
+			return err
+		}
+		sizes[pack] = size
+		for _, entry := range index.Entries {
+			// a path is in two packs while a pack is being compacted
+			if _, ok := entries[entry.Path]; !ok {
+				entry.pack = pack
+				entries[entry.Path] = entry
+			}
+		}
+	}
+	p.modTime, p.entries, p.paths, p.sizes = fi.ModTime(), entries, nil, sizes
+	return nil
+}
+
+// touchLocked records the pack files as unchanged after the changes made by
+// the driver, so that they are not reloaded.
+func (p *packs) touchLocked() {
+	if fi, err := os.Stat(p.dir); err == nil {
+		p.modTime = fi.ModTime()
+	}
+}
+
+// readIndex reads the index of pack and the size of the pack file.
+func (p *packs) readIndex(pack string) (packIndex, int64, error) {
+	var index packIndex
+	data, err := os.ReadFile(filepath.Join(p.dir, pack+".idx"))
+	if err != nil {
+		return index, 0, err
+	}
+	if err := json.Unmarshal(data, &index); err != nil {
+		return index, 0, fmt.Errorf("invalid index of pack %s: %w", pack, err)
+	}
+	fi, err := os.Stat(filepath.Join(p.dir, pack+".pack"))
+	if err != nil {
+		return index, 0, err
+	}
+	return index, fi.Size(), nil
+}
+
+// writeIndexLocked writes the index of the entries of pack, or removes the
+// pack file if it holds no entry anymore.
+func (p *packs) writeIndexLocked(pack string) error {
+	var index packIndex
+	for _, entry := range p.entries {
+		if entry.pack == pack {
+			index.Entries = append(index.Entries, entry)
+		}
+	}
+	if len(index.Entries) == 0 {
+		return p.removePackLocked(pack)
+	}
+	sort.Slice(index.Entries, func(i, j int) bool { return index.Entries[i].Offset < index.Entries[j].Offset })
+	data, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(p.dir, pack+".idx"), data, time.Time{})
+}
+
+// removePackLocked removes a pack file and its index.
+func (p *packs) removePackLocked(pack string) error {
+	// the index goes first, so that the pack file is never indexed while
+	// missing
+	if err := os.Remove(filepath.Join(p.dir, pack+".idx")); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Remove(filepath.Join(p.dir, pack+".pack")); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	delete(p.sizes, pack)
+	return nil
+}
+
+// sortedPathsLocked returns the sorted paths of the entries.
+func (p *packs) sortedPathsLocked() []string {
+	if p.paths == nil {
+		p.paths = make([]string, 0, len(p.entries))
+		for path := range p.entries {
+			p.paths = append(p.paths, path)
+		}
+		sort.Strings(p.paths)
+	}
+	return p.paths
+}
+
+// underLocked returns the sorted paths of the entries below dir.
+func (p *packs) underLocked(dir string) []string {
+	prefix := dirPrefix(dir)
+	paths := p.sortedPathsLocked()
+	start := sort.SearchStrings(paths, prefix)
+	end := start
+	for end < len(paths) && strings.HasPrefix(paths[end], prefix) {
+		end++
+	}
+	return paths[start:end]
+}
+
+// dirPrefix returns the prefix of the paths below dir.
+func dirPrefix(dir string) string {
+	if dir == "/" {
+		return dir
+	}
+	return dir + "/"
+}
+
+// stat returns the info of the file packed at path, or of the directory of
+// the files packed below it.
+func (p *packs) stat(path string) (storagedriver.FileInfo, bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.refreshLocked(); err != nil {
+		return nil, false, err
+	}
+	if entry, ok := p.entries[path]; ok {
+		return storagedriver.FileInfoInternal{FileInfoFields: storagedriver.FileInfoFields{
+			Path:    path,
+			Size:    entry.Length,
+			ModTime: entry.ModTime,
+		}}, true, nil
+	}
+	if len(p.underLocked(path)) > 0 {
+		return storagedriver.FileInfoInternal{FileInfoFields: storagedriver.FileInfoFields{
+			Path:  path,
+			IsDir: true,
+		}}, true, nil
+	}
+	return nil, false, nil
+}
+
+// children returns the paths of the direct descendants of dir holding packed
+// files.
+func (p *packs) children(dir string) ([]string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.refreshLocked(); err != nil {
+		return nil, err
+	}
+	prefix := dirPrefix(dir)
+	var children []string
+	for _, path := range p.underLocked(dir) {
+		child, _, _ := strings.Cut(strings.TrimPrefix(path, prefix), "/")
+		child = prefix + child
+		if len(children) == 0 || children[len(children)-1] != child {
+			children = append(children, child)
+		}
+	}
+	return children, nil
+}
+
+// reader returns a reader of the file packed at path from offset.
+func (p *packs) reader(path string, offset int64) (io.ReadCloser, bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// the pack file may be removed by a compaction since the index was read
+	for retry := true; ; retry = false {
+		if err := p.refreshLocked(); err != nil {
+			return nil, false, err
+		}
+		entry, ok := p.entries[path]
+		if !ok {
+			return nil, false, nil
+		}
+		if offset > entry.Length {
+			return nil, true, storagedriver.InvalidOffsetError{Path: path, Offset: offset}
+		}
+		file, err := os.Open(filepath.Join(p.dir, entry.pack+".pack"))
+		if os.IsNotExist(err) && retry {
+			p.entries = nil
+			continue
+		} else if err != nil {
+			return nil, true, err
+		}
+		return packedReader{
+			SectionReader: io.NewSectionReader(file, entry.Offset+offset, entry.Length-offset),
+			file:          file,
+		}, true, nil
+	}
+}
+
+// packedReader reads a packed file.
+type packedReader struct {
+	*io.SectionReader
+	file *os.File
+}
+
+func (r packedReader) Close() error {
+	return r.file.Close()
+}
+
+// readLocked reads the content of a packed file.
+func (p *packs) readLocked(entry packEntry) ([]byte, error) {
+	file, err := os.Open(filepath.Join(p.dir, entry.pack+".pack"))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	content := make([]byte, entry.Length)
+	if _, err := file.ReadAt(content, entry.Offset); err != nil {
+		return nil, err
+	}
+	return content, nil
+}
+
+// remove removes the file packed at path, or the files packed below it, and
+// returns whether any file was removed.
+func (p *packs) remove(path string) (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.refreshLocked(); err != nil {
+		return false, err
+	}
+	return p.removeLocked(path)
+}
+
+func (p *packs) removeLocked(path string) (bool, error) {
+	paths := append([]string{}, p.underLocked(path)...)
+	if _, ok := p.entries[path]; ok {
+		paths = append(paths, path)
+	}
+	if len(paths) == 0 {
+		return false, nil
+	}
+
+	changed := make(map[string]struct{})
+	for _, path := range paths {
+		changed[p.entries[path].pack] = struct{}{}
+		delete(p.entries, path)
+	}
+	p.paths = nil
+	defer p.touchLocked()
+	for pack := range changed {
+		if err := p.writeIndexLocked(pack); err != nil {
+			return true, err
+		}
+	}
+	return true, nil
+}
+
+// move extracts the file packed at source, or the files packed below it, to
+// loose files at dest under rootDirectory, unless already present, and
+// removes them from the pack files. It returns whether any file was moved.
+func (p *packs) move(rootDirectory, source, dest string) (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.refreshLocked(); err != nil {
+		return false, err
+	}
+	paths := append([]string{}, p.underLocked(source)...)
+	if _, ok := p.entries[source]; ok {
+		paths = append(paths, source)
+	}
+	if len(paths) == 0 {
+		return false, nil
+	}
+
+	for _, path := range paths {
+		target := filepath.Join(rootDirectory, dest, strings.TrimPrefix(path, source))
+		if err := p.extractLocked(p.entries[path], target); err != nil {
+			return true, err
+		}
+	}
+	_, err := p.removeLocked(source)
+	return true, err
+}
+
+// extractLocked writes a packed file to the loose file target, unless it
+// exists.
+func (p *packs) extractLocked(entry packEntry, target string) error {
+	if _, err := os.Stat(target); err == nil {
+		return nil
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	content, err := p.readLocked(entry)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0o777); err != nil {
+		return err
+	}
+	return writeFileAtomic(target, content, entry.ModTime)
+}
+
+// packWriter writes a new pack file.
+type packWriter struct {
+	dir     string
+	name    string
+	file    *os.File
+	size    int64
+	entries []packEntry
+}
+
+func newPackWriter(dir string) (*packWriter, error) {
+	if err := os.MkdirAll(dir, 0o777); err != nil {
+		return nil, err
+	}
+	name := uuid.NewString()
+	file, err := os.OpenFile(filepath.Join(dir, name+".pack.tmp"), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o666)
+	if err != nil {
+		return nil, err
+	}
+	return &packWriter{dir: dir, name: name, file: file}, nil
+}
+
+// add appends a file to the pack file.
+func (w *packWriter) add(path string, content []byte, modTime time.Time) error {
+	if _, err := w.file.Write(content); err != nil {
+		return err
+	}
+	w.entries = append(w.entries, packEntry{
+		Path:    path,
+		Offset:  w.size,
+		Length:  int64(len(content)),
+		ModTime: modTime,
+		pack:    w.name,
+	})
+	w.size += int64(len(content))
+	return nil
+}
+
+// commit makes the pack file and its index visible.
+func (w *packWriter) commit() error {
+	if err := w.file.Sync(); err != nil {
+		w.cancel()
+		return err
+	}
+	if err := w.file.Close(); err != nil {
+		w.cancel()
+		return err
+	}
+	if err := os.Rename(w.file.Name(), filepath.Join(w.dir, w.name+".pack")); err != nil {
+		w.cancel()
+		return err
+	}
+	data, err := json.Marshal(packIndex{Entries: w.entries})
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(filepath.Join(w.dir, w.name+".idx"), data, time.Time{}); err != nil {
+		_ = os.Remove(filepath.Join(w.dir, w.name+".pack"))
+		return err
+	}
+	return syncDir(w.dir)
+}
+
+// cancel removes the pack file.
+func (w *packWriter) cancel() {
+	_ = w.file.Close()
+	_ = os.Remove(w.file.Name())
+}
+
+// addLocked indexes the entries of a committed pack file.
+func (p *packs) addLocked(w *packWriter) {
+	if p.entries == nil {
+		p.entries = make(map[string]packEntry)
+	}
+	if p.sizes == nil {
+		p.sizes = make(map[string]int64)
+	}
+	for _, entry := range w.entries {
+		p.entries[entry.Path] = entry
+	}
+	p.sizes[w.name] = w.size
+	p.paths = nil
+	p.touchLocked()
+}
+
+// writeFileAtomic writes content to name through a temporary file, setting
+// its modification time if not zero.
+func writeFileAtomic(name string, content []byte, modTime time.Time) error {
+	tempName := fmt.Sprintf("%s.%s.tmp", name, uuid.NewString())
+	file, err := os.OpenFile(tempName, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o666)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(content)
+	if err == nil {
+		err = file.Sync()
+	}
+	if cErr := file.Close(); err == nil {
+		err = cErr
+	}
+	if err == nil && !modTime.IsZero() {
+		err = os.Chtimes(tempName, modTime, modTime)
+	}
+	if err == nil {
+		err = os.Rename(tempName, name)
+	}
+	if err != nil {
+		_ = os.Remove(tempName)
+	}
+	return err
+}
+
+// errPackingDisabled is returned by Pack if packing is not enabled.
+var errPackingDisabled = errors.New("filesystem: packing is not enabled")
+
+// Pack packs the files at paths no larger than the maximum file size into
+// pack files, and removes the loose files and the directories left empty.
+// Paths of directories, missing files and files already packed are skipped.
+// It returns the number of files packed.
+//
+// Like garbage collection, Pack must not run while the files are written.
+func (d *Driver) Pack(ctx context.Context, paths []string) (int, error) {
+	p := d.fs.packs
+	if p == nil || !p.params.Enabled {
+		return 0, errPackingDisabled
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.refreshLocked(); err != nil {
+		return 0, err
+	}
+
+	var (
+		w      *packWriter
+		packed []string
+		count  int
+	)
+	flush := func() error {
+		if w == nil {
+			return nil
+		}
+		if err := w.commit(); err != nil {
+			return err
+		}
+		p.addLocked(w)
+		w = nil
+		for _, path := range packed {
+			if err := d.fs.removeLoose(path); err != nil {
+				return err
+			}
+		}
+		count += len(packed)
+		packed = packed[:0]
+		return nil
+	}
+
+	for _, path := range paths {
+		if err := ctx.Err(); err != nil {
+			if w != nil {
+				w.cancel()
+			}
+			return count, err
+		}
+		if _, ok := p.entries[path]; ok {
+			continue
+		}
+		fullPath := d.fs.fullPath(path)
+		fi, err := os.Lstat(fullPath)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return count, err
+		}
+		if !fi.Mode().IsRegular() || fi.Size() > p.params.MaxFileSize {
+			continue
+		}
+		content, err := os.ReadFile(fullPath)
+		if err != nil {
+			return count, err
+		}
+
+		if w != nil && w.size+int64(len(content)) > p.params.PackSize {
+			if err := flush(); err != nil {
+				return count, err
+			}
+		}
+		if w == nil {
+			if w, err = newPackWriter(p.dir); err != nil {
+				return count, err
+			}
+		}
+		if err := w.add(path, content, fi.ModTime()); err != nil {
+			w.cancel()
+			return count, err
+		}
+		packed = append(packed, path)
+	}
+	err := flush()
+	return count, err
+}
+
+// removeLoose removes the loose file at path and the parent directories it
+// leaves empty.
+func (d *driver) removeLoose(path string) error {
+	fullPath := d.fullPath(path)
+	if err := os.Remove(fullPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	root := filepath.Clean(d.rootDirectory)
+	for dir := filepath.Dir(fullPath); dir != root && strings.HasPrefix(dir, root); dir = filepath.Dir(dir) {
+		// fails once a directory is not empty
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+// Unpack writes every packed file back to a loose file, unless a loose file
+// already exists at its path, and removes the pack files. It returns the
+// number of files unpacked.
+//
+// Like garbage collection, Unpack must not run while the files are written.
+func (d *Driver) Unpack(ctx context.Context) (int, error) {
+	p := d.fs.packs
+	if p == nil {
+		return 0, nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.refreshLocked(); err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, path := range p.sortedPathsLocked() {
+		if err := ctx.Err(); err != nil {
+			return count, err
+		}
+		if err := p.extractLocked(p.entries[path], d.fs.fullPath(path)); err != nil {
+			return count, err
+		}
+		count++
+	}
+	if err := os.RemoveAll(p.dir); err != nil {
+		return count, err
+	}
+	p.modTime, p.entries, p.paths, p.sizes = time.Time{}, nil, nil, nil
+	return count, nil
+}
+
+// Compact rewrites the pack files of which deleted files take at least half
+// of the space, removes the pack files left behind by interrupted writes,
+// and returns the number of bytes reclaimed.
+//
+// Like garbage collection, Compact must not run while the files are written.
+func (d *Driver) Compact(ctx context.Context) (int64, error) {
+	p := d.fs.packs
+	if p == nil {
+		return 0, nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.refreshLocked(); err != nil {
+		return 0, err
+	}
+	var reclaimed int64
+
+	// pack files of which the index was not written, and temporary files
+	names, err := os.ReadDir(p.dir)
+	if err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+	for _, name := range names {
+		if pack, ok := strings.CutSuffix(name.Name(), ".pack"); ok {
+			if _, indexed := p.sizes[pack]; indexed {
+				continue
+			}
+		} else if !strings.HasSuffix(name.Name(), ".tmp") {
+			continue
+		}
+		if info, err := name.Info(); err == nil {
+			reclaimed += info.Size()
+		}
+		if err := os.Remove(filepath.Join(p.dir, name.Name())); err != nil && !os.IsNotExist(err) {
+			return reclaimed, err
+		}
+	}
+
+	live := make(map[string]int64)
+	byPack := make(map[string][]packEntry)
+	for _, entry := range p.entries {
+		live[entry.pack] += entry.Length
+		byPack[entry.pack] = append(byPack[entry.pack], entry)
+	}
+	sizes := make(map[string]int64, len(p.sizes))
+	for pack, size := range p.sizes {
+		sizes[pack] = size
+	}
+	for pack, size := range sizes {
+		if err := ctx.Err(); err != nil {
+			return reclaimed, err
+		}
+		if float64(size-live[pack]) < compactThreshold*float64(size) {
+			continue
+		}
+
+		entries := byPack[pack]
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Offset < entries[j].Offset })
+		if len(entries) > 0 {
+			w, err := newPackWriter(p.dir)
+			if err != nil {
+				return reclaimed, err
+			}
+			for _, entry := range entries {
+				content, err := p.readLocked(entry)
+				if err == nil {
+					err = w.add(entry.Path, content, entry.ModTime)
+				}
+				if err != nil {
+					w.cancel()
+					return reclaimed, err
+				}
+			}
+			if err := w.commit(); err != nil {
+				return reclaimed, err
+			}
+			p.addLocked(w)
+		}
+		if err := p.removePackLocked(pack); err != nil {
+			return reclaimed, err
+		}
+		reclaimed += size - live[pack]
+	}
+	p.touchLocked()
+	return reclaimed, nil
+}
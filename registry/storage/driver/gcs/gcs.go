@@ -56,6 +56,10 @@ const (
 	blobContentType          = "application/octet-stream"
 
 	maxTries = 5
+
+	// maxObjectNameLength is the maximum length, in bytes, of an object
+	// name.
+	maxObjectNameLength = 1024
 )
 
 var rangeHeader = regexp.MustCompile(`^bytes=([0-9])+-([0-9]+)$`)
@@ -72,6 +76,10 @@ type driverParameters struct {
 	chunkSize     int
 	gcs           *storage.Client
 
+	// parallelism is the number of chunks of a blob uploaded concurrently.
+	// Above 1, chunks are uploaded as separate objects composed on commit.
+	parallelism int
+
 	// maxConcurrency limits the number of concurrent driver operations
 	// to GCS, which ultimately increases reliability of many simultaneous
 	// pushes by ensuring we aren't DoSing our own server with many
@@ -102,6 +110,7 @@ type driver struct {
 	privateKey    []byte
 	rootDirectory string
 	chunkSize     int
+	parallelism   int
 }
 
 // Wrapper wraps `driver` with a throttler, ensuring that no more than N
@@ -153,6 +162,26 @@ func FromParameters(ctx context.Context, parameters map[string]any) (storagedriv
 		}
 	}
 
+	parallelism := defaultParallelism
+	if parallelismParam, ok := parameters["parallelism"]; ok {
+		switch v := parallelismParam.(type) {
+		case string:
+			vv, err := strconv.Atoi(v)
+			if err != nil {
+				return nil, fmt.Errorf("parallelism must be an integer, %v invalid", parallelismParam)
+			}
+			parallelism = vv
+		case int, uint, int32, uint32, uint64, int64:
+			parallelism = int(reflect.ValueOf(v).Convert(reflect.TypeFor[int]()).Int())
+		default:
+			return nil, fmt.Errorf("invalid value for parallelism: %#v", parallelismParam)
+		}
+
+		if parallelism < 1 {
+			return nil, fmt.Errorf("parallelism %d must be at least 1", parallelism)
+		}
+	}
+
 	var ts oauth2.TokenSource
 	jwtConf := new(jwt.Config)
 	var err error
@@ -229,6 +258,7 @@ func FromParameters(ctx context.Context, parameters map[string]any) (storagedriv
 		privateKey:     jwtConf.PrivateKey,
 		client:         oauth2.NewClient(ctx, ts),
 		chunkSize:      chunkSize,
+		parallelism:    parallelism,
 		maxConcurrency: maxConcurrency,
 		gcs:            gcs,
 	}
@@ -245,6 +275,11 @@ func New(ctx context.Context, params driverParameters) (storagedriver.StorageDri
 	if params.chunkSize <= 0 || params.chunkSize%minChunkSize != 0 {
 		return nil, fmt.Errorf("invalid chunksize: %d is not a positive multiple of %d", params.chunkSize, minChunkSize)
 	}
+	if params.parallelism == 0 {
+		params.parallelism = defaultParallelism
+	} else if params.parallelism < 0 {
+		return nil, fmt.Errorf("invalid parallelism: %d is not positive", params.parallelism)
+	}
 	d := &driver{
 		bucket:        params.gcs.Bucket(params.bucket),
 		rootDirectory: rootDirectory,
@@ -252,6 +287,7 @@ func New(ctx context.Context, params driverParameters) (storagedriver.StorageDri
 		privateKey:    params.privateKey,
 		client:        params.client,
 		chunkSize:     params.chunkSize,
+		parallelism:   params.parallelism,
 	}
 
 	return &Wrapper{
@@ -269,6 +305,12 @@ func (d *driver) Name() string {
 	return driverName
 }
 
+// DriverLimits returns the object name length limit, shortened by the root
+// directory.
+func (d *driver) DriverLimits() storagedriver.Limits {
+	return storagedriver.Limits{MaxPathLength: maxObjectNameLength - (len(d.pathToKey("/a")) - len("/a"))}
+}
+
 // GetContent retrieves the content stored at "path" as a []byte.
 // This should primarily be used for small objects.
 func (d *driver) GetContent(ctx context.Context, path string) ([]byte, error) {
@@ -306,25 +348,41 @@ func (d *driver) Reader(ctx context.Context, path string, offset int64) (io.Read
 	// NOTE(milosgajdos): If length is negative, the object is read until the end
 	// See: https://pkg.go.dev/cloud.google.com/go/storage#ObjectHandle.NewRangeReader
 	r, err := obj.NewRangeReader(ctx, offset, -1)
+	if isRangeNotSatisfiable(err) {
+		var attrs *storage.ObjectAttrs
+		attrs, err = obj.Attrs(ctx)
+		if err != nil {
+			if err == storage.ErrObjectNotExist {
+				return nil, storagedriver.PathNotFoundError{Path: path}
+			}
+			return nil, err
+		}
+		switch {
+		case offset == attrs.Size:
+			return io.NopCloser(bytes.NewReader([]byte{})), nil
+		case offset > attrs.Size:
+			return nil, storagedriver.InvalidOffsetError{Path: path, Offset: offset}
+		}
+		// The object grew between the range read and the attribute lookup,
+		// most likely due to a racing write. Retry the read once.
+		r, err = obj.NewRangeReader(ctx, offset, -1)
+		if isRangeNotSatisfiable(err) {
+			if r != nil {
+				r.Close()
+			}
+			return nil, storagedriver.InvalidOffsetError{Path: path, Offset: offset}
+		}
+	}
 	if err != nil {
+		if r != nil {
+			r.Close()
+		}
 		if err == storage.ErrObjectNotExist {
 			return nil, storagedriver.PathNotFoundError{Path: path}
 		}
 		var status *googleapi.Error
-		if errors.As(err, &status) {
-			switch status.Code {
-			case http.StatusNotFound:
-				return nil, storagedriver.PathNotFoundError{Path: path}
-			case http.StatusRequestedRangeNotSatisfiable:
-				attrs, err := obj.Attrs(ctx)
-				if err != nil {
-					return nil, err
-				}
-				if offset == attrs.Size {
-					return io.NopCloser(bytes.NewReader([]byte{})), nil
-				}
-				return nil, storagedriver.InvalidOffsetError{Path: path, Offset: offset}
-			}
+		if errors.As(err, &status) && status.Code == http.StatusNotFound {
+			return nil, storagedriver.PathNotFoundError{Path: path}
 		}
 		return nil, err
 	}
@@ -335,13 +393,35 @@ func (d *driver) Reader(ctx context.Context, path string, offset int64) (io.Read
 	return r, nil
 }
 
+// isRangeNotSatisfiable returns true if err reports that the requested range
+// lies outside of the object.
+func isRangeNotSatisfiable(err error) bool {
+	var status *googleapi.Error
+	return errors.As(err, &status) && status.Code == http.StatusRequestedRangeNotSatisfiable
+}
+
 // Writer returns a FileWriter which will store the content written to it
 // at the location designated by "path" after the call to Commit.
 func (d *driver) Writer(ctx context.Context, path string, appendMode bool) (storagedriver.FileWriter, error) {
+	key := d.pathToKey(path)
+	if appendMode {
+		// resume the upload in the mode it was started, regardless of the
+		// current parallelism
+		pw, err := resumeParallelWriter(ctx, bucketStore{driver: d}, key, d.chunkSize, d.parallelism)
+		if err != nil {
+			return nil, err
+		}
+		if pw != nil {
+			return pw, nil
+		}
+	} else if d.parallelism > 1 {
+		return newParallelWriter(ctx, bucketStore{driver: d}, key, d.chunkSize, d.parallelism), nil
+	}
+
 	w := &writer{
 		ctx:    ctx,
 		driver: d,
-		object: d.bucket.Object(d.pathToKey(path)),
+		object: d.bucket.Object(key),
 		buffer: make([]byte, d.chunkSize),
 	}
 
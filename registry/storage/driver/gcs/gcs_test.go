@@ -3,7 +3,13 @@ package gcs
 import (
 	"context"
 	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
 
 	"cloud.google.com/go/storage"
@@ -306,3 +312,51 @@ func TestMoveDirectory(t *testing.T) {
 		t.Fatal("Moving directory /parent/dir /parent/other should have return a non-nil error")
 	}
 }
+
+// TestReaderRetry checks that a range read rejected because the object grew
+// after the request is retried, and that the retried read is returned.
+func TestReaderRetry(t *testing.T) {
+	const content = "0123456789"
+
+	var reads atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/b/bucket/o/") {
+			// attributes, reporting the grown object
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"bucket":"bucket","name":"blob","size":"%d"}`, len(content))
+			return
+		}
+		if reads.Add(1) == 1 {
+			// the object is still empty
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes 4-%d/%d", len(content)-1, len(content)))
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)-4))
+		w.WriteHeader(http.StatusPartialContent)
+		io.WriteString(w, content[4:])
+	}))
+	defer server.Close()
+
+	gcs, err := storage.NewClient(context.Background(), option.WithEndpoint(server.URL+"/storage/v1/"), option.WithoutAuthentication())
+	if err != nil {
+		t.Fatalf("unexpected error creating client: %v", err)
+	}
+	d := &driver{bucket: gcs.Bucket("bucket")}
+
+	r, err := d.Reader(context.Background(), "/blob", 4)
+	if err != nil {
+		t.Fatalf("unexpected error reading: %v", err)
+	}
+	defer r.Close()
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("unexpected error reading content: %v", err)
+	}
+	if string(got) != content[4:] {
+		t.Fatalf("unexpected content %q", got)
+	}
+	if n := reads.Load(); n != 2 {
+		t.Fatalf("expected 2 range reads, got %d", n)
+	}
+}
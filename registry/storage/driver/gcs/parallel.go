@@ -0,0 +1,352 @@
+package gcs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+
+	"cloud.google.com/go/storage"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultParallelism = 1
+
+	// maxComposeSources is the maximum number of objects GCS accepts to
+	// compose in a single request.
+	maxComposeSources = 32
+)
+
+var _ storagedriver.FileWriter = &parallelWriter{}
+
+// objectStore is the subset of GCS operations used by parallelWriter.
+type objectStore interface {
+	// put stores content as the object name.
+	put(ctx context.Context, name string, content []byte, contentType string, metadata map[string]string) error
+	// attrs returns the content type and metadata of the object name.
+	attrs(ctx context.Context, name string) (string, map[string]string, error)
+	// get returns the content of the object name.
+	get(ctx context.Context, name string) ([]byte, error)
+	// compose concatenates srcs, in order, into the object dst and returns
+	// its size.
+	compose(ctx context.Context, dst string, srcs []string, contentType string) (int64, error)
+	// delete removes the object name, succeeding if it does not exist.
+	delete(ctx context.Context, name string) error
+}
+
+// bucketStore implements objectStore on the bucket of a driver.
+type bucketStore struct {
+	driver *driver
+}
+
+func (s bucketStore) put(ctx context.Context, name string, content []byte, contentType string, metadata map[string]string) error {
+	return retry(func() error {
+		return s.driver.putContent(ctx, s.driver.bucket.Object(name), content, contentType, metadata)
+	})
+}
+
+func (s bucketStore) attrs(ctx context.Context, name string) (string, map[string]string, error) {
+	attrs, err := s.driver.bucket.Object(name).Attrs(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	return attrs.ContentType, attrs.Metadata, nil
+}
+
+func (s bucketStore) get(ctx context.Context, name string) ([]byte, error) {
+	r, err := s.driver.bucket.Object(name).NewReader(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+func (s bucketStore) compose(ctx context.Context, dst string, srcs []string, contentType string) (int64, error) {
+	objects := make([]*storage.ObjectHandle, len(srcs))
+	for i, src := range srcs {
+		objects[i] = s.driver.bucket.Object(src)
+	}
+	composer := s.driver.bucket.Object(dst).ComposerFrom(objects...)
+	composer.ContentType = contentType
+
+	var size int64
+	err := retry(func() error {
+		attrs, err := composer.Run(ctx)
+		if err != nil {
+			return err
+		}
+		size = attrs.Size
+		return nil
+	})
+	return size, err
+}
+
+func (s bucketStore) delete(ctx context.Context, name string) error {
+	err := s.driver.bucket.Object(name).Delete(ctx)
+	if err == storage.ErrObjectNotExist {
+		return nil
+	}
+	return err
+}
+
+// parallelWriter uploads every chunk written to it as a separate object,
+// with up to parallelism uploads in flight, and composes them into the
+// final object on commit.
+//
+// Closing the writer persists the number of uploaded parts and the bytes
+// not yet forming a full chunk in the upload session object, from which
+// the upload can be resumed.
+type parallelWriter struct {
+	ctx       context.Context
+	store     objectStore
+	name      string
+	chunkSize int
+
+	// parts is the number of parts uploaded or being uploaded.
+	parts  int
+	buffer []byte
+	size   int64
+
+	closed    bool
+	cancelled bool
+	committed bool
+
+	// sem holds a token for every part being uploaded.
+	sem chan struct{}
+	wg  sync.WaitGroup
+	mu  sync.Mutex
+	err error
+}
+
+func newParallelWriter(ctx context.Context, store objectStore, name string, chunkSize, parallelism int) *parallelWriter {
+	return &parallelWriter{
+		ctx:       ctx,
+		store:     store,
+		name:      name,
+		chunkSize: chunkSize,
+		buffer:    make([]byte, 0, chunkSize),
+		sem:       make(chan struct{}, max(parallelism, 1)),
+	}
+}
+
+// resumeParallelWriter resumes the parallel upload to the object name. It
+// returns nil if the upload was not started by a parallel writer.
+func resumeParallelWriter(ctx context.Context, store objectStore, name string, chunkSize, parallelism int) (*parallelWriter, error) {
+	contentType, metadata, err := store.attrs(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != uploadSessionContentType || metadata["Parts"] == "" {
+		return nil, nil
+	}
+	parts, err := strconv.Atoi(metadata["Parts"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid number of parts in upload session %s: %v", name, err)
+	}
+	partSize, err := strconv.Atoi(metadata["Part-Size"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid part size in upload session %s: %v", name, err)
+	}
+	content, err := store.get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	// the parts already uploaded keep their size, even if the chunk size
+	// was changed since the upload started
+	w := newParallelWriter(ctx, store, name, partSize, parallelism)
+	w.parts = parts
+	w.buffer = append(w.buffer, content...)
+	w.size = int64(parts)*int64(partSize) + int64(len(content))
+	return w, nil
+}
+
+func (w *parallelWriter) partName(index int) string {
+	return fmt.Sprintf("%s.part-%08d", w.name, index)
+}
+
+func (w *parallelWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, fmt.Errorf("already closed")
+	} else if w.cancelled {
+		return 0, fmt.Errorf("already cancelled")
+	}
+
+	var written int
+	for written < len(p) {
+		n := copy(w.buffer[len(w.buffer):w.chunkSize], p[written:])
+		w.buffer = w.buffer[:len(w.buffer)+n]
+		written += n
+		w.size += int64(n)
+		if len(w.buffer) == w.chunkSize {
+			if err := w.uploadPart(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// uploadPart starts the upload of the buffer as the next part, blocking
+// while parallelism parts are already in flight. It reports the failure of
+// any previous upload.
+func (w *parallelWriter) uploadPart() error {
+	if err := w.uploadErr(); err != nil {
+		return err
+	}
+
+	index := w.parts
+	chunk := w.buffer
+	w.parts++
+	w.buffer = make([]byte, 0, w.chunkSize)
+
+	w.sem <- struct{}{}
+	w.wg.Add(1)
+	go func() {
+		defer func() {
+			<-w.sem
+			w.wg.Done()
+		}()
+		if err := w.store.put(w.ctx, w.partName(index), chunk, uploadSessionContentType, nil); err != nil {
+			w.mu.Lock()
+			if w.err == nil {
+				w.err = fmt.Errorf("uploading part %d of %s: %w", index, w.name, err)
+			}
+			w.mu.Unlock()
+		}
+	}()
+	return nil
+}
+
+func (w *parallelWriter) uploadErr() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+// wait waits for the parts in flight to be uploaded.
+func (w *parallelWriter) wait() error {
+	w.wg.Wait()
+	return w.uploadErr()
+}
+
+// Size returns the number of bytes written to this FileWriter.
+func (w *parallelWriter) Size() int64 {
+	return w.size
+}
+
+func (w *parallelWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	if err := w.wait(); err != nil {
+		return err
+	}
+
+	metadata := map[string]string{
+		"Parts":     strconv.Itoa(w.parts),
+		"Part-Size": strconv.Itoa(w.chunkSize),
+	}
+	return w.store.put(w.ctx, w.name, w.buffer, uploadSessionContentType, metadata)
+}
+
+// Cancel removes any written content from this FileWriter.
+func (w *parallelWriter) Cancel(ctx context.Context) error {
+	w.closed = true
+	w.cancelled = true
+
+	_ = w.wait()
+	w.deleteParts(ctx, w.parts)
+	return w.store.delete(ctx, w.name)
+}
+
+// Commit flushes all content written to this FileWriter and makes it
+// available for future calls to StorageDriver.GetContent and
+// StorageDriver.Reader.
+func (w *parallelWriter) Commit(ctx context.Context) error {
+	if w.closed {
+		return fmt.Errorf("already closed")
+	}
+	w.closed = true
+
+	if err := w.wait(); err != nil {
+		return err
+	}
+
+	// no part uploaded yet just perform a simple upload
+	if w.parts == 0 {
+		if err := w.store.put(ctx, w.name, w.buffer, blobContentType, nil); err != nil {
+			return err
+		}
+		w.committed = true
+		return nil
+	}
+
+	parts := w.parts
+	if len(w.buffer) > 0 {
+		if err := w.store.put(ctx, w.partName(parts), w.buffer, uploadSessionContentType, nil); err != nil {
+			return err
+		}
+		parts++
+	}
+
+	srcs := make([]string, parts)
+	for i := range srcs {
+		srcs[i] = w.partName(i)
+	}
+	size, err := w.compose(ctx, srcs)
+	if err != nil {
+		return err
+	}
+	if size != w.size {
+		return fmt.Errorf("composed %s has size %d, expected %d", w.name, size, w.size)
+	}
+	w.committed = true
+
+	w.deleteParts(ctx, parts)
+	return nil
+}
+
+// compose concatenates srcs into the object of the writer. As GCS limits
+// the number of objects composed at once, larger uploads are composed in
+// several rounds through intermediate objects.
+func (w *parallelWriter) compose(ctx context.Context, srcs []string) (int64, error) {
+	var intermediates []string
+	defer func() {
+		for _, name := range intermediates {
+			if err := w.store.delete(ctx, name); err != nil {
+				logrus.Infof("error deleting %v: %v", name, err)
+			}
+		}
+	}()
+
+	for round := 0; len(srcs) > maxComposeSources; round++ {
+		var next []string
+		for i := 0; i < len(srcs); i += maxComposeSources {
+			name := fmt.Sprintf("%s.composed-%d-%08d", w.name, round, len(next))
+			intermediates = append(intermediates, name)
+			if _, err := w.store.compose(ctx, name, srcs[i:min(i+maxComposeSources, len(srcs))], uploadSessionContentType); err != nil {
+				return 0, err
+			}
+			next = append(next, name)
+		}
+		srcs = next
+	}
+	return w.store.compose(ctx, w.name, srcs, blobContentType)
+}
+
+// deleteParts deletes the first n parts of the upload. Failures are only
+// logged, as leftover parts are removed along with the upload directory.
+func (w *parallelWriter) deleteParts(ctx context.Context, n int) {
+	for i := range n {
+		if err := w.store.delete(ctx, w.partName(i)); err != nil {
+			logrus.Infof("error deleting %v: %v", w.partName(i), err)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package gcs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+type fakeObject struct {
+	content     []byte
+	contentType string
+	metadata    map[string]string
+}
+
+// fakeStore is an in-memory objectStore recording the number of parts
+// uploaded concurrently.
+type fakeStore struct {
+	mu      sync.Mutex
+	objects map[string]fakeObject
+
+	inflight    int
+	maxInflight int
+	composed    [][]string
+
+	// failPut, if set, returns the error of the upload of the object name.
+	failPut func(name string) error
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{objects: make(map[string]fakeObject)}
+}
+
+func (s *fakeStore) put(ctx context.Context, name string, content []byte, contentType string, metadata map[string]string) error {
+	s.mu.Lock()
+	s.inflight++
+	s.maxInflight = max(s.maxInflight, s.inflight)
+	failPut := s.failPut
+	s.mu.Unlock()
+
+	time.Sleep(time.Millisecond)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inflight--
+	if failPut != nil {
+		if err := failPut(name); err != nil {
+			return err
+		}
+	}
+	s.objects[name] = fakeObject{content: bytes.Clone(content), contentType: contentType, metadata: metadata}
+	return nil
+}
+
+func (s *fakeStore) attrs(ctx context.Context, name string) (string, map[string]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj, ok := s.objects[name]
+	if !ok {
+		return "", nil, storage.ErrObjectNotExist
+	}
+	return obj.contentType, obj.metadata, nil
+}
+
+func (s *fakeStore) get(ctx context.Context, name string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj, ok := s.objects[name]
+	if !ok {
+		return nil, storage.ErrObjectNotExist
+	}
+	return obj.content, nil
+}
+
+func (s *fakeStore) compose(ctx context.Context, dst string, srcs []string, contentType string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(srcs) > maxComposeSources {
+		return 0, fmt.Errorf("too many sources: %d", len(srcs))
+	}
+	var content []byte
+	for _, src := range srcs {
+		obj, ok := s.objects[src]
+		if !ok {
+			return 0, storage.ErrObjectNotExist
+		}
+		content = append(content, obj.content...)
+	}
+	s.objects[dst] = fakeObject{content: content, contentType: contentType}
+	s.composed = append(s.composed, srcs)
+	return int64(len(content)), nil
+}
+
+func (s *fakeStore) delete(ctx context.Context, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.objects, name)
+	return nil
+}
+
+func (s *fakeStore) names() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var names []string
+	for name := range s.objects {
+		names = append(names, name)
+	}
+	return names
+}
+
+func checkCommitted(t *testing.T, store *fakeStore, name string, expected []byte) {
+	t.Helper()
+
+	obj, ok := store.objects[name]
+	if !ok {
+		t.Fatalf("%s was not committed", name)
+	}
+	if obj.contentType != blobContentType {
+		t.Errorf("unexpected content type %q", obj.contentType)
+	}
+	if !bytes.Equal(obj.content, expected) {
+		t.Errorf("unexpected content: got %d bytes, expected %d", len(obj.content), len(expected))
+	}
+	if names := store.names(); len(names) != 1 {
+		t.Errorf("expected parts to be deleted, got %v", names)
+	}
+}
+
+func TestParallelWriter(t *testing.T) {
+	const (
+		name        = "upload/data"
+		chunkSize   = 16
+		parallelism = 4
+	)
+	ctx := context.Background()
+
+	for _, size := range []int{0, chunkSize - 1, chunkSize, 5*chunkSize + 3, 40 * chunkSize, 32*32*chunkSize + 1} {
+		t.Run(fmt.Sprint(size), func(t *testing.T) {
+			store := newFakeStore()
+			content := make([]byte, size)
+			rand.Read(content)
+
+			w := newParallelWriter(ctx, store, name, chunkSize, parallelism)
+			// write in pieces not aligned on chunks
+			for written := 0; written < size; {
+				n, err := w.Write(content[written:min(written+7, size)])
+				if err != nil {
+					t.Fatalf("unexpected error writing: %v", err)
+				}
+				written += n
+			}
+			if w.Size() != int64(size) {
+				t.Fatalf("unexpected size %d, expected %d", w.Size(), size)
+			}
+			if err := w.Commit(ctx); err != nil {
+				t.Fatalf("unexpected error committing: %v", err)
+			}
+
+			checkCommitted(t, store, name, content)
+			for _, srcs := range store.composed {
+				if len(srcs) > maxComposeSources {
+					t.Errorf("composed %d objects at once", len(srcs))
+				}
+			}
+			if size >= 2*parallelism*chunkSize && store.maxInflight < 2 {
+				t.Errorf("expected parts to be uploaded concurrently")
+			}
+			if store.maxInflight > parallelism {
+				t.Errorf("%d parts uploaded concurrently, expected at most %d", store.maxInflight, parallelism)
+			}
+		})
+	}
+}
+
+func TestParallelWriterResume(t *testing.T) {
+	const (
+		name        = "upload/data"
+		chunkSize   = 16
+		parallelism = 4
+	)
+	ctx := context.Background()
+	store := newFakeStore()
+	content := make([]byte, 20*chunkSize+5)
+	rand.Read(content)
+
+	w := newParallelWriter(ctx, store, name, chunkSize, parallelism)
+	if _, err := w.Write(content[:7*chunkSize+3]); err != nil {
+		t.Fatalf("unexpected error writing: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected error closing: %v", err)
+	}
+
+	// interrupt the upload of the next parts
+	errUpload := errors.New("upload interrupted")
+	store.failPut = func(name string) error {
+		if strings.Contains(name, ".part-") {
+			return errUpload
+		}
+		return nil
+	}
+	w, err := resumeParallelWriter(ctx, store, name, chunkSize, parallelism)
+	if err != nil || w == nil {
+		t.Fatalf("unexpected error resuming: %v", err)
+	}
+	if w.Size() != 7*chunkSize+3 {
+		t.Fatalf("unexpected size %d after resuming", w.Size())
+	}
+	_, err = w.Write(content[7*chunkSize+3 : 15*chunkSize])
+	if err == nil {
+		err = w.Close()
+	}
+	if !errors.Is(err, errUpload) {
+		t.Fatalf("expected interrupted upload to fail, got %v", err)
+	}
+
+	// resume from the last state persisted, with a different chunk size
+	store.failPut = nil
+	w, err = resumeParallelWriter(ctx, store, name, 2*chunkSize, parallelism)
+	if err != nil || w == nil {
+		t.Fatalf("unexpected error resuming: %v", err)
+	}
+	if w.Size() != 7*chunkSize+3 {
+		t.Fatalf("unexpected size %d after resuming", w.Size())
+	}
+	if _, err := w.Write(content[7*chunkSize+3:]); err != nil {
+		t.Fatalf("unexpected error writing: %v", err)
+	}
+	if err := w.Commit(ctx); err != nil {
+		t.Fatalf("unexpected error committing: %v", err)
+	}
+	checkCommitted(t, store, name, content)
+}
+
+func TestParallelWriterCancel(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeStore()
+
+	w := newParallelWriter(ctx, store, "upload/data", 16, 4)
+	if _, err := w.Write(make([]byte, 100)); err != nil {
+		t.Fatalf("unexpected error writing: %v", err)
+	}
+	if err := w.Cancel(ctx); err != nil {
+		t.Fatalf("unexpected error cancelling: %v", err)
+	}
+	if names := store.names(); len(names) != 0 {
+		t.Errorf("expected upload to be deleted, got %v", names)
+	}
+}
+
+func TestResumeSequentialUpload(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeStore()
+	store.objects["upload/data"] = fakeObject{
+		contentType: uploadSessionContentType,
+		metadata:    map[string]string{"Session-URI": "https://example.com/session", "Offset": "0"},
+	}
+
+	w, err := resumeParallelWriter(ctx, store, "upload/data", 16, 4)
+	if err != nil {
+		t.Fatalf("unexpected error resuming: %v", err)
+	}
+	if w != nil {
+		t.Fatalf("expected sequential upload not to be resumed in parallel")
+	}
+}
@@ -0,0 +1,330 @@
+package hdfs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+)
+
+const (
+	fileTypeFile      = "FILE"
+	fileTypeDirectory = "DIRECTORY"
+
+	// Exceptions of the WebHDFS API the driver handles.
+	exceptionFileNotFound = "FileNotFoundException"
+	exceptionStandby      = "StandbyException"
+)
+
+// remoteError is an exception raised by the WebHDFS API.
+type remoteError struct {
+	StatusCode    int
+	Exception     string `json:"exception"`
+	JavaClassName string `json:"javaClassName"`
+	Message       string `json:"message"`
+}
+
+func (e *remoteError) Error() string {
+	return fmt.Sprintf("hdfs: %d %s: %s", e.StatusCode, e.Exception, e.Message)
+}
+
+func newRemoteError(resp *http.Response) error {
+	var body struct {
+		RemoteException remoteError `json:"RemoteException"`
+	}
+	if content, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10)); len(content) > 0 {
+		_ = json.Unmarshal(content, &body)
+	}
+	err := &body.RemoteException
+	err.StatusCode = resp.StatusCode
+	if err.Exception == "" {
+		err.Exception = http.StatusText(resp.StatusCode)
+	}
+	return err
+}
+
+// isException reports whether err is a remote error raising exception.
+func isException(err error, exception string) bool {
+	var remoteErr *remoteError
+	return errors.As(err, &remoteErr) && remoteErr.Exception == exception
+}
+
+// fileStatus is the status of a file or directory.
+type fileStatus struct {
+	// PathSuffix is the name of the file in the listings of its directory.
+	PathSuffix string `json:"pathSuffix"`
+	Type       string `json:"type"`
+	Length     int64  `json:"length"`
+	// ModificationTime is in milliseconds since the epoch.
+	ModificationTime int64 `json:"modificationTime"`
+}
+
+// client is a client of the WebHDFS REST API of an HDFS cluster.
+type client struct {
+	http *http.Client
+	// namenodes are the base URLs of the WebHDFS API of the namenodes of
+	// the cluster, several of them when it is highly available.
+	namenodes []*url.URL
+	// active is the index in namenodes of the namenode last found active,
+	// which requests are sent to first.
+	active atomic.Int32
+	user   string
+	// datanodes maps the addresses of the datanodes the namenodes redirect
+	// to, to the addresses they are reachable at.
+	datanodes map[string]string
+}
+
+// newClient returns a client of the cluster of namenodes, which does not
+// follow the redirects to datanodes on its own.
+func newClient(namenodes []*url.URL, user string, datanodes map[string]string) *client {
+	return &client{
+		http: &http.Client{
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		namenodes: namenodes,
+		user:      user,
+		datanodes: datanodes,
+	}
+}
+
+// request describes a request to the WebHDFS API.
+type request struct {
+	method string
+	path   string
+	op     string
+	query  url.Values
+	// body is sent to the datanode the namenode redirects to, if any.
+	body []byte
+}
+
+// do sends req to the active namenode, failing over to the other namenodes
+// when it is unreachable or in standby, and follows the redirect of the
+// namenode to a datanode. It returns the response if it succeeds with the
+// expected status code.
+func (c *client) do(ctx context.Context, req request, expected int) (*http.Response, error) {
+	query := url.Values{}
+	for k, v := range req.query {
+		query[k] = v
+	}
+	query.Set("op", req.op)
+	if c.user != "" {
+		query.Set("user.name", c.user)
+	}
+
+	active := int(c.active.Load())
+	var lastErr error
+	for i := range c.namenodes {
+		n := (active + i) % len(c.namenodes)
+		u := *c.namenodes[n]
+		u.Path += "/webhdfs/v1" + req.path
+		u.RawPath = ""
+		u.RawQuery = query.Encode()
+
+		resp, err := c.send(ctx, req.method, u.String(), nil)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			lastErr = err
+			continue
+		}
+		if resp.StatusCode == http.StatusTemporaryRedirect {
+			resp.Body.Close()
+			c.active.Store(int32(n))
+			return c.redirect(ctx, req, resp.Header.Get("Location"), expected)
+		}
+		if resp.StatusCode == expected {
+			c.active.Store(int32(n))
+			return resp, nil
+		}
+
+		err = newRemoteError(resp)
+		resp.Body.Close()
+		if !isException(err, exceptionStandby) {
+			c.active.Store(int32(n))
+			return nil, err
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// redirect sends req to the datanode at location.
+func (c *client) redirect(ctx context.Context, req request, location string, expected int) (*http.Response, error) {
+	u, err := c.datanodeURL(location)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.send(ctx, req.method, u, req.body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != expected {
+		defer resp.Body.Close()
+		return nil, newRemoteError(resp)
+	}
+	return resp, nil
+}
+
+// datanodeURL returns the URL location redirects to, at the address the
+// datanode is reachable at.
+func (c *client) datanodeURL(location string) (string, error) {
+	u, err := url.Parse(location)
+	if err != nil || u.Host == "" {
+		return "", fmt.Errorf("hdfs: invalid datanode location %q", location)
+	}
+	if address, ok := c.datanodes[u.Host]; ok {
+		u.Host = address
+	}
+	return u.String(), nil
+}
+
+func (c *client) send(ctx context.Context, method, u string, body []byte) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, method, u, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		httpReq.Header.Set("Content-Type", "application/octet-stream")
+	}
+	return c.http.Do(httpReq)
+}
+
+// doJSON sends req, decoding the response into v.
+func (c *client) doJSON(ctx context.Context, req request, v any) error {
+	resp, err := c.do(ctx, req, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// doBoolean sends req, returning the boolean result of the operation.
+func (c *client) doBoolean(ctx context.Context, req request) (bool, error) {
+	var result struct {
+		Boolean bool `json:"boolean"`
+	}
+	err := c.doJSON(ctx, req, &result)
+	return result.Boolean, err
+}
+
+func (c *client) getFileStatus(ctx context.Context, path string) (fileStatus, error) {
+	var result struct {
+		FileStatus fileStatus `json:"FileStatus"`
+	}
+	err := c.doJSON(ctx, request{method: http.MethodGet, path: path, op: "GETFILESTATUS"}, &result)
+	return result.FileStatus, err
+}
+
+func (c *client) listStatus(ctx context.Context, path string) ([]fileStatus, error) {
+	var result struct {
+		FileStatuses struct {
+			FileStatus []fileStatus `json:"FileStatus"`
+		} `json:"FileStatuses"`
+	}
+	err := c.doJSON(ctx, request{method: http.MethodGet, path: path, op: "LISTSTATUS"}, &result)
+	return result.FileStatuses.FileStatus, err
+}
+
+// create creates the file at path with content, replacing any file there and
+// creating its parent directories.
+func (c *client) create(ctx context.Context, path string, content []byte) error {
+	if content == nil {
+		content = []byte{}
+	}
+	resp, err := c.do(ctx, request{
+		method: http.MethodPut,
+		path:   path,
+		op:     "CREATE",
+		query:  url.Values{"overwrite": {"true"}},
+		body:   content,
+	}, http.StatusCreated)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// append appends content to the file at path.
+func (c *client) append(ctx context.Context, path string, content []byte) error {
+	resp, err := c.do(ctx, request{method: http.MethodPost, path: path, op: "APPEND", body: content}, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// open returns a reader of the file at path from offset.
+func (c *client) open(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
+	resp, err := c.do(ctx, request{
+		method: http.MethodGet,
+		path:   path,
+		op:     "OPEN",
+		query:  url.Values{"offset": {strconv.FormatInt(offset, 10)}},
+	}, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// openLocation returns the URL of the datanode serving the file at path, as
+// the namenode redirects to it.
+func (c *client) openLocation(ctx context.Context, path string) (string, error) {
+	var result struct {
+		Location string `json:"Location"`
+	}
+	err := c.doJSON(ctx, request{
+		method: http.MethodGet,
+		path:   path,
+		op:     "OPEN",
+		query:  url.Values{"noredirect": {"true"}},
+	}, &result)
+	if err == nil && result.Location == "" {
+		err = fmt.Errorf("hdfs: no datanode location to open %s", path)
+	}
+	return result.Location, err
+}
+
+func (c *client) mkdirs(ctx context.Context, path string) error {
+	ok, err := c.doBoolean(ctx, request{method: http.MethodPut, path: path, op: "MKDIRS"})
+	if err == nil && !ok {
+		err = fmt.Errorf("hdfs: unable to create directory %s", path)
+	}
+	return err
+}
+
+// rename renames the file or directory at source to destination, reporting
+// whether it was renamed. Existing destinations are not replaced.
+func (c *client) rename(ctx context.Context, source, destination string) (bool, error) {
+	return c.doBoolean(ctx, request{
+		method: http.MethodPut,
+		path:   source,
+		op:     "RENAME",
+		query:  url.Values{"destination": {destination}},
+	})
+}
+
+// delete deletes the file or directory at path, reporting whether it
+// existed.
+func (c *client) delete(ctx context.Context, path string, recursive bool) (bool, error) {
+	return c.doBoolean(ctx, request{
+		method: http.MethodDelete,
+		path:   path,
+		op:     "DELETE",
+		query:  url.Values{"recursive": {strconv.FormatBool(recursive)}},
+	})
+}
@@ -0,0 +1,546 @@
+// Package hdfs provides a storagedriver.StorageDriver implementation to
+// store blobs in the Hadoop Distributed File System (HDFS).
+//
+// The driver talks to the WebHDFS REST API of the namenodes of the cluster,
+// which redirect the reads and writes of file contents to the datanodes.
+// Several namenodes may be configured for highly available clusters, the
+// driver failing over to the next one when the active one is unreachable or
+// in standby.
+//
+// Writers append the content written to them to the file in chunks, so that
+// uploads can be resumed after the writer is closed.
+package hdfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/base"
+	"github.com/distribution/distribution/v3/registry/storage/driver/factory"
+)
+
+const (
+	driverName = "hdfs"
+
+	defaultRootDirectory = "/registry"
+
+	// minChunkSize is the minimum size of the chunks appended by writers,
+	// but the last one.
+	minChunkSize = 1 << 20
+
+	defaultChunkSize = 32 << 20
+
+	// maxChunkSize bounds the memory buffered by each writer.
+	maxChunkSize = 1 << 30
+)
+
+// DriverParameters represents all configuration options available for the
+// hdfs driver
+type DriverParameters struct {
+	// Namenodes are the URLs of the WebHDFS API of the namenodes, such as
+	// http://namenode:9870.
+	Namenodes []string
+
+	// User is the user the requests are made as, with simple
+	// authentication.
+	User string
+
+	// RootDirectory is the directory files are stored below.
+	RootDirectory string
+
+	// ChunkSize is the size of the chunks appended by writers.
+	ChunkSize int
+
+	// DatanodeAddresses maps the addresses of the datanodes the namenodes
+	// redirect to, to the addresses they are reachable at from the registry.
+	DatanodeAddresses map[string]string
+
+	// Redirect redirects clients to the datanodes to read blobs.
+	Redirect bool
+}
+
+func init() {
+	factory.Register(driverName, &hdfsDriverFactory{})
+}
+
+// hdfsDriverFactory implements the factory.StorageDriverFactory interface
+type hdfsDriverFactory struct{}
+
+func (factory *hdfsDriverFactory) Create(ctx context.Context, parameters map[string]any) (storagedriver.StorageDriver, error) {
+	return FromParameters(ctx, parameters)
+}
+
+var _ storagedriver.StorageDriver = &driver{}
+
+type driver struct {
+	client        *client
+	rootDirectory string
+	chunkSize     int
+	redirect      bool
+}
+
+type baseEmbed struct {
+	base.Base
+}
+
+// Driver is a storagedriver.StorageDriver implementation backed by HDFS.
+// Files are stored below the configured root directory.
+type Driver struct {
+	baseEmbed
+}
+
+// FromParameters constructs a new Driver with a given parameters map
+// Required parameters:
+// - namenode
+// Optional Parameters:
+// - user
+// - rootdirectory
+// - chunksize
+// - datanodeaddresses
+// - redirect
+func FromParameters(ctx context.Context, parameters map[string]any) (*Driver, error) {
+	params, err := fromParametersImpl(parameters)
+	if err != nil {
+		return nil, err
+	}
+	return New(ctx, *params)
+}
+
+func fromParametersImpl(parameters map[string]any) (*DriverParameters, error) {
+	params := &DriverParameters{
+		RootDirectory: defaultRootDirectory,
+		ChunkSize:     defaultChunkSize,
+	}
+
+	switch namenode := parameters["namenode"].(type) {
+	case string:
+		if namenode != "" {
+			params.Namenodes = []string{namenode}
+		}
+	case []any:
+		for _, n := range namenode {
+			params.Namenodes = append(params.Namenodes, fmt.Sprint(n))
+		}
+	case []string:
+		params.Namenodes = namenode
+	case nil:
+	default:
+		return nil, fmt.Errorf("namenode must be a URL or a list of URLs, %v invalid", namenode)
+	}
+	if len(params.Namenodes) == 0 {
+		return nil, fmt.Errorf("no namenode parameter provided")
+	}
+	for _, namenode := range params.Namenodes {
+		if u, err := url.Parse(namenode); err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("namenode %q must be an absolute URL", namenode)
+		}
+	}
+
+	if v, ok := parameters["user"]; ok && v != nil {
+		params.User = fmt.Sprint(v)
+	}
+	if v, ok := parameters["rootdirectory"]; ok && v != nil {
+		params.RootDirectory = fmt.Sprint(v)
+	}
+	if !strings.HasPrefix(params.RootDirectory, "/") {
+		return nil, fmt.Errorf("rootdirectory %q must be an absolute path", params.RootDirectory)
+	}
+
+	var err error
+	if params.ChunkSize, err = intParameter(parameters, "chunksize", defaultChunkSize); err != nil {
+		return nil, err
+	}
+	if params.ChunkSize < minChunkSize || params.ChunkSize > maxChunkSize {
+		return nil, fmt.Errorf("chunksize %d must be between %d and %d", params.ChunkSize, minChunkSize, maxChunkSize)
+	}
+
+	switch addresses := parameters["datanodeaddresses"].(type) {
+	case map[string]any:
+		params.DatanodeAddresses = make(map[string]string, len(addresses))
+		for from, to := range addresses {
+			params.DatanodeAddresses[from] = fmt.Sprint(to)
+		}
+	case map[any]any:
+		params.DatanodeAddresses = make(map[string]string, len(addresses))
+		for from, to := range addresses {
+			params.DatanodeAddresses[fmt.Sprint(from)] = fmt.Sprint(to)
+		}
+	case map[string]string:
+		params.DatanodeAddresses = addresses
+	case nil:
+	default:
+		return nil, fmt.Errorf("datanodeaddresses must be a map of datanode addresses, %v invalid", addresses)
+	}
+
+	if v, ok := parameters["redirect"]; ok && v != nil {
+		if params.Redirect, err = strconv.ParseBool(fmt.Sprint(v)); err != nil {
+			return nil, fmt.Errorf("redirect must be a boolean, %v invalid", v)
+		}
+	}
+
+	return params, nil
+}
+
+// intParameter returns the integer value of the parameter name, or def if it
+// is not set.
+func intParameter(parameters map[string]any, name string, def int) (int, error) {
+	param, ok := parameters[name]
+	if !ok {
+		return def, nil
+	}
+
+	switch v := param.(type) {
+	case string:
+		vv, err := strconv.Atoi(v)
+		if err != nil {
+			return 0, fmt.Errorf("%s must be an integer, %v invalid", name, param)
+		}
+		return vv, nil
+	case int, uint, int32, uint32, uint64, int64:
+		return int(reflect.ValueOf(v).Convert(reflect.TypeFor[int]()).Int()), nil
+	default:
+		return 0, fmt.Errorf("invalid value for %s: %#v", name, param)
+	}
+}
+
+// New constructs a new Driver with the given parameters.
+func New(ctx context.Context, params DriverParameters) (*Driver, error) {
+	var namenodes []*url.URL
+	for _, namenode := range params.Namenodes {
+		u, err := url.Parse(namenode)
+		if err != nil {
+			return nil, err
+		}
+		u.Path = strings.TrimSuffix(u.Path, "/")
+		namenodes = append(namenodes, u)
+	}
+
+	d := &driver{
+		client:        newClient(namenodes, params.User, params.DatanodeAddresses),
+		rootDirectory: path.Clean(params.RootDirectory),
+		chunkSize:     params.ChunkSize,
+		redirect:      params.Redirect,
+	}
+
+	return &Driver{
+		baseEmbed: baseEmbed{
+			Base: base.Base{
+				StorageDriver: d,
+			},
+		},
+	}, nil
+}
+
+// Implement the storagedriver.StorageDriver interface
+
+func (d *driver) Name() string {
+	return driverName
+}
+
+// GetContent retrieves the content stored at "path" as a []byte.
+func (d *driver) GetContent(ctx context.Context, path string) ([]byte, error) {
+	reader, err := d.Reader(ctx, path, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+// PutContent stores the []byte content at a location designated by "path".
+func (d *driver) PutContent(ctx context.Context, path string, contents []byte) error {
+	return parseError(path, d.client.create(ctx, d.hdfsPath(path), contents))
+}
+
+// Reader retrieves an io.ReadCloser for the content stored at "path" with a
+// given byte offset.
+func (d *driver) Reader(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
+	status, err := d.client.getFileStatus(ctx, d.hdfsPath(path))
+	if err != nil {
+		return nil, parseError(path, err)
+	}
+	if status.Type != fileTypeFile {
+		return nil, storagedriver.PathNotFoundError{Path: path, DriverName: driverName}
+	}
+	if offset > status.Length {
+		return nil, storagedriver.InvalidOffsetError{Path: path, Offset: offset, DriverName: driverName}
+	}
+	if offset == status.Length {
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+
+	rc, err := d.client.open(ctx, d.hdfsPath(path), offset)
+	if err != nil {
+		return nil, parseError(path, err)
+	}
+	return rc, nil
+}
+
+// Writer returns a FileWriter which will store the content written to it
+// at the location designated by "path" after the call to Commit.
+func (d *driver) Writer(ctx context.Context, path string, appendMode bool) (storagedriver.FileWriter, error) {
+	w := &writer{
+		ctx:    ctx,
+		driver: d,
+		path:   path,
+		buf:    make([]byte, 0, d.chunkSize),
+	}
+
+	if appendMode {
+		status, err := d.client.getFileStatus(ctx, d.hdfsPath(path))
+		switch {
+		case err == nil && status.Type == fileTypeFile:
+			w.size = status.Length
+			return w, nil
+		case err == nil:
+			return nil, storagedriver.Error{
+				DriverName: driverName,
+				Detail:     fmt.Errorf("append to directory %s unsupported", path),
+			}
+		case !isException(err, exceptionFileNotFound):
+			return nil, parseError(path, err)
+		}
+	}
+
+	if err := d.client.create(ctx, d.hdfsPath(path), nil); err != nil {
+		return nil, parseError(path, err)
+	}
+	return w, nil
+}
+
+// Stat retrieves the FileInfo for the given path, including the current size
+// in bytes and the modification time.
+func (d *driver) Stat(ctx context.Context, path string) (storagedriver.FileInfo, error) {
+	status, err := d.client.getFileStatus(ctx, d.hdfsPath(path))
+	if err != nil {
+		return nil, parseError(path, err)
+	}
+
+	fi := storagedriver.FileInfoFields{
+		Path:    path,
+		IsDir:   status.Type == fileTypeDirectory,
+		ModTime: time.UnixMilli(status.ModificationTime),
+	}
+	if !fi.IsDir {
+		fi.Size = status.Length
+	}
+	return storagedriver.FileInfoInternal{FileInfoFields: fi}, nil
+}
+
+// List returns a list of the objects that are direct descendants of the given path.
+func (d *driver) List(ctx context.Context, path string) ([]string, error) {
+	statuses, err := d.client.listStatus(ctx, d.hdfsPath(path))
+	if err != nil {
+		if path == "/" && isException(err, exceptionFileNotFound) {
+			// the root directory is only created with the first file
+			return []string{}, nil
+		}
+		return nil, parseError(path, err)
+	}
+
+	entries := make([]string, 0, len(statuses))
+	for _, status := range statuses {
+		if status.PathSuffix == "" {
+			// path is a file, listed as itself
+			return nil, storagedriver.PathNotFoundError{Path: path, DriverName: driverName}
+		}
+		entries = append(entries, strings.TrimSuffix(path, "/")+"/"+status.PathSuffix)
+	}
+	return entries, nil
+}
+
+// Move moves an object stored at sourcePath to destPath, removing the original
+// object.
+func (d *driver) Move(ctx context.Context, sourcePath string, destPath string) error {
+	source, dest := d.hdfsPath(sourcePath), d.hdfsPath(destPath)
+	if _, err := d.client.getFileStatus(ctx, source); err != nil {
+		return parseError(sourcePath, err)
+	}
+
+	// renames neither replace the destination nor create its parent
+	if err := d.client.mkdirs(ctx, d.hdfsPath(path.Dir(destPath))); err != nil {
+		return parseError(destPath, err)
+	}
+	if _, err := d.client.delete(ctx, dest, false); err != nil {
+		return parseError(destPath, err)
+	}
+	renamed, err := d.client.rename(ctx, source, dest)
+	if err != nil {
+		return parseError(sourcePath, err)
+	}
+	if !renamed {
+		return storagedriver.Error{
+			DriverName: driverName,
+			Detail:     fmt.Errorf("unable to move %s to %s", sourcePath, destPath),
+		}
+	}
+	return nil
+}
+
+// Delete recursively deletes all objects stored at "path" and its subpaths.
+func (d *driver) Delete(ctx context.Context, path string) error {
+	deleted, err := d.client.delete(ctx, d.hdfsPath(path), true)
+	if err != nil {
+		return parseError(path, err)
+	}
+	if !deleted {
+		return storagedriver.PathNotFoundError{Path: path, DriverName: driverName}
+	}
+	return nil
+}
+
+// RedirectURL returns the URL of the datanode serving the content stored at
+// the given path if redirects are enabled. The content is served by the
+// registry otherwise, and for HEAD requests, which datanodes do not serve.
+func (d *driver) RedirectURL(r *http.Request, path string) (string, error) {
+	if !d.redirect || r.Method != http.MethodGet {
+		return "", nil
+	}
+	u, err := d.client.openLocation(r.Context(), d.hdfsPath(path))
+	if err != nil {
+		return "", parseError(path, err)
+	}
+	return u, nil
+}
+
+// Walk traverses a filesystem defined within driver, starting
+// from the given path, calling f on each file
+func (d *driver) Walk(ctx context.Context, path string, f storagedriver.WalkFn, options ...func(*storagedriver.WalkOptions)) error {
+	return storagedriver.WalkFallback(ctx, d, path, f, options...)
+}
+
+// hdfsPath returns the path of the file stored at path in HDFS.
+func (d *driver) hdfsPath(p string) string {
+	return path.Join(d.rootDirectory, p)
+}
+
+func parseError(path string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if isException(err, exceptionFileNotFound) {
+		return storagedriver.PathNotFoundError{Path: path, DriverName: driverName}
+	}
+	var remoteErr *remoteError
+	if errors.As(err, &remoteErr) {
+		return storagedriver.Error{DriverName: driverName, Detail: err}
+	}
+	return err
+}
+
+// writer appends the content written to it to the file in chunks of the
+// configured chunk size. The content not appended yet is appended when the
+// writer is closed, so that it can be resumed.
+type writer struct {
+	ctx    context.Context
+	driver *driver
+	path   string
+
+	// size is the size of the content appended to the file.
+	size int64
+	buf  []byte
+
+	closed    bool
+	committed bool
+	cancelled bool
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if err := w.done(); err != nil {
+		return 0, err
+	}
+
+	var written int
+	for len(p) > 0 {
+		n := min(len(p), w.driver.chunkSize-len(w.buf))
+		w.buf = append(w.buf, p[:n]...)
+		p = p[n:]
+		written += n
+
+		if len(w.buf) == w.driver.chunkSize {
+			if err := w.flush(w.ctx); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// flush appends the buffer to the file.
+func (w *writer) flush(ctx context.Context) error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	if err := w.driver.client.append(ctx, w.driver.hdfsPath(w.path), w.buf); err != nil {
+		return parseError(w.path, err)
+	}
+	w.size += int64(len(w.buf))
+	w.buf = w.buf[:0]
+	return nil
+}
+
+func (w *writer) Size() int64 {
+	return w.size + int64(len(w.buf))
+}
+
+// Close appends the content not appended yet, so that the writer can be
+// resumed.
+func (w *writer) Close() error {
+	if w.closed {
+		return fmt.Errorf("already closed")
+	}
+	w.closed = true
+
+	if w.committed || w.cancelled {
+		return nil
+	}
+	return w.flush(w.ctx)
+}
+
+// Cancel removes the file.
+func (w *writer) Cancel(ctx context.Context) error {
+	if err := w.done(); err != nil {
+		return err
+	}
+	w.cancelled = true
+
+	if _, err := w.driver.client.delete(ctx, w.driver.hdfsPath(w.path), false); err != nil {
+		return parseError(w.path, err)
+	}
+	return nil
+}
+
+// Commit appends the content not appended yet.
+func (w *writer) Commit(ctx context.Context) error {
+	if err := w.done(); err != nil {
+		return err
+	}
+	if err := w.flush(ctx); err != nil {
+		return err
+	}
+	w.committed = true
+	return nil
+}
+
+// done returns an error if the writer is in an invalid state.
+func (w *writer) done() error {
+	switch {
+	case w.closed:
+		return fmt.Errorf("already closed")
+	case w.committed:
+		return fmt.Errorf("already committed")
+	case w.cancelled:
+		return fmt.Errorf("already cancelled")
+	}
+	return nil
+}
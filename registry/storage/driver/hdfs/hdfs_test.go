@@ -0,0 +1,223 @@
+package hdfs
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/testsuites"
+)
+
+const (
+	envNamenode      = "HDFS_NAMENODE"
+	envUser          = "HDFS_USER"
+	envRootDirectory = "HDFS_ROOT_DIRECTORY"
+)
+
+var (
+	hdfsDriverConstructor func() (storagedriver.StorageDriver, error)
+	skipCheck             func(tb testing.TB)
+)
+
+func init() {
+	namenode := os.Getenv(envNamenode)
+
+	hdfsDriverConstructor = func() (storagedriver.StorageDriver, error) {
+		parameters := map[string]any{
+			"namenode":      strings.Split(namenode, ","),
+			"rootdirectory": "/registry-test",
+			// small chunks exercise appends
+			"chunksize": minChunkSize,
+		}
+		if v := os.Getenv(envUser); v != "" {
+			parameters["user"] = v
+		}
+		if v := os.Getenv(envRootDirectory); v != "" {
+			parameters["rootdirectory"] = v
+		}
+		return FromParameters(context.Background(), parameters)
+	}
+
+	// Skip HDFS driver tests if no namenode is provided
+	skipCheck = func(tb testing.TB) {
+		tb.Helper()
+
+		if namenode == "" {
+			tb.Skipf("Must set %s environment variable to run HDFS tests", envNamenode)
+		}
+	}
+}
+
+func TestHDFSDriverSuite(t *testing.T) {
+	skipCheck(t)
+	testsuites.Driver(t, hdfsDriverConstructor, false)
+}
+
+func BenchmarkHDFSDriverSuite(b *testing.B) {
+	skipCheck(b)
+	testsuites.BenchDriver(b, hdfsDriverConstructor)
+}
+
+// newFakeDriverConstructor returns a constructor of drivers backed by an
+// in-process fake of the WebHDFS API.
+func newFakeDriverConstructor(t *testing.T) func() (storagedriver.StorageDriver, error) {
+	server := httptest.NewServer(newFakeCluster("registry"))
+	t.Cleanup(server.Close)
+
+	return func() (storagedriver.StorageDriver, error) {
+		return FromParameters(context.Background(), map[string]any{
+			"namenode":      server.URL,
+			"user":          "registry",
+			"rootdirectory": "/registry/root",
+			"chunksize":     minChunkSize,
+			"redirect":      true,
+		})
+	}
+}
+
+func TestFakeHDFSDriverSuite(t *testing.T) {
+	testsuites.Driver(t, newFakeDriverConstructor(t), false)
+}
+
+// TestNamenodeFailover validates that requests fail over to the next
+// namenode when the first one is in standby or unreachable.
+func TestNamenodeFailover(t *testing.T) {
+	standby := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remoteException(w, http.StatusForbidden, exceptionStandby, "Operation category READ is not supported in state standby")
+	}))
+	t.Cleanup(standby.Close)
+	unreachable := httptest.NewServer(http.NotFoundHandler())
+	unreachable.Close()
+	active := httptest.NewServer(newFakeCluster("registry"))
+	t.Cleanup(active.Close)
+
+	d, err := FromParameters(context.Background(), map[string]any{
+		"namenode": []any{unreachable.URL, standby.URL, active.URL},
+		"user":     "registry",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := d.PutContent(ctx, "/a/b", []byte("content")); err != nil {
+		t.Fatalf("unexpected error putting content: %v", err)
+	}
+	content, err := d.GetContent(ctx, "/a/b")
+	if err != nil || string(content) != "content" {
+		t.Fatalf("unexpected content %q: %v", content, err)
+	}
+
+	client := d.StorageDriver.(*driver).client
+	if active := client.active.Load(); active != 2 {
+		t.Fatalf("expected the active namenode to be remembered, got %d", active)
+	}
+}
+
+// TestDatanodeAddresses validates that the addresses of the datanodes the
+// namenode redirects to are replaced with the configured ones.
+func TestDatanodeAddresses(t *testing.T) {
+	cluster := newFakeCluster("")
+	cluster.datanode = "datanode.invalid:9864"
+	server := httptest.NewServer(cluster)
+	t.Cleanup(server.Close)
+	serverURL, _ := url.Parse(server.URL)
+
+	d, err := FromParameters(context.Background(), map[string]any{
+		"namenode":          server.URL,
+		"datanodeaddresses": map[any]any{"datanode.invalid:9864": serverURL.Host},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := d.PutContent(ctx, "/a", []byte("content")); err != nil {
+		t.Fatalf("unexpected error putting content: %v", err)
+	}
+	content, err := d.GetContent(ctx, "/a")
+	if err != nil || string(content) != "content" {
+		t.Fatalf("unexpected content %q: %v", content, err)
+	}
+}
+
+func TestFromParametersImpl(t *testing.T) {
+	tests := []struct {
+		params   map[string]any
+		expected DriverParameters
+		pass     bool
+	}{
+		{
+			params: map[string]any{"namenode": "http://namenode:9870"},
+			expected: DriverParameters{
+				Namenodes:     []string{"http://namenode:9870"},
+				RootDirectory: defaultRootDirectory,
+				ChunkSize:     defaultChunkSize,
+			},
+			pass: true,
+		},
+		{
+			params: map[string]any{
+				"namenode":          []any{"http://nn1:9870", "http://nn2:9870"},
+				"user":              "registry",
+				"rootdirectory":     "/data/registry",
+				"chunksize":         "1048576",
+				"datanodeaddresses": map[any]any{"dn1:9864": "10.0.0.1:9864"},
+				"redirect":          "true",
+			},
+			expected: DriverParameters{
+				Namenodes:         []string{"http://nn1:9870", "http://nn2:9870"},
+				User:              "registry",
+				RootDirectory:     "/data/registry",
+				ChunkSize:         1 << 20,
+				DatanodeAddresses: map[string]string{"dn1:9864": "10.0.0.1:9864"},
+				Redirect:          true,
+			},
+			pass: true,
+		},
+		{
+			params: map[string]any{},
+			pass:   false,
+		},
+		{
+			params: map[string]any{"namenode": "namenode:9870"},
+			pass:   false,
+		},
+		{
+			params: map[string]any{"namenode": "http://namenode:9870", "rootdirectory": "registry"},
+			pass:   false,
+		},
+		{
+			params: map[string]any{"namenode": "http://namenode:9870", "chunksize": 1024},
+			pass:   false,
+		},
+		{
+			params: map[string]any{"namenode": "http://namenode:9870", "datanodeaddresses": "dn1:9864"},
+			pass:   false,
+		},
+		{
+			params: map[string]any{"namenode": "http://namenode:9870", "redirect": "sometimes"},
+			pass:   false,
+		},
+	}
+
+	for _, tc := range tests {
+		params, err := fromParametersImpl(tc.params)
+		if !tc.pass {
+			if err == nil {
+				t.Fatalf("expected error for params %v", tc.params)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("unexpected error for params %v: %v", tc.params, err)
+		}
+		if !reflect.DeepEqual(*params, tc.expected) {
+			t.Fatalf("unexpected params from %v: %+v != %+v", tc.params, *params, tc.expected)
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package hdfs
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	namenodePrefix = "/webhdfs/v1"
+	datanodePrefix = "/datanode/webhdfs/v1"
+)
+
+// fakeCluster is an in-process implementation of the subset of the WebHDFS
+// API used by the driver, serving both as namenode and datanode.
+type fakeCluster struct {
+	user string
+	// datanode is the address the namenode redirects to, that of the
+	// cluster by default.
+	datanode string
+
+	mu    sync.Mutex
+	files map[string]*fakeFile
+}
+
+type fakeFile struct {
+	dir      bool
+	content  []byte
+	modified time.Time
+}
+
+func newFakeCluster(user string) *fakeCluster {
+	return &fakeCluster{
+		user:  user,
+		files: map[string]*fakeFile{"/": {dir: true, modified: time.Now()}},
+	}
+}
+
+func (c *fakeCluster) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	query := r.URL.Query()
+	if query.Get("user.name") != c.user {
+		remoteException(w, http.StatusUnauthorized, "SecurityException", "unknown user "+query.Get("user.name"))
+		return
+	}
+
+	switch {
+	case strings.HasPrefix(r.URL.Path, datanodePrefix):
+		c.serveDatanode(w, r, path.Clean("/"+strings.TrimPrefix(r.URL.Path, datanodePrefix)), query)
+	case strings.HasPrefix(r.URL.Path, namenodePrefix):
+		c.serveNamenode(w, r, path.Clean("/"+strings.TrimPrefix(r.URL.Path, namenodePrefix)), query)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (c *fakeCluster) serveNamenode(w http.ResponseWriter, r *http.Request, p string, query url.Values) {
+	switch op := query.Get("op"); op {
+	case "CREATE", "APPEND", "OPEN":
+		datanode := c.datanode
+		if datanode == "" {
+			datanode = r.Host
+		}
+		location := (&url.URL{Scheme: "http", Host: datanode, Path: datanodePrefix + p, RawQuery: query.Encode()}).String()
+		if query.Get("noredirect") == "true" {
+			writeJSON(w, map[string]string{"Location": location})
+			return
+		}
+		w.Header().Set("Location", location)
+		w.WriteHeader(http.StatusTemporaryRedirect)
+	case "GETFILESTATUS":
+		f, ok := c.files[p]
+		if !ok {
+			fileNotFound(w, p)
+			return
+		}
+		writeJSON(w, map[string]any{"FileStatus": f.status("")})
+	case "LISTSTATUS":
+		f, ok := c.files[p]
+		if !ok {
+			fileNotFound(w, p)
+			return
+		}
+		statuses := []map[string]any{}
+		if !f.dir {
+			statuses = append(statuses, f.status(""))
+		}
+		for _, child := range c.children(p) {
+			statuses = append(statuses, c.files[child].status(path.Base(child)))
+		}
+		writeJSON(w, map[string]any{"FileStatuses": map[string]any{"FileStatus": statuses}})
+	case "MKDIRS":
+		if !c.mkdirs(w, p) {
+			return
+		}
+		writeJSON(w, map[string]bool{"boolean": true})
+	case "DELETE":
+		f, ok := c.files[p]
+		if !ok || p == "/" {
+			writeJSON(w, map[string]bool{"boolean": false})
+			return
+		}
+		if f.dir && len(c.children(p)) > 0 && query.Get("recursive") != "true" {
+			remoteException(w, http.StatusForbidden, "PathIsNotEmptyDirectoryException", p+" is non empty")
+			return
+		}
+		for name := range c.files {
+			if name == p || strings.HasPrefix(name, p+"/") {
+				delete(c.files, name)
+			}
+		}
+		writeJSON(w, map[string]bool{"boolean": true})
+	case "RENAME":
+		destination := path.Clean(query.Get("destination"))
+		parent, ok := c.files[path.Dir(destination)]
+		_, exists := c.files[destination]
+		if _, found := c.files[p]; !found || exists || !ok || !parent.dir || strings.HasPrefix(destination, p+"/") {
+			writeJSON(w, map[string]bool{"boolean": false})
+			return
+		}
+		for name, f := range c.files {
+			if name == p || strings.HasPrefix(name, p+"/") {
+				delete(c.files, name)
+				c.files[destination+strings.TrimPrefix(name, p)] = f
+			}
+		}
+		writeJSON(w, map[string]bool{"boolean": true})
+	default:
+		remoteException(w, http.StatusBadRequest, "IllegalArgumentException", "invalid op "+op)
+	}
+}
+
+func (c *fakeCluster) serveDatanode(w http.ResponseWriter, r *http.Request, p string, query url.Values) {
+	switch op := query.Get("op"); op {
+	case "CREATE":
+		if f, ok := c.files[p]; ok && (f.dir || query.Get("overwrite") != "true") {
+			remoteException(w, http.StatusForbidden, "FileAlreadyExistsException", p+" already exists")
+			return
+		}
+		if !c.mkdirs(w, path.Dir(p)) {
+			return
+		}
+		content, err := io.ReadAll(r.Body)
+		if err != nil {
+			remoteException(w, http.StatusBadRequest, "IOException", err.Error())
+			return
+		}
+		c.files[p] = &fakeFile{content: content, modified: time.Now()}
+		w.WriteHeader(http.StatusCreated)
+	case "APPEND":
+		f, ok := c.files[p]
+		if !ok || f.dir {
+			fileNotFound(w, p)
+			return
+		}
+		content, err := io.ReadAll(r.Body)
+		if err != nil {
+			remoteException(w, http.StatusBadRequest, "IOException", err.Error())
+			return
+		}
+		f.content = append(f.content, content...)
+		f.modified = time.Now()
+	case "OPEN":
+		f, ok := c.files[p]
+		if !ok || f.dir {
+			fileNotFound(w, p)
+			return
+		}
+		offset, _ := strconv.ParseInt(query.Get("offset"), 10, 64)
+		if offset > int64(len(f.content)) {
+			remoteException(w, http.StatusForbidden, "IOException", "offset out of range")
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Length", strconv.Itoa(len(f.content)-int(offset)))
+		if r.Method != http.MethodHead {
+			_, _ = w.Write(f.content[offset:])
+		}
+	default:
+		remoteException(w, http.StatusBadRequest, "IllegalArgumentException", "invalid op "+op)
+	}
+}
+
+// mkdirs creates the directory p and its parents, reporting whether it
+// succeeded, or the error otherwise.
+func (c *fakeCluster) mkdirs(w http.ResponseWriter, p string) bool {
+	var missing []string
+	for dir := p; ; dir = path.Dir(dir) {
+		f, ok := c.files[dir]
+		if ok {
+			if !f.dir {
+				remoteException(w, http.StatusForbidden, "ParentNotDirectoryException", dir+" is not a directory")
+				return false
+			}
+			break
+		}
+		missing = append(missing, dir)
+	}
+	for _, dir := range missing {
+		c.files[dir] = &fakeFile{dir: true, modified: time.Now()}
+	}
+	return true
+}
+
+// children returns the sorted paths of the direct children of the directory
+// p.
+func (c *fakeCluster) children(p string) []string {
+	var children []string
+	for name := range c.files {
+		if name != "/" && path.Dir(name) == p {
+			children = append(children, name)
+		}
+	}
+	sort.Strings(children)
+	return children
+}
+
+func (f *fakeFile) status(suffix string) map[string]any {
+	status := map[string]any{
+		"pathSuffix":       suffix,
+		"type":             fileTypeFile,
+		"length":           len(f.content),
+		"modificationTime": f.modified.UnixMilli(),
+	}
+	if f.dir {
+		status["type"] = fileTypeDirectory
+		status["length"] = 0
+	}
+	return status
+}
+
+func fileNotFound(w http.ResponseWriter, p string) {
+	remoteException(w, http.StatusNotFound, exceptionFileNotFound, "File does not exist: "+p)
+}
+
+func remoteException(w http.ResponseWriter, status int, exception, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]any{"RemoteException": map[string]string{
+		"exception":     exception,
+		"javaClassName": "org.apache.hadoop." + exception,
+		"message":       message,
+	}})
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -68,6 +68,11 @@ func (d *driver) Name() string {
 	return driverName
 }
 
+// DriverLimits returns no limits, paths being held in memory.
+func (d *driver) DriverLimits() storagedriver.Limits {
+	return storagedriver.Limits{}
+}
+
 // GetContent retrieves the content stored at "path" as a []byte.
 func (d *driver) GetContent(ctx context.Context, path string) ([]byte, error) {
 	d.mutex.RLock()
@@ -129,7 +134,12 @@ func (d *driver) reader(ctx context.Context, path string, offset int64) (io.Read
 		return nil, fmt.Errorf("%q is a directory", path)
 	}
 
-	return io.NopCloser(found.(*file).sectionReader(offset)), nil
+	f := found.(*file)
+	if offset > int64(len(f.data)) {
+		return nil, storagedriver.InvalidOffsetError{Path: path, Offset: offset}
+	}
+
+	return io.NopCloser(f.sectionReader(offset)), nil
 }
 
 // Writer returns a FileWriter which will store the content written to it
@@ -295,7 +305,7 @@ func (w *writer) Size() int64 {
 	w.d.mutex.RLock()
 	defer w.d.mutex.RUnlock()
 
-	return int64(len(w.f.data))
+	return int64(len(w.f.data) + w.buffSize)
 }
 
 func (w *writer) Close() error {
@@ -0,0 +1,50 @@
+package driver
+
+// Limits are the limits the backend of a storage driver puts on the paths it
+// stores, accounting for the prefix, such as a root directory, the driver
+// adds to them. A zero limit is no limit.
+type Limits struct {
+	// MaxPathLength is the maximum length, in bytes, of a path.
+	MaxPathLength int
+
+	// MaxComponentLength is the maximum length, in bytes, of each component
+	// of a path.
+	MaxComponentLength int
+}
+
+// DefaultLimits are the limits of the storage drivers which do not implement
+// Limiter: the 1024 bytes most object stores limit their keys to.
+var DefaultLimits = Limits{MaxPathLength: 1024}
+
+// Limiter is implemented by the storage drivers reporting the limits of their
+// backend, so that the paths exceeding them can be rejected before being
+// written.
+type Limiter interface {
+	// DriverLimits returns the limits of the paths the driver stores.
+	DriverLimits() Limits
+}
+
+// LimitsOf returns the limits of driver if it implements Limiter, or
+// DefaultLimits otherwise.
+func LimitsOf(driver StorageDriver) Limits {
+	if limiter, ok := driver.(Limiter); ok {
+		return limiter.DriverLimits()
+	}
+	return DefaultLimits
+}
+
+// Min returns the most restrictive of the limits l and other.
+func (l Limits) Min(other Limits) Limits {
+	return Limits{
+		MaxPathLength:      minLimit(l.MaxPathLength, other.MaxPathLength),
+		MaxComponentLength: minLimit(l.MaxComponentLength, other.MaxComponentLength),
+	}
+}
+
+// minLimit returns the lowest of the limits a and b, zero being no limit.
+func minLimit(a, b int) int {
+	if a == 0 || b != 0 && b < a {
+		return b
+	}
+	return a
+}
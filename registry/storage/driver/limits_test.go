@@ -0,0 +1,44 @@
+package driver
+
+import (
+	"testing"
+)
+
+type limitedFileSystem struct {
+	StorageDriver
+	limits Limits
+}
+
+func (lfs *limitedFileSystem) DriverLimits() Limits {
+	return lfs.limits
+}
+
+func TestLimitsOf(t *testing.T) {
+	if limits := LimitsOf(&changingFileSystem{}); limits != DefaultLimits {
+		t.Fatalf("expected the default limits, got %+v", limits)
+	}
+
+	expected := Limits{MaxPathLength: 4000, MaxComponentLength: 255}
+	if limits := LimitsOf(&limitedFileSystem{limits: expected}); limits != expected {
+		t.Fatalf("unexpected limits: %+v", limits)
+	}
+}
+
+func TestLimitsMin(t *testing.T) {
+	for _, tc := range []struct {
+		a, b, expected Limits
+	}{
+		{},
+		{a: Limits{MaxPathLength: 1024}, expected: Limits{MaxPathLength: 1024}},
+		{b: Limits{MaxComponentLength: 255}, expected: Limits{MaxComponentLength: 255}},
+		{
+			a:        Limits{MaxPathLength: 1024, MaxComponentLength: 255},
+			b:        Limits{MaxPathLength: 4000, MaxComponentLength: 100},
+			expected: Limits{MaxPathLength: 1024, MaxComponentLength: 100},
+		},
+	} {
+		if limits := tc.a.Min(tc.b); limits != tc.expected {
+			t.Errorf("%+v.Min(%+v) = %+v, expected %+v", tc.a, tc.b, limits, tc.expected)
+		}
+	}
+}
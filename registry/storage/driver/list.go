@@ -0,0 +1,29 @@
+package driver
+
+import (
+	"context"
+)
+
+// ListPager is implemented by the storage drivers which list the direct
+// descendants of a path page by page, so that listing a path with a huge
+// number of descendants does not hold them all in memory.
+type ListPager interface {
+	// ListPages calls f with each page of the direct descendants of path,
+	// in no particular order. It returns a PathNotFoundError if path has no
+	// descendants, and stops at the first error returned by f, which it
+	// returns.
+	ListPages(ctx context.Context, path string, f func(paths []string) error) error
+}
+
+// ListPages calls f with the direct descendants of path, page by page if the
+// driver implements ListPager, or all at once from List otherwise.
+func ListPages(ctx context.Context, driver StorageDriver, path string, f func(paths []string) error) error {
+	if pager, ok := driver.(ListPager); ok {
+		return pager.ListPages(ctx, path, f)
+	}
+	paths, err := driver.List(ctx, path)
+	if err != nil {
+		return err
+	}
+	return f(paths)
+}
@@ -4,18 +4,16 @@ package middleware
 
 import (
 	"context"
-	"crypto/x509"
-	"encoding/pem"
 	"fmt"
 	"net/http"
 	"net/url"
-	"os"
+	"strconv"
 	"strings"
 	"time"
 
-	"github.com/aws/aws-sdk-go/service/cloudfront/sign"
 	"github.com/distribution/distribution/v3/internal/dcontext"
 	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/base"
 	storagemiddleware "github.com/distribution/distribution/v3/registry/storage/driver/middleware"
 	"github.com/sirupsen/logrus"
 )
@@ -32,8 +30,11 @@ func init() {
 // then issues HTTP Temporary Redirects to this CloudFront content URL.
 type cloudFrontStorageMiddleware struct {
 	storagedriver.StorageDriver
+	// keyer returns the keys of the paths of the storage driver, or is nil
+	// if the storage driver is not supported.
+	keyer     S3BucketKeyer
 	awsIPs    *awsIPs
-	urlSigner *sign.URLSigner
+	urlSigner urlSigner
 	baseURL   string
 	duration  time.Duration
 }
@@ -46,17 +47,29 @@ var _ storagedriver.StorageDriver = &cloudFrontStorageMiddleware{}
 // Required options:
 //
 //   - baseurl
-//   - privatekey
-//   - keypairid
+//   - privatekey, unless signer is "exec"
+//   - keypairid, unless signer is "exec"
 //
 // Optional options:
 //
+//   - signer: valid value "key|exec". "key", the URLs are signed with
+//     privatekey, default value. "exec", the URLs are signed by an external
+//     signer, see the exec signer protocol, and privatekey and keypairid must
+//     not be set.
+//   - signercommand: the command signing a URL, with the exec signer.
+//   - signersocket: the unix socket of the daemon signing URLs, with the
+//     exec signer, instead of signercommand.
+//   - signertimeout: the time a URL is signed within, default 5s.
+//   - signerconcurrency: the number of URLs signed concurrently, default 16.
 //   - ipFilteredBy
 //   - awsregion
 //   - ipfilteredby: valid value "none|aws|awsregion". "none", do not filter any IP,
 //     default value. "aws", only aws IP goes to S3 directly. "awsregion", only
 //     regions listed in awsregion options goes to S3 directly
 //   - awsregion: a comma separated string of AWS regions.
+//   - allowunsupporteddriver: whether a storage driver not supported by
+//     CloudFront is allowed, the content being redirected to directly. The
+//     construction fails on such drivers by default.
 func newCloudFrontStorageMiddleware(ctx context.Context, storageDriver storagedriver.StorageDriver, options map[string]any) (storagedriver.StorageDriver, error) {
 	// parse baseurl
 	base, ok := options["baseurl"]
@@ -77,41 +90,47 @@ func newCloudFrontStorageMiddleware(ctx context.Context, storageDriver storagedr
 		return nil, fmt.Errorf("invalid baseurl: %v", err)
 	}
 
-	// parse privatekey to get pkPath
-	pk, ok := options["privatekey"]
-	if !ok {
-		return nil, fmt.Errorf("no privatekey provided")
-	}
-	pkPath, ok := pk.(string)
-	if !ok {
-		return nil, fmt.Errorf("privatekey must be a string")
-	}
-
-	// parse keypairid
-	kpid, ok := options["keypairid"]
-	if !ok {
-		return nil, fmt.Errorf("no keypairid provided")
-	}
-	keypairID, ok := kpid.(string)
-	if !ok {
-		return nil, fmt.Errorf("keypairid must be a string")
+	// parse signer
+	var urlSigner urlSigner
+	signer := "key"
+	if s, ok := options["signer"]; ok {
+		if signer, ok = s.(string); !ok {
+			return nil, fmt.Errorf("signer must be a string")
+		}
 	}
-
-	// get urlSigner from the file specified in pkPath
-	pkBytes, err := os.ReadFile(pkPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read privatekey file: %s", err)
+	switch strings.ToLower(strings.TrimSpace(signer)) {
+	case "", "key":
+		keySigner, err := newKeySigner(options)
+		if err != nil {
+			return nil, err
+		}
+		urlSigner = keySigner
+	case "exec":
+		execSigner, err := newExecSigner(options)
+		if err != nil {
+			return nil, err
+		}
+		urlSigner = execSigner
+	default:
+		return nil, fmt.Errorf("signer only allows a string with the following value: key|exec")
 	}
 
-	block, _ := pem.Decode(pkBytes)
-	if block == nil {
-		return nil, fmt.Errorf("failed to decode private key as an rsa private key")
+	// check the storage driver is supported
+	allowUnsupported := false
+	if v, ok := options["allowunsupporteddriver"]; ok {
+		b, err := strconv.ParseBool(fmt.Sprint(v))
+		if err != nil {
+			return nil, fmt.Errorf("allowunsupporteddriver must be a boolean, %v invalid", v)
+		}
+		allowUnsupported = b
 	}
-	privateKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
-	if err != nil {
-		return nil, err
+	keyer := s3BucketKeyer(storageDriver)
+	if keyer == nil {
+		if !allowUnsupported {
+			return nil, fmt.Errorf("the CloudFront middleware does not support the %s storage driver, set allowunsupporteddriver to redirect to it directly", storageDriver.Name())
+		}
+		dcontext.GetLogger(ctx).Warnf("the CloudFront middleware does not support the %s storage driver, redirecting to it directly", storageDriver.Name())
 	}
-	urlSigner := sign.NewURLSigner(keypairID, privateKey)
 
 	// parse duration
 	duration := 20 * time.Minute
@@ -159,7 +178,10 @@ func newCloudFrontStorageMiddleware(ctx context.Context, storageDriver storagedr
 	}
 
 	// parse ipfilteredby
-	var awsIPs *awsIPs
+	var (
+		awsIPs *awsIPs
+		err    error
+	)
 	if i, ok := options["ipfilteredby"]; ok {
 		if ipFilteredBy, ok := i.(string); ok {
 			switch strings.ToLower(strings.TrimSpace(ipFilteredBy)) {
@@ -197,6 +219,7 @@ func newCloudFrontStorageMiddleware(ctx context.Context, storageDriver storagedr
 
 	return &cloudFrontStorageMiddleware{
 		StorageDriver: storageDriver,
+		keyer:         keyer,
 		urlSigner:     urlSigner,
 		baseURL:       baseURL,
 		duration:      duration,
@@ -210,13 +233,26 @@ type S3BucketKeyer interface {
 	S3BucketKey(path string) string
 }
 
+// s3BucketKeyer returns the S3BucketKeyer of storageDriver, unwrapping the
+// base.Base wrappers of the storage drivers, or nil if there is none.
+func s3BucketKeyer(storageDriver storagedriver.StorageDriver) S3BucketKeyer {
+	for {
+		if keyer, ok := storageDriver.(S3BucketKeyer); ok {
+			return keyer
+		}
+		b, ok := storageDriver.(*base.Base)
+		if !ok {
+			return nil
+		}
+		storageDriver = b.StorageDriver
+	}
+}
+
 // RedirectURL attempts to find a url which may be used to retrieve the file at the given path.
 // Returns an error if the file cannot be found.
 func (lh *cloudFrontStorageMiddleware) RedirectURL(r *http.Request, path string) (string, error) {
 	// TODO(endophage): currently only supports S3
-	keyer, ok := lh.StorageDriver.(S3BucketKeyer)
-	if !ok {
-		dcontext.GetLogger(r.Context()).Warn("the CloudFront middleware does not support this backend storage driver")
+	if lh.keyer == nil {
 		return lh.StorageDriver.RedirectURL(r, path)
 	}
 
@@ -225,7 +261,8 @@ func (lh *cloudFrontStorageMiddleware) RedirectURL(r *http.Request, path string)
 	}
 
 	// Get signed cloudfront url.
-	cfURL, err := lh.urlSigner.Sign(lh.baseURL+keyer.S3BucketKey(path), time.Now().Add(lh.duration))
+	key := lh.keyer.S3BucketKey(path)
+	cfURL, err := lh.urlSigner.Sign(r.Context(), lh.baseURL+key, key, time.Now().Add(lh.duration))
 	if err != nil {
 		return "", err
 	}
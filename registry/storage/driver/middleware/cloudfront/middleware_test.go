@@ -5,6 +5,10 @@ import (
 	"os"
 	"testing"
 
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/base"
+	"github.com/distribution/distribution/v3/registry/storage/driver/filesystem"
+	"github.com/distribution/distribution/v3/registry/storage/driver/s3-aws"
 	"github.com/stretchr/testify/require"
 )
 
@@ -14,7 +18,10 @@ func TestNoConfig(t *testing.T) {
 	require.ErrorContains(t, err, "no baseurl provided")
 }
 
-func TestCloudFrontStorageMiddlewareGenerateKey(t *testing.T) {
+// testOptions returns the required options of the middleware, with a
+// private key written to a temporary file.
+func testOptions(t *testing.T) map[string]any {
+	t.Helper()
 	options := make(map[string]any)
 	options["baseurl"] = "example.com"
 
@@ -35,17 +42,25 @@ pZeMRablbPQdp8/1NyIwimq1VlG0ohQ4P6qhW7E09ZMC
 -----END RSA PRIVATE KEY-----
 `
 
-	file, err := os.CreateTemp("", "pkey")
+	file, err := os.CreateTemp(t.TempDir(), "pkey")
 	if err != nil {
 		t.Fatal("File cannot be created")
 	}
 	if _, err := file.WriteString(privk); err != nil {
 		t.Fatal(err)
 	}
-	defer os.Remove(file.Name())
 	options["privatekey"] = file.Name()
 	options["keypairid"] = "test"
-	storageDriver, err := newCloudFrontStorageMiddleware(context.Background(), nil, options)
+	return options
+}
+
+func TestCloudFrontStorageMiddlewareGenerateKey(t *testing.T) {
+	s3Driver, err := s3.FromParameters(context.Background(), map[string]any{
+		"region": "us-east-1",
+		"bucket": "test",
+	})
+	require.NoError(t, err)
+	storageDriver, err := newCloudFrontStorageMiddleware(context.Background(), s3Driver, testOptions(t))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -53,3 +68,44 @@ pZeMRablbPQdp8/1NyIwimq1VlG0ohQ4P6qhW7E09ZMC
 		t.Fatal("Driver could not be initialized")
 	}
 }
+
+func TestCloudFrontStorageMiddlewareDrivers(t *testing.T) {
+	s3Driver, err := s3.FromParameters(context.Background(), map[string]any{
+		"region": "us-east-1",
+		"bucket": "test",
+	})
+	require.NoError(t, err)
+	fsDriver, err := filesystem.FromParameters(map[string]any{"rootdirectory": t.TempDir()})
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		name             string
+		driver           storagedriver.StorageDriver
+		allowUnsupported any
+		keyed            bool
+		err              string
+	}{
+		{name: "s3", driver: s3Driver, keyed: true},
+		{name: "wrapped s3", driver: &base.Base{StorageDriver: s3Driver}, keyed: true},
+		{name: "filesystem", driver: fsDriver, err: "does not support the filesystem storage driver"},
+		{name: "wrapped filesystem", driver: &base.Base{StorageDriver: fsDriver}, err: "does not support the filesystem storage driver"},
+		{name: "filesystem allowed", driver: fsDriver, allowUnsupported: true},
+		{name: "filesystem allowed as string", driver: fsDriver, allowUnsupported: "true"},
+		{name: "filesystem not allowed", driver: fsDriver, allowUnsupported: false, err: "does not support the filesystem storage driver"},
+		{name: "invalid override", driver: fsDriver, allowUnsupported: "sometimes", err: "allowunsupporteddriver must be a boolean"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			options := testOptions(t)
+			if tc.allowUnsupported != nil {
+				options["allowunsupporteddriver"] = tc.allowUnsupported
+			}
+			storageDriver, err := newCloudFrontStorageMiddleware(context.Background(), tc.driver, options)
+			if tc.err != "" {
+				require.ErrorContains(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.keyed, storageDriver.(*cloudFrontStorageMiddleware).keyer != nil)
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// AWSRegions resolves the AWS region of IP addresses from the AWS IP ranges,
+// which are updated periodically.
+type AWSRegions struct {
+	ips *awsIPs
+}
+
+// NewAWSRegions returns an AWSRegions fetching the AWS IP ranges from
+// ipRangesURL every updateFrequency. The AWS defaults are used for empty
+// values.
+func NewAWSRegions(ctx context.Context, ipRangesURL string, updateFrequency time.Duration) (*AWSRegions, error) {
+	if ipRangesURL == "" {
+		ipRangesURL = defaultIPRangesURL
+	}
+	if updateFrequency <= 0 {
+		updateFrequency = defaultUpdateFrequency
+	}
+	ips, err := newAWSIPs(ctx, ipRangesURL, updateFrequency, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &AWSRegions{ips: ips}, nil
+}
+
+// Region returns the AWS region of ip, such as "us-east-1", or an empty
+// string if ip is not within AWS or the IP ranges are not known yet.
+func (r *AWSRegions) Region(ip net.IP) string {
+	return r.ips.region(ip)
+}
@@ -48,6 +48,7 @@ type awsIPs struct {
 	updateFrequency time.Duration
 	ipv4            []net.IPNet
 	ipv6            []net.IPNet
+	regions         map[string]string // network -> region
 	mutex           sync.RWMutex
 	awsRegion       []string
 	updaterStopChan chan bool
@@ -100,6 +101,7 @@ func (s *awsIPs) tryUpdate(ctx context.Context) error {
 
 	var ipv4 []net.IPNet
 	var ipv6 []net.IPNet
+	regions := make(map[string]string)
 
 	processAddress := func(output *[]net.IPNet, prefix string, region string) {
 		regionAllowed := false
@@ -123,6 +125,7 @@ func (s *awsIPs) tryUpdate(ctx context.Context) error {
 		}
 		if regionAllowed {
 			*output = append(*output, *network)
+			regions[network.String()] = strings.ToLower(region)
 		}
 	}
 
@@ -137,6 +140,7 @@ func (s *awsIPs) tryUpdate(ctx context.Context) error {
 	// Update each attr of awsips atomically.
 	s.ipv4 = ipv4
 	s.ipv6 = ipv6
+	s.regions = regions
 	s.initialized = true
 	return nil
 }
@@ -191,6 +195,20 @@ func (s *awsIPs) contains(ip net.IP) bool {
 	return false
 }
 
+// region returns the region of the network containing ip, or an empty string
+// if ip is not within aws.
+func (s *awsIPs) region(ip net.IP) string {
+	networks := s.getCandidateNetworks(ip)
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return s.regions[network.String()]
+		}
+	}
+	return ""
+}
+
 // parseIPFromRequest attempts to extract the ip address of the
 // client that made the request
 func parseIPFromRequest(request *http.Request) (net.IP, error) {
@@ -426,3 +426,30 @@ func BenchmarkContainsProd(b *testing.B) {
 		ips.contains(ipv6[i])
 	}
 }
+
+func TestAWSRegions(t *testing.T) {
+	t.Parallel()
+	server := setupTest(awsIPResponse{
+		Prefixes: []prefixEntry{
+			{IPV4Prefix: "192.168.0.0/24", Region: "us-east-1"},
+			{IPV4Prefix: "192.168.1.0/24", Region: "EU-West-1"},
+		},
+		V6Prefixes: []prefixEntry{
+			{IPV6Prefix: "2001:db8::/32", Region: "ap-south-1"},
+		},
+	})
+	defer server.Close()
+
+	regions, err := NewAWSRegions(context.Background(), serverIPRanges(server), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ip, region := range map[string]string{
+		"192.168.0.10": "us-east-1",
+		"192.168.1.10": "eu-west-1",
+		"2001:db8::1":  "ap-south-1",
+		"10.0.0.1":     "",
+	} {
+		assertEqual(t, region, regions.Region(net.ParseIP(ip)))
+	}
+}
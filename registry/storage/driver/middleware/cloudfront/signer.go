@@ -0,0 +1,327 @@
+package middleware
+
+// The exec signer protocol, version 1
+//
+// With the "exec" signer, the middleware never loads the CloudFront private
+// key: the URLs are signed by an external signer, which is either a command
+// run once per URL, or a daemon listening on a unix socket and accepting a
+// connection per URL.
+//
+// The middleware sends the signer a request, a single JSON object followed
+// by a newline, on the standard input of the command or on the connection:
+//
+//	{"version": 1, "url": "https://cdn.example.com/docker/registry/v2/blobs/...", "path": "docker/registry/v2/blobs/...", "expires": 1714644000}
+//
+// where url is the URL to sign, path is its S3 key, relative to the base URL,
+// and expires is the time, in seconds since the Unix epoch, the signed URL
+// expires at.
+//
+// The signer responds with a single JSON object, on its standard output
+// before exiting with the status 0, or on the connection before closing it:
+//
+//	{"version": 1, "url": "https://cdn.example.com/docker/registry/v2/blobs/...?Expires=...&Signature=...&Key-Pair-Id=..."}
+//
+// or, if it cannot sign the URL:
+//
+//	{"version": 1, "error": "the reason the URL was not signed"}
+//
+// The version of the response must be the version of the request. Signers
+// must ignore the unknown fields of the requests, which may be added
+// without changing the version. A signer not responding within the timeout
+// of the middleware is killed, or its connection closed.
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/cloudfront/sign"
+	prometheus "github.com/distribution/distribution/v3/metrics"
+	"github.com/docker/go-metrics"
+)
+
+const (
+	// SignerProtocolVersion is the version of the protocol spoken with the
+	// exec signers.
+	SignerProtocolVersion = 1
+
+	// defaultSignerTimeout bounds the time an exec signer takes to sign a
+	// URL, including the time waiting for a concurrency slot.
+	defaultSignerTimeout = 5 * time.Second
+
+	// defaultSignerConcurrency is the number of URLs signed concurrently by
+	// an exec signer.
+	defaultSignerConcurrency = 16
+
+	// maxSignerResponseSize bounds the size of the responses of the exec
+	// signers.
+	maxSignerResponseSize = 64 * 1024
+)
+
+// signerFailures counts the URLs the exec signer failed to sign, by reason.
+var signerFailures = prometheus.CloudFrontNamespace.NewLabeledCounter("signer_failures", "The number of URLs the exec signer failed to sign", "reason")
+
+func init() {
+	metrics.Register(prometheus.CloudFrontNamespace)
+}
+
+// urlSigner signs the CloudFront URLs redirected to.
+type urlSigner interface {
+	// Sign signs rawURL, of the S3 key path, to expire at expires.
+	Sign(ctx context.Context, rawURL, path string, expires time.Time) (string, error)
+}
+
+// keySigner signs URLs with a private key held by the middleware.
+type keySigner struct {
+	signer *sign.URLSigner
+}
+
+// newKeySigner returns a keySigner of the private key and key pair ID
+// configured by options.
+func newKeySigner(options map[string]any) (*keySigner, error) {
+	// parse privatekey to get pkPath
+	pk, ok := options["privatekey"]
+	if !ok {
+		return nil, fmt.Errorf("no privatekey provided")
+	}
+	pkPath, ok := pk.(string)
+	if !ok {
+		return nil, fmt.Errorf("privatekey must be a string")
+	}
+
+	// parse keypairid
+	kpid, ok := options["keypairid"]
+	if !ok {
+		return nil, fmt.Errorf("no keypairid provided")
+	}
+	keypairID, ok := kpid.(string)
+	if !ok {
+		return nil, fmt.Errorf("keypairid must be a string")
+	}
+
+	// get urlSigner from the file specified in pkPath
+	pkBytes, err := os.ReadFile(pkPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read privatekey file: %s", err)
+	}
+
+	block, _ := pem.Decode(pkBytes)
+	if block == nil {
+		return nil, fmt.Errorf("failed to decode private key as an rsa private key")
+	}
+	privateKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	return &keySigner{signer: sign.NewURLSigner(keypairID, privateKey)}, nil
+}
+
+func (s *keySigner) Sign(_ context.Context, rawURL, _ string, expires time.Time) (string, error) {
+	return s.signer.Sign(rawURL, expires)
+}
+
+// execSigner delegates the signing of URLs to an external signer, running
+// command or listening on socket, which holds the private key.
+type execSigner struct {
+	command string
+	socket  string
+	timeout time.Duration
+	slots   chan struct{}
+}
+
+// signRequest is the request sent to an exec signer.
+type signRequest struct {
+	Version int    `json:"version"`
+	URL     string `json:"url"`
+	Path    string `json:"path"`
+	Expires int64  `json:"expires"`
+}
+
+// signResponse is the response of an exec signer.
+type signResponse struct {
+	Version int    `json:"version"`
+	URL     string `json:"url,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// newExecSigner returns the execSigner configured by options.
+func newExecSigner(options map[string]any) (*execSigner, error) {
+	if _, ok := options["privatekey"]; ok {
+		return nil, fmt.Errorf("privatekey must not be set with the exec signer, which holds the private key")
+	}
+	if _, ok := options["keypairid"]; ok {
+		return nil, fmt.Errorf("keypairid must not be set with the exec signer, which holds the key pair")
+	}
+
+	s := &execSigner{timeout: defaultSignerTimeout}
+	if v, ok := options["signercommand"]; ok {
+		if s.command, ok = v.(string); !ok {
+			return nil, fmt.Errorf("signercommand must be a string")
+		}
+	}
+	if v, ok := options["signersocket"]; ok {
+		if s.socket, ok = v.(string); !ok {
+			return nil, fmt.Errorf("signersocket must be a string")
+		}
+	}
+	if (s.command == "") == (s.socket == "") {
+		return nil, fmt.Errorf("exactly one of signercommand and signersocket must be set with the exec signer")
+	}
+
+	if v, ok := options["signertimeout"]; ok {
+		switch v := v.(type) {
+		case time.Duration:
+			s.timeout = v
+		case string:
+			timeout, err := time.ParseDuration(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid signertimeout: %s", err)
+			}
+			s.timeout = timeout
+		default:
+			return nil, fmt.Errorf("signertimeout must be a duration")
+		}
+		if s.timeout <= 0 {
+			return nil, fmt.Errorf("signertimeout must be positive")
+		}
+	}
+
+	concurrency := defaultSignerConcurrency
+	if v, ok := options["signerconcurrency"]; ok {
+		n, err := strconv.Atoi(fmt.Sprint(v))
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("signerconcurrency must be a positive integer, %v invalid", v)
+		}
+		concurrency = n
+	}
+	s.slots = make(chan struct{}, concurrency)
+	return s, nil
+}
+
+func (s *execSigner) Sign(ctx context.Context, rawURL, path string, expires time.Time) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	select {
+	case s.slots <- struct{}{}:
+		defer func() { <-s.slots }()
+	case <-ctx.Done():
+		signerFailures.WithValues("busy").Inc(1)
+		return "", fmt.Errorf("cloudfront signer: no concurrency slot available: %w", ctx.Err())
+	}
+
+	request, err := json.Marshal(signRequest{
+		Version: SignerProtocolVersion,
+		URL:     rawURL,
+		Path:    path,
+		Expires: expires.Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	request = append(request, '\n')
+
+	var response []byte
+	if s.socket != "" {
+		response, err = s.signSocket(ctx, request)
+	} else {
+		response, err = s.signCommand(ctx, request)
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			signerFailures.WithValues("timeout").Inc(1)
+			return "", fmt.Errorf("cloudfront signer: timed out after %s: %w", s.timeout, err)
+		}
+		signerFailures.WithValues("error").Inc(1)
+		return "", fmt.Errorf("cloudfront signer: %w", err)
+	}
+
+	var resp signResponse
+	if err := json.Unmarshal(response, &resp); err != nil {
+		signerFailures.WithValues("malformed").Inc(1)
+		return "", fmt.Errorf("cloudfront signer: malformed response: %w", err)
+	}
+	if resp.Version != SignerProtocolVersion {
+		signerFailures.WithValues("malformed").Inc(1)
+		return "", fmt.Errorf("cloudfront signer: unsupported protocol version %d, expected %d", resp.Version, SignerProtocolVersion)
+	}
+	if resp.Error != "" {
+		signerFailures.WithValues("rejected").Inc(1)
+		return "", fmt.Errorf("cloudfront signer: %s", resp.Error)
+	}
+	if u, err := url.Parse(resp.URL); err != nil || !u.IsAbs() {
+		signerFailures.WithValues("malformed").Inc(1)
+		return "", fmt.Errorf("cloudfront signer: malformed response: invalid url %q", resp.URL)
+	}
+	return resp.URL, nil
+}
+
+// signCommand runs the signer command with request on its standard input,
+// and returns its standard output.
+func (s *execSigner) signCommand(ctx context.Context, request []byte) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, s.command)
+	cmd.Stdin = bytes.NewReader(request)
+	// do not wait for the children of the command keeping its output open
+	cmd.WaitDelay = time.Second
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &limitedBuffer{Buffer: &stdout, limit: maxSignerResponseSize}
+	cmd.Stderr = &limitedBuffer{Buffer: &stderr, limit: maxSignerResponseSize}
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%w: %s", err, msg)
+		}
+		return nil, err
+	}
+	return stdout.Bytes(), nil
+}
+
+// signSocket sends request to the signer listening on the socket, and
+// returns its response.
+func (s *execSigner) signSocket(ctx context.Context, request []byte) ([]byte, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "unix", s.socket)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	// interrupt the exchange once ctx is done
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.SetDeadline(time.Now())
+	})
+	defer stop()
+
+	if _, err := conn.Write(request); err != nil {
+		return nil, err
+	}
+	response, err := bufio.NewReader(io.LimitReader(conn, maxSignerResponseSize)).ReadBytes('\n')
+	if err != nil && (!errors.Is(err, io.EOF) || len(response) == 0) {
+		return nil, err
+	}
+	return response, nil
+}
+
+// limitedBuffer is a bytes.Buffer failing the writes beyond limit.
+type limitedBuffer struct {
+	*bytes.Buffer
+	limit int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.Len()+len(p) > b.limit {
+		return 0, fmt.Errorf("signer output exceeds %d bytes", b.limit)
+	}
+	return b.Buffer.Write(p)
+}
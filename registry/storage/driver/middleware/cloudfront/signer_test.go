@@ -0,0 +1,203 @@
+package middleware
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/registry/storage/driver/s3-aws"
+	"github.com/stretchr/testify/require"
+)
+
+// writeSigner writes a signer command running script, and returns its path.
+func writeSigner(t *testing.T, script string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "signer")
+	require.NoError(t, os.WriteFile(path, []byte("#!/bin/sh\n"+script), 0o700))
+	return path
+}
+
+// listenSigner serves a signer daemon on a unix socket, responding to each
+// request with respond, and returns the path of the socket.
+func listenSigner(t *testing.T, respond func(request signRequest) string) string {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "signer")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "signer.sock")
+	listener, err := net.Listen("unix", path)
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				line, err := bufio.NewReader(conn).ReadBytes('\n')
+				if err != nil {
+					return
+				}
+				var request signRequest
+				if err := json.Unmarshal(line, &request); err != nil {
+					return
+				}
+				_, _ = conn.Write([]byte(respond(request)))
+			}()
+		}
+	}()
+	return path
+}
+
+func TestExecSignerCommand(t *testing.T) {
+	dir := t.TempDir()
+	expires := time.Unix(1714644000, 0)
+
+	for _, tc := range []struct {
+		name   string
+		script string
+		signed string
+		err    string
+	}{
+		{
+			name:   "signed",
+			script: `cat > ` + filepath.Join(dir, "request") + `; echo '{"version": 1, "url": "https://cdn.example.com/key?Signature=abc"}'`,
+			signed: "https://cdn.example.com/key?Signature=abc",
+		},
+		{name: "rejected", script: `echo '{"version": 1, "error": "key unavailable"}'`, err: "key unavailable"},
+		{name: "malformed", script: `echo 'signed'`, err: "malformed response"},
+		{name: "relative url", script: `echo '{"version": 1, "url": "key"}'`, err: "malformed response"},
+		{name: "unsupported version", script: `echo '{"version": 2, "url": "https://cdn.example.com/key"}'`, err: "unsupported protocol version 2"},
+		{name: "failed", script: `echo 'no key' >&2; exit 1`, err: "no key"},
+		{name: "timeout", script: `exec sleep 10`, err: "timed out"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			signer, err := newExecSigner(map[string]any{
+				"signercommand": writeSigner(t, tc.script),
+				"signertimeout": "500ms",
+			})
+			require.NoError(t, err)
+
+			signed, err := signer.Sign(context.Background(), "https://cdn.example.com/key", "key", expires)
+			if tc.err != "" {
+				require.ErrorContains(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.signed, signed)
+
+			var request signRequest
+			data, err := os.ReadFile(filepath.Join(dir, "request"))
+			require.NoError(t, err)
+			require.NoError(t, json.Unmarshal(data, &request))
+			require.Equal(t, signRequest{Version: SignerProtocolVersion, URL: "https://cdn.example.com/key", Path: "key", Expires: expires.Unix()}, request)
+		})
+	}
+}
+
+func TestExecSignerSocket(t *testing.T) {
+	socket := listenSigner(t, func(request signRequest) string {
+		switch request.Path {
+		case "slow":
+			time.Sleep(time.Second)
+		case "malformed":
+			return "{\"version\": 1, \"url\"\n"
+		case "unterminated":
+			return `{"version": 1, "url": "https://cdn.example.com/unterminated?Signature=abc"}`
+		}
+		return `{"version": 1, "url": "` + request.URL + `?Expires=` + time.Unix(request.Expires, 0).UTC().Format("20060102") + `"}` + "\n"
+	})
+	signer, err := newExecSigner(map[string]any{
+		"signersocket":  socket,
+		"signertimeout": 200 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	expires := time.Date(2024, 5, 2, 10, 0, 0, 0, time.UTC)
+
+	signed, err := signer.Sign(context.Background(), "https://cdn.example.com/key", "key", expires)
+	require.NoError(t, err)
+	require.Equal(t, "https://cdn.example.com/key?Expires=20240502", signed)
+
+	signed, err = signer.Sign(context.Background(), "https://cdn.example.com/unterminated", "unterminated", expires)
+	require.NoError(t, err)
+	require.Equal(t, "https://cdn.example.com/unterminated?Signature=abc", signed)
+
+	_, err = signer.Sign(context.Background(), "https://cdn.example.com/malformed", "malformed", expires)
+	require.ErrorContains(t, err, "malformed response")
+
+	_, err = signer.Sign(context.Background(), "https://cdn.example.com/slow", "slow", expires)
+	require.ErrorContains(t, err, "timed out")
+}
+
+func TestExecSignerConcurrency(t *testing.T) {
+	signer, err := newExecSigner(map[string]any{
+		"signercommand":     writeSigner(t, `exec sleep 10`),
+		"signertimeout":     "200ms",
+		"signerconcurrency": 1,
+	})
+	require.NoError(t, err)
+
+	// the slot taken, the signing times out waiting for it
+	signer.slots <- struct{}{}
+	_, err = signer.Sign(context.Background(), "https://cdn.example.com/key", "key", time.Now())
+	require.ErrorContains(t, err, "no concurrency slot available")
+}
+
+func TestExecSignerOptions(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		options map[string]any
+		err     string
+	}{
+		{name: "no signer", options: map[string]any{}, err: "exactly one of signercommand and signersocket"},
+		{name: "both signers", options: map[string]any{"signercommand": "/bin/signer", "signersocket": "/run/signer.sock"}, err: "exactly one of signercommand and signersocket"},
+		{name: "private key", options: map[string]any{"signercommand": "/bin/signer", "privatekey": "/etc/pk.pem"}, err: "privatekey must not be set"},
+		{name: "key pair", options: map[string]any{"signercommand": "/bin/signer", "keypairid": "id"}, err: "keypairid must not be set"},
+		{name: "invalid timeout", options: map[string]any{"signercommand": "/bin/signer", "signertimeout": "soon"}, err: "invalid signertimeout"},
+		{name: "negative timeout", options: map[string]any{"signercommand": "/bin/signer", "signertimeout": "-1s"}, err: "signertimeout must be positive"},
+		{name: "invalid concurrency", options: map[string]any{"signercommand": "/bin/signer", "signerconcurrency": 0}, err: "signerconcurrency must be a positive integer"},
+		{name: "concurrency as string", options: map[string]any{"signercommand": "/bin/signer", "signerconcurrency": "4"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := newExecSigner(tc.options)
+			if tc.err != "" {
+				require.ErrorContains(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestCloudFrontStorageMiddlewareExecSigner(t *testing.T) {
+	s3Driver, err := s3.FromParameters(context.Background(), map[string]any{
+		"region": "us-east-1",
+		"bucket": "test",
+	})
+	require.NoError(t, err)
+
+	storageDriver, err := newCloudFrontStorageMiddleware(context.Background(), s3Driver, map[string]any{
+		"baseurl":       "cdn.example.com",
+		"signer":        "exec",
+		"signercommand": writeSigner(t, `echo '{"version": 1, "url": "https://cdn.example.com/signed"}'`),
+	})
+	require.NoError(t, err)
+	signed, err := storageDriver.RedirectURL(httptest.NewRequest("GET", "/v2/foo/bar/blobs/sha256:abc", nil), "/docker/registry/v2/blobs/ab/abc/data")
+	require.NoError(t, err)
+	require.Equal(t, "https://cdn.example.com/signed", signed)
+
+	_, err = newCloudFrontStorageMiddleware(context.Background(), s3Driver, map[string]any{
+		"baseurl": "cdn.example.com",
+		"signer":  "kms",
+	})
+	require.ErrorContains(t, err, "signer only allows")
+}
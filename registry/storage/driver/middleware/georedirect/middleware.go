@@ -0,0 +1,227 @@
+// Package middleware - georedirect wrapper redirecting blob pulls to regional
+// registries
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/internal/requestutil"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	storagemiddleware "github.com/distribution/distribution/v3/registry/storage/driver/middleware"
+	cloudfront "github.com/distribution/distribution/v3/registry/storage/driver/middleware/cloudfront"
+	"github.com/sirupsen/logrus"
+)
+
+func init() {
+	if err := storagemiddleware.Register("georedirect", newGeoRedirectStorageMiddleware); err != nil {
+		logrus.Errorf("failed to register georedirect storage middleware: %v", err)
+	}
+}
+
+// regionNetwork is a network of clients of a region.
+type regionNetwork struct {
+	network net.IPNet
+	region  string
+}
+
+// geoRedirectStorageMiddleware redirects blob pulls to the registry of the
+// region of the client, and falls back to the storage driver otherwise.
+type geoRedirectStorageMiddleware struct {
+	storagedriver.StorageDriver
+	// registries are the base URLs of the regional registries, by region.
+	registries map[string]*url.URL
+	// networks are sorted by decreasing prefix length, so that the most
+	// specific network of a client is found first.
+	networks   []regionNetwork
+	awsRegions *cloudfront.AWSRegions
+}
+
+var _ storagedriver.StorageDriver = &geoRedirectStorageMiddleware{}
+
+// newGeoRedirectStorageMiddleware constructs and returns a new georedirect
+// storage middleware.
+//
+// Required options:
+//
+//   - registries: the base URLs of the regional registries, by region.
+//
+// Optional options:
+//
+//   - ipranges: the client networks of each region, in CIDR notation.
+//   - awsipranges: resolve the region of clients within AWS from the AWS IP
+//     ranges, for clients not matching ipranges.
+//   - iprangesurl: the URL of the AWS IP ranges.
+//   - updatefrequency: how often the AWS IP ranges are updated.
+func newGeoRedirectStorageMiddleware(ctx context.Context, sd storagedriver.StorageDriver, options map[string]any) (storagedriver.StorageDriver, error) {
+	m := &geoRedirectStorageMiddleware{
+		StorageDriver: sd,
+		registries:    make(map[string]*url.URL),
+	}
+
+	registries, err := getMapOption("registries", options)
+	if err != nil {
+		return nil, err
+	}
+	if len(registries) == 0 {
+		return nil, fmt.Errorf("no registries provided")
+	}
+	for region, v := range registries {
+		baseURL, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("registry of region %s must be a string", region)
+		}
+		u, err := url.Parse(baseURL)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse registry of region %s: %s", region, baseURL)
+		}
+		if u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("registry of region %s must be an absolute URL: %s", region, baseURL)
+		}
+		m.registries[strings.ToLower(region)] = u
+	}
+
+	ipRanges, err := getMapOption("ipranges", options)
+	if err != nil {
+		return nil, err
+	}
+	for region, v := range ipRanges {
+		var cidrs []string
+		switch v := v.(type) {
+		case []string:
+			cidrs = v
+		case []any:
+			for _, cidr := range v {
+				s, ok := cidr.(string)
+				if !ok {
+					return nil, fmt.Errorf("ipranges of region %s must be a list of strings", region)
+				}
+				cidrs = append(cidrs, s)
+			}
+		default:
+			return nil, fmt.Errorf("ipranges of region %s must be a list", region)
+		}
+		for _, cidr := range cidrs {
+			_, network, err := net.ParseCIDR(cidr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid iprange of region %s: %s", region, err)
+			}
+			m.networks = append(m.networks, regionNetwork{network: *network, region: strings.ToLower(region)})
+		}
+	}
+	sort.SliceStable(m.networks, func(i, j int) bool {
+		a, _ := m.networks[i].network.Mask.Size()
+		b, _ := m.networks[j].network.Mask.Size()
+		return a > b
+	})
+
+	if v, ok := options["awsipranges"]; ok {
+		enabled, ok := v.(bool)
+		if !ok {
+			return nil, fmt.Errorf("awsipranges must be a boolean")
+		}
+		if enabled {
+			ipRangesURL, ok := options["iprangesurl"].(string)
+			if _, set := options["iprangesurl"]; set && !ok {
+				return nil, fmt.Errorf("iprangesurl must be a string")
+			}
+			var updateFrequency time.Duration
+			switch u := options["updatefrequency"].(type) {
+			case nil:
+			case time.Duration:
+				updateFrequency = u
+			case string:
+				updateFrequency, err = time.ParseDuration(u)
+				if err != nil {
+					return nil, fmt.Errorf("invalid updatefrequency: %s", err)
+				}
+			default:
+				return nil, fmt.Errorf("invalid updatefrequency: %v", u)
+			}
+			m.awsRegions, err = cloudfront.NewAWSRegions(ctx, ipRangesURL, updateFrequency)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if len(m.networks) == 0 && m.awsRegions == nil {
+		return nil, fmt.Errorf("no ipranges provided and awsipranges disabled")
+	}
+	return m, nil
+}
+
+// getMapOption returns the map option key, which is nil if it is not set.
+func getMapOption(key string, options map[string]any) (map[string]any, error) {
+	switch o := options[key].(type) {
+	case nil:
+		return nil, nil
+	case map[string]any:
+		return o, nil
+	case map[any]any:
+		m := make(map[string]any, len(o))
+		for k, v := range o {
+			s, ok := k.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s keys must be strings", key)
+			}
+			m[s] = v
+		}
+		return m, nil
+	default:
+		return nil, fmt.Errorf("%s must be a map", key)
+	}
+}
+
+// region returns the region of the client address ip, or an empty string if
+// it is unknown.
+func (m *geoRedirectStorageMiddleware) region(ip net.IP) string {
+	for _, n := range m.networks {
+		if n.network.Contains(ip) {
+			return n.region
+		}
+	}
+	if m.awsRegions != nil {
+		return m.awsRegions.Region(ip)
+	}
+	return ""
+}
+
+// RedirectURL redirects blob pulls to the same blob on the registry of the
+// region of the client, and falls back to the storage driver for other
+// requests and for clients of other regions.
+func (m *geoRedirectStorageMiddleware) RedirectURL(r *http.Request, urlPath string) (string, error) {
+	if r == nil || r.Method != http.MethodGet {
+		return m.StorageDriver.RedirectURL(r, urlPath)
+	}
+
+	remoteIP := requestutil.RemoteIP(r)
+	ip := net.ParseIP(remoteIP)
+	if ip == nil {
+		dcontext.GetLogger(r.Context()).Warnf("georedirect: invalid client address %q, serving locally", remoteIP)
+		return m.StorageDriver.RedirectURL(r, urlPath)
+	}
+
+	region := m.region(ip)
+	base, ok := m.registries[region]
+	if !ok {
+		return m.StorageDriver.RedirectURL(r, urlPath)
+	}
+
+	u := *base
+	u.Path = path.Join("/", base.Path, r.URL.Path)
+	u.RawQuery = r.URL.RawQuery
+	dcontext.GetLoggerWithFields(r.Context(), map[any]any{
+		"ip":     remoteIP,
+		"region": region,
+	}).Debug("georedirect: redirecting to regional registry")
+	return u.String(), nil
+}
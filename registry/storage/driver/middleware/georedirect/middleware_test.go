@@ -0,0 +1,117 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/stretchr/testify/require"
+)
+
+const blobPath = "/v2/library/alpine/blobs/sha256:8e1ffdb5a3c0aab8b5cff7bfabe2c0fc0d19dc10c7f5e5b6f1ca5c2a1ddcafd4"
+
+func newRequest(method, remoteAddr string) *http.Request {
+	r := httptest.NewRequest(method, "https://central.example.com"+blobPath, nil)
+	r.RemoteAddr = remoteAddr
+	return r
+}
+
+func TestInvalidOptions(t *testing.T) {
+	for _, tc := range []struct {
+		options map[string]any
+		err     string
+	}{
+		{map[string]any{}, "no registries provided"},
+		{map[string]any{"registries": "eu"}, "registries must be a map"},
+		{map[string]any{"registries": map[string]any{"eu": "eu.example.com"}}, "must be an absolute URL"},
+		{map[string]any{"registries": map[string]any{"eu": "https://eu.example.com"}}, "no ipranges provided"},
+		{map[string]any{
+			"registries": map[string]any{"eu": "https://eu.example.com"},
+			"ipranges":   map[string]any{"eu": []any{"10.0.0.0"}},
+		}, "invalid iprange of region eu"},
+		{map[string]any{
+			"registries":  map[string]any{"eu": "https://eu.example.com"},
+			"awsipranges": "yes",
+		}, "awsipranges must be a boolean"},
+	} {
+		_, err := newGeoRedirectStorageMiddleware(context.Background(), inmemory.New(), tc.options)
+		require.ErrorContains(t, err, tc.err)
+	}
+}
+
+func TestRegionMatching(t *testing.T) {
+	middleware, err := newGeoRedirectStorageMiddleware(context.Background(), inmemory.New(), map[string]any{
+		"registries": map[any]any{
+			"EU": "https://eu.example.com",
+			"us": "https://us.example.com/prefix/",
+		},
+		"ipranges": map[any]any{
+			"eu": []any{"10.0.0.0/8", "2001:db8::/32"},
+			"us": []any{"10.1.0.0/16"},
+			// no registry for this region
+			"ap": []any{"10.2.0.0/16"},
+		},
+	})
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		method     string
+		remoteAddr string
+		expected   string
+	}{
+		{http.MethodGet, "10.0.0.1:1234", "https://eu.example.com" + blobPath},
+		{http.MethodGet, "[2001:db8::1]:1234", "https://eu.example.com" + blobPath},
+		// the most specific network wins
+		{http.MethodGet, "10.1.0.1:1234", "https://us.example.com/prefix" + blobPath},
+		// fallbacks
+		{http.MethodGet, "10.2.0.1:1234", ""},
+		{http.MethodGet, "192.168.0.1:1234", ""},
+		{http.MethodGet, "invalid", ""},
+		{http.MethodHead, "10.0.0.1:1234", ""},
+	} {
+		url, err := middleware.RedirectURL(newRequest(tc.method, tc.remoteAddr), "/docker/registry/v2/blobs/data")
+		require.NoError(t, err)
+		require.Equal(t, tc.expected, url, "%s from %s", tc.method, tc.remoteAddr)
+	}
+}
+
+func TestAWSRegionMatching(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"prefixes": []map[string]string{
+				{"ip_prefix": "52.0.0.0/16", "region": "eu-west-1"},
+				{"ip_prefix": "52.1.0.0/16", "region": "us-east-1"},
+			},
+		})
+	}))
+	defer server.Close()
+
+	middleware, err := newGeoRedirectStorageMiddleware(context.Background(), inmemory.New(), map[string]any{
+		"registries": map[string]any{
+			"eu-west-1": "https://eu.example.com",
+		},
+		"ipranges": map[string]any{
+			"eu-west-1": []string{"10.0.0.0/8"},
+		},
+		"awsipranges": true,
+		"iprangesurl": server.URL,
+	})
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		remoteAddr string
+		expected   string
+	}{
+		{"10.0.0.1:1234", "https://eu.example.com" + blobPath},
+		{"52.0.0.1:1234", "https://eu.example.com" + blobPath},
+		// no registry for this region
+		{"52.1.0.1:1234", ""},
+	} {
+		url, err := middleware.RedirectURL(newRequest(http.MethodGet, tc.remoteAddr), "/docker/registry/v2/blobs/data")
+		require.NoError(t, err)
+		require.Equal(t, tc.expected, url, tc.remoteAddr)
+	}
+}
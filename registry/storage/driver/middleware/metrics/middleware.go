@@ -0,0 +1,220 @@
+// Package middleware - metrics wrapper observing the duration of the storage
+// actions, optionally by repository
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	prometheus "github.com/distribution/distribution/v3/metrics"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	storagemiddleware "github.com/distribution/distribution/v3/registry/storage/driver/middleware"
+	"github.com/docker/go-metrics"
+	promclient "github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// defaultMaxRepositories is the default number of repositories labeled
+	// by name.
+	defaultMaxRepositories = 100
+
+	// otherRepositories labels the actions on the repositories beyond the
+	// maximum number of repositories.
+	otherRepositories = "other"
+)
+
+var (
+	// actionDuration is the duration of the storage actions.
+	actionDuration = promclient.NewHistogramVec(promclient.HistogramOpts{
+		Namespace: prometheus.NamespacePrefix,
+		Subsystem: "storage_middleware",
+		Name:      "action_seconds",
+		Help:      "The number of seconds that the storage action takes",
+		Buckets:   promclient.DefBuckets,
+	}, []string{"driver", "action"})
+
+	// repositoryActionDuration is the duration of the storage actions, by
+	// repository.
+	repositoryActionDuration = promclient.NewHistogramVec(promclient.HistogramOpts{
+		Namespace: prometheus.NamespacePrefix,
+		Subsystem: "storage_middleware",
+		Name:      "repository_action_seconds",
+		Help:      "The number of seconds that the storage action on a repository takes",
+		Buckets:   promclient.DefBuckets,
+	}, []string{"driver", "action", "repository"})
+)
+
+func init() {
+	prometheus.StorageMiddlewareNamespace.Add(actionDuration)
+	prometheus.StorageMiddlewareNamespace.Add(repositoryActionDuration)
+	metrics.Register(prometheus.StorageMiddlewareNamespace)
+
+	if err := storagemiddleware.Register("metrics", newMetricsStorageMiddleware); err != nil {
+		logrus.Errorf("failed to register metrics storage middleware: %v", err)
+	}
+}
+
+// metricsStorageMiddleware observes the duration of the actions of the
+// storage driver. The actions are labeled by repository only if configured,
+// and the number of repository label values is bounded, so that registries
+// with many repositories do not explode the cardinality of the metrics.
+type metricsStorageMiddleware struct {
+	storagedriver.StorageDriver
+	repositoryLabel bool
+	maxRepositories int
+
+	mu           sync.Mutex
+	repositories map[string]struct{}
+}
+
+var _ storagedriver.StorageDriver = &metricsStorageMiddleware{}
+
+// newMetricsStorageMiddleware constructs and returns a new metrics storage
+// middleware.
+//
+// Optional options:
+//
+//   - repositorylabel: label the actions by repository, false by default.
+//   - maxrepositories: the number of repositories labeled by name, the
+//     actions on further repositories being labeled "other", 100 by default.
+func newMetricsStorageMiddleware(ctx context.Context, sd storagedriver.StorageDriver, options map[string]any) (storagedriver.StorageDriver, error) {
+	m := &metricsStorageMiddleware{
+		StorageDriver:   sd,
+		maxRepositories: defaultMaxRepositories,
+		repositories:    make(map[string]struct{}),
+	}
+
+	if v, ok := options["repositorylabel"]; ok {
+		b, err := strconv.ParseBool(fmt.Sprint(v))
+		if err != nil {
+			return nil, fmt.Errorf("repositorylabel must be a boolean, %v invalid", v)
+		}
+		m.repositoryLabel = b
+	}
+
+	if v, ok := options["maxrepositories"]; ok {
+		n, err := strconv.Atoi(fmt.Sprint(v))
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("maxrepositories must be a positive integer, %v invalid", v)
+		}
+		m.maxRepositories = n
+	}
+
+	return m, nil
+}
+
+// observe records the duration of action on path since start, with the
+// trace of ctx as exemplar if enabled.
+func (m *metricsStorageMiddleware) observe(ctx context.Context, action, path string, start time.Time) {
+	if !m.repositoryLabel {
+		prometheus.ObserveDuration(ctx, actionDuration.WithLabelValues(m.Name(), action), start)
+		return
+	}
+	prometheus.ObserveDuration(ctx, repositoryActionDuration.WithLabelValues(m.Name(), action, m.repository(path)), start)
+}
+
+// repository returns the repository label of path: the name of the
+// repository, "other" once the maximum number of repositories is labeled, or
+// the empty string for paths outside of the repositories.
+func (m *metricsStorageMiddleware) repository(path string) string {
+	name := repositoryName(path)
+	if name == "" {
+		return ""
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.repositories[name]; ok {
+		return name
+	}
+	if len(m.repositories) >= m.maxRepositories {
+		return otherRepositories
+	}
+	m.repositories[name] = struct{}{}
+	return name
+}
+
+// repositoryName returns the name of the repository of path, which is made
+// of the components following "repositories" up to the first component
+// starting with an underscore, such as _manifests.
+func repositoryName(path string) string {
+	_, rest, ok := strings.Cut(path, "/repositories/")
+	if !ok {
+		return ""
+	}
+	var components []string
+	for _, component := range strings.Split(rest, "/") {
+		if component == "" || strings.HasPrefix(component, "_") {
+			break
+		}
+		components = append(components, component)
+	}
+	return strings.Join(components, "/")
+}
+
+func (m *metricsStorageMiddleware) GetContent(ctx context.Context, path string) ([]byte, error) {
+	defer m.observe(ctx, "GetContent", path, time.Now())
+	return m.StorageDriver.GetContent(ctx, path)
+}
+
+func (m *metricsStorageMiddleware) PutContent(ctx context.Context, path string, content []byte) error {
+	defer m.observe(ctx, "PutContent", path, time.Now())
+	return m.StorageDriver.PutContent(ctx, path, content)
+}
+
+func (m *metricsStorageMiddleware) Reader(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
+	defer m.observe(ctx, "Reader", path, time.Now())
+	return m.StorageDriver.Reader(ctx, path, offset)
+}
+
+func (m *metricsStorageMiddleware) Writer(ctx context.Context, path string, append bool) (storagedriver.FileWriter, error) {
+	defer m.observe(ctx, "Writer", path, time.Now())
+	return m.StorageDriver.Writer(ctx, path, append)
+}
+
+func (m *metricsStorageMiddleware) Stat(ctx context.Context, path string) (storagedriver.FileInfo, error) {
+	defer m.observe(ctx, "Stat", path, time.Now())
+	return m.StorageDriver.Stat(ctx, path)
+}
+
+func (m *metricsStorageMiddleware) List(ctx context.Context, path string) ([]string, error) {
+	defer m.observe(ctx, "List", path, time.Now())
+	return m.StorageDriver.List(ctx, path)
+}
+
+func (m *metricsStorageMiddleware) ListPages(ctx context.Context, path string, f func(paths []string) error) error {
+	defer m.observe(ctx, "ListPages", path, time.Now())
+	return storagedriver.ListPages(ctx, m.StorageDriver, path, f)
+}
+
+func (m *metricsStorageMiddleware) DriverLimits() storagedriver.Limits {
+	return storagedriver.LimitsOf(m.StorageDriver)
+}
+
+func (m *metricsStorageMiddleware) Move(ctx context.Context, sourcePath string, destPath string) error {
+	defer m.observe(ctx, "Move", sourcePath, time.Now())
+	return m.StorageDriver.Move(ctx, sourcePath, destPath)
+}
+
+func (m *metricsStorageMiddleware) Delete(ctx context.Context, path string) error {
+	defer m.observe(ctx, "Delete", path, time.Now())
+	return m.StorageDriver.Delete(ctx, path)
+}
+
+func (m *metricsStorageMiddleware) RedirectURL(r *http.Request, path string) (string, error) {
+	defer m.observe(r.Context(), "RedirectURL", path, time.Now())
+	return m.StorageDriver.RedirectURL(r, path)
+}
+
+func (m *metricsStorageMiddleware) Walk(ctx context.Context, path string, f storagedriver.WalkFn, options ...func(*storagedriver.WalkOptions)) error {
+	defer m.observe(ctx, "Walk", path, time.Now())
+	return m.StorageDriver.Walk(ctx, path, f, options...)
+}
@@ -0,0 +1,109 @@
+package middleware
+
+import (
+	"context"
+	"sort"
+	"testing"
+
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	promclient "github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+// labels returns the label sets of the series of the collector c.
+func labels(t *testing.T, c promclient.Collector) []map[string]string {
+	t.Helper()
+	registry := promclient.NewRegistry()
+	require.NoError(t, registry.Register(c))
+	families, err := registry.Gather()
+	require.NoError(t, err)
+
+	var series []map[string]string
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			labels := make(map[string]string)
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			series = append(series, labels)
+		}
+	}
+	return series
+}
+
+// repositories returns the sorted repository label values of the series of
+// the collector c.
+func repositories(t *testing.T, c promclient.Collector) []string {
+	t.Helper()
+	var names []string
+	for _, series := range labels(t, c) {
+		names = append(names, series["repository"])
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestRepositoryLabel(t *testing.T) {
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		name         string
+		options      map[string]any
+		repositories []string
+	}{
+		{name: "default", options: map[string]any{}},
+		{name: "disabled", options: map[string]any{"repositorylabel": false}},
+		{name: "enabled", options: map[string]any{"repositorylabel": true}, repositories: []string{"", "library/alpine", "library/busybox", "nginx"}},
+		{name: "bounded", options: map[string]any{"repositorylabel": "true", "maxrepositories": 2}, repositories: []string{"", "library/alpine", "library/busybox", "other"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			actionDuration.Reset()
+			repositoryActionDuration.Reset()
+
+			driver, err := newMetricsStorageMiddleware(ctx, inmemory.New(), tc.options)
+			require.NoError(t, err)
+			for _, path := range []string{
+				"/docker/registry/v2/repositories/library/alpine/_manifests/tags/latest/current/link",
+				"/docker/registry/v2/repositories/library/busybox/_layers/sha256/abc/link",
+				"/docker/registry/v2/repositories/nginx/_uploads/id/data",
+				"/docker/registry/v2/blobs/sha256/ab/abc/data",
+			} {
+				require.NoError(t, driver.PutContent(ctx, path, []byte("content")))
+			}
+
+			if tc.repositories == nil {
+				require.Empty(t, labels(t, repositoryActionDuration))
+				for _, series := range labels(t, actionDuration) {
+					require.NotContains(t, series, "repository")
+					require.Equal(t, "PutContent", series["action"])
+				}
+				require.Len(t, labels(t, actionDuration), 1)
+				return
+			}
+			require.Empty(t, labels(t, actionDuration))
+			require.Equal(t, tc.repositories, repositories(t, repositoryActionDuration))
+		})
+	}
+}
+
+func TestInvalidOptions(t *testing.T) {
+	for _, options := range []map[string]any{
+		{"repositorylabel": "sometimes"},
+		{"maxrepositories": 0},
+		{"maxrepositories": "many"},
+	} {
+		_, err := newMetricsStorageMiddleware(context.Background(), inmemory.New(), options)
+		require.Error(t, err, "options %v", options)
+	}
+}
+
+func TestRepositoryName(t *testing.T) {
+	for path, name := range map[string]string{
+		"/docker/registry/v2/repositories/foo/bar/_manifests/revisions": "foo/bar",
+		"/docker/registry/v2/repositories/foo/bar":                      "foo/bar",
+		"/docker/registry/v2/repositories/":                             "",
+		"/docker/registry/v2/blobs/sha256/ab/abc/data":                  "",
+	} {
+		require.Equal(t, name, repositoryName(path), path)
+	}
+}
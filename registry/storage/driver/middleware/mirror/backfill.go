@@ -0,0 +1,88 @@
+package middleware
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+)
+
+// BackfillResult counts the objects visited by Backfill.
+type BackfillResult struct {
+	// Copied is the number of objects copied.
+	Copied int64
+	// Skipped is the number of objects already present in the destination.
+	Skipped int64
+	// Failed is the number of objects which could not be copied.
+	Failed int64
+}
+
+// Backfill copies the objects of from missing in to, using concurrency
+// workers. Objects already present in to are never overwritten: they were
+// either copied by an earlier backfill, which makes an interrupted backfill
+// resumable, or written to both drivers by the mirror middleware. Objects
+// which cannot be copied are logged and counted, and do not stop the
+// backfill.
+func Backfill(ctx context.Context, from, to storagedriver.StorageDriver, concurrency int) (BackfillResult, error) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	var (
+		copied, skipped, failed atomic.Int64
+		wg                      sync.WaitGroup
+		paths                   = make(chan string)
+	)
+	for range concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range paths {
+				logger := dcontext.GetLoggerWithField(ctx, "path", path)
+				if _, err := to.Stat(ctx, path); err == nil {
+					skipped.Add(1)
+					continue
+				} else if !isPathNotFound(err) {
+					logger.WithError(err).Error("mirror: failed to stat backfill destination")
+					failed.Add(1)
+					continue
+				}
+
+				err := copyObject(ctx, from, to, path)
+				switch {
+				case err == nil:
+					logger.Debug("mirror: backfilled object")
+					copied.Add(1)
+				case isPathNotFound(err):
+					// deleted since it was walked
+					skipped.Add(1)
+				default:
+					logger.WithError(err).Error("mirror: failed to backfill object")
+					failed.Add(1)
+				}
+			}
+		}()
+	}
+
+	err := from.Walk(ctx, "/", func(fileInfo storagedriver.FileInfo) error {
+		if fileInfo.IsDir() {
+			return nil
+		}
+		select {
+		case paths <- fileInfo.Path():
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	close(paths)
+	wg.Wait()
+
+	return BackfillResult{
+		Copied:  copied.Load(),
+		Skipped: skipped.Load(),
+		Failed:  failed.Load(),
+	}, err
+}
@@ -0,0 +1,487 @@
+// Package middleware - mirror writes to a secondary storage driver, for
+// migrations from one storage backend to another.
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/factory"
+	storagemiddleware "github.com/distribution/distribution/v3/registry/storage/driver/middleware"
+	"github.com/sirupsen/logrus"
+)
+
+func init() {
+	if err := storagemiddleware.Register("mirror", newMirrorStorageMiddleware); err != nil {
+		logrus.Errorf("failed to register mirror storage middleware: %v", err)
+	}
+}
+
+// defaultRetryInterval is how often failed writes to the secondary driver
+// are retried by default.
+const defaultRetryInterval = time.Minute
+
+// mirrorStorageMiddleware writes to both the storage driver it wraps, the
+// primary, and a secondary driver. Reads are served by the primary driver,
+// falling back to the secondary driver for content the primary lacks.
+type mirrorStorageMiddleware struct {
+	storagedriver.StorageDriver
+	secondary storagedriver.StorageDriver
+
+	// failOnSecondaryError makes failed writes to the secondary driver
+	// fail the request instead of being retried in the background.
+	failOnSecondaryError bool
+
+	mu      sync.Mutex
+	pending map[string]mirrorOp
+}
+
+var _ storagedriver.StorageDriver = &mirrorStorageMiddleware{}
+
+// mirrorOp is an operation to replay on the secondary driver.
+type mirrorOp int
+
+const (
+	// opCopy copies the content of a path from the primary driver.
+	opCopy mirrorOp = iota
+	// opDelete deletes a path.
+	opDelete
+)
+
+func (op mirrorOp) String() string {
+	if op == opDelete {
+		return "delete"
+	}
+	return "copy"
+}
+
+func newMirrorStorageMiddleware(ctx context.Context, sd storagedriver.StorageDriver, options map[string]any) (storagedriver.StorageDriver, error) {
+	secondary, err := NewSecondary(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &mirrorStorageMiddleware{
+		StorageDriver: sd,
+		secondary:     secondary,
+		pending:       make(map[string]mirrorOp),
+	}
+
+	if f, ok := options["failonsecondaryerror"]; ok {
+		failOnSecondaryError, ok := f.(bool)
+		if !ok {
+			return nil, fmt.Errorf("failonsecondaryerror must be a boolean")
+		}
+		m.failOnSecondaryError = failOnSecondaryError
+	}
+
+	retryInterval := defaultRetryInterval
+	if r, ok := options["retryinterval"]; ok {
+		switch r := r.(type) {
+		case time.Duration:
+			retryInterval = r
+		case string:
+			interval, err := time.ParseDuration(r)
+			if err != nil {
+				return nil, fmt.Errorf("invalid retryinterval: %s", err)
+			}
+			retryInterval = interval
+		default:
+			return nil, fmt.Errorf("retryinterval must be a duration")
+		}
+	}
+	if retryInterval <= 0 {
+		return nil, fmt.Errorf("retryinterval must be positive")
+	}
+
+	go m.retrier(retryInterval)
+
+	return m, nil
+}
+
+// NewSecondary creates the secondary storage driver described by the options
+// of the mirror middleware.
+func NewSecondary(ctx context.Context, options map[string]any) (storagedriver.StorageDriver, error) {
+	name, ok := options["driver"].(string)
+	if !ok || name == "" {
+		return nil, fmt.Errorf("driver must be the name of a storage driver")
+	}
+
+	parameters := make(map[string]any)
+	switch p := options["parameters"].(type) {
+	case nil:
+	case map[string]any:
+		parameters = p
+	case map[any]any:
+		for k, v := range p {
+			key, ok := k.(string)
+			if !ok {
+				return nil, fmt.Errorf("parameters must be a map of strings")
+			}
+			parameters[key] = v
+		}
+	default:
+		return nil, fmt.Errorf("parameters must be a map")
+	}
+
+	secondary, err := factory.Create(ctx, name, parameters)
+	if err != nil {
+		return nil, fmt.Errorf("failed to construct secondary %s driver: %v", name, err)
+	}
+	return secondary, nil
+}
+
+func isPathNotFound(err error) bool {
+	var notFound storagedriver.PathNotFoundError
+	return errors.As(err, &notFound)
+}
+
+// secondaryFailed handles the failure of op on path in the secondary driver.
+// Unless the middleware fails on secondary errors, it logs the failure,
+// queues op for retry and returns nil.
+func (m *mirrorStorageMiddleware) secondaryFailed(ctx context.Context, op mirrorOp, path string, err error) error {
+	if m.failOnSecondaryError {
+		return fmt.Errorf("mirror: secondary %s driver: %w", m.secondary.Name(), err)
+	}
+
+	dcontext.GetLoggerWithFields(ctx, map[any]any{
+		"path":      path,
+		"operation": op,
+	}).WithError(err).Warn("mirror: write to secondary storage driver failed, queueing for retry")
+	m.queue(path, op)
+	return nil
+}
+
+// queue schedules op on path for the next retry, replacing any operation
+// already queued for path.
+func (m *mirrorStorageMiddleware) queue(path string, op mirrorOp) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pending[path] = op
+}
+
+// retrier replays the queued operations every interval. It is meant to be
+// run in a background goroutine.
+func (m *mirrorStorageMiddleware) retrier(interval time.Duration) {
+	ctx := context.Background()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		m.retry(ctx)
+	}
+}
+
+// retry replays the queued operations on the secondary driver. Operations
+// which fail again stay queued.
+func (m *mirrorStorageMiddleware) retry(ctx context.Context) {
+	m.mu.Lock()
+	pending := m.pending
+	m.pending = make(map[string]mirrorOp)
+	m.mu.Unlock()
+
+	for path, op := range pending {
+		var err error
+		switch op {
+		case opCopy:
+			err = copyObject(ctx, m.StorageDriver, m.secondary, path)
+			if isPathNotFound(err) {
+				// the path was deleted or moved from the primary
+				// driver since, which queued its own operation
+				err = nil
+			}
+		case opDelete:
+			err = m.secondary.Delete(ctx, path)
+			if isPathNotFound(err) {
+				err = nil
+			}
+		}
+		if err == nil {
+			continue
+		}
+
+		dcontext.GetLoggerWithFields(ctx, map[any]any{
+			"path":      path,
+			"operation": op,
+		}).WithError(err).Warn("mirror: retry on secondary storage driver failed")
+
+		m.mu.Lock()
+		if _, ok := m.pending[path]; !ok {
+			m.pending[path] = op
+		}
+		m.mu.Unlock()
+	}
+}
+
+// copyObject copies the content of path from one driver to another.
+func copyObject(ctx context.Context, from, to storagedriver.StorageDriver, path string) error {
+	reader, err := from.Reader(ctx, path, 0)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	writer, err := to.Writer(ctx, path, false)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(writer, reader); err != nil {
+		writer.Cancel(ctx)
+		writer.Close()
+		return err
+	}
+	if err := writer.Commit(ctx); err != nil {
+		writer.Cancel(ctx)
+		writer.Close()
+		return err
+	}
+	return writer.Close()
+}
+
+// GetContent returns the content of path from the primary driver, or from
+// the secondary driver if the primary driver does not have it.
+func (m *mirrorStorageMiddleware) GetContent(ctx context.Context, path string) ([]byte, error) {
+	content, err := m.StorageDriver.GetContent(ctx, path)
+	if isPathNotFound(err) {
+		if content, serr := m.secondary.GetContent(ctx, path); serr == nil {
+			return content, nil
+		}
+	}
+	return content, err
+}
+
+// PutContent stores content at path in both drivers.
+func (m *mirrorStorageMiddleware) PutContent(ctx context.Context, path string, content []byte) error {
+	if err := m.StorageDriver.PutContent(ctx, path, content); err != nil {
+		return err
+	}
+	if err := m.secondary.PutContent(ctx, path, content); err != nil {
+		return m.secondaryFailed(ctx, opCopy, path, err)
+	}
+	return nil
+}
+
+// Reader reads path from the primary driver, or from the secondary driver
+// if the primary driver does not have it.
+func (m *mirrorStorageMiddleware) Reader(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
+	reader, err := m.StorageDriver.Reader(ctx, path, offset)
+	if isPathNotFound(err) {
+		if reader, serr := m.secondary.Reader(ctx, path, offset); serr == nil {
+			return reader, nil
+		}
+	}
+	return reader, err
+}
+
+// Writer returns a FileWriter writing to both drivers.
+func (m *mirrorStorageMiddleware) Writer(ctx context.Context, path string, append bool) (storagedriver.FileWriter, error) {
+	primary, err := m.StorageDriver.Writer(ctx, path, append)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &mirrorWriter{
+		ctx:     ctx,
+		m:       m,
+		path:    path,
+		primary: primary,
+	}
+
+	secondary, err := m.secondary.Writer(ctx, path, append)
+	if err == nil && secondary.Size() != primary.Size() {
+		// the secondary driver missed earlier writes, so appending
+		// to its content would corrupt it
+		err = fmt.Errorf("secondary size %d does not match primary size %d", secondary.Size(), primary.Size())
+		secondary.Cancel(ctx)
+		secondary.Close()
+	}
+	if err != nil {
+		if m.failOnSecondaryError {
+			primary.Close()
+			return nil, m.secondaryFailed(ctx, opCopy, path, err)
+		}
+		w.secondaryFailed(err)
+		return w, nil
+	}
+
+	w.secondary = secondary
+	return w, nil
+}
+
+// Stat returns the FileInfo of path from the primary driver, or from the
+// secondary driver if the primary driver does not have it.
+func (m *mirrorStorageMiddleware) Stat(ctx context.Context, path string) (storagedriver.FileInfo, error) {
+	fi, err := m.StorageDriver.Stat(ctx, path)
+	if isPathNotFound(err) {
+		if fi, serr := m.secondary.Stat(ctx, path); serr == nil {
+			return fi, nil
+		}
+	}
+	return fi, err
+}
+
+// List returns the union of the descendants of path in both drivers.
+func (m *mirrorStorageMiddleware) List(ctx context.Context, path string) ([]string, error) {
+	children, err := m.StorageDriver.List(ctx, path)
+	if err != nil && !isPathNotFound(err) {
+		return nil, err
+	}
+
+	secondaryChildren, serr := m.secondary.List(ctx, path)
+	if serr != nil {
+		if !isPathNotFound(serr) {
+			dcontext.GetLogger(ctx).WithError(serr).Warnf("mirror: failed to list %s in secondary storage driver", path)
+		}
+		return children, err
+	}
+
+	seen := make(map[string]struct{}, len(children))
+	for _, child := range children {
+		seen[child] = struct{}{}
+	}
+	for _, child := range secondaryChildren {
+		if _, ok := seen[child]; !ok {
+			children = append(children, child)
+		}
+	}
+	return children, nil
+}
+
+// Move moves sourcePath to destPath in both drivers.
+func (m *mirrorStorageMiddleware) Move(ctx context.Context, sourcePath string, destPath string) error {
+	err := m.StorageDriver.Move(ctx, sourcePath, destPath)
+	if err != nil && !isPathNotFound(err) {
+		return err
+	}
+
+	serr := m.secondary.Move(ctx, sourcePath, destPath)
+	if err != nil {
+		// the source only exists in the secondary driver
+		if serr == nil {
+			return nil
+		}
+		return err
+	}
+	if serr != nil {
+		m.queue(sourcePath, opDelete)
+		return m.secondaryFailed(ctx, opCopy, destPath, serr)
+	}
+	return nil
+}
+
+// Delete deletes path from both drivers.
+func (m *mirrorStorageMiddleware) Delete(ctx context.Context, path string) error {
+	err := m.StorageDriver.Delete(ctx, path)
+	if err != nil && !isPathNotFound(err) {
+		return err
+	}
+
+	serr := m.secondary.Delete(ctx, path)
+	if serr != nil && !isPathNotFound(serr) {
+		if ferr := m.secondaryFailed(ctx, opDelete, path, serr); ferr != nil {
+			return ferr
+		}
+	}
+	if err != nil && serr == nil {
+		// the path only existed in the secondary driver
+		return nil
+	}
+	return err
+}
+
+// RedirectURL redirects to the secondary driver for content the primary
+// driver does not have.
+func (m *mirrorStorageMiddleware) RedirectURL(r *http.Request, path string) (string, error) {
+	if _, err := m.StorageDriver.Stat(r.Context(), path); isPathNotFound(err) {
+		return m.secondary.RedirectURL(r, path)
+	}
+	return m.StorageDriver.RedirectURL(r, path)
+}
+
+// Walk traverses the union of both drivers.
+func (m *mirrorStorageMiddleware) Walk(ctx context.Context, path string, f storagedriver.WalkFn, options ...func(*storagedriver.WalkOptions)) error {
+	return storagedriver.WalkFallback(ctx, m, path, f, options...)
+}
+
+// mirrorWriter writes to both drivers. The secondary writer is nil once a
+// write to it failed, in which case the content is copied to the secondary
+// driver from the primary driver after it is committed.
+type mirrorWriter struct {
+	ctx       context.Context
+	m         *mirrorStorageMiddleware
+	path      string
+	primary   storagedriver.FileWriter
+	secondary storagedriver.FileWriter
+}
+
+var _ storagedriver.FileWriter = &mirrorWriter{}
+
+// secondaryFailed logs the failure of the secondary writer and stops
+// writing to it.
+func (w *mirrorWriter) secondaryFailed(err error) {
+	dcontext.GetLogger(w.ctx).WithError(err).Warnf("mirror: failed to write %s to secondary storage driver, copying it once committed", w.path)
+	if w.secondary != nil {
+		w.secondary.Cancel(w.ctx)
+		w.secondary.Close()
+		w.secondary = nil
+	}
+}
+
+func (w *mirrorWriter) Write(p []byte) (int, error) {
+	n, err := w.primary.Write(p)
+	if w.secondary != nil && n > 0 {
+		if _, serr := w.secondary.Write(p[:n]); serr != nil {
+			if w.m.failOnSecondaryError {
+				return n, w.m.secondaryFailed(w.ctx, opCopy, w.path, serr)
+			}
+			w.secondaryFailed(serr)
+		}
+	}
+	return n, err
+}
+
+func (w *mirrorWriter) Close() error {
+	err := w.primary.Close()
+	if w.secondary != nil {
+		if serr := w.secondary.Close(); serr != nil && err == nil && w.m.failOnSecondaryError {
+			err = w.m.secondaryFailed(w.ctx, opCopy, w.path, serr)
+		}
+	}
+	return err
+}
+
+func (w *mirrorWriter) Size() int64 {
+	return w.primary.Size()
+}
+
+func (w *mirrorWriter) Cancel(ctx context.Context) error {
+	err := w.primary.Cancel(ctx)
+	if w.secondary != nil {
+		w.secondary.Cancel(ctx)
+	}
+	return err
+}
+
+func (w *mirrorWriter) Commit(ctx context.Context) error {
+	if err := w.primary.Commit(ctx); err != nil {
+		return err
+	}
+	if w.secondary == nil {
+		w.m.queue(w.path, opCopy)
+		return nil
+	}
+	if err := w.secondary.Commit(ctx); err != nil {
+		w.secondary.Cancel(ctx)
+		w.secondary.Close()
+		w.secondary = nil
+		return w.m.secondaryFailed(ctx, opCopy, w.path, err)
+	}
+	return nil
+}
@@ -0,0 +1,237 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sort"
+	"testing"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyDriver fails writes while fail is set.
+type flakyDriver struct {
+	storagedriver.StorageDriver
+	fail bool
+}
+
+var errFlaky = errors.New("flaky driver failure")
+
+func (d *flakyDriver) PutContent(ctx context.Context, path string, content []byte) error {
+	if d.fail {
+		return errFlaky
+	}
+	return d.StorageDriver.PutContent(ctx, path, content)
+}
+
+func (d *flakyDriver) Writer(ctx context.Context, path string, append bool) (storagedriver.FileWriter, error) {
+	if d.fail {
+		return nil, errFlaky
+	}
+	return d.StorageDriver.Writer(ctx, path, append)
+}
+
+func (d *flakyDriver) Delete(ctx context.Context, path string) error {
+	if d.fail {
+		return errFlaky
+	}
+	return d.StorageDriver.Delete(ctx, path)
+}
+
+func newTestMirror(t *testing.T, options map[string]any) (*mirrorStorageMiddleware, storagedriver.StorageDriver, storagedriver.StorageDriver) {
+	t.Helper()
+
+	if options == nil {
+		options = make(map[string]any)
+	}
+	options["driver"] = "inmemory"
+
+	primary := inmemory.New()
+	sd, err := newMirrorStorageMiddleware(context.Background(), primary, options)
+	require.NoError(t, err)
+	m := sd.(*mirrorStorageMiddleware)
+	return m, primary, m.secondary
+}
+
+func writeFile(t *testing.T, sd storagedriver.StorageDriver, path, content string, appendTo bool) {
+	t.Helper()
+
+	ctx := context.Background()
+	w, err := sd.Writer(ctx, path, appendTo)
+	require.NoError(t, err)
+	_, err = w.Write([]byte(content))
+	require.NoError(t, err)
+	require.NoError(t, w.Commit(ctx))
+	require.NoError(t, w.Close())
+}
+
+func requireContent(t *testing.T, sd storagedriver.StorageDriver, path, content string) {
+	t.Helper()
+
+	got, err := sd.GetContent(context.Background(), path)
+	require.NoError(t, err, "reading %s from %s", path, sd.Name())
+	require.Equal(t, content, string(got))
+}
+
+func requireNotFound(t *testing.T, sd storagedriver.StorageDriver, path string) {
+	t.Helper()
+
+	_, err := sd.Stat(context.Background(), path)
+	require.ErrorAs(t, err, new(storagedriver.PathNotFoundError))
+}
+
+func TestDualWrite(t *testing.T) {
+	ctx := context.Background()
+	m, primary, secondary := newTestMirror(t, nil)
+
+	require.NoError(t, m.PutContent(ctx, "/a/content", []byte("content")))
+	writeFile(t, m, "/a/upload", "upl", false)
+	writeFile(t, m, "/a/upload", "oad", true)
+	require.NoError(t, m.Move(ctx, "/a/upload", "/a/blob"))
+	require.NoError(t, m.PutContent(ctx, "/a/deleted", []byte("deleted")))
+	require.NoError(t, m.Delete(ctx, "/a/deleted"))
+
+	for _, sd := range []storagedriver.StorageDriver{primary, secondary} {
+		requireContent(t, sd, "/a/content", "content")
+		requireContent(t, sd, "/a/blob", "upload")
+		requireNotFound(t, sd, "/a/upload")
+		requireNotFound(t, sd, "/a/deleted")
+	}
+	require.Empty(t, m.pending)
+}
+
+func TestReadFallback(t *testing.T) {
+	ctx := context.Background()
+	m, primary, secondary := newTestMirror(t, nil)
+
+	require.NoError(t, primary.PutContent(ctx, "/a/new", []byte("new")))
+	require.NoError(t, primary.PutContent(ctx, "/a/both", []byte("new")))
+	require.NoError(t, secondary.PutContent(ctx, "/a/both", []byte("old")))
+	require.NoError(t, secondary.PutContent(ctx, "/a/old", []byte("old")))
+
+	// the primary driver is preferred
+	requireContent(t, m, "/a/new", "new")
+	requireContent(t, m, "/a/both", "new")
+	requireContent(t, m, "/a/old", "old")
+
+	r, err := m.Reader(ctx, "/a/old", 1)
+	require.NoError(t, err)
+	content, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	require.Equal(t, "ld", string(content))
+
+	fi, err := m.Stat(ctx, "/a/old")
+	require.NoError(t, err)
+	require.EqualValues(t, 3, fi.Size())
+	requireNotFound(t, m, "/a/missing")
+
+	children, err := m.List(ctx, "/a")
+	require.NoError(t, err)
+	sort.Strings(children)
+	require.Equal(t, []string{"/a/both", "/a/new", "/a/old"}, children)
+
+	var walked []string
+	require.NoError(t, m.Walk(ctx, "/", func(fi storagedriver.FileInfo) error {
+		if !fi.IsDir() {
+			walked = append(walked, fi.Path())
+		}
+		return nil
+	}))
+	require.Equal(t, []string{"/a/both", "/a/new", "/a/old"}, walked)
+
+	// content only present in the secondary driver can be moved and deleted
+	require.NoError(t, m.Move(ctx, "/a/old", "/a/moved"))
+	requireContent(t, m, "/a/moved", "old")
+	require.NoError(t, m.Delete(ctx, "/a/moved"))
+	requireNotFound(t, m, "/a/moved")
+}
+
+func TestSecondaryFailureRetried(t *testing.T) {
+	ctx := context.Background()
+	m, primary, secondary := newTestMirror(t, nil)
+	flaky := &flakyDriver{StorageDriver: secondary, fail: true}
+	m.secondary = flaky
+
+	require.NoError(t, m.PutContent(ctx, "/a/deleted", []byte("deleted")))
+	flaky.fail = false
+	require.NoError(t, m.secondary.PutContent(ctx, "/a/deleted", []byte("deleted")))
+	flaky.fail = true
+
+	// writes and deletes succeed in the primary driver
+	require.NoError(t, m.PutContent(ctx, "/a/content", []byte("content")))
+	writeFile(t, m, "/a/blob", "blob", false)
+	require.NoError(t, m.Delete(ctx, "/a/deleted"))
+	requireContent(t, primary, "/a/content", "content")
+	requireContent(t, primary, "/a/blob", "blob")
+	requireNotFound(t, primary, "/a/deleted")
+
+	// and are queued for the secondary driver until it recovers
+	m.retry(ctx)
+	require.Len(t, m.pending, 3)
+	requireNotFound(t, secondary, "/a/content")
+
+	flaky.fail = false
+	m.retry(ctx)
+	require.Empty(t, m.pending)
+	requireContent(t, secondary, "/a/content", "content")
+	requireContent(t, secondary, "/a/blob", "blob")
+	requireNotFound(t, secondary, "/a/deleted")
+}
+
+func TestFailOnSecondaryError(t *testing.T) {
+	ctx := context.Background()
+	m, _, secondary := newTestMirror(t, map[string]any{"failonsecondaryerror": true})
+	m.secondary = &flakyDriver{StorageDriver: secondary, fail: true}
+
+	require.ErrorIs(t, m.PutContent(ctx, "/a/content", []byte("content")), errFlaky)
+	_, err := m.Writer(ctx, "/a/blob", false)
+	require.ErrorIs(t, err, errFlaky)
+	require.Empty(t, m.pending)
+}
+
+func TestBackfill(t *testing.T) {
+	ctx := context.Background()
+	from, to := inmemory.New(), inmemory.New()
+
+	paths := []string{"/a/1", "/a/2", "/a/b/3", "/c/4", "/c/d/e/5"}
+	for _, path := range paths {
+		require.NoError(t, from.PutContent(ctx, path, []byte("old"+path)))
+	}
+	// written by the mirror middleware after the migration started
+	require.NoError(t, to.PutContent(ctx, "/a/2", []byte("new")))
+
+	result, err := Backfill(ctx, from, to, 3)
+	require.NoError(t, err)
+	require.Equal(t, BackfillResult{Copied: 4, Skipped: 1}, result)
+
+	for _, path := range paths {
+		if path == "/a/2" {
+			requireContent(t, to, path, "new")
+		} else {
+			requireContent(t, to, path, "old"+path)
+		}
+	}
+
+	// a second backfill has nothing left to copy
+	result, err = Backfill(ctx, from, to, 3)
+	require.NoError(t, err)
+	require.Equal(t, BackfillResult{Skipped: 5}, result)
+}
+
+func TestInvalidOptions(t *testing.T) {
+	for _, options := range []map[string]any{
+		{},
+		{"driver": "nonexistent"},
+		{"driver": "inmemory", "parameters": "invalid"},
+		{"driver": "inmemory", "retryinterval": "invalid"},
+		{"driver": "inmemory", "retryinterval": "-1s"},
+		{"driver": "inmemory", "failonsecondaryerror": "yes"},
+	} {
+		_, err := newMirrorStorageMiddleware(context.Background(), inmemory.New(), options)
+		require.Error(t, err, "options %v", options)
+	}
+}
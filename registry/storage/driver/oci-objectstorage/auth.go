@@ -0,0 +1,50 @@
+package objectstorage
+
+import (
+	"fmt"
+
+	"github.com/oracle/oci-go-sdk/v65/common"
+	"github.com/oracle/oci-go-sdk/v65/common/auth"
+)
+
+const (
+	// authConfigFile authenticates requests with the API signing key of a
+	// user, configured in an OCI CLI configuration file.
+	authConfigFile = "configfile"
+
+	// authInstancePrincipal authenticates requests as the compute instance
+	// the registry runs on.
+	authInstancePrincipal = "instanceprincipal"
+
+	defaultProfile = "DEFAULT"
+)
+
+// newConfigurationProvider returns the provider of the credentials of the
+// authentication mode of params.
+//
+// Instance principals exchange the certificate of the instance, provided by
+// the instance metadata service, for security tokens which the provider
+// refreshes before they expire.
+func newConfigurationProvider(params DriverParameters) (common.ConfigurationProvider, error) {
+	if params.Auth == authInstancePrincipal {
+		provider, err := auth.InstancePrincipalConfigurationProvider()
+		if err != nil {
+			return nil, fmt.Errorf("unable to obtain instance principal credentials: %w", err)
+		}
+		return provider, nil
+	}
+
+	provider, err := common.ConfigurationProviderFromFileWithProfile(params.ConfigFile, params.Profile, "")
+	if err != nil {
+		return nil, err
+	}
+	// the configuration file is only read on use, check the credentials
+	// upfront
+	if _, err := provider.KeyID(); err != nil {
+		return nil, fmt.Errorf("%s: %w", params.ConfigFile, err)
+	}
+	if _, err := provider.PrivateRSAKey(); err != nil {
+		return nil, fmt.Errorf("%s: %w", params.ConfigFile, err)
+	}
+	return provider, nil
+}
@@ -0,0 +1,501 @@
+package objectstorage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/oracle/oci-go-sdk/v65/common"
+)
+
+const maxTries = 5
+
+// serviceError is an error response of the Object Storage service.
+type serviceError struct {
+	StatusCode int
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+	RequestID  string
+}
+
+func (e *serviceError) Error() string {
+	return fmt.Sprintf("oci-objectstorage: %d %s: %s (opc-request-id: %s)", e.StatusCode, e.Code, e.Message, e.RequestID)
+}
+
+func newServiceError(resp *http.Response) error {
+	err := &serviceError{
+		StatusCode: resp.StatusCode,
+		RequestID:  resp.Header.Get("opc-request-id"),
+	}
+	if body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10)); len(body) > 0 {
+		_ = json.Unmarshal(body, err)
+	}
+	if err.Code == "" {
+		err.Code = http.StatusText(resp.StatusCode)
+	}
+	return err
+}
+
+// isStatus reports whether err is a service error with the given status code.
+func isStatus(err error, statusCode int) bool {
+	serviceErr, ok := err.(*serviceError)
+	return ok && serviceErr.StatusCode == statusCode
+}
+
+// client is a client of the Object Storage API, restricted to a bucket.
+type client struct {
+	http *http.Client
+	// signer signs requests, and uploadSigner the requests whose body is
+	// left out of the signature.
+	signer       common.HTTPRequestSigner
+	uploadSigner common.HTTPRequestSigner
+	endpoint     *url.URL
+	namespace    string
+	bucket       string
+}
+
+// request describes a request to the Object Storage API.
+type request struct {
+	method string
+	// path is the path of the request below the namespace, with each of
+	// its segments escaped.
+	path   string
+	query  url.Values
+	header http.Header
+	body   []byte
+	// json marshals the body of the request as JSON.
+	json any
+	// unsignedBody leaves the body out of the signature, as for object
+	// uploads.
+	unsignedBody bool
+}
+
+// do sends req, returning the response if it succeeds with one of the
+// expected status codes, 200 by default. Requests failing with a status
+// indicating a transient failure are retried.
+func (c *client) do(ctx context.Context, req request, expected ...int) (*http.Response, error) {
+	if len(expected) == 0 {
+		expected = []int{http.StatusOK}
+	}
+	if req.json != nil {
+		body, err := json.Marshal(req.json)
+		if err != nil {
+			return nil, err
+		}
+		req.body = body
+	}
+
+	u := *c.endpoint
+	u.RawPath = strings.TrimSuffix(u.EscapedPath(), "/") + "/n/" + url.PathEscape(c.namespace) + req.path
+	u.Path, _ = url.PathUnescape(u.RawPath)
+	u.RawQuery = req.query.Encode()
+
+	backoff := 100 * time.Millisecond
+	for try := 1; ; try++ {
+		resp, err := c.send(ctx, req, &u)
+		if err != nil {
+			return nil, err
+		}
+		for _, status := range expected {
+			if resp.StatusCode == status {
+				return resp, nil
+			}
+		}
+
+		err = newServiceError(resp)
+		resp.Body.Close()
+		if try == maxTries || (resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < http.StatusInternalServerError) {
+			return nil, err
+		}
+
+		select {
+		case <-time.After(backoff + rand.N(backoff)):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		backoff *= 2
+	}
+}
+
+func (c *client) send(ctx context.Context, req request, u *url.URL) (*http.Response, error) {
+	var body io.Reader
+	if req.body != nil || req.method == http.MethodPut || req.method == http.MethodPost {
+		body = bytes.NewReader(req.body)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, req.method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range req.header {
+		httpReq.Header[k] = v
+	}
+	if req.json != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+
+	signer := c.signer
+	if req.unsignedBody {
+		signer = c.uploadSigner
+	} else if (req.method == http.MethodPut || req.method == http.MethodPost) && httpReq.Header.Get("Content-Type") == "" {
+		// the content type is covered by the signature of the body
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	if err := signer.Sign(httpReq); err != nil {
+		return nil, err
+	}
+	return c.http.Do(httpReq)
+}
+
+// objectPath returns the path of the object name.
+func (c *client) objectPath(name string) string {
+	return "/b/" + url.PathEscape(c.bucket) + "/o/" + url.PathEscape(name)
+}
+
+// uploadPath returns the path of the multipart uploads of the object name.
+func (c *client) uploadPath(name string) string {
+	return "/b/" + url.PathEscape(c.bucket) + "/u/" + url.PathEscape(name)
+}
+
+func (c *client) bucketPath(action string) string {
+	return "/b/" + url.PathEscape(c.bucket) + action
+}
+
+// objectInfo describes an object.
+type objectInfo struct {
+	Size        int64
+	Modified    time.Time
+	ContentType string
+	Metadata    map[string]string
+}
+
+// headObject returns the description of the object name.
+func (c *client) headObject(ctx context.Context, name string) (*objectInfo, error) {
+	resp, err := c.do(ctx, request{method: http.MethodHead, path: c.objectPath(name)})
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return responseObjectInfo(resp), nil
+}
+
+func responseObjectInfo(resp *http.Response) *objectInfo {
+	info := &objectInfo{
+		Size:        resp.ContentLength,
+		ContentType: resp.Header.Get("Content-Type"),
+		Metadata:    make(map[string]string),
+	}
+	if resp.StatusCode == http.StatusPartialContent {
+		// the size of the object follows the range in Content-Range
+		if _, total, ok := strings.Cut(resp.Header.Get("Content-Range"), "/"); ok {
+			info.Size, _ = strconv.ParseInt(total, 10, 64)
+		}
+	}
+	info.Modified, _ = http.ParseTime(resp.Header.Get("Last-Modified"))
+	for k, v := range resp.Header {
+		if key, ok := strings.CutPrefix(strings.ToLower(k), "opc-meta-"); ok && len(v) > 0 {
+			info.Metadata[key] = v[0]
+		}
+	}
+	return info
+}
+
+// getObject returns the content of the object name from offset, and its
+// description.
+func (c *client) getObject(ctx context.Context, name string, offset int64) (io.ReadCloser, *objectInfo, error) {
+	req := request{method: http.MethodGet, path: c.objectPath(name), header: http.Header{}}
+	if offset > 0 {
+		req.header.Set("Range", "bytes="+strconv.FormatInt(offset, 10)+"-")
+	}
+	resp, err := c.do(ctx, req, http.StatusOK, http.StatusPartialContent)
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp.Body, responseObjectInfo(resp), nil
+}
+
+// putObject stores content as the object name.
+func (c *client) putObject(ctx context.Context, name string, content []byte, contentType string, metadata map[string]string) error {
+	header := http.Header{"Content-Type": []string{contentType}}
+	for k, v := range metadata {
+		header.Set("opc-meta-"+k, v)
+	}
+	resp, err := c.do(ctx, request{
+		method:       http.MethodPut,
+		path:         c.objectPath(name),
+		header:       header,
+		body:         content,
+		unsignedBody: true,
+	})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// deleteObject deletes the object name.
+func (c *client) deleteObject(ctx context.Context, name string) error {
+	resp, err := c.do(ctx, request{method: http.MethodDelete, path: c.objectPath(name)}, http.StatusOK, http.StatusNoContent)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// renameObject renames the object source to destination, replacing any
+// object named destination.
+func (c *client) renameObject(ctx context.Context, source, destination string) error {
+	resp, err := c.do(ctx, request{
+		method: http.MethodPost,
+		path:   c.bucketPath("/actions/renameObject"),
+		json:   map[string]string{"sourceName": source, "newName": destination},
+	})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// objectSummary describes an object of a listing.
+type objectSummary struct {
+	Name         string    `json:"name"`
+	Size         int64     `json:"size"`
+	TimeModified time.Time `json:"timeModified"`
+}
+
+// objectList is a page of a listing of objects.
+type objectList struct {
+	Objects       []objectSummary `json:"objects"`
+	Prefixes      []string        `json:"prefixes"`
+	NextStartWith string          `json:"nextStartWith"`
+}
+
+// listObjects lists the objects named with prefix, starting with the object
+// named start. If delimiter is set, objects whose name contains it after the
+// prefix are grouped in the prefixes of the listing.
+func (c *client) listObjects(ctx context.Context, prefix, delimiter, start string, limit int) (*objectList, error) {
+	query := url.Values{
+		"prefix": []string{prefix},
+		"fields": []string{"name,size,timeModified"},
+		"limit":  []string{strconv.Itoa(limit)},
+	}
+	if delimiter != "" {
+		query.Set("delimiter", delimiter)
+	}
+	if start != "" {
+		query.Set("start", start)
+	}
+	resp, err := c.do(ctx, request{method: http.MethodGet, path: c.bucketPath("/o"), query: query})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var list objectList
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("unable to parse object listing: %w", err)
+	}
+	return &list, nil
+}
+
+// createMultipartUpload starts a multipart upload of the object name,
+// returning its id.
+func (c *client) createMultipartUpload(ctx context.Context, name, contentType string) (string, error) {
+	resp, err := c.do(ctx, request{
+		method: http.MethodPost,
+		path:   c.bucketPath("/u"),
+		json:   map[string]string{"object": name, "contentType": contentType},
+	})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var upload struct {
+		UploadID string `json:"uploadId"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&upload); err != nil {
+		return "", fmt.Errorf("unable to parse multipart upload: %w", err)
+	}
+	return upload.UploadID, nil
+}
+
+// uploadedPart describes a part of a multipart upload.
+type uploadedPart struct {
+	PartNumber int    `json:"partNumber"`
+	ETag       string `json:"etag"`
+	Size       int64  `json:"size"`
+}
+
+// uploadPart uploads content as the part number of a multipart upload.
+func (c *client) uploadPart(ctx context.Context, name, uploadID string, number int, content []byte) (uploadedPart, error) {
+	resp, err := c.do(ctx, request{
+		method: http.MethodPut,
+		path:   c.uploadPath(name),
+		query: url.Values{
+			"uploadId":      []string{uploadID},
+			"uploadPartNum": []string{strconv.Itoa(number)},
+		},
+		body:         content,
+		unsignedBody: true,
+	})
+	if err != nil {
+		return uploadedPart{}, err
+	}
+	resp.Body.Close()
+	return uploadedPart{PartNumber: number, ETag: resp.Header.Get("ETag"), Size: int64(len(content))}, nil
+}
+
+// listParts lists the parts of a multipart upload.
+func (c *client) listParts(ctx context.Context, name, uploadID string) ([]uploadedPart, error) {
+	var parts []uploadedPart
+	query := url.Values{"uploadId": []string{uploadID}}
+	for {
+		resp, err := c.do(ctx, request{method: http.MethodGet, path: c.uploadPath(name), query: query})
+		if err != nil {
+			return nil, err
+		}
+		var page []uploadedPart
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse multipart upload parts: %w", err)
+		}
+		parts = append(parts, page...)
+
+		next := resp.Header.Get("opc-next-page")
+		if next == "" {
+			return parts, nil
+		}
+		query.Set("page", next)
+	}
+}
+
+// commitMultipartUpload commits the parts of a multipart upload, which
+// replaces the object it was started for.
+func (c *client) commitMultipartUpload(ctx context.Context, name, uploadID string, parts []uploadedPart) error {
+	partsToCommit := make([]map[string]any, len(parts))
+	for i, part := range parts {
+		partsToCommit[i] = map[string]any{"partNum": part.PartNumber, "etag": part.ETag}
+	}
+	resp, err := c.do(ctx, request{
+		method: http.MethodPost,
+		path:   c.uploadPath(name),
+		query:  url.Values{"uploadId": []string{uploadID}},
+		json:   map[string]any{"partsToCommit": partsToCommit},
+	})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// abortMultipartUpload aborts a multipart upload, deleting its parts.
+func (c *client) abortMultipartUpload(ctx context.Context, name, uploadID string) error {
+	resp, err := c.do(ctx, request{
+		method: http.MethodDelete,
+		path:   c.uploadPath(name),
+		query:  url.Values{"uploadId": []string{uploadID}},
+	}, http.StatusOK, http.StatusNoContent)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// createReadRequest creates a pre-authenticated request for reading the
+// object name until expires, returning its URL.
+func (c *client) createReadRequest(ctx context.Context, name string, expires time.Time) (string, error) {
+	resp, err := c.do(ctx, request{
+		method: http.MethodPost,
+		path:   c.bucketPath("/p"),
+		json: map[string]string{
+			"name":        "registry-redirect-" + strconv.FormatUint(rand.Uint64(), 36),
+			"objectName":  name,
+			"accessType":  "ObjectRead",
+			"timeExpires": expires.UTC().Format(time.RFC3339),
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var par struct {
+		AccessURI string `json:"accessUri"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&par); err != nil {
+		return "", fmt.Errorf("unable to parse pre-authenticated request: %w", err)
+	}
+	u, err := c.endpoint.Parse(par.AccessURI)
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+// ensureBucket creates the bucket in compartment if it does not exist.
+func (c *client) ensureBucket(ctx context.Context, compartment string) error {
+	resp, err := c.do(ctx, request{method: http.MethodHead, path: "/b/" + url.PathEscape(c.bucket)})
+	if err == nil {
+		resp.Body.Close()
+		return nil
+	}
+	if !isStatus(err, http.StatusNotFound) {
+		return err
+	}
+
+	resp, err = c.do(ctx, request{
+		method: http.MethodPost,
+		path:   "/b/",
+		json:   map[string]string{"name": c.bucket, "compartmentId": compartment},
+	})
+	if err != nil {
+		if isStatus(err, http.StatusConflict) {
+			// created concurrently
+			return nil
+		}
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// getNamespace returns the Object Storage namespace of the tenancy of the
+// client.
+func getNamespace(ctx context.Context, c *client) (string, error) {
+	u := c.endpoint.JoinPath("/n/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if err := c.signer.Sign(req); err != nil {
+		return "", err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", newServiceError(resp)
+	}
+
+	var namespace string
+	if err := json.NewDecoder(resp.Body).Decode(&namespace); err != nil {
+		return "", fmt.Errorf("unable to parse namespace: %w", err)
+	}
+	return namespace, nil
+}
@@ -0,0 +1,721 @@
+// Package objectstorage provides a storagedriver.StorageDriver implementation
+// to store blobs in Oracle Cloud Infrastructure (OCI) Object Storage.
+//
+// The driver talks to the Object Storage REST API directly, signing requests
+// with the API signing key of a user, read from an OCI CLI configuration
+// file, or as an instance principal when running on an OCI compute instance.
+//
+// Because Object Storage is a key, value store the Stat call does not support
+// last modification time for directories (directories are an abstraction for
+// key, value stores).
+package objectstorage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/base"
+	"github.com/distribution/distribution/v3/registry/storage/driver/factory"
+	"github.com/oracle/oci-go-sdk/v65/common"
+)
+
+const (
+	driverName = "oci-objectstorage"
+
+	// minChunkSize is the minimum size of the parts of multipart uploads,
+	// but the last one.
+	minChunkSize = 10 << 20
+
+	defaultChunkSize = 2 * minChunkSize
+
+	// maxChunkSize bounds the memory buffered by each writer.
+	maxChunkSize = 1 << 30
+
+	defaultRedirectExpiry = 20 * time.Minute
+
+	// listMax is the largest number of objects listed by a single request.
+	listMax = 1000
+
+	blobContentType = "application/octet-stream"
+
+	// uploadSessionContentType is the content type of the objects holding
+	// the state of closed, uncommitted writers: the id of their multipart
+	// upload and the content not uploaded as a part yet.
+	uploadSessionContentType = "application/x-docker-upload-session"
+
+	// uploadIDMetadata is the metadata of upload session objects holding the
+	// id of their multipart upload.
+	uploadIDMetadata = "upload-id"
+)
+
+// DriverParameters represents all configuration options available for the
+// oci-objectstorage driver
+type DriverParameters struct {
+	// Auth is the authentication mode: configfile or instanceprincipal.
+	Auth string
+
+	// ConfigFile is the path of the OCI CLI configuration file holding the
+	// credentials of the configfile mode.
+	ConfigFile string
+
+	// Profile is the profile of ConfigFile holding the credentials.
+	Profile string
+
+	// Region is the region of the bucket. It defaults to the region of the
+	// profile, or of the instance.
+	Region string
+
+	// Endpoint overrides the URL of the Object Storage API of the region.
+	Endpoint string
+
+	// Namespace is the Object Storage namespace of the tenancy, looked up
+	// if not set.
+	Namespace string
+
+	// Bucket is the name of the bucket objects are stored in.
+	Bucket string
+
+	// Compartment is the OCID of the compartment the bucket is created in,
+	// if it does not exist. The bucket must exist if not set.
+	Compartment string
+
+	// RootDirectory is the prefix of the names of the objects.
+	RootDirectory string
+
+	// ChunkSize is the size of the parts of multipart uploads.
+	ChunkSize int
+
+	// RedirectExpiry is the lifetime of the pre-authenticated requests
+	// clients are redirected to.
+	RedirectExpiry time.Duration
+}
+
+func init() {
+	factory.Register(driverName, &objectStorageDriverFactory{})
+}
+
+// objectStorageDriverFactory implements the factory.StorageDriverFactory interface
+type objectStorageDriverFactory struct{}
+
+func (factory *objectStorageDriverFactory) Create(ctx context.Context, parameters map[string]any) (storagedriver.StorageDriver, error) {
+	return FromParameters(ctx, parameters)
+}
+
+var _ storagedriver.StorageDriver = &driver{}
+
+type driver struct {
+	client         *client
+	rootDirectory  string
+	chunkSize      int
+	redirectExpiry time.Duration
+}
+
+type baseEmbed struct {
+	base.Base
+}
+
+// Driver is a storagedriver.StorageDriver implementation backed by OCI Object
+// Storage. Objects are stored at absolute keys in the provided bucket.
+type Driver struct {
+	baseEmbed
+}
+
+// FromParameters constructs a new Driver with a given parameters map
+// Required parameters:
+// - bucket
+// Optional Parameters:
+// - auth
+// - configfile
+// - profile
+// - region
+// - endpoint
+// - namespace
+// - compartment
+// - rootdirectory
+// - chunksize
+// - redirectexpiry
+func FromParameters(ctx context.Context, parameters map[string]any) (*Driver, error) {
+	params, err := fromParametersImpl(parameters)
+	if err != nil {
+		return nil, err
+	}
+	return New(ctx, *params)
+}
+
+func fromParametersImpl(parameters map[string]any) (*DriverParameters, error) {
+	params := &DriverParameters{
+		Auth:           authConfigFile,
+		ConfigFile:     "~/.oci/config",
+		Profile:        defaultProfile,
+		ChunkSize:      defaultChunkSize,
+		RedirectExpiry: defaultRedirectExpiry,
+	}
+
+	bucket, ok := parameters["bucket"]
+	if !ok || fmt.Sprint(bucket) == "" {
+		return nil, fmt.Errorf("no bucket parameter provided")
+	}
+	params.Bucket = fmt.Sprint(bucket)
+
+	for name, value := range map[string]*string{
+		"auth":          &params.Auth,
+		"configfile":    &params.ConfigFile,
+		"profile":       &params.Profile,
+		"region":        &params.Region,
+		"endpoint":      &params.Endpoint,
+		"namespace":     &params.Namespace,
+		"compartment":   &params.Compartment,
+		"rootdirectory": &params.RootDirectory,
+	} {
+		if v, ok := parameters[name]; ok && v != nil {
+			*value = fmt.Sprint(v)
+		}
+	}
+
+	if params.Auth != authConfigFile && params.Auth != authInstancePrincipal {
+		return nil, fmt.Errorf("auth %q must be %s or %s", params.Auth, authConfigFile, authInstancePrincipal)
+	}
+	if params.Endpoint != "" {
+		if u, err := url.Parse(params.Endpoint); err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("endpoint %q must be an absolute URL", params.Endpoint)
+		}
+	}
+
+	var err error
+	if params.ChunkSize, err = intParameter(parameters, "chunksize", defaultChunkSize); err != nil {
+		return nil, err
+	}
+	if params.ChunkSize < minChunkSize || params.ChunkSize > maxChunkSize {
+		return nil, fmt.Errorf("chunksize %d must be between %d and %d", params.ChunkSize, minChunkSize, maxChunkSize)
+	}
+
+	if expiry, ok := parameters["redirectexpiry"]; ok {
+		switch v := expiry.(type) {
+		case time.Duration:
+			params.RedirectExpiry = v
+		default:
+			if params.RedirectExpiry, err = time.ParseDuration(fmt.Sprint(v)); err != nil {
+				return nil, fmt.Errorf("redirectexpiry %v must be a duration", expiry)
+			}
+		}
+		if params.RedirectExpiry <= 0 {
+			return nil, fmt.Errorf("redirectexpiry %v must be positive", expiry)
+		}
+	}
+
+	return params, nil
+}
+
+// intParameter returns the integer value of the parameter name, or def if it
+// is not set.
+func intParameter(parameters map[string]any, name string, def int) (int, error) {
+	param, ok := parameters[name]
+	if !ok {
+		return def, nil
+	}
+
+	switch v := param.(type) {
+	case string:
+		vv, err := strconv.Atoi(v)
+		if err != nil {
+			return 0, fmt.Errorf("%s must be an integer, %v invalid", name, param)
+		}
+		return vv, nil
+	case int, uint, int32, uint32, uint64, int64:
+		return int(reflect.ValueOf(v).Convert(reflect.TypeFor[int]()).Int()), nil
+	default:
+		return 0, fmt.Errorf("invalid value for %s: %#v", name, param)
+	}
+}
+
+// New constructs a new Driver with the given parameters, creating the bucket
+// if it does not exist and a compartment is configured.
+func New(ctx context.Context, params DriverParameters) (*Driver, error) {
+	provider, err := newConfigurationProvider(params)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := params.Endpoint
+	if endpoint == "" {
+		region := params.Region
+		if region == "" {
+			// the region of the profile, or of the instance
+			if region, err = provider.Region(); err != nil || region == "" {
+				return nil, fmt.Errorf("no region parameter provided")
+			}
+		}
+		endpoint = "https://" + common.StringToRegion(region).Endpoint("objectstorage")
+	}
+	endpointURL, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &client{
+		http:         &http.Client{},
+		signer:       common.DefaultRequestSigner(provider),
+		uploadSigner: common.RequestSignerExcludeBody(provider),
+		endpoint:     endpointURL,
+		namespace:    params.Namespace,
+		bucket:       params.Bucket,
+	}
+	if c.namespace == "" {
+		if c.namespace, err = getNamespace(ctx, c); err != nil {
+			return nil, fmt.Errorf("unable to look up the object storage namespace: %w", err)
+		}
+	}
+	if params.Compartment != "" {
+		if err := c.ensureBucket(ctx, params.Compartment); err != nil {
+			return nil, fmt.Errorf("unable to create bucket %s: %w", params.Bucket, err)
+		}
+	}
+
+	d := &driver{
+		client:         c,
+		rootDirectory:  strings.Trim(params.RootDirectory, "/"),
+		chunkSize:      params.ChunkSize,
+		redirectExpiry: params.RedirectExpiry,
+	}
+
+	return &Driver{
+		baseEmbed: baseEmbed{
+			Base: base.Base{
+				StorageDriver: d,
+			},
+		},
+	}, nil
+}
+
+// Implement the storagedriver.StorageDriver interface
+
+func (d *driver) Name() string {
+	return driverName
+}
+
+// GetContent retrieves the content stored at "path" as a []byte.
+func (d *driver) GetContent(ctx context.Context, path string) ([]byte, error) {
+	reader, err := d.Reader(ctx, path, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+// PutContent stores the []byte content at a location designated by "path".
+func (d *driver) PutContent(ctx context.Context, path string, contents []byte) error {
+	return parseError(path, d.client.putObject(ctx, d.objectName(path), contents, blobContentType, nil))
+}
+
+// Reader retrieves an io.ReadCloser for the content stored at "path" with a
+// given byte offset.
+func (d *driver) Reader(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
+	rc, info, err := d.client.getObject(ctx, d.objectName(path), offset)
+	if isStatus(err, http.StatusRequestedRangeNotSatisfiable) {
+		// Reads starting at the end of the object are reported as an
+		// invalid range too, so look up the size to tell the two apart.
+		info, err := d.client.headObject(ctx, d.objectName(path))
+		if err != nil {
+			return nil, parseError(path, err)
+		}
+		if info.ContentType == uploadSessionContentType {
+			return nil, storagedriver.PathNotFoundError{Path: path, DriverName: driverName}
+		}
+		if offset > info.Size {
+			return nil, storagedriver.InvalidOffsetError{Path: path, Offset: offset, DriverName: driverName}
+		}
+		return io.NopCloser(bytes.NewReader(nil)), nil
+	}
+	if err != nil {
+		return nil, parseError(path, err)
+	}
+	if info.ContentType == uploadSessionContentType {
+		rc.Close()
+		return nil, storagedriver.PathNotFoundError{Path: path, DriverName: driverName}
+	}
+	return rc, nil
+}
+
+// Writer returns a FileWriter which will store the content written to it
+// at the location designated by "path" after the call to Commit.
+func (d *driver) Writer(ctx context.Context, path string, appendMode bool) (storagedriver.FileWriter, error) {
+	w := &writer{
+		ctx:    ctx,
+		driver: d,
+		name:   d.objectName(path),
+		buf:    make([]byte, 0, d.chunkSize),
+	}
+	if !appendMode {
+		return w, nil
+	}
+
+	rc, info, err := d.client.getObject(ctx, w.name, 0)
+	if err != nil {
+		return nil, parseError(path, err)
+	}
+	defer rc.Close()
+
+	switch {
+	case info.ContentType == uploadSessionContentType:
+		// resume the writer closed before being committed
+	case info.Size == 0:
+		return w, nil
+	default:
+		return nil, storagedriver.Error{
+			DriverName: driverName,
+			Detail:     fmt.Errorf("append to non-empty committed path %s unsupported", path),
+		}
+	}
+
+	if _, err := io.Copy(bytesWriter{&w.buf}, rc); err != nil {
+		return nil, err
+	}
+	if w.uploadID = info.Metadata[uploadIDMetadata]; w.uploadID != "" {
+		if w.parts, err = d.client.listParts(ctx, w.name, w.uploadID); err != nil {
+			return nil, parseError(path, err)
+		}
+		for _, part := range w.parts {
+			w.offset += part.Size
+		}
+	}
+	return w, nil
+}
+
+// Stat retrieves the FileInfo for the given path, including the current size
+// in bytes and the creation time.
+func (d *driver) Stat(ctx context.Context, path string) (storagedriver.FileInfo, error) {
+	info, err := d.client.headObject(ctx, d.objectName(path))
+	if err == nil {
+		if info.ContentType == uploadSessionContentType {
+			return nil, storagedriver.PathNotFoundError{Path: path, DriverName: driverName}
+		}
+		return storagedriver.FileInfoInternal{FileInfoFields: storagedriver.FileInfoFields{
+			Path:    path,
+			Size:    info.Size,
+			ModTime: info.Modified,
+		}}, nil
+	}
+	if !isStatus(err, http.StatusNotFound) {
+		return nil, parseError(path, err)
+	}
+
+	list, err := d.client.listObjects(ctx, d.dirPrefix(path), "", "", 1)
+	if err != nil {
+		return nil, parseError(path, err)
+	}
+	if len(list.Objects) == 0 {
+		return nil, storagedriver.PathNotFoundError{Path: path, DriverName: driverName}
+	}
+	return storagedriver.FileInfoInternal{FileInfoFields: storagedriver.FileInfoFields{
+		Path:  path,
+		IsDir: true,
+	}}, nil
+}
+
+// List returns a list of the objects that are direct descendants of the given path.
+func (d *driver) List(ctx context.Context, path string) ([]string, error) {
+	prefix := d.dirPrefix(path)
+
+	var (
+		entries []string
+		start   string
+	)
+	for {
+		list, err := d.client.listObjects(ctx, prefix, "/", start, listMax)
+		if err != nil {
+			return nil, parseError(path, err)
+		}
+		for _, object := range list.Objects {
+			entries = append(entries, d.objectPath(object.Name))
+		}
+		for _, p := range list.Prefixes {
+			entries = append(entries, d.objectPath(strings.TrimSuffix(p, "/")))
+		}
+		if list.NextStartWith == "" {
+			break
+		}
+		start = list.NextStartWith
+	}
+
+	if path != "/" && len(entries) == 0 {
+		// Treat empty response as missing directory, since we don't actually
+		// have directories in Object Storage.
+		return nil, storagedriver.PathNotFoundError{Path: path, DriverName: driverName}
+	}
+	return entries, nil
+}
+
+// Move moves an object stored at sourcePath to destPath, removing the original
+// object.
+func (d *driver) Move(ctx context.Context, sourcePath string, destPath string) error {
+	return parseError(sourcePath, d.client.renameObject(ctx, d.objectName(sourcePath), d.objectName(destPath)))
+}
+
+// Delete recursively deletes all objects stored at "path" and its subpaths.
+func (d *driver) Delete(ctx context.Context, path string) error {
+	name := d.objectName(path)
+
+	var names []string
+	if _, err := d.client.headObject(ctx, name); err == nil {
+		names = append(names, name)
+	} else if !isStatus(err, http.StatusNotFound) {
+		return parseError(path, err)
+	}
+
+	prefix := d.dirPrefix(path)
+	var start string
+	for {
+		list, err := d.client.listObjects(ctx, prefix, "", start, listMax)
+		if err != nil {
+			return parseError(path, err)
+		}
+		for _, object := range list.Objects {
+			names = append(names, object.Name)
+		}
+		if list.NextStartWith == "" {
+			break
+		}
+		start = list.NextStartWith
+	}
+
+	if len(names) == 0 {
+		return storagedriver.PathNotFoundError{Path: path, DriverName: driverName}
+	}
+	for _, name := range names {
+		if err := d.client.deleteObject(ctx, name); err != nil && !isStatus(err, http.StatusNotFound) {
+			return parseError(path, err)
+		}
+	}
+	return nil
+}
+
+// RedirectURL returns a URL which may be used to retrieve the content stored
+// at the given path, a pre-authenticated request expiring after the
+// configured redirect expiry.
+func (d *driver) RedirectURL(r *http.Request, path string) (string, error) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return "", nil
+	}
+	u, err := d.client.createReadRequest(r.Context(), d.objectName(path), time.Now().Add(d.redirectExpiry))
+	if err != nil {
+		return "", parseError(path, err)
+	}
+	return u, nil
+}
+
+// Walk traverses a filesystem defined within driver, starting
+// from the given path, calling f on each file
+func (d *driver) Walk(ctx context.Context, path string, f storagedriver.WalkFn, options ...func(*storagedriver.WalkOptions)) error {
+	return storagedriver.WalkFallback(ctx, d, path, f, options...)
+}
+
+// objectName returns the name of the object stored at path.
+func (d *driver) objectName(path string) string {
+	name := strings.TrimLeft(path, "/")
+	if d.rootDirectory == "" {
+		return name
+	}
+	return d.rootDirectory + "/" + name
+}
+
+// dirPrefix returns the prefix of the names of the objects below path.
+func (d *driver) dirPrefix(path string) string {
+	prefix := d.objectName(path)
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return prefix
+}
+
+// objectPath returns the path of the object name.
+func (d *driver) objectPath(name string) string {
+	if d.rootDirectory != "" {
+		name = strings.TrimPrefix(name, d.rootDirectory+"/")
+	}
+	return "/" + name
+}
+
+func parseError(path string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if isStatus(err, http.StatusNotFound) {
+		return storagedriver.PathNotFoundError{Path: path, DriverName: driverName}
+	}
+	var serviceErr *serviceError
+	if errors.As(err, &serviceErr) {
+		return storagedriver.Error{DriverName: driverName, Detail: err}
+	}
+	return err
+}
+
+// bytesWriter appends the bytes written to it to a slice.
+type bytesWriter struct {
+	buf *[]byte
+}
+
+func (w bytesWriter) Write(p []byte) (int, error) {
+	*w.buf = append(*w.buf, p...)
+	return len(p), nil
+}
+
+// writer uploads the content written to it as the parts of a multipart
+// upload, each of the configured chunk size. The multipart upload is only
+// started once a part is full, so that small files are stored with a single
+// request.
+//
+// When closed before being committed, the content not uploaded as a part yet
+// is stored as the object itself, along with the id of the multipart upload,
+// so that the writer can be resumed.
+type writer struct {
+	ctx    context.Context
+	driver *driver
+	name   string
+
+	uploadID string
+	parts    []uploadedPart
+	// offset is the size of the parts uploaded.
+	offset int64
+	buf    []byte
+
+	closed    bool
+	committed bool
+	cancelled bool
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if err := w.done(); err != nil {
+		return 0, err
+	}
+
+	var written int
+	for len(p) > 0 {
+		n := min(len(p), w.driver.chunkSize-len(w.buf))
+		w.buf = append(w.buf, p[:n]...)
+		p = p[n:]
+		written += n
+
+		if len(w.buf) == w.driver.chunkSize {
+			if err := w.flush(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// flush uploads the buffer as a part of the multipart upload, starting it if
+// required.
+func (w *writer) flush() error {
+	if w.uploadID == "" {
+		uploadID, err := w.driver.client.createMultipartUpload(w.ctx, w.name, blobContentType)
+		if err != nil {
+			return err
+		}
+		w.uploadID = uploadID
+	}
+
+	part, err := w.driver.client.uploadPart(w.ctx, w.name, w.uploadID, len(w.parts)+1, w.buf)
+	if err != nil {
+		return err
+	}
+	w.parts = append(w.parts, part)
+	w.offset += part.Size
+	w.buf = w.buf[:0]
+	return nil
+}
+
+func (w *writer) Size() int64 {
+	return w.offset + int64(len(w.buf))
+}
+
+// Close stores the state of the writer, so that it can be resumed.
+func (w *writer) Close() error {
+	if w.closed {
+		return fmt.Errorf("already closed")
+	}
+	w.closed = true
+
+	if w.committed || w.cancelled {
+		return nil
+	}
+	var metadata map[string]string
+	if w.uploadID != "" {
+		metadata = map[string]string{uploadIDMetadata: w.uploadID}
+	}
+	return w.driver.client.putObject(w.ctx, w.name, w.buf, uploadSessionContentType, metadata)
+}
+
+// Cancel aborts the multipart upload and removes the stored state of the
+// writer.
+func (w *writer) Cancel(ctx context.Context) error {
+	if err := w.done(); err != nil {
+		return err
+	}
+	w.cancelled = true
+
+	if w.uploadID != "" {
+		if err := w.driver.client.abortMultipartUpload(ctx, w.name, w.uploadID); err != nil && !isStatus(err, http.StatusNotFound) {
+			return err
+		}
+	}
+	if err := w.driver.client.deleteObject(ctx, w.name); err != nil && !isStatus(err, http.StatusNotFound) {
+		return err
+	}
+	return nil
+}
+
+// Commit stores the content written as the object, replacing the stored
+// state of the writer.
+func (w *writer) Commit(ctx context.Context) error {
+	if err := w.done(); err != nil {
+		return err
+	}
+
+	if w.uploadID == "" {
+		if err := w.driver.client.putObject(ctx, w.name, w.buf, blobContentType, nil); err != nil {
+			return err
+		}
+		w.committed = true
+		return nil
+	}
+
+	if len(w.buf) > 0 {
+		part, err := w.driver.client.uploadPart(ctx, w.name, w.uploadID, len(w.parts)+1, w.buf)
+		if err != nil {
+			return err
+		}
+		w.parts = append(w.parts, part)
+		w.offset += part.Size
+		w.buf = w.buf[:0]
+	}
+	if err := w.driver.client.commitMultipartUpload(ctx, w.name, w.uploadID, w.parts); err != nil {
+		return err
+	}
+	w.committed = true
+	return nil
+}
+
+// done returns an error if the writer is in an invalid state.
+func (w *writer) done() error {
+	switch {
+	case w.closed:
+		return fmt.Errorf("already closed")
+	case w.committed:
+		return fmt.Errorf("already committed")
+	case w.cancelled:
+		return fmt.Errorf("already cancelled")
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+package objectstorage
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/testsuites"
+)
+
+const (
+	envBucket      = "OCI_BUCKET"
+	envRegion      = "OCI_REGION"
+	envNamespace   = "OCI_NAMESPACE"
+	envCompartment = "OCI_COMPARTMENT"
+	envAuth        = "OCI_AUTH"
+	envConfigFile  = "OCI_CONFIG_FILE"
+	envProfile     = "OCI_PROFILE"
+)
+
+var (
+	objectStorageDriverConstructor func() (storagedriver.StorageDriver, error)
+	skipCheck                      func(tb testing.TB)
+)
+
+func init() {
+	bucket := os.Getenv(envBucket)
+
+	objectStorageDriverConstructor = func() (storagedriver.StorageDriver, error) {
+		parameters := map[string]any{
+			"bucket":        bucket,
+			"rootdirectory": "registry-test",
+			// small chunks exercise multipart uploads
+			"chunksize": minChunkSize,
+		}
+		for name, env := range map[string]string{
+			"region":      envRegion,
+			"namespace":   envNamespace,
+			"compartment": envCompartment,
+			"auth":        envAuth,
+			"configfile":  envConfigFile,
+			"profile":     envProfile,
+		} {
+			if v := os.Getenv(env); v != "" {
+				parameters[name] = v
+			}
+		}
+		return FromParameters(context.Background(), parameters)
+	}
+
+	// Skip OCI Object Storage driver tests if no bucket is provided
+	skipCheck = func(tb testing.TB) {
+		tb.Helper()
+
+		if bucket == "" {
+			tb.Skipf("Must set %s environment variable to run OCI Object Storage tests", envBucket)
+		}
+	}
+}
+
+func TestObjectStorageDriverSuite(t *testing.T) {
+	skipCheck(t)
+	testsuites.Driver(t, objectStorageDriverConstructor, false)
+}
+
+func BenchmarkObjectStorageDriverSuite(b *testing.B) {
+	skipCheck(b)
+	testsuites.BenchDriver(b, objectStorageDriverConstructor)
+}
+
+// newFakeDriverConstructor returns a constructor of drivers backed by an
+// in-process fake of the Object Storage API, authenticating with a
+// configuration file.
+func newFakeDriverConstructor(t *testing.T) func() (storagedriver.StorageDriver, error) {
+	dir := t.TempDir()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(dir, "key.pem")
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	if err := os.WriteFile(keyFile, keyPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	configFile := filepath.Join(dir, "config")
+	config := "[DEFAULT]\nuser=ocid1.user.oc1..other\n\n[registry]\n" +
+		"user=ocid1.user.oc1..registry\nfingerprint=12:34\ntenancy=ocid1.tenancy.oc1..test\n" +
+		"region=us-ashburn-1\nkey_file=" + keyFile + "\n"
+	if err := os.WriteFile(configFile, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(newFakeServer("testnamespace", "registry", "ocid1.tenancy.oc1..test/ocid1.user.oc1..registry/12:34", &key.PublicKey))
+	t.Cleanup(server.Close)
+
+	return func() (storagedriver.StorageDriver, error) {
+		return FromParameters(context.Background(), map[string]any{
+			"bucket":        "registry",
+			"configfile":    configFile,
+			"profile":       "registry",
+			"endpoint":      server.URL,
+			"compartment":   "ocid1.compartment.oc1..test",
+			"rootdirectory": "/registry/root",
+			"chunksize":     minChunkSize,
+		})
+	}
+}
+
+func TestFakeObjectStorageDriverSuite(t *testing.T) {
+	testsuites.Driver(t, newFakeDriverConstructor(t), false)
+}
+
+func TestFromParametersImpl(t *testing.T) {
+	defaults := DriverParameters{
+		Auth:           authConfigFile,
+		ConfigFile:     "~/.oci/config",
+		Profile:        defaultProfile,
+		Bucket:         "registry",
+		ChunkSize:      defaultChunkSize,
+		RedirectExpiry: defaultRedirectExpiry,
+	}
+
+	tests := []struct {
+		params   map[string]any
+		expected DriverParameters
+		pass     bool
+	}{
+		{
+			params:   map[string]any{"bucket": "registry"},
+			expected: defaults,
+			pass:     true,
+		},
+		{
+			params: map[string]any{
+				"bucket":         "registry",
+				"auth":           authInstancePrincipal,
+				"region":         "eu-frankfurt-1",
+				"namespace":      "tenancy",
+				"compartment":    "ocid1.compartment.oc1..test",
+				"rootdirectory":  "/registry",
+				"chunksize":      "20971520",
+				"redirectexpiry": "5m",
+			},
+			expected: DriverParameters{
+				Auth:           authInstancePrincipal,
+				ConfigFile:     "~/.oci/config",
+				Profile:        defaultProfile,
+				Region:         "eu-frankfurt-1",
+				Namespace:      "tenancy",
+				Bucket:         "registry",
+				Compartment:    "ocid1.compartment.oc1..test",
+				RootDirectory:  "/registry",
+				ChunkSize:      20 << 20,
+				RedirectExpiry: 5 * time.Minute,
+			},
+			pass: true,
+		},
+		{
+			params: map[string]any{},
+			pass:   false,
+		},
+		{
+			params: map[string]any{"bucket": "registry", "auth": "password"},
+			pass:   false,
+		},
+		{
+			params: map[string]any{"bucket": "registry", "endpoint": "objectstorage.example.com"},
+			pass:   false,
+		},
+		{
+			params: map[string]any{"bucket": "registry", "chunksize": 1 << 20},
+			pass:   false,
+		},
+		{
+			params: map[string]any{"bucket": "registry", "redirectexpiry": "soon"},
+			pass:   false,
+		},
+		{
+			params: map[string]any{"bucket": "registry", "redirectexpiry": "-1m"},
+			pass:   false,
+		},
+	}
+
+	for _, tc := range tests {
+		params, err := fromParametersImpl(tc.params)
+		if !tc.pass {
+			if err == nil {
+				t.Fatalf("expected error for params %v", tc.params)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("unexpected error for params %v: %v", tc.params, err)
+		}
+		if !reflect.DeepEqual(*params, tc.expected) {
+			t.Fatalf("unexpected params from %v: %+v != %+v", tc.params, *params, tc.expected)
+		}
+	}
+}
@@ -0,0 +1,403 @@
+package objectstorage
+
+import (
+	"crypto"
+	"crypto/md5"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fakeServer is an in-process implementation of the subset of the Object
+// Storage API used by the driver, verifying the signatures of requests.
+type fakeServer struct {
+	namespace string
+	bucket    string
+	keyID     string
+	publicKey *rsa.PublicKey
+
+	mu      sync.Mutex
+	objects map[string]*fakeObject
+	uploads map[string]*fakeUpload
+	// pars maps the tokens of pre-authenticated requests to object names.
+	pars   map[string]string
+	nextID int
+}
+
+type fakeObject struct {
+	content     []byte
+	contentType string
+	metadata    map[string]string
+	modified    time.Time
+}
+
+type fakeUpload struct {
+	object      string
+	contentType string
+	parts       map[int][]byte
+}
+
+func newFakeServer(namespace, bucket, keyID string, publicKey *rsa.PublicKey) *fakeServer {
+	return &fakeServer{
+		namespace: namespace,
+		bucket:    bucket,
+		keyID:     keyID,
+		publicKey: publicKey,
+		objects:   make(map[string]*fakeObject),
+		uploads:   make(map[string]*fakeUpload),
+		pars:      make(map[string]string),
+	}
+}
+
+func (s *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	segments := strings.Split(strings.TrimPrefix(r.URL.EscapedPath(), "/"), "/")
+	for i, segment := range segments {
+		segments[i], _ = url.PathUnescape(segment)
+	}
+
+	if segments[0] == "p" && len(segments) == 2 {
+		name, ok := s.pars[segments[1]]
+		if !ok {
+			serviceErrorResponse(w, http.StatusNotFound, "NotAuthorizedOrNotFound")
+			return
+		}
+		s.getObject(w, r, name)
+		return
+	}
+
+	if err := s.verify(r); err != nil {
+		serviceErrorResponse(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	switch {
+	case len(segments) == 2 && segments[0] == "n" && segments[1] == "":
+		_ = json.NewEncoder(w).Encode(s.namespace)
+		return
+	case len(segments) < 4 || segments[0] != "n" || segments[1] != s.namespace || segments[2] != "b":
+		serviceErrorResponse(w, http.StatusNotFound, "NotAuthorizedOrNotFound")
+		return
+	case segments[3] != s.bucket:
+		if r.Method == http.MethodPost && len(segments) == 4 && segments[3] == "" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		serviceErrorResponse(w, http.StatusNotFound, "BucketNotFound")
+		return
+	}
+
+	switch resource := segments[4:]; {
+	case len(resource) == 0 && r.Method == http.MethodHead:
+		w.WriteHeader(http.StatusOK)
+	case len(resource) == 1 && resource[0] == "o" && r.Method == http.MethodGet:
+		s.listObjects(w, r)
+	case len(resource) == 2 && resource[0] == "o":
+		s.object(w, r, resource[1])
+	case len(resource) == 2 && resource[0] == "actions" && resource[1] == "renameObject" && r.Method == http.MethodPost:
+		s.renameObject(w, r)
+	case len(resource) == 1 && resource[0] == "u" && r.Method == http.MethodPost:
+		s.createUpload(w, r)
+	case len(resource) == 2 && resource[0] == "u":
+		s.upload(w, r, resource[1])
+	case len(resource) == 1 && resource[0] == "p" && r.Method == http.MethodPost:
+		s.createPAR(w, r)
+	default:
+		serviceErrorResponse(w, http.StatusNotFound, "NotFound")
+	}
+}
+
+// verify verifies the signature of r.
+func (s *fakeServer) verify(r *http.Request) error {
+	params := make(map[string]string)
+	authorization, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Signature ")
+	if !ok {
+		return fmt.Errorf("missing signature")
+	}
+	for _, param := range strings.Split(authorization, ",") {
+		k, v, _ := strings.Cut(param, "=")
+		params[k] = strings.Trim(v, `"`)
+	}
+	if params["keyId"] != s.keyID {
+		return fmt.Errorf("unknown key %q", params["keyId"])
+	}
+
+	headers := strings.Split(params["headers"], " ")
+	if r.Method == http.MethodPost && !strings.Contains(params["headers"], "x-content-sha256") {
+		return fmt.Errorf("body of %s not signed", r.URL)
+	}
+	signature, err := base64.StdEncoding.DecodeString(params["signature"])
+	if err != nil {
+		return err
+	}
+	digest := sha256.Sum256([]byte(signingString(r, headers)))
+	if err := rsa.VerifyPKCS1v15(s.publicKey, crypto.SHA256, digest[:], signature); err != nil {
+		return err
+	}
+
+	if sum := r.Header.Get("X-Content-Sha256"); sum != "" {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+		bodySum := sha256.Sum256(body)
+		if base64.StdEncoding.EncodeToString(bodySum[:]) != sum {
+			return fmt.Errorf("body does not match x-content-sha256")
+		}
+		r.Body = io.NopCloser(strings.NewReader(string(body)))
+	}
+	return nil
+}
+
+// signingString returns the string signed for the given headers of req.
+func signingString(req *http.Request, headers []string) string {
+	lines := make([]string, len(headers))
+	for i, h := range headers {
+		switch h {
+		case "(request-target)":
+			lines[i] = h + ": " + strings.ToLower(req.Method) + " " + req.URL.RequestURI()
+		case "host":
+			lines[i] = h + ": " + req.Host
+		default:
+			lines[i] = h + ": " + req.Header.Get(h)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (s *fakeServer) object(w http.ResponseWriter, r *http.Request, name string) {
+	switch r.Method {
+	case http.MethodHead, http.MethodGet:
+		s.getObject(w, r, name)
+	case http.MethodPut:
+		content, err := io.ReadAll(r.Body)
+		if err != nil {
+			serviceErrorResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		object := &fakeObject{
+			content:     content,
+			contentType: r.Header.Get("Content-Type"),
+			metadata:    make(map[string]string),
+			modified:    time.Now(),
+		}
+		for k, v := range r.Header {
+			if key, ok := strings.CutPrefix(strings.ToLower(k), "opc-meta-"); ok {
+				object.metadata[key] = v[0]
+			}
+		}
+		s.objects[name] = object
+		w.WriteHeader(http.StatusOK)
+	case http.MethodDelete:
+		if _, ok := s.objects[name]; !ok {
+			serviceErrorResponse(w, http.StatusNotFound, "ObjectNotFound")
+			return
+		}
+		delete(s.objects, name)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		serviceErrorResponse(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+func (s *fakeServer) getObject(w http.ResponseWriter, r *http.Request, name string) {
+	object, ok := s.objects[name]
+	if !ok {
+		serviceErrorResponse(w, http.StatusNotFound, "ObjectNotFound")
+		return
+	}
+
+	w.Header().Set("Content-Type", object.contentType)
+	w.Header().Set("Last-Modified", object.modified.UTC().Format(http.TimeFormat))
+	for k, v := range object.metadata {
+		w.Header().Set("opc-meta-"+k, v)
+	}
+
+	content := object.content
+	status := http.StatusOK
+	if rangeHeader, ok := strings.CutPrefix(r.Header.Get("Range"), "bytes="); ok {
+		start, err := strconv.Atoi(strings.TrimSuffix(rangeHeader, "-"))
+		if err != nil || start >= len(content) {
+			serviceErrorResponse(w, http.StatusRequestedRangeNotSatisfiable, "InvalidRange")
+			return
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, len(content)-1, len(content)))
+		content = content[start:]
+		status = http.StatusPartialContent
+	}
+
+	w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		_, _ = w.Write(content)
+	}
+}
+
+func (s *fakeServer) listObjects(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	prefix, delimiter, start := query.Get("prefix"), query.Get("delimiter"), query.Get("start")
+	limit, _ := strconv.Atoi(query.Get("limit"))
+
+	var names []string
+	for name := range s.objects {
+		if strings.HasPrefix(name, prefix) && name >= start {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	list := objectList{Objects: []objectSummary{}}
+	var lastPrefix string
+	for _, name := range names {
+		if lastPrefix != "" && strings.HasPrefix(name, lastPrefix) {
+			continue
+		}
+		if len(list.Objects)+len(list.Prefixes) == limit {
+			list.NextStartWith = name
+			break
+		}
+		if delimiter != "" {
+			if i := strings.Index(name[len(prefix):], delimiter); i >= 0 {
+				lastPrefix = name[:len(prefix)+i+len(delimiter)]
+				list.Prefixes = append(list.Prefixes, lastPrefix)
+				continue
+			}
+		}
+		object := s.objects[name]
+		list.Objects = append(list.Objects, objectSummary{Name: name, Size: int64(len(object.content)), TimeModified: object.modified})
+	}
+	_ = json.NewEncoder(w).Encode(list)
+}
+
+func (s *fakeServer) renameObject(w http.ResponseWriter, r *http.Request) {
+	var rename struct {
+		SourceName string `json:"sourceName"`
+		NewName    string `json:"newName"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&rename); err != nil {
+		serviceErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	object, ok := s.objects[rename.SourceName]
+	if !ok {
+		serviceErrorResponse(w, http.StatusNotFound, "ObjectNotFound")
+		return
+	}
+	delete(s.objects, rename.SourceName)
+	s.objects[rename.NewName] = object
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *fakeServer) createUpload(w http.ResponseWriter, r *http.Request) {
+	var create struct {
+		Object      string `json:"object"`
+		ContentType string `json:"contentType"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&create); err != nil {
+		serviceErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	s.nextID++
+	uploadID := strconv.Itoa(s.nextID)
+	s.uploads[uploadID] = &fakeUpload{object: create.Object, contentType: create.ContentType, parts: make(map[int][]byte)}
+	_ = json.NewEncoder(w).Encode(map[string]string{"uploadId": uploadID})
+}
+
+func (s *fakeServer) upload(w http.ResponseWriter, r *http.Request, name string) {
+	upload, ok := s.uploads[r.URL.Query().Get("uploadId")]
+	if !ok || upload.object != name {
+		serviceErrorResponse(w, http.StatusNotFound, "NoSuchUpload")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		number, err := strconv.Atoi(r.URL.Query().Get("uploadPartNum"))
+		if err != nil {
+			serviceErrorResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		content, err := io.ReadAll(r.Body)
+		if err != nil {
+			serviceErrorResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		upload.parts[number] = content
+		w.Header().Set("ETag", etag(content))
+		w.WriteHeader(http.StatusOK)
+	case http.MethodGet:
+		parts := []uploadedPart{}
+		for number, content := range upload.parts {
+			parts = append(parts, uploadedPart{PartNumber: number, ETag: etag(content), Size: int64(len(content))})
+		}
+		sort.Slice(parts, func(i, j int) bool { return parts[i].PartNumber < parts[j].PartNumber })
+		_ = json.NewEncoder(w).Encode(parts)
+	case http.MethodPost:
+		var commit struct {
+			PartsToCommit []struct {
+				PartNum int    `json:"partNum"`
+				ETag    string `json:"etag"`
+			} `json:"partsToCommit"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&commit); err != nil {
+			serviceErrorResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		var content []byte
+		for _, part := range commit.PartsToCommit {
+			partContent, ok := upload.parts[part.PartNum]
+			if !ok || etag(partContent) != part.ETag {
+				serviceErrorResponse(w, http.StatusBadRequest, "InvalidPart")
+				return
+			}
+			content = append(content, partContent...)
+		}
+		s.objects[name] = &fakeObject{content: content, contentType: upload.contentType, modified: time.Now()}
+		delete(s.uploads, r.URL.Query().Get("uploadId"))
+		w.WriteHeader(http.StatusOK)
+	case http.MethodDelete:
+		delete(s.uploads, r.URL.Query().Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		serviceErrorResponse(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+func (s *fakeServer) createPAR(w http.ResponseWriter, r *http.Request) {
+	var create struct {
+		ObjectName string `json:"objectName"`
+		AccessType string `json:"accessType"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&create); err != nil || create.AccessType != "ObjectRead" {
+		serviceErrorResponse(w, http.StatusBadRequest, "InvalidParameter")
+		return
+	}
+	s.nextID++
+	token := "par" + strconv.Itoa(s.nextID)
+	s.pars[token] = create.ObjectName
+	_ = json.NewEncoder(w).Encode(map[string]string{"accessUri": "/p/" + token})
+}
+
+func etag(content []byte) string {
+	sum := md5.Sum(content)
+	return hex.EncodeToString(sum[:])
+}
+
+func serviceErrorResponse(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"code": code, "message": code})
+}
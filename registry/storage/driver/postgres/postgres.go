@@ -0,0 +1,638 @@
+// Package postgres provides a storagedriver.StorageDriver implementation which
+// stores content in a PostgreSQL database.
+//
+// Files are stored in two tables: one holding a row per file with its size
+// and modification time, and one holding the file contents split into bytea
+// chunks, keyed by their offset within the file. Directories are implicit,
+// they exist as long as a file exists below them.
+package postgres
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/base"
+	"github.com/distribution/distribution/v3/registry/storage/driver/factory"
+	_ "github.com/lib/pq" // registers the postgres database/sql driver
+)
+
+const (
+	driverName = "postgres"
+
+	defaultTablePrefix = "registry"
+
+	// minChunkSize is the minimum size of the chunks file contents are
+	// split into.
+	minChunkSize = 64 << 10
+
+	// defaultChunkSize is the default size of the chunks file contents are
+	// split into.
+	defaultChunkSize = 4 << 20
+
+	// maxChunkSize keeps chunks well below the 1GB limit of a bytea value.
+	maxChunkSize = 256 << 20
+
+	defaultMaxConnections = 25
+)
+
+// tablePrefixRegexp restricts table prefixes to plain SQL identifiers, as
+// they are interpolated into queries.
+var tablePrefixRegexp = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// DriverParameters represents all configuration options available for the
+// postgres driver
+type DriverParameters struct {
+	// DSN is the connection string of the database.
+	DSN string
+
+	// TablePrefix is prepended to the names of the tables of the driver.
+	TablePrefix string
+
+	// ChunkSize is the size of the chunks file contents are split into.
+	ChunkSize int
+
+	// MaxConnections is the maximum number of open connections to the
+	// database.
+	MaxConnections int
+}
+
+func init() {
+	factory.Register(driverName, &postgresDriverFactory{})
+}
+
+// postgresDriverFactory implements the factory.StorageDriverFactory interface
+type postgresDriverFactory struct{}
+
+func (factory *postgresDriverFactory) Create(ctx context.Context, parameters map[string]any) (storagedriver.StorageDriver, error) {
+	return FromParameters(ctx, parameters)
+}
+
+type driver struct {
+	db        *sql.DB
+	chunkSize int
+	files     string
+	chunks    string
+}
+
+type baseEmbed struct {
+	base.Base
+}
+
+// Driver is a storagedriver.StorageDriver implementation backed by a
+// PostgreSQL database.
+type Driver struct {
+	baseEmbed
+}
+
+// FromParameters constructs a new Driver with a given parameters map
+// Required parameters:
+// - dsn
+// Optional Parameters:
+// - tableprefix
+// - chunksize
+// - maxconnections
+func FromParameters(ctx context.Context, parameters map[string]any) (*Driver, error) {
+	params, err := fromParametersImpl(parameters)
+	if err != nil {
+		return nil, err
+	}
+	return New(ctx, *params)
+}
+
+func fromParametersImpl(parameters map[string]any) (*DriverParameters, error) {
+	params := &DriverParameters{
+		TablePrefix:    defaultTablePrefix,
+		ChunkSize:      defaultChunkSize,
+		MaxConnections: defaultMaxConnections,
+	}
+
+	dsn, ok := parameters["dsn"]
+	if !ok || fmt.Sprint(dsn) == "" {
+		return nil, fmt.Errorf("no dsn parameter provided")
+	}
+	params.DSN = fmt.Sprint(dsn)
+
+	if prefix, ok := parameters["tableprefix"]; ok {
+		params.TablePrefix = fmt.Sprint(prefix)
+		if !tablePrefixRegexp.MatchString(params.TablePrefix) {
+			return nil, fmt.Errorf("tableprefix %q must match %s", params.TablePrefix, tablePrefixRegexp)
+		}
+	}
+
+	var err error
+	if params.ChunkSize, err = intParameter(parameters, "chunksize", defaultChunkSize); err != nil {
+		return nil, err
+	}
+	if params.ChunkSize < minChunkSize || params.ChunkSize > maxChunkSize {
+		return nil, fmt.Errorf("chunksize %d must be between %d and %d", params.ChunkSize, minChunkSize, maxChunkSize)
+	}
+
+	if params.MaxConnections, err = intParameter(parameters, "maxconnections", defaultMaxConnections); err != nil {
+		return nil, err
+	}
+	if params.MaxConnections < 0 {
+		return nil, fmt.Errorf("maxconnections %d must not be negative", params.MaxConnections)
+	}
+
+	return params, nil
+}
+
+// intParameter returns the integer value of the parameter name, or def if it
+// is not set.
+func intParameter(parameters map[string]any, name string, def int) (int, error) {
+	param, ok := parameters[name]
+	if !ok {
+		return def, nil
+	}
+
+	switch v := param.(type) {
+	case string:
+		vv, err := strconv.Atoi(v)
+		if err != nil {
+			return 0, fmt.Errorf("%s must be an integer, %v invalid", name, param)
+		}
+		return vv, nil
+	case int, uint, int32, uint32, uint64, int64:
+		return int(reflect.ValueOf(v).Convert(reflect.TypeFor[int]()).Int()), nil
+	default:
+		return 0, fmt.Errorf("invalid value for %s: %#v", name, param)
+	}
+}
+
+// New constructs a new Driver with the given parameters, creating its tables
+// if required.
+func New(ctx context.Context, params DriverParameters) (*Driver, error) {
+	db, err := sql.Open("postgres", params.DSN)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(params.MaxConnections)
+
+	d := &driver{
+		db:        db,
+		chunkSize: params.ChunkSize,
+		files:     params.TablePrefix + "_files",
+		chunks:    params.TablePrefix + "_chunks",
+	}
+	if err := d.createTables(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create tables: %w", err)
+	}
+
+	return &Driver{
+		baseEmbed: baseEmbed{
+			Base: base.Base{
+				StorageDriver: d,
+			},
+		},
+	}, nil
+}
+
+// createTables creates the tables of the driver if they do not exist. Paths
+// use the "C" collation so that they are ordered bytewise, which range
+// queries on directories rely on. Chunks follow their file on update and
+// delete, making moves and deletes a single statement on the files table.
+func (d *driver) createTables(ctx context.Context) error {
+	_, err := d.db.ExecContext(ctx, fmt.Sprintf(`
+CREATE TABLE IF NOT EXISTS %[1]s (
+	path text COLLATE "C" PRIMARY KEY,
+	size bigint NOT NULL,
+	modified timestamptz NOT NULL
+);
+CREATE TABLE IF NOT EXISTS %[2]s (
+	path text COLLATE "C" NOT NULL REFERENCES %[1]s (path) ON UPDATE CASCADE ON DELETE CASCADE,
+	off bigint NOT NULL,
+	data bytea NOT NULL,
+	PRIMARY KEY (path, off)
+);`, d.files, d.chunks))
+	return err
+}
+
+// Implement the storagedriver.StorageDriver interface
+
+func (d *driver) Name() string {
+	return driverName
+}
+
+// GetContent retrieves the content stored at "path" as a []byte.
+func (d *driver) GetContent(ctx context.Context, path string) ([]byte, error) {
+	rc, err := d.Reader(ctx, path, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	return io.ReadAll(rc)
+}
+
+// PutContent stores the []byte content at a location designated by "path".
+// The content is replaced atomically.
+func (d *driver) PutContent(ctx context.Context, path string, contents []byte) error {
+	return d.inTx(ctx, func(tx *sql.Tx) error {
+		if err := d.createFile(ctx, tx, path); err != nil {
+			return err
+		}
+		for off := 0; off < len(contents); off += d.chunkSize {
+			end := min(off+d.chunkSize, len(contents))
+			if err := d.putChunk(ctx, tx, path, int64(off), contents[off:end]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Reader retrieves an io.ReadCloser for the content stored at "path" with a
+// given byte offset.
+func (d *driver) Reader(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
+	var size int64
+	err := d.db.QueryRowContext(ctx, fmt.Sprintf(`SELECT size FROM %s WHERE path = $1`, d.files), path).Scan(&size)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, storagedriver.PathNotFoundError{Path: path}
+		}
+		return nil, err
+	}
+	if offset > size {
+		return nil, storagedriver.InvalidOffsetError{Path: path, Offset: offset}
+	}
+
+	return &reader{
+		ctx:  ctx,
+		d:    d,
+		path: path,
+		pos:  offset,
+	}, nil
+}
+
+// Writer returns a FileWriter which will store the content written to it
+// at the location designated by "path" after the call to Commit. When
+// appending, the writer resumes after the content already stored.
+func (d *driver) Writer(ctx context.Context, path string, append bool) (storagedriver.FileWriter, error) {
+	w := &writer{
+		ctx:  ctx,
+		d:    d,
+		path: path,
+	}
+
+	if !append {
+		if err := d.inTx(ctx, func(tx *sql.Tx) error {
+			return d.createFile(ctx, tx, path)
+		}); err != nil {
+			return nil, err
+		}
+		return w, nil
+	}
+
+	var size int64
+	err := d.db.QueryRowContext(ctx, fmt.Sprintf(`SELECT size FROM %s WHERE path = $1`, d.files), path).Scan(&size)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		if err := d.inTx(ctx, func(tx *sql.Tx) error {
+			return d.createFile(ctx, tx, path)
+		}); err != nil {
+			return nil, err
+		}
+		return w, nil
+	case err != nil:
+		return nil, err
+	}
+
+	// Resume from the last chunk if it is not full, so that appends do not
+	// leave a trail of small chunks behind.
+	var (
+		off  int64
+		data []byte
+	)
+	err = d.db.QueryRowContext(ctx, fmt.Sprintf(`SELECT off, data FROM %s WHERE path = $1 ORDER BY off DESC LIMIT 1`, d.chunks), path).Scan(&off, &data)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		w.off = size
+	case err != nil:
+		return nil, err
+	case len(data) < d.chunkSize && off+int64(len(data)) == size:
+		w.off = off
+		w.buf = data
+	default:
+		w.off = size
+	}
+	return w, nil
+}
+
+// Stat retrieves the FileInfo for the given path, including the current size
+// in bytes and the modification time.
+func (d *driver) Stat(ctx context.Context, path string) (storagedriver.FileInfo, error) {
+	fi := storagedriver.FileInfoFields{Path: path}
+
+	err := d.db.QueryRowContext(ctx, fmt.Sprintf(`SELECT size, modified FROM %s WHERE path = $1`, d.files), path).Scan(&fi.Size, &fi.ModTime)
+	if err == nil {
+		return storagedriver.FileInfoInternal{FileInfoFields: fi}, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	// path is a directory if files exist below it
+	lower, upper := dirRange(path)
+	var modified sql.NullTime
+	err = d.db.QueryRowContext(ctx, fmt.Sprintf(`SELECT max(modified) FROM %s WHERE path >= $1 AND path < $2`, d.files), lower, upper).Scan(&modified)
+	if err != nil {
+		return nil, err
+	}
+	if !modified.Valid {
+		return nil, storagedriver.PathNotFoundError{Path: path}
+	}
+	fi.IsDir = true
+	fi.ModTime = modified.Time
+	return storagedriver.FileInfoInternal{FileInfoFields: fi}, nil
+}
+
+// List returns a list of the objects that are direct descendants of the given
+// path.
+func (d *driver) List(ctx context.Context, path string) ([]string, error) {
+	lower, upper := dirRange(path)
+	rows, err := d.db.QueryContext(ctx, fmt.Sprintf(`SELECT DISTINCT split_part(substr(path, char_length($1) + 1), '/', 1) AS child FROM %s WHERE path >= $1 AND path < $2 ORDER BY child`, d.files), lower, upper)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []string{}
+	for rows.Next() {
+		var child string
+		if err := rows.Scan(&child); err != nil {
+			return nil, err
+		}
+		keys = append(keys, lower+child)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(keys) == 0 && path != "/" {
+		return nil, storagedriver.PathNotFoundError{Path: path}
+	}
+	return keys, nil
+}
+
+// Move moves an object stored at sourcePath to destPath, removing the original
+// object. The move is performed in a single transaction.
+func (d *driver) Move(ctx context.Context, sourcePath string, destPath string) error {
+	return d.inTx(ctx, func(tx *sql.Tx) error {
+		var size int64
+		err := tx.QueryRowContext(ctx, fmt.Sprintf(`SELECT size FROM %s WHERE path = $1 FOR UPDATE`, d.files), sourcePath).Scan(&size)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return storagedriver.PathNotFoundError{Path: sourcePath}
+			}
+			return err
+		}
+
+		if sourcePath == destPath {
+			return nil
+		}
+
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE path = $1`, d.files), destPath); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, fmt.Sprintf(`UPDATE %s SET path = $2 WHERE path = $1`, d.files), sourcePath, destPath)
+		return err
+	})
+}
+
+// Delete recursively deletes all objects stored at "path" and its subpaths.
+// The delete is performed in a single statement.
+func (d *driver) Delete(ctx context.Context, path string) error {
+	lower, upper := dirRange(path)
+	res, err := d.db.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE path = $1 OR (path >= $2 AND path < $3)`, d.files), path, lower, upper)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return storagedriver.PathNotFoundError{Path: path}
+	}
+	return nil
+}
+
+// RedirectURL returns a URL which may be used to retrieve the content stored
+// at the given path. Content stored in the database cannot be served
+// directly, so requests are never redirected.
+func (d *driver) RedirectURL(*http.Request, string) (string, error) {
+	return "", nil
+}
+
+// Walk traverses a filesystem defined within driver, starting
+// from the given path, calling f on each file and directory
+func (d *driver) Walk(ctx context.Context, path string, f storagedriver.WalkFn, options ...func(*storagedriver.WalkOptions)) error {
+	return storagedriver.WalkFallback(ctx, d, path, f, options...)
+}
+
+// inTx runs fn in a transaction, which is committed if fn succeeds and
+// rolled back otherwise.
+func (d *driver) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return errors.Join(err, rbErr)
+		}
+		return err
+	}
+	return tx.Commit()
+}
+
+// createFile creates an empty file at path, replacing any existing one.
+func (d *driver) createFile(ctx context.Context, tx *sql.Tx, path string) error {
+	_, err := tx.ExecContext(ctx, fmt.Sprintf(`INSERT INTO %s (path, size, modified) VALUES ($1, 0, now()) ON CONFLICT (path) DO UPDATE SET size = 0, modified = now()`, d.files), path)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE path = $1`, d.chunks), path)
+	return err
+}
+
+// putChunk stores data at offset off of the file at path, replacing the chunk
+// previously stored at that offset, and updates the size of the file.
+func (d *driver) putChunk(ctx context.Context, tx *sql.Tx, path string, off int64, data []byte) error {
+	_, err := tx.ExecContext(ctx, fmt.Sprintf(`INSERT INTO %s (path, off, data) VALUES ($1, $2, $3) ON CONFLICT (path, off) DO UPDATE SET data = EXCLUDED.data`, d.chunks), path, off, data)
+	if err != nil {
+		return err
+	}
+	res, err := tx.ExecContext(ctx, fmt.Sprintf(`UPDATE %s SET size = $2, modified = now() WHERE path = $1`, d.files), path, off+int64(len(data)))
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		// the file was deleted or moved while being written
+		return storagedriver.PathNotFoundError{Path: path}
+	}
+	return nil
+}
+
+// dirRange returns the bounds of the paths below the directory path: they
+// sort at or after lower and strictly before upper.
+func dirRange(path string) (lower, upper string) {
+	lower = strings.TrimSuffix(path, "/") + "/"
+	// '0' is the character following '/'
+	upper = lower[:len(lower)-1] + "0"
+	return lower, upper
+}
+
+// reader streams the content of a file, fetching a chunk at a time.
+type reader struct {
+	ctx    context.Context
+	d      *driver
+	path   string
+	pos    int64
+	buf    []byte
+	closed bool
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if r.closed {
+		return 0, fmt.Errorf("already closed")
+	}
+
+	if len(r.buf) == 0 {
+		var (
+			off  int64
+			data []byte
+		)
+		err := r.d.db.QueryRowContext(r.ctx, fmt.Sprintf(`SELECT off, data FROM %s WHERE path = $1 AND off <= $2 ORDER BY off DESC LIMIT 1`, r.d.chunks), r.path, r.pos).Scan(&off, &data)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return 0, io.EOF
+			}
+			return 0, err
+		}
+		if r.pos-off >= int64(len(data)) {
+			return 0, io.EOF
+		}
+		r.buf = data[r.pos-off:]
+	}
+
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	r.pos += int64(n)
+	return n, nil
+}
+
+func (r *reader) Close() error {
+	r.closed = true
+	r.buf = nil
+	return nil
+}
+
+// writer buffers the content written to a file until a full chunk is
+// available. The buffer always starts at the offset of a chunk: when
+// appending to a file whose last chunk is not full, that chunk is loaded in
+// the buffer and rewritten once more content is available.
+type writer struct {
+	ctx       context.Context
+	d         *driver
+	path      string
+	off       int64
+	buf       []byte
+	closed    bool
+	committed bool
+	cancelled bool
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, fmt.Errorf("already closed")
+	} else if w.committed {
+		return 0, fmt.Errorf("already committed")
+	} else if w.cancelled {
+		return 0, fmt.Errorf("already cancelled")
+	}
+
+	w.buf = append(w.buf, p...)
+	for len(w.buf) >= w.d.chunkSize {
+		if err := w.flushChunk(w.buf[:w.d.chunkSize]); err != nil {
+			return 0, err
+		}
+		w.off += int64(w.d.chunkSize)
+		w.buf = bytes.Clone(w.buf[w.d.chunkSize:])
+	}
+	return len(p), nil
+}
+
+func (w *writer) Size() int64 {
+	return w.off + int64(len(w.buf))
+}
+
+func (w *writer) Close() error {
+	if w.closed {
+		return fmt.Errorf("already closed")
+	}
+	w.closed = true
+
+	if w.committed || w.cancelled {
+		return nil
+	}
+	return w.flush()
+}
+
+func (w *writer) Cancel(ctx context.Context) error {
+	if w.closed {
+		return fmt.Errorf("already closed")
+	} else if w.committed {
+		return fmt.Errorf("already committed")
+	}
+	w.cancelled = true
+
+	_, err := w.d.db.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE path = $1`, w.d.files), w.path)
+	return err
+}
+
+func (w *writer) Commit(ctx context.Context) error {
+	if w.closed {
+		return fmt.Errorf("already closed")
+	} else if w.committed {
+		return fmt.Errorf("already committed")
+	} else if w.cancelled {
+		return fmt.Errorf("already cancelled")
+	}
+
+	if err := w.flush(); err != nil {
+		return err
+	}
+	w.committed = true
+	return nil
+}
+
+// flush stores the partial chunk held in the buffer, if any.
+func (w *writer) flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	return w.flushChunk(w.buf)
+}
+
+// flushChunk stores data at the offset of the buffer.
+func (w *writer) flushChunk(data []byte) error {
+	return w.d.inTx(w.ctx, func(tx *sql.Tx) error {
+		return w.d.putChunk(w.ctx, tx, w.path, w.off, data)
+	})
+}
@@ -0,0 +1,140 @@
+package postgres
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/testsuites"
+)
+
+const (
+	envDSN         = "POSTGRES_DSN"
+	envTablePrefix = "POSTGRES_TABLE_PREFIX"
+)
+
+var (
+	postgresDriverConstructor func() (storagedriver.StorageDriver, error)
+	skipCheck                 func(tb testing.TB)
+)
+
+func init() {
+	dsn := os.Getenv(envDSN)
+	tablePrefix := os.Getenv(envTablePrefix)
+	if tablePrefix == "" {
+		tablePrefix = "registry_test"
+	}
+
+	postgresDriverConstructor = func() (storagedriver.StorageDriver, error) {
+		return FromParameters(context.Background(), map[string]any{
+			"dsn":         dsn,
+			"tableprefix": tablePrefix,
+			// small chunks exercise reads and appends spanning chunks
+			"chunksize": minChunkSize,
+		})
+	}
+
+	// Skip postgres storage driver tests if no database is provided
+	skipCheck = func(tb testing.TB) {
+		tb.Helper()
+
+		if dsn == "" {
+			tb.Skipf("Must set %s environment variable to run postgres tests", envDSN)
+		}
+	}
+}
+
+func TestPostgresDriverSuite(t *testing.T) {
+	skipCheck(t)
+	testsuites.Driver(t, postgresDriverConstructor, false)
+}
+
+func BenchmarkPostgresDriverSuite(b *testing.B) {
+	skipCheck(b)
+	testsuites.BenchDriver(b, postgresDriverConstructor)
+}
+
+func TestFromParametersImpl(t *testing.T) {
+	tests := []struct {
+		params   map[string]any
+		expected DriverParameters
+		pass     bool
+	}{
+		{
+			params: map[string]any{"dsn": "postgres://localhost/registry"},
+			expected: DriverParameters{
+				DSN:            "postgres://localhost/registry",
+				TablePrefix:    defaultTablePrefix,
+				ChunkSize:      defaultChunkSize,
+				MaxConnections: defaultMaxConnections,
+			},
+			pass: true,
+		},
+		{
+			params: map[string]any{
+				"dsn":            "postgres://localhost/registry",
+				"tableprefix":    "blobs",
+				"chunksize":      "1048576",
+				"maxconnections": 10,
+			},
+			expected: DriverParameters{
+				DSN:            "postgres://localhost/registry",
+				TablePrefix:    "blobs",
+				ChunkSize:      1 << 20,
+				MaxConnections: 10,
+			},
+			pass: true,
+		},
+		{
+			params: map[string]any{},
+			pass:   false,
+		},
+		{
+			params: map[string]any{"dsn": "postgres://localhost/registry", "tableprefix": "registry; DROP TABLE users"},
+			pass:   false,
+		},
+		{
+			params: map[string]any{"dsn": "postgres://localhost/registry", "chunksize": 1024},
+			pass:   false,
+		},
+		{
+			params: map[string]any{"dsn": "postgres://localhost/registry", "chunksize": "fail"},
+			pass:   false,
+		},
+		{
+			params: map[string]any{"dsn": "postgres://localhost/registry", "maxconnections": -1},
+			pass:   false,
+		},
+	}
+
+	for _, item := range tests {
+		params, err := fromParametersImpl(item.params)
+		if !item.pass {
+			if err == nil {
+				t.Errorf("expected error configuring postgres driver with %v", item.params)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("unexpected error configuring postgres driver with %v: %v", item.params, err)
+		}
+		if *params != item.expected {
+			t.Errorf("expected parameters %+v, got %+v", item.expected, *params)
+		}
+	}
+}
+
+func TestDirRange(t *testing.T) {
+	for _, tc := range []struct {
+		path, lower, upper string
+	}{
+		{path: "/", lower: "/", upper: "0"},
+		{path: "/docker/registry", lower: "/docker/registry/", upper: "/docker/registry0"},
+	} {
+		lower, upper := dirRange(tc.path)
+		if lower != tc.lower || upper != tc.upper {
+			t.Errorf("dirRange(%q) = %q, %q, expected %q, %q", tc.path, lower, upper, tc.lower, tc.upper)
+		}
+	}
+}
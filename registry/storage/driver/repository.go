@@ -0,0 +1,27 @@
+package driver
+
+import "context"
+
+type repositoryKey struct{}
+
+// WithRepository returns a context for the calls made to a storage driver on
+// behalf of the repository name, on paths outside of the repository, such as
+// the data of the blobs it references. Drivers storing repositories on
+// different backends serve these calls on the backend of the repository only.
+func WithRepository(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, repositoryKey{}, name)
+}
+
+// RepositoryFromContext returns the repository the calls made with ctx are on
+// behalf of, or an empty string.
+func RepositoryFromContext(ctx context.Context) string {
+	name, _ := ctx.Value(repositoryKey{}).(string)
+	return name
+}
+
+// RepositoryRouter is implemented by the storage drivers storing
+// repositories on different backends.
+type RepositoryRouter interface {
+	// Backend returns the name of the backend storing the repository name.
+	Backend(name string) string
+}
@@ -0,0 +1,447 @@
+// Package routing provides a storage driver storing the repositories of a
+// registry on different backends, selected by repository name.
+package routing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/base"
+	"github.com/distribution/distribution/v3/registry/storage/driver/factory"
+)
+
+const driverName = "routing"
+
+// DefaultBackend names the storage driver configured for the registry, which
+// stores the repositories matching no rule.
+const DefaultBackend = "default"
+
+// Paths of the registry storage layout the routing depends on. Repository
+// names end at the first path component starting with an underscore.
+const (
+	repositoriesPath = "/docker/registry/v2/repositories"
+	blobsPath        = "/docker/registry/v2/blobs"
+)
+
+// Rule routes the repositories matching any of its patterns, in the syntax of
+// path.Match, to a backend.
+type Rule struct {
+	Repositories []string
+	Backend      string
+}
+
+type driver struct {
+	backends map[string]storagedriver.StorageDriver
+	// order is the order backends are tried in, the default backend first.
+	order []string
+	rules []Rule
+}
+
+// baseEmbed allows us to hide the Base embed.
+type baseEmbed struct {
+	base.Base
+}
+
+// Driver routes the paths of each repository to the backend of the first
+// rule matching its name, or to the default backend.
+//
+// Paths outside of repositories are shared by all backends. Calls made with a
+// context carrying a repository, see storagedriver.WithRepository, are served
+// by the backend of the repository. Otherwise, reads are served by the first
+// backend holding the path, the default backend first, listings and walks
+// merge the content of all backends, and deletions apply to all backends.
+// Writes go to the default backend, and moves to the backend of their source.
+type Driver struct {
+	baseEmbed // embedded, hidden base driver.
+	router    *driver
+}
+
+var (
+	_ storagedriver.StorageDriver    = &Driver{}
+	_ storagedriver.RepositoryRouter = &Driver{}
+)
+
+// New returns a driver routing the repositories matching rules to backends,
+// and the other repositories to the default backend.
+func New(defaultBackend storagedriver.StorageDriver, backends map[string]storagedriver.StorageDriver, rules []Rule) (*Driver, error) {
+	d := &driver{
+		backends: map[string]storagedriver.StorageDriver{DefaultBackend: defaultBackend},
+		order:    []string{DefaultBackend},
+	}
+	for name, backend := range backends {
+		if name == "" || name == DefaultBackend {
+			return nil, fmt.Errorf("invalid backend name %q", name)
+		}
+		d.backends[name] = backend
+		d.order = append(d.order, name)
+	}
+	slices.Sort(d.order[1:])
+
+	for i, rule := range rules {
+		if _, ok := d.backends[rule.Backend]; !ok {
+			return nil, fmt.Errorf("rule %d routes to unknown backend %q", i, rule.Backend)
+		}
+		if len(rule.Repositories) == 0 {
+			return nil, fmt.Errorf("rule %d does not match any repository", i)
+		}
+		for _, pattern := range rule.Repositories {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid rule %d pattern %q: %w", i, pattern, err)
+			}
+		}
+	}
+	d.rules = rules
+	return &Driver{baseEmbed: baseEmbed{Base: base.Base{StorageDriver: d}}, router: d}, nil
+}
+
+// FromParameters returns a driver routing repositories as described by the
+// routes parameters of the storage configuration, the other repositories to
+// defaultBackend. The backends are created with the storage driver factory.
+func FromParameters(ctx context.Context, defaultBackend storagedriver.StorageDriver, parameters map[string]any) (*Driver, error) {
+	backends := make(map[string]storagedriver.StorageDriver)
+	backendParameters, err := stringMap(parameters["backends"])
+	if err != nil {
+		return nil, fmt.Errorf("backends: %w", err)
+	}
+	for name, v := range backendParameters {
+		options, err := stringMap(v)
+		if err != nil {
+			return nil, fmt.Errorf("backend %q: %w", name, err)
+		}
+		driver, ok := options["driver"].(string)
+		if !ok || driver == "" {
+			return nil, fmt.Errorf("backend %q: driver must be the name of a storage driver", name)
+		}
+		driverParameters, err := stringMap(options["parameters"])
+		if err != nil {
+			return nil, fmt.Errorf("backend %q parameters: %w", name, err)
+		}
+		backend, err := factory.Create(ctx, driver, driverParameters)
+		if err != nil {
+			return nil, fmt.Errorf("failed to construct backend %q %s driver: %v", name, driver, err)
+		}
+		backends[name] = backend
+	}
+
+	var rules []Rule
+	ruleParameters, ok := parameters["rules"].([]any)
+	if !ok && parameters["rules"] != nil {
+		return nil, errors.New("rules must be a list")
+	}
+	for i, v := range ruleParameters {
+		options, err := stringMap(v)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i, err)
+		}
+		backend, ok := options["backend"].(string)
+		if !ok {
+			return nil, fmt.Errorf("rule %d: backend must be the name of a backend", i)
+		}
+		patterns, ok := options["repositories"].([]any)
+		if !ok {
+			return nil, fmt.Errorf("rule %d: repositories must be a list of patterns", i)
+		}
+		rule := Rule{Backend: backend}
+		for _, pattern := range patterns {
+			pattern, ok := pattern.(string)
+			if !ok {
+				return nil, fmt.Errorf("rule %d: repositories must be a list of patterns", i)
+			}
+			rule.Repositories = append(rule.Repositories, pattern)
+		}
+		rules = append(rules, rule)
+	}
+
+	return New(defaultBackend, backends, rules)
+}
+
+// stringMap converts a map decoded from the configuration to a map of
+// strings.
+func stringMap(v any) (map[string]any, error) {
+	switch m := v.(type) {
+	case nil:
+		return map[string]any{}, nil
+	case map[string]any:
+		return m, nil
+	case map[any]any:
+		converted := make(map[string]any, len(m))
+		for k, v := range m {
+			key, ok := k.(string)
+			if !ok {
+				return nil, errors.New("must be a map of strings")
+			}
+			converted[key] = v
+		}
+		return converted, nil
+	default:
+		return nil, errors.New("must be a map")
+	}
+}
+
+// Backend returns the name of the backend storing the repository name.
+func (d *Driver) Backend(name string) string {
+	return d.router.Backend(name)
+}
+
+// Backend returns the backend of the first rule matching name.
+func (d *driver) Backend(name string) string {
+	for _, rule := range d.rules {
+		for _, pattern := range rule.Repositories {
+			if ok, _ := path.Match(pattern, name); ok {
+				return rule.Backend
+			}
+		}
+	}
+	return DefaultBackend
+}
+
+// repositoryOf returns the repository subpath belongs to, if any.
+func repositoryOf(subpath string) (string, bool) {
+	rel, ok := strings.CutPrefix(subpath, repositoriesPath+"/")
+	if !ok {
+		return "", false
+	}
+	components := strings.Split(rel, "/")
+	for i, component := range components {
+		if strings.HasPrefix(component, "_") {
+			if i == 0 {
+				return "", false
+			}
+			return strings.Join(components[:i], "/"), true
+		}
+	}
+	// namespaces and repository directories hold the paths of any backend
+	return "", false
+}
+
+// route returns the backend serving subpath alone, or an empty string if the
+// path is shared by all backends.
+func (d *driver) route(ctx context.Context, subpath string) string {
+	if repository, ok := repositoryOf(subpath); ok {
+		return d.Backend(repository)
+	}
+	if repository := storagedriver.RepositoryFromContext(ctx); repository != "" {
+		return d.Backend(repository)
+	}
+	return ""
+}
+
+// candidates returns the backends to try reading subpath from, in order.
+func (d *driver) candidates(ctx context.Context, subpath string) []string {
+	if backend := d.route(ctx, subpath); backend != "" {
+		return []string{backend}
+	}
+	return d.order
+}
+
+// writeBackend returns the backend writes to subpath go to.
+func (d *driver) writeBackend(ctx context.Context, subpath string) string {
+	if backend := d.route(ctx, subpath); backend != "" {
+		return backend
+	}
+	return DefaultBackend
+}
+
+func isPathNotFound(err error) bool {
+	var notFound storagedriver.PathNotFoundError
+	return errors.As(err, &notFound)
+}
+
+// first calls read on the candidate backends of subpath until one holds it.
+func first[T any](ctx context.Context, d *driver, subpath string, read func(storagedriver.StorageDriver) (T, error)) (T, string, error) {
+	for _, name := range d.candidates(ctx, subpath) {
+		v, err := read(d.backends[name])
+		if err == nil || !isPathNotFound(err) {
+			return v, name, err
+		}
+	}
+	var zero T
+	return zero, "", storagedriver.PathNotFoundError{Path: subpath, DriverName: driverName}
+}
+
+// Name returns the human-readable "name" of the driver.
+func (d *driver) Name() string {
+	return driverName
+}
+
+// DriverLimits returns the most restrictive limits of the backends, any of
+// which may store a path.
+func (d *driver) DriverLimits() storagedriver.Limits {
+	var limits storagedriver.Limits
+	for _, name := range d.order {
+		limits = limits.Min(storagedriver.LimitsOf(d.backends[name]))
+	}
+	return limits
+}
+
+// GetContent retrieves the content stored at "path" as a []byte.
+func (d *driver) GetContent(ctx context.Context, path string) ([]byte, error) {
+	content, _, err := first(ctx, d, path, func(backend storagedriver.StorageDriver) ([]byte, error) {
+		return backend.GetContent(ctx, path)
+	})
+	return content, err
+}
+
+// PutContent stores the []byte content at a location designated by "path".
+func (d *driver) PutContent(ctx context.Context, path string, content []byte) error {
+	return d.backends[d.writeBackend(ctx, path)].PutContent(ctx, path, content)
+}
+
+// Reader retrieves an io.ReadCloser for the content stored at "path" with a
+// given byte offset.
+func (d *driver) Reader(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
+	rc, _, err := first(ctx, d, path, func(backend storagedriver.StorageDriver) (io.ReadCloser, error) {
+		return backend.Reader(ctx, path, offset)
+	})
+	return rc, err
+}
+
+// Writer returns a FileWriter which will store the content written to it
+// at the location designated by "path" after the call to Commit.
+func (d *driver) Writer(ctx context.Context, path string, append bool) (storagedriver.FileWriter, error) {
+	return d.backends[d.writeBackend(ctx, path)].Writer(ctx, path, append)
+}
+
+// Stat retrieves the FileInfo for the given path, including the current size
+// in bytes and the creation time.
+func (d *driver) Stat(ctx context.Context, path string) (storagedriver.FileInfo, error) {
+	fi, _, err := first(ctx, d, path, func(backend storagedriver.StorageDriver) (storagedriver.FileInfo, error) {
+		return backend.Stat(ctx, path)
+	})
+	return fi, err
+}
+
+// List returns a list of the objects that are direct descendants of the
+// given path, merged across backends for shared paths.
+func (d *driver) List(ctx context.Context, path string) ([]string, error) {
+	candidates := d.candidates(ctx, path)
+	if len(candidates) == 1 {
+		return d.backends[candidates[0]].List(ctx, path)
+	}
+
+	var (
+		children []string
+		found    bool
+	)
+	for _, name := range candidates {
+		list, err := d.backends[name].List(ctx, path)
+		if err != nil {
+			if isPathNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		found = true
+		children = append(children, list...)
+	}
+	if !found {
+		return nil, storagedriver.PathNotFoundError{Path: path, DriverName: driverName}
+	}
+	slices.Sort(children)
+	return slices.Compact(children), nil
+}
+
+// Move moves an object stored at sourcePath to destPath, removing the
+// original object. Objects moved to another backend are copied then deleted.
+func (d *driver) Move(ctx context.Context, sourcePath string, destPath string) error {
+	_, source, err := first(ctx, d, sourcePath, func(backend storagedriver.StorageDriver) (storagedriver.FileInfo, error) {
+		return backend.Stat(ctx, sourcePath)
+	})
+	if err != nil {
+		return err
+	}
+	dest := d.route(ctx, destPath)
+	if dest == "" {
+		dest = source
+	}
+
+	if source == dest {
+		return d.backends[source].Move(ctx, sourcePath, destPath)
+	}
+	if err := copyPath(ctx, d.backends[source], d.backends[dest], sourcePath, destPath); err != nil {
+		return err
+	}
+	return d.backends[source].Delete(ctx, sourcePath)
+}
+
+// copyPath copies the file sourcePath of source to destPath of dest.
+func copyPath(ctx context.Context, source, dest storagedriver.StorageDriver, sourcePath, destPath string) error {
+	rc, err := source.Reader(ctx, sourcePath, 0)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	fw, err := dest.Writer(ctx, destPath, false)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(fw, rc); err != nil {
+		fw.Cancel(ctx)
+		fw.Close()
+		return err
+	}
+	if err := fw.Commit(ctx); err != nil {
+		fw.Close()
+		return err
+	}
+	return fw.Close()
+}
+
+// Delete recursively deletes all objects stored at "path" and its subpaths,
+// on all backends for shared paths.
+func (d *driver) Delete(ctx context.Context, path string) error {
+	candidates := d.candidates(ctx, path)
+	if len(candidates) == 1 {
+		return d.backends[candidates[0]].Delete(ctx, path)
+	}
+
+	found := false
+	for _, name := range candidates {
+		err := d.backends[name].Delete(ctx, path)
+		if err != nil {
+			if isPathNotFound(err) {
+				continue
+			}
+			return err
+		}
+		found = true
+	}
+	if !found {
+		return storagedriver.PathNotFoundError{Path: path, DriverName: driverName}
+	}
+	return nil
+}
+
+// RedirectURL returns a URL which may be used to retrieve the content stored
+// at the given path, from the backend holding it.
+func (d *driver) RedirectURL(r *http.Request, path string) (string, error) {
+	ctx := r.Context()
+	_, backend, err := first(ctx, d, path, func(backend storagedriver.StorageDriver) (storagedriver.FileInfo, error) {
+		return backend.Stat(ctx, path)
+	})
+	if err != nil {
+		if isPathNotFound(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	return d.backends[backend].RedirectURL(r, path)
+}
+
+// Walk traverses a filesystem defined within driver, starting from the given
+// path, merged across backends for shared paths.
+func (d *driver) Walk(ctx context.Context, path string, f storagedriver.WalkFn, options ...func(*storagedriver.WalkOptions)) error {
+	candidates := d.candidates(ctx, path)
+	if len(candidates) == 1 {
+		return d.backends[candidates[0]].Walk(ctx, path, f, options...)
+	}
+	return storagedriver.WalkFallback(ctx, d, path, f, options...)
+}
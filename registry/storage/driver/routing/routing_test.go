@@ -0,0 +1,254 @@
+package routing
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"path"
+	"slices"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/registry/storage"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/distribution/v3/registry/storage/driver/testsuites"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestRoutingDriverSuite(t *testing.T) {
+	testsuites.Driver(t, func() (storagedriver.StorageDriver, error) {
+		return New(inmemory.New(), map[string]storagedriver.StorageDriver{"other": inmemory.New()}, []Rule{
+			{Repositories: []string{"*"}, Backend: "other"},
+		})
+	}, false)
+}
+
+func blobDataPath(dgst digest.Digest) string {
+	return path.Join(blobsPath, dgst.Algorithm().String(), dgst.Encoded()[:2], dgst.Encoded(), "data")
+}
+
+// exists reports whether backend holds subpath.
+func exists(t *testing.T, backend storagedriver.StorageDriver, subpath string) bool {
+	t.Helper()
+	_, err := backend.Stat(context.Background(), subpath)
+	if err != nil && !isPathNotFound(err) {
+		t.Fatal(err)
+	}
+	return err == nil
+}
+
+// pushBlob uploads content to repository.
+func pushBlob(t *testing.T, repository distribution.Repository, content []byte) v1.Descriptor {
+	t.Helper()
+	ctx := context.Background()
+	wr, err := repository.Blobs(ctx).Create(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wr.ReadFrom(bytes.NewReader(content)); err != nil {
+		t.Fatal(err)
+	}
+	desc, err := wr.Commit(ctx, v1.Descriptor{Digest: digest.FromBytes(content)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return desc
+}
+
+// pushImage pushes an image with a layer of content to repository, tagged
+// latest.
+func pushImage(t *testing.T, repository distribution.Repository, content []byte) (config, layer v1.Descriptor, manifest digest.Digest) {
+	t.Helper()
+	ctx := context.Background()
+	config = pushBlob(t, repository, []byte("{}"))
+	layer = pushBlob(t, repository, content)
+	m, err := ocischema.FromStruct(ocischema.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: v1.MediaTypeImageManifest,
+		Config:    v1.Descriptor{MediaType: v1.MediaTypeImageConfig, Digest: config.Digest, Size: config.Size},
+		Layers:    []v1.Descriptor{{MediaType: v1.MediaTypeImageLayerGzip, Digest: layer.Digest, Size: layer.Size}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ms, err := repository.Manifests(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, err = ms.Put(ctx, m, distribution.WithTag("latest"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config, layer, manifest
+}
+
+func TestRouting(t *testing.T) {
+	ctx := context.Background()
+	defaultBackend, mirrors := inmemory.New(), inmemory.New()
+	driver, err := New(defaultBackend, map[string]storagedriver.StorageDriver{"mirrors": mirrors}, []Rule{
+		{Repositories: []string{"mirrors/*", "mirrors/*/*"}, Backend: "mirrors"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry, err := storage.NewRegistry(ctx, driver, storage.EnableDelete)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repository := func(name string) distribution.Repository {
+		t.Helper()
+		named, _ := reference.WithName(name)
+		repo, err := registry.Repository(ctx, named)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return repo
+	}
+
+	// pushes are placed on the backend of their repository
+	mirrored := repository("mirrors/library/app")
+	_, mirroredLayer, mirroredManifest := pushImage(t, mirrored, []byte("mirrored layer"))
+	prod := repository("prod/app")
+	_, prodLayer, prodManifest := pushImage(t, prod, []byte("prod layer"))
+
+	for _, tc := range []struct {
+		backend, other storagedriver.StorageDriver
+		repository     string
+		blobs          []digest.Digest
+	}{
+		{backend: mirrors, other: defaultBackend, repository: "mirrors/library/app", blobs: []digest.Digest{mirroredLayer.Digest, mirroredManifest}},
+		{backend: defaultBackend, other: mirrors, repository: "prod/app", blobs: []digest.Digest{prodLayer.Digest, prodManifest}},
+	} {
+		repositoryPath := path.Join(repositoriesPath, tc.repository)
+		if !exists(t, tc.backend, repositoryPath) || exists(t, tc.other, repositoryPath) {
+			t.Errorf("repository %s not placed on its backend only", tc.repository)
+		}
+		for _, dgst := range tc.blobs {
+			if !exists(t, tc.backend, blobDataPath(dgst)) || exists(t, tc.other, blobDataPath(dgst)) {
+				t.Errorf("blob %s of %s not placed on its backend only", dgst, tc.repository)
+			}
+		}
+	}
+
+	// the catalog merges the repositories of all backends
+	repos := make([]string, 10)
+	n, err := registry.Repositories(ctx, repos, "")
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	if catalog := repos[:n]; !slices.Equal(catalog, []string{"mirrors/library/app", "prod/app"}) {
+		t.Fatalf("unexpected catalog: %v", catalog)
+	}
+
+	// mounts across backends copy the blob to the backend of the target
+	mirroredRef, _ := reference.WithName("mirrors/library/app")
+	canonical, _ := reference.WithDigest(mirroredRef, mirroredLayer.Digest)
+	promoted := repository("prod/promoted")
+	_, err = promoted.Blobs(ctx).Create(ctx, storage.WithMountFrom(canonical))
+	var mounted distribution.ErrBlobMounted
+	if !errors.As(err, &mounted) {
+		t.Fatalf("expected the blob to be mounted, got %v", err)
+	}
+	if !exists(t, defaultBackend, blobDataPath(mirroredLayer.Digest)) || !exists(t, mirrors, blobDataPath(mirroredLayer.Digest)) {
+		t.Fatal("expected the mounted blob to be copied to the backend of the target")
+	}
+	content, err := promoted.Blobs(ctx).Get(ctx, mirroredLayer.Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "mirrored layer" {
+		t.Fatalf("unexpected content of the mounted blob: %q", content)
+	}
+
+	// and back
+	prodRef, _ := reference.WithName("prod/app")
+	canonical, _ = reference.WithDigest(prodRef, prodLayer.Digest)
+	if _, err := repository("mirrors/library/other").Blobs(ctx).Create(ctx, storage.WithMountFrom(canonical)); !errors.As(err, &mounted) {
+		t.Fatalf("expected the blob to be mounted, got %v", err)
+	}
+	if !exists(t, mirrors, blobDataPath(prodLayer.Digest)) {
+		t.Fatal("expected the mounted blob to be copied to the backend of the target")
+	}
+
+	// mounts within a backend only link the blob
+	sameBackend := repository("prod/same")
+	if _, err := sameBackend.Blobs(ctx).Create(ctx, storage.WithMountFrom(canonical)); !errors.As(err, &mounted) {
+		t.Fatalf("expected the blob to be mounted, got %v", err)
+	}
+
+	// garbage collection sweeps the blobs of every backend
+	unreferenced := pushBlob(t, mirrored, []byte("unreferenced"))
+	if !exists(t, mirrors, blobDataPath(unreferenced.Digest)) {
+		t.Fatal("expected the unreferenced blob to be placed on its backend")
+	}
+	if err := storage.MarkAndSweep(ctx, driver, registry, storage.GCOpts{Quiet: true}); err != nil {
+		t.Fatal(err)
+	}
+	if exists(t, mirrors, blobDataPath(unreferenced.Digest)) {
+		t.Fatal("expected the unreferenced blob to be collected")
+	}
+	for _, dgst := range []digest.Digest{mirroredLayer.Digest, mirroredManifest} {
+		if !exists(t, mirrors, blobDataPath(dgst)) {
+			t.Fatalf("expected the referenced blob %s to be kept", dgst)
+		}
+	}
+	for _, dgst := range []digest.Digest{prodLayer.Digest, prodManifest} {
+		if !exists(t, defaultBackend, blobDataPath(dgst)) {
+			t.Fatalf("expected the referenced blob %s to be kept", dgst)
+		}
+	}
+}
+
+func TestNewInvalid(t *testing.T) {
+	for _, tc := range []struct {
+		backends map[string]storagedriver.StorageDriver
+		rules    []Rule
+	}{
+		{backends: map[string]storagedriver.StorageDriver{DefaultBackend: inmemory.New()}},
+		{rules: []Rule{{Repositories: []string{"mirrors/*"}, Backend: "unknown"}}},
+		{rules: []Rule{{Backend: DefaultBackend}}},
+		{rules: []Rule{{Repositories: []string{"[mirrors"}, Backend: DefaultBackend}}},
+	} {
+		if _, err := New(inmemory.New(), tc.backends, tc.rules); err == nil {
+			t.Errorf("expected an error for backends %v and rules %v", tc.backends, tc.rules)
+		}
+	}
+}
+
+func TestFromParameters(t *testing.T) {
+	driver, err := FromParameters(context.Background(), inmemory.New(), map[string]any{
+		"backends": map[any]any{
+			"mirrors": map[any]any{"driver": "inmemory"},
+		},
+		"rules": []any{
+			map[any]any{"repositories": []any{"mirrors/*"}, "backend": "mirrors"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backend := driver.Backend("mirrors/app"); backend != "mirrors" {
+		t.Fatalf("unexpected backend of mirrors/app: %q", backend)
+	}
+	if backend := driver.Backend("prod/app"); backend != DefaultBackend {
+		t.Fatalf("unexpected backend of prod/app: %q", backend)
+	}
+
+	for _, parameters := range []map[string]any{
+		{"backends": "mirrors"},
+		{"backends": map[any]any{"mirrors": map[any]any{}}},
+		{"backends": map[any]any{"mirrors": map[any]any{"driver": "unknown"}}},
+		{"rules": "mirrors/*"},
+		{"rules": []any{map[any]any{"repositories": "mirrors/*", "backend": DefaultBackend}}},
+	} {
+		if _, err := FromParameters(context.Background(), inmemory.New(), parameters); err == nil {
+			t.Errorf("expected an error for parameters %v", parameters)
+		}
+	}
+}
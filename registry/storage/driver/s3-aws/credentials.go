@@ -0,0 +1,95 @@
+package s3
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/processcreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// The sources of the credentials of the driver, set by the credentialsource
+// parameter.
+const (
+	// CredentialSourceDefault uses the accesskey and secretkey parameters if
+	// set, and the default credential chain of the SDK otherwise: the
+	// environment, the shared credentials file, and the credentials of the
+	// container or of the instance.
+	CredentialSourceDefault = "default"
+	// CredentialSourceStatic uses the accesskey and secretkey parameters.
+	CredentialSourceStatic = "static"
+	// CredentialSourceEnv uses the AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY
+	// and AWS_SESSION_TOKEN environment variables.
+	CredentialSourceEnv = "env"
+	// CredentialSourceSSO uses the credentials of the IAM Identity Center
+	// profile of the shared config file, obtained with "aws sso login".
+	CredentialSourceSSO = "sso"
+	// CredentialSourceProcess uses the credentials printed by the
+	// credentialprocess command, or by the credential_process of the profile
+	// of the shared config file.
+	CredentialSourceProcess = "process"
+)
+
+var credentialSources = []string{
+	CredentialSourceDefault,
+	CredentialSourceStatic,
+	CredentialSourceEnv,
+	CredentialSourceSSO,
+	CredentialSourceProcess,
+}
+
+// newSession returns the session of the driver, configured by awsConfig,
+// with the credentials of the credential source of params.
+func newSession(awsConfig *aws.Config, params DriverParameters) (*session.Session, error) {
+	hasKeys := params.AccessKey != "" && params.SecretKey != ""
+	if params.Profile != "" && params.CredentialSource != CredentialSourceSSO && params.CredentialSource != CredentialSourceProcess {
+		return nil, fmt.Errorf("the profile parameter can only be used with credentialsource %s or %s", CredentialSourceSSO, CredentialSourceProcess)
+	}
+	if params.CredentialProcess != "" && params.CredentialSource != CredentialSourceProcess {
+		return nil, fmt.Errorf("the credentialprocess parameter can only be used with credentialsource %s", CredentialSourceProcess)
+	}
+
+	switch params.CredentialSource {
+	case "", CredentialSourceDefault:
+		if hasKeys {
+			awsConfig.WithCredentials(credentials.NewStaticCredentials(params.AccessKey, params.SecretKey, params.SessionToken))
+		}
+		return session.NewSession(awsConfig)
+	case CredentialSourceStatic:
+		if !hasKeys {
+			return nil, fmt.Errorf("credentialsource %s requires the accesskey and secretkey parameters", params.CredentialSource)
+		}
+		awsConfig.WithCredentials(credentials.NewStaticCredentials(params.AccessKey, params.SecretKey, params.SessionToken))
+		return session.NewSession(awsConfig)
+	}
+
+	if params.AccessKey != "" || params.SecretKey != "" {
+		return nil, fmt.Errorf("the accesskey and secretkey parameters cannot be used with credentialsource %s", params.CredentialSource)
+	}
+	switch params.CredentialSource {
+	case CredentialSourceEnv:
+		creds := credentials.NewEnvCredentials()
+		if _, err := creds.Get(); err != nil {
+			return nil, fmt.Errorf("credentialsource %s: %v", params.CredentialSource, err)
+		}
+		awsConfig.WithCredentials(creds)
+		return session.NewSession(awsConfig)
+	case CredentialSourceProcess:
+		if params.CredentialProcess != "" {
+			awsConfig.WithCredentials(processcreds.NewCredentials(params.CredentialProcess))
+			return session.NewSession(awsConfig)
+		}
+		fallthrough
+	case CredentialSourceSSO:
+		// the shared config file is only loaded on request, and holds the
+		// settings of the sso and process providers
+		return session.NewSessionWithOptions(session.Options{
+			Config:            *awsConfig,
+			Profile:           params.Profile,
+			SharedConfigState: session.SharedConfigEnable,
+		})
+	default:
+		return nil, fmt.Errorf("the credentialsource parameter must be one of %v, %v invalid", credentialSources, params.CredentialSource)
+	}
+}
@@ -31,10 +31,8 @@ import (
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
-	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/endpoints"
 	"github.com/aws/aws-sdk-go/aws/request"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 
 	"github.com/distribution/distribution/v3/internal/dcontext"
@@ -65,6 +63,9 @@ const (
 	// above which multipart copy will be used. (PUT Object - Copy is used
 	// for objects at or below this size.)  Empirically, 32 MB is optimal.
 	defaultMultipartCopyThresholdSize = 32 * 1024 * 1024
+
+	// maxKeyLength is the maximum length, in bytes, of an S3 object key.
+	maxKeyLength = 1024
 )
 
 // listMax is the largest amount of objects you can request from S3 in a list call
@@ -109,6 +110,7 @@ type DriverParameters struct {
 	MultipartCopyChunkSize      int64
 	MultipartCopyMaxConcurrency int64
 	MultipartCopyThresholdSize  int64
+	ListPageSize                int64
 	RootDirectory               string
 	StorageClass                string
 	UserAgent                   string
@@ -119,6 +121,9 @@ type DriverParameters struct {
 	UseFIPSEndpoint             bool
 	LogLevel                    aws.LogLevelType
 	RedirectEndpoint            string
+	CredentialSource            string
+	Profile                     string
+	CredentialProcess           string
 }
 
 func init() {
@@ -164,6 +169,7 @@ type driver struct {
 	MultipartCopyChunkSize      int64
 	MultipartCopyMaxConcurrency int64
 	MultipartCopyThresholdSize  int64
+	ListPageSize                int64
 	RootDirectory               string
 	StorageClass                string
 	ObjectACL                   string
@@ -274,6 +280,11 @@ func FromParameters(ctx context.Context, parameters map[string]any) (*Driver, er
 		return nil, err
 	}
 
+	listPageSize, err := getParameterAsInteger[int64](parameters, "listpagesize", listMax, 1, listMax)
+	if err != nil {
+		return nil, err
+	}
+
 	rootDirectory := parameters["rootdirectory"]
 	if rootDirectory == nil {
 		rootDirectory = ""
@@ -343,6 +354,25 @@ func FromParameters(ctx context.Context, parameters map[string]any) (*Driver, er
 		redirectEndpoint = ""
 	}
 
+	credentialSource := CredentialSourceDefault
+	if credentialSourceParam := parameters["credentialsource"]; credentialSourceParam != nil {
+		credentialSourceString, ok := credentialSourceParam.(string)
+		if !ok || !slices.Contains(credentialSources, credentialSourceString) {
+			return nil, fmt.Errorf("the credentialsource parameter must be one of %v, %v invalid", credentialSources, credentialSourceParam)
+		}
+		credentialSource = credentialSourceString
+	}
+
+	profile := parameters["profile"]
+	if profile == nil {
+		profile = ""
+	}
+
+	credentialProcess := parameters["credentialprocess"]
+	if credentialProcess == nil {
+		credentialProcess = ""
+	}
+
 	params := DriverParameters{
 		AccessKey:                   fmt.Sprint(accessKey),
 		SecretKey:                   fmt.Sprint(secretKey),
@@ -359,6 +389,7 @@ func FromParameters(ctx context.Context, parameters map[string]any) (*Driver, er
 		MultipartCopyChunkSize:      multipartCopyChunkSize,
 		MultipartCopyMaxConcurrency: multipartCopyMaxConcurrency,
 		MultipartCopyThresholdSize:  multipartCopyThresholdSize,
+		ListPageSize:                listPageSize,
 		RootDirectory:               fmt.Sprint(rootDirectory),
 		StorageClass:                storageClass,
 		UserAgent:                   fmt.Sprint(userAgent),
@@ -369,6 +400,9 @@ func FromParameters(ctx context.Context, parameters map[string]any) (*Driver, er
 		UseFIPSEndpoint:             useFIPSEndpointBool,
 		LogLevel:                    getS3LogLevelFromParam(parameters["loglevel"]),
 		RedirectEndpoint:            fmt.Sprint(redirectEndpoint),
+		CredentialSource:            credentialSource,
+		Profile:                     fmt.Sprint(profile),
+		CredentialProcess:           fmt.Sprint(credentialProcess),
 	}
 
 	return New(ctx, params)
@@ -466,15 +500,6 @@ func New(ctx context.Context, params DriverParameters) (*Driver, error) {
 
 	awsConfig := aws.NewConfig().WithLogLevel(params.LogLevel)
 
-	if params.AccessKey != "" && params.SecretKey != "" {
-		creds := credentials.NewStaticCredentials(
-			params.AccessKey,
-			params.SecretKey,
-			params.SessionToken,
-		)
-		awsConfig.WithCredentials(creds)
-	}
-
 	if params.RegionEndpoint != "" {
 		awsConfig.WithEndpoint(params.RegionEndpoint)
 	}
@@ -498,7 +523,7 @@ func New(ctx context.Context, params DriverParameters) (*Driver, error) {
 		})
 	}
 
-	sess, err := session.NewSession(awsConfig)
+	sess, err := newSession(awsConfig, params)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create new session with aws config: %v", err)
 	}
@@ -538,6 +563,7 @@ func New(ctx context.Context, params DriverParameters) (*Driver, error) {
 		MultipartCopyChunkSize:      params.MultipartCopyChunkSize,
 		MultipartCopyMaxConcurrency: params.MultipartCopyMaxConcurrency,
 		MultipartCopyThresholdSize:  params.MultipartCopyThresholdSize,
+		ListPageSize:                params.ListPageSize,
 		RootDirectory:               params.RootDirectory,
 		StorageClass:                params.StorageClass,
 		ObjectACL:                   params.ObjectACL,
@@ -545,6 +571,9 @@ func New(ctx context.Context, params DriverParameters) (*Driver, error) {
 			New: func() any { return &bytes.Buffer{} },
 		},
 	}
+	if d.ListPageSize == 0 {
+		d.ListPageSize = listMax
+	}
 
 	if params.RedirectEndpoint != "" {
 		u, err := url.Parse(params.RedirectEndpoint)
@@ -584,6 +613,12 @@ func (d *driver) Name() string {
 	return driverName
 }
 
+// DriverLimits returns the S3 key length limit, shortened by the root
+// directory.
+func (d *driver) DriverLimits() storagedriver.Limits {
+	return storagedriver.Limits{MaxPathLength: maxKeyLength - (len(d.s3Path("/a")) - len("/a"))}
+}
+
 // GetContent retrieves the content stored at "path" as a []byte.
 func (d *driver) GetContent(ctx context.Context, path string) ([]byte, error) {
 	reader, err := d.Reader(ctx, path, 0)
@@ -618,6 +653,15 @@ func (d *driver) Reader(ctx context.Context, path string, offset int64) (io.Read
 	})
 	if err != nil {
 		if s3Err, ok := err.(awserr.Error); ok && s3Err.Code() == "InvalidRange" {
+			// S3 reports reads starting at the end of the object as an
+			// invalid range too, so look up the size to tell the two apart.
+			fi, err := d.Stat(ctx, path)
+			if err != nil {
+				return nil, err
+			}
+			if offset > fi.Size() {
+				return nil, storagedriver.InvalidOffsetError{Path: path, Offset: offset}
+			}
 			return io.NopCloser(bytes.NewReader(nil)), nil
 		}
 
@@ -807,6 +851,31 @@ func (d *driver) Stat(ctx context.Context, path string) (storagedriver.FileInfo,
 
 // List returns a list of the objects that are direct descendants of the given path.
 func (d *driver) List(ctx context.Context, opath string) ([]string, error) {
+	files := []string{}
+	directories := []string{}
+	err := d.listPages(ctx, opath, func(pageFiles, pageDirectories []string) error {
+		files = append(files, pageFiles...)
+		directories = append(directories, pageDirectories...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return append(files, directories...), nil
+}
+
+// ListPages calls f with each page of at most the list page size of the
+// objects that are direct descendants of the given path, as S3 returns them,
+// rather than assembling them all.
+func (d *driver) ListPages(ctx context.Context, opath string, f func(paths []string) error) error {
+	return d.listPages(ctx, opath, func(files, directories []string) error {
+		return f(append(files, directories...))
+	})
+}
+
+// listPages calls f with the files and the directories of each page of the
+// objects that are direct descendants of the given path.
+func (d *driver) listPages(ctx context.Context, opath string, f func(files, directories []string) error) error {
 	path := opath
 	if path != "/" && path[len(path)-1] != '/' {
 		path = path + "/"
@@ -820,54 +889,49 @@ func (d *driver) List(ctx context.Context, opath string) ([]string, error) {
 		prefix = "/"
 	}
 
-	resp, err := d.S3.ListObjectsV2WithContext(ctx, &s3.ListObjectsV2Input{
+	listObjectsInput := &s3.ListObjectsV2Input{
 		Bucket:    aws.String(d.Bucket),
 		Prefix:    aws.String(d.s3Path(path)),
 		Delimiter: aws.String("/"),
-		MaxKeys:   aws.Int64(listMax),
-	})
-	if err != nil {
-		return nil, parseError(opath, err)
+		MaxKeys:   aws.Int64(d.ListPageSize),
 	}
 
-	files := []string{}
-	directories := []string{}
-
-	for {
+	var (
+		listed  bool
+		pageErr error
+	)
+	err := d.S3.ListObjectsV2PagesWithContext(ctx, listObjectsInput, func(resp *s3.ListObjectsV2Output, lastPage bool) bool {
+		files := make([]string, 0, len(resp.Contents))
 		for _, key := range resp.Contents {
 			files = append(files, strings.Replace(*key.Key, d.s3Path(""), prefix, 1))
 		}
 
+		directories := make([]string, 0, len(resp.CommonPrefixes))
 		for _, commonPrefix := range resp.CommonPrefixes {
 			commonPrefix := *commonPrefix.Prefix
 			directories = append(directories, strings.Replace(commonPrefix[0:len(commonPrefix)-1], d.s3Path(""), prefix, 1))
 		}
 
-		if resp.IsTruncated == nil || !*resp.IsTruncated {
-			break
-		}
-
-		resp, err = d.S3.ListObjectsV2WithContext(ctx, &s3.ListObjectsV2Input{
-			Bucket:            aws.String(d.Bucket),
-			Prefix:            aws.String(d.s3Path(path)),
-			Delimiter:         aws.String("/"),
-			MaxKeys:           aws.Int64(listMax),
-			ContinuationToken: resp.NextContinuationToken,
-		})
-		if err != nil {
-			return nil, err
-		}
-	}
-
-	if opath != "/" {
 		if len(files) == 0 && len(directories) == 0 {
-			// Treat empty response as missing directory, since we don't actually
-			// have directories in s3.
-			return nil, storagedriver.PathNotFoundError{Path: opath}
+			return !lastPage
 		}
+		listed = true
+		pageErr = f(files, directories)
+		return pageErr == nil
+	})
+	if pageErr != nil {
+		return pageErr
+	}
+	if err != nil {
+		return parseError(opath, err)
 	}
 
-	return append(files, directories...), nil
+	if opath != "/" && !listed {
+		// Treat empty response as missing directory, since we don't actually
+		// have directories in s3.
+		return storagedriver.PathNotFoundError{Path: opath}
+	}
+	return nil
 }
 
 // Move moves an object stored at sourcePath to destPath, removing the original
@@ -1122,7 +1186,7 @@ func (d *driver) doWalk(parentCtx context.Context, objectCount *int64, from, sta
 	listObjectsInput := &s3.ListObjectsV2Input{
 		Bucket:     aws.String(d.Bucket),
 		Prefix:     aws.String(d.s3Path(path)),
-		MaxKeys:    aws.Int64(listMax),
+		MaxKeys:    aws.Int64(d.ListPageSize),
 		StartAfter: aws.String(d.s3Path(startAfter)),
 	}
 
@@ -6,9 +6,13 @@ import (
 	"crypto/rand"
 	"errors"
 	"fmt"
+	"maps"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
 	"path"
+	"path/filepath"
 	"reflect"
 	"slices"
 	"sort"
@@ -17,6 +21,8 @@ import (
 	"testing"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials/ssocreds"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/distribution/distribution/v3/internal/dcontext"
 	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
@@ -1176,3 +1182,185 @@ func compareWalked(t *testing.T, expected, walked []string) {
 		}
 	}
 }
+
+func TestCredentialSource(t *testing.T) {
+	dir := t.TempDir()
+	process := filepath.Join(dir, "credentials.sh")
+	if err := os.WriteFile(process, []byte(`#!/bin/sh
+echo '{"Version": 1, "AccessKeyId": "PROFILE", "SecretAccessKey": "secret"}'
+`), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	config := filepath.Join(dir, "config")
+	if err := os.WriteFile(config, []byte(`[profile dev]
+sso_start_url = https://example.awsapps.com/start
+sso_region = us-east-1
+sso_account_id = 123456789012
+sso_role_name = registry
+
+[profile tool]
+credential_process = `+process+`
+`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("HOME", dir)
+	t.Setenv("AWS_CONFIG_FILE", config)
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+	t.Setenv("AWS_ACCESS_KEY_ID", "ENV")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_PROFILE", "")
+
+	for _, tc := range []struct {
+		name       string
+		parameters map[string]any
+		// accessKey is the access key id of the credentials, or the code of
+		// the error of the credentials if errCode is set
+		accessKey string
+		errCode   string
+		invalid   bool
+	}{
+		{name: "default static", parameters: map[string]any{"accesskey": "STATIC", "secretkey": "secret"}, accessKey: "STATIC"},
+		{name: "default chain", parameters: map[string]any{}, accessKey: "ENV"},
+		{name: "static", parameters: map[string]any{"credentialsource": "static", "accesskey": "STATIC", "secretkey": "secret"}, accessKey: "STATIC"},
+		{name: "static without keys", parameters: map[string]any{"credentialsource": "static"}, invalid: true},
+		{name: "env", parameters: map[string]any{"credentialsource": "env"}, accessKey: "ENV"},
+		{name: "env with keys", parameters: map[string]any{"credentialsource": "env", "accesskey": "STATIC", "secretkey": "secret"}, invalid: true},
+		{name: "process command", parameters: map[string]any{
+			"credentialsource":  "process",
+			"credentialprocess": `echo '{"Version": 1, "AccessKeyId": "PROCESS", "SecretAccessKey": "secret"}'`,
+		}, accessKey: "PROCESS"},
+		{name: "process profile", parameters: map[string]any{"credentialsource": "process", "profile": "tool"}, accessKey: "PROFILE"},
+		// without a cached token from aws sso login, the sso provider fails
+		// before reaching the network
+		{name: "sso", parameters: map[string]any{"credentialsource": "sso", "profile": "dev"}, errCode: ssocreds.ErrCodeSSOProviderInvalidToken},
+		{name: "profile without sso", parameters: map[string]any{"profile": "dev"}, invalid: true},
+		{name: "unknown", parameters: map[string]any{"credentialsource": "vault"}, invalid: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			parameters := map[string]any{"region": "us-east-1", "bucket": "registry"}
+			maps.Copy(parameters, tc.parameters)
+			drv, err := FromParameters(context.Background(), parameters)
+			if tc.invalid {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to create driver: %v", err)
+			}
+
+			creds, err := drv.baseEmbed.Base.StorageDriver.(*driver).S3.Client.Config.Credentials.Get()
+			if tc.errCode != "" {
+				var awsErr awserr.Error
+				if !errors.As(err, &awsErr) || awsErr.Code() != tc.errCode {
+					t.Fatalf("expected a %s error, got %v", tc.errCode, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if creds.AccessKeyID != tc.accessKey {
+				t.Fatalf("unexpected access key %q", creds.AccessKeyID)
+			}
+		})
+	}
+}
+
+// TestListPages ensures that listings are streamed page by page from a mock
+// S3 endpoint, with the configured page size, rather than assembled first.
+func TestListPages(t *testing.T) {
+	const keys = 5
+
+	var requests []url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		requests = append(requests, query)
+		pageSize, _ := strconv.Atoi(query.Get("max-keys"))
+		start, _ := strconv.Atoi(query.Get("continuation-token"))
+		end := min(start+pageSize, keys)
+
+		var contents strings.Builder
+		for i := start; i < end; i++ {
+			fmt.Fprintf(&contents, "<Contents><Key>dir/%d</Key><Size>1</Size><LastModified>2009-10-12T17:50:30.000Z</LastModified></Contents>", i)
+		}
+		next := ""
+		if end < keys {
+			next = fmt.Sprintf("<NextContinuationToken>%d</NextContinuationToken>", end)
+		}
+		w.Header().Set("Content-Type", "application/xml")
+		fmt.Fprintf(w, `<ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Name>registry</Name><Prefix>%s</Prefix><KeyCount>%d</KeyCount><MaxKeys>%d</MaxKeys><IsTruncated>%t</IsTruncated>%s%s</ListBucketResult>`,
+			query.Get("prefix"), end-start, pageSize, end < keys, next, contents.String())
+	}))
+	defer server.Close()
+
+	drv, err := FromParameters(context.Background(), map[string]any{
+		"region":         "us-east-1",
+		"bucket":         "registry",
+		"accesskey":      "key",
+		"secretkey":      "secret",
+		"regionendpoint": server.URL,
+		"forcepathstyle": true,
+		"listpagesize":   2,
+	})
+	if err != nil {
+		t.Fatalf("failed to create driver: %v", err)
+	}
+	ctx := context.Background()
+
+	var pages [][]string
+	err = storagedriver.ListPages(ctx, drv, "/dir", func(paths []string) error {
+		// the next page is only requested once this one is consumed
+		if len(requests) != len(pages)+1 {
+			t.Errorf("page %d handled after %d requests", len(pages), len(requests))
+		}
+		pages = append(pages, paths)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error listing: %v", err)
+	}
+	if fmt.Sprint(pages) != "[[/dir/0 /dir/1] [/dir/2 /dir/3] [/dir/4]]" {
+		t.Fatalf("unexpected pages: %v", pages)
+	}
+	for _, query := range requests {
+		if query.Get("max-keys") != "2" || query.Get("delimiter") != "/" {
+			t.Errorf("unexpected list request: %v", query)
+		}
+	}
+
+	// an error of the callback stops the listing
+	requests = nil
+	errStop := errors.New("stop")
+	err = storagedriver.ListPages(ctx, drv, "/dir", func([]string) error {
+		return errStop
+	})
+	if err == nil || !strings.Contains(err.Error(), errStop.Error()) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(requests) != 1 {
+		t.Fatalf("listing continued after an error: %d requests", len(requests))
+	}
+
+	list, err := drv.List(ctx, "/dir")
+	if err != nil || len(list) != keys {
+		t.Fatalf("unexpected list: %v, %v", list, err)
+	}
+
+	requests = nil
+	var walked int
+	if err := drv.Walk(ctx, "/dir", func(storagedriver.FileInfo) error {
+		walked++
+		return nil
+	}); err != nil {
+		t.Fatalf("unexpected error walking: %v", err)
+	}
+	if walked != keys || len(requests) != 3 || requests[0].Get("max-keys") != "2" {
+		t.Fatalf("unexpected walk: %d files in %d requests", walked, len(requests))
+	}
+
+	if _, err := FromParameters(ctx, map[string]any{"region": "us-east-1", "bucket": "registry", "listpagesize": 1001}); err == nil {
+		t.Fatal("expected an error for an invalid list page size")
+	}
+}
@@ -179,17 +179,41 @@ func (err InvalidOffsetError) Error() string {
 	return fmt.Sprintf("%s: invalid offset: %d for path: %s", err.DriverName, err.Offset, err.Path)
 }
 
+// InsufficientStorageError is returned when a write is rejected because the
+// storage backing the driver is running out of free space.
+type InsufficientStorageError struct {
+	Path       string
+	DriverName string
+	// Detail describes the free space remaining.
+	Detail string
+}
+
+func (err InsufficientStorageError) Error() string {
+	msg := fmt.Sprintf("%s: insufficient storage to write path: %s", err.DriverName, err.Path)
+	if err.Detail != "" {
+		msg += " (" + err.Detail + ")"
+	}
+	return msg
+}
+
 // Error is a catch-all error type which captures an error string and
 // the driver type on which it occurred.
 type Error struct {
 	DriverName string
-	Detail     error
+	// Action is the storage driver method which failed, such as Stat.
+	Action string
+	Detail error
 }
 
 func (err Error) Error() string {
 	return fmt.Sprintf("%s: %s", err.DriverName, err.Detail)
 }
 
+// Unwrap returns the error returned by the storage driver.
+func (err Error) Unwrap() error {
+	return err.Detail
+}
+
 func (err Error) MarshalJSON() ([]byte, error) {
 	return json.Marshal(struct {
 		DriverName string `json:"driver"`
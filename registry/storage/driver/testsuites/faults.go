@@ -0,0 +1,307 @@
+package testsuites
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+)
+
+// Operation names a storage driver operation faults may be injected in.
+type Operation string
+
+const (
+	OpGetContent  Operation = "GetContent"
+	OpPutContent  Operation = "PutContent"
+	OpReader      Operation = "Reader"
+	OpWriter      Operation = "Writer"
+	OpStat        Operation = "Stat"
+	OpList        Operation = "List"
+	OpMove        Operation = "Move"
+	OpDelete      Operation = "Delete"
+	OpRedirectURL Operation = "RedirectURL"
+	OpWalk        Operation = "Walk"
+
+	// OpRead is a Read of a reader returned by Reader.
+	OpRead Operation = "Read"
+	// OpWrite is a Write of a writer returned by Writer.
+	OpWrite Operation = "Write"
+	// OpCommit is a Commit of a writer returned by Writer.
+	OpCommit Operation = "Commit"
+	// OpClose is a Close of a writer returned by Writer.
+	OpClose Operation = "Close"
+)
+
+// Fault describes a fault injected in the calls of an operation.
+type Fault struct {
+	// Op is the operation the fault is injected in.
+	Op Operation
+
+	// Call is the number of the call of Op the fault is injected in,
+	// starting from 1 and counted from the injection of the fault. Zero
+	// injects the fault in every call.
+	Call int
+
+	// Delay delays the call. The call fails with the error of its context
+	// if the context is done first.
+	Delay time.Duration
+
+	// Err, if set, is returned by the call.
+	Err error
+
+	// After performs the call before returning Err, as when a request
+	// times out after the backend completed it. By default, Err is returned
+	// without performing the call.
+	After bool
+}
+
+// FaultInjector is a storage driver failing or delaying the operations of
+// the driver it wraps, on a programmable schedule. It is meant to exercise
+// the handling of partial failures of storage backends.
+//
+// Walk is implemented with storagedriver.WalkFallback on top of the
+// FaultInjector, so that the faults injected in List and Stat apply to walks.
+type FaultInjector struct {
+	storagedriver.StorageDriver
+
+	mu     sync.Mutex
+	faults []scheduledFault
+	calls  map[Operation]int
+}
+
+type scheduledFault struct {
+	Fault
+	// call is the number of the call of the operation the fault is injected
+	// in, counted since the driver was created.
+	call int
+}
+
+var _ storagedriver.StorageDriver = &FaultInjector{}
+
+// NewFaultInjector returns a FaultInjector wrapping driver, with faults
+// injected.
+func NewFaultInjector(driver storagedriver.StorageDriver, faults ...Fault) *FaultInjector {
+	d := &FaultInjector{
+		StorageDriver: driver,
+		calls:         make(map[Operation]int),
+	}
+	d.Inject(faults...)
+	return d
+}
+
+// Inject schedules faults, in addition to those already scheduled.
+func (d *FaultInjector) Inject(faults ...Fault) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, fault := range faults {
+		call := 0
+		if fault.Call > 0 {
+			call = d.calls[fault.Op] + fault.Call
+		}
+		d.faults = append(d.faults, scheduledFault{Fault: fault, call: call})
+	}
+}
+
+// Clear removes all the scheduled faults.
+func (d *FaultInjector) Clear() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.faults = nil
+}
+
+// Calls returns the number of calls of op.
+func (d *FaultInjector) Calls(op Operation) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.calls[op]
+}
+
+// call records a call of op and performs it with fn, injecting the faults
+// scheduled for the call.
+func (d *FaultInjector) call(ctx context.Context, op Operation, fn func() error) error {
+	d.mu.Lock()
+	d.calls[op]++
+	n := d.calls[op]
+	var delay time.Duration
+	var injected *Fault
+	for i := range d.faults {
+		fault := &d.faults[i]
+		if fault.Op != op || (fault.call != 0 && fault.call != n) {
+			continue
+		}
+		delay += fault.Delay
+		if fault.Err != nil && injected == nil {
+			injected = &fault.Fault
+		}
+	}
+	d.mu.Unlock()
+
+	if delay > 0 {
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+
+	if injected == nil {
+		return fn()
+	}
+	if injected.After {
+		_ = fn()
+	}
+	return injected.Err
+}
+
+func (d *FaultInjector) GetContent(ctx context.Context, path string) ([]byte, error) {
+	var content []byte
+	err := d.call(ctx, OpGetContent, func() (err error) {
+		content, err = d.StorageDriver.GetContent(ctx, path)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return content, nil
+}
+
+func (d *FaultInjector) PutContent(ctx context.Context, path string, content []byte) error {
+	return d.call(ctx, OpPutContent, func() error {
+		return d.StorageDriver.PutContent(ctx, path, content)
+	})
+}
+
+func (d *FaultInjector) Reader(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
+	var rc io.ReadCloser
+	err := d.call(ctx, OpReader, func() (err error) {
+		rc, err = d.StorageDriver.Reader(ctx, path, offset)
+		return err
+	})
+	if err != nil {
+		if rc != nil {
+			rc.Close()
+		}
+		return nil, err
+	}
+	return &faultyReader{ReadCloser: rc, ctx: ctx, driver: d}, nil
+}
+
+func (d *FaultInjector) Writer(ctx context.Context, path string, append bool) (storagedriver.FileWriter, error) {
+	var fw storagedriver.FileWriter
+	err := d.call(ctx, OpWriter, func() (err error) {
+		fw, err = d.StorageDriver.Writer(ctx, path, append)
+		return err
+	})
+	if err != nil {
+		if fw != nil {
+			fw.Close()
+		}
+		return nil, err
+	}
+	return &faultyWriter{FileWriter: fw, ctx: ctx, driver: d}, nil
+}
+
+func (d *FaultInjector) Stat(ctx context.Context, path string) (storagedriver.FileInfo, error) {
+	var fi storagedriver.FileInfo
+	err := d.call(ctx, OpStat, func() (err error) {
+		fi, err = d.StorageDriver.Stat(ctx, path)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return fi, nil
+}
+
+func (d *FaultInjector) List(ctx context.Context, path string) ([]string, error) {
+	var entries []string
+	err := d.call(ctx, OpList, func() (err error) {
+		entries, err = d.StorageDriver.List(ctx, path)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (d *FaultInjector) Move(ctx context.Context, sourcePath string, destPath string) error {
+	return d.call(ctx, OpMove, func() error {
+		return d.StorageDriver.Move(ctx, sourcePath, destPath)
+	})
+}
+
+func (d *FaultInjector) Delete(ctx context.Context, path string) error {
+	return d.call(ctx, OpDelete, func() error {
+		return d.StorageDriver.Delete(ctx, path)
+	})
+}
+
+func (d *FaultInjector) RedirectURL(r *http.Request, path string) (string, error) {
+	var redirectURL string
+	err := d.call(r.Context(), OpRedirectURL, func() (err error) {
+		redirectURL, err = d.StorageDriver.RedirectURL(r, path)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return redirectURL, nil
+}
+
+func (d *FaultInjector) Walk(ctx context.Context, path string, f storagedriver.WalkFn, options ...func(*storagedriver.WalkOptions)) error {
+	return d.call(ctx, OpWalk, func() error {
+		return storagedriver.WalkFallback(ctx, d, path, f, options...)
+	})
+}
+
+// faultyReader injects the faults scheduled for OpRead.
+type faultyReader struct {
+	io.ReadCloser
+	ctx    context.Context
+	driver *FaultInjector
+}
+
+func (r *faultyReader) Read(p []byte) (int, error) {
+	var n int
+	err := r.driver.call(r.ctx, OpRead, func() (err error) {
+		n, err = r.ReadCloser.Read(p)
+		return err
+	})
+	return n, err
+}
+
+// faultyWriter injects the faults scheduled for OpWrite, OpCommit and
+// OpClose.
+type faultyWriter struct {
+	storagedriver.FileWriter
+	ctx    context.Context
+	driver *FaultInjector
+}
+
+func (w *faultyWriter) Write(p []byte) (int, error) {
+	var n int
+	err := w.driver.call(w.ctx, OpWrite, func() (err error) {
+		n, err = w.FileWriter.Write(p)
+		return err
+	})
+	return n, err
+}
+
+func (w *faultyWriter) Commit(ctx context.Context) error {
+	return w.driver.call(ctx, OpCommit, func() error {
+		return w.FileWriter.Commit(ctx)
+	})
+}
+
+func (w *faultyWriter) Close() error {
+	return w.driver.call(w.ctx, OpClose, func() error {
+		return w.FileWriter.Close()
+	})
+}
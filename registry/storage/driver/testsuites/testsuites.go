@@ -6,6 +6,7 @@ import (
 	crand "crypto/rand"
 	"crypto/sha256"
 	"crypto/tls"
+	"errors"
 	"io"
 	"math/rand"
 	"net/http"
@@ -381,6 +382,44 @@ func (suite *DriverSuite) TestReaderWithOffset() {
 	suite.Require().ErrorIs(err, io.EOF)
 }
 
+// TestReaderOffsetAtSize tests that reading from an offset equal to the size
+// of the content returns a reader which immediately reports io.EOF.
+func (suite *DriverSuite) TestReaderOffsetAtSize() {
+	filename := randomPath(32)
+	defer suite.deletePath(firstPart(filename))
+
+	contents := randomContents(32)
+	err := suite.StorageDriver.PutContent(suite.ctx, filename, contents)
+	suite.Require().NoError(err)
+
+	reader, err := suite.StorageDriver.Reader(suite.ctx, filename, int64(len(contents)))
+	suite.Require().NoError(err)
+	defer reader.Close()
+
+	readContents, err := io.ReadAll(reader)
+	suite.Require().NoError(err)
+	suite.Require().Empty(readContents)
+}
+
+// TestReaderOffsetBeyondSize tests that reading from an offset past the end
+// of the content returns an InvalidOffsetError.
+func (suite *DriverSuite) TestReaderOffsetBeyondSize() {
+	filename := randomPath(32)
+	defer suite.deletePath(firstPart(filename))
+
+	contents := randomContents(32)
+	err := suite.StorageDriver.PutContent(suite.ctx, filename, contents)
+	suite.Require().NoError(err)
+
+	offset := int64(len(contents)) + 1
+	reader, err := suite.StorageDriver.Reader(suite.ctx, filename, offset)
+	suite.Require().IsType(storagedriver.InvalidOffsetError{}, err)
+	suite.Require().Equal(offset, err.(storagedriver.InvalidOffsetError).Offset)
+	suite.Require().Equal(filename, err.(storagedriver.InvalidOffsetError).Path)
+	suite.Require().Nil(reader)
+	suite.Require().Contains(err.Error(), suite.Name())
+}
+
 // TestContinueStreamAppendLarge tests that a stream write can be appended to without
 // corrupting the data with a large chunk size.
 func (suite *DriverSuite) TestContinueStreamAppendLarge() {
@@ -1040,6 +1079,177 @@ func (suite *DriverSuite) TestConcurrentFileStreams() {
 	wg.Wait()
 }
 
+// errInjected is the error of the faults injected by the suite.
+var errInjected = errors.New("injected fault")
+
+// TestFaultWrite checks that a writer failing mid-upload can be resumed
+// without corrupting the content.
+func (suite *DriverSuite) TestFaultWrite() {
+	filename := randomPath(32)
+	defer suite.deletePath(firstPart(filename))
+
+	chunkSize := int64(32)
+	contents := randomContents(3 * chunkSize)
+	d := NewFaultInjector(suite.StorageDriver, Fault{Op: OpWrite, Call: 2, Err: errInjected})
+
+	writer, err := d.Writer(suite.ctx, filename, false)
+	suite.Require().NoError(err)
+	_, err = writer.Write(contents[:chunkSize])
+	suite.Require().NoError(err)
+	_, err = writer.Write(contents[chunkSize : 2*chunkSize])
+	suite.Require().ErrorIs(err, errInjected)
+	suite.Require().Equal(chunkSize, writer.Size())
+	suite.Require().NoError(writer.Close())
+
+	writer, err = d.Writer(suite.ctx, filename, true)
+	suite.Require().NoError(err)
+	suite.Require().Equal(chunkSize, writer.Size())
+	_, err = writer.Write(contents[chunkSize:])
+	suite.Require().NoError(err)
+	suite.Require().NoError(writer.Commit(suite.ctx))
+	suite.Require().NoError(writer.Close())
+
+	received, err := d.GetContent(suite.ctx, filename)
+	suite.Require().NoError(err)
+	suite.Require().Equal(contents, received)
+}
+
+// TestFaultCommit checks that a failed commit can be retried, and that a
+// commit completed by the backend despite failing leaves the content intact.
+func (suite *DriverSuite) TestFaultCommit() {
+	for _, after := range []bool{false, true} {
+		filename := randomPath(32)
+		defer suite.deletePath(firstPart(filename))
+
+		contents := randomContents(64)
+		d := NewFaultInjector(suite.StorageDriver, Fault{Op: OpCommit, Call: 1, Err: errInjected, After: after})
+
+		writer, err := d.Writer(suite.ctx, filename, false)
+		suite.Require().NoError(err)
+		_, err = writer.Write(contents)
+		suite.Require().NoError(err)
+		suite.Require().ErrorIs(writer.Commit(suite.ctx), errInjected)
+		suite.Require().NoError(writer.Close())
+
+		if !after {
+			writer, err = d.Writer(suite.ctx, filename, true)
+			suite.Require().NoError(err)
+			suite.Require().Equal(int64(len(contents)), writer.Size())
+			suite.Require().NoError(writer.Commit(suite.ctx))
+			suite.Require().NoError(writer.Close())
+		}
+
+		received, err := d.GetContent(suite.ctx, filename)
+		suite.Require().NoError(err)
+		suite.Require().Equal(contents, received)
+	}
+}
+
+// TestFaultMove checks that a failed move leaves the source in place, and
+// that retrying a move completed by the backend despite failing reports the
+// source as missing without corrupting the destination.
+func (suite *DriverSuite) TestFaultMove() {
+	contents := randomContents(32)
+	sourcePath := randomPath(32)
+	destPath := randomPath(32)
+
+	defer suite.deletePath(firstPart(sourcePath))
+	defer suite.deletePath(firstPart(destPath))
+
+	d := NewFaultInjector(suite.StorageDriver, Fault{Op: OpMove, Call: 1, Err: errInjected})
+	suite.Require().NoError(d.PutContent(suite.ctx, sourcePath, contents))
+
+	suite.Require().ErrorIs(d.Move(suite.ctx, sourcePath, destPath), errInjected)
+	received, err := d.GetContent(suite.ctx, sourcePath)
+	suite.Require().NoError(err)
+	suite.Require().Equal(contents, received)
+	_, err = d.Stat(suite.ctx, destPath)
+	suite.Require().IsType(storagedriver.PathNotFoundError{}, err)
+
+	d.Inject(Fault{Op: OpMove, Call: 1, Err: errInjected, After: true})
+	suite.Require().ErrorIs(d.Move(suite.ctx, sourcePath, destPath), errInjected)
+	err = d.Move(suite.ctx, sourcePath, destPath)
+	suite.Require().IsType(storagedriver.PathNotFoundError{}, err)
+
+	received, err = d.GetContent(suite.ctx, destPath)
+	suite.Require().NoError(err)
+	suite.Require().Equal(contents, received)
+}
+
+// TestFaultWalk checks that a walk failing to list a directory returns the
+// error after visiting a prefix of the files, and can be retried.
+func (suite *DriverSuite) TestFaultWalk() {
+	rootDirectory := "/" + randomFilename(int64(8+rand.Intn(8)))
+	defer suite.deletePath(rootDirectory)
+
+	for _, dir := range []string{"a", "b", "c"} {
+		for _, file := range []string{"1", "2"} {
+			err := suite.StorageDriver.PutContent(suite.ctx, path.Join(rootDirectory, dir, file), randomContents(8))
+			suite.Require().NoError(err)
+		}
+	}
+
+	d := NewFaultInjector(suite.StorageDriver, Fault{Op: OpList, Call: 3, Err: errInjected})
+	walk := func() ([]string, error) {
+		var visited []string
+		err := d.Walk(suite.ctx, rootDirectory, func(fileInfo storagedriver.FileInfo) error {
+			visited = append(visited, fileInfo.Path())
+			return nil
+		})
+		return visited, err
+	}
+
+	partial, err := walk()
+	suite.Require().ErrorIs(err, errInjected)
+
+	d.Clear()
+	full, err := walk()
+	suite.Require().NoError(err)
+	suite.Require().Len(full, 9)
+	suite.Require().Less(len(partial), len(full))
+	suite.Require().Equal(full[:len(partial)], partial)
+}
+
+// TestFaultRead checks that a read failing mid-stream can be resumed at the
+// offset reached without corrupting the content.
+func (suite *DriverSuite) TestFaultRead() {
+	filename := randomPath(32)
+	defer suite.deletePath(firstPart(filename))
+
+	contents := randomContents(1024)
+	suite.Require().NoError(suite.StorageDriver.PutContent(suite.ctx, filename, contents))
+
+	d := NewFaultInjector(suite.StorageDriver, Fault{Op: OpRead, Call: 2, Err: errInjected})
+	reader, err := d.Reader(suite.ctx, filename, 0)
+	suite.Require().NoError(err)
+	received, err := io.ReadAll(reader)
+	suite.Require().ErrorIs(err, errInjected)
+	suite.Require().NoError(reader.Close())
+
+	reader, err = d.Reader(suite.ctx, filename, int64(len(received)))
+	suite.Require().NoError(err)
+	defer reader.Close()
+	rest, err := io.ReadAll(reader)
+	suite.Require().NoError(err)
+	suite.Require().Equal(contents, append(received, rest...))
+}
+
+// TestFaultTimeout checks that an operation timing out before reaching the
+// backend returns the error of its context and has no effect.
+func (suite *DriverSuite) TestFaultTimeout() {
+	filename := randomPath(32)
+	defer suite.deletePath(firstPart(filename))
+
+	d := NewFaultInjector(suite.StorageDriver, Fault{Op: OpPutContent, Delay: time.Minute})
+	ctx, cancel := context.WithTimeout(suite.ctx, 10*time.Millisecond)
+	defer cancel()
+	err := d.PutContent(ctx, filename, randomContents(32))
+	suite.Require().ErrorIs(err, context.DeadlineExceeded)
+
+	_, err = d.GetContent(suite.ctx, filename)
+	suite.Require().IsType(storagedriver.PathNotFoundError{}, err)
+}
+
 // TODO (brianbland): evaluate the relevancy of this test
 // TestEventualConsistency checks that if stat says that a file is a certain size, then
 // you can freely read from the file (this is the only guarantee that the driver needs to provide)
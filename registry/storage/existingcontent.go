@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/reference"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// ContentMismatchFunc is called when the content uploaded to repo for a blob
+// which already exists differs from the stored content of the blob. It is
+// responsible for reporting the mismatch, which is not logged otherwise.
+type ContentMismatchFunc func(ctx context.Context, repo reference.Named, desc v1.Descriptor)
+
+// VerifyExistingContent is a functional option for NewRegistry. It compares
+// the content of uploads completed with the digest of an existing blob to the
+// stored content, calling fn if it differs, or logging it if fn is nil. As blobs are content-addressable,
+// this may only happen through a digest collision or corrupted storage. The
+// stored content is kept either way.
+func VerifyExistingContent(fn ContentMismatchFunc) RegistryOption {
+	return func(registry *registry) error {
+		registry.verifyExistingContent = true
+		registry.contentMismatch = fn
+		return nil
+	}
+}
+
+// verifyExistingContent compares the uploaded content to the content stored
+// at blobPath for the same digest. Uploads which cannot be compared are
+// logged and otherwise ignored: the stored content always takes precedence.
+func (bw *blobWriter) verifyExistingContent(ctx context.Context, desc v1.Descriptor, blobPath string) {
+	registry := bw.blobStore.registry
+	if !registry.verifyExistingContent {
+		return
+	}
+
+	logger := dcontext.GetLoggerWithField(ctx, "digest", desc.Digest)
+
+	same, err := bw.sameContent(ctx, bw.path, blobPath, desc.Size)
+	if err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+			logger.Errorf("unable to compare upload to existing blob content: %v", err)
+		}
+		return
+	}
+	if same {
+		return
+	}
+
+	// the mismatch is reported by fn if set, and logged otherwise
+	if registry.contentMismatch != nil {
+		registry.contentMismatch(ctx, bw.blobStore.repository.Named(), desc)
+		return
+	}
+	logger.Errorf("content uploaded to %s differs from the existing blob content, keeping the existing content", bw.blobStore.repository.Named().Name())
+}
+
+// sameContent reports whether the files at path1 and path2 hold the same
+// size bytes.
+func (bw *blobWriter) sameContent(ctx context.Context, path1, path2 string, size int64) (bool, error) {
+	fi, err := bw.driver.Stat(ctx, path2)
+	if err != nil {
+		return false, err
+	}
+	if fi.Size() != size {
+		return false, nil
+	}
+
+	fr1, err := newFileReader(ctx, bw.driver, path1, size)
+	if err != nil {
+		return false, err
+	}
+	defer fr1.Close()
+
+	fr2, err := newFileReader(ctx, bw.driver, path2, size)
+	if err != nil {
+		return false, err
+	}
+	defer fr2.Close()
+
+	const chunkSize = 32 << 10
+	buf1 := make([]byte, chunkSize)
+	buf2 := make([]byte, chunkSize)
+	for {
+		n1, err1 := io.ReadFull(fr1, buf1)
+		n2, err2 := io.ReadFull(fr2, buf2)
+		if !bytes.Equal(buf1[:n1], buf2[:n2]) {
+			return false, nil
+		}
+		if err1 == io.EOF || err1 == io.ErrUnexpectedEOF {
+			return err2 == io.EOF || err2 == io.ErrUnexpectedEOF, nil
+		}
+		if err1 != nil {
+			return false, err1
+		}
+		if err2 != nil {
+			if err2 == io.EOF || err2 == io.ErrUnexpectedEOF {
+				return false, nil
+			}
+			return false, err2
+		}
+	}
+}
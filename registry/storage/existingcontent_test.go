@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestVerifyExistingContent(t *testing.T) {
+	ctx := context.Background()
+	imageName, _ := reference.WithName("foo/bar")
+
+	content := []byte("the content pushed first")
+	dgst := digest.FromBytes(content)
+
+	for _, tc := range []struct {
+		name     string
+		stored   []byte
+		verify   bool
+		mismatch bool
+	}{
+		{name: "same content", stored: content, verify: true},
+		{name: "different content", stored: []byte("the content pushed twice"), verify: true, mismatch: true},
+		{name: "different size", stored: []byte("colliding content"), verify: true, mismatch: true},
+		{name: "verification disabled", stored: []byte("colliding content"), verify: false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			driver := inmemory.New()
+
+			var mismatches []v1.Descriptor
+			options := []RegistryOption{}
+			if tc.verify {
+				options = append(options, VerifyExistingContent(func(ctx context.Context, repo reference.Named, desc v1.Descriptor) {
+					if repo.Name() != imageName.Name() {
+						t.Errorf("unexpected repository reported: %s", repo.Name())
+					}
+					mismatches = append(mismatches, desc)
+				}))
+			}
+			registry, err := NewRegistry(ctx, driver, options...)
+			if err != nil {
+				t.Fatalf("error creating registry: %v", err)
+			}
+			repository, err := registry.Repository(ctx, imageName)
+			if err != nil {
+				t.Fatalf("unexpected error getting repo: %v", err)
+			}
+			bs := repository.Blobs(ctx)
+
+			// Simulate a blob stored for the digest, as if pushed to another
+			// repository, possibly with colliding content.
+			blobPath, err := pathFor(blobDataPathSpec{digest: dgst})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := driver.PutContent(ctx, blobPath, tc.stored); err != nil {
+				t.Fatal(err)
+			}
+
+			desc := v1.Descriptor{Digest: dgst, Size: int64(len(content))}
+			if _, err := addBlob(ctx, bs, desc, bytes.NewReader(content)); err != nil {
+				t.Fatalf("unexpected error uploading blob: %v", err)
+			}
+
+			if tc.mismatch != (len(mismatches) > 0) {
+				t.Fatalf("expected mismatch %v, got %v", tc.mismatch, mismatches)
+			}
+			if tc.mismatch && mismatches[0].Digest != dgst {
+				t.Fatalf("unexpected digest reported: %s", mismatches[0].Digest)
+			}
+
+			rc, err := bs.Open(ctx, dgst)
+			if err != nil {
+				t.Fatalf("unexpected error opening blob: %v", err)
+			}
+			defer rc.Close()
+			p, err := io.ReadAll(rc)
+			if err != nil {
+				t.Fatalf("unexpected error reading blob: %v", err)
+			}
+			if !bytes.Equal(p, tc.stored) {
+				t.Fatalf("expected the stored content to be kept, got %q", p)
+			}
+		})
+	}
+}
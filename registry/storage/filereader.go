@@ -126,6 +126,10 @@ func (fr *fileReader) reader() (io.Reader, error) {
 			// allowing future attempts at getting a reader to possibly
 			// succeed if the file turns up later.
 			return io.NopCloser(bytes.NewReader([]byte{})), nil
+		case storagedriver.InvalidOffsetError:
+			// NOTE: Reading past the end of the file is reported as io.EOF,
+			// consistent with reads at the end of the file.
+			return io.NopCloser(bytes.NewReader([]byte{})), nil
 		default:
 			return nil, err
 		}
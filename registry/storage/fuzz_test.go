@@ -0,0 +1,19 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+)
+
+// FuzzPathFor checks pathFor maps every spec as legacyPathFor does.
+func FuzzPathFor(f *testing.F) {
+	f.Add("foo/bar", "latest", "asdf-asdf-asdf-adsf", "sha256:abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789", int64(42))
+	f.Add("/foo//../bar/", "..", "", "sha256:abcdef", int64(-1))
+	f.Add("", "", "", "", int64(0))
+	f.Fuzz(func(t *testing.T, name, tag, id, dgst string, offset int64) {
+		for _, spec := range specsFor(name, tag, id, digest.Digest(dgst), offset) {
+			checkLegacyPathFor(t, spec)
+		}
+	})
+}
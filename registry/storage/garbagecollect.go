@@ -1,15 +1,22 @@
 package storage
 
 import (
+	"cmp"
 	"context"
 	"errors"
 	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/distribution/distribution/v3"
 	"github.com/distribution/distribution/v3/registry/storage/driver"
 	"github.com/distribution/reference"
 	"github.com/opencontainers/go-digest"
 	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"golang.org/x/sync/errgroup"
 )
 
 func emit(format string, a ...any) {
@@ -21,6 +28,17 @@ type GCOpts struct {
 	DryRun         bool
 	RemoveUntagged bool
 	Quiet          bool
+	// Concurrency is the number of repositories marked in parallel, one if
+	// not set.
+	Concurrency int
+	// MaxMarksInMemory is the number of marked digests held in memory, by
+	// the registry wide mark set and by the mark set of each repository whose
+	// layer links are checked, the others being written to files in
+	// SpillDir. Marked digests are only held in memory if not set.
+	MaxMarksInMemory int
+	// SpillDir is the directory marked digests are written to, the default
+	// directory for temporary files if empty.
+	SpillDir string
 }
 
 // ManifestDel contains manifest structure which will be deleted
@@ -30,118 +48,67 @@ type ManifestDel struct {
 	Tags   []string
 }
 
-// MarkAndSweep performs a mark and sweep of registry data
-func MarkAndSweep(ctx context.Context, storageDriver driver.StorageDriver, registry distribution.Namespace, opts GCOpts) error {
+// MarkAndSweep performs a mark and sweep of registry data. Unless
+// opts.DryRun is set, a marker kept by the storage driver while it runs
+// postpones the link reconciliation of the registries sharing the storage.
+func MarkAndSweep(ctx context.Context, storageDriver driver.StorageDriver, registry distribution.Namespace, opts GCOpts) (err error) {
 	repositoryEnumerator, ok := registry.(distribution.RepositoryEnumerator)
 	if !ok {
 		return fmt.Errorf("unable to convert Namespace to RepositoryEnumerator")
 	}
 
-	// mark
-	markSet := make(map[digest.Digest]struct{})
-	deleteLayerSet := make(map[string][]digest.Digest)
-	manifestArr := make([]ManifestDel, 0)
-	err := repositoryEnumerator.Enumerate(ctx, func(repoName string) error {
-		if !opts.Quiet {
-			emit(repoName)
-		}
-
-		var err error
-		named, err := reference.WithName(repoName)
-		if err != nil {
-			return fmt.Errorf("failed to parse repo name %s: %v", repoName, err)
-		}
-		repository, err := registry.Repository(ctx, named)
+	if !opts.DryRun {
+		markerPath, err := pathFor(garbageCollectionPathSpec{})
 		if err != nil {
-			return fmt.Errorf("failed to construct repository: %v", err)
+			return err
 		}
-
-		manifestService, err := repository.Manifests(ctx)
-		if err != nil {
-			return fmt.Errorf("failed to construct manifest service: %v", err)
+		if err := storageDriver.PutContent(ctx, markerPath, []byte(time.Now().UTC().Format(time.RFC3339))); err != nil {
+			return fmt.Errorf("failed to mark the garbage collection in progress: %v", err)
 		}
-
-		manifestEnumerator, ok := manifestService.(distribution.ManifestEnumerator)
-		if !ok {
-			return fmt.Errorf("unable to convert ManifestService into ManifestEnumerator")
-		}
-
-		err = manifestEnumerator.Enumerate(ctx, func(dgst digest.Digest) error {
-			if opts.RemoveUntagged {
-				// fetch all tags where this manifest is the latest one
-				tags, err := repository.Tags(ctx).Lookup(ctx, v1.Descriptor{Digest: dgst})
-				if err != nil {
-					return fmt.Errorf("failed to retrieve tags for digest %v: %v", dgst, err)
-				}
-				if len(tags) == 0 {
-					// fetch all tags from repository
-					// all of these tags could contain manifest in history
-					// which means that we need check (and delete) those references when deleting manifest
-					allTags, err := repository.Tags(ctx).All(ctx)
-					if err != nil {
-						if _, ok := err.(distribution.ErrRepositoryUnknown); ok {
-							if !opts.Quiet {
-								emit("manifest tags path of repository %s does not exist", repoName)
-							}
-							return nil
-						}
-						return fmt.Errorf("failed to retrieve tags %v", err)
-					}
-					manifestArr = append(manifestArr, ManifestDel{Name: repoName, Digest: dgst, Tags: allTags})
-					return nil
-				}
+		defer func() {
+			if deleteErr := storageDriver.Delete(ctx, markerPath); deleteErr != nil && err == nil {
+				err = fmt.Errorf("failed to remove the marker of the garbage collection: %v", deleteErr)
 			}
-			// Mark the manifest's blob
-			if !opts.Quiet {
-				emit("%s: marking manifest %s ", repoName, dgst)
-			}
-			markSet[dgst] = struct{}{}
-
-			return markManifestReferences(dgst, manifestService, ctx, func(d digest.Digest) bool {
-				_, marked := markSet[d]
-				if !marked {
-					markSet[d] = struct{}{}
-					if !opts.Quiet {
-						emit("%s: marking blob %s", repoName, d)
-					}
-				}
-				return marked
-			})
-		})
+		}()
+	}
 
-		if err != nil {
-			// In certain situations such as unfinished uploads, deleting all
-			// tags in S3 or removing the _manifests folder manually, this
-			// error may be of type PathNotFound.
-			//
-			// In these cases we can continue marking other manifests safely.
-			if _, ok := err.(driver.PathNotFoundError); !ok {
-				return err
+	// mark
+	m := newMarker(registry, opts)
+	defer m.close()
+	err = forEachRepository(ctx, repositoryEnumerator, opts.Concurrency, m.markRepository, func(repoName string, lines []string) {
+		if !opts.Quiet {
+			emit(repoName)
+			for _, line := range lines {
+				emit(line)
 			}
 		}
-		blobService := repository.Blobs(ctx)
-		layerEnumerator, ok := blobService.(distribution.ManifestEnumerator)
-		if !ok {
-			return errors.New("unable to convert BlobService into ManifestEnumerator")
-		}
+	})
+	if err != nil {
+		return fmt.Errorf("failed to mark: %v", err)
+	}
 
-		var deleteLayers []digest.Digest
-		err = layerEnumerator.Enumerate(ctx, func(dgst digest.Digest) error {
-			if _, ok := markSet[dgst]; !ok {
-				deleteLayers = append(deleteLayers, dgst)
-			}
-			return nil
-		})
+	// the layer links of a repository are only checked once all
+	// repositories are marked, as the manifests it keeps may be referenced
+	// by the indexes of other repositories
+	deleteLayerSet := make(map[string][]digest.Digest)
+	err = forEachRepository(ctx, repositoryEnumerator, opts.Concurrency, m.unmarkedLayers, func(repoName string, deleteLayers []digest.Digest) {
 		if len(deleteLayers) > 0 {
 			deleteLayerSet[repoName] = deleteLayers
 		}
-		return err
 	})
 	if err != nil {
 		return fmt.Errorf("failed to mark: %v", err)
 	}
 
-	manifestArr = unmarkReferencedManifest(manifestArr, markSet, opts.Quiet)
+	markSet := m.marks
+	manifestArr := m.manifestArr
+	slices.SortFunc(manifestArr, func(a, b ManifestDel) int {
+		return cmp.Or(strings.Compare(a.Name, b.Name), strings.Compare(string(a.Digest), string(b.Digest)))
+	})
+	manifestArr, err = unmarkReferencedManifest(manifestArr, markSet, opts.Quiet)
+	if err != nil {
+		return fmt.Errorf("failed to mark: %v", err)
+	}
 
 	// sweep
 	vacuum := NewVacuum(ctx, storageDriver)
@@ -154,21 +121,27 @@ func MarkAndSweep(ctx context.Context, storageDriver driver.StorageDriver, regis
 		}
 	}
 	blobService := registry.Blobs()
-	deleteSet := make(map[digest.Digest]struct{})
+	var deleteSet []digest.Digest
 	err = blobService.Enumerate(ctx, func(dgst digest.Digest) error {
 		// check if digest is in markSet. If not, delete it!
-		if _, ok := markSet[dgst]; !ok {
-			deleteSet[dgst] = struct{}{}
+		marked, err := markSet.contains(dgst)
+		if err != nil {
+			return err
+		}
+		if !marked {
+			deleteSet = append(deleteSet, dgst)
 		}
 		return nil
 	})
 	if err != nil {
 		return fmt.Errorf("error enumerating blobs: %v", err)
 	}
+	slices.Sort(deleteSet)
+	deleteSet = slices.Compact(deleteSet)
 	if !opts.Quiet {
-		emit("\n%d blobs marked, %d blobs and %d manifests eligible for deletion", len(markSet), len(deleteSet), len(manifestArr))
+		emit("\n%d blobs marked, %d blobs and %d manifests eligible for deletion", markSet.len(), len(deleteSet), len(manifestArr))
 	}
-	for dgst := range deleteSet {
+	for _, dgst := range deleteSet {
 		if !opts.Quiet {
 			emit("blob eligible for deletion: %s", dgst)
 		}
@@ -181,8 +154,8 @@ func MarkAndSweep(ctx context.Context, storageDriver driver.StorageDriver, regis
 		}
 	}
 
-	for repo, dgsts := range deleteLayerSet {
-		for _, dgst := range dgsts {
+	for _, repo := range slices.Sorted(maps.Keys(deleteLayerSet)) {
+		for _, dgst := range deleteLayerSet[repo] {
 			if !opts.Quiet {
 				emit("%s: layer link eligible for deletion: %s", repo, dgst)
 			}
@@ -196,14 +169,30 @@ func MarkAndSweep(ctx context.Context, storageDriver driver.StorageDriver, regis
 		}
 	}
 
+	// the space of the blobs deleted from pack files is only reclaimed once
+	// they are compacted
+	if packer, ok := storageDriver.(blobPacker); ok && !opts.DryRun {
+		reclaimed, err := packer.Compact(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to compact pack files: %v", err)
+		}
+		if !opts.Quiet && reclaimed > 0 {
+			emit("%d bytes reclaimed from pack files", reclaimed)
+		}
+	}
+
 	return err
 }
 
 // unmarkReferencedManifest filters out manifest present in markSet
-func unmarkReferencedManifest(manifestArr []ManifestDel, markSet map[digest.Digest]struct{}, quietOutput bool) []ManifestDel {
+func unmarkReferencedManifest(manifestArr []ManifestDel, markSet *markSet, quietOutput bool) ([]ManifestDel, error) {
 	filtered := make([]ManifestDel, 0)
 	for _, obj := range manifestArr {
-		if _, ok := markSet[obj.Digest]; !ok {
+		marked, err := markSet.contains(obj.Digest)
+		if err != nil {
+			return nil, err
+		}
+		if !marked {
 			if !quietOutput {
 				emit("manifest eligible for deletion: %s", obj)
 			}
@@ -211,11 +200,326 @@ func unmarkReferencedManifest(manifestArr []ManifestDel, markSet map[digest.Dige
 			filtered = append(filtered, obj)
 		}
 	}
-	return filtered
+	return filtered, nil
+}
+
+// maxCachedReferences is the number of manifests whose references are held
+// in memory by the mark phase.
+const maxCachedReferences = 100000
+
+// marker marks the blobs referenced by the manifests of repositories.
+type marker struct {
+	registry distribution.Namespace
+	opts     GCOpts
+
+	// marks holds the digests marked.
+	marks *markSet
+	// traversed holds the digests of the manifests whose references are
+	// marked, which are not traversed again.
+	traversed *markSet
+
+	mu sync.Mutex
+	// references caches the references of manifests, to parse the
+	// manifests referenced by many repositories once.
+	references  map[digest.Digest][]v1.Descriptor
+	manifestArr []ManifestDel
+}
+
+func newMarker(registry distribution.Namespace, opts GCOpts) *marker {
+	return &marker{
+		registry:   registry,
+		opts:       opts,
+		marks:      newMarkSet(opts.MaxMarksInMemory, opts.SpillDir),
+		traversed:  newMarkSet(opts.MaxMarksInMemory, opts.SpillDir),
+		references: make(map[digest.Digest][]v1.Descriptor),
+	}
+}
+
+func (m *marker) close() {
+	m.marks.close()
+	m.traversed.close()
+}
+
+// markRepository marks the blobs referenced by the manifests of repoName,
+// and returns the lines reporting them.
+func (m *marker) markRepository(ctx context.Context, repoName string) ([]string, error) {
+	var lines []string
+	report := func(format string, a ...any) {
+		lines = append(lines, fmt.Sprintf(format, a...))
+	}
+
+	named, err := reference.WithName(repoName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse repo name %s: %v", repoName, err)
+	}
+	repository, err := m.registry.Repository(ctx, named)
+	if err != nil {
+		return nil, fmt.Errorf("failed to construct repository: %v", err)
+	}
+
+	manifestService, err := repository.Manifests(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to construct manifest service: %v", err)
+	}
+
+	manifestEnumerator, ok := manifestService.(distribution.ManifestEnumerator)
+	if !ok {
+		return nil, fmt.Errorf("unable to convert ManifestService into ManifestEnumerator")
+	}
+
+	err = manifestEnumerator.Enumerate(ctx, func(dgst digest.Digest) error {
+		if m.opts.RemoveUntagged {
+			// fetch all tags where this manifest is the latest one
+			tags, err := repository.Tags(ctx).Lookup(ctx, v1.Descriptor{Digest: dgst})
+			if err != nil {
+				return fmt.Errorf("failed to retrieve tags for digest %v: %v", dgst, err)
+			}
+			if len(tags) == 0 {
+				// fetch all tags from repository
+				// all of these tags could contain manifest in history
+				// which means that we need check (and delete) those references when deleting manifest
+				allTags, err := repository.Tags(ctx).All(ctx)
+				if err != nil {
+					if _, ok := err.(distribution.ErrRepositoryUnknown); ok {
+						report("manifest tags path of repository %s does not exist", repoName)
+						return nil
+					}
+					return fmt.Errorf("failed to retrieve tags %v", err)
+				}
+				m.mu.Lock()
+				m.manifestArr = append(m.manifestArr, ManifestDel{Name: repoName, Digest: dgst, Tags: allTags})
+				m.mu.Unlock()
+				return nil
+			}
+		}
+		// Mark the manifest's blob
+		report("%s: marking manifest %s ", repoName, dgst)
+		if _, err := m.marks.add(dgst); err != nil {
+			return err
+		}
+
+		return m.traverse(ctx, manifestService, dgst, func(d digest.Digest) {
+			report("%s: marking blob %s", repoName, d)
+		})
+	})
+
+	if err != nil {
+		// In certain situations such as unfinished uploads, deleting all
+		// tags in S3 or removing the _manifests folder manually, this
+		// error may be of type PathNotFound.
+		//
+		// In these cases we can continue marking other manifests safely.
+		if _, ok := err.(driver.PathNotFoundError); !ok {
+			return nil, err
+		}
+	}
+	return lines, nil
+}
+
+// traverse marks the references of the manifest dgst, and of the manifests
+// of the repository it references, skipping the references already marked as
+// markManifestReferences does, and the manifests already traversed.
+func (m *marker) traverse(ctx context.Context, manifestService distribution.ManifestService, dgst digest.Digest, marked func(digest.Digest)) error {
+	if traversed, err := m.traversed.contains(dgst); traversed || err != nil {
+		return err
+	}
+	references, err := m.manifestReferences(ctx, manifestService, dgst)
+	if err != nil {
+		return err
+	}
+
+	for _, descriptor := range references {
+		// do not visit references if already marked
+		added, err := m.marks.add(descriptor.Digest)
+		if err != nil {
+			return err
+		}
+		if !added {
+			continue
+		}
+		marked(descriptor.Digest)
+
+		if ok, _ := manifestService.Exists(ctx, descriptor.Digest); ok {
+			if err := m.traverse(ctx, manifestService, descriptor.Digest, marked); err != nil {
+				return err
+			}
+		}
+	}
+	_, err = m.traversed.add(dgst)
+	return err
+}
+
+// manifestReferences returns the references of the manifest dgst.
+func (m *marker) manifestReferences(ctx context.Context, manifestService distribution.ManifestService, dgst digest.Digest) ([]v1.Descriptor, error) {
+	m.mu.Lock()
+	references, ok := m.references[dgst]
+	m.mu.Unlock()
+	if ok {
+		return references, nil
+	}
+
+	manifest, err := manifestService.Get(ctx, dgst)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve manifest for digest %v: %v", dgst, err)
+	}
+	references = manifest.References()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.references) >= maxCachedReferences {
+		// evict any manifest
+		for evicted := range m.references {
+			delete(m.references, evicted)
+			break
+		}
+	}
+	m.references[dgst] = references
+	return references, nil
+}
+
+// unmarkedLayers returns the blobs linked to repoName which are not
+// referenced by the marked manifests of repoName, the manifests it keeps. A
+// blob only referenced by the manifests of other repositories is no longer
+// linked to repoName.
+func (m *marker) unmarkedLayers(ctx context.Context, repoName string) ([]digest.Digest, error) {
+	named, err := reference.WithName(repoName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse repo name %s: %v", repoName, err)
+	}
+	repository, err := m.registry.Repository(ctx, named)
+	if err != nil {
+		return nil, fmt.Errorf("failed to construct repository: %v", err)
+	}
+	manifestService, err := repository.Manifests(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to construct manifest service: %v", err)
+	}
+	manifestEnumerator, ok := manifestService.(distribution.ManifestEnumerator)
+	if !ok {
+		return nil, errors.New("unable to convert ManifestService into ManifestEnumerator")
+	}
+
+	repoMarks := newMarkSet(m.opts.MaxMarksInMemory, m.opts.SpillDir)
+	defer repoMarks.close()
+	var markReferences func(dgst digest.Digest) error
+	markReferences = func(dgst digest.Digest) error {
+		references, err := m.manifestReferences(ctx, manifestService, dgst)
+		if err != nil {
+			return err
+		}
+		for _, descriptor := range references {
+			added, err := repoMarks.add(descriptor.Digest)
+			if err != nil {
+				return err
+			}
+			if !added {
+				continue
+			}
+			if ok, _ := manifestService.Exists(ctx, descriptor.Digest); ok {
+				if err := markReferences(descriptor.Digest); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	err = manifestEnumerator.Enumerate(ctx, func(dgst digest.Digest) error {
+		if marked, err := m.marks.contains(dgst); !marked || err != nil {
+			return err
+		}
+		if _, err := repoMarks.add(dgst); err != nil {
+			return err
+		}
+		return markReferences(dgst)
+	})
+	if err != nil {
+		// as when marking the repository, a missing _manifests folder
+		// leaves no blob referenced
+		if _, ok := err.(driver.PathNotFoundError); !ok {
+			return nil, err
+		}
+	}
+
+	blobService := repository.Blobs(ctx)
+	layerEnumerator, ok := blobService.(distribution.ManifestEnumerator)
+	if !ok {
+		return nil, errors.New("unable to convert BlobService into ManifestEnumerator")
+	}
+
+	var deleteLayers []digest.Digest
+	err = layerEnumerator.Enumerate(ctx, func(dgst digest.Digest) error {
+		marked, err := repoMarks.contains(dgst)
+		if err != nil {
+			return err
+		}
+		if !marked {
+			deleteLayers = append(deleteLayers, dgst)
+		}
+		return nil
+	})
+	return deleteLayers, err
+}
+
+// forEachRepository calls fn with each repository of enumerator, from
+// concurrency goroutines, and report with the results of fn in the order
+// the repositories are enumerated.
+func forEachRepository[T any](ctx context.Context, enumerator distribution.RepositoryEnumerator, concurrency int, fn func(context.Context, string) (T, error), report func(string, T)) error {
+	type job struct {
+		repoName string
+		result   T
+		err      error
+		done     chan struct{}
+	}
+
+	g, ctx := errgroup.WithContext(ctx)
+	jobs := make(chan *job)
+	pending := make(chan *job, max(concurrency, 1))
+	for range max(concurrency, 1) {
+		g.Go(func() error {
+			for j := range jobs {
+				j.result, j.err = fn(ctx, j.repoName)
+				close(j.done)
+			}
+			return nil
+		})
+	}
+	g.Go(func() error {
+		for j := range pending {
+			select {
+			case <-j.done:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			if j.err != nil {
+				return j.err
+			}
+			report(j.repoName, j.result)
+		}
+		return nil
+	})
+
+	err := enumerator.Enumerate(ctx, func(repoName string) error {
+		j := &job{repoName: repoName, done: make(chan struct{})}
+		for _, queue := range []chan *job{pending, jobs} {
+			select {
+			case queue <- j:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return nil
+	})
+	close(jobs)
+	close(pending)
+	if werr := g.Wait(); werr != nil {
+		return werr
+	}
+	return err
 }
 
-// markManifestReferences marks the manifest references
-func markManifestReferences(dgst digest.Digest, manifestService distribution.ManifestService, ctx context.Context, ingester func(digest.Digest) bool) error {
+// markManifestReferences marks the manifest references. ingester reports
+// whether a reference was already marked, whose references are then skipped.
+func markManifestReferences(dgst digest.Digest, manifestService distribution.ManifestService, ctx context.Context, ingester func(v1.Descriptor) bool) error {
 	manifest, err := manifestService.Get(ctx, dgst)
 	if err != nil {
 		return fmt.Errorf("failed to retrieve manifest for digest %v: %v", dgst, err)
@@ -225,7 +529,7 @@ func markManifestReferences(dgst digest.Digest, manifestService distribution.Man
 	for _, descriptor := range descriptors {
 
 		// do not visit references if already marked
-		if ingester(descriptor.Digest) {
+		if ingester(descriptor) {
 			continue
 		}
 
@@ -1,9 +1,16 @@
 package storage
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"io"
+	"math/rand/v2"
 	"path"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/distribution/distribution/v3"
 	"github.com/distribution/distribution/v3/internal/dcontext"
@@ -13,6 +20,7 @@ import (
 	"github.com/distribution/distribution/v3/testutil"
 	"github.com/distribution/reference"
 	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
 	v1 "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
@@ -22,7 +30,7 @@ type image struct {
 	layers         map[digest.Digest]io.ReadSeeker
 }
 
-func createRegistry(t *testing.T, driver driver.StorageDriver, options ...RegistryOption) distribution.Namespace {
+func createRegistry(t testing.TB, driver driver.StorageDriver, options ...RegistryOption) distribution.Namespace {
 	ctx := dcontext.Background()
 	options = append(options, EnableDelete)
 	registry, err := NewRegistry(ctx, driver, options...)
@@ -458,6 +466,68 @@ func TestGCWithUnusedLayerLinkPath(t *testing.T) {
 	}
 }
 
+func TestGCWithLayerLinkReferencedByOtherRepository(t *testing.T) {
+	ctx := dcontext.Background()
+	d := inmemory.New()
+
+	registry := createRegistry(t, d)
+	imageRepo := makeRepository(t, registry, "image")
+	image := uploadRandomSchema2Image(t, imageRepo)
+	linkRepo := makeRepository(t, registry, "link")
+	linkImage := uploadRandomSchema2Image(t, linkRepo)
+
+	// link the layers of the image to the other repository, which has no
+	// manifest referencing them
+	for _, rs := range image.layers {
+		if _, err := rs.Seek(0, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := testutil.UploadBlobs(linkRepo, image.layers); err != nil {
+		t.Fatal(err)
+	}
+
+	err := MarkAndSweep(dcontext.Background(), d, registry, GCOpts{
+		DryRun:         false,
+		RemoveUntagged: false,
+	})
+	if err != nil {
+		t.Fatalf("got error: %v, expected nil", err)
+	}
+
+	linkExists := func(repo string, dgst digest.Digest) bool {
+		layerLinkPath, err := pathFor(layerLinkPathSpec{name: repo, digest: dgst})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = d.Stat(ctx, layerLinkPath)
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			return false
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return true
+	}
+	blobs := allBlobs(t, registry)
+	for dgst := range image.layers {
+		if linkExists("link", dgst) {
+			t.Errorf("layer link %s of repository link should be deleted", dgst)
+		}
+		if !linkExists("image", dgst) {
+			t.Errorf("layer link %s of repository image should be kept", dgst)
+		}
+		if _, ok := blobs[dgst]; !ok {
+			t.Errorf("blob %s should be kept", dgst)
+		}
+	}
+	for dgst := range linkImage.layers {
+		if !linkExists("link", dgst) {
+			t.Errorf("layer link %s of repository link should be kept", dgst)
+		}
+	}
+}
+
 func TestGCWithUnknownRepository(t *testing.T) {
 	ctx := dcontext.Background()
 	d := inmemory.New()
@@ -922,3 +992,233 @@ func TestTaggedManifestlistWithDeletedReference(t *testing.T) {
 		t.Fatalf("Garbage collection affected storage: %d != %d", len(after), 0)
 	}
 }
+
+// pushFixtureImage pushes an image of layers, with a shared config, to
+// repository and returns its descriptor.
+func pushFixtureImage(tb testing.TB, repository distribution.Repository, layers ...string) v1.Descriptor {
+	ctx := dcontext.Background()
+	bs := repository.Blobs(ctx)
+	var blobs []v1.Descriptor
+	for _, content := range append([]string{"{}"}, layers...) {
+		desc, err := addBlob(ctx, bs, v1.Descriptor{Digest: digest.FromString(content), Size: int64(len(content))}, strings.NewReader(content))
+		if err != nil {
+			tb.Fatal(err)
+		}
+		blobs = append(blobs, desc)
+	}
+	m := ocischema.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: v1.MediaTypeImageManifest,
+		Config:    v1.Descriptor{MediaType: v1.MediaTypeImageConfig, Digest: blobs[0].Digest, Size: blobs[0].Size},
+	}
+	for _, blob := range blobs[1:] {
+		m.Layers = append(m.Layers, v1.Descriptor{MediaType: v1.MediaTypeImageLayer, Digest: blob.Digest, Size: blob.Size})
+	}
+	manifest, err := ocischema.FromStruct(m)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return pushFixtureManifest(tb, repository, manifest)
+}
+
+func pushFixtureManifest(tb testing.TB, repository distribution.Repository, manifest distribution.Manifest) v1.Descriptor {
+	ctx := dcontext.Background()
+	manifestService, err := repository.Manifests(ctx)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	dgst, err := manifestService.Put(ctx, manifest)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	mediaType, payload, _ := manifest.Payload()
+	return v1.Descriptor{MediaType: mediaType, Digest: dgst, Size: int64(len(payload))}
+}
+
+// pushGCFixture pushes repositories sharing a tagged index of base images,
+// each with a tagged image and index of its own and an untagged image.
+func pushGCFixture(tb testing.TB, registry distribution.Namespace, repositories, layers int) {
+	ctx := dcontext.Background()
+	fixtureLayers := func(prefix string) []string {
+		var contents []string
+		for i := range layers {
+			contents = append(contents, fmt.Sprintf("%s layer %d", prefix, i))
+		}
+		return contents
+	}
+	for i := range repositories {
+		named, err := reference.WithName(fmt.Sprintf("fixture/app%d", i))
+		if err != nil {
+			tb.Fatal(err)
+		}
+		repo, err := registry.Repository(ctx, named)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		tag := func(name string, desc v1.Descriptor) {
+			if err := repo.Tags(ctx).Tag(ctx, name, desc); err != nil {
+				tb.Fatal(err)
+			}
+		}
+
+		var baseImages []v1.Descriptor
+		for _, arch := range []string{"amd64", "arm64"} {
+			baseImages = append(baseImages, pushFixtureImage(tb, repo, fixtureLayers("base "+arch)...))
+		}
+		baseIndex, err := ocischema.FromDescriptors(baseImages, nil)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		base := pushFixtureManifest(tb, repo, baseIndex)
+		tag("base", base)
+
+		image := pushFixtureImage(tb, repo, fixtureLayers(named.Name())...)
+		tag("latest", image)
+		index, err := ocischema.FromDescriptors([]v1.Descriptor{base, image}, nil)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		tag("all", pushFixtureManifest(tb, repo, index))
+
+		pushFixtureImage(tb, repo, fixtureLayers(named.Name()+" untagged")...)
+	}
+}
+
+// previousMarkSet returns the digests marked by the mark phase as it was
+// implemented before marking repositories in parallel.
+func previousMarkSet(tb testing.TB, registry distribution.Namespace, removeUntagged bool) map[digest.Digest]struct{} {
+	ctx := dcontext.Background()
+	markSet := make(map[digest.Digest]struct{})
+	err := registry.(distribution.RepositoryEnumerator).Enumerate(ctx, func(repoName string) error {
+		named, err := reference.WithName(repoName)
+		if err != nil {
+			return err
+		}
+		repository, err := registry.Repository(ctx, named)
+		if err != nil {
+			return err
+		}
+		manifestService, err := repository.Manifests(ctx)
+		if err != nil {
+			return err
+		}
+		return manifestService.(distribution.ManifestEnumerator).Enumerate(ctx, func(dgst digest.Digest) error {
+			if removeUntagged {
+				tags, err := repository.Tags(ctx).Lookup(ctx, v1.Descriptor{Digest: dgst})
+				if err != nil {
+					return err
+				}
+				if len(tags) == 0 {
+					return nil
+				}
+			}
+			markSet[dgst] = struct{}{}
+			return markManifestReferences(dgst, manifestService, ctx, func(desc v1.Descriptor) bool {
+				_, marked := markSet[desc.Digest]
+				markSet[desc.Digest] = struct{}{}
+				return marked
+			})
+		})
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return markSet
+}
+
+func TestMarkMatchesPreviousImplementation(t *testing.T) {
+	ctx := dcontext.Background()
+	registry := createRegistry(t, inmemory.New())
+	pushGCFixture(t, registry, 6, 2)
+
+	for _, removeUntagged := range []bool{false, true} {
+		expected := previousMarkSet(t, registry, removeUntagged)
+		for _, opts := range []GCOpts{
+			{},
+			{Concurrency: 8},
+			{Concurrency: 8, MaxMarksInMemory: 3, SpillDir: t.TempDir()},
+		} {
+			opts.RemoveUntagged = removeUntagged
+			m := newMarker(registry, opts)
+			err := forEachRepository(ctx, registry.(distribution.RepositoryEnumerator), opts.Concurrency, m.markRepository, func(string, []string) {})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n := m.marks.len(); n != len(expected) {
+				t.Errorf("%+v: %d digests marked, expected %d", opts, n, len(expected))
+			}
+			for dgst := range expected {
+				if marked, err := m.marks.contains(dgst); !marked || err != nil {
+					t.Errorf("%+v: %s not marked: %v", opts, dgst, err)
+				}
+			}
+			m.close()
+		}
+	}
+}
+
+func TestForEachRepository(t *testing.T) {
+	ctx := dcontext.Background()
+	registry := createRegistry(t, inmemory.New())
+	pushGCFixture(t, registry, 10, 1)
+	enumerator := registry.(distribution.RepositoryEnumerator)
+
+	var expected []string
+	if err := enumerator.Enumerate(ctx, func(repoName string) error {
+		expected = append(expected, repoName)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// results are reported in the order of enumeration
+	var reported []string
+	err := forEachRepository(ctx, enumerator, 4, func(ctx context.Context, repoName string) (string, error) {
+		time.Sleep(time.Duration(rand.IntN(10)) * time.Millisecond)
+		return repoName, nil
+	}, func(repoName, result string) {
+		if repoName != result {
+			t.Errorf("result %s reported for %s", result, repoName)
+		}
+		reported = append(reported, repoName)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(reported, expected) {
+		t.Fatalf("unexpected order of the results: %v", reported)
+	}
+
+	failure := errors.New("failure")
+	err = forEachRepository(ctx, enumerator, 4, func(ctx context.Context, repoName string) (string, error) {
+		if repoName == expected[3] {
+			return "", failure
+		}
+		return repoName, nil
+	}, func(string, string) {})
+	if err != failure {
+		t.Fatalf("expected the failure to be returned, got %v", err)
+	}
+}
+
+func BenchmarkMarkAndSweep(b *testing.B) {
+	ctx := dcontext.Background()
+	d := inmemory.New()
+	registry := createRegistry(b, d)
+	pushGCFixture(b, registry, 200, 4)
+
+	for _, opts := range []GCOpts{
+		{Concurrency: 1},
+		{Concurrency: 8},
+		{Concurrency: 8, MaxMarksInMemory: 500},
+	} {
+		opts.DryRun, opts.Quiet, opts.SpillDir = true, true, b.TempDir()
+		b.Run(fmt.Sprintf("concurrency=%d,maxmarks=%d", opts.Concurrency, opts.MaxMarksInMemory), func(b *testing.B) {
+			for b.Loop() {
+				if err := MarkAndSweep(ctx, d, registry, opts); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
+	"golang.org/x/time/rate"
+)
+
+// BackfillIndexesOpts contains options for the backfill of the referrers
+// index and of the index of the manifests of indexes.
+type BackfillIndexesOpts struct {
+	// Rate is the maximum number of manifests read per second, unlimited if
+	// not set.
+	Rate float64
+	// Progress is called after each repository is backfilled, in the order
+	// the repositories are enumerated, with the number of repositories
+	// backfilled so far.
+	Progress func(repository string, done int)
+}
+
+// BackfillIndexesResult describes a run of BackfillIndexes.
+type BackfillIndexesResult struct {
+	// Repositories is the number of repositories backfilled by the run.
+	Repositories int
+	// Skipped is the number of repositories backfilled by a previous run.
+	Skipped int
+	// Manifests is the number of manifests read.
+	Manifests int
+}
+
+// BackfillIndexes records the manifests pushed before the referrers index
+// and the index of the manifests of indexes were maintained in both indexes,
+// reading the subject and the manifests listed by every revision.
+//
+// Each repository is marked once backfilled: a later run skips it, so that an
+// interrupted run resumes with the repositories left, and the deletion of
+// untagged manifests only trusts the indexes of the marked repositories.
+// Manifests pushed since the indexes are maintained are indexed as they are
+// pushed, so BackfillIndexes may run while the registry serves pushes, once
+// every instance of the registry maintains the indexes.
+func BackfillIndexes(ctx context.Context, storageDriver driver.StorageDriver, opts BackfillIndexesOpts) (BackfillIndexesResult, error) {
+	var result BackfillIndexesResult
+	reg := &registry{blobStore: &blobStore{driver: storageDriver}}
+	limiter := rate.NewLimiter(rate.Inf, 1)
+	if opts.Rate > 0 {
+		limiter = rate.NewLimiter(rate.Limit(opts.Rate), 1)
+	}
+
+	type repositoryResult struct {
+		skipped   bool
+		manifests int
+	}
+	err := forEachRepository(ctx, reg, 1, func(ctx context.Context, name string) (repositoryResult, error) {
+		manifests, skipped, err := backfillRepositoryIndexes(ctx, storageDriver, name, limiter)
+		return repositoryResult{skipped: skipped, manifests: manifests}, err
+	}, func(name string, r repositoryResult) {
+		result.Manifests += r.manifests
+		if r.skipped {
+			result.Skipped++
+			return
+		}
+		result.Repositories++
+		if opts.Progress != nil {
+			opts.Progress(name, result.Repositories)
+		}
+	})
+	if errors.As(err, new(driver.PathNotFoundError)) {
+		// no repositories
+		err = nil
+	}
+	return result, err
+}
+
+// backfillRepositoryIndexes records the revisions of repository name in the
+// referrers index and the index of the manifests of indexes, then marks the
+// repository as backfilled. It returns the number of manifests read, and
+// whether the repository was skipped as already backfilled.
+func backfillRepositoryIndexes(ctx context.Context, storageDriver driver.StorageDriver, name string, limiter *rate.Limiter) (int, bool, error) {
+	ctx = driver.WithRepository(ctx, name)
+	if indexed, err := manifestsIndexed(ctx, storageDriver, name); indexed || err != nil {
+		return 0, indexed, err
+	}
+
+	revisions, err := revisionDigests(ctx, storageDriver, name)
+	if err != nil {
+		return 0, false, err
+	}
+
+	var manifests int
+	for _, dgst := range revisions {
+		if err := limiter.Wait(ctx); err != nil {
+			return manifests, false, err
+		}
+		linkPaths, err := revisionIndexLinkPaths(ctx, storageDriver, name, dgst)
+		if err != nil {
+			return manifests, false, err
+		}
+		manifests++
+		for _, linkPath := range linkPaths {
+			if err := storageDriver.PutContent(ctx, linkPath, []byte(dgst)); err != nil {
+				return manifests, false, err
+			}
+		}
+	}
+
+	markerPath, err := pathFor(manifestsIndexedPathSpec{name: name})
+	if err != nil {
+		return manifests, false, err
+	}
+	if err := storageDriver.PutContent(ctx, markerPath, []byte(time.Now().UTC().Format(time.RFC3339))); err != nil {
+		return manifests, false, err
+	}
+	dcontext.GetLoggerWithFields(ctx, map[any]any{"repository": name, "manifests": manifests}, "repository", "manifests").Info("backfill: indexed the manifests of the repository")
+	return manifests, false, nil
+}
+
+// revisionIndexLinkPaths returns the paths of the links recording the
+// revision dgst of repository name in the referrers index and the index of
+// the manifests of indexes. Revisions whose blob is missing have none.
+func revisionIndexLinkPaths(ctx context.Context, storageDriver driver.StorageDriver, name string, dgst digest.Digest) ([]string, error) {
+	blobPath, err := pathFor(blobDataPathSpec{digest: dgst})
+	if err != nil {
+		return nil, err
+	}
+	payload, err := storageDriver.GetContent(ctx, blobPath)
+	if err != nil {
+		if errors.As(err, new(driver.PathNotFoundError)) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	linkPaths, err := indexLinkPaths(name, dgst, payload)
+	if err != nil {
+		return nil, err
+	}
+	referrerPath, err := referrerLinkPath(name, dgst, payload)
+	if err != nil {
+		return nil, err
+	}
+	if referrerPath != "" {
+		linkPaths = append(linkPaths, referrerPath)
+	}
+	return linkPaths, nil
+}
+
+// manifestsIndexed reports whether the manifests of repository name were
+// backfilled into the referrers index and the index of the manifests of
+// indexes.
+func manifestsIndexed(ctx context.Context, storageDriver driver.StorageDriver, name string) (bool, error) {
+	markerPath, err := pathFor(manifestsIndexedPathSpec{name: name})
+	if err != nil {
+		return false, err
+	}
+	_, err = storageDriver.Stat(ctx, markerPath)
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.As(err, new(driver.PathNotFoundError)):
+		return false, nil
+	default:
+		return false, err
+	}
+}
@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestBackfillIndexes(t *testing.T) {
+	ctx := context.Background()
+	d := inmemory.New()
+	repo := makeRepository(t, createRegistry(t, d), "a/b")
+	ms := makeManifestService(t, repo)
+	checker := repo.(distribution.ReferrerChecker)
+
+	subject := uploadRandomOCIImage(t, repo)
+	other := uploadRandomOCIImage(t, repo)
+	putReferrer(t, ms, other, subject.manifestDigest, "application/vnd.example.finding")
+	_, payload, err := subject.manifest.Payload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, err := ocischema.FromDescriptors([]v1.Descriptor{{MediaType: v1.MediaTypeImageManifest, Digest: subject.manifestDigest, Size: int64(len(payload))}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexDigest, err := ms.Put(ctx, index)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the manifests were pushed before the indexes were maintained
+	for _, spec := range []pathSpec{
+		manifestReferrersPathSpec{name: "a/b", subject: subject.manifestDigest},
+		manifestIndexesPathSpec{name: "a/b", manifest: subject.manifestDigest},
+	} {
+		p, err := pathFor(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := d.Delete(ctx, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if found, err := checker.HasReferrer(ctx, subject.manifestDigest, "application/vnd.example.finding"); err != nil || found {
+		t.Fatalf("unexpected referrer before the backfill: %v, %v", found, err)
+	}
+
+	var progress []string
+	result, err := BackfillIndexes(ctx, d, BackfillIndexesOpts{
+		Progress: func(repository string, done int) { progress = append(progress, repository) },
+	})
+	if err != nil {
+		t.Fatalf("unexpected error backfilling the indexes: %v", err)
+	}
+	if result.Repositories != 1 || result.Skipped != 0 || result.Manifests != 4 {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+	if len(progress) != 1 || progress[0] != "a/b" {
+		t.Fatalf("unexpected progress: %v", progress)
+	}
+
+	if found, err := checker.HasReferrer(ctx, subject.manifestDigest, "application/vnd.example.finding"); err != nil || !found {
+		t.Fatalf("expected the referrer to be backfilled: %v, %v", found, err)
+	}
+	linkPath, err := pathFor(manifestIndexLinkPathSpec{name: "a/b", manifest: subject.manifestDigest, index: indexDigest})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content, err := d.GetContent(ctx, linkPath); err != nil || string(content) != indexDigest.String() {
+		t.Fatalf("expected the index to be backfilled: %q, %v", content, err)
+	}
+	if indexed, err := manifestsIndexed(ctx, d, "a/b"); err != nil || !indexed {
+		t.Fatalf("expected the repository to be marked as backfilled: %v, %v", indexed, err)
+	}
+
+	// repositories backfilled by a previous run are skipped
+	result, err = BackfillIndexes(ctx, d, BackfillIndexesOpts{Rate: 10})
+	if err != nil {
+		t.Fatalf("unexpected error backfilling the indexes again: %v", err)
+	}
+	if result.Repositories != 0 || result.Skipped != 1 || result.Manifests != 0 {
+		t.Fatalf("unexpected result of the second run: %+v", result)
+	}
+}
@@ -0,0 +1,238 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/manifest/schema2"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Media types of uncompressed and zstd compressed layers of schema2
+// manifests, as understood by container runtimes.
+const (
+	mediaTypeSchema2LayerUncompressed = "application/vnd.docker.image.rootfs.diff.tar"
+	mediaTypeSchema2LayerZstd         = "application/vnd.docker.image.rootfs.diff.tar.zstd"
+)
+
+// tarMagic is the magic of the headers of POSIX tar archives, found at
+// offset tarMagicOffset.
+var tarMagic = []byte("ustar")
+
+const tarMagicOffset = 257
+
+// layerCompression is the compression of the content of a layer, as sniffed
+// from its leading bytes.
+type layerCompression int
+
+const (
+	layerUnknown layerCompression = iota
+	layerUncompressed
+	layerGzip
+	layerZstd
+)
+
+func (c layerCompression) String() string {
+	switch c {
+	case layerUncompressed:
+		return "tar"
+	case layerGzip:
+		return "gzip"
+	case layerZstd:
+		return "zstd"
+	default:
+		return "unknown"
+	}
+}
+
+// sniffLayerCompression returns the compression of the layer starting with
+// header.
+func sniffLayerCompression(header []byte) layerCompression {
+	switch {
+	case bytes.HasPrefix(header, gzipMagic):
+		return layerGzip
+	case bytes.HasPrefix(header, zstdMagic):
+		return layerZstd
+	case len(header) >= tarMagicOffset+len(tarMagic) && bytes.Equal(header[tarMagicOffset:tarMagicOffset+len(tarMagic)], tarMagic):
+		return layerUncompressed
+	default:
+		return layerUnknown
+	}
+}
+
+// isAmbiguousLayerMediaType reports whether mediaType leaves the format of
+// a layer unspecified.
+func isAmbiguousLayerMediaType(mediaType string) bool {
+	return mediaType == "" || mediaType == "application/octet-stream"
+}
+
+// layerMediaTypeCorrection corrects the media types of the layers of the
+// manifests pushed to some repositories.
+type layerMediaTypeCorrection struct {
+	enabled bool
+	// repositories are the names of the repositories, and of the
+	// namespaces of repositories, the correction applies to. It applies to
+	// all repositories if empty.
+	repositories []string
+}
+
+// appliesTo reports whether the correction applies to the repository name.
+func (c layerMediaTypeCorrection) appliesTo(name string) bool {
+	if !c.enabled {
+		return false
+	}
+	if len(c.repositories) == 0 {
+		return true
+	}
+	for _, repository := range c.repositories {
+		if name == repository || strings.HasPrefix(name, repository+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// CorrectLayerMediaTypes is a functional option for NewRegistry. When image
+// manifests are pushed by tag to the given repositories, or to any
+// repository if none is given, it sniffs the content of the layers
+// referenced with a missing or ambiguous media type, and sets the media type
+// matching the content: a gzip or zstd compressed, or an uncompressed tar
+// archive. The corrected manifests are stored in place of the pushed ones,
+// under another digest. Manifests pushed by digest are stored as pushed, as
+// the digest they are referenced with must not change.
+func CorrectLayerMediaTypes(repositories []string) RegistryOption {
+	return func(registry *registry) error {
+		registry.layerMediaTypeCorrection = layerMediaTypeCorrection{enabled: true, repositories: repositories}
+		return nil
+	}
+}
+
+// isTagPut reports whether the options of a manifest put tag the manifest.
+func isTagPut(options []distribution.ManifestServiceOption) bool {
+	for _, option := range options {
+		if _, ok := option.(distribution.WithTagOption); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// correctLayerMediaTypes returns manifest with the media types of its layers
+// corrected, or manifest itself if no media type is corrected.
+func (ms *manifestStore) correctLayerMediaTypes(ctx context.Context, manifest distribution.Manifest) (distribution.Manifest, error) {
+	var (
+		layers    []v1.Descriptor
+		rebuild   func(layers []v1.Descriptor) (distribution.Manifest, error)
+		mediaType func(layerCompression) string
+	)
+	switch m := manifest.(type) {
+	case *ocischema.DeserializedManifest:
+		layers = m.Layers
+		rebuild = func(layers []v1.Descriptor) (distribution.Manifest, error) {
+			mfst := m.Manifest
+			mfst.Layers = layers
+			return ocischema.FromStruct(mfst)
+		}
+		mediaType = func(c layerCompression) string {
+			switch c {
+			case layerGzip:
+				return v1.MediaTypeImageLayerGzip
+			case layerZstd:
+				return v1.MediaTypeImageLayerZstd
+			default:
+				return v1.MediaTypeImageLayer
+			}
+		}
+	case *schema2.DeserializedManifest:
+		layers = m.Layers
+		rebuild = func(layers []v1.Descriptor) (distribution.Manifest, error) {
+			mfst := m.Manifest
+			mfst.Layers = layers
+			return schema2.FromStruct(mfst)
+		}
+		mediaType = func(c layerCompression) string {
+			switch c {
+			case layerGzip:
+				return schema2.MediaTypeLayer
+			case layerZstd:
+				return mediaTypeSchema2LayerZstd
+			default:
+				return mediaTypeSchema2LayerUncompressed
+			}
+		}
+	default:
+		return manifest, nil
+	}
+
+	var corrected []v1.Descriptor
+	for i, layer := range layers {
+		if !isAmbiguousLayerMediaType(layer.MediaType) {
+			continue
+		}
+		compression := ms.sniffLayer(ctx, layer.Digest)
+		if compression == layerUnknown {
+			continue
+		}
+		if corrected == nil {
+			corrected = slices.Clone(layers)
+		}
+		corrected[i].MediaType = mediaType(compression)
+		dcontext.GetLoggerWithFields(ctx, map[any]any{
+			"layer":       layer.Digest,
+			"compression": compression,
+			"previous":    layer.MediaType,
+			"mediatype":   corrected[i].MediaType,
+		}).Warn("correcting the media type of a layer of a pushed manifest")
+	}
+	if corrected == nil {
+		return manifest, nil
+	}
+
+	rebuilt, err := rebuild(corrected)
+	if err != nil {
+		return nil, err
+	}
+	_, payload, err := manifest.Payload()
+	if err != nil {
+		return nil, err
+	}
+	_, correctedPayload, err := rebuilt.Payload()
+	if err != nil {
+		return nil, err
+	}
+	dcontext.GetLoggerWithFields(ctx, map[any]any{
+		"repository": ms.repository.Named().Name(),
+		"pushed":     digest.FromBytes(payload),
+		"stored":     digest.FromBytes(correctedPayload),
+	}).Warn("storing a manifest with corrected layer media types")
+	return rebuilt, nil
+}
+
+// sniffLayer returns the compression of the layer dgst of the repository,
+// or layerUnknown if the layer cannot be read. Layers which are not found
+// are left for the verification of the manifest to report.
+func (ms *manifestStore) sniffLayer(ctx context.Context, dgst digest.Digest) layerCompression {
+	rc, err := ms.repository.Blobs(ctx).Open(ctx, dgst)
+	if err != nil {
+		if err != distribution.ErrBlobUnknown {
+			dcontext.GetLogger(ctx).WithError(err).Warnf("failed to open layer %s to sniff its media type", dgst)
+		}
+		return layerUnknown
+	}
+	defer rc.Close()
+
+	header := make([]byte, tarMagicOffset+len(tarMagic))
+	n, err := io.ReadFull(rc, header)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		dcontext.GetLogger(ctx).WithError(err).Warnf("failed to read layer %s to sniff its media type", dgst)
+		return layerUnknown
+	}
+	return sniffLayerCompression(header[:n])
+}
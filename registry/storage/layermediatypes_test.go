@@ -0,0 +1,162 @@
+package storage
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/manifest/schema2"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func tarBytes(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	content := []byte("hello\n")
+	if err := tw.WriteHeader(&tar.Header{Name: "hello.txt", Mode: 0o644, Size: int64(len(content))}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tw.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestCorrectLayerMediaTypes(t *testing.T) {
+	ctx := context.Background()
+	layer := tarBytes(t)
+
+	for _, tc := range []struct {
+		name         string
+		content      []byte
+		mediaType    string
+		oci          string
+		schema2      string
+		repositories []string
+		byDigest     bool
+	}{
+		{name: "gzip", content: gzipBytes(t, layer), oci: v1.MediaTypeImageLayerGzip, schema2: schema2.MediaTypeLayer},
+		{name: "zstd", content: zstdBytes(t, layer), oci: v1.MediaTypeImageLayerZstd, schema2: mediaTypeSchema2LayerZstd},
+		{name: "tar", content: layer, oci: v1.MediaTypeImageLayer, schema2: mediaTypeSchema2LayerUncompressed},
+		{name: "octet-stream", content: gzipBytes(t, layer), mediaType: "application/octet-stream", oci: v1.MediaTypeImageLayerGzip, schema2: schema2.MediaTypeLayer},
+		{name: "repository", content: gzipBytes(t, layer), repositories: []string{"foo"}, oci: v1.MediaTypeImageLayerGzip, schema2: schema2.MediaTypeLayer},
+		{name: "unknown content", content: []byte("not a layer")},
+		{name: "explicit media type", content: gzipBytes(t, layer), mediaType: v1.MediaTypeImageLayer},
+		{name: "other repository", content: gzipBytes(t, layer), repositories: []string{"foo/baz", "fo"}},
+		{name: "by digest", content: gzipBytes(t, layer), byDigest: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			imageName, _ := reference.WithName("foo/bar")
+			registry, err := NewRegistry(ctx, inmemory.New(), CorrectLayerMediaTypes(tc.repositories))
+			if err != nil {
+				t.Fatalf("error creating registry: %v", err)
+			}
+			repository, err := registry.Repository(ctx, imageName)
+			if err != nil {
+				t.Fatalf("unexpected error getting repo: %v", err)
+			}
+			bs := repository.Blobs(ctx)
+			ms, err := repository.Manifests(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			configContent := []byte("{}")
+			config, err := addBlob(ctx, bs, v1.Descriptor{Digest: digest.FromBytes(configContent), Size: int64(len(configContent))}, bytes.NewReader(configContent))
+			if err != nil {
+				t.Fatal(err)
+			}
+			desc, err := addBlob(ctx, bs, v1.Descriptor{Digest: digest.FromBytes(tc.content), Size: int64(len(tc.content))}, bytes.NewReader(tc.content))
+			if err != nil {
+				t.Fatal(err)
+			}
+			layerDesc := v1.Descriptor{MediaType: tc.mediaType, Digest: desc.Digest, Size: desc.Size}
+
+			ociManifest, err := ocischema.FromStruct(ocischema.Manifest{
+				Versioned: specs.Versioned{SchemaVersion: 2},
+				MediaType: v1.MediaTypeImageManifest,
+				Config:    v1.Descriptor{MediaType: v1.MediaTypeImageConfig, Digest: config.Digest, Size: config.Size},
+				Layers:    []v1.Descriptor{layerDesc},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			schema2Manifest, err := schema2.FromStruct(schema2.Manifest{
+				Versioned: specs.Versioned{SchemaVersion: 2},
+				MediaType: schema2.MediaTypeManifest,
+				Config:    v1.Descriptor{MediaType: schema2.MediaTypeImageConfig, Digest: config.Digest, Size: config.Size},
+				Layers:    []v1.Descriptor{layerDesc},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, m := range []struct {
+				manifest distribution.Manifest
+				expected string
+			}{
+				{ociManifest, tc.oci},
+				{schema2Manifest, tc.schema2},
+			} {
+				var options []distribution.ManifestServiceOption
+				if !tc.byDigest {
+					options = append(options, distribution.WithTag("latest"))
+				}
+				dgst, err := ms.Put(ctx, m.manifest, options...)
+				if err != nil {
+					t.Fatal(err)
+				}
+				_, payload, _ := m.manifest.Payload()
+				if (dgst != digest.FromBytes(payload)) != (m.expected != "") {
+					t.Fatalf("unexpected digest of the stored manifest: %s", dgst)
+				}
+
+				stored, err := ms.Get(ctx, dgst)
+				if err != nil {
+					t.Fatal(err)
+				}
+				_, storedPayload, _ := stored.Payload()
+				if digest.FromBytes(storedPayload) != dgst {
+					t.Fatalf("stored manifest does not match its digest %s", dgst)
+				}
+				expected := m.expected
+				if expected == "" {
+					expected = tc.mediaType
+				}
+				if mediaType := stored.References()[1].MediaType; mediaType != expected {
+					t.Errorf("unexpected media type of the stored layer: %q != %q", mediaType, expected)
+				}
+			}
+		})
+	}
+}
+
+func TestSniffLayerCompression(t *testing.T) {
+	layer := tarBytes(t)
+	for _, tc := range []struct {
+		header   []byte
+		expected layerCompression
+	}{
+		{gzipBytes(t, layer), layerGzip},
+		{zstdBytes(t, layer), layerZstd},
+		{layer, layerUncompressed},
+		{layer[:tarMagicOffset+2], layerUnknown},
+		{[]byte{0x1f}, layerUnknown},
+		{nil, layerUnknown},
+	} {
+		if compression := sniffLayerCompression(tc.header); compression != tc.expected {
+			t.Errorf("unexpected compression of %x: %s != %s", tc.header[:min(len(tc.header), 8)], compression, tc.expected)
+		}
+	}
+}
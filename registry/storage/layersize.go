@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/manifest/schema2"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// layerSize rejects image manifests listing a layer larger than maxSize. The
+// validation is disabled if maxSize is zero.
+type layerSize struct {
+	maxSize int64
+}
+
+// ValidateLayerSize is a functional option for NewRegistry. It rejects image
+// manifests listing a layer larger than maxSize bytes, as stated by the layer
+// descriptors of the manifests.
+func ValidateLayerSize(maxSize int64) RegistryOption {
+	return func(registry *registry) error {
+		if maxSize <= 0 {
+			return fmt.Errorf("maximum layer size must be positive, got %d", maxSize)
+		}
+		registry.layerSize = layerSize{maxSize: maxSize}
+		return nil
+	}
+}
+
+// verify returns an error if manifest is an image manifest listing a layer
+// larger than the maximum size.
+func (l layerSize) verify(ctx context.Context, manifest distribution.Manifest) error {
+	if l.maxSize == 0 {
+		return nil
+	}
+
+	var layers []v1.Descriptor
+	switch m := manifest.(type) {
+	case *schema2.DeserializedManifest:
+		layers = m.Layers
+	case *ocischema.DeserializedManifest:
+		layers = m.Layers
+	default:
+		return nil
+	}
+
+	var errs distribution.ErrManifestVerification
+	for _, layer := range layers {
+		if layer.Size > l.maxSize {
+			errs = append(errs, distribution.ErrManifestLayerTooLarge{Digest: layer.Digest, Size: layer.Size, MaxSize: l.maxSize})
+		}
+	}
+	if len(errs) != 0 {
+		dcontext.GetLogger(ctx).Infof("rejecting manifest: %v", errs)
+		return errs
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestValidateLayerSize(t *testing.T) {
+	config := []byte(`{"architecture":"amd64","os":"linux"}`)
+	small := bytes.Repeat([]byte("s"), 16)
+	limit := bytes.Repeat([]byte("l"), 32)
+	large := bytes.Repeat([]byte("L"), 33)
+
+	for _, tc := range []struct {
+		name     string
+		schema2  bool
+		layers   [][]byte
+		tooLarge [][]byte
+	}{
+		{name: "under", layers: [][]byte{small}},
+		{name: "at", layers: [][]byte{small, limit}},
+		{name: "over", layers: [][]byte{small, large}, tooLarge: [][]byte{large}},
+		{name: "schema2 under", schema2: true, layers: [][]byte{limit, small}},
+		{name: "schema2 over", schema2: true, layers: [][]byte{large, small}, tooLarge: [][]byte{large}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := putImageManifest(t, ValidateLayerSize(int64(len(limit))), tc.schema2, "", config, tc.layers...)
+			if len(tc.tooLarge) == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error putting manifest: %v", err)
+				}
+				return
+			}
+			var verificationErrs distribution.ErrManifestVerification
+			if !errors.As(err, &verificationErrs) || len(verificationErrs) != len(tc.tooLarge) {
+				t.Fatalf("expected %d manifest verification errors, got %v", len(tc.tooLarge), err)
+			}
+			for i, content := range tc.tooLarge {
+				expected := distribution.ErrManifestLayerTooLarge{Digest: digest.FromBytes(content), Size: int64(len(content)), MaxSize: int64(len(limit))}
+				if verificationErrs[i] != expected {
+					t.Fatalf("expected %v, got %v", expected, verificationErrs[i])
+				}
+			}
+		})
+	}
+}
+
+func TestValidateLayerSizeInvalid(t *testing.T) {
+	for _, maxSize := range []int64{0, -1} {
+		if _, err := NewRegistry(context.Background(), inmemory.New(), ValidateLayerSize(maxSize)); err == nil {
+			t.Errorf("expected error for maximum layer size %d", maxSize)
+		}
+	}
+}
@@ -4,8 +4,10 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
 	"path"
+	"strings"
 	"time"
 
 	"github.com/distribution/distribution/v3"
@@ -79,13 +81,18 @@ func (lbs *linkedBlobStore) ServeBlob(ctx context.Context, w http.ResponseWriter
 		w.Header().Set("Content-Type", canonical.MediaType)
 	}
 
+	if bs, ok := lbs.blobServer.(*blobServer); ok {
+		// serve the blob found, rather than looking it up again
+		return bs.serveBlob(ctx, w, r, canonical)
+	}
 	return lbs.blobServer.ServeBlob(ctx, w, r, canonical.Digest)
 }
 
 func (lbs *linkedBlobStore) Put(ctx context.Context, mediaType string, p []byte) (v1.Descriptor, error) {
 	dgst := digest.FromBytes(p)
-	// Place the data in the blob store first.
-	desc, err := lbs.blobStore.Put(ctx, mediaType, p)
+	// Place the data in the blob store first, on the backend of the
+	// repository if repositories are stored on different backends.
+	desc, err := lbs.blobStore.Put(driver.WithRepository(ctx, lbs.repository.Named().Name()), mediaType, p)
 	if err != nil {
 		dcontext.GetLogger(ctx).Errorf("error putting into main store: %v", err)
 		return v1.Descriptor{}, err
@@ -102,6 +109,30 @@ func (lbs *linkedBlobStore) Put(ctx context.Context, mediaType string, p []byte)
 	return desc, lbs.linkBlob(ctx, desc)
 }
 
+// setMediaType records mediaType as the media type of the blob in the
+// repository. Blobs not linked in the repository are left untouched and
+// reported as unknown.
+func (lbs *linkedBlobStore) setMediaType(ctx context.Context, dgst digest.Digest, mediaType string) error {
+	desc, err := lbs.Stat(ctx, dgst) // access check
+	if err != nil {
+		return err
+	}
+	if desc.MediaType == mediaType {
+		return nil
+	}
+
+	mediaTypePath, err := pathFor(layerMediaTypePathSpec{name: lbs.repository.Named().Name(), digest: desc.Digest})
+	if err != nil {
+		return err
+	}
+	if err := lbs.driver.PutContent(ctx, mediaTypePath, []byte(mediaType)); err != nil {
+		return err
+	}
+
+	desc.MediaType = mediaType
+	return lbs.blobAccessController.SetDescriptor(ctx, desc.Digest, desc)
+}
+
 type optionFunc func(any) error
 
 func (f optionFunc) Apply(v any) error {
@@ -137,6 +168,18 @@ func (lbs *linkedBlobStore) Create(ctx context.Context, options ...distribution.
 		}
 	}
 
+	uuid := uuid.NewString()
+
+	// check the longest paths of the upload, whose digest is not known yet
+	name := lbs.repository.Named().Name()
+	longest := digest.NewDigestFromEncoded(digest.Canonical, strings.Repeat("0", digest.Canonical.Size()*2))
+	if err := checkPathLimits(name, lbs.registry.limits,
+		uploadHashStatePathSpec{name: name, id: uuid, alg: digest.Canonical, offset: math.MaxInt64},
+		layerMediaTypePathSpec{name: name, digest: longest},
+	); err != nil {
+		return nil, err
+	}
+
 	if opts.Mount.ShouldMount {
 		desc, err := lbs.mount(ctx, opts.Mount.From, opts.Mount.From.Digest(), opts.Mount.Stat)
 		if err == nil {
@@ -145,7 +188,6 @@ func (lbs *linkedBlobStore) Create(ctx context.Context, options ...distribution.
 		}
 	}
 
-	uuid := uuid.NewString()
 	startedAt := time.Now().UTC()
 
 	path, err := pathFor(uploadDataPathSpec{
@@ -198,6 +240,24 @@ func (lbs *linkedBlobStore) Resume(ctx context.Context, id string) (distribution
 		return nil, err
 	}
 
+	// the client is back, so the upload is no longer abandoned
+	abandonedAtPath, err := pathFor(uploadAbandonedAtPathSpec{
+		name: lbs.repository.Named().Name(),
+		id:   id,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := lbs.blobStore.driver.Stat(ctx, abandonedAtPath); err == nil {
+		if err := lbs.blobStore.driver.Delete(ctx, abandonedAtPath); err != nil {
+			if _, ok := err.(driver.PathNotFoundError); !ok {
+				return nil, err
+			}
+		}
+	} else if _, ok := err.(driver.PathNotFoundError); !ok {
+		return nil, err
+	}
+
 	path, err := pathFor(uploadDataPathSpec{
 		name: lbs.repository.Named().Name(),
 		id:   id,
@@ -288,6 +348,10 @@ func (lbs *linkedBlobStore) mount(ctx context.Context, sourceRepo reference.Name
 		stat = *sourceStat
 	}
 
+	if err := lbs.copyAcrossBackends(ctx, sourceRepo, dgst); err != nil {
+		return v1.Descriptor{}, err
+	}
+
 	desc := v1.Descriptor{
 		Size: stat.Size,
 
@@ -300,6 +364,60 @@ func (lbs *linkedBlobStore) mount(ctx context.Context, sourceRepo reference.Name
 	return desc, lbs.linkBlob(ctx, desc)
 }
 
+// copyAcrossBackends copies the data of the blob dgst to the backend of the
+// repository, if repositories are stored on different backends and
+// sourceRepo is stored on another one, so that each backend holds the data
+// of the blobs of its repositories.
+func (lbs *linkedBlobStore) copyAcrossBackends(ctx context.Context, sourceRepo reference.Named, dgst digest.Digest) error {
+	router := lbs.registry.router
+	if router == nil {
+		return nil
+	}
+	name := lbs.repository.Named().Name()
+	if router.Backend(sourceRepo.Name()) == router.Backend(name) {
+		return nil
+	}
+
+	blobPath, err := pathFor(blobDataPathSpec{digest: dgst})
+	if err != nil {
+		return err
+	}
+	destCtx := driver.WithRepository(ctx, name)
+	if _, err := lbs.driver.Stat(destCtx, blobPath); err == nil {
+		return nil
+	} else if _, ok := err.(driver.PathNotFoundError); !ok {
+		return err
+	}
+
+	dcontext.GetLogger(ctx).Infof("copying blob %s mounted from %s to the backend of %s", dgst, sourceRepo.Name(), name)
+	rc, err := lbs.driver.Reader(driver.WithRepository(ctx, sourceRepo.Name()), blobPath, 0)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	fw, err := lbs.driver.Writer(destCtx, blobPath, false)
+	if err != nil {
+		return err
+	}
+	verifier := dgst.Verifier()
+	if _, err := io.Copy(io.MultiWriter(fw, verifier), rc); err != nil {
+		fw.Cancel(ctx)
+		fw.Close()
+		return err
+	}
+	if !verifier.Verified() {
+		fw.Cancel(ctx)
+		fw.Close()
+		return fmt.Errorf("content of blob %s mounted from %s does not match its digest", dgst, sourceRepo.Name())
+	}
+	if err := fw.Commit(ctx); err != nil {
+		fw.Close()
+		return err
+	}
+	return fw.Close()
+}
+
 // newBlobUpload allocates a new upload controller with the given state.
 func (lbs *linkedBlobStore) newBlobUpload(ctx context.Context, uuid, path string, startedAt time.Time, append bool) (distribution.BlobWriter, error) {
 	fw, err := lbs.driver.Writer(ctx, path, append)
@@ -317,6 +435,12 @@ func (lbs *linkedBlobStore) newBlobUpload(ctx context.Context, uuid, path string
 		driver:                 lbs.driver,
 		path:                   path,
 		resumableDigestEnabled: lbs.resumableDigestEnabled,
+		preverified:            isPreverifiedDigest(ctx),
+		transfer: transferMeter{
+			driver:    lbs.driver.Name(),
+			direction: transferUpload,
+			classes:   lbs.blobStore.transferClasses,
+		},
 	}
 
 	return bw, nil
@@ -362,6 +486,13 @@ type linkedBlobStatter struct {
 	// blobs have not yet been fully merged. At some point, this functionality
 	// should be removed an the blob links folder should be merged.
 	linkPath linkPathFunc
+
+	// mediaTypesEnabled replaces the media type of the blobs with the one
+	// recorded in the repository, see setMediaType.
+	mediaTypesEnabled bool
+
+	// hints spare the lookups of the blobs whose links are found, if set.
+	hints *blobDescriptorHints
 }
 
 var _ distribution.BlobDescriptorService = &linkedBlobStatter{}
@@ -387,10 +518,37 @@ func (lbs *linkedBlobStatter) Stat(ctx context.Context, dgst digest.Digest) (v1.
 		dcontext.GetLogger(ctx).Warnf("looking up blob with canonical target: %v -> %v", dgst, target)
 	}
 
-	// TODO(stevvooe): Look up repository local mediatype and replace that on
-	// the returned descriptor.
+	// the link proves that the blob exists, its hinted descriptor spares
+	// looking it up
+	desc, hinted := lbs.hints.get(lbs.repository.Named().Name(), target)
+	if !hinted {
+		desc, err = lbs.blobStore.statter.Stat(ctx, target)
+		if err != nil {
+			return desc, err
+		}
+	}
+	if !lbs.mediaTypesEnabled {
+		return desc, nil
+	}
 
-	return lbs.blobStore.statter.Stat(ctx, target)
+	// Replace the media type with the repository local one, not trusting
+	// the one returned by the global statter, whose cache may hold the media
+	// type recorded by another repository.
+	desc.MediaType = "application/octet-stream"
+	mediaTypePath, err := pathFor(layerMediaTypePathSpec{name: lbs.repository.Named().Name(), digest: target})
+	if err != nil {
+		return v1.Descriptor{}, err
+	}
+	mediaType, err := lbs.blobStore.driver.GetContent(ctx, mediaTypePath)
+	switch err.(type) {
+	case nil:
+		desc.MediaType = string(mediaType)
+	case driver.PathNotFoundError:
+	default:
+		return v1.Descriptor{}, err
+	}
+
+	return desc, nil
 }
 
 func (lbs *linkedBlobStatter) Clear(ctx context.Context, dgst digest.Digest) (err error) {
@@ -399,7 +557,23 @@ func (lbs *linkedBlobStatter) Clear(ctx context.Context, dgst digest.Digest) (er
 		return err
 	}
 
-	return lbs.blobStore.driver.Delete(ctx, blobLinkPath)
+	if err := lbs.blobStore.driver.Delete(ctx, blobLinkPath); err != nil {
+		return err
+	}
+
+	if lbs.mediaTypesEnabled {
+		mediaTypePath, err := pathFor(layerMediaTypePathSpec{name: lbs.repository.Named().Name(), digest: dgst})
+		if err != nil {
+			return err
+		}
+		if err := lbs.blobStore.driver.Delete(ctx, mediaTypePath); err != nil {
+			if _, ok := err.(driver.PathNotFoundError); !ok {
+				return err
+			}
+		}
+	}
+
+	return nil
 }
 
 func (lbs *linkedBlobStatter) SetDescriptor(ctx context.Context, dgst digest.Digest, desc v1.Descriptor) error {
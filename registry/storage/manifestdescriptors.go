@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"context"
+	"slices"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// CacheManifestDescriptors is a functional option for NewRegistry. It caches
+// the descriptors of the manifests put or fetched, with the media type of
+// their payload, in the repository scoped blob descriptor cache, so that
+// manifests are described without reading their payload from the storage
+// driver. The descriptors are cleared from the cache along with the manifests
+// deleted, as for blobs. It has no effect without BlobDescriptorCacheProvider.
+func CacheManifestDescriptors(registry *registry) error {
+	registry.manifestDescriptorCache = true
+	return nil
+}
+
+var _ distribution.ManifestDescriber = &manifestStore{}
+
+// Describe returns the descriptor of the manifest dgst from the descriptor
+// cache of the repository if enabled, or from its payload.
+func (ms *manifestStore) Describe(ctx context.Context, dgst digest.Digest) (v1.Descriptor, error) {
+	if ms.manifestDescriptorCacheEnabled() {
+		desc, err := ms.repository.descriptorCache.Stat(ctx, dgst)
+		// the descriptor of a manifest stat'ed as a blob, rather than put
+		// or fetched, lacks the media type of its payload
+		if err == nil && slices.Contains(distribution.ManifestMediaTypes(), desc.MediaType) {
+			return desc, nil
+		}
+	}
+
+	manifest, err := ms.Get(ctx, dgst)
+	if err != nil {
+		return v1.Descriptor{}, err
+	}
+	return manifestDescriptor(dgst, manifest)
+}
+
+// manifestDescriptorCacheEnabled reports whether the descriptors of the
+// manifests are cached.
+func (ms *manifestStore) manifestDescriptorCacheEnabled() bool {
+	return ms.repository.manifestDescriptorCache && ms.repository.descriptorCache != nil
+}
+
+// cacheManifestDescriptor caches the descriptor of manifest, of digest dgst,
+// if enabled. Failures are logged, as the manifest is read from the storage
+// driver on cache misses.
+func (ms *manifestStore) cacheManifestDescriptor(ctx context.Context, dgst digest.Digest, manifest distribution.Manifest) {
+	if !ms.manifestDescriptorCacheEnabled() {
+		return
+	}
+
+	desc, err := manifestDescriptor(dgst, manifest)
+	if err == nil {
+		err = ms.repository.descriptorCache.SetDescriptor(ctx, dgst, desc)
+	}
+	if err != nil {
+		dcontext.GetLoggerWithField(ctx, "digest", dgst).Warnf("failed to cache the descriptor of manifest: %v", err)
+	}
+}
+
+// manifestDescriptor returns the descriptor of manifest, of digest dgst.
+func manifestDescriptor(dgst digest.Digest, manifest distribution.Manifest) (v1.Descriptor, error) {
+	mediaType, payload, err := manifest.Payload()
+	if err != nil {
+		return v1.Descriptor{}, err
+	}
+	return v1.Descriptor{MediaType: mediaType, Digest: dgst, Size: int64(len(payload))}, nil
+}
@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/registry/storage/cache/memory"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestCacheManifestDescriptors(t *testing.T) {
+	ctx := context.Background()
+	name, _ := reference.WithName("foo/bar")
+	driver := inmemory.New()
+
+	for _, enabled := range []bool{false, true} {
+		t.Run(map[bool]string{false: "disabled", true: "enabled"}[enabled], func(t *testing.T) {
+			options := []RegistryOption{EnableDelete, BlobDescriptorCacheProvider(memory.NewInMemoryBlobDescriptorCacheProvider(memory.UnlimitedSize))}
+			if enabled {
+				options = append(options, CacheManifestDescriptors)
+			}
+			registry, err := NewRegistry(ctx, driver, options...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			repo, err := registry.Repository(ctx, name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			descriptorCache := repo.(*repository).descriptorCache
+			ms, err := repo.Manifests(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			config := []byte("{}")
+			configDesc, err := addBlob(ctx, repo.Blobs(ctx), v1.Descriptor{Digest: digest.FromBytes(config), Size: int64(len(config))}, bytes.NewReader(config))
+			if err != nil {
+				t.Fatal(err)
+			}
+			image, err := ocischema.FromStruct(ocischema.Manifest{
+				Versioned: specs.Versioned{SchemaVersion: 2},
+				MediaType: v1.MediaTypeImageManifest,
+				Config:    v1.Descriptor{MediaType: v1.MediaTypeImageConfig, Digest: configDesc.Digest, Size: configDesc.Size},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			dgst, err := ms.Put(ctx, image)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, payload, _ := image.Payload()
+			expected := v1.Descriptor{MediaType: v1.MediaTypeImageManifest, Digest: dgst, Size: int64(len(payload))}
+
+			desc, err := ms.(distribution.ManifestDescriber).Describe(ctx, dgst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if desc.MediaType != expected.MediaType || desc.Digest != expected.Digest || desc.Size != expected.Size {
+				t.Fatalf("unexpected descriptor of manifest: %v != %v", desc, expected)
+			}
+
+			// the descriptor of the manifest is only cached with the media
+			// type of its payload if enabled
+			cached, err := descriptorCache.Stat(ctx, dgst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (cached.MediaType == v1.MediaTypeImageManifest) != enabled {
+				t.Fatalf("unexpected cached descriptor of manifest: %v", cached)
+			}
+
+			if err := ms.Delete(ctx, dgst); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := descriptorCache.Stat(ctx, dgst); err != distribution.ErrBlobUnknown {
+				t.Fatalf("expected the descriptor of the deleted manifest to be cleared, got %v", err)
+			}
+			if _, err := ms.(distribution.ManifestDescriber).Describe(ctx, dgst); err == nil {
+				t.Fatal("expected the deleted manifest to be unknown")
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
 	"github.com/distribution/distribution/v3"
@@ -97,6 +98,29 @@ func (ms *manifestListHandler) verifyManifest(ctx context.Context, mnfst distrib
 			}
 		}
 	}
+
+	// Windows hosts only run images whose os.version matches their own, so
+	// clients need it to select the image to pull
+	if ms.validateImageIndexes.windowsOSVersion && !skipDependencyVerification {
+		for _, manifestDescriptor := range mnfst.References() {
+			if p := manifestDescriptor.Platform; p != nil && p.OS == "windows" && p.OSVersion == "" {
+				errs = append(errs, distribution.ErrManifestPlatformInvalid{
+					Digest: manifestDescriptor.Digest,
+					Reason: "os.version is required for windows platforms",
+				})
+			}
+		}
+	}
+
+	// Clients trust the size and media type of the descriptors to fetch and
+	// parse the child manifests
+	if ms.validateImageIndexes.childDescriptors && !skipDependencyVerification {
+		childErrs, err := ms.verifyChildDescriptors(ctx, mnfst)
+		if err != nil {
+			return err
+		}
+		errs = append(errs, childErrs...)
+	}
 	if len(errs) != 0 {
 		return errs
 	}
@@ -104,6 +128,46 @@ func (ms *manifestListHandler) verifyManifest(ctx context.Context, mnfst distrib
 	return nil
 }
 
+// verifyChildDescriptors returns the errors of the descriptors of the
+// manifests referenced by mnfst whose size or media type do not match the
+// stored manifests. The manifests not in the repository are skipped, their
+// existence being checked by the platform validation.
+func (ms *manifestListHandler) verifyChildDescriptors(ctx context.Context, mnfst distribution.Manifest) ([]error, error) {
+	manifestService, err := ms.repository.Manifests(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	for _, descriptor := range mnfst.References() {
+		child, err := manifestService.Get(ctx, descriptor.Digest)
+		if err != nil {
+			var unknownRevision distribution.ErrManifestUnknownRevision
+			if errors.As(err, &unknownRevision) || errors.Is(err, distribution.ErrBlobUnknown) {
+				continue
+			}
+			return nil, err
+		}
+		mediaType, payload, err := child.Payload()
+		if err != nil {
+			return nil, err
+		}
+		if descriptor.Size != int64(len(payload)) {
+			errs = append(errs, distribution.ErrManifestChildDescriptorInvalid{
+				Digest: descriptor.Digest,
+				Reason: fmt.Sprintf("size %d does not match the %d bytes of the manifest", descriptor.Size, len(payload)),
+			})
+		}
+		if descriptor.MediaType != mediaType {
+			errs = append(errs, distribution.ErrManifestChildDescriptorInvalid{
+				Digest: descriptor.Digest,
+				Reason: fmt.Sprintf("media type %q does not match the media type %q of the manifest", descriptor.MediaType, mediaType),
+			})
+		}
+	}
+	return errs, nil
+}
+
 // platformMustExist checks if a descriptor within an index should be validated as existing before accepting the manifest into the registry.
 func (ms *manifestListHandler) platformMustExist(descriptor v1.Descriptor) bool {
 	// If there are no image platforms configured to validate, we must check the existence of all child images.
@@ -88,6 +88,18 @@ func (ms *manifestStore) Get(ctx context.Context, dgst digest.Digest, options ..
 		return nil, err
 	}
 
+	manifest, err := ms.unmarshal(ctx, dgst, content)
+	if err != nil {
+		return nil, err
+	}
+	ms.cacheManifestDescriptor(ctx, dgst, manifest)
+	ms.prefetchBlobDescriptors(ctx, manifest)
+	return manifest, nil
+}
+
+// unmarshal unmarshals the manifest content of digest dgst with the handler
+// of its schema.
+func (ms *manifestStore) unmarshal(ctx context.Context, dgst digest.Digest, content []byte) (distribution.Manifest, error) {
 	// versioned is a minimal representation of a manifest with version and mediatype.
 	var versioned struct {
 		specs.Versioned
@@ -95,7 +107,7 @@ func (ms *manifestStore) Get(ctx context.Context, dgst digest.Digest, options ..
 		// MediaType is the media type of this schema.
 		MediaType string `json:"mediaType,omitempty"`
 	}
-	if err = json.Unmarshal(content, &versioned); err != nil {
+	if err := json.Unmarshal(content, &versioned); err != nil {
 		return nil, err
 	}
 
@@ -134,24 +146,124 @@ func (ms *manifestStore) Get(ctx context.Context, dgst digest.Digest, options ..
 func (ms *manifestStore) Put(ctx context.Context, manifest distribution.Manifest, options ...distribution.ManifestServiceOption) (digest.Digest, error) {
 	dcontext.GetLogger(ms.ctx).Debug("(*manifestStore).Put")
 
+	var handler ManifestHandler
 	switch manifest.(type) {
 	case *schema2.DeserializedManifest:
-		return ms.schema2Handler.Put(ctx, manifest, ms.skipDependencyVerification)
+		handler = ms.schema2Handler
 	case *ocischema.DeserializedManifest:
-		return ms.ocischemaHandler.Put(ctx, manifest, ms.skipDependencyVerification)
+		handler = ms.ocischemaHandler
 	case *manifestlist.DeserializedManifestList:
-		return ms.manifestListHandler.Put(ctx, manifest, ms.skipDependencyVerification)
+		handler = ms.manifestListHandler
 	case *ocischema.DeserializedImageIndex:
-		return ms.ocischemaIndexHandler.Put(ctx, manifest, ms.skipDependencyVerification)
+		handler = ms.ocischemaIndexHandler
+	default:
+		return "", fmt.Errorf("unrecognized manifest type %T", manifest)
+	}
+
+	// manifests stored without verification were not pushed by clients
+	if !ms.skipDependencyVerification {
+		if err := ms.repository.registry.canonicalManifests.verify(ctx, manifest); err != nil {
+			return "", err
+		}
+		if err := ms.repository.registry.uniqueLayers.verify(ctx, manifest); err != nil {
+			return "", err
+		}
+		if err := ms.repository.registry.layerSize.verify(ctx, manifest); err != nil {
+			return "", err
+		}
+	}
+
+	if isTagPut(options) && ms.repository.registry.layerMediaTypeCorrection.appliesTo(ms.repository.Named().Name()) {
+		corrected, err := ms.correctLayerMediaTypes(ctx, manifest)
+		if err != nil {
+			return "", err
+		}
+		manifest = corrected
+	}
+
+	if err := ms.checkPathLimits(manifest, options); err != nil {
+		return "", err
+	}
+
+	dgst, err := handler.Put(ctx, manifest, ms.skipDependencyVerification)
+	if err != nil {
+		return "", err
+	}
+	if err := ms.indexReferrer(ctx, dgst, manifest); err != nil {
+		return "", err
+	}
+	if err := ms.indexManifests(ctx, dgst, manifest); err != nil {
+		return "", err
 	}
 
-	return "", fmt.Errorf("unrecognized manifest type %T", manifest)
+	if ms.repository.registry.blobMediaTypesEnabled {
+		ms.setBlobMediaTypes(ctx, manifest)
+	}
+	if ms.repository.registry.artifactTypesEnabled {
+		ms.setArtifactType(ctx, dgst, manifest)
+	}
+	ms.cacheManifestDescriptor(ctx, dgst, manifest)
+
+	return dgst, nil
+}
+
+// checkPathLimits checks the paths linking manifest, and tagging it if put
+// with a tag, against the limits of the storage driver.
+func (ms *manifestStore) checkPathLimits(manifest distribution.Manifest, options []distribution.ManifestServiceOption) error {
+	_, payload, err := manifest.Payload()
+	if err != nil {
+		return err
+	}
+	name := ms.repository.Named().Name()
+	revision := digest.FromBytes(payload)
+	specs := []pathSpec{manifestRevisionLinkPathSpec{name: name, revision: revision}}
+	for _, option := range options {
+		if option, ok := option.(distribution.WithTagOption); ok {
+			specs = append(specs, manifestTagIndexEntryLinkPathSpec{name: name, tag: option.Tag, revision: revision})
+		}
+	}
+	return checkPathLimits(name, ms.repository.registry.limits, specs...)
+}
+
+// setBlobMediaTypes records the media types the blobs of the repository are
+// referenced with by manifest. Failures are logged rather than failing the
+// put, as the media types only serve as hints.
+func (ms *manifestStore) setBlobMediaTypes(ctx context.Context, manifest distribution.Manifest) {
+	blobs, ok := ms.repository.Blobs(ctx).(*linkedBlobStore)
+	if !ok {
+		return
+	}
+
+	for _, desc := range manifest.References() {
+		if desc.MediaType == "" {
+			continue
+		}
+		// references to manifests or to blobs not pushed to the repository
+		// are reported as unknown
+		if err := blobs.setMediaType(ctx, desc.Digest, desc.MediaType); err != nil && err != distribution.ErrBlobUnknown {
+			dcontext.GetLoggerWithField(ctx, "digest", desc.Digest).Errorf("error recording blob media type: %v", err)
+		}
+	}
 }
 
 // Delete removes the revision of the specified manifest.
 func (ms *manifestStore) Delete(ctx context.Context, dgst digest.Digest) error {
 	dcontext.GetLogger(ms.ctx).Debug("(*manifestStore).Delete")
-	return ms.blobStore.Delete(ctx, dgst)
+	var mediaType string
+	if ms.repository.registry.artifactTypesEnabled {
+		// the manifest can no longer be read once deleted
+		if manifest, err := ms.Get(ctx, dgst); err == nil {
+			mediaType = artifactType(manifest)
+		}
+	}
+	if err := ms.blobStore.Delete(ctx, dgst); err != nil {
+		return err
+	}
+	if mediaType != "" {
+		ms.unsetArtifactType(ctx, dgst, mediaType)
+	}
+	ms.repository.pruned(ctx)
+	return nil
 }
 
 func (ms *manifestStore) Enumerate(ctx context.Context, ingester func(digest.Digest) error) error {
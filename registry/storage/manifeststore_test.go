@@ -591,6 +591,168 @@ func TestIndexManifestStorageWithSelectivePlatforms(t *testing.T) {
 	}
 }
 
+func TestIndexManifestStorageWithWindowsOSVersion(t *testing.T) {
+	imageMediaType := v1.MediaTypeImageManifest
+	indexMediaType := v1.MediaTypeImageIndex
+
+	repoName, _ := reference.WithName("foo/bar")
+	env := newManifestStoreTestEnv(t, repoName, "thetag",
+		BlobDescriptorCacheProvider(memory.NewInMemoryBlobDescriptorCacheProvider(memory.UnlimitedSize)),
+		EnableDelete, EnableRedirect, EnableValidateImageIndexWindowsOSVersion)
+
+	ctx := context.Background()
+	ms, err := env.repository.Manifests(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blobStore := env.repository.Blobs(ctx)
+	windowsManifest, err := createRandomImage(t, t.Name(), imageMediaType, blobStore)
+	if err != nil {
+		t.Fatalf("%s: unexpected error generating random image: %v", t.Name(), err)
+	}
+	linuxManifest, err := createRandomImage(t, t.Name(), imageMediaType, blobStore)
+	if err != nil {
+		t.Fatalf("%s: unexpected error generating random image: %v", t.Name(), err)
+	}
+
+	linuxPlatformSpec := &v1.Platform{
+		Architecture: "amd64",
+		OS:           "linux",
+	}
+
+	for _, tc := range []struct {
+		name      string
+		osVersion string
+		valid     bool
+	}{
+		{name: "without os.version", valid: false},
+		{name: "with os.version", osVersion: "10.0.20348.2340", valid: true},
+	} {
+		windowsPlatformSpec := &v1.Platform{
+			Architecture: "amd64",
+			OS:           "windows",
+			OSVersion:    tc.osVersion,
+		}
+
+		imageIndex, err := ociIndexFromDesriptorsWithMediaType([]v1.Descriptor{
+			createOciManifestDescriptor(t, t.Name(), windowsManifest, windowsPlatformSpec),
+			createOciManifestDescriptor(t, t.Name(), linuxManifest, linuxPlatformSpec),
+		}, indexMediaType)
+		if err != nil {
+			t.Fatalf("unexpected error creating image index: %v", err)
+		}
+
+		list, err := manifestlist.FromDescriptors([]manifestlist.ManifestDescriptor{
+			createManifestListDescriptor(t, t.Name(), windowsManifest, &manifestlist.PlatformSpec{
+				Architecture: windowsPlatformSpec.Architecture,
+				OS:           windowsPlatformSpec.OS,
+				OSVersion:    windowsPlatformSpec.OSVersion,
+			}),
+		})
+		if err != nil {
+			t.Fatalf("unexpected error creating manifest list: %v", err)
+		}
+
+		for _, index := range []distribution.Manifest{imageIndex, list} {
+			_, err = ms.Put(ctx, index)
+			if tc.valid {
+				if err != nil {
+					t.Fatalf("%s: unexpected error putting index: %v", tc.name, err)
+				}
+				continue
+			}
+
+			verificationErrs, ok := err.(distribution.ErrManifestVerification)
+			if !ok || len(verificationErrs) != 1 {
+				t.Fatalf("%s: expected a single verification error, got %v", tc.name, err)
+			}
+			platformErr, ok := verificationErrs[0].(distribution.ErrManifestPlatformInvalid)
+			if !ok {
+				t.Fatalf("%s: expected invalid platform error, got %v", tc.name, verificationErrs[0])
+			}
+			if platformErr.Digest != index.References()[0].Digest {
+				t.Fatalf("%s: expected invalid platform error for %s, got %s", tc.name, index.References()[0].Digest, platformErr.Digest)
+			}
+		}
+	}
+}
+
+func TestIndexManifestStorageWithChildDescriptors(t *testing.T) {
+	imageMediaType := v1.MediaTypeImageManifest
+	indexMediaType := v1.MediaTypeImageIndex
+
+	repoName, _ := reference.WithName("foo/bar")
+	env := newManifestStoreTestEnv(t, repoName, "thetag",
+		BlobDescriptorCacheProvider(memory.NewInMemoryBlobDescriptorCacheProvider(memory.UnlimitedSize)),
+		EnableDelete, EnableRedirect, EnableValidateImageIndexChildDescriptors)
+
+	ctx := context.Background()
+	ms, err := env.repository.Manifests(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blobStore := env.repository.Blobs(ctx)
+	storedManifest, err := createRandomImage(t, t.Name(), imageMediaType, blobStore)
+	if err != nil {
+		t.Fatalf("%s: unexpected error generating random image: %v", t.Name(), err)
+	}
+	if _, err := ms.Put(ctx, storedManifest); err != nil {
+		t.Fatalf("unexpected error putting manifest: %v", err)
+	}
+	// never pushed, so skipped
+	missingManifest, err := createRandomImage(t, t.Name(), imageMediaType, blobStore)
+	if err != nil {
+		t.Fatalf("%s: unexpected error generating random image: %v", t.Name(), err)
+	}
+
+	platformSpec := &v1.Platform{
+		Architecture: "amd64",
+		OS:           "linux",
+	}
+
+	for _, tc := range []struct {
+		name   string
+		modify func(descriptor *v1.Descriptor)
+		valid  bool
+	}{
+		{name: "matching", modify: func(*v1.Descriptor) {}, valid: true},
+		{name: "size mismatch", modify: func(descriptor *v1.Descriptor) { descriptor.Size++ }},
+		{name: "media type mismatch", modify: func(descriptor *v1.Descriptor) { descriptor.MediaType = v1.MediaTypeImageIndex }},
+	} {
+		storedDescriptor := createOciManifestDescriptor(t, t.Name(), storedManifest, platformSpec)
+		tc.modify(&storedDescriptor)
+		missingDescriptor := createOciManifestDescriptor(t, t.Name(), missingManifest, platformSpec)
+		missingDescriptor.Size++
+
+		imageIndex, err := ociIndexFromDesriptorsWithMediaType([]v1.Descriptor{storedDescriptor, missingDescriptor}, indexMediaType)
+		if err != nil {
+			t.Fatalf("unexpected error creating image index: %v", err)
+		}
+
+		_, err = ms.Put(ctx, imageIndex)
+		if tc.valid {
+			if err != nil {
+				t.Fatalf("%s: unexpected error putting index: %v", tc.name, err)
+			}
+			continue
+		}
+
+		verificationErrs, ok := err.(distribution.ErrManifestVerification)
+		if !ok || len(verificationErrs) != 1 {
+			t.Fatalf("%s: expected a single verification error, got %v", tc.name, err)
+		}
+		descriptorErr, ok := verificationErrs[0].(distribution.ErrManifestChildDescriptorInvalid)
+		if !ok {
+			t.Fatalf("%s: expected invalid descriptor error, got %v", tc.name, verificationErrs[0])
+		}
+		if descriptorErr.Digest != storedDescriptor.Digest {
+			t.Fatalf("%s: expected invalid descriptor error for %s, got %s", tc.name, storedDescriptor.Digest, descriptorErr.Digest)
+		}
+	}
+}
+
 // createRandomImage builds an image manifest and store it and its layers in the registry
 func createRandomImage(t *testing.T, testname string, imageMediaType string, blobStore distribution.BlobStore) (distribution.Manifest, error) {
 	builder := ocischema.NewManifestBuilder(blobStore, []byte{}, map[string]string{})
@@ -644,6 +806,7 @@ func createOciManifestDescriptor(t *testing.T, testname string, manifest distrib
 		Platform: &v1.Platform{
 			Architecture: platformSpec.Architecture,
 			OS:           platformSpec.OS,
+			OSVersion:    platformSpec.OSVersion,
 		},
 	}
 }
@@ -665,10 +828,84 @@ func createManifestListDescriptor(t *testing.T, testname string, manifest distri
 		Platform: manifestlist.PlatformSpec{
 			Architecture: platformSpec.Architecture,
 			OS:           platformSpec.OS,
+			OSVersion:    platformSpec.OSVersion,
 		},
 	}
 }
 
+// putImageManifest stores config and layers in a repository of a new registry
+// created with option, then puts an image manifest referencing them, of schema2
+// if useSchema2 and OCI otherwise. An empty configMediaType selects the image
+// config media type of the schema. It returns the error of the put.
+func putImageManifest(t *testing.T, option RegistryOption, useSchema2 bool, configMediaType string, config []byte, layers ...[]byte) error {
+	t.Helper()
+	ctx := context.Background()
+	registry, err := NewRegistry(ctx, inmemory.New(), option)
+	if err != nil {
+		t.Fatalf("error creating registry: %v", err)
+	}
+	name, _ := reference.WithName("foo/bar")
+	repo, err := registry.Repository(ctx, name)
+	if err != nil {
+		t.Fatalf("unexpected error getting repo: %v", err)
+	}
+
+	bs := repo.Blobs(ctx)
+	stored := make(map[digest.Digest]bool)
+	for _, content := range append([][]byte{config}, layers...) {
+		dgst := digest.FromBytes(content)
+		if stored[dgst] {
+			continue
+		}
+		if _, err := addBlob(ctx, bs, v1.Descriptor{Digest: dgst, Size: int64(len(content))}, bytes.NewReader(content)); err != nil {
+			t.Fatalf("error adding blob: %v", err)
+		}
+		stored[dgst] = true
+	}
+	descriptor := func(mediaType string, content []byte) v1.Descriptor {
+		return v1.Descriptor{MediaType: mediaType, Digest: digest.FromBytes(content), Size: int64(len(content))}
+	}
+
+	var manifest distribution.Manifest
+	if useSchema2 {
+		if configMediaType == "" {
+			configMediaType = schema2.MediaTypeImageConfig
+		}
+		m := schema2.Manifest{
+			Versioned: specs.Versioned{SchemaVersion: 2},
+			MediaType: schema2.MediaTypeManifest,
+			Config:    descriptor(configMediaType, config),
+		}
+		for _, content := range layers {
+			m.Layers = append(m.Layers, descriptor(schema2.MediaTypeLayer, content))
+		}
+		manifest, err = schema2.FromStruct(m)
+	} else {
+		if configMediaType == "" {
+			configMediaType = v1.MediaTypeImageConfig
+		}
+		m := ocischema.Manifest{
+			Versioned: specs.Versioned{SchemaVersion: 2},
+			MediaType: v1.MediaTypeImageManifest,
+			Config:    descriptor(configMediaType, config),
+		}
+		for _, content := range layers {
+			m.Layers = append(m.Layers, descriptor(v1.MediaTypeImageLayer, content))
+		}
+		manifest, err = ocischema.FromStruct(m)
+	}
+	if err != nil {
+		t.Fatalf("error building manifest: %v", err)
+	}
+	ms, err := repo.Manifests(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ms.Put(ctx, manifest)
+	return err
+}
+
 // TestLinkPathFuncs ensures that the link path functions behavior are locked
 // down and implemented as expected.
 func TestLinkPathFuncs(t *testing.T) {
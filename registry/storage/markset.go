@@ -0,0 +1,279 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"github.com/opencontainers/go-digest"
+)
+
+// maxMarkRuns is the number of files a mark set is written to before they are
+// merged, to bound the number of files looked up.
+const maxMarkRuns = 8
+
+// markSet is a set of digests safe for concurrent use. Once it holds limit
+// digests in memory, they are written to a sorted file in dir, in which they
+// are then looked up, so that the memory used by the set is bounded.
+type markSet struct {
+	mu    sync.Mutex
+	mem   map[digest.Digest]struct{}
+	limit int
+	dir   string
+	runs  []*markRun
+	// n is the number of digests in the set.
+	n int
+	// seq numbers the files written.
+	seq int
+}
+
+// markRun is a file of sorted digests, in records of width bytes padded with
+// spaces.
+type markRun struct {
+	f     *os.File
+	width int
+	n     int64
+}
+
+// newMarkSet returns a set holding at most limit digests in memory, writing
+// the others to files in a directory created in dir, or in the default
+// directory for temporary files if empty. The set is held in memory only if
+// limit is not positive.
+func newMarkSet(limit int, dir string) *markSet {
+	return &markSet{
+		mem:   make(map[digest.Digest]struct{}),
+		limit: limit,
+		dir:   dir,
+	}
+}
+
+// add adds dgst to the set, and reports whether it was not in the set.
+func (s *markSet) add(dgst digest.Digest) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	found, err := s.containsLocked(dgst)
+	if found || err != nil {
+		return false, err
+	}
+	s.mem[dgst] = struct{}{}
+	s.n++
+	if s.limit > 0 && len(s.mem) >= s.limit {
+		if err := s.spill(); err != nil {
+			return true, err
+		}
+	}
+	return true, nil
+}
+
+// len returns the number of digests in the set.
+func (s *markSet) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.n
+}
+
+// contains reports whether dgst is in the set.
+func (s *markSet) contains(dgst digest.Digest) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.containsLocked(dgst)
+}
+
+func (s *markSet) containsLocked(dgst digest.Digest) (bool, error) {
+	if _, ok := s.mem[dgst]; ok {
+		return true, nil
+	}
+	for _, run := range s.runs {
+		found, err := run.contains(dgst)
+		if found || err != nil {
+			return found, err
+		}
+	}
+	return false, nil
+}
+
+// spill writes the digests held in memory to a new file.
+func (s *markSet) spill() error {
+	if s.seq == 0 {
+		dir, err := os.MkdirTemp(s.dir, "registry-gc-marks-")
+		if err != nil {
+			return err
+		}
+		s.dir = dir
+	}
+	digests := make([]string, 0, len(s.mem))
+	for dgst := range s.mem {
+		digests = append(digests, dgst.String())
+	}
+	slices.Sort(digests)
+
+	run, err := s.writeRun(func(yield func(string) error) error {
+		for _, dgst := range digests {
+			if err := yield(dgst); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, maxLen(digests))
+	if err != nil {
+		return err
+	}
+	s.runs = append(s.runs, run)
+	clear(s.mem)
+
+	if len(s.runs) > maxMarkRuns {
+		return s.merge()
+	}
+	return nil
+}
+
+// merge merges the files of the set into one.
+func (s *markSet) merge() error {
+	width := 0
+	readers := make([]*bufio.Reader, len(s.runs))
+	heads := make([]string, len(s.runs))
+	for i, run := range s.runs {
+		width = max(width, run.width)
+		readers[i] = bufio.NewReader(io.NewSectionReader(run.f, 0, run.n*int64(run.width)))
+	}
+	next := func(i int) error {
+		record := make([]byte, s.runs[i].width)
+		if _, err := io.ReadFull(readers[i], record); err != nil {
+			if err == io.EOF {
+				heads[i] = ""
+				return nil
+			}
+			return err
+		}
+		heads[i] = string(bytes.TrimRight(record, " "))
+		return nil
+	}
+	for i := range s.runs {
+		if err := next(i); err != nil {
+			return err
+		}
+	}
+
+	run, err := s.writeRun(func(yield func(string) error) error {
+		for {
+			least := -1
+			for i, head := range heads {
+				if head != "" && (least < 0 || head < heads[least]) {
+					least = i
+				}
+			}
+			if least < 0 {
+				return nil
+			}
+			dgst := heads[least]
+			if err := yield(dgst); err != nil {
+				return err
+			}
+			// skip the digest in all files
+			for i := range heads {
+				for heads[i] == dgst {
+					if err := next(i); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}, width)
+	if err != nil {
+		return err
+	}
+	for _, old := range s.runs {
+		old.remove()
+	}
+	s.runs = []*markRun{run}
+	return nil
+}
+
+// writeRun writes the sorted digests of each to a new file, in records of
+// width bytes.
+func (s *markSet) writeRun(each func(yield func(string) error) error, width int) (*markRun, error) {
+	s.seq++
+	f, err := os.Create(filepath.Join(s.dir, fmt.Sprintf("run-%d", s.seq)))
+	if err != nil {
+		return nil, err
+	}
+	run := &markRun{f: f, width: width}
+	w := bufio.NewWriter(f)
+	err = each(func(dgst string) error {
+		if _, err := w.WriteString(dgst); err != nil {
+			return err
+		}
+		for range width - len(dgst) {
+			if err := w.WriteByte(' '); err != nil {
+				return err
+			}
+		}
+		run.n++
+		return nil
+	})
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		run.remove()
+		return nil, err
+	}
+	return run, nil
+}
+
+// close removes the files of the set.
+func (s *markSet) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, run := range s.runs {
+		run.remove()
+	}
+	s.runs = nil
+	if s.seq > 0 {
+		os.Remove(s.dir)
+	}
+}
+
+// contains looks dgst up in the run with a binary search.
+func (r *markRun) contains(dgst digest.Digest) (bool, error) {
+	if len(dgst) > r.width {
+		return false, nil
+	}
+	record := make([]byte, r.width)
+	target := string(dgst)
+	lo, hi := int64(0), r.n
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		if _, err := r.f.ReadAt(record, mid*int64(r.width)); err != nil {
+			return false, err
+		}
+		switch candidate := string(bytes.TrimRight(record, " ")); {
+		case candidate == target:
+			return true, nil
+		case candidate < target:
+			lo = mid + 1
+		default:
+			hi = mid
+		}
+	}
+	return false, nil
+}
+
+func (r *markRun) remove() {
+	r.f.Close()
+	os.Remove(r.f.Name())
+}
+
+func maxLen(strs []string) int {
+	n := 0
+	for _, s := range strs {
+		n = max(n, len(s))
+	}
+	return n
+}
@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+)
+
+func TestMarkSet(t *testing.T) {
+	dir := t.TempDir()
+	s := newMarkSet(4, dir)
+
+	var digests, others []digest.Digest
+	for i := range 100 {
+		algorithm := digest.SHA256
+		if i%10 == 0 {
+			algorithm = digest.SHA512
+		}
+		digests = append(digests, algorithm.FromString(fmt.Sprint(i)))
+		others = append(others, algorithm.FromString(fmt.Sprint("other ", i)))
+	}
+
+	for _, dgst := range digests {
+		if added, err := s.add(dgst); !added || err != nil {
+			t.Fatalf("expected %s to be added: %v", dgst, err)
+		}
+	}
+	// digests are added once, whether held in memory or in files
+	for _, dgst := range digests {
+		if added, err := s.add(dgst); added || err != nil {
+			t.Fatalf("expected %s not to be added again: %v", dgst, err)
+		}
+	}
+	if n := s.len(); n != len(digests) {
+		t.Fatalf("unexpected length %d", n)
+	}
+	if len(s.mem) >= 4 || len(s.runs) == 0 || len(s.runs) > maxMarkRuns {
+		t.Fatalf("unexpected %d digests in memory and %d files", len(s.mem), len(s.runs))
+	}
+
+	for _, dgst := range digests {
+		if found, err := s.contains(dgst); !found || err != nil {
+			t.Fatalf("expected %s to be found: %v", dgst, err)
+		}
+	}
+	for _, dgst := range others {
+		if found, err := s.contains(dgst); found || err != nil {
+			t.Fatalf("expected %s not to be found: %v", dgst, err)
+		}
+	}
+
+	s.close()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("expected the files of the set to be removed, found %v", entries)
+	}
+}
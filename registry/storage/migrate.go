@@ -0,0 +1,567 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/manifest/schema2"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// schema1ReviewTagPrefix prefixes the tags of the schema1 revisions which
+// cannot be converted, so that they can be found for manual review.
+const schema1ReviewTagPrefix = "schema1-review-"
+
+// MigrateOpts contains options for the migrations of legacy metadata.
+type MigrateOpts struct {
+	// DryRun reports the changes without making them.
+	DryRun bool
+	// Concurrency is the number of repositories migrated in parallel, one
+	// if not set.
+	Concurrency int
+	// PurgeOriginals removes the schema1 revisions converted by a previous
+	// run, rather than converting revisions.
+	PurgeOriginals bool
+	// Progress is called after each repository is migrated, in the order
+	// the repositories are enumerated, with the number of repositories
+	// migrated so far.
+	Progress func(repository string, done int)
+}
+
+// SignaturesResult describes a run of MigrateSignatures.
+type SignaturesResult struct {
+	// Repositories is the number of repositories migrated.
+	Repositories int
+	// Removed are the signature directories removed, or which would be
+	// removed in dry run mode.
+	Removed []string
+}
+
+// MigrateSignatures removes the signatures directories of the manifest
+// revisions, which held the signatures of schema1 manifests in old versions
+// of the registry. Only the signatures directories of the revisions are
+// removed: the signature blobs they link to are left to the garbage
+// collector.
+func MigrateSignatures(ctx context.Context, storageDriver driver.StorageDriver, opts MigrateOpts) (SignaturesResult, error) {
+	var result SignaturesResult
+	reg := &registry{blobStore: &blobStore{driver: storageDriver}}
+
+	err := forEachRepository(ctx, reg, opts.Concurrency, func(ctx context.Context, name string) ([]string, error) {
+		return migrateSignatures(ctx, storageDriver, name, opts.DryRun)
+	}, func(name string, removed []string) {
+		result.Repositories++
+		result.Removed = append(result.Removed, removed...)
+		if opts.Progress != nil {
+			opts.Progress(name, result.Repositories)
+		}
+	})
+	if errors.As(err, new(driver.PathNotFoundError)) {
+		// no repositories
+		err = nil
+	}
+	return result, err
+}
+
+// migrateSignatures removes the signatures directories of the revisions of
+// repository name.
+func migrateSignatures(ctx context.Context, storageDriver driver.StorageDriver, name string, dryRun bool) ([]string, error) {
+	root, err := pathFor(manifestRevisionsPathSpec{name: name})
+	if err != nil {
+		return nil, err
+	}
+
+	var dirs []string
+	err = storageDriver.Walk(ctx, root, func(fileInfo driver.FileInfo) error {
+		if !fileInfo.IsDir() || path.Base(fileInfo.Path()) != "signatures" {
+			return nil
+		}
+		// only <algorithm>/<hex digest>/signatures, never revisions
+		// themselves
+		if path.Dir(path.Dir(path.Dir(fileInfo.Path()))) == root {
+			dirs = append(dirs, fileInfo.Path())
+		}
+		return driver.ErrSkipDir
+	})
+	if err != nil && !errors.As(err, new(driver.PathNotFoundError)) {
+		return nil, err
+	}
+
+	for _, dir := range dirs {
+		dcontext.GetLoggerWithFields(ctx, map[any]any{"repository": name, "path": dir}, "repository", "path").Info("migrate: removing legacy signatures")
+		if dryRun {
+			continue
+		}
+		if err := storageDriver.Delete(ctx, dir); err != nil && !errors.As(err, new(driver.PathNotFoundError)) {
+			return nil, err
+		}
+	}
+	return dirs, nil
+}
+
+// Schema1Revision describes a schema1 manifest revision found by
+// MigrateSchema1.
+type Schema1Revision struct {
+	Repository string        `json:"repository"`
+	Digest     digest.Digest `json:"digest"`
+	// Tags are the tags pointing to the revision when it was found.
+	Tags []string `json:"tags,omitempty"`
+	// Converted is the digest of the schema2 manifest the revision converts
+	// to, empty if it cannot be converted losslessly.
+	Converted digest.Digest `json:"converted,omitempty"`
+	// Reason tells why the revision cannot be converted.
+	Reason string `json:"reason,omitempty"`
+	// ReviewTag is the tag of a revision which cannot be converted, for
+	// manual review.
+	ReviewTag string `json:"reviewTag,omitempty"`
+	// Purged is set if the revision was removed, or would be removed in dry
+	// run mode, by a purge of the originals.
+	Purged bool `json:"purged,omitempty"`
+}
+
+// Schema1Result describes a run of MigrateSchema1.
+type Schema1Result struct {
+	// Repositories is the number of repositories migrated.
+	Repositories int
+	// Revisions are the schema1 revisions found.
+	Revisions []Schema1Revision
+}
+
+// MigrateSchema1 converts the schema1 manifest revisions, which the registry
+// no longer serves, to schema2 manifests.
+//
+// A revision is converted when all its layers are gzip compressed blobs
+// present in the registry and its history can be parsed: the image config is
+// then built from the history, as the Docker engine does when pulling schema1
+// images. Only the signatures, which are no longer verified, and the legacy
+// image IDs are lost. The tags pointing to the revision are then moved to the
+// converted manifest, all at once. Revisions which cannot be converted are
+// tagged with a tag prefixed with "schema1-review-" for manual review.
+//
+// Converted manifests have a new digest, so the original revisions are kept,
+// until a run with opts.PurgeOriginals removes the revisions which were
+// converted and are no longer tagged. MigrateSchema1 must not run
+// concurrently with pushes or garbage collection.
+func MigrateSchema1(ctx context.Context, storageDriver driver.StorageDriver, opts MigrateOpts) (Schema1Result, error) {
+	var result Schema1Result
+	namespace, err := NewRegistry(ctx, storageDriver)
+	if err != nil {
+		return result, err
+	}
+	reg := namespace.(*registry)
+
+	err = forEachRepository(ctx, reg, opts.Concurrency, func(ctx context.Context, name string) ([]Schema1Revision, error) {
+		return migrateSchema1(ctx, reg, name, opts)
+	}, func(name string, revisions []Schema1Revision) {
+		result.Repositories++
+		result.Revisions = append(result.Revisions, revisions...)
+		if opts.Progress != nil {
+			opts.Progress(name, result.Repositories)
+		}
+	})
+	if errors.As(err, new(driver.PathNotFoundError)) {
+		// no repositories
+		err = nil
+	}
+	return result, err
+}
+
+// migrateSchema1 migrates the schema1 revisions of repository name.
+func migrateSchema1(ctx context.Context, reg *registry, name string, opts MigrateOpts) ([]Schema1Revision, error) {
+	named, err := reference.WithName(name)
+	if err != nil {
+		return nil, err
+	}
+	repo, err := reg.Repository(ctx, named)
+	if err != nil {
+		return nil, err
+	}
+
+	revisions, err := revisionDigests(ctx, reg.blobStore.driver, name)
+	if err != nil {
+		return nil, err
+	}
+	tagged, err := tagsByDigest(ctx, repo.Tags(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	var migrated []Schema1Revision
+	for _, dgst := range revisions {
+		payload, err := reg.blobStore.Get(ctx, dgst)
+		if err != nil {
+			if errors.Is(err, distribution.ErrBlobUnknown) {
+				// dangling revisions are left to the reconciler
+				continue
+			}
+			return migrated, err
+		}
+		var versioned struct {
+			SchemaVersion int `json:"schemaVersion"`
+		}
+		if err := json.Unmarshal(payload, &versioned); err != nil || versioned.SchemaVersion != 1 {
+			continue
+		}
+
+		revision := Schema1Revision{Repository: name, Digest: dgst, Tags: tagged[dgst]}
+		manifest, config, err := convertSchema1(ctx, reg.blobStore, payload)
+		var unconvertible schema1Unconvertible
+		switch {
+		case errors.As(err, &unconvertible):
+			revision.Reason = unconvertible.Error()
+		case err != nil:
+			return migrated, fmt.Errorf("failed to convert revision %s: %w", dgst, err)
+		default:
+			deserialized, err := schema2.FromStruct(manifest)
+			if err != nil {
+				return migrated, err
+			}
+			_, p, _ := deserialized.Payload()
+			revision.Converted = digest.FromBytes(p)
+		}
+
+		if opts.PurgeOriginals {
+			err = purgeSchema1(ctx, reg.blobStore.driver, &revision, opts.DryRun)
+		} else {
+			err = applySchema1(ctx, repo, &revision, manifest, config, opts.DryRun)
+		}
+		migrated = append(migrated, revision)
+		if err != nil {
+			return migrated, fmt.Errorf("failed to migrate revision %s: %w", dgst, err)
+		}
+		dcontext.GetLoggerWithFields(ctx, map[any]any{
+			"repository": name,
+			"digest":     dgst,
+			"converted":  revision.Converted,
+			"reason":     revision.Reason,
+			"purged":     revision.Purged,
+		}, "repository", "digest", "converted", "reason", "purged").Info("migrate: schema1 revision")
+	}
+	return migrated, nil
+}
+
+// applySchema1 stores the conversion of revision and moves its tags to it,
+// or tags the revision for review if it cannot be converted.
+func applySchema1(ctx context.Context, repo distribution.Repository, revision *Schema1Revision, manifest schema2.Manifest, config []byte, dryRun bool) error {
+	tags := repo.Tags(ctx)
+	if revision.Converted == "" {
+		revision.ReviewTag = schema1ReviewTagPrefix + revision.Digest.Encoded()[:12]
+		if dryRun || slices.Contains(revision.Tags, revision.ReviewTag) {
+			return nil
+		}
+		return tags.Tag(ctx, revision.ReviewTag, v1.Descriptor{Digest: revision.Digest})
+	}
+	if dryRun {
+		return nil
+	}
+
+	if _, err := repo.Blobs(ctx).Put(ctx, schema2.MediaTypeImageConfig, config); err != nil {
+		return err
+	}
+	deserialized, err := schema2.FromStruct(manifest)
+	if err != nil {
+		return err
+	}
+	manifests, err := repo.Manifests(ctx)
+	if err != nil {
+		return err
+	}
+	if _, err := manifests.Put(ctx, deserialized); err != nil {
+		return err
+	}
+
+	var ops []distribution.TagOperation
+	for _, tag := range revision.Tags {
+		if strings.HasPrefix(tag, schema1ReviewTagPrefix) {
+			continue
+		}
+		ops = append(ops, distribution.TagOperation{
+			Tag:  tag,
+			Desc: v1.Descriptor{MediaType: schema2.MediaTypeManifest, Digest: revision.Converted},
+		})
+	}
+	if len(ops) == 0 {
+		return nil
+	}
+	batcher, ok := tags.(distribution.TagBatcher)
+	if !ok {
+		return errors.New("tag service does not support batches")
+	}
+	return batcher.TagBatch(ctx, ops)
+}
+
+// purgeSchema1 removes revision if it was converted and is no longer tagged.
+// The tag index entries of the revision are removed along with it, and its
+// blob is left to the garbage collector.
+func purgeSchema1(ctx context.Context, storageDriver driver.StorageDriver, revision *Schema1Revision, dryRun bool) error {
+	if revision.Converted == "" || len(revision.Tags) > 0 {
+		return nil
+	}
+	convertedPath, err := pathFor(manifestRevisionLinkPathSpec{name: revision.Repository, revision: revision.Converted})
+	if err != nil {
+		return err
+	}
+	if _, err := storageDriver.Stat(ctx, convertedPath); err != nil {
+		if errors.As(err, new(driver.PathNotFoundError)) {
+			// not converted yet
+			return nil
+		}
+		return err
+	}
+	revision.Purged = true
+	if dryRun {
+		return nil
+	}
+
+	tagsPath, err := pathFor(manifestTagsPathSpec{name: revision.Repository})
+	if err != nil {
+		return err
+	}
+	tagPaths, err := storageDriver.List(ctx, tagsPath)
+	if err != nil && !errors.As(err, new(driver.PathNotFoundError)) {
+		return err
+	}
+	for _, tagPath := range tagPaths {
+		entryPath, err := pathFor(manifestTagIndexEntryPathSpec{name: revision.Repository, tag: path.Base(tagPath), revision: revision.Digest})
+		if err != nil {
+			return err
+		}
+		if err := storageDriver.Delete(ctx, entryPath); err != nil && !errors.As(err, new(driver.PathNotFoundError)) {
+			return err
+		}
+	}
+
+	revisionPath, err := pathFor(manifestRevisionPathSpec{name: revision.Repository, revision: revision.Digest})
+	if err != nil {
+		return err
+	}
+	return storageDriver.Delete(ctx, revisionPath)
+}
+
+// schema1Unconvertible is returned when a schema1 manifest cannot be
+// converted losslessly.
+type schema1Unconvertible struct {
+	reason string
+}
+
+func (err schema1Unconvertible) Error() string {
+	return err.reason
+}
+
+// schema1Manifest holds the fields of a schema1 manifest used by the
+// conversion.
+type schema1Manifest struct {
+	FSLayers []struct {
+		BlobSum digest.Digest `json:"blobSum"`
+	} `json:"fsLayers"`
+	History []struct {
+		V1Compatibility string `json:"v1Compatibility"`
+	} `json:"history"`
+}
+
+// schema1Layer holds the fields of the v1 compatibility entries of a schema1
+// manifest used by the conversion.
+type schema1Layer struct {
+	Created         time.Time `json:"created"`
+	Author          string    `json:"author,omitempty"`
+	Comment         string    `json:"comment,omitempty"`
+	ThrowAway       bool      `json:"throwaway,omitempty"`
+	ContainerConfig struct {
+		Cmd []string `json:"Cmd"`
+	} `json:"container_config"`
+}
+
+// schema1History is a history entry of the converted image config.
+type schema1History struct {
+	Created    time.Time `json:"created"`
+	Author     string    `json:"author,omitempty"`
+	CreatedBy  string    `json:"created_by,omitempty"`
+	Comment    string    `json:"comment,omitempty"`
+	EmptyLayer bool      `json:"empty_layer,omitempty"`
+}
+
+// convertSchema1 converts the schema1 manifest payload to a schema2 manifest
+// and its image config, as the Docker engine does when pulling schema1
+// images. It returns a schema1Unconvertible error if the manifest cannot be
+// converted losslessly.
+func convertSchema1(ctx context.Context, blobs *blobStore, payload []byte) (schema2.Manifest, []byte, error) {
+	var m schema1Manifest
+	if err := json.Unmarshal(payload, &m); err != nil {
+		return schema2.Manifest{}, nil, schema1Unconvertible{fmt.Sprintf("invalid manifest: %v", err)}
+	}
+	if len(m.FSLayers) == 0 || len(m.FSLayers) != len(m.History) {
+		return schema2.Manifest{}, nil, schema1Unconvertible{"layers do not match the history"}
+	}
+
+	var (
+		layers  []v1.Descriptor
+		diffIDs []digest.Digest
+		history []schema1History
+	)
+	// the layers and history of schema1 manifests are listed from the top
+	for i := len(m.History) - 1; i >= 0; i-- {
+		var layer schema1Layer
+		if err := json.Unmarshal([]byte(m.History[i].V1Compatibility), &layer); err != nil {
+			return schema2.Manifest{}, nil, schema1Unconvertible{fmt.Sprintf("invalid history entry %d: %v", i, err)}
+		}
+		history = append(history, schema1History{
+			Created:    layer.Created,
+			Author:     layer.Author,
+			CreatedBy:  strings.Join(layer.ContainerConfig.Cmd, " "),
+			Comment:    layer.Comment,
+			EmptyLayer: layer.ThrowAway,
+		})
+		if layer.ThrowAway {
+			continue
+		}
+
+		desc, diffID, err := schema1LayerDiffID(ctx, blobs, m.FSLayers[i].BlobSum)
+		if err != nil {
+			return schema2.Manifest{}, nil, err
+		}
+		layers = append(layers, desc)
+		diffIDs = append(diffIDs, diffID)
+	}
+
+	// the config is that of the top layer, without the fields of v1 images
+	var config map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(m.History[0].V1Compatibility), &config); err != nil {
+		return schema2.Manifest{}, nil, schema1Unconvertible{fmt.Sprintf("invalid history entry 0: %v", err)}
+	}
+	for _, field := range []string{"id", "parent", "Size", "parent_id", "layer_id", "throwaway"} {
+		delete(config, field)
+	}
+	for field, v := range map[string]any{
+		"rootfs":  map[string]any{"type": "layers", "diff_ids": diffIDs},
+		"history": history,
+	} {
+		raw, err := json.Marshal(v)
+		if err != nil {
+			return schema2.Manifest{}, nil, err
+		}
+		config[field] = raw
+	}
+	p, err := json.Marshal(config)
+	if err != nil {
+		return schema2.Manifest{}, nil, err
+	}
+
+	return schema2.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: schema2.MediaTypeManifest,
+		Config: v1.Descriptor{
+			MediaType: schema2.MediaTypeImageConfig,
+			Digest:    digest.FromBytes(p),
+			Size:      int64(len(p)),
+		},
+		Layers: layers,
+	}, p, nil
+}
+
+// schema1LayerDiffID returns the descriptor of the layer blob dgst and the
+// digest of its uncompressed content.
+func schema1LayerDiffID(ctx context.Context, blobs *blobStore, dgst digest.Digest) (v1.Descriptor, digest.Digest, error) {
+	desc, err := blobs.statter.Stat(ctx, dgst)
+	if err != nil {
+		if errors.Is(err, distribution.ErrBlobUnknown) {
+			return v1.Descriptor{}, "", schema1Unconvertible{fmt.Sprintf("layer %s is missing", dgst)}
+		}
+		return v1.Descriptor{}, "", err
+	}
+	rc, err := blobs.Open(ctx, dgst)
+	if err != nil {
+		return v1.Descriptor{}, "", err
+	}
+	defer rc.Close()
+
+	br := bufio.NewReader(rc)
+	if magic, err := br.Peek(2); err != nil || !bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		return v1.Descriptor{}, "", schema1Unconvertible{fmt.Sprintf("layer %s is not gzip compressed", dgst)}
+	}
+	zr, err := gzip.NewReader(br)
+	if err != nil {
+		return v1.Descriptor{}, "", schema1Unconvertible{fmt.Sprintf("layer %s is not gzip compressed: %v", dgst, err)}
+	}
+	defer zr.Close()
+	digester := digest.Canonical.Digester()
+	if _, err := io.Copy(digester.Hash(), zr); err != nil {
+		return v1.Descriptor{}, "", schema1Unconvertible{fmt.Sprintf("layer %s cannot be decompressed: %v", dgst, err)}
+	}
+
+	return v1.Descriptor{
+		MediaType: schema2.MediaTypeLayer,
+		Digest:    dgst,
+		Size:      desc.Size,
+	}, digester.Digest(), nil
+}
+
+// revisionDigests returns the digests of the manifest revisions of
+// repository name.
+func revisionDigests(ctx context.Context, storageDriver driver.StorageDriver, name string) ([]digest.Digest, error) {
+	root, err := pathFor(manifestRevisionsPathSpec{name: name})
+	if err != nil {
+		return nil, err
+	}
+
+	var revisions []digest.Digest
+	err = storageDriver.Walk(ctx, root, func(fileInfo driver.FileInfo) error {
+		if fileInfo.IsDir() {
+			if path.Base(fileInfo.Path()) == "signatures" {
+				return driver.ErrSkipDir
+			}
+			return nil
+		}
+		// <algorithm>/<hex digest>/link
+		if path.Base(fileInfo.Path()) != "link" || path.Dir(path.Dir(path.Dir(fileInfo.Path()))) != root {
+			return nil
+		}
+		dir := path.Dir(fileInfo.Path())
+		dgst := digest.NewDigestFromEncoded(digest.Algorithm(path.Base(path.Dir(dir))), path.Base(dir))
+		if dgst.Validate() == nil {
+			revisions = append(revisions, dgst)
+		}
+		return nil
+	})
+	if err != nil && !errors.As(err, new(driver.PathNotFoundError)) {
+		return nil, err
+	}
+	return revisions, nil
+}
+
+// tagsByDigest returns the tags of tags, by the digest they point to.
+func tagsByDigest(ctx context.Context, tags distribution.TagService) (map[digest.Digest][]string, error) {
+	all, err := tags.All(ctx)
+	if err != nil {
+		if errors.As(err, new(distribution.ErrRepositoryUnknown)) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	tagged := make(map[digest.Digest][]string)
+	for _, tag := range all {
+		desc, err := tags.Get(ctx, tag)
+		if err != nil {
+			if errors.As(err, new(distribution.ErrTagUnknown)) {
+				continue
+			}
+			return nil, err
+		}
+		tagged[desc.Digest] = append(tagged[desc.Digest], tag)
+	}
+	return tagged, nil
+}
@@ -0,0 +1,261 @@
+package storage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/distribution/distribution/v3/manifest/schema2"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// migrateFixture stores in repository name a schema1 revision tagged
+// "convertible", with a gzip layer and a throwaway layer, and one tagged
+// "legacy" whose layer is missing. Both have legacy signatures.
+func migrateFixture(t *testing.T, ctx context.Context, d driver.StorageDriver, name string) (convertible, legacy digest.Digest) {
+	t.Helper()
+	namespace, err := NewRegistry(ctx, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg := namespace.(*registry)
+	named, _ := reference.WithName(name)
+	repo, err := reg.Repository(ctx, named)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var layer bytes.Buffer
+	zw := gzip.NewWriter(&layer)
+	zw.Write([]byte("layer content"))
+	zw.Close()
+	layerDesc, err := repo.Blobs(ctx).Put(ctx, "application/octet-stream", layer.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	schema1 := func(blobSums ...digest.Digest) digest.Digest {
+		var history []map[string]string
+		for i := range blobSums {
+			// the top entry holds the config of the image
+			compat := fmt.Sprintf(`{"id":"%d","created":"2016-01-02T15:04:05Z","container_config":{"Cmd":["/bin/sh","-c","step %d"]}}`, i, i)
+			if i == 0 {
+				compat = `{"id":"0","parent":"1","architecture":"amd64","os":"linux","config":{"Env":["A=b"]},"created":"2016-01-02T15:04:05Z","container_config":{"Cmd":["/bin/sh","-c","#(nop) ENV A=b"]},"throwaway":true}`
+			}
+			history = append(history, map[string]string{"v1Compatibility": compat})
+		}
+		var fsLayers []map[string]digest.Digest
+		for _, blobSum := range blobSums {
+			fsLayers = append(fsLayers, map[string]digest.Digest{"blobSum": blobSum})
+		}
+		p, err := json.Marshal(map[string]any{"schemaVersion": 1, "name": name, "tag": "latest", "fsLayers": fsLayers, "history": history})
+		if err != nil {
+			t.Fatal(err)
+		}
+		desc, err := reg.blobStore.Put(ctx, "application/vnd.docker.distribution.manifest.v1+prettyjws", p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		revisionPath, _ := pathFor(manifestRevisionLinkPathSpec{name: name, revision: desc.Digest})
+		if err := reg.blobStore.link(ctx, revisionPath, desc.Digest); err != nil {
+			t.Fatal(err)
+		}
+		signature := digest.FromString("signature " + desc.Digest.String())
+		revisionDir, _ := pathFor(manifestRevisionPathSpec{name: name, revision: desc.Digest})
+		if err := reg.blobStore.link(ctx, revisionDir+"/signatures/sha256/"+signature.Encoded()+"/link", signature); err != nil {
+			t.Fatal(err)
+		}
+		return desc.Digest
+	}
+	// the throwaway layer of the first history entry, the top one, is an
+	// empty tar
+	convertible = schema1(digest.FromString("throwaway"), layerDesc.Digest)
+	legacy = schema1(digest.FromString("missing"), digest.FromString("missing too"))
+
+	tags := repo.Tags(ctx)
+	for tag, dgst := range map[string]digest.Digest{"convertible": convertible, "also-convertible": convertible, "legacy": legacy} {
+		if err := tags.Tag(ctx, tag, v1.Descriptor{Digest: dgst}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return convertible, legacy
+}
+
+func TestMigrateSignatures(t *testing.T) {
+	ctx := context.Background()
+	d := inmemory.New()
+	convertible, _ := migrateFixture(t, ctx, d, "foo/bar")
+	migrateFixture(t, ctx, d, "foo/baz")
+
+	var progress []string
+	result, err := MigrateSignatures(ctx, d, MigrateOpts{DryRun: true, Progress: func(repository string, done int) {
+		progress = append(progress, fmt.Sprintf("%s %d", repository, done))
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Repositories != 2 || len(result.Removed) != 4 {
+		t.Fatalf("unexpected dry run result: %+v", result)
+	}
+	if fmt.Sprint(progress) != "[foo/bar 1 foo/baz 2]" {
+		t.Errorf("unexpected progress: %v", progress)
+	}
+	if _, err := d.Stat(ctx, result.Removed[0]); err != nil {
+		t.Fatalf("dry run removed signatures: %v", err)
+	}
+
+	result, err = MigrateSignatures(ctx, d, MigrateOpts{Concurrency: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Removed) != 4 {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+	for _, dir := range result.Removed {
+		if _, err := d.Stat(ctx, dir); !errors.As(err, new(driver.PathNotFoundError)) {
+			t.Errorf("signatures %s not removed: %v", dir, err)
+		}
+	}
+	revisionPath, _ := pathFor(manifestRevisionLinkPathSpec{name: "foo/bar", revision: convertible})
+	if _, err := d.Stat(ctx, revisionPath); err != nil {
+		t.Errorf("revision removed: %v", err)
+	}
+
+	result, err = MigrateSignatures(ctx, d, MigrateOpts{})
+	if err != nil || len(result.Removed) != 0 {
+		t.Fatalf("unexpected second run result: %+v, %v", result, err)
+	}
+}
+
+func TestMigrateSchema1(t *testing.T) {
+	ctx := context.Background()
+	d := inmemory.New()
+	convertible, legacy := migrateFixture(t, ctx, d, "foo/bar")
+	named, _ := reference.WithName("foo/bar")
+
+	result, err := MigrateSchema1(ctx, d, MigrateOpts{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Repositories != 1 || len(result.Revisions) != 2 {
+		t.Fatalf("unexpected dry run result: %+v", result)
+	}
+	revisions := make(map[digest.Digest]Schema1Revision)
+	for _, revision := range result.Revisions {
+		revisions[revision.Digest] = revision
+	}
+	converted := revisions[convertible].Converted
+	if converted == "" || len(revisions[convertible].Tags) != 2 {
+		t.Fatalf("unexpected convertible revision: %+v", revisions[convertible])
+	}
+	if revisions[legacy].Converted != "" || revisions[legacy].Reason == "" || revisions[legacy].ReviewTag != "schema1-review-"+legacy.Encoded()[:12] {
+		t.Fatalf("unexpected legacy revision: %+v", revisions[legacy])
+	}
+
+	// purging before converting keeps the originals
+	result, err = MigrateSchema1(ctx, d, MigrateOpts{PurgeOriginals: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, revision := range result.Revisions {
+		if revision.Purged {
+			t.Fatalf("revision purged before conversion: %+v", revision)
+		}
+	}
+
+	result, err = MigrateSchema1(ctx, d, MigrateOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Revisions) != 2 {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+
+	reg, err := NewRegistry(ctx, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo, err := reg.Repository(ctx, named)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tags := repo.Tags(ctx)
+	for tag, want := range map[string]digest.Digest{
+		"convertible":      converted,
+		"also-convertible": converted,
+		"legacy":           legacy,
+		"schema1-review-" + legacy.Encoded()[:12]: legacy,
+	} {
+		desc, err := tags.Get(ctx, tag)
+		if err != nil || desc.Digest != want {
+			t.Errorf("tag %s: got %v, %v, want %v", tag, desc.Digest, err, want)
+		}
+	}
+
+	ms, err := repo.Manifests(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := ms.Get(ctx, converted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, ok := m.(*schema2.DeserializedManifest)
+	if !ok || len(manifest.Layers) != 1 {
+		t.Fatalf("unexpected converted manifest: %#v", m)
+	}
+	p, err := repo.Blobs(ctx).Get(ctx, manifest.Config.Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var config struct {
+		Architecture string            `json:"architecture"`
+		ID           string            `json:"id"`
+		Config       map[string]any    `json:"config"`
+		RootFS       map[string]any    `json:"rootfs"`
+		History      []json.RawMessage `json:"history"`
+	}
+	if err := json.Unmarshal(p, &config); err != nil {
+		t.Fatal(err)
+	}
+	diffIDs, _ := config.RootFS["diff_ids"].([]any)
+	if config.Architecture != "amd64" || config.ID != "" || config.Config == nil || len(diffIDs) != 1 || len(config.History) != 2 {
+		t.Fatalf("unexpected converted config: %s", p)
+	}
+	if diffIDs[0] != digest.FromString("layer content").String() {
+		t.Errorf("unexpected diff ID %v", diffIDs[0])
+	}
+
+	// the originals are kept until purged
+	original, _ := pathFor(manifestRevisionLinkPathSpec{name: "foo/bar", revision: convertible})
+	if _, err := d.Stat(ctx, original); err != nil {
+		t.Fatalf("original revision removed: %v", err)
+	}
+	result, err = MigrateSchema1(ctx, d, MigrateOpts{PurgeOriginals: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, revision := range result.Revisions {
+		if revision.Purged != (revision.Digest == convertible) {
+			t.Errorf("unexpected purge: %+v", revision)
+		}
+	}
+	if _, err := d.Stat(ctx, original); !errors.As(err, new(driver.PathNotFoundError)) {
+		t.Errorf("original revision not purged: %v", err)
+	}
+	if _, err := ms.Get(ctx, converted); err != nil {
+		t.Errorf("converted manifest not found: %v", err)
+	}
+	entry, _ := pathFor(manifestTagIndexEntryPathSpec{name: "foo/bar", tag: "convertible", revision: convertible})
+	if _, err := d.Stat(ctx, entry); !errors.As(err, new(driver.PathNotFoundError)) {
+		t.Errorf("tag index entry not purged: %v", err)
+	}
+}
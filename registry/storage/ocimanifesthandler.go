@@ -14,10 +14,13 @@ import (
 
 // ocischemaManifestHandler is a ManifestHandler that covers ocischema manifests.
 type ocischemaManifestHandler struct {
-	repository   distribution.Repository
-	blobStore    distribution.BlobStore
-	ctx          context.Context
-	manifestURLs manifestURLs
+	repository         distribution.Repository
+	blobStore          distribution.BlobStore
+	ctx                context.Context
+	manifestURLs       manifestURLs
+	configMediaTypes   map[string]struct{}
+	blobExistenceRetry blobExistenceRetry
+	configCreated      configCreated
 }
 
 var _ ManifestHandler = &ocischemaManifestHandler{}
@@ -69,16 +72,27 @@ func (ms *ocischemaManifestHandler) verifyManifest(ctx context.Context, mnfst oc
 		return fmt.Errorf("unrecognized manifest schema version %d", mnfst.Manifest.SchemaVersion)
 	}
 
+	if err := verifyConfigMediaType(ms.configMediaTypes, mnfst.Config.MediaType); err != nil {
+		return distribution.ErrManifestVerification{err}
+	}
+
 	if skipDependencyVerification {
 		return nil
 	}
 
+	if err := ms.configCreated.verify(ctx, ms.repository.Blobs(ctx), mnfst.Config); err != nil {
+		if _, ok := err.(distribution.ErrManifestConfigCreatedInvalid); ok {
+			return distribution.ErrManifestVerification{err}
+		}
+		return err
+	}
+
 	manifestService, err := ms.repository.Manifests(ctx)
 	if err != nil {
 		return err
 	}
 
-	blobsService := ms.repository.Blobs(ctx)
+	blobsService := ms.blobExistenceRetry.statter(ms.repository.Blobs(ctx))
 
 	for _, descriptor := range mnfst.References() {
 		err := descriptor.Digest.Validate()
@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"path"
+
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+)
+
+// blobPacker is implemented by the storage drivers packing small files into
+// larger pack files, such as the filesystem driver.
+type blobPacker interface {
+	// Pack packs the small files at paths and returns the number of files
+	// packed.
+	Pack(ctx context.Context, paths []string) (int, error)
+	// Unpack writes the packed files back to loose files and returns their
+	// number.
+	Unpack(ctx context.Context) (int, error)
+	// Compact reclaims the space of the files deleted from the pack files
+	// and returns the number of bytes reclaimed.
+	Compact(ctx context.Context) (int64, error)
+}
+
+// PackBlobs packs the data of the small blobs stored by storageDriver into
+// pack files, after compacting the pack files, and returns the number of
+// blobs packed. It fails if the driver does not pack files.
+func PackBlobs(ctx context.Context, storageDriver driver.StorageDriver) (int, error) {
+	packer, ok := storageDriver.(blobPacker)
+	if !ok {
+		return 0, fmt.Errorf("the %s storage driver does not support packing", storageDriver.Name())
+	}
+	if _, err := packer.Compact(ctx); err != nil {
+		return 0, fmt.Errorf("failed to compact pack files: %w", err)
+	}
+
+	blobsPath, err := pathFor(blobsPathSpec{})
+	if err != nil {
+		return 0, err
+	}
+	var paths []string
+	err = storageDriver.Walk(ctx, blobsPath, func(fileInfo driver.FileInfo) error {
+		if !fileInfo.IsDir() && path.Base(fileInfo.Path()) == "data" {
+			paths = append(paths, fileInfo.Path())
+		}
+		return nil
+	})
+	if _, ok := err.(driver.PathNotFoundError); ok {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	return packer.Pack(ctx, paths)
+}
+
+// UnpackBlobs writes the data of the packed blobs stored by storageDriver
+// back to loose files and returns the number of blobs unpacked. It fails if
+// the driver does not pack files.
+func UnpackBlobs(ctx context.Context, storageDriver driver.StorageDriver) (int, error) {
+	packer, ok := storageDriver.(blobPacker)
+	if !ok {
+		return 0, fmt.Errorf("the %s storage driver does not support packing", storageDriver.Name())
+	}
+	return packer.Unpack(ctx)
+}
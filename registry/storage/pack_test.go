@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/registry/storage/driver/filesystem"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestPackBlobs(t *testing.T) {
+	ctx := dcontext.Background()
+	d, err := filesystem.FromParameters(map[string]any{
+		"rootdirectory": t.TempDir(),
+		"packing":       map[string]any{"enabled": true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry := createRegistry(t, d)
+	repo := makeRepository(t, registry, "komnenos")
+	manifests, _ := repo.Manifests(ctx)
+
+	image1 := uploadRandomSchema2Image(t, repo)
+	image2 := uploadRandomSchema2Image(t, repo)
+
+	checkBlobs := func(im image) {
+		t.Helper()
+		blobs := repo.Blobs(ctx)
+		for layer := range im.layers {
+			if _, err := blobs.Stat(ctx, layer); err != nil {
+				t.Fatalf("failed to stat layer %s: %v", layer, err)
+			}
+		}
+		config := im.manifest.References()[0].Digest
+		p, err := blobs.Get(ctx, config)
+		if err != nil || digest.FromBytes(p) != config {
+			t.Fatalf("failed reading config %s: %v", config, err)
+		}
+		if _, err := manifests.Get(ctx, im.manifestDigest); err != nil {
+			t.Fatalf("failed reading manifest %s: %v", im.manifestDigest, err)
+		}
+	}
+
+	n, err := PackBlobs(ctx, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the manifests and the config shared by the images, while the layers
+	// are too large to be packed
+	if n != 3 {
+		t.Fatalf("unexpected number of blobs packed: %d", n)
+	}
+	checkBlobs(image1)
+	checkBlobs(image2)
+
+	if err := manifests.Delete(ctx, image2.manifestDigest); err != nil {
+		t.Fatalf("failed deleting manifest digest: %v", err)
+	}
+	if err := MarkAndSweep(ctx, d, registry, GCOpts{Quiet: true}); err != nil {
+		t.Fatalf("Failed mark and sweep: %v", err)
+	}
+	blobs := allBlobs(t, registry)
+	for layer := range image2.layers {
+		if _, ok := blobs[layer]; ok {
+			t.Fatalf("deleted layer is present: %v", layer)
+		}
+	}
+	checkBlobs(image1)
+
+	n, err = UnpackBlobs(ctx, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("unexpected number of blobs unpacked: %d", n)
+	}
+	checkBlobs(image1)
+
+	if _, err := PackBlobs(ctx, inmemory.New()); err == nil {
+		t.Fatal("expected an error packing blobs with the inmemory driver")
+	}
+}
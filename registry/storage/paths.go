@@ -3,8 +3,11 @@ package storage
 import (
 	"fmt"
 	"path"
+	"strconv"
 	"strings"
 
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
 	"github.com/opencontainers/go-digest"
 )
 
@@ -32,6 +35,9 @@ const (
 //	        ├── _layers
 //	        │   └── <layer links to blob store>
 //	        ├── _manifests
+//	        │   ├── artifacttypes
+//	        │   │   └── <artifact type digest path>
+//	        │   │       └── mediatype
 //	        │   ├── revisions
 //	        │   │   └── <manifest digest path>
 //	        │   │       └── link
@@ -83,10 +89,25 @@ const (
 //
 //	Manifests:
 //
-//	manifestsPathSpec:             <root>/v2/repositories/<name>/_manifests
-//	manifestRevisionsPathSpec:     <root>/v2/repositories/<name>/_manifests/revisions/
-//	manifestRevisionPathSpec:      <root>/v2/repositories/<name>/_manifests/revisions/<algorithm>/<hex digest>/
-//	manifestRevisionLinkPathSpec:  <root>/v2/repositories/<name>/_manifests/revisions/<algorithm>/<hex digest>/link
+//	manifestsPathSpec:                      <root>/v2/repositories/<name>/_manifests
+//	manifestRevisionsPathSpec:              <root>/v2/repositories/<name>/_manifests/revisions/
+//	manifestRevisionPathSpec:               <root>/v2/repositories/<name>/_manifests/revisions/<algorithm>/<hex digest>/
+//	manifestRevisionLinkPathSpec:           <root>/v2/repositories/<name>/_manifests/revisions/<algorithm>/<hex digest>/link
+//	manifestArtifactTypesPathSpec:          <root>/v2/repositories/<name>/_manifests/artifacttypes/
+//	manifestArtifactTypePathSpec:           <root>/v2/repositories/<name>/_manifests/artifacttypes/<algorithm>/<hex digest of artifact type>/mediatype
+//	manifestArtifactTypeManifestsPathSpec:  <root>/v2/repositories/<name>/_manifests/artifacttypes/<algorithm>/<hex digest of artifact type>/manifests/
+//	manifestArtifactTypeLinkPathSpec:       <root>/v2/repositories/<name>/_manifests/artifacttypes/<algorithm>/<hex digest of artifact type>/manifests/<algorithm>/<hex digest>/link
+//
+//	Referrers:
+//
+//	manifestReferrersPathSpec:     <root>/v2/repositories/<name>/_manifests/referrers/<algorithm>/<hex digest of subject>/<algorithm>/<hex digest of artifact type>/
+//	manifestReferrerLinkPathSpec:  <root>/v2/repositories/<name>/_manifests/referrers/<algorithm>/<hex digest of subject>/<algorithm>/<hex digest of artifact type>/<algorithm>/<hex digest>/link
+//
+//	Indexes:
+//
+//	manifestIndexesPathSpec:       <root>/v2/repositories/<name>/_manifests/indexes/<algorithm>/<hex digest of manifest>/
+//	manifestIndexLinkPathSpec:     <root>/v2/repositories/<name>/_manifests/indexes/<algorithm>/<hex digest of manifest>/<algorithm>/<hex digest>/link
+//	manifestsIndexedPathSpec:      <root>/v2/repositories/<name>/_manifests/indexed
 //
 //	Tags:
 //
@@ -96,16 +117,19 @@ const (
 //	manifestTagIndexPathSpec:              <root>/v2/repositories/<name>/_manifests/tags/<tag>/index/
 //	manifestTagIndexEntryPathSpec:         <root>/v2/repositories/<name>/_manifests/tags/<tag>/index/<algorithm>/<hex digest>/
 //	manifestTagIndexEntryLinkPathSpec:     <root>/v2/repositories/<name>/_manifests/tags/<tag>/index/<algorithm>/<hex digest>/link
+//	manifestTagBatchPathSpec:              <root>/v2/repositories/<name>/_manifests/tagbatch
 //
 //	Blobs:
 //
 //	layerLinkPathSpec:            <root>/v2/repositories/<name>/_layers/<algorithm>/<hex digest>/link
+//	layerMediaTypePathSpec:       <root>/v2/repositories/<name>/_layers/<algorithm>/<hex digest>/mediatype
 //	layersPathSpec:               <root>/v2/repositories/<name>/_layers
 //
 //	Uploads:
 //
 //	uploadDataPathSpec:             <root>/v2/repositories/<name>/_uploads/<id>/data
 //	uploadStartedAtPathSpec:        <root>/v2/repositories/<name>/_uploads/<id>/startedat
+//	uploadAbandonedAtPathSpec:      <root>/v2/repositories/<name>/_uploads/<id>/abandonedat
 //	uploadHashStatePathSpec:        <root>/v2/repositories/<name>/_uploads/<id>/hashstates/<algorithm>/<offset>
 //
 //	Blob Store:
@@ -114,6 +138,11 @@ const (
 //	blobPathSpec:                   <root>/v2/blobs/<algorithm>/<first two hex bytes of digest>/<hex digest>
 //	blobDataPathSpec:               <root>/v2/blobs/<algorithm>/<first two hex bytes of digest>/<hex digest>/data
 //
+//	Maintenance:
+//
+//	reconcileStatePathSpec:         <root>/v2/maintenance/reconcile
+//	garbageCollectionPathSpec:      <root>/v2/maintenance/gc
+//
 // For more information on the semantic meaning of each path and their
 // contents, please see the path spec documentation.
 func pathFor(spec pathSpec) (string, error) {
@@ -128,81 +157,136 @@ func pathFor(spec pathSpec) (string, error) {
 	// build a filesystem walker that converts a string path in one version,
 	// to an intermediate path object, than can be consumed and mapped by the
 	// other version.
-
-	rootPrefix := []string{storagePathRoot, storagePathVersion}
-	repoPrefix := append(rootPrefix, "repositories")
+	//
+	// Paths are mapped on every storage access, so each is built by joinPath
+	// in a single allocation rather than by joining slices of components.
+	// The layout is stored on disk: any change to the paths generated is a
+	// breaking change.
 
 	switch v := spec.(type) {
 
 	case manifestsPathSpec:
-		return path.Join(append(repoPrefix, v.name, "_manifests")...), nil
+		return joinPath(repositoriesPath, v.name, "_manifests"), nil
 
 	case manifestRevisionsPathSpec:
-		return path.Join(append(repoPrefix, v.name, "_manifests", "revisions")...), nil
+		return joinPath(repositoriesPath, v.name, "_manifests", "revisions"), nil
 
 	case manifestRevisionPathSpec:
-		components, err := digestPathComponents(v.revision, false)
+		algorithm, _, hex, err := digestPathElements(v.revision, false)
 		if err != nil {
 			return "", err
 		}
 
-		return path.Join(append(append(repoPrefix, v.name, "_manifests", "revisions"), components...)...), nil
+		return joinPath(repositoriesPath, v.name, "_manifests", "revisions", algorithm, hex), nil
 	case manifestRevisionLinkPathSpec:
-		root, err := pathFor(manifestRevisionPathSpec(v))
+		algorithm, _, hex, err := digestPathElements(v.revision, false)
 		if err != nil {
 			return "", err
 		}
 
-		return path.Join(root, "link"), nil
-	case manifestTagsPathSpec:
-		return path.Join(append(repoPrefix, v.name, "_manifests", "tags")...), nil
-	case manifestTagPathSpec:
-		root, err := pathFor(manifestTagsPathSpec{
-			name: v.name,
-		})
+		return joinPath(repositoriesPath, v.name, "_manifests", "revisions", algorithm, hex, "link"), nil
+	case manifestArtifactTypesPathSpec:
+		return joinPath(repositoriesPath, v.name, "_manifests", "artifacttypes"), nil
+	case manifestArtifactTypePathSpec:
+		algorithm, _, hex, err := digestPathElements(digest.FromString(v.artifactType), false)
 		if err != nil {
 			return "", err
 		}
 
-		return path.Join(root, v.tag), nil
-	case manifestTagCurrentPathSpec:
-		root, err := pathFor(manifestTagPathSpec(v))
+		return joinPath(repositoriesPath, v.name, "_manifests", "artifacttypes", algorithm, hex, "mediatype"), nil
+	case manifestArtifactTypeManifestsPathSpec:
+		algorithm, _, hex, err := digestPathElements(digest.FromString(v.artifactType), false)
 		if err != nil {
 			return "", err
 		}
 
-		return path.Join(root, "current", "link"), nil
-	case manifestTagIndexPathSpec:
-		root, err := pathFor(manifestTagPathSpec(v))
+		return joinPath(repositoriesPath, v.name, "_manifests", "artifacttypes", algorithm, hex, "manifests"), nil
+	case manifestArtifactTypeLinkPathSpec:
+		typeAlgorithm, _, typeHex, err := digestPathElements(digest.FromString(v.artifactType), false)
+		if err != nil {
+			return "", err
+		}
+		algorithm, _, hex, err := digestPathElements(v.revision, false)
 		if err != nil {
 			return "", err
 		}
 
-		return path.Join(root, "index"), nil
-	case manifestTagIndexEntryLinkPathSpec:
-		root, err := pathFor(manifestTagIndexEntryPathSpec(v))
+		return joinPath(repositoriesPath, v.name, "_manifests", "artifacttypes", typeAlgorithm, typeHex, "manifests", algorithm, hex, "link"), nil
+	case manifestReferrersPathSpec:
+		subjectAlgorithm, _, subjectHex, err := digestPathElements(v.subject, false)
+		if err != nil {
+			return "", err
+		}
+		if v.artifactType == "" {
+			return joinPath(repositoriesPath, v.name, "_manifests", "referrers", subjectAlgorithm, subjectHex), nil
+		}
+		typeAlgorithm, _, typeHex, err := digestPathElements(digest.FromString(v.artifactType), false)
 		if err != nil {
 			return "", err
 		}
 
-		return path.Join(root, "link"), nil
-	case manifestTagIndexEntryPathSpec:
-		root, err := pathFor(manifestTagIndexPathSpec{
-			name: v.name,
-			tag:  v.tag,
-		})
+		return joinPath(repositoriesPath, v.name, "_manifests", "referrers", subjectAlgorithm, subjectHex, typeAlgorithm, typeHex), nil
+	case manifestReferrerLinkPathSpec:
+		subjectAlgorithm, _, subjectHex, err := digestPathElements(v.subject, false)
+		if err != nil {
+			return "", err
+		}
+		typeAlgorithm, _, typeHex, err := digestPathElements(digest.FromString(v.artifactType), false)
+		if err != nil {
+			return "", err
+		}
+		algorithm, _, hex, err := digestPathElements(v.referrer, false)
 		if err != nil {
 			return "", err
 		}
 
-		components, err := digestPathComponents(v.revision, false)
+		return joinPath(repositoriesPath, v.name, "_manifests", "referrers", subjectAlgorithm, subjectHex, typeAlgorithm, typeHex, algorithm, hex, "link"), nil
+	case manifestIndexesPathSpec:
+		algorithm, _, hex, err := digestPathElements(v.manifest, false)
 		if err != nil {
 			return "", err
 		}
 
-		return path.Join(root, path.Join(components...)), nil
+		return joinPath(repositoriesPath, v.name, "_manifests", "indexes", algorithm, hex), nil
+	case manifestIndexLinkPathSpec:
+		algorithm, _, hex, err := digestPathElements(v.manifest, false)
+		if err != nil {
+			return "", err
+		}
+		indexAlgorithm, _, indexHex, err := digestPathElements(v.index, false)
+		if err != nil {
+			return "", err
+		}
+
+		return joinPath(repositoriesPath, v.name, "_manifests", "indexes", algorithm, hex, indexAlgorithm, indexHex, "link"), nil
+	case manifestsIndexedPathSpec:
+		return joinPath(repositoriesPath, v.name, "_manifests", "indexed"), nil
+	case manifestTagsPathSpec:
+		return joinPath(repositoriesPath, v.name, "_manifests", "tags"), nil
+	case manifestTagBatchPathSpec:
+		return joinPath(repositoriesPath, v.name, "_manifests", "tagbatch"), nil
+	case manifestTagPathSpec:
+		return joinPath(repositoriesPath, v.name, "_manifests", "tags", v.tag), nil
+	case manifestTagCurrentPathSpec:
+		return joinPath(repositoriesPath, v.name, "_manifests", "tags", v.tag, "current", "link"), nil
+	case manifestTagIndexPathSpec:
+		return joinPath(repositoriesPath, v.name, "_manifests", "tags", v.tag, "index"), nil
+	case manifestTagIndexEntryLinkPathSpec:
+		algorithm, _, hex, err := digestPathElements(v.revision, false)
+		if err != nil {
+			return "", err
+		}
+
+		return joinPath(repositoriesPath, v.name, "_manifests", "tags", v.tag, "index", algorithm, hex, "link"), nil
+	case manifestTagIndexEntryPathSpec:
+		algorithm, _, hex, err := digestPathElements(v.revision, false)
+		if err != nil {
+			return "", err
+		}
+
+		return joinPath(repositoriesPath, v.name, "_manifests", "tags", v.tag, "index", algorithm, hex), nil
 	case layerLinkPathSpec:
-		components, err := digestPathComponents(v.digest, false)
+		algorithm, _, hex, err := digestPathElements(v.digest, false)
 		if err != nil {
 			return "", err
 		}
@@ -212,50 +296,121 @@ func pathFor(spec pathSpec) (string, error) {
 		// A migration strategy would simply leave existing items in place and
 		// write the new paths, commit a file then delete the old files.
 
-		blobLinkPathComponents := append(repoPrefix, v.name, "_layers")
+		return joinPath(repositoriesPath, v.name, "_layers", algorithm, hex, "link"), nil
+	case layerMediaTypePathSpec:
+		algorithm, _, hex, err := digestPathElements(v.digest, false)
+		if err != nil {
+			return "", err
+		}
 
-		return path.Join(path.Join(append(blobLinkPathComponents, components...)...), "link"), nil
+		return joinPath(repositoriesPath, v.name, "_layers", algorithm, hex, "mediatype"), nil
 	case layersPathSpec:
-		return path.Join(append(repoPrefix, v.name, "_layers")...), nil
+		return joinPath(repositoriesPath, v.name, "_layers"), nil
 	case blobsPathSpec:
-		blobsPathPrefix := append(rootPrefix, "blobs")
-		return path.Join(blobsPathPrefix...), nil
+		return blobsPath, nil
 	case blobPathSpec:
-		components, err := digestPathComponents(v.digest, true)
+		algorithm, prefix, hex, err := digestPathElements(v.digest, true)
 		if err != nil {
 			return "", err
 		}
 
-		blobPathPrefix := append(rootPrefix, "blobs")
-		return path.Join(append(blobPathPrefix, components...)...), nil
+		return joinPath(blobsPath, algorithm, prefix, hex), nil
 	case blobDataPathSpec:
-		components, err := digestPathComponents(v.digest, true)
+		algorithm, prefix, hex, err := digestPathElements(v.digest, true)
 		if err != nil {
 			return "", err
 		}
 
-		components = append(components, "data")
-		blobPathPrefix := append(rootPrefix, "blobs")
-		return path.Join(append(blobPathPrefix, components...)...), nil
+		return joinPath(blobsPath, algorithm, prefix, hex, "data"), nil
 
 	case uploadDataPathSpec:
-		return path.Join(append(repoPrefix, v.name, "_uploads", v.id, "data")...), nil
+		return joinPath(repositoriesPath, v.name, "_uploads", v.id, "data"), nil
 	case uploadStartedAtPathSpec:
-		return path.Join(append(repoPrefix, v.name, "_uploads", v.id, "startedat")...), nil
+		return joinPath(repositoriesPath, v.name, "_uploads", v.id, "startedat"), nil
+	case uploadAbandonedAtPathSpec:
+		return joinPath(repositoriesPath, v.name, "_uploads", v.id, "abandonedat"), nil
 	case uploadHashStatePathSpec:
-		offset := fmt.Sprintf("%d", v.offset)
+		offset := strconv.FormatInt(v.offset, 10)
 		if v.list {
 			offset = "" // Limit to the prefix for listing offsets.
 		}
-		return path.Join(append(repoPrefix, v.name, "_uploads", v.id, "hashstates", string(v.alg), offset)...), nil
+		return joinPath(repositoriesPath, v.name, "_uploads", v.id, "hashstates", string(v.alg), offset), nil
 	case repositoriesRootPathSpec:
-		return path.Join(repoPrefix...), nil
+		return repositoriesPath, nil
+	case reconcileStatePathSpec:
+		return joinPath(rootPath, "maintenance", "reconcile"), nil
+	case garbageCollectionPathSpec:
+		return joinPath(rootPath, "maintenance", "gc"), nil
 	default:
 		// TODO(sday): This is an internal error. Ensure it doesn't escape (panic?).
 		return "", fmt.Errorf("unknown path spec: %#v", v)
 	}
 }
 
+// The clean prefixes of the paths mapped.
+var (
+	rootPath         = path.Join(storagePathRoot, storagePathVersion)
+	repositoriesPath = path.Join(rootPath, "repositories")
+	blobsPath        = path.Join(rootPath, "blobs")
+)
+
+// joinPath joins elems like path.Join, skipping empty elements. The path is
+// built in a single allocation, and path.Clean does not allocate again when
+// it is already clean, which is the case for valid names and digests.
+func joinPath(elems ...string) string {
+	size := 0
+	for _, elem := range elems {
+		size += len(elem) + 1
+	}
+
+	var b strings.Builder
+	b.Grow(size)
+	for _, elem := range elems {
+		if elem == "" {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteByte('/')
+		}
+		b.WriteString(elem)
+	}
+	if b.Len() == 0 {
+		return ""
+	}
+	return path.Clean(b.String())
+}
+
+// checkPathLimits maps specs, the paths a push to the repository name is
+// about to write, and returns an ErrRepositoryNameInvalid error if any of
+// them exceeds limits, so that the push is rejected before the storage
+// backend fails it halfway. Paths are only checked when written: existing
+// content stays readable if the backend tolerates it.
+func checkPathLimits(name string, limits driver.Limits, specs ...pathSpec) error {
+	for _, spec := range specs {
+		p, err := pathFor(spec)
+		if err != nil {
+			return err
+		}
+		if limits.MaxPathLength > 0 && len(p) > limits.MaxPathLength {
+			return distribution.ErrRepositoryNameInvalid{
+				Name:   name,
+				Reason: fmt.Errorf("storage key length %d exceeds the limit of %d bytes of the storage driver", len(p), limits.MaxPathLength),
+			}
+		}
+		if limits.MaxComponentLength > 0 {
+			for component := range strings.SplitSeq(p, "/") {
+				if len(component) > limits.MaxComponentLength {
+					return distribution.ErrRepositoryNameInvalid{
+						Name:   name,
+						Reason: fmt.Errorf("storage key component length %d exceeds the limit of %d bytes of the storage driver", len(component), limits.MaxComponentLength),
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
 // pathSpec is a type to mark structs as path specs. There is no
 // implementation because we'd like to keep the specs and the mappers
 // decoupled.
@@ -298,6 +453,109 @@ type manifestRevisionLinkPathSpec struct {
 
 func (manifestRevisionLinkPathSpec) pathSpec() {}
 
+// manifestArtifactTypesPathSpec describes the directory indexing the artifact
+// types of the manifests pushed to a repository.
+type manifestArtifactTypesPathSpec struct {
+	name string
+}
+
+func (manifestArtifactTypesPathSpec) pathSpec() {}
+
+// manifestArtifactTypePathSpec specifies a path for an artifact type of the
+// manifests pushed to a repository, keyed by the digest of the artifact type.
+// The file holds the artifact type as is, for example:
+//
+//	application/vnd.cncf.helm.config.v1+json
+type manifestArtifactTypePathSpec struct {
+	name         string
+	artifactType string
+}
+
+func (manifestArtifactTypePathSpec) pathSpec() {}
+
+// manifestArtifactTypeManifestsPathSpec describes the directory indexing the
+// manifests of an artifact type pushed to a repository.
+type manifestArtifactTypeManifestsPathSpec struct {
+	name         string
+	artifactType string
+}
+
+func (manifestArtifactTypeManifestsPathSpec) pathSpec() {}
+
+// manifestArtifactTypeLinkPathSpec specifies the link to a manifest of an
+// artifact type pushed to a repository. The file holds the digest of the
+// manifest.
+type manifestArtifactTypeLinkPathSpec struct {
+	name         string
+	artifactType string
+	revision     digest.Digest
+}
+
+func (manifestArtifactTypeLinkPathSpec) pathSpec() {}
+
+// manifestReferrersPathSpec describes the directory indexing the manifests
+// of an artifact type referencing the manifest subject as their subject, or
+// the manifests of all artifact types if artifactType is empty.
+type manifestReferrersPathSpec struct {
+	name         string
+	subject      digest.Digest
+	artifactType string
+}
+
+func (manifestReferrersPathSpec) pathSpec() {}
+
+// manifestReferrerLinkPathSpec specifies the link to the referrer, a manifest
+// of an artifact type referencing the manifest subject as its subject. The
+// file holds the digest of the referrer.
+type manifestReferrerLinkPathSpec struct {
+	name         string
+	subject      digest.Digest
+	artifactType string
+	referrer     digest.Digest
+}
+
+func (manifestReferrerLinkPathSpec) pathSpec() {}
+
+// manifestIndexesPathSpec describes the directory indexing the manifests
+// listing the manifest among their manifests, such as image indexes.
+type manifestIndexesPathSpec struct {
+	name     string
+	manifest digest.Digest
+}
+
+func (manifestIndexesPathSpec) pathSpec() {}
+
+// manifestIndexLinkPathSpec specifies the link to the index, a manifest
+// listing the manifest among its manifests. The file holds the digest of
+// the index.
+type manifestIndexLinkPathSpec struct {
+	name     string
+	manifest digest.Digest
+	index    digest.Digest
+}
+
+func (manifestIndexLinkPathSpec) pathSpec() {}
+
+// manifestsIndexedPathSpec specifies the file marking the manifests of the
+// repository as recorded in the referrers index and the index of the
+// manifests of indexes, including the manifests pushed before these were
+// maintained. The file holds the time the backfill of the indexes completed.
+type manifestsIndexedPathSpec struct {
+	name string
+}
+
+func (manifestsIndexedPathSpec) pathSpec() {}
+
+// manifestTagBatchPathSpec specifies the journal of the batch of tag updates
+// being committed to the repository, listing the tags updated with their
+// previous and new revisions. It is removed once the batch is committed or
+// rolled back.
+type manifestTagBatchPathSpec struct {
+	name string
+}
+
+func (manifestTagBatchPathSpec) pathSpec() {}
+
 // manifestTagsPathSpec describes the path elements required to point to the
 // manifest tags directory.
 type manifestTagsPathSpec struct {
@@ -379,6 +637,18 @@ type layerLinkPathSpec struct {
 
 func (layerLinkPathSpec) pathSpec() {}
 
+// layerMediaTypePathSpec specifies a path for the media type of a blob linked
+// in a repository, as referenced by the manifests pushed to the repository.
+// The file holds the media type as is, for example:
+//
+//	application/vnd.oci.image.config.v1+json
+type layerMediaTypePathSpec struct {
+	name   string
+	digest digest.Digest
+}
+
+func (layerMediaTypePathSpec) pathSpec() {}
+
 // blobAlgorithmReplacer does some very simple path sanitization for user
 // input. Paths should be "safe" before getting this far due to strict digest
 // requirements but we can add further path conversion here, if needed.
@@ -431,6 +701,16 @@ type uploadStartedAtPathSpec struct {
 
 func (uploadStartedAtPathSpec) pathSpec() {}
 
+// uploadAbandonedAtPathSpec defines the path parameters for the file that
+// stores the time an upload was abandoned by its client. Abandoned uploads
+// are purged after a shorter grace period than uploads in progress.
+type uploadAbandonedAtPathSpec struct {
+	name string
+	id   string
+}
+
+func (uploadAbandonedAtPathSpec) pathSpec() {}
+
 // uploadHashStatePathSpec defines the path parameters for the file that stores
 // the hash function state of an upload at a specific byte offset. If `list` is
 // set, then the path mapper will generate a list prefix for all hash state
@@ -450,7 +730,30 @@ type repositoriesRootPathSpec struct{}
 
 func (repositoriesRootPathSpec) pathSpec() {}
 
-// digestPathComponents provides a consistent path breakdown for a given
+// reconcileStatePathSpec returns the path of the progress of the link
+// reconciliation, which holds the name of the last repository checked.
+type reconcileStatePathSpec struct{}
+
+func (reconcileStatePathSpec) pathSpec() {}
+
+// garbageCollectionPathSpec returns the path of the marker of a garbage
+// collection in progress, which holds the time it started.
+type garbageCollectionPathSpec struct{}
+
+func (garbageCollectionPathSpec) pathSpec() {}
+
+// algorithmPaths caches the path elements of the algorithms registered by
+// the digest package, sparing the replacement of the algorithm of every
+// digest mapped.
+var algorithmPaths = map[digest.Algorithm]string{}
+
+func init() {
+	for _, algorithm := range []digest.Algorithm{digest.SHA256, digest.SHA384, digest.SHA512} {
+		algorithmPaths[algorithm] = blobAlgorithmReplacer.Replace(string(algorithm))
+	}
+}
+
+// digestPathElements provides a consistent path breakdown for a given
 // digest. For a generic digest, it will be as follows:
 //
 //	<algorithm>/<hex digest>
@@ -459,24 +762,22 @@ func (repositoriesRootPathSpec) pathSpec() {}
 // groups of digest folder. It will be as follows:
 //
 //	<algorithm>/<first two bytes of digest>/<full digest>
-func digestPathComponents(dgst digest.Digest, multilevel bool) ([]string, error) {
+//
+// The prefix of the first two bytes is empty if multilevel is false.
+func digestPathElements(dgst digest.Digest, multilevel bool) (algorithm, prefix, hex string, err error) {
 	if err := dgst.Validate(); err != nil {
-		return nil, err
+		return "", "", "", err
 	}
 
-	algorithm := blobAlgorithmReplacer.Replace(string(dgst.Algorithm()))
-	hex := dgst.Encoded()
-	prefix := []string{algorithm}
-
-	var suffix []string
-
+	algorithm, ok := algorithmPaths[dgst.Algorithm()]
+	if !ok {
+		algorithm = blobAlgorithmReplacer.Replace(string(dgst.Algorithm()))
+	}
+	hex = dgst.Encoded()
 	if multilevel {
-		suffix = append(suffix, hex[:2])
+		prefix = hex[:2]
 	}
-
-	suffix = append(suffix, hex)
-
-	return append(prefix, suffix...), nil
+	return algorithm, prefix, hex, nil
 }
 
 // Reconstructs a digest from a path
@@ -1,9 +1,19 @@
 package storage
 
 import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
 	"testing"
 
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/filesystem"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
 	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
 func TestPathMapper(t *testing.T) {
@@ -68,7 +78,61 @@ func TestPathMapper(t *testing.T) {
 			},
 			expected: "/docker/registry/v2/repositories/foo/bar/_manifests/tags/thetag/index/sha256/abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789/link",
 		},
+		{
+			spec: manifestArtifactTypePathSpec{
+				name:         "foo/bar",
+				artifactType: "application/vnd.cncf.helm.config.v1+json",
+			},
+			expected: "/docker/registry/v2/repositories/foo/bar/_manifests/artifacttypes/sha256/d03fd797d322869d89b420ab7b3b4219d583e9762ce277962c5d629f9ce65eb3/mediatype",
+		},
+		{
+			spec: manifestArtifactTypeLinkPathSpec{
+				name:         "foo/bar",
+				artifactType: "application/vnd.cncf.helm.config.v1+json",
+				revision:     "sha256:abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789",
+			},
+			expected: "/docker/registry/v2/repositories/foo/bar/_manifests/artifacttypes/sha256/d03fd797d322869d89b420ab7b3b4219d583e9762ce277962c5d629f9ce65eb3/manifests/sha256/abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789/link",
+		},
+		{
+			spec: manifestReferrerLinkPathSpec{
+				name:         "foo/bar",
+				subject:      "sha256:abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789",
+				artifactType: "application/vnd.cncf.helm.config.v1+json",
+				referrer:     "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef",
+			},
+			expected: "/docker/registry/v2/repositories/foo/bar/_manifests/referrers/sha256/abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789/sha256/d03fd797d322869d89b420ab7b3b4219d583e9762ce277962c5d629f9ce65eb3/sha256/0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef/link",
+		},
+		{
+			spec: manifestReferrersPathSpec{
+				name:    "foo/bar",
+				subject: "sha256:abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789",
+			},
+			expected: "/docker/registry/v2/repositories/foo/bar/_manifests/referrers/sha256/abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789",
+		},
+		{
+			spec: manifestIndexLinkPathSpec{
+				name:     "foo/bar",
+				manifest: "sha256:abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789",
+				index:    "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef",
+			},
+			expected: "/docker/registry/v2/repositories/foo/bar/_manifests/indexes/sha256/abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789/sha256/0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef/link",
+		},
+		{
+			spec:     manifestsIndexedPathSpec{name: "foo/bar"},
+			expected: "/docker/registry/v2/repositories/foo/bar/_manifests/indexed",
+		},
+		{
+			spec:     manifestTagBatchPathSpec{name: "foo/bar"},
+			expected: "/docker/registry/v2/repositories/foo/bar/_manifests/tagbatch",
+		},
 
+		{
+			spec: layerMediaTypePathSpec{
+				name:   "foo/bar",
+				digest: "sha256:abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789",
+			},
+			expected: "/docker/registry/v2/repositories/foo/bar/_layers/sha256/abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789/mediatype",
+		},
 		{
 			spec: uploadDataPathSpec{
 				name: "foo/bar",
@@ -134,3 +198,342 @@ func TestDigestFromPath(t *testing.T) {
 		}
 	}
 }
+
+// specsFor returns a spec of every type for the given inputs.
+func specsFor(name, tag, id string, dgst digest.Digest, offset int64) []pathSpec {
+	return []pathSpec{
+		manifestsPathSpec{name: name},
+		manifestRevisionsPathSpec{name: name},
+		manifestRevisionPathSpec{name: name, revision: dgst},
+		manifestRevisionLinkPathSpec{name: name, revision: dgst},
+		manifestArtifactTypesPathSpec{name: name},
+		manifestArtifactTypePathSpec{name: name, artifactType: tag},
+		manifestTagsPathSpec{name: name},
+		manifestTagPathSpec{name: name, tag: tag},
+		manifestTagCurrentPathSpec{name: name, tag: tag},
+		manifestTagIndexPathSpec{name: name, tag: tag},
+		manifestTagIndexEntryPathSpec{name: name, tag: tag, revision: dgst},
+		manifestTagIndexEntryLinkPathSpec{name: name, tag: tag, revision: dgst},
+		layerLinkPathSpec{name: name, digest: dgst},
+		layerMediaTypePathSpec{name: name, digest: dgst},
+		layersPathSpec{name: name},
+		blobsPathSpec{},
+		blobPathSpec{digest: dgst},
+		blobDataPathSpec{digest: dgst},
+		uploadDataPathSpec{name: name, id: id},
+		uploadStartedAtPathSpec{name: name, id: id},
+		uploadHashStatePathSpec{name: name, id: id, alg: digest.SHA256, offset: offset},
+		uploadHashStatePathSpec{name: name, id: id, alg: digest.SHA512, offset: offset, list: true},
+		repositoriesRootPathSpec{},
+		reconcileStatePathSpec{},
+		garbageCollectionPathSpec{},
+	}
+}
+
+// checkLegacyPathFor checks pathFor maps spec as legacyPathFor does.
+func checkLegacyPathFor(t *testing.T, spec pathSpec) {
+	t.Helper()
+
+	expected, expectedErr := legacyPathFor(spec)
+	p, err := pathFor(spec)
+	if (err == nil) != (expectedErr == nil) {
+		t.Fatalf("unexpected error mapping %#v: %v != %v", spec, err, expectedErr)
+	}
+	if p != expected {
+		t.Fatalf("unexpected path for %#v: %q != %q", spec, p, expected)
+	}
+}
+
+func TestPathForLegacy(t *testing.T) {
+	for _, name := range []string{"foo", "foo/bar", "a/b/c/d", "", "foo/../bar", "/foo//bar/"} {
+		for _, tag := range []string{"latest", "v1.0", "", "..", "a/b"} {
+			for _, dgst := range []digest.Digest{
+				"sha256:abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789",
+				digest.SHA384.FromString("foo"),
+				digest.SHA512.FromString("foo"),
+				"sha256:abcdef",
+				"sha256:ABCDEF0123456789abcdef0123456789abcdef0123456789abcdef0123456789",
+				"md5:d41d8cd98f00b204e9800998ecf8427e",
+				"",
+			} {
+				for _, offset := range []int64{0, 42, -1, 1 << 40} {
+					for _, spec := range specsFor(name, tag, "asdf-asdf-asdf-adsf", dgst, offset) {
+						checkLegacyPathFor(t, spec)
+					}
+				}
+			}
+		}
+	}
+}
+
+func BenchmarkPathFor(b *testing.B) {
+	var spec pathSpec = blobDataPathSpec{digest: "sha256:abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789"}
+	for _, bm := range []struct {
+		name    string
+		pathFor func(pathSpec) (string, error)
+	}{
+		{name: "legacy", pathFor: legacyPathFor},
+		{name: "current", pathFor: pathFor},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := bm.pathFor(spec); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// legacyPathFor is the implementation of pathFor which joined slices of path
+// components. The paths it returns are stored on disk: pathFor must return
+// the same.
+func legacyPathFor(spec pathSpec) (string, error) {
+	rootPrefix := []string{storagePathRoot, storagePathVersion}
+	repoPrefix := append(rootPrefix, "repositories")
+
+	switch v := spec.(type) {
+
+	case manifestsPathSpec:
+		return path.Join(append(repoPrefix, v.name, "_manifests")...), nil
+
+	case manifestRevisionsPathSpec:
+		return path.Join(append(repoPrefix, v.name, "_manifests", "revisions")...), nil
+
+	case manifestRevisionPathSpec:
+		components, err := legacyDigestPathComponents(v.revision, false)
+		if err != nil {
+			return "", err
+		}
+
+		return path.Join(append(append(repoPrefix, v.name, "_manifests", "revisions"), components...)...), nil
+	case manifestRevisionLinkPathSpec:
+		root, err := legacyPathFor(manifestRevisionPathSpec(v))
+		if err != nil {
+			return "", err
+		}
+
+		return path.Join(root, "link"), nil
+	case manifestArtifactTypesPathSpec:
+		return path.Join(append(repoPrefix, v.name, "_manifests", "artifacttypes")...), nil
+	case manifestArtifactTypePathSpec:
+		components, err := legacyDigestPathComponents(digest.FromString(v.artifactType), false)
+		if err != nil {
+			return "", err
+		}
+
+		return path.Join(path.Join(append(append(repoPrefix, v.name, "_manifests", "artifacttypes"), components...)...), "mediatype"), nil
+	case manifestTagsPathSpec:
+		return path.Join(append(repoPrefix, v.name, "_manifests", "tags")...), nil
+	case manifestTagPathSpec:
+		root, err := legacyPathFor(manifestTagsPathSpec{
+			name: v.name,
+		})
+		if err != nil {
+			return "", err
+		}
+
+		return path.Join(root, v.tag), nil
+	case manifestTagCurrentPathSpec:
+		root, err := legacyPathFor(manifestTagPathSpec(v))
+		if err != nil {
+			return "", err
+		}
+
+		return path.Join(root, "current", "link"), nil
+	case manifestTagIndexPathSpec:
+		root, err := legacyPathFor(manifestTagPathSpec(v))
+		if err != nil {
+			return "", err
+		}
+
+		return path.Join(root, "index"), nil
+	case manifestTagIndexEntryLinkPathSpec:
+		root, err := legacyPathFor(manifestTagIndexEntryPathSpec(v))
+		if err != nil {
+			return "", err
+		}
+
+		return path.Join(root, "link"), nil
+	case manifestTagIndexEntryPathSpec:
+		root, err := legacyPathFor(manifestTagIndexPathSpec{
+			name: v.name,
+			tag:  v.tag,
+		})
+		if err != nil {
+			return "", err
+		}
+
+		components, err := legacyDigestPathComponents(v.revision, false)
+		if err != nil {
+			return "", err
+		}
+
+		return path.Join(root, path.Join(components...)), nil
+	case layerLinkPathSpec:
+		components, err := legacyDigestPathComponents(v.digest, false)
+		if err != nil {
+			return "", err
+		}
+
+		blobLinkPathComponents := append(repoPrefix, v.name, "_layers")
+
+		return path.Join(path.Join(append(blobLinkPathComponents, components...)...), "link"), nil
+	case layerMediaTypePathSpec:
+		components, err := legacyDigestPathComponents(v.digest, false)
+		if err != nil {
+			return "", err
+		}
+
+		return path.Join(path.Join(append(append(repoPrefix, v.name, "_layers"), components...)...), "mediatype"), nil
+	case layersPathSpec:
+		return path.Join(append(repoPrefix, v.name, "_layers")...), nil
+	case blobsPathSpec:
+		blobsPathPrefix := append(rootPrefix, "blobs")
+		return path.Join(blobsPathPrefix...), nil
+	case blobPathSpec:
+		components, err := legacyDigestPathComponents(v.digest, true)
+		if err != nil {
+			return "", err
+		}
+
+		blobPathPrefix := append(rootPrefix, "blobs")
+		return path.Join(append(blobPathPrefix, components...)...), nil
+	case blobDataPathSpec:
+		components, err := legacyDigestPathComponents(v.digest, true)
+		if err != nil {
+			return "", err
+		}
+
+		components = append(components, "data")
+		blobPathPrefix := append(rootPrefix, "blobs")
+		return path.Join(append(blobPathPrefix, components...)...), nil
+
+	case uploadDataPathSpec:
+		return path.Join(append(repoPrefix, v.name, "_uploads", v.id, "data")...), nil
+	case uploadStartedAtPathSpec:
+		return path.Join(append(repoPrefix, v.name, "_uploads", v.id, "startedat")...), nil
+	case uploadHashStatePathSpec:
+		offset := fmt.Sprintf("%d", v.offset)
+		if v.list {
+			offset = "" // Limit to the prefix for listing offsets.
+		}
+		return path.Join(append(repoPrefix, v.name, "_uploads", v.id, "hashstates", string(v.alg), offset)...), nil
+	case repositoriesRootPathSpec:
+		return path.Join(repoPrefix...), nil
+	case reconcileStatePathSpec:
+		return path.Join(append(rootPrefix, "maintenance", "reconcile")...), nil
+	case garbageCollectionPathSpec:
+		return path.Join(append(rootPrefix, "maintenance", "gc")...), nil
+	default:
+		return "", fmt.Errorf("unknown path spec: %#v", v)
+	}
+}
+
+func legacyDigestPathComponents(dgst digest.Digest, multilevel bool) ([]string, error) {
+	if err := dgst.Validate(); err != nil {
+		return nil, err
+	}
+
+	algorithm := blobAlgorithmReplacer.Replace(string(dgst.Algorithm()))
+	hex := dgst.Encoded()
+	prefix := []string{algorithm}
+
+	var suffix []string
+
+	if multilevel {
+		suffix = append(suffix, hex[:2])
+	}
+
+	suffix = append(suffix, hex)
+
+	return append(prefix, suffix...), nil
+}
+
+// limitedDriver simulates the limits of a storage backend.
+type limitedDriver struct {
+	driver.StorageDriver
+	limits driver.Limits
+}
+
+func (d *limitedDriver) DriverLimits() driver.Limits {
+	return d.limits
+}
+
+func TestCheckPathLimitsFilesystem(t *testing.T) {
+	d, err := filesystem.FromParameters(map[string]any{"rootdirectory": t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	limits := driver.LimitsOf(d)
+	if limits.MaxComponentLength != 255 {
+		t.Fatalf("unexpected component limit: %d", limits.MaxComponentLength)
+	}
+
+	// a component of the longest repository name fits NAME_MAX
+	name := strings.Repeat("a", 255)
+	if err := checkPathLimits(name, limits, manifestTagsPathSpec{name: name}); err != nil {
+		t.Fatalf("unexpected error checking %d bytes component: %v", len(name), err)
+	}
+
+	name = "library/" + strings.Repeat("a", 256)
+	err = checkPathLimits(name, limits, manifestTagsPathSpec{name: name})
+	if !errors.As(err, new(distribution.ErrRepositoryNameInvalid)) {
+		t.Fatalf("expected ErrRepositoryNameInvalid, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "component length 256 exceeds the limit of 255 bytes") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestCheckPathLimits(t *testing.T) {
+	ctx := dcontext.Background()
+	// the 1024 bytes S3 key limit, shortened by a root directory of 800 bytes
+	d := &limitedDriver{StorageDriver: inmemory.New(), limits: driver.Limits{MaxPathLength: 1024 - 800}}
+	registry := createRegistry(t, d)
+
+	longName := strings.Repeat("a", 200)
+	_, err := makeRepository(t, registry, longName).Blobs(ctx).Create(ctx)
+	if !errors.As(err, new(distribution.ErrRepositoryNameInvalid)) {
+		t.Fatalf("expected ErrRepositoryNameInvalid starting an upload, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "exceeds the limit of 224 bytes") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	repo := makeRepository(t, registry, "foo")
+	upload, err := repo.Blobs(ctx).Create(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error starting an upload: %v", err)
+	}
+	if err := upload.Cancel(ctx); err != nil {
+		t.Fatalf("unexpected error cancelling the upload: %v", err)
+	}
+
+	image := uploadRandomSchema2Image(t, repo)
+	desc := v1.Descriptor{Digest: image.manifestDigest}
+	if err := repo.Tags(ctx).Tag(ctx, "latest", desc); err != nil {
+		t.Fatalf("unexpected error tagging: %v", err)
+	}
+	err = repo.Tags(ctx).Tag(ctx, strings.Repeat("t", 128), desc)
+	if !errors.As(err, new(distribution.ErrRepositoryNameInvalid)) {
+		t.Fatalf("expected ErrRepositoryNameInvalid tagging, got %v", err)
+	}
+
+	// content written before the limits were enforced stays readable
+	tagPath, err := pathFor(manifestTagCurrentPathSpec{name: longName, tag: "latest"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.PutContent(ctx, tagPath, []byte(image.manifestDigest)); err != nil {
+		t.Fatal(err)
+	}
+	tagged, err := makeRepository(t, registry, longName).Tags(ctx).Get(ctx, "latest")
+	if err != nil {
+		t.Fatalf("unexpected error reading the tag of the long name: %v", err)
+	}
+	if tagged.Digest != image.manifestDigest {
+		t.Fatalf("unexpected digest: %s", tagged.Digest)
+	}
+}
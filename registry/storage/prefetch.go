@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"context"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/manifest/schema2"
+	"github.com/hashicorp/golang-lru/arc/v2"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	// blobDescriptorHintsSize is the number of descriptors held by the hint
+	// cache.
+	blobDescriptorHintsSize = 10000
+
+	// blobDescriptorPrefetchWorkers bounds the number of manifests whose
+	// descriptors are hinted at concurrently. The manifests fetched while
+	// all the workers are busy are not prefetched.
+	blobDescriptorPrefetchWorkers = 4
+)
+
+type blobDescriptorHintKey struct {
+	repo   string
+	digest digest.Digest
+}
+
+// blobDescriptorHints caches the descriptors of the blobs referenced by the
+// manifests fetched, as found in the manifests. A hint never proves that a
+// blob exists: it only spares the lookup of the blob in the blob store once
+// its link in the repository is found.
+type blobDescriptorHints struct {
+	descriptors *arc.ARCCache[blobDescriptorHintKey, v1.Descriptor]
+	workers     chan struct{}
+}
+
+// PrefetchBlobDescriptors is a functional option for NewRegistry. When an
+// image manifest is fetched, the descriptors of its config and layers are
+// hinted in the background, as clients fetch them next, without any call to
+// the storage driver. The lookups of the hinted blobs then only read their
+// link in the repository.
+func PrefetchBlobDescriptors(registry *registry) error {
+	descriptors, err := arc.NewARC[blobDescriptorHintKey, v1.Descriptor](blobDescriptorHintsSize)
+	if err != nil {
+		return err
+	}
+	registry.blobDescriptorHints = &blobDescriptorHints{
+		descriptors: descriptors,
+		workers:     make(chan struct{}, blobDescriptorPrefetchWorkers),
+	}
+	return nil
+}
+
+// prefetchBlobDescriptors hints at the descriptors of the blobs referenced by
+// manifest, if enabled, without waiting for it.
+func (ms *manifestStore) prefetchBlobDescriptors(ctx context.Context, manifest distribution.Manifest) {
+	hints := ms.repository.blobDescriptorHints
+	if hints == nil {
+		return
+	}
+
+	switch manifest.(type) {
+	case *ocischema.DeserializedManifest, *schema2.DeserializedManifest:
+	default:
+		// the references of indexes are manifests, fetched by digest
+		// through the manifest store.
+		return
+	}
+
+	select {
+	case hints.workers <- struct{}{}:
+	default:
+		dcontext.GetLogger(ctx).Debugf("skipping the prefetch of the blob descriptors of repository %s, all the workers are busy", ms.repository.Named().Name())
+		return
+	}
+	go func() {
+		defer func() { <-hints.workers }()
+		hints.add(ms.repository.Named().Name(), manifest.References())
+	}()
+}
+
+// add hints at the descriptors of blobs in the repository name.
+func (hints *blobDescriptorHints) add(name string, blobs []v1.Descriptor) {
+	for _, blob := range blobs {
+		if len(blob.URLs) > 0 || blob.Digest.Validate() != nil {
+			// foreign layers are not stored in the registry
+			continue
+		}
+		// the media type is the one of the blob store, the media type of
+		// the repository is looked up with the link
+		hints.descriptors.Add(blobDescriptorHintKey{repo: name, digest: blob.Digest}, v1.Descriptor{
+			MediaType: "application/octet-stream",
+			Digest:    blob.Digest,
+			Size:      blob.Size,
+		})
+	}
+}
+
+// get returns the descriptor hinted for the blob dgst of the repository
+// name, if any.
+func (hints *blobDescriptorHints) get(name string, dgst digest.Digest) (v1.Descriptor, bool) {
+	if hints == nil {
+		return v1.Descriptor{}, false
+	}
+	return hints.descriptors.Get(blobDescriptorHintKey{repo: name, digest: dgst})
+}
@@ -0,0 +1,187 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/registry/storage/cache/memory"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// countingDriver counts the calls looking up files.
+type countingDriver struct {
+	storagedriver.StorageDriver
+	gets  atomic.Int64
+	stats atomic.Int64
+}
+
+func (d *countingDriver) GetContent(ctx context.Context, path string) ([]byte, error) {
+	d.gets.Add(1)
+	return d.StorageDriver.GetContent(ctx, path)
+}
+
+func (d *countingDriver) Stat(ctx context.Context, path string) (storagedriver.FileInfo, error) {
+	d.stats.Add(1)
+	return d.StorageDriver.Stat(ctx, path)
+}
+
+func TestPrefetchBlobDescriptors(t *testing.T) {
+	ctx := context.Background()
+	name, _ := reference.WithName("foo/bar")
+	driver := &countingDriver{StorageDriver: inmemory.New()}
+
+	// push an image, and an image whose layer is missing from the
+	// repository
+	pusher, err := NewRegistry(ctx, driver, EnableDelete)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo, err := pusher.Repository(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bs := repo.Blobs(ctx)
+	ms, err := repo.Manifests(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var blobs []v1.Descriptor
+	for _, content := range [][]byte{[]byte("{}"), []byte("layer")} {
+		desc, err := addBlob(ctx, bs, v1.Descriptor{Digest: digest.FromBytes(content), Size: int64(len(content))}, bytes.NewReader(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		blobs = append(blobs, desc)
+	}
+	image, err := ocischema.FromStruct(ocischema.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: v1.MediaTypeImageManifest,
+		Config:    v1.Descriptor{MediaType: v1.MediaTypeImageConfig, Digest: blobs[0].Digest, Size: blobs[0].Size},
+		Layers:    []v1.Descriptor{{MediaType: v1.MediaTypeImageLayer, Digest: blobs[1].Digest, Size: blobs[1].Size}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	imageDigest, err := ms.Put(ctx, image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	missing := v1.Descriptor{MediaType: v1.MediaTypeImageLayer, Digest: digest.FromString("missing"), Size: 7}
+	incomplete, err := ocischema.FromStruct(ocischema.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: v1.MediaTypeImageManifest,
+		Config:    v1.Descriptor{MediaType: v1.MediaTypeImageConfig, Digest: blobs[0].Digest, Size: blobs[0].Size},
+		Layers:    []v1.Descriptor{missing},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	skipVerification, err := repo.Manifests(ctx, SkipLayerVerification())
+	if err != nil {
+		t.Fatal(err)
+	}
+	incompleteDigest, err := skipVerification.Put(ctx, incomplete)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a registry with cold caches pulls the image
+	registry, err := NewRegistry(ctx, driver, BlobDescriptorCacheProvider(memory.NewInMemoryBlobDescriptorCacheProvider(memory.UnlimitedSize)), PrefetchBlobDescriptors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo, err = registry.Repository(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hints := repo.(*repository).blobDescriptorHints
+	ms, err = repo.Manifests(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gets, stats := driver.gets.Load(), driver.stats.Load()
+	if _, err := ms.Get(ctx, imageDigest); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ms.Get(ctx, incompleteDigest); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for _, blob := range append(blobs, missing) {
+		for {
+			if _, ok := hints.get(name.Name(), blob.Digest); ok {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("descriptor of blob %s not prefetched", blob.Digest)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	// only the manifests are looked up, with a read of their link and a
+	// stat of their content each
+	if n := driver.gets.Load() - gets; n != 2 {
+		t.Fatalf("unexpected reads in the storage driver for manifest GETs: %d", n)
+	}
+	if n := driver.stats.Load() - stats; n != 2 {
+		t.Fatalf("unexpected stats in the storage driver for manifest GETs: %d", n)
+	}
+
+	// blob HEADs only read the links of the blobs
+	gets, stats = driver.gets.Load(), driver.stats.Load()
+	bs = repo.Blobs(ctx)
+	for _, blob := range blobs {
+		desc, err := bs.Stat(ctx, blob.Digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if desc.Digest != blob.Digest || desc.Size != blob.Size {
+			t.Fatalf("unexpected descriptor of blob %s: %v", blob.Digest, desc)
+		}
+		w := httptest.NewRecorder()
+		if err := bs.ServeBlob(ctx, w, httptest.NewRequest(http.MethodHead, "/", nil), blob.Digest); err != nil {
+			t.Fatal(err)
+		}
+		if w.Code != http.StatusOK || w.Header().Get("Content-Length") != strconv.FormatInt(blob.Size, 10) {
+			t.Fatalf("unexpected response to blob %s HEAD: %d %v", blob.Digest, w.Code, w.Header())
+		}
+	}
+	if n := driver.gets.Load() - gets; n != int64(len(blobs)) {
+		t.Fatalf("unexpected reads in the storage driver for blob HEADs: %d", n)
+	}
+	if n := driver.stats.Load() - stats; n != 0 {
+		t.Fatalf("unexpected stats in the storage driver for blob HEADs: %d", n)
+	}
+
+	// hints do not prove that blobs exist
+	if _, err := bs.Stat(ctx, missing.Digest); err != distribution.ErrBlobUnknown {
+		t.Fatalf("expected the missing blob to be unknown, got %v", err)
+	}
+
+	// nothing is prefetched unless enabled
+	registry, err = NewRegistry(ctx, driver, BlobDescriptorCacheProvider(memory.NewInMemoryBlobDescriptorCacheProvider(memory.UnlimitedSize)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo, err = registry.Repository(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hints := repo.(*repository).blobDescriptorHints; hints != nil {
+		t.Fatalf("expected no hints, got %v", hints)
+	}
+}
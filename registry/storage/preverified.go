@@ -0,0 +1,143 @@
+package storage
+
+import (
+	"context"
+	"io"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	prometheus "github.com/distribution/distribution/v3/metrics"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/reference"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+type preverifiedDigestKey struct{}
+
+// WithPreverifiedDigest returns a context marking the blob uploads created or
+// resumed with it as pre-verified: the client vouches for the digest provided
+// on commit, so content is not hashed as it is written. Instead, committed
+// blobs are linked right away and verified in the background, then removed if
+// their content does not match their digest.
+func WithPreverifiedDigest(ctx context.Context) context.Context {
+	return context.WithValue(ctx, preverifiedDigestKey{}, true)
+}
+
+// isPreverifiedDigest reports whether ctx was marked by WithPreverifiedDigest.
+func isPreverifiedDigest(ctx context.Context) bool {
+	preverified, _ := ctx.Value(preverifiedDigestKey{}).(bool)
+	return preverified
+}
+
+// DigestMismatchFunc is called when a pre-verified blob pushed to repo is
+// found not to match its digest, once it has been removed.
+type DigestMismatchFunc func(ctx context.Context, repo reference.Named, desc v1.Descriptor)
+
+// OnPreverifiedDigestMismatch is a functional option for NewRegistry. It sets
+// the function called when the background verification of a pre-verified
+// blob fails.
+func OnPreverifiedDigestMismatch(fn DigestMismatchFunc) RegistryOption {
+	return func(registry *registry) error {
+		registry.digestMismatch = fn
+		return nil
+	}
+}
+
+// Results of the background verification of pre-verified blobs.
+const (
+	preverifiedMatch    = "match"
+	preverifiedMismatch = "mismatch"
+	preverifiedError    = "error"
+)
+
+var preverifiedVerifications = prometheus.StorageNamespace.NewLabeledCounter("preverified_verifications", "The number of background verifications of blobs pushed with a pre-verified digest", "result")
+
+// needsPreverification reports whether the content of a pre-verified upload
+// committed for desc must be verified once stored. It is not if the blob is
+// already in the blob store, whose content was verified, or if it is the empty
+// blob. The descriptor returned then has the size of the stored blob.
+func (bw *blobWriter) needsPreverification(ctx context.Context, desc v1.Descriptor) (v1.Descriptor, bool, error) {
+	if desc.Digest == digestSha256Empty {
+		return desc, false, nil
+	}
+
+	ctx = storagedriver.WithRepository(ctx, bw.blobStore.repository.Named().Name())
+	blobPath, err := pathFor(blobDataPathSpec{digest: desc.Digest})
+	if err != nil {
+		return desc, false, err
+	}
+	if fi, err := bw.blobStore.driver.Stat(ctx, blobPath); err == nil {
+		desc.Size = fi.Size()
+		return desc, false, nil
+	} else if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+		return desc, false, err
+	}
+	return desc, true, nil
+}
+
+// verifyPreverifiedBlob hashes the content of a committed pre-verified blob,
+// which is stored and linked into the repository like any other blob, so that
+// manifests may reference it right away. If the content does not match desc,
+// the blob is removed: it is unlinked from the repository, its data is deleted
+// from the blob store and the mismatch is reported.
+func (lbs *linkedBlobStore) verifyPreverifiedBlob(ctx context.Context, desc v1.Descriptor) {
+	logger := dcontext.GetLoggerWithField(ctx, "digest", desc.Digest)
+	name := lbs.repository.Named().Name()
+	ctx = storagedriver.WithRepository(ctx, name)
+
+	blobPath, err := pathFor(blobDataPathSpec{digest: desc.Digest})
+	if err != nil {
+		preverifiedVerifications.WithValues(preverifiedError).Inc(1)
+		logger.Errorf("unable to verify pre-verified blob: %v", err)
+		return
+	}
+
+	verified, err := lbs.hashPreverifiedBlob(ctx, desc, blobPath)
+	if err != nil {
+		preverifiedVerifications.WithValues(preverifiedError).Inc(1)
+		logger.Errorf("unable to verify pre-verified blob pushed to %s, serving it unverified: %v", name, err)
+		return
+	}
+	if verified {
+		preverifiedVerifications.WithValues(preverifiedMatch).Inc(1)
+		logger.Debug("pre-verified blob matches its digest")
+		return
+	}
+
+	preverifiedVerifications.WithValues(preverifiedMismatch).Inc(1)
+	logger.Errorf("pre-verified blob pushed to %s does not match its digest, removing it", name)
+	if err := lbs.blobAccessController.Clear(ctx, desc.Digest); err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+			logger.Errorf("unable to unlink mismatched pre-verified blob: %v", err)
+		}
+	}
+	if err := lbs.driver.Delete(ctx, blobPath); err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+			logger.Errorf("unable to delete mismatched pre-verified blob: %v", err)
+		}
+	}
+	if provider := lbs.registry.blobDescriptorCacheProvider; provider != nil {
+		if err := provider.Clear(ctx, desc.Digest); err != nil && err != distribution.ErrBlobUnknown {
+			logger.Errorf("unable to clear the cached descriptor of mismatched pre-verified blob: %v", err)
+		}
+	}
+	if lbs.registry.digestMismatch != nil {
+		lbs.registry.digestMismatch(ctx, lbs.repository.Named(), desc)
+	}
+}
+
+// hashPreverifiedBlob reports whether the content stored at blobPath matches
+// desc.
+func (lbs *linkedBlobStore) hashPreverifiedBlob(ctx context.Context, desc v1.Descriptor, blobPath string) (bool, error) {
+	fr, err := newFileReader(ctx, lbs.driver, blobPath, desc.Size)
+	if err != nil {
+		return false, err
+	}
+	defer fr.Close()
+
+	verifier := desc.Digest.Verifier()
+	if _, err := io.Copy(verifier, fr); err != nil {
+		return false, err
+	}
+	return verifier.Verified(), nil
+}
@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"path"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
+)
+
+// errRepositoryNotEmpty stops the enumeration of the manifests of a
+// repository at the first one.
+var errRepositoryNotEmpty = errors.New("repository not empty")
+
+// pruned prunes the repository if pruning empty repositories is enabled and
+// the repository is now empty. The deletion which emptied the repository has
+// succeeded already, so failures are only logged.
+func (repo *repository) pruned(ctx context.Context) {
+	if !repo.pruneEmptyEnabled {
+		return
+	}
+	if err := repo.pruneIfEmpty(ctx); err != nil {
+		dcontext.GetLogger(ctx).Errorf("failed to prune empty repository %s: %v", repo.name.Name(), err)
+	}
+}
+
+// pruneIfEmpty removes the repository if it has neither tags nor manifests
+// left, so that it is no longer listed by the catalog. Its directory is
+// removed as well, along with the parent directories left empty, unless
+// uploads to the repository are in progress.
+func (repo *repository) pruneIfEmpty(ctx context.Context) error {
+	empty, err := repo.isEmpty(ctx)
+	if err != nil || !empty {
+		return err
+	}
+
+	root, err := pathFor(repositoriesRootPathSpec{})
+	if err != nil {
+		return err
+	}
+	repoPath := path.Join(root, repo.name.Name())
+	dcontext.GetLogger(ctx).Infof("pruning empty repository %s", repo.name.Name())
+
+	// the catalog lists the repositories by their manifests directory,
+	// which is removed first
+	for _, dir := range []string{"_manifests", "_layers"} {
+		if err := deleteIfExists(ctx, repo.driver, path.Join(repoPath, dir)); err != nil {
+			return err
+		}
+	}
+
+	uploads, err := repo.driver.List(ctx, path.Join(repoPath, "_uploads"))
+	if err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+			return err
+		}
+	}
+	if len(uploads) > 0 {
+		return nil
+	}
+	if err := deleteIfExists(ctx, repo.driver, repoPath); err != nil {
+		return err
+	}
+
+	// remove the namespaces left empty, such as "library" once
+	// "library/ubuntu" is removed
+	for dir := path.Dir(repoPath); dir != root && dir != "/"; dir = path.Dir(dir) {
+		entries, err := repo.driver.List(ctx, dir)
+		if err != nil {
+			if _, ok := err.(storagedriver.PathNotFoundError); ok {
+				continue
+			}
+			return err
+		}
+		if len(entries) > 0 {
+			return nil
+		}
+		if err := deleteIfExists(ctx, repo.driver, dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isEmpty reports whether the repository has neither tags nor manifests.
+func (repo *repository) isEmpty(ctx context.Context) (bool, error) {
+	tags, err := repo.Tags(ctx).All(ctx)
+	if err != nil && !errors.As(err, new(distribution.ErrRepositoryUnknown)) {
+		return false, err
+	}
+	if len(tags) > 0 {
+		return false, nil
+	}
+
+	manifests, err := repo.Manifests(ctx)
+	if err != nil {
+		return false, err
+	}
+	enumerator, ok := manifests.(distribution.ManifestEnumerator)
+	if !ok {
+		return false, nil
+	}
+	// the storage drivers may wrap the error stopping the enumeration, so
+	// finding a manifest is recorded apart
+	found := false
+	err = enumerator.Enumerate(ctx, func(digest.Digest) error {
+		found = true
+		return errRepositoryNotEmpty
+	})
+	if found {
+		return false, nil
+	}
+	if _, ok := err.(storagedriver.PathNotFoundError); ok {
+		return true, nil
+	}
+	return err == nil, err
+}
+
+// deleteIfExists deletes path, which may not exist.
+func deleteIfExists(ctx context.Context, driver storagedriver.StorageDriver, path string) error {
+	err := driver.Delete(ctx, path)
+	if _, ok := err.(storagedriver.PathNotFoundError); ok {
+		return nil
+	}
+	return err
+}
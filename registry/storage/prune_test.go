@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestPruneEmpty(t *testing.T) {
+	ctx := context.Background()
+	const root = "/docker/registry/v2/repositories"
+
+	for _, tc := range []struct {
+		name    string
+		options []RegistryOption
+		upload  bool
+		pruned  []string
+		kept    []string
+	}{
+		{
+			name: "disabled",
+			kept: []string{root + "/ns/a/_manifests", root + "/ns/a/_layers"},
+		},
+		{
+			name:    "enabled",
+			options: []RegistryOption{EnablePruneEmpty},
+			pruned:  []string{root + "/ns"},
+		},
+		{
+			name:    "upload in progress",
+			options: []RegistryOption{EnablePruneEmpty},
+			upload:  true,
+			pruned:  []string{root + "/ns/a/_manifests", root + "/ns/a/_layers"},
+			kept:    []string{root + "/ns/a/_uploads"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			driver := inmemory.New()
+			registry := createRegistry(t, driver, tc.options...)
+			repo := makeRepository(t, registry, "ns/a")
+			ms := makeManifestService(t, repo)
+			tags := repo.Tags(ctx)
+
+			image := uploadRandomOCIImage(t, repo)
+			for _, tag := range []string{"latest", "stable"} {
+				if err := tags.Tag(ctx, tag, v1.Descriptor{Digest: image.manifestDigest}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tc.upload {
+				upload, err := repo.Blobs(ctx).Create(ctx)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer upload.Cancel(ctx)
+			}
+
+			// the repository is kept while tags or manifests are left
+			if err := tags.Untag(ctx, "stable"); err != nil {
+				t.Fatal(err)
+			}
+			if err := tags.Untag(ctx, "latest"); err != nil {
+				t.Fatal(err)
+			}
+			if empty, err := repo.(*repository).isEmpty(ctx); err != nil || empty {
+				t.Fatalf("expected the untagged manifest to keep the repository: %v", err)
+			}
+			if _, err := driver.Stat(ctx, root+"/ns/a/_manifests"); err != nil {
+				t.Fatalf("repository pruned while a manifest is left: %v", err)
+			}
+
+			if err := ms.Delete(ctx, image.manifestDigest); err != nil {
+				t.Fatal(err)
+			}
+			for _, p := range tc.pruned {
+				if _, err := driver.Stat(ctx, p); err == nil {
+					t.Fatalf("expected %s to be pruned", p)
+				}
+			}
+			for _, p := range tc.kept {
+				if _, err := driver.Stat(ctx, p); err != nil {
+					t.Fatalf("expected %s to be kept: %v", p, err)
+				}
+			}
+		})
+	}
+}
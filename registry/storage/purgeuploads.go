@@ -13,10 +13,12 @@ import (
 )
 
 // uploadData stored the location of temporary files created during a layer upload
-// along with the date the upload was started
+// along with the date the upload was started, and the date it was abandoned
+// by its client, if it was
 type uploadData struct {
 	containingDir string
 	startedAt     time.Time
+	abandonedAt   time.Time
 }
 
 func newUploadData() uploadData {
@@ -31,14 +33,28 @@ func newUploadData() uploadData {
 // created before olderThan.  The list of files deleted and errors
 // encountered are returned
 func PurgeUploads(ctx context.Context, driver storageDriver.StorageDriver, olderThan time.Time, actuallyDelete bool) ([]string, []error) {
-	logrus.Infof("PurgeUploads starting: olderThan=%s, actuallyDelete=%t", olderThan, actuallyDelete)
+	return PurgeAbandonedUploads(ctx, driver, olderThan, time.Time{}, actuallyDelete)
+}
+
+// PurgeAbandonedUploads deletes files from the upload directory created
+// before olderThan, as well as those of the uploads abandoned by their client
+// before abandonedBefore.  The list of files deleted and errors encountered
+// are returned
+func PurgeAbandonedUploads(ctx context.Context, driver storageDriver.StorageDriver, olderThan, abandonedBefore time.Time, actuallyDelete bool) ([]string, []error) {
+	logrus.Infof("PurgeUploads starting: olderThan=%s, abandonedBefore=%s, actuallyDelete=%t", olderThan, abandonedBefore, actuallyDelete)
 	uploadData, errors := getOutstandingUploads(ctx, driver)
 	var deleted []string
 	for _, uploadData := range uploadData {
-		if uploadData.startedAt.Before(olderThan) {
+		abandoned := !uploadData.abandonedAt.IsZero() && uploadData.abandonedAt.Before(abandonedBefore)
+		if uploadData.startedAt.Before(olderThan) || abandoned {
 			var err error
-			logrus.Infof("Upload files in %s have older date (%s) than purge date (%s).  Removing upload directory.",
-				uploadData.containingDir, uploadData.startedAt, olderThan)
+			if abandoned {
+				logrus.Infof("Upload files in %s were abandoned (%s) before the abandoned purge date (%s).  Removing upload directory.",
+					uploadData.containingDir, uploadData.abandonedAt, abandonedBefore)
+			} else {
+				logrus.Infof("Upload files in %s have older date (%s) than purge date (%s).  Removing upload directory.",
+					uploadData.containingDir, uploadData.startedAt, olderThan)
+			}
 			if actuallyDelete {
 				err = driver.Delete(ctx, uploadData.containingDir)
 			}
@@ -99,12 +115,21 @@ func getOutstandingUploads(ctx context.Context, driver storageDriver.StorageDriv
 			ud.containingDir = filePath
 		}
 		if file == "startedat" {
-			if t, err := readStartedAtFile(ctx, driver, filePath); err == nil {
+			if t, err := readUploadDateFile(ctx, driver, filePath); err == nil {
 				ud.startedAt = t
 			} else {
 				errors = pushError(errors, filePath, err)
 			}
 		}
+		if file == "abandonedat" {
+			// the marker may have been cleared by a resumed upload since
+			// it was listed
+			if t, err := readUploadDateFile(ctx, driver, filePath); err == nil {
+				ud.abandonedAt = t
+			} else if _, ok := err.(storageDriver.PathNotFoundError); !ok {
+				errors = pushError(errors, filePath, err)
+			}
+		}
 
 		uploads[uuid] = ud
 		return nil
@@ -129,8 +154,8 @@ func uuidFromPath(path string) (string, bool) {
 	return "", false
 }
 
-// readStartedAtFile reads the date from an upload's startedAtFile
-func readStartedAtFile(ctx context.Context, driver storageDriver.StorageDriver, path string) (time.Time, error) {
+// readUploadDateFile reads the date from an upload's startedat or abandonedat file
+func readUploadDateFile(ctx context.Context, driver storageDriver.StorageDriver, path string) (time.Time, error) {
 	startedAtBytes, err := driver.GetContent(ctx, path)
 	if err != nil {
 		return time.Now(), err
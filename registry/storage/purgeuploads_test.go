@@ -163,3 +163,45 @@ func TestPurgeMissingStartedAt(t *testing.T) {
 		t.Errorf("Files unexpectedly deleted: %s", deleted)
 	}
 }
+
+func TestPurgeAbandoned(t *testing.T) {
+	oneDayAgo := time.Now().Add(-24 * time.Hour)
+	fs, ctx := testUploadFS(t, 3, "test-repo", oneDayAgo)
+
+	// uploads abandoned two hours ago are past the grace period, unlike
+	// those abandoned ten minutes ago
+	abandoned := uuid.NewString()
+	addUploads(ctx, t, fs, abandoned, "test-repo", oneDayAgo)
+	recent := uuid.NewString()
+	addUploads(ctx, t, fs, recent, "test-repo", oneDayAgo)
+	for id, abandonedAt := range map[string]time.Time{
+		abandoned: time.Now().Add(-2 * time.Hour),
+		recent:    time.Now().Add(-10 * time.Minute),
+	} {
+		abandonedAtPath, err := pathFor(uploadAbandonedAtPathSpec{name: "test-repo", id: id})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := fs.PutContent(ctx, abandonedAtPath, []byte(abandonedAt.Format(time.RFC3339))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// unmarked uploads keep the age policy
+	deleted, errs := PurgeAbandonedUploads(ctx, fs, time.Now().Add(-7*24*time.Hour), time.Now().Add(-time.Hour), true)
+	if len(errs) != 0 {
+		t.Error("Unexpected errors:", errs)
+	}
+	if len(deleted) != 1 || path.Base(deleted[0]) != abandoned {
+		t.Fatalf("expected only the abandoned upload to be deleted: %v", deleted)
+	}
+
+	// without a grace period, abandoned uploads keep the age policy
+	deleted, errs = PurgeAbandonedUploads(ctx, fs, time.Now().Add(-7*24*time.Hour), time.Time{}, true)
+	if len(errs) != 0 {
+		t.Error("Unexpected errors:", errs)
+	}
+	if len(deleted) != 0 {
+		t.Fatalf("Files unexpectedly deleted: %s", deleted)
+	}
+}
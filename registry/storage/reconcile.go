@@ -0,0 +1,399 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	prometheus "github.com/distribution/distribution/v3/metrics"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
+)
+
+// Kinds of the links checked by Reconcile.
+const (
+	ReconcileTagLink      = "tag"
+	ReconcileRevisionLink = "revision"
+	ReconcileLayerLink    = "layer"
+)
+
+// Reasons for which Reconcile reports a link as dangling.
+const (
+	ReconcileInvalidLink     = "invalid link"
+	ReconcileMissingBlob     = "missing blob"
+	ReconcileMissingRevision = "missing revision"
+)
+
+// ErrGarbageCollectionRunning is returned by Reconcile when it stops as a
+// garbage collection is in progress.
+var ErrGarbageCollectionRunning = errors.New("garbage collection in progress")
+
+var (
+	reconcileRepositories = prometheus.StorageNamespace.NewCounter("reconcile_repositories", "The number of repositories checked by the link reconciliation")
+	reconcileIssues       = prometheus.StorageNamespace.NewLabeledCounter("reconcile_issues", "The number of dangling links found by the link reconciliation", "kind", "reason", "repaired")
+)
+
+// ReconcileOpts contains options for the link reconciliation.
+type ReconcileOpts struct {
+	// Repositories is the maximum number of repositories checked by a run.
+	Repositories int
+	// Repair removes the dangling links found. Blob data is never removed.
+	Repair bool
+}
+
+// ReconcileIssue describes a dangling link found by Reconcile.
+type ReconcileIssue struct {
+	Repository string        `json:"repository"`
+	Kind       string        `json:"kind"`
+	Reason     string        `json:"reason"`
+	Path       string        `json:"path"`
+	Tag        string        `json:"tag,omitempty"`
+	Digest     digest.Digest `json:"digest,omitempty"`
+	Repaired   bool          `json:"repaired"`
+}
+
+// ReconcileResult describes a run of Reconcile.
+type ReconcileResult struct {
+	// Repositories are the repositories checked.
+	Repositories []string
+	// Issues are the dangling links found.
+	Issues []ReconcileIssue
+	// Completed is true if the run checked the last repository of the
+	// registry, so that the next run starts over from the first one.
+	Completed bool
+}
+
+// Reconcile checks the tag, manifest revision and layer links of the
+// repositories of the registry against the blobs they reference, reporting
+// the dangling ones and removing them if opts.Repair is set.
+//
+// A run checks at most opts.Repositories repositories, resuming after the
+// last repository checked by the previous run, so that successive runs cover
+// the whole registry. Links are only considered dangling if the blob they
+// reference is missing: since blobs are always written before the links to
+// them, this does not race with pushes.
+//
+// Garbage collection removes blobs before the links to them, so Reconcile
+// does not run concurrently with it: it returns ErrGarbageCollectionRunning
+// without checking any repository while the marker kept by MarkAndSweep
+// exists, and stops with it if a garbage collection starts during the run,
+// before the next repository is checked or link repaired. The progress of
+// the repositories checked is kept.
+func Reconcile(ctx context.Context, storageDriver driver.StorageDriver, opts ReconcileOpts) (ReconcileResult, error) {
+	var result ReconcileResult
+	if opts.Repositories < 1 {
+		return result, fmt.Errorf("invalid number of repositories: %d", opts.Repositories)
+	}
+
+	statePath, err := pathFor(reconcileStatePathSpec{})
+	if err != nil {
+		return result, err
+	}
+	var last string
+	if content, err := storageDriver.GetContent(ctx, statePath); err == nil {
+		last = string(content)
+	} else if !errors.As(err, new(driver.PathNotFoundError)) {
+		return result, err
+	}
+
+	r := &reconciler{
+		driver: storageDriver,
+		repair: opts.Repair,
+		blobs:  make(map[digest.Digest]bool),
+	}
+	if err := r.checkGarbageCollection(ctx); err != nil {
+		return result, err
+	}
+
+	reg := &registry{blobStore: &blobStore{driver: storageDriver}}
+	repos := make([]string, opts.Repositories)
+	n, err := reg.Repositories(ctx, repos, last)
+	switch {
+	case errors.Is(err, io.EOF):
+		result.Completed = true
+	case err != nil:
+		return result, err
+	}
+
+	for _, name := range repos[:n] {
+		if err := r.checkGarbageCollection(ctx); err != nil {
+			return result, err
+		}
+		issues, err := r.reconcileRepository(ctx, name)
+		for _, issue := range issues {
+			dcontext.GetLoggerWithFields(ctx, map[any]any{
+				"repository": issue.Repository,
+				"kind":       issue.Kind,
+				"reason":     issue.Reason,
+				"path":       issue.Path,
+				"tag":        issue.Tag,
+				"digest":     issue.Digest,
+				"repaired":   issue.Repaired,
+			}).Warn("reconcile: dangling link")
+			reconcileIssues.WithValues(issue.Kind, issue.Reason, fmt.Sprint(issue.Repaired)).Inc(1)
+		}
+		result.Issues = append(result.Issues, issues...)
+		if err != nil {
+			return result, fmt.Errorf("failed to reconcile repository %s: %w", name, err)
+		}
+
+		result.Repositories = append(result.Repositories, name)
+		reconcileRepositories.Inc(1)
+
+		if err := storageDriver.PutContent(ctx, statePath, []byte(name)); err != nil {
+			return result, err
+		}
+	}
+
+	if result.Completed {
+		if err := storageDriver.Delete(ctx, statePath); err != nil && !errors.As(err, new(driver.PathNotFoundError)) {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+type reconciler struct {
+	driver driver.StorageDriver
+	repair bool
+	// blobs caches the presence of the blobs checked during a run.
+	blobs map[digest.Digest]bool
+}
+
+// danglingLink is a dangling link found by the reconciler, along with its
+// content, so that it is only removed if it has not changed since.
+type danglingLink struct {
+	issue   ReconcileIssue
+	content []byte
+	// dir is the directory removed to repair the link.
+	dir string
+}
+
+// reconcileRepository checks the links of repository name. Revisions are
+// checked before tags, so that tags pointing to revisions removed in repair
+// mode are found in the same run.
+func (r *reconciler) reconcileRepository(ctx context.Context, name string) ([]ReconcileIssue, error) {
+	var issues []ReconcileIssue
+
+	for _, spec := range []struct {
+		kind string
+		root pathSpec
+	}{
+		{ReconcileRevisionLink, manifestRevisionsPathSpec{name: name}},
+		{ReconcileLayerLink, layersPathSpec{name: name}},
+	} {
+		root, err := pathFor(spec.root)
+		if err != nil {
+			return issues, err
+		}
+
+		var dangling []danglingLink
+		err = r.driver.Walk(ctx, root, func(fileInfo driver.FileInfo) error {
+			if fileInfo.IsDir() || path.Base(fileInfo.Path()) != "link" {
+				return nil
+			}
+			link, err := r.checkLink(ctx, fileInfo.Path())
+			if err != nil || link == nil {
+				return err
+			}
+			link.issue.Repository, link.issue.Kind = name, spec.kind
+			link.dir = path.Dir(fileInfo.Path())
+			dangling = append(dangling, *link)
+			return nil
+		})
+		if err != nil && !errors.As(err, new(driver.PathNotFoundError)) {
+			return issues, err
+		}
+
+		for _, link := range dangling {
+			if err := r.repairLink(ctx, &link); err != nil {
+				return append(issues, link.issue), err
+			}
+			issues = append(issues, link.issue)
+		}
+	}
+
+	tagIssues, err := r.reconcileTags(ctx, name)
+	return append(issues, tagIssues...), err
+}
+
+// reconcileTags checks the current links of the tags of repository name,
+// which must point to a revision of the repository.
+func (r *reconciler) reconcileTags(ctx context.Context, name string) ([]ReconcileIssue, error) {
+	root, err := pathFor(manifestTagsPathSpec{name: name})
+	if err != nil {
+		return nil, err
+	}
+	entries, err := r.driver.List(ctx, root)
+	if err != nil {
+		if errors.As(err, new(driver.PathNotFoundError)) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var issues []ReconcileIssue
+	for _, entry := range entries {
+		tag := path.Base(entry)
+		linkPath, err := pathFor(manifestTagCurrentPathSpec{name: name, tag: tag})
+		if err != nil {
+			return issues, err
+		}
+
+		link, err := r.checkLink(ctx, linkPath)
+		if err != nil {
+			return issues, err
+		}
+		if link == nil {
+			link, err = r.checkRevision(ctx, name, linkPath)
+			if err != nil {
+				return issues, err
+			}
+			if link == nil {
+				continue
+			}
+		}
+
+		link.issue.Repository, link.issue.Kind, link.issue.Tag = name, ReconcileTagLink, tag
+		// a tag is only usable through its current link
+		link.dir, err = pathFor(manifestTagPathSpec{name: name, tag: tag})
+		if err != nil {
+			return issues, err
+		}
+		if err := r.repairLink(ctx, link); err != nil {
+			return append(issues, link.issue), err
+		}
+		issues = append(issues, link.issue)
+	}
+	return issues, nil
+}
+
+// checkLink returns the link at linkPath if it is invalid or references a
+// missing blob, or nil if the link is sound or missing.
+func (r *reconciler) checkLink(ctx context.Context, linkPath string) (*danglingLink, error) {
+	content, err := r.driver.GetContent(ctx, linkPath)
+	if err != nil {
+		if errors.As(err, new(driver.PathNotFoundError)) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	link := &danglingLink{
+		issue:   ReconcileIssue{Path: linkPath},
+		content: content,
+	}
+	dgst, err := digest.Parse(string(content))
+	if err != nil {
+		link.issue.Reason = ReconcileInvalidLink
+		return link, nil
+	}
+
+	exists, err := r.blobExists(ctx, dgst)
+	if err != nil || exists {
+		return nil, err
+	}
+	link.issue.Reason, link.issue.Digest = ReconcileMissingBlob, dgst
+	return link, nil
+}
+
+// checkRevision returns the tag link at linkPath if the manifest it
+// references is not linked in repository name, or nil otherwise.
+func (r *reconciler) checkRevision(ctx context.Context, name, linkPath string) (*danglingLink, error) {
+	content, err := r.driver.GetContent(ctx, linkPath)
+	if err != nil {
+		if errors.As(err, new(driver.PathNotFoundError)) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	dgst, err := digest.Parse(string(content))
+	if err != nil {
+		// changed since it was checked
+		return nil, nil
+	}
+
+	revisionPath, err := pathFor(manifestRevisionLinkPathSpec{name: name, revision: dgst})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.driver.Stat(ctx, revisionPath); err == nil || !errors.As(err, new(driver.PathNotFoundError)) {
+		return nil, err
+	}
+	return &danglingLink{
+		issue:   ReconcileIssue{Reason: ReconcileMissingRevision, Path: linkPath, Digest: dgst},
+		content: content,
+	}, nil
+}
+
+// repairLink removes the directory of link in repair mode, unless the link
+// has changed since it was found dangling.
+func (r *reconciler) repairLink(ctx context.Context, link *danglingLink) error {
+	if !r.repair {
+		return nil
+	}
+	if err := r.checkGarbageCollection(ctx); err != nil {
+		return err
+	}
+
+	content, err := r.driver.GetContent(ctx, link.issue.Path)
+	if err != nil {
+		if errors.As(err, new(driver.PathNotFoundError)) {
+			return nil
+		}
+		return err
+	}
+	if !bytes.Equal(content, link.content) {
+		return nil
+	}
+
+	if err := r.driver.Delete(ctx, link.dir); err != nil && !errors.As(err, new(driver.PathNotFoundError)) {
+		return err
+	}
+	link.issue.Repaired = true
+	return nil
+}
+
+// checkGarbageCollection returns ErrGarbageCollectionRunning if the marker
+// of a garbage collection in progress exists.
+func (r *reconciler) checkGarbageCollection(ctx context.Context) error {
+	markerPath, err := pathFor(garbageCollectionPathSpec{})
+	if err != nil {
+		return err
+	}
+	_, err = r.driver.Stat(ctx, markerPath)
+	switch {
+	case err == nil:
+		return ErrGarbageCollectionRunning
+	case errors.As(err, new(driver.PathNotFoundError)):
+		return nil
+	default:
+		return err
+	}
+}
+
+// blobExists returns whether the data of the blob dgst exists.
+func (r *reconciler) blobExists(ctx context.Context, dgst digest.Digest) (bool, error) {
+	if exists, ok := r.blobs[dgst]; ok {
+		return exists, nil
+	}
+
+	blobPath, err := pathFor(blobDataPathSpec{digest: dgst})
+	if err != nil {
+		return false, err
+	}
+	_, err = r.driver.Stat(ctx, blobPath)
+	switch {
+	case err == nil:
+		r.blobs[dgst] = true
+	case errors.As(err, new(driver.PathNotFoundError)):
+		r.blobs[dgst] = false
+	default:
+		return false, err
+	}
+	return r.blobs[dgst], nil
+}
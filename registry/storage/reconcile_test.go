@@ -0,0 +1,271 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func mustPathFor(t *testing.T, spec pathSpec) string {
+	t.Helper()
+
+	p, err := pathFor(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func pathExists(t *testing.T, d driver.StorageDriver, p string) bool {
+	t.Helper()
+
+	_, err := d.Stat(dcontext.Background(), p)
+	if err == nil {
+		return true
+	}
+	if !errors.As(err, new(driver.PathNotFoundError)) {
+		t.Fatal(err)
+	}
+	return false
+}
+
+func sortIssues(issues []ReconcileIssue) {
+	sort.Slice(issues, func(i, j int) bool {
+		if issues[i].Kind != issues[j].Kind {
+			return issues[i].Kind < issues[j].Kind
+		}
+		return issues[i].Path < issues[j].Path
+	})
+}
+
+func TestReconcile(t *testing.T) {
+	ctx := dcontext.Background()
+	d := inmemory.New()
+	registry := createRegistry(t, d)
+	repo := makeRepository(t, registry, "corrupted")
+	tags := repo.Tags(ctx)
+
+	var (
+		sound       = uploadRandomSchema2Image(t, repo)
+		missingBlob = uploadRandomSchema2Image(t, repo)
+		deleted     = uploadRandomOCIImage(t, repo)
+		layers      = uploadRandomSchema2Image(t, repo)
+	)
+	for tag, img := range map[string]image{
+		"sound":       sound,
+		"missingblob": missingBlob,
+		"deleted":     deleted,
+		"layers":      layers,
+	} {
+		if err := tags.Tag(ctx, tag, v1.Descriptor{Digest: img.manifestDigest}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the manifest blob of a revision is gone
+	if err := d.Delete(ctx, mustPathFor(t, blobPathSpec{digest: missingBlob.manifestDigest})); err != nil {
+		t.Fatal(err)
+	}
+	// a tagged revision was deleted without its tag
+	if err := d.Delete(ctx, mustPathFor(t, manifestRevisionPathSpec{name: "corrupted", revision: deleted.manifestDigest})); err != nil {
+		t.Fatal(err)
+	}
+	// a layer blob is gone, and a layer link is corrupted
+	layerDigests := getKeys(layers.layers)
+	sort.Slice(layerDigests, func(i, j int) bool { return layerDigests[i] < layerDigests[j] })
+	if err := d.Delete(ctx, mustPathFor(t, blobPathSpec{digest: layerDigests[0]})); err != nil {
+		t.Fatal(err)
+	}
+	invalidLinkPath := mustPathFor(t, layerLinkPathSpec{name: "corrupted", digest: layerDigests[1]})
+	if err := d.PutContent(ctx, invalidLinkPath, []byte("invalid")); err != nil {
+		t.Fatal(err)
+	}
+
+	// a repository without corruption
+	uploadRandomOCIImage(t, makeRepository(t, registry, "healthy"))
+
+	expected := []ReconcileIssue{
+		{
+			Repository: "corrupted",
+			Kind:       ReconcileRevisionLink,
+			Reason:     ReconcileMissingBlob,
+			Path:       mustPathFor(t, manifestRevisionLinkPathSpec{name: "corrupted", revision: missingBlob.manifestDigest}),
+			Digest:     missingBlob.manifestDigest,
+		},
+		{
+			Repository: "corrupted",
+			Kind:       ReconcileLayerLink,
+			Reason:     ReconcileMissingBlob,
+			Path:       mustPathFor(t, layerLinkPathSpec{name: "corrupted", digest: layerDigests[0]}),
+			Digest:     layerDigests[0],
+		},
+		{
+			Repository: "corrupted",
+			Kind:       ReconcileLayerLink,
+			Reason:     ReconcileInvalidLink,
+			Path:       invalidLinkPath,
+		},
+		{
+			Repository: "corrupted",
+			Kind:       ReconcileTagLink,
+			Reason:     ReconcileMissingRevision,
+			Path:       mustPathFor(t, manifestTagCurrentPathSpec{name: "corrupted", tag: "deleted"}),
+			Tag:        "deleted",
+			Digest:     deleted.manifestDigest,
+		},
+		{
+			Repository: "corrupted",
+			Kind:       ReconcileTagLink,
+			Reason:     ReconcileMissingBlob,
+			Path:       mustPathFor(t, manifestTagCurrentPathSpec{name: "corrupted", tag: "missingblob"}),
+			Tag:        "missingblob",
+			Digest:     missingBlob.manifestDigest,
+		},
+	}
+	checkIssues := func(issues []ReconcileIssue, repaired bool) {
+		t.Helper()
+
+		sortIssues(issues)
+		sortIssues(expected)
+		if len(issues) != len(expected) {
+			t.Fatalf("expected %d issues, got %+v", len(expected), issues)
+		}
+		for i := range expected {
+			want := expected[i]
+			want.Repaired = repaired
+			if issues[i] != want {
+				t.Errorf("unexpected issue %d: %+v, expected %+v", i, issues[i], want)
+			}
+		}
+	}
+
+	// report mode leaves the links untouched
+	result, err := Reconcile(ctx, d, ReconcileOpts{Repositories: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(result.Repositories) != "[corrupted healthy]" || !result.Completed {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+	checkIssues(result.Issues, false)
+	for _, issue := range expected {
+		if !pathExists(t, d, issue.Path) {
+			t.Errorf("%s was removed in report mode", issue.Path)
+		}
+	}
+
+	// repair mode removes them
+	result, err = Reconcile(ctx, d, ReconcileOpts{Repositories: 10, Repair: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkIssues(result.Issues, true)
+	for _, issue := range expected {
+		if pathExists(t, d, issue.Path) {
+			t.Errorf("%s was not removed in repair mode", issue.Path)
+		}
+	}
+	all, err := tags.All(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(all) != "[layers sound]" {
+		t.Errorf("unexpected tags after repair: %v", all)
+	}
+
+	// blob data is never removed
+	for _, dgst := range []digest.Digest{sound.manifestDigest, deleted.manifestDigest, layers.manifestDigest, layerDigests[1]} {
+		if !pathExists(t, d, mustPathFor(t, blobDataPathSpec{digest: dgst})) {
+			t.Errorf("blob %s was removed", dgst)
+		}
+	}
+	if _, err := makeManifestService(t, repo).Get(ctx, sound.manifestDigest); err != nil {
+		t.Errorf("sound manifest is unavailable after repair: %v", err)
+	}
+
+	result, err = Reconcile(ctx, d, ReconcileOpts{Repositories: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Issues) != 0 {
+		t.Errorf("unexpected issues after repair: %+v", result.Issues)
+	}
+}
+
+func TestReconcileProgress(t *testing.T) {
+	ctx := dcontext.Background()
+	d := inmemory.New()
+	registry := createRegistry(t, d)
+	for i := range 5 {
+		uploadRandomOCIImage(t, makeRepository(t, registry, fmt.Sprintf("repo%d", i)))
+	}
+
+	for _, expected := range []struct {
+		repositories string
+		completed    bool
+	}{
+		{"[repo0 repo1]", false},
+		{"[repo2 repo3]", false},
+		{"[repo4]", true},
+		{"[repo0 repo1]", false},
+	} {
+		result, err := Reconcile(ctx, d, ReconcileOpts{Repositories: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(result.Repositories) != expected.repositories || result.Completed != expected.completed {
+			t.Fatalf("unexpected result %+v, expected %+v", result, expected)
+		}
+	}
+}
+
+func TestReconcileGarbageCollection(t *testing.T) {
+	ctx := dcontext.Background()
+	d := inmemory.New()
+	registry := createRegistry(t, d)
+	img := uploadRandomSchema2Image(t, makeRepository(t, registry, "collected"))
+
+	var layer digest.Digest
+	for layer = range img.layers {
+		break
+	}
+	if err := d.Delete(ctx, mustPathFor(t, blobPathSpec{digest: layer})); err != nil {
+		t.Fatal(err)
+	}
+	linkPath := mustPathFor(t, layerLinkPathSpec{name: "collected", digest: layer})
+	markerPath := mustPathFor(t, garbageCollectionPathSpec{})
+
+	// a garbage collection is in progress
+	if err := d.PutContent(ctx, markerPath, []byte("2026-10-17T00:00:00Z")); err != nil {
+		t.Fatal(err)
+	}
+	result, err := Reconcile(ctx, d, ReconcileOpts{Repositories: 10, Repair: true})
+	if !errors.Is(err, ErrGarbageCollectionRunning) {
+		t.Fatalf("unexpected error %v, expected %v", err, ErrGarbageCollectionRunning)
+	}
+	if len(result.Repositories) != 0 || !pathExists(t, d, linkPath) {
+		t.Fatalf("repositories reconciled during a garbage collection: %+v", result)
+	}
+
+	// the marker is removed once the garbage collection finishes
+	if err := MarkAndSweep(ctx, d, registry, GCOpts{Quiet: true}); err != nil {
+		t.Fatal(err)
+	}
+	if pathExists(t, d, markerPath) {
+		t.Fatal("marker of the garbage collection not removed")
+	}
+	result, err = Reconcile(ctx, d, ReconcileOpts{Repositories: 10, Repair: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(result.Repositories) != "[collected]" || pathExists(t, d, linkPath) {
+		t.Fatalf("dangling layer link not repaired: %+v", result)
+	}
+}
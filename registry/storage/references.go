@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// ReferencesOpts contains options for listing the referenced blobs
+type ReferencesOpts struct {
+	// After skips the repositories up to this one, in the order of the
+	// catalog, to resume a listing.
+	After string
+	// Seen holds the digests already listed, which are not listed again. It
+	// is updated with the digests listed.
+	Seen map[digest.Digest]struct{}
+	// Sizes looks up the sizes of the manifests found in repositories, which
+	// are otherwise not known. The sizes of the blobs they reference are
+	// taken from their references.
+	Sizes bool
+}
+
+// ListReferences calls ingester with the descriptor of each manifest of the
+// repositories of registry, and of each blob they reference, once per digest.
+// checkpoint is called once all the references of a repository are listed,
+// with its name, to resume the listing after it with ReferencesOpts.After.
+//
+// The references are enumerated as by the mark phase of MarkAndSweep, for
+// which all manifests are live, whether tagged or not. Only the set of digests
+// listed is held in memory.
+func ListReferences(ctx context.Context, registry distribution.Namespace, opts ReferencesOpts, ingester func(v1.Descriptor) error, checkpoint func(repoName string) error) error {
+	repositoryEnumerator, ok := registry.(distribution.RepositoryEnumerator)
+	if !ok {
+		return fmt.Errorf("unable to convert Namespace to RepositoryEnumerator")
+	}
+	seen := opts.Seen
+	if seen == nil {
+		seen = make(map[digest.Digest]struct{})
+	}
+
+	return repositoryEnumerator.Enumerate(ctx, func(repoName string) error {
+		if opts.After != "" && !lessPath(opts.After, repoName) {
+			return nil
+		}
+
+		named, err := reference.WithName(repoName)
+		if err != nil {
+			return fmt.Errorf("failed to parse repo name %s: %v", repoName, err)
+		}
+		repository, err := registry.Repository(ctx, named)
+		if err != nil {
+			return fmt.Errorf("failed to construct repository: %v", err)
+		}
+		manifestService, err := repository.Manifests(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to construct manifest service: %v", err)
+		}
+		manifestEnumerator, ok := manifestService.(distribution.ManifestEnumerator)
+		if !ok {
+			return fmt.Errorf("unable to convert ManifestService into ManifestEnumerator")
+		}
+
+		err = manifestEnumerator.Enumerate(ctx, func(dgst digest.Digest) error {
+			if _, ok := seen[dgst]; !ok {
+				desc := v1.Descriptor{Digest: dgst}
+				if opts.Sizes {
+					stat, err := registry.BlobStatter().Stat(ctx, dgst)
+					if err != nil {
+						return fmt.Errorf("failed to stat manifest %s: %v", dgst, err)
+					}
+					desc.Size = stat.Size
+				}
+				if err := ingester(desc); err != nil {
+					return err
+				}
+				seen[dgst] = struct{}{}
+			}
+
+			var ingestErr error
+			err := markManifestReferences(dgst, manifestService, ctx, func(desc v1.Descriptor) bool {
+				if _, listed := seen[desc.Digest]; listed || ingestErr != nil {
+					return true
+				}
+				if ingestErr = ingester(v1.Descriptor{Digest: desc.Digest, Size: desc.Size}); ingestErr != nil {
+					return true
+				}
+				seen[desc.Digest] = struct{}{}
+				return false
+			})
+			if ingestErr != nil {
+				return ingestErr
+			}
+			return err
+		})
+		if err != nil {
+			// as for the mark phase, repositories without manifests are
+			// skipped
+			if _, ok := err.(driver.PathNotFoundError); !ok {
+				return err
+			}
+		}
+		return checkpoint(repoName)
+	})
+}
@@ -0,0 +1,210 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/distribution/distribution/v3"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// errLinkedManifestFound stops the walk of an index of manifests once a
+// manifest of the repository is found.
+var errLinkedManifestFound = errors.New("linked manifest found")
+
+// referrerFields are the fields of a manifest making it a referrer of its
+// subject.
+type referrerFields struct {
+	ArtifactType string         `json:"artifactType,omitempty"`
+	Config       *v1.Descriptor `json:"config,omitempty"`
+	Subject      *v1.Descriptor `json:"subject,omitempty"`
+}
+
+// artifactType returns the artifact type of the manifest: its artifactType
+// field if set, else its config media type.
+func (fields referrerFields) artifactType() string {
+	if fields.ArtifactType == "" && fields.Config != nil {
+		return fields.Config.MediaType
+	}
+	return fields.ArtifactType
+}
+
+// indexReferrer records the manifest dgst in the referrers index of its
+// subject, if it has one. Unlike the artifact types index, the failures fail
+// the put, as pulls may be gated on the referrers of manifests.
+func (ms *manifestStore) indexReferrer(ctx context.Context, dgst digest.Digest, manifest distribution.Manifest) error {
+	_, payload, err := manifest.Payload()
+	if err != nil {
+		return err
+	}
+	linkPath, err := referrerLinkPath(ms.repository.Named().Name(), dgst, payload)
+	if err != nil || linkPath == "" {
+		return err
+	}
+	return ms.repository.driver.PutContent(ctx, linkPath, []byte(dgst))
+}
+
+// referrerLinkPath returns the path of the link recording the manifest dgst
+// of repository name, whose content is payload, in the referrers index of its
+// subject. It returns an empty path if the manifest has no subject, or no
+// artifact type.
+func referrerLinkPath(name string, dgst digest.Digest, payload []byte) (string, error) {
+	var fields referrerFields
+	if err := json.Unmarshal(payload, &fields); err != nil || fields.Subject == nil {
+		return "", nil
+	}
+
+	artifactType := fields.artifactType()
+	if artifactType == "" {
+		return "", nil
+	}
+
+	return pathFor(manifestReferrerLinkPathSpec{
+		name:         name,
+		subject:      fields.Subject.Digest,
+		artifactType: artifactType,
+		referrer:     dgst,
+	})
+}
+
+// HasReferrer reports whether a manifest of one of artifactTypes references
+// subject as its subject. The index is not updated when referrers are
+// deleted: entries whose referrer is no longer in the repository are
+// ignored. Referrers pushed before the index was maintained are only found
+// once backfilled by BackfillIndexes.
+func (repo *repository) HasReferrer(ctx context.Context, subject digest.Digest, artifactTypes ...string) (bool, error) {
+	manifests, err := repo.Manifests(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	for _, artifactType := range artifactTypes {
+		root, err := pathFor(manifestReferrersPathSpec{name: repo.name.Name(), subject: subject, artifactType: artifactType})
+		if err != nil {
+			return false, err
+		}
+		found, err := repo.hasLinkedManifest(ctx, manifests, root, "")
+		if found || err != nil {
+			return found, err
+		}
+	}
+	return false, nil
+}
+
+// Referrers returns the descriptors of the manifests referencing subject as
+// their subject, of artifactType if not empty, found through the referrers
+// index. As for HasReferrer, the referrers no longer in the repository are
+// ignored, and the referrers pushed before the index was maintained are only
+// found once backfilled.
+func (repo *repository) Referrers(ctx context.Context, subject digest.Digest, artifactType string) ([]v1.Descriptor, error) {
+	manifests, err := repo.Manifests(ctx)
+	if err != nil {
+		return nil, err
+	}
+	root, err := pathFor(manifestReferrersPathSpec{name: repo.name.Name(), subject: subject, artifactType: artifactType})
+	if err != nil {
+		return nil, err
+	}
+
+	linked := make(map[digest.Digest]struct{})
+	err = repo.driver.Walk(ctx, root, func(fileInfo storagedriver.FileInfo) error {
+		if fileInfo.IsDir() || path.Base(fileInfo.Path()) != "link" {
+			return nil
+		}
+		content, err := repo.driver.GetContent(ctx, fileInfo.Path())
+		if err != nil {
+			return err
+		}
+		if dgst, err := digest.Parse(string(content)); err == nil {
+			linked[dgst] = struct{}{}
+		}
+		return nil
+	})
+	if err != nil && !errors.As(err, new(storagedriver.PathNotFoundError)) {
+		return nil, err
+	}
+
+	referrers := make([]v1.Descriptor, 0, len(linked))
+	for dgst := range linked {
+		desc, err := repo.referrerDescriptor(ctx, manifests, dgst)
+		if err != nil {
+			if errors.As(err, new(distribution.ErrManifestUnknownRevision)) {
+				// deleted since indexed
+				continue
+			}
+			return nil, err
+		}
+		referrers = append(referrers, desc)
+	}
+	slices.SortFunc(referrers, func(a, b v1.Descriptor) int {
+		return strings.Compare(a.Digest.String(), b.Digest.String())
+	})
+	return referrers, nil
+}
+
+// referrerDescriptor returns the descriptor of the referrer dgst, with its
+// artifact type and annotations.
+func (repo *repository) referrerDescriptor(ctx context.Context, manifests distribution.ManifestService, dgst digest.Digest) (v1.Descriptor, error) {
+	manifest, err := manifests.Get(ctx, dgst)
+	if err != nil {
+		return v1.Descriptor{}, err
+	}
+	mediaType, payload, err := manifest.Payload()
+	if err != nil {
+		return v1.Descriptor{}, err
+	}
+	var fields struct {
+		referrerFields
+		Annotations map[string]string `json:"annotations,omitempty"`
+	}
+	if err := json.Unmarshal(payload, &fields); err != nil {
+		return v1.Descriptor{}, err
+	}
+	return v1.Descriptor{
+		MediaType:    mediaType,
+		Digest:       dgst,
+		Size:         int64(len(payload)),
+		ArtifactType: fields.artifactType(),
+		Annotations:  fields.Annotations,
+	}, nil
+}
+
+// hasLinkedManifest reports whether one of the manifests linked under root,
+// other than exclude, is in the repository.
+func (repo *repository) hasLinkedManifest(ctx context.Context, manifests distribution.ManifestService, root string, exclude digest.Digest) (bool, error) {
+	err := repo.driver.Walk(ctx, root, func(fileInfo storagedriver.FileInfo) error {
+		if fileInfo.IsDir() || path.Base(fileInfo.Path()) != "link" {
+			return nil
+		}
+		content, err := repo.driver.GetContent(ctx, fileInfo.Path())
+		if err != nil {
+			return err
+		}
+		linked, err := digest.Parse(string(content))
+		if err != nil || linked == exclude {
+			return nil
+		}
+		exists, err := manifests.Exists(ctx, linked)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return errLinkedManifestFound
+		}
+		return nil
+	})
+	switch {
+	case errors.Is(err, errLinkedManifestFound):
+		return true, nil
+	case err == nil, errors.As(err, new(storagedriver.PathNotFoundError)):
+		return false, nil
+	default:
+		return false, err
+	}
+}
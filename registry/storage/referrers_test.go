@@ -0,0 +1,156 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// putReferrer puts a copy of the manifest of im, of artifactType, referencing
+// subject as its subject, and returns its digest.
+func putReferrer(t *testing.T, ms distribution.ManifestService, im image, subject digest.Digest, artifactType string) digest.Digest {
+	t.Helper()
+	_, payload, err := im.manifest.Payload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(payload, &fields); err != nil {
+		t.Fatal(err)
+	}
+	fields["subject"] = v1.Descriptor{MediaType: v1.MediaTypeImageManifest, Digest: subject, Size: 1}
+	if artifactType != "" {
+		fields["artifactType"] = artifactType
+	}
+	referrer, err := json.Marshal(fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := new(ocischema.DeserializedManifest)
+	if err := m.UnmarshalJSON(referrer); err != nil {
+		t.Fatal(err)
+	}
+	dgst, err := ms.Put(context.Background(), m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dgst
+}
+
+func TestHasReferrer(t *testing.T) {
+	ctx := context.Background()
+	repo := makeRepository(t, createRegistry(t, inmemory.New()), "a/b")
+	ms := makeManifestService(t, repo)
+	checker := repo.(distribution.ReferrerChecker)
+
+	subject := uploadRandomOCIImage(t, repo)
+	other := uploadRandomOCIImage(t, repo)
+
+	hasReferrer := func(dgst digest.Digest, artifactTypes ...string) bool {
+		t.Helper()
+		found, err := checker.HasReferrer(ctx, dgst, artifactTypes...)
+		if err != nil {
+			t.Fatalf("unexpected error checking the referrers: %v", err)
+		}
+		return found
+	}
+
+	if hasReferrer(subject.manifestDigest, "application/vnd.example.finding") {
+		t.Fatal("unexpected referrer before any was pushed")
+	}
+
+	finding := putReferrer(t, ms, other, subject.manifestDigest, "application/vnd.example.finding")
+	if !hasReferrer(subject.manifestDigest, "application/vnd.example.signature", "application/vnd.example.finding") {
+		t.Fatal("expected the finding to be found")
+	}
+	if hasReferrer(subject.manifestDigest, "application/vnd.example.signature") {
+		t.Fatal("unexpected referrer of another artifact type")
+	}
+	if hasReferrer(other.manifestDigest, "application/vnd.example.finding") {
+		t.Fatal("unexpected referrer of another subject")
+	}
+
+	// referrers without artifactType are of the media type of their config
+	putReferrer(t, ms, other, other.manifestDigest, "")
+	if !hasReferrer(other.manifestDigest, v1.MediaTypeImageConfig) {
+		t.Fatal("expected the referrer to be found by its config media type")
+	}
+
+	// deleted referrers are ignored
+	if err := ms.Delete(ctx, finding); err != nil {
+		t.Fatal(err)
+	}
+	if hasReferrer(subject.manifestDigest, "application/vnd.example.finding") {
+		t.Fatal("unexpected deleted referrer")
+	}
+}
+
+func TestReferrers(t *testing.T) {
+	ctx := context.Background()
+	repo := makeRepository(t, createRegistry(t, inmemory.New()), "a/b")
+	ms := makeManifestService(t, repo)
+	lister := repo.(distribution.ReferrerLister)
+
+	subject := uploadRandomOCIImage(t, repo)
+	other := uploadRandomOCIImage(t, repo)
+
+	referrers := func(artifactType string) []v1.Descriptor {
+		t.Helper()
+		descs, err := lister.Referrers(ctx, subject.manifestDigest, artifactType)
+		if err != nil {
+			t.Fatalf("unexpected error listing the referrers: %v", err)
+		}
+		return descs
+	}
+
+	if descs := referrers(""); len(descs) != 0 {
+		t.Fatalf("unexpected referrers before any was pushed: %v", descs)
+	}
+
+	finding := putReferrer(t, ms, other, subject.manifestDigest, "application/vnd.example.finding")
+	signature := putReferrer(t, ms, other, subject.manifestDigest, "application/vnd.example.signature")
+	putReferrer(t, ms, subject, other.manifestDigest, "application/vnd.example.finding")
+
+	descs := referrers("")
+	if len(descs) != 2 {
+		t.Fatalf("expected the 2 referrers of the subject, got %v", descs)
+	}
+	if descs[0].Digest.String() > descs[1].Digest.String() {
+		t.Fatalf("expected the referrers to be sorted by digest: %v", descs)
+	}
+	for _, desc := range descs {
+		if desc.MediaType != v1.MediaTypeImageManifest || desc.Size == 0 {
+			t.Fatalf("unexpected descriptor: %+v", desc)
+		}
+		switch desc.Digest {
+		case finding:
+			if desc.ArtifactType != "application/vnd.example.finding" {
+				t.Fatalf("unexpected artifact type of the finding: %q", desc.ArtifactType)
+			}
+		case signature:
+			if desc.ArtifactType != "application/vnd.example.signature" {
+				t.Fatalf("unexpected artifact type of the signature: %q", desc.ArtifactType)
+			}
+		default:
+			t.Fatalf("unexpected referrer %s", desc.Digest)
+		}
+	}
+
+	if descs := referrers("application/vnd.example.finding"); len(descs) != 1 || descs[0].Digest != finding {
+		t.Fatalf("expected only the finding, got %v", descs)
+	}
+
+	// deleted referrers are ignored
+	if err := ms.Delete(ctx, finding); err != nil {
+		t.Fatal(err)
+	}
+	if descs := referrers(""); len(descs) != 1 || descs[0].Digest != signature {
+		t.Fatalf("expected only the signature after the deletion of the finding, got %v", descs)
+	}
+}
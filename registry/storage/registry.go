@@ -23,14 +23,34 @@ type registry struct {
 	statter                      *blobStatter // global statter service.
 	blobDescriptorCacheProvider  cache.BlobDescriptorCacheProvider
 	deleteEnabled                bool
+	deleteUntaggedEnabled        bool
+	pruneEmptyEnabled            bool
+	blobMediaTypesEnabled        bool
+	artifactTypesEnabled         bool
 	tagLookupConcurrencyLimit    int
 	resumableDigestEnabled       bool
 	blobDescriptorServiceFactory distribution.BlobDescriptorServiceFactory
 	driver                       storagedriver.StorageDriver
+	router                       storagedriver.RepositoryRouter
+	limits                       storagedriver.Limits
+	digestMismatch               DigestMismatchFunc
+	verifyExistingContent        bool
+	contentMismatch              ContentMismatchFunc
 
 	// Validation
 	manifestURLs         manifestURLs
 	validateImageIndexes validateImageIndexes
+	decompressionLimits  decompressionLimits
+	configMediaTypes     map[string]struct{}
+
+	layerMediaTypeCorrection layerMediaTypeCorrection
+	blobDescriptorHints      *blobDescriptorHints
+	manifestDescriptorCache  bool
+	blobExistenceRetry       blobExistenceRetry
+	configCreated            configCreated
+	canonicalManifests       canonicalManifests
+	uniqueLayers             uniqueLayers
+	layerSize                layerSize
 }
 
 // manifestURLs holds regular expressions for controlling manifest URL whitelisting
@@ -45,6 +65,10 @@ type validateImageIndexes struct {
 	imagesExist bool
 	// platforms can be used to only validate the existence of images for a set of platforms. The empty array means validate all platforms.
 	imagePlatforms []platform
+	// windowsOSVersion requires images for the windows OS to specify their os.version.
+	windowsOSVersion bool
+	// childDescriptors requires the descriptors of the existing child manifests to match them.
+	childDescriptors bool
 }
 
 // platform represents a platform to validate exists in the
@@ -63,6 +87,26 @@ func EnableRedirect(registry *registry) error {
 	return nil
 }
 
+// RequireRedirect is a functional option for NewRegistry. Like
+// EnableRedirect, it causes the backend blob server to redirect blob
+// requests using (StorageDriver).RedirectURL, but fails the requests the
+// driver provides no redirect URL for instead of serving the content.
+func RequireRedirect(registry *registry) error {
+	registry.blobServer.redirect = true
+	registry.blobServer.requireRedirect = true
+	return nil
+}
+
+// RedirectCacheControl is a functional option for NewRegistry. It sets the
+// Cache-Control header of the blob redirects, which defaults to "no-store"
+// so that the signed URLs they point to are not used after they expire.
+func RedirectCacheControl(value string) RegistryOption {
+	return func(registry *registry) error {
+		registry.blobServer.redirectCacheControl = value
+		return nil
+	}
+}
+
 func TagLookupConcurrencyLimit(concurrencyLimit int) RegistryOption {
 	return func(registry *registry) error {
 		registry.tagLookupConcurrencyLimit = concurrencyLimit
@@ -77,6 +121,33 @@ func EnableDelete(registry *registry) error {
 	return nil
 }
 
+// EnableDeleteUntagged is a functional option for NewRegistry. When a tag
+// is moved to another manifest, it deletes the revision the tag pointed to
+// unless another tag or manifest still references it, rather than leaving
+// it to the garbage collector. It requires EnableDelete.
+func EnableDeleteUntagged(registry *registry) error {
+	registry.deleteUntaggedEnabled = true
+	return nil
+}
+
+// EnablePruneEmpty is a functional option for NewRegistry. When the last
+// tag or manifest of a repository is deleted, it removes the repository, so
+// that it is no longer listed by the catalog, along with its empty
+// directories. It requires EnableDelete.
+func EnablePruneEmpty(registry *registry) error {
+	registry.pruneEmptyEnabled = true
+	return nil
+}
+
+// EnableBlobMediaTypes is a functional option for NewRegistry. It records the
+// media types blobs are referenced with by the manifests pushed to a
+// repository, and reports them in place of application/octet-stream when
+// the blobs are accessed through the repository.
+func EnableBlobMediaTypes(registry *registry) error {
+	registry.blobMediaTypesEnabled = true
+	return nil
+}
+
 // DisableDigestResumption is a functional option for NewRegistry. It should be
 // used if the registry is acting as a caching proxy.
 func DisableDigestResumption(registry *registry) error {
@@ -122,6 +193,23 @@ func AddValidateImageIndexImagesExistPlatform(architecture string, os string) Re
 	}
 }
 
+// EnableValidateImageIndexWindowsOSVersion is a functional option for NewRegistry. It
+// enables validation that images for the windows OS referenced by an image index
+// specify their os.version.
+func EnableValidateImageIndexWindowsOSVersion(registry *registry) error {
+	registry.validateImageIndexes.windowsOSVersion = true
+	return nil
+}
+
+// EnableValidateImageIndexChildDescriptors is a functional option for
+// NewRegistry. It enables validation that the size and media type of the
+// manifests referenced by an image index match the stored manifests, for
+// the manifests already in the repository.
+func EnableValidateImageIndexChildDescriptors(registry *registry) error {
+	registry.validateImageIndexes.childDescriptors = true
+	return nil
+}
+
 // BlobDescriptorServiceFactory returns a functional option for NewRegistry. It sets the
 // factory to create BlobDescriptorServiceFactory middleware.
 func BlobDescriptorServiceFactory(factory distribution.BlobDescriptorServiceFactory) RegistryOption {
@@ -131,6 +219,17 @@ func BlobDescriptorServiceFactory(factory distribution.BlobDescriptorServiceFact
 	}
 }
 
+// RouteRepositories returns a functional option for NewRegistry, telling
+// the registry that router stores the repositories on different backends.
+// It is needed when the driver of the registry wraps router in storage
+// middlewares, which hide it.
+func RouteRepositories(router storagedriver.RepositoryRouter) RegistryOption {
+	return func(registry *registry) error {
+		registry.router = router
+		return nil
+	}
+}
+
 // BlobDescriptorCacheProvider returns a functional option for
 // NewRegistry. It creates a cached blob statter for use by the
 // registry.
@@ -161,21 +260,35 @@ func NewRegistry(ctx context.Context, driver storagedriver.StorageDriver, option
 		driver: driver,
 	}
 
+	transferClasses := transferSizeClasses{
+		minSize:    defaultTransferMinSize,
+		mediumSize: defaultTransferMediumSize,
+		largeSize:  defaultTransferLargeSize,
+	}
+
 	bs := &blobStore{
-		driver:  driver,
-		statter: statter,
+		driver:          driver,
+		statter:         statter,
+		transferClasses: transferClasses,
 	}
 
 	registry := &registry{
 		blobStore: bs,
 		blobServer: &blobServer{
-			driver:  driver,
-			statter: statter,
-			pathFn:  bs.path,
+			driver:          driver,
+			statter:         statter,
+			pathFn:          bs.path,
+			transferClasses: transferClasses,
+
+			redirectCacheControl: defaultRedirectCacheControl,
 		},
 		statter:                statter,
 		resumableDigestEnabled: true,
 		driver:                 driver,
+		limits:                 storagedriver.LimitsOf(driver),
+	}
+	if router, ok := driver.(storagedriver.RepositoryRouter); ok {
+		registry.router = router
 	}
 
 	for _, option := range options {
@@ -245,6 +358,8 @@ func (repo *repository) Tags(ctx context.Context) distribution.TagService {
 		blobStore:        repo.registry.blobStore,
 		concurrencyLimit: limit,
 		deleteEnabled:    repo.registry.deleteEnabled,
+
+		deleteUntaggedEnabled: repo.registry.deleteUntaggedEnabled,
 	}
 
 	return tags
@@ -295,17 +410,23 @@ func (repo *repository) Manifests(ctx context.Context, options ...distribution.M
 		repository: repo,
 		blobStore:  blobStore,
 		schema2Handler: &schema2ManifestHandler{
-			ctx:          ctx,
-			repository:   repo,
-			blobStore:    blobStore,
-			manifestURLs: repo.registry.manifestURLs,
+			ctx:                ctx,
+			repository:         repo,
+			blobStore:          blobStore,
+			manifestURLs:       repo.registry.manifestURLs,
+			configMediaTypes:   repo.registry.configMediaTypes,
+			blobExistenceRetry: repo.registry.blobExistenceRetry,
+			configCreated:      repo.registry.configCreated,
 		},
 		manifestListHandler: manifestListHandler,
 		ocischemaHandler: &ocischemaManifestHandler{
-			ctx:          ctx,
-			repository:   repo,
-			blobStore:    blobStore,
-			manifestURLs: repo.registry.manifestURLs,
+			ctx:                ctx,
+			repository:         repo,
+			blobStore:          blobStore,
+			manifestURLs:       repo.registry.manifestURLs,
+			configMediaTypes:   repo.registry.configMediaTypes,
+			blobExistenceRetry: repo.registry.blobExistenceRetry,
+			configCreated:      repo.registry.configCreated,
 		},
 		ocischemaIndexHandler: &ocischemaIndexHandler{
 			manifestListHandler: manifestListHandler,
@@ -328,9 +449,11 @@ func (repo *repository) Manifests(ctx context.Context, options ...distribution.M
 // to a request local.
 func (repo *repository) Blobs(ctx context.Context) distribution.BlobStore {
 	var statter distribution.BlobDescriptorService = &linkedBlobStatter{
-		blobStore:  repo.blobStore,
-		repository: repo,
-		linkPath:   blobLinkPath,
+		blobStore:         repo.blobStore,
+		repository:        repo,
+		linkPath:          blobLinkPath,
+		mediaTypesEnabled: repo.registry.blobMediaTypesEnabled,
+		hints:             repo.registry.blobDescriptorHints,
 	}
 
 	if repo.descriptorCache != nil {
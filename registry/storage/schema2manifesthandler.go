@@ -19,10 +19,13 @@ var (
 
 // schema2ManifestHandler is a ManifestHandler that covers schema2 manifests.
 type schema2ManifestHandler struct {
-	repository   distribution.Repository
-	blobStore    distribution.BlobStore
-	ctx          context.Context
-	manifestURLs manifestURLs
+	repository         distribution.Repository
+	blobStore          distribution.BlobStore
+	ctx                context.Context
+	manifestURLs       manifestURLs
+	configMediaTypes   map[string]struct{}
+	blobExistenceRetry blobExistenceRetry
+	configCreated      configCreated
 }
 
 var _ ManifestHandler = &schema2ManifestHandler{}
@@ -74,16 +77,27 @@ func (ms *schema2ManifestHandler) verifyManifest(ctx context.Context, mnfst sche
 		return fmt.Errorf("unrecognized manifest schema version %d", mnfst.Manifest.SchemaVersion)
 	}
 
+	if err := verifyConfigMediaType(ms.configMediaTypes, mnfst.Config.MediaType); err != nil {
+		return distribution.ErrManifestVerification{err}
+	}
+
 	if skipDependencyVerification {
 		return nil
 	}
 
+	if err := ms.configCreated.verify(ctx, ms.repository.Blobs(ctx), mnfst.Config); err != nil {
+		if _, ok := err.(distribution.ErrManifestConfigCreatedInvalid); ok {
+			return distribution.ErrManifestVerification{err}
+		}
+		return err
+	}
+
 	manifestService, err := ms.repository.Manifests(ctx)
 	if err != nil {
 		return err
 	}
 
-	blobsService := ms.repository.Blobs(ctx)
+	blobsService := ms.blobExistenceRetry.statter(ms.repository.Blobs(ctx))
 
 	for _, descriptor := range mnfst.References() {
 		err := descriptor.Digest.Validate()
@@ -2,7 +2,9 @@ package storage
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"path"
 	"sort"
@@ -14,10 +16,15 @@ import (
 	"golang.org/x/sync/errgroup"
 
 	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/internal/dcontext"
 	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
 )
 
-var _ distribution.TagService = &tagStore{}
+var (
+	_ distribution.TagService      = &tagStore{}
+	_ distribution.TagFilterLister = &tagStore{}
+	_ distribution.TagBatcher      = &tagStore{}
+)
 
 // tagStore provides methods to manage manifest tags in a backend storage driver.
 // This implementation uses the same on-disk layout as the (now deleted) tag
@@ -29,6 +36,9 @@ type tagStore struct {
 	blobStore        *blobStore
 	concurrencyLimit int
 	deleteEnabled    bool
+	// deleteUntaggedEnabled deletes the revisions tags are moved away from
+	// once nothing references them.
+	deleteUntaggedEnabled bool
 }
 
 // All returns all tags
@@ -40,7 +50,16 @@ func (ts *tagStore) All(ctx context.Context) ([]string, error) {
 		return nil, err
 	}
 
-	entries, err := ts.blobStore.driver.List(ctx, pathSpec)
+	// repositories may have huge numbers of tags: collect the names page by
+	// page rather than the full paths all at once
+	tags := []string{}
+	err = storagedriver.ListPages(ctx, ts.blobStore.driver, pathSpec, func(entries []string) error {
+		for _, entry := range entries {
+			_, filename := path.Split(entry)
+			tags = append(tags, filename)
+		}
+		return nil
+	})
 	if err != nil {
 		switch err := err.(type) {
 		case storagedriver.PathNotFoundError:
@@ -50,12 +69,6 @@ func (ts *tagStore) All(ctx context.Context) ([]string, error) {
 		}
 	}
 
-	tags := make([]string, 0, len(entries))
-	for _, entry := range entries {
-		_, filename := path.Split(entry)
-		tags = append(tags, filename)
-	}
-
 	// there is no guarantee for the order,
 	// therefore sort before return.
 	sort.Strings(tags)
@@ -66,23 +79,195 @@ func (ts *tagStore) All(ctx context.Context) ([]string, error) {
 // Tag tags the digest with the given tag, updating the store to point at
 // the current tag. The digest must point to a manifest.
 func (ts *tagStore) Tag(ctx context.Context, tag string, desc v1.Descriptor) error {
-	currentPath, err := pathFor(manifestTagCurrentPathSpec{
-		name: ts.repository.Named().Name(),
-		tag:  tag,
-	})
-	if err != nil {
+	return ts.TagBatch(ctx, []distribution.TagOperation{{Tag: tag, Desc: desc}})
+}
+
+// untagged deletes the revision a tag was moved away from if deleting
+// untagged revisions is enabled. The tag is updated already, so failures
+// are only logged, and the revision is left to the garbage collector.
+func (ts *tagStore) untagged(ctx context.Context, dgst digest.Digest) {
+	if !ts.deleteUntaggedEnabled {
+		return
+	}
+	if err := ts.deleteUntagged(ctx, dgst); err != nil {
+		dcontext.GetLoggerWithField(ctx, "digest", dgst).Errorf("failed to delete untagged manifest: %v", err)
+	}
+}
+
+// stagedTag is a tag updated by TagBatch, along with the revision it pointed
+// to before, so that it can be restored.
+type stagedTag struct {
+	Tag      string        `json:"tag"`
+	Previous digest.Digest `json:"previous,omitempty"`
+	Revision digest.Digest `json:"revision"`
+}
+
+// TagBatch tags the digests of ops with their tags, all or nothing. The tag
+// index entries are staged first, then the current links are overwritten.
+// If any write fails, the tags staged are restored to their previous
+// revision, and the tags created are removed.
+//
+// A batch of several tags is journaled before its current links are
+// overwritten, and commits when the journal is removed: a batch interrupted
+// by a crash is rolled back by the next batch of the repository. The current
+// links are still overwritten one by one, so readers may observe a batch
+// being committed.
+func (ts *tagStore) TagBatch(ctx context.Context, ops []distribution.TagOperation) error {
+	journaled := len(ops) > 1
+	if journaled {
+		if err := ts.recoverTagBatch(ctx); err != nil {
+			return fmt.Errorf("rolling back interrupted tag batch: %w", err)
+		}
+	}
+
+	staged := make([]stagedTag, 0, len(ops))
+	var journalPath string
+	rollback := func(err error) error {
+		restored := true
+		for i := len(staged) - 1; i >= 0; i-- {
+			if rErr := ts.restore(ctx, staged[i]); rErr != nil {
+				err = errors.Join(err, rErr)
+				restored = false
+			}
+		}
+		// a batch rolled back partially stays journaled, for the next batch
+		// to roll back
+		if journalPath != "" && restored {
+			if dErr := ts.blobStore.driver.Delete(ctx, journalPath); dErr != nil && !errors.As(dErr, new(storagedriver.PathNotFoundError)) {
+				err = errors.Join(err, dErr)
+			}
+		}
 		return err
 	}
 
-	lbs := ts.linkedBlobStore(ctx, tag)
+	for _, op := range ops {
+		if err := ts.checkPathLimits(op.Tag, op.Desc.Digest); err != nil {
+			return rollback(err)
+		}
 
-	// Link into the index
-	if err := lbs.linkBlob(ctx, desc); err != nil {
+		tag := stagedTag{Tag: op.Tag, Revision: op.Desc.Digest}
+		desc, err := ts.Get(ctx, op.Tag)
+		switch {
+		case err == nil:
+			tag.Previous = desc.Digest
+		case errors.As(err, new(distribution.ErrTagUnknown)):
+		default:
+			return rollback(err)
+		}
+		staged = append(staged, tag)
+
+		if err := ts.linkedBlobStore(ctx, op.Tag).linkBlob(ctx, op.Desc); err != nil {
+			return rollback(err)
+		}
+	}
+
+	if journaled {
+		content, err := json.Marshal(staged)
+		if err != nil {
+			return rollback(err)
+		}
+		if journalPath, err = pathFor(manifestTagBatchPathSpec{name: ts.repository.Named().Name()}); err != nil {
+			return rollback(err)
+		}
+		if err := ts.blobStore.driver.PutContent(ctx, journalPath, content); err != nil {
+			return rollback(err)
+		}
+	}
+
+	for _, op := range ops {
+		currentPath, err := pathFor(manifestTagCurrentPathSpec{
+			name: ts.repository.Named().Name(),
+			tag:  op.Tag,
+		})
+		if err != nil {
+			return rollback(err)
+		}
+		if err := ts.blobStore.link(ctx, currentPath, op.Desc.Digest); err != nil {
+			return rollback(err)
+		}
+	}
+
+	if journaled {
+		if err := ts.blobStore.driver.Delete(ctx, journalPath); err != nil {
+			return rollback(err)
+		}
+	}
+
+	for _, tag := range staged {
+		if tag.Previous != "" && tag.Previous != tag.Revision {
+			ts.untagged(ctx, tag.Previous)
+		}
+	}
+	return nil
+}
+
+// recoverTagBatch rolls back the batch left journaled by an interrupted
+// TagBatch. The tags updated since by other requests are left as they are.
+func (ts *tagStore) recoverTagBatch(ctx context.Context) error {
+	journalPath, err := pathFor(manifestTagBatchPathSpec{name: ts.repository.Named().Name()})
+	if err != nil {
+		return err
+	}
+	content, err := ts.blobStore.driver.GetContent(ctx, journalPath)
+	if err != nil {
+		if errors.As(err, new(storagedriver.PathNotFoundError)) {
+			return nil
+		}
 		return err
 	}
+	var staged []stagedTag
+	if err := json.Unmarshal(content, &staged); err != nil {
+		// interrupted while journaling, before any current link was
+		// overwritten
+		dcontext.GetLogger(ctx).Warnf("discarding truncated tag batch journal %s: %v", journalPath, err)
+		return ts.blobStore.driver.Delete(ctx, journalPath)
+	}
 
-	// Overwrite the current link
-	return ts.blobStore.link(ctx, currentPath, desc.Digest)
+	for i := len(staged) - 1; i >= 0; i-- {
+		current, err := ts.Get(ctx, staged[i].Tag)
+		switch {
+		case err == nil && current.Digest == staged[i].Revision:
+		case errors.As(err, new(distribution.ErrTagUnknown)) && staged[i].Previous == "":
+			// created without its current link
+		case err == nil, errors.As(err, new(distribution.ErrTagUnknown)):
+			// not overwritten, or updated since
+			continue
+		default:
+			return err
+		}
+		if err := ts.restore(ctx, staged[i]); err != nil {
+			return err
+		}
+	}
+	dcontext.GetLoggerWithField(ctx, "tags", len(staged)).Warnf("rolled back interrupted tag batch of repository %s", ts.repository.Named().Name())
+	return ts.blobStore.driver.Delete(ctx, journalPath)
+}
+
+// restore points a tag staged by TagBatch back at its previous revision, or
+// removes it if it did not exist.
+func (ts *tagStore) restore(ctx context.Context, tag stagedTag) error {
+	if tag.Previous != "" {
+		currentPath, err := pathFor(manifestTagCurrentPathSpec{
+			name: ts.repository.Named().Name(),
+			tag:  tag.Tag,
+		})
+		if err != nil {
+			return err
+		}
+		return ts.blobStore.link(ctx, currentPath, tag.Previous)
+	}
+
+	tagPath, err := pathFor(manifestTagPathSpec{
+		name: ts.repository.Named().Name(),
+		tag:  tag.Tag,
+	})
+	if err != nil {
+		return err
+	}
+	if err := ts.blobStore.driver.Delete(ctx, tagPath); err != nil && !errors.As(err, new(storagedriver.PathNotFoundError)) {
+		return err
+	}
+	return nil
 }
 
 // resolve the current revision for name and tag.
@@ -121,7 +306,20 @@ func (ts *tagStore) Untag(ctx context.Context, tag string) error {
 		return err
 	}
 
-	return ts.blobStore.driver.Delete(ctx, tagPath)
+	if err := ts.blobStore.driver.Delete(ctx, tagPath); err != nil {
+		return err
+	}
+	ts.repository.pruned(ctx)
+	return nil
+}
+
+// checkPathLimits checks the paths tagging revision with tag against the
+// limits of the storage driver.
+func (ts *tagStore) checkPathLimits(tag string, revision digest.Digest) error {
+	name := ts.repository.Named().Name()
+	return checkPathLimits(name, ts.repository.registry.limits,
+		manifestTagIndexEntryLinkPathSpec{name: name, tag: tag, revision: revision},
+	)
 }
 
 // linkedBlobStore returns the linkedBlobStore for the named tag, allowing one
@@ -297,6 +495,127 @@ func (ts *tagStore) List(ctx context.Context, limit int, last string) ([]string,
 	return tags, io.EOF
 }
 
+// ListFiltered returns the tags of the repository matching opts. Tags are
+// filtered while the tag directory is enumerated, using the modification
+// time of their current link, so that only matching tags count towards limit.
+func (ts *tagStore) ListFiltered(ctx context.Context, opts distribution.TagListOptions, limit int, last string) ([]distribution.TagInfo, error) {
+	var tags []distribution.TagInfo
+
+	if limit == 0 {
+		return tags, errors.New("attempted to list 0 tags")
+	}
+
+	root, err := pathFor(manifestTagsPathSpec{
+		name: ts.repository.Named().Name(),
+	})
+	if err != nil {
+		return tags, err
+	}
+
+	startAfter := ""
+	if last != "" {
+		startAfter, err = pathFor(manifestTagPathSpec{
+			name: ts.repository.Named().Name(),
+			tag:  last,
+		})
+		if err != nil {
+			return tags, err
+		}
+	}
+
+	// the literal prefix of the glob narrows the walk like an explicit prefix
+	prefix := opts.Prefix
+	if globPrefix := globLiteralPrefix(opts.Glob); strings.HasPrefix(globPrefix, prefix) {
+		prefix = globPrefix
+	} else if !strings.HasPrefix(prefix, globPrefix) {
+		// no tag can start with both
+		return tags, io.EOF
+	}
+
+	filterTime := !opts.ModifiedBefore.IsZero() || !opts.ModifiedAfter.IsZero()
+	filledBuffer := false
+	err = ts.blobStore.driver.Walk(ctx, root, func(fileInfo storagedriver.FileInfo) error {
+		return handleTag(fileInfo, root, last, func(tag string) error {
+			if !strings.HasPrefix(tag, prefix) {
+				if tag > prefix {
+					// tags are walked in lexical order, none of the
+					// remaining ones can match
+					return storagedriver.ErrFilledBuffer
+				}
+				return nil
+			}
+			if opts.Glob != "" && !matchGlob(opts.Glob, tag) {
+				return nil
+			}
+
+			info := distribution.TagInfo{Name: tag}
+			if filterTime || opts.Details {
+				currentPath, err := pathFor(manifestTagCurrentPathSpec{
+					name: ts.repository.Named().Name(),
+					tag:  tag,
+				})
+				if err != nil {
+					return err
+				}
+
+				fi, err := ts.blobStore.driver.Stat(ctx, currentPath)
+				if err != nil {
+					switch err.(type) {
+					case storagedriver.PathNotFoundError:
+						// the tag is being deleted
+						return nil
+					}
+					return err
+				}
+				modTime := fi.ModTime()
+				if !opts.ModifiedBefore.IsZero() && !modTime.Before(opts.ModifiedBefore) {
+					return nil
+				}
+				if !opts.ModifiedAfter.IsZero() && !modTime.After(opts.ModifiedAfter) {
+					return nil
+				}
+
+				if opts.Details {
+					dgst, err := ts.blobStore.readlink(ctx, currentPath)
+					if err != nil {
+						switch err.(type) {
+						case storagedriver.PathNotFoundError:
+							return nil
+						}
+						return err
+					}
+					info.Digest = dgst
+					info.ModTime = modTime
+				}
+			}
+
+			tags = append(tags, info)
+			// if we've filled our slice, no need to walk any further
+			if limit > 0 && len(tags) == limit {
+				filledBuffer = true
+				return storagedriver.ErrFilledBuffer
+			}
+			return nil
+		})
+	}, storagedriver.WithStartAfterHint(startAfter))
+
+	if err != nil {
+		switch err := err.(type) {
+		case storagedriver.PathNotFoundError:
+			return tags, distribution.ErrRepositoryUnknown{Name: ts.repository.Named().Name()}
+		default:
+			return tags, err
+		}
+	}
+
+	if filledBuffer {
+		// There are potentially more tags to list
+		return tags, nil
+	}
+
+	return tags, io.EOF
+}
+
 // handleTag calls function fn with a tag path if fileInfo
 // has a path of a tag under root and that it is lexographically
 // after last. Otherwise, it will return ErrSkipDir or ErrFilledBuffer.
@@ -319,3 +638,50 @@ func handleTag(fileInfo storagedriver.FileInfo, root, last string, fn func(tagPa
 	}
 	return storagedriver.ErrSkipDir
 }
+
+// globLiteralPrefix returns the part of pattern before its first wildcard.
+func globLiteralPrefix(pattern string) string {
+	if i := strings.IndexAny(pattern, "*?"); i >= 0 {
+		return pattern[:i]
+	}
+	return pattern
+}
+
+// matchGlob reports whether name matches pattern, where '*' matches any
+// sequence of characters and '?' any single character. Every other
+// character matches itself.
+func matchGlob(pattern, name string) bool {
+	px, nx := 0, 0
+	// position to resume from when the last '*' must match one more
+	// character, if any
+	starPx, starNx := -1, -1
+	for px < len(pattern) || nx < len(name) {
+		if px < len(pattern) {
+			switch c := pattern[px]; c {
+			case '*':
+				starPx, starNx = px, nx+1
+				px++
+				continue
+			case '?':
+				if nx < len(name) {
+					px++
+					nx++
+					continue
+				}
+			default:
+				if nx < len(name) && name[nx] == c {
+					px++
+					nx++
+					continue
+				}
+			}
+		}
+		if starPx >= 0 && starNx <= len(name) {
+			px, nx = starPx+1, starNx
+			starNx++
+			continue
+		}
+		return false
+	}
+	return true
+}
@@ -2,12 +2,20 @@ package storage
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
 	"github.com/distribution/distribution/v3/manifest/schema2"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
 	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/distribution/v3/registry/storage/driver/testsuites"
 	"github.com/distribution/reference"
 	digest "github.com/opencontainers/go-digest"
 	"github.com/opencontainers/image-spec/specs-go"
@@ -183,6 +191,199 @@ func TestTagStoreAll(t *testing.T) {
 	}
 }
 
+func TestTagStoreListFiltered(t *testing.T) {
+	env := testTagStore(t)
+	tagStore := env.ts.(distribution.TagFilterLister)
+	ctx := env.ctx
+
+	older := v1.Descriptor{Digest: "sha256:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"}
+	newer := v1.Descriptor{Digest: "sha256:bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"}
+	for _, tag := range []string{"pr-1", "pr-2", "release-1"} {
+		if err := env.ts.Tag(ctx, tag, older); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+	boundary := time.Now()
+	time.Sleep(10 * time.Millisecond)
+	for _, tag := range []string{"pr-3", "release-2"} {
+		if err := env.ts.Tag(ctx, tag, newer); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	names := func(tags []distribution.TagInfo) []string {
+		var names []string
+		for _, tag := range tags {
+			names = append(names, tag.Name)
+		}
+		return names
+	}
+
+	for _, tc := range []struct {
+		name     string
+		opts     distribution.TagListOptions
+		limit    int
+		last     string
+		expected []string
+		err      error
+	}{
+		{
+			name:     "prefix",
+			opts:     distribution.TagListOptions{Prefix: "pr-"},
+			limit:    -1,
+			expected: []string{"pr-1", "pr-2", "pr-3"},
+			err:      io.EOF,
+		},
+		{
+			name:     "modified before",
+			opts:     distribution.TagListOptions{ModifiedBefore: boundary},
+			limit:    -1,
+			expected: []string{"pr-1", "pr-2", "release-1"},
+			err:      io.EOF,
+		},
+		{
+			name:     "prefix and modified before",
+			opts:     distribution.TagListOptions{Prefix: "pr-", ModifiedBefore: boundary},
+			limit:    -1,
+			expected: []string{"pr-1", "pr-2"},
+			err:      io.EOF,
+		},
+		{
+			name:     "prefix and modified after",
+			opts:     distribution.TagListOptions{Prefix: "release-", ModifiedAfter: boundary},
+			limit:    -1,
+			expected: []string{"release-2"},
+			err:      io.EOF,
+		},
+		{
+			name:     "empty time range",
+			opts:     distribution.TagListOptions{ModifiedBefore: boundary, ModifiedAfter: boundary},
+			limit:    -1,
+			expected: nil,
+			err:      io.EOF,
+		},
+		{
+			name:     "limit counts matching tags",
+			opts:     distribution.TagListOptions{Prefix: "pr-", ModifiedBefore: boundary},
+			limit:    1,
+			expected: []string{"pr-1"},
+		},
+		{
+			name:     "last",
+			opts:     distribution.TagListOptions{Prefix: "pr-"},
+			limit:    -1,
+			last:     "pr-1",
+			expected: []string{"pr-2", "pr-3"},
+			err:      io.EOF,
+		},
+		{
+			name:     "glob",
+			opts:     distribution.TagListOptions{Glob: "*-1"},
+			limit:    -1,
+			expected: []string{"pr-1", "release-1"},
+			err:      io.EOF,
+		},
+		{
+			name:     "glob with single character wildcard",
+			opts:     distribution.TagListOptions{Glob: "?r-?"},
+			limit:    -1,
+			expected: []string{"pr-1", "pr-2", "pr-3"},
+			err:      io.EOF,
+		},
+		{
+			name:     "glob and prefix",
+			opts:     distribution.TagListOptions{Prefix: "release-", Glob: "*-2"},
+			limit:    -1,
+			expected: []string{"release-2"},
+			err:      io.EOF,
+		},
+		{
+			name:     "glob disjoint from prefix",
+			opts:     distribution.TagListOptions{Prefix: "release-", Glob: "pr-*"},
+			limit:    -1,
+			expected: nil,
+			err:      io.EOF,
+		},
+		{
+			name:     "glob and modified after",
+			opts:     distribution.TagListOptions{Glob: "*-?", ModifiedAfter: boundary},
+			limit:    -1,
+			expected: []string{"pr-3", "release-2"},
+			err:      io.EOF,
+		},
+		{
+			name:     "limit counts tags matching glob",
+			opts:     distribution.TagListOptions{Glob: "*-1"},
+			limit:    1,
+			expected: []string{"pr-1"},
+		},
+		{
+			name:     "glob and last",
+			opts:     distribution.TagListOptions{Glob: "*-1"},
+			limit:    1,
+			last:     "pr-1",
+			expected: []string{"release-1"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tags, err := tagStore.ListFiltered(ctx, tc.opts, tc.limit, tc.last)
+			if err != tc.err {
+				t.Fatalf("expected error %v, got %v", tc.err, err)
+			}
+			if got := names(tags); !reflect.DeepEqual(got, tc.expected) {
+				t.Fatalf("expected tags %v, got %v", tc.expected, got)
+			}
+			for _, tag := range tags {
+				if tag.Digest != "" || !tag.ModTime.IsZero() {
+					t.Fatalf("unexpected details for tag %s without detail requested", tag.Name)
+				}
+			}
+		})
+	}
+
+	tags, err := tagStore.ListFiltered(ctx, distribution.TagListOptions{Prefix: "release-", Details: true}, -1, "")
+	if err != io.EOF {
+		t.Fatalf("expected io.EOF, got %v", err)
+	}
+	if len(tags) != 2 {
+		t.Fatalf("expected 2 tags, got %d", len(tags))
+	}
+	if tags[0].Digest != older.Digest || !tags[0].ModTime.Before(boundary) {
+		t.Errorf("unexpected details for release-1: %+v", tags[0])
+	}
+	if tags[1].Digest != newer.Digest || !tags[1].ModTime.After(boundary) {
+		t.Errorf("unexpected details for release-2: %+v", tags[1])
+	}
+}
+
+func TestMatchGlob(t *testing.T) {
+	for _, tc := range []struct {
+		pattern string
+		name    string
+		match   bool
+	}{
+		{"", "", true},
+		{"", "a", false},
+		{"*", "", true},
+		{"*", "latest", true},
+		{"v1.?", "v1.2", true},
+		{"v1.?", "v1.23", false},
+		{"v1.*", "v1.23", true},
+		{"v*-rc?", "v1.2-rc1", true},
+		{"v*-rc?", "v1.2-rc", false},
+		{"*a*b", "xaybzab", true},
+		{"*a*b", "xaybzabc", false},
+		{"a**", "a", true},
+		{"[ab]", "a", false},
+		{"[ab]", "[ab]", true},
+	} {
+		if match := matchGlob(tc.pattern, tc.name); match != tc.match {
+			t.Errorf("matchGlob(%q, %q) = %v, expected %v", tc.pattern, tc.name, match, tc.match)
+		}
+	}
+}
+
 func TestTagLookup(t *testing.T) {
 	env := testTagStore(t)
 	tagStore := env.ts
@@ -329,3 +530,232 @@ func digestMap(dgsts []digest.Digest) map[digest.Digest]struct{} {
 	}
 	return set
 }
+
+func TestTagStoreTagBatch(t *testing.T) {
+	ctx := context.Background()
+	d := testsuites.NewFaultInjector(inmemory.New())
+	repo := makeRepository(t, createRegistry(t, d), "a/b")
+	tags := repo.Tags(ctx)
+	batcher, ok := tags.(distribution.TagBatcher)
+	if !ok {
+		t.Fatal("tag store does not support batches")
+	}
+
+	previous := uploadRandomOCIImage(t, repo)
+	released := uploadRandomOCIImage(t, repo)
+	if err := tags.Tag(ctx, "stable", v1.Descriptor{Digest: previous.manifestDigest}); err != nil {
+		t.Fatal(err)
+	}
+	ops := []distribution.TagOperation{
+		{Tag: "stable", Desc: v1.Descriptor{Digest: released.manifestDigest}},
+		{Tag: "v2.4", Desc: v1.Descriptor{Digest: released.manifestDigest}},
+		{Tag: "latest", Desc: v1.Descriptor{Digest: released.manifestDigest}},
+	}
+
+	checkTags := func(expected map[string]digest.Digest) {
+		t.Helper()
+
+		all, err := tags.All(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(all) != len(expected) {
+			t.Fatalf("unexpected tags %v, expected %v", all, expected)
+		}
+		for tag, dgst := range expected {
+			desc, err := tags.Get(ctx, tag)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if desc.Digest != dgst {
+				t.Errorf("tag %s points to %s, expected %s", tag, desc.Digest, dgst)
+			}
+		}
+	}
+
+	// the tag index entries are staged with a write per operation, the
+	// batch is journaled, then the current links are written: fail the
+	// current link of the second tag
+	d.Inject(testsuites.Fault{Op: testsuites.OpPutContent, Call: len(ops) + 3, Err: errors.New("injected failure")})
+	if err := batcher.TagBatch(ctx, ops); err == nil {
+		t.Fatal("expected the batch to fail")
+	}
+	checkTags(map[string]digest.Digest{"stable": previous.manifestDigest})
+
+	// fail the rollback too, as if the registry crashed: the batch is left
+	// journaled with the first tag moved, and rolled back by the next batch
+	d.Clear()
+	d.Inject(
+		testsuites.Fault{Op: testsuites.OpPutContent, Call: len(ops) + 3, Err: errors.New("injected failure")},
+		testsuites.Fault{Op: testsuites.OpPutContent, Call: len(ops) + 4, Err: errors.New("injected failure")},
+	)
+	if err := batcher.TagBatch(ctx, ops); err == nil {
+		t.Fatal("expected the batch to fail")
+	}
+	checkTags(map[string]digest.Digest{"stable": released.manifestDigest})
+
+	d.Clear()
+	nightly := []distribution.TagOperation{
+		{Tag: "nightly", Desc: v1.Descriptor{Digest: released.manifestDigest}},
+		{Tag: "edge", Desc: v1.Descriptor{Digest: released.manifestDigest}},
+	}
+	if err := batcher.TagBatch(ctx, nightly); err != nil {
+		t.Fatal(err)
+	}
+	checkTags(map[string]digest.Digest{
+		"stable":  previous.manifestDigest,
+		"nightly": released.manifestDigest,
+		"edge":    released.manifestDigest,
+	})
+
+	if err := batcher.TagBatch(ctx, ops); err != nil {
+		t.Fatal(err)
+	}
+	checkTags(map[string]digest.Digest{
+		"stable":  released.manifestDigest,
+		"v2.4":    released.manifestDigest,
+		"latest":  released.manifestDigest,
+		"nightly": released.manifestDigest,
+		"edge":    released.manifestDigest,
+	})
+
+	journalPath, err := pathFor(manifestTagBatchPathSpec{name: "a/b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Stat(ctx, journalPath); !errors.As(err, new(storagedriver.PathNotFoundError)) {
+		t.Fatalf("expected the journal to be removed, got %v", err)
+	}
+}
+
+func TestTagStoreDeleteUntagged(t *testing.T) {
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		name string
+		// reference references the first image to keep it
+		reference func(t *testing.T, repo distribution.Repository, ms distribution.ManifestService, old image)
+		disabled  bool
+		deleted   bool
+	}{
+		{
+			name:    "unreferenced",
+			deleted: true,
+		},
+		{
+			name:     "disabled",
+			disabled: true,
+		},
+		{
+			name: "tagged",
+			reference: func(t *testing.T, repo distribution.Repository, ms distribution.ManifestService, old image) {
+				if err := repo.Tags(ctx).Tag(ctx, "stable", v1.Descriptor{Digest: old.manifestDigest}); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name: "referrer",
+			reference: func(t *testing.T, repo distribution.Repository, ms distribution.ManifestService, old image) {
+				_, payload, err := old.manifest.Payload()
+				if err != nil {
+					t.Fatal(err)
+				}
+				var fields map[string]any
+				if err := json.Unmarshal(payload, &fields); err != nil {
+					t.Fatal(err)
+				}
+				fields["subject"] = v1.Descriptor{MediaType: v1.MediaTypeImageManifest, Digest: old.manifestDigest, Size: int64(len(payload))}
+				fields["artifactType"] = "application/vnd.example.signature"
+				referrer, err := json.Marshal(fields)
+				if err != nil {
+					t.Fatal(err)
+				}
+				m := new(ocischema.DeserializedManifest)
+				if err := m.UnmarshalJSON(referrer); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := ms.Put(ctx, m); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name: "unindexed referrer",
+			reference: func(t *testing.T, repo distribution.Repository, ms distribution.ManifestService, old image) {
+				putReferrer(t, ms, old, old.manifestDigest, "application/vnd.example.signature")
+				// pushed before the referrers index was maintained
+				root, err := pathFor(manifestReferrersPathSpec{name: repo.Named().Name(), subject: old.manifestDigest})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := repo.(*repository).driver.Delete(ctx, root); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name: "index",
+			reference: func(t *testing.T, repo distribution.Repository, ms distribution.ManifestService, old image) {
+				_, payload, err := old.manifest.Payload()
+				if err != nil {
+					t.Fatal(err)
+				}
+				index, err := ocischema.FromDescriptors([]v1.Descriptor{{MediaType: v1.MediaTypeImageManifest, Digest: old.manifestDigest, Size: int64(len(payload))}}, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := ms.Put(ctx, index); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+	} {
+		// the references are found by reading the revisions until the
+		// indexes are backfilled, and through the indexes since
+		for _, backfilled := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s/backfilled=%t", tc.name, backfilled), func(t *testing.T) {
+				var options []RegistryOption
+				if !tc.disabled {
+					options = append(options, EnableDeleteUntagged)
+				}
+				d := inmemory.New()
+				repo := makeRepository(t, createRegistry(t, d, options...), "a/b")
+				ms := makeManifestService(t, repo)
+				tags := repo.Tags(ctx)
+
+				old := uploadRandomOCIImage(t, repo)
+				current := uploadRandomOCIImage(t, repo)
+				if err := tags.Tag(ctx, "latest", v1.Descriptor{Digest: old.manifestDigest}); err != nil {
+					t.Fatal(err)
+				}
+				if tc.reference != nil {
+					tc.reference(t, repo, ms, old)
+				}
+				if backfilled {
+					if _, err := BackfillIndexes(ctx, d, BackfillIndexesOpts{}); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				// the tag is moved twice, the second time to the same manifest
+				for i := 0; i < 2; i++ {
+					if err := tags.Tag(ctx, "latest", v1.Descriptor{Digest: current.manifestDigest}); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				exists, err := ms.Exists(ctx, old.manifestDigest)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if exists == tc.deleted {
+					t.Fatalf("unexpected existence of the untagged manifest: %v", exists)
+				}
+				if exists, err := ms.Exists(ctx, current.manifestDigest); err != nil || !exists {
+					t.Fatalf("tagged manifest deleted: %v", err)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	prometheus "github.com/distribution/distribution/v3/metrics"
+	"github.com/docker/go-metrics"
+	promclient "github.com/prometheus/client_golang/prometheus"
+)
+
+// Directions and legs of blob transfers. Uploads flow from the client to the
+// registry, then from the registry to the storage backend; downloads flow
+// the other way.
+const (
+	transferUpload   = "upload"
+	transferDownload = "download"
+
+	transferClient  = "client"
+	transferBackend = "backend"
+)
+
+const (
+	defaultTransferMinSize    = 1 << 20
+	defaultTransferMediumSize = 16 << 20
+	defaultTransferLargeSize  = 256 << 20
+)
+
+// transferSpeed is the speed of blob transfers, observed for each leg of the
+// transfers.
+var transferSpeed = promclient.NewHistogramVec(promclient.HistogramOpts{
+	Namespace: prometheus.NamespacePrefix,
+	Subsystem: "transfer",
+	Name:      "speed_bytes_per_second",
+	Help:      "The speed of blob transfers between the registry and its clients or storage backend",
+	// 64KiB/s to 2GiB/s
+	Buckets: promclient.ExponentialBuckets(64<<10, 2, 16),
+}, []string{"driver", "direction", "leg", "size"})
+
+func init() {
+	prometheus.TransferNamespace.Add(transferSpeed)
+	metrics.Register(prometheus.TransferNamespace)
+}
+
+// transferSizeClasses classes transfers by the number of bytes moved.
+// Transfers of fewer than minSize bytes are not observed, as their speed
+// reflects the latency of the requests rather than the throughput of the
+// legs, and would outnumber the transfers of large blobs.
+type transferSizeClasses struct {
+	minSize    int64
+	mediumSize int64
+	largeSize  int64
+}
+
+// class returns the size class of a transfer of size bytes.
+func (c transferSizeClasses) class(size int64) string {
+	switch {
+	case c.largeSize > 0 && size >= c.largeSize:
+		return "large"
+	case c.mediumSize > 0 && size >= c.mediumSize:
+		return "medium"
+	default:
+		return "small"
+	}
+}
+
+// TransferSpeedSizeClasses is a functional option for NewRegistry. It sets
+// the sizes from which transfers are classed as medium and large by the
+// transfer speed metrics, and the size under which transfers are not
+// observed. A zero size leaves the default.
+func TransferSpeedSizeClasses(minSize, mediumSize, largeSize int64) RegistryOption {
+	return func(registry *registry) error {
+		classes := registry.blobServer.transferClasses
+		if minSize != 0 {
+			classes.minSize = minSize
+		}
+		if mediumSize != 0 {
+			classes.mediumSize = mediumSize
+		}
+		if largeSize != 0 {
+			classes.largeSize = largeSize
+		}
+		if classes.minSize < 0 || classes.mediumSize <= 0 || classes.largeSize <= classes.mediumSize {
+			return fmt.Errorf("invalid transfer size classes: minimum %d, medium %d, large %d", classes.minSize, classes.mediumSize, classes.largeSize)
+		}
+		registry.blobServer.transferClasses = classes
+		registry.blobStore.transferClasses = classes
+		return nil
+	}
+}
+
+// transferLeg accumulates the bytes moved over a leg of a transfer and the
+// time spent moving them.
+type transferLeg struct {
+	bytes   int64
+	elapsed time.Duration
+}
+
+// add accounts n bytes moved since start.
+func (l *transferLeg) add(n int, start time.Time) {
+	l.bytes += int64(n)
+	l.elapsed += time.Since(start)
+}
+
+// transferMeter measures the speed of a blob transfer over its client and
+// backend legs. The time spent in one leg is not attributed to the other, so
+// that the slowest leg of transfers shows as the slowest.
+type transferMeter struct {
+	driver    string
+	direction string
+	classes   transferSizeClasses
+
+	client  transferLeg
+	backend transferLeg
+}
+
+// observe records the speed of the legs of the transfer and resets the
+// meter. Legs of fewer bytes than the minimum size are not observed.
+func (m *transferMeter) observe() {
+	for leg, l := range map[string]transferLeg{transferClient: m.client, transferBackend: m.backend} {
+		if l.bytes == 0 || l.bytes < m.classes.minSize || l.elapsed <= 0 {
+			continue
+		}
+		transferSpeed.WithLabelValues(m.driver, m.direction, leg, m.classes.class(l.bytes)).Observe(float64(l.bytes) / l.elapsed.Seconds())
+	}
+	m.reset()
+}
+
+// reset discards the legs of the transfer.
+func (m *transferMeter) reset() {
+	m.client, m.backend = transferLeg{}, transferLeg{}
+}
+
+// timedReader accounts the reads from r to a transfer leg.
+type timedReader struct {
+	r   io.Reader
+	leg *transferLeg
+}
+
+func (tr *timedReader) Read(p []byte) (int, error) {
+	start := time.Now()
+	n, err := tr.r.Read(p)
+	tr.leg.add(n, start)
+	return n, err
+}
+
+// timedReadSeeker accounts the reads from a ReadSeeker to a transfer leg. It
+// records the first error reading, other than io.EOF.
+type timedReadSeeker struct {
+	io.ReadSeeker
+	leg *transferLeg
+	err error
+}
+
+func (trs *timedReadSeeker) Read(p []byte) (int, error) {
+	start := time.Now()
+	n, err := trs.ReadSeeker.Read(p)
+	trs.leg.add(n, start)
+	if err != nil && err != io.EOF && trs.err == nil {
+		trs.err = err
+	}
+	return n, err
+}
+
+// timedWriter accounts the writes to w to a transfer leg.
+type timedWriter struct {
+	w   io.Writer
+	leg *transferLeg
+}
+
+func (tw *timedWriter) Write(p []byte) (int, error) {
+	start := time.Now()
+	n, err := tw.w.Write(p)
+	tw.leg.add(n, start)
+	return n, err
+}
+
+// timedResponseWriter accounts the writes of a response body to a transfer
+// leg.
+type timedResponseWriter struct {
+	http.ResponseWriter
+	leg *transferLeg
+}
+
+func (trw *timedResponseWriter) Write(p []byte) (int, error) {
+	start := time.Now()
+	n, err := trw.ResponseWriter.Write(p)
+	trw.leg.add(n, start)
+	return n, err
+}
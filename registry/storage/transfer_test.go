@@ -0,0 +1,232 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	promclient "github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// throttledRate is the rate of the throttled legs of transfers, in bytes
+// per second.
+const throttledRate = 4 << 20
+
+// throttle sleeps for the time n bytes take at the throttled rate.
+func throttle(n int) {
+	time.Sleep(time.Duration(n) * time.Second / throttledRate)
+}
+
+// throttledDriver throttles the reads and writes of the content of files,
+// when enabled.
+type throttledDriver struct {
+	storagedriver.StorageDriver
+	name      string
+	throttled bool
+}
+
+func (d *throttledDriver) Name() string {
+	return d.name
+}
+
+func (d *throttledDriver) Reader(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
+	rc, err := d.StorageDriver.Reader(ctx, path, offset)
+	if err != nil || !d.throttled {
+		return rc, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{&throttledReader{rc}, rc}, nil
+}
+
+func (d *throttledDriver) Writer(ctx context.Context, path string, append bool) (storagedriver.FileWriter, error) {
+	fw, err := d.StorageDriver.Writer(ctx, path, append)
+	if err != nil || !d.throttled {
+		return fw, err
+	}
+	return &throttledFileWriter{fw}, nil
+}
+
+type throttledReader struct {
+	r io.Reader
+}
+
+func (tr *throttledReader) Read(p []byte) (int, error) {
+	n, err := tr.r.Read(p)
+	throttle(n)
+	return n, err
+}
+
+type throttledFileWriter struct {
+	storagedriver.FileWriter
+}
+
+func (fw *throttledFileWriter) Write(p []byte) (int, error) {
+	throttle(len(p))
+	return fw.FileWriter.Write(p)
+}
+
+// throttledResponseWriter throttles the writes of the response body.
+type throttledResponseWriter struct {
+	http.ResponseWriter
+}
+
+func (w *throttledResponseWriter) Write(p []byte) (int, error) {
+	throttle(len(p))
+	return w.ResponseWriter.Write(p)
+}
+
+// transferSpeedOf returns the number of observations and the sum of the
+// transfer speed histogram of a leg.
+func transferSpeedOf(t *testing.T, driver, direction, leg, size string) (uint64, float64) {
+	t.Helper()
+
+	var m dto.Metric
+	if err := transferSpeed.WithLabelValues(driver, direction, leg, size).(promclient.Metric).Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetHistogram().GetSampleCount(), m.GetHistogram().GetSampleSum()
+}
+
+func TestTransferSpeed(t *testing.T) {
+	ctx := context.Background()
+	name, _ := reference.WithName("foo/bar")
+
+	content := make([]byte, 2<<20)
+	if _, err := rand.Read(content); err != nil {
+		t.Fatal(err)
+	}
+	dgst := digest.FromBytes(content)
+
+	for _, tc := range []struct {
+		driver          string
+		throttleBackend bool
+		throttledLeg    string
+		fastLeg         string
+	}{
+		{driver: "throttled-client", throttledLeg: transferClient, fastLeg: transferBackend},
+		{driver: "throttled-backend", throttleBackend: true, throttledLeg: transferBackend, fastLeg: transferClient},
+	} {
+		t.Run(tc.driver, func(t *testing.T) {
+			driver := &throttledDriver{StorageDriver: inmemory.New(), name: tc.driver, throttled: tc.throttleBackend}
+			transferSpeed.DeletePartialMatch(promclient.Labels{"driver": tc.driver})
+			registry, err := NewRegistry(ctx, driver, TransferSpeedSizeClasses(0, 1<<20, 8<<20))
+			if err != nil {
+				t.Fatal(err)
+			}
+			repo, err := registry.Repository(ctx, name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			bs := repo.Blobs(ctx)
+
+			var body io.Reader = bytes.NewReader(content)
+			if !tc.throttleBackend {
+				body = &throttledReader{body}
+			}
+			wr, err := bs.Create(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := wr.ReadFrom(body); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := wr.Commit(ctx, v1.Descriptor{Digest: dgst}); err != nil {
+				t.Fatal(err)
+			}
+
+			var w http.ResponseWriter = httptest.NewRecorder()
+			if !tc.throttleBackend {
+				w = &throttledResponseWriter{w}
+			}
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if err := bs.ServeBlob(ctx, w, r, dgst); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, direction := range []string{transferUpload, transferDownload} {
+				count, throttled := transferSpeedOf(t, tc.driver, direction, tc.throttledLeg, "medium")
+				if count != 1 {
+					t.Fatalf("unexpected number of %s %s leg observations: %d", direction, tc.throttledLeg, count)
+				}
+				if throttled > throttledRate*1.05 {
+					t.Errorf("%s speed of the throttled %s leg exceeds the throttled rate: %.f", direction, tc.throttledLeg, throttled)
+				}
+				count, fast := transferSpeedOf(t, tc.driver, direction, tc.fastLeg, "medium")
+				if count != 1 {
+					t.Fatalf("unexpected number of %s %s leg observations: %d", direction, tc.fastLeg, count)
+				}
+				if fast < 2*throttled {
+					t.Errorf("%s speed of the %s leg attributed the throttling: %.f, throttled %.f", direction, tc.fastLeg, fast, throttled)
+				}
+			}
+		})
+	}
+
+	// transfers under the minimum size are not observed
+	driver := &throttledDriver{StorageDriver: inmemory.New(), name: "small"}
+	transferSpeed.DeletePartialMatch(promclient.Labels{"driver": "small"})
+	registry, err := NewRegistry(ctx, driver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo, err := registry.Repository(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	desc, err := addBlob(ctx, repo.Blobs(ctx), v1.Descriptor{Digest: digest.FromString("small"), Size: 5}, bytes.NewReader([]byte("small")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Blobs(ctx).ServeBlob(ctx, httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil), desc.Digest); err != nil {
+		t.Fatal(err)
+	}
+	for _, direction := range []string{transferUpload, transferDownload} {
+		for _, leg := range []string{transferClient, transferBackend} {
+			if count, _ := transferSpeedOf(t, "small", direction, leg, "small"); count != 0 {
+				t.Errorf("unexpected %s %s leg observations of a small transfer: %d", direction, leg, count)
+			}
+		}
+	}
+}
+
+func TestTransferSpeedSizeClasses(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		minSize, mediumSize, largeSize int64
+		valid                          bool
+	}{
+		{valid: true},
+		{minSize: 4 << 10, mediumSize: 1 << 20, largeSize: 1 << 30, valid: true},
+		{minSize: -1},
+		{mediumSize: -1},
+		{mediumSize: 1 << 30, largeSize: 1 << 20},
+		{mediumSize: 1 << 30},
+	} {
+		_, err := NewRegistry(ctx, inmemory.New(), TransferSpeedSizeClasses(tc.minSize, tc.mediumSize, tc.largeSize))
+		if tc.valid && err != nil {
+			t.Errorf("unexpected error for %+v: %v", tc, err)
+		} else if !tc.valid && err == nil {
+			t.Errorf("expected an error for %+v", tc)
+		}
+	}
+
+	classes := transferSizeClasses{minSize: 1, mediumSize: 10, largeSize: 100}
+	for size, expected := range map[int64]string{1: "small", 9: "small", 10: "medium", 99: "medium", 100: "large"} {
+		if class := classes.class(size); class != expected {
+			t.Errorf("unexpected class of %d bytes: %s != %s", size, class, expected)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"context"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/manifest/schema2"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// EmptyLayerDigests are the digests of the empty layers which images built
+// by some tools legitimately repeat: the empty tar archive, and the same
+// gzip compressed.
+var EmptyLayerDigests = []digest.Digest{
+	"sha256:5f70bf18a086007016e948b04aed3b82103a36bea41755b6cddfaf10ace3c6ef",
+	"sha256:a3ed95caeb02ffe68cdd9fd84406680ae93d633cb16422d00e8c7c22955b46d4",
+}
+
+// uniqueLayers rejects image manifests listing a layer more than once. The
+// validation is disabled unless enabled.
+type uniqueLayers struct {
+	enabled bool
+	// repeatable are the layers allowed to be listed more than once.
+	repeatable map[digest.Digest]struct{}
+}
+
+// ValidateUniqueLayers is a functional option for NewRegistry. It rejects
+// image manifests listing the same layer digest more than once, except for
+// the digests of repeatable, such as EmptyLayerDigests.
+func ValidateUniqueLayers(repeatable []digest.Digest) RegistryOption {
+	return func(registry *registry) error {
+		registry.uniqueLayers = uniqueLayers{enabled: true, repeatable: make(map[digest.Digest]struct{}, len(repeatable))}
+		for _, dgst := range repeatable {
+			if err := dgst.Validate(); err != nil {
+				return err
+			}
+			registry.uniqueLayers.repeatable[dgst] = struct{}{}
+		}
+		return nil
+	}
+}
+
+// verify returns an error if manifest is an image manifest listing a layer
+// more than once which is not allowed to repeat.
+func (u uniqueLayers) verify(ctx context.Context, manifest distribution.Manifest) error {
+	if !u.enabled {
+		return nil
+	}
+
+	var layers []v1.Descriptor
+	switch m := manifest.(type) {
+	case *schema2.DeserializedManifest:
+		layers = m.Layers
+	case *ocischema.DeserializedManifest:
+		layers = m.Layers
+	default:
+		return nil
+	}
+
+	var errs distribution.ErrManifestVerification
+	seen := make(map[digest.Digest]int, len(layers))
+	for _, layer := range layers {
+		seen[layer.Digest]++
+		if _, ok := u.repeatable[layer.Digest]; ok || seen[layer.Digest] != 2 {
+			continue
+		}
+		errs = append(errs, distribution.ErrManifestLayerDuplicate{Digest: layer.Digest})
+	}
+	if len(errs) != 0 {
+		dcontext.GetLogger(ctx).Infof("rejecting manifest: %v", errs)
+		return errs
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestValidateUniqueLayers(t *testing.T) {
+	config := []byte(`{"architecture":"amd64","os":"linux"}`)
+	layer1 := []byte("layer1")
+	layer2 := []byte("layer2")
+	// the empty tar archive
+	empty := make([]byte, 1024)
+	if digest.FromBytes(empty) != EmptyLayerDigests[0] {
+		t.Fatalf("unexpected digest of the empty layer: %s", digest.FromBytes(empty))
+	}
+
+	for _, tc := range []struct {
+		name       string
+		schema2    bool
+		layers     [][]byte
+		repeatable [][]byte
+		duplicates []digest.Digest
+	}{
+		{name: "unique", layers: [][]byte{layer1, layer2}},
+		{name: "duplicate", layers: [][]byte{layer1, layer2, layer1}, duplicates: []digest.Digest{digest.FromBytes(layer1)}},
+		{name: "listed thrice", layers: [][]byte{layer1, layer1, layer1}, duplicates: []digest.Digest{digest.FromBytes(layer1)}},
+		{name: "several duplicates", layers: [][]byte{layer1, layer2, layer2, layer1}, duplicates: []digest.Digest{digest.FromBytes(layer2), digest.FromBytes(layer1)}},
+		{name: "empty layer", layers: [][]byte{empty, layer1, empty, layer2, empty}},
+		{name: "allowed layer", layers: [][]byte{layer1, layer2, layer1}, repeatable: [][]byte{layer1}},
+		{name: "schema2 unique", schema2: true, layers: [][]byte{layer1, empty, layer2, empty}},
+		{name: "schema2 duplicate", schema2: true, layers: [][]byte{layer2, layer2}, duplicates: []digest.Digest{digest.FromBytes(layer2)}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			repeatable := EmptyLayerDigests
+			for _, content := range tc.repeatable {
+				repeatable = append(repeatable[:len(repeatable):len(repeatable)], digest.FromBytes(content))
+			}
+
+			err := putImageManifest(t, ValidateUniqueLayers(repeatable), tc.schema2, "", config, tc.layers...)
+			if len(tc.duplicates) == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error putting manifest: %v", err)
+				}
+				return
+			}
+			var verificationErrs distribution.ErrManifestVerification
+			if !errors.As(err, &verificationErrs) || len(verificationErrs) != len(tc.duplicates) {
+				t.Fatalf("expected %d manifest verification errors, got %v", len(tc.duplicates), err)
+			}
+			for i, dgst := range tc.duplicates {
+				if verificationErrs[i] != (distribution.ErrManifestLayerDuplicate{Digest: dgst}) {
+					t.Fatalf("expected a duplicate layer error for %s, got %v", dgst, verificationErrs[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// indexFields are the fields of a manifest listing other manifests, such as
+// an image index.
+type indexFields struct {
+	Manifests []v1.Descriptor `json:"manifests,omitempty"`
+}
+
+// indexManifests records the manifest dgst in the index of the manifests it
+// lists, if any, so that deleting untagged manifests finds the indexes
+// referencing them without reading every revision. As for the referrers
+// index, the failures fail the put.
+func (ms *manifestStore) indexManifests(ctx context.Context, dgst digest.Digest, manifest distribution.Manifest) error {
+	_, payload, err := manifest.Payload()
+	if err != nil {
+		return err
+	}
+	linkPaths, err := indexLinkPaths(ms.repository.Named().Name(), dgst, payload)
+	if err != nil {
+		return err
+	}
+	for _, linkPath := range linkPaths {
+		if err := ms.repository.driver.PutContent(ctx, linkPath, []byte(dgst)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// indexLinkPaths returns the paths of the links recording the manifest dgst
+// of repository name, whose content is payload, in the index of each of the
+// manifests it lists.
+func indexLinkPaths(name string, dgst digest.Digest, payload []byte) ([]string, error) {
+	var fields indexFields
+	if err := json.Unmarshal(payload, &fields); err != nil {
+		return nil, nil
+	}
+
+	linkPaths := make([]string, 0, len(fields.Manifests))
+	for _, desc := range fields.Manifests {
+		linkPath, err := pathFor(manifestIndexLinkPathSpec{
+			name:     name,
+			manifest: desc.Digest,
+			index:    dgst,
+		})
+		if err != nil {
+			return nil, err
+		}
+		linkPaths = append(linkPaths, linkPath)
+	}
+	return linkPaths, nil
+}
+
+// deleteUntagged deletes the revision dgst a tag was moved away from, unless
+// another tag points to it, or another revision of the repository references
+// it as its subject or as a manifest of an index. The references are found
+// through the referrers index and the index of the manifests of indexes once
+// the manifests of the repository were backfilled into them by
+// BackfillIndexes. Until then, the references are found by reading every
+// revision of the repository, as the manifests pushed before the indexes
+// were maintained are missing from them.
+func (ts *tagStore) deleteUntagged(ctx context.Context, dgst digest.Digest) error {
+	tags, err := ts.Lookup(ctx, v1.Descriptor{Digest: dgst})
+	if err != nil {
+		return err
+	}
+	if len(tags) > 0 {
+		return nil
+	}
+
+	manifests, err := ts.repository.Manifests(ctx)
+	if err != nil {
+		return err
+	}
+	name := ts.repository.Named().Name()
+	indexed, err := manifestsIndexed(ctx, ts.repository.driver, name)
+	if err != nil {
+		return err
+	}
+	var referenced bool
+	if indexed {
+		referenced, err = ts.indexedReference(ctx, manifests, dgst)
+	} else {
+		referenced, err = ts.scannedReference(ctx, manifests, dgst)
+	}
+	if err != nil || referenced {
+		return err
+	}
+
+	if err := manifests.Delete(ctx, dgst); err != nil {
+		return err
+	}
+	dcontext.GetLoggerWithField(ctx, "digest", dgst).Infof("deleted manifest untagged from repository %s", name)
+	return nil
+}
+
+// indexedReference reports whether another revision of the repository
+// references the manifest dgst, according to the referrers index and the
+// index of the manifests of indexes.
+func (ts *tagStore) indexedReference(ctx context.Context, manifests distribution.ManifestService, dgst digest.Digest) (bool, error) {
+	name := ts.repository.Named().Name()
+	for _, spec := range []pathSpec{
+		manifestReferrersPathSpec{name: name, subject: dgst},
+		manifestIndexesPathSpec{name: name, manifest: dgst},
+	} {
+		root, err := pathFor(spec)
+		if err != nil {
+			return false, err
+		}
+		referenced, err := ts.repository.hasLinkedManifest(ctx, manifests, root, dgst)
+		if err != nil || referenced {
+			return referenced, err
+		}
+	}
+	return false, nil
+}
+
+// scannedReference reports whether another revision of the repository
+// references the manifest dgst, reading every revision.
+func (ts *tagStore) scannedReference(ctx context.Context, manifests distribution.ManifestService, dgst digest.Digest) (bool, error) {
+	enumerator, ok := manifests.(distribution.ManifestEnumerator)
+	if !ok {
+		return false, distribution.ErrUnsupported
+	}
+	err := enumerator.Enumerate(ctx, func(revision digest.Digest) error {
+		if revision == dgst {
+			return nil
+		}
+		referenced, err := ts.references(ctx, revision, dgst)
+		if err != nil {
+			return err
+		}
+		if referenced {
+			return errLinkedManifestFound
+		}
+		return nil
+	})
+	switch {
+	case errors.Is(err, errLinkedManifestFound):
+		return true, nil
+	case err != nil:
+		return false, err
+	}
+	return false, nil
+}
+
+// references returns whether the revision references the manifest dgst, as
+// its subject or as one of the manifests it lists.
+func (ts *tagStore) references(ctx context.Context, revision, dgst digest.Digest) (bool, error) {
+	payload, err := ts.blobStore.Get(ctx, revision)
+	if err != nil {
+		if errors.Is(err, distribution.ErrBlobUnknown) {
+			// revisions whose blob is gone reference nothing
+			return false, nil
+		}
+		return false, err
+	}
+
+	var fields struct {
+		referrerFields
+		indexFields
+	}
+	if err := json.Unmarshal(payload, &fields); err != nil {
+		// not a manifest referencing other manifests
+		return false, nil
+	}
+	if fields.Subject != nil && fields.Subject.Digest == dgst {
+		return true, nil
+	}
+	for _, desc := range fields.Manifests {
+		if desc.Digest == dgst {
+			return true, nil
+		}
+	}
+	return false, nil
+}
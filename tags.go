@@ -2,6 +2,7 @@ package distribution
 
 import (
 	"context"
+	"time"
 
 	"github.com/opencontainers/go-digest"
 	v1 "github.com/opencontainers/image-spec/specs-go/v1"
@@ -39,3 +40,57 @@ type TagManifestsProvider interface {
 	// includes currently linked digest. There is no ordering guaranteed
 	ManifestDigests(ctx context.Context, tag string) ([]digest.Digest, error)
 }
+
+// TagListOptions restricts and describes the tags returned by a
+// TagFilterLister.
+type TagListOptions struct {
+	// Prefix, if set, restricts the listing to tags starting with it.
+	Prefix string
+
+	// Glob, if set, restricts the listing to tags matching it, where '*'
+	// matches any sequence of characters and '?' any single character.
+	Glob string
+
+	// ModifiedBefore, if set, restricts the listing to tags last modified
+	// before it.
+	ModifiedBefore time.Time
+
+	// ModifiedAfter, if set, restricts the listing to tags last modified
+	// after it.
+	ModifiedAfter time.Time
+
+	// Details requests the digest and modification time of each tag.
+	Details bool
+}
+
+// TagInfo describes a tag returned by a TagFilterLister. Digest and ModTime
+// are only set when details were requested.
+type TagInfo struct {
+	Name    string
+	Digest  digest.Digest
+	ModTime time.Time
+}
+
+// TagFilterLister provides a method to list the tags matching a filter,
+// without requiring the client to resolve each tag individually.
+type TagFilterLister interface {
+	// ListFiltered returns up to limit tags after last which match opts. A
+	// negative limit returns all matching tags. io.EOF is returned once
+	// there are no more matching tags.
+	ListFiltered(ctx context.Context, opts TagListOptions, limit int, last string) ([]TagInfo, error)
+}
+
+// TagOperation points a tag at a manifest.
+type TagOperation struct {
+	Tag  string
+	Desc v1.Descriptor
+}
+
+// TagBatcher provides a method to update several tags at once, so that
+// observers never see them disagree.
+type TagBatcher interface {
+	// TagBatch associates each tag of ops with its descriptor. Either all
+	// the operations are applied, or none of them: the tags already updated
+	// are restored if an operation fails.
+	TagBatch(ctx context.Context, ops []TagOperation) error
+}
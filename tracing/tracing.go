@@ -2,6 +2,7 @@ package tracing
 
 import (
 	"context"
+	"sync/atomic"
 
 	"github.com/distribution/distribution/v3/internal/dcontext"
 	"github.com/distribution/distribution/v3/version"
@@ -12,6 +13,7 @@ import (
 	"go.opentelemetry.io/otel/sdk/resource"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
@@ -26,6 +28,10 @@ const (
 	AttributePrefix = "io.cncf.distribution."
 )
 
+// enabled records whether the spans are exported, that is whether the span
+// exporter is not disabled with OTEL_TRACES_EXPORTER=none.
+var enabled atomic.Bool
+
 // InitOpenTelemetry initializes OpenTelemetry for the application. This function sets up the
 // necessary components for collecting telemetry data, such as traces.
 func InitOpenTelemetry(ctx context.Context) error {
@@ -55,6 +61,8 @@ func InitOpenTelemetry(ctx context.Context) error {
 		return err
 	}
 
+	enabled.Store(!autoexport.IsNoneSpanExporter(autoExp))
+
 	compositeExp := newCompositeExporter(autoExp, loggerExp)
 
 	sp := sdktrace.NewBatchSpanProcessor(compositeExp)
@@ -71,3 +79,20 @@ func InitOpenTelemetry(ctx context.Context) error {
 
 	return nil
 }
+
+// Enabled reports whether OpenTelemetry was initialized with a span exporter,
+// so that the traces referenced from elsewhere, such as metric exemplars, can
+// be looked up.
+func Enabled() bool {
+	return enabled.Load()
+}
+
+// TraceID returns the ID of the trace of the span of ctx, if the span is
+// valid and sampled.
+func TraceID(ctx context.Context) (string, bool) {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() || !sc.IsSampled() {
+		return "", false
+	}
+	return sc.TraceID().String(), true
+}
@@ -0,0 +1,28 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+func TestTraceID(t *testing.T) {
+	ctx := context.Background()
+	if _, ok := TraceID(ctx); ok {
+		t.Fatal("expected no trace ID without span")
+	}
+
+	sampled, span := sdktrace.NewTracerProvider().Tracer("test").Start(ctx, "sampled")
+	defer span.End()
+	traceID, ok := TraceID(sampled)
+	if !ok || traceID != span.SpanContext().TraceID().String() {
+		t.Fatalf("unexpected trace ID %q of sampled span %v", traceID, span.SpanContext())
+	}
+
+	notSampled, span := sdktrace.NewTracerProvider(sdktrace.WithSampler(sdktrace.NeverSample())).Tracer("test").Start(ctx, "not sampled")
+	defer span.End()
+	if _, ok := TraceID(notSampled); ok {
+		t.Fatal("expected no trace ID of span not sampled")
+	}
+}